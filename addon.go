@@ -4,31 +4,58 @@ package nvr
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	stdLog "log"
+	"net/http"
+	"nvr/pkg/log"
 	"nvr/pkg/monitor"
 	"nvr/pkg/storage"
 	"nvr/pkg/web"
+	"nvr/pkg/web/api"
 	"nvr/pkg/web/auth"
+	"nvr/web/static"
+	"sort"
 )
 
 type appRunHook func(context.Context, *App) error
 
+type staticFSHook func(*web.Assets) error
+
+type httpMiddleware struct {
+	wrap     func(http.Handler) http.Handler
+	priority int
+}
+
 type hookList struct {
 	newAuthenticator    auth.NewAuthenticatorFunc
 	onAppRun            []appRunHook
 	template            []web.TemplateHook
 	templateSub         []web.TemplateHook
 	templateData        []web.TemplateDataFunc
+	staticFS            []staticFSHook
 	monitorStart        []monitor.StartHook
+	monitorStop         []monitor.StopHook
 	monitorInputProcess []monitor.StartInputHook
 	monitorEvent        []monitor.EventHook
 	monitorRecSave      []monitor.RecSaveHook
 	monitorRecSaved     []monitor.RecSavedHook
-	migrationMonitor    []monitor.MigationHook
+	migrationMonitor    []monitor.Migration
+	monitorConfigKeys   []func() []string
+	monitorValidate     []monitor.ValidateHook
 	logSource           []string
+	metrics             []metricsHook
+	httpMiddleware      []httpMiddleware
+	recordingDetail     []web.RecordingDetailHook
+
+	addons *addonRegistry
 }
 
-var hooks = &hookList{}
+var hooks = &hookList{
+	addons:           newAddonRegistry(),
+	migrationMonitor: []monitor.Migration{monitor.CoreMigration},
+}
 
 // SetAuthenticator is used to set the authenticator.
 func SetAuthenticator(a auth.NewAuthenticatorFunc) {
@@ -59,11 +86,28 @@ func RegisterTplDataHook(h web.TemplateDataFunc) {
 	hooks.templateData = append(hooks.templateData, h)
 }
 
-// RegisterMonitorStartHook registers hook that's called when the monitor starts.
+// RegisterStaticFS registers a file system that's served at
+// the prefix, "/static/doods/". Templates can get the cache
+// busting URL of the files with {{ asset "doods/doods.mjs" }}.
+func RegisterStaticFS(prefix string, fsys fs.FS) {
+	hooks.staticFS = append(hooks.staticFS, func(assets *web.Assets) error {
+		return assets.Register(prefix, fsys)
+	})
+}
+
+// RegisterMonitorStartHook registers hook that's called when the monitor
+// starts. The context is canceled when the monitor stops or is deleted.
 func RegisterMonitorStartHook(h monitor.StartHook) {
 	hooks.monitorStart = append(hooks.monitorStart, h)
 }
 
+// RegisterMonitorStopHook registers hook that's called after the monitor
+// have stopped. Start hooks of a restart are called after the stop hooks
+// have returned, the monitor manager waits up to 10 seconds for them.
+func RegisterMonitorStopHook(h monitor.StopHook) {
+	hooks.monitorStop = append(hooks.monitorStop, h)
+}
+
 // RegisterMonitorInputProcessHook registers hook that's
 // called when the monitor input process starts.
 func RegisterMonitorInputProcessHook(h monitor.StartInputHook) {
@@ -86,8 +130,26 @@ func RegisterMonitorRecSavedHook(h monitor.RecSavedHook) {
 }
 
 // RegisterMigrationMonitorHook is called when each monitor config is loaded.
-func RegisterMigrationMonitorHook(h monitor.MigationHook) {
-	hooks.migrationMonitor = append(hooks.migrationMonitor, h)
+// The name identifies the migration in the logs.
+func RegisterMigrationMonitorHook(name string, h monitor.MigationHook) {
+	hooks.migrationMonitor = append(hooks.migrationMonitor, monitor.Migration{
+		Name: name,
+		Hook: h,
+	})
+}
+
+// RegisterMonitorConfigKeys registers the monitor config keys used
+// by the addon. Imported monitor configs warn about unknown keys.
+func RegisterMonitorConfigKeys(keys ...string) {
+	hooks.monitorConfigKeys = append(hooks.monitorConfigKeys, func() []string {
+		return keys
+	})
+}
+
+// RegisterMonitorValidateHook registers a hook that validates each
+// monitor config. The hooks are called by the -check command.
+func RegisterMonitorValidateHook(h monitor.ValidateHook) {
+	hooks.monitorValidate = append(hooks.monitorValidate, h)
 }
 
 // RegisterLogSource adds log source.
@@ -95,6 +157,37 @@ func RegisterLogSource(s []string) {
 	hooks.logSource = append(hooks.logSource, s...)
 }
 
+// RegisterMetrics registers a hook that registers the custom
+// metrics of the addon. Labels are limited to the monitor ID and
+// source, the metrics are served by the /metrics endpoint.
+func RegisterMetrics(h metricsHook) {
+	hooks.metrics = append(hooks.metrics, h)
+}
+
+// RegisterRecordingDetailHook registers hook that's called when the
+// detail of a recording is requested. Addons add their state of the
+// recording to detail.Addons, the hook shouldn't touch the disk if
+// detail.HasFile can answer it.
+func RegisterRecordingDetailHook(h web.RecordingDetailHook) {
+	hooks.recordingDetail = append(hooks.recordingDetail, h)
+}
+
+// RegisterHTTPMiddleware registers a middleware that wraps the router.
+// The middleware is applied after the app run hooks, routes added
+// by the hooks are wrapped. Middleware with a higher priority are
+// closer to the router, a lower priority wraps the higher. Equal
+// priorities keep the registration order, the first is outermost.
+//
+// The authentication of the routes is applied per route inside the
+// router, requests aren't authenticated when the middleware is called.
+// Panics are recovered by a built-in middleware that wraps all others.
+func RegisterHTTPMiddleware(m func(http.Handler) http.Handler, priority int) {
+	hooks.httpMiddleware = append(hooks.httpMiddleware, httpMiddleware{
+		wrap:     m,
+		priority: priority,
+	})
+}
+
 func (h *hookList) appRun(ctx context.Context, app *App) error {
 	for _, hook := range h.onAppRun {
 		if err := hook(ctx, app); err != nil {
@@ -128,12 +221,54 @@ func (h *hookList) tplHooks() web.TemplateHooks {
 	}
 }
 
+// Priority of the core middleware.
+const forwardedProtoPriority = 0
+
+// httpHandler wraps the router in the core and registered middleware.
+// The panic recovery and request logger is the outermost middleware.
+func (h *hookList) httpHandler(logger log.ILogger, router http.Handler) http.Handler {
+	middleware := append([]httpMiddleware{{
+		wrap:     web.ForwardedProtoMiddleware,
+		priority: forwardedProtoPriority,
+	}}, h.httpMiddleware...)
+
+	// Outermost first.
+	sort.SliceStable(middleware, func(i, j int) bool {
+		return middleware[i].priority < middleware[j].priority
+	})
+
+	handler := router
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i].wrap(handler)
+	}
+	return api.Middleware(logger, handler)
+}
+
+// assets returns the core static files and the registered file systems.
+func (h *hookList) assets() (*web.Assets, error) {
+	assets := web.NewAssets()
+	if err := assets.Register("/static/", static.Static); err != nil {
+		return nil, err
+	}
+	for _, hook := range h.staticFS {
+		if err := hook(assets); err != nil {
+			return nil, fmt.Errorf("register static fs: %w", err)
+		}
+	}
+	return assets, nil
+}
+
 func (h *hookList) monitor() *monitor.Hooks {
 	startHook := func(ctx context.Context, m *monitor.Monitor) {
 		for _, hook := range h.monitorStart {
 			hook(ctx, m)
 		}
 	}
+	stopHook := func(m *monitor.Monitor) {
+		for _, hook := range h.monitorStop {
+			hook(m)
+		}
+	}
 	startInputHook := func(ctx context.Context, i *monitor.InputProcess, args *[]string) {
 		for _, hook := range h.monitorInputProcess {
 			hook(ctx, i, args)
@@ -154,22 +289,31 @@ func (h *hookList) monitor() *monitor.Hooks {
 			hook(r, recPath, recData)
 		}
 	}
-	migrateHook := func(conf monitor.RawConfig) error {
-		for _, hook := range h.migrationMonitor {
-			err := hook(conf)
-			if err != nil {
-				return err
-			}
+	configKeys := func() []string {
+		var keys []string
+		for _, hook := range h.monitorConfigKeys {
+			keys = append(keys, hook()...)
 		}
-		return nil
+		return keys
 	}
-
 	return &monitor.Hooks{
 		Start:      startHook,
+		Stop:       stopHook,
 		StartInput: startInputHook,
 		Event:      eventHook,
 		RecSave:    recSaveHook,
 		RecSaved:   recSavedHook,
-		Migrate:    migrateHook,
+		Migrations: h.migrationMonitor,
+		ConfigKeys: configKeys,
+	}
+}
+
+// validateMonitor validates the monitor config with
+// the core validator and every registered hook.
+func (h *hookList) validateMonitor(rawConf monitor.RawConfig) error {
+	errs := []error{monitor.ValidateConfig(rawConf)}
+	for _, hook := range h.monitorValidate {
+		errs = append(errs, hook(rawConf))
 	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package nvr
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"nvr/pkg/log"
+	"nvr/pkg/web"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestMiddleware appends the name to the header before
+// and after calling the next handler.
+func newTestMiddleware(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Order", name)
+			next.ServeHTTP(w, r)
+			w.Header().Add("X-Order", "/"+name)
+		})
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	router := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("X-Order", "router")
+		proto, _ := web.ForwardedProto(r)
+		w.Header().Set("X-Proto", proto)
+	})
+
+	t.Run("order", func(t *testing.T) {
+		h := &hookList{addons: newAddonRegistry()}
+		h.httpMiddleware = []httpMiddleware{
+			{newTestMiddleware("b1"), 10},
+			{newTestMiddleware("a"), -5},
+			{newTestMiddleware("c"), 20},
+			{newTestMiddleware("b2"), 10},
+		}
+
+		w := httptest.NewRecorder()
+		handler := h.httpHandler(log.NewDummyLogger(), router)
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		expected := []string{"a", "b1", "b2", "c", "router", "/c", "/b2", "/b1", "/a"}
+		require.Equal(t, expected, w.Header().Values("X-Order"))
+	})
+	t.Run("forwardedProto", func(t *testing.T) {
+		h := &hookList{addons: newAddonRegistry()}
+		var proto string
+		h.httpMiddleware = []httpMiddleware{{
+			wrap: func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					proto, _ = web.ForwardedProto(r)
+					next.ServeHTTP(w, r)
+				})
+			},
+			priority: forwardedProtoPriority + 1,
+		}}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Forwarded-Proto", "https")
+		h.httpHandler(log.NewDummyLogger(), router).ServeHTTP(w, r)
+
+		require.Equal(t, "https", proto)
+		require.Equal(t, "https", w.Header().Get("X-Proto"))
+	})
+	t.Run("recover", func(t *testing.T) {
+		h := &hookList{addons: newAddonRegistry()}
+		buggy := func(http.Handler) http.Handler {
+			return http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				panic("mock")
+			})
+		}
+		// The recovery wraps even the lowest priority.
+		h.httpMiddleware = []httpMiddleware{{buggy, math.MinInt}}
+
+		logger, logs := log.NewMockLogger()
+		w := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			handler := h.httpHandler(logger, router)
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/x", nil))
+			close(done)
+		}()
+		msg := <-logs
+		<-done
+
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, msg, "panic: mock")
+	})
+}
@@ -3,46 +3,107 @@
 package alert
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"nvr"
 	"nvr/pkg/log"
 	"nvr/pkg/monitor"
 	"nvr/pkg/storage"
+	"reflect"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+var nvrAddon = nvr.RegisterAddon("alert", "Send alerts on events")
+
 // Hook Alert hook.
-type Hook func(*monitor.Recorder, *storage.Event, []byte)
+type Hook func(*monitor.Recorder, *storage.Event, []byte) error
 
-var addon struct {
-	hooks []Hook
+type namedHook struct {
+	name string
+	hook Hook
 }
 
+var addon = newAlerter(nil)
+
 // RegisterAlertHook registers hook that's called on alerts.
-func RegisterAlertHook(hook Hook) {
-	addon.hooks = append(addon.hooks, hook)
+// The name identifies the hook in the delivery history.
+func RegisterAlertHook(name string, hook Hook) {
+	addon.mu.Lock()
+	defer addon.mu.Unlock()
+	addon.alertHooks = append(addon.alertHooks, namedHook{name: name, hook: hook})
+}
+
+// LegacyHook alert hook without error.
+//
+// Deprecated: Use Hook.
+type LegacyHook func(*monitor.Recorder, *storage.Event, []byte)
+
+// RegisterLegacyAlertHook registers hook that's called on alerts.
+// The hook is named after the function.
+//
+// Deprecated: Use RegisterAlertHook.
+func RegisterLegacyAlertHook(hook LegacyHook) {
+	name := runtime.FuncForPC(reflect.ValueOf(hook).Pointer()).Name()
+	RegisterAlertHook(name, func(r *monitor.Recorder, e *storage.Event, b []byte) error {
+		hook(r, e, b)
+		return nil
+	})
 }
 
 func init() {
-	RegisterAlertHook(logAlert)
-	a := newAlerter(addon.hooks)
+	RegisterAlertHook("log", logAlert)
 
 	nvr.RegisterLogSource([]string{"alert"})
-	nvr.RegisterMonitorEventHook(a.onEvent)
+	nvrAddon.RegisterMonitorEventHook(addon.onEvent)
+	nvrAddon.RegisterMonitorStartHook(addon.onMonitorStart)
+	nvrAddon.RegisterMonitorConfigKeys("alert")
+	nvrAddon.RegisterAppRunHook(func(_ context.Context, app *nvr.App) error {
+		app.Router.Handle("/api/alert/test/", app.Auth.Admin(addon.handleTest()))
+		app.Router.Handle("/api/alert/history", app.Auth.Admin(addon.handleHistory()))
+		return nil
+	})
 }
 
-func newAlerter(alertHooks []Hook) *alerter {
+func newAlerter(alertHooks []namedHook) *alerter {
 	return &alerter{
 		alertHooks: alertHooks,
 		prevAlerts: map[string]time.Time{},
+		history:    map[string]*hookHistory{},
+		recorders:  map[string]*monitor.Recorder{},
 	}
 }
 
 type alerter struct {
-	alertHooks []Hook
-	prevAlerts map[string]time.Time // map[monitorID]prevAlert.
+	alertHooks []namedHook
+	prevAlerts map[string]time.Time         // map[monitorID]prevAlert.
+	history    map[string]*hookHistory      // map[monitorID]history.
+	recorders  map[string]*monitor.Recorder // Running monitors.
+	mu         sync.Mutex
+}
+
+// onMonitorStart tracks the recorders of running monitors for test alerts.
+func (a *alerter) onMonitorStart(ctx context.Context, m *monitor.Monitor) {
+	id := m.Config.ID()
+	r := m.Recorder()
+
+	a.mu.Lock()
+	a.recorders[id] = r
+	a.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.recorders[id] == r {
+			delete(a.recorders, id)
+		}
+	}()
 }
 
 func (a *alerter) onEvent(r *monitor.Recorder, event *storage.Event) {
@@ -50,7 +111,7 @@ func (a *alerter) onEvent(r *monitor.Recorder, event *storage.Event) {
 		id := r.Config.ID()
 		rawConfig := r.Config.Get("alert")
 
-		err := a.processEvent(r, event, id, rawConfig)
+		_, err := a.processEvent(r, event, id, rawConfig, false)
 		if err != nil {
 			r.Logger.Log(log.Entry{
 				Level:     log.LevelError,
@@ -62,54 +123,80 @@ func (a *alerter) onEvent(r *monitor.Recorder, event *storage.Event) {
 	}()
 }
 
+// processEvent calls the alert hooks if the event passes the
+// monitor config. Returns the results of the hooks if they were called.
 func (a *alerter) processEvent(
 	r *monitor.Recorder,
 	event *storage.Event,
 	id string,
 	rawConfig string,
-) error {
+	ignoreCooldown bool,
+) ([]HookResult, error) {
 	if rawConfig == "" {
-		return nil
+		return nil, nil
 	}
 
 	var config Config
 	err := json.Unmarshal([]byte(rawConfig), &config)
 	if err != nil {
-		return fmt.Errorf("could not unmarshal config: %w", err)
+		return nil, fmt.Errorf("could not unmarshal config: %w", err)
 	}
 	config.fillMissing()
 
 	if config.Enable != "true" {
-		return nil
+		return nil, nil
 	}
 
 	cooldownFloat, err := strconv.ParseFloat(config.Cooldown, 64)
 	if err != nil {
-		return fmt.Errorf("could not parse cooldown: %w", err)
-	}
-
-	cooldown := time.Duration(cooldownFloat * float64(time.Minute))
-	if a.prevAlerts[id].Add(cooldown).After(time.Now()) {
-		return nil
+		return nil, fmt.Errorf("could not parse cooldown: %w", err)
 	}
 
 	threshold, err := strconv.ParseFloat(config.Threshold, 64)
 	if err != nil {
-		return fmt.Errorf("could not parse threshold: %w", err)
+		return nil, fmt.Errorf("could not parse threshold: %w", err)
+	}
+
+	if requiredZones := parseZones(config.RequiredZones); len(requiredZones) != 0 {
+		event = inZones(event, requiredZones)
+		if len(event.Detections) == 0 {
+			return nil, nil
+		}
 	}
 
 	d := bestDetection(*event)
 	if d.Score < threshold {
-		return nil
+		return nil, nil
 	}
 
+	a.mu.Lock()
+	cooldown := time.Duration(cooldownFloat * float64(time.Minute))
+	if !ignoreCooldown && a.prevAlerts[id].Add(cooldown).After(time.Now()) {
+		a.mu.Unlock()
+		return nil, nil
+	}
 	a.prevAlerts[id] = time.Now()
+	hooks := a.alertHooks
+	a.mu.Unlock()
+
+	results := make([]HookResult, 0, len(hooks))
+	for _, h := range hooks {
+		start := time.Now()
+		err := h.hook(r, event, nil)
 
-	for _, hook := range a.alertHooks {
-		hook(r, event, nil)
+		result := HookResult{
+			Time:     start,
+			Hook:     h.name,
+			Duration: time.Since(start),
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+		a.addHistory(id, result)
 	}
 
-	return nil
+	return results, nil
 }
 
 // Config is a monitor alert config.
@@ -117,6 +204,21 @@ type Config struct {
 	Enable    string `json:"enable"`
 	Threshold string `json:"threshold"`
 	Cooldown  string `json:"cooldown"`
+
+	// Comma separated detector zones, only detections
+	// inside one of the zones are alerted if set.
+	RequiredZones string `json:"requiredZones,omitempty"`
+
+	// Webhook is disabled if the URL is empty.
+	WebhookURL    string `json:"webhookURL"`
+	WebhookMethod string `json:"webhookMethod"`
+
+	// "Name: value" pairs separated by semicolons.
+	WebhookHeaders string `json:"webhookHeaders"`
+
+	// Go text/template over WebhookData.
+	WebhookTemplate string `json:"webhookTemplate"`
+	WebhookInsecure string `json:"webhookInsecure"`
 }
 
 func (c *Config) fillMissing() {
@@ -131,6 +233,32 @@ func (c *Config) fillMissing() {
 	}
 }
 
+func parseZones(raw string) []string {
+	var zones []string
+	for _, zone := range strings.Split(raw, ",") {
+		if zone = strings.TrimSpace(zone); zone != "" {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// inZones returns a copy of the event with only the
+// detections that are inside one of the zones.
+func inZones(event *storage.Event, zones []string) *storage.Event {
+	filtered := *event
+	filtered.Detections = nil
+	for _, d := range event.Detections {
+		for _, zone := range d.Zones {
+			if slices.Contains(zones, zone) {
+				filtered.Detections = append(filtered.Detections, d)
+				break
+			}
+		}
+	}
+	return &filtered
+}
+
 func bestDetection(e storage.Event) storage.Detection {
 	var best storage.Detection
 	for _, d := range e.Detections {
@@ -141,7 +269,7 @@ func bestDetection(e storage.Event) storage.Detection {
 	return best
 }
 
-func logAlert(r *monitor.Recorder, event *storage.Event, _ []byte) {
+func logAlert(r *monitor.Recorder, event *storage.Event, _ []byte) error {
 	monitorID := r.Config.ID()
 	d := bestDetection(*event)
 	r.Logger.Log(log.Entry{
@@ -150,4 +278,5 @@ func logAlert(r *monitor.Recorder, event *storage.Event, _ []byte) {
 		MonitorID: monitorID,
 		Msg:       fmt.Sprintf("label:%v score:%v", d.Label, d.Score),
 	})
+	return nil
 }
@@ -108,13 +108,14 @@ func TestProcessEvent(t *testing.T) {
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
 			var outEvent *storage.Event
-			onEvent := func(_ *monitor.Recorder, event *storage.Event, _ []byte) {
+			onEvent := func(_ *monitor.Recorder, event *storage.Event, _ []byte) error {
 				outEvent = event
+				return nil
 			}
 
-			a := newAlerter([]Hook{onEvent})
+			a := newAlerter([]namedHook{{"test", onEvent}})
 
-			err := a.processEvent(nil, tc.event, "", tc.config)
+			_, err := a.processEvent(nil, tc.event, "", tc.config, false)
 			require.Equal(t, err != nil, tc.err)
 
 			if tc.passEvent {
@@ -125,11 +126,12 @@ func TestProcessEvent(t *testing.T) {
 
 	t.Run("cooldown", func(t *testing.T) {
 		var outEvent *storage.Event
-		onEvent := func(_ *monitor.Recorder, event *storage.Event, _ []byte) {
+		onEvent := func(_ *monitor.Recorder, event *storage.Event, _ []byte) error {
 			outEvent = event
+			return nil
 		}
 
-		a := newAlerter([]Hook{onEvent})
+		a := newAlerter([]namedHook{{"test", onEvent}})
 
 		event1 := &storage.Event{
 			Detections: []storage.Detection{
@@ -148,17 +150,64 @@ func TestProcessEvent(t *testing.T) {
 			Cooldown:  "1",
 		})
 
-		err := a.processEvent(nil, event1, "", config)
+		_, err := a.processEvent(nil, event1, "", config, false)
 		require.NoError(t, err)
 		require.Equal(t, outEvent, event1)
 
-		err = a.processEvent(nil, event2, "", config)
+		_, err = a.processEvent(nil, event2, "", config, false)
 		require.NoError(t, err)
 		require.Equal(t, outEvent, event1)
 
 		a.prevAlerts = map[string]time.Time{}
-		err = a.processEvent(nil, event2, "", config)
+		_, err = a.processEvent(nil, event2, "", config, false)
 		require.NoError(t, err)
 		require.Equal(t, outEvent, event2)
 	})
+	t.Run("requiredZones", func(t *testing.T) {
+		var outEvent *storage.Event
+		onEvent := func(_ *monitor.Recorder, event *storage.Event, _ []byte) error {
+			outEvent = event
+			return nil
+		}
+
+		config := rawConf(t, Config{
+			Enable:        "true",
+			Threshold:     "50",
+			Cooldown:      "0",
+			RequiredZones: " driveway,,porch ",
+		})
+		process := func(event *storage.Event) *storage.Event {
+			outEvent = nil
+			a := newAlerter([]namedHook{{"test", onEvent}})
+			_, err := a.processEvent(nil, event, "", config, false)
+			require.NoError(t, err)
+			return outEvent
+		}
+
+		event := &storage.Event{
+			Detections: []storage.Detection{
+				{Label: "a", Score: 90},
+				{Label: "b", Score: 60, Zones: []string{"yard", "porch"}},
+				{Label: "c", Score: 40, Zones: []string{"driveway"}},
+			},
+		}
+		expected := &storage.Event{
+			Detections: []storage.Detection{
+				{Label: "b", Score: 60, Zones: []string{"yard", "porch"}},
+				{Label: "c", Score: 40, Zones: []string{"driveway"}},
+			},
+		}
+		require.Equal(t, expected, process(event))
+		require.Len(t, event.Detections, 3)
+
+		// Best detection is outside the zones.
+		require.Nil(t, process(&storage.Event{
+			Detections: []storage.Detection{
+				{Score: 90, Zones: []string{"yard"}},
+				{Score: 40, Zones: []string{"driveway"}},
+			},
+		}))
+
+		require.Nil(t, process(&storage.Event{}))
+	})
 }
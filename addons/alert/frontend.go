@@ -4,13 +4,12 @@ package alert
 
 import (
 	"fmt"
-	"nvr"
 	"os"
 	"strings"
 )
 
 func init() {
-	nvr.RegisterTplHook(modifyTemplates)
+	nvrAddon.RegisterTplHook(modifyTemplates)
 }
 
 func modifyTemplates(pageFiles map[string]string) error {
@@ -48,6 +47,44 @@ func modifySettingsjs(tpl string) string { //nolint:funlen
 				"30",
 				"30",
 			),
+			requiredZones: newField(
+				[],
+				{ input: "text" },
+				{
+					label: "Required zones",
+					placeholder: "driveway, porch (optional)",
+				}
+			),
+			webhookURL: newField(
+				[],
+				{ input: "text" },
+				{
+					label: "Webhook URL",
+					placeholder: "https://example.com/hook (optional)",
+				}
+			),
+			webhookMethod: fieldTemplate.select(
+				"Webhook method",
+				["POST", "PUT", "GET"],
+				"POST",
+			),
+			webhookHeaders: newField(
+				[],
+				{ input: "text" },
+				{
+					label: "Webhook headers",
+					placeholder: "Authorization: Bearer x; Title: y",
+				}
+			),
+			webhookTemplate: newField(
+				[],
+				{ input: "text" },
+				{
+					label: "Webhook template",
+					placeholder: '{"message": {{ json .Label }}}',
+				}
+			),
+			webhookInsecure: fieldTemplate.toggle("Webhook skip TLS verify", "false"),
 		};
 		const form = newForm(fields);
 		const modal = newModal("Alert", form.html());
@@ -60,7 +97,7 @@ func modifySettingsjs(tpl string) string { //nolint:funlen
 				return;
 			}
 			element.insertAdjacentHTML("beforeend", modal.html)
-			element.querySelector(".js-modal").style.maxWidth = "12rem";
+			element.querySelector(".js-modal").style.maxWidth = "20rem";
 
 			const $modalContent = modal.init(element)
 			form.init($modalContent);
@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package alert
+
+import (
+	"net/http"
+	"nvr/pkg/storage"
+	"nvr/pkg/web/api"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Number of hook results kept per monitor.
+const historySize = 50
+
+// HookResult outcome of a single hook invocation.
+type HookResult struct {
+	Time     time.Time     `json:"time"`
+	Hook     string        `json:"hook"`
+	Duration time.Duration `json:"duration"` // Nanoseconds.
+	Error    string        `json:"error,omitempty"`
+}
+
+// hookHistory ring buffer of hook results.
+type hookHistory struct {
+	results [historySize]HookResult
+	n       int // Number of results.
+	next    int // Index of the next result.
+}
+
+func (h *hookHistory) add(result HookResult) {
+	h.results[h.next] = result
+	h.next = (h.next + 1) % len(h.results)
+	if h.n < len(h.results) {
+		h.n++
+	}
+}
+
+// list returns the results, newest first.
+func (h *hookHistory) list() []HookResult {
+	list := make([]HookResult, 0, h.n)
+	for i := 1; i <= h.n; i++ {
+		list = append(list, h.results[(h.next-i+len(h.results))%len(h.results)])
+	}
+	return list
+}
+
+func (a *alerter) addHistory(monitorID string, result HookResult) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	h, exist := a.history[monitorID]
+	if !exist {
+		h = &hookHistory{}
+		a.history[monitorID] = h
+	}
+	h.add(result)
+}
+
+// handleHistory returns the hook results of all monitors,
+// or a single monitor if the "id" query parameter is set.
+func (a *alerter) handleHistory() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		a.mu.Lock()
+		history := make(map[string][]HookResult, len(a.history))
+		for id, h := range a.history {
+			history[id] = h.list()
+		}
+		a.mu.Unlock()
+
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			api.WriteJSON(w, r, history)
+			return
+		}
+
+		results := history[id]
+		if results == nil {
+			results = []HookResult{}
+		}
+		api.WriteJSON(w, r, results)
+	})
+}
+
+type testResponse struct {
+	// False if the event was filtered by the config.
+	Sent    bool         `json:"sent"`
+	Results []HookResult `json:"results"`
+}
+
+// handleTest sends a synthetic event through the alert
+// path of a running monitor. The cooldown is only
+// bypassed if the "ignoreCooldown" parameter is true.
+func (a *alerter) handleTest() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		id := strings.TrimPrefix(r.URL.Path, "/api/alert/test/")
+		if id == "" {
+			api.BadRequest(w, "missing monitor ID")
+			return
+		}
+
+		query := r.URL.Query()
+		label := query.Get("label")
+		if label == "" {
+			label = "test"
+		}
+		score := 100.0
+		if rawScore := query.Get("score"); rawScore != "" {
+			var err error
+			score, err = strconv.ParseFloat(rawScore, 64)
+			if err != nil {
+				api.BadRequest(w, "invalid score")
+				return
+			}
+		}
+		ignoreCooldown := query.Get("ignoreCooldown") == "true"
+
+		a.mu.Lock()
+		rec, exist := a.recorders[id]
+		a.mu.Unlock()
+		if !exist {
+			api.NotFound(w, "monitor not running")
+			return
+		}
+
+		event := &storage.Event{
+			Time:       time.Now(),
+			Detections: []storage.Detection{{Label: label, Score: score}},
+			Source:     "test",
+		}
+		results, err := a.processEvent(rec, event, id, rec.Config.Get("alert"), ignoreCooldown)
+		if err != nil {
+			api.BadRequest(w, err.Error())
+			return
+		}
+
+		api.WriteJSON(w, r, testResponse{
+			Sent:    results != nil,
+			Results: results,
+		})
+	})
+}
@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHookHistory(t *testing.T) {
+	var h hookHistory
+	require.Empty(t, h.list())
+
+	for i := 0; i < historySize+2; i++ {
+		h.add(HookResult{Hook: strconv.Itoa(i)})
+	}
+	list := h.list()
+	require.Len(t, list, historySize)
+	require.Equal(t, strconv.Itoa(historySize+1), list[0].Hook)
+	require.Equal(t, "2", list[historySize-1].Hook)
+}
+
+func TestHistoryFailingHook(t *testing.T) {
+	okHook := func(*monitor.Recorder, *storage.Event, []byte) error { return nil }
+	failHook := func(*monitor.Recorder, *storage.Event, []byte) error {
+		return errors.New("mock")
+	}
+	a := newAlerter([]namedHook{{"ok", okHook}, {"fail", failHook}})
+
+	config := rawConf(t, Config{Enable: "true", Threshold: "0", Cooldown: "0"})
+	results, err := a.processEvent(nil, &storage.Event{}, "m1", config, false)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/alert/history?id=m1", nil)
+	a.handleHistory().ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var history []HookResult
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+	require.Len(t, history, 2)
+	require.Equal(t, "fail", history[0].Hook)
+	require.Equal(t, "mock", history[0].Error)
+	require.Equal(t, "ok", history[1].Hook)
+	require.Empty(t, history[1].Error)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/api/alert/history?id=m2", nil)
+	a.handleHistory().ServeHTTP(w, r)
+	require.Equal(t, "[]\n", w.Body.String())
+}
+
+func TestHandleTest(t *testing.T) {
+	var events []*storage.Event
+	hook := func(_ *monitor.Recorder, event *storage.Event, _ []byte) error {
+		events = append(events, event)
+		return nil
+	}
+	a := newAlerter([]namedHook{{"test", hook}})
+	a.recorders["m1"] = &monitor.Recorder{
+		Config: monitor.NewConfig(monitor.RawConfig{
+			"id":    "m1",
+			"alert": rawConf(t, Config{Enable: "true", Threshold: "50", Cooldown: "10"}),
+		}),
+	}
+
+	post := func(url string) (*httptest.ResponseRecorder, testResponse) {
+		w := httptest.NewRecorder()
+		a.handleTest().ServeHTTP(w, httptest.NewRequest(http.MethodPost, url, nil))
+		var res testResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		}
+		return w, res
+	}
+
+	w, res := post("/api/alert/test/m1?label=person&score=60")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.True(t, res.Sent)
+	require.Len(t, res.Results, 1)
+	require.Len(t, events, 1)
+	require.Equal(t, storage.Detection{Label: "person", Score: 60}, events[0].Detections[0])
+
+	// Threshold.
+	_, res = post("/api/alert/test/m1?score=40&ignoreCooldown=true")
+	require.False(t, res.Sent)
+
+	// Cooldown.
+	_, res = post("/api/alert/test/m1")
+	require.False(t, res.Sent)
+	_, res = post("/api/alert/test/m1?ignoreCooldown=true")
+	require.True(t, res.Sent)
+	require.Len(t, events, 2)
+	require.Equal(t, "test", events[1].Detections[0].Label)
+
+	w, _ = post("/api/alert/test/nil")
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w, _ = post("/api/alert/test/m1?score=x")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	a.handleTest().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/alert/test/m1", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,403 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package alert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Errors.
+var (
+	ErrWebhookURL         = errors.New("invalid webhook URL")
+	ErrWebhookHeader      = errors.New("invalid webhook header")
+	ErrWebhookTemplate    = errors.New("invalid webhook template")
+	ErrWebhookStatus      = errors.New("unexpected response status")
+	ErrWebhookQueueFull   = errors.New("webhook queue is full")
+	ErrWebhookCircuitOpen = errors.New("webhook circuit is open")
+)
+
+var webhooks = newWebhookSender()
+
+func init() {
+	RegisterAlertHook("webhook", webhooks.hook)
+}
+
+// WebhookData is the data available to the webhook payload
+// template. The payload is the data as JSON if the template is empty.
+type WebhookData struct {
+	MonitorID   string              `json:"monitorID"`
+	MonitorName string              `json:"monitorName"`
+	Time        time.Time           `json:"time"`
+	Detections  []storage.Detection `json:"detections"`
+
+	// Source of the event, see storage.Event.
+	Source string `json:"source"`
+
+	// Label and score of the detection with the highest score.
+	Label string  `json:"label"`
+	Score float64 `json:"score"`
+
+	// Empty if the monitor isn't recording. The URLs are relative
+	// to the address of the NVR, "/api/recording/video/<id>".
+	RecordingID  string `json:"recordingID"`
+	RecordingURL string `json:"recordingURL"`
+	SnapshotURL  string `json:"snapshotURL"`
+}
+
+func newWebhookData(r *monitor.Recorder, event *storage.Event) WebhookData {
+	d := bestDetection(*event)
+	data := WebhookData{
+		MonitorID:   r.Config.ID(),
+		MonitorName: r.Config.Name(),
+		Time:        event.Time,
+		Detections:  event.Detections,
+		Source:      event.Source,
+		Label:       d.Label,
+		Score:       d.Score,
+		RecordingID: r.RecordingID(),
+	}
+	if data.Detections == nil {
+		data.Detections = []storage.Detection{}
+	}
+	if data.RecordingID != "" {
+		data.RecordingURL = "/api/recording/video/" + data.RecordingID
+		data.SnapshotURL = "/api/recording/thumbnail/" + data.RecordingID
+	}
+	return data
+}
+
+// Template functions.
+var webhookFuncs = template.FuncMap{
+	// Encodes the value as JSON, used to escape strings.
+	"json": func(v interface{}) (string, error) {
+		raw, err := json.Marshal(v)
+		return string(raw), err
+	},
+}
+
+type webhookConfig struct {
+	url      string
+	method   string
+	header   http.Header
+	tpl      *template.Template
+	insecure bool
+}
+
+// parseWebhookConfig returns nil if the webhook URL is empty.
+func parseWebhookConfig(c Config) (*webhookConfig, error) {
+	if c.WebhookURL == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(c.WebhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: must be a absolute http or https URL", ErrWebhookURL)
+	}
+
+	method := strings.ToUpper(c.WebhookMethod)
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	header := http.Header{}
+	for _, field := range strings.Split(c.WebhookHeaders, ";") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		name, value, found := strings.Cut(field, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("%w: %q", ErrWebhookHeader, strings.TrimSpace(field))
+		}
+		header.Add(name, strings.TrimSpace(value))
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+
+	var tpl *template.Template
+	if c.WebhookTemplate != "" {
+		tpl, err = template.New("webhook").Funcs(webhookFuncs).Parse(c.WebhookTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrWebhookTemplate, err)
+		}
+	}
+
+	return &webhookConfig{
+		url:      c.WebhookURL,
+		method:   method,
+		header:   header,
+		tpl:      tpl,
+		insecure: c.WebhookInsecure == "true",
+	}, nil
+}
+
+func (c *webhookConfig) payload(data WebhookData) ([]byte, error) {
+	if c.tpl == nil {
+		return json.Marshal(data)
+	}
+	var buf bytes.Buffer
+	if err := c.tpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("execute webhook template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+const (
+	webhookQueueSize        = 64
+	webhookRetries          = 4
+	webhookBackoff          = time.Second
+	webhookTimeout          = 10 * time.Second
+	webhookBreakerThreshold = 3
+	webhookBreakerCooldown  = time.Minute
+
+	// Maximum length of the response body in logs.
+	webhookMaxLogBody = 256
+)
+
+// webhookSender delivers webhooks asynchronously. Each destination
+// has its own queue and circuit breaker, a slow or dead endpoint
+// can only fill its own queue.
+type webhookSender struct {
+	queueSize int
+	retries   int
+	backoff   time.Duration // Doubled after each retry.
+	timeout   time.Duration
+
+	// Number of consecutive failed deliveries that opens the circuit.
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	mu           sync.Mutex
+	destinations map[destinationKey]*webhookDestination
+}
+
+func newWebhookSender() *webhookSender {
+	return &webhookSender{
+		queueSize:        webhookQueueSize,
+		retries:          webhookRetries,
+		backoff:          webhookBackoff,
+		timeout:          webhookTimeout,
+		breakerThreshold: webhookBreakerThreshold,
+		breakerCooldown:  webhookBreakerCooldown,
+		destinations:     map[destinationKey]*webhookDestination{},
+	}
+}
+
+type destinationKey struct {
+	url      string
+	insecure bool
+}
+
+type webhookJob struct {
+	method    string
+	header    http.Header
+	body      []byte
+	logger    log.ILogger
+	monitorID string
+}
+
+// hook is the alert hook. Errors are only returned if the
+// webhook couldn't be queued, delivery errors are logged.
+func (s *webhookSender) hook(r *monitor.Recorder, event *storage.Event, _ []byte) error {
+	var config Config
+	if err := json.Unmarshal([]byte(r.Config.Get("alert")), &config); err != nil {
+		return fmt.Errorf("could not unmarshal config: %w", err)
+	}
+	webhook, err := parseWebhookConfig(config)
+	if err != nil || webhook == nil {
+		return err
+	}
+
+	body, err := webhook.payload(newWebhookData(r, event))
+	if err != nil {
+		return err
+	}
+
+	return s.send(webhook, webhookJob{
+		method:    webhook.method,
+		header:    webhook.header,
+		body:      body,
+		logger:    r.Logger,
+		monitorID: r.Config.ID(),
+	})
+}
+
+func (s *webhookSender) send(c *webhookConfig, job webhookJob) error {
+	d := s.destination(destinationKey{url: c.url, insecure: c.insecure})
+	if d.isOpen(time.Now()) {
+		return fmt.Errorf("%w: %v", ErrWebhookCircuitOpen, d.name)
+	}
+	select {
+	case d.queue <- job:
+		return nil
+	default:
+		return fmt.Errorf("%w: %v", ErrWebhookQueueFull, d.name)
+	}
+}
+
+// destination returns the destination and starts it if it's new.
+func (s *webhookSender) destination(key destinationKey) *webhookDestination {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d, exist := s.destinations[key]; exist {
+		return d
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if key.insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+	}
+	d := &webhookDestination{
+		s:      s,
+		url:    key.url,
+		name:   redactURL(key.url),
+		client: &http.Client{Transport: transport, Timeout: s.timeout},
+		queue:  make(chan webhookJob, s.queueSize),
+	}
+	s.destinations[key] = d
+	go d.run()
+	return d
+}
+
+type webhookDestination struct {
+	s      *webhookSender
+	url    string
+	name   string // URL without path and credentials, used in logs.
+	client *http.Client
+	queue  chan webhookJob
+
+	mu        sync.Mutex
+	failures  int // Consecutive failed deliveries.
+	openUntil time.Time
+}
+
+func (d *webhookDestination) run() {
+	for job := range d.queue {
+		if d.isOpen(time.Now()) {
+			d.logf(job, log.LevelWarning, "webhook dropped: %v", ErrWebhookCircuitOpen)
+			continue
+		}
+		err := d.deliver(job)
+		if err != nil {
+			d.logf(job, log.LevelError, "webhook failed: %v", err)
+		}
+		if d.recordResult(err, time.Now()) {
+			d.logf(job, log.LevelError, "webhook circuit opened for %v",
+				d.s.breakerCooldown)
+		}
+	}
+}
+
+// deliver sends the webhook and retries with exponential backoff.
+func (d *webhookDestination) deliver(job webhookJob) error {
+	backoff := d.s.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := d.post(job)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= d.s.retries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post makes a single request. Returns true if the request can be retried.
+func (d *webhookDestination) post(job webhookJob) (bool, error) {
+	req, err := http.NewRequest(job.method, d.url, bytes.NewReader(job.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header = job.header.Clone()
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		// The URL may contain a token.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return true, fmt.Errorf("%v: %w", d.name, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		io.Copy(io.Discard, res.Body) //nolint:errcheck
+		return false, nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, webhookMaxLogBody+1))
+	truncated := string(body)
+	if len(body) > webhookMaxLogBody {
+		truncated = string(body[:webhookMaxLogBody]) + "..."
+	}
+
+	// Client errors other than rate limiting won't succeed on retry.
+	retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("%v: %w: %v: %q",
+		d.name, ErrWebhookStatus, res.Status, truncated)
+}
+
+func (d *webhookDestination) isOpen(now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return now.Before(d.openUntil)
+}
+
+// recordResult updates the circuit breaker,
+// returns true if the circuit was opened.
+func (d *webhookDestination) recordResult(err error, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err == nil {
+		d.failures = 0
+		return false
+	}
+	d.failures++
+	if d.failures < d.s.breakerThreshold {
+		return false
+	}
+	// A failure after the cooldown opens the circuit again.
+	d.openUntil = now.Add(d.s.breakerCooldown)
+	return true
+}
+
+func (d *webhookDestination) logf(job webhookJob, level log.Level, format string, a ...interface{}) {
+	if job.logger == nil {
+		return
+	}
+	job.logger.Log(log.Entry{
+		Level:     level,
+		Src:       "alert",
+		MonitorID: job.monitorID,
+		Msg:       fmt.Sprintf(format, a...),
+	})
+}
+
+// redactURL returns the scheme and host of the URL.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "invalid URL"
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,252 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package alert
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWebhookSender() *webhookSender {
+	s := newWebhookSender()
+	s.retries = 2
+	s.backoff = time.Millisecond
+	s.breakerThreshold = 2
+	s.breakerCooldown = time.Hour
+	return s
+}
+
+func newTestRecorder(alertConfig Config) (*monitor.Recorder, chan string) {
+	logger, logs := log.NewMockLogger()
+	return &monitor.Recorder{
+		Config: monitor.NewConfig(monitor.RawConfig{
+			"id":    "m1",
+			"name":  "Front door",
+			"alert": string(mustMarshal(alertConfig)),
+		}),
+		Logger: logger,
+	}, logs
+}
+
+func mustMarshal(v interface{}) []byte {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+type webhookRequest struct {
+	method string
+	header http.Header
+	body   string
+}
+
+func TestWebhook(t *testing.T) {
+	event := &storage.Event{
+		Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Detections: []storage.Detection{
+			{Label: "car", Score: 60},
+			{Label: "person", Score: 90},
+		},
+		Source: "doods",
+	}
+
+	t.Run("success", func(t *testing.T) {
+		requests := make(chan webhookRequest, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			requests <- webhookRequest{r.Method, r.Header, string(body)}
+		}))
+		defer server.Close()
+
+		r, _ := newTestRecorder(Config{
+			WebhookURL:      server.URL,
+			WebhookMethod:   "put",
+			WebhookHeaders:  "Authorization: Bearer abc; Title: x",
+			WebhookTemplate: `{"msg": {{ json (printf "%v: %v" .MonitorName .Label) }}, "time": "{{ .Time.Unix }}"}`,
+		})
+		err := newTestWebhookSender().hook(r, event, nil)
+		require.NoError(t, err)
+
+		req := <-requests
+		require.Equal(t, http.MethodPut, req.method)
+		require.Equal(t, "Bearer abc", req.header.Get("Authorization"))
+		require.Equal(t, "x", req.header.Get("Title"))
+		require.Equal(t, "application/json", req.header.Get("Content-Type"))
+		require.Equal(t, `{"msg": "Front door: person", "time": "1704164645"}`, req.body)
+	})
+	t.Run("defaultPayload", func(t *testing.T) {
+		requests := make(chan webhookRequest, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			requests <- webhookRequest{r.Method, r.Header, string(body)}
+		}))
+		defer server.Close()
+
+		r, _ := newTestRecorder(Config{WebhookURL: server.URL})
+		err := newTestWebhookSender().hook(r, event, nil)
+		require.NoError(t, err)
+
+		req := <-requests
+		require.Equal(t, http.MethodPost, req.method)
+
+		var data WebhookData
+		require.NoError(t, json.Unmarshal([]byte(req.body), &data))
+		require.Equal(t, WebhookData{
+			MonitorID:   "m1",
+			MonitorName: "Front door",
+			Time:        event.Time,
+			Detections:  event.Detections,
+			Source:      "doods",
+			Label:       "person",
+			Score:       90,
+		}, data)
+	})
+	t.Run("retryThenSuccess", func(t *testing.T) {
+		var calls atomic.Int32
+		done := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			close(done)
+		}))
+		defer server.Close()
+
+		r, _ := newTestRecorder(Config{WebhookURL: server.URL})
+		s := newTestWebhookSender()
+		require.NoError(t, s.hook(r, event, nil))
+		<-done
+		require.Equal(t, int32(3), calls.Load())
+	})
+	t.Run("clientErrorNotRetried", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(strings.Repeat("x", 1000))) //nolint:errcheck
+		}))
+		defer server.Close()
+
+		r, logs := newTestRecorder(Config{WebhookURL: server.URL})
+		require.NoError(t, newTestWebhookSender().hook(r, event, nil))
+
+		msg := <-logs
+		require.Contains(t, msg, "webhook failed: http://127.0.0.1:")
+		require.Contains(t, msg, "unexpected response status: 400 Bad Request")
+		require.Contains(t, msg, strings.Repeat("x", webhookMaxLogBody)+`..."`)
+		require.NotContains(t, msg, strings.Repeat("x", webhookMaxLogBody+1))
+		require.Equal(t, int32(1), calls.Load())
+	})
+	t.Run("circuitBreaker", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		okCalls := make(chan struct{}, 10)
+		okServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			okCalls <- struct{}{}
+		}))
+		defer okServer.Close()
+
+		s := newTestWebhookSender()
+		r, logs := newTestRecorder(Config{WebhookURL: server.URL})
+
+		// Each failed delivery is 1 attempt and 2 retries.
+		require.NoError(t, s.hook(r, event, nil))
+		require.Contains(t, <-logs, "webhook failed")
+		require.Equal(t, int32(3), calls.Load())
+
+		require.NoError(t, s.hook(r, event, nil))
+		require.Contains(t, <-logs, "webhook failed")
+		require.Contains(t, <-logs, "webhook circuit opened for 1h0m0s")
+		require.Equal(t, int32(6), calls.Load())
+
+		err := s.hook(r, event, nil)
+		require.ErrorIs(t, err, ErrWebhookCircuitOpen)
+		require.Equal(t, int32(6), calls.Load())
+
+		// Other destinations are unaffected.
+		r2, _ := newTestRecorder(Config{WebhookURL: okServer.URL})
+		require.NoError(t, s.hook(r2, event, nil))
+		<-okCalls
+	})
+	t.Run("queueFull", func(t *testing.T) {
+		block := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-block
+		}))
+		defer server.Close()
+		defer close(block)
+
+		s := newTestWebhookSender()
+		s.queueSize = 1
+		r, _ := newTestRecorder(Config{WebhookURL: server.URL})
+
+		// The first job is being delivered and the second is queued.
+		require.NoError(t, s.hook(r, event, nil))
+		require.Eventually(t, func() bool {
+			return len(s.destination(destinationKey{url: server.URL}).queue) == 0
+		}, time.Second, time.Millisecond)
+		require.NoError(t, s.hook(r, event, nil))
+		require.ErrorIs(t, s.hook(r, event, nil), ErrWebhookQueueFull)
+	})
+	t.Run("insecure", func(t *testing.T) {
+		called := make(chan struct{}, 1)
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called <- struct{}{}
+		}))
+		defer server.Close()
+
+		s := newTestWebhookSender()
+		s.retries = 0
+
+		// The certificate is self signed.
+		r, logs := newTestRecorder(Config{WebhookURL: server.URL})
+		require.NoError(t, s.hook(r, event, nil))
+		require.Contains(t, <-logs, "certificate")
+
+		r, _ = newTestRecorder(Config{WebhookURL: server.URL, WebhookInsecure: "true"})
+		require.NoError(t, s.hook(r, event, nil))
+		<-called
+	})
+}
+
+func TestParseWebhookConfig(t *testing.T) {
+	cases := map[string]struct {
+		config Config
+		err    error
+		nilCfg bool
+	}{
+		"disabled":        {Config{}, nil, true},
+		"ok":              {Config{WebhookURL: "https://a/b", WebhookHeaders: "A: 1;;B:2;"}, nil, false},
+		"relativeURL":     {Config{WebhookURL: "/hook"}, ErrWebhookURL, true},
+		"scheme":          {Config{WebhookURL: "ftp://a/b"}, ErrWebhookURL, true},
+		"invalidHeader":   {Config{WebhookURL: "http://a", WebhookHeaders: "A: 1; B"}, ErrWebhookHeader, true},
+		"invalidTemplate": {Config{WebhookURL: "http://a", WebhookTemplate: "{{ .X"}, ErrWebhookTemplate, true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c, err := parseWebhookConfig(tc.config)
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.nilCfg, c == nil)
+		})
+	}
+}
@@ -0,0 +1,217 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package audiotrigger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"nvr"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var nvrAddon = nvr.RegisterAddon("audiotrigger", "Audio trigger")
+
+func init() {
+	nvrAddon.RegisterMonitorInputProcessHook(onInputProcessStart)
+	nvrAddon.RegisterMonitorConfigKeys(
+		"audioTrigger", "audioThreshold", "audioMinDuration", "audioTriggerDuration")
+	nvr.RegisterLogSource([]string{"audio"})
+
+	nvrAddon.RegisterTplHook(modifyTemplates)
+}
+
+// The audio is decoded to mono PCM at a low sample
+// rate, the loudness is calculated for each window.
+const (
+	sampleRate = 8000
+	window     = 100 * time.Millisecond
+	windowSize = sampleRate * int(window/time.Millisecond) / 1000 * 2
+)
+
+func onInputProcessStart(ctx context.Context, i *monitor.InputProcess, _ *[]string) {
+	// The audio is taken from the main stream.
+	if i.IsSubInput() {
+		return
+	}
+
+	id := i.Config.ID()
+	logf := func(level log.Level, format string, a ...interface{}) {
+		i.Logger.Log(log.Entry{
+			Level:     level,
+			Src:       "audio",
+			MonitorID: id,
+			Msg:       fmt.Sprintf(format, a...),
+		})
+	}
+
+	config, enable, err := parseConfig(i.Config)
+	if err != nil {
+		logf(log.LevelError, "could not parse config: %v", err)
+		return
+	}
+	if !enable {
+		return
+	}
+
+	i.WG.Add(1)
+	go start(ctx, i, *config, logf)
+}
+
+func start(
+	ctx context.Context,
+	i *monitor.InputProcess,
+	config config,
+	logf log.Func,
+) {
+	defer i.WG.Done()
+
+	// Wait for the monitor to start.
+	select {
+	case <-time.After(10 * time.Second):
+	case <-ctx.Done():
+		return
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		ctx2, cancel := context.WithCancel(ctx)
+
+		if err := run(ctx2, cancel, i, config, logf); err != nil {
+			logf(log.LevelError, "%v", err)
+		}
+
+		select {
+		case <-time.After(3 * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ErrNoAudioTrack stream does not have a audio track.
+var ErrNoAudioTrack = errors.New("stream does not have a audio track")
+
+func run(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	i *monitor.InputProcess,
+	config config,
+	logf log.Func,
+) error {
+	audioTrack, err := i.AudioTrack(ctx)
+	if err != nil {
+		return fmt.Errorf("get audio track: %w", err)
+	}
+	if audioTrack == nil {
+		return ErrNoAudioTrack
+	}
+
+	args := generateFFmpegArgs(config, i.RTSPprotocol(), i.RTSPaddress())
+	cmd := exec.Command(i.Env.FFmpegBin, args...)
+
+	processLogFunc := func(msg string) {
+		logf(log.FFmpegLevel(config.logLevel), fmt.Sprintf("process: %v", msg))
+	}
+
+	process := i.NewProcess(cmd).
+		StderrLogger(processLogFunc)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("stdout: %w", err)
+	}
+
+	logf(log.LevelInfo, "starting process: %v", cmd)
+
+	r := &levelReader{
+		sendEvent: i.SendEvent,
+		logf:      logf,
+		config:    config,
+		detector:  newDetector(config.threshold, config.minDuration, window),
+	}
+
+	i.WG.Add(1)
+	go r.start(cancel, i.WG, stdout)
+
+	err = process.Start(ctx)
+	if err != nil {
+		return fmt.Errorf("process crashed: %w", err)
+	}
+	return nil
+}
+
+func generateFFmpegArgs(
+	c config,
+	rtspProtocol string,
+	rtspAddress string,
+) []string {
+	// Output.
+	//	ffmpeg -y -threads 1 -loglevel info -rtsp_transport tcp -i rtsp://ip
+	//    -vn -ac 1 -ar 8000 -f s16le -
+
+	return []string{
+		"-y", "-threads", "1", "-loglevel", c.logLevel,
+		"-rtsp_transport", rtspProtocol, "-i", rtspAddress,
+		"-vn", "-ac", "1", "-ar", strconv.Itoa(sampleRate),
+		"-f", "s16le", "-",
+	}
+}
+
+type levelReader struct {
+	sendEvent monitor.SendEventFunc
+	logf      log.Func
+	config    config
+	detector  *detector
+}
+
+func (r *levelReader) start(
+	cancel context.CancelFunc,
+	wg *sync.WaitGroup,
+	stdout io.Reader,
+) {
+	defer wg.Done()
+	err := r.run(stdout)
+	if !errors.Is(err, io.EOF) {
+		r.logf(log.LevelError, "level reader: %v", err)
+	}
+	cancel()
+}
+
+func (r *levelReader) run(stdout io.Reader) error {
+	buf := make([]byte, windowSize)
+	for {
+		_, err := io.ReadFull(stdout, buf)
+		if err != nil {
+			return fmt.Errorf("read samples: %w", err)
+		}
+
+		level := rmsLevel(buf)
+		score, trigger := r.detector.update(level)
+		if !trigger {
+			continue
+		}
+
+		r.logf(log.LevelDebug, "trigger: level:%.1fdB score:%.2f", level, score)
+		t := time.Now().Add(-r.config.timestampOffset - r.config.minDuration)
+		r.sendEvent(storage.Event{ //nolint:errcheck
+			Detections: []storage.Detection{
+				{Label: "audio", Score: score},
+			},
+			Time:        t,
+			Duration:    r.config.minDuration,
+			RecDuration: r.config.recDuration,
+			Source:      "audio",
+		})
+	}
+}
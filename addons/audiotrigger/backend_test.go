@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package audiotrigger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateFFmpegArgs(t *testing.T) {
+	c := config{logLevel: "1"}
+	actual := generateFFmpegArgs(c, "2", "3")
+
+	expected := []string{
+		"-y", "-threads", "1", "-loglevel", "1",
+		"-rtsp_transport", "2", "-i", "3",
+		"-vn", "-ac", "1", "-ar", "8000",
+		"-f", "s16le", "-",
+	}
+	require.Equal(t, expected, actual)
+}
+
+func TestLevelReader(t *testing.T) {
+	// Writes a window with a constant amplitude.
+	var stdout bytes.Buffer
+	writeWindow := func(amplitude int16) {
+		for i := 0; i < windowSize/2; i++ {
+			if i%2 == 1 {
+				binary.Write(&stdout, binary.LittleEndian, -amplitude) //nolint:errcheck
+			} else {
+				binary.Write(&stdout, binary.LittleEndian, amplitude) //nolint:errcheck
+			}
+		}
+	}
+	writeWindow(0)
+	writeWindow(16384)
+	writeWindow(16384)
+	writeWindow(0)
+
+	var events []storage.Event
+	c := config{
+		threshold:   -30,
+		minDuration: 200 * time.Millisecond,
+		recDuration: 1 * time.Second,
+	}
+	r := &levelReader{
+		sendEvent: func(e storage.Event) error {
+			events = append(events, e)
+			return nil
+		},
+		logf:     func(log.Level, string, ...interface{}) {},
+		config:   c,
+		detector: newDetector(c.threshold, c.minDuration, window),
+	}
+
+	err := r.run(&stdout)
+	require.ErrorIs(t, err, io.EOF)
+
+	require.Len(t, events, 1)
+	require.Equal(t, "audio", events[0].Detections[0].Label)
+	require.InDelta(t, 80, events[0].Detections[0].Score, 0.1)
+	require.Equal(t, 200*time.Millisecond, events[0].Duration)
+	require.Equal(t, 1*time.Second, events[0].RecDuration)
+}
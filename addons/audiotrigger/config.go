@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package audiotrigger
+
+import (
+	"errors"
+	"fmt"
+	"nvr/pkg/monitor"
+	"strconv"
+	"time"
+)
+
+type config struct {
+	monitorID       string
+	logLevel        string
+	timestampOffset time.Duration
+	threshold       float64
+	minDuration     time.Duration
+	recDuration     time.Duration
+}
+
+// ErrInvalidThreshold threshold must be below full scale.
+var ErrInvalidThreshold = errors.New("threshold must be between -96 and 0 dBFS")
+
+func parseConfig(c monitor.Config) (*config, bool, error) {
+	if c.Get("audioTrigger") != "true" {
+		return nil, false, nil
+	}
+
+	timestampOffset, err := parseMilliseconds(c.TimestampOffset())
+	if err != nil {
+		return nil, false, fmt.Errorf("parse timestamp offset: %w", err)
+	}
+
+	threshold, err := strconv.ParseFloat(c.Get("audioThreshold"), 64)
+	if err != nil {
+		return nil, false, fmt.Errorf("parse threshold: %w", err)
+	}
+	if threshold < minLevel || threshold >= 0 {
+		return nil, false, fmt.Errorf("%w: %v", ErrInvalidThreshold, threshold)
+	}
+
+	minDuration, err := parseMilliseconds(c.Get("audioMinDuration"))
+	if err != nil {
+		return nil, false, fmt.Errorf("parse min duration: %w", err)
+	}
+
+	recDurationInt, err := strconv.Atoi(c.Get("audioTriggerDuration"))
+	if err != nil {
+		return nil, false, fmt.Errorf("parse trigger duration: %w", err)
+	}
+
+	return &config{
+		monitorID:       c.ID(),
+		logLevel:        c.LogLevel(),
+		timestampOffset: timestampOffset,
+		threshold:       threshold,
+		minDuration:     minDuration,
+		recDuration:     time.Duration(recDurationInt) * time.Second,
+	}, true, nil
+}
+
+func parseMilliseconds(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	ms, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package audiotrigger
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"nvr/pkg/monitor"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	newTestConfig := func() monitor.RawConfig {
+		return monitor.RawConfig{
+			"id":                   "1",
+			"logLevel":             "2",
+			"timestampOffset":      "3",
+			"audioTrigger":         "true",
+			"audioThreshold":       "-4.5",
+			"audioMinDuration":     "6",
+			"audioTriggerDuration": "7",
+		}
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		actual, enable, err := parseConfig(monitor.NewConfig(newTestConfig()))
+		require.NoError(t, err)
+		require.True(t, enable)
+
+		expected := config{
+			monitorID:       "1",
+			logLevel:        "2",
+			timestampOffset: 3 * time.Millisecond,
+			threshold:       -4.5,
+			minDuration:     6 * time.Millisecond,
+			recDuration:     7 * time.Second,
+		}
+		require.Equal(t, expected, *actual)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		c := newTestConfig()
+		c["audioTrigger"] = "false"
+		_, enable, err := parseConfig(monitor.NewConfig(c))
+		require.NoError(t, err)
+		require.False(t, enable)
+	})
+	t.Run("thresholdErr", func(t *testing.T) {
+		c := newTestConfig()
+		c["audioThreshold"] = "nil"
+		_, _, err := parseConfig(monitor.NewConfig(c))
+		require.ErrorIs(t, err, strconv.ErrSyntax)
+	})
+	t.Run("thresholdInvalid", func(t *testing.T) {
+		for _, threshold := range []string{"0", "1", "-97"} {
+			c := newTestConfig()
+			c["audioThreshold"] = threshold
+			_, _, err := parseConfig(monitor.NewConfig(c))
+			require.ErrorIs(t, err, ErrInvalidThreshold)
+		}
+	})
+	t.Run("minDurationErr", func(t *testing.T) {
+		c := newTestConfig()
+		c["audioMinDuration"] = "nil"
+		_, _, err := parseConfig(monitor.NewConfig(c))
+		require.ErrorIs(t, err, strconv.ErrSyntax)
+	})
+	t.Run("triggerDurationErr", func(t *testing.T) {
+		c := newTestConfig()
+		c["audioTriggerDuration"] = "nil"
+		_, _, err := parseConfig(monitor.NewConfig(c))
+		require.ErrorIs(t, err, strconv.ErrSyntax)
+	})
+}
@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package audiotrigger
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Lowest level that can be represented by 16 bit samples.
+const minLevel = -96 // dBFS.
+
+// The level has to drop this far below the threshold before the trigger
+// is released. A level hovering around the threshold would otherwise
+// start and stop the trigger on every window.
+const hysteresis = 6 // dB.
+
+// While the trigger is active, a new event is sent
+// at this interval to keep the recorder running.
+const retriggerInterval = 5 * time.Second
+
+// detector turns a sequence of loudness levels into trigger events.
+// The threshold has to be exceeded for the minimum duration before
+// it triggers, a single loud click is ignored.
+type detector struct {
+	threshold float64
+	release   float64
+
+	minWindows       int
+	retriggerWindows int
+
+	loudWindows      int
+	active           bool
+	windowsSinceSent int
+}
+
+func newDetector(threshold float64, minDuration, window time.Duration) *detector {
+	minWindows := int(math.Ceil(float64(minDuration) / float64(window)))
+	if minWindows < 1 {
+		minWindows = 1
+	}
+	return &detector{
+		threshold:        threshold,
+		release:          threshold - hysteresis,
+		minWindows:       minWindows,
+		retriggerWindows: int(retriggerInterval / window),
+	}
+}
+
+// update is called with the level of each window. Returns
+// true and the score if a event should be sent.
+func (d *detector) update(level float64) (float64, bool) {
+	if d.active {
+		if level < d.release {
+			d.active = false
+			d.loudWindows = 0
+			return 0, false
+		}
+		d.windowsSinceSent++
+		if d.windowsSinceSent < d.retriggerWindows || level < d.threshold {
+			return 0, false
+		}
+		d.windowsSinceSent = 0
+		return d.score(level), true
+	}
+
+	switch {
+	case level >= d.threshold:
+		d.loudWindows++
+	case level < d.release:
+		d.loudWindows = 0
+	}
+	// Levels between the release level and the threshold
+	// neither count towards nor reset the duration.
+
+	if d.loudWindows < d.minWindows {
+		return 0, false
+	}
+	d.active = true
+	d.windowsSinceSent = 0
+	return d.score(level), true
+}
+
+// score is the level above the threshold as a percentage
+// of the range between the threshold and full scale.
+func (d *detector) score(level float64) float64 {
+	score := (level - d.threshold) / -d.threshold * 100
+	return math.Max(0, math.Min(100, score))
+}
+
+// rmsLevel returns the RMS level of signed 16 bit
+// little-endian samples in decibels relative to full scale.
+func rmsLevel(samples []byte) float64 {
+	n := len(samples) / 2
+	if n == 0 {
+		return minLevel
+	}
+	var sum float64
+	for i := 0; i < n; i++ {
+		sample := float64(int16(binary.LittleEndian.Uint16(samples[i*2:])))
+		sum += sample * sample
+	}
+	rms := math.Sqrt(sum/float64(n)) / math.MaxInt16
+	if rms == 0 {
+		return minLevel
+	}
+	return math.Max(minLevel, 20*math.Log10(rms))
+}
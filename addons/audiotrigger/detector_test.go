@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package audiotrigger
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetector(t *testing.T) {
+	// Threshold -30, release -36, triggers after 3 windows.
+	newTestDetector := func() *detector {
+		return newDetector(-30, 300*time.Millisecond, 100*time.Millisecond)
+	}
+
+	// Returns the indexes of the levels that triggered.
+	triggers := func(d *detector, levels []float64) []int {
+		var triggered []int
+		for i, level := range levels {
+			if _, trigger := d.update(level); trigger {
+				triggered = append(triggered, i)
+			}
+		}
+		return triggered
+	}
+
+	testCases := map[string]struct {
+		levels   []float64
+		expected []int
+	}{
+		"silence": {
+			levels:   []float64{-96, -96, -96, -96, -96},
+			expected: nil,
+		},
+		"click": {
+			levels:   []float64{-96, -10, -96, -96, -10, -96},
+			expected: nil,
+		},
+		"sustained": {
+			levels:   []float64{-96, -20, -20, -20, -20, -20},
+			expected: []int{3},
+		},
+		"exactThreshold": {
+			levels:   []float64{-30, -30, -30},
+			expected: []int{2},
+		},
+		"belowThreshold": {
+			levels:   []float64{-31, -31, -31, -31, -31},
+			expected: nil,
+		},
+		"hysteresisHold": {
+			// Dips that stay above the release level
+			// do not reset the duration.
+			levels:   []float64{-20, -33, -20, -33, -20},
+			expected: []int{4},
+		},
+		"hysteresisReset": {
+			levels:   []float64{-20, -20, -40, -20, -20},
+			expected: nil,
+		},
+		"retriggerAfterRelease": {
+			levels:   []float64{-20, -20, -20, -33, -40, -20, -20, -20},
+			expected: []int{2, 7},
+		},
+		"noRetriggerInsideHysteresis": {
+			levels:   []float64{-20, -20, -20, -33, -20, -20, -20},
+			expected: []int{2},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			d := newTestDetector()
+			require.Equal(t, tc.expected, triggers(d, tc.levels))
+		})
+	}
+
+	t.Run("retriggerInterval", func(t *testing.T) {
+		d := newTestDetector()
+		levels := make([]float64, 103)
+		for i := range levels {
+			levels[i] = -20
+		}
+		// Triggers after 3 windows and then every 50 windows.
+		require.Equal(t, []int{2, 52, 102}, triggers(d, levels))
+	})
+	t.Run("minDurationZero", func(t *testing.T) {
+		d := newDetector(-30, 0, 100*time.Millisecond)
+		require.Equal(t, []int{1}, triggers(d, []float64{-96, -20}))
+	})
+}
+
+func TestDetectorScore(t *testing.T) {
+	d := newDetector(-30, 0, 100*time.Millisecond)
+
+	testCases := []struct {
+		level    float64
+		expected float64
+	}{
+		{-30, 0},
+		{-15, 50},
+		{0, 100},
+		{3, 100},
+	}
+	for _, tc := range testCases {
+		require.InDelta(t, tc.expected, d.score(tc.level), 0.001)
+	}
+}
+
+func TestRMSLevel(t *testing.T) {
+	samples := func(values ...int16) []byte {
+		b := make([]byte, len(values)*2)
+		for i, v := range values {
+			binary.LittleEndian.PutUint16(b[i*2:], uint16(v))
+		}
+		return b
+	}
+
+	testCases := map[string]struct {
+		input    []byte
+		expected float64
+	}{
+		"empty":     {nil, minLevel},
+		"silence":   {samples(0, 0, 0, 0), minLevel},
+		"fullScale": {samples(math.MaxInt16, -math.MaxInt16), 0},
+		"half":      {samples(math.MaxInt16/2, -math.MaxInt16/2), -6.02},
+		"tenth":     {samples(3277, -3277, 3277, -3277), -20},
+		"quiet":     {samples(1, -1), -90.31},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require.InDelta(t, tc.expected, rmsLevel(tc.input), 0.01)
+		})
+	}
+}
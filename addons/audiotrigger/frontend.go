@@ -0,0 +1,45 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package audiotrigger
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func modifyTemplates(pageFiles map[string]string) error {
+	js, exists := pageFiles["settings.js"]
+	if !exists {
+		return fmt.Errorf("audiotrigger: settings.js: %w", os.ErrNotExist)
+	}
+
+	pageFiles["settings.js"] = modifySettingsjs(js)
+	return nil
+}
+
+func modifySettingsjs(tpl string) string {
+	const target = "logLevel: fieldTemplate.select("
+
+	const javascript = `
+		audioTrigger: fieldTemplate.toggle("Audio trigger", "false"),
+		audioThreshold: newField(
+			[inputRules.notEmpty, inputRules.noSpaces],
+			{
+				errorField: true,
+				input: "number",
+				min: "-96",
+				max: "-1",
+			},
+			{
+				label: "Audio threshold (dBFS)",
+				placeholder: "-30",
+				initial: "-30",
+			},
+		),
+		audioMinDuration: fieldTemplate.integer("Audio min duration (ms)", "500", "500"),
+		audioTriggerDuration: fieldTemplate.integer("Audio trigger duration (sec)", "120", "120"),
+		`
+
+	return strings.ReplaceAll(tpl, target, javascript+target)
+}
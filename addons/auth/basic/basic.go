@@ -3,6 +3,7 @@
 package basic
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -10,15 +11,15 @@ import (
 	"io"
 	"net/http"
 	"nvr"
+	"nvr/pkg/audit"
 	"nvr/pkg/log"
 	"nvr/pkg/storage"
+	"nvr/pkg/web/api"
 	"nvr/pkg/web/auth"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 func init() {
@@ -31,9 +32,14 @@ type Authenticator struct {
 	accounts  map[string]auth.Account
 	authCache map[string]auth.ValidateResponse
 
-	hashCost int
+	hasher   auth.PasswordHasher
+	sessions *auth.SessionStore
+
+	// Nil if proxy auth is disabled.
+	proxy *auth.ProxyAuth
 
 	logger *log.Logger
+	audit  audit.Func
 
 	// hashLock limits concurrent hashing operations
 	// to mitigate denial of service attacks.
@@ -42,18 +48,41 @@ type Authenticator struct {
 }
 
 // NewBasicAuthenticator creates basic authenticator.
-func NewBasicAuthenticator(env storage.ConfigEnv, logger *log.Logger) (auth.Authenticator, error) {
+func NewBasicAuthenticator(
+	env storage.ConfigEnv,
+	logger *log.Logger,
+	auditf audit.Func,
+) (auth.Authenticator, error) {
+	proxy, err := auth.NewProxyAuth(env.ProxyAuth)
+	if err != nil {
+		return nil, fmt.Errorf("proxy auth: %w", err)
+	}
+
+	sessions, err := auth.NewSessionStore(filepath.Join(env.ConfigDir, "sessions.json"))
+	if err != nil {
+		return nil, fmt.Errorf("session store: %w", err)
+	}
+
 	path := filepath.Join(env.ConfigDir, "users.json")
 	a := Authenticator{
 		path:      path,
 		accounts:  make(map[string]auth.Account),
 		authCache: make(map[string]auth.ValidateResponse),
 
-		hashCost: auth.DefaultBcryptHashCost,
+		hasher:   auth.NewPasswordHasher(env.PasswordHash),
+		sessions: sessions,
+		proxy:    proxy,
 		logger:   logger,
+		audit:    auditf,
 	}
 
+	// New installs don't have any accounts, the
+	// first admin is created by the first-run setup.
 	file, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		a.resetTokens()
+		return &a, nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("read accounts file: %w", err)
 	}
@@ -73,9 +102,66 @@ func NewBasicAuthenticator(env storage.ConfigEnv, logger *log.Logger) (auth.Auth
 	return &a, nil
 }
 
-// ValidateRequest Should always take the same amount of
-// time to run, even when username or password is invalid.
+// ValidateRequest validates the basic auth credentials and the session.
+// Requests from trusted proxies are validated by the proxy
+// headers, basic auth is used as a fallback.
 func (a *Authenticator) ValidateRequest(r *http.Request) auth.ValidateResponse {
+	if a.proxy != nil {
+		if identity, ok := a.proxy.Identify(r); ok {
+			return a.validateProxyIdentity(r, identity)
+		}
+	}
+
+	res := a.validateBasic(r)
+	if !res.IsValid {
+		return res
+	}
+	return a.validateSession(r, res)
+}
+
+// validateSession rejects requests with a revoked or unknown session
+// cookie. Requests without a cookie are valid, see newSession.
+func (a *Authenticator) validateSession(r *http.Request, res auth.ValidateResponse) auth.ValidateResponse {
+	cookie, err := r.Cookie(auth.SessionCookie)
+	if err != nil {
+		return res
+	}
+	session, valid := a.sessions.Validate(cookie.Value)
+	if !valid || session.AccountID != res.User.ID {
+		return auth.ValidateResponse{}
+	}
+	res.SessionID = session.ID
+	return res
+}
+
+// newSession creates a session if a browser without a session loads a page.
+func (a *Authenticator) newSession(w http.ResponseWriter, r *http.Request, res auth.ValidateResponse) {
+	if res.Source != auth.SourceBasic || res.SessionID != "" || !auth.IsPageRequest(r) {
+		return
+	}
+	token, err := a.sessions.Create(res.User.ID, r)
+	if err != nil {
+		a.logger.Log(log.Entry{
+			Level: log.LevelError,
+			Src:   "auth",
+			Msg:   fmt.Sprintf("could not create session: %v: %v", res.User.Username, err),
+		})
+		return
+	}
+	auth.SetSessionCookie(w, r, token)
+}
+
+// clearSession removes the session cookie from rejected
+// requests, a new session is created after the next login.
+func clearSession(w http.ResponseWriter, r *http.Request) {
+	if _, err := r.Cookie(auth.SessionCookie); err == nil {
+		auth.ClearSessionCookie(w)
+	}
+}
+
+// validateBasic Should always take the same amount of
+// time to run, even when username or password is invalid.
+func (a *Authenticator) validateBasic(r *http.Request) auth.ValidateResponse {
 	req := r.Header.Get("Authorization")
 
 	a.mu.Lock()
@@ -95,12 +181,16 @@ func (a *Authenticator) ValidateRequest(r *http.Request) auth.ValidateResponse {
 	defer a.hashLock.Unlock()
 	if !found || name != user.Username {
 		// Generate fake hash to prevent timing based attacks.
-		bcrypt.GenerateFromPassword([]byte(name), a.hashCost) //nolint:errcheck
+		a.hasher.Hash(name) //nolint:errcheck
 		return auth.ValidateResponse{}
 	}
-	if passwordsMatch(user.Password, pass) {
+	ok, needsRehash := a.hasher.Verify(user.Password, pass)
+	if ok {
+		if needsRehash {
+			user = a.rehash(user, pass)
+		}
 		a.mu.Lock()
-		res := auth.ValidateResponse{IsValid: true, User: user}
+		res := auth.ValidateResponse{IsValid: true, User: user, Source: auth.SourceBasic}
 		a.authCache[req] = res // Only cache valid requests.
 		a.mu.Unlock()
 		return res
@@ -108,11 +198,108 @@ func (a *Authenticator) ValidateRequest(r *http.Request) auth.ValidateResponse {
 	return auth.ValidateResponse{}
 }
 
-func passwordsMatch(hash []byte, plaintext string) bool {
-	if err := bcrypt.CompareHashAndPassword(hash, []byte(plaintext)); err != nil {
-		return false
+// validateProxyIdentity unknown users are rejected unless auto
+// provisioning is enabled. The role is taken from the proxy
+// groups if present, otherwise from the account.
+func (a *Authenticator) validateProxyIdentity(
+	r *http.Request,
+	identity auth.ProxyIdentity,
+) auth.ValidateResponse {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	user, found := a.userByNameUnsafe(identity.Username)
+	if !found {
+		if !a.proxy.AutoProvision() {
+			return auth.ValidateResponse{}
+		}
+		var err error
+		user, err = a.provisionUserUnsafe(r, identity.Username)
+		if err != nil {
+			a.logger.Log(log.Entry{
+				Level: log.LevelError,
+				Src:   "auth",
+				Msg:   fmt.Sprintf("could not provision user: %v: %v", identity.Username, err),
+			})
+			return auth.ValidateResponse{}
+		}
+	}
+
+	if identity.HasGroups {
+		user.IsAdmin = identity.IsAdmin
+	}
+	return auth.ValidateResponse{IsValid: true, User: user, Source: auth.SourceProxy}
+}
+
+// provisionUserUnsafe creates a account without a password,
+// the user can only login through the proxy.
+func (a *Authenticator) provisionUserUnsafe(r *http.Request, username string) (auth.Account, error) {
+	user := auth.Account{
+		ID:       auth.GenToken()[:16],
+		Username: username,
+		Token:    auth.GenToken(),
+	}
+
+	err := a.audit(audit.Entry{
+		User:   username,
+		IP:     auth.RequestIP(r),
+		Action: audit.ActionAccountCreate,
+		Target: user.ID,
+		Auth:   auth.SourceProxy,
+	})
+	if err != nil {
+		return auth.Account{}, fmt.Errorf("audit: %w", err)
+	}
+
+	a.accounts[user.ID] = user
+	if err := a.saveToFile(); err != nil {
+		delete(a.accounts, user.ID)
+		return auth.Account{}, fmt.Errorf("save users to file: %w", err)
+	}
+
+	a.logger.Log(log.Entry{
+		Level: log.LevelInfo,
+		Src:   "auth",
+		Msg:   fmt.Sprintf("provisioned user from proxy: %v", username),
+	})
+	return user, nil
+}
+
+// rehash upgrades the password hash of a user to the preferred scheme
+// after a successful login. The hash is only replaced if the password
+// wasn't changed in the meantime. Errors are logged and the old hash
+// is kept, the login is valid either way. Caller must hold hashLock.
+func (a *Authenticator) rehash(user auth.Account, plaintext string) auth.Account {
+	logError := func(err error) {
+		a.logger.Log(log.Entry{
+			Level: log.LevelError,
+			Src:   "auth",
+			Msg:   fmt.Sprintf("could not upgrade password hash: %v: %v", user.Username, err),
+		})
+	}
+
+	newHash, err := a.hasher.Hash(plaintext)
+	if err != nil {
+		logError(err)
+		return user
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	current, exists := a.accounts[user.ID]
+	if !exists || !bytes.Equal(current.Password, user.Password) {
+		return user
 	}
-	return true
+
+	current.Password = newHash
+	a.accounts[user.ID] = current
+	if err := a.saveToFile(); err != nil {
+		a.accounts[user.ID] = user
+		logError(err)
+		return user
+	}
+	return current
 }
 
 func (a *Authenticator) userByNameUnsafe(name string) (auth.Account, bool) {
@@ -204,11 +391,13 @@ func (a *Authenticator) UserSet(req auth.SetUserRequest) error {
 	user := a.accounts[req.ID]
 	a.mu.Unlock()
 
+	passwordChanged := exists && req.PlainPassword != ""
+
 	user.ID = req.ID
 	user.Username = req.Username
 	user.IsAdmin = req.IsAdmin
 	if req.PlainPassword != "" {
-		hashedNewPassword, err := bcrypt.GenerateFromPassword([]byte(req.PlainPassword), a.hashCost)
+		hashedNewPassword, err := a.hasher.Hash(req.PlainPassword)
 		if err != nil {
 			return fmt.Errorf("hash password: %w", err)
 		}
@@ -226,6 +415,12 @@ func (a *Authenticator) UserSet(req auth.SetUserRequest) error {
 		return fmt.Errorf("save users to file: %w", err)
 	}
 
+	if passwordChanged {
+		if err := a.sessions.RevokeAccount(user.ID, req.KeepSessionID); err != nil {
+			return fmt.Errorf("revoke sessions: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -245,20 +440,46 @@ func (a *Authenticator) UserDelete(id string) error {
 		return err
 	}
 
+	if err := a.sessions.RevokeAccount(id, ""); err != nil {
+		return fmt.Errorf("revoke sessions: %w", err)
+	}
+
 	return nil
 }
 
+// SessionsList returns the sessions of a account,
+// the sessions of all accounts if the id is empty.
+func (a *Authenticator) SessionsList(accountID string) []auth.Session {
+	return a.sessions.List(accountID)
+}
+
+// SessionRevoke revokes a session by id. The session must
+// belong to the account unless the account id is empty.
+func (a *Authenticator) SessionRevoke(id string, accountID string) error {
+	return a.sessions.Revoke(id, accountID)
+}
+
+// SessionsRevokeAll revokes all sessions of a account except keepID.
+func (a *Authenticator) SessionsRevokeAll(accountID string, keepID string) error {
+	return a.sessions.RevokeAccount(accountID, keepID)
+}
+
 func (a *Authenticator) saveToFile() error {
 	users, err := json.MarshalIndent(a.accounts, "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshal accounts: %w", err)
 	}
 
-	err = os.WriteFile(a.path, users, 0o600)
-	if err != nil {
+	// Write to a temporary file first so the
+	// accounts file is never partially written.
+	tmpPath := a.path + ".tmp"
+	if err := os.WriteFile(tmpPath, users, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, a.path); err != nil {
+		os.Remove(tmpPath)
 		return err
 	}
-
 	return nil
 }
 
@@ -269,13 +490,20 @@ func (a *Authenticator) User(next http.Handler) http.Handler {
 		if !res.IsValid {
 			if r.Header.Get("Authorization") != "" {
 				username, _ := parseBasicAuth(r.Header.Get("Authorization"))
-				auth.LogFailedLogin(a.logger, r, username)
+				auth.LogFailedLogin(a.logger, a.audit, r, username)
 			}
+			clearSession(w, r)
 			w.Header().Set("WWW-Authenticate", `Basic realm=""`)
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
+		if !auth.ValidCSRF(r, res.User.Token) {
+			api.Forbidden(w, "invalid CSRF-token")
+			return
+		}
+
+		a.newSession(w, r, res)
 		next.ServeHTTP(w, r)
 	})
 }
@@ -288,14 +516,21 @@ func (a *Authenticator) Admin(next http.Handler) http.Handler {
 		if !res.IsValid || !res.User.IsAdmin {
 			if r.Header.Get("Authorization") != "" {
 				username, _ := parseBasicAuth(r.Header.Get("Authorization"))
-				auth.LogFailedLogin(a.logger, r, username)
+				auth.LogFailedLogin(a.logger, a.audit, r, username)
 			}
 
+			clearSession(w, r)
 			w.Header().Set("WWW-Authenticate", `Basic realm="NVR"`)
 			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
 			return
 		}
 
+		if !auth.ValidCSRF(r, res.User.Token) {
+			api.Forbidden(w, "invalid CSRF-token")
+			return
+		}
+
+		a.newSession(w, r, res)
 		next.ServeHTTP(w, r)
 	})
 }
@@ -305,11 +540,9 @@ func (a *Authenticator) Admin(next http.Handler) http.Handler {
 // have a matching token in the "X-CSRF-TOKEN" header.
 func (a *Authenticator) CSRF(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		auth := a.ValidateRequest(r)
-		token := r.Header.Get("X-CSRF-TOKEN")
-
-		if token != auth.User.Token {
-			http.Error(w, "Invalid CSRF-token.", http.StatusUnauthorized)
+		res := a.ValidateRequest(r)
+		if !auth.ValidCSRF(r, res.User.Token) {
+			api.Forbidden(w, "invalid CSRF-token")
 			return
 		}
 
@@ -335,8 +568,14 @@ func (a *Authenticator) MyToken() http.Handler {
 }
 
 // Logout prompts for login and redirects. Old login should be overwritten.
+// The session is revoked.
 func (a *Authenticator) Logout() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(auth.SessionCookie); err == nil {
+			a.sessions.RevokeToken(cookie.Value) //nolint:errcheck
+			auth.ClearSessionCookie(w)
+		}
+
 		switch r.Header.Get("Authorization") {
 		case "Basic Og==":
 		case "":
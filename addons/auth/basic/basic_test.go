@@ -3,24 +3,32 @@
 package basic
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"io/fs"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
+	"nvr/pkg/audit"
 	"nvr/pkg/log"
 	"nvr/pkg/storage"
+	"nvr/pkg/web/api"
 	"nvr/pkg/web/auth"
 
 	"github.com/stretchr/testify/require"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	pass1 = []byte("$2a$04$M0InS5zIFKk.xmjtcabjrudhKhukxJo6cnhJBq9I.J/slbgWE0F.S")
 	pass2 = []byte("$2a$04$A.F3L5bXO/5nF0e6dpmqM.VuOB66.vSt6MbvWvcxeoAqqnvchBMOq")
+
+	testHasher = auth.NewPasswordHasher(storage.PasswordHashConfig{Memory: 64, Iterations: 1})
 )
 
 func newTestAuth(t *testing.T) (string, *Authenticator, func()) {
@@ -53,13 +61,18 @@ func newTestAuth(t *testing.T) (string, *Authenticator, func()) {
 	err = os.WriteFile(usersPath, data, 0o600)
 	require.NoError(t, err)
 
+	sessions, err := auth.NewSessionStore(tempDir + "/sessions.json")
+	require.NoError(t, err)
+
 	auth := Authenticator{
 		path:      usersPath,
 		accounts:  users,
 		authCache: make(map[string]auth.ValidateResponse),
 
-		hashCost: bcrypt.MinCost,
+		hasher:   testHasher,
+		sessions: sessions,
 		logger:   &log.Logger{},
+		audit:    audit.DummyFunc,
 	}
 	return tempDir, &auth, cancelFunc
 }
@@ -78,7 +91,7 @@ func TestNewBasicAuthenticator(t *testing.T) {
 
 		env := storage.ConfigEnv{ConfigDir: tempDir}
 
-		a, err := NewBasicAuthenticator(env, &log.Logger{})
+		a, err := NewBasicAuthenticator(env, &log.Logger{}, audit.DummyFunc)
 		require.NoError(t, err)
 
 		auth := a.(*Authenticator)
@@ -87,9 +100,19 @@ func TestNewBasicAuthenticator(t *testing.T) {
 
 		require.Equal(t, auth.accounts, testAuth.accounts)
 	})
+	t.Run("noFile", func(t *testing.T) {
+		env := storage.ConfigEnv{ConfigDir: t.TempDir()}
+		a, err := NewBasicAuthenticator(env, &log.Logger{}, audit.DummyFunc)
+		require.NoError(t, err)
+		require.Empty(t, a.UsersList())
+	})
 	t.Run("readFileErr", func(t *testing.T) {
-		_, err := NewBasicAuthenticator(storage.ConfigEnv{}, &log.Logger{})
-		require.ErrorIs(t, err, os.ErrNotExist)
+		tempDir := t.TempDir()
+		require.NoError(t, os.Mkdir(filepath.Join(tempDir, "users.json"), 0o700))
+
+		env := storage.ConfigEnv{ConfigDir: tempDir}
+		_, err := NewBasicAuthenticator(env, &log.Logger{}, audit.DummyFunc)
+		require.Error(t, err)
 	})
 }
 
@@ -162,9 +185,13 @@ func TestBasicAuthenticator(t *testing.T) {
 				response := a.ValidateRequest(authHeader("Basic " + auth))
 				require.Equal(t, response.IsValid, tc.valid)
 
+				// The password is rehashed on login.
 				user := response.User
 				user.Token = ""
-				require.Equal(t, user, tc.expected)
+				user.Password = nil
+				expected := tc.expected
+				expected.Password = nil
+				require.Equal(t, user, expected)
 			})
 		}
 
@@ -339,3 +366,453 @@ func TestBasicAuthenticator(t *testing.T) {
 		require.True(t, response2.IsValid)
 	})
 }
+
+func readAccounts(t *testing.T, path string) map[string]auth.Account {
+	t.Helper()
+	file, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var accounts map[string]auth.Account
+	require.NoError(t, json.Unmarshal(file, &accounts))
+	return accounts
+}
+
+func TestRehashOnLogin(t *testing.T) {
+	t.Run("legacy", func(t *testing.T) {
+		tempDir, a, cancel := newTestAuth(t)
+		defer cancel()
+
+		basicAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:pass1"))
+		res := a.ValidateRequest(&http.Request{Header: http.Header{"Authorization": []string{basicAuth}}})
+		require.True(t, res.IsValid)
+
+		newHash := a.accounts["1"].Password
+		require.True(t, strings.HasPrefix(string(newHash), "$argon2id$v=19$m=64,t=1,p=1$"), newHash)
+		require.Equal(t, newHash, res.User.Password)
+
+		// Persisted.
+		accounts := readAccounts(t, tempDir+"/users.json")
+		require.Equal(t, newHash, accounts["1"].Password)
+		require.Equal(t, pass2, accounts["2"].Password)
+
+		ok, needsRehash := a.hasher.Verify(newHash, "pass1")
+		require.True(t, ok)
+		require.False(t, needsRehash)
+
+		// Not rehashed again.
+		a.authCache = make(map[string]auth.ValidateResponse)
+		res = a.ValidateRequest(&http.Request{Header: http.Header{"Authorization": []string{basicAuth}}})
+		require.True(t, res.IsValid)
+		require.Equal(t, newHash, a.accounts["1"].Password)
+	})
+	t.Run("wrongPass", func(t *testing.T) {
+		_, a, cancel := newTestAuth(t)
+		defer cancel()
+
+		basicAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:wrongPass"))
+		res := a.ValidateRequest(&http.Request{Header: http.Header{"Authorization": []string{basicAuth}}})
+		require.False(t, res.IsValid)
+		require.Equal(t, pass1, a.accounts["1"].Password)
+	})
+	t.Run("saveErr", func(t *testing.T) {
+		_, a, cancel := newTestAuth(t)
+		defer cancel()
+		a.path = "/nil/users.json"
+
+		ctx, cancel2 := context.WithCancel(context.Background())
+		defer cancel2()
+		a.logger = log.NewLogger(&sync.WaitGroup{}, nil)
+		require.NoError(t, a.logger.Start(ctx))
+
+		// The login is valid but the old hash is kept.
+		basicAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:pass1"))
+		res := a.ValidateRequest(&http.Request{Header: http.Header{"Authorization": []string{basicAuth}}})
+		require.True(t, res.IsValid)
+		require.Equal(t, pass1, a.accounts["1"].Password)
+	})
+}
+
+func TestConcurrentLogins(t *testing.T) {
+	tempDir, a, cancel := newTestAuth(t)
+	defer cancel()
+
+	credentials := []string{"admin:pass1", "user:pass2"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for _, c := range credentials {
+			wg.Add(1)
+			go func(c string) {
+				defer wg.Done()
+				basicAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte(c))
+				res := a.ValidateRequest(&http.Request{Header: http.Header{"Authorization": []string{basicAuth}}})
+				require.True(t, res.IsValid)
+			}(c)
+		}
+	}
+	wg.Wait()
+
+	accounts := readAccounts(t, tempDir+"/users.json")
+	require.Len(t, accounts, 2)
+	for _, c := range credentials {
+		username, password, _ := strings.Cut(c, ":")
+		account, _ := a.userByNameUnsafe(username)
+		require.Equal(t, account.Password, accounts[account.ID].Password)
+
+		ok, needsRehash := a.hasher.Verify(accounts[account.ID].Password, password)
+		require.True(t, ok)
+		require.False(t, needsRehash)
+	}
+}
+
+func TestFailedLoginAudit(t *testing.T) {
+	_, a, cancel := newTestAuth(t)
+	defer cancel()
+
+	ctx, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	a.logger = log.NewLogger(&sync.WaitGroup{}, nil)
+	require.NoError(t, a.logger.Start(ctx))
+
+	var entries []audit.Entry
+	a.audit = func(e audit.Entry) error {
+		entries = append(entries, e)
+		return nil
+	}
+
+	plainAuth := base64.StdEncoding.EncodeToString([]byte("admin:wrongPass"))
+	r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	r.Header.Set("Authorization", "Basic "+plainAuth)
+	r.RemoteAddr = "1.2.3.4:5"
+	w := httptest.NewRecorder()
+
+	a.Admin(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Fatal("handler should not be called")
+	})).ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	expected := []audit.Entry{{
+		User:   "admin",
+		IP:     "addr:1.2.3.4:5",
+		Action: audit.ActionLoginFailed,
+	}}
+	require.Equal(t, expected, entries)
+}
+
+func TestCSRF(t *testing.T) {
+	_, a, cancel := newTestAuth(t)
+	defer cancel()
+	a.resetTokens()
+	token := a.accounts["1"].Token
+
+	basicAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:pass1"))
+
+	cases := map[string]struct {
+		method   string
+		token    string
+		expected int
+	}{
+		"get":          {http.MethodGet, "", http.StatusOK},
+		"missingToken": {http.MethodPost, "", http.StatusForbidden},
+		"invalidToken": {http.MethodPut, "nil", http.StatusForbidden},
+		"validToken":   {http.MethodDelete, token, http.StatusOK},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			for _, wrap := range []func(http.Handler) http.Handler{a.User, a.Admin} {
+				r := httptest.NewRequest(tc.method, "/api/x", nil)
+				r.Header.Set("Authorization", basicAuth)
+				if tc.token != "" {
+					r.Header.Set("X-CSRF-TOKEN", tc.token)
+				}
+				w := httptest.NewRecorder()
+				wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(w, r)
+				require.Equal(t, tc.expected, w.Code)
+				if tc.expected == http.StatusForbidden {
+					var e api.Error
+					require.NoError(t, json.NewDecoder(w.Body).Decode(&e))
+					require.Equal(t, api.CodeForbidden, e.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyAuth(t *testing.T) {
+	newProxyTestAuth := func(t *testing.T, autoProvision bool) (*Authenticator, *[]audit.Entry) {
+		_, a, cancel := newTestAuth(t)
+		t.Cleanup(cancel)
+
+		ctx, cancel2 := context.WithCancel(context.Background())
+		t.Cleanup(cancel2)
+		a.logger = log.NewLogger(&sync.WaitGroup{}, nil)
+		require.NoError(t, a.logger.Start(ctx))
+
+		var entries []audit.Entry
+		a.audit = func(e audit.Entry) error {
+			entries = append(entries, e)
+			return nil
+		}
+
+		proxy, err := auth.NewProxyAuth(storage.ProxyAuthConfig{
+			Enable:         true,
+			TrustedProxies: []string{"10.0.0.0/24"},
+			AdminGroups:    []string{"admins"},
+			AutoProvision:  autoProvision,
+		})
+		require.NoError(t, err)
+		a.proxy = proxy
+		return a, &entries
+	}
+	newRequest := func(remoteAddr string, user string, groups string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("Remote-User", user)
+		if groups != "" {
+			r.Header.Set("Remote-Groups", groups)
+		}
+		return r
+	}
+
+	t.Run("existingUser", func(t *testing.T) {
+		a, _ := newProxyTestAuth(t, false)
+		res := a.ValidateRequest(newRequest("10.0.0.1:1", "User", ""))
+		require.True(t, res.IsValid)
+		require.Equal(t, "2", res.User.ID)
+		require.False(t, res.User.IsAdmin)
+		require.Equal(t, auth.SourceProxy, res.Source)
+	})
+	t.Run("groupRole", func(t *testing.T) {
+		a, _ := newProxyTestAuth(t, false)
+		res := a.ValidateRequest(newRequest("10.0.0.1:1", "user", "admins"))
+		require.True(t, res.IsValid)
+		require.True(t, res.User.IsAdmin)
+
+		res = a.ValidateRequest(newRequest("10.0.0.1:1", "admin", "dev"))
+		require.True(t, res.IsValid)
+		require.False(t, res.User.IsAdmin)
+
+		// The stored role is not modified.
+		require.True(t, a.accounts["1"].IsAdmin)
+		require.False(t, a.accounts["2"].IsAdmin)
+	})
+	t.Run("spoofed", func(t *testing.T) {
+		a, _ := newProxyTestAuth(t, true)
+		r := newRequest("192.168.1.1:1", "admin", "admins")
+		r.Header.Set("X-Forwarded-For", "10.0.0.1")
+		require.False(t, a.ValidateRequest(r).IsValid)
+
+		w := httptest.NewRecorder()
+		a.Admin(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			t.Fatal("handler should not be called")
+		})).ServeHTTP(w, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Len(t, a.accounts, 2)
+	})
+	t.Run("basicFallback", func(t *testing.T) {
+		a, _ := newProxyTestAuth(t, false)
+		r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+		r.RemoteAddr = "192.168.1.1:1"
+		r.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:pass1")))
+
+		res := a.ValidateRequest(r)
+		require.True(t, res.IsValid)
+		require.Equal(t, auth.SourceBasic, res.Source)
+	})
+	t.Run("unknownUser", func(t *testing.T) {
+		a, _ := newProxyTestAuth(t, false)
+		require.False(t, a.ValidateRequest(newRequest("10.0.0.1:1", "new", "")).IsValid)
+		require.Len(t, a.accounts, 2)
+	})
+	t.Run("autoProvision", func(t *testing.T) {
+		a, entries := newProxyTestAuth(t, true)
+		res := a.ValidateRequest(newRequest("10.0.0.1:1", "new", ""))
+		require.True(t, res.IsValid)
+		require.False(t, res.User.IsAdmin)
+		require.Equal(t, "new", res.User.Username)
+		require.NotEmpty(t, res.User.Token)
+		require.Len(t, a.accounts, 3)
+
+		// Provisioned users cannot login with basic auth.
+		require.Empty(t, a.accounts[res.User.ID].Password)
+
+		saved, err := os.ReadFile(a.path)
+		require.NoError(t, err)
+		require.Contains(t, string(saved), `"username": "new"`)
+
+		require.Len(t, *entries, 1)
+		require.Equal(t, audit.ActionAccountCreate, (*entries)[0].Action)
+		require.Equal(t, auth.SourceProxy, (*entries)[0].Auth)
+
+		// Second request uses the same account.
+		res2 := a.ValidateRequest(newRequest("10.0.0.1:1", "new", ""))
+		require.Equal(t, res.User.ID, res2.User.ID)
+		require.Len(t, a.accounts, 3)
+	})
+}
+
+func TestSessions(t *testing.T) {
+	basicAuth := func(username, password string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	}
+	// serve returns the response and the session cookie if one was set.
+	serve := func(a *Authenticator, auth string, cookie *http.Cookie) (int, *http.Cookie) {
+		r := httptest.NewRequest(http.MethodGet, "/live", nil)
+		r.Header.Set("Authorization", auth)
+		r.Header.Set("Accept", "text/html")
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		a.User(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(w, r)
+
+		cookies := w.Result().Cookies()
+		if len(cookies) == 0 {
+			return w.Code, nil
+		}
+		return w.Code, cookies[0]
+	}
+	login := func(t *testing.T, a *Authenticator, auth string) *http.Cookie {
+		t.Helper()
+		code, cookie := serve(a, auth, nil)
+		require.Equal(t, http.StatusOK, code)
+		require.NotNil(t, cookie)
+		return cookie
+	}
+	sessionID := func(a *Authenticator, auth string, cookie *http.Cookie) string {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", auth)
+		r.AddCookie(cookie)
+		return a.ValidateRequest(r).SessionID
+	}
+
+	// Rejected requests are logged.
+	newSessionTestAuth := func(t *testing.T) *Authenticator {
+		_, a, cancel := newTestAuth(t)
+		t.Cleanup(cancel)
+		ctx, cancel2 := context.WithCancel(context.Background())
+		t.Cleanup(cancel2)
+		a.logger = log.NewLogger(&sync.WaitGroup{}, nil)
+		require.NoError(t, a.logger.Start(ctx))
+		return a
+	}
+
+	t.Run("create", func(t *testing.T) {
+		a := newSessionTestAuth(t)
+		admin := basicAuth("admin", "pass1")
+
+		cookie := login(t, a, admin)
+		require.Equal(t, auth.SessionCookie, cookie.Name)
+		require.True(t, cookie.HttpOnly)
+
+		// No new session is created if the browser has one.
+		code, newCookie := serve(a, admin, cookie)
+		require.Equal(t, http.StatusOK, code)
+		require.Nil(t, newCookie)
+		require.Len(t, a.SessionsList("1"), 1)
+		require.NotEmpty(t, sessionID(a, admin, cookie))
+
+		// The session can't be used by other accounts.
+		code, _ = serve(a, basicAuth("user", "pass2"), cookie)
+		require.Equal(t, http.StatusUnauthorized, code)
+
+		// API requests don't create sessions.
+		r := httptest.NewRequest(http.MethodGet, "/api/x", nil)
+		r.Header.Set("Authorization", admin)
+		w := httptest.NewRecorder()
+		a.User(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Empty(t, w.Result().Cookies())
+	})
+	t.Run("revoke", func(t *testing.T) {
+		a := newSessionTestAuth(t)
+		admin := basicAuth("admin", "pass1")
+
+		cookie := login(t, a, admin)
+		id := sessionID(a, admin, cookie)
+		require.NoError(t, a.SessionRevoke(id, "1"))
+
+		// The revocation takes effect on the next request
+		// even if the credentials are valid.
+		code, cleared := serve(a, admin, cookie)
+		require.Equal(t, http.StatusUnauthorized, code)
+		require.Equal(t, -1, cleared.MaxAge)
+
+		// Only the revoked token is rejected.
+		code, _ = serve(a, admin, nil)
+		require.Equal(t, http.StatusOK, code)
+
+		// A new session is created after the next login.
+		cookie2 := login(t, a, admin)
+		require.NotEqual(t, cookie.Value, cookie2.Value)
+		code, _ = serve(a, admin, cookie2)
+		require.Equal(t, http.StatusOK, code)
+	})
+	t.Run("revokeAll", func(t *testing.T) {
+		a := newSessionTestAuth(t)
+		admin := basicAuth("admin", "pass1")
+
+		cookie1 := login(t, a, admin)
+		cookie2 := login(t, a, admin)
+		userCookie := login(t, a, basicAuth("user", "pass2"))
+
+		require.NoError(t, a.SessionsRevokeAll("1", sessionID(a, admin, cookie1)))
+		code, _ := serve(a, admin, cookie1)
+		require.Equal(t, http.StatusOK, code)
+		code, _ = serve(a, admin, cookie2)
+		require.Equal(t, http.StatusUnauthorized, code)
+		code, _ = serve(a, basicAuth("user", "pass2"), userCookie)
+		require.Equal(t, http.StatusOK, code)
+	})
+	t.Run("passwordChange", func(t *testing.T) {
+		a := newSessionTestAuth(t)
+		user := basicAuth("user", "pass2")
+
+		current := login(t, a, user)
+		other := login(t, a, user)
+		admin := login(t, a, basicAuth("admin", "pass1"))
+
+		// Changing the username doesn't revoke any sessions.
+		err := a.UserSet(auth.SetUserRequest{ID: "2", Username: "user"})
+		require.NoError(t, err)
+		require.Len(t, a.SessionsList("2"), 2)
+
+		err = a.UserSet(auth.SetUserRequest{
+			ID:            "2",
+			Username:      "user",
+			PlainPassword: "new",
+			KeepSessionID: sessionID(a, user, current),
+		})
+		require.NoError(t, err)
+
+		newUser := basicAuth("user", "new")
+		code, _ := serve(a, newUser, current)
+		require.Equal(t, http.StatusOK, code)
+		code, _ = serve(a, newUser, other)
+		require.Equal(t, http.StatusUnauthorized, code)
+
+		// Sessions of other accounts are unaffected.
+		code, _ = serve(a, basicAuth("admin", "pass1"), admin)
+		require.Equal(t, http.StatusOK, code)
+	})
+	t.Run("userDelete", func(t *testing.T) {
+		a := newSessionTestAuth(t)
+
+		login(t, a, basicAuth("user", "pass2"))
+		require.NoError(t, a.UserDelete("2"))
+		require.Empty(t, a.SessionsList(""))
+	})
+	t.Run("logout", func(t *testing.T) {
+		a := newSessionTestAuth(t)
+		cookie := login(t, a, basicAuth("admin", "pass1"))
+
+		r := httptest.NewRequest(http.MethodGet, "/logout", nil)
+		r.Header.Set("Authorization", "Basic Og==")
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		a.Logout().ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Empty(t, a.SessionsList(""))
+		require.Equal(t, -1, w.Result().Cookies()[0].MaxAge)
+	})
+}
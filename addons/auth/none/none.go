@@ -8,15 +8,15 @@ import (
 	"fmt"
 	"net/http"
 	"nvr"
+	"nvr/pkg/audit"
 	"nvr/pkg/log"
 	"nvr/pkg/storage"
+	"nvr/pkg/web/api"
 	"nvr/pkg/web/auth"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sync"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 func init() {
@@ -36,7 +36,7 @@ func init() {
 type Authenticator struct {
 	path     string // Path to save user information.
 	accounts map[string]auth.Account
-	hashCost int
+	hasher   auth.PasswordHasher
 
 	token string
 	mu    sync.Mutex
@@ -44,12 +44,12 @@ type Authenticator struct {
 
 // NewAuthenticator creates a authenticator similar to
 // basic.Authenticator but it allows all requests.
-func NewAuthenticator(env storage.ConfigEnv, _ *log.Logger) (auth.Authenticator, error) {
+func NewAuthenticator(env storage.ConfigEnv, _ *log.Logger, _ audit.Func) (auth.Authenticator, error) {
 	path := filepath.Join(env.ConfigDir, "users.json")
 	a := Authenticator{
 		path:     path,
 		accounts: make(map[string]auth.Account),
-		hashCost: auth.DefaultBcryptHashCost,
+		hasher:   auth.NewPasswordHasher(env.PasswordHash),
 
 		token: auth.GenToken(),
 	}
@@ -138,7 +138,7 @@ func (a *Authenticator) UserSet(req auth.SetUserRequest) error {
 	user.Username = req.Username
 	user.IsAdmin = req.IsAdmin
 	if req.PlainPassword != "" {
-		hashedNewPassword, _ := bcrypt.GenerateFromPassword([]byte(req.PlainPassword), a.hashCost)
+		hashedNewPassword, _ := a.hasher.Hash(req.PlainPassword)
 		user.Password = hashedNewPassword
 	}
 
@@ -168,27 +168,37 @@ func (a *Authenticator) UserDelete(id string) error {
 	return nil
 }
 
-// User allows all requests.
+// SessionsList returns a empty list, sessions aren't tracked.
+func (a *Authenticator) SessionsList(string) []auth.Session {
+	return []auth.Session{}
+}
+
+// SessionRevoke returns auth.ErrSessionNotExist.
+func (a *Authenticator) SessionRevoke(string, string) error {
+	return auth.ErrSessionNotExist
+}
+
+// SessionsRevokeAll does nothing.
+func (a *Authenticator) SessionsRevokeAll(string, string) error {
+	return nil
+}
+
+// User allows all requests with a valid CSRF-token.
 func (a *Authenticator) User(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		next.ServeHTTP(w, r)
-	})
+	return a.CSRF(next)
 }
 
-// Admin allows all requests.
+// Admin allows all requests with a valid CSRF-token.
 func (a *Authenticator) Admin(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		next.ServeHTTP(w, r)
-	})
+	return a.CSRF(next)
 }
 
 // CSRF blocks invalid Cross-site request forgery tokens.
 // The request needs to have the token in the "X-CSRF-TOKEN" header.
 func (a *Authenticator) CSRF(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("X-CSRF-TOKEN")
-		if token != a.token {
-			http.Error(w, "Invalid CSRF-token.", http.StatusUnauthorized)
+		if !auth.ValidCSRF(r, a.token) {
+			api.Forbidden(w, "invalid CSRF-token")
 			return
 		}
 
@@ -8,110 +8,255 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	stdlog "log"
 	"net/http"
 	"nvr"
 	"nvr/pkg/log"
+	"nvr/pkg/metrics"
 	"nvr/pkg/storage"
+	"nvr/pkg/web/api"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+var nvrAddon = nvr.RegisterAddon("doods", "Object detection using DOODS")
+
 var addon = struct {
-	doodsIP      string
-	detectorList detectors
+	config       Config
+	detectors    *detectorStore
 	previewCache *previewCache
 
-	sendRequest sendRequestFunc
+	router *serverRouter
+
+	// The addon runs in a degraded state if the config is invalid.
+	configErr error
+
+	// The detectors couldn't be fetched at startup.
+	fetchPending bool
 
 	logger *log.Logger
 }{}
 
+// Metrics.
+var (
+	detectionsTotal = metrics.NewCounterVec(
+		"nvr_doods_detections_total",
+		"Number of frames with detections that triggered an event.",
+		metrics.LabelMonitorID,
+	)
+	requestDuration = metrics.NewHistogramVec(
+		"nvr_doods_request_duration_seconds",
+		"Duration of the detection requests.",
+		[]float64{0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+		metrics.LabelMonitorID,
+	)
+)
+
 func init() {
 	nvr.RegisterLogSource([]string{"doods"})
 	addon.previewCache = newPreviewCache()
 
-	nvr.RegisterAppRunHook(func(ctx context.Context, app *nvr.App) error {
+	nvrAddon.RegisterAppRunHook(func(ctx context.Context, app *nvr.App) error {
 		addon.logger = app.Logger
 		onEnv(app.Env)
-		app.Router.Handle("/doods.mjs", app.Auth.Admin(serveDoodsMjs()))
 		app.Router.Handle("/api/doods/preview/", app.Auth.Admin(addon.previewCache))
+		app.Router.Handle("/api/doods/status/", app.Auth.Admin(addon.previewCache.statusHandler()))
+		app.Router.Handle(
+			"/api/recording/annotated/",
+			app.Auth.User(handleAnnotated(app.Env.RecordingsDirs())),
+		)
 		onAppRun(ctx, app.WG)
+		app.RegisterReadinessCheck("doods", health)
 		return nil
 	})
-	nvr.RegisterTplHook(modifyTemplates)
+	nvrAddon.RegisterTplHook(modifyTemplates)
+	nvrAddon.RegisterTplDataHook(setTemplateData)
+	nvrAddon.RegisterStaticFS("/static/doods/", staticFS())
+	nvrAddon.RegisterMetrics(func(r *metrics.Registry) error {
+		if err := r.Register(detectionsTotal); err != nil {
+			return err
+		}
+		return r.Register(requestDuration)
+	})
 }
 
+// The fetch is retried in the background if DOODS is unreachable at startup.
+const fetchDetectorsRetryInterval = 3 * time.Second
+
+// onEnv never blocks the startup. If the servers can't be reached,
+// the cached detector list is used until the detectors are fetched.
 func onEnv(env storage.ConfigEnv) {
+	logf := newLogFunc()
+	addon.detectors = newDetectorStore(filepath.Join(env.ConfigDir, "doods-detectors.json"))
+	addon.fetchPending = false
+
 	configPath := env.ConfigDir + "/doods.json"
-	var err error
-	addon.doodsIP, err = readConfig(configPath)
+	config, err := readConfig(configPath)
 	if err != nil {
-		stdlog.Fatalf("doods: config: %v, %v\n", err, configPath)
+		addon.configErr = fmt.Errorf("%w, %v", err, configPath)
+		addon.router = newServerRouter(nil, logf)
+		logf(log.LevelError, "config: %v, object detection is disabled", addon.configErr)
 		return
 	}
+	addon.config = config
+	addon.configErr = nil
 
-	for {
-		addon.detectorList, err = newFetcher(addon.doodsIP).fetchDetectors()
-		if err != nil {
-			fmt.Printf("doods: could not fetch detectors: %v %v\n"+
-				"it can sometimes take a minute for doods to start\n"+
-				"retrying..\n", addon.doodsIP, err)
-			time.Sleep(3 * time.Second)
-			continue
-		}
-		fmt.Printf("doods: found %d detectors:\n", len(addon.detectorList))
-		for _, detector := range addon.detectorList {
-			fmt.Printf("  %v\n", detector.Name)
+	serverConfigs, _ := addon.config.servers()
+	servers := make([]*doodsServer, 0, len(serverConfigs))
+	for _, c := range serverConfigs {
+		servers = append(servers, newDoodsServer(c, addon.config.Transport))
+	}
+	addon.router = newServerRouter(servers, logf)
+
+	list, err := addon.router.fetchDetectors()
+	if err != nil {
+		addon.fetchPending = true
+		if err := addon.detectors.loadCache(); err != nil {
+			logf(log.LevelError, "could not load cached detectors: %v", err)
 		}
+		logf(log.LevelWarning, "could not fetch detectors: %v, "+
+			"starting with %d cached detectors, retrying in the background",
+			err, len(addon.detectors.get()))
 		return
 	}
+
+	fmt.Printf("doods: found %d detectors:\n", len(list))
+	for _, detector := range list {
+		fmt.Printf("  %v\n", detector.Name)
+	}
+	if err := addon.detectors.set(list); err != nil {
+		logf(log.LevelError, "could not cache detectors: %v", err)
+	}
 }
 
-func onAppRun(ctx context.Context, wg *sync.WaitGroup) {
-	logf := func(level log.Level, format string, a ...interface{}) {
+// health is the readiness check of the addon.
+func health() error {
+	if addon.configErr != nil {
+		return fmt.Errorf("config: %w", addon.configErr)
+	}
+	return addon.router.health()
+}
+
+// newDoodsServer the websocket client is created by onAppRun.
+func newDoodsServer(c ServerConfig, transport string) *doodsServer {
+	s := &doodsServer{
+		name:     c.Name,
+		ip:       c.IP,
+		priority: c.Priority,
+	}
+	if transport == transportGRPC {
+		client := newGRPCClient(serverLogFunc(c.Name), c.IP)
+		s.sendRequest = client.sendRequest
+		s.fetchDetectors = client.fetchDetectors
+		s.connected = func() bool { return !client.waitingToRetry() }
+		return s
+	}
+	s.fetchDetectors = newFetcher(c.IP).fetchDetectors
+	return s
+}
+
+func newLogFunc() log.Func {
+	return func(level log.Level, format string, a ...interface{}) {
+		msg, fields := log.Sprintf(format, a...)
 		addon.logger.Log(log.Entry{
-			Level: level,
-			Src:   "doods",
-			Msg:   fmt.Sprintf(format, a...),
+			Level:  level,
+			Src:    "doods",
+			Msg:    msg,
+			Fields: fields,
 		})
 	}
+}
+
+// serverLogFunc adds the server name to the log fields.
+func serverLogFunc(name string) log.Func {
+	logf := newLogFunc()
+	return func(level log.Level, format string, a ...interface{}) {
+		logf(level, format, append(a, log.F("server", name))...)
+	}
+}
+
+func onAppRun(ctx context.Context, wg *sync.WaitGroup) {
+	for _, s := range addon.router.servers {
+		if s.sendRequest != nil {
+			continue
+		}
+		client := newClient(ctx, wg, serverLogFunc(s.name), s.ip, addon.config.BinaryFrames)
+		s.sendRequest = client.sendRequest
+		s.connected = client.isConnected
 
-	client := newClient(ctx, wg, logf, addon.doodsIP)
-	addon.sendRequest = client.sendRequest
+		wg.Add(1)
+		go client.start()
+	}
 
 	wg.Add(1)
-	go client.start()
+	go addon.router.refreshLoop(ctx, wg)
+
+	if addon.fetchPending {
+		wg.Add(1)
+		go fetchDetectorsLoop(ctx, wg, addon.router, addon.detectors, fetchDetectorsRetryInterval)
+	}
 }
 
 // Config doods global configuration.
 type Config struct {
-	IP string `json:"ip"`
+	// IP of the server if Servers is empty.
+	IP string `json:"ip,omitempty"`
+
+	// Servers the first server is the default.
+	Servers []ServerConfig `json:"servers,omitempty"`
+
+	// Transport "websocket" or "grpc", defaults to websocket.
+	Transport string `json:"transport,omitempty"`
+
+	// BinaryFrames send the images as binary websocket messages
+	// instead of base64 in the JSON requests.
+	BinaryFrames bool `json:"binaryFrames,omitempty"`
 }
 
-func readConfig(configPath string) (string, error) {
+// Transports.
+const (
+	transportWebsocket = "websocket"
+	transportGRPC      = "grpc"
+)
+
+var errInvalidTransport = errors.New("invalid transport")
+
+func readConfig(configPath string) (Config, error) {
 	if !dirExist(configPath) {
 		if err := genConfig(configPath); err != nil {
-			return "", fmt.Errorf("generate config: %w", err)
+			return Config{}, fmt.Errorf("generate config: %w", err)
 		}
 	}
 
 	file, err := os.ReadFile(configPath)
 	if err != nil {
-		return "", fmt.Errorf("read config: %w", err)
+		return Config{}, fmt.Errorf("read config: %w", err)
 	}
 
 	var config Config
 	if err := json.Unmarshal(file, &config); err != nil {
-		return "", fmt.Errorf("unmarshal config: %w", err)
+		return Config{}, fmt.Errorf("unmarshal config: %w", err)
+	}
+
+	switch config.Transport {
+	case "":
+		config.Transport = transportWebsocket
+	case transportWebsocket, transportGRPC:
+	default:
+		return Config{}, fmt.Errorf("%w: %q", errInvalidTransport, config.Transport)
+	}
+
+	if _, err := config.servers(); err != nil {
+		return Config{}, err
 	}
 
-	return config.IP, nil
+	return config, nil
 }
 
 var defaultConfig = Config{
@@ -168,15 +313,6 @@ type getDetectorsResponce struct {
 	Detectors detectors `json:"detectors"`
 }
 
-func detectorByName(name string) (detector, error) {
-	for _, detector := range addon.detectorList {
-		if detector.Name == name {
-			return detector, nil
-		}
-	}
-	return detector{}, fmt.Errorf("%v: %w", name, os.ErrNotExist)
-}
-
 type detectors []detector
 
 type detector struct {
@@ -192,6 +328,9 @@ type detectRequest struct {
 	ID           string  `json:"id"`
 	DetectorName string  `json:"detector_name"`
 	Data         *[]byte `json:"data"`
+
+	// The data is sent in the next binary message.
+	BinaryData bool `json:"binary_data,omitempty"`
 	// Preprocess   []string   `json:"preprocess"`
 	Detect thresholds `json:"detect"`
 }
@@ -230,6 +369,13 @@ type client struct {
 	pendingRequests map[string]chan detectResponse
 	requestChan     chan clientRequest
 	responseChan    chan detectResponse
+
+	connected atomic.Bool
+
+	// The client falls back to base64 for the rest
+	// of its lifetime if the server rejects them.
+	binaryFrames   bool
+	binaryRejected atomic.Bool
 }
 
 func newClient(
@@ -237,15 +383,17 @@ func newClient(
 	wg *sync.WaitGroup,
 	logf log.Func,
 	doodsIP string,
+	binaryFrames bool,
 ) *client {
 	return &client{
-		wg:         wg,
-		ctx:        ctx,
-		logf:       logf,
-		url:        "ws://" + doodsIP + "/detect",
-		warmup:     1 * time.Second,
-		timeout:    1000 * time.Millisecond,
-		retrySleep: 3 * time.Second,
+		wg:           wg,
+		ctx:          ctx,
+		logf:         logf,
+		url:          "ws://" + doodsIP + "/detect",
+		warmup:       1 * time.Second,
+		timeout:      1000 * time.Millisecond,
+		retrySleep:   3 * time.Second,
+		binaryFrames: binaryFrames,
 
 		pendingRequests: make(map[string]chan detectResponse),
 		requestChan:     make(chan clientRequest),
@@ -260,8 +408,12 @@ func (c *client) start() {
 	defer c.wg.Done()
 	for {
 		err := c.run()
+		if errors.Is(err, errBinaryRejected) && c.ctx.Err() == nil {
+			c.logf(log.LevelWarning, "server rejected binary frames, falling back to base64")
+			continue
+		}
 		if err != nil {
-			c.logf(log.LevelError, "client crashed: %v", err)
+			c.logf(log.LevelError, "client crashed", log.F("url", c.url), log.Err(err))
 		} else {
 			c.logf(log.LevelInfo, "client stopped")
 		}
@@ -278,16 +430,24 @@ func (c *client) run() error {
 	dialCtx, cancel2 := context.WithTimeout(c.ctx, c.timeout)
 	defer cancel2()
 
-	conn, _, err := websocket.DefaultDialer.DialContext(dialCtx, c.url, nil) //nolint:bodyclose
+	// Permessage-deflate is used if the server supports it.
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = true
+
+	conn, _, err := dialer.DialContext(dialCtx, c.url, nil) //nolint:bodyclose
 	if err != nil {
 		return fmt.Errorf("connect: %v %w", c.url, err)
 	}
+	c.connected.Store(true)
+	defer c.connected.Store(false)
+
 	go c.startReader(conn)
 
-	cleanup := func() {
+	cleanup := func(err error) {
 		conn.Close()
-		for _, ret := range c.pendingRequests {
-			ret <- detectResponse{err: context.Canceled}
+		for id, ret := range c.pendingRequests {
+			ret <- detectResponse{err: err}
+			delete(c.pendingRequests, id)
 		}
 	}
 
@@ -297,18 +457,30 @@ func (c *client) run() error {
 		case r := <-c.requestChan:
 			count++
 			r.request.ID = strconv.Itoa(count)
+			c.pendingRequests[r.request.ID] = r.response
 
-			if err := conn.WriteJSON(r.request); err != nil {
-				cleanup()
-				<-c.responseChan
+			// Requests from other monitors wait until both
+			// messages of a binary request are written.
+			if err := c.writeRequest(conn, r.request); err != nil {
+				conn.Close()
+				readErr := (<-c.responseChan).err
+				if c.isBinaryRejection(readErr) {
+					c.binaryRejected.Store(true)
+					cleanup(errBinaryRejected)
+					return errBinaryRejected
+				}
+				cleanup(context.Canceled)
 				return err
 			}
-			c.pendingRequests[r.request.ID] = r.response
-			break
 
 		case response := <-c.responseChan:
+			if c.isBinaryRejection(response.err) {
+				c.binaryRejected.Store(true)
+				cleanup(errBinaryRejected)
+				return errBinaryRejected
+			}
 			if response.err != nil {
-				cleanup()
+				cleanup(context.Canceled)
 				return fmt.Errorf("read json: %w", response.err)
 			}
 
@@ -324,13 +496,51 @@ func (c *client) run() error {
 			delete(c.pendingRequests, response.ID)
 
 		case <-c.ctx.Done():
-			cleanup()
+			cleanup(context.Canceled)
 			<-c.responseChan
 			return nil
 		}
 	}
 }
 
+var errBinaryRejected = errors.New("server rejected binary frames")
+
+// isBinaryRejection returns true if the server closed the connection
+// because it doesn't support binary messages.
+func (c *client) isBinaryRejection(err error) bool {
+	return c.binaryFrames &&
+		!c.binaryRejected.Load() &&
+		websocket.IsCloseError(err, websocket.CloseUnsupportedData)
+}
+
+// writeRequest writes the request, followed by the image as a binary
+// message if binary frames are enabled. Only called by run.
+func (c *client) writeRequest(conn *websocket.Conn, request detectRequest) error {
+	if !c.binaryFrames || c.binaryRejected.Load() || request.Data == nil {
+		return conn.WriteJSON(request)
+	}
+
+	data := *request.Data
+	request.Data = nil
+	request.BinaryData = true
+	if err := conn.WriteJSON(request); err != nil {
+		return err
+	}
+
+	// The images are already compressed.
+	conn.EnableWriteCompression(false)
+	defer conn.EnableWriteCompression(true)
+	return conn.WriteMessage(websocket.BinaryMessage, binaryFrame(request.ID, data))
+}
+
+// binaryFrame the request ID followed by a null byte and the image.
+func binaryFrame(id string, data []byte) []byte {
+	frame := make([]byte, 0, len(id)+1+len(data))
+	frame = append(frame, id...)
+	frame = append(frame, 0)
+	return append(frame, data...)
+}
+
 func (c *client) startReader(conn *websocket.Conn) {
 	var response detectResponse
 	for {
@@ -343,11 +553,28 @@ func (c *client) startReader(conn *websocket.Conn) {
 	}
 }
 
+// isConnected returns true while the websocket connection is up.
+func (c *client) isConnected() bool {
+	return c.connected.Load()
+}
+
 type sendRequestFunc func(context.Context, detectRequest) (*detections, error)
 
 var errDoods = errors.New("doods error")
 
 func (c *client) sendRequest(ctx context.Context, request detectRequest) (*detections, error) {
+	for {
+		detections, err := c.sendRequestOnce(ctx, request)
+
+		// Sent again in base64 after reconnecting.
+		if errors.Is(err, errBinaryRejected) {
+			continue
+		}
+		return detections, err
+	}
+}
+
+func (c *client) sendRequestOnce(ctx context.Context, request detectRequest) (*detections, error) {
 	res := make(chan detectResponse)
 	req := clientRequest{
 		request:  request,
@@ -394,11 +621,16 @@ func dirExist(path string) bool {
 
 type previewCache struct {
 	monitors map[string][]byte
+	status   map[string]detectorStatus
 	mu       *sync.Mutex
 }
 
 func newPreviewCache() *previewCache {
-	return &previewCache{monitors: make(map[string][]byte), mu: &sync.Mutex{}}
+	return &previewCache{
+		monitors: make(map[string][]byte),
+		status:   make(map[string]detectorStatus),
+		mu:       &sync.Mutex{},
+	}
 }
 
 func (cache *previewCache) Set(monitorID string, buf []byte) {
@@ -408,6 +640,29 @@ func (cache *previewCache) Set(monitorID string, buf []byte) {
 	cache.monitors[monitorID] = buf
 }
 
+// SetStatus sets the detector status of the monitor.
+func (cache *previewCache) SetStatus(monitorID string, status detectorStatus) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.status[monitorID] = status
+}
+
+// delete removes the preview and status of the monitor.
+func (cache *previewCache) delete(monitorID string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	delete(cache.monitors, monitorID)
+	delete(cache.status, monitorID)
+}
+
+// detectorStatus is the status of the detector served to the user.
+type detectorStatus struct {
+	throttleStatus
+	changeStatus
+}
+
 // ServeHTTP Implements http.Handler.
 func (cache *previewCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	cache.mu.Lock()
@@ -417,12 +672,33 @@ func (cache *previewCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	buf, exist := cache.monitors[monitorID]
 	if !exist {
-		http.Error(w, "", http.StatusNotFound)
+		api.NotFound(w, "no preview for monitor")
 		return
 	}
 
-	_, err := w.Write(buf)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(buf) //nolint:errcheck
+}
+
+// statusHandler serves the effective feed rate, detector
+// latency and frame statistics of the monitor.
+func (cache *previewCache) statusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+
+		monitorID := strings.TrimPrefix(r.URL.Path, "/api/doods/status/")
+
+		status, exist := cache.status[monitorID]
+		if !exist {
+			api.NotFound(w, "no status for monitor")
+			return
+		}
+		api.WriteJSON(w, r, status)
+	})
 }
@@ -3,15 +3,20 @@
 package doods
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -44,9 +49,48 @@ func TestReadConfig(t *testing.T) {
 		err := os.WriteFile(configPath, []byte(file), 0o600)
 		require.NoError(t, err)
 
-		ip, err := readConfig(configPath)
+		config, err := readConfig(configPath)
 		require.NoError(t, err)
-		require.Equal(t, ip, "test:8080")
+		require.Equal(t, Config{IP: "test:8080", Transport: "websocket"}, config)
+	})
+	t.Run("grpc", func(t *testing.T) {
+		configPath, cancel := newTestConfig(t)
+		defer cancel()
+
+		file := `{ "ip": "test:8080", "transport": "grpc" }`
+
+		err := os.WriteFile(configPath, []byte(file), 0o600)
+		require.NoError(t, err)
+
+		config, err := readConfig(configPath)
+		require.NoError(t, err)
+		require.Equal(t, Config{IP: "test:8080", Transport: "grpc"}, config)
+	})
+	t.Run("binaryFrames", func(t *testing.T) {
+		configPath, cancel := newTestConfig(t)
+		defer cancel()
+
+		file := `{ "ip": "test:8080", "binaryFrames": true }`
+
+		err := os.WriteFile(configPath, []byte(file), 0o600)
+		require.NoError(t, err)
+
+		config, err := readConfig(configPath)
+		require.NoError(t, err)
+		expected := Config{IP: "test:8080", Transport: "websocket", BinaryFrames: true}
+		require.Equal(t, expected, config)
+	})
+	t.Run("transportErr", func(t *testing.T) {
+		configPath, cancel := newTestConfig(t)
+		defer cancel()
+
+		file := `{ "ip": "test:8080", "transport": "nil" }`
+
+		err := os.WriteFile(configPath, []byte(file), 0o600)
+		require.NoError(t, err)
+
+		_, err = readConfig(configPath)
+		require.ErrorIs(t, err, errInvalidTransport)
 	})
 	t.Run("genFile", func(t *testing.T) {
 		configPath, cancel := newTestConfig(t)
@@ -139,22 +183,6 @@ func TestFetchDetectors(t *testing.T) {
 	})
 }
 
-func TestDetectorByName(t *testing.T) {
-	t.Run("ok", func(t *testing.T) {
-		addon.detectorList = testDetectors
-
-		detector, err := detectorByName("1")
-		require.NoError(t, err)
-		require.Equal(t, detector, testDetectors[0])
-	})
-	t.Run("existError", func(t *testing.T) {
-		addon.detectorList = testDetectors
-
-		_, err := detectorByName("nil")
-		require.ErrorIs(t, err, os.ErrNotExist)
-	})
-}
-
 func logf(log.Level, string, ...interface{}) {}
 
 func TestClient(t *testing.T) {
@@ -285,6 +313,158 @@ func TestSendRequest(t *testing.T) {
 	})
 }
 
+// countingListener counts the bytes read from the accepted connections.
+type countingListener struct {
+	net.Listener
+	n *atomic.Int64
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{Conn: conn, n: l.n}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	n *atomic.Int64
+}
+
+func (c countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// newCountingServer returns a DOODS server that counts the bytes it
+// receives. The label of the detection is the size of the image. The
+// connection is closed with CloseUnsupportedData on binary messages
+// if rejectBinary is set.
+func newCountingServer(
+	t *testing.T,
+	upgrader *websocket.Upgrader,
+	rejectBinary bool,
+) (string, *atomic.Int64) {
+	detect := func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		go func() {
+			defer conn.Close()
+			for {
+				_, msg, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				var request detectRequest
+				if err := json.Unmarshal(msg, &request); err != nil {
+					return
+				}
+
+				var image []byte
+				if request.Data != nil {
+					image = *request.Data
+				}
+				if request.BinaryData {
+					_, frame, err := conn.ReadMessage()
+					if err != nil {
+						return
+					}
+					if rejectBinary {
+						msg := websocket.FormatCloseMessage(websocket.CloseUnsupportedData, "")
+						conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)) //nolint:errcheck
+						return
+					}
+					id, data, _ := bytes.Cut(frame, []byte{0})
+					if string(id) != request.ID {
+						return
+					}
+					image = data
+				}
+
+				response := detectResponse{
+					ID:         request.ID,
+					Detections: detections{{Label: strconv.Itoa(len(image))}},
+				}
+				if err := conn.WriteJSON(response); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	received := &atomic.Int64{}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(detect))
+	server.Listener = countingListener{Listener: server.Listener, n: received}
+	server.Start()
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://"), received
+}
+
+func TestClientBinaryFrames(t *testing.T) {
+	// sendImages sends the image 10 times and
+	// returns the number of bytes the server received.
+	sendImages := func(
+		t *testing.T,
+		upgrader *websocket.Upgrader,
+		rejectBinary bool,
+		binaryFrames bool,
+		image []byte,
+	) (int64, *client) {
+		t.Helper()
+		ip, received := newCountingServer(t, upgrader, rejectBinary)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		wg := &sync.WaitGroup{}
+		defer func() {
+			cancel()
+			wg.Wait()
+		}()
+		c := newClient(ctx, wg, logf, ip, binaryFrames)
+		c.warmup = 0
+		c.retrySleep = 10 * time.Millisecond
+		wg.Add(1)
+		go c.start()
+
+		for i := 0; i < 10; i++ {
+			ctx2, cancel2 := context.WithTimeout(ctx, 5*time.Second)
+			detections, err := c.sendRequest(ctx2, detectRequest{Data: &image})
+			cancel2()
+			require.NoError(t, err)
+			require.Equal(t, strconv.Itoa(len(image)), (*detections)[0].Label)
+		}
+		return received.Load(), c
+	}
+
+	// Compressed images.
+	image := make([]byte, 30000)
+	_, err := rand.New(rand.NewSource(1)).Read(image)
+	require.NoError(t, err)
+
+	t.Run("bandwidth", func(t *testing.T) {
+		base64Bytes, _ := sendImages(t, &websocket.Upgrader{}, false, false, image)
+		binaryBytes, _ := sendImages(t, &websocket.Upgrader{}, false, true, image)
+		require.Greater(t, base64Bytes, int64(10*40000))
+		require.Less(t, binaryBytes, int64(10*30500))
+		require.Less(t, binaryBytes*100/base64Bytes, int64(80))
+	})
+	t.Run("compression", func(t *testing.T) {
+		upgrader := &websocket.Upgrader{EnableCompression: true}
+		received, _ := sendImages(t, upgrader, false, false, make([]byte, 30000))
+		require.Less(t, received, int64(10*1000))
+	})
+	t.Run("fallback", func(t *testing.T) {
+		base64Bytes, _ := sendImages(t, &websocket.Upgrader{}, false, false, image)
+		received, c := sendImages(t, &websocket.Upgrader{}, true, true, image)
+		require.True(t, c.binaryRejected.Load())
+
+		// The rejected image and the requests in base64.
+		require.Greater(t, received, base64Bytes)
+		require.Less(t, received, base64Bytes+40000)
+	})
+}
+
 type cancelFunc func()
 
 type testServer struct {
@@ -0,0 +1,363 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package doods
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"nvr/pkg/video/customformat"
+	"nvr/pkg/video/gortsplib/pkg/h264"
+	"nvr/pkg/web/api"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	nvrAddon.RegisterMonitorRecSavedHook(onRecSaved)
+}
+
+// Number of annotated videos that are generated at the same time.
+const annotateWorkers = 1
+
+var annotations = newAnnotateJobs(annotateWorkers)
+
+// annotateJobs limits the number of concurrent transcodes, recordings
+// that are saved at the same time are queued instead of stampeding.
+type annotateJobs struct {
+	workers chan struct{}
+}
+
+func newAnnotateJobs(workers int) *annotateJobs {
+	return &annotateJobs{workers: make(chan struct{}, workers)}
+}
+
+// run blocks until a worker is free and the video is generated.
+func (j *annotateJobs) run(generate func() error) error {
+	j.workers <- struct{}{}
+	defer func() { <-j.workers }()
+	return generate()
+}
+
+func onRecSaved(r *monitor.Recorder, recPath string, recData storage.RecordingData) {
+	config, enable, err := parseConfig(r.Config)
+	if err != nil || !enable || !config.annotate {
+		return
+	}
+
+	id := r.Config.ID()
+	logf := func(level log.Level, format string, a ...interface{}) {
+		msg, fields := log.Sprintf(format, a...)
+		r.Logger.Log(log.Entry{
+			Level:     level,
+			Src:       "doods",
+			MonitorID: id,
+			Msg:       msg,
+			Fields:    fields,
+		}.Censor(r.Env.CensorLog))
+	}
+
+	err = annotations.run(func() error {
+		return annotate(r, logf, recPath, recData)
+	})
+	if err != nil {
+		logf(log.LevelError, "could not annotate recording: %v", err)
+	}
+}
+
+// annotate draws the detections of the recording into a sibling
+// video, the original is left untouched. The drawbox and drawtext
+// filters are disabled by default and are toggled by a sendcmd file.
+func annotate(
+	r *monitor.Recorder,
+	logf log.Func,
+	recPath string,
+	recData storage.RecordingData,
+) error {
+	width, height, err := recordingSize(recPath + ".meta")
+	if err != nil {
+		return fmt.Errorf("recording size: %w", err)
+	}
+
+	boxes, slots := genAnnotations(recData, width, height)
+	if len(boxes) == 0 {
+		logf(log.LevelDebug, "annotate: %v: no detections", filepath.Base(recPath))
+		return nil
+	}
+
+	cmdPath := recPath + ".annotated_cmd"
+	if err := os.WriteFile(cmdPath, []byte(genSendCmd(boxes)), 0o600); err != nil {
+		return fmt.Errorf("write sendcmd file: %w", err)
+	}
+	defer os.Remove(cmdPath)
+
+	video, err := storage.NewVideoReader(recPath, nil)
+	if err != nil {
+		return fmt.Errorf("video reader: %w", err)
+	}
+	defer video.Close()
+
+	tempPath := recPath + ".annotated_tmp"
+	annotatedPath := recPath + storage.RecordingVariants["annotated"]
+	args := annotateArgs(r.Config.LogLevel(), cmdPath, tempPath, slots, width, height)
+
+	logf(log.LevelInfo, "annotating: %v", strings.Join(args, " "))
+	cmd := ffmpeg.LowPriority(exec.Command(r.Env.FFmpegBin, args...))
+	cmd.Stdin = video
+
+	logFunc := func(msg string) {
+		logf(log.FFmpegLevel(r.Config.LogLevel()), "annotate process: %v", msg)
+	}
+	process := r.NewProcess(cmd).
+		StdoutLogger(logFunc).
+		StderrLogger(logFunc)
+
+	// The transcode runs at a low priority and may be slower than realtime.
+	recDuration := recData.End.Sub(recData.Start)
+	ctx, cancel := context.WithTimeout(context.Background(), 4*recDuration)
+	defer cancel()
+
+	if err := process.Start(ctx); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("generate video: %w %v", err, args)
+	}
+
+	if err := os.Rename(tempPath, annotatedPath); err != nil {
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+	logf(log.LevelInfo, "annotated: %v", filepath.Base(annotatedPath))
+	return nil
+}
+
+// recordingSize returns the video resolution from the recording header.
+func recordingSize(metaPath string) (int, int, error) {
+	meta, err := os.Open(metaPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer meta.Close()
+
+	var header customformat.Header
+	if _, err := header.Unmarshal(meta); err != nil {
+		return 0, 0, fmt.Errorf("unmarshal header: %w", err)
+	}
+
+	var sps h264.SPS
+	if err := sps.Unmarshal(header.VideoSPS); err != nil {
+		return 0, 0, fmt.Errorf("unmarshal sps: %w", err)
+	}
+	return sps.Width(), sps.Height(), nil
+}
+
+// Detections are shown for at least this long, events
+// from a single frame don't have a duration.
+const minAnnotationDuration = time.Second
+
+// annotationBox is a detection drawn from start to end, in seconds
+// from the start of the recording. The coordinates are in pixels.
+type annotationBox struct {
+	start float64
+	end   float64
+	x     int
+	y     int
+	w     int
+	h     int
+	text  string
+
+	// Index of the drawbox and drawtext filter pair.
+	slot int
+}
+
+// genAnnotations returns the boxes of the recording's detections and the
+// number of filter slots. Boxes are assigned the lowest slot that's free,
+// the number of slots is the highest number of simultaneous boxes.
+func genAnnotations(recData storage.RecordingData, width, height int) ([]annotationBox, int) {
+	recDuration := recData.End.Sub(recData.Start).Seconds()
+
+	var boxes []annotationBox
+	for _, e := range recData.Events {
+		duration := e.Duration
+		if duration < minAnnotationDuration {
+			duration = minAnnotationDuration
+		}
+		start := e.Time.Sub(recData.Start).Seconds()
+		end := math.Min(start+duration.Seconds(), recDuration)
+		start = math.Max(start, 0)
+		if end <= start {
+			continue
+		}
+
+		for _, d := range e.Detections {
+			if d.Region == nil || d.Region.Rect == nil {
+				continue
+			}
+			rect := *d.Region.Rect
+			top, left := scaleCoordinate(rect[0], height), scaleCoordinate(rect[1], width)
+			bottom, right := scaleCoordinate(rect[2], height), scaleCoordinate(rect[3], width)
+			if right <= left || bottom <= top {
+				continue
+			}
+			boxes = append(boxes, annotationBox{
+				start: start,
+				end:   end,
+				x:     left,
+				y:     top,
+				w:     right - left,
+				h:     bottom - top,
+				text:  annotationText(d),
+			})
+		}
+	}
+
+	sort.SliceStable(boxes, func(i, j int) bool {
+		return boxes[i].start < boxes[j].start
+	})
+
+	// End time of the last box in each slot.
+	var slotEnds []float64
+	for i, box := range boxes {
+		slot := -1
+		for s, end := range slotEnds {
+			if end <= box.start {
+				slot = s
+				break
+			}
+		}
+		if slot == -1 {
+			slot = len(slotEnds)
+			slotEnds = append(slotEnds, 0)
+		}
+		slotEnds[slot] = box.end
+		boxes[i].slot = slot
+	}
+	return boxes, len(slotEnds)
+}
+
+// scaleCoordinate converts a percentage to pixels, rounded to the
+// nearest pixel. Values outside the frame are clamped to the edge.
+func scaleCoordinate(percent int, size int) int {
+	percent = min(max(percent, 0), 100)
+	return (percent*size + 50) / 100
+}
+
+// annotationText returns the label and score, characters
+// that need escaping in the filter options are dropped.
+func annotationText(d storage.Detection) string {
+	label := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '_' || r == '-' ||
+			('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return -1
+	}, d.Label)
+	return label + " " + strconv.Itoa(int(math.Round(d.Score)))
+}
+
+// genSendCmd returns the sendcmd file. Each box is an interval that
+// moves and enables the filter pair of its slot on enter and
+// disables it on leave. Intervals are ordered by their start time.
+func genSendCmd(boxes []annotationBox) string {
+	var b strings.Builder
+	for _, box := range boxes {
+		drawbox := "drawbox@b" + strconv.Itoa(box.slot)
+		drawtext := "drawtext@t" + strconv.Itoa(box.slot)
+		fmt.Fprintf(&b, "%.3f-%.3f", box.start, box.end)
+		fmt.Fprintf(&b, " [enter] %s x %d,", drawbox, box.x)
+		fmt.Fprintf(&b, " [enter] %s y %d,", drawbox, box.y)
+		fmt.Fprintf(&b, " [enter] %s w %d,", drawbox, box.w)
+		fmt.Fprintf(&b, " [enter] %s h %d,", drawbox, box.h)
+		fmt.Fprintf(&b, " [enter] %s enable 1,", drawbox)
+		fmt.Fprintf(&b, " [enter] %s reinit 'text=%s:x=%d:y=%d',", drawtext, box.text, box.x, box.y)
+		fmt.Fprintf(&b, " [enter] %s enable 1,", drawtext)
+		fmt.Fprintf(&b, " [leave] %s enable 0,", drawbox)
+		fmt.Fprintf(&b, " [leave] %s enable 0;\n", drawtext)
+	}
+	return b.String()
+}
+
+// annotateArgs the timestamps are reset to zero to align
+// the frames with the times in the sendcmd file.
+func annotateArgs(logLevel, cmdPath, outputPath string, slots, width, height int) []string {
+	thickness := strconv.Itoa(max(2, width/320))
+	fontSize := strconv.Itoa(max(12, height/30))
+
+	filters := []string{
+		"setpts=PTS-STARTPTS",
+		"sendcmd=f=" + escapeFilterPath(cmdPath),
+	}
+	for i := 0; i < slots; i++ {
+		slot := strconv.Itoa(i)
+		filters = append(filters,
+			"drawbox@b"+slot+"=enable=0:color=red:t="+thickness,
+			"drawtext@t"+slot+"=enable=0:text=-:fontsize="+fontSize+
+				":fontcolor=white:box=1:boxcolor=red",
+		)
+	}
+
+	return []string{
+		"-n", "-loglevel", logLevel,
+		"-threads", "1",
+		"-i", "-",
+		"-vf", strings.Join(filters, ","),
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23",
+		"-c:a", "copy",
+		"-movflags", "+faststart",
+		"-f", "mp4", outputPath,
+	}
+}
+
+// escapeFilterPath escapes the path for a filter option inside a
+// filtergraph. Option separators are escaped twice since the
+// filtergraph parser removes one level of escaping.
+func escapeFilterPath(path string) string {
+	return strings.NewReplacer(
+		`\`, `\\\\`,
+		`'`, `\\\'`,
+		`:`, `\\:`,
+		`,`, `\,`,
+		`;`, `\;`,
+		`[`, `\[`,
+		`]`, `\]`,
+	).Replace(path)
+}
+
+// handleAnnotated serves the annotated video of a recording.
+func handleAnnotated(recordingsDirs storage.RecordingsDirs) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		recID := strings.TrimPrefix(r.URL.Path, "/api/recording/annotated/")
+		recPath, err := storage.RecordingIDToPath(recID)
+		if err != nil {
+			api.BadRequest(w, err.Error())
+			return
+		}
+
+		path := filepath.Join(recordingsDirs.Find(recID), recPath+storage.RecordingVariants["annotated"])
+		if _, err := os.Stat(path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				api.NotFound(w, "annotated video not found")
+				return
+			}
+			api.InternalError(w, r, "could not stat annotated video", err)
+			return
+		}
+
+		// ServeFile will sanitize ".."
+		http.ServeFile(w, r, path)
+	})
+}
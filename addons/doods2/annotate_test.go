@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package doods
+
+import (
+	"encoding/json"
+	"testing"
+
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Two overlapping events, the second one starts before the recording.
+var annotateTestData = []byte(`{
+	"start": "2000-01-01T00:00:10Z",
+	"end": "2000-01-01T00:01:10Z",
+	"events": [
+		{
+			"time": "2000-01-01T00:00:15.5Z",
+			"detections": [
+				{"label": "person", "score": 87.4, "region": {"rect": [10, 20, 50, 40]}},
+				{"label": "car:x", "score": 51, "region": {"rect": [0, 0, 100, 100]}},
+				{"label": "no rect", "score": 51}
+			],
+			"duration": 4000000000
+		},
+		{
+			"time": "2000-01-01T00:00:09Z",
+			"detections": [
+				{"label": "dog", "score": 60, "region": {"rect": [90, 95, 110, 101]}}
+			],
+			"duration": 7000000000
+		},
+		{
+			"time": "2000-01-01T00:01:09.5Z",
+			"detections": [
+				{"label": "cat", "score": 60, "region": {"rect": [1, 1, 2, 2]}}
+			]
+		}
+	]
+}`)
+
+func TestGenAnnotations(t *testing.T) {
+	var data storage.RecordingData
+	require.NoError(t, json.Unmarshal(annotateTestData, &data))
+
+	boxes, slots := genAnnotations(data, 1920, 1080)
+	expected := []annotationBox{
+		// Clipped to the start of the recording, clamped to the frame.
+		{start: 0, end: 6, x: 1824, y: 972, w: 96, h: 108, text: "dog 60", slot: 0},
+		// Scaled from percentages.
+		{start: 5.5, end: 9.5, x: 384, y: 108, w: 384, h: 432, text: "person 87", slot: 1},
+		{start: 5.5, end: 9.5, x: 0, y: 0, w: 1920, h: 1080, text: "carx 51", slot: 2},
+		// Minimum duration, clipped to the end of the recording.
+		{start: 59.5, end: 60, x: 19, y: 11, w: 19, h: 11, text: "cat 60", slot: 0},
+	}
+	require.Equal(t, expected, boxes)
+	require.Equal(t, 3, slots)
+}
+
+func TestGenSendCmd(t *testing.T) {
+	boxes := []annotationBox{
+		{start: 0, end: 6, x: 1, y: 2, w: 3, h: 4, text: "a 1", slot: 0},
+		{start: 5.5, end: 9.25, x: 5, y: 6, w: 7, h: 8, text: "b 2", slot: 1},
+	}
+	expected := "0.000-6.000" +
+		" [enter] drawbox@b0 x 1, [enter] drawbox@b0 y 2," +
+		" [enter] drawbox@b0 w 3, [enter] drawbox@b0 h 4," +
+		" [enter] drawbox@b0 enable 1," +
+		" [enter] drawtext@t0 reinit 'text=a 1:x=1:y=2'," +
+		" [enter] drawtext@t0 enable 1," +
+		" [leave] drawbox@b0 enable 0, [leave] drawtext@t0 enable 0;\n" +
+		"5.500-9.250" +
+		" [enter] drawbox@b1 x 5, [enter] drawbox@b1 y 6," +
+		" [enter] drawbox@b1 w 7, [enter] drawbox@b1 h 8," +
+		" [enter] drawbox@b1 enable 1," +
+		" [enter] drawtext@t1 reinit 'text=b 2:x=5:y=6'," +
+		" [enter] drawtext@t1 enable 1," +
+		" [leave] drawbox@b1 enable 0, [leave] drawtext@t1 enable 0;\n"
+	require.Equal(t, expected, genSendCmd(boxes))
+}
+
+func TestAnnotateArgs(t *testing.T) {
+	args := annotateArgs("error", "/a:b/c,d", "out", 2, 1280, 720)
+	expected := []string{
+		"-n", "-loglevel", "error",
+		"-threads", "1",
+		"-i", "-",
+		"-vf", "setpts=PTS-STARTPTS," +
+			`sendcmd=f=/a\\:b/c\,d,` +
+			"drawbox@b0=enable=0:color=red:t=4," +
+			"drawtext@t0=enable=0:text=-:fontsize=24:fontcolor=white:box=1:boxcolor=red," +
+			"drawbox@b1=enable=0:color=red:t=4," +
+			"drawtext@t1=enable=0:text=-:fontsize=24:fontcolor=white:box=1:boxcolor=red",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23",
+		"-c:a", "copy",
+		"-movflags", "+faststart",
+		"-f", "mp4", "out",
+	}
+	require.Equal(t, expected, args)
+}
@@ -10,7 +10,6 @@ import (
 	"image"
 	"image/png"
 	"io"
-	"nvr"
 	"nvr/pkg/ffmpeg"
 	"nvr/pkg/log"
 	"nvr/pkg/monitor"
@@ -23,59 +22,86 @@ import (
 )
 
 func init() {
-	nvr.RegisterMonitorInputProcessHook(onInputProcessStart)
+	nvrAddon.RegisterMonitorStartHook(onMonitorStart)
+	nvrAddon.RegisterMonitorStopHook(onMonitorStop)
 }
 
-func onInputProcessStart(ctx context.Context, i *monitor.InputProcess, _ *[]string) {
-	id := i.Config.ID()
+// onMonitorStart starts the detector once per monitor. The detector
+// process reconnects by itself if the input process restarts.
+func onMonitorStart(ctx context.Context, m *monitor.Monitor) {
+	id := m.Config.ID()
 	logf := func(level log.Level, format string, a ...interface{}) {
-		i.Logger.Log(log.Entry{
+		msg, fields := log.Sprintf(format, a...)
+		m.Logger.Log(log.Entry{
 			Level:     level,
 			Src:       "doods",
 			MonitorID: id,
-			Msg:       fmt.Sprintf(format, a...),
+			Msg:       msg,
+			Fields:    fields,
 		})
 	}
 
-	config, enable, err := parseConfig(i.Config)
+	config, enable, err := parseConfig(m.Config)
 	if err != nil {
 		logf(log.LevelError, "could not parse config: %v", err)
 		return
 	}
-	if !enable || config.useSubStream != i.IsSubInput() {
+	if !enable {
 		return
 	}
 	config.fillMissing()
 	if err := config.validate(); err != nil {
 		logf(log.LevelError, "config: %v", err)
+		return
+	}
+
+	input := m.MainInput()
+	if config.useSubStream {
+		input = m.SubInput()
 	}
 
-	i.WG.Add(1)
+	m.WG.Add(1)
 	go func() {
-		defer i.WG.Done()
-		// Wait for monitor to start.
-		select {
-		case <-time.After(10 * time.Second):
-		case <-ctx.Done():
+		defer m.WG.Done()
+		if err := input.WaitForPath(ctx); err != nil {
 			return
 		}
-		if err := start(ctx, i, *config, logf); err != nil {
+		if err := start(ctx, input, *config, logf); err != nil {
 			logf(log.LevelError, "could not start: %v", err)
 		}
 	}()
 }
 
+// onMonitorStop is called after the detector have stopped.
+func onMonitorStop(m *monitor.Monitor) {
+	addon.previewCache.delete(m.Config.ID())
+}
+
 func start(
 	ctx context.Context,
 	input *monitor.InputProcess,
 	config config,
 	logf log.Func,
 ) error {
-	detector, err := detectorByName(config.detectorName)
+	if addon.configErr != nil {
+		return fmt.Errorf("config: %w", addon.configErr)
+	}
+
+	// Waits for the detector list to be refreshed if
+	// the detector isn't in the cached list.
+	detector, err := addon.detectors.waitForDetector(ctx, config.detectorName, logf)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
 		return fmt.Errorf("get detector: %w", err)
 	}
 
+	sendRequest, err := addon.router.sendRequestFunc(config.server, detector, logf)
+	if err != nil {
+		return err
+	}
+
 	videoTrack, err := input.VideoTrack(ctx)
 	if err != nil {
 		return fmt.Errorf("get video track: %w", err)
@@ -98,7 +124,7 @@ func start(
 		return fmt.Errorf("calculate ffmpeg outputs: %w", err)
 	}
 
-	i := newInstance(addon.sendRequest, input, config, addon.previewCache, logf)
+	i := newInstance(sendRequest, input, config, addon.previewCache, logf)
 
 	i.outputs = *outputs
 	i.reverseValues = *reverseValues
@@ -132,6 +158,7 @@ type instance struct {
 	sendRequest  sendRequestFunc
 	encoder      png.Encoder
 	previewCache *previewCache
+	now          func() time.Time
 
 	// watchdogTimer restarts process if it stops outputting frames.
 	watchdogTimer *time.Timer
@@ -151,13 +178,14 @@ func newInstance(
 		logf:      logf,
 		sendEvent: i.SendEvent,
 
-		newProcess:  ffmpeg.NewProcess,
+		newProcess:  i.NewProcess,
 		startReader: startReader,
 		sendRequest: sendRequest,
 		encoder: png.Encoder{
 			CompressionLevel: png.BestSpeed,
 		},
 		previewCache: previewCache,
+		now:          time.Now,
 	}
 }
 
@@ -398,7 +426,24 @@ func startReader(
 }
 
 func (i *instance) runReader(ctx context.Context, stdout io.Reader) error {
-	eventDuration := ffmpeg.FeedRateToDuration(i.c.feedRate)
+	frameInterval, err := ffmpeg.FeedRateToDuration(i.c.feedRate)
+	if err != nil {
+		return err
+	}
+	throttle := newThrottle(frameInterval, i.c.throttleThreshold)
+	changes := newChangeFilter(
+		i.c.changeThreshold,
+		i.c.changeMaxInterval,
+		i.outputs.width,
+		i.outputs.height,
+		i.masked,
+	)
+	setStatus := func() {
+		i.previewCache.SetStatus(i.c.monitorID, detectorStatus{
+			throttleStatus: throttle.status(i.c.feedRate),
+			changeStatus:   changes.status(),
+		})
+	}
 
 	img := NewRGB24(image.Rect(0, 0, i.outputs.width, i.outputs.height))
 	inputBuffer := make([]byte, i.outputs.frameSize)
@@ -412,6 +457,16 @@ func (i *instance) runReader(ctx context.Context, stdout io.Reader) error {
 		t := time.Now().Add(-i.c.timestampOffset)
 		i.watchdogTimer.Reset(10 * time.Second)
 
+		if throttle.skipFrame() {
+			continue
+		}
+		if changes.skipFrame(inputBuffer, i.now()) {
+			setStatus()
+			continue
+		}
+		// The event lasts until the next frame is sent.
+		eventDuration := throttle.effectiveInterval()
+
 		img.Pix = inputBuffer
 		b := bytes.NewBuffer(tmpBuffer)
 		if err := i.encoder.Encode(b, img); err != nil {
@@ -429,12 +484,28 @@ func (i *instance) runReader(ctx context.Context, stdout io.Reader) error {
 
 		ctx2, cancel := context.WithTimeout(ctx, eventDuration*2)
 		defer cancel()
+		requestStart := i.now()
 		detections, err := i.sendRequest(ctx2, request)
 		if err != nil {
 			return fmt.Errorf("send frame: %w", err)
 		}
+		latency := i.now().Sub(requestStart)
+		requestDuration.With(i.c.monitorID).Observe(latency.Seconds())
+
+		prevSkip := throttle.skip
+		if throttle.update(latency) {
+			status := throttle.status(i.c.feedRate)
+			level := log.LevelInfo
+			if throttle.skip > prevSkip {
+				level = log.LevelWarning
+			}
+			i.logf(level, "detector latency %vms, feed rate changed to %.3g/%.3g fps",
+				status.LatencyMs, status.EffectiveRate, status.FeedRate)
+		}
+		setStatus()
 
-		parsed := parseDetections(i.c.minSize, i.c.maxSize, i.c.mask.Area, i.reverseValues, *detections)
+		parsed := parseDetections(
+			i.c.minSize, i.c.maxSize, i.c.mask.Area, i.c.zones, i.reverseValues, *detections)
 		if len(parsed) == 0 {
 			continue
 		}
@@ -447,17 +518,35 @@ func (i *instance) runReader(ctx context.Context, stdout io.Reader) error {
 			Detections:  parsed,
 			Duration:    eventDuration,
 			RecDuration: i.c.recDuration,
+			Source:      "doods",
 		})
 		if err != nil {
 			return fmt.Errorf("send event: %w", err)
 		}
+		detectionsTotal.With(i.c.monitorID).Inc()
+	}
+}
+
+// masked returns true if the pixel of the output frame is inside the mask.
+func (i *instance) masked(x, y int) bool {
+	if !i.c.mask.Enable || len(i.c.mask.Area) < 3 {
+		return false
 	}
+	reverse := i.reverseValues
+	percentX := reverse.uncropXfunc(float32(x)/float32(i.outputs.width)) *
+		reverse.paddingXmultiplier * 100
+	percentY := reverse.uncropYfunc(float32(y)/float32(i.outputs.height)) *
+		reverse.paddingYmultiplier * 100
+
+	// Mask points are x, y.
+	return ffmpeg.VertexInsidePoly(int(percentX), int(percentY), i.c.mask.Area)
 }
 
 func parseDetections(
 	minSize float64,
 	maxSize float64,
 	mask ffmpeg.Polygon,
+	zones zones,
 	reverse reverseValues,
 	detections detections,
 ) []storage.Detection {
@@ -500,14 +589,40 @@ func parseDetections(
 			continue
 		}
 
+		rect := ffmpeg.Rect{top, left, bottom, right}
 		d := storage.Detection{
 			Label: label,
 			Score: score,
 			Region: &storage.Region{
-				Rect: &ffmpeg.Rect{top, left, bottom, right},
+				Rect: &rect,
 			},
+			Zones: zones.containing(rect),
 		}
 		parsed = append(parsed, d)
 	}
 	return parsed
 }
+
+// containing returns the names of the zones that contain the
+// center of the rectangle or enough of the rectangle itself.
+func (zs zones) containing(rect ffmpeg.Rect) []string {
+	var names []string
+	for _, z := range zs {
+		if z.contains(rect) {
+			names = append(names, z.Name)
+		}
+	}
+	return names
+}
+
+func (z zone) contains(rect ffmpeg.Rect) bool {
+	centerY := rect[0] + (rect[2]-rect[0])/2
+	centerX := rect[1] + (rect[3]-rect[1])/2
+
+	// Zone points are x, y.
+	if ffmpeg.VertexInsidePoly(centerX, centerY, z.Area) {
+		return true
+	}
+	return z.Overlap != 0 &&
+		ffmpeg.RectInsidePolyFraction(rect, z.Area)*100 >= z.Overlap
+}
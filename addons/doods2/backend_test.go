@@ -165,8 +165,9 @@ func newTestInstance(logs chan string) *instance {
 	return &instance{
 		env: storage.ConfigEnv{},
 		c: config{
-			feedRate:    2,
-			recDuration: 3,
+			feedRate:          2,
+			recDuration:       3,
+			throttleThreshold: defaultThrottleThreshold,
 		},
 		outputs: outputs{
 			width:     2,
@@ -193,6 +194,7 @@ func newTestInstance(logs chan string) *instance {
 		startReader:   stubStartReader,
 		sendRequest:   stubSendRequest,
 		sendEvent:     stubSendEvent,
+		now:           time.Now,
 		watchdogTimer: time.NewTimer(0),
 	}
 }
@@ -373,6 +375,7 @@ func TestRunInstance(t *testing.T) {
 			}},
 			Duration:    500000000,
 			RecDuration: 3,
+			Source:      "doods",
 		}
 		require.Equal(t, expected, actual)
 	})
@@ -426,7 +429,7 @@ func TestParseDetections(t *testing.T) {
 			},
 		}
 
-		actual := parseDetections(0, 0, nil, reverse, detections)
+		actual := parseDetections(0, 0, nil, nil, reverse, detections)
 		expected := []storage.Detection{
 			{
 				Label: "b",
@@ -464,10 +467,93 @@ func TestParseDetections(t *testing.T) {
 			{40, 60},
 		}
 
-		actual := parseDetections(0, 0, mask, reverse, detections)
+		actual := parseDetections(0, 0, mask, nil, reverse, detections)
 		require.Empty(t, actual)
 	})
+	t.Run("zones", func(t *testing.T) {
+		reverse := reverseValues{
+			paddingXmultiplier: 1,
+			paddingYmultiplier: 1,
+			uncropXfunc:        func(i float32) float32 { return i },
+			uncropYfunc:        func(i float32) float32 { return i },
+		}
+		detections := detections{
+			{
+				Top:        0.6,
+				Left:       0.1,
+				Bottom:     0.8,
+				Right:      0.3,
+				Label:      "person",
+				Confidence: 5,
+			},
+		}
+		zones := zones{
+			{Name: "driveway", Area: ffmpeg.Polygon{{0, 50}, {50, 50}, {50, 100}, {0, 100}}},
+			{Name: "porch", Area: ffmpeg.Polygon{{50, 0}, {100, 0}, {100, 50}, {50, 50}}},
+		}
+
+		actual := parseDetections(0, 0, nil, zones, reverse, detections)
+		require.Len(t, actual, 1)
+		require.Equal(t, []string{"driveway"}, actual[0].Zones)
+	})
 	t.Run("noDetections", func(t *testing.T) {
-		parseDetections(0, 0, nil, reverseValues{}, detections{})
+		parseDetections(0, 0, nil, nil, reverseValues{}, detections{})
+	})
+}
+
+func TestZonesContaining(t *testing.T) {
+	// Right half of the frame.
+	right := ffmpeg.Polygon{{50, 0}, {100, 0}, {100, 100}, {50, 100}}
+
+	cases := map[string]struct {
+		zone     zone
+		rect     ffmpeg.Rect
+		expected []string
+	}{
+		"centerInside": {
+			zone{Name: "a", Area: right},
+			ffmpeg.Rect{10, 60, 20, 80},
+			[]string{"a"},
+		},
+		"outside": {
+			zone{Name: "a", Area: right},
+			ffmpeg.Rect{10, 10, 20, 30},
+			nil,
+		},
+		// Straddles the boundary, 40% of the detection is inside the zone.
+		"straddleCenterOutside": {
+			zone{Name: "a", Area: right},
+			ffmpeg.Rect{0, 35, 10, 60},
+			nil,
+		},
+		"straddleOverlap": {
+			zone{Name: "a", Area: right, Overlap: 35},
+			ffmpeg.Rect{0, 35, 10, 60},
+			[]string{"a"},
+		},
+		"straddleOverlapTooSmall": {
+			zone{Name: "a", Area: right, Overlap: 45},
+			ffmpeg.Rect{0, 35, 10, 60},
+			nil,
+		},
+		// The top of the frame, detections are top, left, bottom, right.
+		"axes": {
+			zone{Name: "a", Area: ffmpeg.Polygon{{0, 0}, {100, 0}, {100, 20}, {0, 20}}},
+			ffmpeg.Rect{0, 60, 10, 80},
+			[]string{"a"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, zones{tc.zone}.containing(tc.rect))
+		})
+	}
+	t.Run("multiple", func(t *testing.T) {
+		zs := zones{
+			{Name: "a", Area: right},
+			{Name: "b", Area: ffmpeg.Polygon{{0, 0}, {100, 0}, {100, 100}, {0, 100}}},
+			{Name: "c", Area: ffmpeg.Polygon{{0, 0}, {10, 0}, {10, 10}, {0, 10}}},
+		}
+		require.Equal(t, []string{"a", "b"}, zs.containing(ffmpeg.Rect{10, 60, 20, 80}))
 	})
 }
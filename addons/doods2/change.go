@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package doods
+
+import (
+	"time"
+)
+
+const (
+	// Frames are downscaled by averaging blocks of pixels before they
+	// are compared, this filters out sensor noise and compression artifacts.
+	changeBlockSize = 8
+
+	// Minimum luma difference of a block to count as changed.
+	changeBlockThreshold = 12
+)
+
+// changeFilter skips frames that barely differ from the last frame sent
+// to the detector. A frame is always sent if the last one was sent more
+// than maxInterval ago, this keeps the preview and the detector warm.
+type changeFilter struct {
+	threshold   float64 // Fraction of changed blocks.
+	maxInterval time.Duration
+
+	width  int // Frame size in pixels.
+	height int
+	cols   int // Frame size in blocks.
+	rows   int
+
+	blockPixels []int  // Number of pixels in each block.
+	masked      []bool // Blocks that are ignored.
+	unmasked    int
+
+	sums     []int
+	cur      []uint8
+	last     []uint8 // Blocks of the frame that was last sent.
+	hasLast  bool
+	lastSent time.Time
+
+	sent    int
+	skipped int
+}
+
+// newChangeFilter the filter is disabled if thresholdPercent is zero.
+// masked returns true if the pixel is inside the mask, changes
+// inside the mask are ignored.
+func newChangeFilter(
+	thresholdPercent float64,
+	maxInterval time.Duration,
+	width int,
+	height int,
+	masked func(x, y int) bool,
+) *changeFilter {
+	cols := (width + changeBlockSize - 1) / changeBlockSize
+	rows := (height + changeBlockSize - 1) / changeBlockSize
+	f := &changeFilter{
+		threshold:   thresholdPercent / 100,
+		maxInterval: maxInterval,
+		width:       width,
+		height:      height,
+		cols:        cols,
+		rows:        rows,
+		blockPixels: make([]int, cols*rows),
+		masked:      make([]bool, cols*rows),
+		sums:        make([]int, cols*rows),
+		cur:         make([]uint8, cols*rows),
+		last:        make([]uint8, cols*rows),
+	}
+
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			x, y := col*changeBlockSize, row*changeBlockSize
+			w := min(changeBlockSize, width-x)
+			h := min(changeBlockSize, height-y)
+
+			i := row*cols + col
+			f.blockPixels[i] = w * h
+			// The block is masked if its center is inside the mask.
+			f.masked[i] = masked(x+w/2, y+h/2)
+			if !f.masked[i] {
+				f.unmasked++
+			}
+		}
+	}
+	return f
+}
+
+// skipFrame returns true if the rgb24 frame should not be sent to the detector.
+func (f *changeFilter) skipFrame(frame []byte, now time.Time) bool {
+	if f.threshold == 0 {
+		f.sent++
+		return false
+	}
+
+	f.downscale(frame)
+	if f.hasLast &&
+		now.Sub(f.lastSent) < f.maxInterval &&
+		f.changedFraction() < f.threshold {
+		f.skipped++
+		return true
+	}
+
+	f.cur, f.last = f.last, f.cur
+	f.hasLast = true
+	f.lastSent = now
+	f.sent++
+	return false
+}
+
+// downscale converts the frame to grayscale and stores the average of each block in cur.
+func (f *changeFilter) downscale(frame []byte) {
+	for i := range f.sums {
+		f.sums[i] = 0
+	}
+	for y := 0; y < f.height; y++ {
+		row := y / changeBlockSize * f.cols
+		pix := frame[y*f.width*3 : (y+1)*f.width*3]
+		for x := 0; x < f.width; x++ {
+			r, g, b := int(pix[x*3]), int(pix[x*3+1]), int(pix[x*3+2])
+			// BT.601 luma.
+			f.sums[row+x/changeBlockSize] += (299*r + 587*g + 114*b) / 1000
+		}
+	}
+	for i, sum := range f.sums {
+		f.cur[i] = uint8(sum / f.blockPixels[i])
+	}
+}
+
+// changedFraction returns the fraction of the unmasked blocks
+// that differ between the current and the last sent frame.
+func (f *changeFilter) changedFraction() float64 {
+	if f.unmasked == 0 {
+		return 0
+	}
+	changed := 0
+	for i := range f.cur {
+		if f.masked[i] {
+			continue
+		}
+		diff := int(f.cur[i]) - int(f.last[i])
+		if diff >= changeBlockThreshold || diff <= -changeBlockThreshold {
+			changed++
+		}
+	}
+	return float64(changed) / float64(f.unmasked)
+}
+
+// changeStatus is the frame statistics of the filter served to the user.
+type changeStatus struct {
+	SentFrames    int `json:"sentFrames"`
+	SkippedFrames int `json:"skippedFrames"`
+}
+
+func (f *changeFilter) status() changeStatus {
+	return changeStatus{
+		SentFrames:    f.sent,
+		SkippedFrames: f.skipped,
+	}
+}
@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package doods
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"nvr/pkg/ffmpeg"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newGrayFrame returns a rgb24 frame filled with the luma value.
+func newGrayFrame(width, height int, luma uint8) []byte {
+	return bytes.Repeat([]byte{luma}, width*height*3)
+}
+
+// fillRect sets the pixels inside the rectangle to the luma value.
+func fillRect(frame []byte, width, x0, y0, x1, y1 int, luma uint8) {
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			i := (y*width + x) * 3
+			frame[i], frame[i+1], frame[i+2] = luma, luma, luma
+		}
+	}
+}
+
+func noMask(int, int) bool { return false }
+
+func TestChangeFilterFraction(t *testing.T) {
+	// 20x16 pixels is 3x2 blocks, the right column is 4 pixels wide.
+	const width, height = 20, 16
+	changed := func(masked func(x, y int) bool, frame []byte) float64 {
+		f := newChangeFilter(1, time.Minute, width, height, masked)
+		f.downscale(newGrayFrame(width, height, 100))
+		f.cur, f.last = f.last, f.cur
+		f.downscale(frame)
+		return f.changedFraction()
+	}
+
+	t.Run("identical", func(t *testing.T) {
+		frame := newGrayFrame(width, height, 100)
+		require.Equal(t, float64(0), changed(noMask, frame))
+	})
+	t.Run("noise", func(t *testing.T) {
+		frame := newGrayFrame(width, height, 100+changeBlockThreshold-1)
+		require.Equal(t, float64(0), changed(noMask, frame))
+	})
+	t.Run("oneBlock", func(t *testing.T) {
+		frame := newGrayFrame(width, height, 100)
+		fillRect(frame, width, 0, 0, 8, 8, 200)
+		require.Equal(t, 1.0/6, changed(noMask, frame))
+	})
+	t.Run("partialBlock", func(t *testing.T) {
+		frame := newGrayFrame(width, height, 100)
+		fillRect(frame, width, 16, 8, 20, 16, 0)
+		require.Equal(t, 1.0/6, changed(noMask, frame))
+	})
+	t.Run("smallObject", func(t *testing.T) {
+		// A quarter of the block changed, the average moves 25.
+		frame := newGrayFrame(width, height, 100)
+		fillRect(frame, width, 0, 0, 4, 4, 200)
+		require.Equal(t, 1.0/6, changed(noMask, frame))
+	})
+	t.Run("color", func(t *testing.T) {
+		frame := newGrayFrame(width, height, 100)
+		for i := 0; i < len(frame); i += 3 {
+			frame[i], frame[i+1], frame[i+2] = 255, 0, 0
+		}
+		// The luma of red is 76.
+		require.Equal(t, float64(1), changed(noMask, frame))
+	})
+	t.Run("masked", func(t *testing.T) {
+		leftColumn := func(x, _ int) bool { return x < 8 }
+		frame := newGrayFrame(width, height, 100)
+		fillRect(frame, width, 0, 0, 8, 16, 200)
+		require.Equal(t, float64(0), changed(leftColumn, frame))
+
+		fillRect(frame, width, 8, 0, 16, 8, 200)
+		require.Equal(t, 1.0/4, changed(leftColumn, frame))
+	})
+	t.Run("allMasked", func(t *testing.T) {
+		all := func(int, int) bool { return true }
+		frame := newGrayFrame(width, height, 200)
+		require.Equal(t, float64(0), changed(all, frame))
+	})
+}
+
+func TestChangeFilterSkipFrame(t *testing.T) {
+	const width, height = 16, 16
+	static := newGrayFrame(width, height, 100)
+	moved := newGrayFrame(width, height, 100)
+	fillRect(moved, width, 0, 0, 8, 8, 200)
+
+	t.Run("maxInterval", func(t *testing.T) {
+		f := newChangeFilter(10, 10*time.Second, width, height, noMask)
+		start := time.Unix(1000, 0)
+		at := func(d time.Duration) time.Time { return start.Add(d) }
+
+		var skipped []bool
+		for _, d := range []time.Duration{0, 3, 6, 9, 12, 15, 18, 22} {
+			skipped = append(skipped, f.skipFrame(static, at(d*time.Second)))
+		}
+		// Forced at 12 and the interval restarts from there.
+		require.Equal(t, []bool{false, true, true, true, false, true, true, false}, skipped)
+		require.Equal(t, changeStatus{SentFrames: 3, SkippedFrames: 5}, f.status())
+	})
+	t.Run("changed", func(t *testing.T) {
+		f := newChangeFilter(10, 10*time.Second, width, height, noMask)
+		now := time.Unix(1000, 0)
+
+		require.False(t, f.skipFrame(static, now))
+		require.False(t, f.skipFrame(moved, now))
+
+		// Compared against the last sent frame.
+		require.True(t, f.skipFrame(moved, now))
+		require.False(t, f.skipFrame(static, now))
+	})
+	t.Run("belowThreshold", func(t *testing.T) {
+		// One of four blocks changed.
+		f := newChangeFilter(30, 10*time.Second, width, height, noMask)
+		now := time.Unix(1000, 0)
+		require.False(t, f.skipFrame(static, now))
+		require.True(t, f.skipFrame(moved, now))
+	})
+	t.Run("disabled", func(t *testing.T) {
+		f := newChangeFilter(0, 10*time.Second, width, height, noMask)
+		now := time.Unix(1000, 0)
+		for i := 0; i < 3; i++ {
+			require.False(t, f.skipFrame(static, now))
+		}
+		require.Equal(t, changeStatus{SentFrames: 3}, f.status())
+	})
+}
+
+func TestInstanceMasked(t *testing.T) {
+	i := newTestInstance(nil)
+	i.outputs.width = 100
+	i.outputs.height = 100
+	i.reverseValues.paddingXmultiplier = 1
+	i.reverseValues.paddingYmultiplier = 1
+	i.c.mask = mask{
+		Enable: true,
+		// Top right quarter.
+		Area: ffmpeg.Polygon{{50, 0}, {100, 0}, {100, 50}, {50, 50}},
+	}
+
+	require.True(t, i.masked(75, 25))
+	require.False(t, i.masked(25, 25))
+	require.False(t, i.masked(75, 75))
+
+	i.c.mask.Enable = false
+	require.False(t, i.masked(75, 25))
+}
+
+func TestRunInstanceChangeFilter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	requests := 0
+	i := newTestInstance(nil)
+	i.c.changeThreshold = 10
+	i.c.changeMaxInterval = 5 * time.Second
+	// Every call takes a second, the throttle must not drop frames.
+	i.c.throttleThreshold = 1e6
+	i.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	i.sendRequest = func(context.Context, detectRequest) (*detections, error) {
+		requests++
+		return &detections{}, nil
+	}
+
+	feed := bytes.NewReader(bytes.Repeat(frames[:12], 12))
+	err := i.runReader(context.Background(), feed)
+	require.ErrorIs(t, err, io.EOF)
+
+	status := i.previewCache.status[i.c.monitorID]
+	require.Equal(t, requests, status.SentFrames)
+	require.Equal(t, 12, status.SentFrames+status.SkippedFrames)
+	require.Less(t, requests, 12)
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"nvr"
 	"nvr/pkg/ffmpeg"
 	"nvr/pkg/monitor"
 	"strconv"
@@ -23,13 +22,26 @@ type config struct {
 	cropY           float64
 	cropSize        float64
 	mask            mask
+	zones           zones
 	minSize         float64
 	maxSize         float64
 	detectorName    string
+	server          string
 	grayMode        bool
 	feedRate        float64
 	recDuration     time.Duration
 	useSubStream    bool
+
+	// Burn the detections into a copy of each recording.
+	annotate bool
+
+	// Percentage of the frame interval.
+	throttleThreshold float64
+
+	// Percentage of changed pixels below which frames are not
+	// sent to the detector, zero disables the filter.
+	changeThreshold   float64
+	changeMaxInterval time.Duration
 }
 
 type rawConfigV1 struct {
@@ -37,12 +49,19 @@ type rawConfigV1 struct {
 	Thresholds   string `json:"thresholds"`
 	Crop         string `json:"crop"`
 	Mask         string `json:"mask"`
+	Zones        string `json:"zones,omitempty"`
 	MinSize      string `json:"minSize"`
 	MaxSize      string `json:"maxSize"`
 	DetectorName string `json:"detectorName"`
+	Server       string `json:"server,omitempty"`
 	FeedRate     string `json:"feedRate"`
 	Duration     string `json:"duration"`
 	UseSubStream string `json:"useSubStream"`
+
+	ThrottleThreshold string `json:"throttleThreshold,omitempty"`
+	Annotate          string `json:"annotate,omitempty"`
+	ChangeThreshold   string `json:"changeThreshold,omitempty"`
+	ChangeMaxInterval string `json:"changeMaxInterval,omitempty"`
 }
 
 type mask struct {
@@ -50,9 +69,22 @@ type mask struct {
 	Area   ffmpeg.Polygon `json:"area"`
 }
 
+// zone named area that detections are tagged with.
+type zone struct {
+	Name string         `json:"name"`
+	Area ffmpeg.Polygon `json:"area"`
+
+	// Percentage of the detection that must be inside the
+	// zone. Only the center point is checked if zero.
+	Overlap float64 `json:"overlap,omitempty"`
+}
+
+type zones []zone
+
 func parseConfig(c monitor.Config) (*config, bool, error) { //nolint:funlen
-	rawConf, err := parseRawConfig(c.Get("doods"))
-	if err != nil {
+	var rawConf rawConfigV1
+	err := c.GetJSON("doods", &rawConf)
+	if err != nil && !errors.Is(err, monitor.ErrConfigKeyAbsent) {
 		return nil, false, err
 	}
 	enable := rawConf.Enable == "true"
@@ -85,6 +117,13 @@ func parseConfig(c monitor.Config) (*config, bool, error) { //nolint:funlen
 		}
 	}
 
+	var zones zones
+	if rawConf.Zones != "" {
+		if err := json.Unmarshal([]byte(rawConf.Zones), &zones); err != nil {
+			return nil, false, fmt.Errorf("unmarshal zones: %w", err)
+		}
+	}
+
 	var minSize float64
 	if rawConf.MinSize != "" {
 		minSize, err = strconv.ParseFloat(rawConf.MinSize, 64)
@@ -105,7 +144,7 @@ func parseConfig(c monitor.Config) (*config, bool, error) { //nolint:funlen
 
 	var feedRate float64
 	if rawConf.FeedRate != "" {
-		feedRate, err = strconv.ParseFloat(rawConf.FeedRate, 64)
+		feedRate, err = ffmpeg.ParseFeedRate(rawConf.FeedRate)
 		if err != nil {
 			return nil, false, fmt.Errorf("parse feed rate: %w", err)
 		}
@@ -116,7 +155,29 @@ func parseConfig(c monitor.Config) (*config, bool, error) { //nolint:funlen
 		return nil, false, err
 	}
 
-	useSubStream := c.SubInputEnabled() && rawConf.UseSubStream == "true"
+	var throttleThreshold float64
+	if rawConf.ThrottleThreshold != "" {
+		throttleThreshold, err = strconv.ParseFloat(rawConf.ThrottleThreshold, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("parse throttle threshold: %w", err)
+		}
+	}
+
+	var changeThreshold float64
+	if rawConf.ChangeThreshold != "" {
+		changeThreshold, err = strconv.ParseFloat(rawConf.ChangeThreshold, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("parse change threshold: %w", err)
+		}
+	}
+
+	changeMaxInterval, err := parseDuration(rawConf.ChangeMaxInterval)
+	if err != nil {
+		return nil, false, fmt.Errorf("change max interval: %w", err)
+	}
+
+	// Use the sub stream by default if available.
+	useSubStream := c.SubStreamEnabled() && rawConf.UseSubStream != "false"
 
 	return &config{
 		monitorID:       c.ID(),
@@ -128,26 +189,21 @@ func parseConfig(c monitor.Config) (*config, bool, error) { //nolint:funlen
 		cropY:           crop[1],
 		cropSize:        crop[2],
 		mask:            mask,
+		zones:           zones,
 		minSize:         minSize,
 		maxSize:         maxSize,
 		detectorName:    rawConf.DetectorName,
+		server:          rawConf.Server,
 		grayMode:        grayMode,
 		feedRate:        feedRate,
 		recDuration:     recDuration,
 		useSubStream:    useSubStream,
-	}, enable, nil
-}
+		annotate:        rawConf.Annotate == "true",
 
-func parseRawConfig(rawDoods string) (rawConfigV1, error) {
-	if rawDoods == "" {
-		return rawConfigV1{}, nil
-	}
-	var rawConf rawConfigV1
-	err := json.Unmarshal([]byte(rawDoods), &rawConf)
-	if err != nil {
-		return rawConfigV1{}, fmt.Errorf("unmarshal doods: %w", err)
-	}
-	return rawConf, nil
+		throttleThreshold: throttleThreshold,
+		changeThreshold:   changeThreshold,
+		changeMaxInterval: changeMaxInterval,
+	}, enable, nil
 }
 
 func parseThresholds(rawThresholds string) (thresholds, error) {
@@ -183,6 +239,9 @@ const (
 	defaultCropSize    = 100
 	defaultFeedRate    = 0.2
 	defaultRecDuration = 120 * time.Second
+
+	defaultThrottleThreshold = 80
+	defaultChangeMaxInterval = 10 * time.Second
 )
 
 func (c *config) fillMissing() {
@@ -198,6 +257,12 @@ func (c *config) fillMissing() {
 	if c.recDuration == 0 {
 		c.recDuration = defaultRecDuration
 	}
+	if c.throttleThreshold == 0 {
+		c.throttleThreshold = defaultThrottleThreshold
+	}
+	if c.changeMaxInterval == 0 {
+		c.changeMaxInterval = defaultChangeMaxInterval
+	}
 }
 
 // Validate errors.
@@ -205,8 +270,16 @@ var (
 	ErrInvalidCropSize = errors.New("invalid crop size")
 	ErrInvalidCropX    = errors.New("invalid cropX")
 	ErrInvalidCropY    = errors.New("invalid cropY")
-	ErrInvalidFeedRate = errors.New("invalid feed rate")
+	ErrInvalidFeedRate = ffmpeg.ErrInvalidFeedRate
 	ErrInvalidDuration = errors.New("invalid duration")
+
+	ErrInvalidThrottleThreshold = errors.New("invalid throttle threshold")
+	ErrInvalidChangeThreshold   = errors.New("invalid change threshold")
+	ErrInvalidChangeMaxInterval = errors.New("invalid change max interval")
+
+	ErrInvalidZone        = errors.New("invalid zone")
+	ErrDuplicateZone      = errors.New("duplicate zone")
+	ErrInvalidZoneOverlap = errors.New("invalid zone overlap")
 )
 
 // The WebUI shouldn't allow the user to save invalid values, this is more of
@@ -221,32 +294,65 @@ func (c *config) validate() error {
 	if c.cropY < 0 || c.cropY > 100 {
 		return fmt.Errorf("%w: %v", ErrInvalidCropY, c.cropY)
 	}
-	if c.feedRate <= 0 {
-		return fmt.Errorf("%w: %v", ErrInvalidFeedRate, c.feedRate)
+	if _, err := ffmpeg.FeedRateToDuration(c.feedRate); err != nil {
+		return err
 	}
 	if c.recDuration < 0 {
 		return fmt.Errorf("%w: %v", ErrInvalidDuration, c.recDuration)
 	}
+	if c.throttleThreshold < 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidThrottleThreshold, c.throttleThreshold)
+	}
+	if c.changeThreshold < 0 || c.changeThreshold > 100 {
+		return fmt.Errorf("%w: %v", ErrInvalidChangeThreshold, c.changeThreshold)
+	}
+	if c.changeMaxInterval < 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidChangeMaxInterval, c.changeMaxInterval)
+	}
+	return c.zones.validate()
+}
+
+func (zs zones) validate() error {
+	names := make(map[string]struct{}, len(zs))
+	for _, z := range zs {
+		if z.Name == "" {
+			return fmt.Errorf("%w: empty name", ErrInvalidZone)
+		}
+		if len(z.Area) < 3 {
+			return fmt.Errorf("%w: %q: area needs at least 3 points", ErrInvalidZone, z.Name)
+		}
+		if _, exists := names[z.Name]; exists {
+			return fmt.Errorf("%w: %q", ErrDuplicateZone, z.Name)
+		}
+		names[z.Name] = struct{}{}
+		if z.Overlap < 0 || z.Overlap > 100 {
+			return fmt.Errorf("%w: %q: %v", ErrInvalidZoneOverlap, z.Name, z.Overlap)
+		}
+	}
 	return nil
 }
 
 func init() {
-	nvr.RegisterMigrationMonitorHook(migrate)
+	nvrAddon.RegisterMigrationMonitorHook(migrate)
+	nvrAddon.RegisterMonitorConfigKeys("doods", "doodsConfigVersion")
 }
 
 const currentConfigVersion = 1
 
-func migrate(c monitor.RawConfig) error {
+func migrate(c monitor.RawConfig) (monitor.MigrationVersions, error) {
 	configVersion, _ := strconv.Atoi(c["doodsConfigVersion"])
 
 	if configVersion < 1 {
 		if err := migrateV0toV1(c); err != nil {
-			return fmt.Errorf("doods v0 to v1: %w", err)
+			return monitor.MigrationVersions{}, fmt.Errorf("doods v0 to v1: %w", err)
 		}
 	}
 
 	c["doodsConfigVersion"] = strconv.Itoa(currentConfigVersion)
-	return nil
+	return monitor.MigrationVersions{
+		From: configVersion,
+		To:   currentConfigVersion,
+	}, nil
 }
 
 func migrateV0toV1(c monitor.RawConfig) error {
@@ -22,17 +22,22 @@ func TestParseConfig(t *testing.T) {
 			"thresholds":   "{\"5\":6}",
 			"crop":         "[7,8,9]",
 			"mask":         "{\"enable\":true,\"area\":[[10,11],[12,13]]}",
+			"zones":        "[{\"name\":\"a\",\"area\":[[1,2],[3,4],[5,6]],\"overlap\":7}]",
 			"detectorName": "14",
+			"server":       "x",
 			"feedRate":     "15",
 			"duration":     "0.000000016",
-			"useSubStream": "true"
+			"useSubStream": "true",
+			"throttleThreshold": "17",
+			"changeThreshold": "1.5",
+			"changeMaxInterval": "30"
 		}`
 		c := monitor.NewConfig(monitor.RawConfig{
 			"id":              "1",
 			"hwaccel":         "2",
 			"logLevel":        "3",
 			"timestampOffset": "4",
-			"subInput":        "x",
+			"subStream":       "x",
 			"doods":           doods,
 		})
 		actual, enable, err := parseConfig(c)
@@ -52,13 +57,46 @@ func TestParseConfig(t *testing.T) {
 				Enable: true,
 				Area:   ffmpeg.Polygon{{10, 11}, {12, 13}},
 			},
+			zones: zones{{
+				Name:    "a",
+				Area:    ffmpeg.Polygon{{1, 2}, {3, 4}, {5, 6}},
+				Overlap: 7,
+			}},
 			detectorName: "14",
+			server:       "x",
 			feedRate:     15,
 			recDuration:  16,
 			useSubStream: true,
+
+			throttleThreshold: 17,
+			changeThreshold:   1.5,
+			changeMaxInterval: 30 * time.Second,
 		}
 		require.Equal(t, expected, *actual)
 	})
+	t.Run("useSubStream", func(t *testing.T) {
+		cases := []struct {
+			subStream    string
+			useSubStream string
+			expected     bool
+		}{
+			{"", "", false},
+			{"", "true", false},
+			{"x", "", true},
+			{"x", "true", true},
+			{"x", "false", false},
+		}
+		for _, tc := range cases {
+			doods := `{"enable": "true", "useSubStream": "` + tc.useSubStream + `"}`
+			c := monitor.NewConfig(monitor.RawConfig{
+				"subStream": tc.subStream,
+				"doods":     doods,
+			})
+			actual, _, err := parseConfig(c)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, actual.useSubStream, tc)
+		}
+	})
 	t.Run("gray", func(t *testing.T) {
 		doods := `
 		{
@@ -155,12 +193,27 @@ func TestParseConfig(t *testing.T) {
 		"maskErr": {
 			"doods": `{"enable": "true", "mask":"{\"enable\":true, \"area\":[[1,x]]}"}`,
 		},
+		"zonesErr": {
+			"doods": `{"enable": "true", "zones":"[{\"name\":1}]"}`,
+		},
 		"feedRateErr": {
 			"doods": `{"enable": "true", "feedRate":"nil"}`,
 		},
+		"feedRateCommaErr": {
+			"doods": `{"enable": "true", "feedRate":"0,2"}`,
+		},
 		"recDurationErr": {
 			"doods": `{"enable": "true", "duration":"nil"}`,
 		},
+		"throttleThresholdErr": {
+			"doods": `{"enable": "true", "throttleThreshold":"nil"}`,
+		},
+		"changeThresholdErr": {
+			"doods": `{"enable": "true", "changeThreshold":"nil"}`,
+		},
+		"changeMaxIntervalErr": {
+			"doods": `{"enable": "true", "changeMaxInterval":"nil"}`,
+		},
 	}
 	for name, conf := range cases {
 		t.Run(name, func(t *testing.T) {
@@ -179,10 +232,15 @@ func TestFillMissing(t *testing.T) {
 		cropSize:    defaultCropSize,
 		feedRate:    defaultFeedRate,
 		recDuration: defaultRecDuration,
+
+		throttleThreshold: defaultThrottleThreshold,
+		changeMaxInterval: defaultChangeMaxInterval,
 	}
 	require.Equal(t, expected, actual)
 }
 
+var testZoneArea = ffmpeg.Polygon{{1, 2}, {3, 4}, {5, 6}}
+
 func TestValidate(t *testing.T) {
 	cases := map[string]struct {
 		input config
@@ -275,6 +333,79 @@ func TestValidate(t *testing.T) {
 			},
 			ErrInvalidDuration,
 		},
+		"throttleThresholdErr": {
+			config{
+				monitorID:         "1",
+				detectorName:      "2",
+				feedRate:          3,
+				recDuration:       4 * time.Second,
+				throttleThreshold: -1,
+			},
+			ErrInvalidThrottleThreshold,
+		},
+		"changeThresholdErr": {
+			config{
+				monitorID:       "1",
+				detectorName:    "2",
+				feedRate:        3,
+				recDuration:     4 * time.Second,
+				changeThreshold: 101,
+			},
+			ErrInvalidChangeThreshold,
+		},
+		"changeMaxIntervalErr": {
+			config{
+				monitorID:         "1",
+				detectorName:      "2",
+				feedRate:          3,
+				recDuration:       4 * time.Second,
+				changeMaxInterval: -1,
+			},
+			ErrInvalidChangeMaxInterval,
+		},
+		"zoneNameErr": {
+			config{
+				monitorID:    "1",
+				detectorName: "2",
+				feedRate:     3,
+				recDuration:  4 * time.Second,
+				zones:        zones{{Area: testZoneArea}},
+			},
+			ErrInvalidZone,
+		},
+		"zoneAreaErr": {
+			config{
+				monitorID:    "1",
+				detectorName: "2",
+				feedRate:     3,
+				recDuration:  4 * time.Second,
+				zones:        zones{{Name: "a", Area: ffmpeg.Polygon{{1, 2}, {3, 4}}}},
+			},
+			ErrInvalidZone,
+		},
+		"zoneDuplicateErr": {
+			config{
+				monitorID:    "1",
+				detectorName: "2",
+				feedRate:     3,
+				recDuration:  4 * time.Second,
+				zones: zones{
+					{Name: "a", Area: testZoneArea},
+					{Name: "a", Area: testZoneArea},
+				},
+			},
+			ErrDuplicateZone,
+		},
+		"zoneOverlapErr": {
+			config{
+				monitorID:    "1",
+				detectorName: "2",
+				feedRate:     3,
+				recDuration:  4 * time.Second,
+				zones:        zones{{Name: "a", Area: testZoneArea, Overlap: 101}},
+			},
+			ErrInvalidZoneOverlap,
+		},
 	}
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
@@ -295,7 +426,7 @@ func TestMigrate(t *testing.T) {
 		"doodsDuration":     "0.000000012",
 		"doodsUseSubStream": "true",
 	}
-	err := migrate(c)
+	_, err := migrate(c)
 	require.NoError(t, err)
 	actual := c
 
@@ -329,7 +460,7 @@ func TestMigrateV0ToV1(t *testing.T) {
 		"doodsDuration":     "0.000000012",
 		"doodsUseSubStream": "true",
 	}
-	err := migrate(c)
+	_, err := migrate(c)
 	require.NoError(t, err)
 	actual := c
 
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package doods
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nvr/pkg/log"
+	"os"
+	"sync"
+	"time"
+)
+
+// detectorStore the combined detector list of all servers. The last
+// fetched list is cached to disk so the addon can start with the
+// cached list while the servers are unreachable.
+type detectorStore struct {
+	cachePath string
+
+	mu   sync.Mutex
+	list detectors
+
+	// Closed and replaced when the list is updated.
+	updated chan struct{}
+}
+
+func newDetectorStore(cachePath string) *detectorStore {
+	return &detectorStore{
+		cachePath: cachePath,
+		updated:   make(chan struct{}),
+	}
+}
+
+func (s *detectorStore) get() detectors {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list
+}
+
+// set replaces the list, saves it to the cache and
+// wakes up the monitors that are waiting for a detector.
+func (s *detectorStore) set(list detectors) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.list = list
+	close(s.updated)
+	s.updated = make(chan struct{})
+
+	if s.cachePath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(list, "", "    ")
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	tmpPath := s.cachePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("write cache: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.cachePath); err != nil {
+		return fmt.Errorf("rename cache: %w", err)
+	}
+	return nil
+}
+
+// loadCache loads the cached list, the list is
+// left empty if the cache doesn't exist.
+func (s *detectorStore) loadCache() error {
+	data, err := os.ReadFile(s.cachePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	var list detectors
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("unmarshal: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.list = list
+	return nil
+}
+
+// byName also returns a channel that's closed when the list is updated.
+func (s *detectorStore) byName(name string) (detector, <-chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, detector := range s.list {
+		if detector.Name == name {
+			return detector, s.updated, nil
+		}
+	}
+	return detector{}, s.updated, fmt.Errorf("%v: %w", name, os.ErrNotExist)
+}
+
+// waitForDetector blocks until the detector is in the list.
+func (s *detectorStore) waitForDetector(
+	ctx context.Context,
+	name string,
+	logf log.Func,
+) (detector, error) {
+	for i := 0; ; i++ {
+		d, updated, err := s.byName(name)
+		if err == nil {
+			if i != 0 {
+				logf(log.LevelInfo, "detector %q is available", name)
+			}
+			return d, nil
+		}
+		if i == 0 {
+			logf(log.LevelWarning, "detector %q is not available,"+
+				" detection will start when the detector list is refreshed", name)
+		}
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return detector{}, ctx.Err()
+		}
+	}
+}
+
+// fetchDetectorsLoop retries until the detectors are fetched.
+func fetchDetectorsLoop(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	router *serverRouter,
+	store *detectorStore,
+	interval time.Duration,
+) {
+	defer wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		list, err := router.fetchDetectors()
+		if err != nil {
+			router.logf(log.LevelDebug, "could not fetch detectors: %v", err)
+			continue
+		}
+		router.logf(log.LevelInfo, "found %d detectors", len(list))
+		if err := store.set(list); err != nil {
+			router.logf(log.LevelError, "could not cache detectors: %v", err)
+		}
+		return
+	}
+}
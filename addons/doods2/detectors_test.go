@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package doods
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectorStore(t *testing.T) {
+	t.Run("byName", func(t *testing.T) {
+		s := newDetectorStore("")
+		require.NoError(t, s.set(testDetectors))
+
+		detector, _, err := s.byName("1")
+		require.NoError(t, err)
+		require.Equal(t, testDetectors[0], detector)
+
+		_, _, err = s.byName("nil")
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+	t.Run("cache", func(t *testing.T) {
+		cachePath := filepath.Join(t.TempDir(), "doods-detectors.json")
+		require.NoError(t, newDetectorStore(cachePath).set(testDetectors))
+
+		s := newDetectorStore(cachePath)
+		require.NoError(t, s.loadCache())
+		require.Equal(t, testDetectors, s.get())
+	})
+	t.Run("cacheNotExist", func(t *testing.T) {
+		s := newDetectorStore(filepath.Join(t.TempDir(), "nil"))
+		require.NoError(t, s.loadCache())
+		require.Empty(t, s.get())
+	})
+}
+
+func newTestLogger(t *testing.T) *log.Logger {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	logger := log.NewLogger(&sync.WaitGroup{}, nil)
+	require.NoError(t, logger.Start(ctx))
+	return logger
+}
+
+func TestOnEnvUnreachable(t *testing.T) {
+	addon.logger = newTestLogger(t)
+	configDir := t.TempDir()
+
+	// Nothing listens on port 1.
+	config, err := json.Marshal(Config{IP: "127.0.0.1:1"})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "doods.json"), config, 0o600))
+
+	cache, err := json.Marshal(testDetectors)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "doods-detectors.json"), cache, 0o600))
+
+	done := make(chan struct{})
+	go func() {
+		onEnv(storage.ConfigEnv{ConfigDir: configDir})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("onEnv is blocking")
+	}
+
+	require.NoError(t, addon.configErr)
+	require.True(t, addon.fetchPending)
+	require.Equal(t, testDetectors, addon.detectors.get())
+}
+
+func TestOnEnvConfigErr(t *testing.T) {
+	addon.logger = newTestLogger(t)
+	configDir := t.TempDir()
+	config := []byte(`{"transport":"nil"}`)
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "doods.json"), config, 0o600))
+
+	onEnv(storage.ConfigEnv{ConfigDir: configDir})
+	require.ErrorIs(t, addon.configErr, errInvalidTransport)
+	require.ErrorIs(t, health(), errInvalidTransport)
+
+	_, exist := addon.router.serverByName("")
+	require.False(t, exist)
+}
+
+func TestFetchDetectorsLoop(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "doods-detectors.json")
+	store := newDetectorStore(cachePath)
+
+	// Fails the first time.
+	var mu sync.Mutex
+	calls := 0
+	server := &doodsServer{
+		name: "a",
+		fetchDetectors: func() (detectors, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls++
+			if calls == 1 {
+				return nil, errors.New("stub")
+			}
+			return testDetectors, nil
+		},
+	}
+	router := newServerRouter([]*doodsServer{server}, logf)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The monitor is waiting for the detector.
+	waitDone := make(chan detector)
+	go func() {
+		d, err := store.waitForDetector(ctx, "1", logf)
+		require.NoError(t, err)
+		waitDone <- d
+	}()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go fetchDetectorsLoop(ctx, wg, router, store, time.Millisecond)
+
+	select {
+	case d := <-waitDone:
+		require.Equal(t, testDetectors[0], d)
+	case <-time.After(5 * time.Second):
+		t.Fatal("detector was not activated")
+	}
+	wg.Wait()
+
+	require.Equal(t, 2, calls)
+	require.True(t, server.hasDetector(testDetectors[0]))
+
+	// Cached.
+	cached := newDetectorStore(cachePath)
+	require.NoError(t, cached.loadCache())
+	require.Equal(t, testDetectors, cached.get())
+}
+
+func TestWaitForDetectorCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := newDetectorStore("").waitForDetector(ctx, "1", logf)
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -3,10 +3,11 @@
 package doods
 
 import (
-	_ "embed"
+	"embed"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"html/template"
+	"io/fs"
 	"os"
 	"strings"
 )
@@ -16,13 +17,18 @@ func modifyTemplates(pageFiles map[string]string) error {
 	if !exists {
 		return fmt.Errorf("doods: settings.js: %w", os.ErrNotExist)
 	}
-
 	pageFiles["settings.js"] = modifySettingsjs(js)
+
+	tpl, exists := pageFiles["settings.tpl"]
+	if !exists {
+		return fmt.Errorf("doods: settings.tpl: %w", os.ErrNotExist)
+	}
+	pageFiles["settings.tpl"] = modifySettingsTpl(tpl)
 	return nil
 }
 
 func modifySettingsjs(tpl string) string {
-	const importStatement = `import { doods } from "./doods.mjs"
+	const importStatement = `import { doods } from "{{ asset "doods/doods.mjs" }}"
 `
 	const target = "logLevel: fieldTemplate.select("
 
@@ -30,21 +36,44 @@ func modifySettingsjs(tpl string) string {
 	return importStatement + tpl
 }
 
-//go:embed doods.mjs
-var doodsMjsFile string
-var doodsMjsCache string
+func modifySettingsTpl(tpl string) string {
+	const target = `{{ template "meta" . }}`
+	const globals = `
+	<script>
+		const DoodsDetectors = JSON.parse("{{ .doodsDetectors }}");
+		const DoodsServers = JSON.parse("{{ .doodsServers }}");
+	</script>`
+
+	return strings.Replace(tpl, target, target+globals, 1)
+}
+
+//go:embed static/doods
+var staticFiles embed.FS
+
+func staticFS() fs.FS {
+	fsys, err := fs.Sub(staticFiles, "static/doods")
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
 
-func serveDoodsMjs() http.Handler {
-	if doodsMjsCache == "" {
-		data, _ := json.Marshal(addon.detectorList)
-		detectorsJSON := string(data)
-		doodsMjsCache = strings.Replace(doodsMjsFile, "$detectorsJSON", detectorsJSON, 1)
+// setTemplateData the detector list can change after startup.
+func setTemplateData(data template.FuncMap, page string) {
+	if page != "settings.tpl" {
+		return
+	}
+	list := addon.detectors.get()
+	if list == nil {
+		list = detectors{}
 	}
+	detectorsJSON, _ := json.Marshal(list)
+	data["doodsDetectors"] = string(detectorsJSON)
 
-	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-		w.Header().Set("content-type", "text/javascript")
-		if _, err := w.Write([]byte(doodsMjsCache)); err != nil {
-			http.Error(w, "could not write: "+err.Error(), http.StatusInternalServerError)
-		}
-	})
+	serverNames := []string{}
+	for _, s := range addon.router.servers {
+		serverNames = append(serverNames, s.name)
+	}
+	serversJSON, _ := json.Marshal(serverNames)
+	data["doodsServers"] = string(serversJSON)
 }
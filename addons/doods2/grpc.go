@@ -0,0 +1,264 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package doods
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"nvr/pkg/log"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// grpcClient is a minimal unary gRPC client for the odrpc
+// service over cleartext HTTP/2. HTTP/2 connections are
+// managed by the transport and are redialed on demand.
+type grpcClient struct {
+	logf     log.Func
+	url      string
+	timeout  time.Duration
+	retryMin time.Duration
+	retryMax time.Duration
+	now      func() time.Time
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	httpClient *http.Client
+
+	mu         sync.Mutex
+	retryDelay time.Duration // Zero after a successful request.
+	retryAfter time.Time
+}
+
+func newGRPCClient(logf log.Func, doodsIP string) *grpcClient {
+	c := &grpcClient{
+		logf:     logf,
+		url:      "http://" + doodsIP + "/odrpc.odrpc/",
+		timeout:  1000 * time.Millisecond,
+		retryMin: 3 * time.Second,
+		retryMax: 1 * time.Minute,
+		now:      time.Now,
+	}
+	c.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+		return (&net.Dialer{}).DialContext(dialCtx, network, addr)
+	}
+	c.httpClient = &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(
+				ctx context.Context, network, addr string, _ *tls.Config,
+			) (net.Conn, error) {
+				return c.dial(ctx, network, addr)
+			},
+		},
+	}
+	return c
+}
+
+// Errors.
+var (
+	errGRPCUnavailable     = errors.New("grpc connection unavailable")
+	errGRPCNoMessage       = errors.New("grpc response without message")
+	errGRPCMessageTooLarge = errors.New("grpc message too large")
+)
+
+// grpcMaxMessageSize is the default limit of gRPC
+// servers and clients. Detection responses are small.
+const grpcMaxMessageSize = 4 * 1024 * 1024
+
+const (
+	grpcStatusOK               = "0"
+	grpcStatusDeadlineExceeded = "4"
+)
+
+func (c *grpcClient) sendRequest(ctx context.Context, request detectRequest) (*detections, error) {
+	msg, err := c.call(ctx, "Detect", request.marshalProto())
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := unmarshalDetectResponse(msg)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal response: %w", err)
+	}
+	if response.ServerError != "" {
+		return nil, fmt.Errorf("%w: %v", errDoods, response.ServerError)
+	}
+	return &response.Detections, nil
+}
+
+func (c *grpcClient) fetchDetectors() (detectors, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msg, err := c.call(ctx, "GetDetectors", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := unmarshalGetDetectorsResponse(msg)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal response: %w", err)
+	}
+	return d, nil
+}
+
+// call sends a single request message and returns the response message.
+func (c *grpcClient) call(ctx context.Context, method string, msg []byte) ([]byte, error) {
+	if c.waitingToRetry() {
+		return nil, errGRPCUnavailable
+	}
+
+	body := make([]byte, 5+len(msg))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(msg)))
+	copy(body[5:], msg)
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, c.url+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set("grpc-timeout", encodeGRPCTimeout(time.Until(deadline)))
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		c.connectionFailed(err)
+		return nil, fmt.Errorf("send request: %w", err)
+	}
+	defer res.Body.Close()
+	c.connectionSucceeded()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: http status: %v", errDoods, res.StatusCode)
+	}
+
+	resMsg, err := readGRPCMessage(res.Body)
+	if err != nil && !errors.Is(err, io.EOF) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	// Trailers are available after the body has been read.
+	if _, err := io.Copy(io.Discard, res.Body); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	if err := grpcStatus(res); err != nil {
+		return nil, err
+	}
+	if resMsg == nil {
+		return nil, errGRPCNoMessage
+	}
+	return resMsg, nil
+}
+
+// waitingToRetry returns true if the last connection
+// attempt failed less than the retry delay ago.
+func (c *grpcClient) waitingToRetry() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now().Before(c.retryAfter)
+}
+
+// connectionFailed doubles the retry delay up to retryMax.
+func (c *grpcClient) connectionFailed(err error) {
+	c.mu.Lock()
+	if c.retryDelay == 0 {
+		c.retryDelay = c.retryMin
+	} else {
+		c.retryDelay *= 2
+	}
+	if c.retryDelay > c.retryMax {
+		c.retryDelay = c.retryMax
+	}
+	delay := c.retryDelay
+	c.retryAfter = c.now().Add(delay)
+	c.mu.Unlock()
+
+	c.logf(log.LevelError, "grpc client: %v, retrying in %v", err, delay)
+}
+
+func (c *grpcClient) connectionSucceeded() {
+	c.mu.Lock()
+	c.retryDelay = 0
+	c.mu.Unlock()
+}
+
+// grpcStatus returns the error for a non-OK status. The
+// status is in the headers if the response has no body.
+func grpcStatus(res *http.Response) error {
+	status := res.Trailer.Get("grpc-status")
+	message := res.Trailer.Get("grpc-message")
+	if status == "" {
+		status = res.Header.Get("grpc-status")
+		message = res.Header.Get("grpc-message")
+	}
+
+	switch status {
+	case "", grpcStatusOK:
+		return nil
+	case grpcStatusDeadlineExceeded:
+		return context.DeadlineExceeded
+	}
+
+	if m, err := url.PathUnescape(message); err == nil {
+		message = m
+	}
+	return fmt.Errorf("%w: grpc status %v: %v", errDoods, status, message)
+}
+
+// readGRPCMessage reads a single length-prefixed message.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, fmt.Errorf("%w: compressed message", errDoods)
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > grpcMaxMessageSize {
+		return nil, fmt.Errorf("%w: %v bytes", errGRPCMessageTooLarge, size)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// encodeGRPCTimeout encodes the "grpc-timeout" header value.
+func encodeGRPCTimeout(d time.Duration) string {
+	const maxValue = 99999999
+	if d <= 0 {
+		return "1n"
+	}
+	if ms := d.Milliseconds(); ms < maxValue {
+		return strconv.FormatInt(ms+1, 10) + "m"
+	}
+	return strconv.FormatInt(int64(d.Seconds())+1, 10) + "S"
+}
@@ -0,0 +1,283 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package doods
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(msg)))
+	w.Write(append(header, msg...)) //nolint:errcheck
+}
+
+func marshalDetectResponse(r detectResponse) []byte {
+	var b []byte
+	b = appendProtoString(b, 1, r.ID)
+	for _, d := range r.Detections {
+		var det []byte
+		det = appendProtoFloat(det, 1, d.Top)
+		det = appendProtoFloat(det, 2, d.Left)
+		det = appendProtoFloat(det, 3, d.Bottom)
+		det = appendProtoFloat(det, 4, d.Right)
+		det = appendProtoString(det, 5, d.Label)
+		det = appendProtoFloat(det, 6, d.Confidence)
+		b = appendProtoBytes(b, 2, det)
+	}
+	return appendProtoString(b, 3, r.ServerError)
+}
+
+func marshalDetectors(list detectors) []byte {
+	var b []byte
+	for _, d := range list {
+		var det []byte
+		det = appendProtoString(det, 1, d.Name)
+		det = appendProtoString(det, 3, d.Model)
+		for _, label := range d.Labels {
+			det = appendProtoString(det, 4, label)
+		}
+		det = appendProtoInt32(det, 5, d.Width)
+		det = appendProtoInt32(det, 6, d.Height)
+		b = appendProtoBytes(b, 1, det)
+	}
+	return b
+}
+
+// newTestGRPCServer starts a cleartext HTTP/2 server.
+// The handler receives the decoded request fields.
+func newTestGRPCServer(
+	t *testing.T,
+	handler func(w http.ResponseWriter, r *http.Request, msg []byte),
+) (*grpcClient, func()) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "application/grpc", r.Header.Get("Content-Type"))
+		msg, err := readGRPCMessage(r.Body)
+		if errors.Is(err, io.EOF) {
+			msg = []byte{}
+		} else {
+			require.NoError(t, err)
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		handler(w, r, msg)
+	})
+
+	server := httptest.NewServer(h2c.NewHandler(h, &http2.Server{}))
+	c := newGRPCClient(logf, strings.TrimPrefix(server.URL, "http://"))
+	return c, server.Close
+}
+
+func TestGRPCClient(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		c, cancel := newTestGRPCServer(t, func(w http.ResponseWriter, r *http.Request, msg []byte) {
+			require.Equal(t, "/odrpc.odrpc/Detect", r.URL.Path)
+			data := []byte{1, 2, 3}
+			expected := detectRequest{
+				DetectorName: "x",
+				Data:         &data,
+				Detect:       thresholds{"a": 0.5, "b": 1},
+			}.marshalProto()
+			require.Equal(t, expected, msg)
+
+			response := detectResponse{
+				Detections: detections{{Top: 0.1, Label: "a", Confidence: 0.75}},
+			}
+			writeGRPCMessage(w, marshalDetectResponse(response))
+			w.Header().Set("Grpc-Status", "0")
+		})
+		defer cancel()
+
+		data := []byte{1, 2, 3}
+		d, err := c.sendRequest(context.Background(), detectRequest{
+			DetectorName: "x",
+			Data:         &data,
+			Detect:       thresholds{"b": 1, "a": 0.5},
+		})
+		require.NoError(t, err)
+		require.Equal(t, &detections{{Top: 0.1, Label: "a", Confidence: 0.75}}, d)
+	})
+	t.Run("timeout", func(t *testing.T) {
+		c, cancel := newTestGRPCServer(t, func(w http.ResponseWriter, r *http.Request, _ []byte) {
+			require.NotEmpty(t, r.Header.Get("grpc-timeout"))
+			<-r.Context().Done()
+		})
+		defer cancel()
+
+		ctx, cancel2 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel2()
+
+		_, err := c.sendRequest(ctx, detectRequest{})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+	t.Run("deadlineExceededStatus", func(t *testing.T) {
+		c, cancel := newTestGRPCServer(t, func(w http.ResponseWriter, _ *http.Request, _ []byte) {
+			w.Header().Set("Grpc-Status", "4")
+		})
+		defer cancel()
+
+		_, err := c.sendRequest(context.Background(), detectRequest{})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+	t.Run("statusErr", func(t *testing.T) {
+		c, cancel := newTestGRPCServer(t, func(w http.ResponseWriter, _ *http.Request, _ []byte) {
+			w.Header().Set("Grpc-Status", "13")
+			w.Header().Set("Grpc-Message", "a%20b")
+		})
+		defer cancel()
+
+		_, err := c.sendRequest(context.Background(), detectRequest{})
+		require.ErrorIs(t, err, errDoods)
+		require.Contains(t, err.Error(), "a b")
+	})
+	t.Run("serverErr", func(t *testing.T) {
+		c, cancel := newTestGRPCServer(t, func(w http.ResponseWriter, _ *http.Request, _ []byte) {
+			writeGRPCMessage(w, marshalDetectResponse(detectResponse{ServerError: "x"}))
+			w.Header().Set("Grpc-Status", "0")
+		})
+		defer cancel()
+
+		_, err := c.sendRequest(context.Background(), detectRequest{})
+		require.ErrorIs(t, err, errDoods)
+	})
+	t.Run("reconnect", func(t *testing.T) {
+		c, cancel := newTestGRPCServer(t, func(w http.ResponseWriter, _ *http.Request, _ []byte) {
+			writeGRPCMessage(w, marshalDetectResponse(detectResponse{}))
+			w.Header().Set("Grpc-Status", "0")
+		})
+		defer cancel()
+
+		now := time.Unix(1, 0)
+		c.now = func() time.Time { return now }
+
+		dial := c.dial
+		dialErr := errors.New("mock")
+		c.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return nil, dialErr
+		}
+
+		_, err := c.sendRequest(context.Background(), detectRequest{})
+		require.ErrorIs(t, err, dialErr)
+
+		// Server is back but the client is waiting to retry.
+		c.dial = dial
+		_, err = c.sendRequest(context.Background(), detectRequest{})
+		require.ErrorIs(t, err, errGRPCUnavailable)
+
+		now = now.Add(c.retryMin)
+		_, err = c.sendRequest(context.Background(), detectRequest{})
+		require.NoError(t, err)
+	})
+	t.Run("backoff", func(t *testing.T) {
+		c, cancel := newTestGRPCServer(t, func(w http.ResponseWriter, _ *http.Request, _ []byte) {
+			writeGRPCMessage(w, marshalDetectResponse(detectResponse{}))
+			w.Header().Set("Grpc-Status", "0")
+		})
+		defer cancel()
+
+		now := time.Unix(1, 0)
+		c.now = func() time.Time { return now }
+		c.retryMin = 1 * time.Second
+		c.retryMax = 5 * time.Second
+
+		dial := c.dial
+		dialErr := errors.New("mock")
+		c.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return nil, dialErr
+		}
+
+		// failAfter waits the expected delay and fails again.
+		failAfter := func(delay time.Duration) {
+			t.Helper()
+			now = now.Add(delay - time.Millisecond)
+			_, err := c.sendRequest(context.Background(), detectRequest{})
+			require.ErrorIs(t, err, errGRPCUnavailable)
+
+			now = now.Add(time.Millisecond)
+			_, err = c.sendRequest(context.Background(), detectRequest{})
+			require.ErrorIs(t, err, dialErr)
+		}
+
+		_, err := c.sendRequest(context.Background(), detectRequest{})
+		require.ErrorIs(t, err, dialErr)
+		failAfter(1 * time.Second)
+		failAfter(2 * time.Second)
+		failAfter(4 * time.Second)
+		failAfter(5 * time.Second)
+		failAfter(5 * time.Second)
+
+		// A successful request resets the delay.
+		c.dial = dial
+		now = now.Add(5 * time.Second)
+		_, err = c.sendRequest(context.Background(), detectRequest{})
+		require.NoError(t, err)
+
+		c.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return nil, dialErr
+		}
+		c.httpClient.CloseIdleConnections()
+		_, err = c.sendRequest(context.Background(), detectRequest{})
+		require.ErrorIs(t, err, dialErr)
+		failAfter(1 * time.Second)
+	})
+	t.Run("messageTooLarge", func(t *testing.T) {
+		c, cancel := newTestGRPCServer(t, func(w http.ResponseWriter, _ *http.Request, _ []byte) {
+			header := make([]byte, 5)
+			binary.BigEndian.PutUint32(header[1:], 0xffffffff)
+			w.Write(header) //nolint:errcheck
+			w.Header().Set("Grpc-Status", "0")
+		})
+		defer cancel()
+
+		_, err := c.sendRequest(context.Background(), detectRequest{})
+		require.ErrorIs(t, err, errGRPCMessageTooLarge)
+	})
+	t.Run("fetchDetectors", func(t *testing.T) {
+		c, cancel := newTestGRPCServer(t, func(w http.ResponseWriter, r *http.Request, msg []byte) {
+			require.Equal(t, "/odrpc.odrpc/GetDetectors", r.URL.Path)
+			require.Empty(t, msg)
+			writeGRPCMessage(w, marshalDetectors(testDetectors))
+			w.Header().Set("Grpc-Status", "0")
+		})
+		defer cancel()
+
+		d, err := c.fetchDetectors()
+		require.NoError(t, err)
+		require.Equal(t, testDetectors, d)
+	})
+}
+
+func TestEncodeGRPCTimeout(t *testing.T) {
+	require.Equal(t, "1n", encodeGRPCTimeout(0))
+	require.Equal(t, "1001m", encodeGRPCTimeout(time.Second))
+	require.Equal(t, "100000001S", encodeGRPCTimeout(100000000*time.Second))
+}
+
+func TestParseProtoErrors(t *testing.T) {
+	cases := map[string][]byte{
+		"tag":       {0x80},
+		"length":    {0x0a, 0x05, 0x01},
+		"fixed32":   {0x0d, 0x01},
+		"wireType":  {0x0b},
+		"detection": {0x12, 0x01, 0x80},
+	}
+	for name, b := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := unmarshalDetectResponse(b)
+			require.ErrorIs(t, err, errInvalidProto)
+		})
+	}
+}
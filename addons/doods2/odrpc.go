@@ -0,0 +1,214 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package doods
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Protobuf encoding of the messages in odrpc.proto.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func appendTag(b []byte, num int, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(wireType))
+}
+
+func appendProtoBytes(b []byte, num int, v []byte) []byte {
+	b = appendTag(b, num, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendProtoString(b []byte, num int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	return appendProtoBytes(b, num, []byte(v))
+}
+
+func appendProtoFloat(b []byte, num int, v float32) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendTag(b, num, wireFixed32)
+	return binary.LittleEndian.AppendUint32(b, math.Float32bits(v))
+}
+
+func appendProtoInt32(b []byte, num int, v int32) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendTag(b, num, wireVarint)
+	return binary.AppendUvarint(b, uint64(int64(v)))
+}
+
+type protoField struct {
+	num      int
+	wireType int
+	varint   uint64 // Also holds fixed32 and fixed64 values.
+	bytes    []byte
+}
+
+func (f protoField) float() float32 {
+	return math.Float32frombits(uint32(f.varint))
+}
+
+var errInvalidProto = errors.New("invalid protobuf message")
+
+// parseProto calls fn for every field in the message.
+func parseProto(b []byte, fn func(protoField) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("%w: tag", errInvalidProto)
+		}
+		b = b[n:]
+
+		f := protoField{num: int(tag >> 3), wireType: int(tag & 7)}
+		switch f.wireType {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				return fmt.Errorf("%w: varint", errInvalidProto)
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return fmt.Errorf("%w: fixed64", errInvalidProto)
+			}
+			f.varint = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return fmt.Errorf("%w: length", errInvalidProto)
+			}
+			f.bytes = b[n : n+int(size)]
+			b = b[n+int(size):]
+		case wireFixed32:
+			if len(b) < 4 {
+				return fmt.Errorf("%w: fixed32", errInvalidProto)
+			}
+			f.varint = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		default:
+			return fmt.Errorf("%w: wire type %v", errInvalidProto, f.wireType)
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r detectRequest) marshalProto() []byte {
+	var b []byte
+	b = appendProtoString(b, 1, r.ID)
+	b = appendProtoString(b, 2, r.DetectorName)
+	if r.Data != nil {
+		b = appendProtoBytes(b, 3, *r.Data)
+	}
+
+	// Sorted for deterministic output.
+	labels := make([]string, 0, len(r.Detect))
+	for label := range r.Detect {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		var entry []byte
+		entry = appendProtoString(entry, 1, label)
+		entry = appendProtoFloat(entry, 2, float32(r.Detect[label]))
+		b = appendProtoBytes(b, 5, entry)
+	}
+	return b
+}
+
+func unmarshalDetectResponse(b []byte) (detectResponse, error) {
+	var res detectResponse
+	err := parseProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			res.ID = string(f.bytes)
+		case 2:
+			d, err := unmarshalDetection(f.bytes)
+			if err != nil {
+				return fmt.Errorf("detection: %w", err)
+			}
+			res.Detections = append(res.Detections, d)
+		case 3:
+			res.ServerError = string(f.bytes)
+		}
+		return nil
+	})
+	return res, err
+}
+
+func unmarshalDetection(b []byte) (Detection, error) {
+	var d Detection
+	err := parseProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			d.Top = f.float()
+		case 2:
+			d.Left = f.float()
+		case 3:
+			d.Bottom = f.float()
+		case 4:
+			d.Right = f.float()
+		case 5:
+			d.Label = string(f.bytes)
+		case 6:
+			d.Confidence = f.float()
+		}
+		return nil
+	})
+	return d, err
+}
+
+func unmarshalGetDetectorsResponse(b []byte) (detectors, error) {
+	var list detectors
+	err := parseProto(b, func(f protoField) error {
+		if f.num != 1 {
+			return nil
+		}
+		d, err := unmarshalDetector(f.bytes)
+		if err != nil {
+			return fmt.Errorf("detector: %w", err)
+		}
+		list = append(list, d)
+		return nil
+	})
+	return list, err
+}
+
+func unmarshalDetector(b []byte) (detector, error) {
+	var d detector
+	err := parseProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			d.Name = string(f.bytes)
+		case 3:
+			d.Model = string(f.bytes)
+		case 4:
+			d.Labels = append(d.Labels, string(f.bytes))
+		case 5:
+			d.Width = int32(f.varint)
+		case 6:
+			d.Height = int32(f.varint)
+		}
+		return nil
+	})
+	return d, err
+}
@@ -0,0 +1,258 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package doods
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"nvr/pkg/log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ServerConfig a single DOODS server.
+type ServerConfig struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+
+	// Priority servers with a higher priority are tried first
+	// when the selected server of a monitor is unavailable.
+	Priority int `json:"priority"`
+}
+
+// Errors.
+var (
+	errNoServers         = errors.New("no servers")
+	errServerNoName      = errors.New("server without name")
+	errServerNoIP        = errors.New("server without ip")
+	errServerDuplicate   = errors.New("duplicate server name")
+	errNoServerForRoute  = errors.New("no available server with detector")
+	errNoServerConnected = errors.New("no connected server")
+)
+
+const defaultServerName = "default"
+
+// servers returns the configured servers. The "ip" field is used as
+// a single server named "default" if the servers list is empty.
+func (c Config) servers() ([]ServerConfig, error) {
+	if len(c.Servers) == 0 {
+		if c.IP == "" {
+			return nil, errNoServers
+		}
+		return []ServerConfig{{Name: defaultServerName, IP: c.IP}}, nil
+	}
+
+	names := make(map[string]struct{}, len(c.Servers))
+	for _, s := range c.Servers {
+		if s.Name == "" {
+			return nil, errServerNoName
+		}
+		if s.IP == "" {
+			return nil, fmt.Errorf("%w: %q", errServerNoIP, s.Name)
+		}
+		if _, exist := names[s.Name]; exist {
+			return nil, fmt.Errorf("%w: %q", errServerDuplicate, s.Name)
+		}
+		names[s.Name] = struct{}{}
+	}
+	return c.Servers, nil
+}
+
+// doodsServer a DOODS server and its client.
+type doodsServer struct {
+	name     string
+	ip       string
+	priority int
+
+	sendRequest    sendRequestFunc
+	fetchDetectors func() (detectors, error)
+	connected      func() bool
+
+	mu        sync.Mutex
+	detectors detectors
+}
+
+func (s *doodsServer) setDetectors(d detectors) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.detectors = d
+}
+
+// hasDetector returns true if the server has a detector with the
+// same name and dimensions. The ffmpeg output is scaled to the
+// dimensions, so a detector with other dimensions can't be used.
+func (s *doodsServer) hasDetector(d detector) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, detector := range s.detectors {
+		if detector.Name == d.Name &&
+			detector.Width == d.Width &&
+			detector.Height == d.Height {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *doodsServer) available(d detector) bool {
+	return s.connected() && s.hasDetector(d)
+}
+
+// serverRouter routes detect requests to the selected server of each
+// monitor and fails over to the other servers by priority when
+// the selected server is disconnected. Requests are routed back
+// to the selected server as soon as it's connected again.
+type serverRouter struct {
+	// Ordered as configured, the first server is the default.
+	servers []*doodsServer
+
+	// Failover order.
+	byPriority []*doodsServer
+
+	logf            log.Func
+	refreshInterval time.Duration
+}
+
+func newServerRouter(servers []*doodsServer, logf log.Func) *serverRouter {
+	byPriority := make([]*doodsServer, len(servers))
+	copy(byPriority, servers)
+	sort.SliceStable(byPriority, func(i, j int) bool {
+		return byPriority[i].priority > byPriority[j].priority
+	})
+	return &serverRouter{
+		servers:         servers,
+		byPriority:      byPriority,
+		logf:            logf,
+		refreshInterval: 1 * time.Minute,
+	}
+}
+
+// serverByName returns the default server if name is empty.
+func (r *serverRouter) serverByName(name string) (*doodsServer, bool) {
+	if len(r.servers) == 0 {
+		return nil, false
+	}
+	if name == "" {
+		return r.servers[0], true
+	}
+	for _, s := range r.servers {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// route returns the server that should handle the next request.
+func (r *serverRouter) route(selected *doodsServer, d detector) (*doodsServer, error) {
+	if selected.available(d) {
+		return selected, nil
+	}
+	for _, s := range r.byPriority {
+		if s != selected && s.available(d) {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %v", errNoServerForRoute, d.Name)
+}
+
+// sendRequestFunc returns the send function for a single monitor.
+// The returned function must not be called concurrently.
+func (r *serverRouter) sendRequestFunc(
+	serverName string,
+	d detector,
+	logf log.Func,
+) (sendRequestFunc, error) {
+	selected, exist := r.serverByName(serverName)
+	if !exist {
+		return nil, fmt.Errorf("server: %q %w", serverName, os.ErrNotExist)
+	}
+
+	current := selected
+	return func(ctx context.Context, request detectRequest) (*detections, error) {
+		s, err := r.route(selected, d)
+		if err != nil {
+			return nil, err
+		}
+		if s != current {
+			if s == selected {
+				logf(log.LevelInfo, "server %q is back, routing requests to it", s.name)
+			} else {
+				logf(log.LevelWarning, "server %q is unavailable, routing requests to %q",
+					selected.name, s.name)
+			}
+			current = s
+		}
+		return s.sendRequest(ctx, request)
+	}, nil
+}
+
+// health is the readiness check of the addon, fails if no server is connected.
+func (r *serverRouter) health() error {
+	for _, s := range r.servers {
+		if s.connected() {
+			return nil
+		}
+	}
+	return errNoServerConnected
+}
+
+// fetchDetectors fetches the detectors from every server once.
+// Returns the combined list, the first detector with a name wins.
+func (r *serverRouter) fetchDetectors() (detectors, error) {
+	var all detectors
+	names := make(map[string]struct{})
+	var errs []error
+	var failed []*doodsServer
+	for _, s := range r.servers {
+		d, err := s.fetchDetectors()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v %v: %w", s.name, s.ip, err))
+			failed = append(failed, s)
+			continue
+		}
+		s.setDetectors(d)
+		for _, detector := range d {
+			if _, exist := names[detector.Name]; exist {
+				continue
+			}
+			names[detector.Name] = struct{}{}
+			all = append(all, detector)
+		}
+	}
+	if len(errs) == len(r.servers) {
+		return nil, errors.Join(errs...)
+	}
+	for i, s := range failed {
+		r.logf(log.LevelWarning, "could not fetch detectors: %v", errs[i], log.F("server", s.name))
+	}
+	return all, nil
+}
+
+// refresh fetches the detector list of every server, the
+// previous list is kept if the server can't be reached.
+func (r *serverRouter) refresh() {
+	for _, s := range r.servers {
+		d, err := s.fetchDetectors()
+		if err != nil {
+			r.logf(log.LevelDebug, "could not refresh detectors: %v", err, log.F("server", s.name))
+			continue
+		}
+		s.setDetectors(d)
+	}
+}
+
+func (r *serverRouter) refreshLoop(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.refreshInterval):
+			r.refresh()
+		}
+	}
+}
@@ -0,0 +1,315 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package doods
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigServers(t *testing.T) {
+	t.Run("legacyIP", func(t *testing.T) {
+		servers, err := Config{IP: "a:8080"}.servers()
+		require.NoError(t, err)
+		require.Equal(t, []ServerConfig{{Name: "default", IP: "a:8080"}}, servers)
+	})
+	t.Run("list", func(t *testing.T) {
+		c := Config{
+			IP: "ignored:8080",
+			Servers: []ServerConfig{
+				{Name: "a", IP: "a:8080"},
+				{Name: "b", IP: "b:8080", Priority: 1},
+			},
+		}
+		servers, err := c.servers()
+		require.NoError(t, err)
+		require.Equal(t, c.Servers, servers)
+	})
+	testCases := map[string]struct {
+		config      Config
+		expectedErr error
+	}{
+		"empty":  {Config{}, errNoServers},
+		"noName": {Config{Servers: []ServerConfig{{IP: "a"}}}, errServerNoName},
+		"noIP":   {Config{Servers: []ServerConfig{{Name: "a"}}}, errServerNoIP},
+		"duplicate": {
+			Config{Servers: []ServerConfig{{Name: "a", IP: "a"}, {Name: "a", IP: "b"}}},
+			errServerDuplicate,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := tc.config.servers()
+			require.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func newStubServer(name string, priority int, connected bool, d ...detector) *doodsServer {
+	return &doodsServer{
+		name:      name,
+		priority:  priority,
+		connected: func() bool { return connected },
+		detectors: d,
+	}
+}
+
+func TestServerRouterRoute(t *testing.T) {
+	d1 := detector{Name: "1", Width: 300, Height: 300}
+	d2 := detector{Name: "2", Width: 300, Height: 300}
+	d1Large := detector{Name: "1", Width: 600, Height: 600}
+
+	testCases := map[string]struct {
+		servers  []*doodsServer
+		detector detector
+		expected string
+	}{
+		"selected": {
+			[]*doodsServer{
+				newStubServer("a", 0, true, d1),
+				newStubServer("b", 1, true, d1),
+			},
+			d1, "a",
+		},
+		"priority": {
+			[]*doodsServer{
+				newStubServer("a", 0, false, d1),
+				newStubServer("b", 1, true, d1),
+				newStubServer("c", 2, true, d1),
+			},
+			d1, "c",
+		},
+		"equalPriority": {
+			[]*doodsServer{
+				newStubServer("a", 0, false, d1),
+				newStubServer("b", 1, true, d1),
+				newStubServer("c", 1, true, d1),
+			},
+			d1, "b",
+		},
+		"mixedDetectors": {
+			[]*doodsServer{
+				newStubServer("a", 0, false, d1),
+				newStubServer("b", 2, true, d2),
+				newStubServer("c", 1, true, d1, d2),
+			},
+			d1, "c",
+		},
+		"dimensions": {
+			[]*doodsServer{
+				newStubServer("a", 0, false, d1),
+				newStubServer("b", 2, true, d1Large),
+				newStubServer("c", 1, true, d1),
+			},
+			d1, "c",
+		},
+		"selectedMissingDetector": {
+			[]*doodsServer{
+				newStubServer("a", 0, true, d2),
+				newStubServer("b", 0, true, d1),
+			},
+			d1, "b",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			r := newServerRouter(tc.servers, logf)
+			s, err := r.route(tc.servers[0], tc.detector)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, s.name)
+		})
+	}
+	t.Run("noServer", func(t *testing.T) {
+		servers := []*doodsServer{
+			newStubServer("a", 0, false, d1),
+			newStubServer("b", 0, true, d2),
+		}
+		r := newServerRouter(servers, logf)
+		_, err := r.route(servers[0], d1)
+		require.ErrorIs(t, err, errNoServerForRoute)
+	})
+}
+
+func TestServerRouterSendRequestFunc(t *testing.T) {
+	r := newServerRouter([]*doodsServer{
+		newStubServer("a", 0, true),
+		newStubServer("b", 0, true),
+	}, logf)
+
+	t.Run("unknownServer", func(t *testing.T) {
+		_, err := r.sendRequestFunc("x", detector{}, logf)
+		require.Error(t, err)
+	})
+	t.Run("default", func(t *testing.T) {
+		s, exist := r.serverByName("")
+		require.True(t, exist)
+		require.Equal(t, "a", s.name)
+	})
+}
+
+func TestServerRouterRefresh(t *testing.T) {
+	fetchErr := false
+	s := &doodsServer{
+		name:      "a",
+		connected: func() bool { return true },
+		fetchDetectors: func() (detectors, error) {
+			if fetchErr {
+				return nil, context.Canceled
+			}
+			return detectors{{Name: "1"}}, nil
+		},
+	}
+	r := newServerRouter([]*doodsServer{s}, logf)
+
+	r.refresh()
+	require.True(t, s.hasDetector(detector{Name: "1"}))
+
+	// The previous list is kept.
+	fetchErr = true
+	r.refresh()
+	require.True(t, s.hasDetector(detector{Name: "1"}))
+}
+
+func TestServerRouterHealth(t *testing.T) {
+	r := newServerRouter([]*doodsServer{
+		newStubServer("a", 0, false),
+		newStubServer("b", 0, true),
+	}, logf)
+	require.NoError(t, r.health())
+
+	r = newServerRouter([]*doodsServer{
+		newStubServer("a", 0, false),
+		newStubServer("b", 0, false),
+	}, logf)
+	require.ErrorIs(t, r.health(), errNoServerConnected)
+}
+
+// fakeServer a websocket DOODS server that can be taken offline.
+type fakeServer struct {
+	ip     string
+	online atomic.Bool
+
+	mu    sync.Mutex
+	conns []*websocket.Conn
+}
+
+func newFakeServer(t *testing.T, name string) *fakeServer {
+	s := &fakeServer{}
+	s.online.Store(true)
+
+	detect := func(w http.ResponseWriter, r *http.Request) {
+		if !s.online.Load() {
+			http.Error(w, "offline", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := new(websocket.Upgrader).Upgrade(w, r, nil)
+		require.NoError(t, err)
+
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+
+		go func() {
+			for {
+				var request detectRequest
+				if err := conn.ReadJSON(&request); err != nil {
+					return
+				}
+				response := detectResponse{
+					ID:         request.ID,
+					Detections: detections{{Label: name}},
+				}
+				if err := conn.WriteJSON(response); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(detect))
+	t.Cleanup(server.Close)
+	s.ip = strings.TrimPrefix(server.URL, "http://")
+	return s
+}
+
+func (s *fakeServer) setOnline(online bool) {
+	s.online.Store(online)
+	if online {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func TestServerFailover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	d := detector{Name: "1", Width: 300, Height: 300}
+	newServer := func(name string, priority int) (*fakeServer, *client, *doodsServer) {
+		fake := newFakeServer(t, name)
+		c := newClient(ctx, wg, logf, fake.ip, false)
+		c.warmup = 0
+		c.retrySleep = 10 * time.Millisecond
+
+		wg.Add(1)
+		go c.start()
+
+		s := &doodsServer{
+			name:        name,
+			priority:    priority,
+			sendRequest: c.sendRequest,
+			connected:   c.isConnected,
+			detectors:   detectors{d},
+		}
+		return fake, c, s
+	}
+	fakeA, clientA, serverA := newServer("a", 0)
+	_, clientB, serverB := newServer("b", 1)
+
+	r := newServerRouter([]*doodsServer{serverA, serverB}, logf)
+	send, err := r.sendRequestFunc("a", d, logf)
+	require.NoError(t, err)
+
+	require.Eventually(t, clientA.isConnected, time.Second, time.Millisecond)
+	require.Eventually(t, clientB.isConnected, time.Second, time.Millisecond)
+
+	sendRequest := func() string {
+		ctx2, cancel2 := context.WithTimeout(ctx, time.Second)
+		defer cancel2()
+		detections, err := send(ctx2, detectRequest{DetectorName: d.Name})
+		require.NoError(t, err)
+		return (*detections)[0].Label
+	}
+	require.Equal(t, "a", sendRequest())
+
+	// Server goes down, requests shift to the next server.
+	fakeA.setOnline(false)
+	require.Eventually(t, func() bool {
+		return !clientA.isConnected()
+	}, time.Second, time.Millisecond)
+	require.Equal(t, "b", sendRequest())
+	require.Equal(t, "b", sendRequest())
+
+	// Server comes back, requests shift back.
+	fakeA.setOnline(true)
+	require.Eventually(t, clientA.isConnected, time.Second, time.Millisecond)
+	require.Equal(t, "a", sendRequest())
+}
@@ -1,23 +1,24 @@
 // SPDX-License-Identifier: GPL-2.0-or-later
 
-import Hls from "./static/scripts/vendor/hls.mjs";
-import { uniqueID } from "./static/scripts/libs/common.mjs";
+import Hls from "../scripts/vendor/hls.mjs";
+import { uniqueID } from "../scripts/libs/common.mjs";
+import { newForm, fieldTemplate } from "../scripts/components/form.mjs";
+import { newFeed } from "../scripts/components/feed.mjs";
+import { newModal } from "../scripts/components/modal.mjs";
 import {
-	newForm,
-	newField,
-	inputRules,
-	fieldTemplate,
-} from "./static/scripts/components/form.mjs";
-import { newFeed } from "./static/scripts/components/feed.mjs";
-import { newModal } from "./static/scripts/components/modal.mjs";
+	newPolygonEditor,
+	renderPolygons,
+} from "../scripts/components/polygonEditor.mjs";
 
-const Detectors = JSON.parse(`$detectorsJSON`);
+// Globals.
+const Detectors = DoodsDetectors; // eslint-disable-line no-undef
+const ServerNames = DoodsServers; // eslint-disable-line no-undef
 
 export function doods() {
-	return _doods(Hls, Detectors);
+	return _doods(Hls, Detectors, ServerNames);
 }
 
-function _doods(hls, detectors) {
+function _doods(hls, detectors, serverNames) {
 	let detectorNames = [];
 	for (const detector of Detectors) {
 		detectorNames.push(detector.name);
@@ -28,6 +29,7 @@ function _doods(hls, detectors) {
 		thresholds: thresholds(detectors),
 		crop: crop(hls, detectors),
 		mask: mask(hls),
+		zones: zones(hls),
 		minSize: fieldTemplate.text("Minimum size %", "0", "0"),
 		maxSize: fieldTemplate.text("Maximum size %", "100", "100"),
 		detectorName: fieldTemplate.select(
@@ -35,21 +37,18 @@ function _doods(hls, detectors) {
 			detectorNames,
 			detectorNames.at(-1), // Last item.
 		),
-		feedRate: newField(
-			[inputRules.notEmpty, inputRules.noSpaces],
-			{
-				errorField: true,
-				input: "number",
-				min: "0",
-			},
-			{
-				label: "Feed rate (fps)",
-				placeholder: "",
-				initial: "0.2",
-			},
+		server: fieldTemplate.select(
+			"Server",
+			serverNames,
+			serverNames[0], // Default server.
 		),
+		feedRate: fieldTemplate.feedRate("Feed rate (fps)", "0.2"),
+		throttleThreshold: fieldTemplate.integer("Throttle threshold (%)", "", "80"),
+		changeThreshold: fieldTemplate.text("Change threshold (%)", "0", "0"),
+		changeMaxInterval: fieldTemplate.integer("Change max interval (sec)", "", "10"),
 		duration: fieldTemplate.integer("Trigger duration (sec)", "", "120"),
 		useSubStream: fieldTemplate.toggle("Use sub stream", "true"),
+		annotate: fieldTemplate.toggle("Annotate recordings", "false"),
 		preview: preview(),
 	};
 
@@ -518,7 +517,7 @@ function crop(hls, detectors) {
 				.querySelector(".form-field-edit-btn")
 				.addEventListener("click", () => {
 					const subInputEnabled =
-						monitorFields.subInput.value() === "" ? "" : "true";
+						monitorFields.subStream.value() === "" ? "" : "true";
 					const monitor = {
 						id: monitorFields.id.value(),
 						audioEnabled: "false",
@@ -548,7 +547,7 @@ function crop(hls, detectors) {
 function mask(hls) {
 	let fields = {};
 	let value = {};
-	let $enable, $overlay, $points, $modalContent, $feed;
+	let $enable, $overlay, $modalContent, $feed, editor;
 
 	const modal = newModal("Mask");
 
@@ -575,7 +574,7 @@ function mask(hls) {
 					></svg>
 				</div>
 			</li>
-			<li class="js-points form-field doodsMask-points-grid"></li>`;
+			<li class="js-points form-field polygon-editor-grid"></li>`;
 
 		$modalContent = modal.init(element);
 		$modalContent.innerHTML = html;
@@ -587,90 +586,22 @@ function mask(hls) {
 		});
 
 		$overlay = $modalContent.querySelector(".js-doods-overlay");
-		$points = $modalContent.querySelector(".js-points");
+		editor = newPolygonEditor(
+			$modalContent.querySelector(".js-points"),
+			renderPreview,
+		);
 
 		renderValue();
 		renderPreview();
 	};
 
 	const renderPreview = () => {
-		let points = "";
-		for (const p of value.area) {
-			points += p[0] + "," + p[1] + " ";
-		}
-		$overlay.innerHTML = `
-				<polygon
-					style="fill: black;"
-					points="${points}"
-				/>`;
+		$overlay.innerHTML = renderPolygons([value.area]);
 	};
 
 	const renderValue = () => {
 		$enable.value = value.enable;
-
-		let html = "";
-		for (const point of Object.entries(value.area)) {
-			const index = point[0];
-			const [x, y] = point[1];
-			html += `
-				<div class="js-point doodsMask-point">
-					<input
-						class="doodsMask-point-input"
-						type="number"
-						min="0"
-						max="100"
-						value="${x}"
-					/>
-					<span class="doodsMask-point-label">${index}</span>
-					<input
-						class="doodsMask-point-input"
-						type="number"
-						min="0"
-						max="100"
-						value="${y}"
-					/>
-				</div>`;
-		}
-		html += `
-			<div style="display: flex; column-gap: 0.2rem;">
-				<button
-					class="js-plus form-field-edit-btn doodsMask-button"
-					style="margin: 0;"
-				>
-					<img src="static/icons/feather/plus.svg">
-				</button>
-				<button
-					class="js-minus form-field-edit-btn doodsMask-button"
-					style="margin: 0;"
-				>
-					<img src="static/icons/feather/minus.svg">
-				</button>
-			</div>`;
-
-		$points.innerHTML = html;
-
-		for (const element of $points.querySelectorAll(".js-point")) {
-			element.addEventListener("change", () => {
-				const index = element.querySelector("span").innerHTML;
-				const $points = element.querySelectorAll("input");
-				const x = Number.parseInt($points[0].value);
-				const y = Number.parseInt($points[1].value);
-				value.area[index] = [x, y];
-				renderPreview();
-			});
-		}
-
-		$points.querySelector(".js-plus").addEventListener("click", () => {
-			value.area.push([50, 50]);
-			renderValue();
-		});
-		$points.querySelector(".js-minus").addEventListener("click", () => {
-			if (value.area.length > 3) {
-				value.area.pop();
-				renderValue();
-			}
-		});
-
+		editor.set(value.area);
 		renderPreview();
 	};
 
@@ -720,7 +651,202 @@ function mask(hls) {
 			element
 				.querySelector(".form-field-edit-btn")
 				.addEventListener("click", () => {
-					const subInputEnabled = fields.subInput.value() === "" ? "" : "true";
+					const subInputEnabled = fields.subStream.value() === "" ? "" : "true";
+					const monitor = {
+						id: fields.id.value(),
+						audioEnabled: "false",
+						subInputEnabled: subInputEnabled,
+					};
+					feed = newFeed(hls, monitor, true);
+
+					if (rendered) {
+						// Update feed.
+						$feed.innerHTML = feed.html;
+					} else {
+						renderModal(element, feed);
+						modal.onClose(() => {
+							feed.destroy();
+						});
+						rendered = true;
+					}
+
+					modal.open();
+					feed.init($modalContent);
+				});
+		},
+	};
+}
+
+// Named areas that detections are tagged with, used by alert rules.
+function zones(hls) {
+	let fields = {};
+	let value = [];
+	let selected = 0;
+	let $select, $name, $overlap, $overlay, $modalContent, $feed, $settings, editor;
+
+	const modal = newModal("Zones");
+
+	const renderModal = (element, feed) => {
+		const html = `
+			<li class="form-field">
+				<label class="form-field-label">Zone</label>
+				<div class="form-field-select-container">
+					<select class="js-select form-field-select"></select>
+					<button class="js-add form-field-edit-btn color2">
+						<img src="static/icons/feather/plus.svg"/>
+					</button>
+					<button class="js-delete form-field-edit-btn color2">
+						<img src="static/icons/feather/minus.svg"/>
+					</button>
+				</div>
+			</li>
+			<div class="js-settings">
+				<li class="form-field">
+					<label class="form-field-label">Name</label>
+					<input class="js-name settings-input-text" type="text"/>
+				</li>
+				<li class="form-field">
+					<label class="form-field-label">Overlap %, 0 checks the center only</label>
+					<input class="js-overlap settings-input-text" type="number" min="0" max="100"/>
+				</li>
+			</div>
+			<li class="form-field">
+				<label class="form-field-label">Preview</label>
+				<div class="js-preview-wrapper" style="position: relative; margin-top: 0.69rem">
+					<div class="js-feed doodsCrop-preview-feed">${feed.html}</div>
+					<svg
+						class="js-doods-overlay doodsMask-preview-overlay"
+						viewBox="0 0 100 100"
+						preserveAspectRatio="none"
+						style="opacity: 0.5;"
+					></svg>
+				</div>
+			</li>
+			<li class="js-points form-field polygon-editor-grid"></li>`;
+
+		$modalContent = modal.init(element);
+		$modalContent.innerHTML = html;
+		$feed = $modalContent.querySelector(".js-feed");
+		$settings = $modalContent.querySelector(".js-settings");
+
+		$select = $modalContent.querySelector(".js-select");
+		$select.addEventListener("change", () => {
+			selected = Number($select.value);
+			renderValue();
+		});
+		$modalContent.querySelector(".js-add").addEventListener("click", () => {
+			value.push({
+				name: `zone${value.length + 1}`,
+				area: [
+					[25, 25],
+					[75, 25],
+					[75, 75],
+					[25, 75],
+				],
+			});
+			selected = value.length - 1;
+			renderValue();
+		});
+		$modalContent.querySelector(".js-delete").addEventListener("click", () => {
+			value.splice(selected, 1);
+			selected = Math.max(0, selected - 1);
+			renderValue();
+		});
+
+		$name = $modalContent.querySelector(".js-name");
+		$name.addEventListener("change", () => {
+			value[selected].name = $name.value.trim();
+			renderValue();
+		});
+		$overlap = $modalContent.querySelector(".js-overlap");
+		$overlap.addEventListener("change", () => {
+			value[selected].overlap = Number($overlap.value);
+		});
+
+		$overlay = $modalContent.querySelector(".js-doods-overlay");
+		editor = newPolygonEditor(
+			$modalContent.querySelector(".js-points"),
+			renderPreview,
+		);
+
+		renderValue();
+	};
+
+	const renderPreview = () => {
+		const zone = value[selected];
+		$overlay.innerHTML = zone ? renderPolygons([zone.area]) : "";
+	};
+
+	const renderValue = () => {
+		let options = "";
+		for (const [index, zone] of value.entries()) {
+			options += `<option value="${index}">${zone.name}</option>`;
+		}
+		$select.innerHTML = options;
+
+		const zone = value[selected];
+		const $points = $modalContent.querySelector(".js-points");
+		$settings.style.display = zone ? "" : "none";
+		$points.style.display = zone ? "" : "none";
+		if (zone) {
+			$select.value = selected;
+			$name.value = zone.name;
+			$overlap.value = zone.overlap ? zone.overlap : 0;
+			editor.set(zone.area);
+		}
+		renderPreview();
+	};
+
+	let rendered = false;
+	const id = uniqueID();
+
+	return {
+		html: `
+			<li
+				id="${id}"
+				class="form-field"
+				style="display:flex; padding-bottom:0.25rem;"
+			>
+				<label class="form-field-label">Zones</label>
+				<div style="width:auto">
+					<button class="form-field-edit-btn color2">
+						<img src="static/icons/feather/edit-3.svg"/>
+					</button>
+				</div>
+				${modal.html}
+			</li> `,
+
+		value() {
+			return value.length === 0 ? "" : JSON.stringify(value);
+		},
+		set(input, _, f) {
+			fields = f;
+			value = input === "" ? [] : JSON.parse(input);
+			selected = 0;
+			if (rendered) {
+				renderValue();
+			}
+		},
+		validate() {
+			const names = new Set();
+			for (const zone of value) {
+				if (zone.name === "") {
+					return "Zones: name cannot be empty";
+				}
+				if (names.has(zone.name)) {
+					return `Zones: duplicate name '${zone.name}'`;
+				}
+				names.add(zone.name);
+			}
+			return "";
+		},
+		init($parent) {
+			var feed;
+			const element = $parent.querySelector(`#${id}`);
+			element
+				.querySelector(".form-field-edit-btn")
+				.addEventListener("click", () => {
+					const subInputEnabled = fields.subStream.value() === "" ? "" : "true";
 					const monitor = {
 						id: fields.id.value(),
 						audioEnabled: "false",
@@ -748,18 +874,33 @@ function mask(hls) {
 
 function preview() {
 	const id = uniqueID();
-	let element;
+	let element, $status;
 	return {
 		html: `
 			<div style="margin: 0.3rem; margin-bottom: 0;">
 				<img id=${id} style="width: 100%; height: 100%">
+				<span id="${id}-status" class="doods-status"></span>
 			</div>`,
 		init() {
 			element = document.querySelector(`#${id}`);
+			$status = document.querySelector(`#${id}-status`);
 		},
-		set(_, __, monitorFields) {
+		async set(_, __, monitorFields) {
 			const monitorID = monitorFields["id"].value();
 			element.src = `api/doods/preview/${monitorID}?rand=${Math.random()}`;
+
+			// The status doesn't exist until the first frame is detected.
+			$status.textContent = "";
+			const response = await fetch(`api/doods/status/${monitorID}`);
+			if (response.status !== 200) {
+				return;
+			}
+			const s = await response.json();
+			const rate = `${s.effectiveRate}/${s.feedRate} fps`;
+			const frames = s.sentFrames + s.skippedFrames;
+			$status.textContent =
+				`${rate}, latency ${Math.round(s.latencyMs)} ms, ` +
+				`skipped ${s.skippedFrames}/${frames} unchanged frames`;
 		},
 	};
 }
@@ -789,6 +930,10 @@ $style.innerHTML = `
 		width: 1.4rem;
 		height: 100%;
 	}
+	.doods-status {
+		font-size: 0.6rem;
+		color: var(--color-text);
+	}
 
 	/* Crop. */
 	.doodsCrop-preview-feed {
@@ -841,37 +986,6 @@ $style.innerHTML = `
 		height: 100%;
 		width: 100%;
 		top: 0;
-	}
-	.doodsMask-points-grid {
-		display: grid;
-		grid-template-columns: repeat(auto-fit, minmax(3.6rem, 3.7rem));
-		column-gap: 0.1rem;
-		row-gap: 0.1rem;
-	}
-	.doodsMask-point {
-		display: flex;
-		background: var(--color2);
-		padding: 0.15rem;
-		border-radius: 0.15rem;
-	}
-	.doodsMask-point-label {
-		font-size: 0.7rem;
-		color: var(--color-text);
-		margin-left: 0.1rem;
-		margin-right: 0.1rem;
-	}
-	.doodsMask-point-input {
-		text-align: center;
-		font-size: 0.5rem;
-		border-style: none;
-		border-radius: 5px;
-		min-width: 0;
-	}
-	.doodsMask-button {
-		background: var(--color2);
-	}
-	.doodsMask-button:hover {
-		background: var(--color1);
 	}`;
 
 document.querySelector("head").append($style);
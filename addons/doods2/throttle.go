@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package doods
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// Weight of the latest sample in the latency average.
+	throttleAlpha = 0.3
+
+	// The rate is never reduced below 1/8 of the feed rate.
+	throttleMaxSkip = 8
+
+	// The rate is increased one step after this many consecutive samples
+	// where the latency is below the margin of the threshold at that rate.
+	throttleRecoverySamples = 5
+	throttleRecoveryMargin  = 0.8
+)
+
+// throttle reduces the rate of frames sent to the detector when the
+// detector can't keep up. Every skip-th frame is sent, the rest are
+// dropped before they are encoded. Each instance has its own throttle,
+// a overloaded detector only slows down the monitors that use it.
+type throttle struct {
+	interval  time.Duration // Frame interval at the full feed rate.
+	threshold float64       // Fraction of the effective interval.
+
+	latency time.Duration // Exponentially weighted moving average.
+	skip    int
+	frame   int
+	good    int // Consecutive samples below the recovery threshold.
+}
+
+func newThrottle(interval time.Duration, thresholdPercent float64) *throttle {
+	return &throttle{
+		interval:  interval,
+		threshold: thresholdPercent / 100,
+		skip:      1,
+	}
+}
+
+// skipFrame returns true if the next frame should be dropped.
+func (t *throttle) skipFrame() bool {
+	skip := t.frame%t.skip != 0
+	t.frame++
+	return skip
+}
+
+// effectiveInterval returns the interval between sent frames.
+func (t *throttle) effectiveInterval() time.Duration {
+	return t.interval * time.Duration(t.skip)
+}
+
+// update adds a request latency sample to the average, the rate is halved if
+// the average is above the threshold of the effective interval. Returns true
+// if the rate was changed.
+func (t *throttle) update(latency time.Duration) bool {
+	if t.latency == 0 {
+		t.latency = latency
+	} else {
+		t.latency = time.Duration(
+			throttleAlpha*float64(latency) + (1-throttleAlpha)*float64(t.latency))
+	}
+
+	limit := t.threshold * float64(t.interval)
+	switch {
+	case float64(t.latency) > limit*float64(t.skip):
+		t.good = 0
+		if t.skip == throttleMaxSkip {
+			return false
+		}
+		t.skip = min(t.skip*2, throttleMaxSkip)
+		return true
+	case t.skip > 1 && float64(t.latency) < limit*float64(t.skip-1)*throttleRecoveryMargin:
+		t.good++
+		if t.good < throttleRecoverySamples {
+			return false
+		}
+		t.good = 0
+		t.skip--
+		return true
+	default:
+		t.good = 0
+		return false
+	}
+}
+
+// throttleStatus is the status of the throttle served to the user.
+type throttleStatus struct {
+	FeedRate      float64 `json:"feedRate"`
+	EffectiveRate float64 `json:"effectiveRate"`
+	LatencyMs     float64 `json:"latencyMs"`
+	Throttled     bool    `json:"throttled"`
+}
+
+func (t *throttle) status(feedRate float64) throttleStatus {
+	return throttleStatus{
+		FeedRate:      feedRate,
+		EffectiveRate: feedRate / float64(t.skip),
+		LatencyMs:     math.Round(float64(t.latency)/float64(time.Microsecond)) / 1000,
+		Throttled:     t.skip > 1,
+	}
+}
@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package doods
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestThrottle(t *testing.T) {
+	// The limit is 80ms at the full rate.
+	newTestThrottle := func() *throttle {
+		return newThrottle(100*time.Millisecond, 80)
+	}
+	skips := func(th *throttle, latency time.Duration, n int) []int {
+		var skips []int
+		for i := 0; i < n; i++ {
+			th.update(latency)
+			skips = append(skips, th.skip)
+		}
+		return skips
+	}
+
+	t.Run("belowThreshold", func(t *testing.T) {
+		th := newTestThrottle()
+		require.Equal(t, []int{1, 1, 1}, skips(th, 70*time.Millisecond, 3))
+	})
+	t.Run("throttleAndRecover", func(t *testing.T) {
+		th := newTestThrottle()
+
+		// Halved until the latency is below 80% of the effective interval.
+		require.Equal(t, []int{2, 4, 4, 4}, skips(th, 200*time.Millisecond, 4))
+		require.Equal(t, 400*time.Millisecond, th.effectiveInterval())
+
+		// One step after 5 consecutive good samples.
+		require.Equal(t, []int{
+			4, 4, 4, 4, 3,
+			3, 3, 3, 3, 2,
+			2, 2, 2, 2, 1,
+			1, 1,
+		}, skips(th, 20*time.Millisecond, 17))
+	})
+	t.Run("floor", func(t *testing.T) {
+		th := newTestThrottle()
+		require.Equal(t, []int{2, 4, 8, 8, 8}, skips(th, 10*time.Second, 5))
+	})
+	t.Run("recoveryInterrupted", func(t *testing.T) {
+		th := newTestThrottle()
+		skips(th, 200*time.Millisecond, 2)
+		require.Equal(t, 4, th.skip)
+
+		require.Equal(t, []int{4, 4, 4, 4}, skips(th, 20*time.Millisecond, 4))
+		// The latency is above the recovery margin and the count is reset.
+		require.Equal(t, []int{4}, skips(th, 600*time.Millisecond, 1))
+		require.Equal(t, []int{4, 4, 4, 4}, skips(th, 20*time.Millisecond, 4))
+	})
+	t.Run("skipFrame", func(t *testing.T) {
+		th := newTestThrottle()
+		th.skip = 3
+		var skipped []bool
+		for i := 0; i < 7; i++ {
+			skipped = append(skipped, th.skipFrame())
+		}
+		require.Equal(t, []bool{false, true, true, false, true, true, false}, skipped)
+	})
+	t.Run("status", func(t *testing.T) {
+		th := newTestThrottle()
+		th.update(200*time.Millisecond + 1234*time.Microsecond)
+		expected := throttleStatus{
+			FeedRate:      10,
+			EffectiveRate: 5,
+			LatencyMs:     201.234,
+			Throttled:     true,
+		}
+		require.Equal(t, expected, th.status(10))
+	})
+}
+
+func TestRunInstanceThrottle(t *testing.T) {
+	newFeed := func(n int) io.Reader {
+		return bytes.NewReader(bytes.Repeat(frames[:12], n))
+	}
+
+	t.Run("overloaded", func(t *testing.T) {
+		now := time.Time{}
+		requests := 0
+		// Each request takes 2 seconds, the frame interval is 500ms.
+		slowSendRequest := func(context.Context, detectRequest) (*detections, error) {
+			requests++
+			now = now.Add(2 * time.Second)
+			return &detections{{Label: "1"}}, nil
+		}
+		var events []storage.Event
+		spySendEvent := func(e storage.Event) error {
+			events = append(events, e)
+			return nil
+		}
+
+		i := newTestInstance(nil)
+		i.now = func() time.Time { return now }
+		i.sendRequest = slowSendRequest
+		i.sendEvent = spySendEvent
+
+		err := i.runReader(context.Background(), newFeed(20))
+		require.ErrorIs(t, err, io.EOF)
+
+		// Frames 0, 2, 4, 8 and 16.
+		require.Equal(t, 5, requests)
+		require.Equal(t, 4*time.Second, events[len(events)-1].Duration)
+
+		expected := throttleStatus{
+			FeedRate:      2,
+			EffectiveRate: 0.25,
+			LatencyMs:     2000,
+			Throttled:     true,
+		}
+		require.Equal(t, expected, i.previewCache.status[i.c.monitorID].throttleStatus)
+	})
+	t.Run("recover", func(t *testing.T) {
+		now := time.Time{}
+		latencies := []time.Duration{time.Second, time.Second}
+		requests := 0
+		fakeSendRequest := func(context.Context, detectRequest) (*detections, error) {
+			latency := 10 * time.Millisecond
+			if requests < len(latencies) {
+				latency = latencies[requests]
+			}
+			requests++
+			now = now.Add(latency)
+			return &detections{}, nil
+		}
+
+		i := newTestInstance(nil)
+		i.now = func() time.Time { return now }
+		i.sendRequest = fakeSendRequest
+
+		err := i.runReader(context.Background(), newFeed(100))
+		require.ErrorIs(t, err, io.EOF)
+
+		status := i.previewCache.status[i.c.monitorID]
+		require.False(t, status.Throttled)
+		require.Equal(t, float64(2), status.EffectiveRate)
+		require.Less(t, status.LatencyMs, float64(20))
+	})
+	t.Run("perMonitor", func(t *testing.T) {
+		now := time.Time{}
+		cache := newPreviewCache()
+		run := func(monitorID string, latency time.Duration) {
+			i := newTestInstance(nil)
+			i.c.monitorID = monitorID
+			i.previewCache = cache
+			i.now = func() time.Time { return now }
+			i.sendRequest = func(context.Context, detectRequest) (*detections, error) {
+				now = now.Add(latency)
+				return &detections{}, nil
+			}
+			err := i.runReader(context.Background(), newFeed(10))
+			require.ErrorIs(t, err, io.EOF)
+		}
+		run("slow", 2*time.Second)
+		run("fast", 10*time.Millisecond)
+
+		require.True(t, cache.status["slow"].Throttled)
+		require.False(t, cache.status["fast"].Throttled)
+	})
+}
@@ -0,0 +1,342 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"nvr"
+	"nvr/addons/alert"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+var nvrAddon = nvr.RegisterAddon("email", "Send alert emails")
+
+var addon = newSender()
+
+func init() {
+	alert.RegisterAlertHook("email", addon.hook)
+
+	nvr.RegisterLogSource([]string{"email"})
+	nvrAddon.RegisterMonitorConfigKeys("email")
+	nvrAddon.RegisterAppRunHook(func(ctx context.Context, app *nvr.App) error {
+		configPath := filepath.Join(app.Env.ConfigDir, "email.json")
+		config, err := readConfig(configPath)
+		if err != nil {
+			return fmt.Errorf("email: %w", err)
+		}
+		addon.start(ctx, app.WG, config)
+		return nil
+	})
+}
+
+// Errors.
+var (
+	ErrSecurity      = errors.New("invalid security, must be none, starttls or tls")
+	ErrFrom          = errors.New("invalid from address")
+	ErrRecipient     = errors.New("invalid recipient")
+	ErrSubject       = errors.New("invalid subject template")
+	ErrNotConfigured = errors.New("SMTP server is not configured")
+	ErrRateLimited   = errors.New("rate limited")
+	ErrQueueFull     = errors.New("email queue is full")
+)
+
+// Config is the global SMTP config, email is disabled if the host is empty.
+type Config struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+
+	// "none", "starttls" or "tls". STARTTLS is used by default.
+	Security string `json:"security"`
+
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+}
+
+const (
+	securityNone     = "none"
+	securityStartTLS = "starttls"
+	securityTLS      = "tls"
+)
+
+var defaultConfig = Config{
+	Port:     587,
+	Security: securityStartTLS,
+}
+
+func readConfig(configPath string) (*Config, error) {
+	if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+		data, _ := json.MarshalIndent(defaultConfig, "", "    ")
+		if err := os.WriteFile(configPath, data, 0o600); err != nil {
+			return nil, fmt.Errorf("generate config: %w", err)
+		}
+	}
+
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(file, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", err, configPath)
+	}
+	return &config, nil
+}
+
+func (c *Config) validate() error {
+	if c.Host == "" {
+		return nil
+	}
+	switch c.Security {
+	case "":
+		c.Security = securityStartTLS
+	case securityNone, securityStartTLS, securityTLS:
+	default:
+		return fmt.Errorf("%w: %q", ErrSecurity, c.Security)
+	}
+	if c.Port == 0 {
+		c.Port = defaultConfig.Port
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("%w: %q", ErrFrom, c.From)
+	}
+	return nil
+}
+
+// MonitorConfig is the email config of a monitor.
+type MonitorConfig struct {
+	// Comma separated addresses, email is disabled if empty.
+	Recipients string `json:"recipients"`
+
+	// Go text/template over Data.
+	Subject string `json:"subject"`
+
+	// Minimum minutes between emails, applied after the alert cooldown.
+	Cooldown string `json:"cooldown"`
+}
+
+const defaultSubject = "{{ .MonitorName }}: {{ .Label }} detected"
+
+type monitorConfig struct {
+	recipients []*mail.Address
+	subject    *template.Template
+	cooldown   time.Duration
+}
+
+// parseMonitorConfig returns nil if there are no recipients.
+func parseMonitorConfig(rawConfig string) (*monitorConfig, error) {
+	if rawConfig == "" {
+		return nil, nil
+	}
+	var c MonitorConfig
+	if err := json.Unmarshal([]byte(rawConfig), &c); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	if strings.TrimSpace(c.Recipients) == "" {
+		return nil, nil
+	}
+
+	var recipients []*mail.Address
+	for _, rawAddr := range strings.Split(c.Recipients, ",") {
+		if strings.TrimSpace(rawAddr) == "" {
+			continue
+		}
+		addr, err := mail.ParseAddress(rawAddr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrRecipient, strings.TrimSpace(rawAddr))
+		}
+		recipients = append(recipients, addr)
+	}
+
+	if c.Subject == "" {
+		c.Subject = defaultSubject
+	}
+	subject, err := template.New("subject").Parse(c.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSubject, err)
+	}
+
+	var cooldown float64
+	if c.Cooldown != "" {
+		cooldown, err = strconv.ParseFloat(c.Cooldown, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse cooldown: %w", err)
+		}
+	}
+
+	return &monitorConfig{
+		recipients: recipients,
+		subject:    subject,
+		cooldown:   time.Duration(cooldown * float64(time.Minute)),
+	}, nil
+}
+
+// Data is the data available to the subject template.
+type Data struct {
+	MonitorID   string
+	MonitorName string
+	Time        time.Time // In the time zone of the monitor.
+	Detections  []storage.Detection
+
+	// Label and score of the detection with the highest score.
+	Label string
+	Score float64
+
+	// Empty if the monitor isn't recording.
+	RecordingID string
+}
+
+func newData(r *monitor.Recorder, event *storage.Event) Data {
+	loc := time.Local
+	if tz := r.Config.TimeZone(); tz != "" {
+		if l, err := time.LoadLocation(tz); err == nil {
+			loc = l
+		}
+	}
+	data := Data{
+		MonitorID:   r.Config.ID(),
+		MonitorName: r.Config.Name(),
+		Time:        event.Time.In(loc),
+		Detections:  event.Detections,
+		RecordingID: r.RecordingID(),
+	}
+	for _, d := range event.Detections {
+		if d.Score > data.Score {
+			data.Label = d.Label
+			data.Score = d.Score
+		}
+	}
+	return data
+}
+
+// body returns the text body of the email.
+func (d Data) body() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v detected on %v at %v.\r\n",
+		d.Label, d.MonitorName, d.Time.Format("2006-01-02 15:04:05 MST"))
+	if len(d.Detections) != 0 {
+		b.WriteString("\r\nDetections:\r\n")
+		for _, det := range d.Detections {
+			fmt.Fprintf(&b, "%v %.0f%%\r\n", det.Label, det.Score)
+		}
+	}
+	if d.RecordingID != "" {
+		fmt.Fprintf(&b, "\r\nRecording: %v\r\n", d.RecordingID)
+	}
+	return b.String()
+}
+
+// hook is the alert hook. Errors are only returned if the
+// email couldn't be queued, delivery errors are logged.
+func (s *sender) hook(r *monitor.Recorder, event *storage.Event, snapshot []byte) error {
+	c, err := parseMonitorConfig(r.Config.Get("email"))
+	if err != nil || c == nil {
+		return err
+	}
+
+	config := s.getConfig()
+	if config == nil || config.Host == "" {
+		return ErrNotConfigured
+	}
+
+	monitorID := r.Config.ID()
+	if !s.allow(monitorID, c.cooldown, time.Now()) {
+		return ErrRateLimited
+	}
+
+	data := newData(r, event)
+	var subject bytes.Buffer
+	if err := c.subject.Execute(&subject, data); err != nil {
+		return fmt.Errorf("execute subject template: %w", err)
+	}
+
+	from, _ := mail.ParseAddress(config.From)
+	msg, err := newMessage(message{
+		from:     from,
+		to:       c.recipients,
+		subject:  subject.String(),
+		date:     time.Now(),
+		body:     data.body(),
+		snapshot: snapshot,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.send(job{
+		from:      from.Address,
+		to:        addresses(c.recipients),
+		msg:       msg,
+		logger:    r.Logger,
+		monitorID: monitorID,
+	})
+}
+
+func addresses(list []*mail.Address) []string {
+	addrs := make([]string, 0, len(list))
+	for _, a := range list {
+		addrs = append(addrs, a.Address)
+	}
+	return addrs
+}
+
+func (s *sender) getConfig() *Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.config
+}
+
+// allow returns true and updates the time of the previous email if
+// the cooldown of the monitor has passed. Same semantics as the alert cooldown.
+func (s *sender) allow(monitorID string, cooldown time.Duration, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if prev, exist := s.prevEmails[monitorID]; exist && prev.Add(cooldown).After(now) {
+		return false
+	}
+	s.prevEmails[monitorID] = now
+	return true
+}
+
+func (s *sender) start(ctx context.Context, wg *sync.WaitGroup, config *Config) {
+	s.mu.Lock()
+	s.config = config
+	s.mu.Unlock()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.run(ctx)
+	}()
+}
+
+func (j job) logf(level log.Level, format string, a ...interface{}) {
+	if j.logger == nil {
+		return
+	}
+	j.logger.Log(log.Entry{
+		Level:     level,
+		Src:       "email",
+		MonitorID: j.monitorID,
+		Msg:       fmt.Sprintf(format, a...),
+	})
+}
@@ -0,0 +1,348 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package email
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testServer is a minimal SMTP server.
+type testServer struct {
+	ln       net.Listener
+	messages chan string
+	conns    atomic.Int32
+
+	// Recipients that are rejected.
+	reject string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	s := &testServer{ln: ln, messages: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.conns.Add(1)
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	c.PrintfLine("220 test ESMTP") //nolint:errcheck
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO":
+			c.PrintfLine("250-test")     //nolint:errcheck
+			c.PrintfLine("250 8BITMIME") //nolint:errcheck
+		case "MAIL", "RSET", "NOOP":
+			c.PrintfLine("250 OK") //nolint:errcheck
+		case "RCPT":
+			if s.reject != "" && strings.Contains(arg, s.reject) {
+				c.PrintfLine("550 5.1.1 mailbox unavailable") //nolint:errcheck
+				continue
+			}
+			c.PrintfLine("250 OK") //nolint:errcheck
+		case "DATA":
+			c.PrintfLine("354 go ahead") //nolint:errcheck
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.messages <- string(data)
+			c.PrintfLine("250 OK") //nolint:errcheck
+		case "QUIT":
+			c.PrintfLine("221 bye") //nolint:errcheck
+			return
+		default:
+			c.PrintfLine("502 not implemented") //nolint:errcheck
+		}
+	}
+}
+
+func (s *testServer) config() *Config {
+	_, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return &Config{
+		Host:     "127.0.0.1",
+		Port:     p,
+		Security: securityNone,
+		From:     "NVR <nvr@example.com>",
+	}
+}
+
+func newTestSender(t *testing.T, config *Config) *sender {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+	s := newSender()
+	s.start(ctx, wg, config)
+	return s
+}
+
+func newTestRecorder(id string, c MonitorConfig, logger log.ILogger) *monitor.Recorder {
+	rawConfig, _ := json.Marshal(c)
+	return &monitor.Recorder{
+		Config: monitor.NewConfig(monitor.RawConfig{
+			"id":       id,
+			"name":     "Front door",
+			"timeZone": "UTC",
+			"email":    string(rawConfig),
+		}),
+		Logger: logger,
+	}
+}
+
+var testEvent = &storage.Event{
+	Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	Detections: []storage.Detection{
+		{Label: "car", Score: 60},
+		{Label: "person", Score: 90},
+	},
+}
+
+func TestHook(t *testing.T) {
+	t.Run("mime", func(t *testing.T) {
+		server := newTestServer(t)
+		s := newTestSender(t, server.config())
+
+		r := newTestRecorder("m1", MonitorConfig{
+			Recipients: "a@example.com, B <b@example.com>",
+			Subject:    "{{ .MonitorName }} {{ .Label }} {{ .Time.Format \"15:04\" }}",
+		}, log.NewDummyLogger())
+
+		snapshot := make([]byte, 200)
+		for i := range snapshot {
+			snapshot[i] = byte(i)
+		}
+		require.NoError(t, s.hook(r, testEvent, snapshot))
+
+		msg, err := mail.ReadMessage(strings.NewReader(<-server.messages))
+		require.NoError(t, err)
+		require.Equal(t, `"NVR" <nvr@example.com>`, msg.Header.Get("From"))
+		require.Equal(t, `<a@example.com>, "B" <b@example.com>`, msg.Header.Get("To"))
+		require.Equal(t, "Front door person 03:04", msg.Header.Get("Subject"))
+
+		mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		require.NoError(t, err)
+		require.Equal(t, "multipart/mixed", mediaType)
+
+		mr := multipart.NewReader(msg.Body, params["boundary"])
+
+		// The quoted-printable encoding is removed by the reader.
+		text, err := mr.NextPart()
+		require.NoError(t, err)
+		require.Equal(t, "text/plain; charset=utf-8", text.Header.Get("Content-Type"))
+		body, err := io.ReadAll(text)
+		require.NoError(t, err)
+		require.Equal(t, "person detected on Front door at 2024-01-02 03:04:05 UTC.\n"+
+			"\n"+
+			"Detections:\n"+
+			"car 60%\n"+
+			"person 90%\n", string(body))
+
+		image, err := mr.NextPart()
+		require.NoError(t, err)
+		require.Equal(t, "image/jpeg", image.Header.Get("Content-Type"))
+		require.Equal(t, "snapshot.jpeg", image.FileName())
+		raw, err := io.ReadAll(image)
+		require.NoError(t, err)
+		// The test server reads lines without the CR.
+		lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+		require.Len(t, lines, 4)
+		for _, line := range lines {
+			require.LessOrEqual(t, len(line), base64LineLength)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+		require.NoError(t, err)
+		require.Equal(t, snapshot, decoded)
+
+		_, err = mr.NextPart()
+		require.ErrorIs(t, err, io.EOF)
+	})
+	t.Run("noSnapshot", func(t *testing.T) {
+		server := newTestServer(t)
+		s := newTestSender(t, server.config())
+
+		r := newTestRecorder("m1", MonitorConfig{Recipients: "a@example.com"}, nil)
+		require.NoError(t, s.hook(r, testEvent, nil))
+
+		msg, err := mail.ReadMessage(strings.NewReader(<-server.messages))
+		require.NoError(t, err)
+		require.Equal(t, "Front door: person detected", msg.Header.Get("Subject"))
+
+		_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		require.NoError(t, err)
+		mr := multipart.NewReader(msg.Body, params["boundary"])
+		_, err = mr.NextPart()
+		require.NoError(t, err)
+		_, err = mr.NextPart()
+		require.ErrorIs(t, err, io.EOF)
+	})
+	t.Run("rateLimit", func(t *testing.T) {
+		server := newTestServer(t)
+		s := newTestSender(t, server.config())
+
+		c := MonitorConfig{Recipients: "a@example.com", Cooldown: "10"}
+		r1 := newTestRecorder("m1", c, nil)
+		r2 := newTestRecorder("m2", c, nil)
+
+		require.NoError(t, s.hook(r1, testEvent, nil))
+		require.ErrorIs(t, s.hook(r1, testEvent, nil), ErrRateLimited)
+
+		// Other monitors are unaffected.
+		require.NoError(t, s.hook(r2, testEvent, nil))
+
+		<-server.messages
+		<-server.messages
+		require.Empty(t, server.messages)
+
+		// The cooldown has passed.
+		require.True(t, s.allow("m1", 10*time.Minute, time.Now().Add(11*time.Minute)))
+	})
+	t.Run("connectionReuse", func(t *testing.T) {
+		server := newTestServer(t)
+		s := newTestSender(t, server.config())
+
+		r := newTestRecorder("m1", MonitorConfig{Recipients: "a@example.com"}, nil)
+		require.NoError(t, s.hook(r, testEvent, nil))
+		<-server.messages
+		require.NoError(t, s.hook(r, testEvent, nil))
+		<-server.messages
+		require.Equal(t, int32(1), server.conns.Load())
+	})
+	t.Run("idleTimeout", func(t *testing.T) {
+		server := newTestServer(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s := newSender()
+		s.idleTimeout = time.Millisecond
+		s.start(ctx, &sync.WaitGroup{}, server.config())
+
+		r := newTestRecorder("m1", MonitorConfig{Recipients: "a@example.com"}, nil)
+		require.NoError(t, s.hook(r, testEvent, nil))
+		<-server.messages
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, s.hook(r, testEvent, nil))
+		<-server.messages
+		require.Equal(t, int32(2), server.conns.Load())
+	})
+	t.Run("smtpError", func(t *testing.T) {
+		server := newTestServer(t)
+		server.reject = "bad@example.com"
+		s := newTestSender(t, server.config())
+
+		logger, logs := log.NewMockLogger()
+		r := newTestRecorder("m1", MonitorConfig{Recipients: "bad@example.com"}, logger)
+		require.NoError(t, s.hook(r, testEvent, nil))
+		require.Equal(t,
+			`email failed: rcpt bad@example.com: 550 "5.1.1 mailbox unavailable"`,
+			<-logs)
+
+		// The connection is closed after a failure.
+		r = newTestRecorder("m1", MonitorConfig{Recipients: "a@example.com"}, nil)
+		require.NoError(t, s.hook(r, testEvent, nil))
+		<-server.messages
+		require.Equal(t, int32(2), server.conns.Load())
+	})
+	t.Run("notConfigured", func(t *testing.T) {
+		s := newSender()
+		r := newTestRecorder("m1", MonitorConfig{Recipients: "a@example.com"}, nil)
+		require.ErrorIs(t, s.hook(r, testEvent, nil), ErrNotConfigured)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		s := newSender()
+		r := newTestRecorder("m1", MonitorConfig{}, nil)
+		require.NoError(t, s.hook(r, testEvent, nil))
+	})
+}
+
+func TestParseMonitorConfig(t *testing.T) {
+	cases := map[string]struct {
+		config MonitorConfig
+		err    error
+		nilCfg bool
+	}{
+		"disabled":        {MonitorConfig{Recipients: " "}, nil, true},
+		"ok":              {MonitorConfig{Recipients: "a@b.c,, d@e.f", Cooldown: "1.5"}, nil, false},
+		"invalidAddress":  {MonitorConfig{Recipients: "a@b.c, x"}, ErrRecipient, true},
+		"invalidTemplate": {MonitorConfig{Recipients: "a@b.c", Subject: "{{ .X"}, ErrSubject, true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			rawConfig, err := json.Marshal(tc.config)
+			require.NoError(t, err)
+			c, err := parseMonitorConfig(string(rawConfig))
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.nilCfg, c == nil)
+		})
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	t.Run("generate", func(t *testing.T) {
+		configPath := filepath.Join(t.TempDir(), "email.json")
+		config, err := readConfig(configPath)
+		require.NoError(t, err)
+		require.Equal(t, defaultConfig, *config)
+		require.FileExists(t, configPath)
+	})
+	cases := map[string]struct {
+		config Config
+		err    error
+	}{
+		"ok":       {Config{Host: "a", From: "b@c.d"}, nil},
+		"security": {Config{Host: "a", Security: "x", From: "b@c.d"}, ErrSecurity},
+		"from":     {Config{Host: "a", From: "x"}, ErrFrom},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "email.json")
+			data, err := json.Marshal(tc.config)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(configPath, data, 0o600))
+
+			_, err = readConfig(configPath)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+type message struct {
+	from     *mail.Address
+	to       []*mail.Address
+	subject  string
+	date     time.Time
+	body     string
+	snapshot []byte // Attached if not nil.
+}
+
+// newMessage returns a multipart/mixed message with a text
+// body and the snapshot attached as a JPEG image.
+func newMessage(m message) ([]byte, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	to := make([]string, 0, len(m.to))
+	for _, addr := range m.to {
+		to = append(to, addr.String())
+	}
+	// Newlines in the subject would inject headers.
+	subject := strings.NewReplacer("\r", "", "\n", " ").Replace(m.subject)
+
+	header := []string{
+		"From: " + m.from.String(),
+		"To: " + strings.Join(to, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + m.date.Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/mixed; boundary="` + w.Boundary() + `"`,
+	}
+	buf.WriteString(strings.Join(header, "\r\n") + "\r\n\r\n")
+
+	text, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	qp := quotedprintable.NewWriter(text)
+	if _, err := qp.Write([]byte(m.body)); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+
+	if m.snapshot != nil {
+		image, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"image/jpeg"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {`attachment; filename="snapshot.jpeg"`},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64(image, m.snapshot); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("close multipart writer: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Maximum line length of base64 encoded parts, RFC 2045.
+const base64LineLength = 76
+
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > base64LineLength {
+		if _, err := io.WriteString(w, encoded[:base64LineLength]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[base64LineLength:]
+	}
+	_, err := io.WriteString(w, encoded+"\r\n")
+	return err
+}
@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"nvr/pkg/log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	emailQueueSize = 64
+	emailTimeout   = 30 * time.Second
+
+	// The connection is kept open for closely spaced alerts.
+	emailIdleTimeout = 30 * time.Second
+)
+
+// sender sends emails asynchronously over a single SMTP connection.
+type sender struct {
+	queue       chan job
+	timeout     time.Duration
+	idleTimeout time.Duration
+
+	// Only used by the run goroutine.
+	conn   net.Conn
+	client *smtp.Client
+
+	mu         sync.Mutex
+	config     *Config
+	prevEmails map[string]time.Time // map[monitorID]prevEmail.
+}
+
+func newSender() *sender {
+	return &sender{
+		queue:       make(chan job, emailQueueSize),
+		timeout:     emailTimeout,
+		idleTimeout: emailIdleTimeout,
+		prevEmails:  map[string]time.Time{},
+	}
+}
+
+type job struct {
+	from      string
+	to        []string
+	msg       []byte
+	logger    log.ILogger
+	monitorID string
+}
+
+func (s *sender) send(j job) error {
+	select {
+	case s.queue <- j:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (s *sender) run(ctx context.Context) {
+	idleTimer := time.NewTimer(s.idleTimeout)
+	idleTimer.Stop()
+	defer s.close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-idleTimer.C:
+			s.close()
+		case j := <-s.queue:
+			idleTimer.Stop()
+			if err := s.deliver(j); err != nil {
+				j.logf(log.LevelError, "email failed: %v", err)
+			}
+			idleTimer.Reset(s.idleTimeout)
+		}
+	}
+}
+
+// deliver sends the email. A reused connection may have been closed by
+// the server, the email is sent again on a new connection if it fails
+// without a SMTP response.
+func (s *sender) deliver(j job) error {
+	reused := s.client != nil
+	err := s.sendMail(j)
+	if err == nil {
+		return nil
+	}
+	s.close()
+
+	var smtpErr *textproto.Error
+	if !reused || errors.As(err, &smtpErr) {
+		return err
+	}
+	err = s.sendMail(j)
+	if err != nil {
+		s.close()
+	}
+	return err
+}
+
+func (s *sender) sendMail(j job) error {
+	if s.client == nil {
+		if err := s.dial(); err != nil {
+			return err
+		}
+	}
+	s.conn.SetDeadline(time.Now().Add(s.timeout)) //nolint:errcheck
+
+	c := s.client
+	if err := c.Mail(j.from); err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+	for _, addr := range j.to {
+		if err := c.Rcpt(addr); err != nil {
+			c.Reset() //nolint:errcheck
+			return fmt.Errorf("rcpt %v: %w", addr, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("data: %w", err)
+	}
+	if _, err := w.Write(j.msg); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("data: %w", err)
+	}
+	return nil
+}
+
+func (s *sender) dial() error {
+	config := s.getConfig()
+	if config == nil || config.Host == "" {
+		return ErrNotConfigured
+	}
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	tlsConfig := &tls.Config{ServerName: config.Host, MinVersion: tls.VersionTLS12}
+
+	dialer := &net.Dialer{Timeout: s.timeout}
+	var conn net.Conn
+	var err error
+	if config.Security == securityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(s.timeout)) //nolint:errcheck
+
+	client, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("greeting: %w", err)
+	}
+	if config.Security == securityStartTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if config.Username != "" {
+		auth := smtp.PlainAuth("", config.Username, config.Password, config.Host)
+		if err := client.Auth(auth); err != nil {
+			client.Close()
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	s.conn = conn
+	s.client = client
+	return nil
+}
+
+// close closes the connection if it's open.
+func (s *sender) close() {
+	if s.client == nil {
+		return
+	}
+	s.conn.SetDeadline(time.Now().Add(s.timeout)) //nolint:errcheck
+	s.client.Quit()                               //nolint:errcheck
+	s.client.Close()
+	s.conn = nil
+	s.client = nil
+}
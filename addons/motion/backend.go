@@ -19,19 +19,22 @@ import (
 	"time"
 )
 
+var nvrAddon = nvr.RegisterAddon("motion", "Motion detection")
+
 func init() {
-	nvr.RegisterMonitorInputProcessHook(onInputProcessStart)
+	nvrAddon.RegisterMonitorInputProcessHook(onInputProcessStart)
+	nvrAddon.RegisterMonitorConfigKeys("motion")
 	nvr.RegisterLogSource([]string{"motion"})
 
-	nvr.RegisterTplHook(modifyTemplates)
-	nvr.RegisterAppRunHook(func(_ context.Context, app *nvr.App) error {
+	nvrAddon.RegisterTplHook(modifyTemplates)
+	nvrAddon.RegisterAppRunHook(func(_ context.Context, app *nvr.App) error {
 		app.Router.Handle("/motion.mjs", app.Auth.Admin(serveMotionMjs()))
 		return nil
 	})
 }
 
 func onInputProcessStart(ctx context.Context, i *monitor.InputProcess, _ *[]string) {
-	if i.Config.SubInputEnabled() != i.IsSubInput() {
+	if !i.IsDetectionInput() {
 		return
 	}
 
@@ -125,7 +128,7 @@ func run(
 		logf(log.FFmpegLevel(config.logLevel), fmt.Sprintf("process: %v", msg))
 	}
 
-	process := ffmpeg.NewProcess(cmd).
+	process := i.NewProcess(cmd).
 		StderrLogger(processLogFunc)
 
 	stdout, err := cmd.StdoutPipe()
@@ -248,6 +251,7 @@ func (d detector) runFrameReader(stdout io.Reader) error {
 			Time:        t,
 			Duration:    d.config.duration,
 			RecDuration: d.config.recDuration,
+			Source:      "motion",
 		})
 	}
 
@@ -52,11 +52,14 @@ func parseConfig(c monitor.Config) (*config, bool, error) {
 		return nil, false, err
 	}
 
-	feedRateFloat, err := strconv.ParseFloat(rawConf.FeedRate, 64)
+	feedRateFloat, err := ffmpeg.ParseFeedRate(rawConf.FeedRate)
 	if err != nil {
 		return nil, false, fmt.Errorf("parse feed rate: %w", err)
 	}
-	duration := ffmpeg.FeedRateToDuration(feedRateFloat)
+	duration, err := ffmpeg.FeedRateToDuration(feedRateFloat)
+	if err != nil {
+		return nil, false, err
+	}
 
 	scale := parseScale(rawConf.FrameScale)
 
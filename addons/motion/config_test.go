@@ -35,7 +35,7 @@ func TestParseConfig(t *testing.T) {
 			"logLevel":        "2",
 			"hwaccel":         "3",
 			"timestampOffset": "4",
-			"subInput":        "x",
+			"subStream":       "x",
 			"motion":          motion,
 		})
 		actual, enable, err := parseConfig(c)
@@ -95,7 +95,7 @@ func TestParseConfig(t *testing.T) {
 			"motion": `{"enable": "true", "feedRate":"nil"}`,
 		},
 		"durationErr": {
-			"motion": `{"enable": "true", "feedRate":"0", "duration":"nil"}`,
+			"motion": `{"enable": "true", "feedRate":"1", "duration":"nil"}`,
 		},
 	}
 	for name, conf := range cases {
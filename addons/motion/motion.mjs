@@ -21,7 +21,7 @@ export function motion() {
 function _motion(hls, hasSubStream) {
 	const fields = {
 		enable: fieldTemplate.toggle("Enable motion detection", "false"),
-		feedRate: fieldTemplate.integer("Feed rate (fps)", "", 2),
+		feedRate: fieldTemplate.feedRate("Feed rate (fps)", "2"),
 		frameScale: fieldTemplate.select(
 			"Frame scale",
 			["full", "half", "third", "quarter", "sixth", "eighth"],
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package status
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// MonitorStatus resource usage of the ffmpeg processes of a monitor.
+type MonitorStatus struct {
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	CPUUsage          int    `json:"cpuUsage"`
+	RAMUsage          uint64 `json:"ramUsage"`
+	RAMUsageFormatted string `json:"ramUsageFormatted"`
+}
+
+type (
+	monitorPIDsFunc   func() map[string][]int
+	monitorNamesFunc  func() map[string]string
+	sampleProcessFunc func(pid int) (processSample, error)
+)
+
+// processSample total CPU time and resident memory of a process.
+type processSample struct {
+	cpuTime time.Duration
+	rss     uint64
+}
+
+func sampleProcess(pid int) (processSample, error) {
+	p, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return processSample{}, err
+	}
+	times, err := p.Times()
+	if err != nil {
+		return processSample{}, fmt.Errorf("times: %w", err)
+	}
+	memInfo, err := p.MemoryInfo()
+	if err != nil {
+		return processSample{}, fmt.Errorf("memory info: %w", err)
+	}
+	return processSample{
+		cpuTime: time.Duration((times.User + times.System) * float64(time.Second)),
+		rss:     memInfo.RSS,
+	}, nil
+}
+
+// monitorSampler attributes the resource usage
+// of the ffmpeg processes to their monitors.
+type monitorSampler struct {
+	pids   monitorPIDsFunc
+	names  monitorNamesFunc
+	sample sampleProcessFunc
+	numCPU int
+
+	prevSamples map[int]processSample
+	prevTime    time.Time
+}
+
+func newMonitorSampler(pids monitorPIDsFunc, names monitorNamesFunc) *monitorSampler {
+	return &monitorSampler{
+		pids:   pids,
+		names:  names,
+		sample: sampleProcess,
+		numCPU: runtime.NumCPU(),
+	}
+}
+
+// update samples the processes and returns the usage of every monitor
+// with running processes. The CPU usage is the share of the total
+// CPU time since the previous update, same as the global CPU usage.
+// Not safe for concurrent use.
+func (s *monitorSampler) update(now time.Time) []MonitorStatus {
+	names := s.names()
+	elapsed := now.Sub(s.prevTime)
+
+	samples := make(map[int]processSample)
+	var statuses []MonitorStatus
+	for id, pids := range s.pids() {
+		status := MonitorStatus{ID: id, Name: names[id]}
+		if status.Name == "" {
+			status.Name = id
+		}
+
+		var cpuTime time.Duration
+		running := false
+		for _, pid := range pids {
+			sample, err := s.sample(pid)
+			if err != nil {
+				// The process exited after the PIDs were listed.
+				continue
+			}
+			running = true
+			samples[pid] = sample
+			status.RAMUsage += sample.rss
+
+			// The first sample of a process only sets the baseline.
+			prev, exist := s.prevSamples[pid]
+			if exist && sample.cpuTime >= prev.cpuTime {
+				cpuTime += sample.cpuTime - prev.cpuTime
+			}
+		}
+		if !running {
+			continue
+		}
+
+		if !s.prevTime.IsZero() && elapsed > 0 && s.numCPU > 0 {
+			total := float64(elapsed) * float64(s.numCPU)
+			status.CPUUsage = int(100 * float64(cpuTime) / total)
+		}
+		status.RAMUsageFormatted = formatRAM(status.RAMUsage)
+		statuses = append(statuses, status)
+	}
+
+	// Exited processes are dropped here.
+	s.prevSamples = samples
+	s.prevTime = now
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Name != statuses[j].Name {
+			return statuses[i].Name < statuses[j].Name
+		}
+		return statuses[i].ID < statuses[j].ID
+	})
+	return statuses
+}
+
+func formatRAM(bytes uint64) string {
+	const (
+		megabyte = 1000 * 1000
+		gigabyte = 1000 * megabyte
+	)
+	if bytes < gigabyte {
+		return fmt.Sprintf("%.0fMB", float64(bytes)/megabyte)
+	}
+	return fmt.Sprintf("%.2fGB", float64(bytes)/gigabyte)
+}
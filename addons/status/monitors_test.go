@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package status
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonitorSampler(t *testing.T) {
+	pids := map[string][]int{
+		"a": {1, 2},
+		"b": {3},
+	}
+	samples := map[int]processSample{
+		1: {cpuTime: 1 * time.Second, rss: 100 * 1000 * 1000},
+		2: {cpuTime: 2 * time.Second, rss: 200 * 1000 * 1000},
+		3: {cpuTime: 3 * time.Second, rss: 2 * 1000 * 1000 * 1000},
+	}
+	s := &monitorSampler{
+		pids: func() map[string][]int { return pids },
+		names: func() map[string]string {
+			return map[string]string{"a": "x", "b": "y"}
+		},
+		sample: func(pid int) (processSample, error) {
+			sample, exist := samples[pid]
+			if !exist {
+				return processSample{}, os.ErrNotExist
+			}
+			return sample, nil
+		},
+		numCPU: 2,
+	}
+	start := time.Unix(0, 0)
+
+	// The first update only sets the CPU baseline.
+	expected := []MonitorStatus{
+		{ID: "a", Name: "x", RAMUsage: 300000000, RAMUsageFormatted: "300MB"},
+		{ID: "b", Name: "y", RAMUsage: 2000000000, RAMUsageFormatted: "2.00GB"},
+	}
+	require.Equal(t, expected, s.update(start))
+
+	// 10 seconds on 2 CPUs, 20 seconds of CPU time in total.
+	samples[1] = processSample{cpuTime: 3 * time.Second, rss: 100 * 1000 * 1000}
+	samples[2] = processSample{cpuTime: 4 * time.Second, rss: 200 * 1000 * 1000}
+	samples[3] = processSample{cpuTime: 13 * time.Second, rss: 2 * 1000 * 1000 * 1000}
+	expected = []MonitorStatus{
+		{ID: "a", Name: "x", CPUUsage: 20, RAMUsage: 300000000, RAMUsageFormatted: "300MB"},
+		{ID: "b", Name: "y", CPUUsage: 50, RAMUsage: 2000000000, RAMUsageFormatted: "2.00GB"},
+	}
+	require.Equal(t, expected, s.update(start.Add(10*time.Second)))
+
+	// Processes that exited between samples disappear.
+	delete(samples, 2)
+	delete(samples, 3)
+	expected = []MonitorStatus{
+		{ID: "a", Name: "x", RAMUsage: 100000000, RAMUsageFormatted: "100MB"},
+	}
+	require.Equal(t, expected, s.update(start.Add(20*time.Second)))
+	require.Len(t, s.prevSamples, 1)
+
+	// New process without a baseline.
+	pids["a"] = []int{1, 4}
+	samples[4] = processSample{cpuTime: 5 * time.Second, rss: 100 * 1000 * 1000}
+	samples[1] = processSample{cpuTime: 5 * time.Second, rss: 100 * 1000 * 1000}
+	expected = []MonitorStatus{
+		{ID: "a", Name: "x", CPUUsage: 10, RAMUsage: 200000000, RAMUsageFormatted: "200MB"},
+	}
+	require.Equal(t, expected, s.update(start.Add(30*time.Second)))
+}
+
+func TestMonitorSamplerNoName(t *testing.T) {
+	s := &monitorSampler{
+		pids:  func() map[string][]int { return map[string][]int{"a": {1}} },
+		names: func() map[string]string { return nil },
+		sample: func(int) (processSample, error) {
+			return processSample{}, nil
+		},
+	}
+	statuses := s.update(time.Unix(0, 0))
+	require.Len(t, statuses, 1)
+	require.Equal(t, "a", statuses[0].Name)
+}
+
+func TestSampleProcess(t *testing.T) {
+	sample, err := sampleProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NotZero(t, sample.rss)
+}
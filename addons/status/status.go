@@ -7,9 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"net/http"
 	"nvr"
 	"nvr/pkg/log"
 	"nvr/pkg/storage"
+	"nvr/pkg/web/api"
 	"strings"
 	"sync"
 	"time"
@@ -18,24 +20,38 @@ import (
 	"github.com/shirou/gopsutil/v3/mem"
 )
 
+var nvrAddon = nvr.RegisterAddon("status", "System status in the navbar")
+
 func init() {
 	var sys *system
 
-	nvr.RegisterAppRunHook(func(ctx context.Context, app *nvr.App) error {
+	nvrAddon.RegisterAppRunHook(func(ctx context.Context, app *nvr.App) error {
 		sys = newSystem(
 			app.Storage.DiskUsageCached,
 			app.Storage.DiskUsage,
 			app.Logger,
 		)
+		sys.monitors = newMonitorSampler(
+			app.MonitorProcessIDs,
+			func() map[string]string {
+				names := make(map[string]string)
+				for id, info := range app.MonitorsInfo() {
+					names[id] = info["name"]
+				}
+				return names
+			},
+		)
 		go sys.StatusLoop(ctx)
+
+		app.Router.Handle("/api/status", app.Auth.User(handleStatus(sys)))
 		return nil
 	})
 
-	nvr.RegisterTplDataHook(func(data template.FuncMap, _ string) {
+	nvrAddon.RegisterTplDataHook(func(data template.FuncMap, _ string) {
 		data["status"] = sys.getStatus()
 	})
 
-	nvr.RegisterTplSubHook(modifySubTemplate)
+	nvrAddon.RegisterTplSubHook(modifySubTemplate)
 }
 
 type status struct {
@@ -43,6 +59,10 @@ type status struct {
 	RAMUsage           int    `json:"ramUsage"`
 	DiskUsage          int    `json:"diskUsage"`
 	DiskUsageFormatted string `json:"diskUsageFormatted"`
+	LockedUsage        int    `json:"lockedUsage"`
+	LockedWarning      bool   `json:"lockedWarning"`
+
+	PerMonitor []MonitorStatus `json:"perMonitor"`
 }
 
 type (
@@ -58,6 +78,9 @@ type system struct {
 	diskCached diskCachedFunc
 	disk       diskFunc
 
+	// Optional.
+	monitors *monitorSampler
+
 	status status
 
 	interval time.Duration
@@ -119,9 +142,22 @@ func (s *system) StatusLoop(ctx context.Context) {
 		if err != nil && !errors.Is(err, context.Canceled) {
 			s.logf(log.LevelError, "could not update system status: %v", err)
 		}
+		s.updatePerMonitor()
 	}
 }
 
+// updatePerMonitor samples the ffmpeg processes of the monitors.
+func (s *system) updatePerMonitor() {
+	if s.monitors == nil {
+		return
+	}
+	perMonitor := s.monitors.update(time.Now())
+
+	s.mu.Lock()
+	s.status.PerMonitor = perMonitor
+	s.mu.Unlock()
+}
+
 func (s *system) getStatus() status {
 	defer s.mu.Unlock()
 	s.mu.Lock()
@@ -146,20 +182,19 @@ func (s *system) updateDiskUnsafe() {
 
 	s.status.DiskUsage = diskUsage.Percent
 	s.status.DiskUsageFormatted = diskUsage.Formatted
+	s.status.LockedUsage = diskUsage.LockedPercent
+	s.status.LockedWarning = diskUsage.LockedWarning
 }
 
-/*func handleStatus(sys *system) http.Handler {
+func handleStatus(sys *system) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(sys.getStatus()); err != nil {
-			http.Error(w, "could not encode json", http.StatusInternalServerError)
-		}
+		api.WriteJSON(w, r, sys.getStatus())
 	})
-}*/
+}
 
 func modifySubTemplate(pageFiles map[string]string) error {
 	const target = "</aside>"
@@ -206,6 +241,12 @@ const sidebarHTML = `
 			background: var(--colorbg);
 			border-radius: 0.2rem;
 		}
+		.statusbar-monitors summary {
+			cursor: pointer;
+		}
+		.statusbar-monitors ul {
+			padding-left: 0.2rem;
+		}
 		.statusbar-progressbar span {
 			display: block;
 			width: 50%;
@@ -256,4 +297,41 @@ const sidebarHTML = `
 				<span style="width: {{ .status.DiskUsage }}%"></span>
 			</div>
 		</li>
+		{{ if .status.LockedWarning }}
+		<li>
+			<div class="statusbar-text-container">
+				<span class="statusbar-text">LOCKED</span>
+				<span class="statusbar-text statusbar-number"
+					>{{ .status.LockedUsage }}%</span
+				>
+			</div>
+			<div class="statusbar-progressbar">
+				<span
+					style="width: {{ .status.LockedUsage }}%; background: var(--color-red)"
+				></span>
+			</div>
+		</li>
+		{{ end }}
+		{{ if .status.PerMonitor }}
+		<li>
+			<details class="statusbar-monitors">
+				<summary class="statusbar-text">MONITORS</summary>
+				<ul>
+					{{ range .status.PerMonitor }}
+					<li>
+						<div class="statusbar-text-container">
+							<span class="statusbar-text">{{ .Name }}</span>
+							<span class="statusbar-text statusbar-number"
+								>{{ .CPUUsage }}% {{ .RAMUsageFormatted }}</span
+							>
+						</div>
+						<div class="statusbar-progressbar">
+							<span style="width: {{ .CPUUsage }}%"></span>
+						</div>
+					</li>
+					{{ end }}
+				</ul>
+			</details>
+		</li>
+		{{ end }}
 	</ul>`
@@ -4,8 +4,13 @@ package status
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -41,9 +46,9 @@ func TestUpdate(t *testing.T) {
 		expectedError bool
 		expectedValue string
 	}{
-		"cpuErr": {stubCPUErr, stubRAM, true, "{0 0 0 }"},
-		"ramErr": {stubCPU, stubRAMErr, true, "{0 0 0 }"},
-		"ok":     {stubCPU, stubRAM, false, "{11 22 0 }"},
+		"cpuErr": {stubCPUErr, stubRAM, true, "{0 0 0  0 false []}"},
+		"ramErr": {stubCPU, stubRAMErr, true, "{0 0 0  0 false []}"},
+		"ok":     {stubCPU, stubRAM, false, "{11 22 0  0 false []}"},
 	}
 
 	for name, tc := range cases {
@@ -121,3 +126,74 @@ func TestUpdateDiskError(t *testing.T) {
 	s.updateDiskUnsafe()
 	require.Equal(t, "could not get disk usage: stub", <-logs)
 }
+
+func renderSidebar(t *testing.T, s status) string {
+	t.Helper()
+	pageFiles := map[string]string{"sidebar.tpl": "<aside></aside>"}
+	require.NoError(t, modifySubTemplate(pageFiles))
+
+	tpl, err := template.New("").Parse(pageFiles["sidebar.tpl"])
+	require.NoError(t, err)
+
+	var b strings.Builder
+	require.NoError(t, tpl.Execute(&b, template.FuncMap{"status": s}))
+	return b.String()
+}
+
+func TestSidebarPerMonitor(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		html := renderSidebar(t, status{})
+		require.NotContains(t, html, "<details")
+	})
+	t.Run("ok", func(t *testing.T) {
+		html := renderSidebar(t, status{
+			PerMonitor: []MonitorStatus{
+				{ID: "a", Name: "x", CPUUsage: 12, RAMUsageFormatted: "34MB"},
+				{ID: "b", Name: "<y>", CPUUsage: 56, RAMUsageFormatted: "78MB"},
+			},
+		})
+		require.Contains(t, html, `<details class="statusbar-monitors">`)
+		require.Contains(t, html, `<span class="statusbar-text">x</span>`)
+		require.Contains(t, html, ">12% 34MB</span")
+		require.Contains(t, html, `style="width: 12%"`)
+		require.Contains(t, html, `<span class="statusbar-text">&lt;y&gt;</span>`)
+		require.Contains(t, html, ">56% 78MB</span")
+		require.Less(t,
+			strings.Index(html, "statusbar-monitors\">"),
+			strings.Index(html, "</aside>"),
+		)
+	})
+}
+
+func TestHandleStatus(t *testing.T) {
+	s := &system{
+		diskCached: func() (storage.DiskUsage, time.Duration) {
+			return storage.DiskUsage{Percent: 1}, 0
+		},
+		status: status{
+			CPUUsage:   2,
+			PerMonitor: []MonitorStatus{{ID: "a", Name: "x", CPUUsage: 3}},
+		},
+	}
+	t.Run("ok", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+		handleStatus(s).ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var actual status
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&actual))
+		expected := status{
+			CPUUsage:   2,
+			DiskUsage:  1,
+			PerMonitor: []MonitorStatus{{ID: "a", Name: "x", CPUUsage: 3}},
+		}
+		require.Equal(t, expected, actual)
+	})
+	t.Run("methodNotAllowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/status", nil)
+		handleStatus(s).ServeHTTP(w, r)
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
@@ -9,9 +9,12 @@ import (
 	"strings"
 )
 
+var nvrAddon = nvr.RegisterAddon("thumbscale", "Thumbnail scale setting")
+
 func init() {
-	nvr.RegisterTplHook(modifyTemplates)
-	nvr.RegisterMonitorRecSaveHook(onRecSave)
+	nvrAddon.RegisterTplHook(modifyTemplates)
+	nvrAddon.RegisterMonitorRecSaveHook(onRecSave)
+	nvrAddon.RegisterMonitorConfigKeys("thumbScale")
 }
 
 func modifyTemplates(pageFiles map[string]string) error {
@@ -5,6 +5,7 @@ package timeline
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"nvr"
@@ -12,72 +13,148 @@ import (
 	"nvr/pkg/log"
 	"nvr/pkg/monitor"
 	"nvr/pkg/storage"
+	"nvr/pkg/web/api"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
+var nvrAddon = nvr.RegisterAddon("timeline", "Low resolution timeline recordings")
+
+var generations = newJobs(generateWorkers)
+
 func init() {
 	nvr.RegisterLogSource([]string{"timeline"})
-	nvr.RegisterMonitorRecSavedHook(onRecSaved)
-	nvr.RegisterMigrationMonitorHook(migrate)
+	nvrAddon.RegisterMonitorRecSavedHook(onRecSaved)
+	nvrAddon.RegisterRecordingDetailHook(onRecordingDetail)
+	nvrAddon.RegisterMigrationMonitorHook(migrate)
+	nvrAddon.RegisterMonitorConfigKeys("timeline", "timelineConfigVersion")
 
-	nvr.RegisterTplSubHook(modifySubTemplates)
-	nvr.RegisterTplHook(modifyTemplates)
+	nvrAddon.RegisterTplSubHook(modifySubTemplates)
+	nvrAddon.RegisterTplHook(modifyTemplates)
+	nvrAddon.RegisterStaticFS("/static/timeline/", staticFS())
 
-	nvr.RegisterAppRunHook(func(_ context.Context, app *nvr.App) error {
+	nvrAddon.RegisterAppRunHook(func(_ context.Context, app *nvr.App) error {
 		app.Router.Handle(
 			"/api/recording/timeline/",
-			app.Auth.User(handleTimeline(app.Env.RecordingsDir())),
+			app.Auth.User(handleTimeline(app.Env.RecordingsDirs(), generations)),
 		)
 		app.Router.Handle(
-			"/timeline",
-			app.Auth.User(app.Templater.Render("timeline.tpl")),
+			"/api/recording/timeline/status/",
+			app.Auth.User(handleTimelineStatus(app.Env.RecordingsDirs(), generations)),
 		)
 		app.Router.Handle(
-			"/timeline.mjs",
-			app.Auth.User(serveTimelineMjs()),
+			"/timeline",
+			app.Auth.User(app.Templater.Render("timeline.tpl")),
 		)
 		return nil
 	})
 }
 
-func handleTimeline(recordingsDir string) http.Handler {
+// handleTimeline serves the timeline file, range requests are supported.
+// Responds with 409 and the status while the timeline is generated.
+func handleTimeline(recordingsDirs storage.RecordingsDirs, j *jobs) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
 		recID := r.URL.Path[24:] // Trim "/api/recording/timeline/"
 		timelinePath, err := storage.RecordingIDToPath(recID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			api.BadRequest(w, err.Error())
 			return
 		}
 
-		path := filepath.Join(recordingsDir, timelinePath+".timeline")
+		path := filepath.Join(recordingsDirs.Find(recID), timelinePath+".timeline")
+		if _, err := os.Stat(path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				timelineNotFound(w, r, recordingsDirs, j, recID)
+				return
+			}
+			api.InternalError(w, r, "could not stat timeline", err)
+			return
+		}
 
 		// ServeFile will sanitize ".."
 		http.ServeFile(w, r, path)
 	})
 }
 
+func timelineNotFound(
+	w http.ResponseWriter,
+	r *http.Request,
+	recordingsDirs storage.RecordingsDirs,
+	j *jobs,
+	recID string,
+) {
+	status, err := j.status(recordingsDirs, recID)
+	if err != nil {
+		api.InternalError(w, r, "could not get timeline status", err)
+		return
+	}
+	if status.Status == statusPending || status.Status == statusGenerating {
+		api.WriteError(w, http.StatusConflict, api.Error{
+			Code:    api.CodeConflict,
+			Message: "timeline is being generated",
+			Details: map[string]string{"status": status.Status},
+		})
+		return
+	}
+	api.NotFound(w, "timeline not found")
+}
+
+func handleTimelineStatus(recordingsDirs storage.RecordingsDirs, j *jobs) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		recID := strings.TrimPrefix(r.URL.Path, "/api/recording/timeline/status/")
+		status, err := j.status(recordingsDirs, recID)
+		if err != nil {
+			if errors.Is(err, storage.ErrInvalidRecordingID) {
+				api.BadRequest(w, err.Error())
+				return
+			}
+			api.InternalError(w, r, "could not get timeline status", err)
+			return
+		}
+		api.WriteJSON(w, r, status)
+	})
+}
+
+// onRecordingDetail adds the timeline status to the recording detail.
+// The status is left out if the failure marker couldn't be read.
+func onRecordingDetail(detail *storage.RecordingDetail) {
+	status, err := generations.detailStatus(detail)
+	if err != nil {
+		return
+	}
+	detail.Addons["timeline"] = status
+}
+
 func onRecSaved(r *monitor.Recorder, recPath string, recData storage.RecordingData) {
 	id := r.Config.ID()
 	logf := func(level log.Level, format string, a ...interface{}) {
-		msg := fmt.Sprintf(format, a...)
+		msg, fields := log.Sprintf(format, a...)
 		r.Logger.Log(log.Entry{
 			Level:     level,
 			Src:       "timeline",
 			MonitorID: id,
-			Msg:       r.Env.CensorLog(msg),
-		})
+			Msg:       msg,
+			Fields:    fields,
+		}.Censor(r.Env.CensorLog))
 	}
 
-	err := recSaved(r, logf, recPath, recData)
+	err := generations.run(recPath, func() error {
+		return recSaved(r, logf, recPath, recData)
+	})
 	if err != nil {
 		logf(log.LevelError, err.Error())
 	}
@@ -103,7 +180,16 @@ func recSaved(
 	tempPath := recPath + ".timeline_tmp"
 	timelinePath := recPath + ".timeline"
 
-	args := genArgs(r.Config.LogLevel(), tempPath, *config)
+	// The timestamp is in the time zone of the recording or the monitor.
+	loc := time.Local
+	if tz := r.Config.TimeZone(); tz != "" {
+		if l, err := time.LoadLocation(tz); err == nil {
+			loc = l
+		}
+	}
+	start := recData.Times(loc).StartLocal
+
+	args := genArgs(r.Config.LogLevel(), tempPath, start, *config)
 
 	logf(log.LevelInfo, "generating: %v", strings.Join(args, " "))
 	cmd := exec.Command(r.Env.FFmpegBin, args...)
@@ -135,7 +221,9 @@ func recSaved(
 
 const defaultScale = "8"
 
-func genArgs(logLevel string, outputPath string, c config) []string {
+// genArgs start is the start time of the recording
+// in the time zone of the timestamp overlay.
+func genArgs(logLevel string, outputPath string, start time.Time, c config) []string {
 	scale := ffmpeg.ParseScaleString(c.scale)
 	if scale == "" {
 		scale = defaultScale
@@ -152,7 +240,12 @@ func genArgs(logLevel string, outputPath string, c config) []string {
 		"-vsync", "vfr", "-vf",
 	}
 
+	// The timestamp is drawn before the decimation and fps
+	// filters while the frames still have their original PTS.
 	filters := "mpdecimate,fps=" + fps + ",mpdecimate"
+	if c.timestamp {
+		filters = timestampFilter(start, c, scale) + "," + filters
+	}
 	if scale != "1" {
 		filters += ",scale='iw/" + scale + ":ih/" + scale + "'"
 	}
@@ -165,6 +258,50 @@ func genArgs(logLevel string, outputPath string, c config) []string {
 	return args
 }
 
+const (
+	defaultTimestampPosition = "top-left"
+	defaultTimestampSize     = "16"
+)
+
+// timestampFilter returns a drawtext filter that burns the wall-clock
+// time of each frame, start time plus the source PTS. The text is drawn
+// before the scale filter, the font size and margin are multiplied by
+// the scale to get the configured size in the timeline video.
+func timestampFilter(start time.Time, c config, scale string) string {
+	scaleFactor, err := strconv.Atoi(scale)
+	if err != nil || scaleFactor < 1 {
+		scaleFactor = 1
+	}
+	size, err := strconv.Atoi(c.timestampSize)
+	if err != nil || size <= 0 {
+		size, _ = strconv.Atoi(defaultTimestampSize)
+	}
+	fontSize := size * scaleFactor
+	margin := strconv.Itoa(fontSize / 2)
+
+	x, y := margin, margin
+	switch c.timestampPosition {
+	case "top-right":
+		x = "w-tw-" + margin
+	case "bottom-left":
+		y = "h-th-" + margin
+	case "bottom-right":
+		x, y = "w-tw-"+margin, "h-th-"+margin
+	}
+
+	// gmtime with the zone offset added to the epoch.
+	_, zoneOffset := start.Zone()
+	offset := float64(start.UnixMilli())/1000 + float64(zoneOffset)
+	text := "%{pts\\:gmtime\\:" +
+		strconv.FormatFloat(offset, 'f', 3, 64) +
+		"\\:%Y-%m-%d %T}"
+
+	return "drawtext=text='" + text + "'" +
+		":x=" + x + ":y=" + y +
+		":fontsize=" + strconv.Itoa(fontSize) +
+		":fontcolor=white:box=1:boxcolor=black@0.5"
+}
+
 func parseQuality(q string) string {
 	switch q {
 	case "1":
@@ -208,9 +345,12 @@ func parseFrameRate(rate string) string {
 }
 
 type config struct {
-	scale     string
-	quality   string
-	frameRate string
+	scale             string
+	quality           string
+	frameRate         string
+	timestamp         bool
+	timestampPosition string
+	timestampSize     string
 }
 
 type rawConfigV1 struct {
@@ -219,35 +359,52 @@ type rawConfigV1 struct {
 	FrameRate string `json:"frameRate"`
 }
 
+type rawConfigV2 struct {
+	Scale             string `json:"scale"`
+	Quality           string `json:"quality"`
+	FrameRate         string `json:"frameRate"`
+	Timestamp         string `json:"timestamp"`
+	TimestampPosition string `json:"timestampPosition"`
+	TimestampSize     string `json:"timestampSize"`
+}
+
 func parseConfig(conf monitor.Config) (*config, error) {
-	var rawConf rawConfigV1
-	rawTimeline := conf.Get("timeline")
-	if rawTimeline != "" {
-		err := json.Unmarshal([]byte(rawTimeline), &rawConf)
-		if err != nil {
-			return nil, fmt.Errorf("unmarshal doods: %w", err)
-		}
+	var rawConf rawConfigV2
+	err := conf.GetJSON("timeline", &rawConf)
+	if err != nil && !errors.Is(err, monitor.ErrConfigKeyAbsent) {
+		return nil, err
 	}
 	return &config{
-		scale:     rawConf.Scale,
-		quality:   rawConf.Quality,
-		frameRate: rawConf.FrameRate,
+		scale:             rawConf.Scale,
+		quality:           rawConf.Quality,
+		frameRate:         rawConf.FrameRate,
+		timestamp:         rawConf.Timestamp == "true",
+		timestampPosition: rawConf.TimestampPosition,
+		timestampSize:     rawConf.TimestampSize,
 	}, nil
 }
 
-const currentConfigVersion = 1
+const currentConfigVersion = 2
 
-func migrate(c monitor.RawConfig) error {
+func migrate(c monitor.RawConfig) (monitor.MigrationVersions, error) {
 	configVersion, _ := strconv.Atoi(c["timelineConfigVersion"])
 
 	if configVersion < 1 {
 		if err := migrateV0toV1(c); err != nil {
-			return fmt.Errorf("timeline v0 to v1: %w", err)
+			return monitor.MigrationVersions{}, fmt.Errorf("timeline v0 to v1: %w", err)
+		}
+	}
+	if configVersion < 2 {
+		if err := migrateV1toV2(c); err != nil {
+			return monitor.MigrationVersions{}, fmt.Errorf("timeline v1 to v2: %w", err)
 		}
 	}
 
 	c["timelineConfigVersion"] = strconv.Itoa(currentConfigVersion)
-	return nil
+	return monitor.MigrationVersions{
+		From: configVersion,
+		To:   currentConfigVersion,
+	}, nil
 }
 
 func migrateV0toV1(c monitor.RawConfig) error {
@@ -268,3 +425,28 @@ func migrateV0toV1(c monitor.RawConfig) error {
 	c["timeline"] = string(rawConfig)
 	return nil
 }
+
+func migrateV1toV2(c monitor.RawConfig) error {
+	var v1 rawConfigV1
+	if c["timeline"] != "" {
+		if err := json.Unmarshal([]byte(c["timeline"]), &v1); err != nil {
+			return fmt.Errorf("unmarshal raw config: %w", err)
+		}
+	}
+
+	config := rawConfigV2{
+		Scale:             v1.Scale,
+		Quality:           v1.Quality,
+		FrameRate:         v1.FrameRate,
+		Timestamp:         "false",
+		TimestampPosition: defaultTimestampPosition,
+		TimestampSize:     defaultTimestampSize,
+	}
+
+	rawConfig, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("marshal raw config: %w", err)
+	}
+	c["timeline"] = string(rawConfig)
+	return nil
+}
@@ -3,10 +3,16 @@
 package timeline
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"nvr/pkg/monitor"
+	"nvr/pkg/web/api"
 
 	"github.com/stretchr/testify/require"
 )
@@ -16,6 +22,7 @@ func TestGenArgs(t *testing.T) {
 		actual := genArgs(
 			"2",
 			"4",
+			time.Time{},
 			config{
 				scale:     "full",
 				quality:   "1",
@@ -38,6 +45,7 @@ func TestGenArgs(t *testing.T) {
 		actual := genArgs(
 			"2",
 			"4",
+			time.Time{},
 			config{
 				scale:     "half",
 				quality:   "12",
@@ -57,7 +65,7 @@ func TestGenArgs(t *testing.T) {
 		require.Equal(t, actual, expected)
 	})
 	t.Run("defaults", func(t *testing.T) {
-		actual := genArgs("2", "4", config{})
+		actual := genArgs("2", "4", time.Time{}, config{})
 		expected := []string{
 			"-n", "-loglevel", "2",
 			"-threads", "1", "-discard", "nokey",
@@ -70,25 +78,93 @@ func TestGenArgs(t *testing.T) {
 		}
 		require.Equal(t, actual, expected)
 	})
+	t.Run("timestamp", func(t *testing.T) {
+		start := time.Date(2001, 2, 3, 4, 5, 6, 7e6, time.FixedZone("", 3600))
+		actual := genArgs(
+			"2",
+			"4",
+			start,
+			config{
+				scale:             "half",
+				timestamp:         true,
+				timestampPosition: "bottom-right",
+				timestampSize:     "10",
+			},
+		)
+		expected := []string{
+			"-n", "-loglevel", "2",
+			"-threads", "1", "-discard", "nokey",
+			"-i", "-", "-an",
+			"-c:v", "libx264", "-x264-params", "keyint=4",
+			"-preset", "veryfast", "-tune", "fastdecode", "-crf", "27",
+			"-vsync", "vfr", "-vf",
+			"drawtext=text='%{pts\\:gmtime\\:981173106.007\\:%Y-%m-%d %T}'" +
+				":x=w-tw-10:y=h-th-10:fontsize=20:fontcolor=white:box=1:boxcolor=black@0.5" +
+				",mpdecimate,fps=6,mpdecimate,scale='iw/2:ih/2'",
+			"-movflags", "empty_moov+default_base_moof+frag_keyframe",
+			"-f", "mp4", "4",
+		}
+		require.Equal(t, actual, expected)
+	})
+}
+
+func TestTimestampFilter(t *testing.T) {
+	start := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	cases := map[string]struct {
+		c        config
+		scale    string
+		expected string
+	}{
+		"defaults": {
+			config{},
+			"1",
+			"drawtext=text='%{pts\\:gmtime\\:981173106.000\\:%Y-%m-%d %T}'" +
+				":x=8:y=8:fontsize=16:fontcolor=white:box=1:boxcolor=black@0.5",
+		},
+		"topRightScaled": {
+			config{timestampPosition: "top-right", timestampSize: "12"},
+			"8",
+			"drawtext=text='%{pts\\:gmtime\\:981173106.000\\:%Y-%m-%d %T}'" +
+				":x=w-tw-48:y=48:fontsize=96:fontcolor=white:box=1:boxcolor=black@0.5",
+		},
+		"bottomLeftInvalidSize": {
+			config{timestampPosition: "bottom-left", timestampSize: "x"},
+			"2",
+			"drawtext=text='%{pts\\:gmtime\\:981173106.000\\:%Y-%m-%d %T}'" +
+				":x=16:y=h-th-16:fontsize=32:fontcolor=white:box=1:boxcolor=black@0.5",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual := timestampFilter(start, tc.c, tc.scale)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
 }
 
 func TestParseConfig(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		timeline := `{
-			"scale":     "1",
-			"quality":   "2",
-			"frameRate": "3"
+			"scale":             "1",
+			"quality":           "2",
+			"frameRate":         "3",
+			"timestamp":         "true",
+			"timestampPosition": "4",
+			"timestampSize":     "5"
 		}`
 		c := monitor.NewConfig(monitor.RawConfig{
-			"timelineConfigVersion": "1",
+			"timelineConfigVersion": "2",
 			"timeline":              timeline,
 		})
 		actual, err := parseConfig(c)
 		require.NoError(t, err)
 		expected := config{
-			scale:     "1",
-			quality:   "2",
-			frameRate: "3",
+			scale:             "1",
+			quality:           "2",
+			frameRate:         "3",
+			timestamp:         true,
+			timestampPosition: "4",
+			timestampSize:     "5",
 		}
 		require.Equal(t, expected, *actual)
 	})
@@ -107,17 +183,21 @@ func TestMigrate(t *testing.T) {
 		"timelineQuality":   "2",
 		"timelineFrameRate": "3",
 	}
-	err := migrate(c)
+	versions, err := migrate(c)
 	require.NoError(t, err)
+	require.Equal(t, monitor.MigrationVersions{From: 0, To: 2}, versions)
 	actual := c
 
 	timeline := strings.Join(strings.Fields(`{
-		"scale":     "1",
-		"quality":   "2",
-		"frameRate": "3"
+		"scale":             "1",
+		"quality":           "2",
+		"frameRate":         "3",
+		"timestamp":         "false",
+		"timestampPosition": "top-left",
+		"timestampSize":     "16"
 	}`), "")
 	expected := map[string]string{
-		"timelineConfigVersion": "1",
+		"timelineConfigVersion": "2",
 		"timeline":              timeline,
 	}
 	require.Equal(t, expected, actual)
@@ -129,7 +209,7 @@ func TestMigrateV0ToV1(t *testing.T) {
 		"timelineQuality":   "2",
 		"timelineFrameRate": "3",
 	}
-	err := migrate(c)
+	err := migrateV0toV1(c)
 	require.NoError(t, err)
 	actual := c
 
@@ -139,8 +219,98 @@ func TestMigrateV0ToV1(t *testing.T) {
 		"frameRate": "3"
 	}`), "")
 	expected := map[string]string{
+		"timeline": timeline,
+	}
+	require.Equal(t, expected, actual)
+}
+
+func TestMigrateV1ToV2(t *testing.T) {
+	c := map[string]string{
 		"timelineConfigVersion": "1",
+		"timeline":              `{"scale":"1","quality":"2","frameRate":"3"}`,
+	}
+	_, err := migrate(c)
+	require.NoError(t, err)
+	actual := c
+
+	timeline := strings.Join(strings.Fields(`{
+		"scale":             "1",
+		"quality":           "2",
+		"frameRate":         "3",
+		"timestamp":         "false",
+		"timestampPosition": "top-left",
+		"timestampSize":     "16"
+	}`), "")
+	expected := map[string]string{
+		"timelineConfigVersion": "2",
 		"timeline":              timeline,
 	}
 	require.Equal(t, expected, actual)
 }
+
+func TestHandleTimeline(t *testing.T) {
+	get := func(h http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("range", func(t *testing.T) {
+		recordingsDirs, recPath := newTestRecordingsDir(t)
+		require.NoError(t, os.WriteFile(recPath+".timeline", []byte("0123456789"), 0o600))
+		h := handleTimeline(recordingsDirs, newJobs(1))
+
+		w := get(h, "/api/recording/timeline/"+testRecID, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+		require.Equal(t, "0123456789", w.Body.String())
+
+		w = get(h, "/api/recording/timeline/"+testRecID, http.Header{"Range": {"bytes=2-5"}})
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		require.Equal(t, "bytes 2-5/10", w.Header().Get("Content-Range"))
+		require.Equal(t, "2345", w.Body.String())
+
+		w = get(h, "/api/recording/timeline/"+testRecID, http.Header{"Range": {"bytes=7-"}})
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		require.Equal(t, "789", w.Body.String())
+
+		w = get(h, "/api/recording/timeline/"+testRecID, http.Header{"Range": {"bytes=20-"}})
+		require.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+	})
+	t.Run("generating", func(t *testing.T) {
+		recordingsDirs, _ := newTestRecordingsDir(t)
+		j := newJobs(1)
+		j.setStatus(testRecID, statusGenerating)
+		h := handleTimeline(recordingsDirs, j)
+
+		w := get(h, "/api/recording/timeline/"+testRecID, nil)
+		require.Equal(t, http.StatusConflict, w.Code)
+		var body api.Error
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		require.Equal(t, map[string]string{"status": statusGenerating}, body.Details)
+	})
+	t.Run("notFound", func(t *testing.T) {
+		recordingsDirs, recPath := newTestRecordingsDir(t)
+		require.NoError(t, os.WriteFile(recPath+failedMarkerExt, []byte("x"), 0o600))
+		h := handleTimeline(recordingsDirs, newJobs(1))
+
+		w := get(h, "/api/recording/timeline/"+testRecID, nil)
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+	t.Run("status", func(t *testing.T) {
+		recordingsDirs, recPath := newTestRecordingsDir(t)
+		require.NoError(t, os.WriteFile(recPath+failedMarkerExt, []byte("x"), 0o600))
+		h := handleTimelineStatus(recordingsDirs, newJobs(1))
+
+		w := get(h, "/api/recording/timeline/status/"+testRecID, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{"status":"failed","error":"x"}`, w.Body.String())
+
+		w = get(h, "/api/recording/timeline/status/x", nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
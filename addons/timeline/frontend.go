@@ -3,9 +3,9 @@
 package timeline
 
 import (
-	_ "embed"
+	"embed"
 	"fmt"
-	"net/http"
+	"io/fs"
 	"os"
 	"strings"
 )
@@ -77,6 +77,17 @@ func modifySettingsjs(tpl string) string { //nolint:funlen
 						initial: 15,
 					}
 				),
+				timestamp: fieldTemplate.toggle("Timestamp", "false"),
+				timestampPosition: fieldTemplate.select(
+					"Timestamp position",
+					["top-left", "top-right", "bottom-left", "bottom-right"],
+					"top-left",
+				),
+				timestampSize: fieldTemplate.integer(
+					"Timestamp size",
+					"16",
+					"16",
+				),
 			};
 
 			const form = newForm(fields);
@@ -160,14 +171,13 @@ func modifySettingsjs(tpl string) string { //nolint:funlen
 	return strings.ReplaceAll(tpl, target, javascript+target)
 }
 
-//go:embed timeline.mjs
-var timelineMjsFile []byte
+//go:embed static/timeline
+var staticFiles embed.FS
 
-func serveTimelineMjs() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-		w.Header().Set("content-type", "text/javascript")
-		if _, err := w.Write(timelineMjsFile); err != nil {
-			http.Error(w, "could not write: "+err.Error(), http.StatusInternalServerError)
-		}
-	})
+func staticFS() fs.FS {
+	fsys, err := fs.Sub(staticFiles, "static/timeline")
+	if err != nil {
+		panic(err)
+	}
+	return fsys
 }
@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package timeline
+
+import (
+	"errors"
+	"fmt"
+	"nvr/pkg/storage"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Timeline statuses.
+const (
+	statusAbsent     = "absent"
+	statusPending    = "pending"
+	statusGenerating = "generating"
+	statusFailed     = "failed"
+	statusReady      = "ready"
+)
+
+// Number of timelines that are generated at the same time.
+const generateWorkers = 2
+
+// The failure marker is written before the generation starts and is
+// replaced by the error, a marker is left behind if the app crashes.
+const (
+	failedMarkerExt   = ".timeline_failed"
+	interruptedErrMsg = "generation was interrupted"
+)
+
+// jobs limits the number of concurrent generations and keeps
+// track of the recordings that are queued or being generated.
+type jobs struct {
+	workers chan struct{}
+
+	mu       sync.Mutex
+	statuses map[string]string // map[recordingID]status.
+}
+
+func newJobs(workers int) *jobs {
+	return &jobs{
+		workers:  make(chan struct{}, workers),
+		statuses: make(map[string]string),
+	}
+}
+
+// run queues the generation of the timeline of the recording
+// and blocks until it's generated. A failure marker with the
+// error is left next to the recording if the generation fails.
+func (j *jobs) run(recPath string, generate func() error) error {
+	recID := filepath.Base(recPath)
+	j.setStatus(recID, statusPending)
+	defer j.setStatus(recID, "")
+
+	j.workers <- struct{}{}
+	defer func() { <-j.workers }()
+	j.setStatus(recID, statusGenerating)
+
+	markerPath := recPath + failedMarkerExt
+	if err := os.WriteFile(markerPath, []byte(interruptedErrMsg), 0o600); err != nil {
+		return fmt.Errorf("write failure marker: %w", err)
+	}
+
+	if err := generate(); err != nil {
+		os.WriteFile(markerPath, []byte(err.Error()), 0o600) //nolint:errcheck
+		return err
+	}
+
+	if err := os.Remove(markerPath); err != nil {
+		return fmt.Errorf("remove failure marker: %w", err)
+	}
+	return nil
+}
+
+func (j *jobs) setStatus(recID string, status string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if status == "" {
+		delete(j.statuses, recID)
+		return
+	}
+	j.statuses[recID] = status
+}
+
+// timelineStatus the status of the timeline of a recording.
+type timelineStatus struct {
+	Status string `json:"status"`
+
+	// Error of the last generation if it failed.
+	Error string `json:"error,omitempty"`
+}
+
+// status returns the status of the timeline, queued
+// and running jobs take precedence over the files.
+func (j *jobs) status(recordingsDirs storage.RecordingsDirs, recID string) (*timelineStatus, error) {
+	if status, exist := j.jobStatus(recID); exist {
+		return status, nil
+	}
+
+	recPath, err := storage.RecordingIDToPath(recID)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(recordingsDirs.Find(recID), recPath)
+
+	_, err = os.Stat(path + ".timeline")
+	if err == nil {
+		return &timelineStatus{Status: statusReady}, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return failedStatus(path)
+}
+
+// detailStatus returns the status of the timeline from the files listed
+// in the recording detail, the marker is only read if the generation failed.
+func (j *jobs) detailStatus(detail *storage.RecordingDetail) (*timelineStatus, error) {
+	if status, exist := j.jobStatus(detail.ID); exist {
+		return status, nil
+	}
+	if detail.HasFile(".timeline") {
+		return &timelineStatus{Status: statusReady}, nil
+	}
+	if !detail.HasFile(failedMarkerExt) {
+		return &timelineStatus{Status: statusAbsent}, nil
+	}
+	return failedStatus(detail.Path())
+}
+
+func (j *jobs) jobStatus(recID string) (*timelineStatus, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status, exist := j.statuses[recID]
+	if !exist {
+		return nil, false
+	}
+	return &timelineStatus{Status: status}, true
+}
+
+// failedStatus returns the failed status if the
+// recording has a failure marker, absent otherwise.
+func failedStatus(path string) (*timelineStatus, error) {
+	marker, err := os.ReadFile(path + failedMarkerExt)
+	if err == nil {
+		return &timelineStatus{Status: statusFailed, Error: string(marker)}, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return &timelineStatus{Status: statusAbsent}, nil
+}
@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package timeline
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testRecID  = "2000-01-01_02-02-02_m1"
+	testRecID2 = "2000-01-01_03-03-03_m1"
+)
+
+// newTestRecordingsDir returns the recordings
+// directory and the path of the test recording.
+func newTestRecordingsDir(t *testing.T) (storage.RecordingsDirs, string) {
+	recordingsDir := t.TempDir()
+	recDir := filepath.Join(recordingsDir, "2000", "01", "01", "m1")
+	require.NoError(t, os.MkdirAll(recDir, 0o700))
+	return storage.RecordingsDirs{recordingsDir}, filepath.Join(recDir, testRecID)
+}
+
+func TestJobs(t *testing.T) {
+	t.Run("transitions", func(t *testing.T) {
+		recordingsDirs, recPath := newTestRecordingsDir(t)
+		recPath2 := filepath.Join(filepath.Dir(recPath), testRecID2)
+		j := newJobs(1)
+
+		requireStatus := func(recID string, expected timelineStatus) {
+			t.Helper()
+			status, err := j.status(recordingsDirs, recID)
+			require.NoError(t, err)
+			require.Equal(t, expected, *status)
+		}
+		requireStatus(testRecID, timelineStatus{Status: statusAbsent})
+
+		started, release := make(chan struct{}), make(chan struct{})
+		done := make(chan error)
+		go func() {
+			done <- j.run(recPath, func() error {
+				close(started)
+				<-release
+				return os.WriteFile(recPath+".timeline", nil, 0o600)
+			})
+		}()
+		<-started
+		requireStatus(testRecID, timelineStatus{Status: statusGenerating})
+
+		// The second job waits for the worker.
+		done2 := make(chan error)
+		go func() {
+			done2 <- j.run(recPath2, func() error { return nil })
+		}()
+		require.Eventually(t, func() bool {
+			status, err := j.status(recordingsDirs, testRecID2)
+			return err == nil && status.Status == statusPending
+		}, time.Second, time.Millisecond)
+
+		close(release)
+		require.NoError(t, <-done)
+		require.NoError(t, <-done2)
+		requireStatus(testRecID, timelineStatus{Status: statusReady})
+
+		// The failure marker is removed on success.
+		_, err := os.Stat(recPath + failedMarkerExt)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+	t.Run("failed", func(t *testing.T) {
+		recordingsDirs, recPath := newTestRecordingsDir(t)
+		j := newJobs(1)
+
+		err := j.run(recPath, func() error { return errors.New("mock") })
+		require.Error(t, err)
+
+		status, err := j.status(recordingsDirs, testRecID)
+		require.NoError(t, err)
+		require.Equal(t, timelineStatus{Status: statusFailed, Error: "mock"}, *status)
+	})
+	t.Run("crashed", func(t *testing.T) {
+		recordingsDirs, recPath := newTestRecordingsDir(t)
+		j := newJobs(1)
+
+		// The marker is written before the generation.
+		err := j.run(recPath, func() error {
+			marker, err := os.ReadFile(recPath + failedMarkerExt)
+			require.NoError(t, err)
+			require.Equal(t, interruptedErrMsg, string(marker))
+			return nil
+		})
+		require.NoError(t, err)
+
+		// Marker left by a previous process.
+		require.NoError(t, os.WriteFile(recPath+failedMarkerExt, []byte(interruptedErrMsg), 0o600))
+		status, err := j.status(recordingsDirs, testRecID)
+		require.NoError(t, err)
+		expected := timelineStatus{Status: statusFailed, Error: interruptedErrMsg}
+		require.Equal(t, expected, *status)
+	})
+	t.Run("invalidIDErr", func(t *testing.T) {
+		_, err := newJobs(1).status(nil, "x")
+		require.ErrorIs(t, err, storage.ErrInvalidRecordingID)
+	})
+	t.Run("detail", func(t *testing.T) {
+		recordingsDirs, recPath := newTestRecordingsDir(t)
+		j := newJobs(1)
+		require.NoError(t, os.WriteFile(recPath+".json", []byte("{}"), 0o600))
+
+		requireStatus := func(expected timelineStatus) {
+			t.Helper()
+			detail, err := storage.ReadRecordingDetail(recordingsDirs[0], testRecID, time.UTC)
+			require.NoError(t, err)
+			status, err := j.detailStatus(detail)
+			require.NoError(t, err)
+			require.Equal(t, expected, *status)
+		}
+		requireStatus(timelineStatus{Status: statusAbsent})
+
+		err := j.run(recPath, func() error { return errors.New("mock") })
+		require.Error(t, err)
+		requireStatus(timelineStatus{Status: statusFailed, Error: "mock"})
+
+		require.NoError(t, j.run(recPath, func() error {
+			return os.WriteFile(recPath+".timeline", nil, 0o600)
+		}))
+		requireStatus(timelineStatus{Status: statusReady})
+	})
+}
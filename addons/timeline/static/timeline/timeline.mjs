@@ -1,11 +1,11 @@
 // SPDX-License-Identifier: GPL-2.0-or-later
 
-import { fetchGet } from "./static/scripts/libs/common.mjs";
-import { fromUTC2 } from "./static/scripts/libs/time.mjs";
+import { fetchGet } from "../scripts/libs/common.mjs";
+import { fromUTC2 } from "../scripts/libs/time.mjs";
 import {
 	newOptionsMenu,
 	newOptionsBtn,
-} from "./static/scripts/components/optionsMenu.mjs";
+} from "../scripts/components/optionsMenu.mjs";
 
 async function newPlayer(element) {
 	const $video = element.querySelector(".js-video");
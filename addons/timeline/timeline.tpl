@@ -5,7 +5,7 @@
 <head>
 	{{ template "meta" . }}
 	<script type="module" defer>
-		import { newTimelineViewer } from "./timeline.mjs";
+		import { newTimelineViewer } from "{{ asset "timeline/timeline.mjs" }}";
 		(async () => {
 			const timelineViewer = await newTimelineViewer();
 			timelineViewer.init();
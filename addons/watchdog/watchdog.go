@@ -12,8 +12,10 @@ import (
 	"time"
 )
 
+var nvrAddon = nvr.RegisterAddon("watchdog", "Restart frozen input processes")
+
 func init() {
-	nvr.RegisterMonitorInputProcessHook(onInputProcessStart)
+	nvrAddon.RegisterMonitorInputProcessHook(onInputProcessStart)
 	nvr.RegisterLogSource([]string{"watchdog"})
 }
 
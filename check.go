@@ -0,0 +1,294 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package nvr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"nvr/pkg/group"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"nvr/pkg/video"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Errors.
+var (
+	ErrCheckFailed        = errors.New("check failed")
+	errNoAuthenticator    = errors.New("no authentication addon enabled")
+	errFFmpegVersion      = errors.New("unexpected output from -version")
+	errMigrationsFailed   = errors.New("migrations failed")
+	errCheckEnvNotLoaded  = errors.New("env.yaml could not be loaded")
+	errCheckConfigsFailed = errors.New("configs could not be read")
+)
+
+const checkFFmpegTimeout = 10 * time.Second
+
+// CheckResult is the outcome of a single startup check.
+type CheckResult struct {
+	Name   string
+	Detail string
+	Err    error
+
+	// The check didn't run because a check it depends on failed.
+	Skipped bool
+}
+
+// CheckReport is the outcome of the startup self-test.
+type CheckReport []CheckResult
+
+// Failed returns true if any check failed or was skipped.
+func (r CheckReport) Failed() bool {
+	for _, result := range r {
+		if result.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes the report as a table with one check per line.
+func (r CheckReport) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, result := range r {
+		status := "ok"
+		msg := result.Detail
+		switch {
+		case result.Skipped:
+			status = "skip"
+			msg = result.Err.Error()
+		case result.Err != nil:
+			status = "FAIL"
+			msg = strings.ReplaceAll(result.Err.Error(), "\n", "; ")
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", status, result.Name, msg)
+	}
+	return tw.Flush()
+}
+
+func (r *CheckReport) add(name string, detail string, err error) {
+	*r = append(*r, CheckResult{Name: name, Detail: detail, Err: err})
+}
+
+func (r *CheckReport) skip(err error, names ...string) {
+	for _, name := range names {
+		*r = append(*r, CheckResult{Name: name, Err: err, Skipped: true})
+	}
+}
+
+// runCheckCommand prints the check report and returns
+// ErrCheckFailed if any check failed.
+func runCheckCommand(envPath string) error {
+	report := runCheck(envPath, hooks)
+	if err := report.Write(os.Stdout); err != nil {
+		return err
+	}
+	if report.Failed() {
+		return ErrCheckFailed
+	}
+	return nil
+}
+
+// runCheck loads the configuration through the same code paths as
+// the app and checks the environment without starting anything.
+// Nothing is written except the removed storage probes.
+func runCheck(envPath string, h *hookList) CheckReport { //nolint:funlen
+	var report CheckReport
+
+	envYAML, err := os.ReadFile(envPath)
+	if err != nil {
+		err = fmt.Errorf("could not read env.yaml: %w", err)
+	}
+	var env *storage.ConfigEnv
+	if err == nil {
+		env, err = storage.NewConfigEnv(envPath, envYAML)
+	}
+	report.add("env", envPath, err)
+	if err != nil {
+		report.skip(errCheckEnvNotLoaded,
+			"addons", "auth", "general", "ffmpeg", "ports", "storage", "migrations", "monitors", "groups")
+		return report
+	}
+
+	report.add("addons", "", h.addons.load(filepath.Join(env.ConfigDir, "addons.json")))
+
+	if h.newAuthenticator == nil {
+		report.add("auth", "", errNoAuthenticator)
+	} else {
+		report.add("auth", "", nil)
+	}
+
+	report.add(checkGeneralConfig(env.ConfigDir))
+	report.add(checkFFmpeg(env.FFmpegBin))
+
+	report.add(checkPort("port http", ":"+strconv.Itoa(env.Port)))
+	report.add(checkPort("port rtsp", video.RTSPAddress(*env)))
+	report.add(checkPort("port hls", video.HLSAddress(*env)))
+
+	report.add("config dir", env.ConfigDir, storage.CheckDirWritable(env.ConfigDir))
+	for _, dir := range env.RecordingsDirs() {
+		report.add(checkDirCreatable("storage", dir))
+	}
+
+	monitorConfigDir := filepath.Join(env.ConfigDir, "monitors")
+	if dirExist(monitorConfigDir) {
+		report = append(report, checkMonitors(monitorConfigDir, h)...)
+	} else {
+		report.add("monitors", "no monitors", nil)
+	}
+
+	groupConfigDir := filepath.Join(env.ConfigDir, "groups")
+	if dirExist(groupConfigDir) {
+		report = append(report, checkGroups(groupConfigDir)...)
+	} else {
+		report.add("groups", "no groups", nil)
+	}
+
+	return report
+}
+
+// checkGeneralConfig the config is generated on start if it doesn't exist.
+func checkGeneralConfig(configDir string) (string, string, error) {
+	path := filepath.Join(configDir, "general.json")
+	if !dirExist(path) {
+		return "general", "will be generated", nil
+	}
+	_, err := storage.NewConfigGeneral(configDir)
+	return "general", path, err
+}
+
+// checkFFmpeg runs "ffmpeg -version" and returns the version.
+func checkFFmpeg(bin string) (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), checkFFmpegTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, bin, "-version").Output()
+	if err != nil {
+		return "ffmpeg", "", fmt.Errorf("%v: %w", bin, err)
+	}
+
+	// "ffmpeg version 5.1.2 Copyright (c) 2000-2022 the FFmpeg developers"
+	firstLine, _, _ := strings.Cut(string(output), "\n")
+	fields := strings.Fields(firstLine)
+	if len(fields) < 3 || fields[1] != "version" {
+		return "ffmpeg", "", fmt.Errorf("%v: %w: %q", bin, errFFmpegVersion, firstLine)
+	}
+	return "ffmpeg", fields[2], nil
+}
+
+// checkPort binds and releases the address.
+func checkPort(name string, address string) (string, string, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return name, "", err
+	}
+	return name, address, ln.Close()
+}
+
+// checkDirCreatable probes the directory, or the closest existing
+// parent if the directory will be created when the app starts.
+func checkDirCreatable(name string, dir string) (string, string, error) {
+	probe := dir
+	for {
+		_, err := os.Stat(probe)
+		if err == nil {
+			break
+		}
+		parent := filepath.Dir(probe)
+		if !errors.Is(err, os.ErrNotExist) || parent == probe {
+			return name, "", err
+		}
+		probe = parent
+	}
+
+	detail := dir
+	if probe != dir {
+		detail += " (will be created)"
+	}
+	return name, detail, storage.CheckDirWritable(probe)
+}
+
+// checkMonitors runs the migrations in dry-run and
+// validates each migrated monitor config.
+func checkMonitors(configDir string, h *hookList) CheckReport {
+	var report CheckReport
+
+	diff, reports, err := monitor.MigrateDryRun(configDir, h.migrationMonitor)
+	if err != nil {
+		report.add("migrations", "", err)
+		report.skip(errCheckConfigsFailed, "monitors")
+		return report
+	}
+	var failed []string
+	for _, r := range reports {
+		if r.Err != nil {
+			failed = append(failed, r.String())
+		}
+	}
+	if len(failed) != 0 {
+		report.add("migrations", "", fmt.Errorf("%w: %v", errMigrationsFailed, strings.Join(failed, "; ")))
+	} else if diff != "" {
+		report.add("migrations", "pending, preview with -migrate-dry-run", nil)
+	} else {
+		report.add("migrations", "no changes", nil)
+	}
+
+	configs, _, err := monitor.MigratedConfigs(configDir, h.migrationMonitor)
+	if err != nil {
+		report.add("monitors", "", err)
+		return report
+	}
+	if len(configs) == 0 {
+		report.add("monitors", "no monitors", nil)
+		return report
+	}
+	for _, id := range sortedKeys(configs) {
+		rawConf := configs[id]
+		report.add("monitor "+id, rawConf["name"], h.validateMonitor(rawConf))
+	}
+	return report
+}
+
+func checkGroups(configDir string) CheckReport {
+	var report CheckReport
+
+	groupManager, err := group.NewManager(configDir)
+	if err != nil {
+		report.add("groups", "", err)
+		return report
+	}
+	configs := groupManager.Configs()
+	if len(configs) == 0 {
+		report.add("groups", "no groups", nil)
+		return report
+	}
+	for _, id := range sortedKeys(configs) {
+		c := configs[id]
+		report.add("group "+id, c["name"], group.ValidateConfig(c))
+	}
+	return report
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func dirExist(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
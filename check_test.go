@@ -0,0 +1,314 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package nvr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"nvr/pkg/audit"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"nvr/pkg/web/auth"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type checkTestEnv struct {
+	homeDir   string
+	configDir string
+	envPath   string
+	ffmpegBin string
+	ports     [3]int
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func writeTestFile(t *testing.T, path string, content string, perm os.FileMode) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+	require.NoError(t, os.WriteFile(path, []byte(content), perm))
+}
+
+const fakeFFmpeg = "#!/bin/sh\n" +
+	"echo 'ffmpeg version 5.1.2 Copyright (c) 2000-2022 the FFmpeg developers'\n"
+
+// newCheckTestEnv creates a environment where every check passes.
+func newCheckTestEnv(t *testing.T) *checkTestEnv {
+	t.Helper()
+	homeDir := t.TempDir()
+	e := &checkTestEnv{
+		homeDir:   homeDir,
+		configDir: filepath.Join(homeDir, "configs"),
+		envPath:   filepath.Join(homeDir, "configs", "env.yaml"),
+		ffmpegBin: filepath.Join(homeDir, "ffmpeg"),
+		ports:     [3]int{freePort(t), freePort(t), freePort(t)},
+	}
+	writeTestFile(t, filepath.Join(homeDir, "go"), "", 0o700)
+	writeTestFile(t, e.ffmpegBin, fakeFFmpeg, 0o700)
+	e.writeEnv(t, "")
+
+	writeTestFile(t, filepath.Join(e.configDir, "monitors", "1.json"), `{
+		"id": "1",
+		"name": "one",
+		"enable": "true",
+		"mainStream": "rtsp://x",
+		"videoLength": "15",
+		"timestampOffset": "500"
+	}`, 0o600)
+	writeTestFile(t, filepath.Join(e.configDir, "groups", "1.json"), `{
+		"id": "1",
+		"name": "one",
+		"monitors": "[\"1\"]"
+	}`, 0o600)
+	return e
+}
+
+func (e *checkTestEnv) writeEnv(t *testing.T, extra string) {
+	t.Helper()
+	env := fmt.Sprintf("port: %d\nrtspPort: %d\nhlsPort: %d\n"+
+		"goBin: %v\nffmpegBin: %v\nhomeDir: %v\n",
+		e.ports[0], e.ports[1], e.ports[2],
+		filepath.Join(e.homeDir, "go"), e.ffmpegBin, e.homeDir,
+	)
+	writeTestFile(t, e.envPath, env+extra, 0o600)
+}
+
+func newCheckTestHooks() *hookList {
+	h := &hookList{addons: newAddonRegistry()}
+	h.newAuthenticator = func(storage.ConfigEnv, *log.Logger, audit.Func) (auth.Authenticator, error) {
+		return nil, nil
+	}
+	return h
+}
+
+// checkErrors returns the error of each check by name.
+func checkErrors(report CheckReport) map[string]error {
+	errs := make(map[string]error)
+	for _, result := range report {
+		if result.Err != nil {
+			errs[result.Name] = result.Err
+		}
+	}
+	return errs
+}
+
+func TestRunCheck(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		report := runCheck(e.envPath, newCheckTestHooks())
+		require.False(t, report.Failed(), checkErrors(report))
+
+		details := make(map[string]string)
+		for _, result := range report {
+			details[result.Name] = result.Detail
+		}
+		require.Equal(t, "5.1.2", details["ffmpeg"])
+		require.Equal(t, ":"+strconv.Itoa(e.ports[0]), details["port http"])
+		require.Equal(t, "127.0.0.1:"+strconv.Itoa(e.ports[1]), details["port rtsp"])
+		require.Equal(t, "one", details["monitor 1"])
+		require.Equal(t, "one", details["group 1"])
+		require.Equal(t, "no changes", details["migrations"])
+		require.Equal(t, "will be generated", details["general"])
+
+		// Nothing was written.
+		_, err := os.Stat(filepath.Join(e.configDir, "general.json"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+		_, err = os.Stat(filepath.Join(e.homeDir, "storage"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+	t.Run("envMissing", func(t *testing.T) {
+		report := runCheck(filepath.Join(t.TempDir(), "env.yaml"), newCheckTestHooks())
+		require.True(t, report.Failed())
+		require.ErrorIs(t, report[0].Err, os.ErrNotExist)
+		for _, result := range report[1:] {
+			require.True(t, result.Skipped)
+		}
+	})
+	t.Run("ffmpegPathWrong", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		require.NoError(t, os.Remove(e.ffmpegBin))
+		report := runCheck(e.envPath, newCheckTestHooks())
+		require.ErrorIs(t, checkErrors(report)["env"], os.ErrNotExist)
+	})
+	t.Run("ffmpegNotExecutable", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		require.NoError(t, os.Chmod(e.ffmpegBin, 0o600))
+		report := runCheck(e.envPath, newCheckTestHooks())
+		require.ErrorIs(t, checkErrors(report)["env"], storage.ErrNotExecutable)
+	})
+	t.Run("ffmpegUnexpectedOutput", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		writeTestFile(t, e.ffmpegBin, "#!/bin/sh\necho nil\n", 0o700)
+		report := runCheck(e.envPath, newCheckTestHooks())
+		require.ErrorIs(t, checkErrors(report)["ffmpeg"], errFFmpegVersion)
+	})
+	t.Run("portTaken", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		ln, err := net.Listen("tcp", ":"+strconv.Itoa(e.ports[0]))
+		require.NoError(t, err)
+		defer ln.Close()
+		ln2, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(e.ports[1]))
+		require.NoError(t, err)
+		defer ln2.Close()
+
+		errs := checkErrors(runCheck(e.envPath, newCheckTestHooks()))
+		require.Len(t, errs, 2)
+		require.Error(t, errs["port http"])
+		require.Error(t, errs["port rtsp"])
+	})
+	t.Run("storageNotWritable", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("root can write to read-only directories")
+		}
+		e := newCheckTestEnv(t)
+		storageDir := filepath.Join(e.homeDir, "storage")
+		require.NoError(t, os.Mkdir(storageDir, 0o500))
+
+		errs := checkErrors(runCheck(e.envPath, newCheckTestHooks()))
+		require.ErrorIs(t, errs["env"], os.ErrPermission)
+	})
+	t.Run("storageIsFile", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		writeTestFile(t, filepath.Join(e.homeDir, "storage"), "", 0o600)
+
+		errs := checkErrors(runCheck(e.envPath, newCheckTestHooks()))
+		require.ErrorIs(t, errs["env"], storage.ErrNotDir)
+	})
+	t.Run("configDirNotWritable", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("root can write to read-only directories")
+		}
+		e := newCheckTestEnv(t)
+		require.NoError(t, os.Chmod(e.configDir, 0o500))
+		t.Cleanup(func() { os.Chmod(e.configDir, 0o700) }) //nolint:errcheck
+
+		errs := checkErrors(runCheck(e.envPath, newCheckTestHooks()))
+		require.ErrorIs(t, errs["config dir"], os.ErrPermission)
+	})
+	t.Run("noAuthenticator", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		h := newCheckTestHooks()
+		h.newAuthenticator = nil
+		errs := checkErrors(runCheck(e.envPath, h))
+		require.Len(t, errs, 1)
+		require.ErrorIs(t, errs["auth"], errNoAuthenticator)
+	})
+	t.Run("malformedGeneralConfig", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		writeTestFile(t, filepath.Join(e.configDir, "general.json"), "{", 0o600)
+		errs := checkErrors(runCheck(e.envPath, newCheckTestHooks()))
+		require.Len(t, errs, 1)
+		require.Error(t, errs["general"])
+	})
+	t.Run("malformedAddonStates", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		writeTestFile(t, filepath.Join(e.configDir, "addons.json"), "{", 0o600)
+		errs := checkErrors(runCheck(e.envPath, newCheckTestHooks()))
+		require.Len(t, errs, 1)
+		require.Error(t, errs["addons"])
+	})
+	t.Run("malformedMonitorJSON", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		writeTestFile(t, filepath.Join(e.configDir, "monitors", "2.json"), `{"id":`, 0o600)
+
+		report := runCheck(e.envPath, newCheckTestHooks())
+		errs := checkErrors(report)
+		require.Len(t, errs, 2)
+		require.Error(t, errs["migrations"])
+		require.ErrorIs(t, errs["monitors"], errCheckConfigsFailed)
+	})
+	t.Run("invalidMonitor", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		writeTestFile(t, filepath.Join(e.configDir, "monitors", "2.json"),
+			`{"id": "2", "enable": "true"}`, 0o600)
+
+		errs := checkErrors(runCheck(e.envPath, newCheckTestHooks()))
+		require.Len(t, errs, 1)
+		require.ErrorIs(t, errs["monitor 2"], monitor.ErrConfigNameMissing)
+		require.ErrorIs(t, errs["monitor 2"], monitor.ErrConfigMainStreamMissing)
+	})
+	t.Run("validateHook", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		h := newCheckTestHooks()
+		h.monitorValidate = append(h.monitorValidate, func(monitor.RawConfig) error {
+			return errStub
+		})
+		errs := checkErrors(runCheck(e.envPath, h))
+		require.Len(t, errs, 1)
+		require.ErrorIs(t, errs["monitor 1"], errStub)
+	})
+	t.Run("migrationFailed", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		h := newCheckTestHooks()
+		h.migrationMonitor = append(h.migrationMonitor, monitor.Migration{
+			Name: "fake",
+			Hook: func(monitor.RawConfig) (monitor.MigrationVersions, error) {
+				return monitor.MigrationVersions{}, errStub
+			},
+		})
+		errs := checkErrors(runCheck(e.envPath, h))
+		require.Len(t, errs, 1)
+		require.ErrorIs(t, errs["migrations"], errMigrationsFailed)
+	})
+	t.Run("migrationPending", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		h := newCheckTestHooks()
+		h.migrationMonitor = append(h.migrationMonitor, monitor.Migration{
+			Name: "fake",
+			Hook: func(c monitor.RawConfig) (monitor.MigrationVersions, error) {
+				c["name"] = "migrated"
+				return monitor.MigrationVersions{From: 0, To: 1}, nil
+			},
+		})
+		report := runCheck(e.envPath, h)
+		require.False(t, report.Failed())
+
+		// The migrated config is validated, the file is unchanged.
+		for _, result := range report {
+			if result.Name == "monitor 1" {
+				require.Equal(t, "migrated", result.Detail)
+			}
+		}
+		file, err := os.ReadFile(filepath.Join(e.configDir, "monitors", "1.json"))
+		require.NoError(t, err)
+		require.NotContains(t, string(file), "migrated")
+	})
+	t.Run("invalidGroup", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		writeTestFile(t, filepath.Join(e.configDir, "groups", "2.json"),
+			`{"id": "2", "name": "two", "monitors": "1"}`, 0o600)
+		errs := checkErrors(runCheck(e.envPath, newCheckTestHooks()))
+		require.Len(t, errs, 1)
+		require.Error(t, errs["group 2"])
+	})
+}
+
+func TestCheckReportWrite(t *testing.T) {
+	report := CheckReport{
+		{Name: "env", Detail: "/env.yaml"},
+		{Name: "ffmpeg", Err: errors.Join(errStub, errStub)},
+		{Name: "monitors", Err: errCheckConfigsFailed, Skipped: true},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, report.Write(&buf))
+	require.Equal(t, ""+
+		"ok    env       /env.yaml\n"+
+		"FAIL  ffmpeg    stub; stub\n"+
+		"skip  monitors  configs could not be read\n",
+		buf.String())
+	require.True(t, report.Failed())
+	require.False(t, report[:1].Failed())
+}
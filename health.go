@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package nvr
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+	"nvr/pkg/web/api"
+	"sync"
+	"time"
+)
+
+// HealthCheck reports the health of a subsystem, returns nil if
+// the subsystem is healthy. Checks are called by unauthenticated
+// probes and must be cheap, the results are cached.
+type HealthCheck func() error
+
+// healthCacheDuration results are cached to survive aggressive probing.
+const healthCacheDuration = 3 * time.Second
+
+type namedHealthCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// healthChecks a set of checks served by a single endpoint.
+type healthChecks struct {
+	cacheDuration time.Duration
+	now           func() time.Time
+	logf          log.Func
+
+	mu        sync.Mutex
+	checks    []namedHealthCheck
+	failing   []string
+	checkedAt time.Time
+	cached    bool
+
+	// Failing checks of the previous run, used to only log changes.
+	prevFailing map[string]struct{}
+}
+
+func newHealthChecks(logf log.Func) *healthChecks {
+	return &healthChecks{
+		cacheDuration: healthCacheDuration,
+		now:           time.Now,
+		logf:          logf,
+		prevFailing:   make(map[string]struct{}),
+	}
+}
+
+func (h *healthChecks) register(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, namedHealthCheck{name: name, check: check})
+	h.cached = false
+}
+
+// run returns the names of the failing checks.
+func (h *healthChecks) run() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	if h.cached && now.Sub(h.checkedAt) < h.cacheDuration {
+		return h.failing
+	}
+
+	var failing []string
+	currentFailing := make(map[string]struct{})
+	for _, c := range h.checks {
+		err := c.check()
+		if err == nil {
+			if _, exist := h.prevFailing[c.name]; exist {
+				h.logf(log.LevelInfo, "health check recovered: %v", c.name)
+			}
+			continue
+		}
+		failing = append(failing, c.name)
+		currentFailing[c.name] = struct{}{}
+		if _, exist := h.prevFailing[c.name]; !exist {
+			h.logf(log.LevelWarning, "health check failed: %v: %v", c.name, err)
+		}
+	}
+
+	h.failing = failing
+	h.prevFailing = currentFailing
+	h.checkedAt = now
+	h.cached = true
+	return failing
+}
+
+type healthResponse struct {
+	Status  string   `json:"status"`
+	Failing []string `json:"failing,omitempty"`
+}
+
+// handler responds with 503 and the names of the failing checks if any
+// check fails. Errors are only logged, the endpoints are unauthenticated.
+func (h *healthChecks) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		response := healthResponse{Status: "ok"}
+		code := http.StatusOK
+		if failing := h.run(); len(failing) != 0 {
+			response = healthResponse{Status: "unavailable", Failing: failing}
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(response) //nolint:errcheck
+	})
+}
+
+// RegisterLivenessCheck registers a check that's served by /healthz.
+// A failing liveness check means that the app should be restarted.
+func (app *App) RegisterLivenessCheck(name string, check HealthCheck) {
+	app.liveness.register(name, check)
+}
+
+// RegisterReadinessCheck registers a check that's served by /readyz.
+// A failing readiness check means that the app can't do its job yet.
+func (app *App) RegisterReadinessCheck(name string, check HealthCheck) {
+	app.readiness.register(name, check)
+}
+
+// Errors.
+var (
+	errLoggerNotRunning   = errors.New("logger is not running")
+	errRTSPNotListening   = errors.New("rtsp server is not listening")
+	errMonitorsNotStarted = errors.New("monitors have not been started")
+	errNoRecordingsDirs   = errors.New("no recordings directories")
+)
+
+// checkDirsWritable fails if any of the directories isn't writable.
+func checkDirsWritable(dirs func() storage.RecordingsDirs) HealthCheck {
+	return func() error {
+		d := dirs()
+		if len(d) == 0 {
+			return errNoRecordingsDirs
+		}
+		for _, dir := range d {
+			if err := storage.CheckDirWritable(dir); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// flagCheck returns a check that fails with err while ok returns false.
+func flagCheck(ok func() bool, err error) HealthCheck {
+	return func() error {
+		if !ok() {
+			return err
+		}
+		return nil
+	}
+}
+
+// registerCoreHealthChecks registers the checks of the core subsystems.
+// The HTTP server is implicitly checked by the probe itself.
+func (app *App) registerCoreHealthChecks() {
+	app.RegisterLivenessCheck("logger", flagCheck(app.Logger.Running, errLoggerNotRunning))
+
+	app.RegisterReadinessCheck("rtsp", flagCheck(app.videoServer.RTSPListening, errRTSPNotListening))
+	app.RegisterReadinessCheck("storage", checkDirsWritable(app.Env.RecordingsDirs))
+	app.RegisterReadinessCheck("config", flagCheck(app.monitorsStarted.Load, errMonitorsNotStarted))
+}
@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package nvr
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errStub = errors.New("stub")
+
+func newTestHealthChecks() (*healthChecks, *time.Time, *[]string) {
+	now := time.Unix(0, 0)
+	var logs []string
+	h := newHealthChecks(func(_ log.Level, format string, a ...interface{}) {
+		msg, _ := log.Sprintf(format, a...)
+		logs = append(logs, msg)
+	})
+	h.now = func() time.Time { return now }
+	return h, &now, &logs
+}
+
+func TestHealthChecksHandler(t *testing.T) {
+	serve := func(h *healthChecks, method string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/readyz", nil)
+		h.handler().ServeHTTP(w, r)
+		return w
+	}
+	t.Run("ok", func(t *testing.T) {
+		h, _, _ := newTestHealthChecks()
+		h.register("a", func() error { return nil })
+
+		w := serve(h, http.MethodGet)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+		require.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	})
+	t.Run("degraded", func(t *testing.T) {
+		h, _, _ := newTestHealthChecks()
+		h.register("a", func() error { return nil })
+		h.register("b", func() error { return errStub })
+		h.register("c", func() error { return errStub })
+
+		w := serve(h, http.MethodGet)
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.JSONEq(t,
+			`{"status":"unavailable","failing":["b","c"]}`,
+			w.Body.String(),
+		)
+	})
+	t.Run("noChecks", func(t *testing.T) {
+		h, _, _ := newTestHealthChecks()
+		require.Equal(t, http.StatusOK, serve(h, http.MethodGet).Code)
+	})
+	t.Run("head", func(t *testing.T) {
+		h, _, _ := newTestHealthChecks()
+		require.Equal(t, http.StatusOK, serve(h, http.MethodHead).Code)
+	})
+	t.Run("methodNotAllowed", func(t *testing.T) {
+		h, _, _ := newTestHealthChecks()
+		require.Equal(t, http.StatusMethodNotAllowed, serve(h, http.MethodPost).Code)
+	})
+}
+
+func TestHealthChecksCache(t *testing.T) {
+	h, now, logs := newTestHealthChecks()
+	calls := 0
+	var checkErr error
+	h.register("a", func() error {
+		calls++
+		return checkErr
+	})
+
+	require.Empty(t, h.run())
+	require.Empty(t, h.run())
+	require.Equal(t, 1, calls)
+
+	// The cached result is returned until it expires.
+	checkErr = errStub
+	*now = now.Add(healthCacheDuration - 1)
+	require.Empty(t, h.run())
+	require.Equal(t, 1, calls)
+
+	*now = now.Add(1)
+	require.Equal(t, []string{"a"}, h.run())
+	require.Equal(t, 2, calls)
+
+	// Registering a check invalidates the cache.
+	h.register("b", func() error { return nil })
+	require.Equal(t, []string{"a"}, h.run())
+	require.Equal(t, 3, calls)
+
+	// Only state changes are logged.
+	*now = now.Add(healthCacheDuration)
+	h.run()
+	checkErr = nil
+	*now = now.Add(healthCacheDuration)
+	require.Empty(t, h.run())
+	expectedLogs := []string{
+		"health check failed: a: stub",
+		"health check recovered: a",
+	}
+	require.Equal(t, expectedLogs, *logs)
+}
+
+func TestFlagCheck(t *testing.T) {
+	ok := false
+	check := flagCheck(func() bool { return ok }, errStub)
+	require.ErrorIs(t, check(), errStub)
+
+	ok = true
+	require.NoError(t, check())
+}
+
+func TestCheckDirsWritable(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		dir := t.TempDir()
+		check := checkDirsWritable(func() storage.RecordingsDirs {
+			return storage.RecordingsDirs{dir}
+		})
+		require.NoError(t, check())
+
+		// The test file is removed.
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+	t.Run("missingDir", func(t *testing.T) {
+		dir := t.TempDir()
+		check := checkDirsWritable(func() storage.RecordingsDirs {
+			return storage.RecordingsDirs{dir, filepath.Join(dir, "x")}
+		})
+		require.ErrorIs(t, check(), os.ErrNotExist)
+	})
+	t.Run("noDirs", func(t *testing.T) {
+		check := checkDirsWritable(func() storage.RecordingsDirs {
+			return nil
+		})
+		require.ErrorIs(t, check(), errNoRecordingsDirs)
+	})
+}
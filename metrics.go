@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package nvr
+
+import (
+	"fmt"
+	"net/http"
+	"nvr/pkg/log"
+	"nvr/pkg/metrics"
+	"nvr/pkg/monitor"
+	"nvr/pkg/video"
+	"nvr/pkg/web/api"
+)
+
+// metricsHook registers the custom metrics of an addon.
+type metricsHook func(*metrics.Registry) error
+
+// newMetricsRegistry registers the core metrics and calls the addon hooks.
+func newMetricsRegistry(
+	logger *log.Logger,
+	videoServer *video.Server,
+	monitorManager *monitor.Manager,
+	hooks *hookList,
+) (*metrics.Registry, error) {
+	registry := metrics.NewRegistry()
+	logger.RegisterMetrics(registry)
+	videoServer.RegisterMetrics(registry)
+	monitorManager.RegisterMetrics(registry)
+
+	for _, hook := range hooks.metrics {
+		if err := hook(registry); err != nil {
+			return nil, fmt.Errorf("register metrics: %w", err)
+		}
+	}
+	return registry, nil
+}
+
+// metricsHandler serves the metrics in the Prometheus text exposition format.
+func metricsHandler(registry *metrics.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		w.Header().Set("Content-Type", metrics.ContentType)
+		w.Header().Set("Cache-Control", "no-store")
+		if r.Method == http.MethodHead {
+			return
+		}
+		registry.Write(w) //nolint:errcheck
+	})
+}
@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package nvr
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"nvr/pkg/log"
+	"nvr/pkg/metrics"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"nvr/pkg/video"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	reMetricComment = regexp.MustCompile(`^# (HELP|TYPE) [a-zA-Z_:][a-zA-Z0-9_:]* .*$`)
+	reMetricSample  = regexp.MustCompile(
+		`^([a-zA-Z_:][a-zA-Z0-9_:]*(?:\{[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*"` +
+			`(?:,[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*")*\})?) (\S+)$`)
+)
+
+// parseMetrics parses the text exposition format and returns
+// the samples by series, `name{label="value"}`.
+func parseMetrics(t *testing.T, body string) map[string]float64 {
+	t.Helper()
+	samples := make(map[string]float64)
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			require.Regexp(t, reMetricComment, line)
+			continue
+		}
+		match := reMetricSample.FindStringSubmatch(line)
+		require.NotNil(t, match, "invalid line: %q", line)
+
+		value, err := strconv.ParseFloat(match[2], 64)
+		require.NoError(t, err, "invalid value: %q", line)
+		samples[match[1]] = value
+	}
+	return samples
+}
+
+func TestMetricsHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wg := &sync.WaitGroup{}
+	logger := log.NewLogger(wg, nil)
+	require.NoError(t, logger.Start(ctx))
+
+	videoServer := video.NewServer(logger, wg, storage.ConfigEnv{})
+	monitorManager, err := monitor.NewManager(
+		t.TempDir(),
+		storage.ConfigEnv{},
+		func() string { return "" },
+		logger,
+		videoServer,
+		&monitor.Hooks{},
+	)
+	require.NoError(t, err)
+
+	h, path := newTestHooks(t, `{"disabled": false}`)
+	addonCounter := metrics.NewCounterVec("test_addon_total", "Test.", metrics.LabelMonitorID)
+	h.registerAddon("enabled", "").RegisterMetrics(func(r *metrics.Registry) error {
+		return r.Register(addonCounter)
+	})
+	h.registerAddon("disabled", "").RegisterMetrics(func(r *metrics.Registry) error {
+		return r.Register(metrics.NewCounterVec("test_disabled_total", "Test."))
+	})
+	require.NoError(t, h.addons.load(path))
+
+	registry, err := newMetricsRegistry(logger, videoServer, monitorManager, h)
+	require.NoError(t, err)
+
+	// Drive a few events.
+	logger.Log(log.Entry{Level: log.LevelError, Src: "app", Msg: "a"})
+	logger.Log(log.Entry{Level: log.LevelError, Src: "app", Msg: "b"})
+	logger.Log(log.Entry{Level: log.LevelInfo, Src: "monitor", Msg: "c"})
+	addonCounter.With("1").Inc()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	metricsHandler(registry).ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, metrics.ContentType, w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	samples := parseMetrics(t, body)
+	require.Equal(t, float64(2), samples[`nvr_log_errors_total{source="app"}`])
+	require.NotContains(t, samples, `nvr_log_errors_total{source="monitor"}`)
+	require.Equal(t, float64(1), samples[`test_addon_total{monitor_id="1"}`])
+
+	for _, name := range []string{
+		"nvr_ingest_bytes_total",
+		"nvr_rtsp_sessions",
+		"nvr_hls_viewers",
+		"nvr_recording_bytes_total",
+		"nvr_ffmpeg_restarts_total",
+	} {
+		require.Contains(t, body, "# TYPE "+name+" ")
+	}
+	require.NotContains(t, body, "test_disabled_total")
+
+	t.Run("methodNotAllowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/metrics", nil)
+		metricsHandler(registry).ServeHTTP(w, r)
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
@@ -5,10 +5,14 @@ package nvr
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
+	"io"
 	"net/http"
+	"nvr/pkg/audit"
+	"nvr/pkg/ffmpeg"
 	"nvr/pkg/group"
 	"nvr/pkg/log"
 	"nvr/pkg/monitor"
@@ -22,6 +26,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -29,6 +34,12 @@ import (
 // Run .
 func Run() error {
 	envFlag := flag.String("env", "", "path to env.yaml")
+	migrateDryRunFlag := flag.Bool("migrate-dry-run", false,
+		"print the monitor config migrations without applying them")
+	migrateRestoreFlag := flag.String("migrate-restore", "",
+		"restore the most recent migration backup of a monitor by ID")
+	checkFlag := flag.Bool("check", false,
+		"check the config and the environment, print a report and exit")
 	flag.Parse()
 
 	if *envFlag == "" {
@@ -41,6 +52,27 @@ func Run() error {
 		return fmt.Errorf("could not get absolute path of env.yaml: %w", err)
 	}
 
+	if *checkFlag {
+		return runCheckCommand(envPath)
+	}
+
+	if *migrateDryRunFlag || *migrateRestoreFlag != "" {
+		return runMigrationCommand(envPath, *migrateDryRunFlag, *migrateRestoreFlag)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	// The app is created after the setup so the
+	// authenticator is loaded with the new admin.
+	err = runSetup(envPath, hooks, stop)
+	if errors.Is(err, errSetupStopped) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("first-run setup: %w", err)
+	}
+
 	wg := &sync.WaitGroup{}
 	app, err := newApp(envPath, wg, hooks)
 	if err != nil {
@@ -53,9 +85,6 @@ func Run() error {
 	fatal := make(chan error, 1)
 	go func() { fatal <- app.run(ctx) }()
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
-
 	select {
 	case err = <-fatal:
 		app.logf(log.LevelError, "fatal error: %v", err)
@@ -79,6 +108,51 @@ func Run() error {
 	return app.server.Shutdown(ctx2)
 }
 
+// runMigrationCommand runs a migration command and exits.
+func runMigrationCommand(envPath string, dryRun bool, restoreID string) error {
+	envYAML, err := os.ReadFile(envPath)
+	if err != nil {
+		return fmt.Errorf("could not read env.yaml: %w", err)
+	}
+
+	env, err := storage.NewConfigEnv(envPath, envYAML)
+	if err != nil {
+		return fmt.Errorf("could not get environment config: %w", err)
+	}
+
+	err = hooks.addons.load(filepath.Join(env.ConfigDir, "addons.json"))
+	if err != nil {
+		return fmt.Errorf("could not load addon states: %w", err)
+	}
+
+	monitorConfigDir := filepath.Join(env.ConfigDir, "monitors")
+
+	if restoreID != "" {
+		backupPath, err := monitor.RestoreBackup(monitorConfigDir, restoreID)
+		if err != nil {
+			return fmt.Errorf("could not restore backup: %w", err)
+		}
+		fmt.Printf("restored %v\n", backupPath)
+		return nil
+	}
+
+	diff, reports, err := monitor.MigrateDryRun(monitorConfigDir, hooks.migrationMonitor)
+	if err != nil {
+		return fmt.Errorf("could not run migrations: %w", err)
+	}
+	for _, report := range reports {
+		if report.Err != nil || report.Versions.From != report.Versions.To {
+			fmt.Println(report.String())
+		}
+	}
+	if diff == "" {
+		fmt.Println("no changes")
+		return nil
+	}
+	fmt.Print(diff)
+	return nil
+}
+
 // App is the main application.
 type App struct {
 	WG             *sync.WaitGroup
@@ -88,10 +162,15 @@ type App struct {
 	monitorManager *monitor.Manager
 	Auth           auth.Authenticator
 	Storage        *storage.Manager
+	scrubber       *storage.Scrubber
 	videoServer    *video.Server
 	Templater      *web.Templater
 	Router         *http.ServeMux
 	server         *http.Server
+
+	liveness        *healthChecks
+	readiness       *healthChecks
+	monitorsStarted atomic.Bool
 }
 
 func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) { //nolint:funlen
@@ -106,6 +185,11 @@ func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) {
 		return nil, fmt.Errorf("could not get environment config: %w", err)
 	}
 
+	err = hooks.addons.load(filepath.Join(env.ConfigDir, "addons.json"))
+	if err != nil {
+		return nil, fmt.Errorf("could not load addon states: %w", err)
+	}
+
 	general, err := storage.NewConfigGeneral(env.ConfigDir)
 	if err != nil {
 		return nil, fmt.Errorf("could not get general config: %w", err)
@@ -122,11 +206,20 @@ func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) {
 	// Video server.
 	videoServer := video.NewServer(logger, wg, *env)
 
+	// Storage.
+	storageManager := storage.NewManager(env.StorageDir, env.StorageDirs, general, logger)
+	recordingsDirs := env.RecordingsDirs()
+	scrubber := storage.NewScrubber(recordingsDirs, logger)
+	crawler := storage.NewCrawler(recordingsDirs.FS())
+	summaryIndex := storage.NewSummaryIndex(recordingsDirs.FS())
+	videoCache := storage.NewVideoCache()
+
 	// Monitors.
 	monitorConfigDir := filepath.Join(env.ConfigDir, "monitors")
 	monitorManager, err := monitor.NewManager(
 		monitorConfigDir,
 		*env,
+		storageManager.NextRecordingsDir,
 		logger,
 		videoServer,
 		hooks.monitor(),
@@ -142,29 +235,58 @@ func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) {
 		return nil, fmt.Errorf("could not create monitor manager: %w", err)
 	}
 
+	// Metrics.
+	metricsRegistry, err := newMetricsRegistry(logger, videoServer, monitorManager, hooks)
+	if err != nil {
+		return nil, err
+	}
+
 	// Authentication.
 	if hooks.newAuthenticator == nil {
 		return nil, fmt.Errorf( //nolint:goerr113
 			"no authentication addon enabled, please enable one in '%v'", envPath)
 	}
 
-	a, err := hooks.newAuthenticator(*env, logger)
+	// Audit log.
+	auditLog, err := audit.NewLog(filepath.Join(env.ConfigDir, "audit"))
+	if err != nil {
+		return nil, fmt.Errorf("could not create audit log: %w", err)
+	}
+
+	a, err := hooks.newAuthenticator(*env, logger, auditLog.Write)
 	if err != nil {
 		return nil, fmt.Errorf("could not create authenticator: %w", err)
 	}
+	auditf := web.NewAuditFunc(a, auditLog.Write)
 
-	// Storage.
-	storageManager := storage.NewManager(env.StorageDir, general, logger)
-	crawler := storage.NewCrawler(os.DirFS(storageManager.RecordingsDir()))
+	videoServer.SetHLSUser(func(r *http.Request) string {
+		return a.ValidateRequest(r).User.Username
+	})
+
+	if env.RTSPAuth {
+		videoServer.SetReadAuth(func(r *http.Request) bool {
+			return a.ValidateRequest(r).IsValid
+		})
+	}
 
 	// Time zone.
 	timeZone, err := system.TimeZone()
 	if err != nil {
 		return nil, err
 	}
+	timeZoneLoc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		timeZoneLoc = time.Local
+	}
+
+	// Static files.
+	assets, err := hooks.assets()
+	if err != nil {
+		return nil, err
+	}
 
 	// Templates.
-	t, err := web.NewTemplater(a, hooks.tplHooks())
+	t, err := web.NewTemplater(a, assets, hooks.tplHooks())
 	if err != nil {
 		return nil, err
 	}
@@ -190,6 +312,18 @@ func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) {
 	)
 	t.RegisterTemplateDataFuncs(hooks.templateData...)
 
+	reporter := web.NewReporter(
+		recordingsDirs,
+		videoCache,
+		timeZoneLoc,
+		func(monitorID string) string {
+			return monitorManager.MonitorsInfo()[monitorID]["name"]
+		},
+		func(ctx context.Context, video io.Reader, offset time.Duration, width int) ([]byte, error) {
+			return ffmpeg.ExtractFrame(ctx, env.FFmpegBin, video, offset, width)
+		},
+	)
+
 	// Routes.
 	router := http.NewServeMux()
 
@@ -198,42 +332,103 @@ func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) {
 	router.Handle("/settings", a.User(t.Render("settings.tpl")))
 	router.Handle("/settings.js", a.User(t.Render("settings.js")))
 	router.Handle("/logs", a.Admin(t.Render("logs.tpl")))
+	router.Handle("/report/", a.User(reporter.Page(t, "report.tpl")))
 	router.Handle("/debug", a.Admin(t.Render("debug.tpl")))
 
-	router.Handle("/static/", a.User(web.Static()))
-	router.Handle("/hls/", a.User(videoServer.HandleHLS()))
+	router.Handle("/static/", a.User(assets.Handler()))
+	router.Handle("/hls/", a.User(web.Compress(videoServer.HandleHLS())))
 
 	router.Handle("/api/system/time-zone", a.User(web.TimeZone(timeZone)))
+	router.Handle("/api/system/addons", a.Admin(hooks.addons.handler()))
 
 	router.Handle("/api/general", a.Admin(web.General(general)))
 	router.Handle("/api/general/set", a.Admin(web.GeneralSet(general)))
 
 	router.Handle("/api/users", a.Admin(web.Users(a)))
-	router.Handle("/api/user/set", a.Admin(web.UserSet(a)))
-	router.Handle("/api/user/delete", a.Admin(web.UserDelete(a)))
+	router.Handle("/api/user/set", a.Admin(web.UserSet(a, auditf)))
+	router.Handle("/api/user/delete", a.Admin(web.UserDelete(a, auditf)))
 	router.Handle("/api/user/my-token", a.Admin(a.MyToken()))
+	router.Handle("/api/account/sessions", a.User(web.AccountSessions(a, auditf)))
+	router.Handle("/api/account/sessions/", a.User(web.AccountSessions(a, auditf)))
 	router.Handle("/logout", a.Logout())
 
+	router.Handle("/api/setup", web.SetupCompleted())
+	router.Handle("/api/setup/", web.SetupCompleted())
+
 	router.Handle("/api/monitor/configs", a.Admin(web.MonitorConfigs(monitorManager)))
-	router.Handle("/api/monitor/delete", a.Admin(web.MonitorDelete(monitorManager)))
+	router.Handle("/api/monitor/config/import", a.Admin(web.MonitorImport(monitorManager, auditf)))
+	router.Handle("/api/monitor/config/", a.Admin(web.MonitorConfigByID(monitorManager, auditf)))
+	router.Handle("/api/monitor/delete", a.Admin(web.MonitorDelete(monitorManager, auditf)))
 	router.Handle("/api/monitor/list", a.User(web.MonitorList(monitorManager.MonitorsInfo)))
 	router.Handle("/api/monitor/restart", a.Admin(web.MonitorRestart(monitorManager)))
-	router.Handle("/api/monitor/set", a.Admin(web.MonitorSet(monitorManager)))
+	router.Handle("/api/monitor/set", a.Admin(web.MonitorSet(monitorManager, auditf)))
+	router.Handle("/api/monitor/stats", a.User(videoServer.HandleStats()))
+	router.Handle("/api/monitor/events", a.User(videoServer.HandleEvents()))
+	router.Handle("/api/monitor/", a.User(web.MonitorByID(
+		monitorManager,
+		func(monitorID string) http.Handler {
+			return a.Admin(videoServer.HandleHLSDebug(monitorID))
+		},
+	)))
+	router.Handle("/api/ingest/", a.User(web.MonitorIngest(monitorManager.PushFrame)))
 
 	router.Handle("/api/group/configs", a.User(web.GroupConfigs(groupManager)))
-	router.Handle("/api/group/set", a.Admin(web.GroupSet(groupManager)))
-	router.Handle("/api/group/delete", a.Admin(web.GroupDelete(groupManager)))
-
-	router.Handle("/api/recording/delete/", a.Admin(web.RecordingDelete(env.RecordingsDir())))
-	router.Handle("/api/recording/thumbnail/", a.User(web.RecordingThumbnail(env.RecordingsDir())))
-	router.Handle("/api/recording/video/", a.User(web.RecordingVideo(logger, env.RecordingsDir())))
-	router.Handle("/api/recording/query", a.User(web.RecordingQuery(crawler, logger)))
+	router.Handle("/api/group/set", a.Admin(web.GroupSet(groupManager, auditf)))
+	router.Handle("/api/group/delete", a.Admin(web.GroupDelete(groupManager, auditf)))
+	router.Handle("/api/group/layout", a.Admin(web.GroupLayout()))
+
+	router.Handle("/api/recording/delete", a.Admin(web.RecordingDeleteBulk(recordingsDirs, videoCache, auditf)))
+	router.Handle("/api/recording/delete/", a.Admin(web.RecordingDelete(recordingsDirs, videoCache, auditf)))
+	router.Handle("/api/recording/thumbnail/", a.User(web.RecordingThumbnail(recordingsDirs)))
+	router.Handle("/api/recording/video/", a.User(web.RecordingVideo(recordingsDirs, videoCache)))
+	router.Handle("/api/recording/query", a.User(web.RecordingQuery(crawler, timeZoneLoc)))
+	router.Handle("/api/recording/summary", a.User(web.RecordingSummary(summaryIndex, timeZoneLoc)))
+	router.Handle("/api/recording/scrubber", a.Admin(web.RecordingScrubber(scrubber.Status)))
+	router.Handle("/api/storage/purge/history", a.Admin(web.PurgeHistory(storageManager.PurgeHistory)))
+	router.Handle("/api/storage/purge/preview", a.Admin(web.PurgePreview(storageManager.PurgePreview)))
+	router.Handle("/api/recording/", web.RecordingByID(
+		a.Admin(web.RecordingDelete(recordingsDirs, videoCache, auditf)),
+		map[string]http.Handler{
+			"lock":   a.User(web.RecordingLock(recordingsDirs, auditf)),
+			"unlock": a.User(web.RecordingLock(recordingsDirs, auditf)),
+			"verify": a.Admin(web.RecordingVerify(scrubber.Verify)),
+			"detail": a.User(web.RecordingDetail(recordingsDirs, timeZoneLoc, hooks.recordingDetail)),
+			"index":  a.User(web.RecordingIndex(recordingsDirs, videoCache)),
+			"download": a.User(web.RecordingDownload(
+				recordingsDirs,
+				videoCache,
+				func(ctx context.Context, input string, output string) error {
+					return ffmpeg.RemuxProgressive(ctx, env.FFmpegBin, input, output)
+				},
+			)),
+			"report.pdf": a.User(reporter.PDF()),
+		},
+	))
 
 	router.Handle("/api/log/feed", a.Admin(web.LogFeed(logger, a)))
 	router.Handle("/api/log/query", a.Admin(web.LogQuery(logStore)))
 	router.Handle("/api/log/sources", a.Admin(web.LogSources(logger)))
 
-	return &App{
+	router.Handle("/api/audit", a.Admin(web.AuditQuery(auditLog)))
+
+	router.Handle("/api/video/viewers", a.Admin(videoServer.HandleViewers()))
+
+	router.Handle("/metrics", a.Admin(metricsHandler(metricsRegistry)))
+
+	// Health checks, unauthenticated.
+	healthLogf := func(level log.Level, format string, a ...interface{}) {
+		logger.Log(log.Entry{
+			Level: level,
+			Src:   "app",
+			Msg:   fmt.Sprintf(format, a...),
+		})
+	}
+	liveness := newHealthChecks(healthLogf)
+	readiness := newHealthChecks(healthLogf)
+	router.Handle("/healthz", liveness.handler())
+	router.Handle("/readyz", readiness.handler())
+
+	app := &App{
 		WG:             wg,
 		Logger:         logger,
 		logStore:       logStore,
@@ -241,22 +436,29 @@ func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) {
 		monitorManager: monitorManager,
 		Auth:           a,
 		Storage:        storageManager,
+		scrubber:       scrubber,
 		videoServer:    videoServer,
 		Templater:      t,
 		Router:         router,
-	}, nil
+
+		liveness:  liveness,
+		readiness: readiness,
+	}
+	app.registerCoreHealthChecks()
+	return app, nil
 }
 
 func (app *App) run(ctx context.Context) error {
 	// Main server.
 	address := ":" + strconv.Itoa(app.Env.Port)
-	app.server = &http.Server{Addr: address, Handler: app.Router}
+	app.server = &http.Server{Addr: address}
 
 	if err := app.Logger.Start(ctx); err != nil {
 		return fmt.Errorf("could not start logger: %w", err)
 	}
 
-	app.Logger.LogToWriter(ctx, os.Stdout)
+	logFormat, _ := log.ParseFormat(string(app.Env.LogFormat))
+	app.Logger.LogToWriter(ctx, os.Stdout, logFormat)
 	app.logStore.SaveLogs(ctx, app.Logger)
 	app.logStore.PurgeLoop(ctx, app.Logger)
 	time.Sleep(10 * time.Millisecond)
@@ -265,6 +467,9 @@ func (app *App) run(ctx context.Context) error {
 		return err
 	}
 
+	// All routes have been registered.
+	app.server.Handler = hooks.httpHandler(app.Logger, app.Router)
+
 	app.logf(log.LevelInfo, "Starting..")
 
 	if err := app.Env.PrepareEnvironment(); err != nil {
@@ -275,14 +480,44 @@ func (app *App) run(ctx context.Context) error {
 		return fmt.Errorf("could not start video server: %w", err)
 	}
 
+	app.recoverRecordings()
+
+	app.monitorManager.LogMigrations()
 	app.monitorManager.StartMonitors()
+	app.monitorsStarted.Store(true)
 
 	go app.Storage.PurgeLoop(ctx, 10*time.Minute)
+	go app.scrubber.Run(ctx)
 
 	app.logf(log.LevelInfo, "Serving app on port %v", app.Env.Port)
 	return app.server.ListenAndServe()
 }
 
+// MonitorsInfo returns common information about the monitors.
+func (app *App) MonitorsInfo() monitor.RawConfigs {
+	return app.monitorManager.MonitorsInfo()
+}
+
+// MonitorProcessIDs returns the process IDs of
+// the running ffmpeg processes of each monitor.
+func (app *App) MonitorProcessIDs() map[string][]int {
+	return app.monitorManager.ProcessIDs()
+}
+
+// recoverRecordings publishes the recordings that
+// were left behind if the app stopped unexpectedly.
+func (app *App) recoverRecordings() {
+	for _, dir := range app.Env.RecordingsDirs() {
+		recovered, err := storage.RecoverRecordings(dir)
+		for _, recID := range recovered {
+			app.logf(log.LevelWarning, "recovered interrupted recording: %v", recID)
+		}
+		if err != nil {
+			app.logf(log.LevelError, "could not recover recordings: %v", err)
+		}
+	}
+}
+
 func (app *App) logf(level log.Level, format string, a ...interface{}) {
 	app.Logger.Log(log.Entry{
 		Level: level,
@@ -0,0 +1,260 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions.
+const (
+	ActionLoginFailed      = "login/failed"
+	ActionAccountCreate    = "account/create"
+	ActionAccountUpdate    = "account/update"
+	ActionAccountDelete    = "account/delete"
+	ActionSessionRevoke    = "session/revoke"
+	ActionSessionRevokeAll = "session/revoke-all"
+	ActionMonitorSet       = "monitor/set"
+	ActionMonitorDelete    = "monitor/delete"
+	ActionMonitorImport    = "monitor/import"
+	ActionGroupSet         = "group/set"
+	ActionGroupDelete      = "group/delete"
+	ActionRecDelete        = "recording/delete"
+	ActionRecLock          = "recording/lock"
+	ActionRecUnlock        = "recording/unlock"
+	ActionSetupEnv         = "setup/env"
+)
+
+// Entry is a single audit record.
+type Entry struct {
+	Time   time.Time `json:"time"` // Do not set manually.
+	User   string    `json:"user"`
+	IP     string    `json:"ip"`
+	Action string    `json:"action"`
+	Target string    `json:"target"`
+	Auth   string    `json:"auth,omitempty"` // Authentication source.
+}
+
+// Func records an audit entry. It must not return before
+// the entry has been written. If an error is returned
+// the action should not be applied.
+type Func func(Entry) error
+
+// DummyFunc discards all entries, used for testing.
+func DummyFunc(Entry) error { return nil }
+
+const (
+	fileName        = "audit.log"
+	defaultMaxSize  = 10 * 1000 * 1000 // 10MB.
+	defaultMaxFiles = 5
+)
+
+// Log is an append-only audit log, stored separately
+// from the operational logs. The active file is rotated
+// when it reaches maxSize and only maxFiles are kept.
+//
+//	audit.log   <- active file.
+//	audit.1.log
+//	audit.2.log <- oldest file.
+type Log struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+
+	file *os.File
+	size int64
+	mu   sync.Mutex
+}
+
+// NewLog opens or creates the audit log in the given directory.
+func NewLog(dir string) (*Log, error) {
+	return newLog(dir, defaultMaxSize, defaultMaxFiles)
+}
+
+func newLog(dir string, maxSize int64, maxFiles int) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create audit directory: %w", err)
+	}
+	l := &Log{
+		dir:      dir,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	path := filepath.Join(l.dir, fileName)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat audit log: %w", err)
+	}
+	l.file = file
+	l.size = stat.Size()
+	return nil
+}
+
+// Write appends the entry to the log and syncs
+// the file before returning. Implements Func.
+func (l *Log) Write(e Entry) error {
+	e.Time = time.Now().UTC()
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("rotate: %w", err)
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write entry: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("sync: %w", err)
+	}
+	return nil
+}
+
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	oldest := filepath.Join(l.dir, rotatedName(l.maxFiles-1))
+	if err := os.Remove(oldest); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := l.maxFiles - 2; i >= 0; i-- {
+		oldPath := filepath.Join(l.dir, rotatedName(i))
+		newPath := filepath.Join(l.dir, rotatedName(i+1))
+		err := os.Rename(oldPath, newPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return l.open()
+}
+
+func rotatedName(i int) string {
+	if i == 0 {
+		return fileName
+	}
+	return "audit." + strconv.Itoa(i) + ".log"
+}
+
+// Close the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Query audit log query.
+type Query struct {
+	// Only return entries before this time, zero value means now.
+	Before time.Time
+	// Only return entries after this time.
+	After time.Time
+	// Only return entries by this user.
+	User string
+	// Maximum number of entries, zero means no limit.
+	Limit int
+}
+
+// Query returns entries matching the query, newest first.
+func (l *Log) Query(q Query) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := []Entry{}
+	for i := 0; i < l.maxFiles; i++ {
+		fileEntries, err := readEntries(filepath.Join(l.dir, rotatedName(i)))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+			return nil, err
+		}
+		// Newest first.
+		sort.SliceStable(fileEntries, func(a, b int) bool {
+			return fileEntries[a].Time.After(fileEntries[b].Time)
+		})
+		for _, e := range fileEntries {
+			if !q.matches(e) {
+				continue
+			}
+			entries = append(entries, e)
+			if q.Limit != 0 && len(entries) >= q.Limit {
+				return entries, nil
+			}
+		}
+	}
+	return entries, nil
+}
+
+func (q Query) matches(e Entry) bool {
+	if !q.Before.IsZero() && !e.Time.Before(q.Before) {
+		return false
+	}
+	if !q.After.IsZero() && !e.Time.After(q.After) {
+		return false
+	}
+	if q.User != "" && q.User != e.User {
+		return false
+	}
+	return true
+}
+
+func readEntries(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			// Skip partially written lines.
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %v: %w", path, err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	t.Run("writeAndQuery", func(t *testing.T) {
+		l, err := NewLog(t.TempDir())
+		require.NoError(t, err)
+		defer l.Close()
+
+		require.NoError(t, l.Write(Entry{User: "a", Action: ActionMonitorSet, Target: "1"}))
+		require.NoError(t, l.Write(Entry{User: "b", Action: ActionMonitorDelete, Target: "2"}))
+		require.NoError(t, l.Write(Entry{User: "a", Action: ActionGroupSet, Target: "3"}))
+
+		entries, err := l.Query(Query{})
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+		require.Equal(t, "3", entries[0].Target)
+		require.Equal(t, "1", entries[2].Target)
+
+		entries, err = l.Query(Query{User: "a", Limit: 1})
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, ActionGroupSet, entries[0].Action)
+
+		entries, err = l.Query(Query{Before: time.Now().Add(-time.Hour)})
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+	t.Run("reopen", func(t *testing.T) {
+		dir := t.TempDir()
+		l, err := NewLog(dir)
+		require.NoError(t, err)
+		require.NoError(t, l.Write(Entry{User: "a", Target: "1"}))
+		require.NoError(t, l.Close())
+
+		l, err = NewLog(dir)
+		require.NoError(t, err)
+		defer l.Close()
+		require.NoError(t, l.Write(Entry{User: "a", Target: "2"}))
+
+		entries, err := l.Query(Query{})
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+	})
+	t.Run("rotate", func(t *testing.T) {
+		dir := t.TempDir()
+		l, err := newLog(dir, 100, 3)
+		require.NoError(t, err)
+		defer l.Close()
+
+		for i := 0; i < 10; i++ {
+			require.NoError(t, l.Write(Entry{User: "a", Target: "x"}))
+		}
+
+		_, err = os.Stat(filepath.Join(dir, "audit.2.log"))
+		require.NoError(t, err)
+		_, err = os.Stat(filepath.Join(dir, "audit.3.log"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		entries, err := l.Query(Query{})
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+	})
+}
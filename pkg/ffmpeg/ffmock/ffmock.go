@@ -48,6 +48,8 @@ func (m mockProcess) Start(ctx context.Context) error {
 	return nil
 }
 
+func (m mockProcess) PID() int { return 0 }
+
 func (m mockProcess) Stop() {
 	if m.c.OnStop != nil {
 		m.c.OnStop()
@@ -4,16 +4,20 @@ package ffmpeg
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/png"
 	"io"
+	"math"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -36,6 +40,9 @@ type Process interface {
 
 	// Stop process.
 	Stop()
+
+	// PID returns the process ID, 0 if the process isn't running.
+	PID() int
 }
 
 // process manages subprocesses.
@@ -46,6 +53,9 @@ type process struct {
 	stdoutLogger LogFunc
 	stderrLogger LogFunc
 
+	// Shared between copies, set while the process is running.
+	pid *atomic.Int64
+
 	done chan struct{}
 }
 
@@ -57,6 +67,7 @@ func NewProcess(cmd *exec.Cmd) Process {
 	return process{
 		timeout: 1000 * time.Millisecond,
 		cmd:     cmd,
+		pid:     &atomic.Int64{},
 	}
 }
 
@@ -95,6 +106,9 @@ func (p process) Start(ctx context.Context) error {
 		return err
 	}
 
+	p.pid.Store(int64(p.cmd.Process.Pid))
+	defer p.pid.Store(0)
+
 	p.done = make(chan struct{})
 
 	go func() {
@@ -139,6 +153,28 @@ func (p process) Stop() {
 	}
 }
 
+func (p process) PID() int {
+	return int(p.pid.Load())
+}
+
+// LowPriority wraps the command in nice so background jobs
+// like transcoding don't compete with the live streams.
+// The command is returned unchanged if nice isn't available.
+func LowPriority(cmd *exec.Cmd) *exec.Cmd {
+	nice, err := exec.LookPath("nice")
+	if err != nil {
+		return cmd
+	}
+	wrapped := exec.Command(nice, append([]string{"-n", "19"}, cmd.Args...)...)
+	wrapped.Args[3] = cmd.Path
+	wrapped.Dir = cmd.Dir
+	wrapped.Env = cmd.Env
+	wrapped.Stdin = cmd.Stdin
+	wrapped.Stdout = cmd.Stdout
+	wrapped.Stderr = cmd.Stderr
+	return wrapped
+}
+
 // FFMPEG stores ffmpeg binary location.
 type FFMPEG struct {
 	command func(...string) *exec.Cmd
@@ -152,6 +188,57 @@ func New(bin string) *FFMPEG {
 	return &FFMPEG{command: command}
 }
 
+// RemuxProgressive copies the streams of the input video into a mp4
+// file with the moov box before the media data, the output is overwritten.
+func RemuxProgressive(ctx context.Context, bin string, input string, output string) error {
+	cmd := exec.CommandContext(ctx, bin,
+		"-y", "-loglevel", "error",
+		"-i", input,
+		"-c", "copy",
+		"-movflags", "+faststart",
+		"-f", "mp4", output,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("remux: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ExtractFrame decodes the frame at the offset of the input video and
+// returns it as a JPEG image scaled to the width, the aspect ratio is kept.
+func ExtractFrame(
+	ctx context.Context,
+	bin string,
+	input io.Reader,
+	offset time.Duration,
+	width int,
+) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, bin,
+		"-loglevel", "error",
+		"-i", "-",
+		"-ss", strconv.FormatFloat(offset.Seconds(), 'f', 3, 64),
+		"-frames:v", "1",
+		"-vf", "scale="+strconv.Itoa(width)+":-2",
+		"-c:v", "mjpeg", "-q:v", "4",
+		"-f", "image2", "-",
+	)
+	cmd.Stdin = input
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("extract frame: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("extract frame: %w", ErrNoFrame)
+	}
+	return stdout.Bytes(), nil
+}
+
+// ErrNoFrame offset is past the end of the video.
+var ErrNoFrame = errors.New("no frame at offset")
+
 /*
 func HWaccels(bin string) ([]string, error) {
 	cmd := exec.Command(bin, "-hwaccels")
@@ -251,6 +338,74 @@ func VertexInsidePoly(y int, x int, poly Polygon) bool {
 	return inside
 }
 
+// RectInsidePolyFraction returns the fraction of the rectangle that is
+// inside the polygon, from 0 to 1. Polygon points are x, y. The polygon
+// is clipped to the rectangle and the areas are compared.
+func RectInsidePolyFraction(rect Rect, poly Polygon) float64 {
+	top, left := float64(rect[0]), float64(rect[1])
+	bottom, right := float64(rect[2]), float64(rect[3])
+
+	rectArea := (bottom - top) * (right - left)
+	if rectArea <= 0 || len(poly) < 3 {
+		return 0
+	}
+
+	points := make([][2]float64, len(poly))
+	for i, p := range poly {
+		points[i] = [2]float64{float64(p[0]), float64(p[1])}
+	}
+
+	// Sutherland-Hodgman, works with any polygon because the rectangle is convex.
+	points = clipPolygon(points, 0, left, true)
+	points = clipPolygon(points, 0, right, false)
+	points = clipPolygon(points, 1, top, true)
+	points = clipPolygon(points, 1, bottom, false)
+
+	fraction := polygonArea(points) / rectArea
+	if fraction > 1 {
+		return 1
+	}
+	return fraction
+}
+
+// clipPolygon removes the part of the polygon on the wrong side of the line
+// where the axis equals the limit. Keeps points above the limit if keepAbove.
+func clipPolygon(points [][2]float64, axis int, limit float64, keepAbove bool) [][2]float64 {
+	inside := func(p [2]float64) bool {
+		if keepAbove {
+			return p[axis] >= limit
+		}
+		return p[axis] <= limit
+	}
+
+	var clipped [][2]float64
+	for i, cur := range points {
+		prev := points[(i+len(points)-1)%len(points)]
+		curInside, prevInside := inside(cur), inside(prev)
+		if curInside != prevInside {
+			t := (limit - prev[axis]) / (cur[axis] - prev[axis])
+			clipped = append(clipped, [2]float64{
+				prev[0] + t*(cur[0]-prev[0]),
+				prev[1] + t*(cur[1]-prev[1]),
+			})
+		}
+		if curInside {
+			clipped = append(clipped, cur)
+		}
+	}
+	return clipped
+}
+
+// polygonArea shoelace formula.
+func polygonArea(points [][2]float64) float64 {
+	var area float64
+	for i, cur := range points {
+		next := points[(i+1)%len(points)]
+		area += cur[0]*next[1] - next[0]*cur[1]
+	}
+	return math.Abs(area) / 2
+}
+
 // SaveImage saves image to specified location.
 func SaveImage(path string, img image.Image) error {
 	os.Remove(path)
@@ -298,10 +453,71 @@ func ParseScaleString(scale string) string {
 	}
 }
 
+// ErrInvalidFeedRate invalid feed rate.
+var ErrInvalidFeedRate = errors.New("invalid feed rate")
+
+// MaxFeedRate sanity limit for the feed rate.
+const MaxFeedRate = 1000
+
+// ParseFeedRate parses a feed rate (fps) from a decimal
+// like "0.2" or a rational like "30000/1001".
+func ParseFeedRate(feedRate string) (float64, error) {
+	if strings.Contains(feedRate, ",") {
+		return 0, fmt.Errorf(
+			"%w: %q: use a dot as the decimal separator", ErrInvalidFeedRate, feedRate)
+	}
+
+	parseFloat := func(s string) (float64, error) {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidFeedRate, feedRate)
+		}
+		// ParseFloat accepts "inf" and "nan".
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidFeedRate, feedRate)
+		}
+		return v, nil
+	}
+
+	var rate float64
+	if num, den, isRational := strings.Cut(feedRate, "/"); isRational {
+		numerator, err := parseFloat(num)
+		if err != nil {
+			return 0, err
+		}
+		denominator, err := parseFloat(den)
+		if err != nil {
+			return 0, err
+		}
+		if denominator == 0 {
+			return 0, fmt.Errorf("%w: %q: zero denominator", ErrInvalidFeedRate, feedRate)
+		}
+		rate = numerator / denominator
+	} else {
+		var err error
+		rate, err = parseFloat(feedRate)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if rate <= 0 {
+		return 0, fmt.Errorf("%w: %q: must be greater than zero", ErrInvalidFeedRate, feedRate)
+	}
+	if rate > MaxFeedRate {
+		return 0, fmt.Errorf("%w: %q: must not be greater than %v",
+			ErrInvalidFeedRate, feedRate, MaxFeedRate)
+	}
+	return rate, nil
+}
+
 // FeedRateToDuration calculates frame duration from feed rate (fps).
-func FeedRateToDuration(feedRate float64) time.Duration {
+func FeedRateToDuration(feedRate float64) (time.Duration, error) {
+	if !(feedRate > 0) || math.IsInf(feedRate, 0) {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidFeedRate, feedRate)
+	}
 	frameDuration := 1 / feedRate
-	return time.Duration(frameDuration * float64(time.Second))
+	return time.Duration(frameDuration * float64(time.Second)), nil
 }
 
 // ParseTimestampOffset converts the timestampOffset string to duration.
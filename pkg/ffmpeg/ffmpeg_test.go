@@ -5,6 +5,7 @@ package ffmpeg
 import (
 	"fmt"
 	"image"
+	"math"
 	"os"
 	"os/exec"
 	"strconv"
@@ -131,6 +132,18 @@ func imageToText(img image.Image) string {
 	return text
 }
 
+func TestLowPriority(t *testing.T) {
+	if _, err := exec.LookPath("nice"); err != nil {
+		t.Skip("nice not found")
+	}
+	cmd := LowPriority(exec.Command("/bin/echo", "a", "b"))
+	require.Equal(t, []string{"-n", "19", "/bin/echo", "a", "b"}, cmd.Args[1:])
+
+	out, err := cmd.Output()
+	require.NoError(t, err)
+	require.Equal(t, "a b\n", string(out))
+}
+
 func TestPolygonToAbs(t *testing.T) {
 	polygon := Polygon{
 		Point{5, 10},
@@ -281,6 +294,38 @@ func TestCreateInvertedMask(t *testing.T) {
 	}
 }
 
+func TestRectInsidePolyFraction(t *testing.T) {
+	square := func(left, top, right, bottom int) Polygon {
+		return Polygon{{left, top}, {right, top}, {right, bottom}, {left, bottom}}
+	}
+	cases := map[string]struct {
+		rect     Rect
+		poly     Polygon
+		expected float64
+	}{
+		"inside":   {Rect{10, 10, 20, 20}, square(0, 0, 100, 100), 1},
+		"outside":  {Rect{10, 10, 20, 20}, square(50, 0, 100, 100), 0},
+		"covers":   {Rect{0, 0, 100, 100}, square(25, 25, 75, 75), 0.25},
+		"straddle": {Rect{0, 40, 10, 60}, square(50, 0, 100, 100), 0.5},
+		"axes":     {Rect{0, 0, 100, 10}, square(0, 0, 10, 50), 0.5},
+		"triangle": {Rect{0, 0, 100, 100}, Polygon{{0, 0}, {100, 0}, {0, 100}}, 0.5},
+		"concave": {
+			Rect{25, 25, 75, 75},
+			Polygon{{0, 0}, {100, 0}, {100, 50}, {50, 50}, {50, 100}, {0, 100}},
+			0.75,
+		},
+		"counterClockwise": {Rect{0, 40, 10, 60}, Polygon{{50, 0}, {50, 100}, {100, 100}, {100, 0}}, 0.5},
+		"emptyRect":        {Rect{10, 10, 10, 20}, square(0, 0, 100, 100), 0},
+		"line":             {Rect{10, 10, 20, 20}, Polygon{{0, 0}, {100, 100}}, 0},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual := RectInsidePolyFraction(tc.rect, tc.poly)
+			require.InDelta(t, tc.expected, actual, 0.0001)
+		})
+	}
+}
+
 func TestSaveImage(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		tempDir, err := os.MkdirTemp("", "")
@@ -365,10 +410,63 @@ func TestFeedRateToDuration(t *testing.T) {
 	for _, tc := range cases {
 		name := strconv.FormatFloat(tc.input, 'f', -1, 64)
 		t.Run(name, func(t *testing.T) {
-			actual := FeedRateToDuration(tc.input)
+			actual, err := FeedRateToDuration(tc.input)
+			require.NoError(t, err)
 			require.Equal(t, tc.expected, actual)
 		})
 	}
+	for _, input := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		name := strconv.FormatFloat(input, 'f', -1, 64)
+		t.Run(name, func(t *testing.T) {
+			_, err := FeedRateToDuration(input)
+			require.ErrorIs(t, err, ErrInvalidFeedRate)
+		})
+	}
+}
+
+func TestParseFeedRate(t *testing.T) {
+	cases := map[string]struct {
+		input    string
+		expected float64
+		err      string
+	}{
+		"integer":     {"5", 5, ""},
+		"decimal":     {"0.2", 0.2, ""},
+		"rational":    {"30000/1001", 30000.0 / 1001, ""},
+		"rationalDec": {"1/0.5", 2, ""},
+		"max":         {"1000", 1000, ""},
+		"comma": {
+			"0,2", 0,
+			`invalid feed rate: "0,2": use a dot as the decimal separator`,
+		},
+		"empty":       {"", 0, `invalid feed rate: ""`},
+		"text":        {"nil", 0, `invalid feed rate: "nil"`},
+		"spaces":      {" 5", 0, `invalid feed rate: " 5"`},
+		"inf":         {"inf", 0, `invalid feed rate: "inf"`},
+		"nan":         {"NaN", 0, `invalid feed rate: "NaN"`},
+		"zero":        {"0", 0, `invalid feed rate: "0": must be greater than zero`},
+		"negative":    {"-1", 0, `invalid feed rate: "-1": must be greater than zero`},
+		"negativeDen": {"1/-2", 0, `invalid feed rate: "1/-2": must be greater than zero`},
+		"zeroDen":     {"1/0", 0, `invalid feed rate: "1/0": zero denominator`},
+		"noDen":       {"1/", 0, `invalid feed rate: "1/"`},
+		"twoSlashes":  {"1/2/3", 0, `invalid feed rate: "1/2/3"`},
+		"tooHigh": {
+			"1001", 0,
+			`invalid feed rate: "1001": must not be greater than 1000`,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual, err := ParseFeedRate(tc.input)
+			if tc.err != "" {
+				require.ErrorIs(t, err, ErrInvalidFeedRate)
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.InDelta(t, tc.expected, actual, 1e-9)
+		})
+	}
 }
 
 func TestParseTimestampOffset(t *testing.T) {
@@ -0,0 +1,284 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Errors.
+var (
+	ErrPipeSource   = errors.New("exactly one of Reader or NextFrame must be set")
+	ErrPipeNoCmd    = errors.New("NewCmd is nil")
+	ErrRestartLimit = errors.New("restart limit reached")
+
+	errProcessExited = errors.New("process exited")
+)
+
+// RestartPolicy controls how often a process is restarted.
+type RestartPolicy struct {
+	// Maximum number of restarts within the window, 0 disables restarts.
+	MaxRestarts int
+
+	// Restarts older than the window are forgotten. Zero means forever.
+	Window time.Duration
+
+	// Delay before each restart.
+	Delay time.Duration
+}
+
+// ResilientPipeConfig ResilientPipe config. Exactly
+// one of Reader and NextFrame must be set.
+type ResilientPipeConfig struct {
+	// NewCmd returns the command for each process, a
+	// Cmd can only be started once. Stdin is set by the pipe.
+	NewCmd func() *exec.Cmd
+
+	// NewProcess defaults to NewProcess.
+	NewProcess NewProcessFunc
+
+	// Stream source. A chunk that was only partially written when the
+	// process exited is resumed. A blocked Read delays the restart.
+	Reader io.Reader
+
+	// Frame source, returns io.EOF at the end. A frame that wasn't
+	// fully written when the process exited is written again in full.
+	// Must return when the context is canceled.
+	NextFrame func(context.Context) ([]byte, error)
+
+	// Primer is optional and returns the data written to the new
+	// process before resuming, the most recent keyframe for example.
+	Primer func() []byte
+
+	Restart RestartPolicy
+
+	// OnRestart is optional and called before each restart.
+	OnRestart func(exitErr error, restarts int)
+}
+
+// PipeStats aggregated statistics of all the processes.
+type PipeStats struct {
+	Restarts     int
+	BytesWritten int64
+	LastExitErr  error
+	LastRestart  time.Time
+}
+
+// ResilientPipe feeds a source into the stdin of a process and
+// restarts the process if it exits before the source ends.
+type ResilientPipe struct {
+	c ResilientPipeConfig
+
+	// Source data that hasn't been written yet.
+	pending []byte
+	readBuf []byte
+
+	restartTimes []time.Time
+
+	mu    sync.Mutex
+	stats PipeStats
+}
+
+const (
+	pipeReadSize = 32 * 1024
+
+	// Wait returns this long after the process exited even if
+	// the stdin goroutine is still blocked on the source.
+	pipeWaitDelay = time.Second
+)
+
+// NewResilientPipe creates a ResilientPipe.
+func NewResilientPipe(c ResilientPipeConfig) (*ResilientPipe, error) {
+	if (c.Reader == nil) == (c.NextFrame == nil) {
+		return nil, ErrPipeSource
+	}
+	if c.NewCmd == nil {
+		return nil, ErrPipeNoCmd
+	}
+	if c.NewProcess == nil {
+		c.NewProcess = NewProcess
+	}
+	return &ResilientPipe{c: c}, nil
+}
+
+// Run blocks until the source ends and the process exits, the context
+// is canceled or the restart limit is reached. Can only be called once.
+func (p *ResilientPipe) Run(ctx context.Context) error {
+	restarted := false
+	for {
+		res := p.runProcess(ctx, restarted)
+		sourceErr, exitErr := res.sourceErr, res.exitErr
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(sourceErr, io.EOF) {
+			// Nothing left to write.
+			return exitErr
+		}
+		if sourceErr != nil && !errors.Is(sourceErr, errProcessExited) {
+			return fmt.Errorf("source: %w", sourceErr)
+		}
+
+		if exitErr == nil {
+			exitErr = errProcessExited
+		}
+		restarts, err := p.restart(exitErr)
+		if err != nil {
+			return err
+		}
+		if p.c.OnRestart != nil {
+			p.c.OnRestart(exitErr, restarts)
+		}
+
+		select {
+		case <-time.After(p.c.Restart.Delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		restarted = true
+	}
+}
+
+type processResult struct {
+	// io.EOF if the source ended.
+	sourceErr error
+	exitErr   error
+}
+
+// runProcess runs a single process and returns
+// when both the process and the feeder have stopped.
+func (p *ResilientPipe) runProcess(ctx context.Context, restarted bool) processResult {
+	pr, pw := io.Pipe()
+	cmd := p.c.NewCmd()
+	cmd.Stdin = pr
+	if cmd.WaitDelay == 0 {
+		cmd.WaitDelay = pipeWaitDelay
+	}
+
+	processCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	feedErr := make(chan error, 1)
+	go func() {
+		feedErr <- p.feed(processCtx, pw, restarted)
+	}()
+
+	exitErr := p.c.NewProcess(cmd).Start(processCtx)
+
+	// Unblock the feeder.
+	pr.CloseWithError(errProcessExited) //nolint:errcheck
+	cancel()
+
+	return processResult{sourceErr: <-feedErr, exitErr: exitErr}
+}
+
+// restart records the restart and returns the number of restarts.
+func (p *ResilientPipe) restart(exitErr error) (int, error) {
+	now := time.Now()
+	if window := p.c.Restart.Window; window != 0 {
+		recent := p.restartTimes[:0]
+		for _, t := range p.restartTimes {
+			if now.Sub(t) < window {
+				recent = append(recent, t)
+			}
+		}
+		p.restartTimes = recent
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.LastExitErr = exitErr
+
+	if len(p.restartTimes) >= p.c.Restart.MaxRestarts {
+		return 0, fmt.Errorf("%w: %d restarts: %w", ErrRestartLimit, p.stats.Restarts, exitErr)
+	}
+	p.restartTimes = append(p.restartTimes, now)
+	p.stats.Restarts++
+	p.stats.LastRestart = now
+	return p.stats.Restarts, nil
+}
+
+func (p *ResilientPipe) feed(ctx context.Context, w *io.PipeWriter, restarted bool) error {
+	if restarted && p.c.Primer != nil {
+		if primer := p.c.Primer(); len(primer) != 0 {
+			n, err := w.Write(primer)
+			p.addWritten(n)
+			if err != nil {
+				return errProcessExited
+			}
+		}
+	}
+	for {
+		if len(p.pending) == 0 {
+			data, err := p.next(ctx)
+			if ctx.Err() != nil {
+				// The process exited while waiting for the source,
+				// a frame that arrived anyway is kept for the next one.
+				p.pending = data
+				return errProcessExited
+			}
+			if err != nil {
+				w.Close() //nolint:errcheck
+				return err
+			}
+			if len(data) == 0 {
+				continue
+			}
+			p.pending = data
+		}
+
+		n, err := w.Write(p.pending)
+		p.addWritten(n)
+		if err != nil {
+			if p.c.Reader != nil {
+				p.pending = p.pending[n:]
+			}
+			return errProcessExited
+		}
+		p.pending = nil
+	}
+}
+
+// next returns the next chunk of the source.
+func (p *ResilientPipe) next(ctx context.Context) ([]byte, error) {
+	if p.c.NextFrame != nil {
+		frame, err := p.c.NextFrame(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return frame, nil
+	}
+
+	if p.readBuf == nil {
+		p.readBuf = make([]byte, pipeReadSize)
+	}
+	for {
+		n, err := p.c.Reader.Read(p.readBuf)
+		if n > 0 {
+			// The buffer is reused, the chunk may be kept until the next process.
+			return append([]byte(nil), p.readBuf[:n]...), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *ResilientPipe) addWritten(n int) {
+	p.mu.Lock()
+	p.stats.BytesWritten += int64(n)
+	p.mu.Unlock()
+}
+
+// Stats returns the aggregated statistics.
+func (p *ResilientPipe) Stats() PipeStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
@@ -0,0 +1,243 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errFakeCrash = errors.New("crash")
+
+// pipeFakeProcess reads chunks from stdin and
+// exits with an error after maxReads reads.
+type pipeFakeProcess struct {
+	cmd       *exec.Cmd
+	chunkSize int
+	maxReads  int
+	onChunk   func([]byte)
+}
+
+func (p *pipeFakeProcess) Timeout(time.Duration) Process { return p }
+func (p *pipeFakeProcess) StdoutLogger(LogFunc) Process  { return p }
+func (p *pipeFakeProcess) StderrLogger(LogFunc) Process  { return p }
+func (p *pipeFakeProcess) Stop()                         {}
+func (p *pipeFakeProcess) PID() int                      { return 0 }
+
+func (p *pipeFakeProcess) Start(ctx context.Context) error {
+	stdin := p.cmd.Stdin
+	go func() {
+		<-ctx.Done()
+		stdin.(io.Closer).Close() //nolint:forcetypeassert
+	}()
+	for i := 0; i < p.maxReads; i++ {
+		buf := make([]byte, p.chunkSize)
+		n, err := io.ReadFull(stdin, buf)
+		if n != 0 {
+			p.onChunk(buf[:n])
+		}
+		if err != nil {
+			return nil
+		}
+	}
+	return errFakeCrash
+}
+
+// pipeRecorder records the chunks read by each process.
+type pipeRecorder struct {
+	mu        sync.Mutex
+	processes [][]string
+}
+
+func (r *pipeRecorder) newProcess(chunkSize int, maxReads int) NewProcessFunc {
+	return func(cmd *exec.Cmd) Process {
+		r.mu.Lock()
+		r.processes = append(r.processes, []string{})
+		index := len(r.processes) - 1
+		r.mu.Unlock()
+		return &pipeFakeProcess{
+			cmd:       cmd,
+			chunkSize: chunkSize,
+			maxReads:  maxReads,
+			onChunk: func(chunk []byte) {
+				r.mu.Lock()
+				r.processes[index] = append(r.processes[index], string(chunk))
+				r.mu.Unlock()
+			},
+		}
+	}
+}
+
+func (r *pipeRecorder) all() []string {
+	var all []string
+	for _, chunks := range r.processes {
+		all = append(all, chunks...)
+	}
+	return all
+}
+
+func newTestCmd() *exec.Cmd { return exec.Command("ffmpeg") }
+
+// frameSource returns n frames and then io.EOF, n < 0 never ends.
+func frameSource(n int) func(context.Context) ([]byte, error) {
+	i := 0
+	return func(context.Context) ([]byte, error) {
+		if i == n {
+			return nil, io.EOF
+		}
+		frame := []byte{'a' + byte(i%26), 'a' + byte(i%26)}
+		i++
+		return frame, nil
+	}
+}
+
+func TestResilientPipe(t *testing.T) {
+	t.Run("frames", func(t *testing.T) {
+		recorder := &pipeRecorder{}
+		var restarts []int
+		p, err := NewResilientPipe(ResilientPipeConfig{
+			NewCmd:     newTestCmd,
+			NewProcess: recorder.newProcess(2, 3),
+			NextFrame:  frameSource(10),
+			Restart:    RestartPolicy{MaxRestarts: 5},
+			OnRestart: func(exitErr error, n int) {
+				require.ErrorIs(t, exitErr, errFakeCrash)
+				restarts = append(restarts, n)
+			},
+		})
+		require.NoError(t, err)
+		require.NoError(t, p.Run(context.Background()))
+
+		// Every frame is received once and in order.
+		expected := [][]string{
+			{"aa", "bb", "cc"},
+			{"dd", "ee", "ff"},
+			{"gg", "hh", "ii"},
+			{"jj"},
+		}
+		require.Equal(t, expected, recorder.processes)
+		require.Equal(t, []int{1, 2, 3}, restarts)
+
+		stats := p.Stats()
+		require.Equal(t, 3, stats.Restarts)
+		require.Equal(t, int64(20), stats.BytesWritten)
+		require.ErrorIs(t, stats.LastExitErr, errFakeCrash)
+	})
+	t.Run("restartLimit", func(t *testing.T) {
+		recorder := &pipeRecorder{}
+		p, err := NewResilientPipe(ResilientPipeConfig{
+			NewCmd:     newTestCmd,
+			NewProcess: recorder.newProcess(2, 2),
+			NextFrame:  frameSource(-1),
+			Restart:    RestartPolicy{MaxRestarts: 2},
+		})
+		require.NoError(t, err)
+
+		err = p.Run(context.Background())
+		require.ErrorIs(t, err, ErrRestartLimit)
+		require.ErrorIs(t, err, errFakeCrash)
+		require.Len(t, recorder.processes, 3)
+		require.Equal(t, []string{"aa", "bb", "cc", "dd", "ee", "ff"}, recorder.all())
+		require.Equal(t, 2, p.Stats().Restarts)
+	})
+	t.Run("restartWindow", func(t *testing.T) {
+		recorder := &pipeRecorder{}
+		p, err := NewResilientPipe(ResilientPipeConfig{
+			NewCmd:     newTestCmd,
+			NewProcess: recorder.newProcess(2, 2),
+			NextFrame:  frameSource(7),
+			Restart: RestartPolicy{
+				MaxRestarts: 1,
+				Window:      time.Nanosecond,
+				Delay:       time.Millisecond,
+			},
+		})
+		require.NoError(t, err)
+
+		// The previous restart is outside the window.
+		require.NoError(t, p.Run(context.Background()))
+		require.Len(t, recorder.all(), 7)
+		require.Equal(t, 3, p.Stats().Restarts)
+	})
+	t.Run("noRestarts", func(t *testing.T) {
+		recorder := &pipeRecorder{}
+		p, err := NewResilientPipe(ResilientPipeConfig{
+			NewCmd:     newTestCmd,
+			NewProcess: recorder.newProcess(2, 1),
+			NextFrame:  frameSource(-1),
+		})
+		require.NoError(t, err)
+		require.ErrorIs(t, p.Run(context.Background()), ErrRestartLimit)
+		require.Len(t, recorder.processes, 1)
+	})
+	t.Run("streamPrimer", func(t *testing.T) {
+		recorder := &pipeRecorder{}
+		source := bytes.NewReader([]byte("0123456789abcdefghijklmnopqrstuvwxyzABCD"))
+		p, err := NewResilientPipe(ResilientPipeConfig{
+			NewCmd:     newTestCmd,
+			NewProcess: recorder.newProcess(10, 3),
+			Reader:     source,
+			Primer:     func() []byte { return []byte("KEYFRAME!!") },
+			Restart:    RestartPolicy{MaxRestarts: 1},
+		})
+		require.NoError(t, err)
+		require.NoError(t, p.Run(context.Background()))
+
+		// The stream continues where the previous process stopped.
+		expected := [][]string{
+			{"0123456789", "abcdefghij", "klmnopqrst"},
+			{"KEYFRAME!!", "uvwxyzABCD"},
+		}
+		require.Equal(t, expected, recorder.processes)
+		require.Equal(t, int64(50), p.Stats().BytesWritten)
+	})
+	t.Run("sourceErr", func(t *testing.T) {
+		recorder := &pipeRecorder{}
+		p, err := NewResilientPipe(ResilientPipeConfig{
+			NewCmd:     newTestCmd,
+			NewProcess: recorder.newProcess(2, 10),
+			NextFrame: func(context.Context) ([]byte, error) {
+				return nil, errFakeCrash
+			},
+		})
+		require.NoError(t, err)
+		require.ErrorIs(t, p.Run(context.Background()), errFakeCrash)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		recorder := &pipeRecorder{}
+		p, err := NewResilientPipe(ResilientPipeConfig{
+			NewCmd:     newTestCmd,
+			NewProcess: recorder.newProcess(2, 10),
+			NextFrame: func(ctx context.Context) ([]byte, error) {
+				cancel()
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		})
+		require.NoError(t, err)
+		require.ErrorIs(t, p.Run(ctx), context.Canceled)
+	})
+	t.Run("config", func(t *testing.T) {
+		_, err := NewResilientPipe(ResilientPipeConfig{NewCmd: newTestCmd})
+		require.ErrorIs(t, err, ErrPipeSource)
+
+		_, err = NewResilientPipe(ResilientPipeConfig{
+			NewCmd:    newTestCmd,
+			Reader:    &bytes.Reader{},
+			NextFrame: frameSource(1),
+		})
+		require.ErrorIs(t, err, ErrPipeSource)
+
+		_, err = NewResilientPipe(ResilientPipeConfig{NextFrame: frameSource(1)})
+		require.ErrorIs(t, err, ErrPipeNoCmd)
+	})
+}
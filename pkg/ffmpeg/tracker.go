@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package ffmpeg
+
+import (
+	"context"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ProcessTracker keeps track of the running processes of each
+// owner, the owner is usually a monitor ID. The zero value is ready to use.
+type ProcessTracker struct {
+	mu        sync.Mutex
+	processes map[string]map[*trackedProcess]struct{}
+}
+
+// NewProcessFunc returns a NewProcessFunc that tracks
+// the processes as belonging to owner while they're running.
+func (t *ProcessTracker) NewProcessFunc(owner string, newProcess NewProcessFunc) NewProcessFunc {
+	return func(cmd *exec.Cmd) Process {
+		return &trackedProcess{
+			Process: newProcess(cmd),
+			owner:   owner,
+			tracker: t,
+		}
+	}
+}
+
+// PIDs returns the sorted process IDs of the running processes of each owner.
+func (t *ProcessTracker) PIDs() map[string][]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pids := make(map[string][]int)
+	for owner, processes := range t.processes {
+		for p := range processes {
+			// The process may not have started yet or already exited.
+			if pid := p.PID(); pid != 0 {
+				pids[owner] = append(pids[owner], pid)
+			}
+		}
+		sort.Ints(pids[owner])
+	}
+	return pids
+}
+
+func (t *ProcessTracker) add(p *trackedProcess) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.processes == nil {
+		t.processes = make(map[string]map[*trackedProcess]struct{})
+	}
+	if t.processes[p.owner] == nil {
+		t.processes[p.owner] = make(map[*trackedProcess]struct{})
+	}
+	t.processes[p.owner][p] = struct{}{}
+}
+
+func (t *ProcessTracker) remove(p *trackedProcess) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.processes[p.owner], p)
+	if len(t.processes[p.owner]) == 0 {
+		delete(t.processes, p.owner)
+	}
+}
+
+type trackedProcess struct {
+	Process
+	owner   string
+	tracker *ProcessTracker
+}
+
+func (p *trackedProcess) wrap(process Process) Process {
+	return &trackedProcess{
+		Process: process,
+		owner:   p.owner,
+		tracker: p.tracker,
+	}
+}
+
+func (p *trackedProcess) Timeout(timeout time.Duration) Process {
+	return p.wrap(p.Process.Timeout(timeout))
+}
+
+func (p *trackedProcess) StdoutLogger(l LogFunc) Process {
+	return p.wrap(p.Process.StdoutLogger(l))
+}
+
+func (p *trackedProcess) StderrLogger(l LogFunc) Process {
+	return p.wrap(p.Process.StderrLogger(l))
+}
+
+func (p *trackedProcess) Start(ctx context.Context) error {
+	p.tracker.add(p)
+	defer p.tracker.remove(p)
+	return p.Process.Start(ctx)
+}
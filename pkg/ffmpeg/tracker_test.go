@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package ffmpeg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessTracker(t *testing.T) {
+	var tracker ProcessTracker
+	require.Empty(t, tracker.PIDs())
+
+	newProcess := tracker.NewProcessFunc("a", NewProcess)
+	p := newProcess(fakeExecCommand("SLEEP=1")).
+		Timeout(5 * time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		p.Start(ctx) //nolint:errcheck
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(tracker.PIDs()["a"]) == 1
+	}, 5*time.Second, time.Millisecond)
+	require.Equal(t, []int{p.PID()}, tracker.PIDs()["a"])
+
+	// Exited processes disappear.
+	cancel()
+	<-done
+	require.Empty(t, tracker.PIDs())
+	require.Equal(t, 0, p.PID())
+}
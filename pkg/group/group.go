@@ -147,6 +147,30 @@ func (m *Manager) Configs() map[string]Config {
 	return configs
 }
 
+// Config validation errors.
+var (
+	ErrConfigIDMissing       = errors.New("id is missing")
+	ErrConfigNameMissing     = errors.New("name is missing")
+	ErrConfigMonitorsInvalid = errors.New("monitors is not a list of monitor IDs")
+)
+
+// ValidateConfig validates the group config. Returns every problem.
+func ValidateConfig(c Config) error {
+	var errs []error
+	if c["id"] == "" {
+		errs = append(errs, ErrConfigIDMissing)
+	}
+	if c["name"] == "" {
+		errs = append(errs, ErrConfigNameMissing)
+	}
+	if _, err := c.Monitors(); err != nil {
+		errs = append(errs, err)
+	} else if err := joinCellErrors(ValidateLayout(c)); err != nil {
+		errs = append(errs, fmt.Errorf("layout: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
 func (m *Manager) newGroup(config Config) *Group {
 	return &Group{
 		Config: config,
@@ -189,3 +189,46 @@ func TestGroupConfigs(t *testing.T) {
 	expected := "map[1:map[id:1 monitors:[\"1\"] name:one] 2:map[id:2 monitors:[\"2\"] name:two]]"
 	require.Equal(t, actual, expected)
 }
+
+func TestValidateConfig(t *testing.T) {
+	cases := map[string]struct {
+		config Config
+		errs   []error
+	}{
+		"ok": {
+			Config{"id": "1", "name": "one", "monitors": `["1"]`},
+			nil,
+		},
+		"empty": {
+			Config{},
+			[]error{ErrConfigIDMissing, ErrConfigNameMissing, ErrConfigMonitorsInvalid},
+		},
+		"invalidMonitors": {
+			Config{"id": "1", "name": "one", "monitors": "1"},
+			[]error{ErrConfigMonitorsInvalid},
+		},
+		"layout": {
+			Config{
+				"id": "1", "name": "one", "monitors": `["1"]`,
+				"layout": `{"columns":2,"rows":2,"cells":[{"monitorId":"2","x":1,"y":1,"width":2,"height":1}]}`,
+			},
+			[]error{ErrLayoutMonitorMissing, ErrLayoutCellBounds},
+		},
+		"invalidLayout": {
+			Config{"id": "1", "name": "one", "monitors": `["1"]`, "layout": "x"},
+			[]error{ErrLayoutInvalid},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateConfig(tc.config)
+			if tc.errs == nil {
+				require.NoError(t, err)
+				return
+			}
+			for _, e := range tc.errs {
+				require.ErrorIs(t, err, e)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package group
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Layout is a fixed grid layout of the group's monitors.
+// The live view lays out the monitors automatically if
+// the group doesn't have a layout.
+type Layout struct {
+	Columns int          `json:"columns"`
+	Rows    int          `json:"rows"`
+	Cells   []LayoutCell `json:"cells"`
+}
+
+// LayoutCell is the position and size of a monitor in the
+// grid. The position is zero based from the top left corner.
+type LayoutCell struct {
+	MonitorID string `json:"monitorId"`
+	X         int    `json:"x"`
+	Y         int    `json:"y"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+}
+
+// maxLayoutSize is the maximum number of columns and rows.
+const maxLayoutSize = 16
+
+// Layout validation errors.
+var (
+	ErrLayoutInvalid        = errors.New("layout is invalid")
+	ErrLayoutGridSize       = fmt.Errorf("columns and rows must be between 1 and %d", maxLayoutSize)
+	ErrLayoutCellSize       = errors.New("width and height must be at least 1")
+	ErrLayoutCellBounds     = errors.New("cell is outside the grid")
+	ErrLayoutCellOverlap    = errors.New("cell overlaps another cell")
+	ErrLayoutMonitorMissing = errors.New("monitor is not in the group")
+	ErrLayoutMonitorDup     = errors.New("monitor is in multiple cells")
+)
+
+// CellError is a validation error of a layout cell.
+type CellError struct {
+	// Index of the cell, -1 if the error is in the grid itself.
+	Cell int
+	Err  error
+}
+
+func (e CellError) Error() string {
+	if e.Cell == -1 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("cell %d: %v", e.Cell, e.Err)
+}
+
+func (e CellError) Unwrap() error {
+	return e.Err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e CellError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Cell  int    `json:"cell"`
+		Error string `json:"error"`
+	}{
+		Cell:  e.Cell,
+		Error: e.Err.Error(),
+	})
+}
+
+// Validate returns every problem with the layout, monitors
+// are the IDs of the monitors in the group. Overlapping
+// cells both get an error so both can be highlighted.
+func (l Layout) Validate(monitors []string) []CellError {
+	if l.Columns < 1 || l.Columns > maxLayoutSize || l.Rows < 1 || l.Rows > maxLayoutSize {
+		return []CellError{{Cell: -1, Err: ErrLayoutGridSize}}
+	}
+
+	inGroup := make(map[string]struct{}, len(monitors))
+	for _, id := range monitors {
+		inGroup[id] = struct{}{}
+	}
+
+	var errs []CellError
+	// Index of the cell that occupies each grid cell, -1 if free.
+	grid := make([]int, l.Columns*l.Rows)
+	for i := range grid {
+		grid[i] = -1
+	}
+	overlaps := make(map[[2]int]struct{})
+	seen := make(map[string]int)
+
+	for i, cell := range l.Cells {
+		if _, exist := inGroup[cell.MonitorID]; !exist {
+			errs = append(errs, CellError{
+				Cell: i,
+				Err:  fmt.Errorf("%w: %q", ErrLayoutMonitorMissing, cell.MonitorID),
+			})
+		}
+		if prev, exist := seen[cell.MonitorID]; exist {
+			errs = append(errs, CellError{
+				Cell: i,
+				Err:  fmt.Errorf("%w: %q is also in cell %d", ErrLayoutMonitorDup, cell.MonitorID, prev),
+			})
+		} else {
+			seen[cell.MonitorID] = i
+		}
+
+		if cell.Width < 1 || cell.Height < 1 {
+			errs = append(errs, CellError{Cell: i, Err: ErrLayoutCellSize})
+			continue
+		}
+		if !cell.inBounds(l.Columns, l.Rows) {
+			errs = append(errs, CellError{Cell: i, Err: ErrLayoutCellBounds})
+			continue
+		}
+
+		for y := cell.Y; y < cell.Y+cell.Height; y++ {
+			for x := cell.X; x < cell.X+cell.Width; x++ {
+				other := grid[y*l.Columns+x]
+				if other == -1 {
+					grid[y*l.Columns+x] = i
+					continue
+				}
+				pair := [2]int{other, i}
+				if _, reported := overlaps[pair]; reported {
+					continue
+				}
+				overlaps[pair] = struct{}{}
+				errs = append(errs,
+					CellError{
+						Cell: other,
+						Err:  fmt.Errorf("%w: %d", ErrLayoutCellOverlap, i),
+					},
+					CellError{
+						Cell: i,
+						Err:  fmt.Errorf("%w: %d", ErrLayoutCellOverlap, other),
+					},
+				)
+			}
+		}
+	}
+	return errs
+}
+
+// inBounds returns true if the cell including its span is inside the grid.
+func (c LayoutCell) inBounds(columns, rows int) bool {
+	return c.X >= 0 && c.Y >= 0 &&
+		c.Width <= columns-c.X && c.Height <= rows-c.Y
+}
+
+// Monitors returns the IDs of the monitors in the group.
+func (c Config) Monitors() ([]string, error) {
+	var monitors []string
+	if err := json.Unmarshal([]byte(c["monitors"]), &monitors); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfigMonitorsInvalid, err)
+	}
+	return monitors, nil
+}
+
+// Layout returns the layout of the group, nil if
+// the group uses the automatic layout.
+func (c Config) Layout() (*Layout, error) {
+	if c["layout"] == "" {
+		return nil, nil
+	}
+	var layout Layout
+	if err := json.Unmarshal([]byte(c["layout"]), &layout); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLayoutInvalid, err)
+	}
+	return &layout, nil
+}
+
+// SetLayout validates and sets the layout of the group, the
+// monitors must be set first. A nil layout removes the layout.
+func (c Config) SetLayout(layout *Layout) error {
+	if layout == nil {
+		delete(c, "layout")
+		return nil
+	}
+	monitors, err := c.Monitors()
+	if err != nil {
+		return err
+	}
+	if err := joinCellErrors(layout.Validate(monitors)); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(layout)
+	if err != nil {
+		return err
+	}
+	c["layout"] = string(raw)
+	return nil
+}
+
+// ValidateLayout returns the errors of the group's layout. Errors that
+// prevent the layout from being parsed are returned as grid errors.
+func ValidateLayout(c Config) []CellError {
+	layout, err := c.Layout()
+	if err != nil {
+		return []CellError{{Cell: -1, Err: err}}
+	}
+	if layout == nil {
+		return nil
+	}
+	monitors, err := c.Monitors()
+	if err != nil {
+		return []CellError{{Cell: -1, Err: err}}
+	}
+	return layout.Validate(monitors)
+}
+
+func joinCellErrors(cellErrs []CellError) error {
+	errs := make([]error, 0, len(cellErrs))
+	for _, err := range cellErrs {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,149 @@
+package group
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayoutValidate(t *testing.T) {
+	cell := func(id string, x, y, w, h int) LayoutCell {
+		return LayoutCell{MonitorID: id, X: x, Y: y, Width: w, Height: h}
+	}
+	monitors := []string{"a", "b", "c"}
+
+	cases := map[string]struct {
+		layout   Layout
+		expected map[int][]error
+	}{
+		"ok": {
+			Layout{Columns: 3, Rows: 3, Cells: []LayoutCell{
+				cell("a", 0, 0, 2, 2),
+				cell("b", 2, 0, 1, 3),
+				cell("c", 0, 2, 2, 1),
+			}},
+			nil,
+		},
+		"spanToEdge": {
+			Layout{Columns: 3, Rows: 2, Cells: []LayoutCell{
+				cell("a", 1, 1, 2, 1),
+			}},
+			nil,
+		},
+		"spanPastRight": {
+			Layout{Columns: 3, Rows: 2, Cells: []LayoutCell{
+				cell("a", 2, 0, 2, 1),
+			}},
+			map[int][]error{0: {ErrLayoutCellBounds}},
+		},
+		"spanPastBottom": {
+			Layout{Columns: 3, Rows: 2, Cells: []LayoutCell{
+				cell("a", 0, 1, 1, 2),
+			}},
+			map[int][]error{0: {ErrLayoutCellBounds}},
+		},
+		"negative": {
+			Layout{Columns: 3, Rows: 2, Cells: []LayoutCell{
+				cell("a", -1, 0, 2, 1),
+			}},
+			map[int][]error{0: {ErrLayoutCellBounds}},
+		},
+		"zeroSize": {
+			Layout{Columns: 3, Rows: 2, Cells: []LayoutCell{
+				cell("a", 0, 0, 0, 1),
+			}},
+			map[int][]error{0: {ErrLayoutCellSize}},
+		},
+		"overlap": {
+			Layout{Columns: 3, Rows: 3, Cells: []LayoutCell{
+				cell("a", 0, 0, 2, 2),
+				cell("b", 1, 1, 2, 2),
+				cell("c", 2, 2, 1, 1),
+			}},
+			map[int][]error{
+				0: {ErrLayoutCellOverlap},
+				1: {ErrLayoutCellOverlap, ErrLayoutCellOverlap},
+				2: {ErrLayoutCellOverlap},
+			},
+		},
+		"adjacent": {
+			Layout{Columns: 4, Rows: 1, Cells: []LayoutCell{
+				cell("a", 0, 0, 2, 1),
+				cell("b", 2, 0, 2, 1),
+			}},
+			nil,
+		},
+		"monitors": {
+			Layout{Columns: 2, Rows: 1, Cells: []LayoutCell{
+				cell("x", 0, 0, 1, 1),
+				cell("x", 1, 0, 1, 1),
+			}},
+			map[int][]error{
+				0: {ErrLayoutMonitorMissing},
+				1: {ErrLayoutMonitorMissing, ErrLayoutMonitorDup},
+			},
+		},
+		"gridSize": {
+			Layout{Columns: 0, Rows: 2},
+			map[int][]error{-1: {ErrLayoutGridSize}},
+		},
+		"gridTooLarge": {
+			Layout{Columns: maxLayoutSize + 1, Rows: 2},
+			map[int][]error{-1: {ErrLayoutGridSize}},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual := make(map[int][]error)
+			for _, err := range tc.layout.Validate(monitors) {
+				actual[err.Cell] = append(actual[err.Cell], err.Err)
+			}
+			require.Len(t, actual, len(tc.expected))
+			for i, errs := range tc.expected {
+				require.Len(t, actual[i], len(errs))
+				for j, err := range errs {
+					require.ErrorIs(t, actual[i][j], err)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigLayout(t *testing.T) {
+	t.Run("automatic", func(t *testing.T) {
+		layout, err := Config{"monitors": `["a"]`}.Layout()
+		require.NoError(t, err)
+		require.Nil(t, layout)
+	})
+	t.Run("set", func(t *testing.T) {
+		c := Config{"monitors": `["a","b"]`}
+		layout := &Layout{Columns: 2, Rows: 1, Cells: []LayoutCell{
+			{MonitorID: "a", X: 0, Y: 0, Width: 1, Height: 1},
+		}}
+		require.NoError(t, c.SetLayout(layout))
+
+		actual, err := c.Layout()
+		require.NoError(t, err)
+		require.Equal(t, layout, actual)
+
+		require.NoError(t, c.SetLayout(nil))
+		_, exist := c["layout"]
+		require.False(t, exist)
+	})
+	t.Run("setInvalid", func(t *testing.T) {
+		c := Config{"monitors": `["a"]`}
+		err := c.SetLayout(&Layout{Columns: 1, Rows: 1, Cells: []LayoutCell{
+			{MonitorID: "a", X: 0, Y: 0, Width: 2, Height: 1},
+		}})
+		require.ErrorIs(t, err, ErrLayoutCellBounds)
+		_, exist := c["layout"]
+		require.False(t, exist)
+	})
+}
+
+func TestCellErrorJSON(t *testing.T) {
+	raw, err := json.Marshal(CellError{Cell: 1, Err: ErrLayoutCellSize})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"cell":1,"error":"width and height must be at least 1"}`, string(raw))
+}
@@ -6,10 +6,14 @@ package log
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"nvr/pkg/metrics"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/text/cases"
@@ -39,7 +43,70 @@ type Entry struct {
 	Src       string    `json:"src"`
 	MonitorID string    `json:"monitorID"`
 	Msg       string    `json:"msg"`
-	Time      UnixMicro `json:"time"` // Timestamp. Do not set manually.
+	Fields    Fields    `json:"fields,omitempty"` // Optional.
+	Time      UnixMicro `json:"time"`             // Timestamp. Do not set manually.
+}
+
+// Fields structured data attached to a log entry. The values are not
+// included in the human-readable message, except for the error, see Text.
+type Fields map[string]string
+
+// FieldErr is the key of the error field.
+const FieldErr = "err"
+
+// Field is a single field that can be passed to a
+// Func after the format arguments, see Sprintf.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// F returns a field.
+//
+//	logf(log.LevelError, "save: %v", err, log.F("recordingID", id))
+func F(key, value string) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err returns the error field. The error is left out of the
+// format and appended to the human-readable message instead.
+//
+//	logf(log.LevelError, "save recording", log.Err(err))
+func Err(err error) Field {
+	return Field{Key: FieldErr, Value: err.Error()}
+}
+
+// Sprintf formats the message and returns the Field arguments
+// separately, they are removed before formatting. Used to
+// implement Func without changing the existing calls.
+func Sprintf(format string, a ...interface{}) (string, Fields) {
+	var fields Fields
+	args := make([]interface{}, 0, len(a))
+	for _, arg := range a {
+		if field, ok := arg.(Field); ok {
+			if fields == nil {
+				fields = make(Fields)
+			}
+			fields[field.Key] = field.Value
+			continue
+		}
+		args = append(args, arg)
+	}
+	return fmt.Sprintf(format, args...), fields
+}
+
+// Censor returns a copy of the entry where
+// censor has been applied to the message and fields.
+func (e Entry) Censor(censor func(string) string) Entry {
+	e.Msg = censor(e.Msg)
+	if e.Fields != nil {
+		fields := make(Fields, len(e.Fields))
+		for key, value := range e.Fields {
+			fields[key] = censor(value)
+		}
+		e.Fields = fields
+	}
+	return e
 }
 
 // GetTime entry timestamp as time.GetTime.
@@ -68,10 +135,19 @@ func (e Entry) String() string {
 	srcTitle := cases.Title(language.Und).String(e.Src)
 	b.WriteString(srcTitle + ": ")
 
-	b.WriteString(e.Msg)
+	b.WriteString(e.Text())
 	return b.String()
 }
 
+// Text returns the human-readable message, the
+// message followed by the error field if set.
+func (e Entry) Text() string {
+	if err, exist := e.Fields[FieldErr]; exist {
+		return e.Msg + ": " + err
+	}
+	return e.Msg
+}
+
 // FFmpegLevel converts ffmpeg log level to Level.
 func FFmpegLevel(logLevel string) Level {
 	switch logLevel {
@@ -95,13 +171,16 @@ type ILogger interface {
 
 // Logger .
 type Logger struct {
-	feed  chan Entry      // feed of logs.
-	sub   chan chan Entry // subscribe requests.
-	unsub chan chan Entry // unsubscribe requests.
+	feed  chan Entry         // feed of logs.
+	sub   chan *Subscription // subscribe requests.
+	unsub chan *Subscription // unsubscribe requests.
 
 	wg      *sync.WaitGroup
 	Ctx     context.Context
 	sources []string
+	running atomic.Bool
+
+	errorsTotal *metrics.CounterVec
 }
 
 var defaultSources = []string{"app", "auth", "monitor", "recorder"}
@@ -110,14 +189,29 @@ var defaultSources = []string{"app", "auth", "monitor", "recorder"}
 func NewLogger(wg *sync.WaitGroup, addonSources []string) *Logger {
 	return &Logger{
 		feed:  make(chan Entry),
-		sub:   make(chan chan Entry),
-		unsub: make(chan chan Entry),
+		sub:   make(chan *Subscription),
+		unsub: make(chan *Subscription),
 
 		wg:      wg,
 		sources: append(defaultSources, addonSources...),
+
+		errorsTotal: newErrorsTotal(),
 	}
 }
 
+func newErrorsTotal() *metrics.CounterVec {
+	return metrics.NewCounterVec(
+		"nvr_log_errors_total",
+		"Number of error logs.",
+		metrics.LabelSource,
+	)
+}
+
+// RegisterMetrics registers the log metrics.
+func (l *Logger) RegisterMetrics(r *metrics.Registry) {
+	r.MustRegister(l.errorsTotal)
+}
+
 // Log to logger.
 func (l *Logger) Log(log Entry) {
 	if log.Level == 0 {
@@ -133,6 +227,10 @@ func (l *Logger) Log(log Entry) {
 
 	log.Time = UnixMicro(time.Now().UnixMicro())
 
+	if log.Level == LevelError && l.errorsTotal != nil {
+		l.errorsTotal.With(log.Src).Inc()
+	}
+
 	select {
 	case <-l.Ctx.Done():
 	case l.feed <- log:
@@ -147,30 +245,34 @@ func (l *Logger) Sources() []string {
 // Start logger.
 func (l *Logger) Start(ctx context.Context) error {
 	l.Ctx = ctx
+	l.running.Store(true)
 
 	l.wg.Add(1)
 	go func() {
-		subs := map[chan Entry]struct{}{}
+		subs := map[*Subscription]struct{}{}
 		for {
 			select {
 			case <-ctx.Done():
 				// Only exit if everyone has unsubscribed.
 				if len(subs) == 0 {
+					l.running.Store(false)
 					l.wg.Done()
 					return
 				}
 				time.Sleep(50 * time.Millisecond)
 
-			case ch := <-l.sub:
-				subs[ch] = struct{}{}
+			case sub := <-l.sub:
+				subs[sub] = struct{}{}
 
-			case ch := <-l.unsub:
-				close(ch)
-				delete(subs, ch)
+			case sub := <-l.unsub:
+				sub.close()
+				delete(subs, sub)
 
 			case msg := <-l.feed:
-				for ch := range subs {
-					ch <- msg
+				// Subscribers are buffered, slow
+				// subscribers can't block the feed.
+				for sub := range subs {
+					sub.push(msg)
 				}
 			}
 		}
@@ -178,48 +280,84 @@ func (l *Logger) Start(ctx context.Context) error {
 	return nil
 }
 
-// CancelFunc cancels log feed subsciption.
-type CancelFunc func()
+// Running returns true if the logger has
+// been started and hasn't exited yet.
+func (l *Logger) Running() bool {
+	return l.running.Load()
+}
 
-// Subscribe returns a new chan with log feed and a CancelFunc.
-func (l *Logger) Subscribe() (<-chan Entry, CancelFunc) {
-	feed := make(chan Entry)
+// Subscribe returns a filtered log feed. The subscription
+// is canceled and the channel closed when ctx is canceled.
+func (l *Logger) Subscribe(ctx context.Context, filter Filter) *Subscription {
+	sub := newSubscription(filter)
 
 	select {
 	case <-l.Ctx.Done():
-		close(feed)
-		return feed, func() {}
-	case l.sub <- feed:
+		close(sub.out)
+		return sub
+	case l.sub <- sub:
 	}
 
-	cancel := func() {
-		l.unSubscribe(feed)
+	go sub.run()
+	go func() {
+		<-ctx.Done()
+		l.unsub <- sub
+	}()
+	return sub
+}
+
+// Format output format of a log feed subscriber.
+type Format string
+
+// Output formats.
+const (
+	// FormatText human-readable message, fields other than the error are omitted.
+	FormatText Format = "text"
+
+	// FormatJSON one JSON object per entry.
+	FormatJSON Format = "json"
+)
+
+// ErrUnknownFormat unknown output format.
+var ErrUnknownFormat = errors.New("unknown log format")
+
+// ParseFormat parses a output format, empty defaults to text.
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case "", FormatText:
+		return FormatText, nil
+	case FormatJSON:
+		return FormatJSON, nil
 	}
-	return feed, cancel
+	return "", fmt.Errorf("%w: %q", ErrUnknownFormat, s)
 }
 
-func (l *Logger) unSubscribe(feed chan Entry) {
-	// Read feed until unsub request is accepted.
-	for {
-		select {
-		case l.unsub <- feed:
-			return
-		case <-feed:
-		}
+// Encode returns the entry in the specified format without a trailing newline.
+func (e Entry) Encode(format Format) ([]byte, error) {
+	if format == FormatJSON {
+		return json.Marshal(e)
 	}
+	return []byte(e.String()), nil
 }
 
 // LogToWriter prints log feed to writer.
-func (l *Logger) LogToWriter(ctx context.Context, out io.Writer) {
+func (l *Logger) LogToWriter(ctx context.Context, out io.Writer, format Format) {
 	l.wg.Add(1)
 	go func() {
-		feed, cancel := l.Subscribe()
-		defer cancel()
+		feed := l.Subscribe(ctx, Filter{}).C
 
 		for {
 			select {
-			case entry := <-feed:
-				fmt.Fprintln(out, entry)
+			case entry, ok := <-feed:
+				if !ok {
+					feed = nil
+					continue
+				}
+				raw, err := entry.Encode(format)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintln(out, string(raw))
 			case <-ctx.Done():
 				l.wg.Done()
 				return
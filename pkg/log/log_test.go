@@ -4,7 +4,9 @@ package log
 
 import (
 	"context"
+	"encoding/json"
 	"io"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -15,8 +17,8 @@ import (
 func newTestLogger(t *testing.T) (func(), *Logger) {
 	logger := &Logger{
 		feed:  make(chan Entry),
-		sub:   make(chan chan Entry),
-		unsub: make(chan chan Entry),
+		sub:   make(chan *Subscription),
+		unsub: make(chan *Subscription),
 		wg:    &sync.WaitGroup{},
 	}
 
@@ -26,6 +28,11 @@ func newTestLogger(t *testing.T) (func(), *Logger) {
 	return cancel, logger
 }
 
+func subscribe(logger *Logger, filter Filter) (<-chan Entry, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return logger.Subscribe(ctx, filter).C, cancel
+}
+
 func TestLoggerMSG(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		cancel, logger := newTestLogger(t)
@@ -47,7 +54,7 @@ func TestLoggerMSG(t *testing.T) {
 			})
 		}()
 
-		feed, cancel2 := logger.Subscribe()
+		feed, cancel2 := subscribe(logger, Filter{})
 		defer cancel2()
 
 		actual := []Entry{<-feed, <-feed, <-feed, <-feed}
@@ -96,7 +103,7 @@ func TestLogger(t *testing.T) {
 		cancel()
 		time.Sleep(10 * time.Millisecond)
 
-		feed, cancel2 := logger.Subscribe()
+		feed, cancel2 := subscribe(logger, Filter{})
 		<-feed
 		_, ok := <-feed
 		require.False(t, ok)
@@ -106,7 +113,7 @@ func TestLogger(t *testing.T) {
 	t.Run("closeChannels", func(t *testing.T) {
 		cancel, logger := newTestLogger(t)
 
-		feed1, cancel2 := logger.Subscribe()
+		feed1, cancel2 := subscribe(logger, Filter{})
 
 		cancel()
 		cancel2()
@@ -118,24 +125,24 @@ func TestLogger(t *testing.T) {
 		cancel, logger := newTestLogger(t)
 		defer cancel()
 
-		feed1, cancel1 := logger.Subscribe()
-		feed2, cancel2 := logger.Subscribe()
+		feed1, cancel1 := subscribe(logger, Filter{})
+		feed2, cancel2 := subscribe(logger, Filter{})
 		cancel2()
+		_, ok := <-feed2
+		require.False(t, ok)
 
 		msg := "test"
 		logger.Log(Entry{Level: LevelInfo, Src: "test", Msg: msg})
 		actual1 := <-feed1
-		actual2 := <-feed2
 		cancel1()
 
 		require.Equal(t, actual1.Msg, msg)
-		require.Equal(t, actual2.Msg, "")
 	})
 	t.Run("unsubAfterPrint", func(t *testing.T) {
 		cancel, logger := newTestLogger(t)
 		defer cancel()
 
-		feed, cancel2 := logger.Subscribe()
+		feed, cancel2 := subscribe(logger, Filter{})
 
 		newTestEntry := func() Entry {
 			return Entry{Level: LevelInfo, Src: ".", Msg: "test"}
@@ -147,8 +154,12 @@ func TestLogger(t *testing.T) {
 		time.Sleep(10 * time.Microsecond)
 		cancel2()
 
-		actual := <-feed
-		require.Equal(t, actual.Msg, "")
+		// Unread entries are discarded.
+		n := 0
+		for range feed {
+			n++
+		}
+		require.LessOrEqual(t, n, 3)
 	})
 	t.Run("logToWriter", func(t *testing.T) {
 		cancel, logger := newTestLogger(t)
@@ -163,7 +174,7 @@ func TestLogger(t *testing.T) {
 
 		w, writes := newMockWriter()
 
-		go logger.LogToWriter(ctx, w)
+		go logger.LogToWriter(ctx, w, FormatText)
 		time.Sleep(100 * time.Millisecond)
 
 		go logger.Log(newTestEntry(LevelError))
@@ -178,6 +189,150 @@ func TestLogger(t *testing.T) {
 		go logger.Log(newTestEntry(LevelDebug))
 		require.Equal(t, "[DEBUG] Src: msg\n", <-writes)
 	})
+	t.Run("logToWriterJSON", func(t *testing.T) {
+		cancel, logger := newTestLogger(t)
+		defer cancel()
+
+		ctx, cancel2 := context.WithCancel(context.Background())
+		defer cancel2()
+
+		w, writes := newMockWriter()
+
+		go logger.LogToWriter(ctx, w, FormatJSON)
+		time.Sleep(100 * time.Millisecond)
+
+		go logger.Log(Entry{
+			Level:  LevelError,
+			Src:    "src",
+			Msg:    "msg",
+			Fields: Fields{"a": "b"},
+		})
+
+		var actual Entry
+		require.NoError(t, json.Unmarshal([]byte(<-writes), &actual))
+		actual.Time = 0
+
+		expected := Entry{
+			Level:  LevelError,
+			Src:    "src",
+			Msg:    "msg",
+			Fields: Fields{"a": "b"},
+		}
+		require.Equal(t, expected, actual)
+	})
+	t.Run("fields", func(t *testing.T) {
+		cancel, logger := newTestLogger(t)
+		defer cancel()
+
+		feed1, cancel1 := subscribe(logger, Filter{})
+		defer cancel1()
+		feed2, cancel2 := subscribe(logger, Filter{})
+		defer cancel2()
+
+		logf := func(level Level, format string, a ...interface{}) {
+			msg, fields := Sprintf(format, a...)
+			logger.Log(Entry{Level: level, Src: "src", Msg: msg, Fields: fields})
+		}
+		go logf(LevelInfo, "saved: %v", "x", F("recordingID", "x"), F("path", "/y"))
+
+		expected := Fields{"recordingID": "x", "path": "/y"}
+		actual1 := <-feed1
+		actual2 := <-feed2
+		require.Equal(t, "saved: x", actual1.Msg)
+		require.Equal(t, expected, actual1.Fields)
+		require.Equal(t, expected, actual2.Fields)
+	})
+}
+
+func TestLoggerRunning(t *testing.T) {
+	cancel, logger := newTestLogger(t)
+	require.True(t, logger.Running())
+
+	cancel()
+	logger.wg.Wait()
+	require.False(t, logger.Running())
+}
+
+func TestSprintf(t *testing.T) {
+	cases := []struct {
+		name           string
+		format         string
+		args           []interface{}
+		expectedMsg    string
+		expectedFields Fields
+	}{
+		{"noFields", "a %v %v", []interface{}{1, 2}, "a 1 2", nil},
+		{
+			"fields",
+			"a %v %v",
+			[]interface{}{1, F("x", "y"), 2, F("z", "w")},
+			"a 1 2",
+			Fields{"x": "y", "z": "w"},
+		},
+		{"onlyFields", "a", []interface{}{F("x", "y")}, "a", Fields{"x": "y"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, fields := Sprintf(tc.format, tc.args...)
+			require.Equal(t, tc.expectedMsg, msg)
+			require.Equal(t, tc.expectedFields, fields)
+		})
+	}
+}
+
+func TestEntryCensor(t *testing.T) {
+	censor := func(s string) string {
+		return strings.ReplaceAll(s, "secret", "$X")
+	}
+	fields := Fields{"a": "1 secret"}
+	entry := Entry{Msg: "secret 2", Fields: fields}
+
+	actual := entry.Censor(censor)
+	expected := Entry{Msg: "$X 2", Fields: Fields{"a": "1 $X"}}
+	require.Equal(t, expected, actual)
+
+	// The original fields must not be modified.
+	require.Equal(t, Fields{"a": "1 secret"}, fields)
+
+	require.Equal(t, Entry{Msg: "$X"}, Entry{Msg: "secret"}.Censor(censor))
+}
+
+func TestEntryText(t *testing.T) {
+	require.Equal(t, "a", Entry{Msg: "a", Fields: Fields{"b": "c"}}.Text())
+
+	entry := Entry{
+		Level:  LevelError,
+		Src:    "s",
+		Msg:    "save recording",
+		Fields: Fields{"recordingID": "x", FieldErr: "disk full"},
+	}
+	require.Equal(t, "save recording: disk full", entry.Text())
+	require.Equal(t, "[ERROR] S: save recording: disk full", entry.String())
+
+	raw, err := entry.Encode(FormatJSON)
+	require.NoError(t, err)
+	require.Contains(t, string(raw), `"msg":"save recording"`)
+	require.Contains(t, string(raw), `"err":"disk full"`)
+}
+
+func TestParseFormat(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected Format
+		err      error
+	}{
+		{"", FormatText, nil},
+		{"text", FormatText, nil},
+		{"json", FormatJSON, nil},
+		{"nil", "", ErrUnknownFormat},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			actual, err := ParseFormat(tc.input)
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
 }
 
 type mockWriter struct {
@@ -109,8 +109,7 @@ func NewStore(
 func (s *Store) SaveLogs(ctx context.Context, logger *Logger) {
 	s.wg.Add(1)
 	go func() {
-		feed, cancel := logger.Subscribe()
-		defer cancel()
+		feed := logger.Subscribe(ctx, Filter{Limit: MaxSubscriptionLimit}).C
 
 		for {
 			select {
@@ -120,7 +119,11 @@ func (s *Store) SaveLogs(ctx context.Context, logger *Logger) {
 				}
 				s.wg.Done()
 				return
-			case log := <-feed:
+			case log, ok := <-feed:
+				if !ok {
+					feed = nil
+					continue
+				}
 				err := s.saveLog(log)
 				if err != nil {
 					fmt.Printf("could not save log: %v %v\n", log.Msg, err)
@@ -583,8 +586,11 @@ func encodeEntry(buf []byte, entry Entry, msgFile io.Writer, msgOffset *uint32)
 		return ErrMonitorIDTooLong
 	}
 
+	// The fields aren't stored, only the human-readable message.
+	msg := entry.Text()
+
 	// Write message and newline.
-	_, err := msgFile.Write(append([]byte(entry.Msg), byte('\n')))
+	_, err := msgFile.Write(append([]byte(msg), byte('\n')))
 	if err != nil {
 		return fmt.Errorf("write msg: %w", err)
 	}
@@ -602,11 +608,11 @@ func encodeEntry(buf []byte, entry Entry, msgFile io.Writer, msgOffset *uint32)
 	))
 	// Message offset and size.
 	binary.BigEndian.PutUint32(buf[40:44], *msgOffset)
-	binary.BigEndian.PutUint16(buf[44:46], uint16(len(entry.Msg)))
+	binary.BigEndian.PutUint16(buf[44:46], uint16(len(msg)))
 	// Level.
 	buf[46] = byte(entry.Level)
 
-	*msgOffset += uint32(len(entry.Msg)) + 1
+	*msgOffset += uint32(len(msg)) + 1
 
 	return nil
 }
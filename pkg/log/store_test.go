@@ -230,6 +230,22 @@ func TestQuery(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, expectedFile, actualFile)
 	})
+	t.Run("errField", func(t *testing.T) {
+		logDir := t.TempDir()
+
+		store := newTestStore(t, logDir)
+		store.saveLog(Entry{Time: 1, Msg: "a", Fields: Fields{FieldErr: "b"}})
+		store.saveLog(Entry{Time: 2, Msg: "c"})
+
+		// The error is stored as part of the message.
+		expected := []Entry{
+			{Time: 2, Msg: "c"},
+			{Time: 1, Msg: "a: b"},
+		}
+		actual, err := store.Query(Query{})
+		require.NoError(t, err)
+		require.Equal(t, expected, actual)
+	})
 	t.Run("order", func(t *testing.T) {
 		logDir := t.TempDir()
 
@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package log
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Default and max number of buffered entries per subscription.
+const (
+	DefaultSubscriptionLimit = 1000
+	MaxSubscriptionLimit     = 10000
+)
+
+// Filter selects the entries of a subscription.
+type Filter struct {
+	// Least severe level to include, LevelError is the most
+	// severe and LevelDebug the least. Zero includes all levels.
+	MinLevel Level
+
+	// Empty includes all sources.
+	Sources []string
+
+	// Empty includes all monitors, entries without a
+	// monitor ID are excluded if monitors are specified.
+	MonitorIDs []string
+
+	// Max number of buffered entries, the oldest entries
+	// are dropped when the subscriber falls behind.
+	// Zero uses DefaultSubscriptionLimit.
+	Limit int
+}
+
+// Match returns true if the entry passes the filter.
+func (f Filter) Match(e Entry) bool {
+	if f.MinLevel != 0 && e.Level > f.MinLevel {
+		return false
+	}
+	return StringInStrings(e.Src, f.Sources) &&
+		StringInStrings(e.MonitorID, f.MonitorIDs)
+}
+
+func (f Filter) limit() int {
+	switch {
+	case f.Limit <= 0:
+		return DefaultSubscriptionLimit
+	case f.Limit > MaxSubscriptionLimit:
+		return MaxSubscriptionLimit
+	}
+	return f.Limit
+}
+
+// Subscription is a filtered log feed. Entries are buffered
+// in a ring buffer so the logger never waits for the subscriber.
+type Subscription struct {
+	// C receives the entries. Closed when the subscription is canceled.
+	C <-chan Entry
+
+	filter Filter
+	out    chan Entry
+
+	mu  sync.Mutex
+	buf ringBuffer
+
+	notify  chan struct{}
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+func newSubscription(filter Filter) *Subscription {
+	out := make(chan Entry)
+	return &Subscription{
+		C:      out,
+		filter: filter,
+		out:    out,
+		buf:    newRingBuffer(filter.limit()),
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// Dropped returns the number of entries that were dropped
+// because the subscriber didn't keep up with the feed.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// push adds the entry to the buffer if it matches the filter, never blocks.
+func (s *Subscription) push(e Entry) {
+	if !s.filter.Match(e) {
+		return
+	}
+	s.mu.Lock()
+	if s.buf.push(e) {
+		s.dropped.Add(1)
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Subscription) pop() (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.pop()
+}
+
+// run delivers the buffered entries until the subscription is closed.
+func (s *Subscription) run() {
+	defer close(s.out)
+	for {
+		entry, ok := s.pop()
+		if !ok {
+			select {
+			case <-s.notify:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		select {
+		case s.out <- entry:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// close stops delivery, buffered entries are discarded.
+func (s *Subscription) close() {
+	close(s.done)
+}
+
+// ringBuffer is a bounded FIFO queue that overwrites the oldest
+// entry when full. Memory is allocated as the buffer fills up.
+type ringBuffer struct {
+	entries []Entry
+	limit   int
+	head    int
+	size    int
+}
+
+func newRingBuffer(limit int) ringBuffer {
+	return ringBuffer{limit: limit}
+}
+
+// push adds the entry and returns true if the oldest entry was dropped.
+func (b *ringBuffer) push(e Entry) bool {
+	if b.size == len(b.entries) && b.size < b.limit {
+		b.grow()
+	}
+	tail := (b.head + b.size) % len(b.entries)
+	b.entries[tail] = e
+	if b.size == len(b.entries) {
+		b.head = (b.head + 1) % len(b.entries)
+		return true
+	}
+	b.size++
+	return false
+}
+
+func (b *ringBuffer) grow() {
+	entries := make([]Entry, min(max(2*len(b.entries), 16), b.limit))
+	for i := 0; i < b.size; i++ {
+		entries[i] = b.entries[(b.head+i)%len(b.entries)]
+	}
+	b.entries = entries
+	b.head = 0
+}
+
+func (b *ringBuffer) pop() (Entry, bool) {
+	if b.size == 0 {
+		return Entry{}, false
+	}
+	e := b.entries[b.head]
+	b.entries[b.head] = Entry{}
+	b.head = (b.head + 1) % len(b.entries)
+	b.size--
+	return e, true
+}
@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package log
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterMatch(t *testing.T) {
+	entry := Entry{Level: LevelInfo, Src: "app", MonitorID: "m1"}
+	cases := map[string]struct {
+		filter   Filter
+		entry    Entry
+		expected bool
+	}{
+		"empty":          {Filter{}, entry, true},
+		"minLevelEqual":  {Filter{MinLevel: LevelInfo}, entry, true},
+		"minLevelBelow":  {Filter{MinLevel: LevelDebug}, entry, true},
+		"minLevelAbove":  {Filter{MinLevel: LevelWarning}, entry, false},
+		"source":         {Filter{Sources: []string{"monitor", "app"}}, entry, true},
+		"sourceMismatch": {Filter{Sources: []string{"monitor"}}, entry, false},
+		"monitor":        {Filter{MonitorIDs: []string{"m1"}}, entry, true},
+		"monitorMismatch": {
+			Filter{MonitorIDs: []string{"m2"}}, entry, false,
+		},
+		"noMonitorID": {
+			Filter{MonitorIDs: []string{"m1"}},
+			Entry{Level: LevelInfo, Src: "app"},
+			false,
+		},
+		"all": {
+			Filter{
+				MinLevel:   LevelInfo,
+				Sources:    []string{"app"},
+				MonitorIDs: []string{"m1"},
+			},
+			entry,
+			true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, tc.filter.Match(tc.entry))
+		})
+	}
+}
+
+func TestFilterLimit(t *testing.T) {
+	require.Equal(t, DefaultSubscriptionLimit, Filter{}.limit())
+	require.Equal(t, DefaultSubscriptionLimit, Filter{Limit: -1}.limit())
+	require.Equal(t, 5, Filter{Limit: 5}.limit())
+	require.Equal(t, MaxSubscriptionLimit, Filter{Limit: MaxSubscriptionLimit + 1}.limit())
+}
+
+func TestRingBuffer(t *testing.T) {
+	b := newRingBuffer(20)
+	for i := 0; i < 25; i++ {
+		dropped := b.push(Entry{Msg: strconv.Itoa(i)})
+		require.Equal(t, i >= 20, dropped)
+	}
+	for i := 5; i < 25; i++ {
+		e, ok := b.pop()
+		require.True(t, ok)
+		require.Equal(t, strconv.Itoa(i), e.Msg)
+	}
+	_, ok := b.pop()
+	require.False(t, ok)
+}
+
+func TestSubscribe(t *testing.T) {
+	t.Run("filter", func(t *testing.T) {
+		cancel, logger := newTestLogger(t)
+		defer cancel()
+
+		feed, cancel2 := subscribe(logger, Filter{
+			MinLevel:   LevelWarning,
+			Sources:    []string{"app"},
+			MonitorIDs: []string{"m1"},
+		})
+		defer cancel2()
+
+		logger.Log(Entry{Level: LevelDebug, Src: "app", MonitorID: "m1", Msg: "1"})
+		logger.Log(Entry{Level: LevelError, Src: "app", MonitorID: "m2", Msg: "2"})
+		logger.Log(Entry{Level: LevelError, Src: "auth", MonitorID: "m1", Msg: "3"})
+		logger.Log(Entry{Level: LevelError, Src: "app", Msg: "4"})
+		logger.Log(Entry{Level: LevelWarning, Src: "app", MonitorID: "m1", Msg: "5"})
+		logger.Log(Entry{Level: LevelError, Src: "app", MonitorID: "m1", Msg: "6"})
+
+		require.Equal(t, "5", (<-feed).Msg)
+		require.Equal(t, "6", (<-feed).Msg)
+	})
+	t.Run("slowConsumer", func(t *testing.T) {
+		cancel, logger := newTestLogger(t)
+		defer cancel()
+
+		ctx, cancel2 := context.WithCancel(context.Background())
+		defer cancel2()
+		sub := logger.Subscribe(ctx, Filter{Sources: []string{"app"}, Limit: 3})
+
+		// The subscriber isn't reading, the logger must not block.
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < 10; i++ {
+				logger.Log(Entry{Level: LevelInfo, Src: "app", Msg: strconv.Itoa(i)})
+			}
+			// Filtered out, received after the others have been buffered.
+			logger.Log(Entry{Level: LevelInfo, Src: "flush", Msg: "x"})
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("logger blocked by slow subscriber")
+		}
+
+		// One entry may have been taken by the delivery goroutine
+		// before the buffer filled up, the rest are the newest.
+		var msgs []string
+		for {
+			e := <-sub.C
+			msgs = append(msgs, e.Msg)
+			if e.Msg == "9" {
+				break
+			}
+		}
+		require.Equal(t, []string{"7", "8", "9"}, msgs[len(msgs)-3:])
+		require.LessOrEqual(t, len(msgs), 4)
+		require.Equal(t, uint64(10-len(msgs)), sub.Dropped())
+	})
+	t.Run("cancel", func(t *testing.T) {
+		cancel, logger := newTestLogger(t)
+
+		ctx, cancel2 := context.WithCancel(context.Background())
+		sub := logger.Subscribe(ctx, Filter{})
+		logger.Log(Entry{Level: LevelInfo, Src: "app", Msg: "1"})
+
+		cancel2()
+		for range sub.C { //nolint:revive
+		}
+
+		// The logger only exits after everyone has unsubscribed.
+		cancel()
+		logger.wg.Wait()
+		require.False(t, logger.Running())
+	})
+	t.Run("loggerCanceled", func(t *testing.T) {
+		cancel, logger := newTestLogger(t)
+		cancel()
+		logger.wg.Wait()
+
+		sub := logger.Subscribe(context.Background(), Filter{})
+		_, ok := <-sub.C
+		require.False(t, ok)
+	})
+}
@@ -0,0 +1,493 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package metrics is a minimal metrics registry that is
+// exposed in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Label names. Only labels with a bounded number of values are
+// allowed, a label with unbounded values like the client address
+// would grow the registry forever.
+const (
+	LabelMonitorID = "monitor_id"
+	LabelSource    = "source"
+)
+
+var allowedLabels = map[string]bool{
+	LabelMonitorID: true,
+	LabelSource:    true,
+}
+
+// ContentType of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// Errors.
+var (
+	ErrDuplicateMetric = errors.New("duplicate metric")
+	ErrInvalidName     = errors.New("invalid metric name")
+	ErrLabelNotAllowed = errors.New("label not allowed")
+)
+
+// Collector is a metric that can be registered.
+type Collector interface {
+	desc() *desc
+	collect() []series
+}
+
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+// newDesc panics on invalid names, metrics are declared at startup.
+func newDesc(name string, help string, typ string, labels []string) *desc {
+	if !validName(name) {
+		panic(fmt.Sprintf("%v: %q", ErrInvalidName, name))
+	}
+	for _, label := range labels {
+		if !allowedLabels[label] {
+			panic(fmt.Sprintf("%v: %q", ErrLabelNotAllowed, label))
+		}
+	}
+	return &desc{name: name, help: help, typ: typ, labels: labels}
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_' || c == ':':
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i != 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// series is a single time series, histograms have multiple.
+type series struct {
+	suffix      string
+	labelValues []string
+	extraLabel  string // "le" label of histogram buckets.
+	extraValue  string
+	value       float64
+}
+
+// Registry of metrics.
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]Collector
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]Collector)}
+}
+
+// Register registers the collector, each name can only be registered once.
+func (r *Registry) Register(c Collector) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := c.desc().name
+	if _, exist := r.collectors[name]; exist {
+		return fmt.Errorf("%w: %v", ErrDuplicateMetric, name)
+	}
+	r.collectors[name] = c
+	return nil
+}
+
+// MustRegister registers the collectors and panics on error.
+func (r *Registry) MustRegister(cs ...Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Write writes all metrics in the text exposition format sorted by name.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	collectors := make([]Collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].desc().name < collectors[j].desc().name
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		writeMetric(bw, c.desc(), c.collect())
+	}
+	return bw.Flush()
+}
+
+func writeMetric(w *bufio.Writer, d *desc, allSeries []series) {
+	sort.SliceStable(allSeries, func(i, j int) bool {
+		return strings.Join(allSeries[i].labelValues, "\xff") <
+			strings.Join(allSeries[j].labelValues, "\xff")
+	})
+
+	w.WriteString("# HELP " + d.name + " " + escapeHelp(d.help) + "\n")
+	w.WriteString("# TYPE " + d.name + " " + d.typ + "\n")
+	for _, s := range allSeries {
+		w.WriteString(d.name + s.suffix)
+
+		var labels []string
+		for i, name := range d.labels {
+			labels = append(labels, name+`="`+escapeLabelValue(s.labelValues[i])+`"`)
+		}
+		if s.extraLabel != "" {
+			labels = append(labels, s.extraLabel+`="`+s.extraValue+`"`)
+		}
+		if len(labels) != 0 {
+			w.WriteString("{" + strings.Join(labels, ",") + "}")
+		}
+
+		w.WriteString(" " + formatFloat(s.value) + "\n")
+	}
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// atomicFloat is a float64 that can be updated concurrently.
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) add(v float64) {
+	for {
+		old := f.bits.Load()
+		new := math.Float64bits(math.Float64frombits(old) + v)
+		if f.bits.CompareAndSwap(old, new) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) set(v float64) {
+	f.bits.Store(math.Float64bits(v))
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+// vec stores one value per combination of label values.
+type vec[T any] struct {
+	d      *desc
+	newVal func() *T
+
+	mu     sync.Mutex
+	values map[string]*T
+	labels map[string][]string
+}
+
+func newVec[T any](d *desc, newVal func() *T) *vec[T] {
+	return &vec[T]{
+		d:      d,
+		newVal: newVal,
+		values: make(map[string]*T),
+		labels: make(map[string][]string),
+	}
+}
+
+func (v *vec[T]) with(labelValues []string) *T {
+	if len(labelValues) != len(v.d.labels) {
+		panic(fmt.Sprintf("%v: expected %d label values, got %d",
+			v.d.name, len(v.d.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	val, exist := v.values[key]
+	if !exist {
+		val = v.newVal()
+		v.values[key] = val
+		v.labels[key] = append([]string(nil), labelValues...)
+	}
+	return val
+}
+
+// each calls fn for every value while holding the lock.
+func (v *vec[T]) each(fn func(labelValues []string, val *T)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for key, val := range v.values {
+		fn(v.labels[key], val)
+	}
+}
+
+// Counter is a value that only increases. A nil counter discards the values.
+type Counter struct {
+	v atomicFloat
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds v to the counter, negative values are ignored.
+func (c *Counter) Add(v float64) {
+	if c != nil && v > 0 {
+		c.v.add(v)
+	}
+}
+
+// Value returns the current value.
+func (c *Counter) Value() float64 {
+	if c == nil {
+		return 0
+	}
+	return c.v.load()
+}
+
+// CounterVec is a counter with labels.
+type CounterVec struct {
+	vec *vec[Counter]
+}
+
+// NewCounterVec creates a counter with the label names. Panics if
+// the name is invalid or a label isn't allowed.
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	d := newDesc(name, help, typeCounter, labels)
+	return &CounterVec{vec: newVec(d, func() *Counter { return &Counter{} })}
+}
+
+// With returns the counter of the label values, nil if c is nil.
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	if c == nil {
+		return nil
+	}
+	return c.vec.with(labelValues)
+}
+
+func (c *CounterVec) desc() *desc {
+	return c.vec.d
+}
+
+func (c *CounterVec) collect() []series {
+	var all []series
+	c.vec.each(func(labelValues []string, val *Counter) {
+		all = append(all, series{labelValues: labelValues, value: val.v.load()})
+	})
+	return all
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	v atomicFloat
+}
+
+// Set sets the gauge.
+func (g *Gauge) Set(v float64) {
+	g.v.set(v)
+}
+
+// Add adds v to the gauge, v can be negative.
+func (g *Gauge) Add(v float64) {
+	g.v.add(v)
+}
+
+// GaugeVec is a gauge with labels.
+type GaugeVec struct {
+	vec *vec[Gauge]
+}
+
+// NewGaugeVec creates a gauge with the label names. Panics if
+// the name is invalid or a label isn't allowed.
+func NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	d := newDesc(name, help, typeGauge, labels)
+	return &GaugeVec{vec: newVec(d, func() *Gauge { return &Gauge{} })}
+}
+
+// With returns the gauge of the label values.
+func (g *GaugeVec) With(labelValues ...string) *Gauge {
+	return g.vec.with(labelValues)
+}
+
+func (g *GaugeVec) desc() *desc {
+	return g.vec.d
+}
+
+func (g *GaugeVec) collect() []series {
+	var all []series
+	g.vec.each(func(labelValues []string, val *Gauge) {
+		all = append(all, series{labelValues: labelValues, value: val.v.load()})
+	})
+	return all
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	upperBounds []float64
+	buckets     []atomic.Uint64 // Not cumulative.
+	count       atomic.Uint64
+	sum         atomicFloat
+}
+
+// Observe adds a single observation.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	if i < len(h.buckets) {
+		h.buckets[i].Add(1)
+	}
+	h.count.Add(1)
+	h.sum.add(v)
+}
+
+// HistogramVec is a histogram with labels.
+type HistogramVec struct {
+	vec         *vec[Histogram]
+	upperBounds []float64
+}
+
+// NewHistogramVec creates a histogram with the bucket upper bounds
+// and label names. The +Inf bucket is implicit. Panics if the name
+// is invalid, a label isn't allowed or the buckets aren't sorted.
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	d := newDesc(name, help, typeHistogram, labels)
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("%v: buckets are not sorted", name))
+	}
+	upperBounds := append([]float64(nil), buckets...)
+	return &HistogramVec{
+		vec: newVec(d, func() *Histogram {
+			return &Histogram{
+				upperBounds: upperBounds,
+				buckets:     make([]atomic.Uint64, len(upperBounds)),
+			}
+		}),
+		upperBounds: upperBounds,
+	}
+}
+
+// With returns the histogram of the label values.
+func (h *HistogramVec) With(labelValues ...string) *Histogram {
+	return h.vec.with(labelValues)
+}
+
+func (h *HistogramVec) desc() *desc {
+	return h.vec.d
+}
+
+func (h *HistogramVec) collect() []series {
+	var all []series
+	h.vec.each(func(labelValues []string, val *Histogram) {
+		var cumulative uint64
+		for i, upperBound := range val.upperBounds {
+			cumulative += val.buckets[i].Load()
+			all = append(all, series{
+				suffix:      "_bucket",
+				labelValues: labelValues,
+				extraLabel:  "le",
+				extraValue:  formatFloat(upperBound),
+				value:       float64(cumulative),
+			})
+		}
+		count := val.count.Load()
+		all = append(all,
+			series{
+				suffix:      "_bucket",
+				labelValues: labelValues,
+				extraLabel:  "le",
+				extraValue:  "+Inf",
+				value:       float64(count),
+			},
+			series{suffix: "_sum", labelValues: labelValues, value: val.sum.load()},
+			series{suffix: "_count", labelValues: labelValues, value: float64(count)},
+		)
+	})
+	return all
+}
+
+// funcCollector reads the values when the metrics are scraped.
+type funcCollector struct {
+	d         *desc
+	collectFn func() map[string]float64
+}
+
+// NewGaugeFunc creates a gauge with a single label that's read
+// on every scrape. The function returns the value of each label.
+func NewGaugeFunc(name string, help string, label string, fn func() map[string]float64) Collector {
+	return &funcCollector{
+		d:         newDesc(name, help, typeGauge, []string{label}),
+		collectFn: fn,
+	}
+}
+
+// NewCounterFunc creates a counter with a single label that's read
+// on every scrape. The function returns the value of each label.
+func NewCounterFunc(name string, help string, label string, fn func() map[string]float64) Collector {
+	return &funcCollector{
+		d:         newDesc(name, help, typeCounter, []string{label}),
+		collectFn: fn,
+	}
+}
+
+func (c *funcCollector) desc() *desc {
+	return c.d
+}
+
+func (c *funcCollector) collect() []series {
+	values := c.collectFn()
+	all := make([]series, 0, len(values))
+	for labelValue, value := range values {
+		all = append(all, series{labelValues: []string{labelValue}, value: value})
+	}
+	return all
+}
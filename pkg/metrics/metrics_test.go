@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+
+	counter := NewCounterVec("b_total", "Counter\nhelp \\.", LabelMonitorID)
+	counter.With("2").Add(2.5)
+	counter.With("1").Inc()
+	counter.With("1").Add(-1)
+
+	gauge := NewGaugeVec("a", "Gauge.", LabelSource)
+	gauge.With(`x"\`).Set(3)
+	gauge.With(`x"\`).Add(-1)
+
+	histogram := NewHistogramVec("c_seconds", "Histogram.", []float64{0.1, 1}, LabelMonitorID)
+	histogram.With("1").Observe(0.05)
+	histogram.With("1").Observe(0.5)
+	histogram.With("1").Observe(2)
+
+	gaugeFunc := NewGaugeFunc("d", "Gauge func.", LabelMonitorID, func() map[string]float64 {
+		return map[string]float64{"2": 1, "1": math.Inf(1)}
+	})
+
+	r.MustRegister(counter, gauge, histogram, gaugeFunc)
+
+	var b bytes.Buffer
+	require.NoError(t, r.Write(&b))
+
+	expected := `# HELP a Gauge.
+# TYPE a gauge
+a{source="x\"\\"} 2
+# HELP b_total Counter\nhelp \\.
+# TYPE b_total counter
+b_total{monitor_id="1"} 1
+b_total{monitor_id="2"} 2.5
+# HELP c_seconds Histogram.
+# TYPE c_seconds histogram
+c_seconds_bucket{monitor_id="1",le="0.1"} 1
+c_seconds_bucket{monitor_id="1",le="1"} 2
+c_seconds_bucket{monitor_id="1",le="+Inf"} 3
+c_seconds_sum{monitor_id="1"} 2.55
+c_seconds_count{monitor_id="1"} 3
+# HELP d Gauge func.
+# TYPE d gauge
+d{monitor_id="1"} +Inf
+d{monitor_id="2"} 1
+`
+	require.Equal(t, expected, b.String())
+}
+
+func TestRegistryDuplicate(t *testing.T) {
+	r := NewRegistry()
+	require.NoError(t, r.Register(NewCounterVec("a", "")))
+	require.ErrorIs(t, r.Register(NewGaugeVec("a", "")), ErrDuplicateMetric)
+}
+
+func TestNewDescPanics(t *testing.T) {
+	require.Panics(t, func() { NewCounterVec("1a", "") })
+	require.Panics(t, func() { NewCounterVec("a-b", "") })
+	require.Panics(t, func() { NewCounterVec("a", "", "remote_addr") })
+	require.Panics(t, func() { NewHistogramVec("a", "", []float64{2, 1}) })
+	require.Panics(t, func() { NewCounterVec("a", "", LabelMonitorID).With() })
+}
+
+func TestNilCounter(t *testing.T) {
+	var vec *CounterVec
+	c := vec.With("1")
+	c.Inc()
+	require.Equal(t, float64(0), c.Value())
+}
+
+func TestCounterConcurrent(t *testing.T) {
+	c := NewCounterVec("a", "", LabelMonitorID)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.With("1").Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, float64(1000), c.With("1").Value())
+}
@@ -2,7 +2,15 @@
 
 package monitor
 
-import "strings"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nvr/pkg/ffmpeg"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // RawConfigs map of RawConfig.
 type RawConfigs map[string]RawConfig
@@ -28,8 +36,91 @@ func (c Config) Get(key string) string {
 	return c.v[key]
 }
 
+// Config value errors. The typed accessors return ErrConfigKeyAbsent
+// if the key is missing or empty and ErrConfigKeyInvalid if the
+// value cannot be parsed, callers decide the default themselves.
+var (
+	ErrConfigKeyAbsent  = errors.New("key is absent")
+	ErrConfigKeyInvalid = errors.New("invalid value")
+)
+
+// Lookup returns the value of the key and if it's present.
+// Empty values are treated as absent.
+func (c Config) Lookup(key string) (string, bool) {
+	value := c.v[key]
+	return value, value != ""
+}
+
+func errKeyAbsent(key string) error {
+	return fmt.Errorf("%w: %s", ErrConfigKeyAbsent, key)
+}
+
+func errKeyInvalid(key string, value string) error {
+	return fmt.Errorf("%w: %s: %q", ErrConfigKeyInvalid, key, value)
+}
+
+// Bool parses a "true" or "false" value.
+func (c Config) Bool(key string) (bool, error) {
+	value, exist := c.Lookup(key)
+	if !exist {
+		return false, errKeyAbsent(key)
+	}
+	switch value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, errKeyInvalid(key, value)
+}
+
+// Float parses a floating point value.
+func (c Config) Float(key string) (float64, error) {
+	value, exist := c.Lookup(key)
+	if !exist {
+		return 0, errKeyAbsent(key)
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, errKeyInvalid(key, value)
+	}
+	return v, nil
+}
+
+// Duration parses a non-negative number of units.
+func (c Config) Duration(key string, unit time.Duration) (time.Duration, error) {
+	v, err := c.Float(key)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("%w: %w", errNegativeDuration, errKeyInvalid(key, c.v[key]))
+	}
+	return time.Duration(v * float64(unit)), nil
+}
+
+// GetJSON unmarshals the value of the key into v. Used
+// by the addons that store their config as a JSON object.
+func (c Config) GetJSON(key string, v interface{}) error {
+	value, exist := c.Lookup(key)
+	if !exist {
+		return errKeyAbsent(key)
+	}
+	if err := json.Unmarshal([]byte(value), v); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrConfigKeyInvalid, key, err)
+	}
+	return nil
+}
+
+// Enabled returns if the monitor is enabled.
+func (c Config) Enabled() (bool, error) {
+	return c.Bool("enable")
+}
+
+// enabled returns false if the value is absent or invalid.
 func (c Config) enabled() bool {
-	return c.v["enable"] == "true"
+	enable, _ := c.Enabled()
+	return enable
 }
 
 // ID returns the monitor ID.
@@ -67,52 +158,271 @@ func (c Config) VideoEncoder() string {
 	return c.v["videoEncoder"]
 }
 
-// MainInput returns the main input url.
-func (c Config) MainInput() string {
-	return c.v["mainInput"]
+// MainStream returns the url of the main stream, it's recorded.
+func (c Config) MainStream() string {
+	return c.v["mainStream"]
+}
+
+// SubStream returns the url of the optional low resolution sub stream.
+func (c Config) SubStream() string {
+	return c.v["subStream"]
+}
+
+// SubStreamEnabled if the sub stream is available.
+// Push monitors don't have a sub stream.
+func (c Config) SubStreamEnabled() bool {
+	return c.SubStream() != "" && !c.PushInput()
+}
+
+// PushInput if the frames are pushed to the ingest
+// endpoint instead of read from the main input.
+func (c Config) PushInput() bool {
+	return c.v["inputType"] == "push"
 }
 
-// SubInput returns the sub input url.
-func (c Config) SubInput() string {
-	return c.v["subInput"]
+// VideoLength returns the max video length, the value is in
+// minutes. Longer recordings are split into multiple files.
+func (c Config) VideoLength() (time.Duration, error) {
+	videoLength, err := c.Duration("videoLength", time.Minute)
+	if err != nil {
+		return 0, err
+	}
+	if videoLength == 0 {
+		return 0, errKeyInvalid("videoLength", c.v["videoLength"])
+	}
+	return videoLength, nil
 }
 
-// SubInputEnabled if sub input is available.
-func (c Config) SubInputEnabled() bool {
-	return c.SubInput() != ""
+// eventTimerConfig returns the settings that control how events start
+// and extend recordings. The durations are in seconds, except the
+// max total duration that is in minutes like the video length.
+func (c Config) eventTimerConfig() (eventTimerConfig, error) {
+	var errs []error
+	postEventDuration, err := c.optionalDuration("postEventDuration", time.Second)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("%w: %w", ErrConfigPostEventDuration, err))
+	}
+	maxEventGap, err := c.optionalDuration("maxEventGap", time.Second)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("%w: %w", ErrConfigMaxEventGap, err))
+	}
+	maxTotalDuration, err := c.optionalDuration("maxTotalDuration", time.Minute)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("%w: %w", ErrConfigMaxTotalDuration, err))
+	}
+	if len(errs) != 0 {
+		return eventTimerConfig{}, errors.Join(errs...)
+	}
+	return eventTimerConfig{
+		postEventDuration: postEventDuration,
+		maxEventGap:       maxEventGap,
+		maxTotalDuration:  maxTotalDuration,
+	}, nil
 }
 
-// video length is seconds.
-func (c Config) videoLength() string {
-	return c.v["videoLength"]
+// errNegativeDuration negative duration.
+var errNegativeDuration = errors.New("negative duration")
+
+// optionalDuration parses a number of units, absent is zero.
+func (c Config) optionalDuration(key string, unit time.Duration) (time.Duration, error) {
+	d, err := c.Duration(key, unit)
+	if errors.Is(err, ErrConfigKeyAbsent) {
+		return 0, nil
+	}
+	return d, err
 }
 
 func (c Config) alwaysRecord() bool {
 	return c.v["alwaysRecord"] == "true"
 }
 
+// HLSIFrames if the live stream should include a I-frame only playlist.
+func (c Config) HLSIFrames() bool {
+	return c.v["hlsIFrames"] == "true"
+}
+
+// HLSEncryption if the HLS segments should be encrypted with AES-128.
+func (c Config) HLSEncryption() bool {
+	return c.v["hlsEncryption"] == "true"
+}
+
+// Default interval between HLS key rotations.
+const defaultHLSKeyRotation = time.Hour
+
+// HLSKeyRotation returns the interval between HLS key
+// rotations in minutes, zero never rotates the keys.
+func (c Config) HLSKeyRotation() (time.Duration, error) {
+	rotation, err := c.Duration("hlsKeyRotation", time.Minute)
+	if errors.Is(err, ErrConfigKeyAbsent) {
+		return defaultHLSKeyRotation, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrConfigHLSKeyRotation, err)
+	}
+	return rotation, nil
+}
+
+// LiveAudio if the audio should be included in the live stream.
+// The audio is recorded either way.
+func (c Config) LiveAudio() bool {
+	return c.v["liveAudio"] != "false"
+}
+
+// AudioLevel if the approximate audio level should be published for the
+// live view meter. The level is read from the main stream.
+func (c Config) AudioLevel() bool {
+	return c.v["audioLevel"] == "true"
+}
+
 // TimestampOffset returns the timestamp offset.
 func (c Config) TimestampOffset() string {
 	return c.v["timestampOffset"]
 }
 
-// LogLevel returns the ffmpeg log level.
+// TimeZone returns the IANA time zone of the monitor, empty if unset.
+func (c Config) TimeZone() string {
+	return c.v["timeZone"]
+}
+
+// LogLevel returns the raw ffmpeg log level, it's
+// validated by ValidateConfig, see ParseLogLevel.
 func (c Config) LogLevel() string {
 	return c.v["logLevel"]
 }
 
+// ParseLogLevel returns the ffmpeg log level. FFmpeg
+// accepts either one of the named levels or a number.
+func (c Config) ParseLogLevel() (string, error) {
+	logLevel, exist := c.Lookup("logLevel")
+	if !exist {
+		return "", errKeyAbsent("logLevel")
+	}
+	switch logLevel {
+	case "quiet", "panic", "fatal", "error", "warning", "info", "verbose", "debug", "trace":
+		return logLevel, nil
+	}
+	if _, err := strconv.Atoi(logLevel); err != nil {
+		return "", errKeyInvalid("logLevel", logLevel)
+	}
+	return logLevel, nil
+}
+
 // Hwaccel returns the ffmpeg hwaccel.
 func (c Config) Hwaccel() string {
 	return c.v["hwaccel"]
 }
 
+// ErrInvalidPrivacyMask invalid privacy mask.
+var ErrInvalidPrivacyMask = errors.New("invalid privacy mask")
+
+type privacyMask struct {
+	Enable bool             `json:"enable"`
+	Areas  []ffmpeg.Polygon `json:"areas"`
+}
+
+// PrivacyMask returns the areas that are blacked out before the stream
+// reaches the RTSP server. Points are in percent. Nil if disabled.
+func (c Config) PrivacyMask() ([]ffmpeg.Polygon, error) {
+	var mask privacyMask
+	err := c.GetJSON("privacyMask", &mask)
+	if errors.Is(err, ErrConfigKeyAbsent) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPrivacyMask, err)
+	}
+	if !mask.Enable || len(mask.Areas) == 0 {
+		return nil, nil
+	}
+
+	for _, area := range mask.Areas {
+		if len(area) < 3 {
+			return nil, fmt.Errorf("%w: area has less than 3 points", ErrInvalidPrivacyMask)
+		}
+		for _, point := range area {
+			if point[0] < 0 || point[0] > 100 || point[1] < 0 || point[1] > 100 {
+				return nil, fmt.Errorf("%w: point out of range: %v", ErrInvalidPrivacyMask, point)
+			}
+		}
+	}
+	return mask.Areas, nil
+}
+
 // CensorLog replaces sensitive monitor config values.
 func (c Config) CensorLog(msg string) string {
-	if c.MainInput() != "" {
-		msg = strings.ReplaceAll(msg, c.MainInput(), "$MainInput")
+	if c.MainStream() != "" {
+		msg = strings.ReplaceAll(msg, c.MainStream(), "$MainStream")
 	}
-	if c.SubInput() != "" {
-		msg = strings.ReplaceAll(msg, c.SubInput(), "$SubInput")
+	if c.SubStream() != "" {
+		msg = strings.ReplaceAll(msg, c.SubStream(), "$SubStream")
 	}
 	return msg
 }
+
+// ValidateHook validates a monitor config, it should
+// only check the keys that are used by the addon.
+type ValidateHook func(RawConfig) error
+
+// Config validation errors.
+var (
+	ErrConfigIDMissing         = errors.New("id is missing")
+	ErrConfigNameMissing       = errors.New("name is missing")
+	ErrConfigEnable            = errors.New("invalid enable")
+	ErrConfigMainStreamMissing = errors.New("main stream is missing")
+	ErrConfigVideoLength       = errors.New("invalid video length")
+	ErrConfigTimestampOffset   = errors.New("invalid timestamp offset")
+	ErrConfigTimeZone          = errors.New("invalid time zone")
+	ErrConfigPostEventDuration = errors.New("invalid post event duration")
+	ErrConfigMaxEventGap       = errors.New("invalid max event gap")
+	ErrConfigMaxTotalDuration  = errors.New("invalid max total duration")
+	ErrConfigHLSKeyRotation    = errors.New("invalid HLS key rotation")
+	ErrConfigLogLevel          = errors.New("invalid log level")
+)
+
+// ValidateConfig validates the core config keys. The recorder settings
+// are only required if the monitor is enabled. Returns every problem.
+func ValidateConfig(rawConf RawConfig) error {
+	c := NewConfig(rawConf)
+
+	var errs []error
+	if c.ID() == "" {
+		errs = append(errs, ErrConfigIDMissing)
+	}
+	if c.Name() == "" {
+		errs = append(errs, ErrConfigNameMissing)
+	}
+	if _, err := c.PrivacyMask(); err != nil {
+		errs = append(errs, err)
+	}
+	if tz := c.TimeZone(); tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %q", ErrConfigTimeZone, tz))
+		}
+	}
+	if _, err := c.ParseLogLevel(); err != nil && !errors.Is(err, ErrConfigKeyAbsent) {
+		errs = append(errs, fmt.Errorf("%w: %w", ErrConfigLogLevel, err))
+	}
+
+	enable, err := c.Enabled()
+	if err != nil && !errors.Is(err, ErrConfigKeyAbsent) {
+		errs = append(errs, fmt.Errorf("%w: %w", ErrConfigEnable, err))
+	}
+	if enable {
+		if c.MainStream() == "" {
+			errs = append(errs, ErrConfigMainStreamMissing)
+		}
+		if _, err := c.VideoLength(); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %w", ErrConfigVideoLength, err))
+		}
+		if _, err := strconv.Atoi(c.TimestampOffset()); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %q", ErrConfigTimestampOffset, c.TimestampOffset()))
+		}
+		if _, err := c.eventTimerConfig(); err != nil {
+			errs = append(errs, err)
+		}
+		if _, err := c.HLSKeyRotation(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -1,7 +1,10 @@
 package monitor
 
 import (
+	"nvr/pkg/ffmpeg"
 	"testing"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/stretchr/testify/require"
 )
@@ -17,15 +20,15 @@ func TestCensorLog(t *testing.T) {
 			"a b c",
 			"a b c",
 		},
-		"mainInput": {
-			RawConfig{"mainInput": "a"},
+		"mainStream": {
+			RawConfig{"mainStream": "a"},
 			"a b c",
-			"$MainInput b c",
+			"$MainStream b c",
 		},
-		"subInput": {
-			RawConfig{"subInput": "b"},
+		"subStream": {
+			RawConfig{"subStream": "b"},
 			"a b c",
-			"a $SubInput c",
+			"a $SubStream c",
 		},
 	}
 	for name, tc := range cases {
@@ -35,3 +38,259 @@ func TestCensorLog(t *testing.T) {
 		})
 	}
 }
+
+func TestPrivacyMask(t *testing.T) {
+	cases := map[string]struct {
+		input    string
+		expected []ffmpeg.Polygon
+		err      error
+	}{
+		"empty":    {"", nil, nil},
+		"disabled": {`{"enable":false,"areas":[[[0,0],[1,0],[1,1]]]}`, nil, nil},
+		"noAreas":  {`{"enable":true,"areas":[]}`, nil, nil},
+		"ok": {
+			`{"enable":true,"areas":[[[0,0],[50,0],[50,50]],[[60,60],[70,60],[70,70],[60,70]]]}`,
+			[]ffmpeg.Polygon{
+				{{0, 0}, {50, 0}, {50, 50}},
+				{{60, 60}, {70, 60}, {70, 70}, {60, 70}},
+			},
+			nil,
+		},
+		"invalidJSON":  {"nil", nil, ErrInvalidPrivacyMask},
+		"tooFewPoints": {`{"enable":true,"areas":[[[0,0],[1,1]]]}`, nil, ErrInvalidPrivacyMask},
+		"outOfRange":   {`{"enable":true,"areas":[[[0,0],[101,0],[1,1]]]}`, nil, ErrInvalidPrivacyMask},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := NewConfig(RawConfig{"privacyMask": tc.input})
+			actual, err := c.PrivacyMask()
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	valid := func() RawConfig {
+		return RawConfig{
+			"id":              "1",
+			"name":            "a",
+			"enable":          "true",
+			"mainStream":      "rtsp://x",
+			"videoLength":     "15",
+			"timestampOffset": "500",
+		}
+	}
+	cases := map[string]struct {
+		modify func(RawConfig)
+		errs   []error
+	}{
+		"ok": {func(RawConfig) {}, nil},
+		"disabled": {
+			func(c RawConfig) {
+				c["enable"] = "false"
+				c["mainStream"] = ""
+				c["videoLength"] = ""
+			},
+			nil,
+		},
+		"invalidEnable": {
+			func(c RawConfig) { c["enable"] = "yes" },
+			[]error{ErrConfigEnable, ErrConfigKeyInvalid},
+		},
+		"logLevel": {func(c RawConfig) { c["logLevel"] = "error" }, nil},
+		"invalidLogLevel": {
+			func(c RawConfig) { c["logLevel"] = "loud" },
+			[]error{ErrConfigLogLevel, ErrConfigKeyInvalid},
+		},
+		"idMissing":   {func(c RawConfig) { c["id"] = "" }, []error{ErrConfigIDMissing}},
+		"nameMissing": {func(c RawConfig) { c["name"] = "" }, []error{ErrConfigNameMissing}},
+		"privacyMask": {
+			func(c RawConfig) { c["privacyMask"] = "nil" },
+			[]error{ErrInvalidPrivacyMask},
+		},
+		"timeZone": {func(c RawConfig) { c["timeZone"] = "Europe/Stockholm" }, nil},
+		"invalidTimeZone": {
+			func(c RawConfig) { c["timeZone"] = "Europe/Nowhere" },
+			[]error{ErrConfigTimeZone},
+		},
+		"eventTimer": {
+			func(c RawConfig) {
+				c["postEventDuration"] = "10"
+				c["maxEventGap"] = "2.5"
+				c["maxTotalDuration"] = ""
+			},
+			nil,
+		},
+		"invalidEventTimer": {
+			func(c RawConfig) {
+				c["postEventDuration"] = "x"
+				c["maxEventGap"] = "-1"
+				c["maxTotalDuration"] = "1m"
+			},
+			[]error{
+				ErrConfigPostEventDuration,
+				ErrConfigMaxEventGap,
+				ErrConfigMaxTotalDuration,
+			},
+		},
+		"invalidHLSKeyRotation": {
+			func(c RawConfig) { c["hlsKeyRotation"] = "-1" },
+			[]error{ErrConfigHLSKeyRotation},
+		},
+		"multiple": {
+			func(c RawConfig) {
+				c["mainStream"] = ""
+				c["videoLength"] = "0"
+				c["timestampOffset"] = "x"
+			},
+			[]error{ErrConfigMainStreamMissing, ErrConfigVideoLength, ErrConfigTimestampOffset},
+		},
+		"videoLengthMissing": {
+			func(c RawConfig) { c["videoLength"] = "" },
+			[]error{ErrConfigVideoLength, ErrConfigKeyAbsent},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			rawConf := valid()
+			tc.modify(rawConf)
+			err := ValidateConfig(rawConf)
+			if tc.errs == nil {
+				require.NoError(t, err)
+				return
+			}
+			for _, e := range tc.errs {
+				require.ErrorIs(t, err, e)
+			}
+		})
+	}
+}
+
+func TestEventTimerConfig(t *testing.T) {
+	c := NewConfig(RawConfig{
+		"postEventDuration": "10",
+		"maxEventGap":       "0.5",
+		"maxTotalDuration":  "2",
+	})
+	config, err := c.eventTimerConfig()
+	require.NoError(t, err)
+
+	expected := eventTimerConfig{
+		postEventDuration: 10 * time.Second,
+		maxEventGap:       500 * time.Millisecond,
+		maxTotalDuration:  2 * time.Minute,
+	}
+	require.Equal(t, expected, config)
+
+	config, err = NewConfig(RawConfig{}).eventTimerConfig()
+	require.NoError(t, err)
+	require.Equal(t, eventTimerConfig{}, config)
+}
+
+func TestHLSKeyRotation(t *testing.T) {
+	rotation, err := NewConfig(RawConfig{}).HLSKeyRotation()
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, rotation)
+
+	rotation, err = NewConfig(RawConfig{"hlsKeyRotation": "0"}).HLSKeyRotation()
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(0), rotation)
+
+	rotation, err = NewConfig(RawConfig{"hlsKeyRotation": "1.5"}).HLSKeyRotation()
+	require.NoError(t, err)
+	require.Equal(t, 90*time.Second, rotation)
+}
+
+func TestConfigAccessors(t *testing.T) {
+	// Each accessor is called with the key absent, empty, invalid and valid.
+	type accessor func(Config) (interface{}, error)
+	cases := map[string]struct {
+		key      string
+		get      accessor
+		invalid  []string
+		valid    string
+		expected interface{}
+	}{
+		"bool": {
+			key:      "x",
+			get:      func(c Config) (interface{}, error) { return c.Bool("x") },
+			invalid:  []string{"yes", "1", "True"},
+			valid:    "true",
+			expected: true,
+		},
+		"float": {
+			key:      "x",
+			get:      func(c Config) (interface{}, error) { return c.Float("x") },
+			invalid:  []string{"x", "1,5"},
+			valid:    "1.5",
+			expected: 1.5,
+		},
+		"duration": {
+			key: "x",
+			get: func(c Config) (interface{}, error) {
+				return c.Duration("x", time.Second)
+			},
+			invalid:  []string{"1s", "-1"},
+			valid:    "2.5",
+			expected: 2500 * time.Millisecond,
+		},
+		"enabled": {
+			key:      "enable",
+			get:      func(c Config) (interface{}, error) { return c.Enabled() },
+			invalid:  []string{"yes"},
+			valid:    "false",
+			expected: false,
+		},
+		"videoLength": {
+			key:      "videoLength",
+			get:      func(c Config) (interface{}, error) { return c.VideoLength() },
+			invalid:  []string{"x", "0", "-1"},
+			valid:    "15",
+			expected: 15 * time.Minute,
+		},
+		"logLevel": {
+			key:      "logLevel",
+			get:      func(c Config) (interface{}, error) { return c.ParseLogLevel() },
+			invalid:  []string{"loud", "1.5"},
+			valid:    "warning",
+			expected: "warning",
+		},
+		"getJSON": {
+			key: "x",
+			get: func(c Config) (interface{}, error) {
+				var v struct{ A string }
+				err := c.GetJSON("x", &v)
+				return v.A, err
+			},
+			invalid:  []string{"nil", `{"A":1}`},
+			valid:    `{"A":"b"}`,
+			expected: "b",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := tc.get(NewConfig(RawConfig{}))
+			require.ErrorIs(t, err, ErrConfigKeyAbsent)
+
+			_, err = tc.get(NewConfig(RawConfig{tc.key: ""}))
+			require.ErrorIs(t, err, ErrConfigKeyAbsent)
+
+			for _, value := range tc.invalid {
+				_, err = tc.get(NewConfig(RawConfig{tc.key: value}))
+				require.ErrorIs(t, err, ErrConfigKeyInvalid, value)
+				require.NotErrorIs(t, err, ErrConfigKeyAbsent, value)
+			}
+
+			actual, err := tc.get(NewConfig(RawConfig{tc.key: tc.valid}))
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestConfigLogLevelNumeric(t *testing.T) {
+	logLevel, err := NewConfig(RawConfig{"logLevel": "16"}).ParseLogLevel()
+	require.NoError(t, err)
+	require.Equal(t, "16", logLevel)
+}
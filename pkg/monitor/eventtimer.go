@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"nvr/pkg/storage"
+	"time"
+)
+
+// eventTimerConfig controls how events start and extend recordings.
+// The zero value of each setting disables it.
+type eventTimerConfig struct {
+	// How long the recording continues after the last event,
+	// zero uses the duration requested by the trigger.
+	postEventDuration time.Duration
+
+	// Events only extend the recording if they arrive within
+	// this duration of the previous event, later events end
+	// the recording and start a new one.
+	maxEventGap time.Duration
+
+	// Recordings end this long after the first event even if
+	// events keep arriving, the next event starts a new recording.
+	// Recordings that are split by the video length count as one.
+	maxTotalDuration time.Duration
+}
+
+// eventAction is what the recorder should do with an event.
+type eventAction int
+
+const (
+	// Start a recording, the recorder isn't recording.
+	eventActionStart eventAction = iota
+
+	// The current recording continues until the end of the timer.
+	eventActionExtend
+
+	// End the current recording and start a new one.
+	eventActionRestart
+)
+
+func (a eventAction) String() string {
+	switch a {
+	case eventActionStart:
+		return "start"
+	case eventActionExtend:
+		return "extend"
+	case eventActionRestart:
+		return "restart"
+	}
+	return "unknown"
+}
+
+// eventTimer tracks when the current recording should end. Events
+// that reach the recorder have already passed the thresholds and
+// zones of their trigger, every event is a qualifying event.
+type eventTimer struct {
+	config eventTimerConfig
+
+	active     bool
+	ending     bool      // The end was reached, the session is stopping.
+	continuous bool      // Started by always record, never ends.
+	start      time.Time // Time of the first event.
+	lastEvent  time.Time
+	end        time.Time
+}
+
+// event updates the timer and returns the action for the event.
+func (t *eventTimer) event(e storage.Event) eventAction {
+	if !t.active {
+		t.reset(e)
+		return eventActionStart
+	}
+
+	if e.Source == storage.EventSourceContinuous {
+		t.continuous = true
+	}
+	if !t.continuous {
+		gap := e.Time.Sub(t.lastEvent)
+		exceedsGap := t.config.maxEventGap != 0 && gap > t.config.maxEventGap
+		exceedsTotal := t.config.maxTotalDuration != 0 &&
+			!e.Time.Before(t.start.Add(t.config.maxTotalDuration))
+		if t.ending || exceedsGap || exceedsTotal {
+			t.reset(e)
+			return eventActionRestart
+		}
+	}
+
+	if e.Time.After(t.lastEvent) {
+		t.lastEvent = e.Time
+	}
+	if end := t.eventEnd(e); end.After(t.end) {
+		t.end = end
+	}
+	return eventActionExtend
+}
+
+func (t *eventTimer) reset(e storage.Event) {
+	t.active = true
+	t.ending = false
+	t.continuous = e.Source == storage.EventSourceContinuous
+	t.start = e.Time
+	t.lastEvent = e.Time
+	t.end = t.eventEnd(e)
+}
+
+// eventEnd returns when the recording should end because of the event.
+func (t *eventTimer) eventEnd(e storage.Event) time.Time {
+	if t.continuous {
+		return e.Time.Add(e.RecDuration)
+	}
+	duration := e.RecDuration
+	if t.config.postEventDuration != 0 {
+		duration = t.config.postEventDuration
+	}
+	end := e.Time.Add(duration)
+	if t.config.maxTotalDuration != 0 {
+		if maxEnd := t.start.Add(t.config.maxTotalDuration); end.After(maxEnd) {
+			end = maxEnd
+		}
+	}
+	return end
+}
+
+// timerEnded is called when the end is reached and the session is stopping.
+func (t *eventTimer) timerEnded() {
+	t.ending = true
+}
+
+// sessionStopped is called when the session has exited.
+func (t *eventTimer) sessionStopped() {
+	t.active = false
+	t.ending = false
+}
+
+// RecorderStatus is the event timer state of a
+// recorder, times are omitted if not recording.
+type RecorderStatus struct {
+	Recording   bool       `json:"recording"`
+	RecordingID string     `json:"recordingId,omitempty"`
+	Continuous  bool       `json:"continuous"`
+	Start       *time.Time `json:"start,omitempty"`
+	LastEvent   *time.Time `json:"lastEvent,omitempty"`
+	End         *time.Time `json:"end,omitempty"`
+
+	// Effective settings in seconds, zero if disabled.
+	PostEventDuration float64 `json:"postEventDuration"`
+	MaxEventGap       float64 `json:"maxEventGap"`
+	MaxTotalDuration  float64 `json:"maxTotalDuration"`
+}
+
+func (t *eventTimer) status() RecorderStatus {
+	s := RecorderStatus{
+		Recording:         t.active,
+		Continuous:        t.active && t.continuous,
+		PostEventDuration: t.config.postEventDuration.Seconds(),
+		MaxEventGap:       t.config.maxEventGap.Seconds(),
+		MaxTotalDuration:  t.config.maxTotalDuration.Seconds(),
+	}
+	if t.active {
+		start, lastEvent := t.start, t.lastEvent
+		s.Start, s.LastEvent = &start, &lastEvent
+		if !t.continuous {
+			end := t.end
+			s.End = &end
+		}
+	}
+	return s
+}
@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventTimer(t *testing.T) {
+	start := time.Unix(1000, 0)
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+	event := func(seconds int, recDuration time.Duration) storage.Event {
+		return storage.Event{Time: at(seconds), RecDuration: recDuration}
+	}
+
+	type step struct {
+		event          storage.Event
+		expectedAction eventAction
+		expectedEnd    time.Time
+	}
+	cases := map[string]struct {
+		config eventTimerConfig
+		steps  []step
+	}{
+		"triggerDuration": {
+			eventTimerConfig{},
+			[]step{
+				{event(0, 30*time.Second), eventActionStart, at(30)},
+				{event(20, 30*time.Second), eventActionExtend, at(50)},
+				// Shorter events don't move the end back.
+				{event(25, time.Second), eventActionExtend, at(50)},
+				// Without a gap limit a trickle keeps it open forever.
+				{event(45, 30*time.Second), eventActionExtend, at(75)},
+				{event(70, 30*time.Second), eventActionExtend, at(100)},
+			},
+		},
+		"postEventDuration": {
+			eventTimerConfig{postEventDuration: 10 * time.Second},
+			[]step{
+				{event(0, time.Hour), eventActionStart, at(10)},
+				{event(5, time.Minute), eventActionExtend, at(15)},
+			},
+		},
+		"maxEventGap": {
+			eventTimerConfig{
+				postEventDuration: 60 * time.Second,
+				maxEventGap:       30 * time.Second,
+			},
+			[]step{
+				{event(0, 0), eventActionStart, at(60)},
+				{event(30, 0), eventActionExtend, at(90)},
+				// The gap is measured from the previous event.
+				{event(55, 0), eventActionExtend, at(115)},
+				{event(86, 0), eventActionRestart, at(146)},
+				{event(100, 0), eventActionExtend, at(160)},
+			},
+		},
+		"maxTotalDuration": {
+			eventTimerConfig{
+				postEventDuration: 20 * time.Second,
+				maxTotalDuration:  time.Minute,
+			},
+			[]step{
+				{event(0, 0), eventActionStart, at(20)},
+				{event(15, 0), eventActionExtend, at(35)},
+				{event(45, 0), eventActionExtend, at(60)},
+				{event(59, 0), eventActionExtend, at(60)},
+				{event(60, 0), eventActionRestart, at(80)},
+				{event(70, 0), eventActionExtend, at(90)},
+			},
+		},
+		"continuous": {
+			eventTimerConfig{
+				postEventDuration: 20 * time.Second,
+				maxEventGap:       10 * time.Second,
+				maxTotalDuration:  time.Minute,
+			},
+			[]step{
+				{
+					storage.Event{
+						Time:        at(0),
+						RecDuration: time.Hour,
+						Source:      storage.EventSourceContinuous,
+					},
+					eventActionStart,
+					at(3600),
+				},
+				{event(1000, 0), eventActionExtend, at(3600)},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			timer := eventTimer{config: tc.config}
+			for i, s := range tc.steps {
+				action := timer.event(s.event)
+				require.Equal(t, s.expectedAction, action, "step %d", i)
+				require.Equal(t, s.expectedEnd, timer.end, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestEventTimerEnding(t *testing.T) {
+	start := time.Unix(1000, 0)
+	timer := eventTimer{}
+	require.Equal(t, eventActionStart, timer.event(storage.Event{Time: start, RecDuration: time.Second}))
+
+	// An event between the end and the session exit starts a new recording.
+	timer.timerEnded()
+	require.Equal(t, eventActionRestart, timer.event(storage.Event{Time: start, RecDuration: time.Second}))
+	require.Equal(t, eventActionExtend, timer.event(storage.Event{Time: start, RecDuration: time.Second}))
+
+	timer.sessionStopped()
+	require.Equal(t, eventActionStart, timer.event(storage.Event{Time: start, RecDuration: time.Second}))
+}
+
+func TestEventTimerStatus(t *testing.T) {
+	timer := eventTimer{config: eventTimerConfig{
+		postEventDuration: 10 * time.Second,
+		maxEventGap:       time.Minute,
+	}}
+	require.Equal(t, RecorderStatus{PostEventDuration: 10, MaxEventGap: 60}, timer.status())
+
+	start := time.Unix(1000, 0)
+	timer.event(storage.Event{Time: start})
+	timer.event(storage.Event{Time: start.Add(5 * time.Second)})
+
+	lastEvent, end := start.Add(5*time.Second), start.Add(15*time.Second)
+	expected := RecorderStatus{
+		Recording:         true,
+		Start:             &start,
+		LastEvent:         &lastEvent,
+		End:               &end,
+		PostEventDuration: 10,
+		MaxEventGap:       60,
+	}
+	require.Equal(t, expected, timer.status())
+}
@@ -0,0 +1,211 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/log"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Push monitors don't connect to a camera, JPEG frames are posted to
+// the ingest endpoint and transcoded to H264 by the main input process.
+
+// Errors.
+var (
+	ErrNotPushInput = errors.New("monitor input type isn't push")
+)
+
+const (
+	// The monitor is offline after no frames were received for this long.
+	ingestIdleTimeout = 10 * time.Second
+
+	// Frames are dropped if the transcoder falls behind.
+	ingestQueueSize = 30
+
+	// Bandwidth limit of the pushed frames of each monitor,
+	// up to one second of frames is allowed as a burst.
+	ingestBytesPerSecond = 8 * 1000 * 1000
+	ingestBurst          = time.Second
+
+	// MaxIngestFrameSize is the maximum size of a pushed frame.
+	MaxIngestFrameSize = 8 * 1000 * 1000
+)
+
+// ingest receives the pushed frames of a monitor.
+type ingest struct {
+	frames      chan []byte
+	idleTimeout time.Duration
+	online      atomic.Bool
+	logf        logFunc
+
+	// Only used by the input process.
+	pending []byte
+}
+
+func newIngest(logf logFunc) *ingest {
+	return &ingest{
+		frames:      make(chan []byte, ingestQueueSize),
+		idleTimeout: ingestIdleTimeout,
+		logf:        logf,
+	}
+}
+
+// push queues the frame, the frame is dropped if the queue is full.
+func (i *ingest) push(frame []byte) {
+	select {
+	case i.frames <- frame:
+	default:
+	}
+}
+
+// waitForFrame blocks until the first frame is received
+// and the monitor is online. The frame is returned by
+// the next call to nextFrame.
+func (i *ingest) waitForFrame(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case frame := <-i.frames:
+		i.pending = frame
+	}
+	i.online.Store(true)
+	i.logf(log.LevelInfo, "push source connected")
+	return nil
+}
+
+// nextFrame returns the next frame. Returns io.EOF
+// and marks the monitor offline on idle timeout.
+func (i *ingest) nextFrame(ctx context.Context) ([]byte, error) {
+	if frame := i.pending; frame != nil {
+		i.pending = nil
+		return frame, nil
+	}
+
+	timer := time.NewTimer(i.idleTimeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case frame := <-i.frames:
+		return frame, nil
+	case <-timer.C:
+		i.online.Store(false)
+		i.logf(log.LevelWarning, "push source idle for %v, offline", i.idleTimeout)
+		return nil, io.EOF
+	}
+}
+
+// runPushProcess feeds the pushed frames into the stdin of the
+// process until the source is idle or the context is canceled.
+func (i *InputProcess) runPushProcess(
+	ctx context.Context,
+	cmd *exec.Cmd,
+	newProcess ffmpeg.NewProcessFunc,
+) error {
+	defer i.ingest.online.Store(false)
+
+	pipe, err := ffmpeg.NewResilientPipe(ffmpeg.ResilientPipeConfig{
+		NewCmd:     func() *exec.Cmd { return cmd },
+		NewProcess: newProcess,
+		NextFrame:  i.ingest.nextFrame,
+	})
+	if err != nil {
+		return err
+	}
+	err = pipe.Run(ctx)
+	if err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// ingestLimiter per monitor bandwidth limiter. Uses the
+// generic cell rate algorithm like the trigger limiter.
+type ingestLimiter struct {
+	bytesPerSecond int
+
+	mu  sync.Mutex
+	tat map[string]time.Time // map[monitorID]arrivalTime.
+}
+
+func newIngestLimiter(bytesPerSecond int) *ingestLimiter {
+	return &ingestLimiter{
+		bytesPerSecond: bytesPerSecond,
+		tat:            make(map[string]time.Time),
+	}
+}
+
+// reserve returns the duration to wait before n bytes are within the limit.
+func (l *ingestLimiter) reserve(monitorID string, n int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tat := l.tat[monitorID]
+	if tat.Before(now) {
+		tat = now
+	}
+	tat = tat.Add(time.Duration(n) * time.Second / time.Duration(l.bytesPerSecond))
+	l.tat[monitorID] = tat
+	return tat.Sub(now) - ingestBurst
+}
+
+// PushFrame queues a JPEG frame for a push monitor. Blocks while the
+// monitor is over the bandwidth limit, this throttles the source.
+func (m *Manager) PushFrame(ctx context.Context, monitorID string, frame []byte) error {
+	m.mu.Lock()
+	_, exist := m.rawConfigs[monitorID]
+	monitor, running := m.runningMonitors[monitorID]
+	m.mu.Unlock()
+
+	if !exist {
+		return ErrMonitorNotExist
+	}
+	if !running || !monitor.Config.enabled() {
+		return ErrMonitorDisabled
+	}
+	if monitor.ingest == nil {
+		return ErrNotPushInput
+	}
+
+	if wait := m.ingestLimiter.reserve(monitorID, len(frame), time.Now()); wait > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	monitor.ingest.push(frame)
+	return nil
+}
+
+// generatePushArgs returns the arguments of the transcoder that reads
+// the pushed JPEG frames from stdin. The frames are timestamped when
+// they're received, the source may have a variable frame rate.
+func (i *InputProcess) generatePushArgs() string {
+	c := i.Config
+	args := "-threads 1 -loglevel " + c.LogLevel() +
+		" -use_wallclock_as_timestamps 1 -f image2pipe -c:v mjpeg -i pipe:0"
+
+	if i.privacyMaskPath != "" {
+		args += " -i " + i.privacyMaskPath +
+			" -filter_complex [1:v][0:v]scale2ref=flags=neighbor[mask][video];" +
+			"[video][mask]overlay[out] -map [out]"
+	}
+
+	// The frames can't be copied.
+	encoder := c.VideoEncoder()
+	if encoder == "" || encoder == "copy" {
+		encoder = "libx264 -preset veryfast"
+	}
+	args += " -an -c:v " + encoder + " -pix_fmt yuv420p"
+	args += " -f rtsp -rtsp_transport " + i.RTSPprotocol() + " " + i.RTSPaddress()
+	return args
+}
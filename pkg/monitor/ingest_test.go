@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+	"nvr/pkg/video"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stdinProcess is a mock transcoder that reads stdin until it's closed.
+type stdinProcess struct {
+	cmd   *exec.Cmd
+	stdin chan []byte
+}
+
+func (p stdinProcess) Timeout(time.Duration) ffmpeg.Process       { return p }
+func (p stdinProcess) StdoutLogger(ffmpeg.LogFunc) ffmpeg.Process { return p }
+func (p stdinProcess) StderrLogger(ffmpeg.LogFunc) ffmpeg.Process { return p }
+func (p stdinProcess) PID() int                                   { return 0 }
+func (p stdinProcess) Stop()                                      {}
+
+func (p stdinProcess) Start(context.Context) error {
+	data, err := io.ReadAll(p.cmd.Stdin)
+	p.stdin <- data
+	return err
+}
+
+func newTestPushInput(t *testing.T) (*InputProcess, chan []byte, chan string) {
+	logs := make(chan string, 10)
+	logf := func(_ log.Level, format string, a ...interface{}) {
+		msg, _ := log.Sprintf(format, a...)
+		logs <- msg
+	}
+	stdin := make(chan []byte, 1)
+
+	i := newTestInputProcess()
+	i.Config = NewConfig(RawConfig{"id": "test", "inputType": "push"})
+	i.logf = logf
+	i.ingest = newIngest(logf)
+	i.ingest.idleTimeout = 50 * time.Millisecond
+	i.newProcess = func(cmd *exec.Cmd) ffmpeg.Process {
+		return stdinProcess{cmd: cmd, stdin: stdin}
+	}
+	return i, stdin, logs
+}
+
+func TestRunPushProcess(t *testing.T) {
+	t.Run("frames", func(t *testing.T) {
+		i, stdin, logs := newTestPushInput(t)
+
+		done := make(chan error)
+		go func() { done <- runInputProcess(context.Background(), i) }()
+
+		frames := [][]byte{{0xff, 0xd8, 1}, {0xff, 0xd8, 2}, {0xff, 0xd8, 3}}
+		for _, frame := range frames {
+			i.ingest.push(frame)
+		}
+		require.Equal(t, "push source connected", <-logs)
+		require.Contains(t, <-logs, "starting main process: ")
+
+		// The stream ends when the source is idle.
+		require.Equal(t, []byte{0xff, 0xd8, 1, 0xff, 0xd8, 2, 0xff, 0xd8, 3}, <-stdin)
+		require.Equal(t, "push source idle for 50ms, offline", <-logs)
+		require.NoError(t, <-done)
+		require.False(t, i.ingest.online.Load())
+	})
+	t.Run("waitForFrame", func(t *testing.T) {
+		i, _, _ := newTestPushInput(t)
+		pathCreated := false
+		i.newVideoServerPath = func(
+			ctx context.Context, name string, conf video.PathConf,
+		) (*video.ServerPath, error) {
+			pathCreated = true
+			return stubNewVideoServerPath(ctx, name, conf)
+		}
+
+		// The process isn't started without frames.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.NoError(t, runInputProcess(ctx, i))
+		require.False(t, pathCreated)
+	})
+}
+
+func TestGenPushArgs(t *testing.T) {
+	i := &InputProcess{
+		Config: NewConfig(RawConfig{
+			"logLevel":     "1",
+			"mainStream":   "x",
+			"audioEncoder": "aac",
+			"videoEncoder": "copy",
+		}),
+		serverPath: video.ServerPath{
+			RtspProtocol: "2",
+			RtspAddress:  "3",
+		},
+		ingest: &ingest{},
+	}
+	expected := "-threads 1 -loglevel 1 -use_wallclock_as_timestamps 1" +
+		" -f image2pipe -c:v mjpeg -i pipe:0 -an -c:v libx264 -preset veryfast" +
+		" -pix_fmt yuv420p -f rtsp -rtsp_transport 2 3"
+	require.Equal(t, expected, i.generateArgs())
+}
+
+func TestIngestLimiter(t *testing.T) {
+	l := newIngestLimiter(1000)
+	now := time.Unix(0, 0)
+
+	// One second is allowed as a burst.
+	require.LessOrEqual(t, l.reserve("a", 1000, now), time.Duration(0))
+	require.Equal(t, 500*time.Millisecond, l.reserve("a", 500, now))
+	require.Equal(t, time.Second, l.reserve("a", 500, now))
+
+	// Other monitors are unaffected.
+	require.LessOrEqual(t, l.reserve("b", 1000, now), time.Duration(0))
+
+	// The limit recovers over time.
+	now = now.Add(3 * time.Second)
+	require.LessOrEqual(t, l.reserve("a", 1000, now), time.Duration(0))
+}
+
+func TestPushFrame(t *testing.T) {
+	newManager := func() *Manager {
+		m := &Manager{
+			rawConfigs: RawConfigs{
+				"push":     {"id": "push", "enable": "true", "inputType": "push"},
+				"camera":   {"id": "camera", "enable": "true"},
+				"disabled": {"id": "disabled", "inputType": "push"},
+			},
+			runningMonitors: make(monitors),
+			logger:          log.NewDummyLogger(),
+			hooks:           stubHooks(),
+			videoServer:     video.NewServer(nil, &sync.WaitGroup{}, storage.ConfigEnv{}),
+			ingestLimiter:   newIngestLimiter(ingestBytesPerSecond),
+		}
+		for id, rawConf := range m.rawConfigs {
+			m.runningMonitors[id] = m.newMonitor(NewConfig(rawConf))
+		}
+		return m
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		m := newManager()
+		require.NoError(t, m.PushFrame(context.Background(), "push", []byte{1}))
+		require.Equal(t, []byte{1}, <-m.runningMonitors["push"].ingest.frames)
+	})
+	t.Run("notExist", func(t *testing.T) {
+		err := newManager().PushFrame(context.Background(), "x", nil)
+		require.ErrorIs(t, err, ErrMonitorNotExist)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		err := newManager().PushFrame(context.Background(), "disabled", nil)
+		require.ErrorIs(t, err, ErrMonitorDisabled)
+	})
+	t.Run("notPush", func(t *testing.T) {
+		err := newManager().PushFrame(context.Background(), "camera", nil)
+		require.ErrorIs(t, err, ErrNotPushInput)
+	})
+	t.Run("online", func(t *testing.T) {
+		m := newManager()
+		require.Equal(t, "false", m.MonitorsInfo()["push"]["online"])
+		m.runningMonitors["push"].ingest.online.Store(true)
+		require.Equal(t, "true", m.MonitorsInfo()["push"]["online"])
+		require.NotContains(t, m.MonitorsInfo()["camera"], "online")
+	})
+}
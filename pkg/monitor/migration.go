@@ -0,0 +1,298 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MigrationVersions are the config versions before and after a migration.
+type MigrationVersions struct {
+	From int
+	To   int
+}
+
+// MigationHook is called when each monitor config is loaded.
+// The config is migrated in place and the versions are returned.
+type MigationHook func(RawConfig) (MigrationVersions, error)
+
+// Migration named migration hook.
+type Migration struct {
+	Name string
+	Hook MigationHook
+}
+
+// MigrationReport is the outcome of a single migration.
+type MigrationReport struct {
+	MonitorID string
+	Name      string
+	Versions  MigrationVersions
+	Err       error
+}
+
+// CoreMigration migrates the config keys of the monitor itself,
+// it runs before the migrations of the addons.
+var CoreMigration = Migration{Name: "monitor", Hook: migrateCore}
+
+// currentConfigVersion is the version of the core config keys.
+const currentConfigVersion = 1
+
+func migrateCore(c RawConfig) (MigrationVersions, error) {
+	configVersion, _ := strconv.Atoi(c["configVersion"])
+
+	if configVersion < 1 {
+		migrateCoreV0toV1(c)
+	}
+
+	c["configVersion"] = strconv.Itoa(currentConfigVersion)
+	return MigrationVersions{
+		From: configVersion,
+		To:   currentConfigVersion,
+	}, nil
+}
+
+// migrateCoreV0toV1 renames the main and sub inputs to streams.
+func migrateCoreV0toV1(c RawConfig) {
+	renames := []struct{ from, to string }{
+		{"mainInput", "mainStream"},
+		{"subInput", "subStream"},
+	}
+	for _, r := range renames {
+		v, exist := c[r.from]
+		if !exist {
+			continue
+		}
+		if _, exist := c[r.to]; !exist {
+			c[r.to] = v
+		}
+		delete(c, r.from)
+	}
+}
+
+// ErrMigrationPanic migration hook panicked.
+var ErrMigrationPanic = errors.New("migration panicked")
+
+// runMigrations runs every migration on a copy of the config. A migration
+// that fails or panics is isolated, its changes are discarded and the
+// error is reported without aborting the remaining migrations.
+func runMigrations(rawConf RawConfig, migrations []Migration) (RawConfig, []MigrationReport) {
+	reports := make([]MigrationReport, 0, len(migrations))
+	for _, m := range migrations {
+		migrated := copyRawConfig(rawConf)
+		versions, err := runMigration(m.Hook, migrated)
+
+		reports = append(reports, MigrationReport{
+			MonitorID: rawConf["id"],
+			Name:      m.Name,
+			Versions:  versions,
+			Err:       err,
+		})
+		if err == nil {
+			rawConf = migrated
+		}
+	}
+	return rawConf, reports
+}
+
+func runMigration(hook MigationHook, rawConf RawConfig) (v MigrationVersions, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrMigrationPanic, r)
+		}
+	}()
+	return hook(rawConf)
+}
+
+func copyRawConfig(rawConf RawConfig) RawConfig {
+	c := make(RawConfig, len(rawConf))
+	for k, v := range rawConf {
+		c[k] = v
+	}
+	return c
+}
+
+func rawConfigsEqual(a RawConfig, b RawConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if v2, exist := b[k]; !exist || v != v2 {
+			return false
+		}
+	}
+	return true
+}
+
+// configDiff returns the changed keys in a line based format.
+func configDiff(before RawConfig, after RawConfig) string {
+	keys := make(map[string]struct{})
+	for k := range before {
+		keys[k] = struct{}{}
+	}
+	for k := range after {
+		keys[k] = struct{}{}
+	}
+	sortedKeys := make([]string, 0, len(keys))
+	for k := range keys {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	var b strings.Builder
+	for _, k := range sortedKeys {
+		v1, exist1 := before[k]
+		v2, exist2 := after[k]
+		if exist1 && exist2 && v1 == v2 {
+			continue
+		}
+		if exist1 {
+			fmt.Fprintf(&b, "- %v: %v\n", k, v1)
+		}
+		if exist2 {
+			fmt.Fprintf(&b, "+ %v: %v\n", k, v2)
+		}
+	}
+	return b.String()
+}
+
+// String returns a human readable summary of the report.
+func (r MigrationReport) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("monitor %v: %v migration failed: %v", r.MonitorID, r.Name, r.Err)
+	}
+	return fmt.Sprintf("monitor %v: migrated %v config from v%v to v%v",
+		r.MonitorID, r.Name, r.Versions.From, r.Versions.To)
+}
+
+// The original monitor configs are backed up here before they are
+// migrated. configPath is the monitors directory inside the config
+// directory, the backups are stored in "configDir/migrations".
+func migrationBackupsDir(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), "migrations")
+}
+
+const backupTimeFormat = "2006-01-02_15-04-05"
+
+func backupDirName(t time.Time) string {
+	return "backup-" + t.Format(backupTimeFormat)
+}
+
+func writeBackup(backupDir string, rawConf RawConfig) error {
+	if err := os.MkdirAll(backupDir, 0o700); err != nil {
+		return fmt.Errorf("create backup directory: %w", err)
+	}
+	jsonConf, err := json.MarshalIndent(rawConf, "", "    ")
+	if err != nil {
+		return fmt.Errorf("marshal config: %w", err)
+	}
+	path := monitorConfigPath(backupDir, rawConf["id"])
+	if err := os.WriteFile(path, jsonConf, 0o600); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	return nil
+}
+
+// MigrateDryRun runs the migrations on every monitor config in
+// configPath without persisting anything. Returns the diff of
+// each config that would change and the migration reports.
+func MigrateDryRun(configPath string, migrations []Migration) (string, []MigrationReport, error) {
+	var diffs string
+	reports, err := dryRunMigrations(configPath, migrations, func(before, after RawConfig) {
+		if diff := configDiff(before, after); diff != "" {
+			diffs += "monitor " + before["id"] + ":\n" + diff
+		}
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return diffs, reports, nil
+}
+
+// MigratedConfigs returns the monitor configs in configPath as they
+// would be after the migrations, without persisting anything.
+func MigratedConfigs(configPath string, migrations []Migration) (RawConfigs, []MigrationReport, error) {
+	configs := make(RawConfigs)
+	reports, err := dryRunMigrations(configPath, migrations, func(_, after RawConfig) {
+		configs[after["id"]] = after
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return configs, reports, nil
+}
+
+// dryRunMigrations calls onConfig with each config before and after the migrations.
+func dryRunMigrations(
+	configPath string,
+	migrations []Migration,
+	onConfig func(before RawConfig, after RawConfig),
+) ([]MigrationReport, error) {
+	configFiles, err := readConfigs(os.DirFS(configPath))
+	if err != nil {
+		return nil, fmt.Errorf("read config files: %w", err)
+	}
+
+	var allReports []MigrationReport
+	for _, file := range configFiles {
+		var rawConf RawConfig
+		if err := json.Unmarshal(file, &rawConf); err != nil {
+			return nil, fmt.Errorf("unmarshal config: %w: %v", err, string(file))
+		}
+		migrated, reports := runMigrations(rawConf, migrations)
+		allReports = append(allReports, reports...)
+		onConfig(rawConf, migrated)
+	}
+	return allReports, nil
+}
+
+// Errors.
+var (
+	ErrNoBackup        = errors.New("no backup found")
+	ErrInvalidBackupID = errors.New("invalid monitor id")
+)
+
+// RestoreBackup restores the most recent migration backup of a monitor
+// config in configPath. Returns the path of the restored backup.
+func RestoreBackup(configPath string, monitorID string) (string, error) {
+	if monitorID == "" || strings.ContainsAny(monitorID, `/\`) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidBackupID, monitorID)
+	}
+
+	backupsDir := migrationBackupsDir(configPath)
+	entries, err := os.ReadDir(backupsDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("read backups directory: %w", err)
+	}
+
+	// Names are timestamps, the most recent is sorted last.
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "backup-") {
+			continue
+		}
+
+		backupPath := monitorConfigPath(filepath.Join(backupsDir, entry.Name()), monitorID)
+		backup, err := os.ReadFile(backupPath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("read backup: %w", err)
+		}
+
+		err = os.WriteFile(monitorConfigPath(configPath, monitorID), backup, 0o600)
+		if err != nil {
+			return "", fmt.Errorf("write config: %w", err)
+		}
+		return backupPath, nil
+	}
+	return "", fmt.Errorf("%w: %v", ErrNoBackup, monitorID)
+}
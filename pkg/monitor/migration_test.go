@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunMigrations(t *testing.T) {
+	stubErr := errors.New("stub")
+
+	migrations := []Migration{
+		{
+			Name: "a",
+			Hook: func(c RawConfig) (MigrationVersions, error) {
+				c["a"] = "1"
+				return MigrationVersions{From: 0, To: 1}, nil
+			},
+		},
+		{
+			Name: "failing",
+			Hook: func(c RawConfig) (MigrationVersions, error) {
+				delete(c, "a")
+				return MigrationVersions{}, stubErr
+			},
+		},
+		{
+			Name: "panic",
+			Hook: func(c RawConfig) (MigrationVersions, error) {
+				c["panic"] = "1"
+				panic("stub")
+			},
+		},
+		{
+			Name: "b",
+			Hook: func(c RawConfig) (MigrationVersions, error) {
+				c["b"] = "2"
+				return MigrationVersions{From: 1, To: 2}, nil
+			},
+		},
+	}
+
+	original := RawConfig{"id": "x"}
+	migrated, reports := runMigrations(original, migrations)
+
+	require.Equal(t, RawConfig{"id": "x"}, original)
+	require.Equal(t, RawConfig{"id": "x", "a": "1", "b": "2"}, migrated)
+
+	require.Len(t, reports, 4)
+	require.NoError(t, reports[0].Err)
+	require.ErrorIs(t, reports[1].Err, stubErr)
+	require.ErrorIs(t, reports[2].Err, ErrMigrationPanic)
+	require.NoError(t, reports[3].Err)
+
+	require.Equal(t, "monitor x: migrated a config from v0 to v1", reports[0].String())
+	require.Equal(t, "monitor x: failing migration failed: stub", reports[1].String())
+}
+
+func TestMigrateCore(t *testing.T) {
+	t.Run("v0", func(t *testing.T) {
+		c := RawConfig{"id": "x", "mainInput": "a", "subInput": "b"}
+		versions, err := migrateCore(c)
+		require.NoError(t, err)
+		require.Equal(t, MigrationVersions{From: 0, To: 1}, versions)
+		expected := RawConfig{
+			"id":            "x",
+			"configVersion": "1",
+			"mainStream":    "a",
+			"subStream":     "b",
+		}
+		require.Equal(t, expected, c)
+	})
+	t.Run("noSubInput", func(t *testing.T) {
+		c := RawConfig{"mainInput": "a"}
+		_, err := migrateCore(c)
+		require.NoError(t, err)
+		require.Equal(t, RawConfig{"configVersion": "1", "mainStream": "a"}, c)
+	})
+	t.Run("keepStream", func(t *testing.T) {
+		c := RawConfig{"mainInput": "a", "mainStream": "b"}
+		_, err := migrateCore(c)
+		require.NoError(t, err)
+		require.Equal(t, RawConfig{"configVersion": "1", "mainStream": "b"}, c)
+	})
+	t.Run("v1", func(t *testing.T) {
+		c := RawConfig{"configVersion": "1", "mainStream": "a"}
+		versions, err := migrateCore(c)
+		require.NoError(t, err)
+		require.Equal(t, MigrationVersions{From: 1, To: 1}, versions)
+		require.Equal(t, RawConfig{"configVersion": "1", "mainStream": "a"}, c)
+	})
+}
+
+func TestConfigDiff(t *testing.T) {
+	before := RawConfig{"a": "1", "b": "2", "c": "3"}
+	after := RawConfig{"a": "1", "b": "4", "d": "5"}
+
+	expected := "- b: 2\n" +
+		"+ b: 4\n" +
+		"- c: 3\n" +
+		"+ d: 5\n"
+	require.Equal(t, expected, configDiff(before, after))
+	require.Equal(t, "", configDiff(before, before))
+}
+
+func newMigrationTestHooks() *Hooks {
+	return &Hooks{Migrations: []Migration{{
+		Name: "test",
+		Hook: func(c RawConfig) (MigrationVersions, error) {
+			c["test"] = "migrated"
+			return MigrationVersions{From: 0, To: 1}, nil
+		},
+	}}}
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	configDir := prepareDir(t)
+	configPath := filepath.Join(configDir, "1.json")
+
+	before, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+
+	diff, reports, err := MigrateDryRun(configDir, newMigrationTestHooks().Migrations)
+	require.NoError(t, err)
+	require.Contains(t, diff, "monitor 1:\n+ test: migrated\n")
+	require.NotEmpty(t, reports)
+
+	after, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+
+	_, err = os.Stat(migrationBackupsDir(configDir))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestMigratedConfigs(t *testing.T) {
+	configDir := prepareDir(t)
+	configPath := filepath.Join(configDir, "1.json")
+
+	before, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+
+	configs, reports, err := MigratedConfigs(configDir, newMigrationTestHooks().Migrations)
+	require.NoError(t, err)
+	require.Equal(t, "migrated", configs["1"]["test"])
+	require.NotEmpty(t, reports)
+
+	after, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+}
+
+func TestRestoreBackup(t *testing.T) {
+	t.Run("roundTrip", func(t *testing.T) {
+		configDir := prepareDir(t)
+		configPath := filepath.Join(configDir, "1.json")
+
+		original, err := os.ReadFile(configPath)
+		require.NoError(t, err)
+
+		manager, err := NewManager(
+			configDir,
+			storage.ConfigEnv{},
+			nil,
+			log.NewDummyLogger(),
+			nil,
+			newMigrationTestHooks(),
+		)
+		require.NoError(t, err)
+		require.Equal(t, "migrated", manager.rawConfigs["1"]["test"])
+		require.Equal(t, "migrated", readConfig(t, configPath)["test"])
+
+		backupPath, err := RestoreBackup(configDir, "1")
+		require.NoError(t, err)
+		require.Equal(t, migrationBackupsDir(configDir), filepath.Dir(filepath.Dir(backupPath)))
+
+		restored := readConfig(t, configPath)
+		_, exist := restored["test"]
+		require.False(t, exist)
+
+		var expected RawConfig
+		require.NoError(t, json.Unmarshal(original, &expected))
+		require.Equal(t, expected, restored)
+	})
+	t.Run("mostRecent", func(t *testing.T) {
+		configDir := prepareDir(t)
+		backupsDir := migrationBackupsDir(configDir)
+
+		old := filepath.Join(backupsDir, "backup-2000-01-01_00-00-00")
+		require.NoError(t, writeBackup(old, RawConfig{"id": "1", "v": "old"}))
+		recent := filepath.Join(backupsDir, "backup-2001-01-01_00-00-00")
+		require.NoError(t, writeBackup(recent, RawConfig{"id": "1", "v": "recent"}))
+
+		_, err := RestoreBackup(configDir, "1")
+		require.NoError(t, err)
+		require.Equal(t, "recent", readConfig(t, filepath.Join(configDir, "1.json"))["v"])
+	})
+	t.Run("noBackup", func(t *testing.T) {
+		_, err := RestoreBackup(prepareDir(t), "1")
+		require.ErrorIs(t, err, ErrNoBackup)
+	})
+	t.Run("invalidID", func(t *testing.T) {
+		_, err := RestoreBackup(prepareDir(t), "../1")
+		require.ErrorIs(t, err, ErrInvalidBackupID)
+	})
+}
@@ -7,9 +7,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"image"
+	"image/draw"
 	"io/fs"
 	"nvr/pkg/ffmpeg"
 	"nvr/pkg/log"
+	"nvr/pkg/metrics"
 	"nvr/pkg/storage"
 	"nvr/pkg/video"
 	"nvr/pkg/video/gortsplib"
@@ -18,12 +21,19 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// StartHook is called when monitor start.
+// StartHook is called when monitor start. The context is canceled
+// when the monitor stops, goroutines started by the hook should be
+// added to the monitor wait group so the monitor waits for them.
 type StartHook func(context.Context, *Monitor)
 
+// StopHook is called after a started monitor have stopped
+// and before the monitor can be started again.
+type StopHook func(*Monitor)
+
 // StartInputHook is called when input process start.
 type StartInputHook func(context.Context, *InputProcess, *[]string)
 
@@ -36,17 +46,18 @@ type RecSaveHook func(*Recorder, *string)
 // RecSavedHook is called after recording have been saved successfully.
 type RecSavedHook func(*Recorder, string, storage.RecordingData)
 
-// MigationHook is called when each monitor config is loaded.
-type MigationHook func(RawConfig) error
-
 // Hooks monitor hooks.
 type Hooks struct {
 	Start      StartHook
+	Stop       StopHook
 	StartInput StartInputHook
 	Event      EventHook
 	RecSave    RecSaveHook
 	RecSaved   RecSavedHook
-	Migrate    MigationHook
+	Migrations []Migration
+
+	// ConfigKeys returns the config keys used by the enabled addons.
+	ConfigKeys func() []string
 }
 
 // Manager for the monitors.
@@ -54,18 +65,29 @@ type Manager struct {
 	rawConfigs      RawConfigs
 	runningMonitors monitors
 
-	env         storage.ConfigEnv
-	logger      log.ILogger
-	videoServer *video.Server
-	path        string
-	hooks       Hooks
-	mu          sync.Mutex
+	env           storage.ConfigEnv
+	recordingsDir func() string
+	logger        log.ILogger
+	videoServer   *video.Server
+	path          string
+	hooks         Hooks
+	processes     ffmpeg.ProcessTracker
+	mu            sync.Mutex
+
+	triggerLimiter *triggerLimiter
+	ingestLimiter  *ingestLimiter
+
+	migrationReports []MigrationReport
+
+	recordingBytes *metrics.CounterVec
+	restarts       *metrics.CounterVec
 }
 
 // NewManager return new monitor manager.
 func NewManager(
 	configPath string,
 	env storage.ConfigEnv,
+	recordingsDir func() string,
 	logger log.ILogger,
 	videoServer *video.Server,
 	hooks *Hooks,
@@ -81,39 +103,95 @@ func NewManager(
 	}
 
 	rawConfigs := make(RawConfigs)
+	var migrationReports []MigrationReport
+	backupDir := filepath.Join(migrationBackupsDir(configPath), backupDirName(time.Now()))
 	for _, file := range configFiles {
 		var rawConf RawConfig
 		if err := json.Unmarshal(file, &rawConf); err != nil {
 			return nil, fmt.Errorf("unmarshal config: %w: %v", err, string(file))
 		}
-		if err := hooks.Migrate(rawConf); err != nil {
-			return nil, fmt.Errorf("migration failed: %w", err)
-		}
 
-		id := rawConf["id"]
-		configPath := monitorConfigPath(configPath, id)
+		migrated, reports := runMigrations(rawConf, hooks.Migrations)
+		migrationReports = append(migrationReports, reports...)
 
-		jsonConf, _ := json.MarshalIndent(rawConf, "", "    ")
-		err := os.WriteFile(configPath, jsonConf, 0o600)
-		if err != nil {
-			return nil, fmt.Errorf("write migrated config: %w", err)
+		id := migrated["id"]
+		if !rawConfigsEqual(rawConf, migrated) {
+			if err := writeBackup(backupDir, rawConf); err != nil {
+				return nil, fmt.Errorf("backup config: %w", err)
+			}
+
+			jsonConf, _ := json.MarshalIndent(migrated, "", "    ")
+			err := os.WriteFile(monitorConfigPath(configPath, id), jsonConf, 0o600)
+			if err != nil {
+				return nil, fmt.Errorf("write migrated config: %w", err)
+			}
 		}
 
-		rawConfigs[id] = rawConf
+		rawConfigs[id] = migrated
 	}
 
 	return &Manager{
 		rawConfigs:      rawConfigs,
 		runningMonitors: make(monitors),
 
-		env:         env,
-		logger:      logger,
-		videoServer: videoServer,
-		path:        configPath,
-		hooks:       *hooks,
+		env:           env,
+		recordingsDir: recordingsDir,
+		logger:        logger,
+		videoServer:   videoServer,
+		path:          configPath,
+		hooks:         *hooks,
+
+		triggerLimiter: newTriggerLimiter(triggerInterval, triggerBurst),
+		ingestLimiter:  newIngestLimiter(ingestBytesPerSecond),
+
+		migrationReports: migrationReports,
+
+		recordingBytes: metrics.NewCounterVec(
+			"nvr_recording_bytes_total",
+			"Bytes written to recordings.",
+			metrics.LabelMonitorID,
+		),
+		restarts: metrics.NewCounterVec(
+			"nvr_ffmpeg_restarts_total",
+			"Number of times the input processes were restarted after a crash.",
+			metrics.LabelMonitorID,
+		),
 	}, nil
 }
 
+// RegisterMetrics registers the recorder and input process metrics.
+func (m *Manager) RegisterMetrics(r *metrics.Registry) {
+	r.MustRegister(m.recordingBytes, m.restarts)
+}
+
+// LogMigrations logs the versions and errors of the
+// migrations that ran when the manager was created.
+func (m *Manager) LogMigrations() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, report := range m.migrationReports {
+		if report.Err != nil {
+			m.logger.Log(log.Entry{
+				Level:     log.LevelError,
+				Src:       "monitor",
+				MonitorID: report.MonitorID,
+				Msg:       report.String(),
+			})
+			continue
+		}
+		if report.Versions.From != report.Versions.To {
+			m.logger.Log(log.Entry{
+				Level:     log.LevelInfo,
+				Src:       "monitor",
+				MonitorID: report.MonitorID,
+				Msg:       report.String(),
+			})
+		}
+	}
+	m.migrationReports = nil
+}
+
 func readConfigs(fileSystem fs.FS) ([][]byte, error) {
 	var files [][]byte
 	walkFunc := func(path string, d fs.DirEntry, err error) error {
@@ -176,7 +254,10 @@ func (m *Manager) RestartMonitor(id string) error {
 		return ErrMonitorNotExist
 	}
 
-	if _, exist := m.runningMonitors[id]; exist {
+	if monitor, exist := m.runningMonitors[id]; exist {
+		if monitor.reload(NewConfig(m.rawConfigs[id])) {
+			return nil
+		}
 		m.unsafeStopMonitor(id)
 	}
 	m.unsafeStartMonitor(id)
@@ -188,7 +269,10 @@ func (m *Manager) RestartMonitor(id string) error {
 func (m *Manager) MonitorSet(id string, rawConf RawConfig) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.unsafeMonitorSet(id, rawConf)
+}
 
+func (m *Manager) unsafeMonitorSet(id string, rawConf RawConfig) error {
 	// Write config to file.
 	configJSON, err := json.MarshalIndent(rawConf, "", "    ")
 	if err != nil {
@@ -248,8 +332,11 @@ func (m *Manager) MonitorsInfo() RawConfigs {
 		}
 
 		subInputEnabled := "false"
-		if c.SubInputEnabled() {
+		// Live renditions, selected with the quality parameter of the playlist.
+		renditions := "high"
+		if c.SubStreamEnabled() {
 			subInputEnabled = "true"
+			renditions = "high,low"
 		}
 
 		configs[c.ID()] = RawConfig{
@@ -258,6 +345,18 @@ func (m *Manager) MonitorsInfo() RawConfigs {
 			"enable":          enable,
 			"audioEnabled":    audioEnabled,
 			"subInputEnabled": subInputEnabled,
+			"renditions":      renditions,
+		}
+
+		// Push monitors are offline until frames are received.
+		if c.PushInput() {
+			online := "false"
+			monitor, running := m.runningMonitors[c.ID()]
+			if running && monitor.ingest != nil && monitor.ingest.online.Load() {
+				online = "true"
+			}
+			configs[c.ID()]["inputType"] = "push"
+			configs[c.ID()]["online"] = online
 		}
 	}
 	return configs
@@ -271,6 +370,12 @@ func monitorConfigPath(path string, id string) string {
 	return filepath.Join(path, id+".json")
 }
 
+// ProcessIDs returns the process IDs of the
+// running ffmpeg processes of each monitor.
+func (m *Manager) ProcessIDs() map[string][]int {
+	return m.processes.PIDs()
+}
+
 // MonitorConfigs returns configurations for all monitors.
 func (m *Manager) MonitorConfigs() RawConfigs {
 	m.mu.Lock()
@@ -295,16 +400,29 @@ type Monitor struct {
 	Logger      log.ILogger
 	videoServer *video.Server
 
-	mainInput *InputProcess
-	subInput  *InputProcess
-	recorder  *Recorder
-	Recorder
+	// Returns the recordings directory for a new recording.
+	recordingsDir func() string
+
+	mainInput  *InputProcess
+	subInput   *InputProcess
+	recorder   *Recorder
 	hooks      Hooks
 	NewProcess ffmpeg.NewProcessFunc
 	logf       logFunc
 
+	// Only set for push monitors.
+	ingest *ingest
+
+	recordingBytes *metrics.Counter
+	restarts       *metrics.Counter
+
 	WG     sync.WaitGroup
 	cancel func()
+
+	stopHookTimeout time.Duration
+
+	// Hot reloadable, the config isn't updated.
+	excludeLiveAudio atomic.Bool
 }
 
 type (
@@ -313,14 +431,18 @@ type (
 
 func (m *Manager) newMonitor(config Config) *Monitor {
 	monitorID := config.ID()
+	censor := func(s string) string {
+		return m.env.CensorLog(config.CensorLog(s))
+	}
 	logf := func(level log.Level, format string, a ...interface{}) {
-		msg := fmt.Sprintf(format, a...)
+		msg, fields := log.Sprintf(format, a...)
 		m.logger.Log(log.Entry{
 			Level:     level,
 			Src:       "monitor",
 			MonitorID: monitorID,
-			Msg:       m.env.CensorLog(config.CensorLog(msg)),
-		})
+			Msg:       msg,
+			Fields:    fields,
+		}.Censor(censor))
 	}
 
 	monitor := &Monitor{
@@ -329,9 +451,20 @@ func (m *Manager) newMonitor(config Config) *Monitor {
 		Logger:      m.logger,
 		videoServer: m.videoServer,
 
+		recordingsDir: m.recordingsDir,
+
 		hooks:      m.hooks,
-		NewProcess: ffmpeg.NewProcess,
+		NewProcess: m.processes.NewProcessFunc(monitorID, ffmpeg.NewProcess),
 		logf:       logf,
+
+		recordingBytes: m.recordingBytes.With(monitorID),
+		restarts:       m.restarts.With(monitorID),
+
+		stopHookTimeout: stopHookTimeout,
+	}
+	monitor.excludeLiveAudio.Store(!config.LiveAudio())
+	if config.PushInput() {
+		monitor.ingest = newIngest(logf)
 	}
 	monitor.mainInput = newInputProcess(monitor, false)
 	monitor.subInput = newInputProcess(monitor, true)
@@ -362,6 +495,7 @@ func (m *Monitor) start() {
 				err := m.SendEvent(storage.Event{
 					Time:        time.Now(),
 					RecDuration: infinte,
+					Source:      storage.EventSourceContinuous,
 				})
 				if err != nil {
 					m.logf(log.LevelError, "could not start continuous recording: %v", err)
@@ -375,7 +509,7 @@ func (m *Monitor) start() {
 	m.WG.Add(1)
 	go m.mainInput.start(m.ctx)
 
-	if m.Config.SubInputEnabled() {
+	if m.Config.SubStreamEnabled() {
 		m.WG.Add(1)
 		go m.subInput.start(m.ctx)
 	}
@@ -384,6 +518,24 @@ func (m *Monitor) start() {
 	go m.recorder.start(m.ctx)
 }
 
+// MainInput returns the main input process.
+func (m *Monitor) MainInput() *InputProcess {
+	return m.mainInput
+}
+
+// SubInput returns the sub input process or nil if it's disabled.
+func (m *Monitor) SubInput() *InputProcess {
+	if !m.Config.SubStreamEnabled() {
+		return nil
+	}
+	return m.subInput
+}
+
+// Recorder returns the recorder of the main input.
+func (m *Monitor) Recorder() *Recorder {
+	return m.recorder
+}
+
 // SendEventFunc send event signature.
 type SendEventFunc func(storage.Event) error
 
@@ -392,20 +544,99 @@ func (m *Monitor) SendEvent(event storage.Event) error {
 	return m.recorder.sendEvent(m.ctx, event)
 }
 
-// Stop monitor.
+// hotReloadKeys are config keys that can be changed without a restart.
+var hotReloadKeys = map[string]struct{}{
+	"liveAudio": {},
+}
+
+// reload applies the new config to the running monitor if only hot
+// reloadable keys changed. Returns false if a restart is required.
+func (m *Monitor) reload(config Config) bool {
+	if m.ctx == nil || !configsEqualExcept(m.Config, config, hotReloadKeys) {
+		return false
+	}
+
+	exclude := !config.LiveAudio()
+	if m.excludeLiveAudio.Swap(exclude) == exclude {
+		return true
+	}
+
+	// Input processes that are restarting will read the new value.
+	inputs := []*InputProcess{m.mainInput}
+	if m.Config.SubStreamEnabled() {
+		inputs = append(inputs, m.subInput)
+	}
+	for _, input := range inputs {
+		err := m.videoServer.SetExcludeLiveAudio(input.rtspPathName(), exclude)
+		if err != nil && !errors.Is(err, video.ErrPathNotExist) {
+			m.logf(log.LevelError, "%v process: set live audio: %v", input.ProcessName(), err)
+		}
+	}
+	m.logf(log.LevelInfo, "reloaded, live audio: %v", !exclude)
+	return true
+}
+
+// configsEqualExcept returns true if the configs are equal, ignoring the keys.
+func configsEqualExcept(a Config, b Config, ignore map[string]struct{}) bool {
+	for key, value := range a.v {
+		if _, ignored := ignore[key]; !ignored && b.v[key] != value {
+			return false
+		}
+	}
+	for key, value := range b.v {
+		if _, ignored := ignore[key]; !ignored && a.v[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// stopHookTimeout the maximum time to wait for the stop hook.
+const stopHookTimeout = 10 * time.Second
+
+// Stop monitor. The stop hook is called after all the goroutines
+// have exited. The manager holds its lock while stopping, a
+// restarting monitor isn't started until the hook has returned.
 func (m *Monitor) stop() {
-	if m.cancel != nil {
-		m.cancel()
+	if m.cancel == nil { // Never started.
+		return
 	}
+	m.cancel()
 	m.WG.Wait()
+
+	if m.hooks.Stop == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		m.hooks.Stop(m)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(m.stopHookTimeout):
+		m.logf(log.LevelError, "stop hook did not return within %v", m.stopHookTimeout)
+	}
 }
 
 // InputProcess monitor input process.
 type InputProcess struct {
 	Config     Config
-	serverPath video.ServerPath
 	isSubInput bool
 
+	// The path is replaced every time the process starts. The
+	// ready channel is closed when the first path is added.
+	serverPath video.ServerPath
+	pathMu     sync.Mutex
+	pathReady  chan struct{}
+
+	// Empty if the privacy mask is disabled.
+	privacyMaskPath string
+
+	// Source of the pushed frames, nil unless this
+	// is the main input of a push monitor.
+	ingest *ingest
+
 	cancel func()
 
 	hooks     Hooks
@@ -414,6 +645,11 @@ type InputProcess struct {
 	WG        *sync.WaitGroup
 	SendEvent SendEventFunc
 
+	excludeLiveAudio *atomic.Bool
+
+	backoff  backoff
+	restarts *metrics.Counter
+
 	logf               logFunc
 	newVideoServerPath newVideoServerPathFunc
 	runInputProcess    runInputProcessFunc
@@ -435,40 +671,100 @@ func newInputProcess(m *Monitor, isSubInput bool) *InputProcess {
 		WG:        &m.WG,
 		SendEvent: m.SendEvent,
 
+		excludeLiveAudio: &m.excludeLiveAudio,
+
+		backoff:  backoff{min: minRestartDelay, max: maxRestartDelay},
+		restarts: m.restarts,
+
 		logf:               m.logf,
 		newVideoServerPath: m.videoServer.NewPath,
 		runInputProcess:    runInputProcess,
-		newProcess:         ffmpeg.NewProcess,
+		newProcess:         m.NewProcess,
+	}
+	if !isSubInput {
+		i.ingest = m.ingest
 	}
 
 	return i
 }
 
+// NewProcess returns a new process that is attributed
+// to the monitor while it's running.
+func (i *InputProcess) NewProcess(cmd *exec.Cmd) ffmpeg.Process {
+	return i.newProcess(cmd)
+}
+
 // IsSubInput if the input is the sub stream.
 func (i *InputProcess) IsSubInput() bool {
 	return i.isSubInput
 }
 
+// IsDetectionInput returns true if the input should feed the
+// detectors. This is the sub stream if one is configured,
+// otherwise the main stream. Recording always uses the main stream.
+func (i *InputProcess) IsDetectionInput() bool {
+	return i.Config.SubStreamEnabled() == i.isSubInput
+}
+
+func (i *InputProcess) path() video.ServerPath {
+	i.pathMu.Lock()
+	defer i.pathMu.Unlock()
+	return i.serverPath
+}
+
+func (i *InputProcess) setPath(serverPath video.ServerPath) {
+	i.pathMu.Lock()
+	defer i.pathMu.Unlock()
+	i.serverPath = serverPath
+	if i.pathReady == nil {
+		i.pathReady = make(chan struct{})
+	}
+	select {
+	case <-i.pathReady:
+	default:
+		close(i.pathReady)
+	}
+}
+
+// WaitForPath blocks until the process has added its path to the
+// video server. Hooks that aren't called by the input process must
+// wait for the path before reading the addresses or tracks.
+func (i *InputProcess) WaitForPath(ctx context.Context) error {
+	i.pathMu.Lock()
+	if i.pathReady == nil {
+		i.pathReady = make(chan struct{})
+	}
+	ready := i.pathReady
+	i.pathMu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // HLSaddress internal HLS address.
 func (i *InputProcess) HLSaddress() string {
-	return i.serverPath.HlsAddress
+	return i.path().HlsAddress
 }
 
 // RTSPaddress internal RTSP address.
 func (i *InputProcess) RTSPaddress() string {
-	return i.serverPath.RtspAddress
+	return i.path().RtspAddress
 }
 
 // RTSPprotocol protocol used by RTSP address.
 func (i *InputProcess) RTSPprotocol() string {
-	return i.serverPath.RtspProtocol
+	return i.path().RtspProtocol
 }
 
 // VideoTrack returns the stream video track.
 func (i *InputProcess) VideoTrack(ctx context.Context) (*gortsplib.TrackH264, error) {
 	// It may take a few seconds for the stream to
 	// become available after the monitor started.
-	muxer, err := i.serverPath.HLSMuxer(ctx)
+	muxer, err := i.path().HLSMuxer(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("get muxer: %w", err)
 	}
@@ -479,7 +775,7 @@ func (i *InputProcess) VideoTrack(ctx context.Context) (*gortsplib.TrackH264, er
 func (i *InputProcess) AudioTrack(ctx context.Context) (*gortsplib.TrackMPEG4Audio, error) {
 	// It may take a few seconds for the stream to
 	// become available after the monitor started.
-	muxer, err := i.serverPath.HLSMuxer(ctx)
+	muxer, err := i.path().HLSMuxer(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("get muxer: %w", err)
 	}
@@ -488,7 +784,7 @@ func (i *InputProcess) AudioTrack(ctx context.Context) (*gortsplib.TrackMPEG4Aud
 
 // HLSMuxer returns the HLS muxer for this input.
 func (i *InputProcess) HLSMuxer(ctx context.Context) (video.IHLSMuxer, error) {
-	return i.serverPath.HLSMuxer(ctx)
+	return i.path().HLSMuxer(ctx)
 }
 
 // ProcessName name of process "main" or "sub".
@@ -501,9 +797,9 @@ func (i *InputProcess) ProcessName() string {
 
 func (i *InputProcess) input() string {
 	if i.IsSubInput() {
-		return i.Config.SubInput()
+		return i.Config.SubStream()
 	}
-	return i.Config.MainInput()
+	return i.Config.MainStream()
 }
 
 func (i *InputProcess) rtspPathName() string {
@@ -518,6 +814,40 @@ func (i *InputProcess) Cancel() {
 	i.cancel()
 }
 
+// Restart delays of input processes. Each input process has its own
+// backoff, a failing sub stream will not delay the main stream.
+const (
+	minRestartDelay = 1 * time.Second
+	maxRestartDelay = 1 * time.Minute
+
+	// Processes that ran for longer than this are
+	// considered healthy and the delay is reset.
+	restartResetAfter = 1 * time.Minute
+)
+
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+// next doubles the delay up to max.
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.min
+	} else {
+		b.current *= 2
+	}
+	if b.current > b.max {
+		b.current = b.max
+	}
+	return b.current
+}
+
+func (b *backoff) reset() {
+	b.current = 0
+}
+
 func (i *InputProcess) start(ctx context.Context) {
 	for {
 		if ctx.Err() != nil {
@@ -526,14 +856,21 @@ func (i *InputProcess) start(ctx context.Context) {
 			return
 		}
 
-		if err := i.runInputProcess(ctx, i); err != nil {
+		started := time.Now()
+		err := i.runInputProcess(ctx, i)
+		if time.Since(started) > restartResetAfter {
+			i.backoff.reset()
+		}
+		if err != nil {
 			i.logf(log.LevelError, "%v process: crashed: %v", i.ProcessName(), err)
+			i.restarts.Inc()
 			select {
 			case <-ctx.Done():
-			case <-time.After(1 * time.Second):
+			case <-time.After(i.backoff.next()):
 			}
 			continue
 		}
+		i.backoff.reset()
 	}
 }
 
@@ -542,12 +879,37 @@ func runInputProcess(ctx context.Context, i *InputProcess) error {
 	i.cancel = cancel2
 	defer cancel2()
 
-	pathConf := video.PathConf{MonitorID: i.Config.ID(), IsSub: i.IsSubInput()}
+	// Push monitors are offline until frames are received.
+	if i.ingest != nil {
+		if err := i.ingest.waitForFrame(processCTX); err != nil {
+			return nil
+		}
+	}
+
+	// Validated by ValidateConfig.
+	keyRotation, _ := i.Config.HLSKeyRotation()
+
+	pathConf := video.PathConf{
+		MonitorID:      i.Config.ID(),
+		IsSub:          i.IsSubInput(),
+		HLSIFrames:     i.Config.HLSIFrames(),
+		HLSEncryption:  i.Config.HLSEncryption(),
+		HLSKeyRotation: keyRotation,
+
+		ExcludeLiveAudio: i.excludeLiveAudio.Load(),
+		AudioLevel:       i.Config.AudioLevel() && !i.IsSubInput(),
+		DebugRTSP:        i.Config.LogLevel() == "debug",
+	}
 	serverPath, err := i.newVideoServerPath(processCTX, i.rtspPathName(), pathConf)
 	if err != nil {
 		return fmt.Errorf("add path to RTSP server: %w", err)
 	}
-	i.serverPath = *serverPath
+	i.setPath(*serverPath)
+
+	i.privacyMaskPath, err = i.writePrivacyMask()
+	if err != nil {
+		return fmt.Errorf("privacy mask: %w", err)
+	}
 
 	logLevel := log.FFmpegLevel(i.Config.LogLevel())
 	args := ffmpeg.ParseArgs(i.generateArgs())
@@ -560,14 +922,20 @@ func runInputProcess(ctx context.Context, i *InputProcess) error {
 		i.logf(logLevel, "%v process: %v", i.ProcessName(), msg)
 	}
 
-	process := i.newProcess(cmd).
-		Timeout(10 * time.Second).
-		StdoutLogger(logFunc).
-		StderrLogger(logFunc)
+	newProcess := func(cmd *exec.Cmd) ffmpeg.Process {
+		return i.newProcess(cmd).
+			Timeout(10 * time.Second).
+			StdoutLogger(logFunc).
+			StderrLogger(logFunc)
+	}
 
 	i.logf(log.LevelInfo, "starting %v process: %v", i.ProcessName(), cmd)
 
-	err = process.Start(processCTX) // Blocks until process exits.
+	if i.ingest != nil {
+		err = i.runPushProcess(processCTX, cmd, newProcess)
+	} else {
+		err = newProcess(cmd).Start(processCTX) // Blocks until process exits.
+	}
 	if err != nil {
 		return fmt.Errorf("crashed: %w", err)
 	}
@@ -580,6 +948,10 @@ func (i *InputProcess) generateArgs() string {
 	// -threads 1 -loglevel error -hwaccel x -i rtsp://x -c:a aac -c:v libx264
 	// -f rtsp -rtsp_transport tcp rtsp://127.0.0.1:2021/test
 
+	if i.ingest != nil {
+		return i.generatePushArgs()
+	}
+
 	c := i.Config
 	var args string
 
@@ -593,6 +965,18 @@ func (i *InputProcess) generateArgs() string {
 	}
 	args += " -i " + i.input()
 
+	if i.privacyMaskPath != "" {
+		// The mask is scaled to the video size and overlaid on the
+		// decoded frames. Hardware decoded frames are downloaded to
+		// system memory unless the input options specify otherwise.
+		args += " -i " + i.privacyMaskPath +
+			" -filter_complex [1:v][0:v]scale2ref=flags=neighbor[mask][video];" +
+			"[video][mask]overlay[out] -map [out]"
+		if c.audioEnabled() {
+			args += " -map 0:a?"
+		}
+	}
+
 	if c.audioEnabled() {
 		args += " -c:a " + c.AudioEncoder()
 	} else {
@@ -604,3 +988,43 @@ func (i *InputProcess) generateArgs() string {
 
 	return args
 }
+
+// ErrPrivacyMaskCopy privacy mask cannot be used with stream copy.
+var ErrPrivacyMaskCopy = errors.New("video encoder cannot be 'copy' when privacy mask is enabled")
+
+// Resolution of the privacy mask image, it's scaled to the video size by FFmpeg.
+const privacyMaskSize = 1000
+
+// writePrivacyMask saves the privacy mask image to
+// the temporary directory and returns the path.
+func (i *InputProcess) writePrivacyMask() (string, error) {
+	areas, err := i.Config.PrivacyMask()
+	if err != nil || areas == nil {
+		return "", err
+	}
+	if i.Config.VideoEncoder() == "copy" && i.ingest == nil {
+		return "", ErrPrivacyMaskCopy
+	}
+
+	dir := filepath.Join(i.Env.TempDir, "privacymask")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, i.rtspPathName()+".png")
+	if err := ffmpeg.SaveImage(path, privacyMaskImage(areas)); err != nil {
+		return "", fmt.Errorf("save image: %w", err)
+	}
+	return path, nil
+}
+
+// privacyMaskImage returns a image where the areas are
+// black and everything else is transparent.
+func privacyMaskImage(areas []ffmpeg.Polygon) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, privacyMaskSize, privacyMaskSize))
+	for _, area := range areas {
+		mask := ffmpeg.CreateMask(privacyMaskSize, privacyMaskSize, area.ToAbs(privacyMaskSize, privacyMaskSize))
+		draw.DrawMask(img, img.Bounds(), image.Black, image.Point{}, mask, image.Point{}, draw.Over)
+	}
+	return img
+}
@@ -8,8 +8,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"nvr/pkg/ffmpeg"
 	"nvr/pkg/log"
+	"nvr/pkg/metrics"
 	"nvr/pkg/storage"
 	"nvr/pkg/video/customformat"
 	"nvr/pkg/video/gortsplib"
@@ -41,21 +43,61 @@ type Recorder struct {
 	wg     *sync.WaitGroup
 	hooks  Hooks
 
+	// Returns the recordings directory for a new recording.
+	recordingsDir func() string
+
 	sleep   time.Duration
 	prevSeg *hls.Segment
+
+	bytesWritten *metrics.Counter
+
+	// Recording that reached the video length and is waiting
+	// for the ID of the next recording before being saved.
+	rollover *recording
+
+	// End of the current trigger, guarded by eventsLock.
+	triggerEnd time.Time
+
+	// Only used by start, guarded by eventsLock for Status.
+	timer eventTimer
+
+	// ID of the current recording, empty if the recorder isn't
+	// recording. Closed and cleared when the ID changes.
+	// Both are guarded by eventsLock.
+	recordingID        string
+	recordingIDChanged chan struct{}
 }
 
+// recording is a generated recording that hasn't been saved.
+type recording struct {
+	filePath  string
+	startTime time.Time
+	endTime   time.Time
+	prev      string // ID of the previous recording.
+	checksums *storage.Checksums
+
+	clockCorrections []storage.ClockCorrection
+}
+
+// Recordings are not split if the trigger ends within this
+// duration, this prevents short tail recordings.
+const minRolloverTail = 10 * time.Second
+
 func newRecorder(m *Monitor) *Recorder {
 	monitorID := m.Config.ID()
 	logf := func(level log.Level, format string, a ...interface{}) {
-		msg := fmt.Sprintf(format, a...)
+		msg, fields := log.Sprintf(format, a...)
 		m.Logger.Log(log.Entry{
 			Level:     level,
 			Src:       "recorder",
 			MonitorID: monitorID,
-			Msg:       m.Env.CensorLog(msg),
-		})
+			Msg:       msg,
+			Fields:    fields,
+		}.Censor(m.Env.CensorLog))
 	}
+	// The config is validated before the monitor starts.
+	timerConfig, _ := m.Config.eventTimerConfig()
+
 	return &Recorder{
 		Config: m.Config,
 
@@ -65,7 +107,7 @@ func newRecorder(m *Monitor) *Recorder {
 
 		logf:       logf,
 		runSession: runRecording,
-		NewProcess: ffmpeg.NewProcess,
+		NewProcess: m.NewProcess,
 
 		input:  m.mainInput,
 		Env:    m.Env,
@@ -73,11 +115,17 @@ func newRecorder(m *Monitor) *Recorder {
 		wg:     &m.WG,
 		hooks:  m.hooks,
 
+		recordingsDir: m.recordingsDir,
+
 		sleep: 3 * time.Second,
+
+		bytesWritten: m.recordingBytes,
+
+		timer: eventTimer{config: timerConfig},
 	}
 }
 
-func (r *Recorder) start(ctx context.Context) {
+func (r *Recorder) start(ctx context.Context) { //nolint:funlen
 	defer r.wg.Done()
 
 	var sessionCtx context.Context
@@ -86,7 +134,19 @@ func (r *Recorder) start(ctx context.Context) {
 	triggerTimer := &time.Timer{}
 	onSessionExit := make(chan struct{})
 
-	var timerEnd time.Time
+	// Start a new session when the current one exits.
+	restart := false
+
+	startSession := func() {
+		r.logf(log.LevelDebug, "starting recording session")
+		isRecording = true
+		sessionCtx, cancelSession = context.WithCancel(ctx)
+		go func() {
+			r.runRecordingSession(sessionCtx)
+			onSessionExit <- struct{}{}
+		}()
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -103,47 +163,71 @@ func (r *Recorder) start(ctx context.Context) {
 			r.hooks.Event(r, &event)
 			r.eventsLock.Lock()
 			*r.events = append(*r.events, event)
+			action := r.timer.event(event)
+			r.triggerEnd = r.timer.end
+			timerEnd := r.timer.end
 			r.eventsLock.Unlock()
 
-			end := event.Time.Add(event.RecDuration)
-			if end.After(timerEnd) {
-				timerEnd = end
-			}
-
-			if isRecording {
+			triggerTimer = time.NewTimer(time.Until(timerEnd))
+			switch action {
+			case eventActionStart:
+				startSession()
+			case eventActionExtend:
 				r.logf(log.LevelDebug, "new event, already recording, updating timer")
-				triggerTimer = time.NewTimer(time.Until(timerEnd))
-				continue
+			case eventActionRestart:
+				r.logf(log.LevelDebug, "new event after the max event gap or"+
+					" max total duration, starting new recording")
+				restart = true
+				cancelSession()
 			}
 
-			r.logf(log.LevelDebug, "starting recording session")
-			isRecording = true
-			triggerTimer = time.NewTimer(time.Until(timerEnd))
-			sessionCtx, cancelSession = context.WithCancel(ctx)
-			go func() {
-				r.runRecordingSession(sessionCtx)
-				onSessionExit <- struct{}{}
-			}()
-
 		case <-triggerTimer.C:
 			r.logf(log.LevelDebug, "timer reached end, canceling session")
+			r.eventsLock.Lock()
+			r.timer.timerEnded()
+			r.eventsLock.Unlock()
+			restart = false
 			cancelSession()
 
 		case <-onSessionExit:
 			// Recording was canceled and stopped.
+			if restart {
+				restart = false
+				startSession()
+				continue
+			}
+			r.eventsLock.Lock()
+			r.timer.sessionStopped()
+			r.eventsLock.Unlock()
 			isRecording = false
-			continue
 		}
 	}
 }
 
+// Status returns the event timer state.
+func (r *Recorder) Status() RecorderStatus {
+	r.eventsLock.Lock()
+	defer r.eventsLock.Unlock()
+	status := r.timer.status()
+	status.RecordingID = r.recordingID
+	return status
+}
+
 func (r *Recorder) runRecordingSession(ctx context.Context) {
 	defer r.logf(log.LevelDebug, "session stopped")
+	defer r.setRecordingID("")
+	defer func() {
+		// Save the last recording if the session
+		// was canceled during rollover.
+		if rec := r.takeRollover(); rec != nil {
+			go r.saveRecording(*rec, "")
+		}
+	}()
 	for {
 		err := r.runSession(ctx, r)
 		if err != nil {
 			if !errors.Is(err, context.Canceled) {
-				r.logf(log.LevelError, "recording crashed: %v", err)
+				r.logf(log.LevelError, "recording crashed", log.Err(err))
 			}
 			select {
 			case <-ctx.Done():
@@ -167,7 +251,15 @@ func (r *Recorder) runRecordingSession(ctx context.Context) {
 
 type runRecordingFunc func(context.Context, *Recorder) error
 
-func runRecording(ctx context.Context, r *Recorder) error {
+func runRecording(ctx context.Context, r *Recorder) error { //nolint:funlen
+	// Previous recording if this is a continuation.
+	prev := r.takeRollover()
+	defer func() {
+		if prev != nil {
+			go r.saveRecording(*prev, "")
+		}
+	}()
+
 	timestampOffsetInt, err := strconv.Atoi(r.Config.TimestampOffset())
 	if err != nil {
 		return fmt.Errorf("parse timestamp offset %w", err)
@@ -184,17 +276,15 @@ func runRecording(ctx context.Context, r *Recorder) error {
 	}
 
 	offset := 0 + time.Duration(timestampOffsetInt)*time.Millisecond
-	startTime := firstSegment.StartTime.Add(-offset)
+	startTime := firstSegment.StartTime.Add(-offset).UTC()
 
 	monitorID := r.Config.ID()
-	fileDir := filepath.Join(
-		r.Env.RecordingsDir(),
-		startTime.Format("2006/01/02/")+monitorID,
-	)
-	filePath := filepath.Join(
-		fileDir,
-		startTime.Format("2006-01-02_15-04-05_")+monitorID,
-	)
+	recPath, err := storage.RecordingIDToPath(storage.NewRecordingID(startTime, monitorID))
+	if err != nil {
+		return fmt.Errorf("recording path: %w", err)
+	}
+	filePath := filepath.Join(r.recordingsDir(), recPath)
+	fileDir := filepath.Dir(filePath)
 	basePath := filepath.Base(filePath)
 
 	err = os.MkdirAll(fileDir, 0o755)
@@ -202,37 +292,141 @@ func runRecording(ctx context.Context, r *Recorder) error {
 		return fmt.Errorf("make directory for video: %w", err)
 	}
 
-	videoLengthStr := r.Config.videoLength()
-	videoLengthFloat, err := strconv.ParseFloat(videoLengthStr, 64)
+	videoLength, err := r.Config.VideoLength()
 	if err != nil {
-		return fmt.Errorf("parse video length: %w", err)
+		return fmt.Errorf("video length: %w", err)
 	}
-	videoLength := time.Duration(videoLengthFloat * float64(time.Minute))
 
-	r.logf(log.LevelInfo, "starting recording: %v", basePath)
+	r.logf(log.LevelInfo, "starting recording: %v", basePath, log.F("recordingID", basePath))
+	r.setRecordingID(basePath)
+
+	rec := recording{
+		filePath:  filePath,
+		startTime: startTime,
+	}
+	if prev != nil {
+		rec.prev = filepath.Base(prev.filePath)
+		go r.saveRecording(*prev, basePath)
+		prev = nil
+	}
 
-	videoTrack := muxer.VideoTrack()
+	videoTrack := segmentVideoTrack(muxer.VideoTrack(), firstSegment)
 	audioTrack := muxer.AudioTrack()
 	go r.generateThumbnail(filePath, firstSegment, videoTrack)
 
-	prevSeg, endTime, err := generateVideo(
-		ctx, filePath, muxer.NextSegment, firstSegment, videoTrack, audioTrack, videoLength)
+	prevSeg, times, checksums, err := generateVideo(
+		ctx,
+		filePath,
+		muxer.NextSegment,
+		firstSegment,
+		videoTrack,
+		audioTrack,
+		videoLength,
+		r.getTriggerEnd,
+		r.bytesWritten,
+	)
 	if err != nil {
 		return fmt.Errorf("write video: %w", err)
 	}
 	r.prevSeg = prevSeg
-	r.logf(log.LevelInfo, "video generated: %v", basePath)
+	r.logf(log.LevelInfo, "video generated: %v", basePath, log.F("recordingID", basePath))
+
+	for _, c := range times.corrections {
+		r.logf(log.LevelWarning, "clock stepped %v at %v into recording %v, re-anchoring",
+			c.Step, c.Offset, basePath, log.F("recordingID", basePath))
+	}
 
-	go r.saveRecording(filePath, startTime, *endTime)
+	rec.startTime = times.start.Add(-offset).UTC()
+	rec.endTime = times.end
+	rec.clockCorrections = times.corrections
+	rec.checksums = checksums
+	if ctx.Err() == nil {
+		// Reached video length, the next recording will save this one.
+		r.eventsLock.Lock()
+		r.rollover = &rec
+		r.eventsLock.Unlock()
+		return nil
+	}
+
+	go r.saveRecording(rec, "")
 
 	return nil
 }
 
+func (r *Recorder) takeRollover() *recording {
+	r.eventsLock.Lock()
+	defer r.eventsLock.Unlock()
+	rec := r.rollover
+	r.rollover = nil
+	return rec
+}
+
+func (r *Recorder) getTriggerEnd() time.Time {
+	r.eventsLock.Lock()
+	defer r.eventsLock.Unlock()
+	return r.triggerEnd
+}
+
+func (r *Recorder) setRecordingID(id string) {
+	r.eventsLock.Lock()
+	defer r.eventsLock.Unlock()
+	r.recordingID = id
+	if r.recordingIDChanged != nil {
+		close(r.recordingIDChanged)
+		r.recordingIDChanged = nil
+	}
+}
+
+// waitForRecordingID returns the ID of the current recording. Waits
+// for the recording to start if the recorder isn't recording, returns
+// a empty string if the context is canceled before that.
+func (r *Recorder) waitForRecordingID(ctx context.Context) string {
+	for {
+		r.eventsLock.Lock()
+		if r.recordingID != "" {
+			defer r.eventsLock.Unlock()
+			return r.recordingID
+		}
+		if r.recordingIDChanged == nil {
+			r.recordingIDChanged = make(chan struct{})
+		}
+		changed := r.recordingIDChanged
+		r.eventsLock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ""
+		case <-changed:
+		}
+	}
+}
+
+// RecordingID returns the ID of the current recording,
+// empty if the recorder isn't recording.
+func (r *Recorder) RecordingID() string {
+	r.eventsLock.Lock()
+	defer r.eventsLock.Unlock()
+	return r.recordingID
+}
+
 // ErrSkippedSegment skipped segment.
 var ErrSkippedSegment = errors.New("skipped segment")
 
 type nextSegmentFunc func(*hls.Segment) (*hls.Segment, error)
 
+// videoTimes are the wall clock times of a generated video.
+type videoTimes struct {
+	start       time.Time
+	end         time.Time
+	corrections []storage.ClockCorrection
+}
+
+// generateVideo writes segments until the video reaches maxDuration.
+// The segment durations are based on the stream timestamps and are
+// used for everything that measures elapsed time, the wall clock is
+// only used to label the video. If the wall clock steps during the
+// recording, the start time is re-anchored to the new wall clock and
+// a correction is recorded instead of trusting the raw difference.
 func generateVideo( //nolint:funlen
 	ctx context.Context,
 	filePath string,
@@ -241,24 +435,28 @@ func generateVideo( //nolint:funlen
 	videoTrack *gortsplib.TrackH264,
 	audioTrack *gortsplib.TrackMPEG4Audio,
 	maxDuration time.Duration,
-) (*hls.Segment, *time.Time, error) {
+	triggerEnd func() time.Time,
+	bytesWritten *metrics.Counter,
+) (*hls.Segment, *videoTimes, *storage.Checksums, error) {
 	prevSeg := firstSegment
-	startTime := firstSegment.StartTime
-	stopTime := firstSegment.StartTime.Add(maxDuration)
-	endTime := startTime
+	times := &videoTimes{start: firstSegment.StartTime}
+
+	// Duration of the written segments.
+	var elapsed time.Duration
 
-	metaPath := filePath + ".meta"
-	mdatPath := filePath + ".mdat"
+	// Published by saveRecording.
+	metaPath := filePath + ".meta" + storage.ActiveSuffix
+	mdatPath := filePath + ".mdat" + storage.ActiveSuffix
 
 	meta, err := os.OpenFile(metaPath, os.O_CREATE|os.O_WRONLY, 0o600)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	defer meta.Close()
 
 	mdat, err := os.OpenFile(mdatPath, os.O_CREATE|os.O_WRONLY, 0o600)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	defer mdat.Close()
 
@@ -266,7 +464,7 @@ func generateVideo( //nolint:funlen
 	if audioTrack != nil {
 		audioConfig, err = audioTrack.Config.Marshal()
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 	}
 
@@ -274,52 +472,115 @@ func generateVideo( //nolint:funlen
 		VideoSPS:    videoTrack.SPS,
 		VideoPPS:    videoTrack.PPS,
 		AudioConfig: audioConfig,
-		StartTime:   startTime.UnixNano(),
+		StartTime:   firstSegment.StartTime.UnixNano(),
 	}
 
-	w, err := customformat.NewWriter(meta, mdat, header)
+	metaSums := storage.NewChecksumWriter(countingWriter{meta, bytesWritten})
+	mdatSums := storage.NewChecksumWriter(countingWriter{mdat, bytesWritten})
+	done := func() (*hls.Segment, *videoTimes, *storage.Checksums, error) {
+		checksums := storage.NewChecksums()
+		checksums.Files["meta"] = metaSums.Sums()
+		checksums.Files["mdat"] = mdatSums.Sums()
+		times.end = times.start.Add(elapsed)
+		return prevSeg, times, checksums, nil
+	}
+
+	w, err := customformat.NewWriter(metaSums, mdatSums, header)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	writeSegment := func(seg *hls.Segment) error {
 		if err := w.WriteSegment(seg); err != nil {
 			return err
 		}
+		step := seg.StartTime.Sub(times.start.Add(elapsed))
+		if step.Abs() >= storage.ClockStepThreshold {
+			times.start = times.start.Add(step)
+			times.corrections = append(times.corrections, storage.ClockCorrection{
+				Offset: elapsed,
+				Step:   step,
+			})
+		}
 		prevSeg = seg
-		endTime = seg.StartTime.Add(seg.RenderedDuration)
+		elapsed += seg.RenderedDuration
 		return nil
 	}
 
 	if err := writeSegment(firstSegment); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	for {
 		if ctx.Err() != nil {
-			return prevSeg, &endTime, nil
+			return done()
 		}
 
 		seg, err := nextSegment(prevSeg)
 		if err != nil {
-			return prevSeg, &endTime, nil
+			return done()
 		}
 
 		if seg.ID != prevSeg.ID+1 {
-			return nil, nil, fmt.Errorf("%w: expected: %v got %v",
+			return nil, nil, nil, fmt.Errorf("%w: expected: %v got %v",
 				ErrSkippedSegment, prevSeg.ID+1, seg.ID)
 		}
 
+		// The video parameters changed, the next
+		// recording will start with this segment.
+		if segmentParamsChanged(videoTrack, seg) {
+			return done()
+		}
+
+		segOffset := elapsed
 		if err := writeSegment(seg); err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 
-		if seg.StartTime.After(stopTime) {
-			return prevSeg, &endTime, nil
+		segStart := times.start.Add(segOffset)
+		if segOffset > maxDuration &&
+			triggerEnd().Sub(segStart) >= minRolloverTail {
+			return done()
 		}
 	}
 }
 
+// countingWriter adds the written bytes to the counter.
+type countingWriter struct {
+	w       io.Writer
+	counter *metrics.Counter
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.counter.Add(float64(n))
+	return n, err
+}
+
+// segmentVideoTrack returns the video track with the parameter
+// sets that the segment was muxed with. The camera may have
+// changed them since the track was created.
+func segmentVideoTrack(videoTrack *gortsplib.TrackH264, seg *hls.Segment) *gortsplib.TrackH264 {
+	if seg.VideoSPS == nil || seg.VideoPPS == nil {
+		return videoTrack
+	}
+	return &gortsplib.TrackH264{
+		PayloadType:       videoTrack.PayloadType,
+		SPS:               seg.VideoSPS,
+		PPS:               seg.VideoPPS,
+		PacketizationMode: videoTrack.PacketizationMode,
+		RTPClockRate:      videoTrack.RTPClockRate,
+	}
+}
+
+func segmentParamsChanged(videoTrack *gortsplib.TrackH264, seg *hls.Segment) bool {
+	if seg.VideoSPS == nil || seg.VideoPPS == nil {
+		return false
+	}
+	return !bytes.Equal(videoTrack.SPS, seg.VideoSPS) ||
+		!bytes.Equal(videoTrack.PPS, seg.VideoPPS)
+}
+
 // The first h264 frame in firstSegment is wrapped in a mp4
 // container and piped into FFmpeg and then converted to jpeg.
 func (r *Recorder) generateThumbnail(
@@ -363,43 +624,58 @@ func (r *Recorder) generateThumbnail(
 	r.logf(log.LevelDebug, "thumbnail generated: %v", filepath.Base(thumbPath))
 }
 
-func (r *Recorder) saveRecording(
-	filePath string,
-	startTime time.Time,
-	endTime time.Time,
-) {
-	r.logf(log.LevelInfo, "saving recording: %v", filepath.Base(filePath))
+// saveRecording writes the data file and publishes the video files.
+// Next is the ID of the following recording if there's one.
+func (r *Recorder) saveRecording(rec recording, next string) {
+	filePath := rec.filePath
+	recID := log.F("recordingID", filepath.Base(filePath))
+	r.logf(log.LevelInfo, "saving recording: %v", filepath.Base(filePath), recID)
 
 	r.eventsLock.Lock()
-	events := r.events.QueryAndPrune(startTime, endTime)
+	events := r.events.QueryAndPrune(rec.startTime, rec.endTime)
 	r.eventsLock.Unlock()
 
 	data := storage.RecordingData{
-		Start:  startTime,
-		End:    endTime,
-		Events: events,
+		Start:     rec.startTime,
+		End:       rec.endTime,
+		Events:    events,
+		Previous:  rec.prev,
+		Next:      next,
+		TimeZone:  r.Config.TimeZone(),
+		Checksums: rec.checksums,
+
+		ClockCorrections: rec.clockCorrections,
 	}
 	json, err := json.MarshalIndent(data, "", "    ")
 	if err != nil {
-		r.logf(log.LevelError, "marshal event data: %w", err)
+		r.logf(log.LevelError, "marshal event data", recID, log.Err(err))
 		return
 	}
 
 	dataPath := filePath + ".json"
 	if err := os.WriteFile(dataPath, json, 0o600); err != nil {
-		r.logf(log.LevelError, "write event data: %v", err)
+		r.logf(log.LevelError, "write event data", recID, log.Err(err))
+		return
+	}
+
+	// The video is complete when it gets its final name.
+	if err := storage.PublishRecording(filePath); err != nil {
+		r.logf(log.LevelError, "publish recording", recID, log.Err(err))
 		return
 	}
 
 	go r.hooks.RecSaved(r, filePath, data)
 
-	r.logf(log.LevelInfo, "recording saved: %v", filepath.Base(dataPath))
+	r.logf(log.LevelInfo, "recording saved: %v", filepath.Base(dataPath), recID, log.F("path", dataPath))
 }
 
 func (r *Recorder) sendEvent(ctx context.Context, event storage.Event) error {
 	if err := event.Validate(); err != nil {
 		return fmt.Errorf("invalid event: %w", err)
 	}
+	// Detections keep their source if several
+	// events from different triggers are merged.
+	event = event.WithDetectionSources()
 	select {
 	case <-ctx.Done():
 		return context.Canceled
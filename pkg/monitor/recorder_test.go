@@ -4,8 +4,11 @@ package monitor
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,6 +18,7 @@ import (
 	"nvr/pkg/ffmpeg"
 	"nvr/pkg/ffmpeg/ffmock"
 	"nvr/pkg/log"
+	"nvr/pkg/metrics"
 	"nvr/pkg/storage"
 	"nvr/pkg/video"
 	"nvr/pkg/video/gortsplib"
@@ -65,7 +69,13 @@ func newTestRecorder(t *testing.T) *Recorder {
 			TempDir:    tempDir,
 			StorageDir: tempDir,
 		},
+		recordingsDir: func() string {
+			return filepath.Join(tempDir, "recordings")
+		},
 		hooks: stubHooks(),
+
+		// Far enough to not affect rollover.
+		triggerEnd: time.Now().Add(time.Hour),
 	}
 }
 
@@ -92,8 +102,9 @@ func (m *mockMuxer) AudioTrack() *gortsplib.TrackMPEG4Audio {
 
 func (m *mockMuxer) NextSegment(_ *hls.Segment) (*hls.Segment, error) {
 	seg := &hls.Segment{
-		ID:        uint64(m.segCount),
-		StartTime: time.Unix(1*int64(m.segCount), 0),
+		ID:               uint64(m.segCount),
+		StartTime:        time.Unix(1*int64(m.segCount), 0),
+		RenderedDuration: time.Second,
 	}
 	m.segCount++
 	return seg, nil
@@ -296,6 +307,64 @@ func TestStartRecorder(t *testing.T) {
 		<-onRunRecording
 		<-onRunRecording
 	})
+	// Events that exceed the gap or total duration end
+	// the recording and start a new one.
+	splitTests := map[string]struct {
+		config eventTimerConfig
+		second time.Duration
+	}{
+		"maxEventGap": {
+			eventTimerConfig{maxEventGap: time.Minute},
+			2 * time.Minute,
+		},
+		"maxTotalDuration": {
+			eventTimerConfig{maxTotalDuration: time.Hour},
+			time.Hour,
+		},
+	}
+	for name, tc := range splitTests {
+		t.Run(name, func(t *testing.T) {
+			sessions := make(chan string)
+			mockRunRecording := func(ctx context.Context, _ *Recorder) error {
+				sessions <- "start"
+				<-ctx.Done()
+				sessions <- "stop"
+				return ctx.Err()
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			r := newTestRecorder(t)
+			r.timer.config = tc.config
+			r.wg.Add(1)
+			r.runSession = mockRunRecording
+			go r.start(ctx)
+
+			now := time.Now()
+			r.eventChan <- storage.Event{Time: now, RecDuration: 2 * time.Hour}
+			require.Equal(t, "start", <-sessions)
+
+			// Within limits.
+			r.eventChan <- storage.Event{
+				Time:        now.Add(30 * time.Second),
+				RecDuration: 2 * time.Hour,
+			}
+			r.eventChan <- storage.Event{
+				Time:        now.Add(30*time.Second + tc.second),
+				RecDuration: 2 * time.Hour,
+			}
+			require.Equal(t, "stop", <-sessions)
+			require.Equal(t, "start", <-sessions)
+
+			status := r.Status()
+			require.True(t, status.Recording)
+			require.Equal(t, now.Add(30*time.Second+tc.second), *status.Start)
+
+			cancel()
+			require.Equal(t, "stop", <-sessions)
+		})
+	}
 }
 
 func createTempDir(t *testing.T, r *Recorder) {
@@ -316,14 +385,14 @@ func TestRunRecording(t *testing.T) {
 	})
 	t.Run("crashed", func(t *testing.T) {
 		r := newTestRecorder(t)
-		r.Env.StorageDir = "/dev/null"
+		r.recordingsDir = func() string { return "/dev/null/recordings" }
 
 		err := runRecording(context.Background(), r)
 		require.Error(t, err)
 	})
 	t.Run("mkdirErr", func(t *testing.T) {
 		r := newTestRecorder(t)
-		r.Env.StorageDir = "/dev/null"
+		r.recordingsDir = func() string { return "/dev/null/recordings" }
 
 		err := runRecording(context.Background(), r)
 		require.Error(t, err)
@@ -333,7 +402,7 @@ func TestRunRecording(t *testing.T) {
 		r.Config.v["videoLength"] = ""
 
 		err := runRecording(context.Background(), r)
-		require.ErrorIs(t, err, strconv.ErrSyntax)
+		require.ErrorIs(t, err, ErrConfigKeyAbsent)
 	})
 	t.Run("parseOffsetErr", func(t *testing.T) {
 		r := newTestRecorder(t)
@@ -344,6 +413,231 @@ func TestRunRecording(t *testing.T) {
 	})
 }
 
+func TestGenerateVideoRollover(t *testing.T) {
+	newSegments := func(n int) nextSegmentFunc {
+		return func(prev *hls.Segment) (*hls.Segment, error) {
+			if int(prev.ID) >= n {
+				return nil, errors.New("mock")
+			}
+			return &hls.Segment{
+				ID:               prev.ID + 1,
+				StartTime:        time.Unix(int64(prev.ID+1), 0),
+				RenderedDuration: time.Second,
+			}, nil
+		}
+	}
+	videoTrack := &gortsplib.TrackH264{SPS: []byte{0, 0, 0}}
+	firstSegment := &hls.Segment{StartTime: time.Unix(0, 0), RenderedDuration: time.Second}
+
+	cases := map[string]struct {
+		triggerEnd time.Time
+		expected   uint64
+	}{
+		// Segment 3 is the first to start after the 2 second limit.
+		"rollover":  {time.Unix(3+10, 0), 3},
+		"finalTail": {time.Unix(3+9, 0), 5},
+		"ended":     {time.Unix(0, 0), 5},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "x")
+			lastSeg, times, _, err := generateVideo(
+				context.Background(),
+				filePath,
+				newSegments(5),
+				firstSegment,
+				videoTrack,
+				nil,
+				2*time.Second,
+				func() time.Time { return tc.triggerEnd },
+				nil,
+			)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, lastSeg.ID)
+			require.Equal(t, time.Unix(int64(tc.expected)+1, 0), times.end)
+		})
+	}
+}
+
+// steppingClock labels each segment with the wall clock,
+// the wall clock steps before the segment with stepAt ID.
+type steppingClock struct {
+	wall   time.Time
+	stepAt uint64
+	step   time.Duration
+}
+
+func (c *steppingClock) nextSegment(n uint64) nextSegmentFunc {
+	return func(prev *hls.Segment) (*hls.Segment, error) {
+		if prev.ID >= n {
+			return nil, errors.New("mock")
+		}
+		c.wall = c.wall.Add(prev.RenderedDuration)
+		seg := &hls.Segment{
+			ID:               prev.ID + 1,
+			RenderedDuration: time.Second,
+		}
+		if seg.ID == c.stepAt {
+			c.wall = c.wall.Add(c.step)
+		}
+		seg.StartTime = c.wall
+		return seg, nil
+	}
+}
+
+func TestGenerateVideoClockStep(t *testing.T) {
+	cases := map[string]time.Duration{
+		"forward":  time.Hour,
+		"backward": -time.Hour,
+		"slew":     storage.ClockStepThreshold - time.Second,
+	}
+	for name, step := range cases {
+		t.Run(name, func(t *testing.T) {
+			clock := &steppingClock{wall: time.Unix(1000, 0), stepAt: 3, step: step}
+			firstSegment := &hls.Segment{StartTime: clock.wall, RenderedDuration: time.Second}
+
+			// The trigger ends 20 seconds after the step by the
+			// new clock, the video length is measured monotonic.
+			triggerEnd := time.Unix(1000+3+20, 0).Add(step)
+
+			lastSeg, times, _, err := generateVideo(
+				context.Background(),
+				filepath.Join(t.TempDir(), "x"),
+				clock.nextSegment(5),
+				firstSegment,
+				&gortsplib.TrackH264{SPS: []byte{0, 0, 0}},
+				nil,
+				4*time.Second,
+				func() time.Time { return triggerEnd },
+				nil,
+			)
+			require.NoError(t, err)
+			require.Equal(t, uint64(5), lastSeg.ID)
+
+			if step.Abs() < storage.ClockStepThreshold {
+				require.Nil(t, times.corrections)
+				require.Equal(t, time.Unix(1000, 0), times.start)
+				require.Equal(t, time.Unix(1006, 0), times.end)
+				return
+			}
+
+			// Re-anchored to the clock after the step.
+			expected := []storage.ClockCorrection{{Offset: 3 * time.Second, Step: step}}
+			require.Equal(t, expected, times.corrections)
+			require.Equal(t, time.Unix(1000, 0).Add(step), times.start)
+			require.Equal(t, 6*time.Second, times.end.Sub(times.start))
+		})
+	}
+}
+
+func TestGenerateVideoParamsChange(t *testing.T) {
+	sps1 := []byte{0x67, 1}
+	sps2 := []byte{0x67, 2}
+	pps := []byte{0x68, 1}
+
+	nextSegment := func(prev *hls.Segment) (*hls.Segment, error) {
+		seg := &hls.Segment{
+			ID:               prev.ID + 1,
+			StartTime:        time.Unix(int64(prev.ID+1), 0),
+			RenderedDuration: time.Second,
+			VideoSPS:         sps1,
+			VideoPPS:         pps,
+		}
+		if seg.ID >= 3 {
+			seg.VideoSPS = sps2
+		}
+		return seg, nil
+	}
+	videoTrack := &gortsplib.TrackH264{SPS: sps2, PPS: pps}
+	firstSegment := &hls.Segment{
+		StartTime:        time.Unix(0, 0),
+		RenderedDuration: time.Second,
+		VideoSPS:         sps1,
+		VideoPPS:         pps,
+	}
+
+	// The track has the current parameters, the
+	// recording must use the segment parameters.
+	track := segmentVideoTrack(videoTrack, firstSegment)
+	require.Equal(t, sps1, track.SPS)
+
+	filePath := filepath.Join(t.TempDir(), "x")
+	bytesWritten := &metrics.Counter{}
+	lastSeg, times, checksums, err := generateVideo(
+		context.Background(),
+		filePath,
+		nextSegment,
+		firstSegment,
+		track,
+		nil,
+		time.Hour,
+		func() time.Time { return time.Time{} },
+		bytesWritten,
+	)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), lastSeg.ID)
+	require.Equal(t, time.Unix(3, 0), times.end)
+
+	// The checksums match the written files.
+	var size int
+	for _, ext := range []string{"meta", "mdat"} {
+		b, err := os.ReadFile(filePath + "." + ext + storage.ActiveSuffix)
+		require.NoError(t, err)
+		w := storage.NewChecksumWriter(io.Discard)
+		_, err = w.Write(b)
+		require.NoError(t, err)
+		require.Equal(t, w.Sums(), checksums.Files[ext])
+		size += len(b)
+	}
+	require.Equal(t, float64(size), bytesWritten.Value())
+}
+
+func TestRunRecordingRollover(t *testing.T) {
+	r := newTestRecorder(t)
+	r.NewProcess = ffmock.NewProcessNil
+
+	readData := func(filePath string) storage.RecordingData {
+		var data storage.RecordingData
+		require.Eventually(t, func() bool {
+			b, err := os.ReadFile(filePath + ".json")
+			return err == nil && json.Unmarshal(b, &data) == nil
+		}, time.Second, time.Millisecond)
+		return data
+	}
+
+	// First recording reaches the video length and is not saved yet.
+	require.NoError(t, runRecording(context.Background(), r))
+	first := r.rollover
+	require.NotNil(t, first)
+	require.Empty(t, first.prev)
+
+	// Second recording saves the first.
+	require.NoError(t, runRecording(context.Background(), r))
+	second := r.rollover
+	require.NotNil(t, second)
+	require.Equal(t, filepath.Base(first.filePath), second.prev)
+	require.Equal(t, filepath.Base(second.filePath), r.waitForRecordingID(context.Background()))
+
+	data := readData(first.filePath)
+	require.Empty(t, data.Previous)
+	require.Equal(t, filepath.Base(second.filePath), data.Next)
+
+	// Canceled recording is saved immediately.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, runRecording(ctx, r))
+	require.Nil(t, r.rollover)
+
+	data = readData(second.filePath)
+	require.Equal(t, filepath.Base(first.filePath), data.Previous)
+	require.NotEmpty(t, data.Next)
+	require.NotEqual(t, filepath.Base(second.filePath), data.Next)
+
+	third := readData(filepath.Join(filepath.Dir(second.filePath), data.Next))
+	require.Equal(t, filepath.Base(second.filePath), third.Previous)
+	require.Empty(t, third.Next)
+}
+
 func TestWriteThumbnail(t *testing.T) {
 	/*t.Run("ok", func(t *testing.T) {
 		r := newTestRecorder(t)
@@ -435,8 +729,15 @@ func TestSaveRecording(t *testing.T) {
 		end := time.Time{}.Add(11 * time.Minute)
 		tempdir := r.Env.TempDir
 		filePath := tempdir + "file"
+		for _, ext := range []string{".meta", ".mdat"} {
+			require.NoError(t, os.WriteFile(filePath+ext+storage.ActiveSuffix, nil, 0o600))
+		}
+
+		r.saveRecording(recording{filePath: filePath, startTime: start, endTime: end}, "")
 
-		r.saveRecording(filePath, start, end)
+		// Published after the data file is written.
+		require.FileExists(t, filePath+".meta")
+		require.FileExists(t, filePath+".mdat")
 
 		b, err := os.ReadFile(filePath + ".json")
 		require.NoError(t, err)
@@ -453,3 +754,59 @@ func TestSaveRecording(t *testing.T) {
 		require.Equal(t, actual, expected)
 	})
 }
+
+func TestSendEventSources(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := newTestRecorder(t)
+	start := time.Time{}.Add(1 * time.Minute)
+	events := []storage.Event{
+		{
+			Time:        start.Add(1 * time.Minute),
+			Detections:  []storage.Detection{{Label: "person"}},
+			RecDuration: 1,
+			Source:      "doods",
+		},
+		{
+			Time:        start.Add(2 * time.Minute),
+			Detections:  []storage.Detection{{Label: "door", Source: "sensor"}},
+			RecDuration: 1,
+			Source:      storage.EventSourceAPI,
+		},
+		{
+			Time:        start.Add(3 * time.Minute),
+			RecDuration: 1,
+		},
+	}
+	r.events = &storage.Events{}
+	go func() {
+		for _, event := range events {
+			require.NoError(t, r.sendEvent(ctx, event))
+		}
+	}()
+	for range events {
+		*r.events = append(*r.events, <-r.eventChan)
+	}
+
+	filePath := r.Env.TempDir + "file"
+	end := start.Add(10 * time.Minute)
+	r.saveRecording(recording{filePath: filePath, startTime: start, endTime: end}, "")
+
+	b, err := os.ReadFile(filePath + ".json")
+	require.NoError(t, err)
+
+	var data storage.RecordingData
+	require.NoError(t, json.Unmarshal(b, &data))
+	require.Len(t, data.Events, 3)
+
+	require.Equal(t, "doods", data.Events[0].Source)
+	require.Equal(t, "doods", data.Events[0].Detections[0].Source)
+	require.Equal(t, "api", data.Events[1].Source)
+	require.Equal(t, "sensor", data.Events[1].Detections[0].Source)
+	require.Empty(t, data.Events[2].Source)
+	require.Empty(t, data.Events[2].Detections)
+
+	// The caller's event isn't modified.
+	require.Empty(t, events[0].Detections[0].Source)
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"nvr/pkg/storage"
+	"sync"
+	"time"
+)
+
+// Errors.
+var (
+	ErrMonitorDisabled = errors.New("monitor is disabled")
+	ErrTriggerLimited  = errors.New("too many triggers")
+)
+
+// Each monitor can be triggered once per interval
+// on average with bursts of up to triggerBurst.
+const (
+	triggerInterval = 1 * time.Second
+	triggerBurst    = 5
+)
+
+// TriggerLimitError is returned when the monitor has been triggered too often.
+type TriggerLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e TriggerLimitError) Error() string {
+	return fmt.Sprintf("%v: retry after %v", ErrTriggerLimited, e.RetryAfter)
+}
+
+// Unwrap returns ErrTriggerLimited.
+func (e TriggerLimitError) Unwrap() error {
+	return ErrTriggerLimited
+}
+
+// triggerLimiter per monitor rate limiter. Uses the generic cell
+// rate algorithm, only the theoretical arrival time is stored.
+type triggerLimiter struct {
+	interval time.Duration
+	burst    int
+
+	mu  sync.Mutex
+	tat map[string]time.Time // map[monitorID]arrivalTime.
+}
+
+func newTriggerLimiter(interval time.Duration, burst int) *triggerLimiter {
+	return &triggerLimiter{
+		interval: interval,
+		burst:    burst,
+		tat:      make(map[string]time.Time),
+	}
+}
+
+// allow returns zero if the trigger is allowed, otherwise
+// the duration until the next trigger will be allowed.
+func (l *triggerLimiter) allow(monitorID string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tat := l.tat[monitorID]
+	if tat.Before(now) {
+		tat = now
+	}
+	allowAt := tat.Add(-time.Duration(l.burst-1) * l.interval)
+	if now.Before(allowAt) {
+		return allowAt.Sub(now)
+	}
+	l.tat[monitorID] = tat.Add(l.interval)
+	return 0
+}
+
+// TriggerEvent sends a external event to the monitor. The event starts
+// or extends a recording like a event from a detector. Returns the ID
+// of the current recording, the ID is empty if the recording didn't
+// start before the context was canceled.
+func (m *Manager) TriggerEvent(
+	ctx context.Context,
+	monitorID string,
+	event storage.Event,
+) (string, error) {
+	m.mu.Lock()
+	_, exist := m.rawConfigs[monitorID]
+	monitor, running := m.runningMonitors[monitorID]
+	m.mu.Unlock()
+
+	if !exist {
+		return "", ErrMonitorNotExist
+	}
+	if !running || !monitor.Config.enabled() {
+		return "", ErrMonitorDisabled
+	}
+
+	if retryAfter := m.triggerLimiter.allow(monitorID, time.Now()); retryAfter != 0 {
+		return "", TriggerLimitError{RetryAfter: retryAfter}
+	}
+
+	if err := monitor.SendEvent(event); err != nil {
+		return "", fmt.Errorf("send event: %w", err)
+	}
+	return monitor.recorder.waitForRecordingID(ctx), nil
+}
+
+// RecorderStatus returns the event timer state of the monitor's recorder.
+func (m *Manager) RecorderStatus(monitorID string) (RecorderStatus, error) {
+	m.mu.Lock()
+	_, exist := m.rawConfigs[monitorID]
+	monitor, running := m.runningMonitors[monitorID]
+	m.mu.Unlock()
+
+	if !exist {
+		return RecorderStatus{}, ErrMonitorNotExist
+	}
+	if !running || !monitor.Config.enabled() {
+		return RecorderStatus{}, ErrMonitorDisabled
+	}
+	return monitor.recorder.Status(), nil
+}
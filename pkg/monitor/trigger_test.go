@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTriggerLimiter(t *testing.T) {
+	l := newTriggerLimiter(time.Second, 2)
+	now := time.Unix(1000, 0)
+
+	require.Zero(t, l.allow("a", now))
+	require.Zero(t, l.allow("a", now))
+	require.Equal(t, time.Second, l.allow("a", now))
+	require.Equal(t, 500*time.Millisecond, l.allow("a", now.Add(500*time.Millisecond)))
+
+	// Other monitors are not affected.
+	require.Zero(t, l.allow("b", now))
+
+	require.Zero(t, l.allow("a", now.Add(time.Second)))
+	require.NotZero(t, l.allow("a", now.Add(time.Second)))
+
+	// Full burst after being idle.
+	later := now.Add(time.Minute)
+	require.Zero(t, l.allow("a", later))
+	require.Zero(t, l.allow("a", later))
+	require.NotZero(t, l.allow("a", later))
+}
+
+func newTestTriggerManager(t *testing.T, events chan storage.Event) (*Manager, chan struct{}) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	onRunRecording := make(chan struct{})
+	r := newTestRecorder(t)
+	r.hooks.Event = func(_ *Recorder, event *storage.Event) {
+		events <- *event
+	}
+	r.runSession = func(ctx context.Context, r *Recorder) error {
+		close(onRunRecording)
+		r.setRecordingID("2006-01-02_15-04-05_1")
+		<-ctx.Done()
+		return nil
+	}
+	r.wg.Add(1)
+	go r.start(ctx)
+
+	rawConf := RawConfig{"id": "1", "enable": "true"}
+	m := &Manager{
+		rawConfigs: RawConfigs{
+			"1": rawConf,
+			"2": RawConfig{"id": "2", "enable": "false"},
+		},
+		runningMonitors: monitors{
+			"1": {Config: NewConfig(rawConf), ctx: ctx, recorder: r},
+			"2": {Config: NewConfig(RawConfig{"id": "2", "enable": "false"})},
+		},
+		triggerLimiter: newTriggerLimiter(time.Hour, 1),
+	}
+	return m, onRunRecording
+}
+
+func TestTriggerEvent(t *testing.T) {
+	event := storage.Event{
+		Time:        time.Now(),
+		Detections:  []storage.Detection{{Label: "door", Score: 100}},
+		RecDuration: time.Hour,
+	}
+	t.Run("ok", func(t *testing.T) {
+		events := make(chan storage.Event, 1)
+		m, onRunRecording := newTestTriggerManager(t, events)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		recordingID, err := m.TriggerEvent(ctx, "1", event)
+		require.NoError(t, err)
+		require.Equal(t, "2006-01-02_15-04-05_1", recordingID)
+
+		<-onRunRecording
+		require.Equal(t, event, <-events)
+
+		// Rate limited.
+		_, err = m.TriggerEvent(ctx, "1", event)
+		require.ErrorIs(t, err, ErrTriggerLimited)
+		var limitErr TriggerLimitError
+		require.ErrorAs(t, err, &limitErr)
+		require.Greater(t, limitErr.RetryAfter, 59*time.Minute)
+	})
+	t.Run("notExist", func(t *testing.T) {
+		m, _ := newTestTriggerManager(t, nil)
+		_, err := m.TriggerEvent(context.Background(), "nil", event)
+		require.ErrorIs(t, err, ErrMonitorNotExist)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		m, _ := newTestTriggerManager(t, nil)
+		_, err := m.TriggerEvent(context.Background(), "2", event)
+		require.ErrorIs(t, err, ErrMonitorDisabled)
+	})
+	t.Run("notRunning", func(t *testing.T) {
+		m, _ := newTestTriggerManager(t, nil)
+		delete(m.runningMonitors, "1")
+		_, err := m.TriggerEvent(context.Background(), "1", event)
+		require.ErrorIs(t, err, ErrMonitorDisabled)
+	})
+}
+
+func TestWaitForRecordingID(t *testing.T) {
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.Equal(t, "", newTestRecorder(t).waitForRecordingID(ctx))
+	})
+	t.Run("wait", func(t *testing.T) {
+		r := newTestRecorder(t)
+		done := make(chan string)
+		go func() {
+			done <- r.waitForRecordingID(context.Background())
+		}()
+		time.Sleep(10 * time.Millisecond)
+		r.setRecordingID("a")
+		require.Equal(t, "a", <-done)
+
+		// Cleared when the session stops.
+		r.setRecordingID("")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.Equal(t, "", r.waitForRecordingID(ctx))
+	})
+}
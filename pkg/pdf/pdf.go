@@ -0,0 +1,341 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package pdf writes simple PDF documents with text, lines, JPEG
+// images and links. Only the standard Helvetica fonts are used, text
+// is encoded as WinAnsi and unsupported characters are replaced.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A4 page size in points.
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+// Font is one of the standard fonts.
+type Font int
+
+// Fonts.
+const (
+	Helvetica Font = iota
+	HelveticaBold
+)
+
+// Document is a PDF document. Coordinates are in points
+// from the top left corner of the page.
+type Document struct {
+	title  string
+	pages  []*Page
+	images []pdfImage
+}
+
+type pdfImage struct {
+	data       []byte
+	width      int
+	height     int
+	colorSpace string
+}
+
+// New returns an empty document.
+func New(title string) *Document {
+	return &Document{title: title}
+}
+
+// Page is a page of the document.
+type Page struct {
+	doc     *Document
+	content bytes.Buffer
+	images  []int
+	links   []link
+}
+
+type link struct {
+	rect [4]float64
+	uri  string
+}
+
+// AddPage adds a A4 page to the document.
+func (d *Document) AddPage() *Page {
+	p := &Page{doc: d}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Text draws a line of text, y is the baseline.
+func (p *Page) Text(x, y float64, font Font, size float64, text string) {
+	fmt.Fprintf(&p.content, "BT /F%d %s Tf %s %s Td (%s) Tj ET\n",
+		font+1, num(size), num(x), num(PageHeight-y), escapeText(text))
+}
+
+// Line draws a line between the two points.
+func (p *Page) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.content, "%s w %s %s m %s %s l S\n",
+		num(width), num(x1), num(PageHeight-y1), num(x2), num(PageHeight-y2))
+}
+
+// FillRect fills the rectangle with a gray level from 0 black to 1 white.
+func (p *Page) FillRect(x, y, w, h, gray float64) {
+	fmt.Fprintf(&p.content, "q %s g %s %s %s %s re f Q\n",
+		num(gray), num(x), num(PageHeight-y-h), num(w), num(h))
+}
+
+// Errors.
+var ErrUnsupportedImage = errors.New("unsupported image")
+
+// Image draws the JPEG image scaled to the rectangle.
+func (p *Page) Image(jpegData []byte, x, y, w, h float64) error {
+	config, err := jpeg.DecodeConfig(bytes.NewReader(jpegData))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnsupportedImage, err)
+	}
+
+	var colorSpace string
+	switch config.ColorModel {
+	case color.GrayModel:
+		colorSpace = "DeviceGray"
+	case color.YCbCrModel:
+		colorSpace = "DeviceRGB"
+	default:
+		return fmt.Errorf("%w: color model", ErrUnsupportedImage)
+	}
+
+	index := len(p.doc.images)
+	p.doc.images = append(p.doc.images, pdfImage{
+		data:       jpegData,
+		width:      config.Width,
+		height:     config.Height,
+		colorSpace: colorSpace,
+	})
+	p.images = append(p.images, index)
+
+	fmt.Fprintf(&p.content, "q %s 0 0 %s %s %s cm /Im%d Do Q\n",
+		num(w), num(h), num(x), num(PageHeight-y-h), index)
+	return nil
+}
+
+// Link makes the rectangle a link to the URI.
+func (p *Page) Link(x, y, w, h float64, uri string) {
+	p.links = append(p.links, link{
+		rect: [4]float64{x, PageHeight - y - h, x + w, PageHeight - y},
+		uri:  uri,
+	})
+}
+
+// WriteTo writes the document.
+func (d *Document) WriteTo(out io.Writer) (int64, error) {
+	w := &writer{}
+	w.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	// Fixed objects.
+	const (
+		catalogObj = 1
+		pagesObj   = 2
+		fontObj    = 3 // One per font.
+		infoObj    = 5
+		firstObj   = 6
+	)
+	next := firstObj
+
+	imageObjs := make([]int, len(d.images))
+	for i := range d.images {
+		imageObjs[i] = next
+		next++
+	}
+
+	pageObjs := make([]int, len(d.pages))
+	for i, p := range d.pages {
+		pageObjs[i] = next
+		next += 2 + len(p.links) // Page, content and annotations.
+	}
+
+	w.object(catalogObj, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesObj))
+
+	kids := make([]string, len(pageObjs))
+	for i, obj := range pageObjs {
+		kids[i] = ref(obj)
+	}
+	w.object(pagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>",
+		strings.Join(kids, " "), len(d.pages)))
+
+	for i, name := range []string{"Helvetica", "Helvetica-Bold"} {
+		w.object(fontObj+i, "<< /Type /Font /Subtype /Type1 /BaseFont /"+name+
+			" /Encoding /WinAnsiEncoding >>")
+	}
+	w.object(infoObj, fmt.Sprintf("<< /Title (%s) /Producer (OS-NVR) >>", escapeText(d.title)))
+
+	for i, img := range d.images {
+		w.stream(imageObjs[i], fmt.Sprintf(
+			"/Type /XObject /Subtype /Image /Width %d /Height %d"+
+				" /ColorSpace /%s /BitsPerComponent 8 /Filter /DCTDecode",
+			img.width, img.height, img.colorSpace), img.data)
+	}
+
+	for i, p := range d.pages {
+		pageObj := pageObjs[i]
+		contentObj := pageObj + 1
+
+		xObjects := ""
+		for _, index := range p.images {
+			xObjects += fmt.Sprintf(" /Im%d %s", index, ref(imageObjs[index]))
+		}
+		annots := make([]string, len(p.links))
+		for j := range p.links {
+			annots[j] = ref(contentObj + 1 + j)
+		}
+
+		w.object(pageObj, fmt.Sprintf(
+			"<< /Type /Page /Parent %s /MediaBox [0 0 %s %s] /Contents %s"+
+				" /Resources << /Font << /F1 %s /F2 %s >> /XObject <<%s >> >>"+
+				" /Annots [%s] >>",
+			ref(pagesObj), num(PageWidth), num(PageHeight), ref(contentObj),
+			ref(fontObj), ref(fontObj+1), xObjects, strings.Join(annots, " ")))
+
+		var content bytes.Buffer
+		zw := zlib.NewWriter(&content)
+		zw.Write(p.content.Bytes()) //nolint:errcheck
+		zw.Close()
+		w.stream(contentObj, "/Filter /FlateDecode", content.Bytes())
+
+		for j, l := range p.links {
+			w.object(contentObj+1+j, fmt.Sprintf(
+				"<< /Type /Annot /Subtype /Link /Rect [%s %s %s %s] /Border [0 0 0]"+
+					" /A << /S /URI /URI (%s) >> >>",
+				num(l.rect[0]), num(l.rect[1]), num(l.rect[2]), num(l.rect[3]),
+				escapeText(l.uri)))
+		}
+	}
+
+	// Cross-reference table.
+	xref := w.buf.Len()
+	w.printf("xref\n0 %d\n0000000000 65535 f \n", next)
+	for obj := 1; obj < next; obj++ {
+		w.printf("%010d 00000 n \n", w.offsets[obj])
+	}
+	w.printf("trailer\n<< /Size %d /Root %s /Info %s >>\nstartxref\n%d\n%%%%EOF\n",
+		next, ref(catalogObj), ref(infoObj), xref)
+
+	n, err := out.Write(w.buf.Bytes())
+	return int64(n), err
+}
+
+type writer struct {
+	buf     bytes.Buffer
+	offsets map[int]int
+}
+
+func (w *writer) printf(format string, a ...interface{}) {
+	fmt.Fprintf(&w.buf, format, a...)
+}
+
+func (w *writer) object(obj int, body string) {
+	w.begin(obj)
+	w.printf("%s\nendobj\n", body)
+}
+
+func (w *writer) stream(obj int, dict string, data []byte) {
+	w.begin(obj)
+	w.printf("<< %s /Length %d >>\nstream\n", dict, len(data))
+	w.buf.Write(data)
+	w.printf("\nendstream\nendobj\n")
+}
+
+func (w *writer) begin(obj int) {
+	if w.offsets == nil {
+		w.offsets = make(map[int]int)
+	}
+	w.offsets[obj] = w.buf.Len()
+	w.printf("%d 0 obj\n", obj)
+}
+
+func ref(obj int) string {
+	return strconv.Itoa(obj) + " 0 R"
+}
+
+func num(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// escapeText encodes the text as a WinAnsi string literal. Characters
+// in the C1 range and outside Latin-1 are replaced by a question mark.
+func escapeText(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		case r >= 160 && r < 256:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// TextWidth returns the width of the text in points.
+func TextWidth(text string, font Font, size float64) float64 {
+	widths := &helveticaWidths
+	if font == HelveticaBold {
+		widths = &helveticaBoldWidths
+	}
+	total := 0
+	for _, r := range text {
+		if r >= 32 && r < 127 {
+			total += widths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// Truncate shortens the text to fit the width, an ellipsis is
+// appended if the text was truncated. The ellipsis is three periods.
+func Truncate(text string, font Font, size float64, width float64) string {
+	if TextWidth(text, font, size) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		s := string(runes) + "..."
+		if TextWidth(s, font, size) <= width {
+			return s
+		}
+	}
+	return ""
+}
+
+// Character widths of the printable ASCII characters from the font metrics.
+var (
+	helveticaWidths = [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}
+	helveticaBoldWidths = [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+)
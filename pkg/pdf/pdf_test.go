@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/jpeg"
+	"io"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	require.NoError(t, jpeg.Encode(&b, img, nil))
+	return b.Bytes()
+}
+
+var xrefEntryRegexp = regexp.MustCompile(`(\d{10}) 00000 n `)
+
+// parseObjects validates the cross-reference table and returns the objects.
+func parseObjects(t *testing.T, doc []byte) map[int][]byte {
+	t.Helper()
+	require.True(t, bytes.HasPrefix(doc, []byte("%PDF-1.4\n")))
+	require.True(t, bytes.HasSuffix(doc, []byte("%%EOF\n")))
+
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(doc)
+	require.NotNil(t, m)
+	xref, err := strconv.Atoi(string(m[1]))
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(doc[xref:], []byte("xref\n")))
+
+	trailer := bytes.Index(doc, []byte("trailer\n"))
+	entries := xrefEntryRegexp.FindAllSubmatch(doc[xref:trailer], -1)
+
+	objects := make(map[int][]byte)
+	for i, entry := range entries {
+		obj := i + 1
+		offset, err := strconv.Atoi(string(entry[1]))
+		require.NoError(t, err)
+
+		header := strconv.Itoa(obj) + " 0 obj\n"
+		require.Equal(t, header, string(doc[offset:offset+len(header)]), obj)
+		end := bytes.Index(doc[offset:], []byte("endobj\n"))
+		require.NotEqual(t, -1, end)
+		objects[obj] = doc[offset+len(header) : offset+end]
+	}
+	require.Contains(t, string(doc[trailer:]), "/Size "+strconv.Itoa(len(entries)+1))
+	return objects
+}
+
+// streamData returns the data of a stream object.
+func streamData(t *testing.T, obj []byte) []byte {
+	t.Helper()
+	m := regexp.MustCompile(`/Length (\d+) >>\nstream\n`).FindSubmatchIndex(obj)
+	require.NotNil(t, m)
+	length, err := strconv.Atoi(string(obj[m[2]:m[3]]))
+	require.NoError(t, err)
+	data := obj[m[1] : m[1]+length]
+	require.Equal(t, "\nendstream\n", string(obj[m[1]+length:]))
+	return data
+}
+
+func TestDocument(t *testing.T) {
+	jpegData := testJPEG(t, image.NewRGBA(image.Rect(0, 0, 16, 8)))
+
+	d := New("Report (1)")
+	p1 := d.AddPage()
+	p1.Text(10, 20, HelveticaBold, 12, "Hello")
+	p1.Line(0, 30, 100, 30, 0.5)
+	p1.FillRect(0, 40, 100, 10, 0.9)
+	require.NoError(t, p1.Image(jpegData, 10, 50, 160, 80))
+	p1.Link(10, 150, 100, 12, "http://x/a(b)")
+	p2 := d.AddPage()
+	p2.Text(10, 20, Helvetica, 10, "Page 2")
+
+	var b bytes.Buffer
+	n, err := d.WriteTo(&b)
+	require.NoError(t, err)
+	require.Equal(t, int64(b.Len()), n)
+
+	objects := parseObjects(t, b.Bytes())
+	// Catalog, pages, 2 fonts, info, image, 2 pages with content and 1 link.
+	require.Len(t, objects, 11)
+	require.Contains(t, string(objects[2]), "/Kids [7 0 R 10 0 R] /Count 2")
+	require.Contains(t, string(objects[5]), `/Title (Report \(1\))`)
+
+	// Image.
+	require.Contains(t, string(objects[6]), "/Width 16 /Height 8 /ColorSpace /DeviceRGB")
+	require.Equal(t, jpegData, streamData(t, objects[6]))
+
+	// First page.
+	require.Contains(t, string(objects[7]), "/XObject << /Im0 6 0 R >>")
+	require.Contains(t, string(objects[7]), "/Annots [9 0 R]")
+	zr, err := zlib.NewReader(bytes.NewReader(streamData(t, objects[8])))
+	require.NoError(t, err)
+	content, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	require.Equal(t,
+		"BT /F2 12 Tf 10 821.89 Td (Hello) Tj ET\n"+
+			"0.5 w 0 811.89 m 100 811.89 l S\n"+
+			"q 0.9 g 0 791.89 100 10 re f Q\n"+
+			"q 160 0 0 80 10 711.89 cm /Im0 Do Q\n",
+		string(content))
+	require.Contains(t, string(objects[9]), "/Rect [10 679.89 110 691.89]")
+	require.Contains(t, string(objects[9]), `/URI (http://x/a\(b\))`)
+
+	// Second page.
+	require.Contains(t, string(objects[10]), "/Annots []")
+}
+
+func TestImage(t *testing.T) {
+	p := New("").AddPage()
+	require.ErrorIs(t, p.Image([]byte("png"), 0, 0, 1, 1), ErrUnsupportedImage)
+
+	gray := testJPEG(t, image.NewGray(image.Rect(0, 0, 1, 1)))
+	require.NoError(t, p.Image(gray, 0, 0, 1, 1))
+	require.Equal(t, "DeviceGray", p.doc.images[0].colorSpace)
+}
+
+func TestEscapeText(t *testing.T) {
+	cases := map[string]string{
+		"abc":        "abc",
+		`a(b)\c`:     `a\(b\)\\c`,
+		"caf\u00e9":  `caf\351`,
+		"\u00a0x":    `\240x`,
+		"a\nb":       "a?b",
+		"\u65e5":     "?",
+		"100 \u20ac": "100 ?",
+	}
+	for input, want := range cases {
+		require.Equal(t, want, escapeText(input), input)
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	require.Equal(t, 5.56, TextWidth("0", Helvetica, 10))
+	require.Equal(t, 22.78, TextWidth("Hello", Helvetica, 10))
+	require.Equal(t, 24.45, TextWidth("Hello", HelveticaBold, 10))
+	require.Equal(t, 5.56, TextWidth("\u00e9", Helvetica, 10))
+}
+
+func TestTruncate(t *testing.T) {
+	require.Equal(t, "Hello", Truncate("Hello", Helvetica, 10, 100))
+	require.Equal(t, "Hell...", Truncate("Hello world", Helvetica, 10, 26))
+	require.Equal(t, "", Truncate("Hello", Helvetica, 10, 1))
+}
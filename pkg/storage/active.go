@@ -0,0 +1,228 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"nvr/pkg/video/customformat"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The video files of a recording are written with the active suffix and
+// renamed when the recording is saved, after the data file is written.
+// Other programs that watch the recordings directory never see a
+// partially written video under its final name.
+
+// ActiveSuffix is appended to the video files while they're written.
+const ActiveSuffix = ".part"
+
+// The mdat file is renamed first, the video is
+// complete once the meta file has its final name.
+var activeExts = []string{".mdat", ".meta"}
+
+// PublishRecording renames the active video files of the recording.
+func PublishRecording(recordingPath string) error {
+	for _, ext := range activeExts {
+		path := recordingPath + ext
+		err := os.Rename(path+ActiveSuffix, path)
+		if err == nil {
+			continue
+		}
+		// Renamed before a crash.
+		if errors.Is(err, os.ErrNotExist) && fileExists(path) {
+			continue
+		}
+		return fmt.Errorf("publish %v: %w", ext, err)
+	}
+	return nil
+}
+
+// videoPaths returns the paths of the meta and mdat files of the recording,
+// the active files are returned if the recording hasn't been published.
+func videoPaths(recordingPath string) (string, string, bool) {
+	metaPath, mdatPath := recordingPath+".meta", recordingPath+".mdat"
+	if !fileExists(metaPath) && fileExists(metaPath+ActiveSuffix) {
+		return metaPath + ActiveSuffix, mdatPath + ActiveSuffix, true
+	}
+	return metaPath, mdatPath, false
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// activeRecordings returns the IDs of the recordings
+// in the directory that haven't been published.
+func activeRecordings(fileSystem fs.FS, dir string) (map[string]struct{}, error) {
+	entries, err := fs.ReadDir(fileSystem, dir)
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %v %w", dir, err)
+	}
+	active := make(map[string]struct{})
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ActiveSuffix) {
+			active[recordingIDFromFile(entry.Name())] = struct{}{}
+		}
+	}
+	return active, nil
+}
+
+// Number of the newest day directories that are scanned for active recordings.
+const recoverDays = 2
+
+// RecoverRecordings publishes the recordings that were being written
+// when the app stopped unexpectedly, a data file with the time range
+// of the video is written if the recording wasn't saved. Active
+// recordings without samples are deleted. Only the newest days are
+// scanned, the recorders write to the current day. Must be called
+// before the recorders start. Returns the IDs of the recovered recordings.
+func RecoverRecordings(recordingsDir string) ([]string, error) {
+	days, err := newestDays(recordingsDir, recoverDays)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var recovered []string
+	var errs []error
+	for _, day := range days {
+		monitorDirs, err := os.ReadDir(day)
+		if err != nil {
+			return nil, fmt.Errorf("read day directory: %w", err)
+		}
+		for _, entry := range monitorDirs {
+			if !entry.IsDir() {
+				continue
+			}
+			monitorPath := filepath.Join(day, entry.Name())
+			active, err := activeRecordings(os.DirFS(monitorPath), ".")
+			if err != nil {
+				return nil, err
+			}
+			for recID := range active {
+				ok, err := recoverRecording(filepath.Join(monitorPath, recID))
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%v: %w", recID, err))
+					continue
+				}
+				if ok {
+					recovered = append(recovered, recID)
+				}
+			}
+		}
+	}
+	return recovered, errors.Join(errs...)
+}
+
+// newestDays returns up to n of the newest day directories, newest first.
+func newestDays(recordingsDir string, n int) ([]string, error) {
+	const dayDepth = 3
+
+	var days []string
+	var walk func(path string, depth int) error
+	walk = func(path string, depth int) error {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return fmt.Errorf("read directory: %w", err)
+		}
+		for i := len(entries) - 1; i >= 0 && len(days) < n; i-- {
+			if !entries[i].IsDir() {
+				continue
+			}
+			entryPath := filepath.Join(path, entries[i].Name())
+			if depth == dayDepth {
+				days = append(days, entryPath)
+				continue
+			}
+			if err := walk(entryPath, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(recordingsDir, 1); err != nil {
+		return nil, err
+	}
+	return days, nil
+}
+
+// recoverRecording returns false if the recording was deleted.
+func recoverRecording(recordingPath string) (bool, error) {
+	dataPath := recordingPath + ".json"
+	if !fileExists(dataPath) {
+		data, err := activeRecordingData(recordingPath + ".meta" + ActiveSuffix)
+		if errors.Is(err, errNoSamples) {
+			for _, ext := range activeExts {
+				path := recordingPath + ext + ActiveSuffix
+				if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return false, err
+				}
+			}
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		raw, err := json.MarshalIndent(data, "", "    ")
+		if err != nil {
+			return false, fmt.Errorf("marshal data: %w", err)
+		}
+		if err := os.WriteFile(dataPath, raw, 0o600); err != nil {
+			return false, fmt.Errorf("write data: %w", err)
+		}
+	}
+
+	if err := PublishRecording(recordingPath); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+var errNoSamples = errors.New("no samples")
+
+// activeRecordingData returns the data of a recording that was interrupted,
+// the time range is read from the samples that were written.
+func activeRecordingData(metaPath string) (*RecordingData, error) {
+	raw, err := os.ReadFile(metaPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNoSamples
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read meta file: %w", err)
+	}
+
+	reader, header, err := customformat.NewReader(bytes.NewReader(raw), len(raw))
+	if err != nil {
+		return nil, errNoSamples
+	}
+	samples, err := reader.ReadAllSamples()
+	if err != nil {
+		return nil, fmt.Errorf("read samples: %w", err)
+	}
+	if len(samples) == 0 {
+		return nil, errNoSamples
+	}
+
+	var end int64
+	for _, s := range samples {
+		if s.Next > end {
+			end = s.Next
+		}
+	}
+	return &RecordingData{
+		Start:  time.Unix(0, header.StartTime).UTC(),
+		End:    time.Unix(0, end).UTC(),
+		Events: []Event{},
+	}, nil
+}
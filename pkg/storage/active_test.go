@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nvr/pkg/video/customformat"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishRecording(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, testRecID)
+		createFiles(t, dir, []string{
+			testRecID + ".json",
+			testRecID + ".mdat.part",
+			testRecID + ".meta.part",
+		})
+
+		require.NoError(t, PublishRecording(path))
+		require.Equal(t,
+			[]string{testRecID + ".json", testRecID + ".mdat", testRecID + ".meta"},
+			listDirectory(t, dir),
+		)
+	})
+	t.Run("partial", func(t *testing.T) {
+		// Interrupted between the renames.
+		dir := t.TempDir()
+		path := filepath.Join(dir, testRecID)
+		createFiles(t, dir, []string{testRecID + ".mdat", testRecID + ".meta.part"})
+
+		require.NoError(t, PublishRecording(path))
+		require.Equal(t,
+			[]string{testRecID + ".mdat", testRecID + ".meta"},
+			listDirectory(t, dir),
+		)
+	})
+	t.Run("notExistErr", func(t *testing.T) {
+		err := PublishRecording(filepath.Join(t.TempDir(), testRecID))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestNewVideoReaderActive(t *testing.T) {
+	path := writeTestVideo(t)
+	for _, ext := range activeExts {
+		require.NoError(t, os.Rename(path+ext, path+ext+ActiveSuffix))
+	}
+
+	cache := NewVideoCache()
+	video, err := NewVideoReader(path, cache)
+	require.NoError(t, err)
+	defer video.Close()
+	_, exist := cache.get(path)
+	require.False(t, exist)
+
+	progressive, err := NewProgressiveVideoReader(path)
+	require.NoError(t, err)
+	defer progressive.Close()
+}
+
+// writeActiveRecording writes the active video files
+// of a recording that was interrupted.
+func writeActiveRecording(t *testing.T, recDir string, start time.Time, samples int) string {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(recDir, 0o700))
+	path := filepath.Join(recDir, testRecID)
+
+	meta := customformat.Header{StartTime: start.UnixNano()}.Marshal()
+	for i := 0; i < samples; i++ {
+		pts := start.Add(time.Duration(i) * time.Second).UnixNano()
+		sample := customformat.Sample{
+			PTS:  pts,
+			DTS:  pts,
+			Next: pts + int64(time.Second),
+			Size: 1,
+		}
+		meta = append(meta, sample.Marshal()...)
+	}
+	require.NoError(t, os.WriteFile(path+".meta"+ActiveSuffix, meta, 0o600))
+	require.NoError(t, os.WriteFile(path+".mdat"+ActiveSuffix, make([]byte, samples), 0o600))
+	return path
+}
+
+func TestRecoverRecordings(t *testing.T) {
+	start := time.Date(2000, 1, 1, 2, 2, 2, 0, time.UTC)
+	t.Run("ok", func(t *testing.T) {
+		recordingsDir := t.TempDir()
+		recDir := filepath.Join(recordingsDir, "2000", "01", "01", "m1")
+		path := writeActiveRecording(t, recDir, start, 3)
+
+		recovered, err := RecoverRecordings(recordingsDir)
+		require.NoError(t, err)
+		require.Equal(t, []string{testRecID}, recovered)
+		require.Equal(t,
+			[]string{testRecID + ".json", testRecID + ".mdat", testRecID + ".meta"},
+			listDirectory(t, recDir),
+		)
+
+		raw, err := os.ReadFile(path + ".json")
+		require.NoError(t, err)
+		var data RecordingData
+		require.NoError(t, json.Unmarshal(raw, &data))
+		require.Equal(t, start, data.Start)
+		require.Equal(t, start.Add(3*time.Second), data.End)
+		require.Equal(t, []Event{}, data.Events)
+	})
+	t.Run("saved", func(t *testing.T) {
+		// Crashed after the data file was written.
+		recordingsDir := t.TempDir()
+		recDir := filepath.Join(recordingsDir, "2000", "01", "01", "m1")
+		path := writeActiveRecording(t, recDir, start, 1)
+		require.NoError(t, os.WriteFile(path+".json", []byte(`{"locked":true}`), 0o600))
+
+		recovered, err := RecoverRecordings(recordingsDir)
+		require.NoError(t, err)
+		require.Equal(t, []string{testRecID}, recovered)
+
+		raw, err := os.ReadFile(path + ".json")
+		require.NoError(t, err)
+		require.Equal(t, `{"locked":true}`, string(raw))
+		require.NoFileExists(t, path+".meta"+ActiveSuffix)
+		require.FileExists(t, path+".meta")
+	})
+	t.Run("noSamples", func(t *testing.T) {
+		recordingsDir := t.TempDir()
+		recDir := filepath.Join(recordingsDir, "2000", "01", "01", "m1")
+		writeActiveRecording(t, recDir, start, 0)
+		createFiles(t, recDir, []string{testRecID + ".jpeg"})
+
+		recovered, err := RecoverRecordings(recordingsDir)
+		require.NoError(t, err)
+		require.Empty(t, recovered)
+		require.Equal(t, []string{testRecID + ".jpeg"}, listDirectory(t, recDir))
+	})
+	t.Run("oldDaysIgnored", func(t *testing.T) {
+		recordingsDir := t.TempDir()
+		oldDir := filepath.Join(recordingsDir, "2000", "01", "01", "m1")
+		writeActiveRecording(t, oldDir, start, 1)
+		writeFiles(t, recordingsDir, "2000/01/02/m1/x.json", "2000/02/01/m1/x.json")
+
+		recovered, err := RecoverRecordings(recordingsDir)
+		require.NoError(t, err)
+		require.Empty(t, recovered)
+		require.FileExists(t, filepath.Join(oldDir, testRecID+".meta"+ActiveSuffix))
+	})
+	t.Run("dirNotExist", func(t *testing.T) {
+		recovered, err := RecoverRecordings(filepath.Join(t.TempDir(), "x"))
+		require.NoError(t, err)
+		require.Empty(t, recovered)
+	})
+}
+
+func TestSelectDayActive(t *testing.T) {
+	recordingsDir := t.TempDir()
+	dayDir := filepath.Join(recordingsDir, "2000", "01", "01")
+	writeActiveRecording(t, filepath.Join(dayDir, "m1"), time.Unix(0, 0), 1)
+	writeFile(t, filepath.Join(dayDir, "m1", "2000-01-01_01-01-01_m1.mdat"), 10)
+	writeFile(t, filepath.Join(dayDir, "m1", "2000-01-01_01-01-01_m1.json"), 2)
+
+	paths, err := selectDay(dayDir)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dayDir, "m1/2000-01-01_01-01-01_m1.json"),
+		filepath.Join(dayDir, "m1/2000-01-01_01-01-01_m1.mdat"),
+	}, paths)
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"time"
+)
+
+// ClockStepThreshold is the smallest difference between the wall clock
+// and the monotonic clock that is treated as a clock step. NTP slews
+// small offsets, larger ones are applied as a single step.
+const ClockStepThreshold = 10 * time.Second
+
+// Clock provides the wall clock for labeling and the
+// monotonic clock for measuring elapsed time.
+type Clock interface {
+	// Now returns the current wall clock time.
+	Now() time.Time
+
+	// Monotonic returns a reading of the monotonic clock, it's
+	// only meaningful when compared to another reading.
+	Monotonic() time.Duration
+}
+
+type systemClock struct {
+	epoch time.Time
+}
+
+// NewSystemClock returns a clock backed by the system clock.
+func NewSystemClock() Clock {
+	return systemClock{epoch: time.Now()}
+}
+
+func (c systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c systemClock) Monotonic() time.Duration {
+	return time.Since(c.epoch)
+}
+
+// ClockStep is a wall clock step.
+type ClockStep struct {
+	// Wall clock time before and after the step.
+	Before time.Time
+	After  time.Time
+
+	// Monotonic clock reading when the step was detected.
+	Detected time.Duration
+}
+
+// Size returns the size of the step, it's negative if the clock stepped backward.
+func (s ClockStep) Size() time.Duration {
+	return s.After.Sub(s.Before)
+}
+
+// ClockStepDetector detects wall clock steps by comparing the wall
+// clock and the monotonic clock between consecutive checks.
+type ClockStepDetector struct {
+	clock    Clock
+	prevWall time.Time
+	prevMono time.Duration
+}
+
+// NewClockStepDetector returns a detector that uses the current time as reference.
+func NewClockStepDetector(clock Clock) *ClockStepDetector {
+	return &ClockStepDetector{
+		clock:    clock,
+		prevWall: clock.Now(),
+		prevMono: clock.Monotonic(),
+	}
+}
+
+// Check returns the step if the wall clock has drifted more than
+// ClockStepThreshold from the monotonic clock since the last check.
+func (d *ClockStepDetector) Check() *ClockStep {
+	wall, mono := d.clock.Now(), d.clock.Monotonic()
+	expected := d.prevWall.Add(mono - d.prevMono)
+	d.prevWall, d.prevMono = wall, mono
+
+	offset := wall.Sub(expected)
+	if offset.Abs() < ClockStepThreshold {
+		return nil
+	}
+	return &ClockStep{
+		Before:   expected,
+		After:    wall,
+		Detected: mono,
+	}
+}
+
+// ClockCorrection is recorded when the wall clock stepped during a
+// recording. The recording was re-anchored to the wall clock after
+// the step, the recording ID still uses the time before the step.
+type ClockCorrection struct {
+	// Position in the recording where the step was detected.
+	Offset time.Duration `json:"offset"`
+
+	// Size of the step, negative if the clock stepped backward.
+	Step time.Duration `json:"step"`
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	wall time.Time
+	mono time.Duration
+}
+
+func (c *fakeClock) Now() time.Time           { return c.wall }
+func (c *fakeClock) Monotonic() time.Duration { return c.mono }
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.wall = c.wall.Add(d)
+	c.mono += d
+}
+
+func TestClockStepDetector(t *testing.T) {
+	clock := &fakeClock{wall: time.Unix(1000, 0)}
+	d := NewClockStepDetector(clock)
+
+	clock.advance(time.Hour)
+	require.Nil(t, d.Check())
+
+	// Slewed.
+	clock.advance(time.Minute)
+	clock.wall = clock.wall.Add(ClockStepThreshold - time.Second)
+	require.Nil(t, d.Check())
+
+	clock.advance(time.Minute)
+	clock.wall = clock.wall.Add(-time.Hour)
+	step := d.Check()
+	require.NotNil(t, step)
+	require.Equal(t, -time.Hour, step.Size())
+	require.Equal(t, clock.wall, step.After)
+	require.Equal(t, clock.mono, step.Detected)
+
+	// The step is only reported once.
+	clock.advance(time.Minute)
+	require.Nil(t, d.Check())
+}
+
+func TestPruneClockStep(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 12, 0, 0, 0, time.UTC)
+	}
+	newManager := func(t *testing.T, clock *fakeClock) (*Manager, string) {
+		t.Helper()
+		m, recDir, _ := newTestManager(t, 0)
+		m.clock = clock
+		m.clockSteps = NewClockStepDetector(clock)
+		return m, recDir
+	}
+
+	t.Run("forward", func(t *testing.T) {
+		// The clock was reset to 2000 before NTP synced.
+		clock := &fakeClock{wall: date(2000, 1, 1)}
+		m, recDir := newManager(t, clock)
+		writeFile(t, filepath.Join(recDir, "1999/12/31/m1/a.mp4"), 500)
+		writeFile(t, filepath.Join(recDir, "2000/01/01/m1/a.mp4"), 500)
+
+		clock.advance(time.Minute)
+		clock.wall = date(2024, 6, 1)
+		m.checkClock()
+		writeFile(t, filepath.Join(recDir, "2024/06/01/m1/a.mp4"), 500)
+
+		require.NoError(t, m.prune())
+		require.NoDirExists(t, filepath.Join(recDir, "1999/12/31"))
+
+		// Recorded just before the step.
+		require.NoError(t, m.prune())
+		require.DirExists(t, filepath.Join(recDir, "2000/01/01"))
+		require.DirExists(t, filepath.Join(recDir, "2024/06/01"))
+
+		// Normal pruning resumes after the grace period.
+		clock.advance(clockStepGrace + time.Minute)
+		m.checkClock()
+		require.NoError(t, m.prune())
+		require.NoDirExists(t, filepath.Join(recDir, "2000/01/01"))
+	})
+	t.Run("backward", func(t *testing.T) {
+		clock := &fakeClock{wall: date(2024, 6, 1)}
+		m, recDir := newManager(t, clock)
+		writeFile(t, filepath.Join(recDir, "2024/04/30/m1/a.mp4"), 500)
+		writeFile(t, filepath.Join(recDir, "2024/06/01/m1/a.mp4"), 500)
+
+		clock.advance(time.Minute)
+		clock.wall = date(2024, 5, 1)
+		m.checkClock()
+		writeFile(t, filepath.Join(recDir, "2024/05/01/m1/a.mp4"), 500)
+
+		require.NoError(t, m.prune())
+		require.NoDirExists(t, filepath.Join(recDir, "2024/04/30"))
+
+		// The repeated day and the future day.
+		require.NoError(t, m.prune())
+		require.DirExists(t, filepath.Join(recDir, "2024/05/01"))
+		require.DirExists(t, filepath.Join(recDir, "2024/06/01"))
+	})
+	t.Run("noStep", func(t *testing.T) {
+		clock := &fakeClock{wall: date(2024, 6, 1)}
+		m, recDir := newManager(t, clock)
+		writeFile(t, filepath.Join(recDir, "2024/06/01/m1/a.mp4"), 500)
+		writeFile(t, filepath.Join(recDir, "2024/06/02/m1/a.mp4"), 500)
+
+		clock.advance(time.Minute)
+		m.checkClock()
+		require.NoError(t, m.prune())
+		require.NoDirExists(t, filepath.Join(recDir, "2024/06/01"))
+	})
+}
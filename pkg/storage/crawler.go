@@ -23,7 +23,8 @@ import (
 //             ├── YYYY-MM-DD_hh-mm-ss_monitor2.mp4   // Video.
 //             └── YYYY-MM-DD_hh-mm-ss_monitor2.json  // Event data.
 //
-// Event data is only generated If video was saved successfully.
+// Event data is only generated If video was saved successfully. The
+// video files have the ActiveSuffix until the recording is published.
 // The job of these functions are to on-request find and return recording IDs.
 
 // CrawlerQuery query of recordings for crawler to find.
@@ -36,6 +37,12 @@ type CrawlerQuery struct {
 	// If event data should be read from file and included.
 	IncludeData bool
 
+	// Only return locked or unlocked recordings if set.
+	Locked *bool
+
+	// Only return recordings with an event or detection from the source if set.
+	Source string
+
 	// Query scoped cache to avoid reading the same directory twice.
 	cache queryCache
 }
@@ -89,21 +96,60 @@ func (c *Crawler) RecordingByQuery(q *CrawlerQuery) ([]Recording, error) {
 			return recordings, nil
 		}
 
-		data := func() *RecordingData {
-			if q.IncludeData {
-				return readDataFile(file.fs)
-			}
-			return nil
-		}()
+		if q.Locked != nil && isLocked(file.fs, ".") != *q.Locked {
+			continue
+		}
+
+		var data *RecordingData
+		if q.IncludeData || q.Source != "" {
+			data = readDataFile(file.fs)
+		}
+		if q.Source != "" && !data.hasSource(q.Source) {
+			continue
+		}
+		if !q.IncludeData {
+			data = nil
+		}
+
+		var variants []string
+		if q.IncludeData {
+			variants = c.variants(file.path)
+		}
 
 		recordings = append(recordings, Recording{
-			ID:   filepath.Base(file.path),
-			Data: data,
+			ID:       filepath.Base(file.path),
+			Data:     data,
+			Variants: variants,
 		})
 	}
 	return recordings, nil
 }
 
+// variants returns the sorted names of the variants that exist for the recording.
+func (c *Crawler) variants(recPath string) []string {
+	var variants []string
+	for name, ext := range RecordingVariants {
+		if _, err := fs.Stat(c.fs, recPath+ext); err == nil {
+			variants = append(variants, name)
+		}
+	}
+	sort.Strings(variants)
+	return variants
+}
+
+// hasSource returns true if one of the events has the source.
+func (d *RecordingData) hasSource(source string) bool {
+	if d == nil {
+		return false
+	}
+	for _, e := range d.Events {
+		if e.HasSource(source) {
+			return true
+		}
+	}
+	return false
+}
+
 func readDataFile(fileSystem fs.FS) *RecordingData {
 	rawData, err := fs.ReadFile(fileSystem, ".")
 	if err != nil {
@@ -114,6 +160,7 @@ func readDataFile(fileSystem fs.FS) *RecordingData {
 	if err != nil {
 		return nil
 	}
+	data.Checksums = nil
 	return &data
 }
 
@@ -428,7 +475,10 @@ func (d *dir) sibling() (*dir, error) {
 // ErrInvalidRecordingID invalid recording ID.
 var ErrInvalidRecordingID = errors.New("invalid recording ID")
 
-// RecordingIDToPath converts recording ID to path.
+// RecordingIDToPath converts recording ID to path. The path is derived
+// from the time in the ID, which is UTC for new recordings and local time
+// for legacy recordings, files are never renamed. ResolveRecordingPath
+// also finds legacy recordings by their UTC ID.
 func RecordingIDToPath(id string) (string, error) {
 	if len(id) < 20 {
 		return "", ErrInvalidRecordingID
@@ -184,6 +184,95 @@ func TestRecordingByQuery(t *testing.T) {
 		require.NoError(t, err)
 		require.Nil(t, rec[0].Data)
 	})
+	t.Run("locked", func(t *testing.T) {
+		lockedFS := fstest.MapFS{
+			"2000/01/01/m1/2000-01-01_1_m1.json": {Data: []byte(`{"locked":true}`)},
+			"2000/01/01/m1/2000-01-01_2_m1.json": {Data: []byte(`{}`)},
+			"2000/01/01/m1/2000-01-01_3_m1.json": {Data: []byte(`{"locked":true}`)},
+			"2000/01/01/m1/2000-01-01_4_m1.json": {},
+		}
+		query := func(locked bool) []string {
+			recordings, err := NewCrawler(lockedFS).RecordingByQuery(
+				&CrawlerQuery{
+					Time:   "9999-01-01",
+					Limit:  5,
+					Locked: &locked,
+				},
+			)
+			require.NoError(t, err)
+
+			var ids []string
+			for _, rec := range recordings {
+				ids = append(ids, rec.ID)
+			}
+			return ids
+		}
+		require.Equal(t, []string{"2000-01-01_3_m1", "2000-01-01_1_m1"}, query(true))
+		require.Equal(t, []string{"2000-01-01_4_m1", "2000-01-01_2_m1"}, query(false))
+	})
+	t.Run("source", func(t *testing.T) {
+		sourceFS := fstest.MapFS{
+			"2000/01/01/m1/2000-01-01_1_m1.json": {
+				Data: []byte(`{"events":[{"source":"doods"}]}`),
+			},
+			"2000/01/01/m1/2000-01-01_2_m1.json": {
+				Data: []byte(`{"events":[{"source":"api"},{"detections":[{"source":"motion"}]}]}`),
+			},
+			"2000/01/01/m1/2000-01-01_3_m1.json": {
+				Data: []byte(`{"events":[{"detections":[{"label":"a"}]}]}`),
+			},
+			"2000/01/01/m1/2000-01-01_4_m1.json": {},
+		}
+		query := func(source string, includeData bool) []Recording {
+			recordings, err := NewCrawler(sourceFS).RecordingByQuery(
+				&CrawlerQuery{
+					Time:        "9999-01-01",
+					Limit:       5,
+					Source:      source,
+					IncludeData: includeData,
+				},
+			)
+			require.NoError(t, err)
+			return recordings
+		}
+		ids := func(recordings []Recording) []string {
+			var ids []string
+			for _, rec := range recordings {
+				ids = append(ids, rec.ID)
+			}
+			return ids
+		}
+		require.Equal(t, []string{"2000-01-01_1_m1"}, ids(query("doods", false)))
+		require.Equal(t, []string{"2000-01-01_2_m1"}, ids(query("motion", false)))
+		require.Empty(t, query("x", false))
+		require.Len(t, query("", false), 4)
+
+		recordings := query("api", false)
+		require.Equal(t, []string{"2000-01-01_2_m1"}, ids(recordings))
+		require.Nil(t, recordings[0].Data)
+
+		recordings = query("api", true)
+		require.Equal(t, "api", recordings[0].Data.Events[0].Source)
+	})
+	t.Run("variants", func(t *testing.T) {
+		variantsFS := fstest.MapFS{
+			"2000/01/01/m1/2000-01-01_1_m1.json":          {},
+			"2000/01/01/m1/2000-01-01_2_m1.json":          {},
+			"2000/01/01/m1/2000-01-01_2_m1.annotated.mp4": {},
+		}
+		query := func(includeData bool) []Recording {
+			recordings, err := NewCrawler(variantsFS).RecordingByQuery(
+				&CrawlerQuery{Time: "9999-01-01", Limit: 2, IncludeData: includeData},
+			)
+			require.NoError(t, err)
+			return recordings
+		}
+		recordings := query(true)
+		require.Equal(t, []string{"annotated"}, recordings[0].Variants)
+		require.Nil(t, recordings[1].Variants)
+
+		require.Nil(t, query(false)[0].Variants)
+	})
 }
 
 func TestRecordingIDToPath(t *testing.T) {
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RecordingDetail is the recording data and the state of the
+// files next to the recording, everything the playback page
+// needs to open a recording.
+type RecordingDetail struct {
+	ID    string          `json:"id"`
+	Data  *RecordingData  `json:"data"`
+	Times *RecordingTimes `json:"times,omitempty"`
+
+	// Active recordings are still being written, the
+	// data is nil until the recording has been saved.
+	Active bool `json:"active"`
+	Locked bool `json:"locked"`
+
+	// IDs of the adjacent recordings if the
+	// recording was split by the video length.
+	Previous string `json:"previous,omitempty"`
+	Next     string `json:"next,omitempty"`
+
+	Variants []string `json:"variants,omitempty"`
+
+	// Size of the video files in bytes.
+	Size int64 `json:"size"`
+
+	// State of the addons, like the timeline status.
+	Addons map[string]interface{} `json:"addons,omitempty"`
+
+	// Extensions of the files of the recording, ".json".
+	exts map[string]struct{}
+	path string
+}
+
+// Path returns the path of the recording without extension.
+func (d *RecordingDetail) Path() string {
+	return d.path
+}
+
+// HasFile returns true if the recording has a file
+// with the extension, ".timeline" or ".jpeg".
+func (d *RecordingDetail) HasFile(ext string) bool {
+	_, exist := d.exts[ext]
+	return exist
+}
+
+// Video files of the recording, the size of the recording
+// is the sum of the files that exist.
+var recordingVideoExts = []string{".meta", ".mdat", ".mp4"}
+
+// ReadRecordingDetail returns the detail of a recording. The recording
+// directory is listed once and the data file is read, the other files
+// are only checked by name. Returns os.ErrNotExist if the recording
+// doesn't exist.
+func ReadRecordingDetail(
+	recordingsDir string,
+	recID string,
+	fallbackLoc *time.Location,
+) (*RecordingDetail, error) {
+	// RecordingIDToPath will validate the ID.
+	recPath, err := RecordingIDToPath(recID)
+	if err != nil {
+		return nil, fmt.Errorf("recording id to path: %q %w", recID, err)
+	}
+	fullRecPath := filepath.Join(recordingsDir, recPath)
+	recDir := filepath.Dir(fullRecPath)
+	recDirFS := os.DirFS(recDir)
+
+	entries, err := fs.ReadDir(recDirFS, ".")
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+
+	d := &RecordingDetail{
+		ID:   recID,
+		exts: make(map[string]struct{}),
+		path: fullRecPath,
+	}
+	sizes := make(map[string]int64)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || recordingIDFromFile(name) != recID {
+			continue
+		}
+		ext := strings.TrimPrefix(name, recID)
+		if strings.HasSuffix(ext, ActiveSuffix) {
+			d.Active = true
+			ext = strings.TrimSuffix(ext, ActiveSuffix)
+		}
+		d.exts[ext] = struct{}{}
+		if info, err := entry.Info(); err == nil {
+			sizes[ext] += info.Size()
+		}
+	}
+	if len(d.exts) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	for _, ext := range recordingVideoExts {
+		d.Size += sizes[ext]
+	}
+	for name, ext := range RecordingVariants {
+		if d.HasFile(ext) {
+			d.Variants = append(d.Variants, name)
+		}
+	}
+	sort.Strings(d.Variants)
+
+	// The data file is written when the recording is saved.
+	if !d.HasFile(".json") {
+		d.Active = true
+		return d, nil
+	}
+	rawData, err := fs.ReadFile(recDirFS, recID+".json")
+	if err != nil {
+		return nil, fmt.Errorf("read data file: %w", err)
+	}
+	var data RecordingData
+	if err := json.Unmarshal(rawData, &data); err != nil {
+		return nil, fmt.Errorf("unmarshal data file: %w", err)
+	}
+	data.Checksums = nil
+
+	times := data.Times(fallbackLoc)
+	d.Data = &data
+	d.Times = &times
+	d.Locked = data.Locked
+	d.Previous = data.Previous
+	d.Next = data.Next
+	return d, nil
+}
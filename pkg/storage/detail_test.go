@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadRecordingDetail(t *testing.T) {
+	const recID = "2000-01-01_02-02-02_m1"
+	newRecording := func(t *testing.T, files map[string]string) (string, string) {
+		t.Helper()
+		recordingsDir := t.TempDir()
+		recDir := filepath.Join(recordingsDir, "2000", "01", "01", "m1")
+		require.NoError(t, os.MkdirAll(recDir, 0o700))
+		for name, content := range files {
+			path := filepath.Join(recDir, name)
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		}
+		return recordingsDir, filepath.Join(recDir, recID)
+	}
+
+	t.Run("full", func(t *testing.T) {
+		data := `{
+			"start": "2000-01-01T02:02:02Z",
+			"end": "2000-01-01T02:03:02Z",
+			"events": [{"time": "2000-01-01T02:02:10Z", "source": "api"}],
+			"previous": "2000-01-01_02-01-02_m1",
+			"next": "2000-01-01_02-03-02_m1",
+			"locked": true,
+			"checksums": {"meta": "x"}
+		}`
+		recordingsDir, recPath := newRecording(t, map[string]string{
+			recID + ".json":                   data,
+			recID + ".meta":                   "123",
+			recID + ".mdat":                   "1234567",
+			recID + ".jpeg":                   "x",
+			recID + ".annotated.mp4":          "xx",
+			recID + ".timeline":               "x",
+			"2000-01-01_02-03-02_m1.meta":     "123",
+			"2000-01-01_02-02-02_m10.meta":    "123",
+			"2000-01-01_02-02-02_m1.other.db": "x",
+		})
+
+		d, err := ReadRecordingDetail(recordingsDir, recID, time.UTC)
+		require.NoError(t, err)
+
+		start := time.Date(2000, 1, 1, 2, 2, 2, 0, time.UTC)
+		end := start.Add(time.Minute)
+		require.Equal(t, &RecordingData{
+			Start:    start,
+			End:      end,
+			Events:   []Event{{Time: start.Add(8 * time.Second), Source: "api"}},
+			Previous: "2000-01-01_02-01-02_m1",
+			Next:     "2000-01-01_02-03-02_m1",
+			Locked:   true,
+		}, d.Data)
+		require.Equal(t, &RecordingTimes{
+			StartUTC:   start,
+			EndUTC:     end,
+			StartLocal: start,
+			EndLocal:   end,
+			TimeZone:   "UTC",
+		}, d.Times)
+
+		require.False(t, d.Active)
+		require.True(t, d.Locked)
+		require.Equal(t, "2000-01-01_02-01-02_m1", d.Previous)
+		require.Equal(t, "2000-01-01_02-03-02_m1", d.Next)
+		require.Equal(t, []string{"annotated"}, d.Variants)
+		require.Equal(t, int64(10), d.Size)
+		require.Equal(t, recPath, d.Path())
+		require.True(t, d.HasFile(".jpeg"))
+		require.True(t, d.HasFile(".timeline"))
+		require.True(t, d.HasFile(".other.db"))
+	})
+	t.Run("minimal", func(t *testing.T) {
+		recordingsDir, _ := newRecording(t, map[string]string{
+			recID + ".json": `{"start": "2000-01-01T02:02:02Z"}`,
+			recID + ".mp4":  "12345",
+		})
+
+		d, err := ReadRecordingDetail(recordingsDir, recID, time.UTC)
+		require.NoError(t, err)
+		require.NotNil(t, d.Data)
+		require.False(t, d.Active)
+		require.False(t, d.Locked)
+		require.Empty(t, d.Previous)
+		require.Nil(t, d.Variants)
+		require.Equal(t, int64(5), d.Size)
+		require.False(t, d.HasFile(".jpeg"))
+		require.False(t, d.HasFile(".timeline"))
+	})
+	t.Run("active", func(t *testing.T) {
+		recordingsDir, _ := newRecording(t, map[string]string{
+			recID + ".meta" + ActiveSuffix: "123",
+			recID + ".mdat" + ActiveSuffix: "1234",
+		})
+
+		d, err := ReadRecordingDetail(recordingsDir, recID, time.UTC)
+		require.NoError(t, err)
+		require.True(t, d.Active)
+		require.Nil(t, d.Data)
+		require.Nil(t, d.Times)
+		require.Equal(t, int64(7), d.Size)
+		require.True(t, d.HasFile(".meta"))
+	})
+	t.Run("publishedWithoutData", func(t *testing.T) {
+		recordingsDir, _ := newRecording(t, map[string]string{
+			recID + ".meta": "123",
+		})
+		d, err := ReadRecordingDetail(recordingsDir, recID, time.UTC)
+		require.NoError(t, err)
+		require.True(t, d.Active)
+	})
+	t.Run("notExistErr", func(t *testing.T) {
+		recordingsDir, _ := newRecording(t, map[string]string{
+			"2000-01-01_02-03-02_m1.json": "{}",
+		})
+		_, err := ReadRecordingDetail(recordingsDir, recID, time.UTC)
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		_, err = ReadRecordingDetail(t.TempDir(), recID, time.UTC)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+	t.Run("invalidIDErr", func(t *testing.T) {
+		_, err := ReadRecordingDetail(t.TempDir(), "invalid", time.UTC)
+		require.ErrorIs(t, err, ErrInvalidRecordingID)
+	})
+	t.Run("corruptDataErr", func(t *testing.T) {
+		recordingsDir, _ := newRecording(t, map[string]string{
+			recID + ".json": "{",
+		})
+		_, err := ReadRecordingDetail(recordingsDir, recID, time.UTC)
+		require.Error(t, err)
+	})
+}
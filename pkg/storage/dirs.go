@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// StorageDirConfig additional storage directory.
+type StorageDirConfig struct {
+	Path string `yaml:"path"`
+
+	// Directories with higher priority are filled first.
+	// The main storage directory has priority 0.
+	Priority int `yaml:"priority"`
+
+	// Max disk space in GB, 0 is unlimited.
+	DiskSpace float64 `yaml:"diskSpace"`
+}
+
+// RecordingsDirs recordings directories of all storage directories.
+// Recording IDs don't include the directory, the directory
+// is resolved by looking for the files of the recording.
+type RecordingsDirs []string
+
+// Find returns the recordings directory that contains the recording.
+// Returns the first directory if the recording doesn't exist.
+func (dirs RecordingsDirs) Find(recID string) string {
+	dir, _ := dirs.Resolve(recID)
+	return dir
+}
+
+// Resolve returns the recordings directory that contains the recording
+// and the ID that the files of the recording are named by. Legacy
+// recordings were named in the local time of the server and are also
+// found by their UTC ID. Returns the first directory and the ID
+// unchanged if the recording doesn't exist.
+func (dirs RecordingsDirs) Resolve(recID string) (string, string) {
+	return dirs.resolve(recID, time.Local)
+}
+
+func (dirs RecordingsDirs) resolve(recID string, loc *time.Location) (string, string) {
+	for _, dir := range dirs {
+		recPath, exist, err := resolveRecordingPath(os.DirFS(dir), recID, loc)
+		if err != nil {
+			break
+		}
+		if exist {
+			return dir, path.Base(recPath)
+		}
+	}
+	return dirs[0], recID
+}
+
+// FS returns a file system of the merged recordings directories.
+func (dirs RecordingsDirs) FS() fs.FS {
+	if len(dirs) == 1 {
+		return os.DirFS(dirs[0])
+	}
+	m := make(mergedFS, 0, len(dirs))
+	for _, dir := range dirs {
+		m = append(m, os.DirFS(dir))
+	}
+	return m
+}
+
+// mergedFS read-only union of file systems. Files are opened from
+// the first file system that contains them and directories are merged.
+type mergedFS []fs.FS
+
+func (m mergedFS) Open(name string) (fs.File, error) {
+	var firstErr error
+	for _, fsys := range m {
+		file, err := fsys.Open(name)
+		if err == nil {
+			return file, nil
+		}
+		if firstErr == nil || !errors.Is(err, fs.ErrNotExist) {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+func (m mergedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	seen := make(map[string]struct{})
+	exist := false
+	for _, fsys := range m {
+		dirEntries, err := fs.ReadDir(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		exist = true
+
+		for _, entry := range dirEntries {
+			if _, duplicate := seen[entry.Name()]; duplicate {
+				continue
+			}
+			seen[entry.Name()] = struct{}{}
+			entries = append(entries, entry)
+		}
+	}
+	if !exist {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
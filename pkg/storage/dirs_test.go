@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordingsDirsFind(t *testing.T) {
+	dir1, dir2 := t.TempDir(), t.TempDir()
+	writeFiles(t, dir1, "2000/01/01/m1/2000-01-01_01-01-01_m1.mp4")
+	writeFiles(t, dir2, "2000/01/01/m1/2000-01-01_02-02-02_m1.jpeg")
+	writeFiles(t, dir2, "2000/01/01/m1/2000-01-01_04-04-04_m1x.jpeg")
+
+	dirs := RecordingsDirs{dir1, dir2}
+	require.Equal(t, dir1, dirs.Find("2000-01-01_01-01-01_m1"))
+	require.Equal(t, dir2, dirs.Find("2000-01-01_02-02-02_m1"))
+
+	// Missing and invalid recordings.
+	require.Equal(t, dir1, dirs.Find("2000-01-01_03-03-03_m1"))
+	require.Equal(t, dir1, dirs.Find("2000-01-01_04-04-04_m1"))
+	require.Equal(t, dir1, dirs.Find("nil"))
+}
+
+func TestRecordingsDirsResolve(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	dir1, dir2 := t.TempDir(), t.TempDir()
+	writeFiles(t, dir1, "2000/01/01/m1/2000-01-01_01-01-01_m1.json")
+	// Legacy, 15:00 UTC.
+	writeFiles(t, dir2, "2000/01/01/m1/2000-01-01_10-00-00_m1.json")
+
+	dirs := RecordingsDirs{dir1, dir2}
+	cases := map[string]struct {
+		input string
+		dir   string
+		id    string
+	}{
+		"utc":         {"2000-01-01_01-01-01_m1", dir1, "2000-01-01_01-01-01_m1"},
+		"legacy":      {"2000-01-01_15-00-00_m1", dir2, "2000-01-01_10-00-00_m1"},
+		"legacyExact": {"2000-01-01_10-00-00_m1", dir2, "2000-01-01_10-00-00_m1"},
+		"missing":     {"2000-01-01_03-03-03_m1", dir1, "2000-01-01_03-03-03_m1"},
+		"invalid":     {"nil", dir1, "nil"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir, id := dirs.resolve(tc.input, ny)
+			require.Equal(t, tc.dir, dir)
+			require.Equal(t, tc.id, id)
+		})
+	}
+}
+
+func TestMergedFS(t *testing.T) {
+	m := mergedFS{
+		fstest.MapFS{
+			"2000/01/01/m1/2000-01-01_1_m1.json": {Data: []byte("a")},
+			"2000/01/02/m1/2000-01-02_1_m1.json": {},
+		},
+		fstest.MapFS{
+			"2000/01/01/m1/2000-01-01_2_m1.json": {},
+			"2000/01/01/m2/2000-01-01_1_m2.json": {},
+			"2001/01/01/m1/2001-01-01_1_m1.json": {},
+		},
+	}
+
+	names := func(dir string) []string {
+		entries, err := fs.ReadDir(m, dir)
+		require.NoError(t, err)
+		var list []string
+		for _, entry := range entries {
+			list = append(list, entry.Name())
+		}
+		return list
+	}
+	require.Equal(t, []string{"2000", "2001"}, names("."))
+	require.Equal(t, []string{"01", "02"}, names("2000/01"))
+	require.Equal(t, []string{"m1", "m2"}, names("2000/01/01"))
+	require.Equal(t,
+		[]string{"2000-01-01_1_m1.json", "2000-01-01_2_m1.json"},
+		names("2000/01/01/m1"),
+	)
+
+	data, err := fs.ReadFile(m, "2000/01/01/m1/2000-01-01_1_m1.json")
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), data)
+
+	_, err = fs.ReadDir(m, "nil")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = m.Open("nil")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	t.Run("crawler", func(t *testing.T) {
+		query := &CrawlerQuery{Time: "9999-01-01", Limit: 10}
+		recordings, err := NewCrawler(m).RecordingByQuery(query)
+		require.NoError(t, err)
+
+		var ids []string
+		for _, rec := range recordings {
+			ids = append(ids, rec.ID)
+		}
+		expected := []string{
+			"2001-01-01_1_m1",
+			"2000-01-02_1_m1",
+			"2000-01-01_2_m1",
+			"2000-01-01_1_m2",
+			"2000-01-01_1_m1",
+		}
+		require.Equal(t, expected, ids)
+	})
+}
+
+func writeFiles(t *testing.T, dir string, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		path = filepath.Join(dir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, nil, 0o600))
+	}
+}
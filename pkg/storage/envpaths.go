@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Errors.
+var (
+	ErrUndefinedEnvVar = errors.New("undefined environment variable")
+	ErrNotDir          = errors.New("not a directory")
+	ErrIsDir           = errors.New("is a directory")
+	ErrNotExecutable   = errors.New("not executable")
+)
+
+// resolvePath expands environment variables and a leading "~".
+// Relative paths are resolved against the base directory.
+func resolvePath(path string, baseDir string) (string, error) {
+	var undefined []string
+	path = os.Expand(path, func(name string) string {
+		value, exist := os.LookupEnv(name)
+		if !exist {
+			undefined = append(undefined, name)
+		}
+		return value
+	})
+	if len(undefined) != 0 {
+		return "", fmt.Errorf("%w: %v", ErrUndefinedEnvVar, strings.Join(undefined, ", "))
+	}
+
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, path[1:])
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	return filepath.Clean(path), nil
+}
+
+// CheckDirWritable creates and removes a small file in the directory.
+func CheckDirWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".healthcheck-*")
+	if err != nil {
+		return err
+	}
+	path := file.Name()
+
+	_, err = file.Write([]byte("ok"))
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+	if err2 := os.Remove(path); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %w", dir, err)
+	}
+	return nil
+}
+
+// checkEnvDir checks that the directory, or the closest existing
+// parent if the directory will be created, is a writable directory.
+func checkEnvDir(field string, dir string) error {
+	probe := dir
+	for {
+		info, err := os.Stat(probe)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%v '%v': %w", field, probe, ErrNotDir)
+			}
+			break
+		}
+		parent := filepath.Dir(probe)
+		if !errors.Is(err, os.ErrNotExist) || parent == probe {
+			return fmt.Errorf("%v '%v': %w", field, dir, err)
+		}
+		probe = parent
+	}
+
+	if err := CheckDirWritable(probe); err != nil {
+		return fmt.Errorf("%v '%v': not writable: %w", field, dir, err)
+	}
+	return nil
+}
+
+// checkEnvBin checks that the file exists and is executable.
+func checkEnvBin(field string, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%v '%v': %w", field, path, os.ErrNotExist)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%v '%v': %w", field, path, ErrIsDir)
+	}
+	if info.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("%v '%v': %w", field, path, ErrNotExecutable)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Locked recordings are never deleted by the storage pruning.
+
+// SetRecordingLocked sets the locked field in the data file of the recording.
+// Will return os.ErrNotExist if the recording doesn't exists.
+func SetRecordingLocked(recordingsDir, recID string, locked bool) error {
+	return updateRecordingData(recordingsDir, recID, func(data map[string]json.RawMessage) error {
+		if locked {
+			data["locked"] = json.RawMessage("true")
+		} else {
+			delete(data, "locked")
+		}
+		return nil
+	})
+}
+
+// dataFileMu prevents concurrent updates from overwriting each other.
+var dataFileMu sync.Mutex
+
+// updateRecordingData reads, modifies and replaces the data file of
+// the recording. Unknown fields are preserved. Will return
+// os.ErrNotExist if the recording doesn't exists.
+func updateRecordingData(
+	recordingsDir string,
+	recID string,
+	update func(map[string]json.RawMessage) error,
+) error {
+	// RecordingIDToPath will validate the ID.
+	recPath, err := RecordingIDToPath(recID)
+	if err != nil {
+		return fmt.Errorf("recording id to path: %q %w", recID, err)
+	}
+	dataPath := filepath.Join(recordingsDir, recPath) + ".json"
+
+	dataFileMu.Lock()
+	defer dataFileMu.Unlock()
+
+	rawData, err := os.ReadFile(dataPath)
+	if err != nil {
+		return err
+	}
+
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(rawData, &data); err != nil {
+		return fmt.Errorf("unmarshal data: %w", err)
+	}
+	if err := update(data); err != nil {
+		return err
+	}
+
+	rawData, err = json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		return fmt.Errorf("marshal data: %w", err)
+	}
+
+	// The temporary file must not end with
+	// ".json" or the crawler would find it.
+	tmpPath := filepath.Join(recordingsDir, recPath) + ".tmp"
+	if err := os.WriteFile(tmpPath, rawData, 0o600); err != nil {
+		return fmt.Errorf("write data: %w", err)
+	}
+	if err := os.Rename(tmpPath, dataPath); err != nil {
+		return fmt.Errorf("rename data: %w", err)
+	}
+	return nil
+}
+
+// isLocked returns true if the data file has the locked field set.
+func isLocked(fileSystem fs.FS, dataPath string) bool {
+	rawData, err := fs.ReadFile(fileSystem, dataPath)
+	if err != nil {
+		return false
+	}
+	var data struct {
+		Locked bool `json:"locked"`
+	}
+	if err := json.Unmarshal(rawData, &data); err != nil {
+		return false
+	}
+	return data.Locked
+}
+
+// recordingIDFromFile returns the recording ID of a recording file.
+// "2000-01-01_01-01-01_x.mp4" > "2000-01-01_01-01-01_x".
+func recordingIDFromFile(name string) string {
+	id, _, _ := strings.Cut(name, ".")
+	return id
+}
+
+// lockedRecordings returns the IDs of the locked
+// recordings in a directory that contains recordings.
+func lockedRecordings(fileSystem fs.FS, dir string) (map[string]struct{}, error) {
+	entries, err := fs.ReadDir(fileSystem, dir)
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %v %w", dir, err)
+	}
+	locked := make(map[string]struct{})
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		if isLocked(fileSystem, path.Join(dir, name)) {
+			locked[recordingIDFromFile(name)] = struct{}{}
+		}
+	}
+	return locked, nil
+}
+
+// lockedBytes returns the size of all locked recordings in the storage directory.
+func lockedBytes(fileSystem fs.FS) int64 {
+	var total int64
+	fs.WalkDir(fileSystem, "recordings", func(dir string, d fs.DirEntry, err error) error { //nolint:errcheck
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		entries, err := fs.ReadDir(fileSystem, dir)
+		if err != nil {
+			return nil
+		}
+		locked, err := lockedRecordings(fileSystem, dir)
+		if err != nil || len(locked) == 0 {
+			return nil
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			if _, exist := locked[recordingIDFromFile(entry.Name())]; !exist {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetRecordingLocked(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		recordingsDir := t.TempDir()
+		recDir := filepath.Join(recordingsDir, "2000", "01", "01", "m1")
+		recID := "2000-01-01_02-02-02_m1"
+		dataPath := filepath.Join(recDir, recID+".json")
+		require.NoError(t, os.MkdirAll(recDir, 0o700))
+		require.NoError(t, os.WriteFile(dataPath, []byte(`{"next":"x","unknown":1}`), 0o600))
+
+		require.NoError(t, SetRecordingLocked(recordingsDir, recID, true))
+		require.True(t, isLocked(os.DirFS(recDir), recID+".json"))
+
+		data, err := os.ReadFile(dataPath)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"locked":true,"next":"x","unknown":1}`, string(data))
+
+		require.NoError(t, SetRecordingLocked(recordingsDir, recID, false))
+		data, err = os.ReadFile(dataPath)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"next":"x","unknown":1}`, string(data))
+
+		require.Equal(t, []string{recID + ".json"}, listDirectory(t, recDir))
+	})
+	t.Run("invalidIDErr", func(t *testing.T) {
+		err := SetRecordingLocked(t.TempDir(), "invalid", true)
+		require.ErrorIs(t, err, ErrInvalidRecordingID)
+	})
+	t.Run("notExistErr", func(t *testing.T) {
+		err := SetRecordingLocked(t.TempDir(), "2000-01-01_02-02-02_m1", true)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
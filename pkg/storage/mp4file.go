@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"nvr/pkg/log"
+	"nvr/pkg/video/mp4muxer"
+	"os"
+	"path/filepath"
+)
+
+// DefaultMP4Reserve is enough space for the metadata
+// of a 15 minute recording with audio at 25 fps.
+const DefaultMP4Reserve = 1024 * 1024
+
+// WriteMP4File writes the recording as a mp4 file next to it. The media data
+// is copied once, after reserve bytes of free space, and the metadata is
+// written into that space. If the mp4 file exists, only the metadata is
+// updated. The file is only rewritten if the metadata doesn't fit in the
+// reserved space, the needed size is logged so the reserve can be tuned.
+func WriteMP4File(recordingPath string, reserve int64, logf log.Func) error {
+	v, err := readRecordingSamples(recordingPath + ".meta")
+	if err != nil {
+		return err
+	}
+
+	mp4Path := recordingPath + ".mp4"
+	if fileExists(mp4Path) {
+		return v.finalizeMP4(mp4Path, reserve, logf)
+	}
+
+	tmpPath := mp4Path + ".tmp"
+	if err := writeReservedMP4(tmpPath, recordingPath+".mdat", reserve); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := v.finalizeMP4(tmpPath, reserve, logf); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, mp4Path)
+}
+
+func writeReservedMP4(path string, mdatPath string, reserve int64) error {
+	mdat, err := os.Open(mdatPath)
+	if err != nil {
+		return fmt.Errorf("open mdat file: %w", err)
+	}
+	defer mdat.Close()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("create mp4 file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := mp4muxer.WriteReservedHeader(file, reserve); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	if _, err := io.Copy(file, mdat); err != nil {
+		return fmt.Errorf("copy mdat: %w", err)
+	}
+	return file.Close()
+}
+
+// finalizeMP4 writes the metadata into the reserved space of the
+// file or rewrites the file if the reserved space is too small.
+func (v *recordingSamples) finalizeMP4(path string, reserve int64, logf log.Func) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("open mp4 file: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat mp4 file: %w", err)
+	}
+
+	_, err = mp4muxer.FinalizeReservedMP4(
+		file, stat.Size(), v.startTime, v.samples, v.videoTrack, v.audioTrack)
+	var reserveErr *mp4muxer.ReserveError
+	if !errors.As(err, &reserveErr) {
+		if err != nil {
+			return fmt.Errorf("finalize: %w", err)
+		}
+		return file.Close()
+	}
+
+	logf(log.LevelWarning, "mp4 metadata needs %d bytes but %d bytes are reserved, rewriting: %v",
+		reserveErr.Needed, reserveErr.Reserved, filepath.Base(path))
+
+	rewritePath := path + ".rewrite"
+	if err := v.rewriteMP4(rewritePath, file, stat.Size(), reserve); err != nil {
+		os.Remove(rewritePath)
+		return err
+	}
+	return os.Rename(rewritePath, path)
+}
+
+func (v *recordingSamples) rewriteMP4(path string, src io.ReaderAt, srcSize int64, reserve int64) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("create mp4 file: %w", err)
+	}
+	defer file.Close()
+
+	_, err = mp4muxer.RewriteReservedMP4(
+		file, src, srcSize, reserve, v.startTime, v.samples, v.videoTrack, v.audioTrack)
+	if err != nil {
+		return fmt.Errorf("rewrite: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"nvr/pkg/log"
+	"nvr/pkg/video/mp4"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteMP4File(t *testing.T) {
+	writeMP4 := func(t *testing.T, path string, reserve int64) ([]byte, []string) {
+		t.Helper()
+		var logs []string
+		logf := func(_ log.Level, format string, a ...interface{}) {
+			logs = append(logs, fmt.Sprintf(format, a...))
+		}
+		require.NoError(t, WriteMP4File(path, reserve, logf))
+		require.NoFileExists(t, path+".mp4.tmp")
+
+		file, err := os.ReadFile(path + ".mp4")
+		require.NoError(t, err)
+		return file, logs
+	}
+	boxTypes := func(t *testing.T, file []byte) []string {
+		t.Helper()
+		infos, err := mp4.ReadBoxInfos(bytes.NewReader(file), int64(len(file)))
+		require.NoError(t, err)
+		var types []string
+		for _, info := range infos {
+			types = append(types, string(info.Type[:]))
+		}
+		return types
+	}
+	readVideo := func(t *testing.T, path string) []byte {
+		t.Helper()
+		video, err := NewVideoReader(path, nil)
+		require.NoError(t, err)
+		defer video.Close()
+		buf, err := io.ReadAll(video)
+		require.NoError(t, err)
+		return buf
+	}
+
+	t.Run("patch", func(t *testing.T) {
+		path := writeTestVideo(t)
+
+		file, logs := writeMP4(t, path, DefaultMP4Reserve)
+		require.Empty(t, logs)
+		require.Equal(t, []string{"ftyp", "moov", "free", "sidx", "mdat"}, boxTypes(t, file))
+		require.Greater(t, len(file), DefaultMP4Reserve)
+		require.Equal(t, []byte{0, 0, 0, 0}, file[len(file)-4:])
+
+		// Only the metadata is updated.
+		file2, logs := writeMP4(t, path, DefaultMP4Reserve)
+		require.Empty(t, logs)
+		require.Equal(t, file, file2)
+	})
+	t.Run("rewrite", func(t *testing.T) {
+		path := writeTestVideo(t)
+
+		file, logs := writeMP4(t, path, 64)
+		require.Len(t, logs, 1)
+		require.Contains(t, logs[0], "bytes but 64 bytes are reserved")
+		require.NoFileExists(t, path+".mp4.tmp.rewrite")
+
+		// Without padding the file is identical to the generated video.
+		require.Equal(t, []string{"ftyp", "moov", "sidx", "mdat"}, boxTypes(t, file))
+		require.Equal(t, readVideo(t, path), file)
+
+		// The rewritten file has room for the metadata.
+		file2, logs := writeMP4(t, path, 64)
+		require.Empty(t, logs)
+		require.Equal(t, file, file2)
+	})
+}
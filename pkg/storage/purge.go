@@ -0,0 +1,501 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"nvr/pkg/log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PurgeReasonDiskSpace the disk usage of the storage directory reached
+// the purge threshold. It's the only reason, monitors don't have
+// their own retention policies.
+const PurgeReasonDiskSpace = "diskSpace"
+
+// The oldest day is purged when the disk usage is at or above this percentage.
+const purgeThresholdPercent = 99
+
+// PurgeReport the result of a purge cycle. The report of
+// a dry run is what the purge would have deleted.
+type PurgeReport struct {
+	Time   time.Time        `json:"time"`
+	DryRun bool             `json:"dryRun"`
+	Dirs   []PurgeDirReport `json:"dirs"`
+}
+
+// PurgeDirReport the result of purging a single recordings directory.
+type PurgeDirReport struct {
+	Dir          string `json:"dir"`
+	UsagePercent int    `json:"usagePercent"`
+
+	// Empty if the directory wasn't purged.
+	Reason string `json:"reason,omitempty"`
+
+	// Paths are relative to the recordings directory. The empty
+	// directories are found while searching for the oldest day.
+	Day       string   `json:"day,omitempty"`
+	Paths     []string `json:"paths,omitempty"`
+	EmptyDirs []string `json:"emptyDirs,omitempty"`
+
+	// Deleted recordings by monitor ID.
+	Monitors map[string]PurgeMonitorReport `json:"monitors,omitempty"`
+
+	Warnings []string `json:"warnings,omitempty"`
+
+	// The paths may be partially deleted if the deletion failed.
+	Error string `json:"error,omitempty"`
+}
+
+// PurgeMonitorReport the deleted recordings of a monitor. The time
+// range is zero if none of the recordings have a valid ID.
+type PurgeMonitorReport struct {
+	Recordings int       `json:"recordings"`
+	Bytes      int64     `json:"bytes"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+}
+
+// isNoop returns true if nothing was deleted and nothing failed.
+func (r PurgeReport) isNoop() bool {
+	for _, dir := range r.Dirs {
+		if len(dir.Paths) != 0 || len(dir.EmptyDirs) != 0 || dir.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// PurgeLoop runs Purge on an interval until context is canceled.
+func (s *Manager) PurgeLoop(ctx context.Context, duration time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(duration):
+			s.checkClock()
+			if err := s.prune(); err != nil {
+				s.logger.Log(log.Entry{
+					Level: log.LevelError,
+					Src:   "app",
+					Msg:   fmt.Sprintf("could not purge storage: %v", err),
+				})
+			}
+		}
+	}
+}
+
+// PurgePreview returns what a purge cycle would delete right now.
+func (s *Manager) PurgePreview() PurgeReport {
+	report, _ := s.purge(true)
+	return report
+}
+
+// PurgeHistory returns the reports of the latest
+// purge cycles that deleted something, newest first.
+func (s *Manager) PurgeHistory() []PurgeReport {
+	return s.purgeHistory.list()
+}
+
+// prune prunes each storage directory.
+func (s *Manager) prune() error {
+	_, err := s.purge(false)
+	return err
+}
+
+// purge runs a purge cycle on each storage directory, nothing is
+// deleted in dry-run mode. The previews and the cycles run the same
+// selection and can't interleave, a preview is exactly what the
+// next cycle would delete if nothing changes in between.
+func (s *Manager) purge(dryRun bool) (PurgeReport, error) {
+	s.purgeMu.Lock()
+	defer s.purgeMu.Unlock()
+
+	report := PurgeReport{Time: s.now(), DryRun: dryRun}
+	var errs []error
+	for _, dir := range s.recordingsDirs() {
+		sel, err := s.selectPurge(dir)
+		if err == nil && !dryRun {
+			err = s.executePurge(sel)
+		}
+		if err != nil {
+			sel.report.Error = err.Error()
+			errs = append(errs, err)
+		}
+		report.Dirs = append(report.Dirs, sel.report)
+	}
+
+	if !dryRun && !report.isNoop() {
+		s.purgeHistory.add(report)
+	}
+	return report, errors.Join(errs...)
+}
+
+func (s *Manager) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
+
+// purgeSelection what a purge cycle deletes from a recordings directory.
+type purgeSelection struct {
+	// Absolute paths. The empty directories are removed first.
+	day       string
+	paths     []string
+	emptyDirs []string
+
+	report PurgeDirReport
+}
+
+func (sel *purgeSelection) warn(format string, a ...any) {
+	sel.report.Warnings = append(sel.report.Warnings, fmt.Sprintf(format, a...))
+}
+
+// selectPurge selects what to delete from the recordings directory without
+// deleting anything. If disk usage is at or above 99%, all unlocked
+// recordings from the oldest day with unlocked recordings are selected.
+func (s *Manager) selectPurge(dir recordingsDir) (purgeSelection, error) {
+	sel := purgeSelection{report: PurgeDirReport{Dir: dir.path}}
+
+	usage, err := dir.disk.usage(10 * time.Minute)
+	if err != nil {
+		return sel, fmt.Errorf("update disk usage: %w", err)
+	}
+	sel.report.UsagePercent = usage.Percent
+
+	// The pruning can only make progress on the unlocked recordings.
+	if s.lockedWarning(usage) {
+		sel.warn("locked recordings are using %v%% of the disk space: %q %v",
+			usage.LockedPercent, dir.path, formatDiskUsage(float64(usage.Locked)))
+	}
+
+	if usage.Percent < purgeThresholdPercent {
+		return sel, nil
+	}
+	sel.report.Reason = PurgeReasonDiskSpace
+
+	search := newDaySearch(dir.path)
+	clockSkipped := false
+	for {
+		day, err := search.oldestDay()
+		sel.emptyDirs = search.emptyDirs
+		sel.report.EmptyDirs = relativePaths(dir.path, search.emptyDirs)
+		if err != nil {
+			return sel, err
+		}
+		if day == "" {
+			if len(search.skip) != 0 && !clockSkipped {
+				sel.warn("pruning storage: all recordings are locked: %q", dir.path)
+			}
+			return sel, nil
+		}
+
+		if s.implausibleDay(dir.path, day) {
+			sel.warn("pruning storage: skipping %q after clock step", day)
+			search.skip[day] = struct{}{}
+			clockSkipped = true
+			continue
+		}
+
+		paths, err := selectDay(day)
+		if err != nil {
+			return sel, err
+		}
+		if len(paths) == 0 {
+			search.skip[day] = struct{}{}
+			continue
+		}
+
+		monitors, err := purgedMonitors(day, paths)
+		if err != nil {
+			return sel, err
+		}
+		sel.day = day
+		sel.paths = paths
+		sel.report.Day = relativePath(dir.path, day)
+		sel.report.Paths = relativePaths(dir.path, paths)
+		sel.report.Monitors = monitors
+		return sel, nil
+	}
+}
+
+// executePurge deletes the selection.
+func (s *Manager) executePurge(sel purgeSelection) error {
+	for _, msg := range sel.report.Warnings {
+		s.logger.Log(log.Entry{
+			Level: log.LevelWarning,
+			Src:   "app",
+			Msg:   msg,
+		})
+	}
+
+	for _, path := range sel.emptyDirs {
+		if err := s.removeAll(path); err != nil {
+			return fmt.Errorf("remove empty directory: %w", err)
+		}
+	}
+
+	if len(sel.paths) == 0 {
+		return nil
+	}
+	if len(sel.paths) == 1 && sel.paths[0] == sel.day {
+		s.logger.Log(log.Entry{
+			Level: log.LevelInfo,
+			Src:   "app",
+			Msg:   fmt.Sprintf("pruning storage: deleting %q", sel.day),
+		})
+	} else {
+		s.logger.Log(log.Entry{
+			Level: log.LevelInfo,
+			Src:   "app",
+			Msg:   fmt.Sprintf("pruning storage: deleting unlocked recordings from %q", sel.day),
+		})
+	}
+	for _, path := range sel.paths {
+		if err := s.removeAll(path); err != nil {
+			return fmt.Errorf("remove recordings: %w", err)
+		}
+	}
+	return nil
+}
+
+// daySearch finds the oldest day directory without modifying the
+// recordings directory. Directories that only contain empty
+// directories are empty once the empty directories are removed.
+type daySearch struct {
+	recordingsDir string
+
+	// Days that only contain locked recordings.
+	skip map[string]struct{}
+
+	// In the order they were found.
+	empty     map[string]struct{}
+	emptyDirs []string
+}
+
+func newDaySearch(recordingsDir string) *daySearch {
+	return &daySearch{
+		recordingsDir: recordingsDir,
+		skip:          make(map[string]struct{}),
+		empty:         make(map[string]struct{}),
+	}
+}
+
+// oldestDay returns the path to the oldest day directory that isn't
+// skipped or empty. Returns an empty string if there are no days left.
+func (d *daySearch) oldestDay() (string, error) {
+	const dayDepth = 3
+
+	path := d.recordingsDir
+	for depth := 1; depth <= dayDepth; depth++ {
+		list, err := fs.ReadDir(os.DirFS(path), ".")
+		if err != nil {
+			return "", fmt.Errorf("read directory %v: %w", path, err)
+		}
+
+		var entries []string
+		for _, entry := range list {
+			entryPath := filepath.Join(path, entry.Name())
+			if _, isEmpty := d.empty[entryPath]; !isEmpty {
+				entries = append(entries, entryPath)
+			}
+		}
+
+		isDirEmpty := len(entries) == 0
+		if isDirEmpty {
+			// Don't delete the recordings directory.
+			if depth == 1 {
+				return "", nil
+			}
+			d.empty[path] = struct{}{}
+			d.emptyDirs = append(d.emptyDirs, path)
+
+			path = d.recordingsDir
+			depth = 0
+			continue
+		}
+
+		next := ""
+		for _, entryPath := range entries {
+			if _, skipped := d.skip[entryPath]; !skipped {
+				next = entryPath
+				break
+			}
+		}
+
+		allSkipped := next == ""
+		if allSkipped {
+			if depth == 1 {
+				return "", nil
+			}
+			d.skip[path] = struct{}{}
+			path = d.recordingsDir
+			depth = 0
+			continue
+		}
+		path = next
+	}
+	return path, nil
+}
+
+// selectDay returns the paths of the unlocked recordings in the day
+// directory, the day itself is returned if nothing is locked. The
+// active recordings are treated as locked. Returns no paths if
+// all recordings are locked.
+func selectDay(dayPath string) ([]string, error) {
+	dayFS := os.DirFS(dayPath)
+	monitorDirs, err := fs.ReadDir(dayFS, ".")
+	if err != nil {
+		return nil, fmt.Errorf("read day directory: %v %w", dayPath, err)
+	}
+
+	lockedByMonitor := make(map[string]map[string]struct{})
+	for _, entry := range monitorDirs {
+		if !entry.IsDir() {
+			continue
+		}
+		locked, err := lockedRecordings(dayFS, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		active, err := activeRecordings(dayFS, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		for recID := range active {
+			locked[recID] = struct{}{}
+		}
+		if len(locked) != 0 {
+			lockedByMonitor[entry.Name()] = locked
+		}
+	}
+
+	if len(lockedByMonitor) == 0 {
+		return []string{dayPath}, nil
+	}
+
+	var paths []string
+	for _, entry := range monitorDirs {
+		monitorPath := filepath.Join(dayPath, entry.Name())
+		locked, exist := lockedByMonitor[entry.Name()]
+		if !exist {
+			paths = append(paths, monitorPath)
+			continue
+		}
+
+		files, err := fs.ReadDir(dayFS, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read monitor directory: %v %w", monitorPath, err)
+		}
+		for _, file := range files {
+			if _, isLocked := locked[recordingIDFromFile(file.Name())]; isLocked {
+				continue
+			}
+			paths = append(paths, filepath.Join(monitorPath, file.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// purgedMonitors returns the recordings in the paths by monitor ID.
+func purgedMonitors(dayPath string, paths []string) (map[string]PurgeMonitorReport, error) {
+	monitors := make(map[string]PurgeMonitorReport)
+	recordings := make(map[string]struct{})
+	walkFunc := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		monitorID, _, _ := strings.Cut(relativePath(dayPath, path), "/")
+		m := monitors[monitorID]
+		m.Bytes += info.Size()
+
+		recID := recordingIDFromFile(d.Name())
+		key := monitorID + "/" + recID
+		if _, exist := recordings[key]; !exist {
+			recordings[key] = struct{}{}
+			m.Recordings++
+			if t, err := RecordingIDTime(recID); err == nil {
+				if m.From.IsZero() || t.Before(m.From) {
+					m.From = t
+				}
+				if t.After(m.To) {
+					m.To = t
+				}
+			}
+		}
+		monitors[monitorID] = m
+		return nil
+	}
+	for _, path := range paths {
+		if err := filepath.WalkDir(path, walkFunc); err != nil {
+			return nil, fmt.Errorf("walk recordings: %w", err)
+		}
+	}
+	return monitors, nil
+}
+
+func relativePath(base string, path string) string {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+func relativePaths(base string, paths []string) []string {
+	if len(paths) == 0 {
+		return nil
+	}
+	rel := make([]string, len(paths))
+	for i, path := range paths {
+		rel[i] = relativePath(base, path)
+	}
+	return rel
+}
+
+// Number of reports that are kept in the purge history.
+const purgeHistoryCap = 100
+
+// purgeHistory the reports of the latest purge cycles. Only cycles
+// that deleted something or failed are kept, a cycle runs every 10
+// minutes and most of them don't do anything.
+type purgeHistory struct {
+	mu      sync.Mutex
+	reports []PurgeReport // Oldest first.
+}
+
+func (h *purgeHistory) add(report PurgeReport) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reports = append(h.reports, report)
+	if len(h.reports) > purgeHistoryCap {
+		h.reports = h.reports[len(h.reports)-purgeHistoryCap:]
+	}
+}
+
+// list returns the reports newest first.
+func (h *purgeHistory) list() []PurgeReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	reports := make([]PurgeReport, len(h.reports))
+	for i, report := range h.reports {
+		reports[len(reports)-1-i] = report
+	}
+	return reports
+}
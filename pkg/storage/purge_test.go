@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writePurgeFixture writes a day with locked and unlocked
+// recordings, an empty month and a newer day.
+func writePurgeFixture(t *testing.T, recDir string) {
+	t.Helper()
+	write := func(dir string, recID string, size int, locked bool) {
+		writeFile(t, filepath.Join(recDir, dir, recID+".mp4"), size)
+		data := `{}`
+		if locked {
+			data = `{"locked":true}`
+		}
+		require.NoError(t, os.WriteFile(
+			filepath.Join(recDir, dir, recID+".json"), []byte(data), 0o600))
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(recDir, "1999/12"), 0o700))
+	write("2000/01/01/m1", "2000-01-01_01-01-01_m1", 10, true)
+	write("2000/01/01/m1", "2000-01-01_02-02-02_m1", 20, false)
+	write("2000/01/01/m1", "2000-01-01_03-03-03_m1", 30, false)
+	write("2000/01/01/m2", "2000-01-01_04-04-04_m2", 40, false)
+	write("2000/01/02/m1", "2000-01-02_01-01-01_m1", 50, false)
+}
+
+// snapshotTree returns the path and size of every file and directory.
+func snapshotTree(t *testing.T, dir string) map[string]int64 {
+	t.Helper()
+	tree := make(map[string]int64)
+	err := fs.WalkDir(os.DirFS(dir), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		tree[path] = info.Size()
+		return nil
+	})
+	require.NoError(t, err)
+	return tree
+}
+
+func newPurgeTestManager(tempDir string) *Manager {
+	return &Manager{
+		storageDir: tempDir,
+		disk: &disk{
+			storageDirFS:   os.DirFS(tempDir),
+			general:        diskSpace1,
+			diskUsageBytes: highUsage,
+		},
+		removeAll: os.RemoveAll,
+		logger:    log.NewDummyLogger(),
+	}
+}
+
+func TestSelectPurge(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		tempDir := t.TempDir()
+		recDir := filepath.Join(tempDir, "recordings")
+		writePurgeFixture(t, recDir)
+		before := snapshotTree(t, tempDir)
+
+		m := newPurgeTestManager(tempDir)
+		sel, err := m.selectPurge(m.recordingsDirs()[0])
+		require.NoError(t, err)
+		require.Equal(t, before, snapshotTree(t, tempDir))
+
+		expected := PurgeDirReport{
+			Dir:          recDir,
+			UsagePercent: 100,
+			Reason:       PurgeReasonDiskSpace,
+			Day:          "2000/01/01",
+			Paths: []string{
+				"2000/01/01/m1/2000-01-01_02-02-02_m1.json",
+				"2000/01/01/m1/2000-01-01_02-02-02_m1.mp4",
+				"2000/01/01/m1/2000-01-01_03-03-03_m1.json",
+				"2000/01/01/m1/2000-01-01_03-03-03_m1.mp4",
+				"2000/01/01/m2",
+			},
+			EmptyDirs: []string{"1999/12", "1999"},
+			Monitors: map[string]PurgeMonitorReport{
+				"m1": {
+					Recordings: 2,
+					Bytes:      2 + 20 + 2 + 30,
+					From:       time.Date(2000, 1, 1, 2, 2, 2, 0, time.UTC),
+					To:         time.Date(2000, 1, 1, 3, 3, 3, 0, time.UTC),
+				},
+				"m2": {
+					Recordings: 1,
+					Bytes:      2 + 40,
+					From:       time.Date(2000, 1, 1, 4, 4, 4, 0, time.UTC),
+					To:         time.Date(2000, 1, 1, 4, 4, 4, 0, time.UTC),
+				},
+			},
+		}
+		require.Equal(t, expected, sel.report)
+		require.Equal(t, filepath.Join(recDir, "2000/01/01"), sel.day)
+		require.Equal(t, []string{
+			filepath.Join(recDir, "1999/12"),
+			filepath.Join(recDir, "1999"),
+		}, sel.emptyDirs)
+	})
+	t.Run("belowThreshold", func(t *testing.T) {
+		tempDir := t.TempDir()
+		writePurgeFixture(t, filepath.Join(tempDir, "recordings"))
+
+		m := newPurgeTestManager(tempDir)
+		m.disk.diskUsageBytes = func(fs.FS) int64 { return 1 }
+		sel, err := m.selectPurge(m.recordingsDirs()[0])
+		require.NoError(t, err)
+		require.Empty(t, sel.report.Reason)
+		require.Empty(t, sel.paths)
+		require.Empty(t, sel.emptyDirs)
+	})
+	t.Run("allLocked", func(t *testing.T) {
+		tempDir := t.TempDir()
+		recDir := filepath.Join(tempDir, "recordings")
+		writeFile(t, filepath.Join(recDir, "2000/01/01/m1/2000-01-01_01-01-01_m1.mp4"), 10)
+		require.NoError(t, os.WriteFile(
+			filepath.Join(recDir, "2000/01/01/m1/2000-01-01_01-01-01_m1.json"),
+			[]byte(`{"locked":true}`), 0o600))
+
+		m := newPurgeTestManager(tempDir)
+		sel, err := m.selectPurge(m.recordingsDirs()[0])
+		require.NoError(t, err)
+		require.Empty(t, sel.paths)
+		require.Equal(t, []string{
+			"pruning storage: all recordings are locked: " + strconv.Quote(recDir),
+		}, sel.report.Warnings)
+	})
+}
+
+func TestPurgePreview(t *testing.T) {
+	tempDir := t.TempDir()
+	recDir := filepath.Join(tempDir, "recordings")
+	writePurgeFixture(t, recDir)
+	before := snapshotTree(t, tempDir)
+
+	m := newPurgeTestManager(tempDir)
+	preview := m.PurgePreview()
+	require.True(t, preview.DryRun)
+	require.Equal(t, before, snapshotTree(t, tempDir))
+	require.Empty(t, m.PurgeHistory())
+
+	// The preview and the purge must select the same recordings.
+	report, err := m.purge(false)
+	require.NoError(t, err)
+	require.False(t, report.DryRun)
+	preview.Time, preview.DryRun = report.Time, report.DryRun
+	require.Equal(t, preview, report)
+
+	require.NoDirExists(t, filepath.Join(recDir, "1999"))
+	require.NoDirExists(t, filepath.Join(recDir, "2000/01/01/m2"))
+	require.Equal(t,
+		[]string{"2000-01-01_01-01-01_m1.json", "2000-01-01_01-01-01_m1.mp4"},
+		listDirectory(t, filepath.Join(recDir, "2000/01/01/m1")),
+	)
+	require.DirExists(t, filepath.Join(recDir, "2000/01/02/m1"))
+	require.Equal(t, []PurgeReport{report}, m.PurgeHistory())
+
+	// The next preview selects the next day.
+	preview = m.PurgePreview()
+	require.Equal(t, "2000/01/02", preview.Dirs[0].Day)
+}
+
+func TestPurgeHistory(t *testing.T) {
+	t.Run("noop", func(t *testing.T) {
+		tempDir := t.TempDir()
+		writePurgeFixture(t, filepath.Join(tempDir, "recordings"))
+
+		m := newPurgeTestManager(tempDir)
+		m.disk.diskUsageBytes = func(fs.FS) int64 { return 1 }
+		require.NoError(t, m.prune())
+		require.Empty(t, m.PurgeHistory())
+	})
+	t.Run("capped", func(t *testing.T) {
+		var h purgeHistory
+		for i := 0; i < purgeHistoryCap+5; i++ {
+			h.add(PurgeReport{Time: time.Unix(int64(i), 0)})
+		}
+		reports := h.list()
+		require.Len(t, reports, purgeHistoryCap)
+		require.Equal(t, time.Unix(purgeHistoryCap+4, 0), reports[0].Time)
+		require.Equal(t, time.Unix(5, 0), reports[len(reports)-1].Time)
+	})
+}
@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// recordingIDLayout is the time layout of recording IDs.
+const recordingIDLayout = "2006-01-02_15-04-05"
+
+// NewRecordingID returns the ID of a recording of the monitor that
+// started at the time. IDs are in UTC, a local time would repeat
+// when daylight saving time ends and give two recordings the same ID.
+func NewRecordingID(start time.Time, monitorID string) string {
+	return start.UTC().Format(recordingIDLayout) + "_" + monitorID
+}
+
+// RecordingIDTime returns the start time encoded in the ID as UTC.
+func RecordingIDTime(id string) (time.Time, error) {
+	if _, err := RecordingIDToPath(id); err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(recordingIDLayout, id[:19])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrInvalidRecordingID, id)
+	}
+	return t, nil
+}
+
+// LegacyRecordingID converts the ID of a recording that was named in the
+// local time of the location to a UTC ID. Recordings used to be named in
+// local time and keep their names. A time in the repeated hour when daylight
+// saving time ends is ambiguous, the first occurrence is used.
+func LegacyRecordingID(id string, loc *time.Location) (string, error) {
+	t, err := RecordingIDTime(id)
+	if err != nil {
+		return "", err
+	}
+	local := time.Date(
+		t.Year(), t.Month(), t.Day(),
+		t.Hour(), t.Minute(), t.Second(), 0, loc,
+	)
+	return NewRecordingID(local, id[20:]), nil
+}
+
+// ResolveRecordingPath returns the path of the recording relative to the
+// recordings directory. If the recording doesn't exist at the path of the
+// ID, the path of the legacy local time ID in the location is tried. This
+// allows UTC IDs to be used for recordings that were named in local time.
+func ResolveRecordingPath(fileSystem fs.FS, id string, loc *time.Location) (string, error) {
+	recPath, _, err := resolveRecordingPath(fileSystem, id, loc)
+	return recPath, err
+}
+
+// resolveRecordingPath also returns true if the recording exists.
+func resolveRecordingPath(fileSystem fs.FS, id string, loc *time.Location) (string, bool, error) {
+	recPath, err := RecordingIDToPath(id)
+	if err != nil {
+		return "", false, err
+	}
+	if recordingExistsFS(fileSystem, recPath) {
+		return recPath, true, nil
+	}
+
+	t, err := RecordingIDTime(id)
+	if err != nil {
+		return "", false, err
+	}
+	legacyID := t.In(loc).Format(recordingIDLayout) + "_" + id[20:]
+
+	// The legacy ID is ambiguous in the repeated hour when daylight saving
+	// time ends, it belongs to the first occurrence and not to this ID.
+	utcID, err := LegacyRecordingID(legacyID, loc)
+	if err != nil || utcID != id {
+		return recPath, false, err
+	}
+
+	legacyPath, err := RecordingIDToPath(legacyID)
+	if err != nil {
+		return "", false, err
+	}
+	if recordingExistsFS(fileSystem, legacyPath) {
+		return legacyPath, true, nil
+	}
+	return recPath, false, nil
+}
+
+// recordingExistsFS returns true if any file of the recording exists.
+func recordingExistsFS(fileSystem fs.FS, recPath string) bool {
+	entries, err := fs.ReadDir(fileSystem, path.Dir(recPath))
+	if err != nil {
+		return false
+	}
+	recID := path.Base(recPath)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), recID+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordingTimes start and end of a recording
+// in UTC and in the time zone of the monitor.
+type RecordingTimes struct {
+	StartUTC   time.Time `json:"startUTC"`
+	EndUTC     time.Time `json:"endUTC"`
+	StartLocal time.Time `json:"startLocal"`
+	EndLocal   time.Time `json:"endLocal"`
+	TimeZone   string    `json:"timeZone"`
+}
+
+// Times returns the times of the recording. The fallback is used if the
+// recording doesn't have a time zone or the time zone is unknown.
+func (d RecordingData) Times(fallback *time.Location) RecordingTimes {
+	loc := fallback
+	if d.TimeZone != "" {
+		if l, err := time.LoadLocation(d.TimeZone); err == nil {
+			loc = l
+		}
+	}
+	return RecordingTimes{
+		StartUTC:   d.Start.UTC(),
+		EndUTC:     d.End.UTC(),
+		StartLocal: d.Start.In(loc),
+		EndLocal:   d.End.In(loc),
+		TimeZone:   loc.String(),
+	}
+}
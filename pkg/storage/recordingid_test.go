@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRecordingID(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	t.Run("autumnDST", func(t *testing.T) {
+		// 01:30 occurs twice when daylight saving time ends.
+		first := time.Date(2024, 11, 3, 1, 30, 0, 0, ny)
+		second := first.Add(time.Hour)
+		require.Equal(t,
+			first.Format(recordingIDLayout),
+			second.Format(recordingIDLayout),
+		)
+
+		id1 := NewRecordingID(first, "m1")
+		id2 := NewRecordingID(second, "m1")
+		require.Equal(t, "2024-11-03_05-30-00_m1", id1)
+		require.Equal(t, "2024-11-03_06-30-00_m1", id2)
+
+		path1, err := RecordingIDToPath(id1)
+		require.NoError(t, err)
+		path2, err := RecordingIDToPath(id2)
+		require.NoError(t, err)
+		require.NotEqual(t, path1, path2)
+	})
+	t.Run("dateChange", func(t *testing.T) {
+		// The directory is the UTC date.
+		id := NewRecordingID(time.Date(2024, 1, 15, 22, 0, 0, 0, ny), "m1")
+		require.Equal(t, "2024-01-16_03-00-00_m1", id)
+
+		recPath, err := RecordingIDToPath(id)
+		require.NoError(t, err)
+		require.Equal(t, "2024/01/16/m1/2024-01-16_03-00-00_m1", recPath)
+	})
+}
+
+func TestRecordingIDTime(t *testing.T) {
+	actual, err := RecordingIDTime("2024-03-10_07-10-00_m1")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2024, 3, 10, 7, 10, 0, 0, time.UTC), actual)
+
+	_, err = RecordingIDTime("2024-13-10_07-10-00_m1")
+	require.ErrorIs(t, err, ErrInvalidRecordingID)
+
+	_, err = RecordingIDTime("x")
+	require.ErrorIs(t, err, ErrInvalidRecordingID)
+}
+
+func TestLegacyRecordingID(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	cases := map[string]struct {
+		input    string
+		expected string
+	}{
+		"winter": {"2024-01-15_10-00-00_m1", "2024-01-15_15-00-00_m1"},
+		"summer": {"2024-07-15_10-00-00_m1", "2024-07-15_14-00-00_m1"},
+		"day":    {"2024-01-15_22-00-00_m1", "2024-01-16_03-00-00_m1"},
+		// The first 01:30 is used, the second overwrote the first anyway.
+		"autumnDST": {"2024-11-03_01-30-00_m1", "2024-11-03_05-30-00_m1"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual, err := LegacyRecordingID(tc.input, ny)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		_, err := LegacyRecordingID("x", ny)
+		require.ErrorIs(t, err, ErrInvalidRecordingID)
+	})
+}
+
+func TestResolveRecordingPath(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	fileSystem := fstest.MapFS{
+		// Legacy, 15:00 UTC.
+		"2024/01/15/m1/2024-01-15_10-00-00_m1.json": {},
+		"2024/01/15/m1/2024-01-15_10-00-00_m1.mp4":  {},
+		// 10:00 UTC.
+		"2024/01/16/m1/2024-01-16_10-00-00_m1.json": {},
+		// Legacy, 05:30 UTC, repeated at 06:30 UTC.
+		"2023/11/05/m1/2023-11-05_01-30-00_m1.json": {},
+	}
+
+	cases := map[string]struct {
+		input    string
+		expected string
+	}{
+		"utc":         {"2024-01-16_10-00-00_m1", "2024/01/16/m1/2024-01-16_10-00-00_m1"},
+		"legacy":      {"2024-01-15_15-00-00_m1", "2024/01/15/m1/2024-01-15_10-00-00_m1"},
+		"legacyExact": {"2024-01-15_10-00-00_m1", "2024/01/15/m1/2024-01-15_10-00-00_m1"},
+		"missing":     {"2024-01-17_10-00-00_m1", "2024/01/17/m1/2024-01-17_10-00-00_m1"},
+		"dstFirst":    {"2023-11-05_05-30-00_m1", "2023/11/05/m1/2023-11-05_01-30-00_m1"},
+		"dstSecond":   {"2023-11-05_06-30-00_m1", "2023/11/05/m1/2023-11-05_06-30-00_m1"},
+		// Prefix of a existing recording.
+		"otherMonitor": {"2024-01-15_15-00-00_m", "2024/01/15/m/2024-01-15_15-00-00_m"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual, err := ResolveRecordingPath(fileSystem, tc.input, ny)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		_, err := ResolveRecordingPath(fileSystem, "x", ny)
+		require.ErrorIs(t, err, ErrInvalidRecordingID)
+	})
+}
+
+func TestRecordingByQuerySpringForward(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	// Clocks jump from 02:00 to 03:00 on 2024-03-10.
+	ids := []string{
+		NewRecordingID(time.Date(2024, 3, 10, 1, 50, 0, 0, ny), "m1"),
+		NewRecordingID(time.Date(2024, 3, 10, 3, 10, 0, 0, ny), "m1"),
+		NewRecordingID(time.Date(2024, 3, 10, 4, 0, 0, 0, ny), "m1"),
+	}
+	require.Equal(t, []string{
+		"2024-03-10_06-50-00_m1",
+		"2024-03-10_07-10-00_m1",
+		"2024-03-10_08-00-00_m1",
+	}, ids)
+
+	fileSystem := fstest.MapFS{}
+	for _, id := range ids {
+		recPath, err := RecordingIDToPath(id)
+		require.NoError(t, err)
+		fileSystem[recPath+".json"] = &fstest.MapFile{}
+	}
+
+	query := func(start time.Time, reverse bool) []string {
+		recordings, err := NewCrawler(fileSystem).RecordingByQuery(&CrawlerQuery{
+			Time:    start.UTC().Format(recordingIDLayout),
+			Limit:   5,
+			Reverse: reverse,
+		})
+		require.NoError(t, err)
+		var ids []string
+		for _, rec := range recordings {
+			ids = append(ids, rec.ID)
+		}
+		return ids
+	}
+
+	// Newest first from 03:30.
+	require.Equal(t,
+		[]string{"2024-03-10_07-10-00_m1", "2024-03-10_06-50-00_m1"},
+		query(time.Date(2024, 3, 10, 3, 30, 0, 0, ny), false),
+	)
+	// Oldest first from 01:00.
+	require.Equal(t, ids, query(time.Date(2024, 3, 10, 1, 0, 0, 0, ny), true))
+}
+
+func TestRecordingDataTimes(t *testing.T) {
+	start := time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC)
+	end := time.Date(2024, 11, 3, 6, 45, 0, 0, time.UTC)
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	require.NoError(t, err)
+
+	t.Run("timeZone", func(t *testing.T) {
+		data := RecordingData{Start: start, End: end, TimeZone: "America/New_York"}
+		times := data.Times(stockholm)
+		require.Equal(t, start, times.StartUTC)
+		require.Equal(t, end, times.EndUTC)
+		require.Equal(t, "2024-11-03T01:30:00-04:00", times.StartLocal.Format(time.RFC3339))
+		require.Equal(t, "2024-11-03T01:45:00-05:00", times.EndLocal.Format(time.RFC3339))
+		require.Equal(t, "America/New_York", times.TimeZone)
+	})
+	t.Run("legacy", func(t *testing.T) {
+		// Legacy data files have a local offset.
+		data := RecordingData{Start: start.In(stockholm), End: end.In(stockholm)}
+		times := data.Times(stockholm)
+		require.Equal(t, time.UTC, times.StartUTC.Location())
+		require.True(t, start.Equal(times.StartUTC))
+		require.Equal(t, "2024-11-03T06:30:00+01:00", times.StartLocal.Format(time.RFC3339))
+		require.Equal(t, "Europe/Stockholm", times.TimeZone)
+	})
+	t.Run("unknownTimeZone", func(t *testing.T) {
+		data := RecordingData{Start: start, End: end, TimeZone: "Europe/Nowhere"}
+		require.Equal(t, "Europe/Stockholm", data.Times(stockholm).TimeZone)
+	})
+}
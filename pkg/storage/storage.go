@@ -3,14 +3,15 @@
 package storage
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
+	"net"
 	"nvr/pkg/log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,19 +25,65 @@ type Manager struct {
 	storageDir   string
 	storageDirFS fs.FS
 	disk         *disk
+	general      *ConfigGeneral
 	removeAll    func(string) error
 
+	// Additional storage directories.
+	extraDirs []recordingsDir
+
+	// Only checked by the purge loop, the last step
+	// is nil if no step has been detected.
+	clock      Clock
+	clockSteps *ClockStepDetector
+	lastStep   *ClockStep
+
+	// Serializes the purge cycles and the previews.
+	purgeMu      sync.Mutex
+	purgeHistory purgeHistory
+
 	logger log.ILogger
 }
 
+// recordingsDir recordings directory and the disk usage of its storage directory.
+type recordingsDir struct {
+	path     string
+	priority int
+	disk     *disk
+}
+
 // NewManager returns new manager.
-func NewManager(storageDir string, general *ConfigGeneral, log log.ILogger) *Manager {
+func NewManager(
+	storageDir string,
+	storageDirs []StorageDirConfig,
+	general *ConfigGeneral,
+	log log.ILogger,
+) *Manager {
 	storageDirFS := os.DirFS(storageDir)
+
+	var extraDirs []recordingsDir
+	for _, c := range sortStorageDirs(storageDirs) {
+		extraDirs = append(extraDirs, recordingsDir{
+			path:     filepath.Join(c.Path, "recordings"),
+			priority: c.Priority,
+			disk: &disk{
+				space:          int64(c.DiskSpace * gigabyte),
+				storageDirFS:   os.DirFS(c.Path),
+				diskUsageBytes: diskUsageBytes,
+				lockedBytes:    lockedBytes,
+			},
+		})
+	}
+
+	clock := NewSystemClock()
 	return &Manager{
 		storageDir:   storageDir,
 		storageDirFS: storageDirFS,
 		disk:         newDisk(general, storageDirFS),
+		general:      general,
 		removeAll:    os.RemoveAll,
+		extraDirs:    extraDirs,
+		clock:        clock,
+		clockSteps:   NewClockStepDetector(clock),
 
 		logger: log,
 	}
@@ -47,95 +94,181 @@ func (s *Manager) RecordingsDir() string {
 	return filepath.Join(s.storageDir, "recordings")
 }
 
+// recordingsDirs returns all recordings directories ordered by priority.
+func (s *Manager) recordingsDirs() []recordingsDir {
+	dirs := []recordingsDir{{path: s.RecordingsDir(), disk: s.disk}}
+	dirs = append(dirs, s.extraDirs...)
+
+	// The main directory is first if the priorities are equal.
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return dirs[i].priority > dirs[j].priority
+	})
+	return dirs
+}
+
+// New recordings spill over to the next storage directory
+// when the disk usage is at or above this percentage.
+const spilloverPercent = 95
+
+// NextRecordingsDir returns the recordings directory for a new recording.
+// Returns the highest priority directory with free space, or the highest
+// priority directory if all of them are full and waiting to be pruned.
+func (s *Manager) NextRecordingsDir() string {
+	dirs := s.recordingsDirs()
+	for _, dir := range dirs {
+		usage, err := dir.disk.usage(10 * time.Minute)
+		if err != nil {
+			s.logger.Log(log.Entry{
+				Level: log.LevelError,
+				Src:   "app",
+				Msg:   fmt.Sprintf("could not get disk usage: %q %v", dir.path, err),
+			})
+			continue
+		}
+		if usage.Percent < spilloverPercent {
+			return dir.path
+		}
+	}
+	return dirs[0].path
+}
+
 // DiskUsageCached returns cached value and its age.
 func (s *Manager) DiskUsageCached() (DiskUsage, time.Duration) {
-	return s.disk.usageCached()
+	usage, age := s.diskUsageCached()
+	usage.LockedWarning = s.lockedWarning(usage)
+	return usage, age
+}
+
+func (s *Manager) diskUsageCached() (DiskUsage, time.Duration) {
+	usage, age := s.disk.usageCached()
+	if len(s.extraDirs) == 0 {
+		return usage, age
+	}
+
+	usages := []DiskUsage{usage}
+	for _, dir := range s.extraDirs {
+		u, a := dir.disk.usageCached()
+		usages = append(usages, u)
+		if a > age {
+			age = a
+		}
+	}
+	return sumDiskUsage(usages), age
 }
 
 // DiskUsage returns cached value if witin maxAge.
 // Will update and return new value if the cached value is too old.
 func (s *Manager) DiskUsage(maxAge time.Duration) (DiskUsage, error) {
-	return s.disk.usage(maxAge)
-}
-
-// prune checks if disk usage is above 99%,
-// if true deletes all files from the oldest day.
-func (s *Manager) prune() error {
-	usage, err := s.DiskUsage(10 * time.Minute)
+	usage, err := s.diskUsage(maxAge)
 	if err != nil {
-		return fmt.Errorf("update disk usage: %w", err)
-	}
-	if usage.Percent < 99 {
-		return nil
+		return DiskUsage{}, err
 	}
+	usage.LockedWarning = s.lockedWarning(usage)
+	return usage, nil
+}
 
-	const dayDepth = 3
+func (s *Manager) diskUsage(maxAge time.Duration) (DiskUsage, error) {
+	usage, err := s.disk.usage(maxAge)
+	if err != nil || len(s.extraDirs) == 0 {
+		return usage, err
+	}
 
-	// Find the oldest day.
-	path := s.RecordingsDir()
-	for depth := 1; depth <= dayDepth; depth++ {
-		list, err := fs.ReadDir(os.DirFS(path), ".")
+	usages := []DiskUsage{usage}
+	for _, dir := range s.extraDirs {
+		u, err := dir.disk.usage(maxAge)
 		if err != nil {
-			return fmt.Errorf("read directory %v: %w", path, err)
+			return DiskUsage{}, err
 		}
+		usages = append(usages, u)
+	}
+	return sumDiskUsage(usages), nil
+}
 
-		isDirEmpty := len(list) == 0
-		if isDirEmpty {
-			// Don't delete the recordings directory.
-			if depth == 1 {
-				return nil
-			}
+// MaxLockedPercent returns the percentage of the disk space that
+// locked recordings can use before a warning is logged.
+func (s *Manager) MaxLockedPercent() int {
+	if s.general == nil {
+		return defaultMaxLockedPercent
+	}
+	return s.general.MaxLockedPercent()
+}
 
-			if err := s.removeAll(path); err != nil {
-				return fmt.Errorf("remove empty directory: %w", err)
-			}
+func (s *Manager) lockedWarning(usage DiskUsage) bool {
+	return usage.Locked != 0 && usage.LockedPercent >= s.MaxLockedPercent()
+}
 
-			path = s.RecordingsDir()
-			depth = 0
-			continue
-		}
+// Days aren't pruned based on their timestamps for this long after a clock step.
+const clockStepGrace = time.Hour
 
-		firstFile := list[0].Name()
-		path = filepath.Join(path, firstFile)
-	}
+// checkClock checks for wall clock steps since the last check.
+func (s *Manager) checkClock() {
+	// The last step is read by the purge selection.
+	s.purgeMu.Lock()
+	defer s.purgeMu.Unlock()
 
+	if s.clockSteps == nil {
+		return
+	}
+	step := s.clockSteps.Check()
+	if step == nil {
+		return
+	}
+	s.lastStep = step
 	s.logger.Log(log.Entry{
-		Level: log.LevelInfo,
+		Level: log.LevelWarning,
 		Src:   "app",
-		Msg:   fmt.Sprintf("pruning storage: deleting %q", path),
+		Msg: fmt.Sprintf("clock stepped %v from %v to %v",
+			step.Size(), step.Before.Format(time.RFC3339), step.After.Format(time.RFC3339)),
 	})
+}
 
-	// Delete all files from that day
-	if err := s.removeAll(path); err != nil {
-		return fmt.Errorf("remove directory: %w", err)
+// implausibleDay returns true if the day directory was recently labeled by
+// a wrong clock. Right after a clock step, recordings from the skipped or
+// repeated time range and recordings from the future can't be trusted to
+// be the oldest, they may have been recorded just before the step.
+func (s *Manager) implausibleDay(recordingsDir string, dayPath string) bool {
+	if s.lastStep == nil || s.clock.Monotonic()-s.lastStep.Detected > clockStepGrace {
+		return false
 	}
-	return nil
-}
 
-// PurgeLoop runs Purge on an interval until context is canceled.
-func (s *Manager) PurgeLoop(ctx context.Context, duration time.Duration) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-time.After(duration):
-			if err := s.prune(); err != nil {
-				s.logger.Log(log.Entry{
-					Level: log.LevelError,
-					Src:   "app",
-					Msg:   fmt.Sprintf("could not purge storage: %v", err),
-				})
-			}
-		}
+	rel, err := filepath.Rel(recordingsDir, dayPath)
+	if err != nil {
+		return false
+	}
+	day, err := time.ParseInLocation("2006/01/02", filepath.ToSlash(rel), time.UTC)
+	if err != nil {
+		return false
+	}
+
+	truncateDay := func(t time.Time) time.Time {
+		t = t.UTC()
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	if day.After(truncateDay(s.clock.Now())) {
+		return true
 	}
+
+	low, high := truncateDay(s.lastStep.Before), truncateDay(s.lastStep.After)
+	if high.Before(low) {
+		low, high = high, low
+	}
+	return !day.Before(low) && !day.After(high)
 }
 
 // Only used to calculate and cache disk usage.
 type disk struct {
-	general        *ConfigGeneral
+	general *ConfigGeneral
+
+	// Disk space in bytes if general is nil. Additional
+	// storage directories don't use the general config.
+	space int64
+
 	storageDirFS   fs.FS
 	diskUsageBytes func(fs.FS) int64
 
+	// Size of the locked recordings, not calculated if nil.
+	lockedBytes func(fs.FS) int64
+
 	cache      DiskUsage
 	lastUpdate time.Time
 	cacheLock  sync.Mutex
@@ -147,6 +280,7 @@ func newDisk(general *ConfigGeneral, storageDirFS fs.FS) *disk {
 	return &disk{
 		general:        general,
 		diskUsageBytes: diskUsageBytes,
+		lockedBytes:    lockedBytes,
 		storageDirFS:   storageDirFS,
 	}
 }
@@ -201,32 +335,79 @@ func (d *disk) usage(maxAge time.Duration) (DiskUsage, error) {
 func (d *disk) calculateDiskUsage() (DiskUsage, error) {
 	used := d.diskUsageBytes(d.storageDirFS)
 
-	diskSpaceBytes, err := d.general.DiskSpace()
+	diskSpaceBytes, err := d.diskSpace()
 	if err != nil {
 		return DiskUsage{}, fmt.Errorf("disk space: %w", err)
 	}
 
-	percent := func() int {
-		if used == 0 || diskSpaceBytes == 0 {
+	var locked int64
+	if d.lockedBytes != nil {
+		locked = d.lockedBytes(d.storageDirFS)
+	}
+
+	percent := func(bytes int64) int {
+		if bytes == 0 || diskSpaceBytes == 0 {
 			return 0
 		}
-		return int((used * 100) / diskSpaceBytes)
-	}()
+		return int((bytes * 100) / diskSpaceBytes)
+	}
 
 	return DiskUsage{
-		Used:      used,
-		Percent:   percent,
-		Max:       diskSpaceBytes / int64(gigabyte),
-		Formatted: formatDiskUsage(float64(used)),
+		Used:          used,
+		Percent:       percent(used),
+		Max:           diskSpaceBytes / int64(gigabyte),
+		Formatted:     formatDiskUsage(float64(used)),
+		Locked:        locked,
+		LockedPercent: percent(locked),
 	}, nil
 }
 
+func (d *disk) diskSpace() (int64, error) {
+	if d.general == nil {
+		return d.space, nil
+	}
+	return d.general.DiskSpace()
+}
+
+// sumDiskUsage returns the combined usage of multiple disks.
+func sumDiskUsage(usages []DiskUsage) DiskUsage {
+	var used, max, locked int64
+	for _, usage := range usages {
+		used += usage.Used
+		max += usage.Max
+		locked += usage.Locked
+	}
+
+	percent := func(bytes int64) int {
+		if max == 0 {
+			return 0
+		}
+		return int((bytes * 100) / (max * int64(gigabyte)))
+	}
+
+	return DiskUsage{
+		Used:          used,
+		Percent:       percent(used),
+		Max:           max,
+		Formatted:     formatDiskUsage(float64(used)),
+		Locked:        locked,
+		LockedPercent: percent(locked),
+	}
+}
+
 // DiskUsage in Bytes.
 type DiskUsage struct {
 	Used      int64
 	Percent   int
 	Max       int64
 	Formatted string
+
+	// Locked recordings.
+	Locked        int64
+	LockedPercent int
+
+	// True if the locked recordings exceed MaxLockedPercent.
+	LockedWarning bool
 }
 
 const (
@@ -277,30 +458,120 @@ type ConfigEnv struct {
 	Port           int    `yaml:"port"`
 	RTSPPort       int    `yaml:"rtspPort"`
 	RTSPPortExpose bool   `yaml:"rtspPortExpose"`
+	RTSPAuth       bool   `yaml:"rtspAuth"`
 	HLSPort        int    `yaml:"hlsPort"`
 	HLSPortExpose  bool   `yaml:"hlsPortExpose"`
+	HLSSessions    bool   `yaml:"hlsSessions"`
+	HLSMaxViewers  int    `yaml:"hlsMaxViewers"`
 	GoBin          string `yaml:"goBin"`
 	FFmpegBin      string `yaml:"ffmpegBin"`
 
+	// IP address of the interface that the RTSP server listens
+	// on, "0.0.0.0" for all interfaces. See RTSPHost.
+	RTSPBindAddress string `yaml:"rtspBindAddress,omitempty"`
+
+	// Allow the RTSP server to listen on a non-loopback
+	// interface without requiring authentication.
+	RTSPInsecure bool `yaml:"rtspInsecure,omitempty"`
+
+	// Format of the log output to stdout, "text" or "json".
+	LogFormat log.Format `yaml:"logFormat,omitempty"`
+
+	// Bandwidth limit in kilobits per second of each RTSP reader
+	// that doesn't connect from localhost, 0 is unlimited.
+	RTSPReaderRate int `yaml:"rtspReaderRate,omitempty"`
+
+	// Memory budget in megabytes for the in-memory video
+	// buffers of all monitors combined, 0 is unlimited.
+	VideoMemoryBudget int `yaml:"videoMemoryBudget,omitempty"`
+
 	StorageDir string `yaml:"storageDir"`
 	TempDir    string
 
+	// Additional storage directories, new recordings spill
+	// over to the next directory when one becomes full.
+	StorageDirs []StorageDirConfig `yaml:"storageDirs,omitempty"`
+
 	HomeDir   string `yaml:"homeDir"`
 	ConfigDir string
+
+	// Trust identity headers from a authenticating reverse proxy.
+	ProxyAuth ProxyAuthConfig `yaml:"proxyAuth,omitempty"`
+
+	// Parameters of the password hash used for new and upgraded passwords.
+	PasswordHash PasswordHashConfig `yaml:"passwordHash,omitempty"`
 }
 
-// ErrPathNotAbsolute path is not absolute.
-var ErrPathNotAbsolute = errors.New("path is not absolute")
+// PasswordHashConfig argon2id parameters, zero values use the defaults.
+type PasswordHashConfig struct {
+	// Memory in KiB.
+	Memory     int `yaml:"memory"`
+	Iterations int `yaml:"iterations"`
+}
 
-// NewConfigEnv return new environment configuration.
+// ProxyAuthConfig reverse proxy header authentication.
+type ProxyAuthConfig struct {
+	Enable bool `yaml:"enable"`
+
+	// The headers are only trusted from these CIDRs.
+	TrustedProxies []string `yaml:"trustedProxies"`
+
+	// Header names, defaults to "Remote-User" and "Remote-Groups".
+	UserHeader   string `yaml:"userHeader"`
+	GroupsHeader string `yaml:"groupsHeader"`
+
+	// Members of AdminGroups are admins. If UserGroups isn't
+	// empty, users must be a member of one of the groups.
+	AdminGroups []string `yaml:"adminGroups"`
+	UserGroups  []string `yaml:"userGroups"`
+
+	// Create user accounts for unknown usernames.
+	AutoProvision bool `yaml:"autoProvision"`
+}
+
+// ErrDuplicateStorageDir storage directory is the main storage directory.
+var ErrDuplicateStorageDir = errors.New("same as storageDir")
+
+// ErrInvalidDiskSpace negative disk space.
+var ErrInvalidDiskSpace = errors.New("invalid disk space")
+
+// ErrInvalidBindAddress bind address isn't a IP address.
+var ErrInvalidBindAddress = errors.New("invalid bind address")
+
+// ErrRTSPUnprotected the RTSP server is reachable from the network without auth.
+var ErrRTSPUnprotected = errors.New(
+	"RTSP server is exposed without authentication, enable rtspAuth or set rtspInsecure")
+
+// NewConfigEnv return new environment configuration. Environment
+// variables and "~" are expanded in the paths and relative paths
+// are resolved against the config directory. All problems are
+// returned together.
 func NewConfigEnv(envPath string, envYAML []byte) (*ConfigEnv, error) {
+	env, err := ParseConfigEnv(envPath, envYAML)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.check(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// ParseConfigEnv parses and resolves the environment configuration
+// without checking the paths. Used by the first-run setup that
+// serves the setup page before the paths are configured.
+func ParseConfigEnv(envPath string, envYAML []byte) (*ConfigEnv, error) {
 	var env ConfigEnv
 
 	if err := yaml.Unmarshal(envYAML, &env); err != nil {
 		return nil, fmt.Errorf("unmarshal env.yaml: %w", err)
 	}
 
-	env.ConfigDir = filepath.Dir(envPath)
+	configDir, err := filepath.Abs(filepath.Dir(envPath))
+	if err != nil {
+		return nil, fmt.Errorf("config dir: %w", err)
+	}
+	env.ConfigDir = configDir
 	env.TempDir = filepath.Join(os.TempDir(), "nvr")
 
 	if env.Port == 0 {
@@ -312,40 +583,135 @@ func NewConfigEnv(envPath string, envYAML []byte) (*ConfigEnv, error) {
 	if env.HLSPort == 0 {
 		env.HLSPort = 2022
 	}
-	if env.GoBin == "" {
-		env.GoBin = "/usr/bin/go"
-	}
-	if env.FFmpegBin == "" {
-		env.FFmpegBin = "/usr/bin/ffmpeg"
-	}
-	if env.HomeDir == "" {
-		env.HomeDir = filepath.Dir(env.ConfigDir)
+
+	var errs []error
+	resolve := func(field string, path *string, defaultPath string) {
+		if *path == "" {
+			*path = defaultPath
+			return
+		}
+		resolved, err := resolvePath(*path, env.ConfigDir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v '%v': %w", field, *path, err))
+			return
+		}
+		*path = resolved
+	}
+	resolve("goBin", &env.GoBin, "/usr/bin/go")
+	resolve("ffmpegBin", &env.FFmpegBin, "/usr/bin/ffmpeg")
+	resolve("homeDir", &env.HomeDir, filepath.Dir(env.ConfigDir))
+	resolve("storageDir", &env.StorageDir, filepath.Join(env.HomeDir, "storage"))
+	for i := range env.StorageDirs {
+		if env.StorageDirs[i].Path == "" {
+			errs = append(errs, fmt.Errorf("storageDirs path: %w", ErrValueMissing))
+			continue
+		}
+		resolve("storageDirs", &env.StorageDirs[i].Path, "")
 	}
-	if env.StorageDir == "" {
-		env.StorageDir = filepath.Join(env.HomeDir, "storage")
+	if len(errs) != 0 {
+		return nil, errors.Join(errs...)
 	}
+	return &env, nil
+}
 
+// check checks the resolved paths and settings.
+func (env ConfigEnv) check() error {
+	var errs []error
 	if !dirExist(env.GoBin) {
-		return nil, fmt.Errorf("goBin '%v': %w", env.GoBin, os.ErrNotExist)
-	}
-	if !dirExist(env.FFmpegBin) {
-		return nil, fmt.Errorf("ffmpegBin '%v: %w' does not exist", env.FFmpegBin, os.ErrNotExist)
+		errs = append(errs, fmt.Errorf("goBin '%v': %w", env.GoBin, os.ErrNotExist))
+	}
+	errs = append(errs,
+		checkEnvBin("ffmpegBin", env.FFmpegBin),
+		checkEnvDir("homeDir", env.HomeDir),
+		checkEnvDir("storageDir", env.StorageDir),
+	)
+	errs = append(errs, env.checkRTSPBind())
+	if _, err := log.ParseFormat(string(env.LogFormat)); err != nil {
+		errs = append(errs, fmt.Errorf("logFormat: %w", err))
+	}
+	for _, dir := range env.StorageDirs {
+		if dir.Path == env.StorageDir {
+			errs = append(errs, fmt.Errorf("storageDirs '%v': %w", dir.Path, ErrDuplicateStorageDir))
+			continue
+		}
+		errs = append(errs, checkEnvDir("storageDirs", dir.Path))
+		if dir.DiskSpace < 0 {
+			errs = append(errs, fmt.Errorf("storageDirs '%v': %w", dir.Path, ErrInvalidDiskSpace))
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	if !filepath.IsAbs(env.GoBin) {
-		return nil, fmt.Errorf("goBin '%v': %w", env.GoBin, ErrPathNotAbsolute)
+// SetConfigEnvPaths returns the env.yaml with the storage directory
+// and ffmpeg binary replaced. Other keys and comments are kept.
+func SetConfigEnvPaths(envYAML []byte, storageDir string, ffmpegBin string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(envYAML, &doc); err != nil {
+		return nil, fmt.Errorf("unmarshal env.yaml: %w", err)
 	}
-	if !filepath.IsAbs(env.FFmpegBin) {
-		return nil, fmt.Errorf("ffmpegBin '%v': %w", env.FFmpegBin, ErrPathNotAbsolute)
+	if doc.Kind == 0 {
+		// Empty file.
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
 	}
-	if !filepath.IsAbs(env.HomeDir) {
-		return nil, fmt.Errorf("homeDir '%v': %w", env.HomeDir, ErrPathNotAbsolute)
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("unmarshal env.yaml: %w", ErrNotMapping)
 	}
-	if !filepath.IsAbs(env.StorageDir) {
-		return nil, fmt.Errorf("StorageDir '%v': %w", env.StorageDir, ErrPathNotAbsolute)
+	setYAMLValue(root, "storageDir", storageDir)
+	setYAMLValue(root, "ffmpegBin", ffmpegBin)
+
+	return yaml.Marshal(&doc)
+}
+
+// ErrNotMapping the yaml document isn't a mapping.
+var ErrNotMapping = errors.New("not a mapping")
+
+func setYAMLValue(mapping *yaml.Node, key string, value string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1].SetString(value)
+			return
+		}
 	}
+	keyNode := &yaml.Node{}
+	keyNode.SetString(key)
+	valueNode := &yaml.Node{}
+	valueNode.SetString(value)
+	mapping.Content = append(mapping.Content, keyNode, valueNode)
+}
 
-	return &env, nil
+// RTSPHost returns the host that the RTSP server listens on. Defaults to
+// the loopback interface, or all interfaces if rtspPortExpose is set.
+func (env ConfigEnv) RTSPHost() string {
+	switch {
+	case env.RTSPBindAddress != "":
+		return env.RTSPBindAddress
+	case env.RTSPPortExpose:
+		return ""
+	default:
+		return "127.0.0.1"
+	}
+}
+
+// checkRTSPBind refuses to expose the RTSP server without auth unless
+// rtspInsecure is set, readers and publishers would be unprotected.
+func (env ConfigEnv) checkRTSPBind() error {
+	host := env.RTSPHost()
+	exposed := true
+	if host != "" {
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return fmt.Errorf("rtspBindAddress '%v': %w", host, ErrInvalidBindAddress)
+		}
+		exposed = !ip.IsLoopback()
+	}
+	if exposed && !env.RTSPAuth && !env.RTSPInsecure {
+		return fmt.Errorf("rtspBindAddress '%v': %w", host, ErrRTSPUnprotected)
+	}
+	return nil
 }
 
 // RecordingsDir return recordings directory.
@@ -353,18 +719,47 @@ func (env ConfigEnv) RecordingsDir() string {
 	return filepath.Join(env.StorageDir, "recordings")
 }
 
+// RecordingsDirs returns the recordings directories
+// of all storage directories ordered by priority.
+func (env ConfigEnv) RecordingsDirs() RecordingsDirs {
+	dirs := RecordingsDirs{}
+	mainAdded := false
+	for _, dir := range sortStorageDirs(env.StorageDirs) {
+		if !mainAdded && dir.Priority <= 0 {
+			dirs = append(dirs, env.RecordingsDir())
+			mainAdded = true
+		}
+		dirs = append(dirs, filepath.Join(dir.Path, "recordings"))
+	}
+	if !mainAdded {
+		dirs = append(dirs, env.RecordingsDir())
+	}
+	return dirs
+}
+
+// sortStorageDirs returns a copy sorted by priority.
+func sortStorageDirs(dirs []StorageDirConfig) []StorageDirConfig {
+	sorted := append([]StorageDirConfig{}, dirs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority > sorted[j].Priority
+	})
+	return sorted
+}
+
 // PrepareEnvironment prepares directories.
 func (env ConfigEnv) PrepareEnvironment() error {
-	err := os.MkdirAll(env.RecordingsDir(), 0o700)
-	if err != nil && !errors.Is(err, os.ErrExist) {
-		return fmt.Errorf("create recordings directory: %v: %w", env.StorageDir, err)
+	for _, dir := range env.RecordingsDirs() {
+		err := os.MkdirAll(dir, 0o700)
+		if err != nil && !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("create recordings directory: %v: %w", dir, err)
+		}
 	}
 
 	// Make sure env.TempDir isn't set to "/".
 	if len(env.TempDir) <= 4 {
 		panic(fmt.Sprintf("tempDir sanity check: %v", env.TempDir))
 	}
-	err = os.RemoveAll(env.TempDir)
+	err := os.RemoveAll(env.TempDir)
 	if err != nil {
 		return fmt.Errorf("clear tempDir: %v: %w", env.TempDir, err)
 	}
@@ -471,8 +866,32 @@ func (general *ConfigGeneral) DiskSpace() (int64, error) {
 	return int64(diskSpaceByte), nil
 }
 
-// DeleteRecording delete a recording by ID.
+const defaultMaxLockedPercent = 50
+
+// MaxLockedPercent returns the configured percentage of the disk space
+// that locked recordings can use before a warning is logged.
+func (general *ConfigGeneral) MaxLockedPercent() int {
+	defer general.mu.Unlock()
+	general.mu.Lock()
+
+	percent, err := strconv.Atoi(general.Config["maxLockedPercent"])
+	if err != nil || percent <= 0 {
+		return defaultMaxLockedPercent
+	}
+	return percent
+}
+
+// Errors.
+var (
+	ErrRecordingInProgress = errors.New("recording is in progress")
+	ErrRecordingLocked     = errors.New("recording is locked")
+)
+
+// DeleteRecording delete a recording by ID, the video file and all sidecars.
 // Will return os.ErrNotExist if the recording doesn't exists.
+// Will return ErrRecordingInProgress if the recording hasn't been saved or
+// published yet, the data file is written when the recording is finished.
+// Will return ErrRecordingLocked if the recording is locked.
 func DeleteRecording(recordingsDir, recID string) error {
 	// RecordingIDToPath will validate the ID.
 	recPath, err := RecordingIDToPath(recID)
@@ -483,28 +902,46 @@ func DeleteRecording(recordingsDir, recID string) error {
 	fullRecPath := filepath.Join(recordingsDir, recPath)
 	recDir := filepath.Dir(fullRecPath)
 
-	var returnedError error
-	recordingExists := false
-	entries, err := fs.ReadDir(os.DirFS(recDir), ".")
+	recDirFS := os.DirFS(recDir)
+	entries, err := fs.ReadDir(recDirFS, ".")
 	if err != nil {
 		return fmt.Errorf("read directory: %q %w", recDir, err)
 	}
+
+	var files []string
+	hasData := false
+	isActive := false
 	for _, entry := range entries {
 		name := entry.Name()
-		if !strings.HasPrefix(name, recID) {
+		if entry.IsDir() || recordingIDFromFile(name) != recID {
 			continue
 		}
-		path := filepath.Join(recDir, name)
-		err := os.Remove(path)
-		if err != nil {
-			returnedError = fmt.Errorf("delete file: %q %w", path, err)
+		if name == recID+".json" {
+			hasData = true
+		}
+		if strings.HasSuffix(name, ActiveSuffix) {
+			isActive = true
 		}
-		recordingExists = true
+		files = append(files, name)
 	}
 
-	if !recordingExists {
+	if len(files) == 0 {
 		return os.ErrNotExist
 	}
+	if !hasData || isActive {
+		return ErrRecordingInProgress
+	}
+	if isLocked(recDirFS, recID+".json") {
+		return ErrRecordingLocked
+	}
+
+	var returnedError error
+	for _, name := range files {
+		path := filepath.Join(recDir, name)
+		if err := os.Remove(path); err != nil {
+			returnedError = fmt.Errorf("delete file: %q %w", path, err)
+		}
+	}
 	return returnedError
 }
 
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"testing/fstest"
 	"time"
@@ -271,6 +272,160 @@ func TestPurge(t *testing.T) {
 	})
 }
 
+func TestPurgeLocked(t *testing.T) {
+	newManager := func(tempDir string) *Manager {
+		return &Manager{
+			storageDir: tempDir,
+			disk: &disk{
+				storageDirFS:   os.DirFS(tempDir),
+				general:        diskSpace1,
+				diskUsageBytes: highUsage,
+			},
+			removeAll: os.RemoveAll,
+			logger:    log.NewDummyLogger(),
+		}
+	}
+	writeRecording := func(t *testing.T, dir string, recID string, locked bool) {
+		t.Helper()
+		data := `{}`
+		if locked {
+			data = `{"locked":true}`
+		}
+		require.NoError(t, os.MkdirAll(dir, 0o700))
+		writeFile(t, filepath.Join(dir, recID+".mp4"), 10)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, recID+".json"), []byte(data), 0o600))
+	}
+
+	t.Run("sameDay", func(t *testing.T) {
+		tempDir := t.TempDir()
+		dayDir := filepath.Join(tempDir, "recordings/2000/01/01")
+		writeRecording(t, filepath.Join(dayDir, "m1"), "2000-01-01_01-01-01_m1", true)
+		writeRecording(t, filepath.Join(dayDir, "m1"), "2000-01-01_02-02-02_m1", false)
+		writeRecording(t, filepath.Join(dayDir, "m2"), "2000-01-01_01-01-01_m2", false)
+
+		require.NoError(t, newManager(tempDir).prune())
+		require.Equal(t,
+			[]string{"2000-01-01_01-01-01_m1.json", "2000-01-01_01-01-01_m1.mp4"},
+			listDirectory(t, filepath.Join(dayDir, "m1")),
+		)
+		require.NoDirExists(t, filepath.Join(dayDir, "m2"))
+	})
+	t.Run("skipLockedDay", func(t *testing.T) {
+		tempDir := t.TempDir()
+		day1 := filepath.Join(tempDir, "recordings/2000/01/01/m1")
+		day2 := filepath.Join(tempDir, "recordings/2000/01/02/m1")
+		day3 := filepath.Join(tempDir, "recordings/2000/01/03/m1")
+		writeRecording(t, day1, "2000-01-01_01-01-01_m1", true)
+		writeRecording(t, day2, "2000-01-02_01-01-01_m1", false)
+		writeRecording(t, day3, "2000-01-03_01-01-01_m1", false)
+
+		// The oldest day is locked, the next day is deleted instead.
+		require.NoError(t, newManager(tempDir).prune())
+		require.Equal(t,
+			[]string{"2000-01-01_01-01-01_m1.json", "2000-01-01_01-01-01_m1.mp4"},
+			listDirectory(t, day1),
+		)
+		require.NoDirExists(t, filepath.Dir(day2))
+		require.DirExists(t, day3)
+	})
+	t.Run("allLocked", func(t *testing.T) {
+		tempDir := t.TempDir()
+		day1 := filepath.Join(tempDir, "recordings/2000/01/01/m1")
+		day2 := filepath.Join(tempDir, "recordings/2000/02/01/m1")
+		writeRecording(t, day1, "2000-01-01_01-01-01_m1", true)
+		writeRecording(t, day2, "2000-02-01_01-01-01_m1", true)
+
+		require.NoError(t, newManager(tempDir).prune())
+		require.DirExists(t, day1)
+		require.DirExists(t, day2)
+	})
+}
+
+func TestLockedWarning(t *testing.T) {
+	tempDir := t.TempDir()
+	recDir := filepath.Join(tempDir, "recordings/2000/01/01/m1")
+	require.NoError(t, os.MkdirAll(recDir, 0o700))
+	writeFile(t, filepath.Join(recDir, "2000-01-01_01-01-01_m1.mp4"), 400)
+	writeFile(t, filepath.Join(recDir, "2000-01-01_02-02-02_m1.mp4"), 400)
+	require.NoError(t, os.WriteFile(
+		filepath.Join(recDir, "2000-01-01_01-01-01_m1.json"), []byte(`{"locked":true}`), 0o600))
+
+	general := &ConfigGeneral{Config: map[string]string{"diskSpace": "0.000001"}}
+	m := NewManager(tempDir, nil, general, log.NewDummyLogger())
+
+	usage, err := m.DiskUsage(0)
+	require.NoError(t, err)
+	require.Equal(t, int64(415), usage.Locked)
+	require.Equal(t, 41, usage.LockedPercent)
+	require.False(t, usage.LockedWarning)
+
+	general.Config["maxLockedPercent"] = "40"
+	usage, _ = m.DiskUsageCached()
+	require.True(t, usage.LockedWarning)
+}
+
+// newTestManager returns a manager with two storage directories, the
+// main directory has 1KB of space and the second directory 1GB.
+func newTestManager(t *testing.T, priority int) (*Manager, string, string) {
+	t.Helper()
+	dir1, dir2 := t.TempDir(), t.TempDir()
+	general := &ConfigGeneral{Config: map[string]string{"diskSpace": "0.000001"}}
+	m := NewManager(
+		dir1,
+		[]StorageDirConfig{{Path: dir2, Priority: priority, DiskSpace: 1}},
+		general,
+		log.NewDummyLogger(),
+	)
+	return m, filepath.Join(dir1, "recordings"), filepath.Join(dir2, "recordings")
+}
+
+func writeFile(t *testing.T, path string, size int) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+	require.NoError(t, os.WriteFile(path, bytes.Repeat([]byte{0}, size), 0o600))
+}
+
+func TestNextRecordingsDir(t *testing.T) {
+	t.Run("spillover", func(t *testing.T) {
+		m, recDir1, recDir2 := newTestManager(t, 0)
+		require.Equal(t, recDir1, m.NextRecordingsDir())
+
+		// Nearly full.
+		writeFile(t, filepath.Join(recDir1, "2000/01/01/m1/a.mp4"), 960)
+		m.disk.lastUpdate = time.Time{}
+		require.Equal(t, recDir2, m.NextRecordingsDir())
+	})
+	t.Run("priority", func(t *testing.T) {
+		m, _, recDir2 := newTestManager(t, 1)
+		require.Equal(t, recDir2, m.NextRecordingsDir())
+	})
+	t.Run("allFull", func(t *testing.T) {
+		m, recDir1, recDir2 := newTestManager(t, 0)
+		writeFile(t, filepath.Join(recDir1, "a"), 1000)
+		writeFile(t, filepath.Join(recDir2, "a"), 1000)
+		m.extraDirs[0].disk.space = 1000
+		require.Equal(t, recDir1, m.NextRecordingsDir())
+	})
+}
+
+func TestPruneStorageDirs(t *testing.T) {
+	m, recDir1, recDir2 := newTestManager(t, 0)
+	writeFile(t, filepath.Join(recDir1, "2000/01/01/m1/a.mp4"), 500)
+	writeFile(t, filepath.Join(recDir1, "2000/01/02/m1/a.mp4"), 500)
+	writeFile(t, filepath.Join(recDir2, "2000/01/01/m1/a.mp4"), 500)
+
+	// Only the full disk is pruned.
+	require.NoError(t, m.prune())
+	require.NoDirExists(t, filepath.Join(recDir1, "2000/01/01"))
+	require.DirExists(t, filepath.Join(recDir1, "2000/01/02"))
+	require.DirExists(t, filepath.Join(recDir2, "2000/01/01"))
+
+	usage, err := m.DiskUsage(0)
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), usage.Used)
+	require.Equal(t, int64(1), usage.Max)
+}
+
 func writeEmptyDirs(t *testing.T, base string, paths []string) {
 	t.Helper()
 	for _, path := range paths {
@@ -366,7 +521,7 @@ func newTestEnv(t *testing.T) (string, *ConfigEnv, func()) {
 	err = os.WriteFile(goBin, []byte{}, 0o600)
 	require.NoError(t, err)
 
-	err = os.WriteFile(ffmpegBin, []byte{}, 0o600)
+	err = os.WriteFile(ffmpegBin, []byte{}, 0o700)
 	require.NoError(t, err)
 
 	env := &ConfigEnv{
@@ -454,53 +609,193 @@ func TestNewConfigEnv(t *testing.T) {
 		_, err = NewConfigEnv(envPath, envYAML)
 		require.ErrorIs(t, err, os.ErrNotExist)
 	})
-	t.Run("storageAbs", func(t *testing.T) {
+	t.Run("logFormat", func(t *testing.T) {
 		envPath, testEnv, cancel := newTestEnv(t)
 		defer cancel()
 
-		testEnv.StorageDir = "."
+		testEnv.LogFormat = "nil"
 
 		envYAML, err := yaml.Marshal(testEnv)
 		require.NoError(t, err)
 
 		_, err = NewConfigEnv(envPath, envYAML)
-		require.ErrorIs(t, err, ErrPathNotAbsolute)
+		require.ErrorIs(t, err, log.ErrUnknownFormat)
 	})
-	t.Run("goBinAbs", func(t *testing.T) {
+	t.Run("storageDirs", func(t *testing.T) {
 		envPath, testEnv, cancel := newTestEnv(t)
 		defer cancel()
 
-		testEnv.GoBin = "."
-
+		a := filepath.Join(testEnv.HomeDir, "a")
+		b := filepath.Join(testEnv.HomeDir, "b")
+		c := filepath.Join(testEnv.HomeDir, "c")
+		testEnv.StorageDirs = []StorageDirConfig{
+			{Path: a, Priority: -1, DiskSpace: 1},
+			{Path: b, Priority: 1, DiskSpace: 2},
+			{Path: c},
+		}
 		envYAML, err := yaml.Marshal(testEnv)
 		require.NoError(t, err)
 
-		_, err = NewConfigEnv(envPath, envYAML)
-		require.ErrorIs(t, err, ErrPathNotAbsolute)
+		env, err := NewConfigEnv(envPath, envYAML)
+		require.NoError(t, err)
+		require.Equal(t, testEnv.StorageDirs, env.StorageDirs)
+
+		expected := RecordingsDirs{
+			filepath.Join(b, "recordings"),
+			testEnv.RecordingsDir(),
+			filepath.Join(c, "recordings"),
+			filepath.Join(a, "recordings"),
+		}
+		require.Equal(t, expected, env.RecordingsDirs())
+	})
+	t.Run("storageDirsErrors", func(t *testing.T) {
+		cases := map[string]struct {
+			dir StorageDirConfig
+			err error
+		}{
+			"missing":   {StorageDirConfig{Path: ""}, ErrValueMissing},
+			"duplicate": {StorageDirConfig{Path: "../storage/"}, ErrDuplicateStorageDir},
+			"diskSpace": {StorageDirConfig{Path: "/a", DiskSpace: -1}, ErrInvalidDiskSpace},
+			"notDir":    {StorageDirConfig{Path: "../go"}, ErrNotDir},
+		}
+		for name, tc := range cases {
+			t.Run(name, func(t *testing.T) {
+				envPath, testEnv, cancel := newTestEnv(t)
+				defer cancel()
+
+				testEnv.StorageDirs = []StorageDirConfig{tc.dir}
+				envYAML, err := yaml.Marshal(testEnv)
+				require.NoError(t, err)
+
+				_, err = NewConfigEnv(envPath, envYAML)
+				require.ErrorIs(t, err, tc.err)
+			})
+		}
 	})
-	t.Run("ffmpegBinAbs", func(t *testing.T) {
+	t.Run("rtspBind", func(t *testing.T) {
+		cases := map[string]struct {
+			address  string
+			expose   bool
+			auth     bool
+			insecure bool
+			err      error
+		}{
+			"default":          {"", false, false, false, nil},
+			"loopback":         {"127.0.0.2", false, false, false, nil},
+			"loopbackIPv6":     {"::1", false, false, false, nil},
+			"allAuth":          {"0.0.0.0", false, true, false, nil},
+			"interfaceAuth":    {"192.168.1.10", false, true, false, nil},
+			"allInsecure":      {"0.0.0.0", false, false, true, nil},
+			"all":              {"0.0.0.0", false, false, false, ErrRTSPUnprotected},
+			"interface":        {"192.168.1.10", false, false, false, ErrRTSPUnprotected},
+			"allIPv6":          {"::", false, false, false, ErrRTSPUnprotected},
+			"expose":           {"", true, false, false, ErrRTSPUnprotected},
+			"exposeAuth":       {"", true, true, false, nil},
+			"addressOverrides": {"127.0.0.1", true, false, false, nil},
+			"hostname":         {"localhost", false, false, false, ErrInvalidBindAddress},
+			"port":             {"127.0.0.1:2021", false, false, false, ErrInvalidBindAddress},
+		}
+		for name, tc := range cases {
+			t.Run(name, func(t *testing.T) {
+				envPath, testEnv, cancel := newTestEnv(t)
+				defer cancel()
+
+				testEnv.RTSPBindAddress = tc.address
+				testEnv.RTSPPortExpose = tc.expose
+				testEnv.RTSPAuth = tc.auth
+				testEnv.RTSPInsecure = tc.insecure
+				envYAML, err := yaml.Marshal(testEnv)
+				require.NoError(t, err)
+
+				_, err = NewConfigEnv(envPath, envYAML)
+				require.ErrorIs(t, err, tc.err)
+			})
+		}
+	})
+	t.Run("relative", func(t *testing.T) {
 		envPath, testEnv, cancel := newTestEnv(t)
 		defer cancel()
 
-		testEnv.FFmpegBin = "."
+		envYAML := []byte("goBin: ../go\n" +
+			"ffmpegBin: ./../ffmpeg\n" +
+			"homeDir: ..\n" +
+			"storageDir: storage\n" +
+			"storageDirs:\n  - path: ../a\n")
+
+		env, err := NewConfigEnv(envPath, envYAML)
+		require.NoError(t, err)
+		require.Equal(t, testEnv.GoBin, env.GoBin)
+		require.Equal(t, testEnv.FFmpegBin, env.FFmpegBin)
+		require.Equal(t, testEnv.HomeDir, env.HomeDir)
+		require.Equal(t, filepath.Join(testEnv.ConfigDir, "storage"), env.StorageDir)
+		require.Equal(t, filepath.Join(testEnv.HomeDir, "a"), env.StorageDirs[0].Path)
+	})
+	t.Run("expand", func(t *testing.T) {
+		envPath, testEnv, cancel := newTestEnv(t)
+		defer cancel()
+
+		t.Setenv("HOME", testEnv.HomeDir)
+		t.Setenv("NVR_TEST_STORAGE", "x")
+		envYAML := []byte("goBin: ~/go\n" +
+			"ffmpegBin: $HOME/ffmpeg\n" +
+			"homeDir: ~\n" +
+			"storageDir: ${HOME}/${NVR_TEST_STORAGE}\n")
+
+		env, err := NewConfigEnv(envPath, envYAML)
+		require.NoError(t, err)
+		require.Equal(t, testEnv.GoBin, env.GoBin)
+		require.Equal(t, testEnv.FFmpegBin, env.FFmpegBin)
+		require.Equal(t, testEnv.HomeDir, env.HomeDir)
+		require.Equal(t, filepath.Join(testEnv.HomeDir, "x"), env.StorageDir)
+	})
+	t.Run("undefinedEnvVar", func(t *testing.T) {
+		envPath, _, cancel := newTestEnv(t)
+		defer cancel()
+
+		envYAML := []byte("storageDir: ${NVR_TEST_NIL}/a\nhomeDir: $NVR_TEST_NIL2\n")
+		_, err := NewConfigEnv(envPath, envYAML)
+		require.ErrorIs(t, err, ErrUndefinedEnvVar)
+		require.Contains(t, err.Error(), "storageDir")
+		require.Contains(t, err.Error(), "NVR_TEST_NIL")
+		require.Contains(t, err.Error(), "homeDir")
+		require.Contains(t, err.Error(), "NVR_TEST_NIL2")
+	})
+	t.Run("aggregateError", func(t *testing.T) {
+		envPath, testEnv, cancel := newTestEnv(t)
+		defer cancel()
+
+		require.NoError(t, os.Chmod(testEnv.FFmpegBin, 0o600))
+		testEnv.GoBin = "/dev/null/nil"
+		testEnv.StorageDir = testEnv.FFmpegBin
+		testEnv.LogFormat = "nil"
+		testEnv.StorageDirs = []StorageDirConfig{{Path: "/a", DiskSpace: -1}}
 
 		envYAML, err := yaml.Marshal(testEnv)
 		require.NoError(t, err)
 
 		_, err = NewConfigEnv(envPath, envYAML)
-		require.ErrorIs(t, err, ErrPathNotAbsolute)
+		require.ErrorIs(t, err, os.ErrNotExist)
+		require.ErrorIs(t, err, ErrNotExecutable)
+		require.ErrorIs(t, err, ErrNotDir)
+		require.ErrorIs(t, err, log.ErrUnknownFormat)
+		require.ErrorIs(t, err, ErrInvalidDiskSpace)
+
+		lines := strings.Split(err.Error(), "\n")
+		require.Len(t, lines, 5)
+		require.True(t, strings.HasPrefix(lines[0], "goBin '/dev/null/nil'"), lines[0])
+		require.True(t, strings.HasPrefix(lines[1], "ffmpegBin '"), lines[1])
+		require.True(t, strings.HasPrefix(lines[2], "storageDir '"), lines[2])
 	})
-	t.Run("homeDirAbs", func(t *testing.T) {
+	t.Run("binIsDir", func(t *testing.T) {
 		envPath, testEnv, cancel := newTestEnv(t)
 		defer cancel()
 
-		testEnv.HomeDir = "."
-
+		testEnv.FFmpegBin = testEnv.HomeDir
 		envYAML, err := yaml.Marshal(testEnv)
 		require.NoError(t, err)
 
 		_, err = NewConfigEnv(envPath, envYAML)
-		require.ErrorIs(t, err, ErrPathNotAbsolute)
+		require.ErrorIs(t, err, ErrIsDir)
 	})
 	t.Run("CensorLog", func(t *testing.T) {
 		cases := map[string]struct {
@@ -530,6 +825,58 @@ func TestNewConfigEnv(t *testing.T) {
 	})
 }
 
+func TestParseConfigEnv(t *testing.T) {
+	envPath, _, cancel := newTestEnv(t)
+	defer cancel()
+
+	envYAML := []byte("ffmpegBin: /nil/ffmpeg\nstorageDir: ../storage\n")
+
+	// The paths are resolved but not checked.
+	env, err := ParseConfigEnv(envPath, envYAML)
+	require.NoError(t, err)
+	require.Equal(t, "/nil/ffmpeg", env.FFmpegBin)
+	require.Equal(t, filepath.Join(filepath.Dir(env.ConfigDir), "storage"), env.StorageDir)
+
+	_, err = NewConfigEnv(envPath, envYAML)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSetConfigEnvPaths(t *testing.T) {
+	t.Run("replace", func(t *testing.T) {
+		envYAML := "# comment\n" +
+			"port: 2020\n" +
+			"ffmpegBin: /usr/bin/ffmpeg\n" +
+			"storageDir: /old\n"
+
+		actual, err := SetConfigEnvPaths([]byte(envYAML), "/new", "/bin/ffmpeg")
+		require.NoError(t, err)
+
+		expected := "# comment\n" +
+			"port: 2020\n" +
+			"ffmpegBin: /bin/ffmpeg\n" +
+			"storageDir: /new\n"
+		require.Equal(t, expected, string(actual))
+	})
+	t.Run("add", func(t *testing.T) {
+		actual, err := SetConfigEnvPaths([]byte("port: 2020\n"), "/new", "/bin/ffmpeg")
+		require.NoError(t, err)
+
+		expected := "port: 2020\n" +
+			"storageDir: /new\n" +
+			"ffmpegBin: /bin/ffmpeg\n"
+		require.Equal(t, expected, string(actual))
+	})
+	t.Run("empty", func(t *testing.T) {
+		actual, err := SetConfigEnvPaths(nil, "/new", "/bin/ffmpeg")
+		require.NoError(t, err)
+		require.Equal(t, "storageDir: /new\nffmpegBin: /bin/ffmpeg\n", string(actual))
+	})
+	t.Run("notMapping", func(t *testing.T) {
+		_, err := SetConfigEnvPaths([]byte("- a\n"), "/new", "/bin/ffmpeg")
+		require.ErrorIs(t, err, ErrNotMapping)
+	})
+}
+
 func TestPrepareEnvironment(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		tempDir, err := os.MkdirTemp("", "")
@@ -554,6 +901,17 @@ func TestPrepareEnvironment(t *testing.T) {
 		require.DirExists(t, env.RecordingsDir())
 		require.NoFileExists(t, testFile)
 	})
+	t.Run("storageDirs", func(t *testing.T) {
+		tempDir := t.TempDir()
+		env := &ConfigEnv{
+			StorageDir:  filepath.Join(tempDir, "storage"),
+			StorageDirs: []StorageDirConfig{{Path: filepath.Join(tempDir, "storage2")}},
+			TempDir:     filepath.Join(tempDir, "temp"),
+		}
+		require.NoError(t, env.PrepareEnvironment())
+		require.DirExists(t, filepath.Join(tempDir, "storage", "recordings"))
+		require.DirExists(t, filepath.Join(tempDir, "storage2", "recordings"))
+	})
 }
 
 func newTestGeneral(t *testing.T) (string, *ConfigGeneral, func()) {
@@ -684,8 +1042,11 @@ func TestDeleteRecording(t *testing.T) {
 		files := []string{
 			recID + ".jpeg",
 			recID + ".json",
+			recID + ".mdat",
+			recID + ".meta",
 			recID + ".mp4",
 			recID + ".x",
+			"2000-01-01_02-02-02_m10.json",
 			"2000-01-01_02-02-02_x1.mp4",
 		}
 		require.NoError(t, os.MkdirAll(recDir, 0o700))
@@ -695,7 +1056,47 @@ func TestDeleteRecording(t *testing.T) {
 		err := DeleteRecording(recordingsDir, recID)
 		require.NoError(t, err)
 		require.Equal(t,
-			[]string{"2000-01-01_02-02-02_x1.mp4"},
+			[]string{"2000-01-01_02-02-02_m10.json", "2000-01-01_02-02-02_x1.mp4"},
+			listDirectory(t, recDir),
+		)
+	})
+	t.Run("inProgressErr", func(t *testing.T) {
+		recordingsDir := t.TempDir()
+		recDir := filepath.Join(recordingsDir, "2000", "01", "01", "m1")
+		recID := "2000-01-01_02-02-02_m1"
+		files := []string{recID + ".jpeg", recID + ".mdat", recID + ".meta"}
+		require.NoError(t, os.MkdirAll(recDir, 0o700))
+		createFiles(t, recDir, files)
+
+		err := DeleteRecording(recordingsDir, recID)
+		require.ErrorIs(t, err, ErrRecordingInProgress)
+		require.Equal(t, files, listDirectory(t, recDir))
+	})
+	t.Run("activeErr", func(t *testing.T) {
+		recordingsDir := t.TempDir()
+		recDir := filepath.Join(recordingsDir, "2000", "01", "01", "m1")
+		recID := "2000-01-01_02-02-02_m1"
+		files := []string{recID + ".json", recID + ".mdat.part", recID + ".meta.part"}
+		require.NoError(t, os.MkdirAll(recDir, 0o700))
+		createFiles(t, recDir, files)
+
+		err := DeleteRecording(recordingsDir, recID)
+		require.ErrorIs(t, err, ErrRecordingInProgress)
+		require.Equal(t, files, listDirectory(t, recDir))
+	})
+	t.Run("lockedErr", func(t *testing.T) {
+		recordingsDir := t.TempDir()
+		recDir := filepath.Join(recordingsDir, "2000", "01", "01", "m1")
+		recID := "2000-01-01_02-02-02_m1"
+		require.NoError(t, os.MkdirAll(recDir, 0o700))
+		createFiles(t, recDir, []string{recID + ".json", recID + ".meta"})
+		require.NoError(t, os.WriteFile(
+			filepath.Join(recDir, recID+".json"), []byte(`{"locked":true}`), 0o600))
+
+		err := DeleteRecording(recordingsDir, recID)
+		require.ErrorIs(t, err, ErrRecordingLocked)
+		require.Equal(t,
+			[]string{recID + ".json", recID + ".meta"},
 			listDirectory(t, recDir),
 		)
 	})
@@ -0,0 +1,281 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DaySummary recordings of a single calendar day.
+type DaySummary struct {
+	// YYYY-MM-DD
+	Day string `json:"day"`
+
+	// Number of recordings that started on the day.
+	Recordings int `json:"recordings"`
+
+	// Recorded seconds within the day. Recordings that
+	// cross midnight are split between the days.
+	Duration float64 `json:"duration"`
+
+	// Size of the videos that started on the day.
+	Bytes int64 `json:"bytes"`
+
+	// Number of recordings with at least one event.
+	Events int `json:"events"`
+}
+
+// MonthSummary per day summary of a month, days
+// without recordings are omitted.
+type MonthSummary struct {
+	Year  int          `json:"year"`
+	Month int          `json:"month"`
+	Days  []DaySummary `json:"days"`
+}
+
+// recordingSummary the fields of a recording used by the summary.
+type recordingSummary struct {
+	monitorID string
+	start     time.Time
+	end       time.Time
+	bytes     int64
+	events    int
+
+	// Used to detect changes to the data file.
+	dataModTime time.Time
+	dataSize    int64
+}
+
+// SummaryIndex summarizes recordings per calendar day. The data
+// files are only read the first time a recording is seen or
+// after the file changed, the directories are listed every time.
+type SummaryIndex struct {
+	fs fs.FS
+
+	mu sync.Mutex
+	// Key is the day directory "YYYY/MM/DD", the value
+	// is the recordings in the directory by recording ID.
+	days map[string]map[string]recordingSummary
+}
+
+// NewSummaryIndex creates a summary index.
+func NewSummaryIndex(fileSystem fs.FS) *SummaryIndex {
+	return &SummaryIndex{
+		fs:   fileSystem,
+		days: make(map[string]map[string]recordingSummary),
+	}
+}
+
+// ErrInvalidMonth invalid month.
+var ErrInvalidMonth = errors.New("invalid month")
+
+// MonthSummary returns the summary of the month in the location. All
+// monitors are included if monitors is empty. Days are calendar days
+// in the location, a day may be 23 or 25 hours long on DST changes.
+func (s *SummaryIndex) MonthSummary(
+	year int,
+	month time.Month,
+	loc *time.Location,
+	monitors []string,
+) (*MonthSummary, error) {
+	if month < time.January || month > time.December {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMonth, int(month))
+	}
+	monthStart := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	monthEnd := monthStart.AddDate(0, 1, 0)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The directories are named after the start time in the timezone of
+	// the recorder, which may differ from the location. The adjacent days
+	// are included to find recordings that cross into the month.
+	dayDirs, err := s.monthDayDirs(monthStart)
+	if err != nil {
+		return nil, err
+	}
+	dayDirs = append(dayDirs,
+		monthStart.AddDate(0, 0, -1).Format("2006/01/02"),
+		monthEnd.Format("2006/01/02"),
+	)
+
+	selected := make(map[string]bool, len(monitors))
+	for _, m := range monitors {
+		selected[m] = true
+	}
+
+	days := make(map[int]*DaySummary)
+	dayAt := func(t time.Time) *DaySummary {
+		day, exist := days[t.Day()]
+		if !exist {
+			day = &DaySummary{Day: t.Format("2006-01-02")}
+			days[t.Day()] = day
+		}
+		return day
+	}
+
+	for _, dayDir := range dayDirs {
+		recordings, err := s.updateDay(dayDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range recordings {
+			if len(selected) != 0 && !selected[rec.monitorID] {
+				continue
+			}
+			start := rec.start.In(loc)
+			end := rec.end.In(loc)
+			if !end.After(monthStart) || !start.Before(monthEnd) {
+				continue
+			}
+
+			if !start.Before(monthStart) {
+				day := dayAt(start)
+				day.Recordings++
+				day.Bytes += rec.bytes
+				if rec.events != 0 {
+					day.Events++
+				}
+			}
+
+			// Split the duration at midnight.
+			for t := maxTime(start, monthStart); t.Before(end) && t.Before(monthEnd); {
+				nextDay := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+				until := minTime(end, nextDay)
+				dayAt(t).Duration += until.Sub(t).Seconds()
+				t = nextDay
+			}
+		}
+	}
+
+	summary := &MonthSummary{
+		Year:  year,
+		Month: int(month),
+		Days:  []DaySummary{},
+	}
+	for d := 1; d <= 31; d++ {
+		if day, exist := days[d]; exist {
+			summary.Days = append(summary.Days, *day)
+		}
+	}
+	return summary, nil
+}
+
+// monthDayDirs returns the day directories of the month.
+func (s *SummaryIndex) monthDayDirs(monthStart time.Time) ([]string, error) {
+	monthDir := monthStart.Format("2006/01")
+	entries, err := fs.ReadDir(s.fs, monthDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read month directory: %w", err)
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, path.Join(monthDir, entry.Name()))
+		}
+	}
+	return dirs, nil
+}
+
+// updateDay lists the day directory and updates the cached recordings.
+// Recordings that no longer exist are removed from the cache.
+func (s *SummaryIndex) updateDay(dayDir string) (map[string]recordingSummary, error) {
+	monitorDirs, err := fs.ReadDir(s.fs, dayDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		delete(s.days, dayDir)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read day directory: %w", err)
+	}
+
+	cached := s.days[dayDir]
+	updated := make(map[string]recordingSummary)
+	for _, monitorDir := range monitorDirs {
+		if !monitorDir.IsDir() {
+			continue
+		}
+		monitorID := monitorDir.Name()
+		monitorPath := path.Join(dayDir, monitorID)
+		files, err := fs.ReadDir(s.fs, monitorPath)
+		if err != nil {
+			return nil, fmt.Errorf("read monitor directory: %w", err)
+		}
+		for _, file := range files {
+			recID, isData := strings.CutSuffix(file.Name(), ".json")
+			if !isData || file.IsDir() {
+				continue
+			}
+			info, err := file.Info()
+			if err != nil {
+				continue
+			}
+
+			rec, exist := cached[recID]
+			if !exist || !rec.dataModTime.Equal(info.ModTime()) || rec.dataSize != info.Size() {
+				rec, err = s.readRecording(path.Join(monitorPath, recID), monitorID, info)
+				if err != nil {
+					// Incomplete or corrupt data file.
+					continue
+				}
+			}
+			updated[recID] = rec
+		}
+	}
+	s.days[dayDir] = updated
+	return updated, nil
+}
+
+func (s *SummaryIndex) readRecording(
+	recPath string,
+	monitorID string,
+	dataInfo fs.FileInfo,
+) (recordingSummary, error) {
+	rawData, err := fs.ReadFile(s.fs, recPath+".json")
+	if err != nil {
+		return recordingSummary{}, err
+	}
+	var data RecordingData
+	if err := json.Unmarshal(rawData, &data); err != nil {
+		return recordingSummary{}, err
+	}
+
+	var bytes int64
+	if videoInfo, err := fs.Stat(s.fs, recPath+".mp4"); err == nil {
+		bytes = videoInfo.Size()
+	}
+
+	return recordingSummary{
+		monitorID:   monitorID,
+		start:       data.Start,
+		end:         data.End,
+		bytes:       bytes,
+		events:      len(data.Events),
+		dataModTime: dataInfo.ModTime(),
+		dataSize:    dataInfo.Size(),
+	}, nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
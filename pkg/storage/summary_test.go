@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"encoding/json"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSummaryFS(t *testing.T, loc *time.Location) fstest.MapFS {
+	t.Helper()
+	date := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2024, month, day, hour, min, 0, 0, loc)
+	}
+	m := fstest.MapFS{}
+	add := func(dir string, recID string, start, end time.Time, events int, size int) {
+		data := RecordingData{Start: start.UTC(), End: end.UTC()}
+		for i := 0; i < events; i++ {
+			data.Events = append(data.Events, Event{Time: start})
+		}
+		rawData, err := json.Marshal(data)
+		require.NoError(t, err)
+		m[dir+"/"+recID+".json"] = &fstest.MapFile{Data: rawData}
+		m[dir+"/"+recID+".mp4"] = &fstest.MapFile{Data: make([]byte, size)}
+	}
+
+	// Previous month, crosses midnight into the 1st.
+	add("2024/02/29/m2", "2024-02-29_23-00-00_m2", date(2, 29, 23, 0), date(3, 1, 1, 0), 0, 1)
+
+	// Crosses midnight.
+	add("2024/03/09/m1", "2024-03-09_23-30-00_m1", date(3, 9, 23, 30), date(3, 10, 0, 30), 1, 10)
+
+	// The DST change day is 23 hours long.
+	add("2024/03/10/m1", "2024-03-10_00-30-00_m1", date(3, 10, 0, 30), date(3, 11, 0, 0), 2, 100)
+
+	// Directory named after the UTC date.
+	add("2024/03/21/m2", "2024-03-21_02-00-00_m2", date(3, 20, 22, 0), date(3, 20, 22, 10), 0, 1000)
+
+	// Crosses into the next month.
+	add("2024/03/31/m2", "2024-03-31_23-30-00_m2", date(3, 31, 23, 30), date(4, 1, 0, 30), 0, 10000)
+
+	// Next month.
+	add("2024/04/01/m1", "2024-04-01_01-00-00_m1", date(4, 1, 1, 0), date(4, 1, 2, 0), 0, 1)
+
+	// Invalid data file.
+	m["2024/03/05/m1/2024-03-05_00-00-00_m1.json"] = &fstest.MapFile{Data: []byte("{")}
+	return m
+}
+
+// openCounter counts the opened data files.
+type openCounter struct {
+	fs.FS
+	dataFiles int
+}
+
+func (c *openCounter) Open(name string) (fs.File, error) {
+	if strings.HasSuffix(name, ".json") {
+		c.dataFiles++
+	}
+	return c.FS.Open(name)
+}
+
+func TestSummaryIndex(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	t.Run("allMonitors", func(t *testing.T) {
+		index := NewSummaryIndex(newTestSummaryFS(t, loc))
+		summary, err := index.MonthSummary(2024, time.March, loc, nil)
+		require.NoError(t, err)
+
+		expected := &MonthSummary{
+			Year:  2024,
+			Month: 3,
+			Days: []DaySummary{
+				{Day: "2024-03-01", Duration: 3600},
+				{Day: "2024-03-09", Recordings: 1, Duration: 1800, Bytes: 10, Events: 1},
+				{Day: "2024-03-10", Recordings: 1, Duration: 1800 + 22.5*3600, Bytes: 100, Events: 1},
+				{Day: "2024-03-20", Recordings: 1, Duration: 600, Bytes: 1000},
+				{Day: "2024-03-31", Recordings: 1, Duration: 1800, Bytes: 10000},
+			},
+		}
+		require.Equal(t, expected, summary)
+	})
+	t.Run("monitor", func(t *testing.T) {
+		index := NewSummaryIndex(newTestSummaryFS(t, loc))
+		summary, err := index.MonthSummary(2024, time.April, loc, []string{"m1"})
+		require.NoError(t, err)
+
+		expected := []DaySummary{
+			{Day: "2024-04-01", Recordings: 1, Duration: 3600, Bytes: 1},
+		}
+		require.Equal(t, expected, summary.Days)
+	})
+	t.Run("empty", func(t *testing.T) {
+		index := NewSummaryIndex(newTestSummaryFS(t, loc))
+		summary, err := index.MonthSummary(2023, time.January, loc, nil)
+		require.NoError(t, err)
+		require.Equal(t, []DaySummary{}, summary.Days)
+	})
+	t.Run("invalidMonth", func(t *testing.T) {
+		index := NewSummaryIndex(newTestSummaryFS(t, loc))
+		_, err := index.MonthSummary(2024, 13, loc, nil)
+		require.ErrorIs(t, err, ErrInvalidMonth)
+	})
+	t.Run("cache", func(t *testing.T) {
+		mapFS := newTestSummaryFS(t, loc)
+		counter := &openCounter{FS: mapFS}
+		index := NewSummaryIndex(counter)
+
+		_, err := index.MonthSummary(2024, time.March, loc, []string{"m1"})
+		require.NoError(t, err)
+		require.Equal(t, 7, counter.dataFiles)
+
+		// Only the invalid file is read again.
+		summary, err := index.MonthSummary(2024, time.March, loc, []string{"m1"})
+		require.NoError(t, err)
+		require.Equal(t, 8, counter.dataFiles)
+		require.Len(t, summary.Days, 2)
+
+		// Changed data file.
+		recPath := "2024/03/09/m1/2024-03-09_23-30-00_m1"
+		mapFS[recPath+".json"].ModTime = time.Unix(1, 0)
+		_, err = index.MonthSummary(2024, time.March, loc, []string{"m1"})
+		require.NoError(t, err)
+		require.Equal(t, 10, counter.dataFiles)
+
+		// Deleted recording.
+		delete(mapFS, recPath+".json")
+		delete(mapFS, recPath+".mp4")
+		summary, err = index.MonthSummary(2024, time.March, loc, []string{"m1"})
+		require.NoError(t, err)
+		require.Equal(t, 11, counter.dataFiles)
+		expected := []DaySummary{
+			{Day: "2024-03-10", Recordings: 1, Duration: 22.5 * 3600, Bytes: 100, Events: 1},
+		}
+		require.Equal(t, expected, summary.Days)
+	})
+}
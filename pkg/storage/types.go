@@ -13,6 +13,19 @@ import (
 type Recording struct {
 	ID   string         `json:"id"`
 	Data *RecordingData `json:"data"`
+
+	// Only set if the data is included.
+	Times *RecordingTimes `json:"times,omitempty"`
+
+	// Names of the video variants that exist next to
+	// the recording, only set if the data is included.
+	Variants []string `json:"variants,omitempty"`
+}
+
+// RecordingVariants are the alternative videos that may be generated
+// next to a recording, variant name mapped to the file extension.
+var RecordingVariants = map[string]string{
+	"annotated": ".annotated.mp4",
 }
 
 // RecordingData recording data marshaled to json and saved next to video and thumbnail.
@@ -20,6 +33,27 @@ type RecordingData struct {
 	Start  time.Time `json:"start"`
 	End    time.Time `json:"end"`
 	Events []Event   `json:"events"`
+
+	// IANA time zone of the monitor, empty if the monitor
+	// didn't have a time zone or the recording is older.
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Wall clock steps that were detected during the recording.
+	ClockCorrections []ClockCorrection `json:"clockCorrections,omitempty"`
+
+	// IDs of the adjacent recordings if the
+	// recording was split by the video length.
+	Previous string `json:"previous,omitempty"`
+	Next     string `json:"next,omitempty"`
+
+	// Locked recordings are skipped by the storage pruning.
+	Locked bool `json:"locked,omitempty"`
+
+	// Checksums of the video files, not included in queries.
+	Checksums *Checksums `json:"checksums,omitempty"`
+
+	// Result of the last checksum verification.
+	Verification *Verification `json:"verification,omitempty"`
 }
 
 // Events .
@@ -43,12 +77,22 @@ func (e *Events) QueryAndPrune(start, end time.Time) Events {
 	return returnEvents
 }
 
+// Event sources of the core triggers, addons use their log source.
+const (
+	EventSourceAPI        = "api"
+	EventSourceContinuous = "continuous"
+)
+
 // Event is a recording trigger event.
 type Event struct {
 	Time        time.Time     `json:"time,omitempty"`
 	Detections  []Detection   `json:"detections,omitempty"`
 	Duration    time.Duration `json:"duration,omitempty"`
 	RecDuration time.Duration `json:"-"`
+
+	// Trigger that produced the event, like "doods" or "api".
+	// Empty in recordings that were saved before it was added.
+	Source string `json:"source,omitempty"`
 }
 
 func (e Event) String() string {
@@ -56,6 +100,36 @@ func (e Event) String() string {
 		e.Time, e.Detections, e.Duration, e.RecDuration)
 }
 
+// WithDetectionSources returns a copy of the event where the
+// detections without a source have the source of the event.
+func (e Event) WithDetectionSources() Event {
+	if len(e.Detections) == 0 {
+		return e
+	}
+	detections := make([]Detection, len(e.Detections))
+	for i, d := range e.Detections {
+		if d.Source == "" {
+			d.Source = e.Source
+		}
+		detections[i] = d
+	}
+	e.Detections = detections
+	return e
+}
+
+// HasSource returns true if the event or one of its detections has the source.
+func (e Event) HasSource(source string) bool {
+	if e.Source == source {
+		return true
+	}
+	for _, d := range e.Detections {
+		if d.Source == source {
+			return true
+		}
+	}
+	return false
+}
+
 // ErrValueMissing value missing.
 var ErrValueMissing = errors.New("value missing")
 
@@ -75,6 +149,12 @@ type Detection struct {
 	Label  string  `json:"label,omitempty"`
 	Score  float64 `json:"score,omitempty"`
 	Region *Region `json:"region,omitempty"`
+
+	// Source of the detection, the recorder fills in the source of the event.
+	Source string `json:"source,omitempty"`
+
+	// Names of the detector zones that contain the detection.
+	Zones []string `json:"zones,omitempty"`
 }
 
 // Region where detection occurred.
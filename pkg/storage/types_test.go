@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -24,3 +25,38 @@ func TestValidateEvent(t *testing.T) {
 		})
 	}
 }
+
+func TestEventWithDetectionSources(t *testing.T) {
+	event := Event{
+		Source: "doods",
+		Detections: []Detection{
+			{Label: "a"},
+			{Label: "b", Source: "motion"},
+		},
+	}
+	actual := event.WithDetectionSources()
+	expected := []Detection{
+		{Label: "a", Source: "doods"},
+		{Label: "b", Source: "motion"},
+	}
+	require.Equal(t, expected, actual.Detections)
+	require.Empty(t, event.Detections[0].Source, "original modified")
+
+	require.True(t, actual.HasSource("doods"))
+	require.True(t, actual.HasSource("motion"))
+	require.False(t, actual.HasSource("api"))
+}
+
+func TestEventSourceBackwardCompatibility(t *testing.T) {
+	raw := `{"events":[{"time":"2001-02-03T04:05:06Z","detections":[{"label":"a","score":1}]}]}`
+	var data RecordingData
+	require.NoError(t, json.Unmarshal([]byte(raw), &data))
+	require.Empty(t, data.Events[0].Source)
+	require.Empty(t, data.Events[0].Detections[0].Source)
+	require.False(t, data.Events[0].HasSource("doods"))
+
+	// Empty sources are omitted.
+	rawEvent, err := json.Marshal(Event{Detections: []Detection{{Label: "a"}}})
+	require.NoError(t, err)
+	require.NotContains(t, string(rawEvent), "source")
+}
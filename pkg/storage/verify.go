@@ -0,0 +1,411 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"nvr/pkg/log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The recorder computes a checksum of every chunk of the video files
+// while they're written. The scrubber slowly reads old recordings
+// and compares the checksums to detect corrupted files.
+
+const (
+	checksumAlgorithm = "crc32c"
+	checksumChunkSize = 1 << 20
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksums of the video files of a recording.
+type Checksums struct {
+	Algorithm string `json:"algorithm"`
+	ChunkSize int    `json:"chunkSize"`
+
+	// Checksum of every chunk, map[fileExtension]checksums.
+	Files map[string][]uint32 `json:"files"`
+}
+
+// NewChecksums returns checksums without files.
+func NewChecksums() *Checksums {
+	return &Checksums{
+		Algorithm: checksumAlgorithm,
+		ChunkSize: checksumChunkSize,
+		Files:     make(map[string][]uint32),
+	}
+}
+
+// ChecksumWriter computes the chunk checksums of the bytes written to w.
+type ChecksumWriter struct {
+	w         io.Writer
+	chunkSize int
+	sums      []uint32
+
+	// Checksum and size of the current chunk.
+	crc uint32
+	n   int
+}
+
+// NewChecksumWriter returns a writer that writes to w.
+func NewChecksumWriter(w io.Writer) *ChecksumWriter {
+	return &ChecksumWriter{w: w, chunkSize: checksumChunkSize}
+}
+
+func (w *ChecksumWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.update(p[:n])
+	return n, err
+}
+
+func (w *ChecksumWriter) update(p []byte) {
+	for len(p) > 0 {
+		n := min(len(p), w.chunkSize-w.n)
+		w.crc = crc32.Update(w.crc, castagnoli, p[:n])
+		w.n += n
+		p = p[n:]
+		if w.n == w.chunkSize {
+			w.sums = append(w.sums, w.crc)
+			w.crc, w.n = 0, 0
+		}
+	}
+}
+
+// Sums returns the checksums of the written bytes,
+// the last chunk is shorter than the chunk size.
+func (w *ChecksumWriter) Sums() []uint32 {
+	sums := append([]uint32{}, w.sums...)
+	if w.n != 0 {
+		sums = append(sums, w.crc)
+	}
+	return sums
+}
+
+// Verification statuses.
+const (
+	VerificationOK      = "ok"
+	VerificationCorrupt = "corrupt"
+)
+
+// Verification is the result of the last checksum verification.
+type Verification struct {
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+
+	// Mismatches if the recording is corrupt, "mdat: chunk 3".
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Errors.
+var (
+	ErrNoChecksums       = errors.New("recording has no checksums")
+	ErrChecksumAlgorithm = errors.New("unsupported checksum algorithm")
+)
+
+// verifyRecording compares the video files to the checksums in the
+// data file and saves the result in the data file. Reads are paced
+// by the limiter if it isn't nil. Will return os.ErrNotExist if the
+// recording doesn't exists.
+func verifyRecording(
+	ctx context.Context,
+	recordingsDir string,
+	recID string,
+	limiter *rateLimiter,
+	now time.Time,
+) (*Verification, error) {
+	recPath, err := RecordingIDToPath(recID)
+	if err != nil {
+		return nil, fmt.Errorf("recording id to path: %q %w", recID, err)
+	}
+	filePath := filepath.Join(recordingsDir, recPath)
+
+	rawData, err := os.ReadFile(filePath + ".json")
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		Checksums *Checksums `json:"checksums"`
+	}
+	if err := json.Unmarshal(rawData, &data); err != nil {
+		return nil, fmt.Errorf("unmarshal data: %w", err)
+	}
+	checksums := data.Checksums
+	if checksums == nil {
+		return nil, ErrNoChecksums
+	}
+	if checksums.Algorithm != checksumAlgorithm || checksums.ChunkSize <= 0 {
+		return nil, fmt.Errorf("%w: %q", ErrChecksumAlgorithm, checksums.Algorithm)
+	}
+
+	exts := make([]string, 0, len(checksums.Files))
+	for ext := range checksums.Files {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+
+	var mismatches []string
+	for _, ext := range exts {
+		m, err := verifyFile(
+			ctx, filePath+"."+ext, ext, checksums.Files[ext], checksums.ChunkSize, limiter)
+		if err != nil {
+			return nil, fmt.Errorf("verify %v: %w", ext, err)
+		}
+		mismatches = append(mismatches, m...)
+	}
+
+	v := &Verification{Time: now, Status: VerificationOK}
+	if len(mismatches) != 0 {
+		v.Status = VerificationCorrupt
+		v.Errors = mismatches
+	}
+
+	rawVerification, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal verification: %w", err)
+	}
+	err = updateRecordingData(recordingsDir, recID, func(data map[string]json.RawMessage) error {
+		data["verification"] = rawVerification
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("update data: %w", err)
+	}
+	return v, nil
+}
+
+// verifyFile returns the chunks that don't match the checksums.
+func verifyFile(
+	ctx context.Context,
+	path string,
+	ext string,
+	sums []uint32,
+	chunkSize int,
+	limiter *rateLimiter,
+) ([]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{ext + ": missing"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mismatches []string
+	buf := make([]byte, chunkSize)
+	for i := 0; ; i++ {
+		n, err := io.ReadFull(file, buf)
+		if errors.Is(err, io.EOF) {
+			if i < len(sums) {
+				mismatches = append(mismatches, ext+": truncated")
+			}
+			return mismatches, nil
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+		if i >= len(sums) {
+			return append(mismatches, ext+": trailing data"), nil
+		}
+		if crc32.Checksum(buf[:n], castagnoli) != sums[i] {
+			mismatches = append(mismatches, fmt.Sprintf("%v: chunk %v", ext, i))
+		}
+		if limiter != nil {
+			if err := limiter.wait(ctx, n); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// rateLimiter paces reads to a average number of bytes per second.
+type rateLimiter struct {
+	bytesPerSecond int
+	now            func() time.Time
+	sleep          func(context.Context, time.Duration) error
+
+	// Time when the bytes read so far are within the rate.
+	next time.Time
+}
+
+func newRateLimiter(bytesPerSecond int) *rateLimiter {
+	return &rateLimiter{
+		bytesPerSecond: bytesPerSecond,
+		now:            time.Now,
+		sleep:          sleepCtx,
+	}
+}
+
+// wait is called after n bytes are read and blocks until the
+// average rate is below the limit. Idle time doesn't allow bursts.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	now := l.now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.bytesPerSecond))
+	return l.sleep(ctx, l.next.Sub(now))
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+const (
+	// The scrubber reads slowly to not compete with the recorders.
+	scrubBytesPerSecond = 4 * megabyte
+
+	// Recordings that ended recently are skipped.
+	scrubMinAge = time.Hour
+
+	// Recordings are verified again after this duration.
+	scrubInterval = 30 * 24 * time.Hour
+
+	// Pause between walks of the recordings directories.
+	scrubPause = time.Hour
+)
+
+// Scrubber verifies the checksums of old recordings in the background.
+type Scrubber struct {
+	recordingsDirs RecordingsDirs
+	limiter        *rateLimiter
+	logger         log.ILogger
+	now            func() time.Time
+
+	verified atomic.Int64
+	corrupt  atomic.Int64
+}
+
+// NewScrubber creates a scrubber.
+func NewScrubber(recordingsDirs RecordingsDirs, logger log.ILogger) *Scrubber {
+	return &Scrubber{
+		recordingsDirs: recordingsDirs,
+		limiter:        newRateLimiter(int(scrubBytesPerSecond)),
+		logger:         logger,
+		now:            time.Now,
+	}
+}
+
+// ScrubberStatus verification counters since the app started.
+type ScrubberStatus struct {
+	Verified int64 `json:"verified"`
+	Corrupt  int64 `json:"corrupt"`
+}
+
+// Status returns the verification counters.
+func (s *Scrubber) Status() ScrubberStatus {
+	return ScrubberStatus{
+		Verified: s.verified.Load(),
+		Corrupt:  s.corrupt.Load(),
+	}
+}
+
+// Run walks the recordings directories until the context is canceled.
+func (s *Scrubber) Run(ctx context.Context) {
+	for {
+		s.scrub(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(scrubPause):
+		}
+	}
+}
+
+// scrub verifies the recordings that are due, oldest first.
+func (s *Scrubber) scrub(ctx context.Context) {
+	for _, recordingsDir := range s.recordingsDirs {
+		filepath.WalkDir(recordingsDir, func(path string, d fs.DirEntry, err error) error { //nolint:errcheck
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil || d.IsDir() || !strings.HasSuffix(path, ".json") {
+				return nil
+			}
+			s.scrubRecording(ctx, recordingsDir, path)
+			return nil
+		})
+	}
+}
+
+func (s *Scrubber) scrubRecording(ctx context.Context, recordingsDir, dataPath string) {
+	rawData, err := os.ReadFile(dataPath)
+	if err != nil {
+		return
+	}
+	var data struct {
+		End          time.Time     `json:"end"`
+		Checksums    *Checksums    `json:"checksums"`
+		Verification *Verification `json:"verification"`
+	}
+	if err := json.Unmarshal(rawData, &data); err != nil {
+		return
+	}
+
+	now := s.now()
+	if data.Checksums == nil ||
+		now.Sub(data.End) < scrubMinAge ||
+		(data.Verification != nil && now.Sub(data.Verification.Time) < scrubInterval) {
+		return
+	}
+
+	recID := recordingIDFromFile(filepath.Base(dataPath))
+	_, err = s.verify(ctx, recordingsDir, recID, s.limiter)
+	if err != nil && ctx.Err() == nil && !errors.Is(err, os.ErrNotExist) {
+		s.logf(log.LevelError, "could not verify recording: %v %v", recID, err)
+	}
+}
+
+// Verify verifies the recording immediately without the rate limit.
+// Will return os.ErrNotExist if the recording doesn't exists.
+func (s *Scrubber) Verify(ctx context.Context, recID string) (*Verification, error) {
+	return s.verify(ctx, s.recordingsDirs.Find(recID), recID, nil)
+}
+
+func (s *Scrubber) verify(
+	ctx context.Context,
+	recordingsDir string,
+	recID string,
+	limiter *rateLimiter,
+) (*Verification, error) {
+	v, err := verifyRecording(ctx, recordingsDir, recID, limiter, s.now())
+	if err != nil {
+		return nil, err
+	}
+	s.verified.Add(1)
+	if v.Status == VerificationCorrupt {
+		s.corrupt.Add(1)
+		s.logf(log.LevelError, "recording is corrupt: %v %v", recID, strings.Join(v.Errors, ", "))
+	}
+	return v, nil
+}
+
+func (s *Scrubber) logf(level log.Level, format string, a ...interface{}) {
+	s.logger.Log(log.Entry{
+		Level: level,
+		Src:   "app",
+		Msg:   fmt.Sprintf(format, a...),
+	})
+}
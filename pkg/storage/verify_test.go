@@ -0,0 +1,252 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewChecksumWriter(&buf)
+	w.chunkSize = 4
+
+	for _, p := range []string{"ab", "cdefg", "", "hij"} {
+		n, err := w.Write([]byte(p))
+		require.NoError(t, err)
+		require.Equal(t, len(p), n)
+	}
+	require.Equal(t, "abcdefghij", buf.String())
+
+	sum := func(s string) uint32 { return crc32.Checksum([]byte(s), castagnoli) }
+	require.Equal(t, []uint32{sum("abcd"), sum("efgh"), sum("ij")}, w.Sums())
+
+	require.Equal(t, []uint32{}, NewChecksumWriter(&buf).Sums())
+}
+
+const testRecID = "2000-01-01_02-02-02_m1"
+
+// writeTestRecording writes a fixture recording
+// with checksums and returns the file path.
+func writeTestRecording(t *testing.T, recordingsDir string, end time.Time) string {
+	recDir := filepath.Join(recordingsDir, "2000", "01", "01", "m1")
+	require.NoError(t, os.MkdirAll(recDir, 0o700))
+	filePath := filepath.Join(recDir, testRecID)
+
+	checksums := NewChecksums()
+	files := map[string][]byte{
+		"meta": bytes.Repeat([]byte{1}, 100),
+		"mdat": bytes.Repeat([]byte{2, 3, 4}, checksumChunkSize),
+	}
+	for ext, content := range files {
+		file, err := os.Create(filePath + "." + ext)
+		require.NoError(t, err)
+		w := NewChecksumWriter(file)
+		_, err = w.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+		checksums.Files[ext] = w.Sums()
+	}
+
+	data := RecordingData{End: end, Checksums: checksums, Locked: true}
+	rawData, err := json.Marshal(data)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filePath+".json", rawData, 0o600))
+	return filePath
+}
+
+func readTestVerification(t *testing.T, filePath string) *Verification {
+	rawData, err := os.ReadFile(filePath + ".json")
+	require.NoError(t, err)
+	var data RecordingData
+	require.NoError(t, json.Unmarshal(rawData, &data))
+	require.True(t, data.Locked)
+	return data.Verification
+}
+
+func TestVerifyRecording(t *testing.T) {
+	now := time.Unix(1000, 0).UTC()
+
+	t.Run("ok", func(t *testing.T) {
+		recordingsDir := t.TempDir()
+		filePath := writeTestRecording(t, recordingsDir, time.Time{})
+
+		v, err := verifyRecording(context.Background(), recordingsDir, testRecID, nil, now)
+		require.NoError(t, err)
+		expected := &Verification{Time: now, Status: VerificationOK}
+		require.Equal(t, expected, v)
+		require.Equal(t, expected, readTestVerification(t, filePath))
+	})
+	t.Run("corruptByte", func(t *testing.T) {
+		recordingsDir := t.TempDir()
+		filePath := writeTestRecording(t, recordingsDir, time.Time{})
+
+		// Flip a bit in the second chunk.
+		mdat, err := os.OpenFile(filePath+".mdat", os.O_RDWR, 0)
+		require.NoError(t, err)
+		_, err = mdat.WriteAt([]byte{0x83}, checksumChunkSize+10)
+		require.NoError(t, err)
+		require.NoError(t, mdat.Close())
+
+		v, err := verifyRecording(context.Background(), recordingsDir, testRecID, nil, now)
+		require.NoError(t, err)
+		expected := &Verification{
+			Time:   now,
+			Status: VerificationCorrupt,
+			Errors: []string{"mdat: chunk 1"},
+		}
+		require.Equal(t, expected, v)
+		require.Equal(t, expected, readTestVerification(t, filePath))
+	})
+	t.Run("sizeChanged", func(t *testing.T) {
+		recordingsDir := t.TempDir()
+		filePath := writeTestRecording(t, recordingsDir, time.Time{})
+
+		require.NoError(t, os.Truncate(filePath+".mdat", checksumChunkSize))
+		require.NoError(t, os.WriteFile(filePath+".meta", make([]byte, 101), 0o600))
+
+		v, err := verifyRecording(context.Background(), recordingsDir, testRecID, nil, now)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"mdat: truncated",
+			"meta: chunk 0",
+		}, v.Errors)
+	})
+	t.Run("missingFile", func(t *testing.T) {
+		recordingsDir := t.TempDir()
+		filePath := writeTestRecording(t, recordingsDir, time.Time{})
+		require.NoError(t, os.Remove(filePath+".meta"))
+
+		v, err := verifyRecording(context.Background(), recordingsDir, testRecID, nil, now)
+		require.NoError(t, err)
+		require.Equal(t, []string{"meta: missing"}, v.Errors)
+	})
+	t.Run("noChecksumsErr", func(t *testing.T) {
+		recordingsDir := t.TempDir()
+		recDir := filepath.Join(recordingsDir, "2000", "01", "01", "m1")
+		require.NoError(t, os.MkdirAll(recDir, 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(recDir, testRecID+".json"), []byte("{}"), 0o600))
+
+		_, err := verifyRecording(context.Background(), recordingsDir, testRecID, nil, now)
+		require.ErrorIs(t, err, ErrNoChecksums)
+	})
+	t.Run("notExistErr", func(t *testing.T) {
+		_, err := verifyRecording(context.Background(), t.TempDir(), testRecID, nil, now)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	var sleeps []time.Duration
+	l := &rateLimiter{
+		bytesPerSecond: 1000,
+		now:            func() time.Time { return now },
+		sleep: func(_ context.Context, d time.Duration) error {
+			sleeps = append(sleeps, d)
+			now = now.Add(d)
+			return nil
+		},
+	}
+	ctx := context.Background()
+
+	// Reads are paced to the rate.
+	require.NoError(t, l.wait(ctx, 500))
+	require.NoError(t, l.wait(ctx, 1000))
+	require.Equal(t, []time.Duration{500 * time.Millisecond, time.Second}, sleeps)
+	require.Equal(t, time.Unix(0, 0).Add(1500*time.Millisecond), now)
+
+	// The time spent reading counts toward the rate.
+	sleeps = nil
+	now = now.Add(200 * time.Millisecond)
+	require.NoError(t, l.wait(ctx, 500))
+	require.Equal(t, []time.Duration{500 * time.Millisecond}, sleeps)
+
+	// Idle time doesn't allow a burst.
+	sleeps = nil
+	now = now.Add(time.Hour)
+	require.NoError(t, l.wait(ctx, 1000))
+	require.NoError(t, l.wait(ctx, 1000))
+	require.Equal(t, []time.Duration{time.Second, time.Second}, sleeps)
+}
+
+func TestScrubber(t *testing.T) {
+	now := time.Unix(100000, 0).UTC()
+	newTestScrubber := func(recordingsDir string) (*Scrubber, chan string, *int) {
+		logger, logs := log.NewMockLogger()
+		s := NewScrubber(RecordingsDirs{recordingsDir}, logger)
+		s.now = func() time.Time { return now }
+		var read int
+		s.limiter.now = func() time.Time { return now }
+		s.limiter.sleep = func(context.Context, time.Duration) error {
+			read++
+			return nil
+		}
+		return s, logs, &read
+	}
+
+	t.Run("corrupt", func(t *testing.T) {
+		recordingsDir := t.TempDir()
+		filePath := writeTestRecording(t, recordingsDir, now.Add(-2*scrubMinAge))
+		require.NoError(t, os.WriteFile(filePath+".meta", make([]byte, 100), 0o600))
+		s, logs, read := newTestScrubber(recordingsDir)
+
+		// The mock logger blocks until the entry is received.
+		scrub := func() {
+			done := make(chan struct{})
+			go func() {
+				s.scrub(context.Background())
+				close(done)
+			}()
+			require.Equal(t, "recording is corrupt: "+testRecID+" meta: chunk 0", <-logs)
+			<-done
+		}
+
+		scrub()
+		require.Equal(t, ScrubberStatus{Verified: 1, Corrupt: 1}, s.Status())
+		require.Equal(t, VerificationCorrupt, readTestVerification(t, filePath).Status)
+
+		// Reads are rate limited, 1 meta and 3 mdat chunks.
+		require.Equal(t, 4, *read)
+
+		// Recently verified recordings are skipped.
+		s.scrub(context.Background())
+		require.Equal(t, ScrubberStatus{Verified: 1, Corrupt: 1}, s.Status())
+
+		now = now.Add(scrubInterval)
+		scrub()
+		require.Equal(t, ScrubberStatus{Verified: 2, Corrupt: 2}, s.Status())
+	})
+	t.Run("skipRecent", func(t *testing.T) {
+		recordingsDir := t.TempDir()
+		writeTestRecording(t, recordingsDir, now)
+		s, _, _ := newTestScrubber(recordingsDir)
+
+		s.scrub(context.Background())
+		require.Equal(t, ScrubberStatus{}, s.Status())
+	})
+	t.Run("verify", func(t *testing.T) {
+		recordingsDir := t.TempDir()
+		filePath := writeTestRecording(t, recordingsDir, now)
+		s, _, read := newTestScrubber(recordingsDir)
+
+		// Manual verification isn't rate limited.
+		v, err := s.Verify(context.Background(), testRecID)
+		require.NoError(t, err)
+		require.Equal(t, VerificationOK, v.Status)
+		require.Equal(t, v, readTestVerification(t, filePath))
+		require.Equal(t, 0, *read)
+		require.Equal(t, ScrubberStatus{Verified: 1}, s.Status())
+	})
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io"
 	"nvr/pkg/video/customformat"
+	"nvr/pkg/video/gortsplib"
 	"nvr/pkg/video/mp4muxer"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -22,35 +24,55 @@ type VideoReader struct {
 
 	i int64 // current reading index
 
+	index   mp4muxer.Index
 	modTime time.Time
 }
 
-// NewVideoReader creates a video reader.
+// NewVideoReader creates a video reader. Recordings that are still
+// being written are read from the active files and aren't cached.
 // Caller must call Close() when done.
 func NewVideoReader(recordingPath string, cache *VideoCache) (*VideoReader, error) {
-	metaPath := recordingPath + ".meta"
-	mdatPath := recordingPath + ".mdat"
+	metaPath, mdatPath, active := videoPaths(recordingPath)
 
 	var meta *videoMetadata
 	var err error
-	if cache != nil {
+	if cache != nil && !active {
 		var exist bool
 		meta, exist = cache.get(recordingPath)
 		if !exist {
-			meta, err = readVideoMetadata(metaPath)
+			meta, err = readVideoMetadata(metaPath, mp4muxer.GenerateMP4)
 			if err != nil {
 				return nil, err
 			}
 			cache.add(recordingPath, meta)
 		}
 	} else {
-		meta, err = readVideoMetadata(metaPath)
+		meta, err = readVideoMetadata(metaPath, mp4muxer.GenerateMP4)
 		if err != nil {
 			return nil, err
 		}
 	}
+	return newVideoReader(mdatPath, meta, active)
+}
+
+// NewProgressiveVideoReader creates a video reader for a progressive
+// mp4 without a segment index, see mp4muxer.GenerateProgressiveMP4.
+// The metadata isn't cached. Caller must call Close() when done.
+func NewProgressiveVideoReader(recordingPath string) (*VideoReader, error) {
+	metaPath, mdatPath, active := videoPaths(recordingPath)
+	meta, err := readVideoMetadata(metaPath, generateProgressiveMP4)
+	if err != nil {
+		return nil, err
+	}
+	return newVideoReader(mdatPath, meta, active)
+}
 
+func newVideoReader(mdatPath string, meta *videoMetadata, active bool) (*VideoReader, error) {
 	mdat, err := os.Open(mdatPath)
+	if active && errors.Is(err, os.ErrNotExist) {
+		// Published after the meta file was read.
+		mdat, err = os.Open(strings.TrimSuffix(mdatPath, ActiveSuffix))
+	}
 	if err != nil {
 		return nil, fmt.Errorf("open mdat file: %w", err)
 	}
@@ -62,17 +84,68 @@ func NewVideoReader(recordingPath string, cache *VideoCache) (*VideoReader, erro
 		metaSize: int64(len(meta.buf)),
 		mdatSize: meta.mdatSize,
 
+		index:   meta.index,
 		modTime: meta.modTime,
 	}, nil
 }
 
-func readVideoMetadata(metaPath string) (*videoMetadata, error) {
+// generateMP4Func generates the mp4 metadata of the samples.
+type generateMP4Func func(
+	out io.Writer,
+	startTime int64,
+	samples []customformat.Sample,
+	videoTrack *gortsplib.TrackH264,
+	audioTrack *gortsplib.TrackMPEG4Audio,
+) (int64, mp4muxer.Index, error)
+
+// generateProgressiveMP4 progressive files have no fragment index.
+func generateProgressiveMP4(
+	out io.Writer,
+	startTime int64,
+	samples []customformat.Sample,
+	videoTrack *gortsplib.TrackH264,
+	audioTrack *gortsplib.TrackMPEG4Audio,
+) (int64, mp4muxer.Index, error) {
+	mdatSize, err := mp4muxer.GenerateProgressiveMP4(out, startTime, samples, videoTrack, audioTrack)
+	return mdatSize, nil, err
+}
+
+func readVideoMetadata(metaPath string, generate generateMP4Func) (*videoMetadata, error) {
+	v, err := readRecordingSamples(metaPath)
+	if err != nil {
+		return nil, err
+	}
+
+	metaBuf := &bytes.Buffer{}
+	mdatSize, index, err := generate(
+		metaBuf, v.startTime, v.samples, v.videoTrack, v.audioTrack)
+	if err != nil {
+		return nil, fmt.Errorf("generate meta: %w", err)
+	}
+
+	return &videoMetadata{
+		buf:      metaBuf.Bytes(),
+		mdatSize: mdatSize,
+		index:    index,
+		modTime:  v.modTime,
+	}, nil
+}
+
+// recordingSamples the contents of a meta file.
+type recordingSamples struct {
+	startTime  int64
+	samples    []customformat.Sample
+	videoTrack *gortsplib.TrackH264
+	audioTrack *gortsplib.TrackMPEG4Audio
+	modTime    time.Time
+}
+
+func readRecordingSamples(metaPath string) (*recordingSamples, error) {
 	metaStat, err := os.Stat(metaPath)
 	if err != nil {
 		return nil, fmt.Errorf("stat meta file: %w", err)
 	}
 	metaSize := int(metaStat.Size())
-	modTime := metaStat.ModTime()
 
 	meta, err := os.Open(metaPath)
 	if err != nil {
@@ -95,17 +168,12 @@ func readVideoMetadata(metaPath string) (*videoMetadata, error) {
 		return nil, fmt.Errorf("read all samples: %w", err)
 	}
 
-	metaBuf := &bytes.Buffer{}
-	mdatSize, err := mp4muxer.GenerateMP4(
-		metaBuf, header.StartTime, samples, videoTrack, audioTrack)
-	if err != nil {
-		return nil, fmt.Errorf("generate meta: %w", err)
-	}
-
-	return &videoMetadata{
-		buf:      metaBuf.Bytes(),
-		mdatSize: mdatSize,
-		modTime:  modTime,
+	return &recordingSamples{
+		startTime:  header.StartTime,
+		samples:    samples,
+		videoTrack: videoTrack,
+		audioTrack: audioTrack,
+		modTime:    metaStat.ModTime(),
 	}, nil
 }
 
@@ -186,6 +254,22 @@ func (r *VideoReader) Seek(offset int64, whence int) (int64, error) {
 	return abs, nil
 }
 
+// SeekTime seeks to the start of the keyframe fragment
+// that contains the timestamp and returns the new offset.
+// Timestamps are relative to the start of the video.
+func (r *VideoReader) SeekTime(t time.Duration) (int64, error) {
+	fragment, exist := r.index.Find(t)
+	if !exist {
+		return r.Seek(r.metaSize, io.SeekStart)
+	}
+	return r.Seek(fragment.Offset, io.SeekStart)
+}
+
+// Index returns the fragment index.
+func (r *VideoReader) Index() mp4muxer.Index {
+	return r.index
+}
+
 // Close implements io.Closer .
 func (r *VideoReader) Close() error {
 	return r.mdat.Close()
@@ -214,6 +298,7 @@ type VideoCache struct {
 type videoMetadata struct {
 	buf      []byte
 	mdatSize int64
+	index    mp4muxer.Index
 	modTime  time.Time
 
 	key string
@@ -257,6 +342,13 @@ func (c *VideoCache) add(key string, video *videoMetadata) {
 	c.items[key] = video
 }
 
+// Delete removes the cached metadata of a recording.
+func (c *VideoCache) Delete(recordingPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.items, recordingPath)
+}
+
 // get item by key and update its age if it exists.
 func (c *VideoCache) get(key string) (*videoMetadata, bool) {
 	c.mu.Lock()
@@ -3,14 +3,54 @@ package storage
 import (
 	"bytes"
 	"io"
+	"nvr/pkg/video/mp4muxer"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
 
 func TestNewVideoReader(t *testing.T) {
+	path := writeTestVideo(t)
+
+	video, err := NewVideoReader(path, nil)
+	require.NoError(t, err)
+	defer video.Close()
+
+	n, err := new(bytes.Buffer).ReadFrom(video)
+	require.NoError(t, err)
+	require.Greater(t, n, int64(1000))
+}
+
+func TestNewProgressiveVideoReader(t *testing.T) {
+	path := writeTestVideo(t)
+
+	readAll := func(video *VideoReader, err error) ([]byte, mp4muxer.Index) {
+		require.NoError(t, err)
+		defer video.Close()
+		buf, err := io.ReadAll(video)
+		require.NoError(t, err)
+		require.Equal(t, video.Size(), int64(len(buf)))
+		return buf, video.Index()
+	}
+	fragmented, index := readAll(NewVideoReader(path, nil))
+	progressive, progressiveIndex := readAll(NewProgressiveVideoReader(path))
+
+	// Only the fragmented file is indexed.
+	require.NotEmpty(t, index)
+	require.Empty(t, progressiveIndex)
+
+	require.Equal(t, "isom", string(progressive[8:12]))
+	require.NotContains(t, string(progressive), "sidx")
+	require.Less(t, len(progressive), len(fragmented))
+
+	// Same mdat.
+	require.Equal(t, fragmented[len(fragmented)-12:], progressive[len(progressive)-12:])
+}
+
+func writeTestVideo(t *testing.T) string {
 	tempDir := t.TempDir()
 	path := filepath.Join(tempDir, "x")
 	metaPath := path + ".meta"
@@ -32,21 +72,14 @@ func TestNewVideoReader(t *testing.T) {
 		0, 0, 0, 0, 0, 0, 0, 0, // DTS.
 		0, 0, 0, 0, 0, 0, 0, 0, // Next dts.
 		0, 0, 0, 0, // Offset.
-		0, 0, 0, 0, // Size.
+		0, 0, 0, 4, // Size.
 	}
 
 	err := os.WriteFile(metaPath, testMeta, 0o600)
 	require.NoError(t, err)
 	err = os.WriteFile(mdatPath, []byte{0, 0, 0, 0}, 0o600)
 	require.NoError(t, err)
-
-	video, err := NewVideoReader(path, nil)
-	require.NoError(t, err)
-	defer video.Close()
-
-	n, err := new(bytes.Buffer).ReadFrom(video)
-	require.NoError(t, err)
-	require.Greater(t, n, int64(1000))
+	return path
 }
 
 func TestVideoReader(t *testing.T) {
@@ -112,6 +145,47 @@ func TestVideoReader(t *testing.T) {
 	require.ErrorIs(t, err, errNegativePosition)
 }
 
+func TestVideoReaderSeekTime(t *testing.T) {
+	r := VideoReader{
+		meta:     bytes.NewReader([]byte{0, 1, 2, 3, 4}),
+		mdat:     &mockReadSeekCloser{reader: bytes.NewReader([]byte{5, 6, 7, 8, 9})},
+		metaSize: 5,
+		mdatSize: 5,
+		index: mp4muxer.Index{
+			{Offset: 5, Size: 2, Start: 0, Duration: 2 * time.Second},
+			{Offset: 7, Size: 3, Start: 2 * time.Second, Duration: 2 * time.Second},
+		},
+	}
+
+	cases := []struct {
+		t        time.Duration
+		expected int64
+	}{
+		{0, 5},
+		{1999 * time.Millisecond, 5},
+		{2 * time.Second, 7},
+		{3 * time.Second, 7},
+		{time.Hour, 7},
+	}
+	for _, tc := range cases {
+		abs, err := r.SeekTime(tc.t)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, abs)
+	}
+
+	buf := make([]byte, 3)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.Equal(t, []byte{7, 8, 9}, buf)
+
+	// Empty index.
+	r.index = nil
+	abs, err := r.SeekTime(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), abs)
+}
+
 func TestVideoReaderCache(t *testing.T) {
 	cache := NewVideoCache()
 	cache.maxSize = 3
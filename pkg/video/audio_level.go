@@ -0,0 +1,264 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"math"
+	"nvr/pkg/video/gortsplib/pkg/bits"
+	"nvr/pkg/video/gortsplib/pkg/mpeg4audio"
+	"sync"
+)
+
+// AudioLevel approximate level of the audio in dBFS.
+type AudioLevel struct {
+	RMS  float64 `json:"rms"`
+	Peak float64 `json:"peak"`
+}
+
+// Audio levels are published audioLevelRate times per second of audio.
+const audioLevelRate = 4
+
+// The audio level is approximated from the global gain of the AAC access
+// units, the start value of the scale factors. The scale factors step
+// the gain of the spectral coefficients by 1.5 dB. This avoids decoding
+// the audio but ignores the quantized coefficients, the level is only
+// good enough for a meter.
+const (
+	audioLevelFloor  = -90.0
+	aacGainStep      = 1.5
+	aacFullScaleGain = 160
+)
+
+// AAC syntax elements.
+const (
+	aacElementSCE       = 0
+	aacElementCPE       = 1
+	aacElementLFE       = 3
+	aacEightShortSeq    = 2
+	aacShortWindowCount = 8
+)
+
+// aacLevel returns the approximate level of a raw AAC access unit.
+// Returns false if the access unit couldn't be parsed.
+func aacLevel(au []byte) (float64, bool) {
+	gain, maxSFB, err := aacGlobalGain(au)
+	if err != nil {
+		return 0, false
+	}
+	// No bands, silence.
+	if maxSFB == 0 {
+		return audioLevelFloor, true
+	}
+	level := aacGainStep * float64(int(gain)-aacFullScaleGain)
+	return math.Max(audioLevelFloor, math.Min(0, level)), true
+}
+
+// aacGlobalGain reads the global gain and the number of bands of
+// the first channel of the first element of the access unit.
+func aacGlobalGain(au []byte) (uint8, uint64, error) {
+	pos := 0
+	element, err := bits.ReadBits(au, &pos, 3)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch element {
+	case aacElementSCE, aacElementLFE:
+		pos += 4 // Element instance tag.
+		gain, err := bits.ReadUint8(au, &pos)
+		if err != nil {
+			return 0, 0, err
+		}
+		maxSFB, _, err := aacReadICSInfo(au, &pos)
+		return gain, maxSFB, err
+
+	case aacElementCPE:
+		pos += 4 // Element instance tag.
+		commonWindow, err := bits.ReadFlag(au, &pos)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !commonWindow {
+			gain, err := bits.ReadUint8(au, &pos)
+			if err != nil {
+				return 0, 0, err
+			}
+			maxSFB, _, err := aacReadICSInfo(au, &pos)
+			return gain, maxSFB, err
+		}
+
+		maxSFB, windowGroups, err := aacReadICSInfo(au, &pos)
+		if err != nil {
+			return 0, 0, err
+		}
+		msMaskPresent, err := bits.ReadBits(au, &pos, 2)
+		if err != nil {
+			return 0, 0, err
+		}
+		if msMaskPresent == 1 {
+			pos += int(windowGroups * maxSFB) // ms_used.
+		}
+		gain, err := bits.ReadUint8(au, &pos)
+		return gain, maxSFB, err
+	}
+	return 0, 0, errUnsupportedAACElement
+}
+
+var errUnsupportedAACElement = errors.New("unsupported AAC element")
+
+// aacReadICSInfo reads the ics_info of a AAC LC channel stream and
+// returns the number of bands and the number of window groups.
+func aacReadICSInfo(au []byte, pos *int) (uint64, uint64, error) {
+	*pos++ // Reserved bit.
+	windowSequence, err := bits.ReadBits(au, pos, 2)
+	if err != nil {
+		return 0, 0, err
+	}
+	*pos++ // Window shape.
+
+	if windowSequence == aacEightShortSeq {
+		maxSFB, err := bits.ReadBits(au, pos, 4)
+		if err != nil {
+			return 0, 0, err
+		}
+		grouping, err := bits.ReadBits(au, pos, 7)
+		if err != nil {
+			return 0, 0, err
+		}
+		// A group starts at each cleared bit.
+		windowGroups := uint64(1)
+		for i := 0; i < aacShortWindowCount-1; i++ {
+			if grouping&(1<<i) == 0 {
+				windowGroups++
+			}
+		}
+		return maxSFB, windowGroups, nil
+	}
+
+	maxSFB, err := bits.ReadBits(au, pos, 6)
+	if err != nil {
+		return 0, 0, err
+	}
+	predictorDataPresent, err := bits.ReadFlag(au, pos)
+	if err != nil {
+		return 0, 0, err
+	}
+	if predictorDataPresent {
+		return 0, 0, errUnsupportedAACElement
+	}
+	return maxSFB, 1, nil
+}
+
+// audioLevelMeter accumulates the levels of the access units and
+// publishes the RMS and peak level audioLevelRate times per second.
+type audioLevelMeter struct {
+	publish func(AudioLevel)
+
+	samples int
+	power   float64 // Sum of the linear power.
+	n       int
+	peak    float64
+}
+
+func newAudioLevelMeter(publish func(AudioLevel)) *audioLevelMeter {
+	return &audioLevelMeter{
+		publish: publish,
+		peak:    audioLevelFloor,
+	}
+}
+
+// onAU is called by the HLS muxer for every audio access unit.
+func (m *audioLevelMeter) onAU(au []byte, sampleRate int) {
+	if level, ok := aacLevel(au); ok {
+		m.power += math.Pow(10, level/10)
+		m.n++
+		m.peak = math.Max(m.peak, level)
+	}
+
+	m.samples += mpeg4audio.SamplesPerAccessUnit
+	if m.samples < sampleRate/audioLevelRate {
+		return
+	}
+
+	if m.n != 0 {
+		rms := 10 * math.Log10(m.power/float64(m.n))
+		m.publish(AudioLevel{
+			RMS:  math.Round(math.Max(audioLevelFloor, rms)*10) / 10,
+			Peak: math.Round(m.peak*10) / 10,
+		})
+	}
+	m.samples, m.power, m.n, m.peak = 0, 0, 0, audioLevelFloor
+}
+
+// audioLevelEvent is sent to the subscribers when a level is published.
+type audioLevelEvent struct {
+	MonitorID string `json:"monitorID"`
+	AudioLevel
+}
+
+// Subscribers that fall behind miss events, only the latest level matters.
+const audioLevelSubBuffer = 16
+
+// audioLevels latest audio level of each monitor.
+type audioLevels struct {
+	mu     sync.Mutex
+	levels map[string]AudioLevel
+	subs   map[chan audioLevelEvent]struct{}
+}
+
+func newAudioLevels() *audioLevels {
+	return &audioLevels{
+		levels: make(map[string]AudioLevel),
+		subs:   make(map[chan audioLevelEvent]struct{}),
+	}
+}
+
+func (l *audioLevels) publish(monitorID string, level AudioLevel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.levels[monitorID] = level
+	event := audioLevelEvent{MonitorID: monitorID, AudioLevel: level}
+	for sub := range l.subs {
+		select {
+		case sub <- event:
+		default:
+		}
+	}
+}
+
+// remove is called when the path of the monitor is closed.
+func (l *audioLevels) remove(monitorID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.levels, monitorID)
+}
+
+// latest returns a copy of the latest level of each monitor.
+func (l *audioLevels) latest() map[string]AudioLevel {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	levels := make(map[string]AudioLevel, len(l.levels))
+	for id, level := range l.levels {
+		levels[id] = level
+	}
+	return levels
+}
+
+// subscribe returns a channel that receives the published
+// levels until the context is canceled.
+func (l *audioLevels) subscribe(ctx context.Context) <-chan audioLevelEvent {
+	sub := make(chan audioLevelEvent, audioLevelSubBuffer)
+
+	l.mu.Lock()
+	l.subs[sub] = struct{}{}
+	l.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		l.mu.Lock()
+		delete(l.subs, sub)
+		l.mu.Unlock()
+	}()
+	return sub
+}
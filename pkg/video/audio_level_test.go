@@ -0,0 +1,175 @@
+package video
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"nvr/pkg/video/gortsplib/pkg/bits"
+
+	"github.com/stretchr/testify/require"
+)
+
+type aacField struct {
+	v uint64
+	n int
+}
+
+// newTestAU writes the fields of a raw AAC access unit.
+func newTestAU(fields ...aacField) []byte {
+	size := 0
+	for _, f := range fields {
+		size += f.n
+	}
+	au := make([]byte, bits.BytesNeeded(size)+4)
+	pos := 0
+	for _, f := range fields {
+		bits.WriteBits(au, &pos, f.v, f.n)
+	}
+	return au
+}
+
+// newTestSCE returns a single channel element with a long window.
+func newTestSCE(gain uint64, maxSFB uint64) []byte {
+	return newTestAU(
+		aacField{aacElementSCE, 3},
+		aacField{0, 4},      // Element instance tag.
+		aacField{gain, 8},   // Global gain.
+		aacField{0, 1},      // Reserved bit.
+		aacField{0, 2},      // Window sequence.
+		aacField{0, 1},      // Window shape.
+		aacField{maxSFB, 6}, // Max sfb.
+		aacField{0, 1},      // Predictor data present.
+	)
+}
+
+func TestAACLevel(t *testing.T) {
+	cases := []struct {
+		name  string
+		au    []byte
+		level float64
+		ok    bool
+	}{
+		{"sce", newTestSCE(140, 40), -30, true},
+		{"fullScale", newTestSCE(200, 40), 0, true},
+		{"floor", newTestSCE(0, 40), audioLevelFloor, true},
+		{"silence", newTestSCE(140, 0), audioLevelFloor, true},
+		{
+			"cpe",
+			newTestAU(
+				aacField{aacElementCPE, 3},
+				aacField{0, 4},   // Element instance tag.
+				aacField{0, 1},   // Common window.
+				aacField{150, 8}, // Global gain.
+				aacField{0, 1},   // Reserved bit.
+				aacField{0, 2},   // Window sequence.
+				aacField{0, 1},   // Window shape.
+				aacField{49, 6},  // Max sfb.
+				aacField{0, 1},   // Predictor data present.
+			),
+			-15,
+			true,
+		},
+		{
+			"cpeCommonWindowShort",
+			newTestAU(
+				aacField{aacElementCPE, 3},
+				aacField{0, 4}, // Element instance tag.
+				aacField{1, 1}, // Common window.
+				aacField{0, 1}, // Reserved bit.
+				aacField{aacEightShortSeq, 2},
+				aacField{0, 1},         // Window shape.
+				aacField{14, 4},        // Max sfb.
+				aacField{0b1101101, 7}, // Scale factor grouping, 3 groups.
+				aacField{1, 2},         // Ms mask present.
+				aacField{0, 3 * 14},    // Ms used.
+				aacField{130, 8},       // Global gain.
+			),
+			-45,
+			true,
+		},
+		{"fill", newTestAU(aacField{6, 3}, aacField{0, 4}), 0, false},
+		{"empty", nil, 0, false},
+		{"truncated", []byte{0}, 0, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			level, ok := aacLevel(tc.au)
+			require.Equal(t, tc.ok, ok)
+			require.Equal(t, tc.level, level)
+		})
+	}
+}
+
+func TestAudioLevelMeter(t *testing.T) {
+	var published []AudioLevel
+	m := newAudioLevelMeter(func(level AudioLevel) {
+		published = append(published, level)
+	})
+
+	// 8000 Hz, two access units per window.
+	const sampleRate = 8000
+	m.onAU(newTestSCE(150, 40), sampleRate)
+	require.Empty(t, published)
+	m.onAU(newTestSCE(140, 40), sampleRate)
+	require.Equal(t, []AudioLevel{{RMS: -17.9, Peak: -15}}, published)
+
+	// Invalid access units are skipped.
+	m.onAU(nil, sampleRate)
+	m.onAU(newTestSCE(100, 40), sampleRate)
+	require.Equal(t, AudioLevel{RMS: -90, Peak: -90}, published[1])
+
+	// Nothing is published if no access unit was valid.
+	m.onAU(nil, sampleRate)
+	m.onAU(nil, sampleRate)
+	require.Len(t, published, 2)
+}
+
+// fakeAudioLevelSource publishes levels like the meter of a path.
+type fakeAudioLevelSource struct {
+	levels    *audioLevels
+	monitorID string
+}
+
+func (s fakeAudioLevelSource) emit(rms, peak float64) {
+	s.levels.publish(s.monitorID, AudioLevel{RMS: rms, Peak: peak})
+}
+
+func TestAudioLevels(t *testing.T) {
+	levels := newAudioLevels()
+	a := fakeAudioLevelSource{levels: levels, monitorID: "a"}
+	b := fakeAudioLevelSource{levels: levels, monitorID: "b"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := levels.subscribe(ctx)
+
+	a.emit(-30, -20)
+	b.emit(-40, -35)
+	a.emit(-25, -10)
+
+	require.Equal(t, audioLevelEvent{"a", AudioLevel{-30, -20}}, <-sub)
+	require.Equal(t, audioLevelEvent{"b", AudioLevel{-40, -35}}, <-sub)
+	require.Equal(t, audioLevelEvent{"a", AudioLevel{-25, -10}}, <-sub)
+
+	// Only the latest level is kept.
+	require.Equal(t, map[string]AudioLevel{
+		"a": {-25, -10},
+		"b": {-40, -35},
+	}, levels.latest())
+
+	levels.remove("a")
+	require.Equal(t, map[string]AudioLevel{"b": {-40, -35}}, levels.latest())
+
+	// Slow subscribers drop events instead of blocking the source.
+	for i := 0; i < audioLevelSubBuffer*2; i++ {
+		a.emit(-1, -1)
+	}
+	require.Len(t, sub, audioLevelSubBuffer)
+
+	cancel()
+	require.Eventually(t, func() bool {
+		levels.mu.Lock()
+		defer levels.mu.Unlock()
+		return len(levels.subs) == 0
+	}, time.Second, time.Millisecond)
+}
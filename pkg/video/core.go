@@ -2,56 +2,106 @@ package video
 
 import (
 	"context"
+	"errors"
+	"net"
 	"net/http"
 	"nvr/pkg/log"
 	"nvr/pkg/storage"
 	"nvr/pkg/video/gortsplib"
 	"nvr/pkg/video/hls"
+	"nvr/pkg/web/api"
 	"strconv"
 	"sync"
 )
 
 // Server is an instance of rtsp-simple-server.
 type Server struct {
-	rtspAddress string
+	rtspAddress string // Dial address.
 	hlsAddress  string
 	pathManager *pathManager
 	rtspServer  *rtspServer
 	hlsServer   *hlsServer
+	hlsSessions *hlsSessions
+	memory      *memoryAccountant
+	audioLevels *audioLevels
 	wg          *sync.WaitGroup
 }
 
 const readBufferCount = 2048
 
+// RTSPAddress returns the address that the RTSP server listens on.
+func RTSPAddress(env storage.ConfigEnv) string {
+	return net.JoinHostPort(env.RTSPHost(), strconv.Itoa(env.RTSPPort))
+}
+
+// rtspDialAddress returns the address that the monitor pipeline connects
+// to. The loopback interface is used if the server listens on all of them.
+func rtspDialAddress(env storage.ConfigEnv) string {
+	host := env.RTSPHost()
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(env.RTSPPort))
+}
+
+// HLSAddress returns the address that the HLS server listens on.
+func HLSAddress(env storage.ConfigEnv) string {
+	if env.HLSPortExpose {
+		return ":" + strconv.Itoa(env.HLSPort)
+	}
+	return "127.0.0.1:" + strconv.Itoa(env.HLSPort)
+}
+
 // NewServer allocates a server.
 func NewServer(log *log.Logger, wg *sync.WaitGroup, env storage.ConfigEnv) *Server {
-	rtspAddress := func() string {
-		if env.RTSPPortExpose {
-			return ":" + strconv.Itoa(env.RTSPPort)
-		}
-		return "127.0.0.1:" + strconv.Itoa(env.RTSPPort)
-	}()
-	hlsAddress := func() string {
-		if env.HLSPortExpose {
-			return ":" + strconv.Itoa(env.HLSPort)
-		}
-		return "127.0.0.1:" + strconv.Itoa(env.HLSPort)
-	}()
-
-	hlsServer := newHLSServer(wg, readBufferCount, log)
-	pathManager := newPathManager(wg, log, hlsServer)
-	rtspServer := newRTSPServer(wg, rtspAddress, readBufferCount, pathManager, log)
+	hlsAddress := HLSAddress(env)
+
+	memory := newMemoryAccountant(int64(env.VideoMemoryBudget)*int64(mb), log)
+	hlsServer := newHLSServer(wg, readBufferCount, log, memory)
+	audioLevels := newAudioLevels()
+	pathManager := newPathManager(wg, log, hlsServer, audioLevels)
+	rtspServer := newRTSPServer(
+		wg,
+		RTSPAddress(env),
+		readBufferCount,
+		env.RTSPReaderRate*1000/8,
+		pathManager,
+		log,
+	)
+
+	var sessions *hlsSessions
+	if env.HLSSessions {
+		sessions = newHLSSessions(env.HLSMaxViewers)
+	}
 
 	return &Server{
-		rtspAddress: rtspAddress,
+		rtspAddress: rtspDialAddress(env),
 		hlsAddress:  hlsAddress,
 		pathManager: pathManager,
 		rtspServer:  rtspServer,
 		hlsServer:   hlsServer,
+		hlsSessions: sessions,
+		memory:      memory,
+		audioLevels: audioLevels,
 		wg:          wg,
 	}
 }
 
+// ReadAuthFunc returns true if the request is authorized.
+// The RTSP Authorization header is passed as is.
+type ReadAuthFunc func(*http.Request) bool
+
+// ErrUnauthorized RTSP client failed authentication.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// SetReadAuth require external RTSP readers and publishers to authenticate.
+// Clients on the same host as the server are exempt, they connect
+// from the loopback interface or from the RTSP bind address.
+// Must be called before Start.
+func (s *Server) SetReadAuth(auth ReadAuthFunc) {
+	s.rtspServer.readAuth = auth
+}
+
 // Start server.
 func (s *Server) Start(ctx context.Context) error {
 	ctx2, cancel := context.WithCancel(ctx)
@@ -66,9 +116,26 @@ func (s *Server) Start(ctx context.Context) error {
 		cancel()
 		return err
 	}
+
+	s.wg.Add(2)
+	go s.runStats(ctx2)
+	go s.memory.run(ctx2, s.wg)
+
 	return nil
 }
 
+// SetExcludeLiveAudio changes if the audio is omitted from the live HLS
+// stream of a running path. Players reload the stream, the recorder
+// is not affected. Returns ErrPathNotExist if the path doesn't exist.
+func (s *Server) SetExcludeLiveAudio(pathName string, exclude bool) error {
+	return s.pathManager.setExcludeLiveAudio(pathName, exclude)
+}
+
+// RTSPListening returns true if the RTSP server is accepting connections.
+func (s *Server) RTSPListening() bool {
+	return s.rtspServer.listening.Load()
+}
+
 // CancelFunc .
 type CancelFunc func()
 
@@ -112,6 +179,34 @@ func (s *Server) PathExist(name string) bool {
 }
 
 // HandleHLS handle hls requests.
-func (s *Server) HandleHLS() http.HandlerFunc {
-	return s.hlsServer.HandleRequest()
+func (s *Server) HandleHLS() http.Handler {
+	if s.hlsSessions == nil {
+		return s.hlsServer.HandleRequest()
+	}
+	return s.hlsSessions.handle(s.pathManager.monitorID, s.hlsServer.HandleRequest())
+}
+
+// SetHLSUser sets the function used to identify the
+// user of HLS viewing sessions. Must be called before Start.
+func (s *Server) SetHLSUser(user UserFunc) {
+	if s.hlsSessions != nil {
+		s.hlsSessions.user = user
+	}
+}
+
+// HandleViewers returns the active HLS viewers of each monitor.
+// The response is empty if HLS sessions are disabled.
+func (s *Server) HandleViewers() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		viewers := make(map[string]ViewerStats)
+		if s.hlsSessions != nil {
+			viewers = s.hlsSessions.viewers()
+		}
+		api.WriteJSON(w, r, viewers)
+	})
 }
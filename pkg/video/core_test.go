@@ -7,6 +7,10 @@ import (
 	"time"
 
 	"nvr/pkg/log"
+	"nvr/pkg/storage"
+	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/gortsplib/pkg/base"
+	"nvr/pkg/video/gortsplib/pkg/url"
 
 	"github.com/stretchr/testify/require"
 )
@@ -17,12 +21,15 @@ func newTestServer(t *testing.T) (*Server, cancelFunc) {
 	wg := sync.WaitGroup{}
 
 	logger := log.NewDummyLogger()
-	pathManager := newPathManager(&wg, logger, nil)
+	audioLevels := newAudioLevels()
+	pathManager := newPathManager(&wg, logger, nil, audioLevels)
 
 	s := &Server{
 		rtspAddress: "127.0.0.1:8554",
 		hlsAddress:  "127.0.0.1:8888",
 		pathManager: pathManager,
+		memory:      newMemoryAccountant(0, logger),
+		audioLevels: audioLevels,
 		wg:          &wg,
 	}
 
@@ -57,3 +64,156 @@ func TestNewPath(t *testing.T) {
 	time.Sleep(10 * time.Millisecond)
 	require.False(t, p.PathExist("mypath"))
 }
+
+func TestRTSPAddress(t *testing.T) {
+	cases := map[string]struct {
+		env          storage.ConfigEnv
+		expectedBind string
+		expectedDial string
+	}{
+		"default": {
+			storage.ConfigEnv{RTSPPort: 2021},
+			"127.0.0.1:2021",
+			"127.0.0.1:2021",
+		},
+		"expose": {
+			storage.ConfigEnv{RTSPPort: 2021, RTSPPortExpose: true},
+			":2021",
+			"127.0.0.1:2021",
+		},
+		"all": {
+			storage.ConfigEnv{RTSPPort: 2021, RTSPBindAddress: "0.0.0.0"},
+			"0.0.0.0:2021",
+			"127.0.0.1:2021",
+		},
+		"allIPv6": {
+			storage.ConfigEnv{RTSPPort: 2021, RTSPBindAddress: "::"},
+			"[::]:2021",
+			"127.0.0.1:2021",
+		},
+		"interface": {
+			storage.ConfigEnv{RTSPPort: 2021, RTSPBindAddress: "192.168.1.10"},
+			"192.168.1.10:2021",
+			"192.168.1.10:2021",
+		},
+		"loopbackIPv6": {
+			storage.ConfigEnv{RTSPPort: 2021, RTSPBindAddress: "::1"},
+			"[::1]:2021",
+			"[::1]:2021",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expectedBind, RTSPAddress(tc.env))
+			require.Equal(t, tc.expectedDial, rtspDialAddress(tc.env))
+		})
+	}
+}
+
+func TestNewPathBindAddress(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	env := storage.ConfigEnv{
+		RTSPPort:        2021,
+		RTSPBindAddress: "192.168.1.10",
+		HLSPort:         2022,
+	}
+	s := NewServer(log.NewLogger(wg, nil), wg, env)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	path, err := s.NewPath(ctx, "x", PathConf{MonitorID: "x"})
+	require.NoError(t, err)
+	require.Equal(t, "rtsp://192.168.1.10:2021/x", path.RtspAddress)
+	require.Equal(t, "192.168.1.10:2021", s.rtspServer.address)
+}
+
+func TestOnDescribe(t *testing.T) {
+	p, cancel := newTestServer(t)
+	defer cancel()
+
+	ctx, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	_, err := p.NewPath(ctx, "mypath", PathConf{MonitorID: "x"})
+	require.NoError(t, err)
+
+	t.Run("unknownPath", func(t *testing.T) {
+		res, _, err := p.pathManager.onDescribe("nil")
+		require.ErrorIs(t, err, ErrPathNotExist)
+		require.Equal(t, base.StatusNotFound, res.StatusCode)
+	})
+	t.Run("noPublisher", func(t *testing.T) {
+		res, _, err := p.pathManager.onDescribe("mypath")
+		require.ErrorIs(t, err, ErrPathNoOnePublishing)
+		require.Equal(t, base.StatusServiceUnavailable, res.StatusCode)
+	})
+}
+
+func TestStreamParamsReady(t *testing.T) {
+	s := newStream(gortsplib.Tracks{&gortsplib.TrackH264{PayloadType: 96}}, nil)
+	defer s.close()
+	require.ErrorIs(t, s.paramsReady(), ErrStreamNoParams)
+
+	track := s.tracks()[0].(*gortsplib.TrackH264) //nolint:forcetypeassert
+	track.SafeSetSPS([]byte{0x01})
+	track.SafeSetPPS([]byte{0x02})
+	require.NoError(t, s.paramsReady())
+}
+
+type entryRecorder struct {
+	mu      sync.Mutex
+	entries []log.Entry
+}
+
+func (r *entryRecorder) Log(entry log.Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+}
+
+func TestRTSPServerDebug(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	logger := &entryRecorder{}
+	pm := newPathManager(&wg, logger, nil, nil)
+	_, err := pm.AddPath(ctx, "a", PathConf{MonitorID: "a", DebugRTSP: true})
+	require.NoError(t, err)
+	_, err = pm.AddPath(ctx, "b", PathConf{MonitorID: "b"})
+	require.NoError(t, err)
+
+	s := &rtspServer{pathManager: pm}
+	newReq := func(rawURL string) *base.Request {
+		u, err := url.Parse(rawURL)
+		require.NoError(t, err)
+		return &base.Request{
+			Method: base.Setup,
+			URL:    u,
+			Header: base.Header{"CSeq": base.HeaderValue{"1"}},
+		}
+	}
+	res := &base.Response{StatusCode: base.StatusOK}
+
+	req := newReq("rtsp://localhost:8554/a/trackID=0")
+	s.onDebugRequest(nil, req)
+	s.onDebugResponse(nil, req, res)
+	s.onDebugRequest(nil, newReq("rtsp://localhost:8554/a/"))
+	s.onDebugRequest(nil, newReq("rtsp://localhost:8554/b/trackID=0"))
+	s.onDebugRequest(nil, newReq("rtsp://localhost:8554/nil"))
+
+	require.Len(t, logger.entries, 3)
+	for _, entry := range logger.entries {
+		require.Equal(t, log.LevelDebug, entry.Level)
+		require.Equal(t, "a", entry.MonitorID)
+	}
+	require.Contains(t, logger.entries[0].Msg, "main: RTSP request from")
+	require.Contains(t, logger.entries[0].Msg, "SETUP rtsp://localhost:8554/a/trackID=0")
+	require.Contains(t, logger.entries[1].Msg, "main: RTSP response to")
+	require.Contains(t, logger.entries[1].Msg, "200 OK")
+}
@@ -56,3 +56,45 @@ func (d *dataMPEG4Audio) getRTPPackets() []*rtp.Packet {
 func (d *dataMPEG4Audio) getNTP() time.Time {
 	return d.ntp
 }
+
+// detachData returns a copy of the data without the RTP packets. Received
+// packets are only valid until the server handler returns, the NALUs and
+// AUs may reference their payload and are copied.
+func detachData(d data) data {
+	switch d := d.(type) {
+	case *dataH264:
+		return &dataH264{
+			trackID: d.trackID,
+			ntp:     d.ntp,
+			pts:     d.pts,
+			nalus:   copyBuffers(d.nalus),
+		}
+	case *dataMPEG4Audio:
+		return &dataMPEG4Audio{
+			trackID: d.trackID,
+			ntp:     d.ntp,
+			pts:     d.pts,
+			aus:     copyBuffers(d.aus),
+		}
+	}
+	return d
+}
+
+// copyBuffers copies the buffers into a single allocation.
+func copyBuffers(bufs [][]byte) [][]byte {
+	if bufs == nil {
+		return nil
+	}
+	size := 0
+	for _, b := range bufs {
+		size += len(b)
+	}
+	buf := make([]byte, size)
+	ret := make([][]byte, len(bufs))
+	n := 0
+	for i, b := range bufs {
+		ret[i] = buf[n : n+len(b) : n+len(b)]
+		n += copy(buf[n:], b)
+	}
+	return ret
+}
@@ -0,0 +1,55 @@
+package video
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetachData(t *testing.T) {
+	t.Run("h264", func(t *testing.T) {
+		payload := []byte{1, 2, 3, 4}
+		d := &dataH264{
+			trackID:    1,
+			rtpPackets: []*rtp.Packet{{Payload: payload}},
+			ntp:        time.Unix(1, 0),
+			pts:        2,
+			nalus:      [][]byte{payload[:2], payload[2:]},
+		}
+		detached := detachData(d)
+		require.Equal(t, &dataH264{
+			trackID: 1,
+			ntp:     time.Unix(1, 0),
+			pts:     2,
+			nalus:   [][]byte{{1, 2}, {3, 4}},
+		}, detached)
+
+		// The payload is reused.
+		copy(payload, []byte{5, 6, 7, 8})
+		require.Equal(t, [][]byte{{1, 2}, {3, 4}}, detached.(*dataH264).nalus)
+
+		// Appending to a NALU doesn't overwrite the next.
+		nalus := detached.(*dataH264).nalus
+		_ = append(nalus[0], 9)
+		require.Equal(t, []byte{3, 4}, nalus[1])
+	})
+	t.Run("h264Nil", func(t *testing.T) {
+		detached := detachData(&dataH264{trackID: 1})
+		require.Nil(t, detached.(*dataH264).nalus)
+	})
+	t.Run("mpeg4Audio", func(t *testing.T) {
+		payload := []byte{1, 2}
+		detached := detachData(&dataMPEG4Audio{
+			trackID:    2,
+			rtpPackets: []*rtp.Packet{{Payload: payload}},
+			aus:        [][]byte{payload},
+		})
+		payload[0] = 3
+		require.Equal(t, &dataMPEG4Audio{
+			trackID: 2,
+			aus:     [][]byte{{1, 2}},
+		}, detached)
+	})
+}
@@ -0,0 +1,89 @@
+package video
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"nvr/pkg/web/api"
+	"time"
+)
+
+// Server-sent event types.
+const eventAudioLevel = "audio-level"
+
+// A comment is sent if there are no events, proxies may
+// close connections that are idle for too long.
+const eventsKeepalive = 15 * time.Second
+
+// writeEvent writes a server-sent event with JSON data.
+func writeEvent(w io.Writer, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// HandleEvents streams the live events of all monitors, or a single
+// monitor if the "id" query parameter is set, as server-sent events.
+// The latest audio levels are sent when the stream starts.
+func (s *Server) HandleEvents() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
+			return
+		}
+		id := r.URL.Query().Get("id")
+
+		ctx := r.Context()
+		levels := s.audioLevels.subscribe(ctx)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		rc := http.NewResponseController(w)
+		flush := func() bool {
+			return rc.Flush() == nil
+		}
+
+		for monitorID, level := range s.audioLevels.latest() {
+			if id != "" && monitorID != id {
+				continue
+			}
+			event := audioLevelEvent{MonitorID: monitorID, AudioLevel: level}
+			if err := writeEvent(w, eventAudioLevel, event); err != nil {
+				return
+			}
+		}
+		if !flush() {
+			return
+		}
+
+		keepalive := time.NewTicker(eventsKeepalive)
+		defer keepalive.Stop()
+
+		for {
+			select {
+			case event := <-levels:
+				if id != "" && event.MonitorID != id {
+					continue
+				}
+				if err := writeEvent(w, eventAudioLevel, event); err != nil {
+					return
+				}
+			case <-keepalive.C:
+				if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+			if !flush() {
+				return
+			}
+		}
+	})
+}
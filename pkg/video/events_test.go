@@ -0,0 +1,96 @@
+package video
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteEvent(t *testing.T) {
+	var buf bytes.Buffer
+	event := audioLevelEvent{MonitorID: "a", AudioLevel: AudioLevel{RMS: -30.5, Peak: -20}}
+	require.NoError(t, writeEvent(&buf, eventAudioLevel, event))
+
+	expected := "event: audio-level\n" +
+		`data: {"monitorID":"a","rms":-30.5,"peak":-20}` + "\n\n"
+	require.Equal(t, expected, buf.String())
+}
+
+func TestHandleEvents(t *testing.T) {
+	s, cancel := newTestServer(t)
+	defer cancel()
+
+	a := fakeAudioLevelSource{levels: s.audioLevels, monitorID: "a"}
+	b := fakeAudioLevelSource{levels: s.audioLevels, monitorID: "b"}
+
+	server := httptest.NewServer(s.HandleEvents())
+	defer server.Close()
+
+	// readEvent reads the lines of the next event.
+	readEvent := func(r *bufio.Reader) string {
+		var event string
+		for {
+			line, err := r.ReadString('\n')
+			require.NoError(t, err)
+			if line == "\n" {
+				return event
+			}
+			event += line
+		}
+	}
+	connect := func(url string) (*bufio.Reader, func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		require.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+		return bufio.NewReader(res.Body), func() {
+			cancel()
+			res.Body.Close()
+		}
+	}
+
+	t.Run("latest", func(t *testing.T) {
+		a.emit(-30, -20)
+		defer s.audioLevels.remove("a")
+
+		r, disconnect := connect(server.URL)
+		defer disconnect()
+
+		// The latest level is sent first.
+		expected := "event: audio-level\n" +
+			`data: {"monitorID":"a","rms":-30,"peak":-20}` + "\n"
+		require.Equal(t, expected, readEvent(r))
+
+		b.emit(-40, -35)
+		expected = "event: audio-level\n" +
+			`data: {"monitorID":"b","rms":-40,"peak":-35}` + "\n"
+		require.Equal(t, expected, readEvent(r))
+	})
+	t.Run("filter", func(t *testing.T) {
+		b.emit(-40, -35)
+		defer s.audioLevels.remove("b")
+
+		r, disconnect := connect(server.URL + "?id=a")
+		defer disconnect()
+
+		b.emit(-41, -36)
+		a.emit(-31, -21)
+		expected := "event: audio-level\n" +
+			`data: {"monitorID":"a","rms":-31,"peak":-21}` + "\n"
+		require.Equal(t, expected, readEvent(r))
+	})
+	t.Run("methodNotAllowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/monitor/events", nil)
+		s.HandleEvents().ServeHTTP(w, r)
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
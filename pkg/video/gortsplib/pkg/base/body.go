@@ -11,30 +11,38 @@ import (
 // Errors.
 var (
 	ErrBodyContentLengthInvalid = errors.New("invalid Content-Length")
-	ErrBodyContentLengthToBig   = fmt.Errorf(
-		"Content-Length exceeds %d", rtspMaxContentLength)
+	ErrBodyContentLengthMissing = errors.New("missing Content-Length")
+	ErrBodyContentLengthToBig   = errors.New("Content-Length exceeds")
 )
 
-const (
-	rtspMaxContentLength = 128 * 1024
-)
+// DefaultMaxBodySize is the default maximum body size in bytes.
+const DefaultMaxBodySize = 128 * 1024
 
 type body []byte
 
-func (b *body) read(header Header, rb *bufio.Reader) error {
+// read reads a body of Content-Length bytes. The body can only be framed
+// by Content-Length, if required is true and the header is missing, the
+// body can't be skipped and ErrBodyContentLengthMissing is returned.
+func (b *body) read(header Header, rb *bufio.Reader, maxSize int64, required bool) error {
 	cls, ok := header["Content-Length"]
-	if !ok || len(cls) != 1 {
+	if !ok {
 		*b = nil
+		if required {
+			return ErrBodyContentLengthMissing
+		}
 		return nil
 	}
+	if len(cls) != 1 {
+		return ErrBodyContentLengthInvalid
+	}
 
 	cl, err := strconv.ParseInt(cls[0], 10, 64)
-	if err != nil {
+	if err != nil || cl < 0 {
 		return ErrBodyContentLengthInvalid
 	}
 
-	if cl > rtspMaxContentLength {
-		return fmt.Errorf("%w (it's %d)", ErrBodyContentLengthToBig, cl)
+	if cl > maxSize {
+		return fmt.Errorf("%w %d (it's %d)", ErrBodyContentLengthToBig, maxSize, cl)
 	}
 
 	*b = make([]byte, cl)
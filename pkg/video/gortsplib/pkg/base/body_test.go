@@ -26,7 +26,7 @@ func TestBodyRead(t *testing.T) {
 	for _, ca := range casesBody {
 		t.Run(ca.name, func(t *testing.T) {
 			var p body
-			err := p.read(ca.h, bufio.NewReader(bytes.NewReader(ca.byts)))
+			err := p.read(ca.h, bufio.NewReader(bytes.NewReader(ca.byts)), DefaultMaxBodySize, false)
 			require.NoError(t, err)
 			require.Equal(t, ca.byts, []byte(p))
 		})
@@ -64,15 +64,52 @@ func TestBodyReadErrors(t *testing.T) {
 			[]byte("123"),
 			"Content-Length exceeds 131072 (it's 1000000)",
 		},
+		{
+			"negative content-length",
+			Header{
+				"Content-Length": HeaderValue{"-1"},
+			},
+			[]byte("123"),
+			"invalid Content-Length",
+		},
+		{
+			"multiple content-length",
+			Header{
+				"Content-Length": HeaderValue{"1", "2"},
+			},
+			[]byte("123"),
+			"invalid Content-Length",
+		},
 	} {
 		t.Run(ca.name, func(t *testing.T) {
 			var p body
-			err := p.read(ca.h, bufio.NewReader(bytes.NewReader(ca.byts)))
+			err := p.read(ca.h, bufio.NewReader(bytes.NewReader(ca.byts)), DefaultMaxBodySize, false)
 			require.EqualError(t, err, ca.err)
 		})
 	}
 }
 
+func TestBodyReadLimits(t *testing.T) {
+	t.Run("missing", func(t *testing.T) {
+		var p body
+		err := p.read(Header{}, bufio.NewReader(bytes.NewReader([]byte("v=0"))), 10, true)
+		require.ErrorIs(t, err, ErrBodyContentLengthMissing)
+	})
+	t.Run("notRequired", func(t *testing.T) {
+		var p body
+		err := p.read(Header{}, bufio.NewReader(bytes.NewReader([]byte("v=0"))), 10, false)
+		require.NoError(t, err)
+		require.Nil(t, p)
+	})
+	t.Run("maxSize", func(t *testing.T) {
+		h := Header{"Content-Length": HeaderValue{"4"}}
+		var p body
+		err := p.read(h, bufio.NewReader(bytes.NewReader([]byte("1234"))), 3, false)
+		require.ErrorIs(t, err, ErrBodyContentLengthToBig)
+		require.EqualError(t, err, "Content-Length exceeds 3 (it's 4)")
+	})
+}
+
 func TestBodyMarshal(t *testing.T) {
 	for _, ca := range casesBody {
 		t.Run(ca.name, func(t *testing.T) {
@@ -66,7 +66,8 @@ func (h *Header) read(rb *bufio.Reader) error {
 		key := string([]byte{byt})
 		byts, err := readBytesLimited(rb, ':', headerMaxKeyLength-1)
 		if err != nil {
-			return ErrHeaderValueMissing
+			// Keep the cause, read deadlines are checked by the server.
+			return fmt.Errorf("%w: %w", ErrHeaderValueMissing, err)
 		}
 		key += string(byts[:len(byts)-1])
 		key = headerKeyNormalize(key)
@@ -26,9 +26,20 @@ var ErrInvalidMagicByte = errors.New("invalid magic byte")
 
 // Read decodes an interleaved frame.
 func (f *InterleavedFrame) Read(br *bufio.Reader) error {
-	var header [4]byte
-	_, err := io.ReadFull(br, header[:])
+	return f.ReadWithAlloc(br, func(size int) []byte {
+		return make([]byte, size)
+	})
+}
+
+// ReadWithAlloc decodes an interleaved frame, the payload
+// is read into the buffer returned by alloc.
+func (f *InterleavedFrame) ReadWithAlloc(br *bufio.Reader, alloc func(size int) []byte) error {
+	// Peek doesn't allocate, unlike reading into a local array.
+	header, err := br.Peek(4)
 	if err != nil {
+		if len(header) != 0 && errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
 		return err
 	}
 
@@ -39,7 +50,8 @@ func (f *InterleavedFrame) Read(br *bufio.Reader) error {
 	payloadLen := int(binary.BigEndian.Uint16(header[2:]))
 
 	f.Channel = int(header[1])
-	f.Payload = make([]byte, payloadLen)
+	br.Discard(4) //nolint:errcheck
+	f.Payload = alloc(payloadLen)
 
 	_, err = io.ReadFull(br, f.Payload)
 	if err != nil {
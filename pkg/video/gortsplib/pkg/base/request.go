@@ -28,6 +28,7 @@ const (
 	Pause        Method = "PAUSE"
 	Play         Method = "PLAY"
 	Record       Method = "RECORD"
+	Redirect     Method = "REDIRECT"
 	Setup        Method = "SETUP"
 	SetParameter Method = "SET_PARAMETER"
 	Teardown     Method = "TEARDOWN"
@@ -55,8 +56,15 @@ var (
 	ErrReqUnexpectedProtocol = errors.New("unexpected protocol")
 )
 
-// Read reads a request.
+// Read reads a request with the default maximum body size.
 func (req *Request) Read(rb *bufio.Reader) error {
+	return req.ReadWithMaxBodySize(rb, DefaultMaxBodySize)
+}
+
+// ReadWithMaxBodySize reads a request. The request is left
+// partially read if an error is returned and the reader
+// must not be used to read the following request.
+func (req *Request) ReadWithMaxBodySize(rb *bufio.Reader, maxBodySize int64) error {
 	byts, err := readBytesLimited(rb, ' ', requestMaxMethodLength)
 	if err != nil {
 		return err
@@ -100,7 +108,7 @@ func (req *Request) Read(rb *bufio.Reader) error {
 		return err
 	}
 
-	err = (*body)(&req.Body).read(req.Header, rb)
+	err = (*body)(&req.Body).read(req.Header, rb, maxBodySize, req.hasBody())
 	if err != nil {
 		return err
 	}
@@ -108,6 +116,12 @@ func (req *Request) Read(rb *bufio.Reader) error {
 	return nil
 }
 
+// hasBody returns true if the request declares a body type.
+func (req *Request) hasBody() bool {
+	_, ok := req.Header["Content-Type"]
+	return ok
+}
+
 // MarshalSize returns the size of a Request.
 func (req Request) MarshalSize() int {
 	n := 0
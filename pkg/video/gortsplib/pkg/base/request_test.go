@@ -220,6 +220,23 @@ func TestRequestReadErrors(t *testing.T) {
 			[]byte("GET rtsp://testing123 RTSP/1.0\r\nContent-Length: 17\r\n\r\n123"),
 			io.ErrUnexpectedEOF,
 		},
+		{
+			"announce missing content-length",
+			[]byte("ANNOUNCE rtsp://testing123 RTSP/1.0\r\nCSeq: 1\r\n" +
+				"Content-Type: application/sdp\r\n\r\nv=0\r\n"),
+			ErrBodyContentLengthMissing,
+		},
+		{
+			"content-type missing content-length",
+			[]byte("SET_PARAMETER rtsp://testing123 RTSP/1.0\r\n" +
+				"Content-Type: text/parameters\r\n\r\na: b\r\n"),
+			ErrBodyContentLengthMissing,
+		},
+		{
+			"body too big",
+			[]byte("ANNOUNCE rtsp://testing123 RTSP/1.0\r\nContent-Length: 1000000\r\n\r\n"),
+			ErrBodyContentLengthToBig,
+		},
 	} {
 		t.Run(ca.name, func(t *testing.T) {
 			var req Request
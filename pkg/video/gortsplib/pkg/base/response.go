@@ -29,6 +29,7 @@ const (
 	StatusProxyAuthRequired                  StatusCode = 407
 	StatusRequestTimeout                     StatusCode = 408
 	StatusGone                               StatusCode = 410
+	StatusLengthRequired                     StatusCode = 411
 	StatusPreconditionFailed                 StatusCode = 412
 	StatusRequestEntityTooLarge              StatusCode = 413
 	StatusRequestURITooLong                  StatusCode = 414
@@ -85,6 +86,7 @@ var statusMessages = map[StatusCode]string{
 	StatusProxyAuthRequired:                  "Proxy Auth Required",
 	StatusRequestTimeout:                     "Request Timeout",
 	StatusGone:                               "Gone",
+	StatusLengthRequired:                     "Length Required",
 	StatusPreconditionFailed:                 "Precondition Failed",
 	StatusRequestEntityTooLarge:              "Request Entity Too Large",
 	StatusRequestURITooLong:                  "Request URI Too Long",
@@ -123,7 +125,8 @@ type Response struct {
 	// numeric status code
 	StatusCode StatusCode
 
-	// status message
+	// status message, the standard message of the
+	// status code is used if empty. May be empty when read.
 	StatusMessage string
 
 	// map of header values
@@ -137,11 +140,16 @@ type Response struct {
 var (
 	ErrResUnexpectedProtocol = errors.New("unexpected protocol")
 	ErrResStatusCodeBad      = errors.New("unable to parse status code")
-	ErrResStatusMsgEmpty     = errors.New("empty status message")
 )
 
-// Read reads a response.
+// Read reads a response with the default maximum body size.
 func (res *Response) Read(rb *bufio.Reader) error {
+	return res.ReadWithMaxBodySize(rb, DefaultMaxBodySize)
+}
+
+// ReadWithMaxBodySize reads a response. Bodies are always read, even
+// if the caller doesn't need them, to keep the reader in sync.
+func (res *Response) ReadWithMaxBodySize(rb *bufio.Reader, maxBodySize int64) error {
 	byts, err := readBytesLimited(rb, ' ', 255)
 	if err != nil {
 		return err
@@ -153,11 +161,10 @@ func (res *Response) Read(rb *bufio.Reader) error {
 			ErrResUnexpectedProtocol, rtspProtocol10, proto)
 	}
 
-	byts, err = readBytesLimited(rb, ' ', 4)
+	statusCodeStr, err := readStatusCode(rb)
 	if err != nil {
 		return err
 	}
-	statusCodeStr := string(byts[:len(byts)-1])
 
 	statusCode64, err := strconv.ParseInt(statusCodeStr, 10, 32)
 	if err != nil {
@@ -171,10 +178,6 @@ func (res *Response) Read(rb *bufio.Reader) error {
 	}
 	res.StatusMessage = string(byts[:len(byts)-1])
 
-	if len(res.StatusMessage) == 0 {
-		return ErrResStatusMsgEmpty
-	}
-
 	err = readNewLine(rb)
 	if err != nil {
 		return err
@@ -185,7 +188,8 @@ func (res *Response) Read(rb *bufio.Reader) error {
 		return err
 	}
 
-	err = (*body)(&res.Body).read(res.Header, rb)
+	_, hasContentType := res.Header["Content-Type"]
+	err = (*body)(&res.Body).read(res.Header, rb, maxBodySize, hasContentType)
 	if err != nil {
 		return err
 	}
@@ -193,6 +197,30 @@ func (res *Response) Read(rb *bufio.Reader) error {
 	return nil
 }
 
+// readStatusCode reads the status code and the following space. Some
+// servers omit the space if the reason phrase is empty, the '\r' is kept.
+func readStatusCode(rb *bufio.Reader) (string, error) {
+	const maxLen = 4
+	for i := 1; i <= maxLen; i++ {
+		byts, err := rb.Peek(i)
+		if err != nil {
+			return "", err
+		}
+
+		switch byts[i-1] {
+		case ' ':
+			code := string(byts[:i-1])
+			rb.Discard(i) //nolint:errcheck
+			return code, nil
+		case '\r':
+			code := string(byts[:i-1])
+			rb.Discard(i - 1) //nolint:errcheck
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %d", ErrBufLenToBig, maxLen)
+}
+
 // MarshalSize returns the size of a Response.
 func (res Response) MarshalSize() int {
 	n := 0
@@ -91,6 +91,36 @@ var casesResponse = []struct {
 			),
 		},
 	},
+	{
+		"custom status message",
+		[]byte("RTSP/1.0 401 Camera Login Required\r\n" +
+			"CSeq: 3\r\n" +
+			"WWW-Authenticate: Basic realm=\"camera\"\r\n" +
+			"\r\n",
+		),
+		Response{
+			StatusCode:    StatusUnauthorized,
+			StatusMessage: "Camera Login Required",
+			Header: Header{
+				"CSeq":             HeaderValue{"3"},
+				"WWW-Authenticate": HeaderValue{"Basic realm=\"camera\""},
+			},
+		},
+	},
+	{
+		"nonstandard status code",
+		[]byte("RTSP/1.0 299 Custom\r\n" +
+			"CSeq: 4\r\n" +
+			"\r\n",
+		),
+		Response{
+			StatusCode:    299,
+			StatusMessage: "Custom",
+			Header: Header{
+				"CSeq": HeaderValue{"4"},
+			},
+		},
+	},
 }
 
 func TestResponseRead(t *testing.T) {
@@ -152,11 +182,6 @@ func TestResponseReadErrors(t *testing.T) {
 			[]byte("RTSP/1.0 str OK\r\n"),
 			ErrResStatusCodeBad,
 		},
-		{
-			"empty message",
-			[]byte("RTSP/1.0 200 \r\n"),
-			ErrResStatusMsgEmpty,
-		},
 		{
 			"invalid header",
 			[]byte("RTSP/1.0 200 OK\r\nTesting: val\r"),
@@ -167,6 +192,11 @@ func TestResponseReadErrors(t *testing.T) {
 			[]byte("RTSP/1.0 200 OK\r\nContent-Length: 17\r\n\r\n123"),
 			io.ErrUnexpectedEOF,
 		},
+		{
+			"content-type missing content-length",
+			[]byte("RTSP/1.0 404 Not Found\r\nContent-Type: text/html\r\n\r\nnot found"),
+			ErrBodyContentLengthMissing,
+		},
 	} {
 		t.Run(ca.name, func(t *testing.T) {
 			var res Response
@@ -176,6 +206,59 @@ func TestResponseReadErrors(t *testing.T) {
 	}
 }
 
+func TestResponseReadEmptyStatusMessage(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		byts []byte
+	}{
+		{
+			"trailing space",
+			[]byte("RTSP/1.0 200 \r\nCSeq: 1\r\n\r\n"),
+		},
+		{
+			"no space",
+			[]byte("RTSP/1.0 200\r\nCSeq: 1\r\n\r\n"),
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var res Response
+			err := res.Read(bufio.NewReader(bytes.NewBuffer(ca.byts)))
+			require.NoError(t, err)
+			require.Equal(t, Response{
+				StatusCode: StatusOK,
+				Header:     Header{"CSeq": HeaderValue{"1"}},
+			}, res)
+
+			// The standard message is used when marshaled.
+			require.Equal(t, "RTSP/1.0 200 OK\r\nCSeq: 1\r\n\r\n", res.String())
+		})
+	}
+}
+
+func TestResponseReadUnexpectedBody(t *testing.T) {
+	byts := []byte("RTSP/1.0 404 Not Found\r\n" +
+		"CSeq: 1\r\n" +
+		"Content-Type: text/html\r\n" +
+		"Content-Length: 9\r\n" +
+		"\r\n" +
+		"not found" +
+		"RTSP/1.0 200 OK\r\n" +
+		"CSeq: 2\r\n" +
+		"\r\n")
+	rb := bufio.NewReader(bytes.NewBuffer(byts))
+
+	var res Response
+	require.NoError(t, res.Read(rb))
+	require.Equal(t, StatusNotFound, res.StatusCode)
+	require.Equal(t, []byte("not found"), res.Body)
+
+	// The body must be skipped to read the next response.
+	var res2 Response
+	require.NoError(t, res2.Read(rb))
+	require.Equal(t, StatusOK, res2.StatusCode)
+	require.Equal(t, HeaderValue{"2"}, res2.Header["CSeq"])
+}
+
 func TestResponseMarshal(t *testing.T) {
 	for _, c := range casesResponse {
 		t.Run(c.name, func(t *testing.T) {
@@ -213,6 +296,31 @@ func TestResponseMarshalAutoFillStatus(t *testing.T) {
 	require.Equal(t, byts, buf)
 }
 
+func TestResponseMarshalStatusMessages(t *testing.T) {
+	for _, ca := range []struct {
+		code     StatusCode
+		expected string
+	}{
+		{StatusContinue, "RTSP/1.0 100 Continue\r\n\r\n"},
+		{StatusMethodNotValidInThisState, "RTSP/1.0 455 Method Not Valid In This State\r\n\r\n"},
+		{StatusInvalidRange, "RTSP/1.0 457 Invalid Range\r\n\r\n"},
+		{StatusOptionNotSupported, "RTSP/1.0 551 Option Not Supported\r\n\r\n"},
+	} {
+		t.Run(ca.expected[9:12], func(t *testing.T) {
+			res := Response{StatusCode: ca.code, Header: Header{}}
+			buf, err := res.Marshal()
+			require.NoError(t, err)
+			require.Equal(t, ca.expected, string(buf))
+
+			var res2 Response
+			err = res2.Read(bufio.NewReader(bytes.NewBuffer(buf)))
+			require.NoError(t, err)
+			require.Equal(t, ca.code, res2.StatusCode)
+			require.Equal(t, StatusMessages[ca.code], res2.StatusMessage)
+		})
+	}
+}
+
 func TestResponseString(t *testing.T) {
 	byts := []byte("RTSP/1.0 200 OK\r\n" +
 		"CSeq: 3\r\n" +
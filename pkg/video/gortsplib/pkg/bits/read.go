@@ -11,11 +11,17 @@ var (
 	ErrInvalidValue  = errors.New("invalid value")
 )
 
-// ReadBits reads N bits.
+// ReadBits reads N bits, N must be between 0 and 64.
 func ReadBits(buf []byte, pos *int, n int) (uint64, error) {
+	if n < 0 || n > 64 || *pos < 0 {
+		return 0, ErrInvalidValue
+	}
 	if n > ((len(buf) * 8) - *pos) {
 		return 0, ErrNotEnoughBits
 	}
+	if n == 0 {
+		return 0, nil
+	}
 
 	v := uint64(0)
 
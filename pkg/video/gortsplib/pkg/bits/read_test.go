@@ -30,6 +30,52 @@ func TestReadBitsError(t *testing.T) {
 	require.EqualError(t, err, "not enough bits")
 }
 
+func TestReadBitsBounds(t *testing.T) {
+	cases := []struct {
+		name string
+		len  int
+		pos  int
+		n    int
+		err  error
+	}{
+		{"emptyZero", 0, 0, 0, nil},
+		{"endZero", 1, 8, 0, nil},
+		{"endZero2", 2, 16, 0, nil},
+		{"end", 1, 8, 1, ErrNotEnoughBits},
+		{"overflow", 1, 1, 8, ErrNotEnoughBits},
+		{"overflow2", 1, 1, 9, ErrNotEnoughBits},
+		{"posPastEnd", 1, 9, 0, ErrNotEnoughBits},
+		{"exact", 2, 3, 13, nil},
+		{"negativeN", 1, 0, -1, ErrInvalidValue},
+		{"negativePos", 1, -1, 1, ErrInvalidValue},
+		{"tooLarge", 9, 0, 65, ErrInvalidValue},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := make([]byte, tc.len)
+			pos := tc.pos
+			require.NotPanics(t, func() {
+				_, err := ReadBits(buf, &pos, tc.n)
+				require.ErrorIs(t, err, tc.err)
+			})
+		})
+	}
+}
+
+func TestReadBitsNoPanic(t *testing.T) {
+	for l := 0; l < 4; l++ {
+		buf := make([]byte, l)
+		for p := 0; p <= l*8+1; p++ {
+			for n := 0; n <= 65; n++ {
+				pos := p
+				require.NotPanics(t, func() {
+					ReadBits(buf, &pos, n) //nolint:errcheck
+				}, "len=%d pos=%d n=%d", l, p, n)
+			}
+		}
+	}
+}
+
 func TestReadGolombUnsigned(t *testing.T) {
 	buf := []byte{0x38}
 	pos := 0
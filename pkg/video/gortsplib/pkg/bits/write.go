@@ -1,26 +1,41 @@
 package bits
 
-// WriteBits writes N bits.
-func WriteBits(buf []byte, pos *int, bits uint64, n int) {
+// WriteBits writes the N least significant bits of v, N must be between 0 and 64.
+// The caller must make sure that buf has room for N bits after pos,
+// use BytesNeeded to size the buffer. WriteBits panics otherwise.
+// Bits after pos in the first byte must be zero.
+func WriteBits(buf []byte, pos *int, v uint64, n int) {
+	if n <= 0 {
+		return
+	}
+	if n < 64 {
+		v &= 1<<n - 1
+	}
+
 	res := 8 - (*pos & 0x07)
 	if n < res {
-		buf[*pos>>0x03] |= byte(bits << (res - n))
+		buf[*pos>>0x03] |= byte(v << (res - n))
 		*pos += n
 		return
 	}
 
-	buf[*pos>>3] |= byte(bits >> (n - res))
+	buf[*pos>>3] |= byte(v >> (n - res))
 	*pos += res
 	n -= res
 
 	for n >= 8 {
-		buf[*pos>>3] = byte(bits >> (n - 8))
+		buf[*pos>>3] = byte(v >> (n - 8))
 		*pos += 8
 		n -= 8
 	}
 
 	if n > 0 {
-		buf[*pos>>3] = byte((bits & (1<<n - 1)) << (8 - n))
+		buf[*pos>>3] = byte((v & (1<<n - 1)) << (8 - n))
 		*pos += n
 	}
 }
+
+// BytesNeeded returns the number of bytes needed to hold N bits.
+func BytesNeeded(n int) int {
+	return (n + 7) / 8
+}
@@ -1,6 +1,7 @@
 package bits
 
 import (
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -16,3 +17,66 @@ func TestWriteBits(t *testing.T) {
 	WriteBits(buf, &pos, uint64(0xaaec4), 20)
 	require.Equal(t, []byte{0xA8, 0xC7, 0xD6, 0xAA, 0xBB, 0x10}, buf)
 }
+
+func TestWriteBitsMasksValue(t *testing.T) {
+	buf := make([]byte, 2)
+	pos := 0
+	WriteBits(buf, &pos, 0xFF, 3)
+	WriteBits(buf, &pos, 0, 5)
+	require.Equal(t, []byte{0xE0, 0x00}, buf)
+	require.Equal(t, 8, pos)
+}
+
+func TestWriteBitsZero(t *testing.T) {
+	buf := make([]byte, 1)
+	pos := 8
+	require.NotPanics(t, func() {
+		WriteBits(buf, &pos, 1, 0)
+	})
+	require.Equal(t, 8, pos)
+}
+
+func TestBytesNeeded(t *testing.T) {
+	require.Equal(t, 0, BytesNeeded(0))
+	require.Equal(t, 1, BytesNeeded(1))
+	require.Equal(t, 1, BytesNeeded(8))
+	require.Equal(t, 2, BytesNeeded(9))
+}
+
+func TestWriteReadRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1)) //nolint:gosec
+
+	for i := 0; i < 1000; i++ {
+		offset := rng.Intn(16)
+		count := 1 + rng.Intn(8)
+
+		values := make([]uint64, count)
+		sizes := make([]int, count)
+		total := offset
+		for j := range values {
+			sizes[j] = 1 + rng.Intn(64)
+			values[j] = rng.Uint64()
+			if sizes[j] < 64 {
+				values[j] &= 1<<sizes[j] - 1
+			}
+			total += sizes[j]
+		}
+
+		buf := make([]byte, BytesNeeded(total))
+		pos := offset
+		for j, v := range values {
+			WriteBits(buf, &pos, v, sizes[j])
+		}
+		require.Equal(t, total, pos)
+
+		pos = offset
+		for j, v := range values {
+			actual, err := ReadBits(buf, &pos, sizes[j])
+			require.NoError(t, err)
+			require.Equal(t, v, actual, "iteration=%d value=%d size=%d", i, j, sizes[j])
+		}
+
+		_, err := ReadBits(buf, &pos, len(buf)*8-pos+1)
+		require.ErrorIs(t, err, ErrNotEnoughBits)
+	}
+}
@@ -3,51 +3,129 @@ package conn
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"io"
 	"nvr/pkg/video/gortsplib/pkg/base"
+	"sync"
 )
 
 const (
 	readBufferSize = 4096
 )
 
-// Conn is a RTSP connection.
+// Conn is a RTSP connection. Reads must be done from a single goroutine,
+// writes may be done concurrently and are never interleaved.
 type Conn struct {
-	w   io.Writer
-	br  *bufio.Reader
-	req base.Request
-	res base.Response
-	fr  base.InterleavedFrame
+	w           io.Writer
+	writeMu     sync.Mutex
+	br          *bufio.Reader
+	req         base.Request
+	res         base.Response
+	fr          base.InterleavedFrame
+	maxBodySize int64
+
+	// The payload buffer of fr if it was read from the pool.
+	framePool  *FramePool
+	frameBuf   *[]byte
+	frameAlloc func(size int) []byte
+
+	// The position in the stream is unknown after a read
+	// error, every following read returns the same error.
+	readErr error
 }
 
 // NewConn allocates a Conn.
 func NewConn(rw io.ReadWriter) *Conn {
 	return &Conn{
-		w:  rw,
-		br: bufio.NewReaderSize(rw, readBufferSize),
+		w:           rw,
+		br:          bufio.NewReaderSize(rw, readBufferSize),
+		maxBodySize: base.DefaultMaxBodySize,
 	}
 }
 
-// ReadRequest reads a Request.
+// SetMaxBodySize sets the maximum body size of requests and responses.
+func (c *Conn) SetMaxBodySize(size int64) {
+	c.maxBodySize = size
+}
+
+// SetFramePool sets the pool that payloads of interleaved frames are read into.
+func (c *Conn) SetFramePool(pool *FramePool) {
+	c.framePool = pool
+	c.frameAlloc = func(size int) []byte {
+		c.frameBuf = c.framePool.get(size)
+		return *c.frameBuf
+	}
+}
+
+// Buffered returns true if bytes that haven't been read are buffered.
+func (c *Conn) Buffered() bool {
+	return c.br.Buffered() != 0
+}
+
+// ReadRequest reads a Request. The request is reused by the next read.
 func (c *Conn) ReadRequest() (*base.Request, error) {
-	err := c.req.Read(c.br)
-	return &c.req, err
+	if c.readErr != nil {
+		return nil, c.readErr
+	}
+	c.readErr = c.req.ReadWithMaxBodySize(c.br, c.maxBodySize)
+	return &c.req, c.readErr
 }
 
 // ReadResponse reads a Response.
 func (c *Conn) ReadResponse() (*base.Response, error) {
-	err := c.res.Read(c.br)
-	return &c.res, err
+	if c.readErr != nil {
+		return nil, c.readErr
+	}
+	c.readErr = c.res.ReadWithMaxBodySize(c.br, c.maxBodySize)
+	return &c.res, c.readErr
 }
 
-// ReadInterleavedFrame reads a InterleavedFrame.
+// ReadInterleavedFrame reads a InterleavedFrame. If a frame pool is set,
+// the payload is only valid until ReleaseInterleavedFrame is called, which
+// must be done before the next frame is read.
 func (c *Conn) ReadInterleavedFrame() (*base.InterleavedFrame, error) {
-	err := c.fr.Read(c.br)
-	return &c.fr, err
+	if c.readErr != nil {
+		return nil, c.readErr
+	}
+	if c.framePool == nil {
+		c.readErr = c.fr.Read(c.br)
+		return &c.fr, c.readErr
+	}
+
+	if c.frameBuf != nil && c.framePool.debug {
+		panic("conn: interleaved frame was not released")
+	}
+	c.readErr = c.fr.ReadWithAlloc(c.br, c.frameAlloc)
+	if c.readErr != nil && c.frameBuf != nil {
+		c.ReleaseInterleavedFrame()
+	}
+	return &c.fr, c.readErr
+}
+
+// ReleaseInterleavedFrame returns the payload of the last interleaved frame
+// to the frame pool. The frame must not be released twice.
+func (c *Conn) ReleaseInterleavedFrame() {
+	if c.framePool == nil {
+		return
+	}
+	if c.frameBuf == nil {
+		if c.framePool.debug {
+			panic("conn: interleaved frame was already released")
+		}
+		return
+	}
+	c.framePool.put(c.frameBuf)
+	c.frameBuf = nil
+	c.fr.Payload = nil
 }
 
 // ReadInterleavedFrameOrRequest reads an InterleavedFrame or a Request.
+// The partially read request is returned together with body errors.
 func (c *Conn) ReadInterleavedFrameOrRequest() (interface{}, error) {
+	if c.readErr != nil {
+		return nil, c.readErr
+	}
 	b, err := c.br.ReadByte()
 	if err != nil {
 		return nil, err
@@ -63,6 +141,9 @@ func (c *Conn) ReadInterleavedFrameOrRequest() (interface{}, error) {
 
 // ReadInterleavedFrameOrResponse reads an InterleavedFrame or a Response.
 func (c *Conn) ReadInterleavedFrameOrResponse() (interface{}, error) {
+	if c.readErr != nil {
+		return nil, c.readErr
+	}
 	b, err := c.br.ReadByte()
 	if err != nil {
 		return nil, err
@@ -104,23 +185,41 @@ func (c *Conn) ReadResponseIgnoreFrames() (*base.Response, error) {
 	}
 }
 
+// write writes a whole message, messages from other goroutines
+// aren't written in the middle of it even if w splits the write.
+func (c *Conn) write(buf []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.w.Write(buf)
+	return err
+}
+
 // WriteRequest writes a request.
 func (c *Conn) WriteRequest(req *base.Request) error {
 	buf, _ := req.Marshal()
-	_, err := c.w.Write(buf)
-	return err
+	return c.write(buf)
 }
 
 // WriteResponse writes a response.
 func (c *Conn) WriteResponse(res *base.Response) error {
 	buf, _ := res.Marshal()
-	_, err := c.w.Write(buf)
-	return err
+	return c.write(buf)
+}
+
+// ErrNotProvisional status code is not in the 1xx range.
+var ErrNotProvisional = errors.New("status code is not provisional")
+
+// WriteResponseProvisional writes a provisional response, like 100 Continue,
+// before the final response to the same request. The CSeq header must be set.
+func (c *Conn) WriteResponseProvisional(res *base.Response) error {
+	if res.StatusCode < 100 || res.StatusCode > 199 {
+		return fmt.Errorf("%w: %d", ErrNotProvisional, res.StatusCode)
+	}
+	return c.WriteResponse(res)
 }
 
 // WriteInterleavedFrame writes an interleaved frame.
 func (c *Conn) WriteInterleavedFrame(fr *base.InterleavedFrame, buf []byte) error {
 	n, _ := fr.MarshalTo(buf)
-	_, err := c.w.Write(buf[:n])
-	return err
+	return c.write(buf[:n])
 }
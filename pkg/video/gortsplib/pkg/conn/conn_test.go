@@ -2,6 +2,9 @@ package conn
 
 import (
 	"bytes"
+	"io"
+	"runtime"
+	"sync"
 	"testing"
 
 	"nvr/pkg/video/gortsplib/pkg/base"
@@ -80,6 +83,45 @@ func TestReadInterleavedFrameOrRequestErrors(t *testing.T) {
 	}
 }
 
+func TestReadRequestBodyErrorIsSticky(t *testing.T) {
+	// The SDP would be parsed as the next request if the reader continued.
+	byts := []byte("ANNOUNCE rtsp://example.com/media.mp4 RTSP/1.0\r\n" +
+		"CSeq: 2\r\n" +
+		"Content-Type: application/sdp\r\n" +
+		"\r\n" +
+		"v=0\r\n" +
+		"OPTIONS rtsp://example.com/media.mp4 RTSP/1.0\r\n" +
+		"CSeq: 3\r\n" +
+		"\r\n")
+
+	conn := NewConn(bytes.NewBuffer(byts))
+
+	out, err := conn.ReadInterleavedFrameOrRequest()
+	require.ErrorIs(t, err, base.ErrBodyContentLengthMissing)
+	req, ok := out.(*base.Request)
+	require.True(t, ok)
+	require.Equal(t, base.HeaderValue{"2"}, req.Header["CSeq"])
+
+	_, err = conn.ReadInterleavedFrameOrRequest()
+	require.ErrorIs(t, err, base.ErrBodyContentLengthMissing)
+	_, err = conn.ReadRequest()
+	require.ErrorIs(t, err, base.ErrBodyContentLengthMissing)
+}
+
+func TestSetMaxBodySize(t *testing.T) {
+	byts := []byte("ANNOUNCE rtsp://example.com/media.mp4 RTSP/1.0\r\n" +
+		"CSeq: 2\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"v=0\r\n")
+
+	conn := NewConn(bytes.NewBuffer(byts))
+	conn.SetMaxBodySize(4)
+
+	_, err := conn.ReadRequest()
+	require.ErrorIs(t, err, base.ErrBodyContentLengthToBig)
+}
+
 func TestReadInterleavedFrameOrResponse(t *testing.T) {
 	byts := []byte("RTSP/1.0 200 OK\r\n" +
 		"CSeq: 1\r\n" +
@@ -213,6 +255,34 @@ func TestWriteResponse(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestWriteResponseProvisional(t *testing.T) {
+	var buf bytes.Buffer
+	conn := NewConn(&buf)
+	err := conn.WriteResponseProvisional(&base.Response{
+		StatusCode: base.StatusContinue,
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"2"},
+		},
+	})
+	require.NoError(t, err)
+	err = conn.WriteResponse(&base.Response{
+		StatusCode: base.StatusOK,
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"2"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "RTSP/1.0 100 Continue\r\nCSeq: 2\r\n\r\n"+
+		"RTSP/1.0 200 OK\r\nCSeq: 2\r\n\r\n", buf.String())
+
+	res, err := NewConn(&buf).ReadResponse()
+	require.NoError(t, err)
+	require.Equal(t, base.StatusContinue, res.StatusCode)
+
+	err = conn.WriteResponseProvisional(&base.Response{StatusCode: base.StatusOK})
+	require.ErrorIs(t, err, ErrNotProvisional)
+}
+
 func TestWriteInterleavedFrame(t *testing.T) {
 	var buf bytes.Buffer
 	conn := NewConn(&buf)
@@ -222,3 +292,71 @@ func TestWriteInterleavedFrame(t *testing.T) {
 	}, make([]byte, 1024))
 	require.NoError(t, err)
 }
+
+// chunkWriter writes a single byte at a time like
+// a writer that is interrupted by short writes.
+type chunkWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *chunkWriter) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		w.mu.Lock()
+		w.buf.WriteByte(b)
+		w.mu.Unlock()
+		runtime.Gosched()
+	}
+	return len(p), nil
+}
+
+func TestWriteConcurrent(t *testing.T) {
+	var w chunkWriter
+	conn := NewConn(&w)
+
+	const n = 20
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			err := conn.WriteResponse(&base.Response{
+				StatusCode: base.StatusOK,
+				Header:     base.Header{"CSeq": base.HeaderValue{"1"}},
+			})
+			require.NoError(t, err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 1024)
+		for i := 0; i < n; i++ {
+			err := conn.WriteInterleavedFrame(&base.InterleavedFrame{
+				Channel: 0,
+				Payload: []byte{0x01, 0x02, 0x03, 0x04},
+			}, buf)
+			require.NoError(t, err)
+		}
+	}()
+	wg.Wait()
+
+	// The messages can be read back if they weren't interleaved.
+	r := NewConn(&w.buf)
+	var responses, frames int
+	for i := 0; i < 2*n; i++ {
+		what, err := r.ReadInterleavedFrameOrResponse()
+		require.NoError(t, err)
+		switch what.(type) {
+		case *base.Response:
+			responses++
+		case *base.InterleavedFrame:
+			frames++
+		}
+	}
+	require.Equal(t, n, responses)
+	require.Equal(t, n, frames)
+}
@@ -0,0 +1,93 @@
+package conn
+
+import (
+	"sync"
+)
+
+// MaxFramePayloadSize is the maximum payload size of interleaved frames.
+const MaxFramePayloadSize = 1<<16 - 1
+
+// poisonByte fills released buffers in debug mode.
+const poisonByte = 0xdd
+
+// FramePool is a pool of interleaved frame payload buffers, it can be
+// shared between connections. Buffers are reused after they're released,
+// consumers that keep the payload after the release must copy it.
+//
+// In debug mode, released buffers are poisoned and kept in a free list
+// instead of a sync.Pool. Double releases and writes after the release
+// cause a panic, reads after the release return the poison and are
+// reported as data races when the buffer is reused by another goroutine.
+type FramePool struct {
+	size  int
+	pool  sync.Pool
+	debug bool
+
+	mu   sync.Mutex
+	free []*[]byte
+}
+
+// NewFramePool allocates a FramePool. Payloads
+// larger than size are allocated without the pool.
+func NewFramePool(size int, debug bool) *FramePool {
+	p := &FramePool{
+		size:  size,
+		debug: debug,
+	}
+	p.pool.New = func() interface{} {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+func (p *FramePool) get(size int) *[]byte {
+	if size > p.size {
+		buf := make([]byte, size)
+		return &buf
+	}
+	if !p.debug {
+		buf := p.pool.Get().(*[]byte) //nolint:forcetypeassert
+		*buf = (*buf)[:size]
+		return buf
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.free) == 0 {
+		buf := make([]byte, size, p.size)
+		return &buf
+	}
+	buf := p.free[len(p.free)-1]
+	p.free = p.free[:len(p.free)-1]
+	for _, b := range (*buf)[:p.size] {
+		if b != poisonByte {
+			panic("conn: frame buffer was modified after release")
+		}
+	}
+	*buf = (*buf)[:size]
+	return buf
+}
+
+func (p *FramePool) put(buf *[]byte) {
+	if cap(*buf) != p.size {
+		return
+	}
+	if !p.debug {
+		p.pool.Put(buf)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, b := range p.free {
+		if b == buf {
+			panic("conn: frame buffer was released twice")
+		}
+	}
+	*buf = (*buf)[:p.size]
+	for i := range *buf {
+		(*buf)[i] = poisonByte
+	}
+	p.free = append(p.free, buf)
+}
@@ -0,0 +1,156 @@
+package conn
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func interleavedFrames(n int, size int) []byte {
+	frame := append([]byte{0x24, 0x0, byte(size >> 8), byte(size)}, make([]byte, size)...)
+	return bytes.Repeat(frame, n)
+}
+
+func TestReadInterleavedFramePooled(t *testing.T) {
+	byts := []byte{0x24, 0x6, 0x0, 0x4, 0x1, 0x2, 0x3, 0x4}
+	byts = append(byts, 0x24, 0x7, 0x0, 0x2, 0x5, 0x6)
+	byts = append(byts, 0x24, 0x8, 0x0, 0x4, 0x1)
+
+	pool := NewFramePool(MaxFramePayloadSize, true)
+	conn := NewConn(bytes.NewBuffer(byts))
+	conn.SetFramePool(pool)
+
+	fr, err := conn.ReadInterleavedFrame()
+	require.NoError(t, err)
+	require.Equal(t, 6, fr.Channel)
+	require.Equal(t, []byte{0x1, 0x2, 0x3, 0x4}, fr.Payload)
+	payload := fr.Payload
+	conn.ReleaseInterleavedFrame()
+	require.Nil(t, fr.Payload)
+
+	// The retained payload is poisoned.
+	require.Equal(t, []byte{poisonByte, poisonByte, poisonByte, poisonByte}, payload)
+
+	fr, err = conn.ReadInterleavedFrame()
+	require.NoError(t, err)
+	require.Equal(t, 7, fr.Channel)
+	require.Equal(t, []byte{0x5, 0x6}, fr.Payload)
+	conn.ReleaseInterleavedFrame()
+
+	// The buffer is released on read errors.
+	_, err = conn.ReadInterleavedFrame()
+	require.Error(t, err)
+	require.Len(t, pool.free, 1)
+}
+
+func TestReadInterleavedFramePooledTooBig(t *testing.T) {
+	pool := NewFramePool(2, true)
+	conn := NewConn(bytes.NewBuffer(interleavedFrames(1, 4)))
+	conn.SetFramePool(pool)
+
+	fr, err := conn.ReadInterleavedFrame()
+	require.NoError(t, err)
+	require.Len(t, fr.Payload, 4)
+	conn.ReleaseInterleavedFrame()
+	require.Empty(t, pool.free)
+}
+
+func TestFramePoolDebug(t *testing.T) {
+	t.Run("doubleRelease", func(t *testing.T) {
+		conn := NewConn(bytes.NewBuffer(interleavedFrames(1, 4)))
+		conn.SetFramePool(NewFramePool(MaxFramePayloadSize, true))
+
+		_, err := conn.ReadInterleavedFrame()
+		require.NoError(t, err)
+		conn.ReleaseInterleavedFrame()
+		require.PanicsWithValue(t, "conn: interleaved frame was already released", func() {
+			conn.ReleaseInterleavedFrame()
+		})
+	})
+	t.Run("doublePut", func(t *testing.T) {
+		pool := NewFramePool(4, true)
+		buf := pool.get(4)
+		pool.put(buf)
+		require.PanicsWithValue(t, "conn: frame buffer was released twice", func() {
+			pool.put(buf)
+		})
+	})
+	t.Run("notReleased", func(t *testing.T) {
+		conn := NewConn(bytes.NewBuffer(interleavedFrames(2, 4)))
+		conn.SetFramePool(NewFramePool(MaxFramePayloadSize, true))
+
+		_, err := conn.ReadInterleavedFrame()
+		require.NoError(t, err)
+		require.PanicsWithValue(t, "conn: interleaved frame was not released", func() {
+			conn.ReadInterleavedFrame() //nolint:errcheck
+		})
+	})
+	t.Run("writeAfterRelease", func(t *testing.T) {
+		pool := NewFramePool(4, true)
+		buf := pool.get(4)
+		pool.put(buf)
+		(*buf)[0] = 1
+		require.PanicsWithValue(t, "conn: frame buffer was modified after release", func() {
+			pool.get(4)
+		})
+	})
+	t.Run("disabled", func(t *testing.T) {
+		conn := NewConn(bytes.NewBuffer(interleavedFrames(2, 4)))
+		conn.SetFramePool(NewFramePool(MaxFramePayloadSize, false))
+
+		_, err := conn.ReadInterleavedFrame()
+		require.NoError(t, err)
+		conn.ReleaseInterleavedFrame()
+		conn.ReleaseInterleavedFrame()
+	})
+}
+
+// Connections share the pool.
+func TestFramePoolConcurrent(t *testing.T) {
+	for _, debug := range []bool{false, true} {
+		pool := NewFramePool(MaxFramePayloadSize, debug)
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn := NewConn(bytes.NewBuffer(interleavedFrames(100, 1000)))
+				conn.SetFramePool(pool)
+				for j := 0; j < 100; j++ {
+					fr, err := conn.ReadInterleavedFrame()
+					require.NoError(t, err)
+					require.Equal(t, make([]byte, 1000), fr.Payload)
+					conn.ReleaseInterleavedFrame()
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func benchmarkReadInterleavedFrame(b *testing.B, pool *FramePool) {
+	byts := interleavedFrames(1000, 1400)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(byts)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn := NewConn(bytes.NewBuffer(byts))
+		conn.SetFramePool(pool)
+		for j := 0; j < 1000; j++ {
+			if _, err := conn.ReadInterleavedFrame(); err != nil {
+				b.Fatal(err)
+			}
+			conn.ReleaseInterleavedFrame()
+		}
+	}
+}
+
+func BenchmarkReadInterleavedFrame(b *testing.B) {
+	benchmarkReadInterleavedFrame(b, nil)
+}
+
+func BenchmarkReadInterleavedFramePooled(b *testing.B) {
+	benchmarkReadInterleavedFrame(b, NewFramePool(MaxFramePayloadSize, false))
+}
@@ -0,0 +1,224 @@
+package headers
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// AuthMethod is an authentication method.
+type AuthMethod int
+
+const (
+	// AuthBasic is the Basic authentication method.
+	AuthBasic AuthMethod = iota
+
+	// AuthDigest is the Digest authentication method.
+	AuthDigest
+)
+
+// Digest algorithms.
+const (
+	DigestMD5    = "MD5"
+	DigestSHA256 = "SHA-256"
+)
+
+// Auth errors.
+var (
+	ErrAuthValueMissing         = errors.New("value not provided")
+	ErrAuthMultipleValues       = errors.New("value provided multiple times")
+	ErrAuthMethodUnsupported    = errors.New("unsupported authentication method")
+	ErrAuthParamInvalid         = errors.New("invalid parameter")
+	ErrAuthParamDuplicate       = errors.New("parameter provided multiple times")
+	ErrAuthParamMissing         = errors.New("parameter missing")
+	ErrAuthAlgorithmUnsupported = errors.New("unsupported algorithm")
+	ErrAuthQopUnsupported       = errors.New("unsupported qop")
+	ErrAuthBasicInvalid         = errors.New("invalid basic credentials")
+)
+
+func (m AuthMethod) String() string {
+	if m == AuthBasic {
+		return "Basic"
+	}
+	return "Digest"
+}
+
+// authMethodParse splits the header value into the method and the parameters.
+func authMethodParse(v string) (AuthMethod, string, error) {
+	method, params, _ := strings.Cut(v, " ")
+	params = strings.TrimLeft(params, " \t")
+
+	switch {
+	case strings.EqualFold(method, "Basic"):
+		return AuthBasic, params, nil
+
+	case strings.EqualFold(method, "Digest"):
+		return AuthDigest, params, nil
+	}
+	return 0, "", fmt.Errorf("%w (%v)", ErrAuthMethodUnsupported, method)
+}
+
+// authParamsParse parses the comma separated parameters of an
+// authentication header. Quoted values may contain escaped characters
+// and commas, quoted values must be closed and followed by a comma.
+// Unquoted values are accepted because some cameras emit them.
+// The keys are returned in lower case. The values aren't included
+// in the errors since they may contain credentials.
+func authParamsParse(str string) (map[string]string, error) {
+	ret := make(map[string]string)
+
+	for {
+		str = strings.TrimLeft(str, " \t")
+		if str == "" {
+			return ret, nil
+		}
+
+		// empty list element
+		if str[0] == ',' {
+			str = str[1:]
+			continue
+		}
+
+		i := strings.IndexByte(str, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("%w: key without value", ErrAuthParamInvalid)
+		}
+		key := strings.ToLower(strings.TrimRight(str[:i], " \t"))
+		if !isAuthToken(key) {
+			return nil, fmt.Errorf("%w: invalid key", ErrAuthParamInvalid)
+		}
+		str = strings.TrimLeft(str[i+1:], " \t")
+
+		var val string
+		if str != "" && str[0] == '"' {
+			var err error
+			val, str, err = readAuthQuoted(str)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", err, key)
+			}
+
+			str = strings.TrimLeft(str, " \t")
+			if str != "" && str[0] != ',' {
+				return nil, fmt.Errorf("%w: characters after quoted value: %v",
+					ErrAuthParamInvalid, key)
+			}
+		} else {
+			j := strings.IndexByte(str, ',')
+			if j < 0 {
+				j = len(str)
+			}
+			val = strings.TrimRight(str[:j], " \t")
+			str = str[j:]
+
+			if strings.ContainsRune(val, '"') {
+				return nil, fmt.Errorf("%w: quote in unquoted value: %v",
+					ErrAuthParamInvalid, key)
+			}
+		}
+
+		if hasControlChar(val) {
+			return nil, fmt.Errorf("%w: control character: %v", ErrAuthParamInvalid, key)
+		}
+		if _, exist := ret[key]; exist {
+			return nil, fmt.Errorf("%w: %v", ErrAuthParamDuplicate, key)
+		}
+		ret[key] = val
+	}
+}
+
+// readAuthQuoted reads a quoted string, str must start with a quote.
+func readAuthQuoted(str string) (string, string, error) {
+	var b strings.Builder
+	for i := 1; i < len(str); i++ {
+		switch str[i] {
+		case '"':
+			return b.String(), str[i+1:], nil
+
+		case '\\':
+			i++
+			if i >= len(str) {
+				return "", "", ErrKeyValApexesNotClosed
+			}
+		}
+		b.WriteByte(str[i])
+	}
+	return "", "", ErrKeyValApexesNotClosed
+}
+
+func isAuthToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func hasControlChar(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < ' ' && s[i] != '\t') || s[i] == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// authQuote returns the value as a quoted string.
+func authQuote(v string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(v); i++ {
+		if v[i] == '"' || v[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(v[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// authTokenOrQuote returns the value as is if it's
+// a token, a quoted string is returned otherwise.
+func authTokenOrQuote(v string) string {
+	if isAuthToken(v) {
+		return v
+	}
+	return authQuote(v)
+}
+
+// digestAlgorithm returns the name of the algorithm, MD5 is the default.
+func digestAlgorithm(algorithm *string) string {
+	if algorithm == nil {
+		return DigestMD5
+	}
+	return strings.ToUpper(*algorithm)
+}
+
+// digestHash returns the hash function of the algorithm.
+func digestHash(algorithm *string) (func(string) string, error) {
+	var newHash func() hash.Hash
+	switch digestAlgorithm(algorithm) {
+	case DigestMD5:
+		newHash = md5.New
+
+	case DigestSHA256:
+		newHash = sha256.New
+
+	default:
+		return nil, fmt.Errorf("%w (%v)", ErrAuthAlgorithmUnsupported, *algorithm)
+	}
+
+	return func(s string) string {
+		h := newHash()
+		h.Write([]byte(s))
+		return hex.EncodeToString(h.Sum(nil))
+	}, nil
+}
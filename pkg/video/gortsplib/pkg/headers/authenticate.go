@@ -0,0 +1,141 @@
+package headers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"nvr/pkg/video/gortsplib/pkg/base"
+	"strings"
+)
+
+// Authenticate is a WWW-Authenticate header.
+type Authenticate struct {
+	// authentication method
+	Method AuthMethod
+
+	// realm
+	Realm string
+
+	// (digest) nonce
+	Nonce string
+
+	// (optional, digest) opaque
+	Opaque *string
+
+	// (optional, digest) the nonce is stale and the credentials are valid
+	Stale bool
+
+	// (optional, digest) algorithm, MD5 if nil
+	Algorithm *string
+
+	// (optional, digest) quality of protection
+	Qop *string
+}
+
+// NewAuthenticate returns a challenge of the authentication
+// method, a random nonce is generated for Digest challenges.
+func NewAuthenticate(method AuthMethod, realm string) (Authenticate, error) {
+	h := Authenticate{
+		Method: method,
+		Realm:  realm,
+	}
+	if method != AuthDigest {
+		return h, nil
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return Authenticate{}, fmt.Errorf("generate nonce: %w", err)
+	}
+	h.Nonce = hex.EncodeToString(nonce)
+	return h, nil
+}
+
+// Unmarshal decodes a WWW-Authenticate header.
+func (h *Authenticate) Unmarshal(v base.HeaderValue) error {
+	if len(v) == 0 {
+		return ErrAuthValueMissing
+	}
+
+	if len(v) > 1 {
+		return fmt.Errorf("%w (%v)", ErrAuthMultipleValues, v)
+	}
+
+	method, params, err := authMethodParse(v[0])
+	if err != nil {
+		return err
+	}
+	h.Method = method
+
+	kvs, err := authParamsParse(params)
+	if err != nil {
+		return err
+	}
+
+	realm, exist := kvs["realm"]
+	if !exist {
+		return fmt.Errorf("%w: realm", ErrAuthParamMissing)
+	}
+	h.Realm = realm
+
+	if method == AuthBasic {
+		return nil
+	}
+
+	nonce, exist := kvs["nonce"]
+	if !exist {
+		return fmt.Errorf("%w: nonce", ErrAuthParamMissing)
+	}
+	h.Nonce = nonce
+
+	for k, v := range kvs {
+		v := v
+		switch k {
+		case "opaque":
+			h.Opaque = &v
+
+		case "stale":
+			h.Stale = strings.EqualFold(v, "true")
+
+		case "algorithm":
+			h.Algorithm = &v
+
+		case "qop":
+			h.Qop = &v
+
+		default:
+			// ignore non-standard keys
+		}
+	}
+
+	return nil
+}
+
+// Marshal encodes a WWW-Authenticate header.
+func (h Authenticate) Marshal() base.HeaderValue {
+	ret := h.Method.String() + " realm=" + authQuote(h.Realm)
+
+	if h.Method == AuthBasic {
+		return base.HeaderValue{ret}
+	}
+
+	ret += ", nonce=" + authQuote(h.Nonce)
+
+	if h.Opaque != nil {
+		ret += ", opaque=" + authQuote(*h.Opaque)
+	}
+
+	if h.Stale {
+		ret += ", stale=TRUE"
+	}
+
+	if h.Algorithm != nil {
+		ret += ", algorithm=" + authTokenOrQuote(*h.Algorithm)
+	}
+
+	if h.Qop != nil {
+		ret += ", qop=" + authQuote(*h.Qop)
+	}
+
+	return base.HeaderValue{ret}
+}
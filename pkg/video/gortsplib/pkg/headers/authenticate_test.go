@@ -0,0 +1,153 @@
+package headers
+
+import (
+	"testing"
+
+	"nvr/pkg/video/gortsplib/pkg/base"
+
+	"github.com/stretchr/testify/require"
+)
+
+var casesAuthenticate = []struct {
+	name string
+	vin  base.HeaderValue
+	vout base.HeaderValue
+	h    Authenticate
+}{
+	{
+		"basic",
+		base.HeaderValue{`Basic realm="4419b63f5e51"`},
+		base.HeaderValue{`Basic realm="4419b63f5e51"`},
+		Authenticate{
+			Method: AuthBasic,
+			Realm:  "4419b63f5e51",
+		},
+	},
+	{
+		"live555",
+		base.HeaderValue{`Digest realm="LIVE555 Streaming Media", nonce="c8d0e6b35ff3a4e2d3d8f6bb2c3c1e6a"`},
+		base.HeaderValue{`Digest realm="LIVE555 Streaming Media", nonce="c8d0e6b35ff3a4e2d3d8f6bb2c3c1e6a"`},
+		Authenticate{
+			Method: AuthDigest,
+			Realm:  "LIVE555 Streaming Media",
+			Nonce:  "c8d0e6b35ff3a4e2d3d8f6bb2c3c1e6a",
+		},
+	},
+	{
+		"onvif camera",
+		base.HeaderValue{`Digest realm="IP Camera(C6083)", nonce="4d3c7b6a9e2f1c0b8a7d6e5f4c3b2a19", stale="FALSE"`},
+		base.HeaderValue{`Digest realm="IP Camera(C6083)", nonce="4d3c7b6a9e2f1c0b8a7d6e5f4c3b2a19"`},
+		Authenticate{
+			Method: AuthDigest,
+			Realm:  "IP Camera(C6083)",
+			Nonce:  "4d3c7b6a9e2f1c0b8a7d6e5f4c3b2a19",
+		},
+	},
+	{
+		"unquoted values",
+		base.HeaderValue{`Digest realm=IPCAM,nonce=6e2c4a, algorithm=SHA-256,qop=auth, stale=TRUE`},
+		base.HeaderValue{`Digest realm="IPCAM", nonce="6e2c4a", stale=TRUE, algorithm=SHA-256, qop="auth"`},
+		Authenticate{
+			Method:    AuthDigest,
+			Realm:     "IPCAM",
+			Nonce:     "6e2c4a",
+			Stale:     true,
+			Algorithm: strPtr("SHA-256"),
+			Qop:       strPtr("auth"),
+		},
+	},
+	{
+		"opaque and escaped quote",
+		base.HeaderValue{`digest REALM="a \"b\", c", nonce="n", opaque="5ccc069c403ebaf9f0171e9517f40e41"`},
+		base.HeaderValue{`Digest realm="a \"b\", c", nonce="n", opaque="5ccc069c403ebaf9f0171e9517f40e41"`},
+		Authenticate{
+			Method: AuthDigest,
+			Realm:  `a "b", c`,
+			Nonce:  "n",
+			Opaque: strPtr("5ccc069c403ebaf9f0171e9517f40e41"),
+		},
+	},
+}
+
+func TestAuthenticateUnmarshal(t *testing.T) {
+	for _, ca := range casesAuthenticate {
+		t.Run(ca.name, func(t *testing.T) {
+			var h Authenticate
+			err := h.Unmarshal(ca.vin)
+			require.NoError(t, err)
+			require.Equal(t, ca.h, h)
+		})
+	}
+}
+
+func TestAuthenticateUnmarshalErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		hv   base.HeaderValue
+		err  string
+	}{
+		{
+			"empty",
+			base.HeaderValue{},
+			"value not provided",
+		},
+		{
+			"2 values",
+			base.HeaderValue{"a", "b"},
+			"value provided multiple times ([a b])",
+		},
+		{
+			"unsupported method",
+			base.HeaderValue{`Bearer realm="a"`},
+			"unsupported authentication method (Bearer)",
+		},
+		{
+			"missing realm",
+			base.HeaderValue{`Digest nonce="a"`},
+			"parameter missing: realm",
+		},
+		{
+			"missing nonce",
+			base.HeaderValue{`Digest realm="a"`},
+			"parameter missing: nonce",
+		},
+		{
+			"apexes not closed",
+			base.HeaderValue{`Digest realm="a, nonce="b`},
+			"invalid parameter: characters after quoted value: realm",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var h Authenticate
+			err := h.Unmarshal(ca.hv)
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func TestAuthenticateMarshal(t *testing.T) {
+	for _, ca := range casesAuthenticate {
+		t.Run(ca.name, func(t *testing.T) {
+			req := ca.h.Marshal()
+			require.Equal(t, ca.vout, req)
+		})
+	}
+}
+
+func TestNewAuthenticate(t *testing.T) {
+	h, err := NewAuthenticate(AuthBasic, "nvr")
+	require.NoError(t, err)
+	require.Equal(t, Authenticate{Method: AuthBasic, Realm: "nvr"}, h)
+
+	h1, err := NewAuthenticate(AuthDigest, "nvr")
+	require.NoError(t, err)
+	require.Len(t, h1.Nonce, 32)
+
+	h2, err := NewAuthenticate(AuthDigest, "nvr")
+	require.NoError(t, err)
+	require.NotEqual(t, h1.Nonce, h2.Nonce)
+
+	var dec Authenticate
+	require.NoError(t, dec.Unmarshal(h1.Marshal()))
+	require.Equal(t, h1, dec)
+}
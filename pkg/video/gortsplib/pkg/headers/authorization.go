@@ -0,0 +1,266 @@
+package headers
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"nvr/pkg/video/gortsplib/pkg/base"
+	"strings"
+)
+
+// Authorization is an Authorization header.
+type Authorization struct {
+	// authentication method
+	Method AuthMethod
+
+	// (basic) user
+	BasicUser string
+
+	// (basic) password
+	BasicPass string
+
+	// (digest) user
+	Username string
+
+	// (digest) realm of the challenge
+	Realm string
+
+	// (digest) nonce of the challenge
+	Nonce string
+
+	// (digest) request URI
+	URI string
+
+	// (digest) response
+	Response string
+
+	// (optional, digest) opaque of the challenge
+	Opaque *string
+
+	// (optional, digest) algorithm, MD5 if nil
+	Algorithm *string
+
+	// (optional, digest) quality of protection
+	Qop *string
+
+	// (optional, digest) nonce count, required if qop is set
+	NC *string
+
+	// (optional, digest) client nonce, required if qop is set
+	CNonce *string
+}
+
+// Unmarshal decodes an Authorization header.
+func (h *Authorization) Unmarshal(v base.HeaderValue) error {
+	if len(v) == 0 {
+		return ErrAuthValueMissing
+	}
+
+	if len(v) > 1 {
+		return fmt.Errorf("%w (%d values)", ErrAuthMultipleValues, len(v))
+	}
+
+	method, params, err := authMethodParse(v[0])
+	if err != nil {
+		return err
+	}
+	h.Method = method
+
+	if method == AuthBasic {
+		return h.unmarshalBasic(params)
+	}
+
+	kvs, err := authParamsParse(params)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range []string{"username", "realm", "nonce", "uri", "response"} {
+		if _, exist := kvs[k]; !exist {
+			return fmt.Errorf("%w: %v", ErrAuthParamMissing, k)
+		}
+	}
+	h.Username = kvs["username"]
+	h.Realm = kvs["realm"]
+	h.Nonce = kvs["nonce"]
+	h.URI = kvs["uri"]
+	h.Response = kvs["response"]
+
+	for k, v := range kvs {
+		v := v
+		switch k {
+		case "opaque":
+			h.Opaque = &v
+
+		case "algorithm":
+			h.Algorithm = &v
+
+		case "qop":
+			h.Qop = &v
+
+		case "nc":
+			h.NC = &v
+
+		case "cnonce":
+			h.CNonce = &v
+
+		default:
+			// ignore non-standard keys
+		}
+	}
+
+	if h.Qop != nil {
+		if h.NC == nil {
+			return fmt.Errorf("%w: nc", ErrAuthParamMissing)
+		}
+		if h.CNonce == nil {
+			return fmt.Errorf("%w: cnonce", ErrAuthParamMissing)
+		}
+	}
+
+	return nil
+}
+
+func (h *Authorization) unmarshalBasic(params string) error {
+	params = strings.TrimRight(params, " \t")
+
+	// Some clients omit the padding.
+	raw, err := base64.StdEncoding.DecodeString(params)
+	if err != nil {
+		raw, err = base64.RawStdEncoding.DecodeString(params)
+		if err != nil {
+			return fmt.Errorf("%w: invalid base64", ErrAuthBasicInvalid)
+		}
+	}
+
+	user, pass, found := strings.Cut(string(raw), ":")
+	if !found {
+		return fmt.Errorf("%w: missing separator", ErrAuthBasicInvalid)
+	}
+	h.BasicUser = user
+	h.BasicPass = pass
+	return nil
+}
+
+// Marshal encodes an Authorization header.
+func (h Authorization) Marshal() base.HeaderValue {
+	if h.Method == AuthBasic {
+		return base.HeaderValue{"Basic " +
+			base64.StdEncoding.EncodeToString([]byte(h.BasicUser+":"+h.BasicPass))}
+	}
+
+	ret := "Digest username=" + authQuote(h.Username) +
+		", realm=" + authQuote(h.Realm) +
+		", nonce=" + authQuote(h.Nonce) +
+		", uri=" + authQuote(h.URI) +
+		", response=" + authQuote(h.Response)
+
+	if h.Opaque != nil {
+		ret += ", opaque=" + authQuote(*h.Opaque)
+	}
+
+	if h.Algorithm != nil {
+		ret += ", algorithm=" + authTokenOrQuote(*h.Algorithm)
+	}
+
+	if h.Qop != nil {
+		ret += ", qop=" + authTokenOrQuote(*h.Qop)
+	}
+
+	if h.NC != nil {
+		ret += ", nc=" + authTokenOrQuote(*h.NC)
+	}
+
+	if h.CNonce != nil {
+		ret += ", cnonce=" + authQuote(*h.CNonce)
+	}
+
+	return base.HeaderValue{ret}
+}
+
+// DigestResponse computes the response of a Digest authorization
+// from the fields of the header and the password, see RFC 7616.
+// Only the "auth" quality of protection is supported.
+func (h Authorization) DigestResponse(method base.Method, pass string) (string, error) {
+	hash, err := digestHash(h.Algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	ha1 := hash(h.Username + ":" + h.Realm + ":" + pass)
+	ha2 := hash(string(method) + ":" + h.URI)
+
+	if h.Qop == nil {
+		return hash(ha1 + ":" + h.Nonce + ":" + ha2), nil
+	}
+
+	if *h.Qop != "auth" {
+		return "", fmt.Errorf("%w (%v)", ErrAuthQopUnsupported, *h.Qop)
+	}
+
+	if h.NC == nil || h.CNonce == nil {
+		return "", fmt.Errorf("%w: nc or cnonce", ErrAuthParamMissing)
+	}
+
+	return hash(ha1 + ":" + h.Nonce + ":" + *h.NC + ":" + *h.CNonce + ":" +
+		*h.Qop + ":" + ha2), nil
+}
+
+// Verify errors.
+var (
+	ErrAuthWrongMethod        = errors.New("wrong authentication method")
+	ErrAuthChallengeMismatch  = errors.New("authorization doesn't match the challenge")
+	ErrAuthInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Verify checks the authorization against the challenge that was sent
+// to the client and the expected credentials. The caller is responsible
+// for checking the URI and the age of the nonce.
+func (h Authorization) Verify(
+	challenge Authenticate,
+	method base.Method,
+	user string,
+	pass string,
+) error {
+	if h.Method != challenge.Method {
+		return fmt.Errorf("%w: expected %v, got %v",
+			ErrAuthWrongMethod, challenge.Method, h.Method)
+	}
+
+	if h.Method == AuthBasic {
+		userOK := subtle.ConstantTimeCompare([]byte(h.BasicUser), []byte(user))
+		passOK := subtle.ConstantTimeCompare([]byte(h.BasicPass), []byte(pass))
+		if userOK&passOK != 1 {
+			return ErrAuthInvalidCredentials
+		}
+		return nil
+	}
+
+	switch {
+	case h.Realm != challenge.Realm:
+		return fmt.Errorf("%w: realm", ErrAuthChallengeMismatch)
+
+	case h.Nonce != challenge.Nonce:
+		return fmt.Errorf("%w: nonce", ErrAuthChallengeMismatch)
+
+	case challenge.Opaque != nil && (h.Opaque == nil || *h.Opaque != *challenge.Opaque):
+		return fmt.Errorf("%w: opaque", ErrAuthChallengeMismatch)
+
+	case digestAlgorithm(h.Algorithm) != digestAlgorithm(challenge.Algorithm):
+		return fmt.Errorf("%w: algorithm", ErrAuthChallengeMismatch)
+	}
+
+	expected, err := h.DigestResponse(method, pass)
+	if err != nil {
+		return err
+	}
+
+	userOK := subtle.ConstantTimeCompare([]byte(h.Username), []byte(user))
+	responseOK := subtle.ConstantTimeCompare(
+		[]byte(strings.ToLower(h.Response)), []byte(expected))
+	if userOK&responseOK != 1 {
+		return ErrAuthInvalidCredentials
+	}
+	return nil
+}
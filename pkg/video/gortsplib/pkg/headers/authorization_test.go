@@ -0,0 +1,474 @@
+package headers
+
+import (
+	"testing"
+
+	"nvr/pkg/video/gortsplib/pkg/base"
+
+	"github.com/stretchr/testify/require"
+)
+
+func strPtr(v string) *string {
+	return &v
+}
+
+var casesAuthorization = []struct {
+	name string
+	vin  base.HeaderValue
+	vout base.HeaderValue
+	h    Authorization
+}{
+	{
+		"basic",
+		base.HeaderValue{`Basic YWRtaW46MTI6MzQ1`},
+		base.HeaderValue{`Basic YWRtaW46MTI6MzQ1`},
+		Authorization{
+			Method:    AuthBasic,
+			BasicUser: "admin",
+			BasicPass: "12:345",
+		},
+	},
+	{
+		"basic without padding",
+		base.HeaderValue{`Basic dXNlcjpwYXNz `},
+		base.HeaderValue{`Basic dXNlcjpwYXNz`},
+		Authorization{
+			Method:    AuthBasic,
+			BasicUser: "user",
+			BasicPass: "pass",
+		},
+	},
+	{
+		"ffmpeg",
+		base.HeaderValue{`Digest username="admin", realm="IP Camera(C6083)", ` +
+			`nonce="4d3c7b6a9e2f1c0b8a7d6e5f4c3b2a19", ` +
+			`uri="rtsp://192.168.1.64:554/Streaming/Channels/101", ` +
+			`response="97667f74f19118be1f5e90d45ca450cd"`},
+		base.HeaderValue{`Digest username="admin", realm="IP Camera(C6083)", ` +
+			`nonce="4d3c7b6a9e2f1c0b8a7d6e5f4c3b2a19", ` +
+			`uri="rtsp://192.168.1.64:554/Streaming/Channels/101", ` +
+			`response="97667f74f19118be1f5e90d45ca450cd"`},
+		Authorization{
+			Method:   AuthDigest,
+			Username: "admin",
+			Realm:    "IP Camera(C6083)",
+			Nonce:    "4d3c7b6a9e2f1c0b8a7d6e5f4c3b2a19",
+			URI:      "rtsp://192.168.1.64:554/Streaming/Channels/101",
+			Response: "97667f74f19118be1f5e90d45ca450cd",
+		},
+	},
+	{
+		"ffmpeg qop",
+		base.HeaderValue{`Digest username="admin", realm="Login to 4K05A6CPAZ", ` +
+			`nonce="1643898012", ` +
+			`uri="rtsp://192.168.1.108:554/cam/realmonitor?channel=1&subtype=0", ` +
+			`response="ab8dde085a2482984aed05ab3750481f", algorithm="MD5", ` +
+			`qop="auth", cnonce="a8f3c2e1", nc=00000001`},
+		base.HeaderValue{`Digest username="admin", realm="Login to 4K05A6CPAZ", ` +
+			`nonce="1643898012", ` +
+			`uri="rtsp://192.168.1.108:554/cam/realmonitor?channel=1&subtype=0", ` +
+			`response="ab8dde085a2482984aed05ab3750481f", algorithm=MD5, ` +
+			`qop=auth, nc=00000001, cnonce="a8f3c2e1"`},
+		Authorization{
+			Method:    AuthDigest,
+			Username:  "admin",
+			Realm:     "Login to 4K05A6CPAZ",
+			Nonce:     "1643898012",
+			URI:       "rtsp://192.168.1.108:554/cam/realmonitor?channel=1&subtype=0",
+			Response:  "ab8dde085a2482984aed05ab3750481f",
+			Algorithm: strPtr("MD5"),
+			Qop:       strPtr("auth"),
+			NC:        strPtr("00000001"),
+			CNonce:    strPtr("a8f3c2e1"),
+		},
+	},
+	{
+		"vlc",
+		base.HeaderValue{`Digest username="user", realm="LIVE555 Streaming Media", ` +
+			`nonce="c8d0e6b35ff3a4e2d3d8f6bb2c3c1e6a", uri="rtsp://10.0.0.5:8554/stream/", ` +
+			`response="50e7f4f10fdf3ba258a7bbc10b52759f"`},
+		base.HeaderValue{`Digest username="user", realm="LIVE555 Streaming Media", ` +
+			`nonce="c8d0e6b35ff3a4e2d3d8f6bb2c3c1e6a", uri="rtsp://10.0.0.5:8554/stream/", ` +
+			`response="50e7f4f10fdf3ba258a7bbc10b52759f"`},
+		Authorization{
+			Method:   AuthDigest,
+			Username: "user",
+			Realm:    "LIVE555 Streaming Media",
+			Nonce:    "c8d0e6b35ff3a4e2d3d8f6bb2c3c1e6a",
+			URI:      "rtsp://10.0.0.5:8554/stream/",
+			Response: "50e7f4f10fdf3ba258a7bbc10b52759f",
+		},
+	},
+	{
+		"onvif camera unquoted",
+		base.HeaderValue{`Digest username=admin,realm=IPCAM,nonce=6e2c4a,` +
+			`uri=rtsp://192.168.1.20/trackID=1,` +
+			`response=cfe01e22e889018bbc13ae098279b19d1427231ce4b05cce32d626a1e255e874,` +
+			`algorithm=SHA-256,qop=auth,nc=00000002,cnonce=1f0e,opaque=`},
+		base.HeaderValue{`Digest username="admin", realm="IPCAM", nonce="6e2c4a", ` +
+			`uri="rtsp://192.168.1.20/trackID=1", ` +
+			`response="cfe01e22e889018bbc13ae098279b19d1427231ce4b05cce32d626a1e255e874", ` +
+			`opaque="", algorithm=SHA-256, qop=auth, nc=00000002, cnonce="1f0e"`},
+		Authorization{
+			Method:    AuthDigest,
+			Username:  "admin",
+			Realm:     "IPCAM",
+			Nonce:     "6e2c4a",
+			URI:       "rtsp://192.168.1.20/trackID=1",
+			Response:  "cfe01e22e889018bbc13ae098279b19d1427231ce4b05cce32d626a1e255e874",
+			Opaque:    strPtr(""),
+			Algorithm: strPtr("SHA-256"),
+			Qop:       strPtr("auth"),
+			NC:        strPtr("00000002"),
+			CNonce:    strPtr("1f0e"),
+		},
+	},
+}
+
+func TestAuthorizationUnmarshal(t *testing.T) {
+	for _, ca := range casesAuthorization {
+		t.Run(ca.name, func(t *testing.T) {
+			var h Authorization
+			err := h.Unmarshal(ca.vin)
+			require.NoError(t, err)
+			require.Equal(t, ca.h, h)
+		})
+	}
+}
+
+func TestAuthorizationUnmarshalErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		hv   base.HeaderValue
+		err  string
+	}{
+		{
+			"empty",
+			base.HeaderValue{},
+			"value not provided",
+		},
+		{
+			"2 values",
+			base.HeaderValue{"a", "b"},
+			"value provided multiple times (2 values)",
+		},
+		{
+			"unsupported method",
+			base.HeaderValue{`Bearer abc`},
+			"unsupported authentication method (Bearer)",
+		},
+		{
+			"basic invalid base64",
+			base.HeaderValue{`Basic !!!`},
+			"invalid basic credentials: invalid base64",
+		},
+		{
+			"basic missing separator",
+			base.HeaderValue{`Basic YWRtaW4=`},
+			"invalid basic credentials: missing separator",
+		},
+		{
+			"missing response",
+			base.HeaderValue{`Digest username="a", realm="b", nonce="c", uri="d"`},
+			"parameter missing: response",
+		},
+		{
+			"qop without nc",
+			base.HeaderValue{`Digest username="a", realm="b", nonce="c", uri="d", ` +
+				`response="e", qop=auth, cnonce="f"`},
+			"parameter missing: nc",
+		},
+		{
+			"apexes not closed",
+			base.HeaderValue{`Digest username="a`},
+			"apexes not closed: username",
+		},
+		{
+			"escape at end",
+			base.HeaderValue{`Digest username="a\`},
+			"apexes not closed: username",
+		},
+		{
+			"characters after quoted value",
+			base.HeaderValue{`Digest username="a"b, realm="b"`},
+			"invalid parameter: characters after quoted value: username",
+		},
+		{
+			"quote in unquoted value",
+			base.HeaderValue{`Digest username=a"b"`},
+			"invalid parameter: quote in unquoted value: username",
+		},
+		{
+			"key without value",
+			base.HeaderValue{`Digest username`},
+			"invalid parameter: key without value",
+		},
+		{
+			"invalid key",
+			base.HeaderValue{`Digest user name="a"`},
+			"invalid parameter: invalid key",
+		},
+		{
+			"duplicate key",
+			base.HeaderValue{`Digest username="a", USERNAME="b"`},
+			"parameter provided multiple times: username",
+		},
+		{
+			"control character",
+			base.HeaderValue{"Digest username=\"a\r\nb\""},
+			"invalid parameter: control character: username",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var h Authorization
+			err := h.Unmarshal(ca.hv)
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func TestAuthorizationMarshal(t *testing.T) {
+	for _, ca := range casesAuthorization {
+		t.Run(ca.name, func(t *testing.T) {
+			req := ca.h.Marshal()
+			require.Equal(t, ca.vout, req)
+		})
+	}
+}
+
+func TestAuthorizationDigestResponse(t *testing.T) {
+	for _, ca := range []struct {
+		name     string
+		method   base.Method
+		pass     string
+		h        Authorization
+		response string
+	}{
+		{
+			"rfc2617",
+			"GET",
+			"Circle Of Life",
+			Authorization{
+				Username: "Mufasa",
+				Realm:    "testrealm@host.com",
+				Nonce:    "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+				URI:      "/dir/index.html",
+				Qop:      strPtr("auth"),
+				NC:       strPtr("00000001"),
+				CNonce:   strPtr("0a4f113b"),
+			},
+			"6629fae49393a05397450978507c4ef1",
+		},
+		{
+			"rfc7616 sha-256",
+			"GET",
+			"Circle of Life",
+			Authorization{
+				Username:  "Mufasa",
+				Realm:     "http-auth@example.org",
+				Nonce:     "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v",
+				URI:       "/dir/index.html",
+				Algorithm: strPtr("SHA-256"),
+				Qop:       strPtr("auth"),
+				NC:        strPtr("00000001"),
+				CNonce:    strPtr("f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"),
+			},
+			"753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1",
+		},
+		{
+			"ffmpeg",
+			base.Describe,
+			"12345",
+			casesAuthorization[2].h,
+			casesAuthorization[2].h.Response,
+		},
+		{
+			"lower case algorithm",
+			base.Describe,
+			"admin123",
+			func() Authorization {
+				h := casesAuthorization[3].h
+				h.Algorithm = strPtr("md5")
+				return h
+			}(),
+			casesAuthorization[3].h.Response,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			response, err := ca.h.DigestResponse(ca.method, ca.pass)
+			require.NoError(t, err)
+			require.Equal(t, ca.response, response)
+		})
+	}
+
+	t.Run("unsupported algorithm", func(t *testing.T) {
+		h := Authorization{Algorithm: strPtr("SHA-512-256")}
+		_, err := h.DigestResponse(base.Describe, "")
+		require.ErrorIs(t, err, ErrAuthAlgorithmUnsupported)
+	})
+	t.Run("unsupported qop", func(t *testing.T) {
+		h := Authorization{Qop: strPtr("auth-int")}
+		_, err := h.DigestResponse(base.Describe, "")
+		require.ErrorIs(t, err, ErrAuthQopUnsupported)
+	})
+}
+
+func TestAuthorizationVerify(t *testing.T) {
+	basicChallenge := Authenticate{Method: AuthBasic, Realm: "nvr"}
+	digestChallenge := Authenticate{
+		Method:    AuthDigest,
+		Realm:     "IPCAM",
+		Nonce:     "6e2c4a",
+		Algorithm: strPtr("SHA-256"),
+	}
+	digest := casesAuthorization[5].h
+	digest.Opaque = nil
+
+	t.Run("basic", func(t *testing.T) {
+		h := casesAuthorization[0].h
+		require.NoError(t, h.Verify(basicChallenge, base.Describe, "admin", "12:345"))
+		require.ErrorIs(t,
+			h.Verify(basicChallenge, base.Describe, "admin", "12345"),
+			ErrAuthInvalidCredentials)
+	})
+	t.Run("digest", func(t *testing.T) {
+		require.NoError(t, digest.Verify(digestChallenge, base.Setup, "admin", "secret"))
+
+		upper := digest
+		upper.Response = "CFE01E22E889018BBC13AE098279B19D1427231CE4B05CCE32D626A1E255E874"
+		require.NoError(t, upper.Verify(digestChallenge, base.Setup, "admin", "secret"))
+	})
+	for _, ca := range []struct {
+		name      string
+		h         Authorization
+		challenge Authenticate
+		method    base.Method
+		user      string
+		pass      string
+		err       error
+	}{
+		{
+			"wrong method",
+			digest,
+			basicChallenge,
+			base.Setup, "admin", "secret",
+			ErrAuthWrongMethod,
+		},
+		{
+			"wrong password",
+			digest,
+			digestChallenge,
+			base.Setup, "admin", "secret2",
+			ErrAuthInvalidCredentials,
+		},
+		{
+			"wrong user",
+			digest,
+			digestChallenge,
+			base.Setup, "admin2", "secret",
+			ErrAuthInvalidCredentials,
+		},
+		{
+			"wrong request method",
+			digest,
+			digestChallenge,
+			base.Play, "admin", "secret",
+			ErrAuthInvalidCredentials,
+		},
+		{
+			"wrong nonce",
+			digest,
+			func() Authenticate {
+				c := digestChallenge
+				c.Nonce = "x"
+				return c
+			}(),
+			base.Setup, "admin", "secret",
+			ErrAuthChallengeMismatch,
+		},
+		{
+			"missing opaque",
+			digest,
+			func() Authenticate {
+				c := digestChallenge
+				c.Opaque = strPtr("x")
+				return c
+			}(),
+			base.Setup, "admin", "secret",
+			ErrAuthChallengeMismatch,
+		},
+		{
+			"algorithm downgrade",
+			digest,
+			func() Authenticate {
+				c := digestChallenge
+				c.Algorithm = nil
+				return c
+			}(),
+			base.Setup, "admin", "secret",
+			ErrAuthChallengeMismatch,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			err := ca.h.Verify(ca.challenge, ca.method, ca.user, ca.pass)
+			require.ErrorIs(t, err, ca.err)
+		})
+	}
+}
+
+func FuzzAuthorizationUnmarshal(f *testing.F) {
+	for _, ca := range casesAuthorization {
+		f.Add(ca.vin[0])
+	}
+	for _, ca := range casesAuthenticate {
+		f.Add(ca.vin[0])
+	}
+
+	// Malformed headers.
+	for _, v := range []string{
+		``,
+		` `,
+		`Digest`,
+		`Digest `,
+		`Digest ,`,
+		`Digest =`,
+		`Digest ="a"`,
+		`Digest a=`,
+		`Digest a="`,
+		`Digest a="\`,
+		`Digest a="\"`,
+		`Digest a=""""`,
+		`Digest a="b"c="d"`,
+		`Digest a=b=c`,
+		`Digest a=,,,b=`,
+		`Digest a="b",a="b"`,
+		"Digest a=\"\x00\"",
+		"Digest a=\x7f",
+		"Digest\ta=b",
+		`Basic`,
+		`Basic :`,
+		`Basic Og==`,
+		`Basic ====`,
+		`Basic YQ`,
+		`basic YTpi`,
+		"Basic YTpi\x00",
+	} {
+		f.Add(v)
+	}
+
+	f.Fuzz(func(t *testing.T, v string) {
+		var h Authorization
+		if h.Unmarshal(base.HeaderValue{v}) == nil {
+			var h2 Authorization
+			require.NoError(t, h2.Unmarshal(h.Marshal()))
+			require.Equal(t, h, h2)
+		}
+
+		var a Authenticate
+		if a.Unmarshal(base.HeaderValue{v}) == nil {
+			var a2 Authenticate
+			require.NoError(t, a2.Unmarshal(a.Marshal()))
+			require.Equal(t, a, a2)
+		}
+	})
+}
@@ -0,0 +1,304 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"nvr/pkg/video/gortsplib/pkg/base"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Range errors.
+var (
+	ErrRangeValueMissing   = errors.New("value not provided")
+	ErrRangeMultipleValues = errors.New("value provided multiple times")
+	ErrRangeInvalid        = errors.New("invalid range")
+	ErrRangeUnsupported    = errors.New("unsupported range unit")
+	ErrRangeEndBeforeStart = errors.New("end is before start")
+)
+
+// RangeValue can be
+// - RangeNPT
+// - RangeUTC.
+type RangeValue interface {
+	unmarshal(string) error
+	marshal() string
+}
+
+// RangeNPTTime is a normal play time. Now is true for "now",
+// the live position, Duration is the offset otherwise.
+type RangeNPTTime struct {
+	Now      bool
+	Duration time.Duration
+}
+
+// RangeNPTNow returns the live position.
+func RangeNPTNow() RangeNPTTime {
+	return RangeNPTTime{Now: true}
+}
+
+func (t *RangeNPTTime) unmarshal(s string) error {
+	if s == "now" {
+		*t = RangeNPTTime{Now: true}
+		return nil
+	}
+
+	// npt-hhmmss = npt-hh ":" npt-mm ":" npt-ss [ "." *DIGIT ]
+	if strings.Contains(s, ":") {
+		parts := strings.Split(s, ":")
+		if len(parts) != 3 {
+			return fmt.Errorf("%w: %q", ErrRangeInvalid, s)
+		}
+		hours, err := parseDigits(parts[0])
+		if err != nil {
+			return err
+		}
+		minutes, err := parseDigits(parts[1])
+		if err != nil {
+			return err
+		}
+		if len(parts[1]) != 2 || minutes > 59 {
+			return fmt.Errorf("%w: minutes: %q", ErrRangeInvalid, s)
+		}
+		seconds, err := parseSeconds(parts[2])
+		if err != nil {
+			return err
+		}
+		secondsInt, _, _ := strings.Cut(parts[2], ".")
+		if len(secondsInt) != 2 || seconds >= time.Minute {
+			return fmt.Errorf("%w: seconds: %q", ErrRangeInvalid, s)
+		}
+
+		if hours > uint64(math.MaxInt64/time.Hour) {
+			return fmt.Errorf("%w: hours: %q", ErrRangeInvalid, s)
+		}
+		d := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+		if d > math.MaxInt64-seconds {
+			return fmt.Errorf("%w: %q", ErrRangeInvalid, s)
+		}
+		*t = RangeNPTTime{Duration: d + seconds}
+		return nil
+	}
+
+	// npt-sec = 1*DIGIT [ "." *DIGIT ]
+	seconds, err := parseSeconds(s)
+	if err != nil {
+		return err
+	}
+	*t = RangeNPTTime{Duration: seconds}
+	return nil
+}
+
+func (t RangeNPTTime) marshal() string {
+	if t.Now {
+		return "now"
+	}
+	return strconv.FormatFloat(t.Duration.Seconds(), 'f', -1, 64)
+}
+
+// parseDigits parses a non-empty unsigned decimal number.
+func parseDigits(s string) (uint64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("%w: empty number", ErrRangeInvalid)
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("%w: %q", ErrRangeInvalid, s)
+		}
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrRangeInvalid, s)
+	}
+	return v, nil
+}
+
+// parseSeconds parses "1*DIGIT [ "." *DIGIT ]" without float rounding,
+// digits beyond nanosecond precision are truncated.
+func parseSeconds(s string) (time.Duration, error) {
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	seconds, err := parseDigits(intPart)
+	if err != nil {
+		return 0, err
+	}
+	if seconds > uint64(math.MaxInt64/time.Second) {
+		return 0, fmt.Errorf("%w: %q", ErrRangeInvalid, s)
+	}
+
+	var nanos uint64
+	if fracPart != "" {
+		if len(fracPart) > 9 {
+			fracPart = fracPart[:9]
+		}
+		nanos, err = parseDigits(fracPart)
+		if err != nil {
+			return 0, err
+		}
+		for i := len(fracPart); i < 9; i++ {
+			nanos *= 10
+		}
+	}
+
+	d := time.Duration(seconds) * time.Second
+	if d > math.MaxInt64-time.Duration(nanos) {
+		return 0, fmt.Errorf("%w: %q", ErrRangeInvalid, s)
+	}
+	return d + time.Duration(nanos), nil
+}
+
+// RangeNPT is a range expressed in normal play time.
+type RangeNPT struct {
+	Start RangeNPTTime
+
+	// (optional) end, the range is open-ended if nil.
+	End *RangeNPTTime
+}
+
+func (r *RangeNPT) unmarshal(s string) error {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok || start == "" {
+		return fmt.Errorf("%w: %q", ErrRangeInvalid, s)
+	}
+
+	if err := r.Start.unmarshal(start); err != nil {
+		return err
+	}
+
+	r.End = nil
+	if end != "" {
+		var t RangeNPTTime
+		if err := t.unmarshal(end); err != nil {
+			return err
+		}
+		if !r.Start.Now && !t.Now && t.Duration < r.Start.Duration {
+			return fmt.Errorf("%w: %q", ErrRangeEndBeforeStart, s)
+		}
+		r.End = &t
+	}
+	return nil
+}
+
+func (r RangeNPT) marshal() string {
+	ret := "npt=" + r.Start.marshal() + "-"
+	if r.End != nil {
+		ret += r.End.marshal()
+	}
+	return ret
+}
+
+const (
+	rangeUTCLayout         = "20060102T150405Z"
+	rangeUTCLayoutFraction = "20060102T150405.999999999Z"
+)
+
+// RangeUTC is a range expressed in absolute time, the "clock" unit.
+type RangeUTC struct {
+	Start time.Time
+
+	// (optional) end, the range is open-ended if nil.
+	End *time.Time
+}
+
+func parseRangeUTCTime(s string) (time.Time, error) {
+	// utc-date "T" utc-time "Z", the fraction is optional.
+	if len(s) < len(rangeUTCLayout) || s[8] != 'T' || !strings.HasSuffix(s, "Z") {
+		return time.Time{}, fmt.Errorf("%w: %q", ErrRangeInvalid, s)
+	}
+	t, err := time.Parse(rangeUTCLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %q", ErrRangeInvalid, s)
+	}
+	return t, nil
+}
+
+func (r *RangeUTC) unmarshal(s string) error {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok || start == "" {
+		return fmt.Errorf("%w: %q", ErrRangeInvalid, s)
+	}
+
+	var err error
+	r.Start, err = parseRangeUTCTime(start)
+	if err != nil {
+		return err
+	}
+
+	r.End = nil
+	if end != "" {
+		t, err := parseRangeUTCTime(end)
+		if err != nil {
+			return err
+		}
+		if t.Before(r.Start) {
+			return fmt.Errorf("%w: %q", ErrRangeEndBeforeStart, s)
+		}
+		r.End = &t
+	}
+	return nil
+}
+
+func (r RangeUTC) marshal() string {
+	ret := "clock=" + r.Start.UTC().Format(rangeUTCLayoutFraction) + "-"
+	if r.End != nil {
+		ret += r.End.UTC().Format(rangeUTCLayoutFraction)
+	}
+	return ret
+}
+
+// Range is a Range header.
+type Range struct {
+	// range value
+	Value RangeValue
+}
+
+// Unmarshal decodes a Range header.
+func (h *Range) Unmarshal(v base.HeaderValue) error {
+	if len(v) == 0 {
+		return ErrRangeValueMissing
+	}
+
+	if len(v) > 1 {
+		return fmt.Errorf("%w (%v)", ErrRangeMultipleValues, v)
+	}
+
+	v0 := v[0]
+
+	// Parameters like ";time=" are ignored.
+	if i := strings.IndexByte(v0, ';'); i >= 0 {
+		v0 = v0[:i]
+	}
+	v0 = strings.TrimSpace(v0)
+
+	unit, value, ok := strings.Cut(v0, "=")
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrRangeInvalid, v0)
+	}
+
+	switch unit {
+	case "npt":
+		var r RangeNPT
+		if err := r.unmarshal(value); err != nil {
+			return err
+		}
+		h.Value = &r
+
+	case "clock":
+		var r RangeUTC
+		if err := r.unmarshal(value); err != nil {
+			return err
+		}
+		h.Value = &r
+
+	default:
+		return fmt.Errorf("%w: %q", ErrRangeUnsupported, unit)
+	}
+
+	return nil
+}
+
+// Marshal encodes a Range header.
+func (h Range) Marshal() base.HeaderValue {
+	return base.HeaderValue{h.Value.marshal()}
+}
@@ -0,0 +1,169 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"nvr/pkg/video/gortsplib/pkg/base"
+
+	"github.com/stretchr/testify/require"
+)
+
+func nptTime(d time.Duration) *RangeNPTTime {
+	return &RangeNPTTime{Duration: d}
+}
+
+func utcTime(s string) *time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		panic(err)
+	}
+	return &t
+}
+
+var casesRange = []struct {
+	name string
+	vin  base.HeaderValue
+	vout base.HeaderValue
+	h    Range
+}{
+	{
+		"npt open-ended",
+		base.HeaderValue{`npt=0-`},
+		base.HeaderValue{`npt=0-`},
+		Range{Value: &RangeNPT{Start: RangeNPTTime{}}},
+	},
+	{
+		"npt now",
+		base.HeaderValue{`npt=now-`},
+		base.HeaderValue{`npt=now-`},
+		Range{Value: &RangeNPT{Start: RangeNPTNow()}},
+	},
+	{
+		"npt seconds",
+		base.HeaderValue{`npt=10-25`},
+		base.HeaderValue{`npt=10-25`},
+		Range{Value: &RangeNPT{
+			Start: RangeNPTTime{Duration: 10 * time.Second},
+			End:   nptTime(25 * time.Second),
+		}},
+	},
+	{
+		"npt fractional seconds",
+		base.HeaderValue{`npt=1.25-3.000`},
+		base.HeaderValue{`npt=1.25-3`},
+		Range{Value: &RangeNPT{
+			Start: RangeNPTTime{Duration: 1250 * time.Millisecond},
+			End:   nptTime(3 * time.Second),
+		}},
+	},
+	{
+		"npt trailing dot",
+		base.HeaderValue{`npt=5.-`},
+		base.HeaderValue{`npt=5-`},
+		Range{Value: &RangeNPT{Start: RangeNPTTime{Duration: 5 * time.Second}}},
+	},
+	{
+		"npt sub nanosecond digits",
+		base.HeaderValue{`npt=0.0000000019-`},
+		base.HeaderValue{`npt=0.000000001-`},
+		Range{Value: &RangeNPT{Start: RangeNPTTime{Duration: 1}}},
+	},
+	{
+		"npt hhmmss",
+		base.HeaderValue{`npt=1:02:03.5-12:00:00`},
+		base.HeaderValue{`npt=3723.5-43200`},
+		Range{Value: &RangeNPT{
+			Start: RangeNPTTime{Duration: time.Hour + 2*time.Minute + 3500*time.Millisecond},
+			End:   nptTime(12 * time.Hour),
+		}},
+	},
+	{
+		"npt to now",
+		base.HeaderValue{`npt=30-now`},
+		base.HeaderValue{`npt=30-now`},
+		Range{Value: &RangeNPT{
+			Start: RangeNPTTime{Duration: 30 * time.Second},
+			End:   &RangeNPTTime{Now: true},
+		}},
+	},
+	{
+		"npt with time parameter",
+		base.HeaderValue{`npt=0-;time=19970123T143720Z`},
+		base.HeaderValue{`npt=0-`},
+		Range{Value: &RangeNPT{}},
+	},
+	{
+		"clock open-ended",
+		base.HeaderValue{`clock=19961108T142300Z-`},
+		base.HeaderValue{`clock=19961108T142300Z-`},
+		Range{Value: &RangeUTC{Start: *utcTime("1996-11-08T14:23:00Z")}},
+	},
+	{
+		"clock",
+		base.HeaderValue{`clock=19961108T142300Z-19961108T143520.25Z`},
+		base.HeaderValue{`clock=19961108T142300Z-19961108T143520.25Z`},
+		Range{Value: &RangeUTC{
+			Start: *utcTime("1996-11-08T14:23:00Z"),
+			End:   utcTime("1996-11-08T14:35:20.25Z"),
+		}},
+	},
+}
+
+func TestRangeUnmarshal(t *testing.T) {
+	for _, ca := range casesRange {
+		t.Run(ca.name, func(t *testing.T) {
+			var h Range
+			err := h.Unmarshal(ca.vin)
+			require.NoError(t, err)
+			require.Equal(t, ca.h, h)
+		})
+	}
+}
+
+func TestRangeMarshal(t *testing.T) {
+	for _, ca := range casesRange {
+		t.Run(ca.name, func(t *testing.T) {
+			req := ca.h.Marshal()
+			require.Equal(t, ca.vout, req)
+		})
+	}
+}
+
+func TestRangeUnmarshalErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		hv   base.HeaderValue
+		err  error
+	}{
+		{"empty", base.HeaderValue{}, ErrRangeValueMissing},
+		{"2 values", base.HeaderValue{"a", "b"}, ErrRangeMultipleValues},
+		{"no unit", base.HeaderValue{`0-`}, ErrRangeInvalid},
+		{"unsupported unit", base.HeaderValue{`smpte=10:07:00-`}, ErrRangeUnsupported},
+		{"no dash", base.HeaderValue{`npt=10`}, ErrRangeInvalid},
+		{"no start", base.HeaderValue{`npt=-10`}, ErrRangeInvalid},
+		{"negative", base.HeaderValue{`npt=-1-`}, ErrRangeInvalid},
+		{"sign", base.HeaderValue{`npt=+1-`}, ErrRangeInvalid},
+		{"exponent", base.HeaderValue{`npt=1e3-`}, ErrRangeInvalid},
+		{"leading dot", base.HeaderValue{`npt=.5-`}, ErrRangeInvalid},
+		{"text", base.HeaderValue{`npt=later-`}, ErrRangeInvalid},
+		{"end before start", base.HeaderValue{`npt=10-5`}, ErrRangeEndBeforeStart},
+		{"overflow", base.HeaderValue{`npt=99999999999999999999-`}, ErrRangeInvalid},
+		{"hhmmss minutes", base.HeaderValue{`npt=0:60:00-`}, ErrRangeInvalid},
+		{"hhmmss seconds", base.HeaderValue{`npt=0:00:60-`}, ErrRangeInvalid},
+		{"hhmmss short", base.HeaderValue{`npt=0:1:00-`}, ErrRangeInvalid},
+		{"hhmmss parts", base.HeaderValue{`npt=01:00-`}, ErrRangeInvalid},
+		{"hhmmss hours overflow", base.HeaderValue{`npt=9999999:00:00-`}, ErrRangeInvalid},
+		{"clock no z", base.HeaderValue{`clock=19961108T142300-`}, ErrRangeInvalid},
+		{"clock date", base.HeaderValue{`clock=19961308T142300Z-`}, ErrRangeInvalid},
+		{"clock end before start", base.HeaderValue{
+			`clock=19961108T142300Z-19961108T142259Z`,
+		}, ErrRangeEndBeforeStart},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var h Range
+			err := h.Unmarshal(ca.hv)
+			require.ErrorIs(t, err, ca.err)
+		})
+	}
+}
@@ -29,6 +29,9 @@ func (e ServerInvalidStateError) Error() string {
 // ErrServerInvalidPath invalid path.
 var ErrServerInvalidPath = errors.New("invalid path")
 
+// ErrServerNotAcceptable the client doesn't accept SDP.
+var ErrServerNotAcceptable = errors.New("Accept header doesn't include application/sdp")
+
 // ErrServerContentTypeMissing Content-Type header is missing.
 var ErrServerContentTypeMissing = errors.New("Content-Type header is missing")
 
@@ -62,6 +65,16 @@ func (e ServerTransportHeaderInvalidError) Error() string {
 	return fmt.Sprintf("invalid transport header: %v", e.Err)
 }
 
+// ServerRangeHeaderInvalidError is an error that can be returned by a server.
+type ServerRangeHeaderInvalidError struct {
+	Err error
+}
+
+// Error implements the error interface.
+func (e ServerRangeHeaderInvalidError) Error() string {
+	return fmt.Sprintf("invalid range header: %v", e.Err)
+}
+
 // ServerTrackAlreadySetupError is an error that can be returned by a server.
 type ServerTrackAlreadySetupError struct {
 	TrackID int
@@ -115,6 +128,16 @@ func (e ServerSessionTeardownError) Error() string {
 	return fmt.Sprintf("teared down by %v", e.Author)
 }
 
+// ServerConnTimeoutError a read or write deadline of the connection expired.
+type ServerConnTimeoutError struct {
+	Op string // "read" or "write".
+}
+
+// Error implements the error interface.
+func (e ServerConnTimeoutError) Error() string {
+	return e.Op + " timed out"
+}
+
 // ErrServerSessionLinkedToOtherConn is an error that can be returned by a server.
 var ErrServerSessionLinkedToOtherConn = errors.New(
 	"session is linked to another connection")
@@ -130,7 +153,8 @@ type ServerPathHasChangedError struct {
 
 // Error implements the error interface.
 func (e ServerPathHasChangedError) Error() string {
-	return fmt.Sprintf("path has changed, was '%s', now is '%s'", e.Prev, e.Cur)
+	return fmt.Sprintf("path has changed, was '%s', now is '%s',"+
+		" paths are compared without trailing slash, query and track ID", e.Prev, e.Cur)
 }
 
 // ErrServerCannotUseSessionCreatedByOtherIP cannot use a session created with a different IP.
@@ -142,3 +166,6 @@ var ErrServerSessionNotInUse = errors.New("not in use")
 
 // ErrServerUnexpectedFrame received unexpected interleaved frame.
 var ErrServerUnexpectedFrame = errors.New("received unexpected interleaved frame")
+
+// ErrServerUnknownChannel received interleaved frame on a channel that hasn't been set up.
+var ErrServerUnknownChannel = errors.New("received interleaved frame on unknown channel")
@@ -9,7 +9,6 @@ import (
 var (
 	ErrADTSdecodeLengthInvalid     = errors.New("invalid length")
 	ErrADTSdecodeSyncwordInvalid   = errors.New("invalid syncword")
-	ErrADTSdecodeCRCunsupported    = errors.New("CRC is not supported")
 	ErrADTSdecodeTypeUnsupported   = errors.New("unsupported audio type")
 	ErrADTSdecodeSampleRateInvalid = errors.New("invalid sample rate index")
 	ErrADTSdecodeChannelInvalid    = errors.New("invalid channel configuration")
@@ -29,17 +28,43 @@ func (e ADTSdecodeAUsizeToBigError) Error() string {
 	return fmt.Sprintf("AU size (%d) is too big (maximum is %d)", e.AUsize, MaxAccessUnitSize)
 }
 
+// Header sizes without and with the CRC.
+const (
+	adtsHeaderSize    = 7
+	adtsHeaderSizeCRC = 9
+)
+
 // ADTSPacket is an ADTS packet.
 type ADTSPacket struct {
 	Type         ObjectType
 	SampleRate   int
 	ChannelCount int
-	AU           []byte
+
+	// CRC of packets with error protection, nil if the protection is
+	// absent. The CRC covers bits of the raw data block, it's kept as
+	// is and isn't verified.
+	CRC *uint16
+
+	AU []byte
 }
 
 // ADTSPackets is a group od ADTS packets.
 type ADTSPackets []*ADTSPacket
 
+// NewADTSPackets returns unprotected ADTS packets of the AUs.
+func NewADTSPackets(conf Config, aus [][]byte) ADTSPackets {
+	ps := make(ADTSPackets, len(aus))
+	for i, au := range aus {
+		ps[i] = &ADTSPacket{
+			Type:         conf.Type,
+			SampleRate:   conf.SampleRate,
+			ChannelCount: conf.ChannelCount,
+			AU:           au,
+		}
+	}
+	return ps
+}
+
 // Unmarshal decodes an ADTS stream into ADTS packets.
 func (ps *ADTSPackets) Unmarshal(buf []byte) error { //nolint:funlen
 	// refs: https://wiki.multimedia.cx/index.php/ADTS
@@ -48,7 +73,7 @@ func (ps *ADTSPackets) Unmarshal(buf []byte) error { //nolint:funlen
 	pos := 0
 
 	for {
-		if (bl - pos) < 8 {
+		if (bl - pos) < adtsHeaderSize {
 			return ErrADTSdecodeLengthInvalid
 		}
 
@@ -57,9 +82,13 @@ func (ps *ADTSPackets) Unmarshal(buf []byte) error { //nolint:funlen
 			return ErrADTSdecodeSyncwordInvalid
 		}
 
+		headerSize := adtsHeaderSize
 		protectionAbsent := buf[pos+1] & 0x01
 		if protectionAbsent != 1 {
-			return ErrADTSdecodeCRCunsupported
+			headerSize = adtsHeaderSizeCRC
+			if (bl - pos) < headerSize {
+				return ErrADTSdecodeLengthInvalid
+			}
 		}
 
 		pkt := &ADTSPacket{}
@@ -69,8 +98,9 @@ func (ps *ADTSPackets) Unmarshal(buf []byte) error { //nolint:funlen
 			return fmt.Errorf("%w: %d", ErrADTSdecodeTypeUnsupported, pkt.Type)
 		}
 
+		// Index 15 is a explicit sample rate, it can't be used in ADTS.
 		sampleRateIndex := (buf[pos+2] >> 2) & 0x0F
-		if sampleRateIndex <= 12 {
+		if int(sampleRateIndex) < len(sampleRates) {
 			pkt.SampleRate = sampleRates[sampleRateIndex]
 		} else {
 			return fmt.Errorf("%w: %d", ErrADTSdecodeSampleRateInvalid, sampleRateIndex)
@@ -88,11 +118,18 @@ func (ps *ADTSPackets) Unmarshal(buf []byte) error { //nolint:funlen
 			return fmt.Errorf("%w: %d", ErrADTSdecodeChannelInvalid, channelConfig)
 		}
 
-		frameLen := int(((uint16(buf[pos+3])&0x03)<<11)|
-			(uint16(buf[pos+4])<<3)|
-			((uint16(buf[pos+5])>>5)&0x07)) - 7
-		if frameLen > MaxAccessUnitSize {
-			return ADTSdecodeAUsizeToBigError{AUsize: frameLen}
+		// The frame length includes the header.
+		frameLen := int(((uint16(buf[pos+3]) & 0x03) << 11) |
+			(uint16(buf[pos+4]) << 3) |
+			((uint16(buf[pos+5]) >> 5) & 0x07))
+		if frameLen < headerSize {
+			return fmt.Errorf("%w: %d is smaller than the header",
+				ErrADTSdecodeFrameLengthInvalid, frameLen)
+		}
+
+		auSize := frameLen - headerSize
+		if auSize > MaxAccessUnitSize {
+			return ADTSdecodeAUsizeToBigError{AUsize: auSize}
 		}
 
 		frameCount := buf[pos+6] & 0x03
@@ -100,12 +137,17 @@ func (ps *ADTSPackets) Unmarshal(buf []byte) error { //nolint:funlen
 			return ErrADTSdecodeMultipleFramesUnsupported
 		}
 
-		if len(buf[pos+7:]) < frameLen {
+		if headerSize == adtsHeaderSizeCRC {
+			crc := uint16(buf[pos+7])<<8 | uint16(buf[pos+8])
+			pkt.CRC = &crc
+		}
+
+		if len(buf[pos+headerSize:]) < auSize {
 			return ErrADTSdecodeFrameLengthInvalid
 		}
 
-		pkt.AU = buf[pos+7 : pos+7+frameLen]
-		pos += 7 + frameLen
+		pkt.AU = buf[pos+headerSize : pos+frameLen]
+		pos += frameLen
 
 		*ps = append(*ps, pkt)
 
@@ -117,26 +159,42 @@ func (ps *ADTSPackets) Unmarshal(buf []byte) error { //nolint:funlen
 	return nil
 }
 
+func (pkt *ADTSPacket) headerSize() int {
+	if pkt.CRC != nil {
+		return adtsHeaderSizeCRC
+	}
+	return adtsHeaderSize
+}
+
 func (ps ADTSPackets) marshalSize() int {
 	n := 0
 	for _, pkt := range ps {
-		n += 7 + len(pkt.AU)
+		n += pkt.headerSize() + len(pkt.AU)
 	}
 	return n
 }
 
 // ADTS encode errors.
 var (
+	ErrADTSencodeTypeUnsupported     = errors.New("unsupported audio type")
 	ErrADTSencodeSampleRateInvalid   = errors.New("invalid sample rate")
 	ErrADTSencodeChannelCountInvalid = errors.New("invalid channel count")
+	ErrADTSencodeAUsizeInvalid       = errors.New("invalid AU size")
 )
 
-// Marshal encodes ADTS packets into an ADTS stream.
+// Marshal encodes ADTS packets into an ADTS stream. Packets
+// without a CRC are encoded with the protection absent.
 func (ps ADTSPackets) Marshal() ([]byte, error) {
 	buf := make([]byte, ps.marshalSize())
 	pos := 0
 
 	for _, pkt := range ps {
+		// The profile is 2 bits, the object type minus one.
+		if pkt.Type < 1 || pkt.Type > 4 {
+			return nil, fmt.Errorf("%w: %d",
+				ErrADTSencodeTypeUnsupported, pkt.Type)
+		}
+
 		sampleRateIndex, ok := reverseSampleRates[pkt.SampleRate]
 		if !ok {
 			return nil, fmt.Errorf("%w: %d",
@@ -156,18 +214,33 @@ func (ps ADTSPackets) Marshal() ([]byte, error) {
 				ErrADTSencodeChannelCountInvalid, pkt.ChannelCount)
 		}
 
-		frameLen := len(pkt.AU) + 7
+		if len(pkt.AU) > MaxAccessUnitSize {
+			return nil, fmt.Errorf("%w: %d",
+				ErrADTSencodeAUsizeInvalid, len(pkt.AU))
+		}
+
+		headerSize := pkt.headerSize()
+		frameLen := len(pkt.AU) + headerSize
+
+		protectionAbsent := 1
+		if pkt.CRC != nil {
+			protectionAbsent = 0
+		}
 
 		fullness := 0x07FF // like ffmpeg does
 
 		buf[pos+0] = 0xFF
-		buf[pos+1] = 0xF1
+		buf[pos+1] = 0xF0 | uint8(protectionAbsent)
 		buf[pos+2] = uint8((int(pkt.Type-1) << 6) | (sampleRateIndex << 2) | ((channelConfig >> 2) & 0x01))
 		buf[pos+3] = uint8((channelConfig&0x03)<<6 | (frameLen>>11)&0x03)
 		buf[pos+4] = uint8((frameLen >> 3) & 0xFF)
 		buf[pos+5] = uint8((frameLen&0x07)<<5 | ((fullness >> 6) & 0x1F))
 		buf[pos+6] = uint8((fullness & 0x3F) << 2)
-		pos += 7
+		if pkt.CRC != nil {
+			buf[pos+7] = uint8(*pkt.CRC >> 8)
+			buf[pos+8] = uint8(*pkt.CRC)
+		}
+		pos += headerSize
 
 		pos += copy(buf[pos:], pkt.AU)
 	}
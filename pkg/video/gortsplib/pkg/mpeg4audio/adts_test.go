@@ -1,11 +1,16 @@
 package mpeg4audio
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
+func uint16Ptr(v uint16) *uint16 {
+	return &v
+}
+
 var casesADTS = []struct {
 	name string
 	byts []byte
@@ -23,6 +28,19 @@ var casesADTS = []struct {
 			},
 		},
 	},
+	{
+		"crc",
+		[]byte{0xff, 0xf0, 0x4c, 0x80, 0x1, 0x7f, 0xfc, 0x12, 0x34, 0xaa, 0xbb},
+		ADTSPackets{
+			{
+				Type:         2,
+				SampleRate:   48000,
+				ChannelCount: 2,
+				CRC:          uint16Ptr(0x1234),
+				AU:           []byte{0xaa, 0xbb},
+			},
+		},
+	},
 	{
 		"multiple",
 		[]byte{
@@ -85,9 +103,9 @@ func TestDecodeADTSErrors(t *testing.T) {
 			"invalid syncword",
 		},
 		{
-			"crc",
-			[]byte{0xff, 0xF0, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
-			"CRC is not supported",
+			"crc invalid length",
+			[]byte{0xff, 0xf0, 0x4c, 0x80, 0x1, 0x3f, 0xfc, 0x12},
+			"invalid length",
 		},
 		{
 			"invalid audio type",
@@ -99,6 +117,11 @@ func TestDecodeADTSErrors(t *testing.T) {
 			[]byte{0xff, 0xf1, 0x74, 0x80, 0x1, 0x3f, 0xfc, 0xaa},
 			"invalid sample rate index: 13",
 		},
+		{
+			"explicit sample rate index",
+			[]byte{0xff, 0xf1, 0x7c, 0x80, 0x1, 0x3f, 0xfc, 0xaa},
+			"invalid sample rate index: 15",
+		},
 		{
 			"invalid channel configuration",
 			[]byte{0xff, 0xf1, 0x4c, 0x00, 0x1, 0x3f, 0xfc, 0xaa},
@@ -114,6 +137,24 @@ func TestDecodeADTSErrors(t *testing.T) {
 			[]byte{0xff, 0xf1, 0x4c, 0x80, 0x1, 0x3f, 0xfc, 0xaa},
 			"invalid frame length",
 		},
+		{
+			"frame length zero",
+			[]byte{0xff, 0xf1, 0x4c, 0x80, 0x0, 0x1f, 0xfc, 0xaa},
+			"invalid frame length: 0 is smaller than the header",
+		},
+		{
+			"frame length smaller than crc header",
+			[]byte{0xff, 0xf0, 0x4c, 0x80, 0x1, 0x1f, 0xfc, 0x12, 0x34, 0xaa},
+			"invalid frame length: 8 is smaller than the header",
+		},
+		{
+			"second packet truncated",
+			[]byte{
+				0xff, 0xf1, 0x4c, 0x80, 0x1, 0x3f, 0xfc, 0xaa,
+				0xbb, 0xff, 0xf1, 0x4c,
+			},
+			"invalid length",
+		},
 	} {
 		t.Run(ca.name, func(t *testing.T) {
 			var pkts ADTSPackets
@@ -122,3 +163,85 @@ func TestDecodeADTSErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestADTSRoundTrip(t *testing.T) {
+	conf := Config{
+		Type:         ObjectTypeAACLC,
+		SampleRate:   44100,
+		ChannelCount: 2,
+	}
+	aus := [][]byte{{0x01, 0x02, 0x03}, {0x04}, bytes.Repeat([]byte{0x05}, 300)}
+
+	t.Run("unprotected", func(t *testing.T) {
+		pkts := NewADTSPackets(conf, aus)
+		byts, err := pkts.Marshal()
+		require.NoError(t, err)
+		require.Len(t, byts, 3*7+3+1+300)
+
+		var dec ADTSPackets
+		require.NoError(t, dec.Unmarshal(byts))
+		require.Equal(t, pkts, dec)
+	})
+	t.Run("crc", func(t *testing.T) {
+		pkts := NewADTSPackets(conf, aus)
+		for i, pkt := range pkts {
+			pkt.CRC = uint16Ptr(uint16(i) + 0xabc0)
+		}
+		byts, err := pkts.Marshal()
+		require.NoError(t, err)
+		require.Len(t, byts, 3*9+3+1+300)
+
+		var dec ADTSPackets
+		require.NoError(t, dec.Unmarshal(byts))
+		require.Equal(t, pkts, dec)
+	})
+}
+
+func TestADTSMarshalErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		pkt  ADTSPacket
+		err  string
+	}{
+		{
+			"invalid audio type",
+			ADTSPacket{Type: ObjectTypeSBR, SampleRate: 48000, ChannelCount: 2},
+			"unsupported audio type: 5",
+		},
+		{
+			"invalid sample rate",
+			ADTSPacket{Type: ObjectTypeAACLC, SampleRate: 48001, ChannelCount: 2},
+			"invalid sample rate: 48001",
+		},
+		{
+			"invalid channel count",
+			ADTSPacket{Type: ObjectTypeAACLC, SampleRate: 48000, ChannelCount: 7},
+			"invalid channel count (7)",
+		},
+		{
+			"invalid AU size",
+			ADTSPacket{
+				Type:         ObjectTypeAACLC,
+				SampleRate:   48000,
+				ChannelCount: 2,
+				AU:           make([]byte, MaxAccessUnitSize+1),
+			},
+			"invalid AU size: 5121",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			_, err := ADTSPackets{&ca.pkt}.Marshal()
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func FuzzADTSUnmarshal(f *testing.F) {
+	for _, ca := range casesADTS {
+		f.Add(ca.byts)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var pkts ADTSPackets
+		pkts.Unmarshal(b) //nolint:errcheck
+	})
+}
@@ -37,6 +37,11 @@ func New(size uint64) (*RingBuffer, error) {
 	}, nil
 }
 
+// Size returns the number of items the buffer can hold.
+func (r *RingBuffer) Size() uint64 {
+	return r.size
+}
+
 // Close makes Pull() return false.
 func (r *RingBuffer) Close() {
 	atomic.StoreInt64(&r.closed, 1)
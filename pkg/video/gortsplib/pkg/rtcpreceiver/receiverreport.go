@@ -0,0 +1,91 @@
+package rtcpreceiver
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Errors.
+var (
+	ErrPacketTooShort     = errors.New("RTCP packet too short")
+	ErrPacketVersion      = errors.New("invalid RTCP version")
+	ErrPacketLength       = errors.New("invalid RTCP packet length")
+	ErrReportBlocksLength = errors.New("report blocks exceed packet length")
+)
+
+// ReportBlock is a reception report block of a RTCP receiver report.
+type ReportBlock struct {
+	// SSRC of the source the report is about.
+	SSRC uint32
+
+	// Fraction of packets lost since the previous report, in units of 1/256.
+	FractionLost uint8
+
+	// Cumulative number of packets lost.
+	PacketsLost int32
+
+	// Extended highest sequence number received.
+	HighestSeq uint32
+
+	// Interarrival jitter in timestamp units.
+	Jitter uint32
+
+	// Middle 32 bits of the NTP timestamp of the last sender report.
+	LastSenderReport uint32
+
+	// Delay since the last sender report in units of 1/65536 seconds.
+	Delay uint32
+}
+
+// ParseReceiverReports returns the report blocks of the receiver reports
+// in a compound RTCP packet. Other packet types are skipped.
+func ParseReceiverReports(payload []byte) ([]ReportBlock, error) {
+	var blocks []ReportBlock
+	for len(payload) != 0 {
+		if len(payload) < 4 {
+			return nil, ErrPacketTooShort
+		}
+		if payload[0]>>6 != 2 {
+			return nil, ErrPacketVersion
+		}
+		length := (int(binary.BigEndian.Uint16(payload[2:])) + 1) * 4
+		if length > len(payload) {
+			return nil, ErrPacketLength
+		}
+		packet := payload[:length]
+		payload = payload[length:]
+
+		if packet[1] != typeReceiverReport {
+			continue
+		}
+		if len(packet) < 8 {
+			return nil, ErrPacketTooShort
+		}
+
+		count := int(packet[0] & 0x1F)
+		if 8+count*24 > len(packet) {
+			return nil, ErrReportBlocksLength
+		}
+		for i := 0; i < count; i++ {
+			blocks = append(blocks, parseReportBlock(packet[8+i*24:]))
+		}
+	}
+	return blocks, nil
+}
+
+func parseReportBlock(buf []byte) ReportBlock {
+	lost := binary.BigEndian.Uint32(buf[4:])
+
+	// Sign extend the 24 bit integer.
+	packetsLost := int32(lost<<8) >> 8
+
+	return ReportBlock{
+		SSRC:             binary.BigEndian.Uint32(buf[0:]),
+		FractionLost:     uint8(lost >> 24),
+		PacketsLost:      packetsLost,
+		HighestSeq:       binary.BigEndian.Uint32(buf[8:]),
+		Jitter:           binary.BigEndian.Uint32(buf[12:]),
+		LastSenderReport: binary.BigEndian.Uint32(buf[16:]),
+		Delay:            binary.BigEndian.Uint32(buf[20:]),
+	}
+}
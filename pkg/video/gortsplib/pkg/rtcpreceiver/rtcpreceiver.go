@@ -0,0 +1,195 @@
+// Package rtcpreceiver contains a utility to generate RTCP receiver reports.
+package rtcpreceiver
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// RTCP packet types.
+const (
+	typeSenderReport   = 200
+	typeReceiverReport = 201
+)
+
+// Stats are the reception statistics of a track.
+type Stats struct {
+	// Cumulative number of packets lost.
+	PacketsLost int64
+
+	// Interarrival jitter in seconds.
+	Jitter float64
+}
+
+// RTCPReceiver is a utility to generate RTCP receiver reports.
+type RTCPReceiver struct {
+	receiverSSRC uint32
+	clockRate    float64
+
+	mutex sync.Mutex
+
+	// data from RTP packets
+	initialized      bool
+	senderSSRC       uint32
+	baseSeq          uint16
+	maxSeq           uint16
+	seqCycles        uint32
+	received         uint32
+	expectedPrior    uint32
+	receivedPrior    uint32
+	lastTransit      float64
+	jitter           float64
+	transitFilled    bool
+	receivedInPeriod bool
+
+	// data from RTCP sender reports
+	lastSenderReport     uint32
+	lastSenderReportTime time.Time
+}
+
+// New allocates a RTCPReceiver.
+func New(receiverSSRC uint32, clockRate int) *RTCPReceiver {
+	return &RTCPReceiver{
+		receiverSSRC: receiverSSRC,
+		clockRate:    float64(clockRate),
+	}
+}
+
+// ProcessPacketRTP extracts the needed data from RTP packets.
+func (rr *RTCPReceiver) ProcessPacketRTP(ts time.Time, pkt *rtp.Packet) {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	rr.receivedInPeriod = true
+
+	if !rr.initialized {
+		rr.initialized = true
+		rr.senderSSRC = pkt.SSRC
+		rr.baseSeq = pkt.SequenceNumber
+		rr.maxSeq = pkt.SequenceNumber
+		rr.received = 1
+		rr.updateJitter(ts, pkt.Timestamp)
+		return
+	}
+
+	rr.received++
+
+	// Reordered and duplicated packets don't move the highest sequence number.
+	diff := int16(pkt.SequenceNumber - rr.maxSeq)
+	if diff > 0 {
+		if pkt.SequenceNumber < rr.maxSeq {
+			rr.seqCycles++
+		}
+		rr.maxSeq = pkt.SequenceNumber
+	}
+
+	rr.updateJitter(ts, pkt.Timestamp)
+}
+
+// updateJitter implements the interarrival jitter estimator of RFC3550 A.8.
+func (rr *RTCPReceiver) updateJitter(ts time.Time, rtpTime uint32) {
+	arrival := float64(ts.UnixNano()) * rr.clockRate / float64(time.Second)
+	transit := arrival - float64(rtpTime)
+
+	if rr.transitFilled {
+		d := math.Abs(transit - rr.lastTransit)
+		rr.jitter += (d - rr.jitter) / 16
+	}
+	rr.lastTransit = transit
+	rr.transitFilled = true
+}
+
+// ProcessSenderReport extracts the needed data from RTCP sender reports.
+func (rr *RTCPReceiver) ProcessSenderReport(ts time.Time, payload []byte) {
+	if len(payload) < 28 || payload[1] != typeSenderReport {
+		return
+	}
+
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	// Middle 32 bits of the NTP timestamp.
+	rr.lastSenderReport = binary.BigEndian.Uint32(payload[10:14])
+	rr.lastSenderReportTime = ts
+}
+
+func (rr *RTCPReceiver) extendedMaxSeq() uint32 {
+	return rr.seqCycles<<16 | uint32(rr.maxSeq)
+}
+
+func (rr *RTCPReceiver) expected() uint32 {
+	return rr.extendedMaxSeq() - uint32(rr.baseSeq) + 1
+}
+
+func (rr *RTCPReceiver) packetsLost() int64 {
+	return int64(rr.expected()) - int64(rr.received)
+}
+
+// Report generates a RTCP receiver report.
+// It returns nil if no packets have been received since the last report.
+func (rr *RTCPReceiver) Report(ts time.Time) []byte {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	if !rr.receivedInPeriod {
+		return nil
+	}
+	rr.receivedInPeriod = false
+
+	expected := rr.expected()
+	expectedInterval := expected - rr.expectedPrior
+	receivedInterval := rr.received - rr.receivedPrior
+	rr.expectedPrior = expected
+	rr.receivedPrior = rr.received
+
+	var fractionLost uint8
+	if lostInterval := int64(expectedInterval) - int64(receivedInterval); expectedInterval != 0 && lostInterval > 0 {
+		fractionLost = uint8((lostInterval << 8) / int64(expectedInterval))
+	}
+
+	// Cumulative number of packets lost is a signed 24 bit integer.
+	lost := rr.packetsLost()
+	if lost > 0x7FFFFF {
+		lost = 0x7FFFFF
+	} else if lost < -0x800000 {
+		lost = -0x800000
+	}
+
+	var delay uint32
+	if !rr.lastSenderReportTime.IsZero() {
+		// Delay since last sender report in units of 1/65536 seconds.
+		delay = uint32(ts.Sub(rr.lastSenderReportTime).Seconds() * 65536)
+	}
+
+	buf := make([]byte, 32)
+	buf[0] = 2<<6 | 1 // version 2, one report block.
+	buf[1] = typeReceiverReport
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(buf)/4-1))
+	binary.BigEndian.PutUint32(buf[4:], rr.receiverSSRC)
+	binary.BigEndian.PutUint32(buf[8:], rr.senderSSRC)
+	binary.BigEndian.PutUint32(buf[12:], uint32(fractionLost)<<24|uint32(lost)&0xFFFFFF)
+	binary.BigEndian.PutUint32(buf[16:], rr.extendedMaxSeq())
+	binary.BigEndian.PutUint32(buf[20:], uint32(rr.jitter))
+	binary.BigEndian.PutUint32(buf[24:], rr.lastSenderReport)
+	binary.BigEndian.PutUint32(buf[28:], delay)
+
+	return buf
+}
+
+// Stats returns the reception statistics.
+func (rr *RTCPReceiver) Stats() Stats {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	if !rr.initialized {
+		return Stats{}
+	}
+	return Stats{
+		PacketsLost: rr.packetsLost(),
+		Jitter:      rr.jitter / rr.clockRate,
+	}
+}
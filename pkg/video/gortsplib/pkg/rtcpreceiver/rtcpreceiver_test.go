@@ -0,0 +1,126 @@
+package rtcpreceiver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func testPacket(seq uint16, ts uint32) *rtp.Packet {
+	return &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    96,
+			SequenceNumber: seq,
+			Timestamp:      ts,
+			SSRC:           0xba9da416,
+		},
+		Payload: []byte{0x00, 0x00},
+	}
+}
+
+func TestRTCPReceiver(t *testing.T) {
+	rr := New(0x65f83afb, 90000)
+	start := time.Date(2008, 5, 20, 22, 15, 20, 0, time.UTC)
+
+	require.Nil(t, rr.Report(start))
+
+	sr := []byte{
+		0x80, 0xc8, 0x00, 0x06, 0xba, 0x9d, 0xa4, 0x16,
+		0xe0, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x01,
+	}
+	rr.ProcessSenderReport(start, sr)
+
+	// Sequence number wraps around, 0x0001 and 0x0002 are lost.
+	rr.ProcessPacketRTP(start, testPacket(0xFFFE, 0))
+	rr.ProcessPacketRTP(start.Add(time.Second), testPacket(0xFFFF, 90000))
+	rr.ProcessPacketRTP(start.Add(2*time.Second), testPacket(0x0000, 180000))
+	rr.ProcessPacketRTP(start.Add(5*time.Second), testPacket(0x0003, 450000))
+
+	expected := []byte{
+		0x81, 0xc9, 0x00, 0x07, // Header.
+		0x65, 0xf8, 0x3a, 0xfb, // Receiver SSRC.
+		0xba, 0x9d, 0xa4, 0x16, // Sender SSRC.
+		0x55, 0x00, 0x00, 0x02, // Fraction lost 2/6, cumulative lost 2.
+		0x00, 0x01, 0x00, 0x03, // Extended highest sequence number.
+		0x00, 0x00, 0x00, 0x00, // Jitter.
+		0x00, 0x00, 0x80, 0x00, // Last sender report.
+		0x00, 0x05, 0x00, 0x00, // Delay since last sender report.
+	}
+	require.Equal(t, expected, rr.Report(start.Add(5*time.Second)))
+	require.Equal(t, Stats{PacketsLost: 2}, rr.Stats())
+
+	// No packets since the last report.
+	require.Nil(t, rr.Report(start.Add(6*time.Second)))
+
+	// Packet arrives 16ms late.
+	rr.ProcessPacketRTP(start.Add(6*time.Second+16*time.Millisecond), testPacket(0x0004, 540000))
+	report := rr.Report(start.Add(7 * time.Second))
+	require.Equal(t, []byte{0x00, 0x00, 0x00, 0x02}, report[12:16])
+	require.Equal(t, []byte{0x00, 0x00, 0x00, 0x5a}, report[20:24])
+
+	stats := rr.Stats()
+	require.Equal(t, int64(2), stats.PacketsLost)
+	require.InDelta(t, 0.001, stats.Jitter, 0.00001)
+}
+
+func TestRTCPReceiverReorder(t *testing.T) {
+	rr := New(1, 90000)
+	now := time.Now()
+
+	rr.ProcessPacketRTP(now, testPacket(10, 0))
+	rr.ProcessPacketRTP(now, testPacket(12, 0))
+	rr.ProcessPacketRTP(now, testPacket(11, 0))
+
+	report := rr.Report(now)
+	require.Equal(t, []byte{0x00, 0x00, 0x00, 0x00}, report[12:16])
+	require.Equal(t, []byte{0x00, 0x00, 0x00, 0x0c}, report[16:20])
+}
+
+func TestParseReceiverReports(t *testing.T) {
+	rr := []byte{
+		0x81, 0xc9, 0x00, 0x07, 0x65, 0xf8, 0x3a, 0xfb,
+		0xba, 0x9d, 0xa4, 0x16, 0x40, 0xff, 0xff, 0xfe,
+		0x00, 0x01, 0x00, 0x03, 0x00, 0x00, 0x00, 0x2d,
+		0x80, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00,
+	}
+	sdes := []byte{
+		0x81, 0xca, 0x00, 0x02, 0x65, 0xf8, 0x3a, 0xfb,
+		0x01, 0x00, 0x00, 0x00,
+	}
+	block := ReportBlock{
+		SSRC:             0xba9da416,
+		FractionLost:     0x40,
+		PacketsLost:      -2,
+		HighestSeq:       0x10003,
+		Jitter:           45,
+		LastSenderReport: 0x80000000,
+		Delay:            0x10000,
+	}
+
+	testCases := map[string]struct {
+		payload  []byte
+		expected []ReportBlock
+		err      error
+	}{
+		"receiverReport": {rr, []ReportBlock{block}, nil},
+		"compound":       {append(append([]byte{}, rr...), sdes...), []ReportBlock{block}, nil},
+		"noBlocks":       {[]byte{0x80, 0xc9, 0x00, 0x01, 0x65, 0xf8, 0x3a, 0xfb}, nil, nil},
+		"sdesOnly":       {sdes, nil, nil},
+		"tooShort":       {[]byte{0x81, 0xc9}, nil, ErrPacketTooShort},
+		"version":        {[]byte{0x41, 0xc9, 0x00, 0x00}, nil, ErrPacketVersion},
+		"length":         {rr[:20], nil, ErrPacketLength},
+		"blocksLength":   {[]byte{0x82, 0xc9, 0x00, 0x01, 0x65, 0xf8, 0x3a, 0xfb}, nil, ErrReportBlocksLength},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			blocks, err := ParseReceiverReports(tc.payload)
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.expected, blocks)
+		})
+	}
+}
@@ -65,23 +65,32 @@ func (e MaxNALUsError) Error() string {
 type Decoder struct {
 	PacketizationMode int
 
+	// clock rate of packets (optional).
+	ClockRate int
+
 	timeDecoder         *rtptimedec.Decoder
 	firstPacketReceived bool
-	fragmentedSize      int
-	fragments           [][]byte
 	firstNALUParsed     bool
 	annexBMode          bool
 
+	// Fragments are copied since the packet payload
+	// may be reused after Decode returns.
+	fragments []byte
+
 	// for DecodeUntilMarker()
 	naluBuffer [][]byte
 }
 
 // Init initializes the decoder.
 func (d *Decoder) Init() {
-	d.timeDecoder = rtptimedec.New(rtpClockRate)
+	if d.ClockRate == 0 {
+		d.ClockRate = defaultClockRate
+	}
+	d.timeDecoder = rtptimedec.New(d.ClockRate)
 }
 
-// Decode decodes NALUs from a RTP/H264 packet.
+// Decode decodes NALUs from a RTP/H264 packet. NALUs that
+// aren't fragmented reference the payload of the packet.
 func (d *Decoder) Decode(pkt *rtp.Packet) ([][]byte, time.Duration, error) { //nolint:funlen,gocognit
 	if d.PacketizationMode >= 2 {
 		return nil, 0, ErrModeUnsupported
@@ -113,8 +122,8 @@ func (d *Decoder) Decode(pkt *rtp.Packet) ([][]byte, time.Duration, error) { //n
 
 			nri := (pkt.Payload[0] >> 5) & 0x03
 			typ := pkt.Payload[1] & 0x1F
-			d.fragmentedSize = len(pkt.Payload[1:])
-			d.fragments = append(d.fragments, []byte{(nri << 5) | typ}, pkt.Payload[2:])
+			d.fragments = append(d.fragments, (nri<<5)|typ)
+			d.fragments = append(d.fragments, pkt.Payload[2:]...)
 			d.firstPacketReceived = true
 
 			return nil, 0, ErrMorePacketsNeeded
@@ -128,24 +137,20 @@ func (d *Decoder) Decode(pkt *rtp.Packet) ([][]byte, time.Duration, error) { //n
 			return nil, 0, ErrFUinvalidNonStarting
 		}
 
-		d.fragmentedSize += len(pkt.Payload[2:])
-		if d.fragmentedSize > h264.MaxNALUSize {
+		fragmentedSize := len(d.fragments) + len(pkt.Payload[2:])
+		if fragmentedSize > h264.MaxNALUSize {
 			d.fragments = d.fragments[:0]
-			return nil, 0, NALUToBigError{NALUsize: d.fragmentedSize}
+			return nil, 0, NALUToBigError{NALUsize: fragmentedSize}
 		}
 
-		d.fragments = append(d.fragments, pkt.Payload[2:])
+		d.fragments = append(d.fragments, pkt.Payload[2:]...)
 
 		if end != 1 {
 			return nil, 0, ErrMorePacketsNeeded
 		}
 
-		nalu := make([]byte, d.fragmentedSize)
-		pos := 0
-
-		for _, frag := range d.fragments {
-			pos += copy(nalu[pos:], frag)
-		}
+		nalu := make([]byte, len(d.fragments))
+		copy(nalu, d.fragments)
 
 		d.fragments = d.fragments[:0]
 		nalus = [][]byte{nalu}
@@ -204,7 +209,7 @@ func (d *Decoder) Decode(pkt *rtp.Packet) ([][]byte, time.Duration, error) { //n
 
 // DecodeUntilMarker decodes NALUs from a RTP/H264 packet and puts them in a buffer.
 // When a packet has the marker flag (meaning that all the NALUs with the same PTS have
-// been received), the buffer is returned.
+// been received), the buffer is returned. The NALUs are copied into the buffer.
 func (d *Decoder) DecodeUntilMarker(pkt *rtp.Packet) ([][]byte, time.Duration, error) {
 	nalus, pts, err := d.Decode(pkt)
 	if err != nil {
@@ -215,7 +220,9 @@ func (d *Decoder) DecodeUntilMarker(pkt *rtp.Packet) ([][]byte, time.Duration, e
 		return nil, 0, MaxNALUsError{count: len(d.naluBuffer) + len(nalus)}
 	}
 
-	d.naluBuffer = append(d.naluBuffer, nalus...)
+	for _, nalu := range nalus {
+		d.naluBuffer = append(d.naluBuffer, append([]byte(nil), nalu...))
+	}
 
 	if !pkt.Marker {
 		return nil, 0, ErrMorePacketsNeeded
@@ -389,6 +389,33 @@ func TestDecodeSTAPAWithPadding(t *testing.T) {
 	}, nalus)
 }
 
+func TestDecodeClockRate(t *testing.T) {
+	d := &Decoder{ClockRate: 1000}
+	d.Init()
+
+	var ptss []time.Duration
+	for _, ts := range []uint32{4294966796, 0, 1500} {
+		_, pts, err := d.Decode(&rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         true,
+				PayloadType:    96,
+				SequenceNumber: 17645,
+				Timestamp:      ts,
+				SSRC:           0x9dbb7812,
+			},
+			Payload: []byte{0x05},
+		})
+		require.NoError(t, err)
+		ptss = append(ptss, pts)
+	}
+	require.Equal(t, []time.Duration{
+		0,
+		500 * time.Millisecond,
+		2 * time.Second,
+	}, ptss)
+}
+
 func TestDecodeAnnexB(t *testing.T) {
 	d := &Decoder{}
 	d.Init()
@@ -3,6 +3,7 @@ package rtph264
 import (
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"log"
 	"time"
 
@@ -10,8 +11,18 @@ import (
 )
 
 const (
-	rtpVersion   = 0x02
-	rtpClockRate = 90000 // H264 always uses 90khz.
+	rtpVersion = 0x02
+
+	defaultMTU = 1500
+
+	// IP header + UDP header + RTP header.
+	packetOverhead = 20 + 8 + 12
+
+	// FU-A indicator + FU-A header + at least one byte of the NALU.
+	minPayloadMaxSize = 3
+
+	// RFC 6184 requires 90kHz, a few cameras use other rates.
+	defaultClockRate = 90000
 )
 
 func randUint32() uint32 {
@@ -36,11 +47,19 @@ type Encoder struct {
 	// initial timestamp of packets (optional).
 	InitialTimestamp *uint32
 
+	// maximum transmission unit of the network path (optional).
+	// The IP, UDP and RTP headers are subtracted from the MTU to
+	// get the PayloadMaxSize if PayloadMaxSize isn't set.
+	MTU int
+
 	// maximum size of packet payloads (optional).
 	PayloadMaxSize int
 
 	PacketizationMode int
 
+	// clock rate of packets (optional).
+	ClockRate int
+
 	sequenceNumber uint16
 }
 
@@ -58,22 +77,37 @@ func (e *Encoder) Init() {
 		v := randUint32()
 		e.InitialTimestamp = &v
 	}
+	if e.MTU == 0 {
+		e.MTU = defaultMTU
+	}
 	if e.PayloadMaxSize == 0 {
-		e.PayloadMaxSize = 1460 // 1500 (UDP MTU) - 20 (IP header) - 8 (UDP header) - 12 (RTP header)
+		e.PayloadMaxSize = e.MTU - packetOverhead
+	}
+	if e.ClockRate == 0 {
+		e.ClockRate = defaultClockRate
 	}
 
 	e.sequenceNumber = *e.InitialSequenceNumber
 }
 
 func (e *Encoder) encodeTimestamp(ts time.Duration) uint32 {
-	return *e.InitialTimestamp + uint32(ts.Seconds()*rtpClockRate)
+	return *e.InitialTimestamp + uint32(ts.Seconds()*float64(e.ClockRate))
 }
 
-// Encode encodes NALUs into RTP/H264 packets.
+// ErrPayloadMaxSizeTooSmall the maximum payload size can't fit a FU-A fragment.
+var ErrPayloadMaxSizeTooSmall = errors.New("payload max size is too small")
+
+// Encode encodes the NALUs of a access unit into RTP/H264 packets. Small
+// NALUs are aggregated into STAP-A packets and NALUs larger than the
+// maximum payload size are fragmented into FU-A packets. The marker
+// bit is set on the last packet of the access unit.
 func (e *Encoder) Encode(nalus [][]byte, pts time.Duration) ([]*rtp.Packet, error) {
 	if e.PacketizationMode >= 2 {
 		return nil, ErrModeUnsupported
 	}
+	if e.PayloadMaxSize < minPayloadMaxSize {
+		return nil, ErrPayloadMaxSizeTooSmall
+	}
 
 	var rets []*rtp.Packet
 	var batch [][]byte
@@ -112,7 +146,7 @@ func (e *Encoder) Encode(nalus [][]byte, pts time.Duration) ([]*rtp.Packet, erro
 func (e *Encoder) writeBatch(nalus [][]byte, pts time.Duration, marker bool) ([]*rtp.Packet, error) {
 	if len(nalus) == 1 {
 		// the NALU fits into a single RTP packet
-		if len(nalus[0]) < e.PayloadMaxSize {
+		if len(nalus[0]) <= e.PayloadMaxSize {
 			return e.writeSingle(nalus[0], pts, marker)
 		}
 
@@ -144,11 +178,9 @@ func (e *Encoder) writeSingle(nalu []byte, pts time.Duration, marker bool) ([]*r
 func (e *Encoder) writeFragmented(nalu []byte, pts time.Duration, marker bool) ([]*rtp.Packet, error) {
 	// use only FU-A, not FU-B, since we always use non-interleaved mode
 	// (packetization-mode=1)
-	packetCount := (len(nalu) - 1) / (e.PayloadMaxSize - 2)
-	lastPacketSize := (len(nalu) - 1) % (e.PayloadMaxSize - 2)
-	if lastPacketSize > 0 {
-		packetCount++
-	}
+	fragmentSize := e.PayloadMaxSize - 2
+	packetCount := (len(nalu) - 1 + fragmentSize - 1) / fragmentSize
+	lastPacketSize := len(nalu) - 1 - (packetCount-1)*fragmentSize
 
 	ret := make([]*rtp.Packet, packetCount)
 	encPTS := e.encodeTimestamp(pts)
@@ -165,7 +197,7 @@ func (e *Encoder) writeFragmented(nalu []byte, pts time.Duration, marker bool) (
 			start = 1
 		}
 		end := uint8(0)
-		le := e.PayloadMaxSize - 2
+		le := fragmentSize
 		if i == (packetCount - 1) {
 			end = 1
 			le = lastPacketSize
@@ -2,7 +2,9 @@ package rtph264
 
 import (
 	"testing"
+	"time"
 
+	"github.com/pion/rtp"
 	"github.com/stretchr/testify/require"
 )
 
@@ -33,6 +35,20 @@ func TestEncode(t *testing.T) {
 	}
 }
 
+func TestEncodeClockRate(t *testing.T) {
+	initialTimestamp := uint32(0x88776655)
+	e := &Encoder{
+		PayloadType:      96,
+		InitialTimestamp: &initialTimestamp,
+		ClockRate:        1000,
+	}
+	e.Init()
+
+	pkts, err := e.Encode([][]byte{{0x05}}, 2*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, initialTimestamp+2000, pkts[0].Timestamp)
+}
+
 func TestEncodeRandomInitialState(t *testing.T) {
 	e := &Encoder{
 		PayloadType: 96,
@@ -42,3 +58,185 @@ func TestEncodeRandomInitialState(t *testing.T) {
 	require.NotEqual(t, nil, e.InitialSequenceNumber)
 	require.NotEqual(t, nil, e.InitialTimestamp)
 }
+
+func newTestNALU(typ byte, size int) []byte {
+	nalu := make([]byte, size)
+	nalu[0] = typ
+	for i := 1; i < size; i++ {
+		nalu[i] = byte(i)
+	}
+	return nalu
+}
+
+// decodeAccessUnit decodes the packets of a single access unit.
+func decodeAccessUnit(t *testing.T, d *Decoder, pkts []*rtp.Packet) ([][]byte, time.Duration) {
+	t.Helper()
+	for i, pkt := range pkts {
+		nalus, pts, err := d.DecodeUntilMarker(pkt)
+		if i != len(pkts)-1 {
+			require.ErrorIs(t, err, ErrMorePacketsNeeded)
+			continue
+		}
+		require.NoError(t, err)
+		return append([][]byte(nil), nalus...), pts
+	}
+	return nil, 0
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	cases := []struct {
+		name  string
+		nalus [][]byte
+		types []naluType
+	}{
+		{
+			"single",
+			[][]byte{newTestNALU(0x05, 1000)},
+			[]naluType{5},
+		},
+		{
+			"aggregated",
+			[][]byte{
+				newTestNALU(0x09, 2),
+				newTestNALU(0x07, 20),
+				newTestNALU(0x08, 4),
+			},
+			[]naluType{naluTypeSTAPA},
+		},
+		{
+			"fragmented",
+			[][]byte{newTestNALU(0x05, 5000)},
+			[]naluType{naluTypeFUA, naluTypeFUA, naluTypeFUA, naluTypeFUA},
+		},
+		{
+			"mixed",
+			[][]byte{
+				newTestNALU(0x07, 20),
+				newTestNALU(0x08, 4),
+				newTestNALU(0x05, 3000),
+				newTestNALU(0x06, 1450),
+				newTestNALU(0x01, 10),
+				newTestNALU(0x01, 10),
+			},
+			[]naluType{
+				naluTypeSTAPA,
+				naluTypeFUA, naluTypeFUA, naluTypeFUA,
+				6,
+				naluTypeSTAPA,
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &Encoder{PayloadType: 96}
+			e.Init()
+			require.Equal(t, 1460, e.PayloadMaxSize)
+
+			d := &Decoder{}
+			d.Init()
+
+			seq := *e.InitialSequenceNumber
+			for i, pts := range []time.Duration{0, 40 * time.Millisecond} {
+				pkts, err := e.Encode(tc.nalus, pts)
+				require.NoError(t, err)
+
+				var types []naluType
+				for j, pkt := range pkts {
+					types = append(types, naluType(pkt.Payload[0]&0x1f))
+					require.LessOrEqual(t, len(pkt.Payload), e.PayloadMaxSize)
+					require.Equal(t, j == len(pkts)-1, pkt.Marker)
+					require.Equal(t, seq, pkt.SequenceNumber)
+					require.Equal(t, *e.SSRC, pkt.SSRC)
+					require.Equal(t, *e.InitialTimestamp+uint32(i*3600), pkt.Timestamp)
+					seq++
+				}
+				require.Equal(t, tc.types, types)
+
+				nalus, decodedPTS := decodeAccessUnit(t, d, pkts)
+				require.Equal(t, tc.nalus, nalus)
+				require.Equal(t, pts, decodedPTS)
+			}
+		})
+	}
+}
+
+func TestEncodeMTU(t *testing.T) {
+	e := &Encoder{PayloadType: 96, MTU: 1200}
+	e.Init()
+	require.Equal(t, 1160, e.PayloadMaxSize)
+
+	pkts, err := e.Encode([][]byte{newTestNALU(0x05, 3000)}, 0)
+	require.NoError(t, err)
+	for _, pkt := range pkts {
+		require.LessOrEqual(t, pkt.MarshalSize(), 1200-20-8)
+	}
+
+	e = &Encoder{PayloadType: 96, PayloadMaxSize: 2}
+	e.Init()
+	_, err = e.Encode([][]byte{newTestNALU(0x05, 3)}, 0)
+	require.ErrorIs(t, err, ErrPayloadMaxSizeTooSmall)
+}
+
+func TestEncodeMTUBoundary(t *testing.T) {
+	const payloadMaxSize = 100
+	const fragmentSize = payloadMaxSize - 2
+
+	cases := []struct {
+		name  string
+		nalus [][]byte
+		sizes []int
+	}{
+		{
+			"singleExact",
+			[][]byte{newTestNALU(0x05, payloadMaxSize)},
+			[]int{payloadMaxSize},
+		},
+		{
+			"fragmentedOneOver",
+			[][]byte{newTestNALU(0x05, payloadMaxSize+1)},
+			[]int{payloadMaxSize, 2 + 2},
+		},
+		{
+			// The fragments are exactly full.
+			"fragmentedExact",
+			[][]byte{newTestNALU(0x05, 1+2*fragmentSize)},
+			[]int{payloadMaxSize, payloadMaxSize},
+		},
+		{
+			"fragmentedExactPlusOne",
+			[][]byte{newTestNALU(0x05, 2+2*fragmentSize)},
+			[]int{payloadMaxSize, payloadMaxSize, 2 + 1},
+		},
+		{
+			// Header + two size fields + NALUs.
+			"aggregatedExact",
+			[][]byte{newTestNALU(0x07, 50), newTestNALU(0x08, payloadMaxSize-1-2-2-50)},
+			[]int{payloadMaxSize},
+		},
+		{
+			"aggregatedOneOver",
+			[][]byte{newTestNALU(0x07, 50), newTestNALU(0x08, payloadMaxSize-1-2-2-50+1)},
+			[]int{50, payloadMaxSize - 1 - 2 - 2 - 50 + 1},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &Encoder{PayloadType: 96, PayloadMaxSize: payloadMaxSize}
+			e.Init()
+
+			pkts, err := e.Encode(tc.nalus, 0)
+			require.NoError(t, err)
+
+			var sizes []int
+			for _, pkt := range pkts {
+				sizes = append(sizes, len(pkt.Payload))
+			}
+			require.Equal(t, tc.sizes, sizes)
+
+			d := &Decoder{}
+			d.Init()
+			nalus, _ := decodeAccessUnit(t, d, pkts)
+			require.Equal(t, tc.nalus, nalus)
+		})
+	}
+}
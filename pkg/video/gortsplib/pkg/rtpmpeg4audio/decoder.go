@@ -32,8 +32,11 @@ type Decoder struct {
 	timeDecoder    *rtptimedec.Decoder
 	firstAUParsed  bool
 	adtsMode       bool
-	fragments      [][]byte
 	fragmentedSize int
+
+	// Fragments are copied since the packet payload
+	// may be reused after Decode returns.
+	fragments [][]byte
 }
 
 // Init initializes the decoder.
@@ -65,6 +68,7 @@ func (e AUsizeToBigError) Error() string {
 // Decode decodes AUs from a RTP/MPEG4-audio packet.
 // It returns the AUs and the PTS of the first AU.
 // The PTS of subsequent AUs can be calculated by adding time.Second*mpeg4audio.SamplesPerAccessUnit/clockRate.
+// AUs that aren't fragmented reference the payload of the packet.
 func (d *Decoder) Decode(pkt *rtp.Packet) ([][]byte, time.Duration, error) {
 	if len(pkt.Payload) < 2 {
 		d.fragments = d.fragments[:0]
@@ -115,7 +119,7 @@ func (d *Decoder) decodeFragmented(
 		return nil, 0, AUsizeToBigError{AUsize: d.fragmentedSize}
 	}
 
-	d.fragments = append(d.fragments, payload[:dataLens[0]])
+	d.fragments = append(d.fragments, append([]byte(nil), payload[:dataLens[0]]...))
 
 	if !pkt.Header.Marker {
 		return nil, 0, ErrMorePacketsNeeded
@@ -174,7 +178,7 @@ func (d *Decoder) decodeUnfragmented(
 	}
 
 	d.fragmentedSize = int(dataLens[0])
-	d.fragments = append(d.fragments, payload[:dataLens[0]])
+	d.fragments = append(d.fragments, append([]byte(nil), payload[:dataLens[0]]...))
 	return nil, 0, ErrMorePacketsNeeded
 }
 
@@ -132,10 +132,7 @@ func (e *Encoder) writeBatch(aus [][]byte, pts time.Duration) ([]*rtp.Packet, er
 
 func (e *Encoder) writeFragmented(au []byte, pts time.Duration) ([]*rtp.Packet, error) {
 	auHeadersLen := e.SizeLength + e.IndexLength
-	auHeadersLenBytes := auHeadersLen / 8
-	if (auHeadersLen % 8) != 0 {
-		auHeadersLenBytes++
-	}
+	auHeadersLenBytes := bits.BytesNeeded(auHeadersLen)
 	auMaxSize := e.PayloadMaxSize - 2 - auHeadersLenBytes
 	packetCount := len(au) / auMaxSize
 	lastPacketSize := len(au) % auMaxSize
@@ -208,10 +205,7 @@ func (e *Encoder) lenAggregated(aus [][]byte, addAU []byte) int {
 			auHeadersLen += e.SizeLength + e.IndexDeltaLength
 		}
 	}
-	ret += auHeadersLen / 8
-	if (auHeadersLen % 8) != 0 {
-		ret++
-	}
+	ret += bits.BytesNeeded(auHeadersLen)
 
 	// AU
 	for _, au := range aus {
@@ -239,10 +233,7 @@ func (e *Encoder) writeAggregated(aus [][]byte, pts time.Duration) ([]*rtp.Packe
 			written += e.IndexDeltaLength
 		}
 	}
-	pos = 2 + (written / 8)
-	if (written % 8) != 0 {
-		pos++
-	}
+	pos = 2 + bits.BytesNeeded(written)
 
 	// AU-headers-length
 	payload[0] = byte(written >> 8)
@@ -3,6 +3,7 @@ package url
 import (
 	"errors"
 	"fmt"
+	"net"
 	"net/url"
 	"regexp"
 	"strings"
@@ -82,8 +83,8 @@ func (u *URL) CloneWithoutCredentials() *URL {
 	})
 }
 
-// RTSPPath returns the path of a RTSP URL.
-func (u *URL) RTSPPath() (string, bool) {
+// RTSPPathAndQuery returns the path and query of a RTSP URL.
+func (u *URL) RTSPPathAndQuery() (string, bool) {
 	var pathAndQuery string
 	if u.RawPath != "" {
 		pathAndQuery = u.RawPath
@@ -98,11 +99,21 @@ func (u *URL) RTSPPath() (string, bool) {
 	if len(pathAndQuery) == 0 || pathAndQuery[0] != '/' {
 		return "", false
 	}
-	pathAndQuery = pathAndQuery[1:]
+	return pathAndQuery[1:], true
+}
 
-	path := removeQuery(pathAndQuery)
+// Query parses the query of a RTSP URL. Malformed pairs are discarded.
+func (u *URL) Query() url.Values {
+	return (*url.URL)(u).Query()
+}
 
-	return path, true
+// RTSPPath returns the path of a RTSP URL.
+func (u *URL) RTSPPath() (string, bool) {
+	pathAndQuery, ok := u.RTSPPathAndQuery()
+	if !ok {
+		return "", false
+	}
+	return removeQuery(pathAndQuery), true
 }
 
 func removeQuery(pathAndQuery string) string {
@@ -112,3 +123,59 @@ func removeQuery(pathAndQuery string) string {
 	}
 	return pathAndQuery
 }
+
+// FormatHost returns the host in the form used by URLs, IPv6 literals
+// without a port are enclosed in brackets. Hosts that are already
+// bracketed or that have a port are returned unchanged.
+func FormatHost(host string) string {
+	if strings.HasPrefix(host, "[") {
+		return host
+	}
+	if strings.Contains(host, ":") && net.ParseIP(removeZone(host)) != nil {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// HostsEqual reports whether two URL hosts refer to the same host and
+// port. IP addresses are compared by value, "[::1]:554" and
+// "[0:0:0:0:0:0:0:1]:554" are equal. Host names are case insensitive.
+func HostsEqual(a, b string) bool {
+	hostA, portA := splitHost(a)
+	hostB, portB := splitHost(b)
+	if portA != portB {
+		return false
+	}
+	if strings.EqualFold(hostA, hostB) {
+		return true
+	}
+
+	ipA, ipB := net.ParseIP(removeZone(hostA)), net.ParseIP(removeZone(hostB))
+	if ipA == nil || ipB == nil || !ipA.Equal(ipB) {
+		return false
+	}
+	return zone(hostA) == zone(hostB)
+}
+
+// splitHost splits a URL host into host and port, the port is optional.
+func splitHost(hostport string) (string, string) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]"), ""
+	}
+	return host, port
+}
+
+func removeZone(host string) string {
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		return host[:i]
+	}
+	return host
+}
+
+func zone(host string) string {
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		return host[i+1:]
+	}
+	return ""
+}
@@ -3,12 +3,20 @@ package gortsplib
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"math"
 	"net"
 	"nvr/pkg/video/gortsplib/pkg/base"
+	"nvr/pkg/video/gortsplib/pkg/conn"
+	"nvr/pkg/video/gortsplib/pkg/headers"
 	"nvr/pkg/video/gortsplib/pkg/liberrors"
+	"nvr/pkg/video/gortsplib/pkg/url"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,35 +24,144 @@ import (
 )
 
 // ServerHandler is the interface implemented by all the server handlers.
+// The optional methods are detected with the ServerHandlerOn* interfaces,
+// requests for methods that the handler doesn't implement are rejected
+// and the methods are left out of the Public header.
 type ServerHandler interface {
+	// OnRequest is called before each request is handled. If a
+	// response is returned, it's sent to the client as is and
+	// the request isn't processed further.
+	OnRequest(*ServerConn, *base.Request) (*base.Response, error)
 	OnConnClose(*ServerConn, error)
 	OnSessionOpen(*ServerSession, *ServerConn, string)
 	OnSessionClose(*ServerSession, error)
 	OnDescribe(pathName string) (*base.Response, *ServerStream, error)
-	OnAnnounce(*ServerSession, string, Tracks) (*base.Response, error)
 	OnSetup(*ServerSession, string, int) (*base.Response, *ServerStream, error)
-	OnPlay(*ServerSession) (*base.Response, error)
-	OnRecord(*ServerSession) (*base.Response, error)
+	// OnPacketRTP is called with every received RTP packet. The packet
+	// is only valid until the function returns, the payload is reused.
 	OnPacketRTP(*ServerSession, int, *rtp.Packet)
 	OnDecodeError(*ServerSession, error)
+	// OnLimitReached is called when a connection or
+	// session is rejected because a limit was reached.
+	OnLimitReached(net.Addr, error)
+}
+
+// ServerHandlerOnAnnounce can be implemented by a ServerHandler to accept publishers.
+type ServerHandlerOnAnnounce interface {
+	OnAnnounce(*ServerSession, string, Tracks) (*base.Response, error)
+}
+
+// ServerHandlerOnPlay can be implemented by a ServerHandler to serve readers.
+type ServerHandlerOnPlay interface {
+	// OnPlay is called with the requested range, the
+	// range is "npt=now-" if the request has none.
+	OnPlay(*ServerSession, headers.Range) (*base.Response, error)
+}
+
+// ServerHandlerOnRecord can be implemented by a ServerHandler to accept publishers.
+type ServerHandlerOnRecord interface {
+	OnRecord(*ServerSession) (*base.Response, error)
+}
+
+// ServerHandlerOnPause can be implemented by a ServerHandler to support PAUSE.
+// The session returns to the pre play or pre record state if the response is OK.
+type ServerHandlerOnPause interface {
+	OnPause(*ServerSession) (*base.Response, error)
+}
+
+// ServerHandlerOnGetParameter can be implemented by a ServerHandler
+// to support GET_PARAMETER, clients often use it as a keepalive.
+type ServerHandlerOnGetParameter interface {
+	OnGetParameter(*ServerSession, string, *base.Request) (*base.Response, error)
+}
+
+// ServerHandlerOnSetParameter can be implemented by a ServerHandler to support SET_PARAMETER.
+type ServerHandlerOnSetParameter interface {
+	OnSetParameter(*ServerSession, string, *base.Request) (*base.Response, error)
+}
+
+// serverMethods returns the methods supported by the handler in the
+// order of the Public header. The methods are the same in every state,
+// requests in the wrong state are rejected by the session.
+func serverMethods(h ServerHandler) []base.Method {
+	methods := []base.Method{base.Options, base.Describe}
+	if _, ok := h.(ServerHandlerOnAnnounce); ok {
+		methods = append(methods, base.Announce)
+	}
+	methods = append(methods, base.Setup)
+	if _, ok := h.(ServerHandlerOnPlay); ok {
+		methods = append(methods, base.Play)
+	}
+	if _, ok := h.(ServerHandlerOnRecord); ok {
+		methods = append(methods, base.Record)
+	}
+	if _, ok := h.(ServerHandlerOnPause); ok {
+		methods = append(methods, base.Pause)
+	}
+	if _, ok := h.(ServerHandlerOnGetParameter); ok {
+		methods = append(methods, base.GetParameter)
+	}
+	if _, ok := h.(ServerHandlerOnSetParameter); ok {
+		methods = append(methods, base.SetParameter)
+	}
+	return append(methods, base.Teardown)
+}
+
+func serverSupportsMethod(h ServerHandler, method base.Method) bool {
+	for _, m := range serverMethods(h) {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// serverPublicHeader returns the Public header of OPTIONS responses.
+func serverPublicHeader(h ServerHandler) base.HeaderValue {
+	methods := serverMethods(h)
+	names := make([]string, len(methods))
+	for i, m := range methods {
+		names[i] = string(m)
+	}
+	return base.HeaderValue{strings.Join(names, ", ")}
+}
+
+// Number of random bytes in a session ID, RFC 2326 requires at least
+// 8 bytes of randomness. IDs are hex encoded to only use safe characters.
+const sessionIDSize = 16
+
+// sessionKey is the key of a session in the session map. IDs are hashed
+// so that looking up a client supplied ID doesn't compare its content.
+type sessionKey [sha256.Size]byte
+
+func newSessionKey(id string) sessionKey {
+	return sha256.Sum256([]byte(id))
 }
 
-func newSessionSecretID(sessions map[string]*ServerSession) (string, error) {
+// newSessionSecretID returns a random ID that isn't used by another session.
+func newSessionSecretID(
+	sessions map[sessionKey]*ServerSession,
+	randRead func([]byte) (int, error),
+) (string, error) {
 	for {
-		b := make([]byte, 4)
-		_, err := rand.Read(b)
-		if err != nil {
+		b := make([]byte, sessionIDSize)
+		if _, err := randRead(b); err != nil {
 			return "", err
 		}
 
-		id := strconv.FormatUint(uint64(binary.LittleEndian.Uint32(b)), 10)
-
-		if _, ok := sessions[id]; !ok {
+		id := hex.EncodeToString(b)
+		if _, ok := sessions[newSessionKey(id)]; !ok {
 			return id, nil
 		}
 	}
 }
 
+func randUint32() uint32 {
+	var b [4]byte
+	rand.Read(b[:]) //nolint:errcheck
+	return binary.BigEndian.Uint32(b[:])
+}
+
 type sessionRequestRes struct {
 	ss  *ServerSession
 	res *base.Response
@@ -90,21 +207,58 @@ type Server struct {
 	sessionTimeout    time.Duration
 	checkStreamPeriod time.Duration
 
+	// Period of RTCP receiver reports sent to publishers.
+	receiverReportPeriod time.Duration
+
+	// Maximum size of request bodies, larger
+	// requests are rejected and the connection closed.
+	maxBodySize int64
+
+	// Connection and session limits.
+	limits ServerLimits
+
+	// Rate limit of reading sessions.
+	writeRate ServerWriteRate
+
+	// Called with the requests and responses of every connection.
+	debugHooks ServerDebugHooks
+
+	// Disables the host replacement of announced track controls.
+	strictTrackControls bool
+
+	// Interleaved frames are read into pooled buffers.
+	framePool  *conn.FramePool
+	frameDebug bool
+
 	ctx         context.Context
 	ctxCancel   func()
 	wg          sync.WaitGroup
 	tcpListener net.Listener
-	sessions    map[string]*ServerSession
+	sessions    map[sessionKey]*ServerSession
 	conns       map[*ServerConn]struct{}
 	closeError  error
+	limiter     *serverLimiter
+	writes      *writeScheduler
+
+	// Drain state, guarded by drainMu.
+	drainMu       sync.Mutex
+	draining      bool
+	redirectHost  string
+	drainDeadline time.Time
+
+	// Closed when draining and all sessions have closed.
+	drained chan struct{}
 
 	// in
 	connClose      chan *ServerConn
 	sessionRequest chan sessionRequestReq
 	sessionClose   chan *ServerSession
+	drainStart     chan time.Duration
 }
 
-// NewServer creates a new RTSP server.
+// NewServer creates a new RTSP server. The address is a "host:port"
+// pair, IPv6 hosts must be enclosed in brackets. An empty or
+// unspecified host listens on both IPv4 and IPv6.
 func NewServer(
 	handler ServerHandler,
 	readTimeout time.Duration,
@@ -123,9 +277,31 @@ func NewServer(
 	}
 }
 
+// SetLimits sets the connection and session limits.
+// Must be called before Start.
+func (s *Server) SetLimits(limits ServerLimits) {
+	s.limits = limits
+}
+
+// SetStrictTrackControls disables the replacement of the host of
+// announced track controls that point at another host, see TrackURLBase.
+// Must be called before Start.
+func (s *Server) SetStrictTrackControls(strict bool) {
+	s.strictTrackControls = strict
+}
+
+// Stats returns the current connection and session counts.
+func (s *Server) Stats() ServerStats {
+	if s.limiter == nil {
+		return ServerStats{}
+	}
+	return s.limiter.getStats()
+}
+
 // Errors.
 var (
 	ErrServerMissingRTSPaddress = errors.New("RTSPAddress not provided")
+	ErrServerInvalidRTSPaddress = errors.New("invalid RTSPAddress")
 	ErrWriteBufferSize          = errors.New("WriteBufferCount must be a power of two")
 )
 
@@ -160,10 +336,19 @@ func (s *Server) Start() error {
 	if s.checkStreamPeriod == 0 {
 		s.checkStreamPeriod = 1 * time.Second
 	}
+	if s.receiverReportPeriod == 0 {
+		s.receiverReportPeriod = 10 * time.Second
+	}
+	if s.maxBodySize == 0 {
+		s.maxBodySize = base.DefaultMaxBodySize
+	}
 
 	if s.rtspAddress == "" {
 		return ErrServerMissingRTSPaddress
 	}
+	if _, _, err := net.SplitHostPort(s.rtspAddress); err != nil {
+		return fmt.Errorf("%w: %w", ErrServerInvalidRTSPaddress, err)
+	}
 
 	var err error
 	s.tcpListener, err = s.listen("tcp", s.rtspAddress)
@@ -172,6 +357,11 @@ func (s *Server) Start() error {
 	}
 
 	s.ctx, s.ctxCancel = context.WithCancel(context.Background())
+	s.limiter = newServerLimiter(s.limits)
+	s.writes = newWriteScheduler(writeStallTimeout)
+	s.framePool = conn.NewFramePool(conn.MaxFramePayloadSize, s.frameDebug)
+	s.drained = make(chan struct{})
+	s.drainStart = make(chan time.Duration)
 
 	s.wg.Add(1)
 	go s.run()
@@ -180,7 +370,23 @@ func (s *Server) Start() error {
 }
 
 // Close closes all the server resources and waits for them to close.
+// If the server is draining, Close first waits for the sessions
+// to finish, up to the end of the grace period.
 func (s *Server) Close() error {
+	s.drainMu.Lock()
+	draining, deadline := s.draining, s.drainDeadline
+	s.drainMu.Unlock()
+
+	if draining {
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-s.drained:
+		case <-timer.C:
+		case <-s.ctx.Done():
+		}
+		timer.Stop()
+	}
+
 	s.ctxCancel()
 	s.wg.Wait()
 	return s.closeError
@@ -196,10 +402,83 @@ func (s *Server) Wait() error {
 // ErrServerInternalError internal error.
 var ErrServerInternalError = errors.New("internal error")
 
+// Drain errors.
+var (
+	ErrServerDraining = errors.New("server is draining")
+	ErrServerDrained  = errors.New("server drained")
+)
+
+// Drain puts the server in maintenance mode. New SETUP and ANNOUNCE
+// requests are redirected to redirectHost, or rejected with 503 and
+// Retry-After if redirectHost is empty. Existing sessions are closed
+// at the end of the grace period, sessions that have a path are sent
+// a REDIRECT request first if redirectHost is set. IPv6 redirect
+// hosts with a port must be enclosed in brackets.
+// Calling Drain on a draining server does nothing.
+func (s *Server) Drain(redirectHost string, gracePeriod time.Duration) {
+	s.drainMu.Lock()
+	if s.draining {
+		s.drainMu.Unlock()
+		return
+	}
+	s.draining = true
+	s.redirectHost = url.FormatHost(redirectHost)
+	s.drainDeadline = time.Now().Add(gracePeriod)
+	s.drainMu.Unlock()
+
+	select {
+	case s.drainStart <- gracePeriod:
+	case <-s.ctx.Done():
+	}
+}
+
+// Draining returns true if Drain has been called.
+func (s *Server) Draining() bool {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	return s.draining
+}
+
+func (s *Server) getRedirectHost() string {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	return s.redirectHost
+}
+
+// drainResponse returns the response to requests
+// that would create a new session while draining.
+func (s *Server) drainResponse(req *base.Request) *base.Response {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	if s.redirectHost != "" {
+		u := req.URL.Clone()
+		u.User = nil
+		u.Host = s.redirectHost
+		return &base.Response{
+			StatusCode: base.StatusFound,
+			Header: base.Header{
+				"Location": base.HeaderValue{u.String()},
+			},
+		}
+	}
+
+	retryAfter := int64(math.Ceil(time.Until(s.drainDeadline).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	return &base.Response{
+		StatusCode: base.StatusServiceUnavailable,
+		Header: base.Header{
+			"Retry-After": base.HeaderValue{strconv.FormatInt(retryAfter, 10)},
+		},
+	}
+}
+
 func (s *Server) run() { //nolint:funlen,gocognit
 	defer s.wg.Done()
 
-	s.sessions = make(map[string]*ServerSession)
+	s.sessions = make(map[sessionKey]*ServerSession)
 	s.conns = make(map[*ServerConn]struct{})
 	s.connClose = make(chan *ServerConn)
 	s.sessionRequest = make(chan sessionRequestReq)
@@ -231,6 +510,19 @@ func (s *Server) run() { //nolint:funlen,gocognit
 		}
 	}()
 
+	var drainTimer *time.Timer
+	var gracePeriodEnd <-chan time.Time
+	draining := false
+	checkDrained := func() {
+		if draining && len(s.sessions) == 0 {
+			select {
+			case <-s.drained:
+			default:
+				close(s.drained)
+			}
+		}
+	}
+
 	s.closeError = func() error {
 		for {
 			select {
@@ -238,6 +530,11 @@ func (s *Server) run() { //nolint:funlen,gocognit
 				return err
 
 			case nconn := <-connNew:
+				if err := s.limiter.connOpen(nconn.RemoteAddr(), nconn.LocalAddr()); err != nil {
+					s.wg.Add(1)
+					go s.rejectConn(nconn, err)
+					continue
+				}
 
 				sc := newServerConn(s, nconn)
 				s.conns[sc] = struct{}{}
@@ -247,10 +544,11 @@ func (s *Server) run() { //nolint:funlen,gocognit
 					continue
 				}
 				delete(s.conns, sc)
+				s.limiter.connClose(sc.remoteAddr, sc.localAddr)
 				sc.Close()
 
 			case req := <-s.sessionRequest:
-				if ss, ok := s.sessions[req.id]; ok {
+				if ss, ok := s.sessions[newSessionKey(req.id)]; ok {
 					if !req.sc.ip().Equal(ss.author.ip()) ||
 						req.sc.zone() != ss.author.zone() {
 						req.res <- sessionRequestRes{
@@ -273,7 +571,9 @@ func (s *Server) run() { //nolint:funlen,gocognit
 						}
 					}
 				} else {
-					if !req.create {
+					// IDs are only assigned by the server, a client
+					// supplied ID that isn't in use is never reused.
+					if !req.create || req.id != "" {
 						req.res <- sessionRequestRes{
 							res: &base.Response{
 								StatusCode: base.StatusSessionNotFound,
@@ -283,8 +583,25 @@ func (s *Server) run() { //nolint:funlen,gocognit
 						continue
 					}
 
-					secretID, err := newSessionSecretID(s.sessions)
+					if draining {
+						req.res <- sessionRequestRes{
+							res: s.drainResponse(req.req),
+							err: ErrServerDraining,
+						}
+						continue
+					}
+
+					if err := s.limiter.sessionOpen(req.sc); err != nil {
+						req.res <- sessionRequestRes{
+							res: limitResponse(),
+							err: err,
+						}
+						continue
+					}
+
+					secretID, err := newSessionSecretID(s.sessions, rand.Read)
 					if err != nil {
+						s.limiter.sessionClose(req.sc)
 						req.res <- sessionRequestRes{
 							res: &base.Response{
 								StatusCode: base.StatusBadRequest,
@@ -296,6 +613,7 @@ func (s *Server) run() { //nolint:funlen,gocognit
 
 					name, ok := req.req.URL.RTSPPath()
 					if !ok {
+						s.limiter.sessionClose(req.sc)
 						req.res <- sessionRequestRes{
 							res: &base.Response{
 								StatusCode: base.StatusBadRequest,
@@ -306,7 +624,7 @@ func (s *Server) run() { //nolint:funlen,gocognit
 					}
 
 					ss := newServerSession(s, secretID, req.sc, name)
-					s.sessions[secretID] = ss
+					s.sessions[newSessionKey(secretID)] = ss
 
 					select {
 					case ss.request <- req:
@@ -321,11 +639,25 @@ func (s *Server) run() { //nolint:funlen,gocognit
 				}
 
 			case ss := <-s.sessionClose:
-				if sss, ok := s.sessions[ss.secretID]; !ok || sss != ss {
+				key := newSessionKey(ss.secretID)
+				if sss, ok := s.sessions[key]; !ok || sss != ss {
 					continue
 				}
-				delete(s.sessions, ss.secretID)
+				delete(s.sessions, key)
+				s.limiter.sessionClose(ss.author)
 				ss.Close()
+				checkDrained()
+
+			case gracePeriod := <-s.drainStart:
+				draining = true
+				drainTimer = time.NewTimer(gracePeriod)
+				gracePeriodEnd = drainTimer.C
+				checkDrained()
+
+			case <-gracePeriodEnd:
+				for _, ss := range s.sessions {
+					ss.drain()
+				}
 
 			case <-s.ctx.Done():
 				return context.Canceled
@@ -335,9 +667,27 @@ func (s *Server) run() { //nolint:funlen,gocognit
 
 	s.ctxCancel()
 
+	if drainTimer != nil {
+		drainTimer.Stop()
+	}
+
 	s.tcpListener.Close()
 }
 
+// rejectConn closes a connection that was rejected at accept time.
+func (s *Server) rejectConn(nconn net.Conn, err error) {
+	defer s.wg.Done()
+	defer nconn.Close()
+
+	if s.limits.RejectWithResponse {
+		nconn.SetWriteDeadline(time.Now().Add(s.writeTimeout)) //nolint:errcheck
+		res := limitResponse()
+		res.Header["Server"] = base.HeaderValue{"gortsplib"}
+		conn.NewConn(nconn).WriteResponse(res) //nolint:errcheck
+	}
+	s.handler.OnLimitReached(nconn.RemoteAddr(), err)
+}
+
 // StartAndWait starts the server and waits until a fatal error.
 func (s *Server) StartAndWait() error {
 	err := s.Start()
@@ -0,0 +1,90 @@
+package gortsplib
+
+import (
+	"net"
+	"nvr/pkg/video/gortsplib/pkg/base"
+	"nvr/pkg/video/gortsplib/pkg/url"
+	"strings"
+)
+
+// ServerDebugHooks are called with the requests and responses of every
+// connection, used for protocol level debugging. Interleaved frames are
+// never passed to the hooks. The hooks receive copies where the values
+// of the Authorization header are redacted.
+type ServerDebugHooks struct {
+	// OnRequest is called before the request is handled.
+	OnRequest func(remoteAddr net.Addr, req *base.Request)
+
+	// OnResponse is called after the response to the request is written.
+	OnResponse func(remoteAddr net.Addr, req *base.Request, res *base.Response)
+}
+
+// RedactedAuthorization replaces the credentials of Authorization headers.
+const RedactedAuthorization = "REDACTED"
+
+// SetDebugHooks sets the debug hooks. Must be called before Start.
+func (s *Server) SetDebugHooks(hooks ServerDebugHooks) {
+	s.debugHooks = hooks
+}
+
+// SetFrameDebug enables the debug mode of the interleaved frame pool, used
+// to detect handlers that keep packets after OnPacketRTP returns. Released
+// buffers are poisoned and misuse causes a panic. Must be called before Start.
+func (s *Server) SetFrameDebug(debug bool) {
+	s.frameDebug = debug
+}
+
+func (sc *ServerConn) debugRequest(req *base.Request) {
+	if hook := sc.s.debugHooks.OnRequest; hook != nil {
+		hook(sc.remoteAddr, redactRequest(req))
+	}
+}
+
+func (sc *ServerConn) debugResponse(req *base.Request, res *base.Response) {
+	if hook := sc.s.debugHooks.OnResponse; hook != nil {
+		hook(sc.remoteAddr, redactRequest(req), copyResponse(res))
+	}
+}
+
+// redactRequest returns a copy of the request where the credentials
+// are removed from the URL and replaced in the Authorization header.
+// The authorization scheme is kept.
+func redactRequest(req *base.Request) *base.Request {
+	header := copyHeader(req.Header)
+	if values, exist := header["Authorization"]; exist {
+		redacted := make(base.HeaderValue, len(values))
+		for i, v := range values {
+			scheme, _, _ := strings.Cut(v, " ")
+			redacted[i] = scheme + " " + RedactedAuthorization
+		}
+		header["Authorization"] = redacted
+	}
+
+	var u *url.URL
+	if req.URL != nil {
+		u = req.URL.CloneWithoutCredentials()
+	}
+	return &base.Request{
+		Method: req.Method,
+		URL:    u,
+		Header: header,
+		Body:   append([]byte(nil), req.Body...),
+	}
+}
+
+func copyResponse(res *base.Response) *base.Response {
+	return &base.Response{
+		StatusCode:    res.StatusCode,
+		StatusMessage: res.StatusMessage,
+		Header:        copyHeader(res.Header),
+		Body:          append([]byte(nil), res.Body...),
+	}
+}
+
+func copyHeader(h base.Header) base.Header {
+	c := make(base.Header, len(h))
+	for key, values := range h {
+		c[key] = append(base.HeaderValue(nil), values...)
+	}
+	return c
+}
@@ -0,0 +1,218 @@
+package gortsplib
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"nvr/pkg/video/gortsplib/pkg/base"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Default limits, high enough to not affect normal use.
+const (
+	DefaultServerMaxConns           = 1024
+	DefaultServerMaxSessions        = 512
+	DefaultServerMaxSessionsPerConn = 8
+	DefaultServerMaxConnsPerIP      = 128
+)
+
+// Clients that hit a limit are told to retry after this duration.
+const limitRetryAfter = 5 * time.Second
+
+// Limit errors.
+var (
+	ErrServerMaxConns           = errors.New("too many connections")
+	ErrServerMaxConnsPerIP      = errors.New("too many connections from the same IP")
+	ErrServerMaxSessions        = errors.New("too many sessions")
+	ErrServerMaxSessionsPerConn = errors.New("too many sessions created by the connection")
+)
+
+// ServerLimits bounds the number of connections and sessions.
+// Zero values are replaced by the defaults, negative values
+// disable the limit.
+type ServerLimits struct {
+	MaxConns           int
+	MaxSessions        int
+	MaxSessionsPerConn int
+	MaxConnsPerIP      int
+
+	// Write a 503 response to connections that
+	// are rejected at accept time before closing them.
+	RejectWithResponse bool
+
+	// Connections from the same host are not limited and are not
+	// counted, see ServerConn.IsLocal. Used by the monitor pipeline.
+	ExemptLoopback bool
+}
+
+func (l *ServerLimits) setDefaults() {
+	setDefault := func(v *int, def int) {
+		if *v == 0 {
+			*v = def
+		}
+	}
+	setDefault(&l.MaxConns, DefaultServerMaxConns)
+	setDefault(&l.MaxSessions, DefaultServerMaxSessions)
+	setDefault(&l.MaxSessionsPerConn, DefaultServerMaxSessionsPerConn)
+	setDefault(&l.MaxConnsPerIP, DefaultServerMaxConnsPerIP)
+}
+
+// ServerStats current connection and session counts.
+type ServerStats struct {
+	Conns    int
+	Sessions int
+
+	// Connections and sessions rejected by the limits.
+	RejectedConns    uint64
+	RejectedSessions uint64
+}
+
+// serverLimiter the counters are only modified by the server loop.
+type serverLimiter struct {
+	limits ServerLimits
+
+	conns           int
+	connsPerIP      map[string]int
+	sessions        int
+	sessionsPerConn map[*ServerConn]int
+
+	mu    sync.Mutex
+	stats ServerStats
+}
+
+func newServerLimiter(limits ServerLimits) *serverLimiter {
+	limits.setDefaults()
+	return &serverLimiter{
+		limits:          limits,
+		connsPerIP:      make(map[string]int),
+		sessionsPerConn: make(map[*ServerConn]int),
+	}
+}
+
+// isLocalAddr returns true if the remote address is a loopback address
+// or the local address. Clients on the same host that connect to the
+// address the server is bound to use it as the source address.
+func isLocalAddr(remote net.Addr, local net.Addr) bool {
+	remoteTCP, ok := remote.(*net.TCPAddr)
+	if !ok || remoteTCP == nil {
+		return false
+	}
+	if remoteTCP.IP.IsLoopback() {
+		return true
+	}
+	localTCP, ok := local.(*net.TCPAddr)
+	return ok && localTCP != nil && remoteTCP.IP.Equal(localTCP.IP)
+}
+
+// limitKey returns false if the address is exempt.
+func (l *serverLimiter) limitKey(remote net.Addr, local net.Addr) (string, bool) {
+	tcpAddr, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return remote.String(), true
+	}
+	if l.limits.ExemptLoopback && isLocalAddr(remote, local) {
+		return "", false
+	}
+	ip := tcpAddr.IP.String()
+	if tcpAddr.Zone != "" {
+		ip += "%" + tcpAddr.Zone
+	}
+	return ip, true
+}
+
+func exceeds(count int, limit int) bool {
+	return limit >= 0 && count >= limit
+}
+
+// connOpen counts the connection or returns the limit that was hit.
+func (l *serverLimiter) connOpen(remote net.Addr, local net.Addr) error {
+	ip, limited := l.limitKey(remote, local)
+	if limited {
+		if exceeds(l.conns, l.limits.MaxConns) {
+			l.updateStats(func(s *ServerStats) { s.RejectedConns++ })
+			return fmt.Errorf("%w: limit %d", ErrServerMaxConns, l.limits.MaxConns)
+		}
+		if exceeds(l.connsPerIP[ip], l.limits.MaxConnsPerIP) {
+			l.updateStats(func(s *ServerStats) { s.RejectedConns++ })
+			return fmt.Errorf("%w: limit %d", ErrServerMaxConnsPerIP, l.limits.MaxConnsPerIP)
+		}
+		l.conns++
+		l.connsPerIP[ip]++
+	}
+	l.updateStats(func(s *ServerStats) { s.Conns++ })
+	return nil
+}
+
+func (l *serverLimiter) connClose(remote net.Addr, local net.Addr) {
+	ip, limited := l.limitKey(remote, local)
+	if limited {
+		l.conns--
+		l.connsPerIP[ip]--
+		if l.connsPerIP[ip] <= 0 {
+			delete(l.connsPerIP, ip)
+		}
+	}
+	l.updateStats(func(s *ServerStats) { s.Conns-- })
+}
+
+// sessionOpen counts the session or returns the limit that was hit.
+func (l *serverLimiter) sessionOpen(author *ServerConn) error {
+	if _, limited := l.limitKey(author.remoteAddr, author.localAddr); limited {
+		if exceeds(l.sessions, l.limits.MaxSessions) {
+			l.updateStats(func(s *ServerStats) { s.RejectedSessions++ })
+			return fmt.Errorf("%w: limit %d", ErrServerMaxSessions, l.limits.MaxSessions)
+		}
+		if exceeds(l.sessionsPerConn[author], l.limits.MaxSessionsPerConn) {
+			l.updateStats(func(s *ServerStats) { s.RejectedSessions++ })
+			return fmt.Errorf("%w: limit %d",
+				ErrServerMaxSessionsPerConn, l.limits.MaxSessionsPerConn)
+		}
+		l.sessions++
+		l.sessionsPerConn[author]++
+	}
+	l.updateStats(func(s *ServerStats) { s.Sessions++ })
+	return nil
+}
+
+func (l *serverLimiter) sessionClose(author *ServerConn) {
+	if _, limited := l.limitKey(author.remoteAddr, author.localAddr); limited {
+		l.sessions--
+		l.sessionsPerConn[author]--
+		if l.sessionsPerConn[author] <= 0 {
+			delete(l.sessionsPerConn, author)
+		}
+	}
+	l.updateStats(func(s *ServerStats) { s.Sessions-- })
+}
+
+func (l *serverLimiter) updateStats(fn func(*ServerStats)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fn(&l.stats)
+}
+
+func (l *serverLimiter) getStats() ServerStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+func isLimitError(err error) bool {
+	return errors.Is(err, ErrServerMaxConns) ||
+		errors.Is(err, ErrServerMaxConnsPerIP) ||
+		errors.Is(err, ErrServerMaxSessions) ||
+		errors.Is(err, ErrServerMaxSessionsPerConn)
+}
+
+func limitResponse() *base.Response {
+	return &base.Response{
+		StatusCode: base.StatusServiceUnavailable,
+		Header: base.Header{
+			"Retry-After": base.HeaderValue{
+				strconv.Itoa(int(limitRetryAfter.Seconds())),
+			},
+		},
+	}
+}
@@ -0,0 +1,341 @@
+package gortsplib
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"nvr/pkg/video/gortsplib/pkg/base"
+	"nvr/pkg/video/gortsplib/pkg/conn"
+	"nvr/pkg/video/gortsplib/pkg/headers"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerLimiter(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.ParseIP("192.168.1.2"), Port: 1000}
+	remote2 := &net.TCPAddr{IP: net.ParseIP("192.168.1.3"), Port: 1000}
+	loopback := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1000}
+	server := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 554}
+
+	t.Run("defaults", func(t *testing.T) {
+		l := newServerLimiter(ServerLimits{MaxConns: 5, MaxSessions: -1})
+		require.Equal(t, ServerLimits{
+			MaxConns:           5,
+			MaxSessions:        -1,
+			MaxSessionsPerConn: DefaultServerMaxSessionsPerConn,
+			MaxConnsPerIP:      DefaultServerMaxConnsPerIP,
+		}, l.limits)
+	})
+	t.Run("maxConns", func(t *testing.T) {
+		l := newServerLimiter(ServerLimits{MaxConns: 2})
+		require.NoError(t, l.connOpen(remote, server))
+		require.NoError(t, l.connOpen(remote2, server))
+		require.ErrorIs(t, l.connOpen(remote, server), ErrServerMaxConns)
+
+		l.connClose(remote, server)
+		require.NoError(t, l.connOpen(remote, server))
+		require.Equal(t, ServerStats{Conns: 2, RejectedConns: 1}, l.getStats())
+	})
+	t.Run("maxConnsPerIP", func(t *testing.T) {
+		l := newServerLimiter(ServerLimits{MaxConnsPerIP: 1})
+		require.NoError(t, l.connOpen(remote, server))
+		require.ErrorIs(t, l.connOpen(remote, server), ErrServerMaxConnsPerIP)
+		require.NoError(t, l.connOpen(remote2, server))
+
+		l.connClose(remote, server)
+		require.Empty(t, l.connsPerIP[remote.IP.String()])
+		require.NoError(t, l.connOpen(remote, server))
+	})
+	t.Run("maxSessions", func(t *testing.T) {
+		l := newServerLimiter(ServerLimits{MaxSessions: 1})
+		sc := &ServerConn{remoteAddr: remote}
+		sc2 := &ServerConn{remoteAddr: remote2}
+		require.NoError(t, l.sessionOpen(sc))
+		require.ErrorIs(t, l.sessionOpen(sc2), ErrServerMaxSessions)
+
+		l.sessionClose(sc)
+		require.NoError(t, l.sessionOpen(sc2))
+		require.Equal(t, ServerStats{Sessions: 1, RejectedSessions: 1}, l.getStats())
+	})
+	t.Run("maxSessionsPerConn", func(t *testing.T) {
+		l := newServerLimiter(ServerLimits{MaxSessionsPerConn: 2})
+		sc := &ServerConn{remoteAddr: remote}
+		sc2 := &ServerConn{remoteAddr: remote}
+		require.NoError(t, l.sessionOpen(sc))
+		require.NoError(t, l.sessionOpen(sc))
+		require.ErrorIs(t, l.sessionOpen(sc), ErrServerMaxSessionsPerConn)
+		require.NoError(t, l.sessionOpen(sc2))
+
+		l.sessionClose(sc)
+		require.NoError(t, l.sessionOpen(sc))
+	})
+	t.Run("disabled", func(t *testing.T) {
+		l := newServerLimiter(ServerLimits{MaxConns: -1, MaxConnsPerIP: -1})
+		for i := 0; i < DefaultServerMaxConns+1; i++ {
+			require.NoError(t, l.connOpen(remote, server))
+		}
+	})
+	t.Run("exemptLoopback", func(t *testing.T) {
+		l := newServerLimiter(ServerLimits{
+			MaxConns:       1,
+			MaxSessions:    1,
+			ExemptLoopback: true,
+		})
+		require.NoError(t, l.connOpen(remote, server))
+		require.NoError(t, l.connOpen(loopback, server))
+		require.NoError(t, l.connOpen(loopback, server))
+		require.ErrorIs(t, l.connOpen(remote2, server), ErrServerMaxConns)
+
+		sc := &ServerConn{remoteAddr: loopback}
+		require.NoError(t, l.sessionOpen(&ServerConn{remoteAddr: remote}))
+		require.NoError(t, l.sessionOpen(sc))
+		require.NoError(t, l.sessionOpen(sc))
+
+		// Exempt connections are included in the stats.
+		require.Equal(t, ServerStats{
+			Conns:         3,
+			Sessions:      3,
+			RejectedConns: 1,
+		}, l.getStats())
+	})
+	t.Run("exemptServerAddress", func(t *testing.T) {
+		l := newServerLimiter(ServerLimits{
+			MaxConns:       1,
+			MaxSessions:    1,
+			ExemptLoopback: true,
+		})
+		// A client on the same host that dialed the bind address.
+		local := &net.TCPAddr{IP: server.IP, Port: 1000}
+		require.NoError(t, l.connOpen(remote, server))
+		require.NoError(t, l.connOpen(local, server))
+		require.NoError(t, l.connOpen(local, server))
+
+		sc := &ServerConn{remoteAddr: local, localAddr: server}
+		require.NoError(t, l.sessionOpen(&ServerConn{remoteAddr: remote, localAddr: server}))
+		require.NoError(t, l.sessionOpen(sc))
+		require.NoError(t, l.sessionOpen(sc))
+	})
+}
+
+func TestIsLocalAddr(t *testing.T) {
+	server := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 554}
+	addr := func(ip string) *net.TCPAddr {
+		return &net.TCPAddr{IP: net.ParseIP(ip), Port: 1000}
+	}
+	require.True(t, isLocalAddr(addr("127.0.0.1"), server))
+	require.True(t, isLocalAddr(addr("::1"), server))
+	require.True(t, isLocalAddr(addr("192.168.1.1"), server))
+	require.False(t, isLocalAddr(addr("192.168.1.2"), server))
+	require.False(t, isLocalAddr(addr("192.168.1.2"), nil))
+	require.False(t, isLocalAddr((*net.TCPAddr)(nil), server))
+}
+
+func writeOptions(conn *conn.Conn) (*base.Response, error) {
+	return writeReqReadRes(conn, base.Request{
+		Method: base.Options,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{"CSeq": base.HeaderValue{"1"}},
+	})
+}
+
+func dialTestServer(t *testing.T) (*conn.Conn, net.Conn) {
+	t.Helper()
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	t.Cleanup(func() { nconn.Close() })
+	return conn.NewConn(nconn), nconn
+}
+
+// limitRecorder records the errors passed to OnLimitReached.
+type limitRecorder struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (r *limitRecorder) onLimitReached(_ net.Addr, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+func (r *limitRecorder) get() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]error{}, r.errs...)
+}
+
+func TestServerMaxConns(t *testing.T) {
+	rec := &limitRecorder{}
+	s := &Server{
+		handler:     &testServerHandler{onLimitReached: rec.onLimitReached},
+		rtspAddress: "localhost:8554",
+	}
+	s.SetLimits(ServerLimits{MaxConns: 2, RejectWithResponse: true})
+	require.NoError(t, s.Start())
+	defer s.Close()
+
+	conn1, _ := dialTestServer(t)
+	conn2, _ := dialTestServer(t)
+	for _, c := range []*conn.Conn{conn1, conn2} {
+		res, err := writeOptions(c)
+		require.NoError(t, err)
+		require.Equal(t, base.StatusOK, res.StatusCode)
+	}
+
+	// The third connection is rejected.
+	conn3, _ := dialTestServer(t)
+	res, err := conn3.ReadResponse()
+	require.NoError(t, err)
+	require.Equal(t, base.StatusServiceUnavailable, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"5"}, res.Header["Retry-After"])
+	_, err = conn3.ReadResponse()
+	require.ErrorIs(t, err, io.EOF)
+
+	require.Eventually(t, func() bool { return len(rec.get()) == 1 }, time.Second, time.Millisecond)
+	require.ErrorIs(t, rec.get()[0], ErrServerMaxConns)
+	require.Equal(t, ServerStats{Conns: 2, RejectedConns: 1}, s.Stats())
+
+	// The existing connections are unaffected.
+	for _, c := range []*conn.Conn{conn1, conn2} {
+		res, err := writeOptions(c)
+		require.NoError(t, err)
+		require.Equal(t, base.StatusOK, res.StatusCode)
+	}
+}
+
+func TestServerMaxConnsPerIP(t *testing.T) {
+	s := &Server{
+		handler:     &testServerHandler{},
+		rtspAddress: "localhost:8554",
+	}
+	s.SetLimits(ServerLimits{MaxConnsPerIP: 1})
+	require.NoError(t, s.Start())
+	defer s.Close()
+
+	conn1, nconn1 := dialTestServer(t)
+	res, err := writeOptions(conn1)
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	// Closed without a response.
+	conn2, _ := dialTestServer(t)
+	_, err = writeOptions(conn2)
+	require.Error(t, err)
+
+	res, err = writeOptions(conn1)
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	// The slot is freed when the connection closes.
+	nconn1.Close()
+	require.Eventually(t, func() bool {
+		return s.Stats().Conns == 0
+	}, time.Second, time.Millisecond)
+
+	conn3, _ := dialTestServer(t)
+	res, err = writeOptions(conn3)
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+}
+
+func TestServerMaxSessions(t *testing.T) {
+	track := &TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}
+	stream := NewServerStream(Tracks{track})
+	defer stream.Close()
+
+	rec := &limitRecorder{}
+	s := &Server{
+		handler: &testServerHandler{
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				return &base.Response{StatusCode: base.StatusOK}, stream, nil
+			},
+			onPlay: func(*ServerSession, headers.Range) (*base.Response, error) {
+				return &base.Response{StatusCode: base.StatusOK}, nil
+			},
+			onLimitReached: rec.onLimitReached,
+		},
+		rtspAddress: "localhost:8554",
+	}
+	s.SetLimits(ServerLimits{MaxSessions: 1})
+	require.NoError(t, s.Start())
+	defer s.Close()
+
+	setup := func(c *conn.Conn) (*base.Response, error) {
+		return writeReqReadRes(c, base.Request{
+			Method: base.Setup,
+			URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+			Header: base.Header{
+				"CSeq": base.HeaderValue{"1"},
+				"Transport": headers.Transport{
+					Mode: func() *headers.TransportMode {
+						v := headers.TransportModePlay
+						return &v
+					}(),
+					InterleavedIDs: &[2]int{0, 1},
+				}.Marshal(),
+			},
+		})
+	}
+
+	conn1, _ := dialTestServer(t)
+	res, err := setup(conn1)
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	var sx headers.Session
+	require.NoError(t, sx.Unmarshal(res.Header["Session"]))
+
+	conn2, _ := dialTestServer(t)
+	res, err = setup(conn2)
+	require.NoError(t, err)
+	require.Equal(t, base.StatusServiceUnavailable, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"5"}, res.Header["Retry-After"])
+
+	errs := rec.get()
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[0], ErrServerMaxSessions)
+	stats := s.Stats()
+	require.Equal(t, 1, stats.Sessions)
+	require.Equal(t, uint64(1), stats.RejectedSessions)
+
+	// The existing session is unaffected.
+	res, err = writeReqReadRes(conn1, base.Request{
+		Method: base.Play,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"2"},
+			"Session": base.HeaderValue{sx.Session},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+}
+
+func TestServerLimitsExemptLoopback(t *testing.T) {
+	s := &Server{
+		handler:     &testServerHandler{},
+		rtspAddress: "localhost:8554",
+	}
+	s.SetLimits(ServerLimits{
+		MaxConns:       1,
+		MaxConnsPerIP:  1,
+		ExemptLoopback: true,
+	})
+	require.NoError(t, s.Start())
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		c, _ := dialTestServer(t)
+		res, err := writeOptions(c)
+		require.NoError(t, err)
+		require.Equal(t, base.StatusOK, res.StatusCode)
+	}
+	require.Equal(t, 3, s.Stats().Conns)
+}
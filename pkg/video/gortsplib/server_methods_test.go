@@ -0,0 +1,269 @@
+package gortsplib
+
+import (
+	"net"
+	"testing"
+
+	"nvr/pkg/video/gortsplib/pkg/base"
+	"nvr/pkg/video/gortsplib/pkg/conn"
+	"nvr/pkg/video/gortsplib/pkg/headers"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+// testCoreHandler only implements the required handler methods.
+type testCoreHandler struct {
+	stream *ServerStream
+}
+
+func (testCoreHandler) OnRequest(*ServerConn, *base.Request) (*base.Response, error) {
+	return nil, nil
+}
+func (testCoreHandler) OnConnClose(*ServerConn, error)                    {}
+func (testCoreHandler) OnSessionOpen(*ServerSession, *ServerConn, string) {}
+func (testCoreHandler) OnSessionClose(*ServerSession, error)              {}
+func (h testCoreHandler) OnDescribe(string) (*base.Response, *ServerStream, error) {
+	return &base.Response{StatusCode: base.StatusOK}, h.stream, nil
+}
+
+func (h testCoreHandler) OnSetup(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+	return &base.Response{StatusCode: base.StatusOK}, h.stream, nil
+}
+func (testCoreHandler) OnPacketRTP(*ServerSession, int, *rtp.Packet) {}
+func (testCoreHandler) OnDecodeError(*ServerSession, error)          {}
+func (testCoreHandler) OnLimitReached(net.Addr, error)               {}
+
+type testPlayHandler struct {
+	testCoreHandler
+}
+
+func (testPlayHandler) OnPlay(*ServerSession, headers.Range) (*base.Response, error) {
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
+
+type testPublishHandler struct {
+	testCoreHandler
+}
+
+func (testPublishHandler) OnAnnounce(*ServerSession, string, Tracks) (*base.Response, error) {
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
+
+func (testPublishHandler) OnRecord(*ServerSession) (*base.Response, error) {
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
+
+// testAllHandler implements every optional handler method.
+type testAllHandler struct {
+	testCoreHandler
+}
+
+func (testAllHandler) OnAnnounce(*ServerSession, string, Tracks) (*base.Response, error) {
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
+
+func (testAllHandler) OnPlay(*ServerSession, headers.Range) (*base.Response, error) {
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
+
+func (testAllHandler) OnRecord(*ServerSession) (*base.Response, error) {
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
+
+func (testAllHandler) OnPause(*ServerSession) (*base.Response, error) {
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
+
+func (testAllHandler) OnGetParameter(
+	_ *ServerSession,
+	path string,
+	_ *base.Request,
+) (*base.Response, error) {
+	return &base.Response{
+		StatusCode: base.StatusOK,
+		Body:       []byte(path),
+	}, nil
+}
+
+func (testAllHandler) OnSetParameter(*ServerSession, string, *base.Request) (*base.Response, error) {
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
+
+func TestServerPublicHeader(t *testing.T) {
+	cases := map[string]struct {
+		handler  ServerHandler
+		expected string
+	}{
+		"core": {
+			testCoreHandler{},
+			"OPTIONS, DESCRIBE, SETUP, TEARDOWN",
+		},
+		"play": {
+			testPlayHandler{},
+			"OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN",
+		},
+		"publish": {
+			testPublishHandler{},
+			"OPTIONS, DESCRIBE, ANNOUNCE, SETUP, RECORD, TEARDOWN",
+		},
+		"testServerHandler": {
+			&testServerHandler{},
+			"OPTIONS, DESCRIBE, ANNOUNCE, SETUP, PLAY, RECORD, TEARDOWN",
+		},
+		"all": {
+			testAllHandler{},
+			"OPTIONS, DESCRIBE, ANNOUNCE, SETUP, PLAY, RECORD, PAUSE," +
+				" GET_PARAMETER, SET_PARAMETER, TEARDOWN",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual := serverPublicHeader(tc.handler)
+			require.Equal(t, base.HeaderValue{tc.expected}, actual)
+		})
+	}
+}
+
+func newTestMethodsServer(t *testing.T, handler func(*ServerStream) ServerHandler) (*conn.Conn, func()) {
+	t.Helper()
+	track := &TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}
+	stream := NewServerStream(Tracks{track})
+
+	s := &Server{
+		handler:     handler(stream),
+		rtspAddress: "localhost:8554",
+	}
+	require.NoError(t, s.Start())
+
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+
+	return conn.NewConn(nconn), func() {
+		nconn.Close()
+		s.Close()
+		stream.Close()
+	}
+}
+
+func setupTestMethodsSession(t *testing.T, conn *conn.Conn) base.HeaderValue {
+	t.Helper()
+	res, err := writeReqReadRes(conn, base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+			"Transport": headers.Transport{
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModePlay
+					return &v
+				}(),
+				InterleavedIDs: &[2]int{0, 1},
+			}.Marshal(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	var sx headers.Session
+	require.NoError(t, sx.Unmarshal(res.Header["Session"]))
+	return base.HeaderValue{sx.Session}
+}
+
+func TestServerMethods(t *testing.T) {
+	t.Run("publicInsideSession", func(t *testing.T) {
+		conn, cleanup := newTestMethodsServer(t, func(stream *ServerStream) ServerHandler {
+			return testPlayHandler{testCoreHandler{stream: stream}}
+		})
+		defer cleanup()
+
+		expected := base.HeaderValue{"OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN"}
+
+		res, err := writeReqReadRes(conn, base.Request{
+			Method: base.Options,
+			URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+			Header: base.Header{"CSeq": base.HeaderValue{"1"}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, base.StatusOK, res.StatusCode)
+		require.Equal(t, expected, res.Header["Public"])
+
+		session := setupTestMethodsSession(t, conn)
+
+		res, err = writeReqReadRes(conn, base.Request{
+			Method: base.Options,
+			URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+			Header: base.Header{
+				"CSeq":    base.HeaderValue{"2"},
+				"Session": session,
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, base.StatusOK, res.StatusCode)
+		require.Equal(t, expected, res.Header["Public"])
+	})
+	t.Run("notImplemented", func(t *testing.T) {
+		conn, cleanup := newTestMethodsServer(t, func(stream *ServerStream) ServerHandler {
+			return testPlayHandler{testCoreHandler{stream: stream}}
+		})
+		defer cleanup()
+
+		session := setupTestMethodsSession(t, conn)
+
+		for _, method := range []base.Method{
+			base.Announce,
+			base.Record,
+			base.Pause,
+			base.GetParameter,
+			base.SetParameter,
+		} {
+			res, err := writeReqReadRes(conn, base.Request{
+				Method: method,
+				URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+				Header: base.Header{
+					"CSeq":    base.HeaderValue{"2"},
+					"Session": session,
+				},
+			})
+			require.NoError(t, err)
+			require.Equal(t, base.StatusNotImplemented, res.StatusCode, method)
+		}
+	})
+	t.Run("optional", func(t *testing.T) {
+		conn, cleanup := newTestMethodsServer(t, func(stream *ServerStream) ServerHandler {
+			return testAllHandler{testCoreHandler{stream: stream}}
+		})
+		defer cleanup()
+
+		session := setupTestMethodsSession(t, conn)
+
+		request := func(method base.Method) *base.Response {
+			res, err := writeReqReadRes(conn, base.Request{
+				Method: method,
+				URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+				Header: base.Header{
+					"CSeq":    base.HeaderValue{"2"},
+					"Session": session,
+				},
+			})
+			require.NoError(t, err)
+			require.Equal(t, base.StatusOK, res.StatusCode, method)
+			return res
+		}
+
+		res := request(base.GetParameter)
+		require.Equal(t, []byte("teststream"), res.Body)
+		request(base.SetParameter)
+
+		// The session can be played again after a pause.
+		request(base.Play)
+		request(base.Pause)
+		request(base.Options)
+		request(base.Play)
+		request(base.Teardown)
+	})
+}
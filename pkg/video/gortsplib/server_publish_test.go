@@ -3,12 +3,14 @@ package gortsplib
 import (
 	"bytes"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
 	"nvr/pkg/video/gortsplib/pkg/base"
 	"nvr/pkg/video/gortsplib/pkg/conn"
 	"nvr/pkg/video/gortsplib/pkg/headers"
+	"nvr/pkg/video/gortsplib/pkg/rtcpreceiver"
 	"nvr/pkg/video/gortsplib/pkg/url"
 
 	"github.com/pion/rtp"
@@ -102,6 +104,7 @@ func TestServerPublishErrorAnnounce(t *testing.T) {
 					"CSeq":         base.HeaderValue{"1"},
 					"Content-Type": base.HeaderValue{"aa"},
 				},
+				Body: []byte{0x01},
 			},
 			"read: unsupported Content-Type header '[aa]'",
 		},
@@ -126,7 +129,7 @@ func TestServerPublishErrorAnnounce(t *testing.T) {
 		{
 			"invalid URL 2",
 			invalidURLAnnounceReq(t, "rtsp://host"),
-			"read: invalid track URL (rtsp://localhost:8554)",
+			"read: invalid track URL (rtsp://host)",
 		},
 		{
 			"invalid URL 3",
@@ -169,6 +172,90 @@ func TestServerPublishErrorAnnounce(t *testing.T) {
 	}
 }
 
+func TestServerPublishAnnounceValidation(t *testing.T) {
+	announceReq := func(sdp string) base.Request {
+		return base.Request{
+			Method: base.Announce,
+			URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+			Header: base.Header{
+				"CSeq":         base.HeaderValue{"1"},
+				"Content-Type": base.HeaderValue{"application/sdp"},
+			},
+			Body: []byte(sdp),
+		}
+	}
+
+	t.Run("problems", func(t *testing.T) {
+		connClosed := make(chan struct{})
+		s := &Server{
+			handler: &testServerHandler{
+				onConnClose: func(_ *ServerConn, err error) {
+					require.EqualError(t, err, "read: invalid SDP: "+
+						"media 1: unsupported codec: video H265/90000, "+supportedCodecs+
+						"; media 2: rtpmap attribute is missing for payload type 97")
+					close(connClosed)
+				},
+				onAnnounce: func(*ServerSession, string, Tracks) (*base.Response, error) {
+					t.Error("should not be called")
+					return &base.Response{StatusCode: base.StatusOK}, nil
+				},
+			},
+			rtspAddress: "localhost:8554",
+		}
+		require.NoError(t, s.Start())
+		defer s.Close()
+
+		nconn, err := net.Dial("tcp", "localhost:8554")
+		require.NoError(t, err)
+		defer nconn.Close()
+		conn := conn.NewConn(nconn)
+
+		res, err := writeReqReadRes(conn, announceReq(testSDPHeader+
+			"m=video 0 RTP/AVP 96\r\n"+
+			"a=rtpmap:96 H265/90000\r\n"+
+			"m=audio 0 RTP/AVP 97\r\n"))
+		require.NoError(t, err)
+		require.Equal(t, base.StatusBadRequest, res.StatusCode)
+		require.Equal(t, base.HeaderValue{"text/plain"}, res.Header["Content-Type"])
+		require.Equal(t,
+			"media 1: unsupported codec: video H265/90000, "+supportedCodecs+"\n"+
+				"media 2: rtpmap attribute is missing for payload type 97\n",
+			string(res.Body),
+		)
+
+		<-connClosed
+	})
+	t.Run("ignorableSection", func(t *testing.T) {
+		s := &Server{
+			handler: &testServerHandler{
+				onAnnounce: func(ss *ServerSession, _ string, tracks Tracks) (*base.Response, error) {
+					require.Len(t, tracks, 1)
+					require.Equal(t,
+						[]string{`media 2: skipped "application" section`},
+						ss.AnnounceWarnings(),
+					)
+					return &base.Response{StatusCode: base.StatusOK}, nil
+				},
+			},
+			rtspAddress: "localhost:8554",
+		}
+		require.NoError(t, s.Start())
+		defer s.Close()
+
+		nconn, err := net.Dial("tcp", "localhost:8554")
+		require.NoError(t, err)
+		defer nconn.Close()
+		conn := conn.NewConn(nconn)
+
+		res, err := writeReqReadRes(conn, announceReq(testSDPHeader+
+			strings.ReplaceAll(testSDPVideo, "trackID=0", "rtsp://localhost:8554/teststream/trackID=0")+
+			"m=application 0 RTP/AVP 107\r\n"+
+			"a=rtpmap:107 vnd.onvif.metadata/90000\r\n"))
+		require.NoError(t, err)
+		require.Equal(t, base.StatusOK, res.StatusCode)
+	})
+}
+
 func TestServerPublishSetupPath(t *testing.T) {
 	for _, ca := range []struct {
 		name    string
@@ -219,6 +306,27 @@ func TestServerPublishSetupPath(t *testing.T) {
 			"test/stream",
 			0,
 		},
+		{
+			"absolute control",
+			"rtsp://localhost:8554/teststream/trackID=0",
+			"rtsp://localhost:8554/teststream/trackID=0",
+			"teststream",
+			0,
+		},
+		{
+			"absolute control behind nat",
+			"rtsp://192.168.1.10:554/teststream/trackID=0",
+			"rtsp://192.168.1.10:554/teststream/trackID=0",
+			"teststream",
+			0,
+		},
+		{
+			"absolute control behind nat, connection host",
+			"rtsp://192.168.1.10:554/teststream/trackID=0",
+			"rtsp://localhost:8554/teststream/trackID=0",
+			"teststream",
+			0,
+		},
 	} {
 		t.Run(ca.name, func(t *testing.T) {
 			s := &Server{
@@ -930,3 +1038,245 @@ func TestServerPublishWithoutTeardown(t *testing.T) {
 	<-sessionClosed
 	<-connClosed
 }
+
+func TestServerPublishReceiverReport(t *testing.T) {
+	stats := make(chan map[int]rtcpreceiver.Stats, 1)
+
+	s := &Server{
+		handler: &testServerHandler{
+			onAnnounce: func(*ServerSession, string, Tracks) (*base.Response, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, nil
+			},
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, nil, nil
+			},
+			onRecord: func(*ServerSession) (*base.Response, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, nil
+			},
+			onPacketRTP: func(ss *ServerSession, _ int, pkt *rtp.Packet) {
+				if pkt.SequenceNumber == 104 {
+					stats <- ss.ReceiverStats()
+				}
+			},
+		},
+		receiverReportPeriod: 100 * time.Millisecond,
+		rtspAddress:          "localhost:8554",
+	}
+
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer nconn.Close()
+	conn := conn.NewConn(nconn)
+
+	track := &TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}
+
+	tracks := Tracks{track}
+	tracks.setControls()
+
+	res, err := writeReqReadRes(conn, base.Request{
+		Method: base.Announce,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq":         base.HeaderValue{"1"},
+			"Content-Type": base.HeaderValue{"application/sdp"},
+		},
+		Body: tracks.Marshal(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	inTH := &headers.Transport{
+		Mode: func() *headers.TransportMode {
+			v := headers.TransportModeRecord
+			return &v
+		}(),
+		InterleavedIDs: &[2]int{2, 3},
+	}
+
+	res, err = writeReqReadRes(conn, base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq":      base.HeaderValue{"2"},
+			"Transport": inTH.Marshal(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	var sx headers.Session
+	err = sx.Unmarshal(res.Header["Session"])
+	require.NoError(t, err)
+
+	res, err = writeReqReadRes(conn, base.Request{
+		Method: base.Record,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"3"},
+			"Session": base.HeaderValue{sx.Session},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	// Packets 102 and 103 are lost.
+	for _, seq := range []uint16{100, 101, 104} {
+		pkt := testRTPPacket
+		pkt.SequenceNumber = seq
+		pkt.SSRC = 0x38f27a2f
+		byts, err := pkt.Marshal()
+		require.NoError(t, err)
+
+		err = conn.WriteInterleavedFrame(&base.InterleavedFrame{
+			Channel: 2,
+			Payload: byts,
+		}, make([]byte, 1024))
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, int64(2), (<-stats)[0].PacketsLost)
+
+	fr, err := conn.ReadInterleavedFrame()
+	require.NoError(t, err)
+	require.Equal(t, 3, fr.Channel)
+	require.Len(t, fr.Payload, 32)
+	require.Equal(t, []byte{0x81, 0xc9, 0x00, 0x07}, fr.Payload[:4])
+	require.Equal(t, []byte{0x38, 0xf2, 0x7a, 0x2f}, fr.Payload[8:12])
+
+	// Fraction lost 2/5, cumulative lost 2.
+	require.Equal(t, []byte{0x66, 0x00, 0x00, 0x02}, fr.Payload[12:16])
+
+	// Extended highest sequence number.
+	require.Equal(t, []byte{0x00, 0x00, 0x00, 0x68}, fr.Payload[16:20])
+}
+
+func TestServerPublishFrameRelease(t *testing.T) {
+	// The payload of the first packet is retained without a copy.
+	var retained []byte
+	retainedAfterRelease := make(chan []byte, 1)
+
+	s := &Server{
+		handler: &testServerHandler{
+			onAnnounce: func(*ServerSession, string, Tracks) (*base.Response, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, nil
+			},
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, nil, nil
+			},
+			onRecord: func(*ServerSession) (*base.Response, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, nil
+			},
+			onPacketRTP: func(_ *ServerSession, _ int, pkt *rtp.Packet) {
+				if retained == nil {
+					require.Equal(t, []byte{0x01, 0x02}, pkt.Payload)
+					retained = pkt.Payload
+					return
+				}
+				require.Equal(t, []byte{0x03, 0x04}, pkt.Payload)
+				retainedAfterRelease <- append([]byte(nil), retained...)
+			},
+		},
+		rtspAddress: "localhost:8554",
+	}
+	s.SetFrameDebug(true)
+
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer nconn.Close()
+	conn := conn.NewConn(nconn)
+
+	track := &TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}
+
+	tracks := Tracks{track}
+	tracks.setControls()
+
+	res, err := writeReqReadRes(conn, base.Request{
+		Method: base.Announce,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq":         base.HeaderValue{"1"},
+			"Content-Type": base.HeaderValue{"application/sdp"},
+		},
+		Body: tracks.Marshal(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	inTH := &headers.Transport{
+		Mode: func() *headers.TransportMode {
+			v := headers.TransportModeRecord
+			return &v
+		}(),
+		InterleavedIDs: &[2]int{0, 1},
+	}
+
+	res, err = writeReqReadRes(conn, base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq":      base.HeaderValue{"2"},
+			"Transport": inTH.Marshal(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	var sx headers.Session
+	err = sx.Unmarshal(res.Header["Session"])
+	require.NoError(t, err)
+
+	res, err = writeReqReadRes(conn, base.Request{
+		Method: base.Record,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"3"},
+			"Session": base.HeaderValue{sx.Session},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	for _, payload := range [][]byte{{0x01, 0x02}, {0x03, 0x04}} {
+		pkt := testRTPPacket
+		pkt.Payload = payload
+		byts, err := pkt.Marshal()
+		require.NoError(t, err)
+
+		err = conn.WriteInterleavedFrame(&base.InterleavedFrame{
+			Channel: 0,
+			Payload: byts,
+		}, make([]byte, 1024))
+		require.NoError(t, err)
+	}
+
+	// The buffer of the first packet was released and reused.
+	require.Equal(t, []byte{0x03, 0x04}, <-retainedAfterRelease)
+}
@@ -2,72 +2,685 @@ package gortsplib
 
 import (
 	"net"
+	"strconv"
 	"testing"
 	"time"
 
 	"nvr/pkg/video/gortsplib/pkg/base"
 	"nvr/pkg/video/gortsplib/pkg/conn"
 	"nvr/pkg/video/gortsplib/pkg/headers"
+	"nvr/pkg/video/gortsplib/pkg/liberrors"
+	"nvr/pkg/video/gortsplib/pkg/mpeg4audio"
 
+	"github.com/pion/rtp"
 	"github.com/stretchr/testify/require"
 )
 
+// getIP returns the first global unicast address of the host, IPv4
+// addresses are preferred. Use net.JoinHostPort to add a port.
 func getIP(t *testing.T) string {
-	intfs, err := net.Interfaces()
+	addrs, err := net.InterfaceAddrs()
 	require.NoError(t, err)
 
-	for _, intf := range intfs {
-		addrs, err := intf.Addrs()
-		if err != nil {
+	var ipv6 string
+	for _, addr := range addrs {
+		var ip net.IP
+		switch v := addr.(type) {
+		case *net.IPNet:
+			ip = v.IP
+		case *net.IPAddr:
+			ip = v.IP
+		}
+		// Link-local addresses are only usable with a zone.
+		if ip == nil || !ip.IsGlobalUnicast() {
 			continue
 		}
+		if ip.To4() != nil {
+			return ip.String()
+		}
+		if ipv6 == "" {
+			ipv6 = ip.String()
+		}
+	}
+	if ipv6 != "" {
+		return ipv6
+	}
+
+	t.Errorf("unable to find a IP")
+	return ""
+}
+
+// requireIPv6 skips the test if the IPv6 loopback address is unavailable.
+func requireIPv6(t *testing.T) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is unavailable: %v", err)
+	}
+	ln.Close()
+}
+
+func TestServerReadSetupPath(t *testing.T) {
+	for _, ca := range []struct {
+		name    string
+		url     string
+		path    string
+		trackID int
+	}{
+		{
+			"normal",
+			"rtsp://localhost:8554/teststream/trackID=2",
+			"teststream",
+			2,
+		},
+		{
+			// this is needed to support reading mpegts with ffmpeg
+			"without track id",
+			"rtsp://localhost:8554/teststream/",
+			"teststream",
+			0,
+		},
+		{
+			"subpath",
+			"rtsp://localhost:8554/test/stream/trackID=0",
+			"test/stream",
+			0,
+		},
+		{
+			"subpath without track id",
+			"rtsp://localhost:8554/test/stream/",
+			"test/stream",
+			0,
+		},
+		{
+			"query track id",
+			"rtsp://localhost:8554/teststream?trackID=2",
+			"teststream",
+			2,
+		},
+		{
+			"query track id with other parameters",
+			"rtsp://localhost:8554/test/stream/?channel=1&subtype=0&trackID=1",
+			"test/stream",
+			1,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			track := &TrackH264{
+				PayloadType: 96,
+				SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+				PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+			}
+
+			stream := NewServerStream(Tracks{track, track, track, track, track})
+			defer stream.Close()
+
+			s := &Server{
+				handler: &testServerHandler{
+					onSetup: func(
+						_ *ServerSession,
+						path string,
+						trackID int,
+					) (*base.Response, *ServerStream, error) {
+						require.Equal(t, ca.path, path)
+						require.Equal(t, ca.trackID, trackID)
+						return &base.Response{
+							StatusCode: base.StatusOK,
+						}, stream, nil
+					},
+				},
+				rtspAddress: "localhost:8554",
+			}
+
+			err := s.Start()
+			require.NoError(t, err)
+			defer s.Close()
+
+			nconn, err := net.Dial("tcp", "localhost:8554")
+			require.NoError(t, err)
+			defer nconn.Close()
+			conn := conn.NewConn(nconn)
+
+			th := &headers.Transport{
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModePlay
+					return &v
+				}(),
+				InterleavedIDs: &[2]int{ca.trackID * 2, (ca.trackID * 2) + 1},
+			}
+
+			res, err := writeReqReadRes(conn, base.Request{
+				Method: base.Setup,
+				URL:    mustParseURL(ca.url),
+				Header: base.Header{
+					"CSeq":      base.HeaderValue{"1"},
+					"Transport": th.Marshal(),
+				},
+			})
+			require.NoError(t, err)
+			require.Equal(t, base.StatusOK, res.StatusCode)
+		})
+	}
+}
+
+func TestServerReadClientStyles(t *testing.T) {
+	for _, ca := range []struct {
+		name     string
+		setups   []string
+		play     string
+		teardown string
+	}{
+		{
+			"live555",
+			[]string{
+				"rtsp://localhost:8554/teststream/trackID=0",
+				"rtsp://localhost:8554/teststream/trackID=1",
+			},
+			"rtsp://localhost:8554/teststream/",
+			"rtsp://localhost:8554/teststream/",
+		},
+		{
+			"ffmpeg",
+			[]string{
+				"rtsp://localhost:8554/teststream/trackID=0",
+				"rtsp://localhost:8554/teststream/trackID=1",
+			},
+			"rtsp://localhost:8554/teststream",
+			"rtsp://localhost:8554/teststream",
+		},
+		{
+			// Control attributes appended to a Content-Base with a query.
+			"vlc",
+			[]string{
+				"rtsp://localhost:8554/teststream?token=x/trackID=0",
+				"rtsp://localhost:8554/teststream?token=x/trackID=1",
+			},
+			"rtsp://localhost:8554/teststream?token=x/",
+			"rtsp://localhost:8554/teststream?token=x/",
+		},
+		{
+			// PLAY and TEARDOWN against a track URL.
+			"gstreamer",
+			[]string{
+				"rtsp://localhost:8554/teststream/trackID=0/",
+				"rtsp://localhost:8554/teststream/trackID=1?token=x",
+			},
+			"rtsp://localhost:8554/teststream/trackID=0",
+			"rtsp://localhost:8554/teststream/trackID=1/",
+		},
+		{
+			// Track ID as a query parameter.
+			"encoder",
+			[]string{
+				"rtsp://localhost:8554/teststream?trackID=0",
+				"rtsp://localhost:8554/teststream?trackID=1",
+			},
+			"rtsp://localhost:8554/teststream",
+			"rtsp://localhost:8554/teststream?trackID=0",
+		},
+		{
+			// Dahua style query on every request.
+			"dahua",
+			[]string{
+				"rtsp://localhost:8554/teststream?channel=1&subtype=0/trackID=0",
+				"rtsp://localhost:8554/teststream?channel=1&subtype=0&trackID=1",
+			},
+			"rtsp://localhost:8554/teststream?channel=1&subtype=0",
+			"rtsp://localhost:8554/teststream?channel=1&subtype=0",
+		},
+		{
+			// Query and non-query forms within one session.
+			"mixed",
+			[]string{
+				"rtsp://localhost:8554/teststream/trackID=0",
+				"rtsp://localhost:8554/teststream?channel=1&trackID=1",
+			},
+			"rtsp://localhost:8554/teststream?channel=1",
+			"rtsp://localhost:8554/teststream/",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			track := &TrackH264{
+				PayloadType: 96,
+				SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+				PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+			}
+
+			stream := NewServerStream(Tracks{track, track})
+			defer stream.Close()
+
+			nextTrackID := 0
+			s := &Server{
+				handler: &testServerHandler{
+					onSetup: func(
+						_ *ServerSession,
+						path string,
+						trackID int,
+					) (*base.Response, *ServerStream, error) {
+						require.Equal(t, "teststream", path)
+						require.Equal(t, nextTrackID, trackID)
+						nextTrackID++
+						return &base.Response{
+							StatusCode: base.StatusOK,
+						}, stream, nil
+					},
+					onPlay: func(*ServerSession, headers.Range) (*base.Response, error) {
+						return &base.Response{
+							StatusCode: base.StatusOK,
+						}, nil
+					},
+				},
+				rtspAddress: "localhost:8554",
+			}
+
+			err := s.Start()
+			require.NoError(t, err)
+			defer s.Close()
+
+			nconn, err := net.Dial("tcp", "localhost:8554")
+			require.NoError(t, err)
+			defer nconn.Close()
+			conn := conn.NewConn(nconn)
+
+			var session string
+			for i, u := range ca.setups {
+				th := &headers.Transport{
+					Mode: func() *headers.TransportMode {
+						v := headers.TransportModePlay
+						return &v
+					}(),
+					InterleavedIDs: &[2]int{i * 2, (i * 2) + 1},
+				}
+				header := base.Header{
+					"CSeq":      base.HeaderValue{strconv.Itoa(i + 1)},
+					"Transport": th.Marshal(),
+				}
+				if session != "" {
+					header["Session"] = base.HeaderValue{session}
+				}
+
+				res, err := writeReqReadRes(conn, base.Request{
+					Method: base.Setup,
+					URL:    mustParseURL(u),
+					Header: header,
+				})
+				require.NoError(t, err)
+				require.Equal(t, base.StatusOK, res.StatusCode)
+
+				var sx headers.Session
+				require.NoError(t, sx.Unmarshal(res.Header["Session"]))
+				session = sx.Session
+			}
+
+			res, err := writeReqReadRes(conn, base.Request{
+				Method: base.Play,
+				URL:    mustParseURL(ca.play),
+				Header: base.Header{
+					"CSeq":    base.HeaderValue{"3"},
+					"Session": base.HeaderValue{session},
+				},
+			})
+			require.NoError(t, err)
+			require.Equal(t, base.StatusOK, res.StatusCode)
+
+			res, err = writeReqReadRes(conn, base.Request{
+				Method: base.Teardown,
+				URL:    mustParseURL(ca.teardown),
+				Header: base.Header{
+					"CSeq":    base.HeaderValue{"4"},
+					"Session": base.HeaderValue{session},
+				},
+			})
+			require.NoError(t, err)
+			require.Equal(t, base.StatusOK, res.StatusCode)
+		})
+	}
+}
+
+func TestServerReadPlayPathMismatch(t *testing.T) {
+	connClosed := make(chan struct{})
+
+	track := &TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}
+
+	stream := NewServerStream(Tracks{track})
+	defer stream.Close()
+
+	s := &Server{
+		handler: &testServerHandler{
+			onConnClose: func(_ *ServerConn, err error) {
+				require.EqualError(t, err, "read: path has changed,"+
+					" was 'teststream', now is 'otherstream',"+
+					" paths are compared without trailing slash, query and track ID")
+				close(connClosed)
+			},
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, stream, nil
+			},
+		},
+		rtspAddress: "localhost:8554",
+	}
+
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer nconn.Close()
+	conn := conn.NewConn(nconn)
+
+	res, err := writeReqReadRes(conn, base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+			"Transport": headers.Transport{
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModePlay
+					return &v
+				}(),
+				InterleavedIDs: &[2]int{0, 1},
+			}.Marshal(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	var sx headers.Session
+	require.NoError(t, sx.Unmarshal(res.Header["Session"]))
+
+	res, err = writeReqReadRes(conn, base.Request{
+		Method: base.Play,
+		URL:    mustParseURL("rtsp://localhost:8554/otherstream/trackID=0"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"2"},
+			"Session": base.HeaderValue{sx.Session},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusBadRequest, res.StatusCode)
+
+	<-connClosed
+}
+
+func TestServerReadDescribeSetup(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		url  string
+	}{
+		{"normal", "rtsp://localhost:8554/teststream"},
+		{"dahua", "rtsp://localhost:8554/teststream?channel=1&subtype=0"},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			track1 := &TrackH264{
+				PayloadType: 96,
+				SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+				PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+			}
+			track2 := &TrackMPEG4Audio{
+				PayloadType: 97,
+				Config: &mpeg4audio.Config{
+					Type:         2,
+					SampleRate:   44100,
+					ChannelCount: 2,
+				},
+				SizeLength:       13,
+				IndexLength:      3,
+				IndexDeltaLength: 3,
+			}
+
+			stream := NewServerStream(Tracks{track1, track2})
+			defer stream.Close()
+
+			s := &Server{
+				handler: &testServerHandler{
+					onDescribe: func(path string) (*base.Response, *ServerStream, error) {
+						require.Equal(t, "teststream", path)
+						return &base.Response{
+							StatusCode: base.StatusOK,
+						}, stream, nil
+					},
+					onSetup: func(
+						_ *ServerSession,
+						path string,
+						trackID int,
+					) (*base.Response, *ServerStream, error) {
+						require.Equal(t, "teststream", path)
+						return &base.Response{
+							StatusCode: base.StatusOK,
+						}, stream, nil
+					},
+				},
+				rtspAddress: "localhost:8554",
+			}
+
+			err := s.Start()
+			require.NoError(t, err)
+			defer s.Close()
+
+			nconn, err := net.Dial("tcp", "localhost:8554")
+			require.NoError(t, err)
+			defer nconn.Close()
+			conn := conn.NewConn(nconn)
+
+			res, err := writeReqReadRes(conn, base.Request{
+				Method: base.Describe,
+				URL:    mustParseURL(ca.url),
+				Header: base.Header{
+					"CSeq":   base.HeaderValue{"1"},
+					"Accept": base.HeaderValue{"application/sdp"},
+				},
+			})
+			require.NoError(t, err)
+			require.Equal(t, base.StatusOK, res.StatusCode)
+			require.Equal(t, base.HeaderValue{"application/sdp"}, res.Header["Content-Type"])
+			require.Equal(t, base.HeaderValue{ca.url + "/"}, res.Header["Content-Base"])
+
+			var tracks Tracks
+			_, err = tracks.Unmarshal(res.Body)
+			require.NoError(t, err)
+			require.Len(t, tracks, 2)
+
+			contentBase, err := ContentBase(res.Header, mustParseURL(ca.url))
+			require.NoError(t, err)
+			trackURLs, err := tracks.URLs(TrackURLBase{ContentBase: contentBase})
+			require.NoError(t, err)
+
+			var session string
+			for i, track := range tracks {
+				require.Equal(t, "trackID="+strconv.Itoa(i), track.GetControl())
+				u := trackURLs[i]
+
+				th := &headers.Transport{
+					Mode: func() *headers.TransportMode {
+						v := headers.TransportModePlay
+						return &v
+					}(),
+					InterleavedIDs: &[2]int{i * 2, (i * 2) + 1},
+				}
+				header := base.Header{
+					"CSeq":      base.HeaderValue{strconv.Itoa(i + 2)},
+					"Transport": th.Marshal(),
+				}
+				if session != "" {
+					header["Session"] = base.HeaderValue{session}
+				}
+
+				res, err := writeReqReadRes(conn, base.Request{
+					Method: base.Setup,
+					URL:    u,
+					Header: header,
+				})
+				require.NoError(t, err)
+				require.Equal(t, base.StatusOK, res.StatusCode)
+
+				var sx headers.Session
+				err = sx.Unmarshal(res.Header["Session"])
+				require.NoError(t, err)
+				session = sx.Session
+			}
+		})
+	}
+}
+
+func TestServerReadIPv6(t *testing.T) {
+	requireIPv6(t)
+
+	track := &TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}
+	stream := NewServerStream(Tracks{track})
+	defer stream.Close()
+
+	s := &Server{
+		handler: &testServerHandler{
+			onDescribe: func(string) (*base.Response, *ServerStream, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, stream, nil
+			},
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, stream, nil
+			},
+			onPlay: func(*ServerSession, headers.Range) (*base.Response, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, nil
+			},
+		},
+		// Empty host, listens on both IPv4 and IPv6.
+		rtspAddress: ":8554",
+	}
+
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	// An IDR is needed for the RTP-Info header.
+	stream.WritePacketRTP(0, &rtp.Packet{
+		Header: rtp.Header{
+			Version:     2,
+			PayloadType: 96,
+			Marker:      true,
+		},
+		Payload: []byte{0x05, 0x01},
+	})
+
+	// The same listener accepts IPv4 connections.
+	nconn4, err := net.Dial("tcp", "127.0.0.1:8554")
+	require.NoError(t, err)
+	defer nconn4.Close()
+	conn4 := conn.NewConn(nconn4)
+
+	nconn, err := net.Dial("tcp", "[::1]:8554")
+	require.NoError(t, err)
+	defer nconn.Close()
+	conn := conn.NewConn(nconn)
+
+	res, err := writeReqReadRes(conn, base.Request{
+		Method: base.Options,
+		URL:    mustParseURL("rtsp://[::1]:8554/teststream"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	res, err = writeReqReadRes(conn, base.Request{
+		Method: base.Describe,
+		URL:    mustParseURL("rtsp://[::1]:8554/teststream"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"2"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"rtsp://[::1]:8554/teststream/"}, res.Header["Content-Base"])
+
+	var tracks Tracks
+	_, err = tracks.Unmarshal(res.Body)
+	require.NoError(t, err)
+
+	contentBase, err := ContentBase(res.Header, mustParseURL("rtsp://[::1]:8554/teststream"))
+	require.NoError(t, err)
+	trackURLs, err := tracks.URLs(TrackURLBase{
+		ContentBase: contentBase,
+		Conn:        mustParseURL("rtsp://[0:0:0:0:0:0:0:1]:8554/teststream"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "rtsp://[::1]:8554/teststream/trackID=0", trackURLs[0].String())
+
+	res, err = writeReqReadRes(conn, base.Request{
+		Method: base.Setup,
+		URL:    trackURLs[0],
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"3"},
+			"Transport": headers.Transport{
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModePlay
+					return &v
+				}(),
+				InterleavedIDs: &[2]int{0, 1},
+			}.Marshal(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
 
-		for _, addr := range addrs {
-			switch v := addr.(type) {
-			case *net.IPNet:
-				return v.IP.String()
-			case *net.IPAddr:
-				return v.IP.String()
-			}
-		}
-	}
+	var sx headers.Session
+	err = sx.Unmarshal(res.Header["Session"])
+	require.NoError(t, err)
 
-	t.Errorf("unable to find a IP")
-	return ""
+	res, err = writeReqReadRes(conn, base.Request{
+		Method: base.Play,
+		URL:    mustParseURL("rtsp://[::1]:8554/teststream"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"4"},
+			"Session": base.HeaderValue{sx.Session},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	var ri headers.RTPinfo
+	err = ri.Unmarshal(res.Header["RTP-Info"])
+	require.NoError(t, err)
+	require.Len(t, ri, 1)
+	require.Equal(t, "rtsp://[::1]:8554/teststream/trackID=0", ri[0].URL)
+
+	res, err = writeReqReadRes(conn4, base.Request{
+		Method: base.Options,
+		URL:    mustParseURL("rtsp://127.0.0.1:8554/teststream"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
 }
 
-func TestServerReadSetupPath(t *testing.T) {
+func TestServerReadDescribeAccept(t *testing.T) {
 	for _, ca := range []struct {
-		name    string
-		url     string
-		path    string
-		trackID int
+		name   string
+		accept base.HeaderValue
+		status base.StatusCode
 	}{
-		{
-			"normal",
-			"rtsp://localhost:8554/teststream/trackID=2",
-			"teststream",
-			2,
-		},
-		{
-			// this is needed to support reading mpegts with ffmpeg
-			"without track id",
-			"rtsp://localhost:8554/teststream/",
-			"teststream",
-			0,
-		},
-		{
-			"subpath",
-			"rtsp://localhost:8554/test/stream/trackID=0",
-			"test/stream",
-			0,
-		},
-		{
-			"subpath without track id",
-			"rtsp://localhost:8554/test/stream/",
-			"test/stream",
-			0,
-		},
+		{"missing", nil, base.StatusOK},
+		{"sdp", base.HeaderValue{"application/sdp"}, base.StatusOK},
+		{"list", base.HeaderValue{"text/html, application/sdp;q=0.5"}, base.StatusOK},
+		{"wildcard", base.HeaderValue{"*/*"}, base.StatusOK},
+		{"unsupported", base.HeaderValue{"text/html"}, base.StatusNotAcceptable},
 	} {
 		t.Run(ca.name, func(t *testing.T) {
 			track := &TrackH264{
@@ -76,18 +689,12 @@ func TestServerReadSetupPath(t *testing.T) {
 				PPS:         []byte{0x01, 0x02, 0x03, 0x04},
 			}
 
-			stream := NewServerStream(Tracks{track, track, track, track, track})
+			stream := NewServerStream(Tracks{track})
 			defer stream.Close()
 
 			s := &Server{
 				handler: &testServerHandler{
-					onSetup: func(
-						_ *ServerSession,
-						path string,
-						trackID int,
-					) (*base.Response, *ServerStream, error) {
-						require.Equal(t, ca.path, path)
-						require.Equal(t, ca.trackID, trackID)
+					onDescribe: func(string) (*base.Response, *ServerStream, error) {
 						return &base.Response{
 							StatusCode: base.StatusOK,
 						}, stream, nil
@@ -105,28 +712,63 @@ func TestServerReadSetupPath(t *testing.T) {
 			defer nconn.Close()
 			conn := conn.NewConn(nconn)
 
-			th := &headers.Transport{
-				Mode: func() *headers.TransportMode {
-					v := headers.TransportModePlay
-					return &v
-				}(),
-				InterleavedIDs: &[2]int{ca.trackID * 2, (ca.trackID * 2) + 1},
+			header := base.Header{"CSeq": base.HeaderValue{"1"}}
+			if ca.accept != nil {
+				header["Accept"] = ca.accept
 			}
 
 			res, err := writeReqReadRes(conn, base.Request{
-				Method: base.Setup,
-				URL:    mustParseURL(ca.url),
-				Header: base.Header{
-					"CSeq":      base.HeaderValue{"1"},
-					"Transport": th.Marshal(),
-				},
+				Method: base.Describe,
+				URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+				Header: header,
 			})
 			require.NoError(t, err)
-			require.Equal(t, base.StatusOK, res.StatusCode)
+			require.Equal(t, ca.status, res.StatusCode)
 		})
 	}
 }
 
+func TestServerOnRequest(t *testing.T) {
+	s := &Server{
+		handler: &testServerHandler{
+			onRequest: func(_ *ServerConn, req *base.Request) (*base.Response, error) {
+				if req.Method == base.Describe {
+					return &base.Response{
+						StatusCode: base.StatusUnauthorized,
+					}, nil
+				}
+				return nil, nil
+			},
+		},
+		rtspAddress: "localhost:8554",
+	}
+
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer nconn.Close()
+	conn := conn.NewConn(nconn)
+
+	res, err := writeReqReadRes(conn, base.Request{
+		Method: base.Describe,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{"CSeq": base.HeaderValue{"1"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusUnauthorized, res.StatusCode)
+
+	res, err = writeReqReadRes(conn, base.Request{
+		Method: base.Options,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{"CSeq": base.HeaderValue{"2"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+}
+
 func TestServerReadSetupErrors(t *testing.T) {
 	for _, ca := range []string{
 		"different paths",
@@ -154,7 +796,9 @@ func TestServerReadSetupErrors(t *testing.T) {
 					onConnClose: func(_ *ServerConn, err error) {
 						switch ca {
 						case "different paths":
-							require.EqualError(t, err, "read: can't setup tracks with different paths")
+							require.EqualError(t, err, "read: can't setup tracks with different paths:"+
+								" 'teststream' and 'test12stream',"+
+								" paths are compared without trailing slash, query and track ID")
 
 						case "double setup":
 							require.EqualError(t, err, "read: track 0 has already been setup")
@@ -277,7 +921,7 @@ func TestServerReadTCPResponseBeforeFrames(t *testing.T) {
 					StatusCode: base.StatusOK,
 				}, stream, nil
 			},
-			onPlay: func(*ServerSession) (*base.Response, error) {
+			onPlay: func(*ServerSession, headers.Range) (*base.Response, error) {
 				go func() {
 					defer close(writerDone)
 
@@ -377,7 +1021,7 @@ func TestServerReadWithoutTeardown(t *testing.T) {
 			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
 				return &base.Response{StatusCode: base.StatusOK}, stream, nil
 			},
-			onPlay: func(*ServerSession) (*base.Response, error) {
+			onPlay: func(*ServerSession, headers.Range) (*base.Response, error) {
 				return &base.Response{StatusCode: base.StatusOK}, nil
 			},
 		},
@@ -435,3 +1079,254 @@ func TestServerReadWithoutTeardown(t *testing.T) {
 	<-sessionClosed
 	<-connClosed
 }
+
+func TestServerReadPlayRange(t *testing.T) {
+	for _, ca := range []struct {
+		name        string
+		header      base.HeaderValue
+		status      base.StatusCode
+		expected    *headers.Range
+		responseRng base.HeaderValue
+	}{
+		{
+			"missing",
+			nil,
+			base.StatusOK,
+			&headers.Range{Value: &headers.RangeNPT{Start: headers.RangeNPTNow()}},
+			base.HeaderValue{"npt=now-"},
+		},
+		{
+			"npt",
+			base.HeaderValue{"npt=0:01:10.5-"},
+			base.StatusOK,
+			&headers.Range{Value: &headers.RangeNPT{
+				Start: headers.RangeNPTTime{Duration: 70500 * time.Millisecond},
+			}},
+			base.HeaderValue{"npt=70.5-"},
+		},
+		{
+			"clock",
+			base.HeaderValue{"clock=20000101T000000Z-"},
+			base.StatusOK,
+			&headers.Range{Value: &headers.RangeUTC{
+				Start: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			}},
+			base.HeaderValue{"clock=20000101T000000Z-"},
+		},
+		{
+			"invalid",
+			base.HeaderValue{"npt=x-"},
+			base.StatusInvalidRange,
+			nil,
+			nil,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			track := &TrackH264{
+				PayloadType: 96,
+				SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+				PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+			}
+
+			stream := NewServerStream(Tracks{track})
+			defer stream.Close()
+
+			var received *headers.Range
+			s := &Server{
+				rtspAddress: "localhost:8554",
+				handler: &testServerHandler{
+					onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+						return &base.Response{
+							StatusCode: base.StatusOK,
+						}, stream, nil
+					},
+					onPlay: func(_ *ServerSession, rng headers.Range) (*base.Response, error) {
+						received = &rng
+						return &base.Response{
+							StatusCode: base.StatusOK,
+						}, nil
+					},
+				},
+			}
+
+			err := s.Start()
+			require.NoError(t, err)
+			defer s.Close()
+
+			nconn, err := net.Dial("tcp", "localhost:8554")
+			require.NoError(t, err)
+			defer nconn.Close()
+			conn := conn.NewConn(nconn)
+
+			res, err := writeReqReadRes(conn, base.Request{
+				Method: base.Setup,
+				URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+				Header: base.Header{
+					"CSeq": base.HeaderValue{"1"},
+					"Transport": headers.Transport{
+						Mode: func() *headers.TransportMode {
+							v := headers.TransportModePlay
+							return &v
+						}(),
+						InterleavedIDs: &[2]int{0, 1},
+					}.Marshal(),
+				},
+			})
+			require.NoError(t, err)
+			require.Equal(t, base.StatusOK, res.StatusCode)
+
+			var sx headers.Session
+			err = sx.Unmarshal(res.Header["Session"])
+			require.NoError(t, err)
+
+			header := base.Header{
+				"CSeq":    base.HeaderValue{"2"},
+				"Session": base.HeaderValue{sx.Session},
+			}
+			if ca.header != nil {
+				header["Range"] = ca.header
+			}
+			res, err = writeReqReadRes(conn, base.Request{
+				Method: base.Play,
+				URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+				Header: header,
+			})
+			require.NoError(t, err)
+			require.Equal(t, ca.status, res.StatusCode)
+			require.Equal(t, ca.expected, received)
+			require.Equal(t, ca.responseRng, res.Header["Range"])
+		})
+	}
+}
+
+func TestServerReadRTCPReceiverReport(t *testing.T) {
+	track := &TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}
+
+	stream := NewServerStream(Tracks{track})
+	defer stream.Close()
+
+	sessions := make(chan *ServerSession, 1)
+	connClosed := make(chan error, 1)
+
+	s := &Server{
+		rtspAddress: "localhost:8554",
+		handler: &testServerHandler{
+			onConnClose: func(_ *ServerConn, err error) {
+				connClosed <- err
+			},
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, stream, nil
+			},
+			onPlay: func(ss *ServerSession, _ headers.Range) (*base.Response, error) {
+				sessions <- ss
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, nil
+			},
+		},
+	}
+
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer nconn.Close()
+	conn := conn.NewConn(nconn)
+
+	res, err := writeReqReadRes(conn, base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+			"Transport": headers.Transport{
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModePlay
+					return &v
+				}(),
+				InterleavedIDs: &[2]int{0, 1},
+			}.Marshal(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	var sx headers.Session
+	err = sx.Unmarshal(res.Header["Session"])
+	require.NoError(t, err)
+
+	res, err = writeReqReadRes(conn, base.Request{
+		Method: base.Play,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"2"},
+			"Session": base.HeaderValue{sx.Session},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	ss := <-sessions
+	require.Empty(t, ss.ClientReports())
+
+	// Fraction lost 64/256, cumulative lost 3, jitter 9000.
+	// Followed by a SDES packet like VLC sends.
+	err = conn.WriteInterleavedFrame(&base.InterleavedFrame{
+		Channel: 1,
+		Payload: []byte{
+			0x81, 0xc9, 0x00, 0x07, 0x65, 0xf8, 0x3a, 0xfb,
+			0xba, 0x9d, 0xa4, 0x16, 0x40, 0x00, 0x00, 0x03,
+			0x00, 0x00, 0x00, 0x64, 0x00, 0x00, 0x23, 0x28,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x81, 0xca, 0x00, 0x02, 0x65, 0xf8, 0x3a, 0xfb,
+			0x01, 0x00, 0x00, 0x00,
+		},
+	}, make([]byte, 1024))
+	require.NoError(t, err)
+
+	// Invalid RTCP packets are ignored.
+	err = conn.WriteInterleavedFrame(&base.InterleavedFrame{
+		Channel: 1,
+		Payload: []byte{0x01, 0x02},
+	}, make([]byte, 1024))
+	require.NoError(t, err)
+
+	// The session survives and frames are processed before the request.
+	res, err = writeReqReadRes(conn, base.Request{
+		Method: base.Options,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"3"},
+			"Session": base.HeaderValue{sx.Session},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	reports := ss.ClientReports()
+	require.Len(t, reports, 1)
+	report := reports[0]
+	require.WithinDuration(t, time.Now(), report.LastReport, 5*time.Second)
+	report.LastReport = time.Time{}
+	require.Equal(t, ClientReport{
+		FractionLost: 0.25,
+		PacketsLost:  3,
+		Jitter:       0.1,
+	}, report)
+
+	err = conn.WriteInterleavedFrame(&base.InterleavedFrame{
+		Channel: 5,
+		Payload: []byte{0x01, 0x02, 0x03, 0x04},
+	}, make([]byte, 1024))
+	require.NoError(t, err)
+
+	err = <-connClosed
+	require.ErrorIs(t, err, liberrors.ErrServerUnknownChannel)
+	require.EqualError(t, err, "read: received interleaved frame on unknown channel: 5")
+}
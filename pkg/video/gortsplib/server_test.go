@@ -1,14 +1,20 @@
 package gortsplib
 
 import (
+	"crypto/rand"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"nvr/pkg/video/gortsplib/pkg/base"
 	"nvr/pkg/video/gortsplib/pkg/conn"
 	"nvr/pkg/video/gortsplib/pkg/headers"
+	"nvr/pkg/video/gortsplib/pkg/liberrors"
 
 	"github.com/pion/rtp"
 	"github.com/stretchr/testify/require"
@@ -23,15 +29,28 @@ func writeReqReadRes(conn *conn.Conn, req base.Request) (*base.Response, error)
 }
 
 type testServerHandler struct {
+	onRequest      func(*ServerConn, *base.Request) (*base.Response, error)
 	onConnClose    func(*ServerConn, error)
 	onSessionOpen  func(*ServerSession, *ServerConn, string)
 	onSessionClose func(*ServerSession, error)
+	onDescribe     func(string) (*base.Response, *ServerStream, error)
 	onAnnounce     func(*ServerSession, string, Tracks) (*base.Response, error)
 	onSetup        func(*ServerSession, string, int) (*base.Response, *ServerStream, error)
-	onPlay         func(*ServerSession) (*base.Response, error)
+	onPlay         func(*ServerSession, headers.Range) (*base.Response, error)
 	onRecord       func(*ServerSession) (*base.Response, error)
 	onPacketRTP    func(*ServerSession, int, *rtp.Packet)
 	onDecodeError  func(*ServerSession, error)
+	onLimitReached func(net.Addr, error)
+}
+
+func (sh *testServerHandler) OnRequest(
+	sc *ServerConn,
+	req *base.Request,
+) (*base.Response, error) {
+	if sh.onRequest != nil {
+		return sh.onRequest(sc, req)
+	}
+	return nil, nil
 }
 
 func (sh *testServerHandler) OnConnClose(sc *ServerConn, err error) {
@@ -59,6 +78,9 @@ func (sh *testServerHandler) OnSessionClose(session *ServerSession, err error) {
 func (sh *testServerHandler) OnDescribe(
 	pathName string,
 ) (*base.Response, *ServerStream, error) {
+	if sh.onDescribe != nil {
+		return sh.onDescribe(pathName)
+	}
 	return nil, nil, fmt.Errorf("unimplemented")
 }
 
@@ -86,9 +108,10 @@ func (sh *testServerHandler) OnSetup(
 
 func (sh *testServerHandler) OnPlay(
 	session *ServerSession,
+	rng headers.Range,
 ) (*base.Response, error) {
 	if sh.onPlay != nil {
-		return sh.onPlay(session)
+		return sh.onPlay(session, rng)
 	}
 	return nil, fmt.Errorf("unimplemented")
 }
@@ -133,6 +156,58 @@ func TestServerClose(t *testing.T) {
 	s.Close()
 }
 
+func TestServerInvalidAddress(t *testing.T) {
+	// IPv6 hosts must be enclosed in brackets.
+	s := &Server{
+		handler:     &testServerHandler{},
+		rtspAddress: "::1:8554",
+	}
+	err := s.Start()
+	require.ErrorIs(t, err, ErrServerInvalidRTSPaddress)
+}
+
+func TestNewSessionSecretID(t *testing.T) {
+	t.Run("format", func(t *testing.T) {
+		id, err := newSessionSecretID(nil, rand.Read)
+		require.NoError(t, err)
+		require.Regexp(t, `^[0-9a-f]{32}$`, id)
+	})
+	t.Run("unique", func(t *testing.T) {
+		sessions := make(map[sessionKey]*ServerSession)
+		for i := 0; i < 100000; i++ {
+			id, err := newSessionSecretID(sessions, rand.Read)
+			require.NoError(t, err)
+			key := newSessionKey(id)
+			_, exists := sessions[key]
+			require.False(t, exists)
+			sessions[key] = nil
+		}
+	})
+	t.Run("collision", func(t *testing.T) {
+		// The first ID is in use.
+		calls := 0
+		randRead := func(b []byte) (int, error) {
+			calls++
+			for i := range b {
+				b[i] = byte(calls)
+			}
+			return len(b), nil
+		}
+		sessions := map[sessionKey]*ServerSession{
+			newSessionKey(strings.Repeat("01", sessionIDSize)): nil,
+		}
+		id, err := newSessionSecretID(sessions, randRead)
+		require.NoError(t, err)
+		require.Equal(t, strings.Repeat("02", sessionIDSize), id)
+		require.Equal(t, 2, calls)
+	})
+	t.Run("randError", func(t *testing.T) {
+		randRead := func([]byte) (int, error) { return 0, io.ErrUnexpectedEOF }
+		_, err := newSessionSecretID(nil, randRead)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
 func TestServerCSeq(t *testing.T) {
 	s := &Server{
 		rtspAddress: "localhost:8554",
@@ -192,6 +267,74 @@ func TestServerErrorCSeqMissing(t *testing.T) {
 	<-connClosed
 }
 
+func TestServerErrorRequestBody(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		req    string
+		status base.StatusCode
+		err    error
+	}{
+		{
+			"missing length",
+			"ANNOUNCE rtsp://localhost:8554/teststream RTSP/1.0\r\n" +
+				"CSeq: 1\r\n" +
+				"Content-Type: application/sdp\r\n" +
+				"\r\n" +
+				"v=0\r\n",
+			base.StatusLengthRequired,
+			base.ErrBodyContentLengthMissing,
+		},
+		{
+			"oversized body",
+			"ANNOUNCE rtsp://localhost:8554/teststream RTSP/1.0\r\n" +
+				"CSeq: 1\r\n" +
+				"Content-Type: application/sdp\r\n" +
+				"Content-Length: 11\r\n" +
+				"\r\n" +
+				"v=0\r\ns=abc\r\n",
+			base.StatusRequestEntityTooLarge,
+			base.ErrBodyContentLengthToBig,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			connClosed := make(chan struct{})
+
+			s := &Server{
+				handler: &testServerHandler{
+					onConnClose: func(_ *ServerConn, err error) {
+						require.ErrorIs(t, err, ca.err)
+						close(connClosed)
+					},
+				},
+				rtspAddress: "localhost:8554",
+				maxBodySize: 10,
+			}
+			err := s.Start()
+			require.NoError(t, err)
+			defer s.Close()
+
+			nconn, err := net.Dial("tcp", "localhost:8554")
+			require.NoError(t, err)
+			defer nconn.Close()
+			conn := conn.NewConn(nconn)
+
+			_, err = nconn.Write([]byte(ca.req))
+			require.NoError(t, err)
+
+			res, err := conn.ReadResponse()
+			require.NoError(t, err)
+			require.Equal(t, ca.status, res.StatusCode)
+			require.Equal(t, base.HeaderValue{"1"}, res.Header["CSeq"])
+
+			<-connClosed
+
+			// The body must not be parsed as the next request.
+			_, err = conn.ReadResponse()
+			require.ErrorIs(t, err, io.EOF)
+		})
+	}
+}
+
 func TestServerErrorMethodNotImplemented(t *testing.T) {
 	for _, ca := range []string{"outside session", "inside session"} {
 		t.Run(ca, func(t *testing.T) {
@@ -296,7 +439,7 @@ func TestServerErrorTCPTwoConnOneSession(t *testing.T) {
 					StatusCode: base.StatusOK,
 				}, stream, nil
 			},
-			onPlay: func(*ServerSession) (*base.Response, error) {
+			onPlay: func(*ServerSession, headers.Range) (*base.Response, error) {
 				return &base.Response{
 					StatusCode: base.StatusOK,
 				}, nil
@@ -387,7 +530,7 @@ func TestServerErrorTCPOneConnTwoSessions(t *testing.T) {
 					StatusCode: base.StatusOK,
 				}, stream, nil
 			},
-			onPlay: func(*ServerSession) (*base.Response, error) {
+			onPlay: func(*ServerSession, headers.Range) (*base.Response, error) {
 				return &base.Response{
 					StatusCode: base.StatusOK,
 				}, nil
@@ -457,6 +600,8 @@ func TestServerErrorTCPOneConnTwoSessions(t *testing.T) {
 
 func TestServerErrorInvalidSession(t *testing.T) {
 	for _, method := range []base.Method{
+		base.Announce,
+		base.Setup,
 		base.Play,
 		base.Record,
 		base.Teardown,
@@ -464,7 +609,7 @@ func TestServerErrorInvalidSession(t *testing.T) {
 		t.Run(string(method), func(t *testing.T) {
 			s := &Server{
 				handler: &testServerHandler{
-					onPlay: func(*ServerSession) (*base.Response, error) {
+					onPlay: func(*ServerSession, headers.Range) (*base.Response, error) {
 						return &base.Response{
 							StatusCode: base.StatusOK,
 						}, nil
@@ -700,3 +845,657 @@ func TestServerSessionTeardown(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, base.StatusOK, res.StatusCode)
 }
+
+func (sh *testServerHandler) OnLimitReached(addr net.Addr, err error) {
+	if sh.onLimitReached != nil {
+		sh.onLimitReached(addr, err)
+	}
+}
+
+func TestServerDrain(t *testing.T) {
+	setup := func(conn *conn.Conn, cseq string) (*base.Response, error) {
+		return writeReqReadRes(conn, base.Request{
+			Method: base.Setup,
+			URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+			Header: base.Header{
+				"CSeq": base.HeaderValue{cseq},
+				"Transport": headers.Transport{
+					Mode: func() *headers.TransportMode {
+						v := headers.TransportModePlay
+						return &v
+					}(),
+					InterleavedIDs: &[2]int{0, 1},
+				}.Marshal(),
+			},
+		})
+	}
+
+	cases := map[string]struct {
+		redirectHost string
+		checkNew     func(*testing.T, *base.Response)
+		checkOld     func(*testing.T, *conn.Conn)
+	}{
+		"503": {
+			redirectHost: "",
+			checkNew: func(t *testing.T, res *base.Response) {
+				require.Equal(t, base.StatusServiceUnavailable, res.StatusCode)
+				require.Equal(t, base.HeaderValue{"1"}, res.Header["Retry-After"])
+			},
+			checkOld: func(t *testing.T, conn *conn.Conn) {
+				_, err := conn.ReadResponse()
+				require.Error(t, err)
+			},
+		},
+		"redirect": {
+			redirectHost: "otherhost:8554",
+			checkNew: func(t *testing.T, res *base.Response) {
+				require.Equal(t, base.StatusFound, res.StatusCode)
+				require.Equal(t,
+					base.HeaderValue{"rtsp://otherhost:8554/teststream/trackID=0"},
+					res.Header["Location"],
+				)
+			},
+			checkOld: func(t *testing.T, conn *conn.Conn) {
+				req, err := conn.ReadRequest()
+				require.NoError(t, err)
+				require.Equal(t, base.Redirect, req.Method)
+				require.Equal(t, "rtsp://otherhost:8554/teststream", req.URL.String())
+			},
+		},
+		"redirectIPv6": {
+			redirectHost: "::1",
+			checkNew: func(t *testing.T, res *base.Response) {
+				require.Equal(t, base.StatusFound, res.StatusCode)
+				require.Equal(t,
+					base.HeaderValue{"rtsp://[::1]/teststream/trackID=0"},
+					res.Header["Location"],
+				)
+			},
+			checkOld: func(t *testing.T, conn *conn.Conn) {
+				req, err := conn.ReadRequest()
+				require.NoError(t, err)
+				require.Equal(t, base.Redirect, req.Method)
+				require.Equal(t, "rtsp://[::1]/teststream", req.URL.String())
+				require.Equal(t, base.HeaderValue{"rtsp://[::1]/teststream"}, req.Header["Location"])
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			stream := NewServerStream(Tracks{&TrackH264{
+				PayloadType: 96,
+				SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+				PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+			}})
+			defer stream.Close()
+
+			sessionClosed := make(chan error)
+			s := &Server{
+				handler: &testServerHandler{
+					onSessionClose: func(_ *ServerSession, err error) {
+						sessionClosed <- err
+					},
+					onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+						return &base.Response{
+							StatusCode: base.StatusOK,
+						}, stream, nil
+					},
+				},
+				rtspAddress: "localhost:8554",
+			}
+
+			err := s.Start()
+			require.NoError(t, err)
+			defer s.Close()
+
+			// Existing session.
+			nconn1, err := net.Dial("tcp", "localhost:8554")
+			require.NoError(t, err)
+			defer nconn1.Close()
+			conn1 := conn.NewConn(nconn1)
+
+			res, err := setup(conn1, "1")
+			require.NoError(t, err)
+			require.Equal(t, base.StatusOK, res.StatusCode)
+
+			var sx headers.Session
+			err = sx.Unmarshal(res.Header["Session"])
+			require.NoError(t, err)
+
+			require.False(t, s.Draining())
+			s.Drain(tc.redirectHost, 500*time.Millisecond)
+			require.True(t, s.Draining())
+
+			// New client.
+			nconn2, err := net.Dial("tcp", "localhost:8554")
+			require.NoError(t, err)
+			defer nconn2.Close()
+			conn2 := conn.NewConn(nconn2)
+
+			res, err = setup(conn2, "1")
+			require.NoError(t, err)
+			tc.checkNew(t, res)
+
+			// The existing session survives until the grace period ends.
+			res, err = writeReqReadRes(conn1, base.Request{
+				Method: base.Options,
+				URL:    mustParseURL("rtsp://localhost:8554/"),
+				Header: base.Header{
+					"CSeq":    base.HeaderValue{"2"},
+					"Session": base.HeaderValue{sx.Session},
+				},
+			})
+			require.NoError(t, err)
+			require.Equal(t, base.StatusOK, res.StatusCode)
+
+			select {
+			case <-sessionClosed:
+				t.Fatal("session closed before grace period")
+			case <-time.After(200 * time.Millisecond):
+			}
+
+			tc.checkOld(t, conn1)
+			require.ErrorIs(t, <-sessionClosed, ErrServerDrained)
+		})
+	}
+}
+
+func TestServerDrainClose(t *testing.T) {
+	stream := NewServerStream(Tracks{&TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}})
+	defer stream.Close()
+
+	s := &Server{
+		handler: &testServerHandler{
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, stream, nil
+			},
+		},
+		rtspAddress: "localhost:8554",
+	}
+
+	err := s.Start()
+	require.NoError(t, err)
+
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer nconn.Close()
+	conn := conn.NewConn(nconn)
+
+	res, err := writeReqReadRes(conn, base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+			"Transport": headers.Transport{
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModePlay
+					return &v
+				}(),
+				InterleavedIDs: &[2]int{0, 1},
+			}.Marshal(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	var sx headers.Session
+	err = sx.Unmarshal(res.Header["Session"])
+	require.NoError(t, err)
+
+	s.Drain("", time.Hour)
+
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+
+	// Close waits for the session.
+	select {
+	case <-closed:
+		t.Fatal("closed before session finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	res, err = writeReqReadRes(conn, base.Request{
+		Method: base.Teardown,
+		URL:    mustParseURL("rtsp://localhost:8554/"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"2"},
+			"Session": base.HeaderValue{sx.Session},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("close timed out")
+	}
+}
+
+func TestServerReadTimeoutHalfOpen(t *testing.T) {
+	connClosed := make(chan error)
+
+	s := &Server{
+		handler: &testServerHandler{
+			onConnClose: func(_ *ServerConn, err error) {
+				connClosed <- err
+			},
+		},
+		readTimeout: 200 * time.Millisecond,
+		rtspAddress: "localhost:8554",
+	}
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer nconn.Close()
+
+	// Incomplete request.
+	_, err = nconn.Write([]byte("OPTIONS rtsp://localhost:8554/ RTSP/1.0\r\nCSeq"))
+	require.NoError(t, err)
+
+	select {
+	case err := <-connClosed:
+		require.ErrorAs(t, err, &liberrors.ServerConnTimeoutError{})
+		require.EqualError(t, err, "read: read timed out")
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not closed")
+	}
+
+	nconn.SetReadDeadline(time.Now().Add(time.Second)) //nolint:errcheck
+	_, err = nconn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestServerWriteTimeout(t *testing.T) {
+	stream := NewServerStream(Tracks{&TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}})
+	defer stream.Close()
+
+	connClosed := make(chan error, 2)
+	sessionClosed := make(chan struct{}, 2)
+
+	s := &Server{
+		handler: &testServerHandler{
+			onConnClose: func(_ *ServerConn, err error) {
+				connClosed <- err
+			},
+			onSessionOpen: func(_ *ServerSession, sc *ServerConn, _ string) {
+				// Fill the buffers quickly.
+				sc.NetConn().(*net.TCPConn).SetWriteBuffer(4096) //nolint:errcheck,forcetypeassert
+			},
+			onSessionClose: func(*ServerSession, error) {
+				sessionClosed <- struct{}{}
+			},
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, stream, nil
+			},
+			onPlay: func(*ServerSession, headers.Range) (*base.Response, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, nil
+			},
+		},
+		writeTimeout: 200 * time.Millisecond,
+		rtspAddress:  "localhost:8554",
+	}
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	play := func() *conn.Conn {
+		nconn, err := net.Dial("tcp", "localhost:8554")
+		require.NoError(t, err)
+		t.Cleanup(func() { nconn.Close() })
+		nconn.(*net.TCPConn).SetReadBuffer(4096) //nolint:errcheck,forcetypeassert
+		conn := conn.NewConn(nconn)
+
+		res, err := writeReqReadRes(conn, base.Request{
+			Method: base.Setup,
+			URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+			Header: base.Header{
+				"CSeq": base.HeaderValue{"1"},
+				"Transport": headers.Transport{
+					Mode: func() *headers.TransportMode {
+						v := headers.TransportModePlay
+						return &v
+					}(),
+					InterleavedIDs: &[2]int{0, 1},
+				}.Marshal(),
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, base.StatusOK, res.StatusCode)
+
+		var sx headers.Session
+		err = sx.Unmarshal(res.Header["Session"])
+		require.NoError(t, err)
+
+		res, err = writeReqReadRes(conn, base.Request{
+			Method: base.Play,
+			URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+			Header: base.Header{
+				"CSeq":    base.HeaderValue{"2"},
+				"Session": base.HeaderValue{sx.Session},
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, base.StatusOK, res.StatusCode)
+		return conn
+	}
+
+	// The first client never reads.
+	play()
+	healthy := play()
+
+	var received atomic.Int64
+	go func() {
+		for {
+			if _, err := healthy.ReadInterleavedFrame(); err != nil {
+				return
+			}
+			received.Add(1)
+		}
+	}()
+
+	writerDone := make(chan struct{})
+	writerTerminate := make(chan struct{})
+	defer func() {
+		close(writerTerminate)
+		<-writerDone
+	}()
+	go func() {
+		defer close(writerDone)
+		pkt := testRTPPacket
+		pkt.Payload = make([]byte, 1000)
+		for {
+			select {
+			case <-time.After(time.Millisecond):
+				stream.WritePacketRTP(0, &pkt)
+			case <-writerTerminate:
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-connClosed:
+		require.ErrorAs(t, err, &liberrors.ServerConnTimeoutError{})
+		require.EqualError(t, err, "write: write timed out")
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked connection was not closed")
+	}
+	select {
+	case <-sessionClosed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked session was not closed")
+	}
+
+	// The other session is not stalled.
+	n := received.Load()
+	require.Eventually(t, func() bool {
+		return received.Load() > n
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Empty(t, sessionClosed)
+}
+
+// writePipelined writes the requests in a single write.
+func writePipelined(t *testing.T, nconn net.Conn, reqs ...base.Request) {
+	t.Helper()
+	var buf []byte
+	for _, req := range reqs {
+		byts, err := req.Marshal()
+		require.NoError(t, err)
+		buf = append(buf, byts...)
+	}
+	_, err := nconn.Write(buf)
+	require.NoError(t, err)
+}
+
+func TestServerPipelinedRequests(t *testing.T) {
+	track := &TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}
+	stream := NewServerStream(Tracks{track})
+	defer stream.Close()
+
+	s := &Server{
+		handler: &testServerHandler{
+			onDescribe: func(string) (*base.Response, *ServerStream, error) {
+				// The following requests are buffered while this is handled.
+				time.Sleep(20 * time.Millisecond)
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, stream, nil
+			},
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, stream, nil
+			},
+		},
+		rtspAddress: "localhost:8554",
+	}
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer nconn.Close()
+	conn := conn.NewConn(nconn)
+
+	writePipelined(t, nconn,
+		base.Request{
+			Method: base.Options,
+			URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+			Header: base.Header{"CSeq": base.HeaderValue{"1"}},
+		},
+		base.Request{
+			Method: base.Describe,
+			URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+			Header: base.Header{"CSeq": base.HeaderValue{"2"}},
+		},
+		base.Request{
+			Method: base.Setup,
+			URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+			Header: base.Header{
+				"CSeq": base.HeaderValue{"3"},
+				"Transport": headers.Transport{
+					Mode: func() *headers.TransportMode {
+						v := headers.TransportModePlay
+						return &v
+					}(),
+					InterleavedIDs: &[2]int{0, 1},
+				}.Marshal(),
+			},
+		},
+	)
+
+	res, err := conn.ReadResponse()
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"1"}, res.Header["CSeq"])
+	require.Contains(t, res.Header, "Public")
+
+	res, err = conn.ReadResponse()
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"2"}, res.Header["CSeq"])
+	require.Equal(t, stream.Tracks().Marshal(), res.Body)
+
+	res, err = conn.ReadResponse()
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+	require.Equal(t, base.HeaderValue{"3"}, res.Header["CSeq"])
+	require.Contains(t, res.Header, "Session")
+	require.Contains(t, res.Header, "Transport")
+}
+
+func TestServerPipelinedRequestsError(t *testing.T) {
+	connClosed := make(chan struct{})
+	s := &Server{
+		handler: &testServerHandler{
+			onConnClose: func(_ *ServerConn, err error) {
+				require.EqualError(t, err, "read: CSeq is missing")
+				close(connClosed)
+			},
+		},
+		rtspAddress: "localhost:8554",
+	}
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer nconn.Close()
+	conn := conn.NewConn(nconn)
+
+	writePipelined(t, nconn,
+		base.Request{
+			Method: base.Options,
+			URL:    mustParseURL("rtsp://localhost:8554/"),
+			Header: base.Header{},
+		},
+		base.Request{
+			Method: base.Options,
+			URL:    mustParseURL("rtsp://localhost:8554/"),
+			Header: base.Header{"CSeq": base.HeaderValue{"2"}},
+		},
+	)
+
+	// The request after the error isn't answered.
+	res, err := conn.ReadResponse()
+	require.NoError(t, err)
+	require.Equal(t, base.StatusBadRequest, res.StatusCode)
+
+	<-connClosed
+	_, err = conn.ReadResponse()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestServerPipelinedRequestsWhilePlaying(t *testing.T) {
+	track := &TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}
+	stream := NewServerStream(Tracks{track})
+	defer stream.Close()
+
+	s := &Server{
+		handler: &testServerHandler{
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, stream, nil
+			},
+			onPlay: func(*ServerSession, headers.Range) (*base.Response, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, nil
+			},
+		},
+		rtspAddress: "localhost:8554",
+	}
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer nconn.Close()
+	conn := conn.NewConn(nconn)
+
+	res, err := writeReqReadRes(conn, base.Request{
+		Method: base.Setup,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream/trackID=0"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+			"Transport": headers.Transport{
+				Mode: func() *headers.TransportMode {
+					v := headers.TransportModePlay
+					return &v
+				}(),
+				InterleavedIDs: &[2]int{0, 1},
+			}.Marshal(),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	var sx headers.Session
+	err = sx.Unmarshal(res.Header["Session"])
+	require.NoError(t, err)
+
+	res, err = writeReqReadRes(conn, base.Request{
+		Method: base.Play,
+		URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+		Header: base.Header{
+			"CSeq":    base.HeaderValue{"2"},
+			"Session": base.HeaderValue{sx.Session},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	writerDone := make(chan struct{})
+	writerTerminate := make(chan struct{})
+	defer func() {
+		close(writerTerminate)
+		<-writerDone
+	}()
+	go func() {
+		defer close(writerDone)
+		for {
+			select {
+			case <-time.After(time.Millisecond):
+				stream.WritePacketRTP(0, &testRTPPacket)
+			case <-writerTerminate:
+				return
+			}
+		}
+	}()
+
+	var reqs []base.Request
+	for cseq := 3; cseq <= 5; cseq++ {
+		reqs = append(reqs, base.Request{
+			Method: base.Options,
+			URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+			Header: base.Header{
+				"CSeq":    base.HeaderValue{strconv.Itoa(cseq)},
+				"Session": base.HeaderValue{sx.Session},
+			},
+		})
+	}
+	writePipelined(t, nconn, reqs...)
+
+	// The responses are never written in the middle of a frame.
+	for cseq := 3; cseq <= 5; cseq++ {
+		res, err := conn.ReadResponseIgnoreFrames()
+		require.NoError(t, err)
+		require.Equal(t, base.StatusOK, res.StatusCode)
+		require.Equal(t, base.HeaderValue{strconv.Itoa(cseq)}, res.Header["CSeq"])
+	}
+	_, err = conn.ReadInterleavedFrame()
+	require.NoError(t, err)
+}
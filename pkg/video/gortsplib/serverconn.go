@@ -2,6 +2,7 @@ package gortsplib
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"net"
@@ -19,9 +20,18 @@ func getSessionID(header base.Header) string {
 	return ""
 }
 
+// Requests that a client pipelines are read ahead while they're buffered
+// and answered in order. The reader waits for the answers before blocking
+// on the connection, a slow handler must not cause a read timeout.
+const maxPipelinedRequests = 8
+
 type readReq struct {
 	req *base.Request
-	res chan error
+	res chan error // Buffered.
+
+	// The request body couldn't be read, the
+	// error is answered and the connection closed.
+	bodyErr error
 }
 
 // ServerConn is a server-side RTSP connection.
@@ -32,12 +42,14 @@ type ServerConn struct {
 	ctx        context.Context
 	ctxCancel  func()
 	remoteAddr *net.TCPAddr
+	localAddr  net.Addr
 	conn       *conn.Conn
 	session    *ServerSession
 	readFunc   func(readRequest chan readReq) error
 
 	// in
 	sessionRemove chan *ServerSession
+	writeErr      chan error
 
 	// out
 	done chan struct{}
@@ -54,7 +66,9 @@ func newServerConn(
 		ctx:           ctx,
 		ctxCancel:     ctxCancel,
 		remoteAddr:    nconn.RemoteAddr().(*net.TCPAddr),
+		localAddr:     nconn.LocalAddr(),
 		sessionRemove: make(chan *ServerSession),
+		writeErr:      make(chan error, 1),
 		done:          make(chan struct{}),
 	}
 
@@ -77,6 +91,12 @@ func (sc *ServerConn) NetConn() net.Conn {
 	return sc.nconn
 }
 
+// IsLocal returns true if the client is on the same host as the server,
+// it connected from a loopback address or from the address of the server.
+func (sc *ServerConn) IsLocal() bool {
+	return isLocalAddr(sc.remoteAddr, sc.localAddr)
+}
+
 func (sc *ServerConn) Session() *ServerSession {
 	return sc.session
 }
@@ -94,8 +114,10 @@ func (sc *ServerConn) run() {
 	defer close(sc.done)
 
 	sc.conn = conn.NewConn(sc.nconn)
+	sc.conn.SetMaxBodySize(sc.s.maxBodySize)
+	sc.conn.SetFramePool(sc.s.framePool)
 
-	readRequest := make(chan readReq)
+	readRequest := make(chan readReq, maxPipelinedRequests)
 	readErr := make(chan error)
 	readDone := make(chan struct{})
 	go sc.runReader(readRequest, readErr, readDone)
@@ -104,11 +126,25 @@ func (sc *ServerConn) run() {
 		for {
 			select {
 			case req := <-readRequest:
-				req.res <- sc.handleRequestOuter(req.req)
+				var err error
+				if req.bodyErr != nil {
+					err = sc.handleBodyError(req.req, req.bodyErr)
+				} else {
+					err = sc.handleRequestOuter(req.req)
+				}
+				req.res <- err
+
+				// The following pipelined requests must not be answered.
+				if err != nil && !errors.Is(err, errSwitchReadFunc) {
+					return fmt.Errorf("read: %w", err)
+				}
 
 			case err := <-readErr:
 				return fmt.Errorf("read: %w", err)
 
+			case err := <-sc.writeErr:
+				return fmt.Errorf("write: %w", err)
+
 			case ss := <-sc.sessionRemove:
 				if sc.session == ss {
 					sc.session = nil
@@ -140,6 +176,23 @@ func (sc *ServerConn) run() {
 	sc.s.handler.OnConnClose(sc, err)
 }
 
+// closeWithWriteError closes the connection from the session writer.
+func (sc *ServerConn) closeWithWriteError(err error) {
+	select {
+	case sc.writeErr <- timeoutError("write", err):
+	default:
+	}
+}
+
+// timeoutError translates deadline errors into ServerConnTimeoutError.
+func timeoutError(op string, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return liberrors.ServerConnTimeoutError{Op: op}
+	}
+	return err
+}
+
 var errSwitchReadFunc = errors.New("switch read function")
 
 func (sc *ServerConn) runReader(readRequest chan readReq, readErr chan error, readDone chan struct{}) {
@@ -161,38 +214,85 @@ func (sc *ServerConn) runReader(readRequest chan readReq, readErr chan error, re
 }
 
 func (sc *ServerConn) readFuncStandard(readRequest chan readReq) error {
-	// reset deadline
-	sc.nconn.SetReadDeadline(time.Time{}) //nolint:errcheck
-
+	var pending []chan error
 	for {
+		sc.nconn.SetReadDeadline(time.Now().Add(sc.s.readTimeout)) //nolint:errcheck
+
 		value, err := sc.conn.ReadInterleavedFrameOrRequest()
 		if err != nil {
-			return err
+			if err := sc.waitPending(&pending); err != nil {
+				return err
+			}
+			return sc.readError(readRequest, value, err)
 		}
 
 		switch what := value.(type) {
 		case *base.Request:
-			cres := make(chan error)
-			select {
-			case readRequest <- readReq{req: what, res: cres}:
-				err = <-cres
-				if err != nil {
+			if err := sc.queueRequest(readRequest, &pending, what, nil); err != nil {
+				return err
+			}
+			if sc.mustWaitPending(what, pending) {
+				if err := sc.waitPending(&pending); err != nil {
 					return err
 				}
-
-			case <-sc.ctx.Done():
-				return context.Canceled
 			}
 		default:
+			if err := sc.waitPending(&pending); err != nil {
+				return err
+			}
 			return liberrors.ErrServerUnexpectedFrame
 		}
 	}
 }
 
-func (sc *ServerConn) readFuncTCP(readRequest chan readReq) error {
-	// reset deadline
-	sc.nconn.SetReadDeadline(time.Time{}) //nolint:errcheck
+// queueRequest passes a copy of the request to the run loop, the
+// request is reused by the next read. The answer is appended to pending.
+func (sc *ServerConn) queueRequest(
+	readRequest chan readReq,
+	pending *[]chan error,
+	req *base.Request,
+	bodyErr error,
+) error {
+	reqCopy := *req
+	res := make(chan error, 1)
+	select {
+	case readRequest <- readReq{req: &reqCopy, res: res, bodyErr: bodyErr}:
+		*pending = append(*pending, res)
+		return nil
+	case <-sc.ctx.Done():
+		return context.Canceled
+	}
+}
+
+// mustWaitPending returns true if the queued requests must be answered
+// before the next read. Requests that can switch the read function are
+// waited for, the bytes that follow them may be interleaved frames.
+func (sc *ServerConn) mustWaitPending(req *base.Request, pending []chan error) bool {
+	switch req.Method {
+	case base.Play, base.Record, base.Pause, base.Teardown:
+		return true
+	}
+	return !sc.conn.Buffered() || len(pending) >= maxPipelinedRequests
+}
+
+// waitPending waits for the queued requests to be answered in order.
+func (sc *ServerConn) waitPending(pending *[]chan error) error {
+	for len(*pending) != 0 {
+		select {
+		case err := <-(*pending)[0]:
+			*pending = (*pending)[1:]
+			if err != nil {
+				return err
+			}
+		case <-sc.ctx.Done():
+			return context.Canceled
+		}
+	}
+	*pending = nil
+	return nil
+}
 
+func (sc *ServerConn) readFuncTCP(readRequest chan readReq) error {
 	select {
 	case sc.session.startWriter <- struct{}{}:
 	case <-sc.session.ctx.Done():
@@ -212,55 +312,127 @@ func (sc *ServerConn) readFuncTCP(readRequest chan readReq) error {
 				return fmt.Errorf("unmarshal packet: %w", err)
 			}
 
+			if track.rtcpReceiver != nil {
+				track.rtcpReceiver.ProcessPacketRTP(time.Now(), pkt)
+			}
+
 			sc.s.handler.OnPacketRTP(sc.session, track.id, pkt)
 
 			return nil
 		}
 	}
 
+	// Readers may only send RTCP receiver reports and keepalive
+	// requests, they are allowed to be idle for the session timeout.
+	readTimeout := sc.s.readTimeout
+	if sc.session.state == ServerSessionStatePlay {
+		readTimeout = sc.s.sessionTimeout
+	}
+
+	var pending []chan error
 	for {
-		if sc.session.state == ServerSessionStateRecord {
-			sc.nconn.SetReadDeadline(time.Now().Add(sc.s.readTimeout)) //nolint:errcheck
-		}
+		// Every frame, including frames on channels
+		// that haven't been set up, extends the deadline.
+		sc.nconn.SetReadDeadline(time.Now().Add(readTimeout)) //nolint:errcheck
 
 		what, err := sc.conn.ReadInterleavedFrameOrRequest()
 		if err != nil {
-			return err
+			if err := sc.waitPending(&pending); err != nil {
+				return err
+			}
+			return sc.readError(readRequest, what, err)
 		}
 
 		switch twhat := what.(type) {
 		case *base.InterleavedFrame:
-			// forward frame only if it has been set up
-			if track, ok := sc.session.tcpTracksByChannel[twhat.Channel]; ok {
-				err := processFunc(track, twhat.Payload)
-				if err != nil {
-					return err
-				}
+			// The payload is released after it's processed, the
+			// handler must copy the packet payload to retain it.
+			err := sc.processFrame(twhat, processFunc)
+			sc.conn.ReleaseInterleavedFrame()
+			if err != nil {
+				return err
 			}
 
 		case *base.Request:
-			cres := make(chan error)
-			select {
-			case readRequest <- readReq{req: twhat, res: cres}:
-				err := <-cres
-				if err != nil {
+			if err := sc.queueRequest(readRequest, &pending, twhat, nil); err != nil {
+				return err
+			}
+			if sc.mustWaitPending(twhat, pending) {
+				if err := sc.waitPending(&pending); err != nil {
 					return err
 				}
-
-			case <-sc.ctx.Done():
-				return context.Canceled
 			}
 		}
 	}
 }
 
-var supportedMethods = []string{
-	string(base.Describe),
-	string(base.Announce),
-	string(base.Setup),
-	string(base.Play),
-	string(base.Record),
-	string(base.Teardown),
+func (sc *ServerConn) processFrame(
+	fr *base.InterleavedFrame,
+	processFunc func(*ServerSessionSetuppedTrack, []byte) error,
+) error {
+	// forward frame only if it has been set up
+	if track, ok := sc.session.tcpTracksByChannel[fr.Channel]; ok {
+		return processFunc(track, fr.Payload)
+	}
+	if track, ok := sc.session.tcpTracksByChannel[fr.Channel-1]; ok {
+		// RTCP channel.
+		switch {
+		case track.rtcpReceiver != nil:
+			track.rtcpReceiver.ProcessSenderReport(time.Now(), fr.Payload)
+		case track.clientReport != nil:
+			sc.session.processReceiverReport(track, time.Now(), fr.Payload)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %d", liberrors.ErrServerUnknownChannel, fr.Channel)
+}
+
+// readError answers requests with invalid bodies before the connection
+// is closed. The connection can't be reused since the end of the body is
+// unknown and the following bytes can't be parsed as a request.
+func (sc *ServerConn) readError(readRequest chan readReq, value interface{}, err error) error {
+	req, ok := value.(*base.Request)
+	if !ok || bodyErrorStatus(err) == 0 {
+		return timeoutError("read", err)
+	}
+
+	var pending []chan error
+	if err := sc.queueRequest(readRequest, &pending, req, err); err != nil {
+		return err
+	}
+	return sc.waitPending(&pending)
+}
+
+func bodyErrorStatus(err error) base.StatusCode {
+	switch {
+	case errors.Is(err, base.ErrBodyContentLengthMissing):
+		return base.StatusLengthRequired
+	case errors.Is(err, base.ErrBodyContentLengthToBig):
+		return base.StatusRequestEntityTooLarge
+	case errors.Is(err, base.ErrBodyContentLengthInvalid):
+		return base.StatusBadRequest
+	}
+	return 0
+}
+
+func (sc *ServerConn) handleBodyError(req *base.Request, bodyErr error) error {
+	sc.debugRequest(req)
+	res := &base.Response{
+		StatusCode: bodyErrorStatus(bodyErr),
+		Header: base.Header{
+			"Connection": base.HeaderValue{"close"},
+			"Server":     base.HeaderValue{"gortsplib"},
+		},
+	}
+	if cseq, ok := req.Header["CSeq"]; ok {
+		res.Header["CSeq"] = cseq
+	}
+
+	sc.nconn.SetWriteDeadline(time.Now().Add(sc.s.writeTimeout)) //nolint:errcheck
+	sc.conn.WriteResponse(res)                                   //nolint:errcheck
+	sc.debugResponse(req, res)
+
+	return bodyErr
 }
 
 func (sc *ServerConn) handleRequest(req *base.Request) (*base.Response, error) { //nolint:funlen
@@ -271,6 +443,16 @@ func (sc *ServerConn) handleRequest(req *base.Request) (*base.Response, error) {
 		}, liberrors.ErrServerCSeqMissing
 	}
 
+	if res, err := sc.s.handler.OnRequest(sc, req); res != nil {
+		return res, err
+	}
+
+	if !serverSupportsMethod(sc.s.handler, req.Method) {
+		return &base.Response{
+			StatusCode: base.StatusNotImplemented,
+		}, nil
+	}
+
 	sxID := getSessionID(req.Header)
 
 	switch req.Method {
@@ -282,7 +464,7 @@ func (sc *ServerConn) handleRequest(req *base.Request) (*base.Response, error) {
 		return &base.Response{
 			StatusCode: base.StatusOK,
 			Header: base.Header{
-				"Public": base.HeaderValue{strings.Join(supportedMethods, ", ")},
+				"Public": serverPublicHeader(sc.s.handler),
 			},
 		}, nil
 
@@ -294,6 +476,12 @@ func (sc *ServerConn) handleRequest(req *base.Request) (*base.Response, error) {
 			}, liberrors.ErrServerInvalidPath
 		}
 
+		if !acceptsSDP(req.Header["Accept"]) {
+			return &base.Response{
+				StatusCode: base.StatusNotAcceptable,
+			}, liberrors.ErrServerNotAcceptable
+		}
+
 		h := sc.s.handler
 		res, stream, err := h.OnDescribe(path)
 
@@ -323,7 +511,7 @@ func (sc *ServerConn) handleRequest(req *base.Request) (*base.Response, error) {
 			return sc.handleRequestInSession(sxID, req, false)
 		}
 
-	case base.Record:
+	case base.Record, base.Pause, base.GetParameter, base.SetParameter:
 		if sxID != "" {
 			return sc.handleRequestInSession(sxID, req, false)
 		}
@@ -340,6 +528,7 @@ func (sc *ServerConn) handleRequest(req *base.Request) (*base.Response, error) {
 }
 
 func (sc *ServerConn) handleRequestOuter(req *base.Request) error {
+	sc.debugRequest(req)
 	res, err := sc.handleRequest(req)
 
 	if res.Header == nil {
@@ -355,7 +544,11 @@ func (sc *ServerConn) handleRequestOuter(req *base.Request) error {
 	res.Header["Server"] = base.HeaderValue{"gortsplib"}
 
 	sc.nconn.SetWriteDeadline(time.Now().Add(sc.s.writeTimeout)) //nolint:errcheck
-	sc.conn.WriteResponse(res)                                   //nolint:errcheck
+	werr := sc.conn.WriteResponse(res)
+	sc.debugResponse(req, res)
+	if werr != nil && err == nil {
+		return timeoutError("write", werr)
+	}
 
 	return err
 }
@@ -373,7 +566,7 @@ func (sc *ServerConn) handleRequestInSession(
 		// * SETUP requests comes after ANNOUNCE response, that don't contain the session ID
 		if sxID != "" {
 			// the connection can't communicate with two sessions at once.
-			if sxID != sc.session.secretID {
+			if subtle.ConstantTimeCompare([]byte(sxID), []byte(sc.session.secretID)) != 1 {
 				return &base.Response{
 					StatusCode: base.StatusBadRequest,
 				}, liberrors.ErrServerLinkedToOtherSession
@@ -417,6 +610,9 @@ func (sc *ServerConn) handleRequestInSession(
 		res := <-cres
 		sc.session = res.ss
 
+		if isLimitError(res.err) {
+			sc.s.handler.OnLimitReached(sc.remoteAddr, res.err)
+		}
 		return res.res, res.err
 
 	case <-sc.s.ctx.Done():
@@ -425,3 +621,22 @@ func (sc *ServerConn) handleRequestInSession(
 		}, context.Canceled
 	}
 }
+
+// acceptsSDP returns true if the Accept header is
+// missing or includes a media range matching SDP.
+func acceptsSDP(accept base.HeaderValue) bool {
+	if len(accept) == 0 {
+		return true
+	}
+	for _, v := range accept {
+		for _, mediaRange := range strings.Split(v, ",") {
+			// Strip parameters like ";q=0.5".
+			mediaRange, _, _ = strings.Cut(mediaRange, ";")
+			switch strings.ToLower(strings.TrimSpace(mediaRange)) {
+			case "application/sdp", "application/*", "*/*":
+				return true
+			}
+		}
+	}
+	return false
+}
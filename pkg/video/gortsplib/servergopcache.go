@@ -0,0 +1,149 @@
+package gortsplib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+type gopCachePacket struct {
+	trackID int
+	byts    []byte
+}
+
+// gopCache keeps the packets of all tracks since the most recent IDR
+// of the video track. New readers receive the cached packets before
+// the live packets, this allows them to start decoding immediately.
+type gopCache struct {
+	maxBytes     int
+	videoTrackID int
+	videoTrack   *TrackH264
+
+	pkts  []gopCachePacket
+	size  int
+	ready bool      // The cached packets start with an IDR access unit.
+	ntp   time.Time // Time of the cached IDR.
+
+	// Current access unit of the video track.
+	auFilled    bool
+	auTimestamp uint32
+	auNTP       time.Time
+	auStart     int
+
+	// Parameter sets of the cached packets.
+	sps []byte
+	pps []byte
+}
+
+// newGOPCache returns nil if the tracks don't include a H264 track.
+func newGOPCache(tracks Tracks, maxBytes int) *gopCache {
+	for trackID, track := range tracks {
+		if t, ok := track.(*TrackH264); ok {
+			return &gopCache{
+				maxBytes:     maxBytes,
+				videoTrackID: trackID,
+				videoTrack:   t,
+			}
+		}
+	}
+	return nil
+}
+
+func (c *gopCache) write(trackID int, pkt *rtp.Packet, byts []byte, ntp time.Time) {
+	if trackID == c.videoTrackID {
+		if !c.auFilled || pkt.Header.Timestamp != c.auTimestamp {
+			c.auFilled = true
+			c.auTimestamp = pkt.Header.Timestamp
+			c.auNTP = ntp
+			c.auStart = len(c.pkts)
+
+			// Packets before the change can't be decoded with the new parameters.
+			if c.paramsChanged() {
+				c.ready = false
+			}
+			// Only the current access unit is kept while waiting for an IDR.
+			if !c.ready {
+				c.drop(c.auStart)
+			}
+		}
+
+		if rtpH264ContainsIDR(pkt) {
+			c.drop(c.auStart)
+			c.ready = true
+			c.ntp = c.auNTP
+		}
+	}
+
+	c.pkts = append(c.pkts, gopCachePacket{trackID: trackID, byts: byts})
+	c.size += len(byts)
+
+	// The GOP is too long, wait for the next IDR.
+	if c.size > c.maxBytes {
+		c.drop(len(c.pkts))
+		c.ready = false
+	}
+}
+
+// drop removes the first n packets.
+func (c *gopCache) drop(n int) {
+	if n == 0 {
+		return
+	}
+	for _, pkt := range c.pkts[:n] {
+		c.size -= len(pkt.byts)
+	}
+	remaining := copy(c.pkts, c.pkts[n:])
+	clear(c.pkts[remaining:])
+	c.pkts = c.pkts[:remaining]
+	c.auStart -= n
+}
+
+func (c *gopCache) paramsChanged() bool {
+	sps, pps := c.videoTrack.SafeSPS(), c.videoTrack.SafePPS()
+	changed := !bytes.Equal(sps, c.sps) || !bytes.Equal(pps, c.pps)
+	c.sps, c.pps = sps, pps
+	return changed
+}
+
+// gopBurst describes the cached packets that were written to a reader.
+type gopBurst struct {
+	// Time of the first packet.
+	ntp time.Time
+
+	// Sequence number of the first packet of each track.
+	seqs map[int]uint16
+}
+
+// burst writes the cached packets to the session. The packets are
+// renumbered to end right before the next live packet of each track.
+// Returns nil if nothing was written.
+func (c *gopCache) burst(ss *ServerSession, streamTracks []*serverStreamTrack) *gopBurst {
+	// The ring buffer would overwrite the first packets.
+	if !c.ready || len(c.pkts) == 0 || len(c.pkts) > int(ss.writeBuffer.Size()/2) {
+		return nil
+	}
+
+	counts := make(map[int]int)
+	for _, pkt := range c.pkts {
+		counts[pkt.trackID]++
+	}
+	seqs := make(map[int]uint16)
+	for trackID, n := range counts {
+		seqs[trackID] = streamTracks[trackID].lastSequenceNumber + 1 - uint16(n)
+	}
+
+	next := make(map[int]uint16, len(seqs))
+	for trackID, seq := range seqs {
+		next[trackID] = seq
+	}
+	for _, pkt := range c.pkts {
+		byts := append([]byte(nil), pkt.byts...)
+		binary.BigEndian.PutUint16(byts[2:4], next[pkt.trackID])
+		next[pkt.trackID]++
+		ss.writePacketRTP(pkt.trackID, byts)
+	}
+
+	return &gopBurst{ntp: c.ntp, seqs: seqs}
+}
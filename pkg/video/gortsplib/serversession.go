@@ -8,10 +8,13 @@ import (
 	"nvr/pkg/video/gortsplib/pkg/headers"
 	"nvr/pkg/video/gortsplib/pkg/liberrors"
 	"nvr/pkg/video/gortsplib/pkg/ringbuffer"
+	"nvr/pkg/video/gortsplib/pkg/rtcpreceiver"
+	"nvr/pkg/video/gortsplib/pkg/sdp"
 	"nvr/pkg/video/gortsplib/pkg/url"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pion/rtp"
@@ -62,49 +65,87 @@ func setupGetTrackIDPath(
 	thMode *headers.TransportMode,
 	announcedTracks []*ServerSessionAnnouncedTrack,
 	setuppedPath *string,
-	setuppedBaseURL *url.URL,
+	strictTrackControls bool,
 ) (int, string, error) {
-	path, ok := u.RTSPPath()
+	pathAndQuery, ok := u.RTSPPathAndQuery()
 	if !ok {
 		return 0, "", liberrors.ErrServerInvalidPath
 	}
 
 	if thMode != nil && *thMode != headers.TransportModePlay {
 		for trackID, track := range announcedTracks {
-			u2, _ := track.track.url(setuppedBaseURL)
-			if u2.String() == u.String() {
+			if track.matchesURL(u, strictTrackControls) {
 				return trackID, *setuppedPath, nil
 			}
 		}
 
+		path, _, _ := strings.Cut(pathAndQuery, "?")
 		return 0, "", fmt.Errorf("%w (%s)", ErrTrackInvalid, path)
 	}
 
-	i := stringsReverseIndex(path, "/trackID=")
+	path, trackID, hasTrackID, err := splitTrackPath(u)
+	if err != nil {
+		return 0, "", err
+	}
 
 	// URL doesn't contain trackID - it's track zero
-	if i < 0 {
-		if !strings.HasSuffix(path, "/") {
+	if !hasTrackID {
+		withoutQuery, _, _ := strings.Cut(pathAndQuery, "?")
+		if !strings.HasSuffix(withoutQuery, "/") {
 			return 0, "", ErrPathInvalid
 		}
-		path = path[:len(path)-1]
+	}
 
-		// we assume it's track 0
-		return 0, path, nil
+	// The base path is set by the first SETUP.
+	if setuppedPath != nil && (path != *setuppedPath) {
+		return 0, "", fmt.Errorf("%w: '%s' and '%s',"+
+			" paths are compared without trailing slash, query and track ID",
+			ErrTrackPathError, *setuppedPath, path)
 	}
 
-	tmp, err := strconv.ParseInt(path[i+len("/trackID="):], 10, 64)
-	if err != nil || tmp < 0 {
-		return 0, "", fmt.Errorf("%w (%v)", ErrTrackParseError, path)
+	return trackID, path, nil
+}
+
+// splitTrackPath splits "path/trackID=N" into the base path and the
+// track ID. A trailing slash and the query are ignored. The query can
+// be in front of the track ID if the client appended the track control
+// to a Content-Base with a query, "path?query/trackID=N". Some encoders
+// put the track ID in the query instead, "path?trackID=N".
+func splitTrackPath(u *url.URL) (string, int, bool, error) {
+	pathAndQuery, _ := u.RTSPPathAndQuery()
+
+	i := stringsReverseIndex(pathAndQuery, "/trackID=")
+	if i < 0 {
+		path, _, _ := strings.Cut(pathAndQuery, "?")
+		path = strings.TrimSuffix(path, "/")
+
+		rawID := u.Query().Get("trackID")
+		if rawID == "" {
+			return path, 0, false, nil
+		}
+		trackID, err := parseTrackID(rawID)
+		if err != nil {
+			return "", 0, false, fmt.Errorf("%w (%v)", err, pathAndQuery)
+		}
+		return path, trackID, true, nil
 	}
-	trackID := int(tmp)
-	path = path[:i]
 
-	if setuppedPath != nil && (path != *setuppedPath) {
-		return 0, "", ErrTrackPathError
+	rawID, _, _ := strings.Cut(pathAndQuery[i+len("/trackID="):], "?")
+	trackID, err := parseTrackID(rawID)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("%w (%v)", err, pathAndQuery)
 	}
 
-	return trackID, path, nil
+	path, _, _ := strings.Cut(pathAndQuery[:i], "?")
+	return strings.TrimSuffix(path, "/"), trackID, true, nil
+}
+
+func parseTrackID(raw string) (int, error) {
+	tmp, err := strconv.ParseInt(strings.TrimSuffix(raw, "/"), 10, 64)
+	if err != nil || tmp < 0 {
+		return 0, ErrTrackParseError
+	}
+	return int(tmp), nil
 }
 
 // ServerSessionState is a state of a ServerSession.
@@ -138,13 +179,56 @@ func (s ServerSessionState) String() string {
 
 // ServerSessionSetuppedTrack is a setupped track of a ServerSession.
 type ServerSessionSetuppedTrack struct {
-	id         int
-	tcpChannel int
+	id           int
+	tcpChannel   int
+	rtcpReceiver *rtcpreceiver.RTCPReceiver // record
+	clientReport *clientReport              // play
+}
+
+// ClientReport is the reception statistics of a track
+// reported by a reader through RTCP receiver reports.
+type ClientReport struct {
+	// Fraction of packets lost since the previous report, from 0 to 1.
+	FractionLost float64
+
+	// Cumulative number of packets lost.
+	PacketsLost int64
+
+	// Interarrival jitter in seconds.
+	Jitter float64
+
+	// Time the last receiver report was received.
+	LastReport time.Time
+}
+
+type clientReport struct {
+	clockRate float64
+
+	mutex  sync.Mutex
+	report ClientReport
 }
 
 // ServerSessionAnnouncedTrack is an announced track of a ServerSession.
 type ServerSessionAnnouncedTrack struct {
 	track Track
+
+	// Resolved control URL.
+	url *url.URL
+}
+
+// matchesURL returns true if u is the URL of the track. The host is
+// ignored unless strict, the resolved URL may have a different host
+// than the control that the client uses.
+func (t *ServerSessionAnnouncedTrack) matchesURL(u *url.URL, strict bool) bool {
+	if t.url.String() == u.String() {
+		return true
+	}
+	if strict {
+		return false
+	}
+	a, _ := t.url.RTSPPathAndQuery()
+	b, _ := u.RTSPPathAndQuery()
+	return a == b
 }
 
 // ServerSession is a server-side RTSP session.
@@ -160,12 +244,12 @@ type ServerSession struct {
 	setuppedTracks     map[int]*ServerSessionSetuppedTrack
 	tcpTracksByChannel map[int]*ServerSessionSetuppedTrack
 	IsTransportSetup   bool
-	setuppedBaseURL    *url.URL      // publish
 	setuppedStream     *ServerStream // read
 	setuppedPath       *string
 	lastRequestTime    time.Time
 	tcpConn            *ServerConn
 	announcedTracks    []*ServerSessionAnnouncedTrack // publish
+	announceWarnings   []string                       // publish
 	writerRunning      bool
 	writeBuffer        *ringbuffer.RingBuffer
 
@@ -176,6 +260,7 @@ type ServerSession struct {
 	request     chan sessionRequestReq
 	connRemove  chan *ServerConn
 	startWriter chan struct{}
+	drainEnd    chan struct{}
 }
 
 func newServerSession(
@@ -197,6 +282,7 @@ func newServerSession(
 		request:         make(chan sessionRequestReq),
 		connRemove:      make(chan *ServerConn),
 		startWriter:     make(chan struct{}),
+		drainEnd:        make(chan struct{}, 1),
 	}
 
 	s.wg.Add(1)
@@ -211,6 +297,14 @@ func (ss *ServerSession) Close() error {
 	return nil
 }
 
+// drain is called by the server when the drain grace period ends.
+func (ss *ServerSession) drain() {
+	select {
+	case ss.drainEnd <- struct{}{}:
+	default:
+	}
+}
+
 // State returns the state of the session.
 func (ss *ServerSession) State() ServerSessionState {
 	return ss.state
@@ -226,6 +320,12 @@ func (ss *ServerSession) AnnouncedTracks() []*ServerSessionAnnouncedTrack {
 	return ss.announcedTracks
 }
 
+// AnnounceWarnings returns the media sections of the
+// announced SDP that were skipped. Set before OnAnnounce.
+func (ss *ServerSession) AnnounceWarnings() []string {
+	return ss.announceWarnings
+}
+
 func (ss *ServerSession) checkState(allowed map[ServerSessionState]struct{}) error {
 	if _, ok := allowed[ss.state]; ok {
 		return nil
@@ -284,7 +384,19 @@ func (ss *ServerSession) run(name string) {
 	ss.s.handler.OnSessionClose(ss, err)
 }
 
-func (ss *ServerSession) runInner() error { //nolint:gocognit
+func (ss *ServerSession) runInner() error { //nolint:gocognit,funlen
+	// Receiver reports are sent while recording.
+	var receiverReportTicker *time.Ticker
+	var receiverReportC <-chan time.Time
+	stopReceiverReports := func() {
+		if receiverReportTicker != nil {
+			receiverReportTicker.Stop()
+			receiverReportTicker = nil
+			receiverReportC = nil
+		}
+	}
+	defer stopReceiverReports()
+
 	for {
 		select {
 		case req := <-ss.request:
@@ -330,6 +442,10 @@ func (ss *ServerSession) runInner() error { //nolint:gocognit
 				return liberrors.ServerSessionTeardownError{Author: req.sc.NetConn().RemoteAddr()}
 			}
 
+			if ss.state != ServerSessionStateRecord {
+				stopReceiverReports()
+			}
+
 		case sc := <-ss.connRemove:
 			delete(ss.conns, sc)
 
@@ -343,15 +459,70 @@ func (ss *ServerSession) runInner() error { //nolint:gocognit
 				ss.IsTransportSetup {
 				ss.writerRunning = true
 				ss.writerDone = make(chan struct{})
-				go ss.runWriter()
+
+				// Only readers are rate limited.
+				var bucket *tokenBucket
+				if ss.state == ServerSessionStatePlay {
+					bucket = ss.s.newWriteBucket(ss.tcpConn.remoteAddr, ss.tcpConn.localAddr)
+				}
+				go ss.runWriter(bucket)
+
+				if ss.state == ServerSessionStateRecord && receiverReportTicker == nil {
+					receiverReportTicker = time.NewTicker(ss.s.receiverReportPeriod)
+					receiverReportC = receiverReportTicker.C
+				}
+			}
+
+		case now := <-receiverReportC:
+			if ss.writerRunning {
+				ss.writeReceiverReports(now)
 			}
 
+		case <-ss.drainEnd:
+			ss.sendRedirect()
+			return ErrServerDrained
+
 		case <-ss.ctx.Done():
 			return context.Canceled
 		}
 	}
 }
 
+// sendRedirect sends a REDIRECT request to the session
+// connections if the server has a redirect host.
+func (ss *ServerSession) sendRedirect() {
+	host := ss.s.getRedirectHost()
+	if host == "" || ss.setuppedPath == nil {
+		return
+	}
+
+	// Stop the writer to avoid interleaving the request with packets.
+	if ss.writerRunning {
+		ss.writeBuffer.Close()
+		<-ss.writerDone
+		ss.writerRunning = false
+	}
+
+	u := &url.URL{
+		Scheme: "rtsp",
+		Host:   host,
+		Path:   "/" + *ss.setuppedPath,
+	}
+	req := &base.Request{
+		Method: base.Redirect,
+		URL:    u,
+		Header: base.Header{
+			"CSeq":     base.HeaderValue{"1"},
+			"Session":  headers.Session{Session: ss.secretID}.Marshal(),
+			"Location": base.HeaderValue{u.String()},
+		},
+	}
+	for sc := range ss.conns {
+		sc.nconn.SetWriteDeadline(time.Now().Add(ss.s.writeTimeout)) //nolint:errcheck
+		sc.conn.WriteRequest(req)                                     //nolint:errcheck
+	}
+}
+
 func (ss *ServerSession) handleRequest( //nolint:funlen
 	sc *ServerConn,
 	req *base.Request,
@@ -364,7 +535,7 @@ func (ss *ServerSession) handleRequest( //nolint:funlen
 
 	var path string
 	switch req.Method {
-	case base.Announce, base.Play, base.Record, base.GetParameter, base.SetParameter:
+	case base.Announce, base.Play, base.Record, base.Pause, base.GetParameter, base.SetParameter:
 		var ok bool
 		path, ok = req.URL.RTSPPath()
 		if !ok {
@@ -376,6 +547,12 @@ func (ss *ServerSession) handleRequest( //nolint:funlen
 		if req.Method != base.Announce {
 			// path can end with a slash due to Content-Base, remove it
 			path = strings.TrimSuffix(path, "/")
+
+			// Some clients send requests to a track URL instead
+			// of the aggregate URL, both refer to the base path.
+			if basePath, _, hasTrackID, err := splitTrackPath(req.URL); err == nil && hasTrackID {
+				path = basePath
+			}
 		}
 	}
 
@@ -395,6 +572,9 @@ func (ss *ServerSession) handleRequest( //nolint:funlen
 	case base.Record:
 		return ss.handleRecord(sc, path)
 
+	case base.Pause:
+		return ss.handlePause(path)
+
 	case base.Teardown:
 		var err error
 		if ss.state == ServerSessionStatePlay || ss.state == ServerSessionStateRecord {
@@ -407,15 +587,10 @@ func (ss *ServerSession) handleRequest( //nolint:funlen
 		}, err
 
 	case base.GetParameter:
-		// GET_PARAMETER is used like a ping when reading, and sometimes
-		// also when publishing; reply with 200
-		return &base.Response{
-			StatusCode: base.StatusOK,
-			Header: base.Header{
-				"Content-Type": base.HeaderValue{"text/parameters"},
-			},
-			Body: []byte{},
-		}, nil
+		return ss.s.handler.(ServerHandlerOnGetParameter).OnGetParameter(ss, path, req)
+
+	case base.SetParameter:
+		return ss.s.handler.(ServerHandlerOnSetParameter).OnSetParameter(ss, path, req)
 	}
 
 	return &base.Response{
@@ -427,7 +602,7 @@ func (ss *ServerSession) handleOptions() (*base.Response, error) {
 	return &base.Response{
 		StatusCode: base.StatusOK,
 		Header: base.Header{
-			"Public": base.HeaderValue{strings.Join(supportedMethods, ", ")},
+			"Public": serverPublicHeader(ss.s.handler),
 		},
 	}, nil
 }
@@ -465,6 +640,19 @@ func (ss *ServerSession) handleAnnounce( //nolint:funlen
 		}, liberrors.ErrServerContentTypeUnsupportedError{CT: ct}
 	}
 
+	var sd sdp.SessionDescription
+	if err := sd.Unmarshal(req.Body); err != nil {
+		return &base.Response{
+			StatusCode: base.StatusBadRequest,
+		}, liberrors.ServerSDPinvalidError{Err: err}
+	}
+
+	warnings, err := ValidateAnnounce(&sd)
+	if err != nil {
+		return sdpProblemsResponse(err), liberrors.ServerSDPinvalidError{Err: err}
+	}
+	ss.announceWarnings = warnings
+
 	var tracks Tracks
 	_, err = tracks.Unmarshal(req.Body)
 	if err != nil {
@@ -473,13 +661,27 @@ func (ss *ServerSession) handleAnnounce( //nolint:funlen
 		}, liberrors.ServerSDPinvalidError{Err: err}
 	}
 
-	for _, track := range tracks {
-		trackURL, err := track.url(req.URL)
+	contentBase, err := ContentBase(req.Header, req.URL)
+	if err != nil {
+		return &base.Response{
+			StatusCode: base.StatusBadRequest,
+		}, err
+	}
+	urlBase := TrackURLBase{
+		ContentBase: contentBase,
+		Conn:        req.URL,
+		Strict:      ss.s.strictTrackControls,
+	}
+
+	trackURLs := make([]*url.URL, len(tracks))
+	for i, track := range tracks {
+		trackURL, err := track.url(urlBase)
 		if err != nil {
 			return &base.Response{
 				StatusCode: base.StatusBadRequest,
 			}, ErrTrackGenURL
 		}
+		trackURLs[i] = trackURL
 
 		trackPath, ok := trackURL.RTSPPath()
 		if !ok {
@@ -496,7 +698,7 @@ func (ss *ServerSession) handleAnnounce( //nolint:funlen
 		}
 	}
 
-	res, err := ss.s.handler.OnAnnounce(ss, path, tracks)
+	res, err := ss.s.handler.(ServerHandlerOnAnnounce).OnAnnounce(ss, path, tracks)
 
 	if res.StatusCode != base.StatusOK {
 		return res, err
@@ -504,18 +706,38 @@ func (ss *ServerSession) handleAnnounce( //nolint:funlen
 
 	ss.state = ServerSessionStatePreRecord
 	ss.setuppedPath = &path
-	ss.setuppedBaseURL = req.URL
 
 	ss.announcedTracks = make([]*ServerSessionAnnouncedTrack, len(tracks))
 	for trackID, track := range tracks {
 		ss.announcedTracks[trackID] = &ServerSessionAnnouncedTrack{
 			track: track,
+			url:   trackURLs[trackID],
 		}
 	}
 
 	return res, err
 }
 
+// sdpProblemsResponse lists the problems in the response body, one per line.
+func sdpProblemsResponse(err error) *base.Response {
+	res := &base.Response{
+		StatusCode: base.StatusBadRequest,
+		Header: base.Header{
+			"Content-Type": base.HeaderValue{"text/plain"},
+		},
+	}
+
+	var validationErr SDPValidationError
+	if !errors.As(err, &validationErr) {
+		res.Body = []byte(err.Error() + "\n")
+		return res
+	}
+	for _, p := range validationErr.Problems {
+		res.Body = append(res.Body, p.Error()+"\n"...)
+	}
+	return res
+}
+
 func (ss *ServerSession) handleSetup(req *base.Request) (*base.Response, error) { //nolint:funlen
 	err := ss.checkState(map[ServerSessionState]struct{}{
 		ServerSessionStateInitial:   {},
@@ -541,7 +763,7 @@ func (ss *ServerSession) handleSetup(req *base.Request) (*base.Response, error)
 		inTH.Mode,
 		ss.announcedTracks,
 		ss.setuppedPath,
-		ss.setuppedBaseURL,
+		ss.s.strictTrackControls,
 	)
 	if err != nil {
 		return &base.Response{
@@ -635,7 +857,15 @@ func (ss *ServerSession) handleSetup(req *base.Request) (*base.Response, error)
 		res.Header = make(base.Header)
 	}
 
-	sst := &ServerSessionSetuppedTrack{id: trackID}
+	sst := &ServerSessionSetuppedTrack{
+		id:         trackID,
+		tcpChannel: inTH.InterleavedIDs[0],
+	}
+	if ss.state == ServerSessionStatePrePlay {
+		sst.clientReport = &clientReport{
+			clockRate: float64(stream.Tracks()[trackID].ClockRate()),
+		}
+	}
 
 	if ss.tcpTracksByChannel == nil {
 		ss.tcpTracksByChannel = make(map[int]*ServerSessionSetuppedTrack)
@@ -678,6 +908,16 @@ func (ss *ServerSession) handlePlay( //nolint:funlen
 		}, liberrors.ServerPathHasChangedError{Prev: *ss.setuppedPath, Cur: path}
 	}
 
+	// The live position is played if the range is missing.
+	rng := headers.Range{Value: &headers.RangeNPT{Start: headers.RangeNPTNow()}}
+	if v, ok := req.Header["Range"]; ok {
+		if err := rng.Unmarshal(v); err != nil {
+			return &base.Response{
+				StatusCode: base.StatusInvalidRange,
+			}, liberrors.ServerRangeHeaderInvalidError{Err: err}
+		}
+	}
+
 	// allocate writeBuffer before calling OnPlay().
 	// in this way it's possible to call ServerSession.WritePacket*()
 	// inside the callback.
@@ -685,7 +925,7 @@ func (ss *ServerSession) handlePlay( //nolint:funlen
 		ss.writeBuffer, _ = ringbuffer.New(uint64(ss.s.writeBufferCount))
 	}
 
-	res, err := sc.s.handler.OnPlay(ss)
+	res, err := sc.s.handler.(ServerHandlerOnPlay).OnPlay(ss, rng)
 
 	if res.StatusCode != base.StatusOK {
 		if ss.State() == ServerSessionStatePrePlay {
@@ -694,6 +934,14 @@ func (ss *ServerSession) handlePlay( //nolint:funlen
 		return res, err
 	}
 
+	// The handler can set the range that is actually played.
+	if _, ok := res.Header["Range"]; !ok {
+		if res.Header == nil {
+			res.Header = make(base.Header)
+		}
+		res.Header["Range"] = rng.Marshal()
+	}
+
 	if ss.state == ServerSessionStatePlay {
 		return res, err
 	}
@@ -707,7 +955,7 @@ func (ss *ServerSession) handlePlay( //nolint:funlen
 	ss.writeBuffer, _ = ringbuffer.New(uint64(ss.s.readBufferCount))
 	// runWriter() is called by ServerConn after the response has been sent
 
-	ss.setuppedStream.readerSetActive(ss)
+	burst := ss.setuppedStream.readerSetActive(ss)
 
 	var trackIDs []int
 	for trackID := range ss.setuppedTracks {
@@ -721,11 +969,21 @@ func (ss *ServerSession) handlePlay( //nolint:funlen
 	var ri headers.RTPinfo
 	now := time.Now()
 
+	// The playback starts at the cached IDR.
+	if burst != nil {
+		now = burst.ntp
+	}
+
 	for _, trackID := range trackIDs {
 		seqNum, ts, ok := ss.setuppedStream.rtpInfo(trackID, now)
 		if !ok {
 			continue
 		}
+		if burst != nil {
+			if seq, ok := burst.seqs[trackID]; ok {
+				seqNum = seq
+			}
+		}
 
 		u := &url.URL{
 			Scheme: req.URL.Scheme,
@@ -780,7 +1038,7 @@ func (ss *ServerSession) handleRecord(
 	// inside the callback.
 	ss.writeBuffer, _ = ringbuffer.New(uint64(8))
 
-	res, err := ss.s.handler.OnRecord(ss)
+	res, err := ss.s.handler.(ServerHandlerOnRecord).OnRecord(ss)
 
 	if res.StatusCode != base.StatusOK {
 		ss.writeBuffer = nil
@@ -789,6 +1047,11 @@ func (ss *ServerSession) handleRecord(
 
 	ss.state = ServerSessionStateRecord
 
+	for trackID, sst := range ss.setuppedTracks {
+		clockRate := ss.announcedTracks[trackID].track.ClockRate()
+		sst.rtcpReceiver = rtcpreceiver.New(randUint32(), clockRate)
+	}
+
 	ss.tcpConn = sc
 	ss.tcpConn.readFunc = ss.tcpConn.readFuncTCP
 	err = errSwitchReadFunc
@@ -797,25 +1060,83 @@ func (ss *ServerSession) handleRecord(
 	return res, err
 }
 
-func (ss *ServerSession) runWriter() {
+// handlePause stops playing or recording, the
+// session can be played or recorded again.
+func (ss *ServerSession) handlePause(path string) (*base.Response, error) {
+	err := ss.checkState(map[ServerSessionState]struct{}{
+		ServerSessionStatePrePlay:   {},
+		ServerSessionStatePlay:      {},
+		ServerSessionStatePreRecord: {},
+		ServerSessionStateRecord:    {},
+	})
+	if err != nil {
+		return &base.Response{
+			StatusCode: base.StatusBadRequest,
+		}, err
+	}
+
+	if ss.setuppedPath != nil && path != *ss.setuppedPath {
+		return &base.Response{
+			StatusCode: base.StatusBadRequest,
+		}, liberrors.ServerPathHasChangedError{Prev: *ss.setuppedPath, Cur: path}
+	}
+
+	res, err := ss.s.handler.(ServerHandlerOnPause).OnPause(ss)
+	if res.StatusCode != base.StatusOK {
+		return res, err
+	}
+
+	switch ss.state {
+	case ServerSessionStatePlay:
+		ss.setuppedStream.readerSetInactive(ss)
+		ss.state = ServerSessionStatePrePlay
+	case ServerSessionStateRecord:
+		ss.state = ServerSessionStatePreRecord
+	default:
+		return res, err
+	}
+
+	if ss.writerRunning {
+		ss.writeBuffer.Close()
+		<-ss.writerDone
+		ss.writerRunning = false
+	}
+	ss.writeBuffer = nil
+
+	// The following bytes are requests, not interleaved frames.
+	ss.tcpConn.readFunc = ss.tcpConn.readFuncStandard
+	return res, errSwitchReadFunc
+}
+
+// runWriter writes the queued packets. If the session is rate limited,
+// the writes are paced by the token bucket and interleaved with the
+// other limited sessions by the write scheduler.
+func (ss *ServerSession) runWriter(bucket *tokenBucket) {
 	defer close(ss.writerDone)
 
 	rtpFrames := make(map[int]*base.InterleavedFrame, len(ss.setuppedTracks))
+	rtcpFrames := make(map[int]*base.InterleavedFrame, len(ss.setuppedTracks))
 
 	for trackID, sst := range ss.setuppedTracks {
 		rtpFrames[trackID] = &base.InterleavedFrame{Channel: sst.tcpChannel}
+		rtcpFrames[trackID] = &base.InterleavedFrame{Channel: sst.tcpChannel + 1}
 	}
 
 	buf := make([]byte, maxPacketSize+4)
 
-	writeFunc := func(trackID int, payload []byte) {
-		fr := rtpFrames[trackID]
-		fr.Payload = payload
+	writeFunc := func(data trackTypePayload) error {
+		fr := rtpFrames[data.trackID]
+		if data.isRTCP {
+			fr = rtcpFrames[data.trackID]
+		}
+		fr.Payload = data.payload
 
 		ss.tcpConn.nconn.SetWriteDeadline(time.Now().Add(ss.s.writeTimeout)) //nolint:errcheck
-		ss.tcpConn.conn.WriteInterleavedFrame(fr, buf)                       //nolint:errcheck
+		return ss.tcpConn.conn.WriteInterleavedFrame(fr, buf)
 	}
 
+	turn := newWriteTurn()
+
 	for {
 		tmp, ok := ss.writeBuffer.Pull()
 		if !ok {
@@ -823,7 +1144,30 @@ func (ss *ServerSession) runWriter() {
 		}
 		data := tmp.(trackTypePayload) //nolint:forcetypeassert
 
-		writeFunc(data.trackID, data.payload)
+		if bucket != nil {
+			// The interleaved frame header is 4 bytes.
+			if wait := bucket.reserve(len(data.payload) + 4); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ss.ctx.Done():
+					timer.Stop()
+					return
+				}
+			}
+			ss.s.writes.acquire(turn)
+		}
+
+		err := writeFunc(data)
+		if bucket != nil {
+			ss.s.writes.release(turn)
+		}
+		if err != nil {
+			// A blocked client must not hold the session open,
+			// closing the connection also closes the session.
+			ss.tcpConn.closeWithWriteError(err)
+			return
+		}
 	}
 }
 
@@ -838,6 +1182,91 @@ func (ss *ServerSession) writePacketRTP(trackID int, byts []byte) {
 	})
 }
 
+// writeReceiverReports queues a RTCP receiver report for each
+// track that has received packets since the previous report.
+func (ss *ServerSession) writeReceiverReports(now time.Time) {
+	for trackID, sst := range ss.setuppedTracks {
+		if sst.rtcpReceiver == nil {
+			continue
+		}
+		report := sst.rtcpReceiver.Report(now)
+		if report == nil {
+			continue
+		}
+		ss.writeBuffer.Push(trackTypePayload{
+			trackID: trackID,
+			isRTCP:  true,
+			payload: report,
+		})
+	}
+}
+
+// ReceiverStats returns the reception statistics of each
+// track while recording, the map is indexed by track ID.
+func (ss *ServerSession) ReceiverStats() map[int]rtcpreceiver.Stats {
+	stats := make(map[int]rtcpreceiver.Stats)
+	for trackID, sst := range ss.setuppedTracks {
+		if sst.rtcpReceiver != nil {
+			stats[trackID] = sst.rtcpReceiver.Stats()
+		}
+	}
+	return stats
+}
+
+// ClientReports returns the reception statistics reported by the
+// reader of each track while playing, the map is indexed by track ID.
+// Tracks without a receiver report are omitted.
+func (ss *ServerSession) ClientReports() map[int]ClientReport {
+	reports := make(map[int]ClientReport)
+	for trackID, sst := range ss.setuppedTracks {
+		if sst.clientReport == nil {
+			continue
+		}
+		sst.clientReport.mutex.Lock()
+		report := sst.clientReport.report
+		sst.clientReport.mutex.Unlock()
+		if !report.LastReport.IsZero() {
+			reports[trackID] = report
+		}
+	}
+	return reports
+}
+
+// processReceiverReport updates the client report with a RTCP packet
+// from the reader. Invalid packets and packets without a report block
+// about the track are ignored, they must not close the connection.
+func (ss *ServerSession) processReceiverReport(
+	sst *ServerSessionSetuppedTrack,
+	now time.Time,
+	payload []byte,
+) {
+	blocks, err := rtcpreceiver.ParseReceiverReports(payload)
+	if err != nil || len(blocks) == 0 {
+		return
+	}
+
+	// Prefer the block about the SSRC of the stream.
+	block := blocks[0]
+	if ssrc := ss.setuppedStream.ssrc(sst.id); ssrc != 0 {
+		for _, b := range blocks {
+			if b.SSRC == ssrc {
+				block = b
+				break
+			}
+		}
+	}
+
+	cr := sst.clientReport
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	cr.report = ClientReport{
+		FractionLost: float64(block.FractionLost) / 256,
+		PacketsLost:  int64(block.PacketsLost),
+		Jitter:       float64(block.Jitter) / cr.clockRate,
+		LastReport:   now,
+	}
+}
+
 // WritePacketRTP writes a RTP packet to the session.
 func (ss *ServerSession) WritePacketRTP(trackID int, pkt *rtp.Packet) {
 	byts, err := pkt.Marshal()
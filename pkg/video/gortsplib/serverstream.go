@@ -3,6 +3,7 @@ package gortsplib
 import (
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/rtp"
@@ -10,10 +11,15 @@ import (
 
 type trackTypePayload struct {
 	trackID int
+	isRTCP  bool
 	payload []byte
 }
 
 type serverStreamTrack struct {
+	// Difference between the sequence numbers of the written
+	// and the distributed packets, caused by the packet filter.
+	seqOffset uint16
+
 	lastSequenceNumber uint16
 	lastSSRC           uint32
 	lastTimeFilled     bool
@@ -34,8 +40,22 @@ type ServerStream struct {
 	readers        map[*ServerSession]struct{}
 	streamTracks   []*serverStreamTrack
 	closed         bool
+
+	packetFilter atomic.Pointer[PacketFilter]
+	gopCache     *gopCache
 }
 
+// PacketFilter is called with each RTP packet before it's distributed to the
+// readers. It can drop the packet by returning nil, pass it through or replace
+// it with multiple packets. The sequence numbers of the returned packets are
+// ignored, the stream renumbers the packets to keep the numbering continuous.
+// The packets must not be modified after they are returned.
+//
+// The payload of the packet can be a pooled buffer that is reused after
+// WritePacketRTP returns. A filter that keeps the packet, to return it later
+// or to inspect it in another goroutine, must copy it.
+type PacketFilter func(trackID int, pkt *rtp.Packet) []*rtp.Packet
+
 // NewServerStream allocates a ServerStream.
 func NewServerStream(tracks Tracks) *ServerStream {
 	tracks = tracks.clone()
@@ -138,15 +158,23 @@ func (st *ServerStream) readerRemove(ss *ServerSession) {
 	delete(st.readers, ss)
 }
 
-func (st *ServerStream) readerSetActive(ss *ServerSession) {
+// readerSetActive starts distributing packets to the session. The cached
+// GOP is written first if the GOP cache is enabled, no live packets can
+// be written in between.
+func (st *ServerStream) readerSetActive(ss *ServerSession) *gopBurst {
 	st.mutex.Lock()
 	defer st.mutex.Unlock()
 
 	if st.closed {
-		return
+		return nil
 	}
 
 	st.readersUnicast[ss] = struct{}{}
+
+	if st.gopCache == nil {
+		return nil
+	}
+	return st.gopCache.burst(ss, st.streamTracks)
 }
 
 func (st *ServerStream) readerSetInactive(ss *ServerSession) {
@@ -160,6 +188,25 @@ func (st *ServerStream) readerSetInactive(ss *ServerSession) {
 	delete(st.readersUnicast, ss)
 }
 
+// SetPacketFilter sets the packet filter, nil removes it.
+// Safe to call while packets are being written.
+func (st *ServerStream) SetPacketFilter(filter PacketFilter) {
+	if filter == nil {
+		st.packetFilter.Store(nil)
+		return
+	}
+	st.packetFilter.Store(&filter)
+}
+
+// EnableGOPCache keeps the packets since the most recent IDR, up to
+// maxBytes, and sends them to new readers to let them start decoding
+// immediately. Does nothing if the stream doesn't have a H264 track.
+func (st *ServerStream) EnableGOPCache(maxBytes int) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	st.gopCache = newGOPCache(st.tracks, maxBytes)
+}
+
 // WritePacketRTP writes a RTP packet to all the readers of the stream.
 func (st *ServerStream) WritePacketRTP(trackID int, pkt *rtp.Packet) {
 	st.WritePacketRTPWithNTP(trackID, pkt, time.Now())
@@ -169,21 +216,60 @@ func (st *ServerStream) WritePacketRTP(trackID int, pkt *rtp.Packet) {
 // ntp is the absolute time of the packet, and is needed to generate RTCP sender reports
 // that allows the receiver to reconstruct the absolute time of the packet.
 func (st *ServerStream) WritePacketRTPWithNTP(trackID int, pkt *rtp.Packet, ntp time.Time) {
-	byts := make([]byte, maxPacketSize)
-	n, err := pkt.MarshalTo(byts)
-	if err != nil {
+	// The filter is called without holding any locks.
+	filter := st.packetFilter.Load()
+	if filter == nil {
+		st.writePacketsRTP(trackID, pkt.Header.SequenceNumber, []*rtp.Packet{pkt}, ntp)
 		return
 	}
-	byts = byts[:n]
+	st.writePacketsRTP(trackID, pkt.Header.SequenceNumber, (*filter)(trackID, pkt), ntp)
+}
 
-	st.mutex.RLock()
-	defer st.mutex.RUnlock()
+// writePacketsRTP distributes the packets that replaced the written packet.
+func (st *ServerStream) writePacketsRTP(
+	trackID int,
+	seq uint16,
+	pkts []*rtp.Packet,
+	ntp time.Time,
+) {
+	// The write lock is held because the sequence offset is changed.
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
 
 	if st.closed {
 		return
 	}
 
 	track := st.streamTracks[trackID]
+
+	if len(pkts) == 0 {
+		// The next packet takes the sequence number of the dropped packet.
+		track.seqOffset--
+		return
+	}
+
+	for i, pkt := range pkts {
+		// Shallow copy, the header is the only modified field.
+		renumbered := *pkt
+		renumbered.Header.SequenceNumber = seq + track.seqOffset + uint16(i)
+		st.writePacketRTP(trackID, track, &renumbered, ntp)
+	}
+	track.seqOffset += uint16(len(pkts) - 1)
+}
+
+func (st *ServerStream) writePacketRTP(
+	trackID int,
+	track *serverStreamTrack,
+	pkt *rtp.Packet,
+	ntp time.Time,
+) {
+	byts := make([]byte, maxPacketSize)
+	n, err := pkt.MarshalTo(byts)
+	if err != nil {
+		return
+	}
+	byts = byts[:n]
+
 	ptsEqualsDTS := ptsEqualsDTS(st.tracks[trackID], pkt)
 
 	if ptsEqualsDTS {
@@ -195,6 +281,10 @@ func (st *ServerStream) WritePacketRTPWithNTP(trackID int, pkt *rtp.Packet, ntp
 	track.lastSequenceNumber = pkt.Header.SequenceNumber
 	track.lastSSRC = pkt.Header.SSRC
 
+	if st.gopCache != nil {
+		st.gopCache.write(trackID, pkt, byts, ntp)
+	}
+
 	// send unicast
 	for r := range st.readersUnicast {
 		r.writePacketRTP(trackID, byts)
@@ -0,0 +1,340 @@
+package gortsplib
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"nvr/pkg/video/gortsplib/pkg/mpeg4audio"
+	"nvr/pkg/video/gortsplib/pkg/ringbuffer"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestReader returns a session that reads track 0 from the stream.
+func newTestReader(t *testing.T, stream *ServerStream) *ServerSession {
+	t.Helper()
+	writeBuffer, err := ringbuffer.New(64)
+	require.NoError(t, err)
+
+	ss := &ServerSession{
+		setuppedTracks: map[int]*ServerSessionSetuppedTrack{0: {}},
+		writeBuffer:    writeBuffer,
+	}
+	stream.readers[ss] = struct{}{}
+	stream.readersUnicast[ss] = struct{}{}
+	return ss
+}
+
+// readPackets pulls n packets from the write buffer of the session.
+func readPackets(t *testing.T, ss *ServerSession, n int) []*rtp.Packet {
+	t.Helper()
+	var pkts []*rtp.Packet
+	for i := 0; i < n; i++ {
+		item, ok := ss.writeBuffer.Pull()
+		require.True(t, ok)
+
+		var pkt rtp.Packet
+		require.NoError(t, pkt.Unmarshal(item.(trackTypePayload).payload))
+		pkts = append(pkts, &pkt)
+	}
+	return pkts
+}
+
+func newTestPacket(seq uint16, payload byte) *rtp.Packet {
+	return &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    96,
+			SequenceNumber: seq,
+			SSRC:           1234,
+		},
+		Payload: []byte{payload},
+	}
+}
+
+func TestServerStreamPacketFilter(t *testing.T) {
+	track := &TrackH264{PayloadType: 96}
+
+	requireContinuous := func(t *testing.T, pkts []*rtp.Packet) {
+		t.Helper()
+		for i := 1; i < len(pkts); i++ {
+			require.Equal(t,
+				pkts[i-1].Header.SequenceNumber+1,
+				pkts[i].Header.SequenceNumber,
+				"packet %d", i,
+			)
+		}
+	}
+	payloads := func(pkts []*rtp.Packet) []byte {
+		var p []byte
+		for _, pkt := range pkts {
+			p = append(p, pkt.Payload[0])
+		}
+		return p
+	}
+
+	t.Run("passthrough", func(t *testing.T) {
+		stream := NewServerStream(Tracks{track})
+		r1, r2 := newTestReader(t, stream), newTestReader(t, stream)
+		stream.SetPacketFilter(func(_ int, pkt *rtp.Packet) []*rtp.Packet {
+			return []*rtp.Packet{pkt}
+		})
+
+		for i := 0; i < 3; i++ {
+			stream.WritePacketRTP(0, newTestPacket(uint16(100+i), byte(i)))
+		}
+		for _, r := range []*ServerSession{r1, r2} {
+			pkts := readPackets(t, r, 3)
+			require.Equal(t, uint16(100), pkts[0].Header.SequenceNumber)
+			require.Equal(t, []byte{0, 1, 2}, payloads(pkts))
+			requireContinuous(t, pkts)
+		}
+	})
+	t.Run("drop", func(t *testing.T) {
+		stream := NewServerStream(Tracks{track})
+		r1, r2 := newTestReader(t, stream), newTestReader(t, stream)
+		stream.SetPacketFilter(func(_ int, pkt *rtp.Packet) []*rtp.Packet {
+			if pkt.Payload[0]%2 == 1 {
+				return nil
+			}
+			return []*rtp.Packet{pkt}
+		})
+
+		for i := 0; i < 6; i++ {
+			stream.WritePacketRTP(0, newTestPacket(uint16(65533+i), byte(i)))
+		}
+		for _, r := range []*ServerSession{r1, r2} {
+			pkts := readPackets(t, r, 3)
+			require.Equal(t, []byte{0, 2, 4}, payloads(pkts))
+			requireContinuous(t, pkts)
+		}
+	})
+	t.Run("expand", func(t *testing.T) {
+		stream := NewServerStream(Tracks{track})
+		r1, r2 := newTestReader(t, stream), newTestReader(t, stream)
+
+		// Inject a packet ahead of every packet with payload 1.
+		stream.SetPacketFilter(func(_ int, pkt *rtp.Packet) []*rtp.Packet {
+			if pkt.Payload[0] == 1 {
+				return []*rtp.Packet{newTestPacket(0, 9), pkt}
+			}
+			return []*rtp.Packet{pkt}
+		})
+
+		input := []*rtp.Packet{
+			newTestPacket(10, 0),
+			newTestPacket(11, 1),
+			newTestPacket(12, 2),
+		}
+		for _, pkt := range input {
+			stream.WritePacketRTP(0, pkt)
+		}
+		for _, r := range []*ServerSession{r1, r2} {
+			pkts := readPackets(t, r, 4)
+			require.Equal(t, []byte{0, 9, 1, 2}, payloads(pkts))
+			require.Equal(t, uint16(10), pkts[0].Header.SequenceNumber)
+			requireContinuous(t, pkts)
+		}
+
+		// The written packets are not modified.
+		require.Equal(t, uint16(11), input[1].Header.SequenceNumber)
+
+		// Numbering stays continuous after the filter is removed.
+		stream.SetPacketFilter(nil)
+		stream.WritePacketRTP(0, newTestPacket(13, 3))
+		pkts := readPackets(t, r1, 1)
+		require.Equal(t, uint16(14), pkts[0].Header.SequenceNumber)
+	})
+	t.Run("concurrentSet", func(t *testing.T) {
+		stream := NewServerStream(Tracks{track})
+		newTestReader(t, stream)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				stream.SetPacketFilter(func(_ int, pkt *rtp.Packet) []*rtp.Packet {
+					return []*rtp.Packet{pkt}
+				})
+				stream.SetPacketFilter(nil)
+			}
+		}()
+		for i := 0; i < 100; i++ {
+			stream.WritePacketRTP(0, newTestPacket(uint16(i), 0))
+		}
+		wg.Wait()
+	})
+	t.Run("concurrentWrite", func(t *testing.T) {
+		stream := NewServerStream(Tracks{track})
+		stream.SetPacketFilter(func(_ int, pkt *rtp.Packet) []*rtp.Packet {
+			if pkt.Payload[0] == 1 {
+				return nil
+			}
+			return []*rtp.Packet{pkt, pkt}
+		})
+
+		// Two writers of the same track change the sequence offset.
+		var wg sync.WaitGroup
+		for w := 0; w < 2; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					stream.WritePacketRTP(0, newTestPacket(uint16(i), byte(i%2)))
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func TestServerStreamGOPCache(t *testing.T) {
+	newTracks := func() Tracks {
+		return Tracks{
+			&TrackH264{PayloadType: 96, SPS: []byte{0x67, 1}, PPS: []byte{0x68, 1}},
+			&TrackMPEG4Audio{PayloadType: 97, Config: &mpeg4audio.Config{
+				Type:         mpeg4audio.ObjectTypeAACLC,
+				SampleRate:   48000,
+				ChannelCount: 2,
+			}},
+		}
+	}
+	newSession := func(t *testing.T) *ServerSession {
+		t.Helper()
+		writeBuffer, err := ringbuffer.New(64)
+		require.NoError(t, err)
+		return &ServerSession{
+			setuppedTracks: map[int]*ServerSessionSetuppedTrack{0: {}, 1: {}},
+			writeBuffer:    writeBuffer,
+		}
+	}
+	newPacket := func(seq uint16, ts uint32, payload ...byte) *rtp.Packet {
+		pkt := newTestPacket(seq, 0)
+		pkt.Header.Timestamp = ts
+		pkt.Payload = payload
+		return pkt
+	}
+	const (
+		nonIDR  = 0x01
+		fuA     = 0x1c
+		idrFUA  = 0x85 // Start of an IDR.
+		contFUA = 0x05
+	)
+	type received struct {
+		trackID int
+		seq     uint16
+		ts      uint32
+		payload []byte
+	}
+	readAll := func(t *testing.T, ss *ServerSession) []received {
+		t.Helper()
+		var pkts []received
+		for {
+			item, ok := ss.writeBuffer.Pull()
+			require.True(t, ok)
+			tp := item.(trackTypePayload)
+
+			var pkt rtp.Packet
+			require.NoError(t, pkt.Unmarshal(tp.payload))
+			pkts = append(pkts, received{
+				trackID: tp.trackID,
+				seq:     pkt.Header.SequenceNumber,
+				ts:      pkt.Header.Timestamp,
+				payload: pkt.Payload,
+			})
+			if pkt.Payload[0] == 0xff {
+				return pkts
+			}
+		}
+	}
+	// The last live packet written by the tests.
+	endMarker := func(stream *ServerStream, seq uint16) {
+		stream.WritePacketRTP(1, newPacket(seq, 0, 0xff))
+	}
+
+	t.Run("lateJoiner", func(t *testing.T) {
+		stream := NewServerStream(newTracks())
+		stream.EnableGOPCache(1000)
+
+		ntp := time.Unix(100, 0)
+		stream.WritePacketRTPWithNTP(0, newPacket(10, 0, nonIDR), ntp)
+		stream.WritePacketRTPWithNTP(1, newPacket(50, 0, 1), ntp)
+		stream.WritePacketRTPWithNTP(0, newPacket(11, 3000, fuA, idrFUA), ntp.Add(time.Second))
+		stream.WritePacketRTPWithNTP(0, newPacket(12, 3000, fuA, contFUA), ntp.Add(time.Second))
+		stream.WritePacketRTPWithNTP(1, newPacket(51, 960, 2), ntp.Add(time.Second))
+		// Lost packet.
+		stream.WritePacketRTPWithNTP(0, newPacket(15, 6000, nonIDR), ntp.Add(2*time.Second))
+
+		ss := newSession(t)
+		burst := stream.readerSetActive(ss)
+		require.NotNil(t, burst)
+		require.Equal(t, ntp.Add(time.Second), burst.ntp)
+		require.Equal(t, map[int]uint16{0: 13, 1: 51}, burst.seqs)
+
+		stream.WritePacketRTP(0, newPacket(16, 9000, nonIDR))
+		endMarker(stream, 52)
+
+		expected := []received{
+			{0, 13, 3000, []byte{fuA, idrFUA}},
+			{0, 14, 3000, []byte{fuA, contFUA}},
+			{1, 51, 960, []byte{2}},
+			{0, 15, 6000, []byte{nonIDR}},
+			{0, 16, 9000, []byte{nonIDR}},
+			{1, 52, 0, []byte{0xff}},
+		}
+		require.Equal(t, expected, readAll(t, ss))
+	})
+	t.Run("newGOP", func(t *testing.T) {
+		stream := NewServerStream(newTracks())
+		stream.EnableGOPCache(1000)
+
+		stream.WritePacketRTP(0, newPacket(1, 0, fuA, idrFUA))
+		stream.WritePacketRTP(0, newPacket(2, 3000, nonIDR))
+		stream.WritePacketRTP(1, newPacket(1, 100, 1))
+		// The parameter sets and the IDR have the same timestamp.
+		stream.WritePacketRTP(0, newPacket(3, 6000, 0x67, 1))
+		stream.WritePacketRTP(0, newPacket(4, 6000, fuA, idrFUA))
+
+		ss := newSession(t)
+		require.NotNil(t, stream.readerSetActive(ss))
+		endMarker(stream, 2)
+
+		pkts := readAll(t, ss)
+		require.Equal(t, []byte{0x67, 1}, pkts[0].payload)
+		require.Equal(t, []byte{fuA, idrFUA}, pkts[1].payload)
+		require.Len(t, pkts, 3)
+	})
+	t.Run("paramsChanged", func(t *testing.T) {
+		tracks := newTracks()
+		stream := NewServerStream(tracks)
+		stream.EnableGOPCache(1000)
+
+		stream.WritePacketRTP(0, newPacket(1, 0, fuA, idrFUA))
+		stream.Tracks()[0].(*TrackH264).SafeSetSPS([]byte{0x67, 2})
+		stream.WritePacketRTP(0, newPacket(2, 3000, nonIDR))
+
+		ss := newSession(t)
+		require.Nil(t, stream.readerSetActive(ss))
+
+		// The cache is ready after the next IDR.
+		stream.WritePacketRTP(0, newPacket(3, 6000, fuA, idrFUA))
+		require.NotNil(t, stream.readerSetActive(newSession(t)))
+	})
+	t.Run("maxBytes", func(t *testing.T) {
+		stream := NewServerStream(newTracks())
+		stream.EnableGOPCache(30)
+
+		stream.WritePacketRTP(0, newPacket(1, 0, fuA, idrFUA))
+		stream.WritePacketRTP(0, newPacket(2, 3000, nonIDR))
+		stream.WritePacketRTP(0, newPacket(3, 6000, nonIDR))
+		require.Nil(t, stream.readerSetActive(newSession(t)))
+	})
+	t.Run("disabled", func(t *testing.T) {
+		stream := NewServerStream(newTracks())
+		stream.WritePacketRTP(0, newPacket(1, 0, fuA, idrFUA))
+		require.Nil(t, stream.readerSetActive(newSession(t)))
+	})
+}
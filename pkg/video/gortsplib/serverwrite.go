@@ -0,0 +1,227 @@
+package gortsplib
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// ServerWriteRate limits the rate that packets are written to each reading
+// session, a single remote reader can't saturate the uplink and make the
+// TCP connections of the other sessions back up.
+type ServerWriteRate struct {
+	// Zero is unlimited.
+	BytesPerSecond int
+
+	// Bytes that can be written at once after the session has been idle,
+	// like a keyframe. Defaults to a second of data.
+	Burst int
+
+	// Sessions of connections from the same host are not limited
+	// and are not interleaved, see ServerConn.IsLocal.
+	// Used by the monitor pipeline.
+	ExemptLoopback bool
+}
+
+// SetWriteRate sets the rate limit of reading sessions.
+// Must be called before Start.
+func (s *Server) SetWriteRate(rate ServerWriteRate) {
+	s.writeRate = rate
+}
+
+// newWriteBucket returns the token bucket of a session that
+// reads from the connection, nil if the session isn't limited.
+func (s *Server) newWriteBucket(remote net.Addr, local net.Addr) *tokenBucket {
+	rate := s.writeRate
+	if rate.BytesPerSecond <= 0 {
+		return nil
+	}
+	if rate.ExemptLoopback && isLocalAddr(remote, local) {
+		return nil
+	}
+	burst := rate.Burst
+	if burst <= 0 {
+		burst = rate.BytesPerSecond
+	}
+	return newTokenBucket(rate.BytesPerSecond, burst, time.Now)
+}
+
+// tokenBucket paces the writes of a session. The bucket is
+// filled at the rate up to the burst size, packets that are
+// larger than the tokens left put the bucket in debt.
+type tokenBucket struct {
+	rate  float64 // Bytes per second.
+	burst float64
+	now   func() time.Time
+
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int, burst int, now func() time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		now:    now,
+		tokens: float64(burst),
+		last:   now(),
+	}
+}
+
+// reserve takes n bytes from the bucket and returns
+// how long to wait before they can be written.
+func (b *tokenBucket) reserve(n int) time.Duration {
+	now := b.now()
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+
+	b.tokens += elapsed * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// A writer that is blocked by a slow client for longer than this
+// is skipped, the other sessions continue without waiting for it.
+const writeStallTimeout = 50 * time.Millisecond
+
+// writeScheduler interleaves the writes of the rate limited sessions.
+// Each session counts the packets that it started to write, a session
+// can't start a packet while another session is behind it, a session
+// with a backlog, like a keyframe burst, waits for the other sessions
+// that are writing instead of draining its backlog at once.
+//
+// The writes are not serialized, the mutex only protects the counters
+// and is never held during a write. Sessions of the same host and
+// unlimited sessions don't use the scheduler.
+type writeScheduler struct {
+	stallTimeout time.Duration
+
+	mutex   sync.Mutex
+	writers map[*writeTurn]struct{}
+}
+
+func newWriteScheduler(stallTimeout time.Duration) *writeScheduler {
+	return &writeScheduler{
+		stallTimeout: stallTimeout,
+		writers:      make(map[*writeTurn]struct{}),
+	}
+}
+
+// writeTurn is owned by a single writer and reused for every packet.
+type writeTurn struct {
+	ready chan struct{}
+
+	packets uint64
+	writing bool
+	started time.Time
+}
+
+func newWriteTurn() *writeTurn {
+	return &writeTurn{ready: make(chan struct{}, 1)}
+}
+
+// acquire blocks until the writer can start its next packet.
+func (s *writeScheduler) acquire(t *writeTurn) {
+	s.mutex.Lock()
+
+	// A writer that was idle starts next to the sessions
+	// that are writing instead of catching up with them.
+	if min, ok := s.minPackets(); ok && t.packets+1 < min {
+		t.packets = min - 1
+	}
+	s.writers[t] = struct{}{}
+
+	for {
+		now := time.Now()
+		wait, blocked := s.blocked(t, now)
+		if !blocked {
+			t.packets++
+			t.writing = true
+			t.started = now
+			s.wake()
+			s.mutex.Unlock()
+			return
+		}
+		s.mutex.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-t.ready:
+		case <-timer.C:
+		}
+		timer.Stop()
+
+		s.mutex.Lock()
+	}
+}
+
+// release is called after the packet has been written.
+func (s *writeScheduler) release(t *writeTurn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	t.writing = false
+	delete(s.writers, t)
+	s.wake()
+}
+
+// wake makes the waiting writers check if they can continue.
+func (s *writeScheduler) wake() {
+	for w := range s.writers {
+		if w.writing {
+			continue
+		}
+		select {
+		case w.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// minPackets returns the lowest packet count of the writers.
+func (s *writeScheduler) minPackets() (uint64, bool) {
+	var min uint64
+	found := false
+	for w := range s.writers {
+		if !found || w.packets < min {
+			min = w.packets
+			found = true
+		}
+	}
+	return min, found
+}
+
+// blocked returns true if another writer is behind the writer. The
+// duration is the time until the writers that block it are stalled.
+func (s *writeScheduler) blocked(t *writeTurn, now time.Time) (time.Duration, bool) {
+	var wait time.Duration
+	blocked := false
+	for w := range s.writers {
+		if w == t || w.packets >= t.packets {
+			continue
+		}
+
+		// The writers are woken up when it starts writing.
+		if !w.writing {
+			blocked = true
+			wait = s.stallTimeout
+			continue
+		}
+
+		stall := w.started.Add(s.stallTimeout).Sub(now)
+		if stall <= 0 {
+			continue
+		}
+		if !blocked || stall < wait {
+			wait = stall
+		}
+		blocked = true
+	}
+	return wait, blocked
+}
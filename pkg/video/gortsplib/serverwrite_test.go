@@ -0,0 +1,296 @@
+package gortsplib
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"nvr/pkg/video/gortsplib/pkg/base"
+	"nvr/pkg/video/gortsplib/pkg/conn"
+	"nvr/pkg/video/gortsplib/pkg/headers"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	clock := func() time.Time { return now }
+
+	// 1000 bytes per second, 500 byte burst.
+	b := newTokenBucket(1000, 500, clock)
+
+	// The burst is written immediately.
+	require.Equal(t, time.Duration(0), b.reserve(300))
+	require.Equal(t, time.Duration(0), b.reserve(200))
+
+	// The bucket is empty.
+	require.Equal(t, 100*time.Millisecond, b.reserve(100))
+	require.Equal(t, 300*time.Millisecond, b.reserve(200))
+
+	// Paced at the rate.
+	now = now.Add(300 * time.Millisecond)
+	require.Equal(t, 100*time.Millisecond, b.reserve(100))
+
+	// Idle time fills the bucket up to the burst.
+	now = now.Add(time.Hour)
+	require.Equal(t, time.Duration(0), b.reserve(500))
+	require.Equal(t, 10*time.Millisecond, b.reserve(10))
+
+	// Large packets put the bucket in debt.
+	now = now.Add(time.Hour)
+	require.Equal(t, 1500*time.Millisecond, b.reserve(2000))
+	now = now.Add(1500 * time.Millisecond)
+	require.Equal(t, time.Duration(0), b.reserve(0))
+}
+
+func TestServerNewWriteBucket(t *testing.T) {
+	server := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 554}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 1000}
+	loopback := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1000}
+	sameHost := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 1000}
+
+	s := &Server{}
+	require.Nil(t, s.newWriteBucket(remote, server))
+
+	s.SetWriteRate(ServerWriteRate{BytesPerSecond: 1000, ExemptLoopback: true})
+	b := s.newWriteBucket(remote, server)
+	require.NotNil(t, b)
+	require.Equal(t, float64(1000), b.burst)
+	require.Nil(t, s.newWriteBucket(loopback, server))
+	require.Nil(t, s.newWriteBucket(sameHost, server))
+
+	s.SetWriteRate(ServerWriteRate{BytesPerSecond: 1000, Burst: 200})
+	b = s.newWriteBucket(loopback, server)
+	require.NotNil(t, b)
+	require.Equal(t, float64(200), b.burst)
+}
+
+// acquireAsync acquires the turn in a goroutine, the
+// returned channel is closed when it's acquired.
+func acquireAsync(s *writeScheduler, turn *writeTurn) chan struct{} {
+	acquired := make(chan struct{})
+	go func() {
+		s.acquire(turn)
+		close(acquired)
+	}()
+	return acquired
+}
+
+func requireAcquired(t *testing.T, acquired chan struct{}) {
+	t.Helper()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func requireBlocked(t *testing.T, acquired chan struct{}) {
+	t.Helper()
+	select {
+	case <-acquired:
+		t.Fatal("should be blocked")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestWriteScheduler(t *testing.T) {
+	t.Run("interleave", func(t *testing.T) {
+		s := newWriteScheduler(time.Hour)
+		a, b := newWriteTurn(), newWriteTurn()
+
+		// Both streams write their first packet at the same time.
+		s.acquire(a)
+		s.acquire(b)
+
+		// A can start one packet ahead of B.
+		s.release(a)
+		s.acquire(a)
+		s.release(a)
+
+		// B is still writing its first packet.
+		acquired := acquireAsync(s, a)
+		requireBlocked(t, acquired)
+
+		// B starts its second packet, A can continue.
+		s.release(b)
+		s.acquire(b)
+		requireAcquired(t, acquired)
+		require.Equal(t, uint64(3), a.packets)
+		require.Equal(t, uint64(2), b.packets)
+
+		s.release(a)
+		s.release(b)
+		require.Empty(t, s.writers)
+	})
+	t.Run("uncontended", func(t *testing.T) {
+		s := newWriteScheduler(time.Hour)
+		turn := newWriteTurn()
+		for i := 0; i < 3; i++ {
+			s.acquire(turn)
+			require.True(t, turn.writing)
+			s.release(turn)
+		}
+		require.Equal(t, uint64(3), turn.packets)
+		require.Empty(t, s.writers)
+	})
+	t.Run("idle", func(t *testing.T) {
+		s := newWriteScheduler(time.Hour)
+		a, b := newWriteTurn(), newWriteTurn()
+		for i := 0; i < 5; i++ {
+			s.acquire(a)
+			s.release(a)
+		}
+
+		// B was idle, it doesn't get to write 5 packets in a row.
+		s.acquire(a)
+		s.acquire(b)
+		require.Equal(t, uint64(6), b.packets)
+
+		s.release(a)
+		s.release(b)
+	})
+	t.Run("stall", func(t *testing.T) {
+		s := newWriteScheduler(10 * time.Millisecond)
+		a, b := newWriteTurn(), newWriteTurn()
+
+		// The client of A is blocked.
+		s.acquire(a)
+
+		s.acquire(b)
+		s.release(b)
+		s.acquire(b)
+		s.release(b)
+
+		// B waits for A until A is stalled.
+		requireAcquired(t, acquireAsync(s, b))
+		require.Equal(t, uint64(3), b.packets)
+
+		// A finishes after it was skipped.
+		s.release(a)
+		s.release(b)
+		require.Empty(t, s.writers)
+	})
+}
+
+func TestServerWriteRateSlowRemoteReader(t *testing.T) {
+	ip := net.ParseIP(getIP(t))
+	if ip == nil || ip.To4() == nil {
+		t.Skip("no IPv4 address")
+	}
+
+	stream := NewServerStream(Tracks{&TrackH264{
+		PayloadType: 96,
+		SPS:         []byte{0x01, 0x02, 0x03, 0x04},
+		PPS:         []byte{0x01, 0x02, 0x03, 0x04},
+	}})
+	defer stream.Close()
+
+	s := &Server{
+		handler: &testServerHandler{
+			onSessionOpen: func(_ *ServerSession, sc *ServerConn, _ string) {
+				// Fill the buffers quickly.
+				sc.NetConn().(*net.TCPConn).SetWriteBuffer(4096) //nolint:errcheck,forcetypeassert
+			},
+			onSetup: func(*ServerSession, string, int) (*base.Response, *ServerStream, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, stream, nil
+			},
+			onPlay: func(*ServerSession, headers.Range) (*base.Response, error) {
+				return &base.Response{
+					StatusCode: base.StatusOK,
+				}, nil
+			},
+		},
+		rtspAddress: "127.0.0.1:8554",
+	}
+	s.SetWriteRate(ServerWriteRate{BytesPerSecond: 1 << 30, ExemptLoopback: true})
+	require.NoError(t, s.Start())
+	defer s.Close()
+
+	play := func(localIP net.IP) *conn.Conn {
+		dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: localIP}}
+		nconn, err := dialer.Dial("tcp", "127.0.0.1:8554")
+		require.NoError(t, err)
+		t.Cleanup(func() { nconn.Close() })
+		nconn.(*net.TCPConn).SetReadBuffer(4096) //nolint:errcheck,forcetypeassert
+		conn := conn.NewConn(nconn)
+
+		res, err := writeReqReadRes(conn, base.Request{
+			Method: base.Setup,
+			URL:    mustParseURL("rtsp://127.0.0.1:8554/teststream/trackID=0"),
+			Header: base.Header{
+				"CSeq": base.HeaderValue{"1"},
+				"Transport": headers.Transport{
+					Mode: func() *headers.TransportMode {
+						v := headers.TransportModePlay
+						return &v
+					}(),
+					InterleavedIDs: &[2]int{0, 1},
+				}.Marshal(),
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, base.StatusOK, res.StatusCode)
+
+		var sx headers.Session
+		require.NoError(t, sx.Unmarshal(res.Header["Session"]))
+
+		res, err = writeReqReadRes(conn, base.Request{
+			Method: base.Play,
+			URL:    mustParseURL("rtsp://127.0.0.1:8554/teststream"),
+			Header: base.Header{
+				"CSeq":    base.HeaderValue{"2"},
+				"Session": base.HeaderValue{sx.Session},
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, base.StatusOK, res.StatusCode)
+		return conn
+	}
+
+	// The remote client is rate limited, it reads a few packets
+	// now and then and its writes are blocked most of the time.
+	remote := play(ip)
+	loopback := play(net.ParseIP("127.0.0.1"))
+
+	remoteDone := make(chan struct{})
+	remoteTerminate := make(chan struct{})
+	defer func() {
+		close(remoteTerminate)
+		<-remoteDone
+	}()
+	go func() {
+		defer close(remoteDone)
+		for {
+			select {
+			case <-time.After(writeStallTimeout):
+			case <-remoteTerminate:
+				return
+			}
+			for i := 0; i < 10; i++ {
+				if _, err := remote.ReadInterleavedFrame(); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	pkt := testRTPPacket
+	pkt.Payload = make([]byte, 1000)
+
+	// Each packet is received by the loopback client without
+	// waiting for the blocked writes of the remote client.
+	var maxLatency time.Duration
+	for end := time.Now().Add(10 * writeStallTimeout); time.Now().Before(end); {
+		start := time.Now()
+		stream.WritePacketRTP(0, &pkt)
+		_, err := loopback.ReadInterleavedFrame()
+		require.NoError(t, err)
+		if latency := time.Since(start); latency > maxLatency {
+			maxLatency = latency
+		}
+	}
+	require.Less(t, maxLatency, writeStallTimeout/2)
+}
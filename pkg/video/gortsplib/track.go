@@ -23,7 +23,7 @@ type Track interface {
 	// MediaDescription returns the track media description in SDP format.
 	MediaDescription() *psdp.MediaDescription
 	clone() Track
-	url(*url.URL) (*url.URL, error)
+	url(TrackURLBase) (*url.URL, error)
 }
 
 // Track errors.
@@ -86,8 +86,8 @@ func newTrackFromMediaDescription(md *psdp.MediaDescription) (Track, error) {
 		codec, clock := getCodecAndClock(md.Attributes, payloadType)
 		codec = strings.ToLower(codec)
 
-		if md.MediaName.Media == "video" && codec == "h264" && clock == "90000" {
-			return newTrackH264FromMediaDescription(control, payloadType, md)
+		if md.MediaName.Media == "video" && codec == "h264" {
+			return newTrackH264FromMediaDescription(control, payloadType, clock, md)
 		} else if md.MediaName.Media == "audio" && strings.ToLower(codec) == "mpeg4-generic" {
 			return newTrackMPEG4AudioFromMediaDescription(control, payloadType, md)
 		}
@@ -110,29 +110,59 @@ func (t *trackBase) SetControl(c string) {
 	t.control = c
 }
 
-func (t *trackBase) url(contentBase *url.URL) (*url.URL, error) {
-	if contentBase == nil {
+// TrackURLBase is used to resolve the track control attributes, RFC 2326 C.1.1.
+type TrackURLBase struct {
+	// Relative controls are resolved against the content base.
+	ContentBase *url.URL
+
+	// URL that the connection was made to, defaults to the content base.
+	// Cameras behind NAT often return their internal address in the
+	// controls and in the Content-Base header. Resolved URLs with another
+	// host whose path begins with the path of the content base use the
+	// host and credentials of the connection instead.
+	Conn *url.URL
+
+	// Strict disables the host replacement.
+	Strict bool
+}
+
+func (t *trackBase) url(b TrackURLBase) (*url.URL, error) {
+	if b.ContentBase == nil {
 		return nil, ErrTrackContentBaseMissing
 	}
+	conn := b.Conn
+	if conn == nil {
+		conn = b.ContentBase
+	}
+
+	ur, err := resolveControl(b.ContentBase, t.GetControl())
+	if err != nil {
+		return nil, err
+	}
 
-	control := t.GetControl()
+	if url.HostsEqual(ur.Host, conn.Host) {
+		if ur.User == nil {
+			ur.User = conn.User
+		}
+		return ur, nil
+	}
+	if b.Strict || !hasPathPrefix(ur, b.ContentBase) {
+		return ur, nil
+	}
+	ur.Host = conn.Host
+	ur.User = conn.User
+	return ur, nil
+}
 
+func resolveControl(contentBase *url.URL, control string) (*url.URL, error) {
 	// no control attribute, use base URL
 	if control == "" {
-		return contentBase, nil
+		return contentBase.Clone(), nil
 	}
 
 	// control attribute contains an absolute path
-	if strings.HasPrefix(control, "rtsp://") {
-		ur, err := url.Parse(control)
-		if err != nil {
-			return nil, err
-		}
-
-		// copy host and credentials
-		ur.Host = contentBase.Host
-		ur.User = contentBase.User
-		return ur, nil
+	if isAbsoluteControl(control) {
+		return url.Parse(control)
 	}
 
 	// control attribute contains a relative control attribute
@@ -144,6 +174,28 @@ func (t *trackBase) url(contentBase *url.URL) (*url.URL, error) {
 		strURL += "/"
 	}
 
-	ur, _ := url.Parse(strURL + control)
-	return ur, nil
+	return url.Parse(strURL + control)
+}
+
+func isAbsoluteControl(control string) bool {
+	control = strings.ToLower(control)
+	return strings.HasPrefix(control, "rtsp://") || strings.HasPrefix(control, "rtsps://")
+}
+
+// hasPathPrefix returns true if the path of u begins with the
+// path of base. Trailing slashes and queries are ignored.
+func hasPathPrefix(u *url.URL, base *url.URL) bool {
+	path, ok := u.RTSPPath()
+	if !ok {
+		return false
+	}
+	basePath, ok := base.RTSPPath()
+	if !ok {
+		return false
+	}
+	basePath = strings.TrimSuffix(basePath, "/")
+	if basePath == "" {
+		return true
+	}
+	return path == basePath || strings.HasPrefix(path, basePath+"/")
 }
@@ -30,6 +30,10 @@ type TrackH264 struct {
 	PPS               []byte
 	PacketizationMode int
 
+	// RTPClockRate is only set for cameras that don't use
+	// the standard 90kHz clock, 90000 is used if zero.
+	RTPClockRate int
+
 	trackBase
 	mu sync.RWMutex
 }
@@ -37,14 +41,23 @@ type TrackH264 struct {
 func newTrackH264FromMediaDescription(
 	control string,
 	payloadType uint8,
+	clock string,
 	md *psdp.MediaDescription,
-) (*TrackH264, error) { //nolint:unparam
+) (*TrackH264, error) {
+	clockRate, err := parseH264ClockRate(clock)
+	if err != nil {
+		return nil, err
+	}
+
 	t := &TrackH264{
 		PayloadType: payloadType,
 		trackBase: trackBase{
 			control: control,
 		},
 	}
+	if clockRate != h264ClockRate {
+		t.RTPClockRate = clockRate
+	}
 
 	t.fillParamsFromMediaDescription(md) //nolint:errcheck
 
@@ -107,9 +120,23 @@ func (t *TrackH264) fillParamsFromMediaDescription(md *psdp.MediaDescription) er
 	return fmt.Errorf("%w (%v)", ErrH264spropMissing, v)
 }
 
+// The clock rate required by RFC 6184.
+const h264ClockRate = 90000
+
+func parseH264ClockRate(clock string) (int, error) {
+	clockRate, err := strconv.Atoi(clock)
+	if err != nil || clockRate <= 0 {
+		return 0, fmt.Errorf("%w: H264 %q", ErrSDPClockRateInvalid, clock)
+	}
+	return clockRate, nil
+}
+
 // ClockRate returns the track clock rate.
 func (t *TrackH264) ClockRate() int {
-	return 90000
+	if t.RTPClockRate != 0 {
+		return t.RTPClockRate
+	}
+	return h264ClockRate
 }
 
 // MediaDescription returns the track media description in SDP format.
@@ -149,7 +176,7 @@ func (t *TrackH264) MediaDescription() *psdp.MediaDescription {
 		Attributes: []psdp.Attribute{
 			{
 				Key:   "rtpmap",
-				Value: typ + " H264/90000",
+				Value: typ + " H264/" + strconv.Itoa(t.ClockRate()),
 			},
 			{
 				Key:   "fmtp",
@@ -169,6 +196,7 @@ func (t *TrackH264) clone() Track {
 		SPS:               t.SPS,
 		PPS:               t.PPS,
 		PacketizationMode: t.PacketizationMode,
+		RTPClockRate:      t.RTPClockRate,
 		trackBase:         t.trackBase,
 	}
 }
@@ -177,6 +205,7 @@ func (t *TrackH264) clone() Track {
 func (t *TrackH264) CreateDecoder() *rtph264.Decoder {
 	d := &rtph264.Decoder{
 		PacketizationMode: t.PacketizationMode,
+		ClockRate:         t.ClockRate(),
 	}
 	d.Init()
 	return d
@@ -187,6 +216,7 @@ func (t *TrackH264) CreateEncoder() *rtph264.Encoder {
 	e := &rtph264.Encoder{
 		PayloadType:       t.PayloadType,
 		PacketizationMode: t.PacketizationMode,
+		ClockRate:         t.ClockRate(),
 	}
 	e.Init()
 	return e
@@ -263,4 +263,12 @@ func TestTrackH264MediaDescription(t *testing.T) {
 			},
 		}, track.MediaDescription())
 	})
+	t.Run("clock rate", func(t *testing.T) {
+		track := &TrackH264{
+			PayloadType:  96,
+			RTPClockRate: 1000,
+		}
+		require.Equal(t, 1000, track.ClockRate())
+		require.Equal(t, "96 H264/1000", track.MediaDescription().Attributes[0].Value)
+	})
 }
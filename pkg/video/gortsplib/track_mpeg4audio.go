@@ -8,6 +8,7 @@ import (
 	"nvr/pkg/video/gortsplib/pkg/rtpmpeg4audio"
 	"strconv"
 	"strings"
+	"unicode"
 
 	psdp "github.com/pion/sdp/v3"
 )
@@ -35,72 +36,118 @@ var (
 	ErrACCindexDeltaLengthInvalid = errors.New("invalid AAC IndexDeltaLength")
 )
 
-func newTrackMPEG4AudioFromMediaDescription( //nolint:funlen
+// AU header lengths of the AAC-hbr mode. Used when the
+// fmtp doesn't specify any of them, like ffmpeg does.
+const (
+	defaultAACSizeLength       = 13
+	defaultAACIndexLength      = 3
+	defaultAACIndexDeltaLength = 3
+)
+
+// The profile-level-id written by this package and ffmpeg.
+const expectedAACProfileLevelID = "1"
+
+func newTrackMPEG4AudioFromMediaDescription(
 	control string,
 	payloadType uint8,
 	md *psdp.MediaDescription,
 ) (*TrackMPEG4Audio, error) {
+	t := &TrackMPEG4Audio{
+		PayloadType: payloadType,
+		trackBase: trackBase{
+			control: control,
+		},
+	}
+	if _, err := t.fillParamsFromMediaDescription(md); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// fillParamsFromMediaDescription parses the fmtp attribute. Cameras
+// produce all kinds of nonstandard output, parameter names are case
+// insensitive and whitespace is ignored. Returns warnings about
+// unexpected values that were accepted anyway.
+func (t *TrackMPEG4Audio) fillParamsFromMediaDescription( //nolint:funlen
+	md *psdp.MediaDescription,
+) ([]string, error) {
 	v, ok := md.Attribute("fmtp")
 	if !ok {
 		return nil, ErrAACfmtpMissing
 	}
 
-	tmp := strings.SplitN(v, " ", 2)
-	if len(tmp) != 2 {
+	_, params, ok := cutSpace(strings.TrimSpace(v))
+	if !ok {
 		return nil, fmt.Errorf("%w (%v)", ErrACCfmtpInvalid, v)
 	}
 
-	t := &TrackMPEG4Audio{
-		PayloadType: payloadType,
-		trackBase: trackBase{
-			control: control,
-		},
-	}
+	var warnings []string
+	auHeaderSpecified := false
 
-	for _, kv := range strings.Split(tmp[1], ";") {
-		kv = strings.Trim(kv, " ")
+	for _, kv := range strings.Split(params, ";") {
+		kv = strings.TrimSpace(kv)
 
 		if len(kv) == 0 {
 			continue
 		}
 
-		tmp := strings.SplitN(kv, "=", 2)
-		if len(tmp) != 2 {
+		key, val, ok := strings.Cut(kv, "=")
+		if !ok {
 			return nil, fmt.Errorf("%w (%v)", ErrACCfmtpInvalid, v)
 		}
-		switch strings.ToLower(tmp[0]) {
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+
+		switch key {
+		case "profile-level-id":
+			if val != expectedAACProfileLevelID {
+				warnings = append(warnings, fmt.Sprintf(
+					"unexpected AAC profile-level-id %q, expected %q",
+					val, expectedAACProfileLevelID))
+			}
+
 		case "config":
-			enc, err := hex.DecodeString(tmp[1])
+			// Some cameras drop the trailing zero of the config.
+			// The bits are left aligned so padding with a zero
+			// only adds unused bits to the end.
+			config := val
+			if len(config)%2 != 0 {
+				config += "0"
+			}
+			enc, err := hex.DecodeString(config)
 			if err != nil {
-				return nil, fmt.Errorf("%w (%v)", ErrACCconfigInvalid, tmp[1])
+				return nil, fmt.Errorf("%w (%v)", ErrACCconfigInvalid, val)
 			}
 
 			t.Config = &mpeg4audio.Config{}
 			err = t.Config.Unmarshal(enc)
 			if err != nil {
-				return nil, fmt.Errorf("%w (%v)", ErrACCconfigInvalid, tmp[1])
+				return nil, fmt.Errorf("%w (%v)", ErrACCconfigInvalid, val)
 			}
 
 		case "sizelength":
-			val, err := strconv.ParseUint(tmp[1], 10, 64)
+			auHeaderSpecified = true
+			n, err := strconv.ParseUint(val, 10, 64)
 			if err != nil {
-				return nil, fmt.Errorf("%w (%v)", ErrACCsizelengthMissing, tmp[1])
+				return nil, fmt.Errorf("%w (%v)", ErrACCsizelengthMissing, val)
 			}
-			t.SizeLength = int(val)
+			t.SizeLength = int(n)
 
 		case "indexlength":
-			val, err := strconv.ParseUint(tmp[1], 10, 64)
+			auHeaderSpecified = true
+			n, err := strconv.ParseUint(val, 10, 64)
 			if err != nil {
-				return nil, fmt.Errorf("%w (%v)", ErrACCindexLengthInvalid, tmp[1])
+				return nil, fmt.Errorf("%w (%v)", ErrACCindexLengthInvalid, val)
 			}
-			t.IndexLength = int(val)
+			t.IndexLength = int(n)
 
 		case "indexdeltalength":
-			val, err := strconv.ParseUint(tmp[1], 10, 64)
+			auHeaderSpecified = true
+			n, err := strconv.ParseUint(val, 10, 64)
 			if err != nil {
-				return nil, fmt.Errorf("%w (%v)", ErrACCindexDeltaLengthInvalid, tmp[1])
+				return nil, fmt.Errorf("%w (%v)", ErrACCindexDeltaLengthInvalid, val)
 			}
-			t.IndexDeltaLength = int(val)
+			t.IndexDeltaLength = int(n)
 		}
 	}
 
@@ -108,11 +155,26 @@ func newTrackMPEG4AudioFromMediaDescription( //nolint:funlen
 		return nil, fmt.Errorf("%w (%v)", ErrACCconfigMissing, v)
 	}
 
+	if !auHeaderSpecified {
+		t.SizeLength = defaultAACSizeLength
+		t.IndexLength = defaultAACIndexLength
+		t.IndexDeltaLength = defaultAACIndexDeltaLength
+	}
+
 	if t.SizeLength == 0 {
 		return nil, fmt.Errorf("%w (%v)", ErrACCsizelengthMissing, v)
 	}
 
-	return t, nil
+	return warnings, nil
+}
+
+// cutSpace slices s around the first whitespace.
+func cutSpace(s string) (string, string, bool) {
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i == -1 {
+		return s, "", false
+	}
+	return s[:i], strings.TrimSpace(s[i:]), true
 }
 
 // ClockRate returns the track clock rate.
@@ -78,3 +78,119 @@ func TestTrackMPEG4AudioMediaDescription(t *testing.T) {
 		},
 	}, track.MediaDescription())
 }
+
+func newTestMPEG4AudioMediaDescription(fmtp string) *psdp.MediaDescription {
+	return &psdp.MediaDescription{
+		MediaName: psdp.MediaName{
+			Media:   "audio",
+			Protos:  []string{"RTP", "AVP"},
+			Formats: []string{"96"},
+		},
+		Attributes: []psdp.Attribute{
+			{
+				Key:   "rtpmap",
+				Value: "96 mpeg4-generic/16000/2",
+			},
+			{
+				Key:   "fmtp",
+				Value: fmtp,
+			},
+		},
+	}
+}
+
+func TestTrackMPEG4AudioNonstandardFmtp(t *testing.T) {
+	config := &mpeg4audio.Config{
+		Type:         mpeg4audio.ObjectTypeAACLC,
+		SampleRate:   16000,
+		ChannelCount: 2,
+	}
+	testCases := []struct {
+		name     string
+		fmtp     string
+		warnings []string
+	}{
+		{
+			"uppercaseNames",
+			"96 streamtype=5; profile-level-id=1; mode=AAC-hbr; " +
+				"SizeLength=13; IndexLength=3; IndexDeltaLength=3; Config=1410",
+			nil,
+		},
+		{
+			"noSpaces",
+			"96 streamtype=5;profile-level-id=1;mode=AAC-hbr;" +
+				"sizelength=13;indexlength=3;indexdeltalength=3;config=1410;",
+			nil,
+		},
+		{
+			"extraWhitespace",
+			"96  profile-level-id = 1 ;\tmode=AAC-hbr ;  sizelength=13 ; " +
+				"indexlength=3; indexdeltalength=3 ; config=1410 ",
+			nil,
+		},
+		{
+			"tab",
+			"96\tprofile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;" +
+				"indexdeltalength=3;config=1410",
+			nil,
+		},
+		{
+			"oddLengthConfig",
+			"96 profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;" +
+				"indexdeltalength=3;config=141",
+			nil,
+		},
+		{
+			"profileLevelID15",
+			"96 streamtype=5; profile-level-id=15; mode=AAC-hbr; config=1410; " +
+				"SizeLength=13; IndexLength=3; IndexDeltaLength=3; Profile=1;",
+			[]string{`unexpected AAC profile-level-id "15", expected "1"`},
+		},
+		{
+			"missingLengths",
+			"96 profile-level-id=1;mode=AAC-hbr;config=1410",
+			nil,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			md := newTestMPEG4AudioMediaDescription(tc.fmtp)
+
+			var track TrackMPEG4Audio
+			warnings, err := track.fillParamsFromMediaDescription(md)
+			require.NoError(t, err)
+			require.Equal(t, tc.warnings, warnings)
+
+			require.Equal(t, TrackMPEG4Audio{
+				Config:           config,
+				SizeLength:       13,
+				IndexLength:      3,
+				IndexDeltaLength: 3,
+			}, track)
+		})
+	}
+}
+
+func TestTrackMPEG4AudioFmtpRoundTrip(t *testing.T) {
+	fmtp := "96 streamtype=5; profile-level-id=15; mode=AAC-hbr; config=141; " +
+		"SizeLength=13; IndexLength=3; IndexDeltaLength=3; Profile=1;"
+
+	track, err := newTrackMPEG4AudioFromMediaDescription(
+		"trackID=1", 96, newTestMPEG4AudioMediaDescription(fmtp))
+	require.NoError(t, err)
+
+	md := track.MediaDescription()
+	canonical := "96 profile-level-id=1; mode=AAC-hbr; sizelength=13; " +
+		"indexlength=3; indexdeltalength=3; config=1410"
+	require.Equal(t, []psdp.Attribute{
+		{Key: "rtpmap", Value: "96 mpeg4-generic/16000/2"},
+		{Key: "fmtp", Value: canonical},
+		{Key: "control", Value: "trackID=1"},
+	}, md.Attributes)
+
+	// The canonical output is parsed into the same track.
+	track2, err := newTrackMPEG4AudioFromMediaDescription("trackID=1", 96, md)
+	require.NoError(t, err)
+	require.Equal(t, track, track2)
+	require.Equal(t, md, track2.MediaDescription())
+}
@@ -3,7 +3,10 @@ package gortsplib
 import (
 	"errors"
 	"fmt"
+	neturl "net/url"
+	"nvr/pkg/video/gortsplib/pkg/base"
 	"nvr/pkg/video/gortsplib/pkg/sdp"
+	"nvr/pkg/video/gortsplib/pkg/url"
 	"strconv"
 
 	psdp "github.com/pion/sdp/v3"
@@ -44,6 +47,49 @@ func (ts *Tracks) Unmarshal(byts []byte) (*sdp.SessionDescription, error) {
 	return &sd, nil
 }
 
+// ErrContentBaseInvalid invalid Content-Base or Content-Location header.
+var ErrContentBaseInvalid = errors.New("invalid content base")
+
+// ContentBase returns the URL that relative track controls are resolved
+// against. The Content-Base header, then the Content-Location header,
+// then the request URL, RFC 2326 C.1.1. A relative Content-Location
+// is resolved against the request URL.
+func ContentBase(header base.Header, requestURL *url.URL) (*url.URL, error) {
+	for _, key := range []string{"Content-Base", "Content-Location"} {
+		v, ok := header[key]
+		if !ok || len(v) != 1 || v[0] == "" {
+			continue
+		}
+		if isAbsoluteControl(v[0]) {
+			u, err := url.Parse(v[0])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrContentBaseInvalid, key, err)
+			}
+			return u, nil
+		}
+
+		ref, err := neturl.Parse(v[0])
+		if err != nil || ref.IsAbs() {
+			return nil, fmt.Errorf("%w: %s: %v", ErrContentBaseInvalid, key, v[0])
+		}
+		return (*url.URL)((*neturl.URL)(requestURL).ResolveReference(ref)), nil
+	}
+	return requestURL, nil
+}
+
+// URLs returns the URLs of the tracks, used in the SETUP requests.
+func (ts Tracks) URLs(b TrackURLBase) ([]*url.URL, error) {
+	urls := make([]*url.URL, len(ts))
+	for i, t := range ts {
+		u, err := t.url(b)
+		if err != nil {
+			return nil, fmt.Errorf("track %d: %w", i+1, err)
+		}
+		urls[i] = u
+	}
+	return urls, nil
+}
+
 func (ts Tracks) clone() Tracks {
 	ret := make(Tracks, len(ts))
 	for i, track := range ts {
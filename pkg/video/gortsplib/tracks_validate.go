@@ -0,0 +1,199 @@
+package gortsplib
+
+import (
+	"errors"
+	"fmt"
+	"nvr/pkg/video/gortsplib/pkg/sdp"
+	"strconv"
+	"strings"
+
+	psdp "github.com/pion/sdp/v3"
+)
+
+// SDP validation errors.
+var (
+	ErrSDPNoMedia            = errors.New("no media sections")
+	ErrSDPMultipleFormats    = errors.New("multiple payload types in one media section are not supported")
+	ErrSDPPayloadTypeInvalid = errors.New("invalid payload type")
+	ErrSDPRtpmapMissing      = errors.New("rtpmap attribute is missing")
+	ErrSDPRtpmapInvalid      = errors.New("invalid rtpmap attribute")
+	ErrSDPClockRateInvalid   = errors.New("invalid clock rate")
+	ErrSDPCodecUnsupported   = errors.New("unsupported codec")
+	ErrSDPControlDuplicate   = errors.New("duplicate control attribute")
+)
+
+const supportedCodecs = "supported codecs are H264 video and MPEG4-GENERIC (AAC) audio"
+
+// SDPProblem is a problem with a single media section.
+type SDPProblem struct {
+	// Media is the 1-based index of the media section, 0 for the session.
+	Media int
+	Err   error
+}
+
+// Error implements the error interface.
+func (p SDPProblem) Error() string {
+	if p.Media == 0 {
+		return p.Err.Error()
+	}
+	return fmt.Sprintf("media %d: %v", p.Media, p.Err)
+}
+
+// Unwrap returns the underlying error.
+func (p SDPProblem) Unwrap() error {
+	return p.Err
+}
+
+// SDPValidationError lists every problem of an announced SDP.
+type SDPValidationError struct {
+	Problems []SDPProblem
+}
+
+// Error implements the error interface.
+func (e SDPValidationError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		problems[i] = p.Error()
+	}
+	return strings.Join(problems, "; ")
+}
+
+// Unwrap returns the problems.
+func (e SDPValidationError) Unwrap() []error {
+	errs := make([]error, len(e.Problems))
+	for i, p := range e.Problems {
+		errs[i] = p
+	}
+	return errs
+}
+
+// ValidateAnnounce checks that every media section of an announced
+// SDP can be handled. Audio and video sections must have a single
+// payload type with a supported codec and the parameters required
+// by the codec. Other sections, like metadata, are skipped and
+// returned as warnings together with unexpected parameter values
+// that were accepted. The error is a SDPValidationError.
+func ValidateAnnounce(sd *sdp.SessionDescription) ([]string, error) {
+	if len(sd.MediaDescriptions) == 0 {
+		return nil, SDPValidationError{
+			Problems: []SDPProblem{{Err: ErrSDPNoMedia}},
+		}
+	}
+
+	var warnings []string
+	var problems []SDPProblem
+	controls := make(map[string]int)
+	supported := 0
+
+	for i, md := range sd.MediaDescriptions {
+		index := i + 1
+
+		media := md.MediaName.Media
+		if media != "video" && media != "audio" {
+			warnings = append(warnings,
+				fmt.Sprintf("media %d: skipped %q section", index, media))
+			continue
+		}
+
+		for _, err := range validateControl(md, controls, index) {
+			problems = append(problems, SDPProblem{Media: index, Err: err})
+		}
+
+		mediaWarnings, err := validateMediaDescription(md)
+		for _, warning := range mediaWarnings {
+			warnings = append(warnings, fmt.Sprintf("media %d: %v", index, warning))
+		}
+		if err != nil {
+			problems = append(problems, SDPProblem{Media: index, Err: err})
+			continue
+		}
+		supported++
+	}
+
+	if len(problems) == 0 && supported == 0 {
+		problems = append(problems, SDPProblem{Err: ErrNoValidTracks})
+	}
+	if len(problems) != 0 {
+		return warnings, SDPValidationError{Problems: problems}
+	}
+	return warnings, nil
+}
+
+// validateControl the control attribute must be unique within the
+// section and among the sections. controls maps the control
+// attributes of the previous sections to their indices.
+func validateControl(md *psdp.MediaDescription, controls map[string]int, index int) []error {
+	var errs []error
+	count := 0
+	for _, attr := range md.Attributes {
+		if attr.Key != "control" {
+			continue
+		}
+		count++
+		if count == 2 {
+			errs = append(errs, ErrSDPControlDuplicate)
+		}
+		if prev, exist := controls[attr.Value]; exist && prev != index {
+			errs = append(errs, fmt.Errorf("%w: %q is also used by media %d",
+				ErrSDPControlDuplicate, attr.Value, prev))
+			continue
+		}
+		controls[attr.Value] = index
+	}
+	return errs
+}
+
+func validateMediaDescription(md *psdp.MediaDescription) ([]string, error) {
+	switch len(md.MediaName.Formats) {
+	case 0:
+		return nil, ErrTrackNoFormats
+	case 1:
+	default:
+		return nil, fmt.Errorf("%w (%v)", ErrSDPMultipleFormats, md.MediaName.Formats)
+	}
+
+	format := md.MediaName.Formats[0]
+	tmp, err := strconv.ParseUint(format, 10, 7)
+	if err != nil {
+		return nil, fmt.Errorf("%w (%v)", ErrSDPPayloadTypeInvalid, format)
+	}
+	payloadType := uint8(tmp)
+
+	if getRtpmapAttribute(md.Attributes, payloadType) == "" {
+		return nil, fmt.Errorf("%w for payload type %d", ErrSDPRtpmapMissing, payloadType)
+	}
+	codec, clock := getCodecAndClock(md.Attributes, payloadType)
+	if codec == "" {
+		return nil, fmt.Errorf("%w for payload type %d", ErrSDPRtpmapInvalid, payloadType)
+	}
+
+	media := md.MediaName.Media
+	switch {
+	case media == "video" && strings.EqualFold(codec, "h264"):
+		if _, err := parseH264ClockRate(clock); err != nil {
+			return nil, err
+		}
+		return nil, validateH264(md)
+
+	case media == "audio" && strings.EqualFold(codec, "mpeg4-generic"):
+		var t TrackMPEG4Audio
+		return t.fillParamsFromMediaDescription(md)
+
+	default:
+		return nil, fmt.Errorf("%w: %v %v/%v, %v",
+			ErrSDPCodecUnsupported, media, codec, clock, supportedCodecs)
+	}
+}
+
+// validateH264 the SPS and PPS must be provided by sprop-parameter-sets.
+func validateH264(md *psdp.MediaDescription) error {
+	var t TrackH264
+	err := t.fillParamsFromMediaDescription(md)
+	if err != nil && !errors.Is(err, ErrH264spropMissing) {
+		return err
+	}
+	if t.SPS == nil || t.PPS == nil {
+		return fmt.Errorf("%w, H264 requires SPS and PPS in the fmtp attribute", ErrH264spropMissing)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package gortsplib
+
+import (
+	"strings"
+	"testing"
+
+	"nvr/pkg/video/gortsplib/pkg/sdp"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testSDPHeader = "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=Stream\r\n" +
+		"c=IN IP4 0.0.0.0\r\n" +
+		"t=0 0\r\n"
+
+	testSDPVideo = "m=video 0 RTP/AVP 96\r\n" +
+		"a=rtpmap:96 H264/90000\r\n" +
+		"a=fmtp:96 packetization-mode=1; sprop-parameter-sets=Z2QAKKy0A8ARPyo=,aO4Bniw=\r\n" +
+		"a=control:trackID=0\r\n"
+
+	testSDPAudio = "m=audio 0 RTP/AVP 97\r\n" +
+		"a=rtpmap:97 mpeg4-generic/44100/2\r\n" +
+		"a=fmtp:97 profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=1210\r\n" +
+		"a=control:trackID=1\r\n"
+)
+
+func mustUnmarshalSDP(t *testing.T, s string) *sdp.SessionDescription {
+	t.Helper()
+	var sd sdp.SessionDescription
+	require.NoError(t, sd.Unmarshal([]byte(s)))
+	return &sd
+}
+
+func TestValidateAnnounce(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		sd := mustUnmarshalSDP(t, testSDPHeader+testSDPVideo+testSDPAudio)
+		warnings, err := ValidateAnnounce(sd)
+		require.NoError(t, err)
+		require.Empty(t, warnings)
+	})
+	t.Run("ignorableSection", func(t *testing.T) {
+		sd := mustUnmarshalSDP(t, testSDPHeader+testSDPVideo+
+			"m=application 0 RTP/AVP 107\r\n"+
+			"a=rtpmap:107 vnd.onvif.metadata/90000\r\n"+
+			"a=control:trackID=2\r\n")
+		warnings, err := ValidateAnnounce(sd)
+		require.NoError(t, err)
+		require.Equal(t, []string{`media 2: skipped "application" section`}, warnings)
+
+		// The section is ignored by the tracks.
+		var tracks Tracks
+		_, err = tracks.Unmarshal([]byte(testSDPHeader + testSDPVideo +
+			"m=application 0 RTP/AVP 107\r\n" +
+			"a=rtpmap:107 vnd.onvif.metadata/90000\r\n"))
+		require.NoError(t, err)
+		require.Len(t, tracks, 1)
+	})
+	t.Run("unexpectedProfileLevelID", func(t *testing.T) {
+		sd := mustUnmarshalSDP(t, testSDPHeader+testSDPVideo+
+			strings.ReplaceAll(testSDPAudio, "profile-level-id=1", "profile-level-id=15"))
+		warnings, err := ValidateAnnounce(sd)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			`media 2: unexpected AAC profile-level-id "15", expected "1"`,
+		}, warnings)
+	})
+
+	testCases := []struct {
+		name     string
+		sdp      string
+		expected []error
+		msg      string
+	}{
+		{
+			"noMedia",
+			testSDPHeader,
+			[]error{ErrSDPNoMedia},
+			"no media sections",
+		},
+		{
+			"onlyIgnorable",
+			testSDPHeader + "m=application 0 RTP/AVP 107\r\n",
+			[]error{ErrNoValidTracks},
+			"no valid tracks found",
+		},
+		{
+			"multipleFormats",
+			testSDPHeader + "m=video 0 RTP/AVP 96 97\r\n" +
+				"a=rtpmap:96 H264/90000\r\n",
+			[]error{ErrSDPMultipleFormats},
+			"media 1: multiple payload types in one media section are not supported ([96 97])",
+		},
+		{
+			"invalidPayloadType",
+			testSDPHeader + "m=video 0 RTP/AVP x\r\n",
+			[]error{ErrSDPPayloadTypeInvalid},
+			"media 1: invalid payload type (x)",
+		},
+		{
+			"payloadTypeOutOfRange",
+			testSDPHeader + "m=video 0 RTP/AVP 200\r\n",
+			[]error{ErrSDPPayloadTypeInvalid},
+			"media 1: invalid payload type (200)",
+		},
+		{
+			"missingRtpmap",
+			testSDPHeader + "m=video 0 RTP/AVP 96\r\n" +
+				"a=rtpmap:97 H264/90000\r\n",
+			[]error{ErrSDPRtpmapMissing},
+			"media 1: rtpmap attribute is missing for payload type 96",
+		},
+		{
+			"invalidRtpmap",
+			testSDPHeader + "m=video 0 RTP/AVP 96\r\n" +
+				"a=rtpmap:96 H264\r\n",
+			[]error{ErrSDPRtpmapInvalid},
+			"media 1: invalid rtpmap attribute for payload type 96",
+		},
+		{
+			"unsupportedCodec",
+			testSDPHeader + "m=video 0 RTP/AVP 96\r\n" +
+				"a=rtpmap:96 H265/90000\r\n",
+			[]error{ErrSDPCodecUnsupported},
+			"media 1: unsupported codec: video H265/90000, " + supportedCodecs,
+		},
+		{
+			"h264ClockRate",
+			testSDPHeader + "m=video 0 RTP/AVP 96\r\n" +
+				"a=rtpmap:96 H264/x\r\n",
+			[]error{ErrSDPClockRateInvalid},
+			`media 1: invalid clock rate: H264 "x"`,
+		},
+		{
+			"h264fmtpMissing",
+			testSDPHeader + "m=video 0 RTP/AVP 96\r\n" +
+				"a=rtpmap:96 H264/90000\r\n",
+			[]error{ErrH264fmtpMissing},
+			"media 1: fmtp attribute is missing",
+		},
+		{
+			"h264spropMissing",
+			testSDPHeader + "m=video 0 RTP/AVP 96\r\n" +
+				"a=rtpmap:96 H264/90000\r\n" +
+				"a=fmtp:96 packetization-mode=1\r\n",
+			[]error{ErrH264spropMissing},
+			"media 1: sprop-parameter-sets is missing, H264 requires SPS and PPS in the fmtp attribute",
+		},
+		{
+			"aacConfigMissing",
+			testSDPHeader + "m=audio 0 RTP/AVP 97\r\n" +
+				"a=rtpmap:97 mpeg4-generic/44100/2\r\n" +
+				"a=fmtp:97 sizelength=13\r\n",
+			[]error{ErrACCconfigMissing},
+			"media 1: config is missing (97 sizelength=13)",
+		},
+		{
+			"duplicateControlInSection",
+			testSDPHeader + testSDPVideo + "a=control:trackID=9\r\n",
+			[]error{ErrSDPControlDuplicate},
+			"media 1: duplicate control attribute",
+		},
+		{
+			"duplicateControlBetweenSections",
+			testSDPHeader + testSDPVideo + strings.ReplaceAll(testSDPAudio, "trackID=1", "trackID=0"),
+			[]error{ErrSDPControlDuplicate},
+			`media 2: duplicate control attribute: "trackID=0" is also used by media 1`,
+		},
+		{
+			"multipleProblems",
+			testSDPHeader + "m=video 0 RTP/AVP 96\r\n" +
+				"a=rtpmap:96 H265/90000\r\n" +
+				"m=audio 0 RTP/AVP 0\r\n",
+			[]error{ErrSDPCodecUnsupported, ErrSDPRtpmapMissing},
+			"media 1: unsupported codec: video H265/90000, " + supportedCodecs +
+				"; media 2: rtpmap attribute is missing for payload type 0",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ValidateAnnounce(mustUnmarshalSDP(t, tc.sdp))
+			var validationErr SDPValidationError
+			require.ErrorAs(t, err, &validationErr)
+			for _, e := range tc.expected {
+				require.ErrorIs(t, err, e)
+			}
+			require.EqualError(t, err, tc.msg)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package hls
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EncryptionConfig enables AES-128 encryption of the media segments.
+// Each segment is encrypted once when it's finalized. Partial segments
+// and the I-frame playlist would be served in cleartext, they are
+// disabled when encrypting.
+type EncryptionConfig struct {
+	// Duration after which a new key is generated, the
+	// current segment keeps its key. Zero never rotates.
+	KeyRotation time.Duration
+}
+
+// segmentKey is a AES-128 key referenced by the playlist as keyName(id).
+type segmentKey struct {
+	id      uint64
+	key     []byte
+	created time.Time
+}
+
+// keyRing holds the keys of the segments in the playlist.
+type keyRing struct {
+	rotation time.Duration
+	history  int
+	now      func() time.Time
+
+	mu      sync.Mutex
+	keys    map[uint64]*segmentKey
+	current *segmentKey
+	nextID  uint64
+}
+
+func newKeyRing(rotation time.Duration, history int) *keyRing {
+	return &keyRing{
+		rotation: rotation,
+		history:  history,
+		now:      time.Now,
+		keys:     make(map[uint64]*segmentKey),
+	}
+}
+
+// currentKey returns the key of the next segment. A new key
+// is generated if the current key is older than the rotation.
+func (k *keyRing) currentKey() (*segmentKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := k.now()
+	if k.current != nil &&
+		(k.rotation == 0 || now.Sub(k.current.created) < k.rotation) {
+		return k.current, nil
+	}
+
+	key := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	k.current = &segmentKey{id: k.nextID, key: key, created: now}
+	k.keys[k.nextID] = k.current
+	k.nextID++
+
+	// Every segment references at most one key, keys older
+	// than the segments in the playlist aren't needed.
+	if k.current.id >= uint64(k.history) {
+		delete(k.keys, k.current.id-uint64(k.history))
+	}
+	return k.current, nil
+}
+
+func (k *keyRing) key(id uint64) ([]byte, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	key, exist := k.keys[id]
+	if !exist {
+		return nil, false
+	}
+	return key.key, true
+}
+
+func keyName(id uint64) string {
+	return "key" + strconv.FormatUint(id, 10) + ".key"
+}
+
+func keyTag(id uint64) string {
+	return "#EXT-X-KEY:METHOD=AES-128,URI=\"" + keyName(id) + "\"\n"
+}
+
+// keyFile returns the key file. The key must not be stored by caches.
+func (k *keyRing) keyFile(name string) *MuxerFileResponse {
+	rawID := strings.TrimSuffix(strings.TrimPrefix(name, "key"), ".key")
+	id, err := strconv.ParseUint(rawID, 10, 64)
+	if err != nil {
+		return &MuxerFileResponse{Status: http.StatusNotFound}
+	}
+	key, exist := k.key(id)
+	if !exist {
+		return &MuxerFileResponse{Status: http.StatusNotFound}
+	}
+	return &MuxerFileResponse{
+		Status: http.StatusOK,
+		Header: map[string]string{
+			"Content-Type":  "application/octet-stream",
+			"Cache-Control": "no-store",
+		},
+		Body: bytes.NewReader(key),
+	}
+}
+
+// segmentIV returns the IV of a segment. The playlist doesn't specify
+// the IV, players use the media sequence number as a 128 bit integer.
+func segmentIV(msn uint64) []byte {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], msn)
+	return iv
+}
+
+// encryptSegment encrypts the segment with AES-128-CBC and PKCS7 padding.
+func encryptSegment(key []byte, msn uint64, plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(plain)%aes.BlockSize
+	buf := make([]byte, len(plain)+padding)
+	copy(buf, plain)
+	for i := len(plain); i < len(buf); i++ {
+		buf[i] = byte(padding)
+	}
+	cipher.NewCBCEncrypter(block, segmentIV(msn)).CryptBlocks(buf, buf)
+	return buf, nil
+}
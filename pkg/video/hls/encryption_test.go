@@ -0,0 +1,226 @@
+package hls
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+	"nvr/pkg/video/gortsplib"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRing(t *testing.T) {
+	now := time.Unix(1000, 0)
+	k := newKeyRing(time.Minute, 2)
+	k.now = func() time.Time { return now }
+
+	key0, err := k.currentKey()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), key0.id)
+	require.Len(t, key0.key, aes.BlockSize)
+
+	now = now.Add(59 * time.Second)
+	key, err := k.currentKey()
+	require.NoError(t, err)
+	require.Equal(t, key0, key)
+
+	now = now.Add(time.Second)
+	key1, err := k.currentKey()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), key1.id)
+	require.NotEqual(t, key0.key, key1.key)
+
+	now = now.Add(time.Minute)
+	key2, err := k.currentKey()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), key2.id)
+
+	// Only the newest keys are kept.
+	_, exist := k.key(0)
+	require.False(t, exist)
+	actual, exist := k.key(1)
+	require.True(t, exist)
+	require.Equal(t, key1.key, actual)
+
+	res := k.keyFile("key2.key")
+	require.Equal(t, http.StatusOK, res.Status)
+	require.Equal(t, "no-store", res.Header["Cache-Control"])
+	require.Equal(t, http.StatusNotFound, k.keyFile("key0.key").Status)
+	require.Equal(t, http.StatusNotFound, k.keyFile("keyx.key").Status)
+}
+
+func TestKeyRingNoRotation(t *testing.T) {
+	now := time.Unix(1000, 0)
+	k := newKeyRing(0, 2)
+	k.now = func() time.Time { return now }
+
+	key0, err := k.currentKey()
+	require.NoError(t, err)
+
+	now = now.Add(24 * time.Hour)
+	key, err := k.currentKey()
+	require.NoError(t, err)
+	require.Equal(t, key0, key)
+}
+
+func decryptSegment(t *testing.T, key []byte, msn uint64, encrypted []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	require.Zero(t, len(encrypted)%aes.BlockSize)
+
+	plain := make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(block, segmentIV(msn)).CryptBlocks(plain, encrypted)
+
+	padding := int(plain[len(plain)-1])
+	require.LessOrEqual(t, padding, aes.BlockSize)
+	return plain[:len(plain)-padding]
+}
+
+func TestMuxerEncryption(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := NewMuxer(
+		ctx,
+		0,
+		10,
+		time.Second,
+		200*time.Millisecond,
+		50*1000*1000,
+		true,
+		&EncryptionConfig{KeyRotation: time.Minute},
+		func(log.Level, string, ...interface{}) {},
+		&gortsplib.TrackH264{SPS: testSPS1, PPS: testPPS},
+		nil,
+		nil,
+	)
+	now := time.Unix(1000, 0)
+	m.keys.now = func() time.Time { return now }
+
+	// Keyframe every 10 frames, each GOP finalizes the previous segment.
+	pts := time.Duration(0)
+	writeGOP := func() {
+		for i := 0; i < 10; i++ {
+			au := [][]byte{{1, 2}} // Non-IDR.
+			if i == 0 {
+				au = [][]byte{testSPS1, testPPS, {5, 1}} // IDR.
+			}
+			require.NoError(t, m.WriteH264(time.Time{}, pts, au))
+			pts += 100 * time.Millisecond
+		}
+	}
+	writeGOP()
+	writeGOP() // seg7, key0.
+	now = now.Add(time.Minute)
+	writeGOP() // seg8, key1.
+	writeGOP() // seg9, key1.
+
+	// Each segment references the key it was encrypted with.
+	playlist := readFile(t, m, "stream.m3u8")
+	var keysAndSegments []string
+	for _, line := range strings.Split(playlist, "\n") {
+		if strings.HasPrefix(line, "#EXT-X-KEY") || strings.HasPrefix(line, "seg") {
+			keysAndSegments = append(keysAndSegments, line)
+		}
+	}
+	expected := []string{
+		`#EXT-X-KEY:METHOD=AES-128,URI="key0.key"`,
+		"seg7.mp4",
+		`#EXT-X-KEY:METHOD=AES-128,URI="key1.key"`,
+		"seg8.mp4",
+		"seg9.mp4",
+	}
+	require.Equal(t, expected, keysAndSegments)
+
+	// The init file isn't encrypted.
+	require.Less(t,
+		strings.Index(playlist, "#EXT-X-MAP"),
+		strings.Index(playlist, "#EXT-X-KEY"),
+	)
+
+	// Partial segments and byte ranges would be cleartext.
+	require.NotContains(t, playlist, "#EXT-X-PART")
+	require.NotContains(t, playlist, "#EXT-X-PRELOAD-HINT")
+	require.Equal(t, http.StatusNotFound, m.File("part0.mp4", "", "", "").Status)
+	require.Equal(t, http.StatusNotFound, m.File("iframes.m3u8", "", "", "").Status)
+	require.NotContains(t, readFile(t, m, "index.m3u8"), "I-FRAME")
+
+	// Decrypt the served segments with the fetched keys.
+	seg, err := m.NextSegment(nil)
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		msn uint64
+		key string
+	}{
+		{7, "key0.key"},
+		{8, "key1.key"},
+		{9, "key1.key"},
+	} {
+		require.Equal(t, tc.msn, seg.ID)
+		plain, err := io.ReadAll(&partsReader{parts: seg.Parts})
+		require.NoError(t, err)
+
+		key := readFile(t, m, tc.key)
+		encrypted := readFile(t, m, seg.name+".mp4")
+		require.NotEqual(t, plain, []byte(encrypted))
+
+		actual := decryptSegment(t, []byte(key), tc.msn, []byte(encrypted))
+		require.Equal(t, plain, actual, seg.name)
+
+		if tc.msn != 9 {
+			seg, err = m.NextSegment(seg)
+			require.NoError(t, err)
+		}
+	}
+}
+
+func TestMuxerEncryptionParamsChange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := NewMuxer(
+		ctx,
+		0,
+		10,
+		time.Second,
+		200*time.Millisecond,
+		50*1000*1000,
+		false,
+		&EncryptionConfig{},
+		func(log.Level, string, ...interface{}) {},
+		&gortsplib.TrackH264{SPS: testSPS1, PPS: testPPS},
+		nil,
+		nil,
+	)
+
+	pts := time.Duration(0)
+	writeGOP := func(sps []byte) {
+		for i := 0; i < 10; i++ {
+			au := [][]byte{{1, 2}} // Non-IDR.
+			if i == 0 {
+				au = [][]byte{sps, testPPS, {5, 1}} // IDR.
+			}
+			require.NoError(t, m.WriteH264(time.Time{}, pts, au))
+			pts += 100 * time.Millisecond
+		}
+	}
+	writeGOP(testSPS1)
+	writeGOP(testSPS1)
+	writeGOP(testSPS2)
+	writeGOP(testSPS2)
+
+	// The key is cleared before the new init file and set again.
+	playlist := readFile(t, m, "stream.m3u8")
+	require.Contains(t, playlist, "#EXT-X-KEY:METHOD=NONE\n"+
+		"#EXT-X-DISCONTINUITY\n"+
+		"#EXT-X-MAP:URI=\"init1.mp4\"\n"+
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"key0.key\"\n")
+}
@@ -3,6 +3,7 @@ package hls
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"nvr/pkg/video/gortsplib"
 	"nvr/pkg/video/gortsplib/pkg/h264"
 	"nvr/pkg/video/mp4"
@@ -213,7 +214,7 @@ func initGenerateVideoTrack(videoTrack *gortsplib.TrackH264) (*mp4.Boxes, error)
 				Box: &mp4.Mdia{},
 				Children: []mp4.Boxes{
 					{Box: &mp4.Mdhd{
-						Timescale: VideoTimescale, // the number of time units that pass per second.
+						Timescale: uint32(videoTrack.ClockRate()), // the number of time units that pass per second.
 						Language:  [3]byte{'u', 'n', 'd'},
 					}},
 					{Box: &mp4.Hdlr{
@@ -228,6 +229,16 @@ func initGenerateVideoTrack(videoTrack *gortsplib.TrackH264) (*mp4.Boxes, error)
 	return &trak, nil
 }
 
+// Mp4aSampleRate returns the sample rate in the 16.16 fixed-point format
+// of the mp4a box. Rates above 65535 don't fit and are written as zero,
+// like FFmpeg does, players use the rate from the esds box instead.
+func Mp4aSampleRate(sampleRate int) uint32 {
+	if sampleRate <= 0 || sampleRate > math.MaxUint16 {
+		return 0
+	}
+	return uint32(sampleRate) << 16
+}
+
 func initGenerateAudioTrack(audioTrack *gortsplib.TrackMPEG4Audio) (*mp4.Boxes, error) { //nolint:funlen
 	/*
 	   trak
@@ -289,7 +300,7 @@ func initGenerateAudioTrack(audioTrack *gortsplib.TrackMPEG4Audio) (*mp4.Boxes,
 									},
 									ChannelCount: uint16(audioTrack.Config.ChannelCount),
 									SampleSize:   16,
-									SampleRate:   uint32(audioTrack.ClockRate() * 65536),
+									SampleRate:   Mp4aSampleRate(audioTrack.ClockRate()),
 								},
 								Children: []mp4.Boxes{
 									{Box: &myEsds{
@@ -294,3 +294,13 @@ func TestGenerateInit(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, expected, actual)
 }
+
+func TestMp4aSampleRate(t *testing.T) {
+	require.Equal(t, uint32(0x2B110000), Mp4aSampleRate(11025))
+	require.Equal(t, uint32(0xAC440000), Mp4aSampleRate(44100))
+	require.Equal(t, uint32(0xFFFF0000), Mp4aSampleRate(65535))
+
+	// 96000 overflows the 16.16 fixed-point format.
+	require.Equal(t, uint32(0), Mp4aSampleRate(96000))
+	require.Equal(t, uint32(0), Mp4aSampleRate(0))
+}
@@ -0,0 +1,125 @@
+package hls
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http"
+	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/gortsplib/pkg/h264"
+	"strconv"
+	"strings"
+)
+
+// Rendition is a variant stream of a multivariant playlist.
+type Rendition struct {
+	// URI of the media playlist.
+	URI string
+
+	// URI of the I-frame playlist, empty if there is none.
+	IFrameURI string
+
+	// Bits per second.
+	Bandwidth int
+
+	// Zero if the SPS couldn't be parsed.
+	Width  int
+	Height int
+
+	Codecs      string
+	VideoCodecs string
+}
+
+// Used when neither the bitrate or resolution is known.
+const defaultBandwidth = 200000
+
+// Bits per second per pixel, used to estimate the
+// bandwidth from the resolution if the bitrate is unknown.
+const estimatedBitsPerPixel = 2
+
+// Rendition returns the rendition of the muxer. The playlist URIs are
+// relative to dir. If the bandwidth is zero, it's estimated from the
+// resolution in the SPS.
+func (m *Muxer) Rendition(dir string, bandwidth int) Rendition {
+	sps := m.videoTrack.SafeSPS()
+	codecs, videoCodecs := trackCodecs(sps, m.audioTrack)
+
+	r := Rendition{
+		URI:         dir + "stream.m3u8",
+		Bandwidth:   bandwidth,
+		Codecs:      codecs,
+		VideoCodecs: videoCodecs,
+	}
+	if m.iFrames {
+		r.IFrameURI = dir + "iframes.m3u8"
+	}
+
+	var spsp h264.SPS
+	if err := spsp.Unmarshal(sps); err == nil {
+		r.Width = spsp.Width()
+		r.Height = spsp.Height()
+	}
+
+	if r.Bandwidth <= 0 {
+		r.Bandwidth = r.Width * r.Height * estimatedBitsPerPixel
+	}
+	if r.Bandwidth <= 0 {
+		r.Bandwidth = defaultBandwidth
+	}
+	return r
+}
+
+// MultivariantPlaylist returns a playlist of the renditions, clients
+// can switch between them based on the available bandwidth.
+func MultivariantPlaylist(renditions []Rendition) *MuxerFileResponse {
+	cnt := "#EXTM3U\n" +
+		"#EXT-X-VERSION:9\n" +
+		"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		"\n"
+
+	for _, r := range renditions {
+		cnt += "#EXT-X-STREAM-INF:BANDWIDTH=" + strconv.Itoa(r.Bandwidth)
+		if r.Width != 0 && r.Height != 0 {
+			cnt += ",RESOLUTION=" + strconv.Itoa(r.Width) + "x" + strconv.Itoa(r.Height)
+		}
+		cnt += ",CODECS=\"" + r.Codecs + "\"\n" +
+			r.URI + "\n"
+	}
+
+	for _, r := range renditions {
+		if r.IFrameURI == "" {
+			continue
+		}
+		cnt += "#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=" + strconv.Itoa(r.Bandwidth)
+		if r.Width != 0 && r.Height != 0 {
+			cnt += ",RESOLUTION=" + strconv.Itoa(r.Width) + "x" + strconv.Itoa(r.Height)
+		}
+		cnt += ",CODECS=\"" + r.VideoCodecs + "\",URI=\"" + r.IFrameURI + "\"\n"
+	}
+
+	return &MuxerFileResponse{
+		Status: http.StatusOK,
+		Header: map[string]string{
+			"Content-Type": `audio/mpegURL`,
+		},
+		Body: bytes.NewReader([]byte(cnt)),
+	}
+}
+
+// trackCodecs returns the codecs of both tracks and the video track.
+func trackCodecs(sps []byte, audioTrack *gortsplib.TrackMPEG4Audio) (string, string) {
+	var codecs []string
+
+	if len(sps) >= 4 {
+		codecs = append(codecs, "avc1."+hex.EncodeToString(sps[1:4]))
+	}
+	videoCodecs := strings.Join(codecs, ",")
+
+	// https://developer.mozilla.org/en-US/docs/Web/Media/Formats/codecs_parameter
+	if audioTrack != nil {
+		codecs = append(
+			codecs,
+			"mp4a.40."+strconv.FormatInt(int64(audioTrack.Config.Type), 10),
+		)
+	}
+	return strings.Join(codecs, ","), videoCodecs
+}
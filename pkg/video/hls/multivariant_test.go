@@ -0,0 +1,128 @@
+package hls
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/gortsplib/pkg/mpeg4audio"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMuxer(
+	t *testing.T,
+	sps []byte,
+	iFrames bool,
+	audioTrack *gortsplib.TrackMPEG4Audio,
+) *Muxer {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return NewMuxer(
+		ctx,
+		0,
+		10,
+		10*time.Second,
+		200*time.Millisecond,
+		50*1000*1000,
+		iFrames,
+		nil,
+		func(log.Level, string, ...interface{}) {},
+		&gortsplib.TrackH264{SPS: sps, PPS: testPPS},
+		audioTrack,
+		nil,
+	)
+}
+
+func TestMuxerRendition(t *testing.T) {
+	audioTrack := &gortsplib.TrackMPEG4Audio{
+		Config: &mpeg4audio.Config{Type: mpeg4audio.ObjectTypeAACLC},
+	}
+	m := newTestMuxer(t, testSPS2, true, audioTrack)
+	require.Equal(t, Rendition{
+		URI:         "../a/stream.m3u8",
+		IFrameURI:   "../a/iframes.m3u8",
+		Bandwidth:   3000000,
+		Width:       1920,
+		Height:      1080,
+		Codecs:      "avc1.42c028,mp4a.40.2",
+		VideoCodecs: "avc1.42c028",
+	}, m.Rendition("../a/", 3000000))
+
+	// The bandwidth is estimated from the resolution.
+	m = newTestMuxer(t, testSPS1, false, nil)
+	require.Equal(t, Rendition{
+		URI:         "stream.m3u8",
+		Bandwidth:   352 * 288 * estimatedBitsPerPixel,
+		Width:       352,
+		Height:      288,
+		Codecs:      "avc1.64000c",
+		VideoCodecs: "avc1.64000c",
+	}, m.Rendition("", 0))
+
+	// Invalid SPS.
+	m = newTestMuxer(t, []byte{0x67, 1, 2, 3}, false, nil)
+	require.Equal(t, Rendition{
+		URI:         "stream.m3u8",
+		Bandwidth:   defaultBandwidth,
+		Codecs:      "avc1.010203",
+		VideoCodecs: "avc1.010203",
+	}, m.Rendition("", 0))
+}
+
+func TestMultivariantPlaylist(t *testing.T) {
+	readPlaylist := func(res *MuxerFileResponse) string {
+		require.Equal(t, http.StatusOK, res.Status)
+		require.Equal(t, "audio/mpegURL", res.Header["Content-Type"])
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+	t.Run("single", func(t *testing.T) {
+		res := MultivariantPlaylist([]Rendition{{
+			URI:       "stream.m3u8",
+			Bandwidth: 200000,
+			Codecs:    "avc1.64000c",
+		}})
+		// Identical to the primary playlist of the muxer.
+		m := newTestMuxer(t, testSPS1, false, nil)
+		require.Equal(t, readFile(t, m, "index.m3u8"), readPlaylist(res))
+	})
+	t.Run("sub", func(t *testing.T) {
+		res := MultivariantPlaylist([]Rendition{
+			{
+				URI:         "stream.m3u8",
+				IFrameURI:   "iframes.m3u8",
+				Bandwidth:   4000000,
+				Width:       1920,
+				Height:      1080,
+				Codecs:      "avc1.42c028,mp4a.40.2",
+				VideoCodecs: "avc1.42c028",
+			},
+			{
+				URI:         "../a_sub/stream.m3u8",
+				Bandwidth:   500000,
+				Width:       352,
+				Height:      288,
+				Codecs:      "avc1.64000c",
+				VideoCodecs: "avc1.64000c",
+			},
+		})
+		require.Equal(t, "#EXTM3U\n"+
+			"#EXT-X-VERSION:9\n"+
+			"#EXT-X-INDEPENDENT-SEGMENTS\n"+
+			"\n"+
+			"#EXT-X-STREAM-INF:BANDWIDTH=4000000,RESOLUTION=1920x1080,"+
+			"CODECS=\"avc1.42c028,mp4a.40.2\"\n"+
+			"stream.m3u8\n"+
+			"#EXT-X-STREAM-INF:BANDWIDTH=500000,RESOLUTION=352x288,CODECS=\"avc1.64000c\"\n"+
+			"../a_sub/stream.m3u8\n"+
+			"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=4000000,RESOLUTION=1920x1080,"+
+			"CODECS=\"avc1.42c028\",URI=\"iframes.m3u8\"\n",
+			readPlaylist(res))
+	})
+}
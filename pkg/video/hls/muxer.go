@@ -8,7 +8,10 @@ import (
 	"net/http"
 	"nvr/pkg/log"
 	"nvr/pkg/video/gortsplib"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,17 +29,34 @@ type Muxer struct {
 	logf       log.Func
 	videoTrack *gortsplib.TrackH264
 	audioTrack *gortsplib.TrackMPEG4Audio
+	iFrames    bool
+
+	// Segment keys, nil if encryption is disabled.
+	keys *keyRing
 
 	mutex        sync.Mutex
 	videoLastSPS []byte
 	videoLastPPS []byte
 	initContent  []byte
+
+	// Init files by parameters ID, see videoParams.
+	params map[uint64]*videoParams
+	inits  map[uint64][]byte
+
+	// Current parameters, nil before the first keyframe.
+	currentParams atomic.Pointer[videoParams]
 }
 
+// Number of parameter changes to keep the init files for, older
+// parameters can't be referenced by the playlist anymore.
+const maxParamsHistory = 8
+
 // ErrTrackInvalid invalid H264 track: SPS or PPS not provided into the SDP.
 var ErrTrackInvalid = errors.New("invalid H264 track: SPS or PPS not provided into the SDP")
 
-// NewMuxer allocates a Muxer.
+// NewMuxer allocates a Muxer. If iFrames is true, every keyframe
+// starts a new part and a I-frame only playlist is served. The
+// segments are encrypted if encryption isn't nil.
 func NewMuxer(
 	ctx context.Context,
 	id uint16,
@@ -44,17 +64,32 @@ func NewMuxer(
 	segmentDuration time.Duration,
 	partDuration time.Duration,
 	segmentMaxSize uint64,
+	iFrames bool,
+	encryption *EncryptionConfig,
 	logf log.Func,
 	videoTrack *gortsplib.TrackH264,
 	audioTrack *gortsplib.TrackMPEG4Audio,
+	onSegmentFinalized func(*Segment),
 ) *Muxer {
-	playlist := newPlaylist(ctx, id, segmentCount)
+	var keys *keyRing
+	if encryption != nil {
+		// The byte ranges of the I-frame playlist can't be decrypted.
+		iFrames = false
+		keys = newKeyRing(encryption.KeyRotation, segmentCount+1)
+	}
+
+	playlist := newPlaylist(ctx, id, segmentCount, encryption != nil)
 	go playlist.start()
 
 	m := &Muxer{
 		playlist:   playlist,
 		logf:       logf,
 		videoTrack: videoTrack,
+		audioTrack: audioTrack,
+		iFrames:    iFrames,
+		keys:       keys,
+		params:     make(map[uint64]*videoParams),
+		inits:      make(map[uint64][]byte),
 	}
 
 	m.segmenter = newSegmenter(
@@ -63,14 +98,52 @@ func NewMuxer(
 		segmentDuration,
 		partDuration,
 		segmentMaxSize,
+		iFrames,
 		videoTrack,
 		audioTrack,
-		m.playlist.onSegmentFinalized,
+		func(segment *Segment) {
+			if m.keys != nil {
+				m.encryptSegment(segment)
+			}
+			m.playlist.onSegmentFinalized(segment)
+			if onSegmentFinalized != nil {
+				onSegmentFinalized(segment)
+			}
+		},
 		m.playlist.partFinalized,
+		m.onParamsChanged,
 	)
 	return m
 }
 
+func (m *Muxer) onParamsChanged(params *videoParams) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if params.id != 0 {
+		m.logf(log.LevelInfo, "video parameters changed, new init: %v", initName(params.id))
+	}
+	m.params[params.id] = params
+	m.currentParams.Store(params)
+	if params.id >= maxParamsHistory {
+		delete(m.params, params.id-maxParamsHistory)
+		delete(m.inits, params.id-maxParamsHistory)
+	}
+}
+
+// encryptSegment encrypts the segment with the current key. The
+// playlist refuses to serve the segment if the encryption failed.
+func (m *Muxer) encryptSegment(segment *Segment) {
+	key, err := m.keys.currentKey()
+	if err != nil {
+		m.logf(log.LevelError, "generate segment key: %v", err)
+		return
+	}
+	if err := segment.encrypt(key); err != nil {
+		m.logf(log.LevelError, "encrypt segment: %v", err)
+	}
+}
+
 // OnSegmentFinalizedFunc is injected by core.
 type OnSegmentFinalizedFunc func([]SegmentOrGap)
 
@@ -92,40 +165,94 @@ func (m *Muxer) File(
 	skip string,
 ) *MuxerFileResponse {
 	if name == "index.m3u8" {
-		return primaryPlaylist(m.videoTrack, m.audioTrack)
+		return primaryPlaylist(m.videoTrack, m.audioTrack, m.iFrames)
+	}
+
+	if name == "iframes.m3u8" {
+		if !m.iFrames {
+			return &MuxerFileResponse{Status: http.StatusNotFound}
+		}
+		return m.playlist.iFramePlaylistReader()
 	}
 
 	if name == "init.mp4" {
 		m.mutex.Lock()
 		defer m.mutex.Unlock()
 
-		sps := m.videoTrack.SPS
+		sps := m.videoTrack.SafeSPS()
+		pps := m.videoTrack.SafePPS()
 
 		if m.initContent == nil ||
 			(!bytes.Equal(m.videoLastSPS, sps) ||
-				!bytes.Equal(m.videoLastPPS, m.videoTrack.PPS)) {
-			initContent, err := generateInit(m.videoTrack, m.audioTrack)
+				!bytes.Equal(m.videoLastPPS, pps)) {
+			track := newVideoParams(0, sps, pps).track(m.videoTrack)
+			initContent, err := generateInit(track, m.audioTrack)
 			if err != nil {
-				m.logf(log.LevelError, "generate init.mp4: %w", err)
+				m.logf(log.LevelError, "generate init.mp4: %v", err)
 				return &MuxerFileResponse{Status: http.StatusInternalServerError}
 			}
-			m.videoLastSPS = m.videoTrack.SPS
-			m.videoLastPPS = m.videoTrack.PPS
+			m.videoLastSPS = sps
+			m.videoLastPPS = pps
 			m.initContent = initContent
 		}
 
-		return &MuxerFileResponse{
-			Status: http.StatusOK,
-			Header: map[string]string{
-				"Content-Type": "video/mp4",
-			},
-			Body: bytes.NewReader(m.initContent),
+		return initResponse(m.initContent)
+	}
+
+	if strings.HasPrefix(name, "init") {
+		return m.paramsInit(name)
+	}
+
+	if strings.HasPrefix(name, "key") {
+		if m.keys == nil {
+			return &MuxerFileResponse{Status: http.StatusNotFound}
 		}
+		return m.keys.keyFile(name)
 	}
 
 	return m.playlist.file(name, msn, part, skip)
 }
 
+// paramsInit returns the init file for a specific parameters ID.
+func (m *Muxer) paramsInit(name string) *MuxerFileResponse {
+	rawID := strings.TrimSuffix(strings.TrimPrefix(name, "init"), ".mp4")
+	id, err := strconv.ParseUint(rawID, 10, 64)
+	if err != nil {
+		return &MuxerFileResponse{Status: http.StatusNotFound}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if initContent, exist := m.inits[id]; exist {
+		return initResponse(initContent)
+	}
+
+	params, exist := m.params[id]
+	if !exist {
+		return &MuxerFileResponse{Status: http.StatusNotFound}
+	}
+
+	initContent, err := generateInit(params.track(m.videoTrack), m.audioTrack)
+	if err != nil {
+		m.logf(log.LevelError, "generate %v: %v", name, err)
+		return &MuxerFileResponse{Status: http.StatusInternalServerError}
+	}
+	m.inits[id] = initContent
+
+	return initResponse(initContent)
+}
+
+func initResponse(initContent []byte) *MuxerFileResponse {
+	return &MuxerFileResponse{
+		Status: http.StatusOK,
+		Header: map[string]string{
+			"Content-Type": "video/mp4",
+		},
+		Body: bytes.NewReader(initContent),
+	}
+}
+
 // VideoTrack returns the stream video track.
 func (m *Muxer) VideoTrack() *gortsplib.TrackH264 {
 	return m.videoTrack
@@ -147,8 +274,65 @@ func (m *Muxer) NextSegment(maybePrevSeg *Segment) (*Segment, error) {
 	return m.playlist.nextSegment(maybePrevSeg)
 }
 
-// VideoTimescale the number of time units that pass per second.
-const VideoTimescale = 90000
+// MemoryUsage returns the number of bytes used
+// by the segments and parts that are kept in memory.
+func (m *Muxer) MemoryUsage() int64 {
+	return m.playlist.memoryUsage.Load()
+}
+
+// Shrink requests the muxer to free n bytes by dropping the
+// oldest segments, the newest segment is always kept. Never
+// blocks, the request is dropped if one is already pending.
+func (m *Muxer) Shrink(n int64) {
+	m.playlist.requestShrink(n)
+}
+
+// MuxerStatus is a snapshot of the state of the muxer.
+type MuxerStatus struct {
+	// Video samples are dropped until the first keyframe.
+	KeyframeReceived bool
+
+	// The parameter sets are known from the track or the stream.
+	SPSKnown bool
+	PPSKnown bool
+
+	// ID of the segment that is being written and the time it
+	// was created, zero before the first keyframe.
+	SegmentID    uint64
+	SegmentStart time.Time
+
+	// Bytes used by the segments and parts in memory.
+	MemoryUsage int64
+}
+
+// Status returns the state of the muxer without
+// blocking the writer, it's safe to call at any time.
+func (m *Muxer) Status() MuxerStatus {
+	var sps, pps []byte
+	if m.videoTrack != nil {
+		sps, pps = m.videoTrack.SafeSPS(), m.videoTrack.SafePPS()
+	}
+	if params := m.currentParams.Load(); params != nil {
+		if len(sps) == 0 {
+			sps = params.sps
+		}
+		if len(pps) == 0 {
+			pps = params.pps
+		}
+	}
+
+	status := MuxerStatus{
+		KeyframeReceived: m.segmenter.statusKeyframeReceived.Load(),
+		SPSKnown:         len(sps) != 0,
+		PPSKnown:         len(pps) != 0,
+		SegmentID:        m.segmenter.statusSegmentID.Load(),
+		MemoryUsage:      m.MemoryUsage(),
+	}
+	if start := m.segmenter.statusSegmentStart.Load(); start != 0 {
+		status.SegmentStart = time.Unix(0, start)
+	}
+	return status
+}
 
 // Sample .
 type Sample interface {
@@ -0,0 +1,140 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+	"nvr/pkg/video/gortsplib"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	// 352x288.
+	testSPS1 = []byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+		0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+		0x00, 0x03, 0x00, 0x3d, 0x08,
+	}
+	// 1920x1080.
+	testSPS2 = []byte{
+		0x67, 0x42, 0xc0, 0x28, 0xd9, 0x00, 0x78, 0x02,
+		0x27, 0xe5, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04,
+		0x00, 0x00, 0x03, 0x00, 0xf0, 0x3c, 0x60, 0xc9, 0x20,
+	}
+	testPPS = []byte{0x68, 0xee, 0x3c, 0x80}
+)
+
+// initDimensions returns the width and height of the avc1 box.
+func initDimensions(t *testing.T, init []byte) (int, int) {
+	i := bytes.Index(init, []byte("avc1"))
+	require.NotEqual(t, -1, i)
+	// Reserved(6) DataReferenceIndex(2) PreDefined(2) Reserved(2) PreDefined(12).
+	i += 4 + 24
+	return int(binary.BigEndian.Uint16(init[i:])), int(binary.BigEndian.Uint16(init[i+2:]))
+}
+
+func readFile(t *testing.T, m *Muxer, name string) string {
+	res := m.File(name, "", "", "")
+	require.Equal(t, http.StatusOK, res.Status, name)
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestMuxerParamsChange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	videoTrack := &gortsplib.TrackH264{SPS: testSPS1, PPS: testPPS}
+	m := NewMuxer(
+		ctx,
+		0,
+		10,
+		10*time.Second,
+		200*time.Millisecond,
+		50*1000*1000,
+		false,
+		nil,
+		func(log.Level, string, ...interface{}) {},
+		videoTrack,
+		nil,
+		nil,
+	)
+
+	// Keyframe every 10 frames. The camera resends the parameter
+	// sets every GOP and changes the resolution after 3 GOPs.
+	pts := time.Duration(0)
+	writeGOP := func(sps []byte) {
+		for i := 0; i < 10; i++ {
+			au := [][]byte{{1, 2}} // Non-IDR.
+			if i == 0 {
+				au = [][]byte{sps, testPPS, {5, 1}} // IDR.
+			}
+			require.NoError(t, m.WriteH264(time.Time{}, pts, au))
+			pts += 100 * time.Millisecond
+		}
+	}
+	for i := 0; i < 3; i++ {
+		writeGOP(testSPS1)
+	}
+	for i := 0; i < 3; i++ {
+		writeGOP(testSPS2)
+	}
+
+	playlist := readFile(t, m, "stream.m3u8")
+	require.Contains(t, playlist, "#EXT-X-MAP:URI=\"init0.mp4\"\n")
+	require.Contains(t, playlist, "#EXT-X-DISCONTINUITY\n#EXT-X-MAP:URI=\"init1.mp4\"\n")
+	require.Equal(t, 1, bytes.Count([]byte(playlist), []byte("#EXT-X-DISCONTINUITY\n")),
+		"repeated parameter sets must not create a discontinuity")
+
+	width, height := initDimensions(t, []byte(readFile(t, m, "init0.mp4")))
+	require.Equal(t, 352, width)
+	require.Equal(t, 288, height)
+
+	width, height = initDimensions(t, []byte(readFile(t, m, "init1.mp4")))
+	require.Equal(t, 1920, width)
+	require.Equal(t, 1080, height)
+
+	require.Equal(t, http.StatusNotFound, m.File("init2.mp4", "", "", "").Status)
+	require.Equal(t, http.StatusNotFound, m.File("initx.mp4", "", "", "").Status)
+
+	// The segment is cut short by the change, the parts of
+	// the next segment are muxed with the new parameters.
+	seg, err := m.NextSegment(nil)
+	require.NoError(t, err)
+	require.Equal(t, testSPS1, seg.VideoSPS)
+	require.Equal(t, testPPS, seg.VideoPPS)
+	require.Less(t, seg.RenderedDuration, 4*time.Second)
+
+	require.Equal(t, testSPS2, m.segmenter.currentSegment.VideoSPS)
+}
+
+func TestSegmenterParamsChanged(t *testing.T) {
+	s := &segmenter{
+		videoTrack:  &gortsplib.TrackH264{SPS: testSPS1, PPS: testPPS},
+		videoParams: newVideoParams(0, testSPS1, testPPS),
+	}
+
+	testCases := map[string]struct {
+		au       [][]byte
+		expected bool
+	}{
+		"noParams":  {[][]byte{{5}}, false},
+		"repeat":    {[][]byte{testSPS1, testPPS, {5}}, false},
+		"spsOnly":   {[][]byte{testSPS2, {5}}, true},
+		"ppsOnly":   {[][]byte{{0x68, 1}, {5}}, true},
+		"newParams": {[][]byte{testSPS2, testPPS, {5}}, true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, s.paramsChanged(tc.au))
+		})
+	}
+}
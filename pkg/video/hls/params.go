@@ -0,0 +1,67 @@
+package hls
+
+import (
+	"hash/fnv"
+	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/gortsplib/pkg/h264"
+	"strconv"
+)
+
+// videoParams the H264 parameter sets that a segment was muxed with.
+// Cameras may send new parameter sets in-band, when switching between
+// day and night mode for example, each change increments the ID.
+type videoParams struct {
+	id   uint64
+	sps  []byte
+	pps  []byte
+	hash uint64
+}
+
+func newVideoParams(id uint64, sps []byte, pps []byte) *videoParams {
+	return &videoParams{
+		id:   id,
+		sps:  sps,
+		pps:  pps,
+		hash: paramsHash(sps, pps),
+	}
+}
+
+// paramsHash cameras resend the parameter sets every GOP,
+// comparing a hash makes it cheap to ignore the repeats.
+func paramsHash(sps []byte, pps []byte) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte{byte(len(sps) >> 8), byte(len(sps))})
+	h.Write(sps)
+	h.Write(pps)
+	return h.Sum64()
+}
+
+// extractParams returns the SPS and PPS in the access unit, if any.
+func extractParams(au [][]byte) ([]byte, []byte) {
+	var sps []byte
+	var pps []byte
+	for _, nalu := range au {
+		switch h264.NALUType(nalu[0] & 0x1F) {
+		case h264.NALUTypeSPS:
+			sps = nalu
+		case h264.NALUTypePPS:
+			pps = nalu
+		}
+	}
+	return sps, pps
+}
+
+// track returns a copy of the video track with these parameters.
+func (p *videoParams) track(videoTrack *gortsplib.TrackH264) *gortsplib.TrackH264 {
+	return &gortsplib.TrackH264{
+		PayloadType:       videoTrack.PayloadType,
+		SPS:               p.sps,
+		PPS:               p.pps,
+		PacketizationMode: videoTrack.PacketizationMode,
+		RTPClockRate:      videoTrack.RTPClockRate,
+	}
+}
+
+func initName(paramsID uint64) string {
+	return "init" + strconv.FormatUint(paramsID, 10) + ".mp4"
+}
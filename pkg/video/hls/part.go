@@ -43,15 +43,29 @@ func (b *myMdat) Marshal(w *bitio.Writer) error {
 }
 
 // NanoToTimescale converts value in nanoseconds into a different timescale.
+// The result is rounded to the nearest unit, a AAC frame at 11025Hz isn't a
+// whole number of nanoseconds and would otherwise be one unit too short.
 func NanoToTimescale(value int64, timescale int64) int64 {
 	secs := value / int64(time.Second)
 	dec := value % int64(time.Second)
-	return secs*timescale + dec*timescale/int64(time.Second)
+	half := int64(time.Second) / 2
+	if dec < 0 {
+		half = -half
+	}
+	return secs*timescale + (dec*timescale+half)/int64(time.Second)
+}
+
+// timescaleDuration converts the duration of a sample into the timescale.
+// The duration is the difference between the converted end and start
+// times, the rounding errors of consecutive samples cancel out.
+func timescaleDuration(start int64, duration int64, timescale int64) int64 {
+	return NanoToTimescale(start+duration, timescale) - NanoToTimescale(start, timescale)
 }
 
 func generateVideoTraf( //nolint:funlen
 	muxerStartTime int64,
 	trackID int,
+	videoClockRate int,
 	videoSamples []*VideoSample,
 	dataOffset int32,
 ) mp4.Boxes {
@@ -76,7 +90,7 @@ func generateVideoTraf( //nolint:funlen
 		// sum of decode durations of all earlier samples
 		BaseMediaDecodeTimeV1: uint64(
 			NanoToTimescale(
-				videoSamples[0].DTS-muxerStartTime, VideoTimescale)),
+				videoSamples[0].DTS-muxerStartTime, int64(videoClockRate))),
 	}
 
 	flags := 0
@@ -102,11 +116,11 @@ func generateVideoTraf( //nolint:funlen
 			flags |= 1 << 16 // sample_is_non_sync_sample
 		}
 		trun.Entries[i] = mp4.TrunEntry{
-			SampleDuration: uint32(
-				NanoToTimescale(int64(e.Duration), VideoTimescale)),
+			SampleDuration: uint32(timescaleDuration(
+				e.DTS-muxerStartTime, int64(e.Duration), int64(videoClockRate))),
 			SampleSize:                    uint32(len(e.AVCC)),
 			SampleFlags:                   flags,
-			SampleCompositionTimeOffsetV1: int32(NanoToTimescale(off, VideoTimescale)),
+			SampleCompositionTimeOffsetV1: int32(NanoToTimescale(off, int64(videoClockRate))),
 		}
 	}
 
@@ -167,8 +181,8 @@ func generateAudioTraf(
 	trun.Entries = make([]mp4.TrunEntry, len(audioSamples))
 	for i, e := range audioSamples {
 		trun.Entries[i] = mp4.TrunEntry{
-			SampleDuration: uint32(
-				NanoToTimescale(int64(e.Duration()), int64(audioClockRate))),
+			SampleDuration: uint32(timescaleDuration(
+				e.PTS-muxerStartTime, int64(e.Duration()), int64(audioClockRate))),
 			SampleSize: uint32(len(e.AU)),
 		}
 	}
@@ -185,6 +199,7 @@ func generateAudioTraf(
 
 func generatePart( //nolint:funlen
 	muxerStartTime int64,
+	videoTrack *gortsplib.TrackH264,
 	audioTrack *gortsplib.TrackMPEG4Audio,
 	videoSamples []*VideoSample,
 	audioSamples []*AudioSample,
@@ -229,6 +244,7 @@ func generatePart( //nolint:funlen
 	traf := generateVideoTraf(
 		muxerStartTime,
 		trackID,
+		videoTrack.ClockRate(),
 		videoSamples,
 		videoDataOffset)
 	moof.Children = append(moof.Children, traf)
@@ -285,9 +301,11 @@ func partName(id uint64) string {
 
 // MuxerPart fmp4 part.
 type MuxerPart struct {
+	videoTrack     *gortsplib.TrackH264
 	audioTrack     *gortsplib.TrackMPEG4Audio
 	muxerStartTime int64
 	id             uint64
+	params         *videoParams
 
 	isIndependent    bool
 	VideoSamples     []*VideoSample
@@ -297,14 +315,18 @@ type MuxerPart struct {
 }
 
 func newPart(
+	videoTrack *gortsplib.TrackH264,
 	audioTrack *gortsplib.TrackMPEG4Audio,
 	muxerStartTime int64,
 	id uint64,
+	params *videoParams,
 ) *MuxerPart {
 	return &MuxerPart{
+		videoTrack:     videoTrack,
 		audioTrack:     audioTrack,
 		muxerStartTime: muxerStartTime,
 		id:             id,
+		params:         params,
 	}
 }
 
@@ -316,6 +338,19 @@ func (p *MuxerPart) reader() io.Reader {
 	return bytes.NewReader(p.renderedContent)
 }
 
+// memorySize returns the approximate number of
+// bytes used by the samples and the rendered content.
+func (p *MuxerPart) memorySize() int64 {
+	size := int64(len(p.renderedContent))
+	for _, sample := range p.VideoSamples {
+		size += int64(len(sample.AVCC))
+	}
+	for _, sample := range p.AudioSamples {
+		size += int64(len(sample.AU))
+	}
+	return size
+}
+
 func (p *MuxerPart) duration() time.Duration {
 	total := time.Duration(0)
 	for _, e := range p.VideoSamples {
@@ -329,6 +364,7 @@ func (p *MuxerPart) finalize() error {
 		var err error
 		p.renderedContent, err = generatePart(
 			p.muxerStartTime,
+			p.videoTrack,
 			p.audioTrack,
 			p.VideoSamples,
 			p.AudioSamples)
@@ -7,6 +7,7 @@ import (
 
 	"nvr/pkg/video/gortsplib"
 	"nvr/pkg/video/gortsplib/pkg/mpeg4audio"
+	"nvr/pkg/video/mp4"
 
 	"github.com/stretchr/testify/require"
 )
@@ -15,6 +16,7 @@ func TestGeneratePart(t *testing.T) {
 	t.Run("minimal", func(t *testing.T) {
 		actual, err := generatePart(
 			0,
+			&gortsplib.TrackH264{},
 			&gortsplib.TrackMPEG4Audio{},
 			[]*VideoSample{{
 				PTS:  0,
@@ -51,6 +53,7 @@ func TestGeneratePart(t *testing.T) {
 	t.Run("videoSample", func(t *testing.T) {
 		actual, err := generatePart(
 			0,
+			&gortsplib.TrackH264{},
 			&gortsplib.TrackMPEG4Audio{},
 			[]*VideoSample{{
 				PTS:  0,
@@ -88,6 +91,7 @@ func TestGeneratePart(t *testing.T) {
 	t.Run("audioSample", func(t *testing.T) {
 		actual, err := generatePart(
 			0,
+			&gortsplib.TrackH264{},
 			&gortsplib.TrackMPEG4Audio{Config: &mpeg4audio.Config{}},
 			[]*VideoSample{{
 				PTS:  0,
@@ -141,6 +145,7 @@ func TestGeneratePart(t *testing.T) {
 	t.Run("videoAndAudioSample", func(t *testing.T) {
 		actual, err := generatePart(
 			0,
+			&gortsplib.TrackH264{},
 			&gortsplib.TrackMPEG4Audio{Config: &mpeg4audio.Config{}},
 			[]*VideoSample{{
 				PTS:  0,
@@ -195,6 +200,7 @@ func TestGeneratePart(t *testing.T) {
 	t.Run("multipleVideoSample", func(t *testing.T) {
 		actual, err := generatePart(
 			0,
+			&gortsplib.TrackH264{},
 			&gortsplib.TrackMPEG4Audio{},
 			[]*VideoSample{
 				{
@@ -268,6 +274,7 @@ func TestGeneratePart(t *testing.T) {
 
 		actual, err := generatePart(
 			muxerStartTime,
+			&gortsplib.TrackH264{},
 			&gortsplib.TrackMPEG4Audio{
 				Config: &mpeg4audio.Config{ChannelCount: 1, SampleRate: 44100},
 			},
@@ -298,7 +305,7 @@ func TestGeneratePart(t *testing.T) {
 			1, 0, 0xf, 1, // FullBox.
 			0, 0, 0, 2, // Sample count.
 			0, 0, 0, 0xc8, // Data offset.
-			0, 0, 0x2e, 0xdf, // Entry1 sample duration.
+			0, 0, 0x2e, 0xe0, // Entry1 sample duration.
 			0, 0, 0, 4, // Entry1 sample size.
 			0, 0, 0, 0, // Entry1 sample flags.
 			0xff, 0xff, 0xe8, 0x90, // 1 Entry SampleCompositionTimeOffset
@@ -334,42 +341,42 @@ func TestDurationGoToMp4(t *testing.T) {
 	}{
 		{
 			input:    100000,
-			scale:    VideoTimescale,
+			scale:    90000,
 			expected: 9,
 		},
 		{
 			input:    100000000,
-			scale:    VideoTimescale,
+			scale:    90000,
 			expected: 9000,
 		},
 		{
 			input:    100000000000,
-			scale:    VideoTimescale,
+			scale:    90000,
 			expected: 9000000,
 		},
 		{
 			input:    100000000000000, // 3 days.
-			scale:    VideoTimescale,
+			scale:    90000,
 			expected: 9000000000,
 		},
 		{
 			input:    1000000000000000, // 30 days.
-			scale:    VideoTimescale,
+			scale:    90000,
 			expected: 90000000000,
 		},
 		{
 			input:    10000000000000000, // 300 days.
-			scale:    VideoTimescale,
+			scale:    90000,
 			expected: 900000000000,
 		},
 		{
 			input:    100000000000000000, // 3000 days.
-			scale:    VideoTimescale,
+			scale:    90000,
 			expected: 9000000000000,
 		},
 		{
 			input:    1000000000000000000, // 30000 days.
-			scale:    VideoTimescale,
+			scale:    90000,
 			expected: 90000000000000,
 		},
 	}
@@ -379,3 +386,82 @@ func TestDurationGoToMp4(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerateTrafClockRates(t *testing.T) {
+	muxerStartTime := int64(1000000000)
+
+	// The samples are split across two parts, like the parts of a segment.
+	trafs := func(samples int, generate func(start, end int) mp4.Boxes) (uint64, uint64, []uint32) {
+		traf1 := generate(0, samples/2)
+		traf2 := generate(samples/2, samples)
+
+		var durations []uint32
+		for _, traf := range []mp4.Boxes{traf1, traf2} {
+			for _, e := range traf.Children[2].Box.(*mp4.Trun).Entries {
+				durations = append(durations, e.SampleDuration)
+			}
+		}
+		return traf1.Children[1].Box.(*mp4.Tfdt).BaseMediaDecodeTimeV1,
+			traf2.Children[1].Box.(*mp4.Tfdt).BaseMediaDecodeTimeV1,
+			durations
+	}
+	sum := func(durations []uint32) uint64 {
+		var total uint64
+		for _, d := range durations {
+			total += uint64(d)
+		}
+		return total
+	}
+
+	t.Run("video1000Hz", func(t *testing.T) {
+		// 30 fps for 10 seconds with RTP timestamps from a 1000Hz clock.
+		const clockRate = 1000
+		dts := func(i int) int64 {
+			ticks := int64(i) * clockRate / 30
+			return muxerStartTime + ticks*int64(time.Second)/clockRate
+		}
+		samples := make([]*VideoSample, 300)
+		for i := range samples {
+			samples[i] = &VideoSample{
+				PTS:      dts(i),
+				DTS:      dts(i),
+				Duration: time.Duration(dts(i+1) - dts(i)),
+			}
+		}
+
+		tfdt1, tfdt2, durations := trafs(len(samples), func(start, end int) mp4.Boxes {
+			return generateVideoTraf(muxerStartTime, 1, clockRate, samples[start:end], 0)
+		})
+		require.Equal(t, uint64(0), tfdt1)
+		require.Equal(t, sum(durations[:150]), tfdt2)
+
+		// 10 seconds of wall-clock time.
+		require.Equal(t, uint64(10*clockRate), sum(durations))
+	})
+	t.Run("audio11025Hz", func(t *testing.T) {
+		// AAC frames aren't a whole number of nanoseconds at 11025Hz.
+		const clockRate = 11025
+		pts := func(i int) int64 {
+			return muxerStartTime + int64(i)*
+				int64(mpeg4audio.SamplesPerAccessUnit*time.Second/clockRate)
+		}
+		samples := make([]*AudioSample, 1000)
+		for i := range samples {
+			samples[i] = &AudioSample{PTS: pts(i), NextPTS: pts(i + 1)}
+		}
+
+		tfdt1, tfdt2, durations := trafs(len(samples), func(start, end int) mp4.Boxes {
+			return generateAudioTraf(muxerStartTime, 2, clockRate, samples[start:end], 0)
+		})
+		require.Equal(t, uint64(0), tfdt1)
+		require.Equal(t, sum(durations[:500]), tfdt2)
+
+		// Every frame is played at the original pitch.
+		for _, d := range durations {
+			require.Equal(t, uint32(mpeg4audio.SamplesPerAccessUnit), d)
+		}
+		wallClock := time.Duration(pts(len(samples)) - muxerStartTime)
+		playback := time.Duration(sum(durations)) * time.Second / clockRate
+		require.InDelta(t, wallClock, playback, float64(time.Second/clockRate))
+	})
+}
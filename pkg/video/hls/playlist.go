@@ -3,13 +3,13 @@ package hls
 import (
 	"bytes"
 	"context"
-	"encoding/hex"
 	"io"
 	"math"
 	"net/http"
 	"nvr/pkg/video/gortsplib"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -75,9 +75,18 @@ type playlist struct {
 
 	segmentCount int
 
+	// Segments are encrypted, partial segments aren't
+	// served since they would be in cleartext.
+	encrypted bool
+
+	// Bytes used by the segments and parts in the playlist.
+	memoryUsage atomic.Int64
+
 	segments           []SegmentOrGap
 	segmentsByName     map[string]*Segment
 	segmentDeleteCount int
+	iFrameDeleteCount  int
+	discontinuityCount int
 	partsByName        map[string]*MuxerPart
 	nextSegmentID      uint64
 	nextSegmentParts   []*MuxerPart
@@ -89,6 +98,7 @@ type playlist struct {
 	nextSegmentsOnHold map[nextSegmentRequest2]struct{}
 
 	chPlaylist         chan playlistRequest
+	chIFramePlaylist   chan chan *MuxerFileResponse
 	chSegment          chan segmentRequest
 	chSegmentFinalized chan segmentFinalizedRequest
 	chPartFinalized    chan partFinalizedRequest
@@ -96,13 +106,20 @@ type playlist struct {
 	chBlockingPart     chan blockingPartRequest
 	chWaitForSegFinal  chan chan struct{}
 	chNextSegment      chan nextSegmentRequest
+	chShrink           chan int64
 }
 
-func newPlaylist(ctx context.Context, muxerID uint16, segmentCount int) *playlist {
+func newPlaylist(
+	ctx context.Context,
+	muxerID uint16,
+	segmentCount int,
+	encrypted bool,
+) *playlist {
 	return &playlist{
 		ctx:            ctx,
 		muxerID:        muxerID,
 		segmentCount:   segmentCount,
+		encrypted:      encrypted,
 		segmentsByName: make(map[string]*Segment),
 		partsByName:    make(map[string]*MuxerPart),
 
@@ -112,6 +129,7 @@ func newPlaylist(ctx context.Context, muxerID uint16, segmentCount int) *playlis
 		nextSegmentsOnHold: make(map[nextSegmentRequest2]struct{}),
 
 		chPlaylist:         make(chan playlistRequest),
+		chIFramePlaylist:   make(chan chan *MuxerFileResponse),
 		chSegment:          make(chan segmentRequest),
 		chSegmentFinalized: make(chan segmentFinalizedRequest),
 		chPartFinalized:    make(chan partFinalizedRequest),
@@ -119,6 +137,7 @@ func newPlaylist(ctx context.Context, muxerID uint16, segmentCount int) *playlis
 		chBlockingPart:     make(chan blockingPartRequest),
 		chWaitForSegFinal:  make(chan chan struct{}),
 		chNextSegment:      make(chan nextSegmentRequest),
+		chShrink:           make(chan int64, 1),
 	}
 }
 
@@ -144,12 +163,30 @@ func (p *playlist) start() { //nolint:funlen,gocognit
 				Body: bytes.NewReader(p.fullPlaylist(req.isDeltaUpdate)),
 			}
 
+		case res := <-p.chIFramePlaylist:
+			if !p.hasContent() {
+				res <- &MuxerFileResponse{Status: http.StatusNotFound}
+				continue
+			}
+			res <- &MuxerFileResponse{
+				Status: http.StatusOK,
+				Header: map[string]string{
+					"Content-Type": `audio/mpegURL`,
+				},
+				Body: bytes.NewReader(p.iFramePlaylist()),
+			}
+
 		case req := <-p.chSegment:
 			segment, exist := p.segmentsByName[req.name]
 			if !exist {
 				req.res <- &MuxerFileResponse{Status: http.StatusNotFound}
 				continue
 			}
+			if p.encrypted && segment.encrypted == nil {
+				// Encryption failed.
+				req.res <- &MuxerFileResponse{Status: http.StatusInternalServerError}
+				continue
+			}
 			req.res <- &MuxerFileResponse{
 				Status: http.StatusOK,
 				Header: map[string]string{
@@ -166,6 +203,7 @@ func (p *playlist) start() { //nolint:funlen,gocognit
 			part := req.part
 			p.partsByName[part.name()] = part
 			p.nextSegmentParts = append(p.nextSegmentParts, part)
+			p.memoryUsage.Add(part.memorySize())
 			p.nextPartID = part.id + 1
 
 			p.checkPending()
@@ -252,6 +290,9 @@ func (p *playlist) start() { //nolint:funlen,gocognit
 				}
 				p.nextSegmentsOnHold[req] = struct{}{}
 			}
+
+		case n := <-p.chShrink:
+			p.shrink(n)
 		}
 	}
 }
@@ -338,7 +379,8 @@ func (p *playlist) hasPart(segmentID uint64, partID uint64) bool {
 		return true
 	}
 
-	if segmentID != p.nextSegmentID {
+	// Blocking requests wait for complete segments.
+	if p.encrypted || segmentID != p.nextSegmentID {
 		return false
 	}
 
@@ -436,6 +478,7 @@ func (p *playlist) playlistReader(msn, part, skip string) *MuxerFileResponse {
 func primaryPlaylist(
 	videoTrack *gortsplib.TrackH264,
 	audioTrack *gortsplib.TrackMPEG4Audio,
+	iFrames bool,
 ) *MuxerFileResponse {
 	return &MuxerFileResponse{
 		Status: http.StatusOK,
@@ -443,27 +486,21 @@ func primaryPlaylist(
 			"Content-Type": `audio/mpegURL`,
 		},
 		Body: func() io.Reader {
-			var codecs []string
-
-			sps := videoTrack.SPS
-			if len(sps) >= 4 {
-				codecs = append(codecs, "avc1."+hex.EncodeToString(sps[1:4]))
-			}
-
-			// https://developer.mozilla.org/en-US/docs/Web/Media/Formats/codecs_parameter
-			if audioTrack != nil {
-				codecs = append(
-					codecs,
-					"mp4a.40."+strconv.FormatInt(int64(audioTrack.Config.Type), 10),
-				)
-			}
+			codecs, videoCodecs := trackCodecs(videoTrack.SPS, audioTrack)
 
-			return bytes.NewReader([]byte("#EXTM3U\n" +
+			cnt := "#EXTM3U\n" +
 				"#EXT-X-VERSION:9\n" +
 				"#EXT-X-INDEPENDENT-SEGMENTS\n" +
 				"\n" +
-				"#EXT-X-STREAM-INF:BANDWIDTH=200000,CODECS=\"" + strings.Join(codecs, ",") + "\"\n" +
-				"stream.m3u8\n"))
+				"#EXT-X-STREAM-INF:BANDWIDTH=200000,CODECS=\"" + codecs + "\"\n" +
+				"stream.m3u8\n"
+
+			if iFrames {
+				cnt += "#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=200000,CODECS=\"" +
+					videoCodecs + "\",URI=\"iframes.m3u8\"\n"
+			}
+
+			return bytes.NewReader([]byte(cnt))
 		}(),
 	}
 }
@@ -489,7 +526,9 @@ func (p *playlist) fullPlaylist(isDeltaUpdate bool) []byte { //nolint:funlen
 	// they should seek when playing in Low-Latency Mode.  Its value MUST
 	// be at least twice the Part Target Duration.  Its value SHOULD be
 	// at least three times the Part Target Duration.
-	cnt += ",PART-HOLD-BACK=" + strconv.FormatFloat((partTargetDuration).Seconds()*2.5, 'f', 5, 64)
+	if !p.encrypted {
+		cnt += ",PART-HOLD-BACK=" + strconv.FormatFloat((partTargetDuration).Seconds()*2.5, 'f', 5, 64)
+	}
 
 	// Indicates that the Server can produce Playlist Delta Updates in
 	// response to the _HLS_skip Delivery Directive.  Its value is the
@@ -499,13 +538,22 @@ func (p *playlist) fullPlaylist(isDeltaUpdate bool) []byte { //nolint:funlen
 
 	cnt += "\n"
 
-	cnt += "#EXT-X-PART-INF:PART-TARGET=" + strconv.FormatFloat(partTargetDuration.Seconds(), 'f', -1, 64) + "\n"
+	if !p.encrypted {
+		cnt += "#EXT-X-PART-INF:PART-TARGET=" + strconv.FormatFloat(partTargetDuration.Seconds(), 'f', -1, 64) + "\n"
+	}
 
 	cnt += "#EXT-X-MEDIA-SEQUENCE:" + strconv.FormatInt(int64(p.segmentDeleteCount), 10) + "\n"
+	if p.discontinuityCount != 0 {
+		cnt += "#EXT-X-DISCONTINUITY-SEQUENCE:" + strconv.Itoa(p.discontinuityCount) + "\n"
+	}
+
+	params := p.firstParams()
 
 	skipped := 0
 	if !isDeltaUpdate {
-		cnt += "#EXT-X-MAP:URI=\"init.mp4\"\n"
+		if params != nil {
+			cnt += "#EXT-X-MAP:URI=\"" + initName(params.id) + "\"\n"
+		}
 	} else {
 		var curDuration time.Duration
 		shown := 0
@@ -520,18 +568,40 @@ func (p *playlist) fullPlaylist(isDeltaUpdate bool) []byte { //nolint:funlen
 		cnt += "#EXT-X-SKIP:SKIPPED-SEGMENTS=" + strconv.FormatInt(int64(skipped), 10) + "\n"
 	}
 
+	// Key of the following segments, the first shown
+	// segment and every key rotation emits a key tag.
+	keyEmitted := false
+	var keyID uint64
+
 	for i, sog := range p.segments {
 		if i < skipped {
+			if seg, ok := sog.(*Segment); ok {
+				params = seg.params
+			}
 			continue
 		}
 
 		switch seg := sog.(type) {
 		case *Segment:
+			if p.encrypted && params != nil && params.id != seg.params.id {
+				// The init files aren't encrypted.
+				cnt += "#EXT-X-KEY:METHOD=NONE\n"
+				keyEmitted = false
+			}
+			cnt += paramsChangeTags(params, seg.params)
+			params = seg.params
+
+			if p.encrypted && (!keyEmitted || keyID != seg.keyID) {
+				cnt += keyTag(seg.keyID)
+				keyEmitted = true
+				keyID = seg.keyID
+			}
+
 			if (len(p.segments) - i) <= 2 {
 				cnt += "#EXT-X-PROGRAM-DATE-TIME:" + seg.StartTime.Format("2006-01-02T15:04:05.999Z07:00") + "\n"
 			}
 
-			if (len(p.segments) - i) <= 2 {
+			if !p.encrypted && (len(p.segments)-i) <= 2 {
 				for _, part := range seg.Parts {
 					cnt += "#EXT-X-PART:DURATION=" + strconv.FormatFloat(part.renderedDuration.Seconds(), 'f', 5, 64) +
 						",URI=\"" + part.name() + ".mp4\""
@@ -552,7 +622,14 @@ func (p *playlist) fullPlaylist(isDeltaUpdate bool) []byte { //nolint:funlen
 		}
 	}
 
+	if p.encrypted {
+		return []byte(cnt)
+	}
+
 	for _, part := range p.nextSegmentParts {
+		cnt += paramsChangeTags(params, part.params)
+		params = part.params
+
 		cnt += "#EXT-X-PART:DURATION=" + strconv.FormatFloat(part.renderedDuration.Seconds(), 'f', 5, 64) +
 			",URI=\"" + part.name() + ".mp4\""
 		if part.isIndependent {
@@ -568,6 +645,81 @@ func (p *playlist) fullPlaylist(isDeltaUpdate bool) []byte { //nolint:funlen
 	return []byte(cnt)
 }
 
+// firstParams returns the parameters of the first segment or part.
+func (p *playlist) firstParams() *videoParams {
+	for _, sog := range p.segments {
+		if seg, ok := sog.(*Segment); ok {
+			return seg.params
+		}
+	}
+	if len(p.nextSegmentParts) != 0 {
+		return p.nextSegmentParts[0].params
+	}
+	return nil
+}
+
+// paramsChangeTags a discontinuity with a new init file is
+// required when the camera changes the video parameters.
+func paramsChangeTags(prev *videoParams, params *videoParams) string {
+	if prev == nil || params == nil || prev.id == params.id {
+		return ""
+	}
+	return "#EXT-X-DISCONTINUITY\n" +
+		"#EXT-X-MAP:URI=\"" + initName(params.id) + "\"\n"
+}
+
+// iFramePlaylist returns a I-frame only media playlist where
+// each entry is a byte range of a keyframe within a segment.
+func (p *playlist) iFramePlaylist() []byte {
+	var entries string
+	var targetDuration uint
+	params := p.firstParams()
+	for _, sog := range p.segments {
+		seg, ok := sog.(*Segment)
+		if !ok {
+			continue
+		}
+		frames := seg.iFrames()
+		if len(frames) != 0 {
+			entries += paramsChangeTags(params, seg.params)
+			params = seg.params
+		}
+		for _, frame := range frames {
+			v := uint(math.Round(frame.duration.Seconds()))
+			if v > targetDuration {
+				targetDuration = v
+			}
+			entries += "#EXTINF:" + strconv.FormatFloat(frame.duration.Seconds(), 'f', 5, 64) + ",\n" +
+				"#EXT-X-BYTERANGE:" + strconv.FormatUint(frame.size, 10) +
+				"@" + strconv.FormatUint(frame.offset, 10) + "\n" +
+				seg.name + ".mp4\n"
+		}
+	}
+
+	cnt := "#EXTM3U\n" +
+		"#EXT-X-VERSION:9\n" +
+		"#EXT-X-TARGETDURATION:" + strconv.FormatUint(uint64(targetDuration), 10) + "\n" +
+		"#EXT-X-MEDIA-SEQUENCE:" + strconv.FormatInt(int64(p.iFrameDeleteCount), 10) + "\n"
+	if p.discontinuityCount != 0 {
+		cnt += "#EXT-X-DISCONTINUITY-SEQUENCE:" + strconv.Itoa(p.discontinuityCount) + "\n"
+	}
+	cnt += "#EXT-X-I-FRAMES-ONLY\n"
+	if first := p.firstParams(); first != nil {
+		cnt += "#EXT-X-MAP:URI=\"" + initName(first.id) + "\"\n"
+	}
+	return []byte(cnt + entries)
+}
+
+func (p *playlist) iFramePlaylistReader() *MuxerFileResponse {
+	res := make(chan *MuxerFileResponse)
+	select {
+	case <-p.ctx.Done():
+		return &MuxerFileResponse{Status: http.StatusInternalServerError}
+	case p.chIFramePlaylist <- res:
+		return <-res
+	}
+}
+
 type segmentRequest struct {
 	name string
 	res  chan *MuxerFileResponse
@@ -596,7 +748,7 @@ func (p *playlist) segmentReader(fname string) *MuxerFileResponse {
 			return <-segmentRes
 		}
 
-	case strings.HasPrefix(fname, "part"):
+	case strings.HasPrefix(fname, "part") && !p.encrypted:
 		blockingPartRes := make(chan *MuxerFileResponse)
 		blockingPartReq := blockingPartRequest{
 			partName: fname,
@@ -644,23 +796,12 @@ func (p *playlist) segmentFinalized(segment *Segment) {
 
 	p.segmentsByName[segment.name] = segment
 	p.segments = append(p.segments, segment)
+	p.memoryUsage.Add(int64(len(segment.encrypted)))
 	p.nextSegmentID = segment.ID + 1
 	p.nextSegmentParts = p.nextSegmentParts[:0]
 
 	if len(p.segments) > p.segmentCount {
-		toDelete := p.segments[0]
-
-		if toDeleteSeg, ok := toDelete.(*Segment); ok {
-			for _, part := range toDeleteSeg.Parts {
-				delete(p.partsByName, part.name())
-			}
-
-			delete(p.segmentsByName, toDeleteSeg.name)
-		}
-
-		p.segments[0] = nil // Free memory!
-		p.segments = p.segments[1:]
-		p.segmentDeleteCount++
+		p.deleteOldestSegment()
 	}
 
 	for done := range p.segFinalOnHold {
@@ -677,6 +818,68 @@ func (p *playlist) segmentFinalized(segment *Segment) {
 	p.checkPending()
 }
 
+// deleteOldestSegment removes the first segment or gap
+// from the playlist and returns the number of bytes freed.
+func (p *playlist) deleteOldestSegment() int64 {
+	var freed int64
+	toDelete := p.segments[0]
+
+	if toDeleteSeg, ok := toDelete.(*Segment); ok {
+		for _, part := range toDeleteSeg.Parts {
+			delete(p.partsByName, part.name())
+			freed += part.memorySize()
+		}
+
+		delete(p.segmentsByName, toDeleteSeg.name)
+		freed += int64(len(toDeleteSeg.encrypted))
+		p.iFrameDeleteCount += len(toDeleteSeg.iFrames())
+
+		// The next segment loses its discontinuity tag.
+		if len(p.segments) > 1 {
+			next, ok := p.segments[1].(*Segment)
+			if ok && next.params.id != toDeleteSeg.params.id {
+				p.discontinuityCount++
+			}
+		}
+	}
+
+	p.segments[0] = nil // Free memory!
+	p.segments = p.segments[1:]
+	p.segmentDeleteCount++
+	p.memoryUsage.Add(-freed)
+	return freed
+}
+
+// Number of segments that are never dropped by shrink.
+const minSegmentCount = 1
+
+// shrink drops the oldest segments until n bytes have been freed.
+func (p *playlist) shrink(n int64) {
+	var freed int64
+	for freed < n && p.segmentsInPlaylist() > minSegmentCount {
+		freed += p.deleteOldestSegment()
+	}
+}
+
+func (p *playlist) segmentsInPlaylist() int {
+	count := 0
+	for _, sog := range p.segments {
+		if _, ok := sog.(*Segment); ok {
+			count++
+		}
+	}
+	return count
+}
+
+// requestShrink never blocks, the request is
+// dropped if a previous request is pending.
+func (p *playlist) requestShrink(n int64) {
+	select {
+	case p.chShrink <- n:
+	default:
+	}
+}
+
 type partFinalizedRequest struct {
 	part *MuxerPart
 	done chan struct{}
@@ -2,7 +2,13 @@ package hls
 
 import (
 	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
 	"testing"
+	"time"
+
+	"nvr/pkg/video/gortsplib"
 
 	"github.com/stretchr/testify/require"
 )
@@ -11,7 +17,7 @@ func TestNextSegment(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	playlist := newPlaylist(ctx, 0, 3)
+	playlist := newPlaylist(ctx, 0, 3, false)
 	go playlist.start()
 
 	seg5 := &Segment{ID: 5}
@@ -52,3 +58,172 @@ func TestNextSegment(t *testing.T) {
 		<-done
 	})
 }
+
+func TestIFramePlaylist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	playlist := newPlaylist(ctx, 0, 3, false)
+	go playlist.start()
+
+	var partID uint64
+	genPartID := func() uint64 {
+		partID++
+		return partID
+	}
+	seg := newSegment(
+		7, 0, time.Time{}, 0, 0, 1000, true, newVideoParams(0, nil, nil), &gortsplib.TrackH264{}, nil, genPartID, func(*MuxerPart) {})
+
+	// Keyframe every third sample, part duration is longer than the GOP.
+	for i := 0; i < 8; i++ {
+		sample := &VideoSample{
+			PTS:        int64(i) * int64(time.Second),
+			DTS:        int64(i) * int64(time.Second),
+			AVCC:       []byte{byte(i), byte(i)},
+			IdrPresent: i%3 == 0,
+			Duration:   time.Second,
+		}
+		require.NoError(t, seg.writeH264(sample, 10*time.Second))
+	}
+	require.NoError(t, seg.finalize(&VideoSample{DTS: int64(8 * time.Second)}))
+	playlist.onSegmentFinalized(seg)
+
+	res := playlist.iFramePlaylistReader()
+	require.Equal(t, http.StatusOK, res.Status)
+	require.Equal(t, "audio/mpegURL", res.Header["Content-Type"])
+	rawPlaylist, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:9\n" +
+		"#EXT-X-TARGETDURATION:3\n" +
+		"#EXT-X-MEDIA-SEQUENCE:0\n" +
+		"#EXT-X-I-FRAMES-ONLY\n" +
+		"#EXT-X-MAP:URI=\"init0.mp4\"\n" +
+		"#EXTINF:3.00000,\n" +
+		"#EXT-X-BYTERANGE:150@0\n" +
+		"seg7.mp4\n" +
+		"#EXTINF:3.00000,\n" +
+		"#EXT-X-BYTERANGE:150@150\n" +
+		"seg7.mp4\n" +
+		"#EXTINF:2.00000,\n" +
+		"#EXT-X-BYTERANGE:132@300\n" +
+		"seg7.mp4\n"
+	require.Equal(t, expected, string(rawPlaylist))
+
+	// Each byte range must decode to a moof+mdat
+	// pair that starts with a sync sample.
+	reader := seg.reader()
+	for _, frame := range seg.iFrames() {
+		_, err := reader.Seek(int64(frame.offset), io.SeekStart)
+		require.NoError(t, err)
+
+		buf := make([]byte, frame.size)
+		_, err = io.ReadFull(reader, buf)
+		require.NoError(t, err)
+
+		moofSize := binary.BigEndian.Uint32(buf[0:4])
+		require.Equal(t, "moof", string(buf[4:8]))
+		require.Equal(t, "trun", string(buf[72:76]))
+		firstSampleFlags := binary.BigEndian.Uint32(buf[96:100])
+		require.Zero(t, firstSampleFlags&(1<<16), "first sample is not a sync sample")
+
+		mdatSize := binary.BigEndian.Uint32(buf[moofSize : moofSize+4])
+		require.Equal(t, "mdat", string(buf[moofSize+4:moofSize+8]))
+		require.Equal(t, frame.size, uint64(moofSize+mdatSize))
+	}
+}
+
+func TestPlaylistShrink(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	playlist := newPlaylist(ctx, 0, 20, false)
+	go playlist.start()
+
+	var partID uint64
+	genPartID := func() uint64 {
+		partID++
+		return partID
+	}
+	params := newVideoParams(0, nil, nil)
+	newTestSegment := func(id uint64) *Segment {
+		seg := newSegment(
+			id, 0, time.Time{}, 0, 0, 1000, false, params, &gortsplib.TrackH264{}, nil, genPartID, playlist.partFinalized)
+		sample := &VideoSample{
+			DTS:        int64(id) * int64(time.Second),
+			AVCC:       make([]byte, 100),
+			IdrPresent: true,
+			Duration:   time.Second,
+		}
+		require.NoError(t, seg.writeH264(sample, 10*time.Second))
+		require.NoError(t, seg.finalize(&VideoSample{DTS: int64(id+1) * int64(time.Second)}))
+		playlist.onSegmentFinalized(seg)
+		return seg
+	}
+	segmentSize := func(seg *Segment) int64 {
+		var size int64
+		for _, part := range seg.Parts {
+			size += part.memorySize()
+		}
+		return size
+	}
+
+	seg1 := newTestSegment(1)
+	seg2 := newTestSegment(2)
+	seg3 := newTestSegment(3)
+	size1, size2, size3 := segmentSize(seg1), segmentSize(seg2), segmentSize(seg3)
+	require.Greater(t, size1, int64(100))
+	require.Equal(t, size1+size2+size3, playlist.memoryUsage.Load())
+
+	// The oldest segment is dropped.
+	playlist.requestShrink(1)
+	require.Eventually(t, func() bool {
+		return playlist.memoryUsage.Load() == size2+size3
+	}, time.Second, time.Millisecond)
+
+	// The newest segment is kept.
+	playlist.requestShrink(1 << 40)
+	require.Eventually(t, func() bool {
+		return playlist.memoryUsage.Load() == size3
+	}, time.Second, time.Millisecond)
+
+	seg, err := playlist.nextSegment(nil)
+	require.NoError(t, err)
+	require.Equal(t, seg3, seg)
+}
+
+func TestPartsReaderSeek(t *testing.T) {
+	reader := &partsReader{parts: []*MuxerPart{
+		{renderedContent: []byte{0, 1, 2}},
+		{renderedContent: []byte{3, 4}},
+		{renderedContent: []byte{5, 6, 7}},
+	}}
+
+	cases := []struct {
+		offset   int64
+		whence   int
+		expected []byte
+	}{
+		{4, io.SeekStart, []byte{4, 5, 6, 7}},
+		{-3, io.SeekEnd, []byte{5, 6, 7}},
+		{0, io.SeekStart, []byte{0, 1, 2, 3, 4, 5, 6, 7}},
+		{8, io.SeekStart, []byte{}},
+	}
+	for _, tc := range cases {
+		_, err := reader.Seek(tc.offset, tc.whence)
+		require.NoError(t, err)
+		actual, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, actual)
+	}
+
+	_, err := reader.Seek(2, io.SeekStart)
+	require.NoError(t, err)
+	pos, err := reader.Seek(3, io.SeekCurrent)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), pos)
+
+	_, err = reader.Seek(-1, io.SeekStart)
+	require.ErrorIs(t, err, ErrInvalidSeek)
+}
@@ -1,6 +1,7 @@
 package hls
 
 import (
+	"bytes"
 	"errors"
 	"io"
 	"nvr/pkg/video/gortsplib"
@@ -14,6 +15,9 @@ type partsReader struct {
 	curPos  int
 }
 
+// ErrInvalidSeek invalid seek.
+var ErrInvalidSeek = errors.New("invalid seek")
+
 func (mbr *partsReader) Read(p []byte) (int, error) {
 	n := 0
 	lenp := len(p)
@@ -38,23 +42,75 @@ func (mbr *partsReader) Read(p []byte) (int, error) {
 	}
 }
 
+// Seek implements io.Seeker.
+func (mbr *partsReader) Seek(offset int64, whence int) (int64, error) {
+	var size int64
+	for _, part := range mbr.parts {
+		size += int64(len(part.renderedContent))
+	}
+
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = offset
+		for _, part := range mbr.parts[:mbr.curPart] {
+			abs += int64(len(part.renderedContent))
+		}
+		abs += int64(mbr.curPos)
+	case io.SeekEnd:
+		abs = size + offset
+	default:
+		return 0, ErrInvalidSeek
+	}
+	if abs < 0 {
+		return 0, ErrInvalidSeek
+	}
+
+	mbr.curPart = 0
+	mbr.curPos = 0
+	remaining := abs
+	for mbr.curPart < len(mbr.parts) {
+		partSize := int64(len(mbr.parts[mbr.curPart].renderedContent))
+		if remaining < partSize {
+			mbr.curPos = int(remaining)
+			break
+		}
+		remaining -= partSize
+		mbr.curPart++
+	}
+	return abs, nil
+}
+
 // Segment .
 type Segment struct {
 	ID              uint64
 	muxerID         uint16
-	StartTime       time.Time // Segment start time.
+	StartTime       time.Time // Segment start time in UTC.
 	startDTS        time.Duration
 	muxerStartTime  int64
 	segmentMaxSize  uint64
+	splitAtIDR      bool
+	params          *videoParams
+	videoTrack      *gortsplib.TrackH264
 	audioTrack      *gortsplib.TrackMPEG4Audio
 	genPartID       func() uint64
 	onPartFinalized func(*MuxerPart)
 
+	// Parameter sets that the segment was muxed with.
+	VideoSPS []byte
+	VideoPPS []byte
+
 	name             string
 	size             uint64
 	Parts            []*MuxerPart
 	currentPart      *MuxerPart
 	RenderedDuration time.Duration
+
+	// Encrypted content and key, nil if encryption is disabled.
+	encrypted []byte
+	keyID     uint64
 }
 
 func newSegment(
@@ -64,6 +120,9 @@ func newSegment(
 	startDTS time.Duration,
 	muxerStartTime int64,
 	segmentMaxSize uint64,
+	splitAtIDR bool,
+	params *videoParams,
+	videoTrack *gortsplib.TrackH264,
 	audioTrack *gortsplib.TrackMPEG4Audio,
 	genPartID func() uint64,
 	onPartFinalized func(*MuxerPart),
@@ -71,29 +130,54 @@ func newSegment(
 	s := &Segment{
 		ID:              id,
 		muxerID:         muxerID,
-		StartTime:       startTime,
+		StartTime:       startTime.UTC(),
 		startDTS:        startDTS,
 		muxerStartTime:  muxerStartTime,
 		segmentMaxSize:  segmentMaxSize,
+		splitAtIDR:      splitAtIDR,
+		params:          params,
+		videoTrack:      videoTrack,
 		audioTrack:      audioTrack,
 		genPartID:       genPartID,
 		onPartFinalized: onPartFinalized,
+		VideoSPS:        params.sps,
+		VideoPPS:        params.pps,
 		name:            "seg" + strconv.FormatUint(id, 10),
 	}
 
 	s.currentPart = newPart(
+		videoTrack,
 		audioTrack,
 		s.muxerStartTime,
 		s.genPartID(),
+		params,
 	)
 
 	return s
 }
 
-func (s *Segment) reader() io.Reader {
+func (s *Segment) reader() io.ReadSeeker {
+	if s.encrypted != nil {
+		return bytes.NewReader(s.encrypted)
+	}
 	return &partsReader{parts: s.Parts}
 }
 
+// encrypt renders the encrypted content, called once when finalized.
+func (s *Segment) encrypt(key *segmentKey) error {
+	plain, err := io.ReadAll(&partsReader{parts: s.Parts})
+	if err != nil {
+		return err
+	}
+	encrypted, err := encryptSegment(key.key, s.ID, plain)
+	if err != nil {
+		return err
+	}
+	s.encrypted = encrypted
+	s.keyID = key.id
+	return nil
+}
+
 func (s *Segment) getRenderedDuration() time.Duration {
 	return s.RenderedDuration
 }
@@ -125,29 +209,74 @@ func (s *Segment) writeH264(sample *VideoSample, adjustedPartDuration time.Durat
 		return ErrMaximumSegmentSize
 	}
 
+	// Start a new part on every keyframe so that each
+	// keyframe can be addressed by a byte range.
+	if s.splitAtIDR && sample.IdrPresent && len(s.currentPart.VideoSamples) != 0 {
+		if err := s.switchPart(); err != nil {
+			return err
+		}
+	}
+
 	s.currentPart.writeH264(sample)
 
 	s.size += size
 
 	// switch part
 	if s.currentPart.duration() >= adjustedPartDuration {
-		if err := s.currentPart.finalize(); err != nil {
+		if err := s.switchPart(); err != nil {
 			return err
 		}
+	}
 
-		s.Parts = append(s.Parts, s.currentPart)
-		s.onPartFinalized(s.currentPart)
+	return nil
+}
 
-		s.currentPart = newPart(
-			s.audioTrack,
-			s.muxerStartTime,
-			s.genPartID(),
-		)
+func (s *Segment) switchPart() error {
+	if err := s.currentPart.finalize(); err != nil {
+		return err
 	}
 
+	s.Parts = append(s.Parts, s.currentPart)
+	s.onPartFinalized(s.currentPart)
+
+	s.currentPart = newPart(
+		s.videoTrack,
+		s.audioTrack,
+		s.muxerStartTime,
+		s.genPartID(),
+		s.params,
+	)
 	return nil
 }
 
+// iFrame byte range of a keyframe's moof+mdat pair within a segment.
+type iFrame struct {
+	offset   uint64
+	size     uint64
+	duration time.Duration
+}
+
+// iFrames returns the parts that start with a keyframe. The duration
+// of a iFrame lasts until the next iFrame or the end of the segment.
+func (s *Segment) iFrames() []iFrame {
+	var frames []iFrame
+	var offset uint64
+	for _, part := range s.Parts {
+		size := uint64(len(part.renderedContent))
+		if len(part.VideoSamples) != 0 && part.VideoSamples[0].IdrPresent {
+			frames = append(frames, iFrame{
+				offset: offset,
+				size:   size,
+			})
+		}
+		if len(frames) != 0 {
+			frames[len(frames)-1].duration += part.renderedDuration
+		}
+		offset += size
+	}
+	return frames
+}
+
 func (s *Segment) writeAAC(sample *AudioSample) error {
 	size := uint64(len(sample.AU))
 	if (s.size + size) > s.segmentMaxSize {
@@ -1,9 +1,9 @@
 package hls
 
 import (
-	"bytes"
 	"nvr/pkg/video/gortsplib"
 	"nvr/pkg/video/gortsplib/pkg/h264"
+	"sync/atomic"
 	"time"
 )
 
@@ -48,18 +48,19 @@ type segmenter struct {
 	segmentDuration    time.Duration
 	partDuration       time.Duration
 	segmentMaxSize     uint64
+	splitAtIDR         bool
 	videoTrack         *gortsplib.TrackH264
 	audioTrack         *gortsplib.TrackMPEG4Audio
 	onSegmentFinalized func(*Segment)
 	onPartFinalized    func(*MuxerPart)
+	onParamsChanged    func(*videoParams)
 
 	startDTS                       time.Duration
 	muxerStartTime                 int64
 	videoFirstRandomAccessReceived bool
 	videoDTSExtractor              *h264.DTSExtractor
-	lastVideoParams                [][]byte
+	videoParams                    *videoParams
 	nextSegmentID                  uint64
-	videoSPS                       []byte
 	currentSegment                 *Segment
 	nextPartID                     uint64
 	nextVideoSample                *VideoSample
@@ -67,6 +68,12 @@ type segmenter struct {
 	firstSegmentFinalized          bool
 	sampleDurations                map[time.Duration]struct{}
 	adjustedPartDuration           time.Duration
+
+	// Mirrors of the state above for Muxer.Status, the
+	// other fields are only accessed by the writer.
+	statusKeyframeReceived atomic.Bool
+	statusSegmentID        atomic.Uint64
+	statusSegmentStart     atomic.Int64 // UnixNano.
 }
 
 func newSegmenter(
@@ -75,20 +82,24 @@ func newSegmenter(
 	segmentDuration time.Duration,
 	partDuration time.Duration,
 	segmentMaxSize uint64,
+	splitAtIDR bool,
 	videoTrack *gortsplib.TrackH264,
 	audioTrack *gortsplib.TrackMPEG4Audio,
 	onSegmentFinalized func(*Segment),
 	onPartFinalized func(*MuxerPart),
+	onParamsChanged func(*videoParams),
 ) *segmenter {
 	return &segmenter{
 		muxerID:            muxerID,
 		segmentDuration:    segmentDuration,
 		partDuration:       partDuration,
 		segmentMaxSize:     segmentMaxSize,
+		splitAtIDR:         splitAtIDR,
 		videoTrack:         videoTrack,
 		audioTrack:         audioTrack,
 		onSegmentFinalized: onSegmentFinalized,
 		onPartFinalized:    onPartFinalized,
+		onParamsChanged:    onParamsChanged,
 		muxerStartTime:     muxerStartTime,
 		nextSegmentID:      7, // Required by iOS.
 		sampleDurations:    make(map[time.Duration]struct{}),
@@ -165,8 +176,10 @@ func (m *segmenter) writeH264Entry( //nolint:funlen
 		}
 
 		m.videoFirstRandomAccessReceived = true
+		m.statusKeyframeReceived.Store(true)
 		m.videoDTSExtractor = h264.NewDTSExtractor()
-		m.videoSPS = m.videoTrack.SPS
+		m.videoParams = m.newVideoParams(au, 0)
+		m.onParamsChanged(m.videoParams)
 
 		var err error
 		dts, err = m.videoDTSExtractor.Extract(au, dts)
@@ -214,17 +227,7 @@ func (m *segmenter) writeH264Entry( //nolint:funlen
 
 	if m.currentSegment == nil {
 		// create first segment
-		m.currentSegment = newSegment(
-			m.genSegmentID(),
-			m.muxerID,
-			ntp,
-			time.Duration(sample.DTS-m.muxerStartTime),
-			m.muxerStartTime,
-			m.segmentMaxSize,
-			m.audioTrack,
-			m.genPartID,
-			m.onPartFinalized,
-		)
+		m.currentSegment = m.newSegment(ntp, sample.DTS)
 	}
 
 	m.adjustPartDuration(sample.Duration)
@@ -236,8 +239,7 @@ func (m *segmenter) writeH264Entry( //nolint:funlen
 
 	// switch segment
 	if randomAccessPresent {
-		videoParams := extractVideoParams(m.videoTrack)
-		paramsChanged := !videoParamsEqual(m.lastVideoParams, videoParams)
+		paramsChanged := m.paramsChanged(au)
 
 		if (time.Duration(m.nextVideoSample.DTS)-m.currentSegment.startDTS) >= m.segmentDuration ||
 			paramsChanged {
@@ -249,49 +251,75 @@ func (m *segmenter) writeH264Entry( //nolint:funlen
 
 			m.firstSegmentFinalized = true
 
-			m.currentSegment = newSegment(
-				m.genSegmentID(),
-				m.muxerID,
-				ntp,
-				time.Duration(sample.DTS-m.muxerStartTime),
-				m.muxerStartTime,
-				m.segmentMaxSize,
-				m.audioTrack,
-				m.genPartID,
-				m.onPartFinalized,
-			)
-
 			if paramsChanged {
-				m.lastVideoParams = videoParams
+				m.videoParams = m.newVideoParams(au, m.videoParams.id+1)
+				m.onParamsChanged(m.videoParams)
 				m.firstSegmentFinalized = false
 
 				// reset adjusted part duration
 				m.sampleDurations = make(map[time.Duration]struct{})
 			}
+
+			m.currentSegment = m.newSegment(ntp, sample.DTS)
 		}
 	}
 
 	return nil
 }
 
-func extractVideoParams(track *gortsplib.TrackH264) [][]byte {
-	params := make([][]byte, 2)
-	params[0] = track.SafeSPS()
-	params[1] = track.SafePPS()
-	return params
+func (m *segmenter) newSegment(ntp time.Time, dts int64) *Segment {
+	id := m.genSegmentID()
+	m.statusSegmentID.Store(id)
+	m.statusSegmentStart.Store(time.Now().UnixNano())
+	return newSegment(
+		id,
+		m.muxerID,
+		ntp,
+		time.Duration(dts-m.muxerStartTime),
+		m.muxerStartTime,
+		m.segmentMaxSize,
+		m.splitAtIDR,
+		m.videoParams,
+		m.videoTrack,
+		m.audioTrack,
+		m.genPartID,
+		m.onPartFinalized,
+	)
 }
 
-func videoParamsEqual(p1 [][]byte, p2 [][]byte) bool {
-	if len(p1) != len(p2) {
-		return true
+// newVideoParams returns the parameter sets in the access unit.
+func (m *segmenter) newVideoParams(au [][]byte, id uint64) *videoParams {
+	sps, pps := m.auParams(au)
+	return newVideoParams(id, sps, pps)
+}
+
+// paramsChanged returns true if the access unit contains
+// parameter sets that differ from the current ones.
+func (m *segmenter) paramsChanged(au [][]byte) bool {
+	sps, pps := m.auParams(au)
+	return paramsHash(sps, pps) != m.videoParams.hash
+}
+
+// auParams the stream prepends the parameter sets to every IDR.
+// Missing parameter sets are taken from the current parameters,
+// or the track before the first IDR.
+func (m *segmenter) auParams(au [][]byte) ([]byte, []byte) {
+	sps, pps := extractParams(au)
+	if sps != nil && pps != nil {
+		return sps, pps
 	}
 
-	for i, p := range p1 {
-		if !bytes.Equal(p2[i], p) {
-			return false
-		}
+	prevSPS, prevPPS := m.videoTrack.SafeSPS(), m.videoTrack.SafePPS()
+	if m.videoParams != nil {
+		prevSPS, prevPPS = m.videoParams.sps, m.videoParams.pps
 	}
-	return true
+	if sps == nil {
+		sps = prevSPS
+	}
+	if pps == nil {
+		pps = prevPPS
+	}
+	return sps, pps
 }
 
 func (m *segmenter) writeAAC(pts time.Duration, au []byte) error {
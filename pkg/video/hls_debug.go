@@ -0,0 +1,284 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/web/api"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HLS pipeline states. The state is the first
+// stage of the pipeline that isn't working.
+const (
+	HLSStateStopped            = "stopped"            // The input process isn't running.
+	HLSStateNoPublisher        = "noPublisher"        // FFmpeg hasn't started publishing.
+	HLSStateNoPackets          = "noPackets"          // No packets have been received.
+	HLSStateWaitingForParams   = "waitingForParams"   // The SPS or PPS is unknown.
+	HLSStateWaitingForKeyframe = "waitingForKeyframe" // No IDR has been received.
+	HLSStateStalled            = "stalled"            // No recent packets.
+	HLSStateRunning            = "running"
+)
+
+// hlsStallTimeout packets are expected at least this often.
+const hlsStallTimeout = 5 * time.Second
+
+// HLSDebugStatus the state of the HLS pipeline of a stream.
+// Ages are in seconds and null if it hasn't happened yet.
+type HLSDebugStatus struct {
+	State string `json:"state"`
+
+	VideoTrack         bool     `json:"videoTrack"`
+	AudioTrack         bool     `json:"audioTrack"`
+	LastVideoPacketAge *float64 `json:"lastVideoPacketAge"`
+	LastAudioPacketAge *float64 `json:"lastAudioPacketAge"`
+
+	SPSKnown         bool     `json:"spsKnown"`
+	PPSKnown         bool     `json:"ppsKnown"`
+	KeyframeReceived bool     `json:"keyframeReceived"`
+	SegmentID        uint64   `json:"segmentID"`
+	SegmentAge       *float64 `json:"segmentAge"`
+
+	// Bytes of the segments and parts in memory.
+	WindowBytes int64 `json:"windowBytes"`
+
+	LastPlaylistRequestAge *float64 `json:"lastPlaylistRequestAge"`
+
+	// Last error of the muxer, kept across input restarts.
+	LastError    string   `json:"lastError,omitempty"`
+	LastErrorAge *float64 `json:"lastErrorAge,omitempty"`
+
+	Ingest       IngestStats        `json:"ingest"`
+	RTSPSessions []RTSPSessionStats `json:"rtspSessions"`
+}
+
+// MonitorHLSDebug the HLS pipeline of the main and sub stream.
+type MonitorHLSDebug struct {
+	Main *HLSDebugStatus `json:"main,omitempty"`
+	Sub  *HLSDebugStatus `json:"sub,omitempty"`
+}
+
+// RTSPSessionStats a RTSP session of a stream.
+type RTSPSessionStats struct {
+	ID         string `json:"id"`
+	RemoteAddr string `json:"remoteAddr"`
+	Publisher  bool   `json:"publisher"`
+	State      string `json:"state"`
+
+	// Reception statistics by track ID, measured by the server for
+	// the publisher and reported by the client for readers.
+	Tracks map[int]RTSPTrackStats `json:"tracks,omitempty"`
+}
+
+// RTSPTrackStats Jitter is in seconds.
+type RTSPTrackStats struct {
+	PacketsLost int64   `json:"packetsLost"`
+	Jitter      float64 `json:"jitter"`
+}
+
+// hlsDebugState is updated by the HLS muxer and read by the debug
+// endpoint without blocking the muxer. The state belongs to the
+// path manager and is reused when the input process restarts.
+type hlsDebugState struct {
+	monitorID string
+	isSub     bool
+
+	muxer               atomic.Pointer[HLSMuxer]
+	lastVideoPacket     atomic.Int64 // UnixNano.
+	lastAudioPacket     atomic.Int64 // UnixNano.
+	lastPlaylistRequest atomic.Int64 // UnixNano.
+
+	mu            sync.Mutex
+	lastError     string
+	lastErrorTime time.Time
+}
+
+func newHLSDebugState(conf *PathConf) *hlsDebugState {
+	return &hlsDebugState{
+		monitorID: conf.MonitorID,
+		isSub:     conf.IsSub,
+	}
+}
+
+// onMuxerStart is called by the HLS muxer.
+func (d *hlsDebugState) onMuxerStart(m *HLSMuxer) {
+	d.lastVideoPacket.Store(0)
+	d.lastAudioPacket.Store(0)
+	d.muxer.Store(m)
+}
+
+// onMuxerClose is called by the HLS muxer.
+func (d *hlsDebugState) onMuxerClose(m *HLSMuxer, err error) {
+	d.muxer.CompareAndSwap(m, nil)
+	if err == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	d.mu.Lock()
+	d.lastError = err.Error()
+	d.lastErrorTime = time.Now()
+	d.mu.Unlock()
+}
+
+func secondsSince(now time.Time, unixNano int64) *float64 {
+	if unixNano == 0 {
+		return nil
+	}
+	age := now.Sub(time.Unix(0, unixNano)).Seconds()
+	return &age
+}
+
+// status pa is nil if the path doesn't exist.
+func (d *hlsDebugState) status(now time.Time, pa *path) HLSDebugStatus {
+	status := HLSDebugStatus{
+		LastVideoPacketAge:     secondsSince(now, d.lastVideoPacket.Load()),
+		LastAudioPacketAge:     secondsSince(now, d.lastAudioPacket.Load()),
+		LastPlaylistRequestAge: secondsSince(now, d.lastPlaylistRequest.Load()),
+		RTSPSessions:           []RTSPSessionStats{},
+	}
+
+	d.mu.Lock()
+	status.LastError = d.lastError
+	if !d.lastErrorTime.IsZero() {
+		status.LastErrorAge = secondsSince(now, d.lastErrorTime.UnixNano())
+	}
+	d.mu.Unlock()
+
+	if pa == nil {
+		status.State = HLSStateStopped
+		return status
+	}
+	status.Ingest = pa.stats.snapshot(now)
+	status.RTSPSessions = pa.rtspSessionStats()
+
+	m := d.muxer.Load()
+	if m == nil {
+		status.State = HLSStateNoPublisher
+		return status
+	}
+
+	muxerStatus := m.muxer.Status()
+	status.VideoTrack = m.videoTrack != nil
+	status.AudioTrack = m.audioTrack != nil
+	status.SPSKnown = muxerStatus.SPSKnown
+	status.PPSKnown = muxerStatus.PPSKnown
+	status.KeyframeReceived = muxerStatus.KeyframeReceived
+	status.SegmentID = muxerStatus.SegmentID
+	if !muxerStatus.SegmentStart.IsZero() {
+		status.SegmentAge = secondsSince(now, muxerStatus.SegmentStart.UnixNano())
+	}
+	status.WindowBytes = m.memoryUsage()
+
+	lastPacket := status.LastVideoPacketAge
+	if !status.VideoTrack {
+		lastPacket = status.LastAudioPacketAge
+	}
+
+	switch {
+	case lastPacket == nil:
+		status.State = HLSStateNoPackets
+	case status.VideoTrack && (!status.SPSKnown || !status.PPSKnown):
+		status.State = HLSStateWaitingForParams
+	case status.VideoTrack && !status.KeyframeReceived:
+		status.State = HLSStateWaitingForKeyframe
+	case *lastPacket > hlsStallTimeout.Seconds():
+		status.State = HLSStateStalled
+	default:
+		status.State = HLSStateRunning
+	}
+	return status
+}
+
+// rtspSessionStats returns the publisher and readers of the path.
+func (pa *path) rtspSessionStats() []RTSPSessionStats {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+
+	stats := []RTSPSessionStats{}
+	if pa.source != nil {
+		stats = append(stats, pa.source.debugStats(true))
+	}
+	for r := range pa.readers {
+		stats = append(stats, r.debugStats(false))
+	}
+	return stats
+}
+
+// debugStats the track statistics are only read while playing or
+// recording, the tracks can't be modified in those states.
+func (s *rtspSession) debugStats(publisher bool) RTSPSessionStats {
+	s.stateMutex.Lock()
+	state := s.state
+	s.stateMutex.Unlock()
+
+	stats := RTSPSessionStats{
+		ID:        s.id,
+		Publisher: publisher,
+		State:     state.String(),
+	}
+	if s.author != nil {
+		stats.RemoteAddr = s.author.NetConn().RemoteAddr().String()
+	}
+
+	switch state {
+	case gortsplib.ServerSessionStateRecord:
+		stats.Tracks = make(map[int]RTSPTrackStats)
+		for trackID, track := range s.ss.ReceiverStats() {
+			stats.Tracks[trackID] = RTSPTrackStats{
+				PacketsLost: track.PacketsLost,
+				Jitter:      track.Jitter,
+			}
+		}
+	case gortsplib.ServerSessionStatePlay:
+		stats.Tracks = make(map[int]RTSPTrackStats)
+		for trackID, report := range s.ss.ClientReports() {
+			stats.Tracks[trackID] = RTSPTrackStats{
+				PacketsLost: report.PacketsLost,
+				Jitter:      report.Jitter,
+			}
+		}
+	}
+	return stats
+}
+
+// hlsDebug returns the HLS pipeline of the main and sub stream of a
+// monitor. Returns false if the monitor has never added a path.
+func (pm *pathManager) hlsDebug(monitorID string, now time.Time) (MonitorHLSDebug, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	var debug MonitorHLSDebug
+	found := false
+	for name, state := range pm.hlsDebugStates {
+		if state.monitorID != monitorID {
+			continue
+		}
+		found = true
+		status := state.status(now, pm.paths[name])
+		if state.isSub {
+			debug.Sub = &status
+		} else {
+			debug.Main = &status
+		}
+	}
+	return debug, found
+}
+
+// HandleHLSDebug returns the state of the HLS pipelines of a monitor.
+func (s *Server) HandleHLSDebug(monitorID string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		debug, exist := s.pathManager.hlsDebug(monitorID, time.Now())
+		if !exist {
+			api.NotFound(w, "monitor not running")
+			return
+		}
+		api.WriteJSON(w, r, debug)
+	})
+}
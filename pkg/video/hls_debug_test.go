@@ -0,0 +1,144 @@
+package video
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// 352x288, the DTS extractor can parse the non-IDR slices.
+var testDebugSPS = []byte{
+	0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+	0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+	0x00, 0x03, 0x00, 0x3d, 0x08,
+}
+
+// writeTestH264 pushes an access unit through the ring
+// buffer and waits for the writer to process it.
+func writeTestH264(t *testing.T, m *HLSMuxer, pts time.Duration, nalus ...[]byte) {
+	t.Helper()
+	prev := m.path.hlsDebug.lastVideoPacket.Load()
+	m.readerData(&dataH264{
+		trackID: 0,
+		ntp:     time.Now(),
+		pts:     pts,
+		nalus:   nalus,
+	})
+	require.Eventually(t, func() bool {
+		return m.path.hlsDebug.lastVideoPacket.Load() != prev
+	}, time.Second, time.Millisecond)
+}
+
+func TestHLSDebugStatus(t *testing.T) {
+	t.Run("noPackets", func(t *testing.T) {
+		m := newTestHLSMuxer(t, false)
+		status := m.path.hlsDebug.status(time.Now(), m.path)
+		require.Equal(t, HLSStateNoPackets, status.State)
+		require.True(t, status.VideoTrack)
+		require.True(t, status.AudioTrack)
+		require.Nil(t, status.LastVideoPacketAge)
+		require.Nil(t, status.LastPlaylistRequestAge)
+	})
+	t.Run("noKeyframe", func(t *testing.T) {
+		m := newTestHLSMuxer(t, false)
+		for i := 0; i < 3; i++ {
+			writeTestH264(t, m, time.Duration(i)*100*time.Millisecond, []byte{1, 2})
+		}
+
+		status := m.path.hlsDebug.status(time.Now(), m.path)
+		require.Equal(t, HLSStateWaitingForKeyframe, status.State)
+		require.NotNil(t, status.LastVideoPacketAge)
+		require.True(t, status.SPSKnown)
+		require.True(t, status.PPSKnown)
+		require.False(t, status.KeyframeReceived)
+		require.Equal(t, uint64(0), status.SegmentID)
+		require.Nil(t, status.SegmentAge)
+		require.Equal(t, int64(0), status.WindowBytes)
+	})
+	t.Run("healthy", func(t *testing.T) {
+		m := newTestHLSMuxer(t, false)
+		writeTestH264(t, m, 0, testDebugSPS, m.videoTrack.SafePPS(), []byte{5, 1})
+		for i := 1; i < 4; i++ {
+			writeTestH264(t, m, time.Duration(i)*100*time.Millisecond, []byte{1, 2})
+		}
+		m.path.hlsDebug.lastPlaylistRequest.Store(time.Now().UnixNano())
+
+		status := m.path.hlsDebug.status(time.Now(), m.path)
+		require.Equal(t, HLSStateRunning, status.State)
+		require.True(t, status.KeyframeReceived)
+		require.Equal(t, uint64(7), status.SegmentID)
+		require.NotNil(t, status.SegmentAge)
+		require.Less(t, *status.LastVideoPacketAge, hlsStallTimeout.Seconds())
+		require.NotNil(t, status.LastPlaylistRequestAge)
+		require.Empty(t, status.LastError)
+
+		// Packets stop arriving.
+		status = m.path.hlsDebug.status(time.Now().Add(10*time.Second), m.path)
+		require.Equal(t, HLSStateStalled, status.State)
+	})
+	t.Run("noPublisher", func(t *testing.T) {
+		m := newTestHLSMuxer(t, false)
+		d := m.path.hlsDebug
+		d.onMuxerClose(m, errors.New("muxer error: x")) //nolint:goerr113
+
+		status := d.status(time.Now(), m.path)
+		require.Equal(t, HLSStateNoPublisher, status.State)
+		require.Equal(t, "muxer error: x", status.LastError)
+		require.NotNil(t, status.LastErrorAge)
+
+		// The error is kept after the path is removed.
+		status = d.status(time.Now(), nil)
+		require.Equal(t, HLSStateStopped, status.State)
+		require.Equal(t, "muxer error: x", status.LastError)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		m := newTestHLSMuxer(t, false)
+		m.path.hlsDebug.onMuxerClose(m, context.Canceled)
+		require.Empty(t, m.path.hlsDebug.status(time.Now(), m.path).LastError)
+	})
+}
+
+func TestHandleHLSDebug(t *testing.T) {
+	s, cancel := newTestServer(t)
+	defer cancel()
+
+	ctx, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	_, err := s.NewPath(ctx, "x", PathConf{MonitorID: "x"})
+	require.NoError(t, err)
+	_, err = s.NewPath(ctx, "x_sub", PathConf{MonitorID: "x", IsSub: true})
+	require.NoError(t, err)
+
+	get := func(method string, monitorID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/api/monitor/"+monitorID+"/hls-debug", nil)
+		s.HandleHLSDebug(monitorID).ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		w := get(http.MethodGet, "x")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var debug MonitorHLSDebug
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &debug))
+		require.Equal(t, HLSStateNoPublisher, debug.Main.State)
+		require.Equal(t, HLSStateNoPublisher, debug.Sub.State)
+		require.Equal(t, []RTSPSessionStats{}, debug.Main.RTSPSessions)
+	})
+	t.Run("notExist", func(t *testing.T) {
+		w := get(http.MethodGet, "nil")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+	t.Run("methodNotAllowed", func(t *testing.T) {
+		w := get(http.MethodPost, "x")
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
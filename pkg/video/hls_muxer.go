@@ -7,10 +7,12 @@ import (
 	"net/http"
 	"nvr/pkg/log"
 	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/gortsplib/pkg/h264"
 	"nvr/pkg/video/gortsplib/pkg/mpeg4audio"
 	"nvr/pkg/video/gortsplib/pkg/ringbuffer"
 	"nvr/pkg/video/hls"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,13 +25,26 @@ type HLSMuxer struct {
 	path            *path
 	pathConf        PathConf
 	muxerClose      muxerCloseFunc
+	memory          *memoryAccountant
 
 	ctx         context.Context
 	ctxCancel   func()
 	ringBuffer  *ringbuffer.RingBuffer
-	muxer       *hls.Muxer
 	nextMuxerID uint16
 
+	// Used by the recorder, and by the live view
+	// unless the audio is excluded from it.
+	muxer *hls.Muxer
+
+	// Video only muxer for the live view, nil if the audio isn't
+	// excluded. Replaced when the live view is reinitialized.
+	liveMuxer  atomic.Pointer[hls.Muxer]
+	liveMu     sync.Mutex
+	liveCancel context.CancelFunc
+
+	videoTrack *gortsplib.TrackH264
+	audioTrack *gortsplib.TrackMPEG4Audio
+
 	// in
 	chRequest chan *hlsMuxerRequest
 }
@@ -40,6 +55,7 @@ func newHLSMuxer(
 	wg *sync.WaitGroup,
 	path *path,
 	muxerClose muxerCloseFunc,
+	memory *memoryAccountant,
 ) *HLSMuxer {
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
@@ -49,6 +65,7 @@ func newHLSMuxer(
 		path:            path,
 		pathConf:        *path.conf,
 		muxerClose:      muxerClose,
+		memory:          memory,
 		ctx:             ctx,
 		ctxCancel:       ctxCancel,
 		chRequest:       make(chan *hlsMuxerRequest),
@@ -66,6 +83,7 @@ func (m *HLSMuxer) logf(format string, a ...interface{}) {
 func (m *HLSMuxer) start(tracks gortsplib.Tracks) error {
 	if err := m.run(tracks); err != nil {
 		m.ctxCancel()
+		m.path.hlsDebug.onMuxerClose(m, err)
 		return err
 	}
 	return nil
@@ -77,12 +95,25 @@ func (m *HLSMuxer) run(tracks gortsplib.Tracks) error {
 		return fmt.Errorf("parse tracks: %w", err)
 	}
 
-	m.muxer = m.createMuxer(videoTrack, audioTrack)
+	m.videoTrack = videoTrack
+	m.audioTrack = audioTrack
+	m.muxer = m.createMuxer(m.ctx, videoTrack, audioTrack, func(segment *hls.Segment) {
+		m.path.stats.onSegmentFinalized(segment.RenderedDuration)
+	})
+	m.reinitLive()
 
 	m.ringBuffer, err = ringbuffer.New(uint64(m.readBufferCount))
 	if err != nil {
 		return err
 	}
+	m.path.hlsDebug.onMuxerStart(m)
+
+	unregisterMemory := m.memory.register(&memoryConsumer{
+		monitorID: m.pathConf.MonitorID,
+		name:      m.memoryName(),
+		usage:     m.memoryUsage,
+		shrink:    m.shrink,
+	})
 
 	innerErr := make(chan error)
 	go func() {
@@ -98,9 +129,11 @@ func (m *HLSMuxer) run(tracks gortsplib.Tracks) error {
 	go func() {
 		defer m.wg.Done()
 
-		cleanup := func() {
+		cleanup := func(err error) {
 			m.ctxCancel()
+			m.path.hlsDebug.onMuxerClose(m, err)
 			m.muxerClose(m)
+			unregisterMemory()
 
 			// This will disconnect FFmpeg and restart the input process.
 			m.path.close()
@@ -111,7 +144,7 @@ func (m *HLSMuxer) run(tracks gortsplib.Tracks) error {
 		for {
 			select {
 			case <-m.ctx.Done():
-				cleanup()
+				cleanup(nil)
 				<-innerErr
 				return
 
@@ -119,7 +152,7 @@ func (m *HLSMuxer) run(tracks gortsplib.Tracks) error {
 				req.res <- m.handleRequest(req)
 
 			case err := <-innerErr:
-				cleanup()
+				cleanup(err)
 				if !errors.Is(err, context.Canceled) {
 					m.logf("closed: %v", err)
 				}
@@ -131,6 +164,65 @@ func (m *HLSMuxer) run(tracks gortsplib.Tracks) error {
 	return nil
 }
 
+func (m *HLSMuxer) memoryName() string {
+	if m.pathConf.IsSub {
+		return "HLS sub"
+	}
+	return "HLS main"
+}
+
+func (m *HLSMuxer) memoryUsage() int64 {
+	usage := m.muxer.MemoryUsage()
+	if live := m.liveMuxer.Load(); live != nil {
+		usage += live.MemoryUsage()
+	}
+	return usage
+}
+
+func (m *HLSMuxer) shrink(n int64) {
+	m.muxer.Shrink(n)
+	if live := m.liveMuxer.Load(); live != nil {
+		live.Shrink(n)
+	}
+}
+
+// reinitLive replaces the live muxer. A video only muxer is created if the
+// audio is excluded from the live view. The muxer ID changes so players
+// will reload the playlist and init file, the recorder is unaffected.
+func (m *HLSMuxer) reinitLive() {
+	m.liveMu.Lock()
+	defer m.liveMu.Unlock()
+
+	if m.liveCancel != nil {
+		m.liveCancel()
+		m.liveCancel = nil
+	}
+
+	if !m.path.excludeLiveAudio.Load() || m.audioTrack == nil || m.videoTrack == nil {
+		m.liveMuxer.Store(nil)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.liveCancel = cancel
+	m.liveMuxer.Store(m.createMuxer(ctx, m.videoTrack, nil, nil))
+}
+
+// live returns the muxer that serves the live view.
+func (m *HLSMuxer) live() *hls.Muxer {
+	if live := m.liveMuxer.Load(); live != nil {
+		return live
+	}
+	return m.muxer
+}
+
+// rendition returns the rendition of the live muxer, the
+// bandwidth is measured from the stream of the publisher.
+func (m *HLSMuxer) rendition(dir string) hls.Rendition {
+	stats := m.path.stats.snapshot(time.Now())
+	return m.live().Rendition(dir, int(stats.BytesPerSecond*8))
+}
+
 func (m *HLSMuxer) genMuxerID() uint16 {
 	id := m.nextMuxerID
 	m.nextMuxerID++
@@ -188,23 +280,33 @@ var mb = uint64(1000000)
 var hlsSegmentMaxSize = 50 * mb
 
 func (m *HLSMuxer) createMuxer(
+	ctx context.Context,
 	videoTrack *gortsplib.TrackH264,
 	audioTrack *gortsplib.TrackMPEG4Audio,
+	onSegmentFinalized func(*hls.Segment),
 ) *hls.Muxer {
 	muxerLogFunc := func(level log.Level, format string, a ...interface{}) {
 		m.path.logf(level, "HLS: "+format, a...)
 	}
 
+	var encryption *hls.EncryptionConfig
+	if m.pathConf.HLSEncryption {
+		encryption = &hls.EncryptionConfig{KeyRotation: m.pathConf.HLSKeyRotation}
+	}
+
 	return hls.NewMuxer(
-		m.ctx,
+		ctx,
 		m.genMuxerID(),
 		hlsSegmentCount,
 		hlsSegmentDuration,
 		hlsPartDuration,
 		hlsSegmentMaxSize,
+		m.pathConf.HLSIFrames,
+		encryption,
 		muxerLogFunc,
 		videoTrack,
 		audioTrack,
+		onSegmentFinalized,
 	)
 }
 
@@ -225,6 +327,13 @@ func (m *HLSMuxer) runWriter(
 	audioStartPTSFilled := false
 	var audioStartPTS time.Duration
 
+	var levelMeter *audioLevelMeter
+	if m.pathConf.AudioLevel && m.path.audioLevels != nil && audioTrack != nil {
+		levelMeter = newAudioLevelMeter(func(level AudioLevel) {
+			m.path.audioLevels.publish(m.pathConf.MonitorID, level)
+		})
+	}
+
 	for {
 		item, ok := m.ringBuffer.Pull()
 		if !ok {
@@ -238,6 +347,7 @@ func (m *HLSMuxer) runWriter(
 			if tdata.nalus == nil {
 				continue
 			}
+			m.path.hlsDebug.lastVideoPacket.Store(time.Now().UnixNano())
 
 			if !videoStartPTSFilled {
 				videoStartPTSFilled = true
@@ -245,16 +355,26 @@ func (m *HLSMuxer) runWriter(
 			}
 			pts := tdata.pts - videoStartPTS
 
+			if h264.IDRPresent(tdata.nalus) {
+				m.path.stats.onKeyframe(tdata.ntp)
+			}
+
 			err := m.muxer.WriteH264(tdata.ntp, pts, tdata.nalus)
 			if err != nil {
 				return fmt.Errorf("muxer error: %w", err)
 			}
+			if live := m.liveMuxer.Load(); live != nil {
+				if err := live.WriteH264(tdata.ntp, pts, tdata.nalus); err != nil {
+					return fmt.Errorf("live muxer error: %w", err)
+				}
+			}
 		} else if audioTrack != nil && data.getTrackID() == audioTrackID {
 			tdata := data.(*dataMPEG4Audio) //nolint:forcetypeassert
 
 			if tdata.aus == nil {
 				continue
 			}
+			m.path.hlsDebug.lastAudioPacket.Store(time.Now().UnixNano())
 
 			if !audioStartPTSFilled {
 				audioStartPTSFilled = true
@@ -270,6 +390,9 @@ func (m *HLSMuxer) runWriter(
 				if err != nil {
 					return fmt.Errorf("muxer error: %w", err)
 				}
+				if levelMeter != nil {
+					levelMeter.onAU(au, audioTrack.ClockRate())
+				}
 			}
 		}
 	}
@@ -303,7 +426,7 @@ func (m *HLSMuxer) handleRequest(req *hlsMuxerRequest) *hls.MuxerFileResponse {
 		return ""
 	}()
 
-	return m.muxer.File(req.file, msn, part, skip)
+	return m.live().File(req.file, msn, part, skip)
 }
 
 // onRequest is called by hlsserver.Server (forwarded from ServeHTTP).
@@ -0,0 +1,175 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"nvr/pkg/log"
+	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/gortsplib/pkg/mpeg4audio"
+	"nvr/pkg/video/hls"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHLSMuxer(t *testing.T, excludeLiveAudio bool) *HLSMuxer {
+	t.Helper()
+	conf := &PathConf{MonitorID: "a", ExcludeLiveAudio: excludeLiveAudio}
+	return newTestHLSMuxerPath(t, "a", conf)
+}
+
+func newTestHLSMuxerPath(t *testing.T, name string, conf *PathConf) *HLSMuxer {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	logger := log.NewDummyLogger()
+	pa := newPath(ctx, name, conf, wg, nil, logger, newHLSDebugState(conf), nil)
+	m := newHLSMuxer(ctx, 16, wg, pa, func(*HLSMuxer) {}, newMemoryAccountant(0, logger))
+
+	tracks := gortsplib.Tracks{
+		&gortsplib.TrackH264{
+			PayloadType: 96,
+			SPS: []byte{
+				103, 100, 0, 22, 172, 217, 64, 164,
+				59, 228, 136, 192, 68, 0, 0, 3,
+				0, 4, 0, 0, 3, 0, 96, 60,
+				88, 182, 88,
+			},
+			PPS: []byte{1, 2, 3, 4},
+		},
+		&gortsplib.TrackMPEG4Audio{
+			PayloadType: 97,
+			Config: &mpeg4audio.Config{
+				Type:         mpeg4audio.ObjectTypeAACLC,
+				SampleRate:   44100,
+				ChannelCount: 1,
+			},
+			SizeLength:       13,
+			IndexLength:      3,
+			IndexDeltaLength: 3,
+		},
+	}
+	require.NoError(t, m.start(tracks))
+	return m
+}
+
+func readMuxerFile(t *testing.T, res *hls.MuxerFileResponse) []byte {
+	t.Helper()
+	require.Equal(t, http.StatusOK, res.Status)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return body
+}
+
+// liveFile returns a file served to the live view.
+func liveFile(t *testing.T, m *HLSMuxer, name string) []byte {
+	t.Helper()
+	return readMuxerFile(t, m.handleRequest(&hlsMuxerRequest{
+		file: name,
+		req:  httptest.NewRequest(http.MethodGet, "/hls/a/"+name, nil),
+	}))
+}
+
+// recorderFile returns a file from the muxer used by the recorder.
+func recorderFile(t *testing.T, m *HLSMuxer, name string) []byte {
+	t.Helper()
+	return readMuxerFile(t, m.muxer.File(name, "", "", ""))
+}
+
+func TestHLSMuxerExcludeLiveAudio(t *testing.T) {
+	trak := []byte("trak")
+	mp4a := []byte("mp4a")
+
+	t.Run("excluded", func(t *testing.T) {
+		m := newTestHLSMuxer(t, true)
+		require.NotNil(t, m.liveMuxer.Load())
+
+		liveInit := liveFile(t, m, "init.mp4")
+		require.Equal(t, 1, bytes.Count(liveInit, trak))
+		require.False(t, bytes.Contains(liveInit, mp4a))
+
+		recorderInit := recorderFile(t, m, "init.mp4")
+		require.Equal(t, 2, bytes.Count(recorderInit, trak))
+		require.True(t, bytes.Contains(recorderInit, mp4a))
+
+		require.NotNil(t, m.muxer.AudioTrack())
+		require.Nil(t, m.liveMuxer.Load().AudioTrack())
+
+		livePlaylist := liveFile(t, m, "index.m3u8")
+		require.Contains(t, string(livePlaylist), `CODECS="avc1.640016"`)
+		recorderPlaylist := recorderFile(t, m, "index.m3u8")
+		require.Contains(t, string(recorderPlaylist), `CODECS="avc1.640016,mp4a.40.2"`)
+	})
+	t.Run("included", func(t *testing.T) {
+		m := newTestHLSMuxer(t, false)
+		require.Nil(t, m.liveMuxer.Load())
+
+		liveInit := liveFile(t, m, "init.mp4")
+		require.Equal(t, 2, bytes.Count(liveInit, trak))
+		require.Equal(t, m.muxer, m.live())
+	})
+	t.Run("reinit", func(t *testing.T) {
+		m := newTestHLSMuxer(t, false)
+
+		m.path.excludeLiveAudio.Store(true)
+		m.reinitLive()
+		first := m.liveMuxer.Load()
+		require.NotNil(t, first)
+		require.False(t, bytes.Contains(liveFile(t, m, "init.mp4"), mp4a))
+
+		// A new muxer is created every time.
+		m.reinitLive()
+		require.NotSame(t, first, m.liveMuxer.Load())
+
+		m.path.excludeLiveAudio.Store(false)
+		m.reinitLive()
+		require.Nil(t, m.liveMuxer.Load())
+		require.True(t, bytes.Contains(liveFile(t, m, "init.mp4"), mp4a))
+	})
+}
+
+type reinitRecorder struct {
+	pathManagerHLSServer
+	reinits []string
+}
+
+func (r *reinitRecorder) reinitLiveMuxer(pathName string) {
+	r.reinits = append(r.reinits, pathName)
+}
+
+func TestSetExcludeLiveAudio(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	hlsServer := &reinitRecorder{}
+	pm := newPathManager(&wg, log.NewDummyLogger(), hlsServer, nil)
+	_, err := pm.AddPath(ctx, "a", PathConf{MonitorID: "a"})
+	require.NoError(t, err)
+
+	require.NoError(t, pm.setExcludeLiveAudio("a", true))
+	require.True(t, pm.paths["a"].excludeLiveAudio.Load())
+
+	// Unchanged.
+	require.NoError(t, pm.setExcludeLiveAudio("a", true))
+	require.Equal(t, []string{"a"}, hlsServer.reinits)
+
+	require.NoError(t, pm.setExcludeLiveAudio("a", false))
+	require.Equal(t, []string{"a", "a"}, hlsServer.reinits)
+
+	err = pm.setExcludeLiveAudio("nil", true)
+	require.ErrorIs(t, err, ErrPathNotExist)
+}
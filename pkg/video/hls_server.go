@@ -19,6 +19,7 @@ import (
 type hlsServer struct {
 	readBufferCount int
 	logger          *log.Logger
+	memory          *memoryAccountant
 
 	ctx    context.Context
 	wg     *sync.WaitGroup
@@ -30,16 +31,19 @@ type hlsServer struct {
 	chRequest            chan *hlsMuxerRequest
 	chMuxerbyPathName    chan muxerByPathNameRequest
 	chMuxerClose         chan *HLSMuxer
+	chReinitLiveMuxer    chan string
 }
 
 func newHLSServer(
 	wg *sync.WaitGroup,
 	readBufferCount int,
 	logger *log.Logger,
+	memory *memoryAccountant,
 ) *hlsServer {
 	return &hlsServer{
 		readBufferCount:      readBufferCount,
 		logger:               logger,
+		memory:               memory,
 		wg:                   wg,
 		muxers:               make(map[string]*HLSMuxer),
 		chPathSourceReady:    make(chan pathSourceReadyRequest),
@@ -47,6 +51,7 @@ func newHLSServer(
 		chRequest:            make(chan *hlsMuxerRequest),
 		chMuxerbyPathName:    make(chan muxerByPathNameRequest),
 		chMuxerClose:         make(chan *HLSMuxer),
+		chReinitLiveMuxer:    make(chan string),
 	}
 }
 
@@ -126,6 +131,7 @@ func (s *hlsServer) run() {
 				s.wg,
 				req.path,
 				s.muxerClose,
+				s.memory,
 			)
 
 			if err := m.start(req.tracks); err != nil {
@@ -145,11 +151,20 @@ func (s *hlsServer) run() {
 
 		case req := <-s.chRequest:
 			m, exist := s.muxers[req.path]
-			if exist {
-				m.onRequest(req)
+			if !exist {
+				req.res <- &hls.MuxerFileResponse{Status: http.StatusNotFound}
 				continue
 			}
-			req.res <- &hls.MuxerFileResponse{Status: http.StatusNotFound}
+			if strings.HasSuffix(req.file, ".m3u8") {
+				m.path.hlsDebug.lastPlaylistRequest.Store(time.Now().UnixNano())
+			}
+			if req.file == "index.m3u8" {
+				if res := s.multivariantPlaylist(m, req.req); res != nil {
+					req.res <- res
+					continue
+				}
+			}
+			m.onRequest(req)
 
 		case req := <-s.chMuxerbyPathName:
 			m, exist := s.muxers[req.pathName]
@@ -159,6 +174,11 @@ func (s *hlsServer) run() {
 			}
 			req.res <- nil
 
+		case pathName := <-s.chReinitLiveMuxer:
+			if m, exist := s.muxers[pathName]; exist {
+				m.reinitLive()
+			}
+
 		case c := <-s.chMuxerClose:
 			_, exist := s.muxers[c.path.name]
 			if exist {
@@ -168,6 +188,57 @@ func (s *hlsServer) run() {
 	}
 }
 
+// Renditions of the multivariant playlist, selected
+// with the "quality" query parameter of the playlist.
+const (
+	hlsQualityAuto = "auto"
+	hlsQualityHigh = "high"
+	hlsQualityLow  = "low"
+)
+
+// multivariantPlaylist returns the playlist of a main stream with both
+// renditions, clients choose the rendition based on the bandwidth unless
+// it's pinned by the quality parameter. Nil is returned if the playlist
+// of the muxer should be served, when there is no sub stream or the main
+// stream is pinned.
+func (s *hlsServer) multivariantPlaylist(m *HLSMuxer, r *http.Request) *hls.MuxerFileResponse {
+	quality := r.URL.Query().Get("quality")
+	switch quality {
+	case "", hlsQualityAuto, hlsQualityHigh, hlsQualityLow:
+	default:
+		return &hls.MuxerFileResponse{Status: http.StatusBadRequest}
+	}
+	if m.pathConf.IsSub || quality == hlsQualityHigh {
+		return nil
+	}
+
+	sub := s.subMuxer(m.pathConf.MonitorID)
+	if sub == nil {
+		return nil
+	}
+
+	// The playlist URIs are relative to the directory of the request.
+	subDir := "../" + sub.path.name + "/"
+	if id := hlsSessionFromContext(r.Context()); id != "" {
+		subDir = "../../" + sub.path.name + "/" + hlsSessionPrefix + id + "/"
+	}
+	subRendition := sub.rendition(subDir)
+
+	if quality == hlsQualityLow {
+		return hls.MultivariantPlaylist([]hls.Rendition{subRendition})
+	}
+	return hls.MultivariantPlaylist([]hls.Rendition{m.rendition(""), subRendition})
+}
+
+func (s *hlsServer) subMuxer(monitorID string) *HLSMuxer {
+	for _, m := range s.muxers {
+		if m.pathConf.IsSub && m.pathConf.MonitorID == monitorID {
+			return m
+		}
+	}
+	return nil
+}
+
 func (s *hlsServer) HandleRequest() http.HandlerFunc { //nolint:funlen
 	return func(w http.ResponseWriter, r *http.Request) {
 		// s.logf(log.LevelInfo, "[conn %v] %s %s", r.RemoteAddr, r.Method, r.URL.Path)
@@ -199,7 +270,8 @@ func (s *hlsServer) HandleRequest() http.HandlerFunc { //nolint:funlen
 		dir, fname := func() (string, string) {
 			if strings.HasSuffix(pa, ".ts") ||
 				strings.HasSuffix(pa, ".m3u8") ||
-				strings.HasSuffix(pa, ".mp4") {
+				strings.HasSuffix(pa, ".mp4") ||
+				strings.HasSuffix(pa, ".key") {
 				return gopath.Dir(pa), gopath.Base(pa)
 			}
 			return pa, ""
@@ -229,6 +301,13 @@ func (s *hlsServer) HandleRequest() http.HandlerFunc { //nolint:funlen
 			for k, v := range res.Header {
 				w.Header().Set(k, v)
 			}
+
+			// Range requests are required by the I-frame playlist.
+			if rs, ok := res.Body.(io.ReadSeeker); ok && res.Status == http.StatusOK {
+				http.ServeContent(w, r, fname, time.Time{}, rs)
+				return
+			}
+
 			w.WriteHeader(res.Status)
 
 			if res.Body != nil {
@@ -282,6 +361,14 @@ func (s *hlsServer) muxerClose(c *HLSMuxer) {
 	}
 }
 
+// reinitLiveMuxer is called by pathManager.
+func (s *hlsServer) reinitLiveMuxer(pathName string) {
+	select {
+	case s.chReinitLiveMuxer <- pathName:
+	case <-s.ctx.Done():
+	}
+}
+
 type muxerByPathNameRequest struct {
 	pathName string
 	res      chan *HLSMuxer
@@ -0,0 +1,116 @@
+package video
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHLSServerMultivariantPlaylist(t *testing.T) {
+	newServer := func(t *testing.T, withSub bool) *hlsServer {
+		s := &hlsServer{muxers: map[string]*HLSMuxer{}}
+		s.muxers["a"] = newTestHLSMuxerPath(t, "a", &PathConf{MonitorID: "a"})
+		if withSub {
+			s.muxers["a_sub"] = newTestHLSMuxerPath(t, "a_sub", &PathConf{
+				MonitorID:        "a",
+				IsSub:            true,
+				ExcludeLiveAudio: true,
+			})
+		}
+		// Other monitor.
+		s.muxers["b_sub"] = newTestHLSMuxerPath(t, "b_sub", &PathConf{MonitorID: "b", IsSub: true})
+		return s
+	}
+	playlist := func(t *testing.T, s *hlsServer, path string, target string) (int, string) {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if id := strings.TrimPrefix(target, "/hls/"+path+"/session-"); id != target {
+			id, _, _ = strings.Cut(id, "/")
+			r = r.WithContext(context.WithValue(r.Context(), hlsSessionKey{}, id))
+		}
+		res := s.multivariantPlaylist(s.muxers[path], r)
+		if res == nil {
+			return 0, ""
+		}
+		if res.Body == nil {
+			return res.Status, ""
+		}
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.Status, string(b)
+	}
+
+	const (
+		main = "#EXT-X-STREAM-INF:BANDWIDTH=4000000,RESOLUTION=650x450," +
+			"CODECS=\"avc1.640016,mp4a.40.2\"\nstream.m3u8\n"
+		sub = "#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=650x450," +
+			"CODECS=\"avc1.640016\"\n../a_sub/stream.m3u8\n"
+		header = "#EXTM3U\n#EXT-X-VERSION:9\n#EXT-X-INDEPENDENT-SEGMENTS\n\n"
+	)
+
+	t.Run("noSubStream", func(t *testing.T) {
+		s := newServer(t, false)
+		for _, quality := range []string{"", "auto", "high", "low"} {
+			status, _ := playlist(t, s, "a", "/hls/a/index.m3u8?quality="+quality)
+			require.Equal(t, 0, status, quality)
+		}
+	})
+	t.Run("auto", func(t *testing.T) {
+		s := newServer(t, true)
+		s.muxers["a"].path.stats.bytesRate = testRate(500000)
+		s.muxers["a_sub"].path.stats.bytesRate = testRate(100000)
+
+		status, body := playlist(t, s, "a", "/hls/a/index.m3u8")
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, header+main+sub, body)
+
+		status, body = playlist(t, s, "a", "/hls/a/index.m3u8?quality=auto")
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, header+main+sub, body)
+	})
+	t.Run("session", func(t *testing.T) {
+		s := newServer(t, true)
+		s.muxers["a"].path.stats.bytesRate = testRate(500000)
+		s.muxers["a_sub"].path.stats.bytesRate = testRate(100000)
+
+		status, body := playlist(t, s, "a", "/hls/a/session-x/index.m3u8")
+		require.Equal(t, http.StatusOK, status)
+		require.Contains(t, body, "\n../../a_sub/session-x/stream.m3u8\n")
+	})
+	t.Run("pinned", func(t *testing.T) {
+		s := newServer(t, true)
+		s.muxers["a"].path.stats.bytesRate = testRate(500000)
+		s.muxers["a_sub"].path.stats.bytesRate = testRate(100000)
+
+		// The playlist of the muxer is served.
+		status, _ := playlist(t, s, "a", "/hls/a/index.m3u8?quality=high")
+		require.Equal(t, 0, status)
+
+		status, body := playlist(t, s, "a", "/hls/a/index.m3u8?quality=low")
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, header+sub, body)
+	})
+	t.Run("subPath", func(t *testing.T) {
+		s := newServer(t, true)
+		status, _ := playlist(t, s, "a_sub", "/hls/a_sub/index.m3u8?quality=low")
+		require.Equal(t, 0, status)
+	})
+	t.Run("invalidQuality", func(t *testing.T) {
+		s := newServer(t, true)
+		status, _ := playlist(t, s, "a", "/hls/a/index.m3u8?quality=x")
+		require.Equal(t, http.StatusBadRequest, status)
+	})
+}
+
+// testRate returns a rate with the given bytes per second.
+func testRate(bytesPerSecond int64) rollingRate {
+	var r rollingRate
+	r.add(time.Unix(0, 0), 0)
+	r.add(time.Unix(1, 0), bytesPerSecond)
+	return r
+}
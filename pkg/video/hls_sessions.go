@@ -0,0 +1,264 @@
+package video
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hlsSessionPrefix is the path element prefix that carries the
+// session ID, "/hls/<path>/session-<id>/<file>".
+const hlsSessionPrefix = "session-"
+
+// Idle sessions are expired after this duration. Players
+// request the playlist at least once per segment.
+const hlsSessionTimeout = 30 * time.Second
+
+// UserFunc returns the username of the request.
+type UserFunc func(*http.Request) string
+
+// ViewerStats active viewers of a single monitor.
+type ViewerStats struct {
+	Viewers int   `json:"viewers"`
+	Bytes   int64 `json:"bytes"`
+}
+
+type hlsSession struct {
+	user       string
+	monitorID  string
+	lastAccess time.Time
+	bytes      int64
+}
+
+// hlsSessions tracks HLS viewing sessions. Sessions are minted on
+// the first playlist request and expired when idle.
+type hlsSessions struct {
+	maxPerUser int
+	timeout    time.Duration
+	now        func() time.Time
+	user       UserFunc
+
+	mu       sync.Mutex
+	sessions map[string]*hlsSession
+}
+
+func newHLSSessions(maxPerUser int) *hlsSessions {
+	return &hlsSessions{
+		maxPerUser: maxPerUser,
+		timeout:    hlsSessionTimeout,
+		now:        time.Now,
+		user:       func(*http.Request) string { return "" },
+		sessions:   make(map[string]*hlsSession),
+	}
+}
+
+// ErrTooManyViewers the user reached the max concurrent viewers.
+var ErrTooManyViewers = errors.New("too many concurrent viewers")
+
+// mint creates a new session.
+func (s *hlsSessions) mint(user string, monitorID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.unsafeExpire()
+
+	if s.maxPerUser > 0 {
+		count := 0
+		for _, session := range s.sessions {
+			if session.user == user {
+				count++
+			}
+		}
+		if count >= s.maxPerUser {
+			return "", ErrTooManyViewers
+		}
+	}
+
+	id, err := newHLSSessionID()
+	if err != nil {
+		return "", err
+	}
+	s.sessions[id] = &hlsSession{
+		user:       user,
+		monitorID:  monitorID,
+		lastAccess: s.now(),
+	}
+	return id, nil
+}
+
+// touch updates the last access time, returns false if
+// the session doesn't exist or has expired.
+func (s *hlsSessions) touch(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.unsafeExpire()
+
+	session, exist := s.sessions[id]
+	if !exist {
+		return false
+	}
+	session.lastAccess = s.now()
+	return true
+}
+
+func (s *hlsSessions) addBytes(id string, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, exist := s.sessions[id]; exist {
+		session.bytes += n
+	}
+}
+
+// viewers returns the active viewers of each monitor.
+func (s *hlsSessions) viewers() map[string]ViewerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.unsafeExpire()
+
+	stats := make(map[string]ViewerStats)
+	for _, session := range s.sessions {
+		v := stats[session.monitorID]
+		v.Viewers++
+		v.Bytes += session.bytes
+		stats[session.monitorID] = v
+	}
+	return stats
+}
+
+func (s *hlsSessions) unsafeExpire() {
+	now := s.now()
+	for id, session := range s.sessions {
+		if now.Sub(session.lastAccess) > s.timeout {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+func newHLSSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type hlsSessionKey struct{}
+
+// hlsSessionFromContext returns the session ID of
+// the request, empty if it's outside of a session.
+func hlsSessionFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(hlsSessionKey{}).(string)
+	return id
+}
+
+// splitHLSSession splits "<path>/session-<id>" into path and ID.
+func splitHLSSession(dir string) (string, string) {
+	i := strings.LastIndex(dir, "/")
+	if i == -1 {
+		return dir, ""
+	}
+	id, ok := strings.CutPrefix(dir[i+1:], hlsSessionPrefix)
+	if !ok {
+		return dir, ""
+	}
+	return dir[:i], id
+}
+
+// handle wraps the HLS handler. Playlist requests without a
+// session are redirected to a new session, requests within a
+// session are served with the session element removed. Media
+// requests without a session are rejected, the playlists only
+// reference files within the session, otherwise the segments
+// could be fetched without counting towards the viewer limit.
+func (s *hlsSessions) handle(
+	monitorID func(string) (string, bool),
+	next http.Handler,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || len(r.URL.Path) <= 5 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Remove leading prefix "/hls/"
+		pa := r.URL.Path[5:]
+		i := strings.LastIndex(pa, "/")
+		if i == -1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		dir, fname := pa[:i], pa[i+1:]
+		pathName, id := splitHLSSession(dir)
+		isPlaylist := strings.HasSuffix(fname, ".m3u8")
+
+		if id == "" {
+			if !isPlaylist {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			mID, exist := monitorID(pathName)
+			if !exist {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			id, err := s.mint(s.user(r), mID)
+			if errors.Is(err, ErrTooManyViewers) {
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			redirect(w, r, hlsSessionPrefix+id+"/"+fname)
+			return
+		}
+
+		if !s.touch(id) {
+			if isPlaylist {
+				// Start a new session.
+				redirect(w, r, "../"+fname)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		r2 := r.Clone(context.WithValue(r.Context(), hlsSessionKey{}, id))
+		r2.URL.Path = "/hls/" + pathName + "/" + fname
+		cw := &countingWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r2)
+		s.addBytes(id, cw.n)
+	})
+}
+
+// redirect redirects to a location relative to the request
+// path. http.Redirect can't be used because it resolves the
+// location to an absolute path, which breaks behind a
+// reverse proxy that serves the app from a sub path.
+func redirect(w http.ResponseWriter, r *http.Request, location string) {
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusFound)
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
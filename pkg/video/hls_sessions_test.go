@@ -0,0 +1,182 @@
+package video
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHLSSessions(maxPerUser int) (*hlsSessions, *time.Time) {
+	now := time.Unix(1, 0)
+	s := newHLSSessions(maxPerUser)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestHLSSessionsMint(t *testing.T) {
+	s, _ := newTestHLSSessions(0)
+
+	id1, err := s.mint("a", "m1")
+	require.NoError(t, err)
+	id2, err := s.mint("a", "m1")
+	require.NoError(t, err)
+	id3, err := s.mint("b", "m2")
+	require.NoError(t, err)
+
+	require.NotEqual(t, id1, id2)
+	require.Len(t, id3, 32)
+	require.True(t, s.touch(id1))
+	require.False(t, s.touch("nil"))
+
+	expected := map[string]ViewerStats{
+		"m1": {Viewers: 2},
+		"m2": {Viewers: 1},
+	}
+	require.Equal(t, expected, s.viewers())
+}
+
+func TestHLSSessionsExpiry(t *testing.T) {
+	s, now := newTestHLSSessions(0)
+
+	id1, err := s.mint("a", "m1")
+	require.NoError(t, err)
+	id2, err := s.mint("a", "m1")
+	require.NoError(t, err)
+
+	*now = now.Add(20 * time.Second)
+	require.True(t, s.touch(id1))
+
+	*now = now.Add(20 * time.Second)
+	require.True(t, s.touch(id1))
+	require.False(t, s.touch(id2))
+
+	require.Equal(t, map[string]ViewerStats{"m1": {Viewers: 1}}, s.viewers())
+
+	*now = now.Add(31 * time.Second)
+	require.Empty(t, s.viewers())
+}
+
+func TestHLSSessionsLimit(t *testing.T) {
+	s, now := newTestHLSSessions(2)
+
+	_, err := s.mint("a", "m1")
+	require.NoError(t, err)
+	_, err = s.mint("a", "m2")
+	require.NoError(t, err)
+	_, err = s.mint("a", "m1")
+	require.ErrorIs(t, err, ErrTooManyViewers)
+
+	// Other users are not affected.
+	_, err = s.mint("b", "m1")
+	require.NoError(t, err)
+
+	// Expired sessions don't count.
+	*now = now.Add(31 * time.Second)
+	_, err = s.mint("a", "m1")
+	require.NoError(t, err)
+}
+
+func TestHLSSessionsHandle(t *testing.T) {
+	s, _ := newTestHLSSessions(1)
+	s.user = func(r *http.Request) string { return r.Header.Get("user") }
+
+	monitorID := func(name string) (string, bool) {
+		if name == "m1" || name == "m1_sub" {
+			return "m1", true
+		}
+		return "", false
+	}
+
+	var paths []string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		io.WriteString(w, "abc") //nolint:errcheck
+	})
+	h := s.handle(monitorID, next)
+
+	get := func(url string, user string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		r.Header.Set("user", user)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// Mint.
+	w := get("/hls/m1_sub/index.m3u8?a=b", "u1")
+	require.Equal(t, http.StatusFound, w.Code)
+	// The location is relative, the app may be served from a sub path.
+	location := w.Header().Get("Location")
+	require.True(t, strings.HasPrefix(location, "session-"), location)
+	require.True(t, strings.HasSuffix(location, "/index.m3u8?a=b"), location)
+	require.Empty(t, paths)
+
+	// Playlist and segment within session.
+	sessionDir := "/hls/m1_sub/" + strings.TrimSuffix(location, "/index.m3u8?a=b")
+	w = get(sessionDir+"/index.m3u8", "u1")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = get(sessionDir+"/seg1.mp4", "u1")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, []string{"/hls/m1_sub/index.m3u8", "/hls/m1_sub/seg1.mp4"}, paths)
+	require.Equal(t, map[string]ViewerStats{"m1": {Viewers: 1, Bytes: 6}}, s.viewers())
+
+	// Limit.
+	w = get("/hls/m1/index.m3u8", "u1")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// Unknown path.
+	w = get("/hls/nil/index.m3u8", "u2")
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	// Expired session.
+	w = get("/hls/m1/session-nil/index.m3u8", "u2")
+	require.Equal(t, http.StatusFound, w.Code)
+	require.Equal(t, "../index.m3u8", w.Header().Get("Location"))
+	w = get("/hls/m1/session-nil/seg1.mp4", "u2")
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	// Media requests without session can't bypass the limit.
+	paths = nil
+	w = get("/hls/m1/seg2.mp4", "u1")
+	require.Equal(t, http.StatusNotFound, w.Code)
+	w = get("/hls/m1/init.mp4", "u2")
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Empty(t, paths)
+}
+
+func TestHLSSessionsRedirectSubPath(t *testing.T) {
+	s, _ := newTestHLSSessions(0)
+	monitorID := func(string) (string, bool) { return "m1", true }
+	h := s.handle(monitorID, http.NotFoundHandler())
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// A reverse proxy serves the app from "/nvr/" and strips the prefix.
+	base, err := url.Parse("https://example.com/nvr/hls/m1/index.m3u8")
+	require.NoError(t, err)
+
+	w := get("/hls/m1/index.m3u8")
+	require.Equal(t, http.StatusFound, w.Code)
+	location, err := base.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(location.Path, "/nvr/hls/m1/session-"), location.Path)
+
+	w = get("/hls/m1/session-nil/index.m3u8")
+	require.Equal(t, http.StatusFound, w.Code)
+	base, err = url.Parse("https://example.com/nvr/hls/m1/session-nil/index.m3u8")
+	require.NoError(t, err)
+	location, err = base.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, "/nvr/hls/m1/index.m3u8", location.Path)
+}
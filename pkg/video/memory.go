@@ -0,0 +1,204 @@
+package video
+
+import (
+	"context"
+	"math"
+	"nvr/pkg/log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The memory budget is enforced every memoryInterval.
+const memoryInterval = time.Second
+
+// MemoryStats in-memory buffer usage of a monitor.
+type MemoryStats struct {
+	Bytes int64 `json:"bytes"`
+
+	// Number of times the buffers were requested to
+	// shrink in order to stay within the memory budget.
+	Shrinks int64 `json:"shrinks"`
+}
+
+// memoryConsumer is a in-memory buffer, like a HLS muxer
+// or a pre-record buffer, that can free memory on request.
+type memoryConsumer struct {
+	monitorID string
+	name      string
+
+	// Must be cheap, called without locks on the packet path.
+	usage func() int64
+
+	// Requests the consumer to free n bytes, must not block.
+	shrink func(n int64)
+
+	// The consumer was requested to shrink by the previous enforcement.
+	shrinking bool
+}
+
+// memoryAccountant enforces a global memory budget by requesting the
+// largest consumers to shrink. The consumers keep their usage in
+// atomic counters and the enforcement runs on a ticker, it never
+// blocks the packet path.
+type memoryAccountant struct {
+	budget int64
+	logger log.ILogger
+
+	mu        sync.Mutex
+	consumers map[*memoryConsumer]struct{}
+	shrinks   map[string]int64
+}
+
+func newMemoryAccountant(budget int64, logger log.ILogger) *memoryAccountant {
+	return &memoryAccountant{
+		budget:    budget,
+		logger:    logger,
+		consumers: make(map[*memoryConsumer]struct{}),
+		shrinks:   make(map[string]int64),
+	}
+}
+
+// register adds a consumer and returns a function that removes it.
+func (a *memoryAccountant) register(c *memoryConsumer) func() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.consumers[c] = struct{}{}
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(a.consumers, c)
+	}
+}
+
+// shrinkRequests returns the number of bytes that each consumer should
+// free. Consumers that use more than an equal share of the budget are
+// over budget, the excess is split between them proportionally to how
+// much they are over their share.
+func shrinkRequests(budget int64, usages []int64) []int64 {
+	requests := make([]int64, len(usages))
+	if budget <= 0 || len(usages) == 0 {
+		return requests
+	}
+
+	var total int64
+	for _, usage := range usages {
+		total += usage
+	}
+	excess := total - budget
+	if excess <= 0 {
+		return requests
+	}
+
+	share := budget / int64(len(usages))
+	var totalOver int64
+	for _, usage := range usages {
+		if usage > share {
+			totalOver += usage - share
+		}
+	}
+
+	for i, usage := range usages {
+		if usage <= share {
+			continue
+		}
+		over := usage - share
+		request := math.Ceil(float64(excess) * float64(over) / float64(totalOver))
+		requests[i] = min(int64(request), over)
+	}
+	return requests
+}
+
+// enforce requests the over budget consumers to shrink.
+func (a *memoryAccountant) enforce() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.budget <= 0 {
+		return
+	}
+
+	consumers := make([]*memoryConsumer, 0, len(a.consumers))
+	for c := range a.consumers {
+		consumers = append(consumers, c)
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		if consumers[i].monitorID != consumers[j].monitorID {
+			return consumers[i].monitorID < consumers[j].monitorID
+		}
+		return consumers[i].name < consumers[j].name
+	})
+
+	usages := make([]int64, len(consumers))
+	var total int64
+	for i, c := range consumers {
+		usages[i] = c.usage()
+		total += usages[i]
+	}
+
+	for i, request := range shrinkRequests(a.budget, usages) {
+		c := consumers[i]
+		if request == 0 {
+			c.shrinking = false
+			continue
+		}
+
+		c.shrink(request)
+		a.shrinks[c.monitorID]++
+
+		// Only log when the consumer starts shrinking.
+		if !c.shrinking {
+			a.logf(c.monitorID,
+				"memory budget exceeded (%v of %v bytes), shrinking %v by %v bytes",
+				total, a.budget, c.name, request)
+		}
+		c.shrinking = true
+	}
+}
+
+func (a *memoryAccountant) logf(monitorID string, format string, v ...interface{}) {
+	msg, fields := log.Sprintf(format, v...)
+	a.logger.Log(log.Entry{
+		Level:     log.LevelWarning,
+		Src:       "monitor",
+		MonitorID: monitorID,
+		Msg:       msg,
+		Fields:    fields,
+	})
+}
+
+// stats returns the memory usage of each monitor.
+func (a *memoryAccountant) stats() map[string]MemoryStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := make(map[string]MemoryStats)
+	for c := range a.consumers {
+		s := stats[c.monitorID]
+		s.Bytes += c.usage()
+		stats[c.monitorID] = s
+	}
+	for monitorID, shrinks := range a.shrinks {
+		s := stats[monitorID]
+		s.Shrinks = shrinks
+		stats[monitorID] = s
+	}
+	return stats
+}
+
+// run enforces the budget until the context is canceled.
+func (a *memoryAccountant) run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(memoryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.enforce()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package video
+
+import (
+	"nvr/pkg/log"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShrinkRequests(t *testing.T) {
+	cases := []struct {
+		name     string
+		budget   int64
+		usages   []int64
+		expected []int64
+	}{
+		{"unlimited", 0, []int64{100, 200}, []int64{0, 0}},
+		{"noConsumers", 100, nil, []int64{}},
+		{"withinBudget", 300, []int64{100, 200}, []int64{0, 0}},
+		// Share is 100, only the second consumer is over.
+		{"single", 200, []int64{50, 250}, []int64{0, 100}},
+		// Share is 100, excess is 300 split 1:2.
+		{"proportional", 300, []int64{100, 200, 300}, []int64{0, 100, 200}},
+		// Share is 100, excess is 200 split 1:1.
+		{"equal", 200, []int64{200, 200}, []int64{100, 100}},
+		// Rounded up.
+		{"rounding", 300, []int64{150, 150, 101}, []int64{50, 50, 1}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, shrinkRequests(tc.budget, tc.usages))
+		})
+	}
+}
+
+type fakeMemoryConsumer struct {
+	usage    int64
+	requests []int64
+}
+
+func (c *fakeMemoryConsumer) register(a *memoryAccountant, monitorID string, name string) func() {
+	return a.register(&memoryConsumer{
+		monitorID: monitorID,
+		name:      name,
+		usage:     func() int64 { return c.usage },
+		shrink: func(n int64) {
+			c.requests = append(c.requests, n)
+			c.usage -= n
+		},
+	})
+}
+
+type logRecorder struct {
+	entries []log.Entry
+}
+
+func (l *logRecorder) Log(entry log.Entry) {
+	l.entries = append(l.entries, entry)
+}
+
+func TestMemoryAccountant(t *testing.T) {
+	logger := &logRecorder{}
+	a := newMemoryAccountant(1000, logger)
+
+	small := &fakeMemoryConsumer{usage: 100}
+	medium := &fakeMemoryConsumer{usage: 600}
+	large := &fakeMemoryConsumer{usage: 900}
+	small.register(a, "a", "HLS main")
+	medium.register(a, "b", "HLS main")
+	unregisterLarge := large.register(a, "b", "HLS sub")
+
+	// Share is 333, excess is 600, medium is 267 over and large is 567 over.
+	a.enforce()
+	require.Empty(t, small.requests)
+	require.Equal(t, []int64{193}, medium.requests)
+	require.Equal(t, []int64{408}, large.requests)
+
+	expectedStats := map[string]MemoryStats{
+		"a": {Bytes: 100},
+		"b": {Bytes: 899, Shrinks: 2},
+	}
+	require.Equal(t, expectedStats, a.stats())
+
+	require.Len(t, logger.entries, 2)
+	require.Equal(t, log.LevelWarning, logger.entries[0].Level)
+	require.Equal(t, "b", logger.entries[0].MonitorID)
+	require.Equal(t,
+		"memory budget exceeded (1600 of 1000 bytes), shrinking HLS main by 193 bytes",
+		logger.entries[0].Msg,
+	)
+
+	// Still over budget, only logged when the shrinking starts.
+	large.usage += 100
+	a.enforce()
+	require.Len(t, large.requests, 2)
+	require.Len(t, logger.entries, 2)
+
+	// Within budget.
+	unregisterLarge()
+	a.enforce()
+	require.Len(t, medium.requests, 2)
+	require.Len(t, large.requests, 2)
+	require.Equal(t, int64(4), a.stats()["b"].Shrinks)
+}
+
+func TestMemoryAccountantUnlimited(t *testing.T) {
+	a := newMemoryAccountant(0, &logRecorder{})
+	c := &fakeMemoryConsumer{usage: 1 << 40}
+	c.register(a, "a", "HLS main")
+	a.enforce()
+	require.Empty(t, c.requests)
+}
@@ -282,17 +282,23 @@ const (
 // TypeFree BoxType.
 func TypeFree() BoxType { return [4]byte{'f', 'r', 'e', 'e'} }
 
-// Free is ISOBMFF free box type.
-type Free struct{}
+// Free is ISOBMFF free box type. The data is ignored by readers,
+// the box is used as padding and to reserve space.
+type Free struct {
+	Data []byte
+}
 
 // Type returns the BoxType.
 func (*Free) Type() BoxType { return TypeFree() }
 
 // Size returns the marshaled size in bytes.
-func (*Free) Size() int { return 0 }
+func (b *Free) Size() int { return len(b.Data) }
 
-// Marshal is never called.
-func (*Free) Marshal(_ *bitio.Writer) error { return nil }
+// Marshal box to writer.
+func (b *Free) Marshal(w *bitio.Writer) error {
+	w.TryWrite(b.Data)
+	return w.TryError
+}
 
 /*************************** ftyp ****************************/
 
@@ -899,6 +905,86 @@ func (b *AvcC) Marshal(w *bitio.Writer) error {
 	return w.TryError
 }
 
+/*************************** sidx ****************************/
+
+// TypeSidx BoxType.
+func TypeSidx() BoxType { return [4]byte{'s', 'i', 'd', 'x'} }
+
+// Sidx is ISOBMFF sidx box type.
+type Sidx struct {
+	FullBox
+	ReferenceID                uint32
+	Timescale                  uint32
+	EarliestPresentationTimeV0 uint32
+	FirstOffsetV0              uint32
+	EarliestPresentationTimeV1 uint64
+	FirstOffsetV1              uint64
+	References                 []SidxReference
+}
+
+// SidxReference .
+type SidxReference struct {
+	ReferenceType      bool   // 1 bit.
+	ReferencedSize     uint32 // 31 bits.
+	SubsegmentDuration uint32
+	StartsWithSAP      bool   // 1 bit.
+	SAPType            uint8  // 3 bits.
+	SAPDeltaTime       uint32 // 28 bits.
+}
+
+// Type returns the BoxType.
+func (*Sidx) Type() BoxType { return TypeSidx() }
+
+// Size returns the marshaled size in bytes.
+func (b *Sidx) Size() int {
+	total := b.FullBox.FieldSize() + 8
+	if b.FullBox.Version == 0 {
+		total += 8
+	} else {
+		total += 16
+	}
+	return total + 4 + len(b.References)*12
+}
+
+// Marshal box to writer.
+func (b *Sidx) Marshal(w *bitio.Writer) error {
+	err := b.FullBox.MarshalField(w)
+	if err != nil {
+		return err
+	}
+	w.TryWriteUint32(b.ReferenceID)
+	w.TryWriteUint32(b.Timescale)
+	if b.FullBox.Version == 0 {
+		w.TryWriteUint32(b.EarliestPresentationTimeV0)
+		w.TryWriteUint32(b.FirstOffsetV0)
+	} else {
+		w.TryWriteUint64(b.EarliestPresentationTimeV1)
+		w.TryWriteUint64(b.FirstOffsetV1)
+	}
+	w.TryWriteUint16(0) // Reserved.
+	w.TryWriteUint16(uint16(len(b.References)))
+	for _, ref := range b.References {
+		size := ref.ReferencedSize & 0x7fffffff
+		if ref.ReferenceType {
+			size |= 1 << 31
+		}
+		w.TryWriteUint32(size)
+		w.TryWriteUint32(ref.SubsegmentDuration)
+
+		sap := uint32(ref.SAPType&0x7)<<28 | ref.SAPDeltaTime&0x0fffffff
+		if ref.StartsWithSAP {
+			sap |= 1 << 31
+		}
+		w.TryWriteUint32(sap)
+	}
+	return w.TryError
+}
+
+/*************************** skip ****************************/
+
+// TypeSkip BoxType. Skip boxes are free boxes with another name.
+func TypeSkip() BoxType { return [4]byte{'s', 'k', 'i', 'p'} }
+
 /*************************** smhd ****************************/
 
 // TypeSmhd BoxType.
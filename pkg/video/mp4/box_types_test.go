@@ -202,6 +202,11 @@ func TestBoxTypes(t *testing.T) {
 				0x00, 0x00, 0x01, // flags
 			},
 		},
+		{
+			name: "free",
+			src:  &Free{Data: []byte{0x00, 0x00, 0x00, 0x00}},
+			bin:  []byte{0x00, 0x00, 0x00, 0x00},
+		},
 		{
 			name: "ftyp",
 			src: &Ftyp{
@@ -669,6 +674,76 @@ func TestBoxTypes(t *testing.T) {
 			},
 		},
 
+		{
+			name: "sidx: version 0",
+			src: &Sidx{
+				FullBox: FullBox{
+					Version: 0,
+					Flags:   [3]byte{0x00, 0x00, 0x00},
+				},
+				ReferenceID:                0x01234567,
+				Timescale:                  0x23456789,
+				EarliestPresentationTimeV0: 0x456789ab,
+				FirstOffsetV0:              0x6789abcd,
+				References: []SidxReference{
+					{
+						ReferenceType:      false,
+						ReferencedSize:     0x01234567,
+						SubsegmentDuration: 0x23456789,
+						StartsWithSAP:      true,
+						SAPType:            1,
+						SAPDeltaTime:       0x00000000,
+					},
+					{
+						ReferenceType:      true,
+						ReferencedSize:     0x01234567,
+						SubsegmentDuration: 0x456789ab,
+						StartsWithSAP:      false,
+						SAPType:            0,
+						SAPDeltaTime:       0x0789abcd,
+					},
+				},
+			},
+			bin: []byte{
+				0,                // version
+				0x00, 0x00, 0x00, // flags
+				0x01, 0x23, 0x45, 0x67, // reference ID
+				0x23, 0x45, 0x67, 0x89, // timescale
+				0x45, 0x67, 0x89, 0xab, // earliest presentation time
+				0x67, 0x89, 0xab, 0xcd, // first offset
+				0x00, 0x00, // reserved
+				0x00, 0x02, // reference count
+				0x01, 0x23, 0x45, 0x67, // reference type, referenced size
+				0x23, 0x45, 0x67, 0x89, // subsegment duration
+				0x90, 0x00, 0x00, 0x00, // starts with SAP, SAP type, SAP delta time
+				0x81, 0x23, 0x45, 0x67, // reference type, referenced size
+				0x45, 0x67, 0x89, 0xab, // subsegment duration
+				0x07, 0x89, 0xab, 0xcd, // starts with SAP, SAP type, SAP delta time
+			},
+		},
+		{
+			name: "sidx: version 1",
+			src: &Sidx{
+				FullBox: FullBox{
+					Version: 1,
+					Flags:   [3]byte{0x00, 0x00, 0x00},
+				},
+				ReferenceID:                0x01234567,
+				Timescale:                  0x23456789,
+				EarliestPresentationTimeV1: 0x0123456789abcdef,
+				FirstOffsetV1:              0x89abcdef01234567,
+			},
+			bin: []byte{
+				1,                // version
+				0x00, 0x00, 0x00, // flags
+				0x01, 0x23, 0x45, 0x67, // reference ID
+				0x23, 0x45, 0x67, 0x89, // timescale
+				0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, // earliest presentation time
+				0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, // first offset
+				0x00, 0x00, // reserved
+				0x00, 0x00, // reference count
+			},
+		},
 		{
 			name: "smhd",
 			src: &Smhd{
@@ -0,0 +1,94 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BoxInfo is the header of a box.
+type BoxInfo struct {
+	// Offset from the start of the file.
+	Offset int64
+
+	// Size including the header.
+	Size       int64
+	HeaderSize int64
+	Type       BoxType
+}
+
+// End returns the offset of the first byte after the box.
+func (b BoxInfo) End() int64 {
+	return b.Offset + b.Size
+}
+
+// IsFree returns true for free and skip boxes, readers ignore their content.
+func (b BoxInfo) IsFree() bool {
+	return b.Type == TypeFree() || b.Type == TypeSkip()
+}
+
+// Box reader errors.
+var (
+	ErrBoxTooSmall = errors.New("box is smaller than its header")
+	ErrBoxTooLarge = errors.New("box extends past the end of the file")
+)
+
+// ReadBoxInfo reads the header of the box at offset. Boxes with the size zero
+// extend to the end of the file. Large boxes use a 64 bit size.
+func ReadBoxInfo(r io.ReaderAt, offset int64, fileSize int64) (BoxInfo, error) {
+	buf := make([]byte, 16)
+	if _, err := r.ReadAt(buf[:8], offset); err != nil {
+		return BoxInfo{}, fmt.Errorf("read header: %w", err)
+	}
+
+	info := BoxInfo{
+		Offset:     offset,
+		Size:       int64(binary.BigEndian.Uint32(buf[:4])),
+		HeaderSize: 8,
+	}
+	copy(info.Type[:], buf[4:8])
+
+	switch info.Size {
+	case 0:
+		info.Size = fileSize - offset
+	case 1:
+		if _, err := r.ReadAt(buf[8:16], offset+8); err != nil {
+			return BoxInfo{}, fmt.Errorf("read large size: %w", err)
+		}
+		info.Size = int64(binary.BigEndian.Uint64(buf[8:16]))
+		info.HeaderSize = 16
+	}
+
+	if info.Size < info.HeaderSize {
+		return BoxInfo{}, fmt.Errorf("%w: %q %d", ErrBoxTooSmall, info.Type, info.Size)
+	}
+	if info.End() > fileSize {
+		return BoxInfo{}, fmt.Errorf("%w: %q %d", ErrBoxTooLarge, info.Type, info.Size)
+	}
+	return info, nil
+}
+
+// ReadBoxInfos reads the headers of the top level boxes, including free boxes.
+func ReadBoxInfos(r io.ReaderAt, fileSize int64) ([]BoxInfo, error) {
+	var infos []BoxInfo
+	for offset := int64(0); offset < fileSize; {
+		info, err := ReadBoxInfo(r, offset, fileSize)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+		offset = info.End()
+	}
+	return infos, nil
+}
+
+// FindBox returns the first box of the type.
+func FindBox(infos []BoxInfo, typ BoxType) (BoxInfo, bool) {
+	for _, info := range infos {
+		if info.Type == typ {
+			return info, true
+		}
+	}
+	return BoxInfo{}, false
+}
@@ -0,0 +1,85 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testBox(typ string, payloadSize int) []byte {
+	buf := make([]byte, 8+payloadSize)
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(buf)))
+	copy(buf[4:8], typ)
+	return buf
+}
+
+func TestReadBoxInfos(t *testing.T) {
+	t.Run("freeBoxes", func(t *testing.T) {
+		var file []byte
+		file = append(file, testBox("free", 0)...)
+		file = append(file, testBox("ftyp", 4)...)
+		file = append(file, testBox("skip", 2)...)
+		file = append(file, testBox("free", 10)...)
+		file = append(file, testBox("mdat", 6)...)
+		file = append(file, testBox("free", 1)...)
+
+		infos, err := ReadBoxInfos(bytes.NewReader(file), int64(len(file)))
+		require.NoError(t, err)
+
+		expected := []BoxInfo{
+			{Offset: 0, Size: 8, HeaderSize: 8, Type: TypeFree()},
+			{Offset: 8, Size: 12, HeaderSize: 8, Type: TypeFtyp()},
+			{Offset: 20, Size: 10, HeaderSize: 8, Type: TypeSkip()},
+			{Offset: 30, Size: 18, HeaderSize: 8, Type: TypeFree()},
+			{Offset: 48, Size: 14, HeaderSize: 8, Type: TypeMdat()},
+			{Offset: 62, Size: 9, HeaderSize: 8, Type: TypeFree()},
+		}
+		require.Equal(t, expected, infos)
+
+		for i, info := range infos {
+			require.Equal(t, info.Type != TypeFtyp() && info.Type != TypeMdat(), info.IsFree(), i)
+		}
+
+		mdat, exist := FindBox(infos, TypeMdat())
+		require.True(t, exist)
+		require.Equal(t, int64(62), mdat.End())
+
+		_, exist = FindBox(infos, TypeMoov())
+		require.False(t, exist)
+	})
+	t.Run("sizeZero", func(t *testing.T) {
+		file := testBox("ftyp", 4)
+		mdat := testBox("mdat", 20)
+		binary.BigEndian.PutUint32(mdat[:4], 0)
+		file = append(file, mdat...)
+
+		infos, err := ReadBoxInfos(bytes.NewReader(file), int64(len(file)))
+		require.NoError(t, err)
+		require.Len(t, infos, 2)
+		require.Equal(t, BoxInfo{Offset: 12, Size: 28, HeaderSize: 8, Type: TypeMdat()}, infos[1])
+	})
+	t.Run("largeSize", func(t *testing.T) {
+		file := testBox("mdat", 12)
+		binary.BigEndian.PutUint32(file[:4], 1)
+		binary.BigEndian.PutUint64(file[8:16], 20)
+
+		infos, err := ReadBoxInfos(bytes.NewReader(file), int64(len(file)))
+		require.NoError(t, err)
+		require.Equal(t, []BoxInfo{{Size: 20, HeaderSize: 16, Type: TypeMdat()}}, infos)
+	})
+	t.Run("tooSmall", func(t *testing.T) {
+		file := testBox("free", 0)
+		binary.BigEndian.PutUint32(file[:4], 4)
+
+		_, err := ReadBoxInfos(bytes.NewReader(file), int64(len(file)))
+		require.ErrorIs(t, err, ErrBoxTooSmall)
+	})
+	t.Run("tooLarge", func(t *testing.T) {
+		file := testBox("free", 8)
+
+		_, err := ReadBoxInfos(bytes.NewReader(file), int64(len(file)-1))
+		require.ErrorIs(t, err, ErrBoxTooLarge)
+	})
+}
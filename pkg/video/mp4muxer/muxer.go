@@ -19,6 +19,14 @@ type muxer struct {
 	audioTrack  *gortsplib.TrackMPEG4Audio
 	audioConfig []byte
 
+	// Timescales are the track clock rates.
+	videoTimescale int64
+	audioTimescale int64
+
+	// Progressive files have no sidx box and no fragment index.
+	progressive bool
+	ftypSize    int
+
 	startTime int64
 	endTime   int64
 
@@ -40,50 +48,158 @@ type muxer struct {
 
 	prevChunkVideo bool
 	prevChunkAudio bool
+
+	fragments []fragment
+}
+
+// fragment is a range of samples starting at a video sync sample.
+type fragment struct {
+	mdatPos      uint32
+	duration     int64 // Video timescale.
+	isSyncSample bool
+}
+
+// Fragment is a keyframe aligned byte range of the video.
+type Fragment struct {
+	// Offset from the start of the file.
+	Offset int64
+	Size   int64
+
+	// Start offset from the start of the video.
+	Start    time.Duration
+	Duration time.Duration
+}
+
+// Index of fragments in presentation order.
+type Index []Fragment
+
+// Find returns the fragment that contains the timestamp.
+// The last fragment is returned if the timestamp is past
+// the end and false is returned if the index is empty.
+func (index Index) Find(t time.Duration) (Fragment, bool) {
+	if len(index) == 0 {
+		return Fragment{}, false
+	}
+	for _, f := range index {
+		if t < f.Start+f.Duration {
+			return f, true
+		}
+	}
+	return index[len(index)-1], true
 }
 
-// GenerateMP4 generates mp4 from samples.
+// GenerateMP4 generates mp4 metadata from samples. Returns the mdat
+// size and a fragment index that is also written as a sidx box.
 func GenerateMP4(
 	out io.Writer,
 	startTime int64,
 	samples []customformat.Sample,
 	videoTrack *gortsplib.TrackH264,
 	audioTrack *gortsplib.TrackMPEG4Audio,
+) (int64, Index, error) {
+	return generateMP4(out, startTime, samples, videoTrack, audioTrack, iso4Ftyp(), false)
+}
+
+func iso4Ftyp() *mp4.Ftyp {
+	return &mp4.Ftyp{
+		MajorBrand:   [4]byte{'i', 's', 'o', '4'},
+		MinorVersion: 512,
+		CompatibleBrands: []mp4.CompatibleBrandElem{
+			{CompatibleBrand: [4]byte{'i', 's', 'o', '4'}},
+		},
+	}
+}
+
+// GenerateProgressiveMP4 generates the same metadata as GenerateMP4 without
+// the sidx box and with the common isom brands. Some importers reject files
+// with a segment index because it's usually found in fragmented files.
+// The sample tables and the mdat are identical. Returns the mdat size,
+// progressive files are downloaded whole and have no fragment index.
+func GenerateProgressiveMP4(
+	out io.Writer,
+	startTime int64,
+	samples []customformat.Sample,
+	videoTrack *gortsplib.TrackH264,
+	audioTrack *gortsplib.TrackMPEG4Audio,
 ) (int64, error) {
+	ftyp := &mp4.Ftyp{
+		MajorBrand:   [4]byte{'i', 's', 'o', 'm'},
+		MinorVersion: 512,
+		CompatibleBrands: []mp4.CompatibleBrandElem{
+			{CompatibleBrand: [4]byte{'i', 's', 'o', 'm'}},
+			{CompatibleBrand: [4]byte{'i', 's', 'o', '2'}},
+			{CompatibleBrand: [4]byte{'a', 'v', 'c', '1'}},
+			{CompatibleBrand: [4]byte{'m', 'p', '4', '1'}},
+		},
+	}
+	mdatSize, _, err := generateMP4(out, startTime, samples, videoTrack, audioTrack, ftyp, true)
+	return mdatSize, err
+}
+
+func generateMP4(
+	out io.Writer,
+	startTime int64,
+	samples []customformat.Sample,
+	videoTrack *gortsplib.TrackH264,
+	audioTrack *gortsplib.TrackMPEG4Audio,
+	ftyp *mp4.Ftyp,
+	progressive bool,
+) (int64, Index, error) {
+	m, err := newMuxer(out, startTime, videoTrack, audioTrack, progressive)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	m.ftypSize, err = mp4.WriteSingleBox(m.out, ftyp)
+	if err != nil {
+		return 0, nil, fmt.Errorf("write ftyp: %w", err)
+	}
+
+	m.writeSamples(samples)
+
+	index, err := m.writeMetadata()
+	if err != nil {
+		return 0, nil, fmt.Errorf("write metadata: %w", err)
+	}
+	return int64(m.mdatPos), index, nil
+}
+
+func newMuxer(
+	out io.Writer,
+	startTime int64,
+	videoTrack *gortsplib.TrackH264,
+	audioTrack *gortsplib.TrackMPEG4Audio,
+	progressive bool,
+) (*muxer, error) {
 	bw := bitio.NewByteWriter(out)
 	m := &muxer{
 		out:        bitio.NewWriter(bw),
 		videoTrack: videoTrack,
 		audioTrack: audioTrack,
 
+		videoTimescale: int64(videoTrack.ClockRate()),
+
+		progressive: progressive,
 		startTime:   startTime,
 		firstSample: true,
 	}
 
 	err := m.videoSPSP.Unmarshal(videoTrack.SPS)
 	if err != nil {
-		return 0, fmt.Errorf("unmarshal video spsp: %w", err)
+		return nil, fmt.Errorf("unmarshal video spsp: %w", err)
 	}
 
 	if audioTrack != nil {
+		m.audioTimescale = int64(audioTrack.ClockRate())
 		m.audioConfig, err = audioTrack.Config.Marshal()
 		if err != nil {
-			return 0, fmt.Errorf("marshal audio config: %w", err)
+			return nil, fmt.Errorf("marshal audio config: %w", err)
 		}
 	}
+	return m, nil
+}
 
-	ftyp := &mp4.Ftyp{
-		MajorBrand:   [4]byte{'i', 's', 'o', '4'},
-		MinorVersion: 512,
-		CompatibleBrands: []mp4.CompatibleBrandElem{
-			{CompatibleBrand: [4]byte{'i', 's', 'o', '4'}},
-		},
-	}
-	_, err = mp4.WriteSingleBox(m.out, ftyp)
-	if err != nil {
-		return 0, fmt.Errorf("write ftyp: %w", err)
-	}
-
+func (m *muxer) writeSamples(samples []customformat.Sample) {
 	for _, sample := range samples {
 		if sample.IsAudioSample {
 			m.writeAudioSample(sample)
@@ -91,15 +207,14 @@ func GenerateMP4(
 			m.writeVideoSample(sample)
 		}
 	}
-
-	if err := m.writeMetadata(); err != nil {
-		return 0, fmt.Errorf("write metadata: %w", err)
-	}
-	return int64(m.mdatPos), nil
 }
 
 func (m *muxer) writeVideoSample(sample customformat.Sample) {
-	delta := hls.NanoToTimescale(sample.Next-sample.DTS, hls.VideoTimescale)
+	delta := m.timescaleDelta(sample.DTS, sample.Next, m.videoTimescale)
+	if !m.progressive {
+		m.indexVideoSample(sample, delta)
+	}
+
 	if len(m.videoStts) > 0 && m.videoStts[len(m.videoStts)-1].SampleDelta == uint32(delta) {
 		m.videoStts[len(m.videoStts)-1].SampleCount++
 	} else {
@@ -109,8 +224,8 @@ func (m *muxer) writeVideoSample(sample customformat.Sample) {
 		})
 	}
 
-	pts := hls.NanoToTimescale(sample.PTS-m.startTime, hls.VideoTimescale)
-	dts := hls.NanoToTimescale(sample.DTS-m.startTime, hls.VideoTimescale)
+	pts := hls.NanoToTimescale(sample.PTS-m.startTime, m.videoTimescale)
+	dts := hls.NanoToTimescale(sample.DTS-m.startTime, m.videoTimescale)
 
 	if m.firstSample {
 		m.dtsShift = pts - dts
@@ -150,8 +265,23 @@ func (m *muxer) writeVideoSample(sample customformat.Sample) {
 	m.endTime = sample.Next
 }
 
+// indexVideoSample starts a new fragment at each sync sample.
+func (m *muxer) indexVideoSample(sample customformat.Sample, delta int64) {
+	if sample.IsSyncSample || len(m.fragments) == 0 {
+		m.fragments = append(m.fragments, fragment{
+			mdatPos:      m.mdatPos,
+			isSyncSample: sample.IsSyncSample,
+		})
+	}
+	m.fragments[len(m.fragments)-1].duration += delta
+}
+
 func (m *muxer) writeAudioSample(sample customformat.Sample) {
-	delta := hls.NanoToTimescale(sample.Next-sample.PTS, int64(m.audioTrack.ClockRate()))
+	if !m.progressive && len(m.fragments) == 0 {
+		m.fragments = append(m.fragments, fragment{mdatPos: m.mdatPos})
+	}
+
+	delta := m.timescaleDelta(sample.PTS, sample.Next, m.audioTimescale)
 	if len(m.audioStts) > 0 && m.audioStts[len(m.audioStts)-1].SampleDelta == uint32(delta) {
 		m.audioStts[len(m.audioStts)-1].SampleCount++
 	} else {
@@ -178,17 +308,60 @@ func (m *muxer) writeAudioSample(sample customformat.Sample) {
 	m.audioStsz = append(m.audioStsz, sample.Size)
 }
 
-func (m *muxer) writeMetadata() error {
+// timescaleDelta converts the start and end times before subtracting
+// them, the rounding errors of consecutive samples cancel out.
+func (m *muxer) timescaleDelta(start int64, end int64, timescale int64) int64 {
+	return hls.NanoToTimescale(end-m.startTime, timescale) -
+		hls.NanoToTimescale(start-m.startTime, timescale)
+}
+
+func (m *muxer) writeMetadata() (Index, error) {
 	/*
 	   moov
 	   - mvhd
 	   - trak (video)
 	   - trak (audio)
+	   sidx (not progressive)
 	*/
 
+	moov := m.generateMoov()
+
+	mdatOffset := uint32(m.ftypSize + moov.Size() + mdatHeaderSize)
+
+	var sidx mp4.Boxes
+	if !m.progressive {
+		sidx = m.generateSidx()
+		mdatOffset += uint32(sidx.Size())
+	}
+	m.offsetChunks(mdatOffset)
+
+	if err := moov.Marshal(m.out); err != nil {
+		return nil, fmt.Errorf("marshal moov: %w", err)
+	}
+	if !m.progressive {
+		if err := sidx.Marshal(m.out); err != nil {
+			return nil, fmt.Errorf("marshal sidx: %w", err)
+		}
+	}
+
+	m.out.TryWriteUint32(8 + m.mdatPos)
+	m.out.TryWrite([]byte{'m', 'd', 'a', 't'})
+	if m.out.TryError != nil {
+		return nil, m.out.TryError
+	}
+
+	if m.progressive {
+		return nil, nil
+	}
+	return m.generateIndex(mdatOffset), nil
+}
+
+const mdatHeaderSize = 8
+
+func (m *muxer) generateMoov() mp4.Boxes {
 	duration := time.Duration(m.endTime - m.startTime)
 
-	moov := mp4.Boxes{
+	return mp4.Boxes{
 		Box: &mp4.Moov{},
 		Children: []mp4.Boxes{
 			{Box: &mp4.Mvhd{
@@ -203,24 +376,67 @@ func (m *muxer) writeMetadata() error {
 			m.generateAudioTrak(duration),
 		},
 	}
+}
 
-	const ftypSize = 20
-	const mdatHeaderSize = 8
-	mdatOffset := uint32(ftypSize + moov.Size() + mdatHeaderSize)
+// offsetChunks converts the chunk offsets from
+// mdat payload offsets into file offsets.
+func (m *muxer) offsetChunks(mdatOffset uint32) {
 	for i := 0; i < len(m.videoStco); i++ {
 		m.videoStco[i] += mdatOffset
 	}
 	for i := 0; i < len(m.audioStco); i++ {
 		m.audioStco[i] += mdatOffset
 	}
+}
 
-	if err := moov.Marshal(m.out); err != nil {
-		return fmt.Errorf("marshal moov: %w", err)
+// generateSidx indexes the mdat payload, the
+// first reference starts after the mdat header.
+func (m *muxer) generateSidx() mp4.Boxes {
+	refs := make([]mp4.SidxReference, len(m.fragments))
+	for i, f := range m.fragments {
+		refs[i] = mp4.SidxReference{
+			ReferencedSize:     m.fragmentSize(i),
+			SubsegmentDuration: uint32(f.duration),
+			StartsWithSAP:      f.isSyncSample,
+		}
+		if f.isSyncSample {
+			refs[i].SAPType = 1
+		}
 	}
+	return mp4.Boxes{Box: &mp4.Sidx{
+		ReferenceID:   hls.VideoTrackID,
+		Timescale:     uint32(m.videoTimescale),
+		FirstOffsetV0: 8,
+		References:    refs,
+	}}
+}
 
-	m.out.TryWriteUint32(8 + m.mdatPos)
-	m.out.TryWrite([]byte{'m', 'd', 'a', 't'})
-	return m.out.TryError
+func (m *muxer) generateIndex(mdatOffset uint32) Index {
+	index := make(Index, len(m.fragments))
+	var start int64
+	for i, f := range m.fragments {
+		index[i] = Fragment{
+			Offset:   int64(mdatOffset + f.mdatPos),
+			Size:     int64(m.fragmentSize(i)),
+			Start:    timescaleToDuration(start, m.videoTimescale),
+			Duration: timescaleToDuration(f.duration, m.videoTimescale),
+		}
+		start += f.duration
+	}
+	return index
+}
+
+func (m *muxer) fragmentSize(i int) uint32 {
+	if i == len(m.fragments)-1 {
+		return m.mdatPos - m.fragments[i].mdatPos
+	}
+	return m.fragments[i+1].mdatPos - m.fragments[i].mdatPos
+}
+
+func timescaleToDuration(v int64, timescale int64) time.Duration {
+	secs := v / timescale
+	dec := v % timescale
+	return time.Duration(secs*int64(time.Second) + dec*int64(time.Second)/timescale)
 }
 
 func (m *muxer) generateVideoTrak(duration time.Duration) mp4.Boxes {
@@ -250,12 +466,12 @@ func (m *muxer) generateVideoTrak(duration time.Duration) mp4.Boxes {
 				Box: &mp4.Mdia{},
 				Children: []mp4.Boxes{
 					{Box: &mp4.Mdhd{
-						Timescale: hls.VideoTimescale, // the number of time units that pass per second
+						Timescale: uint32(m.videoTimescale), // the number of time units that pass per second
 						Language:  [3]byte{'u', 'n', 'd'},
 						DurationV0: uint32(
 							hls.NanoToTimescale(
 								int64(duration),
-								hls.VideoTimescale,
+								m.videoTimescale,
 							)),
 					}},
 					{Box: &mp4.Hdlr{
@@ -419,10 +635,10 @@ func (m *muxer) generateAudioTrak(duration time.Duration) mp4.Boxes {
 				Box: &mp4.Mdia{},
 				Children: []mp4.Boxes{
 					{Box: &mp4.Mdhd{
-						Timescale: uint32(m.audioTrack.ClockRate()),
+						Timescale: uint32(m.audioTimescale),
 						Language:  [3]byte{'u', 'n', 'd'},
 						DurationV0: uint32(
-							hls.NanoToTimescale(int64(duration), int64(m.audioTrack.ClockRate()))),
+							hls.NanoToTimescale(int64(duration), m.audioTimescale)),
 					}},
 					{Box: &mp4.Hdlr{
 						HandlerType: [4]byte{'s', 'o', 'u', 'n'},
@@ -484,7 +700,7 @@ func (m *muxer) generateAudioMinf() mp4.Boxes { //nolint:funlen
 									},
 									ChannelCount: uint16(m.audioTrack.Config.ChannelCount),
 									SampleSize:   16,
-									SampleRate:   uint32(m.audioTrack.ClockRate() * 65536),
+									SampleRate:   hls.Mp4aSampleRate(m.audioTrack.ClockRate()),
 								},
 								Children: []mp4.Boxes{
 									{Box: &myEsds{
@@ -2,12 +2,15 @@ package mp4muxer
 
 import (
 	"bytes"
+	"encoding/binary"
 	"testing"
+	"time"
 
 	"nvr/pkg/video/customformat"
 	"nvr/pkg/video/gortsplib"
 	"nvr/pkg/video/gortsplib/pkg/h264"
 	"nvr/pkg/video/gortsplib/pkg/mpeg4audio"
+	"nvr/pkg/video/mp4"
 
 	"github.com/stretchr/testify/require"
 )
@@ -67,10 +70,16 @@ func TestGenerateMP4(t *testing.T) {
 	}
 
 	startTime := int64(10000)
-	mdatSize, err := GenerateMP4(buf, startTime, samples, videoTrack, audioTrack)
+	mdatSize, index, err := GenerateMP4(buf, startTime, samples, videoTrack, audioTrack)
 	require.NoError(t, err)
 	require.Equal(t, int64(10), mdatSize)
 
+	expectedIndex := Index{
+		{Offset: 0x4cd, Size: 4, Duration: 200000},
+		{Offset: 0x4d1, Size: 6, Start: 200000, Duration: 100000},
+	}
+	require.Equal(t, expectedIndex, index)
+
 	expected := []byte{
 		0, 0, 0, 0x14, 'f', 't', 'y', 'p',
 		'i', 's', 'o', '4',
@@ -203,7 +212,7 @@ func TestGenerateMP4(t *testing.T) {
 		0, 0, 0, 1, // Entry2 sample count.
 		0, 0, 0, 0x12, // Entry2 sample offset
 		0, 0, 0, 1, // Entry3 sample count.
-		0, 0, 0, 0xd, // Entry3 sample offset
+		0, 0, 0, 0xe, // Entry3 sample offset
 		0, 0, 0, 0x1c, 's', 't', 's', 'c',
 		0, 0, 0, 0, // FullBox.
 		0, 0, 0, 1, // Entry count.
@@ -220,7 +229,7 @@ func TestGenerateMP4(t *testing.T) {
 		0, 0, 0, 0x14, 's', 't', 'c', 'o',
 		0, 0, 0, 0, // FullBox.
 		0, 0, 0, 1, // Entry count.
-		0, 0, 4, 0x95, // Chunk offset1.
+		0, 0, 4, 0xcd, // Chunk offset1.
 
 		/* Audio trak */
 		0, 0, 1, 0xcc, 't', 'r', 'a', 'k',
@@ -301,7 +310,7 @@ func TestGenerateMP4(t *testing.T) {
 		0, 0, 0, 0, // FullBox.
 		0, 0, 0, 1, // Entry count.
 		0, 0, 0, 2, // Entry1 sample count.
-		0, 0, 0, 4, // Entry1 sample delta.
+		0, 0, 0, 5, // Entry1 sample delta.
 		0, 0, 0, 0x1c, 's', 't', 's', 'c',
 		0, 0, 0, 0, // FullBox.
 		0, 0, 0, 1, // Entry count.
@@ -317,9 +326,251 @@ func TestGenerateMP4(t *testing.T) {
 		0, 0, 0, 0x14, 's', 't', 'c', 'o',
 		0, 0, 0, 0, // FullBox.
 		0, 0, 0, 1, // Entry count.
-		0, 0, 4, 0x9b, // Chunk offset1.
+		0, 0, 4, 0xd3, // Chunk offset1.
+
+		0, 0, 0, 0x38, 's', 'i', 'd', 'x',
+		0, 0, 0, 0, // FullBox.
+		0, 0, 0, 1, // Reference ID.
+		0, 1, 0x5f, 0x90, // Timescale.
+		0, 0, 0, 0, // Earliest presentation time.
+		0, 0, 0, 8, // First offset.
+		0, 0, // Reserved.
+		0, 2, // Reference count.
+		0, 0, 0, 4, // Reference1 size.
+		0, 0, 0, 0x12, // Reference1 duration.
+		0, 0, 0, 0, // Reference1 SAP.
+		0, 0, 0, 6, // Reference2 size.
+		0, 0, 0, 9, // Reference2 duration.
+		0x90, 0, 0, 0, // Reference2 SAP.
 
 		0, 0, 0, 0x12, 'm', 'd', 'a', 't',
 	}
 	require.Equal(t, expected, buf.Bytes())
 }
+
+func TestGenerateMP4ClockRates(t *testing.T) {
+	sps := []byte{
+		103, 100, 0, 22, 172, 217, 64, 164,
+		59, 228, 136, 192, 68, 0, 0, 3,
+		0, 4, 0, 0, 3, 0, 96, 60,
+		88, 182, 88,
+	}
+	videoTrack := &gortsplib.TrackH264{SPS: sps, RTPClockRate: 1000}
+	audioTrack := &gortsplib.TrackMPEG4Audio{
+		Config: &mpeg4audio.Config{ChannelCount: 1, SampleRate: 11025},
+	}
+	startTime := int64(1000000000)
+
+	// 30 fps with RTP timestamps from a 1000Hz clock, keyframe every second.
+	videoTime := func(i int) int64 {
+		return startTime + (int64(i)*1000/30)*int64(time.Millisecond)
+	}
+	audioTime := func(i int) int64 {
+		return startTime + int64(i)*
+			int64(mpeg4audio.SamplesPerAccessUnit*time.Second/11025)
+	}
+	var samples []customformat.Sample
+	for i := 0; i < 90; i++ {
+		samples = append(samples, customformat.Sample{
+			IsSyncSample: i%30 == 0,
+			PTS:          videoTime(i),
+			DTS:          videoTime(i),
+			Next:         videoTime(i + 1),
+			Size:         1,
+		})
+	}
+	for i := 0; i < 30; i++ {
+		samples = append(samples, customformat.Sample{
+			IsAudioSample: true,
+			PTS:           audioTime(i),
+			Next:          audioTime(i + 1),
+			Size:          1,
+		})
+	}
+
+	m := &muxer{
+		videoTrack:     videoTrack,
+		audioTrack:     audioTrack,
+		videoTimescale: int64(videoTrack.ClockRate()),
+		audioTimescale: int64(audioTrack.ClockRate()),
+		startTime:      startTime,
+		firstSample:    true,
+	}
+	for _, sample := range samples {
+		if sample.IsAudioSample {
+			m.writeAudioSample(sample)
+		} else {
+			m.writeVideoSample(sample)
+		}
+	}
+
+	// The video plays for 3 seconds.
+	index := m.generateIndex(0)
+	require.Len(t, index, 3)
+	for i, f := range index {
+		require.Equal(t, time.Duration(i)*time.Second, f.Start)
+		require.Equal(t, time.Second, f.Duration)
+	}
+
+	// Every audio frame is played at the original pitch.
+	require.Equal(t, []mp4.SttsEntry{{
+		SampleCount: 30,
+		SampleDelta: mpeg4audio.SamplesPerAccessUnit,
+	}}, m.audioStts)
+
+	buf := &bytes.Buffer{}
+	_, _, err := GenerateMP4(buf, startTime, samples, videoTrack, audioTrack)
+	require.NoError(t, err)
+}
+
+// findBoxes returns the payloads of the boxes at the path.
+func findBoxes(buf []byte, path ...string) [][]byte {
+	var found [][]byte
+	for len(buf) >= 8 {
+		size := int(binary.BigEndian.Uint32(buf[:4]))
+		if size < 8 || size > len(buf) {
+			size = len(buf) // The mdat payload is written separately.
+		}
+		if string(buf[4:8]) == path[0] {
+			if len(path) == 1 {
+				found = append(found, buf[8:size])
+			} else {
+				found = append(found, findBoxes(buf[8:size], path[1:]...)...)
+			}
+		}
+		buf = buf[size:]
+	}
+	return found
+}
+
+// sampleTable the sample count and the total duration from
+// the stts box, and the chunk offsets from the stco box.
+type sampleTable struct {
+	count    int
+	duration int
+	stco     []uint32
+	stbl     map[string][]byte
+}
+
+func readSampleTables(t *testing.T, buf []byte) []sampleTable {
+	t.Helper()
+	var tables []sampleTable
+	for _, trak := range findBoxes(buf, "moov", "trak") {
+		stbl := findBoxes(trak, "mdia", "minf", "stbl")
+		require.Len(t, stbl, 1)
+
+		table := sampleTable{stbl: map[string][]byte{}}
+		for _, typ := range []string{"stsd", "stts", "stss", "ctts", "stsc", "stsz"} {
+			if box := findBoxes(stbl[0], typ); len(box) == 1 {
+				table.stbl[typ] = box[0]
+			}
+		}
+
+		stts := table.stbl["stts"]
+		for i := 0; i < int(binary.BigEndian.Uint32(stts[4:8])); i++ {
+			count := int(binary.BigEndian.Uint32(stts[8+i*8:]))
+			delta := int(binary.BigEndian.Uint32(stts[12+i*8:]))
+			table.count += count
+			table.duration += count * delta
+		}
+		stsz := table.stbl["stsz"]
+		require.Equal(t, table.count, int(binary.BigEndian.Uint32(stsz[8:12])))
+
+		stco := findBoxes(stbl[0], "stco")[0]
+		for i := 0; i < int(binary.BigEndian.Uint32(stco[4:8])); i++ {
+			table.stco = append(table.stco, binary.BigEndian.Uint32(stco[8+i*4:]))
+		}
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+func TestGenerateProgressiveMP4(t *testing.T) {
+	sps := []byte{
+		103, 100, 0, 22, 172, 217, 64, 164,
+		59, 228, 136, 192, 68, 0, 0, 3,
+		0, 4, 0, 0, 3, 0, 96, 60,
+		88, 182, 88,
+	}
+	videoTrack := &gortsplib.TrackH264{SPS: sps}
+	audioTrack := &gortsplib.TrackMPEG4Audio{
+		Config: &mpeg4audio.Config{ChannelCount: 1, SampleRate: 48000},
+	}
+	startTime := int64(1000000000)
+
+	// 3 seconds of 25 fps video and interleaved audio, keyframe every second.
+	var samples []customformat.Sample
+	audioDuration := int64(mpeg4audio.SamplesPerAccessUnit * time.Second / 48000)
+	audioTime := startTime
+	for i := 0; i < 75; i++ {
+		videoTime := startTime + int64(i)*int64(40*time.Millisecond)
+		samples = append(samples, customformat.Sample{
+			IsSyncSample: i%25 == 0,
+			PTS:          videoTime,
+			DTS:          videoTime,
+			Next:         videoTime + int64(40*time.Millisecond),
+			Size:         uint32(100 + i),
+		})
+		for audioTime < videoTime+int64(40*time.Millisecond) {
+			samples = append(samples, customformat.Sample{
+				IsAudioSample: true,
+				PTS:           audioTime,
+				Next:          audioTime + audioDuration,
+				Size:          10,
+			})
+			audioTime += audioDuration
+		}
+	}
+
+	fragmented := &bytes.Buffer{}
+	mdatSize, index, err := GenerateMP4(
+		fragmented, startTime, samples, videoTrack, audioTrack)
+	require.NoError(t, err)
+
+	progressive := &bytes.Buffer{}
+	progressiveMdatSize, err := GenerateProgressiveMP4(
+		progressive, startTime, samples, videoTrack, audioTrack)
+	require.NoError(t, err)
+	require.Equal(t, mdatSize, progressiveMdatSize)
+
+	ftyp := findBoxes(progressive.Bytes(), "ftyp")
+	require.Len(t, ftyp, 1)
+	require.Equal(t, "isom", string(ftyp[0][:4]))
+	require.Len(t, findBoxes(fragmented.Bytes(), "sidx"), 1)
+	require.Empty(t, findBoxes(progressive.Bytes(), "sidx"))
+
+	// The mdat header is the last box of the metadata.
+	require.Equal(t, "mdat", string(progressive.Bytes()[progressive.Len()-4:]))
+	sidxSize := uint32(fragmented.Len() - progressive.Len())
+
+	tables := readSampleTables(t, fragmented.Bytes())
+	progressiveTables := readSampleTables(t, progressive.Bytes())
+	require.Len(t, tables, 2)
+	require.Len(t, progressiveTables, 2)
+
+	// Video.
+	require.Equal(t, 75, progressiveTables[0].count)
+	require.Equal(t, 3*90000, progressiveTables[0].duration)
+
+	// Audio.
+	require.Equal(t, 3*48000/mpeg4audio.SamplesPerAccessUnit+1, progressiveTables[1].count)
+
+	for i, table := range tables {
+		progressiveTable := progressiveTables[i]
+		require.Equal(t, table.count, progressiveTable.count)
+		require.Equal(t, table.duration, progressiveTable.duration)
+		require.Equal(t, table.stbl, progressiveTable.stbl)
+
+		// The chunks start at the same mdat offsets.
+		require.Len(t, progressiveTable.stco, len(table.stco))
+		for j, offset := range table.stco {
+			require.Equal(t, offset-sidxSize, progressiveTable.stco[j])
+		}
+	}
+
+	// The first video chunk starts at the mdat payload.
+	require.Equal(t, uint32(progressive.Len()), progressiveTables[0].stco[0])
+
+	// The fragmented file is indexed by keyframe.
+	require.Len(t, index, 3)
+}
@@ -0,0 +1,262 @@
+package mp4muxer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"nvr/pkg/video/customformat"
+	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/mp4"
+	"nvr/pkg/video/mp4/bitio"
+)
+
+// Reserved files are written in a single pass, the media data is
+// written before the metadata is known. The space between the ftyp
+// and mdat boxes is reserved for the metadata and holds a free box.
+//
+//	ftyp
+//	free
+//	mdat
+//
+// Finalizing the file writes the metadata into the reserved space and
+// pads the remainder with a free box, the media data isn't moved. The
+// padding is placed before the sidx box because the sidx references
+// start at the first byte after it.
+//
+//	ftyp
+//	moov
+//	free
+//	sidx
+//	mdat
+
+// Reserved file errors.
+var (
+	ErrInvalidReserve = errors.New("reserve is smaller than a free box")
+	ErrNotReserved    = errors.New("not a reserved file")
+	ErrMdatSize       = errors.New("mdat size does not match the samples")
+)
+
+// ReserveError is returned when the metadata doesn't fit in the reserved space.
+// The metadata fits if the reserved space is exactly the needed size or if
+// there is room for the free box header in the remainder.
+type ReserveError struct {
+	Reserved int64
+	Needed   int64
+}
+
+func (e *ReserveError) Error() string {
+	return fmt.Sprintf("metadata needs %d bytes, %d bytes are reserved", e.Needed, e.Reserved)
+}
+
+const freeHeaderSize = 8
+
+// WriteReservedHeader writes the ftyp box, a free box of reserve bytes and the
+// header of a mdat box that extends to the end of the file. Returns the size
+// of the header, the media data is written after it.
+func WriteReservedHeader(out io.Writer, reserve int64) (int64, error) {
+	if reserve < freeHeaderSize {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidReserve, reserve)
+	}
+
+	w := bitio.NewWriter(bitio.NewByteWriter(out))
+	ftypSize, err := mp4.WriteSingleBox(w, iso4Ftyp())
+	if err != nil {
+		return 0, fmt.Errorf("write ftyp: %w", err)
+	}
+
+	free := &mp4.Free{Data: make([]byte, reserve-freeHeaderSize)}
+	if _, err := mp4.WriteSingleBox(w, free); err != nil {
+		return 0, fmt.Errorf("write free: %w", err)
+	}
+
+	// The size is set by FinalizeReservedMP4.
+	w.TryWriteUint32(0)
+	w.TryWrite([]byte{'m', 'd', 'a', 't'})
+	if w.TryError != nil {
+		return 0, w.TryError
+	}
+	return int64(ftypSize) + reserve + mdatHeaderSize, nil
+}
+
+// ReadWriterAt is implemented by *os.File.
+type ReadWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// FinalizeReservedMP4 writes the metadata of the samples into the reserved
+// space and sets the mdat size. Everything between the ftyp and mdat boxes
+// is reserved space, the metadata of a previous finalization is replaced.
+// The file is unchanged if a *ReserveError is returned.
+func FinalizeReservedMP4(
+	file ReadWriterAt,
+	fileSize int64,
+	startTime int64,
+	samples []customformat.Sample,
+	videoTrack *gortsplib.TrackH264,
+	audioTrack *gortsplib.TrackMPEG4Audio,
+) (Index, error) {
+	ftyp, mdat, err := readReservedLayout(file, fileSize)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	m, err := newReservedMuxer(buf, mdat, startTime, samples, videoTrack, audioTrack)
+	if err != nil {
+		return nil, err
+	}
+
+	reserved := mdat.Offset - ftyp.End()
+	if needed := m.reservedMetadataSize(); !reserveFits(reserved, needed) {
+		return nil, &ReserveError{Reserved: reserved, Needed: needed}
+	}
+
+	index, err := m.writeReservedMetadata(reserved, mdat.Offset+mdatHeaderSize)
+	if err != nil {
+		return nil, fmt.Errorf("write metadata: %w", err)
+	}
+	if _, err := file.WriteAt(buf.Bytes(), ftyp.End()); err != nil {
+		return nil, fmt.Errorf("write metadata: %w", err)
+	}
+
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(mdat.Size))
+	if _, err := file.WriteAt(size, mdat.Offset); err != nil {
+		return nil, fmt.Errorf("write mdat size: %w", err)
+	}
+	return index, nil
+}
+
+// RewriteReservedMP4 copies the file to out with the metadata in front of the
+// media data. It's used when the metadata doesn't fit in the reserved space,
+// the copy reserves the larger of reserve and the size of the metadata.
+func RewriteReservedMP4(
+	out io.Writer,
+	file io.ReaderAt,
+	fileSize int64,
+	reserve int64,
+	startTime int64,
+	samples []customformat.Sample,
+	videoTrack *gortsplib.TrackH264,
+	audioTrack *gortsplib.TrackMPEG4Audio,
+) (Index, error) {
+	_, mdat, err := readReservedLayout(file, fileSize)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := newReservedMuxer(out, mdat, startTime, samples, videoTrack, audioTrack)
+	if err != nil {
+		return nil, err
+	}
+
+	ftypSize, err := mp4.WriteSingleBox(m.out, iso4Ftyp())
+	if err != nil {
+		return nil, fmt.Errorf("write ftyp: %w", err)
+	}
+
+	reserved := reserve
+	if needed := m.reservedMetadataSize(); !reserveFits(reserved, needed) {
+		reserved = needed
+	}
+
+	mdatOffset := int64(ftypSize) + reserved + mdatHeaderSize
+	index, err := m.writeReservedMetadata(reserved, mdatOffset)
+	if err != nil {
+		return nil, fmt.Errorf("write metadata: %w", err)
+	}
+
+	m.out.TryWriteUint32(uint32(mdat.Size))
+	m.out.TryWrite([]byte{'m', 'd', 'a', 't'})
+	if m.out.TryError != nil {
+		return nil, m.out.TryError
+	}
+
+	payload := io.NewSectionReader(file, mdat.Offset+mdat.HeaderSize, mdat.Size-mdat.HeaderSize)
+	if _, err := io.Copy(out, payload); err != nil {
+		return nil, fmt.Errorf("copy mdat: %w", err)
+	}
+	return index, nil
+}
+
+// readReservedLayout returns the ftyp box and the following mdat box.
+// Free boxes and other boxes are allowed anywhere around them.
+func readReservedLayout(file io.ReaderAt, fileSize int64) (mp4.BoxInfo, mp4.BoxInfo, error) {
+	boxes, err := mp4.ReadBoxInfos(file, fileSize)
+	if err != nil {
+		return mp4.BoxInfo{}, mp4.BoxInfo{}, fmt.Errorf("read boxes: %w", err)
+	}
+
+	ftyp, exist := mp4.FindBox(boxes, mp4.TypeFtyp())
+	if !exist {
+		return mp4.BoxInfo{}, mp4.BoxInfo{}, fmt.Errorf("%w: no ftyp box", ErrNotReserved)
+	}
+	mdat, exist := mp4.FindBox(boxes, mp4.TypeMdat())
+	if !exist || mdat.Offset < ftyp.End() {
+		return mp4.BoxInfo{}, mp4.BoxInfo{}, fmt.Errorf("%w: no mdat box after the ftyp box", ErrNotReserved)
+	}
+	if mdat.HeaderSize != mdatHeaderSize || mdat.Size > math.MaxUint32 {
+		return mp4.BoxInfo{}, mp4.BoxInfo{}, fmt.Errorf("%w: mdat box is too large", ErrNotReserved)
+	}
+	return ftyp, mdat, nil
+}
+
+// newReservedMuxer returns a muxer with the samples
+// written and checks that they match the mdat box.
+func newReservedMuxer(
+	out io.Writer,
+	mdat mp4.BoxInfo,
+	startTime int64,
+	samples []customformat.Sample,
+	videoTrack *gortsplib.TrackH264,
+	audioTrack *gortsplib.TrackMPEG4Audio,
+) (*muxer, error) {
+	m, err := newMuxer(out, startTime, videoTrack, audioTrack, false)
+	if err != nil {
+		return nil, err
+	}
+	m.writeSamples(samples)
+
+	if payloadSize := mdat.Size - mdat.HeaderSize; int64(m.mdatPos) != payloadSize {
+		return nil, fmt.Errorf("%w: mdat: %d samples: %d", ErrMdatSize, payloadSize, m.mdatPos)
+	}
+	return m, nil
+}
+
+func reserveFits(reserved int64, needed int64) bool {
+	padding := reserved - needed
+	return padding == 0 || padding >= freeHeaderSize
+}
+
+// reservedMetadataSize returns the size of the moov and sidx boxes.
+func (m *muxer) reservedMetadataSize() int64 {
+	moov := m.generateMoov()
+	sidx := m.generateSidx()
+	return int64(moov.Size() + sidx.Size())
+}
+
+// writeReservedMetadata writes the moov, free and sidx boxes.
+// The free box pads the metadata to the reserved size.
+func (m *muxer) writeReservedMetadata(reserved int64, mdatOffset int64) (Index, error) {
+	moov := m.generateMoov()
+	sidx := m.generateSidx()
+	m.offsetChunks(uint32(mdatOffset))
+
+	if err := moov.Marshal(m.out); err != nil {
+		return nil, fmt.Errorf("marshal moov: %w", err)
+	}
+	if padding := reserved - int64(moov.Size()+sidx.Size()); padding != 0 {
+		free := &mp4.Free{Data: make([]byte, padding-freeHeaderSize)}
+		if _, err := mp4.WriteSingleBox(m.out, free); err != nil {
+			return nil, fmt.Errorf("write free: %w", err)
+		}
+	}
+	if err := sidx.Marshal(m.out); err != nil {
+		return nil, fmt.Errorf("marshal sidx: %w", err)
+	}
+	return m.generateIndex(uint32(mdatOffset)), nil
+}
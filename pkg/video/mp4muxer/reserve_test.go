@@ -0,0 +1,319 @@
+package mp4muxer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nvr/pkg/video/customformat"
+	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/gortsplib/pkg/mpeg4audio"
+	"nvr/pkg/video/mp4"
+
+	"github.com/stretchr/testify/require"
+)
+
+type reservedTestVideo struct {
+	startTime  int64
+	samples    []customformat.Sample
+	mdat       []byte
+	videoTrack *gortsplib.TrackH264
+	audioTrack *gortsplib.TrackMPEG4Audio
+}
+
+// newReservedTestVideo 2 seconds of 25 fps video
+// and interleaved audio, keyframe every second.
+func newReservedTestVideo() reservedTestVideo {
+	v := reservedTestVideo{
+		startTime: 1000000000,
+		videoTrack: &gortsplib.TrackH264{SPS: []byte{
+			103, 100, 0, 22, 172, 217, 64, 164,
+			59, 228, 136, 192, 68, 0, 0, 3,
+			0, 4, 0, 0, 3, 0, 96, 60,
+			88, 182, 88,
+		}},
+		audioTrack: &gortsplib.TrackMPEG4Audio{
+			Config: &mpeg4audio.Config{ChannelCount: 1, SampleRate: 48000},
+		},
+	}
+
+	audioDuration := int64(mpeg4audio.SamplesPerAccessUnit * time.Second / 48000)
+	audioTime := v.startTime
+	for i := 0; i < 50; i++ {
+		videoTime := v.startTime + int64(i)*int64(40*time.Millisecond)
+		v.samples = append(v.samples, customformat.Sample{
+			IsSyncSample: i%25 == 0,
+			PTS:          videoTime,
+			DTS:          videoTime,
+			Next:         videoTime + int64(40*time.Millisecond),
+			Size:         uint32(100 + i),
+		})
+		for audioTime < videoTime+int64(40*time.Millisecond) {
+			v.samples = append(v.samples, customformat.Sample{
+				IsAudioSample: true,
+				PTS:           audioTime,
+				Next:          audioTime + audioDuration,
+				Size:          10,
+			})
+			audioTime += audioDuration
+		}
+	}
+	for _, s := range v.samples {
+		for i := 0; i < int(s.Size); i++ {
+			v.mdat = append(v.mdat, byte(len(v.mdat)))
+		}
+	}
+	return v
+}
+
+// writeFile writes the reserved header and the media data.
+func (v reservedTestVideo) writeFile(t *testing.T, reserve int64) string {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	headerSize, err := WriteReservedHeader(buf, reserve)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), headerSize)
+	buf.Write(v.mdat)
+	return writeTempFile(t, buf.Bytes())
+}
+
+func (v reservedTestVideo) finalize(path string) (Index, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return FinalizeReservedMP4(
+		file, stat.Size(), v.startTime, v.samples, v.videoTrack, v.audioTrack)
+}
+
+func writeTempFile(t *testing.T, buf []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "x.mp4")
+	require.NoError(t, os.WriteFile(path, buf, 0o600))
+	return path
+}
+
+func boxTypes(t *testing.T, file []byte) []string {
+	t.Helper()
+	infos, err := mp4.ReadBoxInfos(bytes.NewReader(file), int64(len(file)))
+	require.NoError(t, err)
+	var types []string
+	for _, info := range infos {
+		types = append(types, string(info.Type[:]))
+	}
+	return types
+}
+
+// requireSameMetadata checks that the sample tables, the chunk offsets
+// relative to the mdat payload and the index match GenerateMP4.
+func (v reservedTestVideo) requireSameMetadata(t *testing.T, file []byte, index Index) {
+	t.Helper()
+	generated := &bytes.Buffer{}
+	_, generatedIndex, err := GenerateMP4(
+		generated, v.startTime, v.samples, v.videoTrack, v.audioTrack)
+	require.NoError(t, err)
+
+	infos, err := mp4.ReadBoxInfos(bytes.NewReader(file), int64(len(file)))
+	require.NoError(t, err)
+	mdat, exist := mp4.FindBox(infos, mp4.TypeMdat())
+	require.True(t, exist)
+	require.Equal(t, int64(len(file)), mdat.End())
+	payloadOffset := mdat.Offset + mdat.HeaderSize
+	require.Equal(t, v.mdat, file[payloadOffset:])
+
+	// The generated metadata ends with the mdat header.
+	shift := uint32(payloadOffset) - uint32(generated.Len())
+
+	tables := readSampleTables(t, file)
+	generatedTables := readSampleTables(t, generated.Bytes())
+	require.Len(t, tables, 2)
+	for i, table := range tables {
+		generatedTable := generatedTables[i]
+		require.Equal(t, generatedTable.stbl, table.stbl)
+		require.Len(t, table.stco, len(generatedTable.stco))
+		for j, offset := range generatedTable.stco {
+			require.Equal(t, offset+shift, table.stco[j])
+		}
+	}
+	require.Equal(t, uint32(payloadOffset), tables[0].stco[0])
+
+	require.Equal(t, findBoxes(generated.Bytes(), "sidx"), findBoxes(file, "sidx"))
+
+	require.Len(t, index, len(generatedIndex))
+	for i, f := range generatedIndex {
+		f.Offset += int64(shift)
+		require.Equal(t, f, index[i])
+	}
+	require.Equal(t, payloadOffset, index[0].Offset)
+}
+
+func TestFinalizeReservedMP4(t *testing.T) {
+	v := newReservedTestVideo()
+
+	t.Run("patch", func(t *testing.T) {
+		path := v.writeFile(t, 4096)
+		before, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, []string{"ftyp", "free", "mdat"}, boxTypes(t, before))
+
+		index, err := v.finalize(path)
+		require.NoError(t, err)
+
+		file, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Len(t, file, len(before))
+		require.Equal(t, []string{"ftyp", "moov", "free", "sidx", "mdat"}, boxTypes(t, file))
+		v.requireSameMetadata(t, file, index)
+
+		// The previous metadata is replaced.
+		index2, err := v.finalize(path)
+		require.NoError(t, err)
+		require.Equal(t, index, index2)
+		file2, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, file, file2)
+	})
+	t.Run("exactFit", func(t *testing.T) {
+		needed := reservedSize(t, v)
+		path := v.writeFile(t, needed)
+
+		index, err := v.finalize(path)
+		require.NoError(t, err)
+
+		file, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, []string{"ftyp", "moov", "sidx", "mdat"}, boxTypes(t, file))
+		v.requireSameMetadata(t, file, index)
+	})
+	t.Run("overflow", func(t *testing.T) {
+		needed := reservedSize(t, v)
+		for _, reserve := range []int64{64, needed - 1, needed + 1, needed + 7} {
+			path := v.writeFile(t, reserve)
+			before, err := os.ReadFile(path)
+			require.NoError(t, err)
+
+			_, err = v.finalize(path)
+			var reserveErr *ReserveError
+			require.ErrorAs(t, err, &reserveErr)
+			require.Equal(t, ReserveError{Reserved: reserve, Needed: needed}, *reserveErr)
+
+			// Unchanged.
+			file, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.Equal(t, before, file)
+		}
+	})
+	t.Run("freeBoxes", func(t *testing.T) {
+		ftyp := &bytes.Buffer{}
+		_, err := WriteReservedHeader(ftyp, 8)
+		require.NoError(t, err)
+		ftypSize := ftyp.Len() - 16
+
+		free := func(size int) []byte {
+			buf := make([]byte, size)
+			binary.BigEndian.PutUint32(buf, uint32(size))
+			copy(buf[4:8], "free")
+			return buf
+		}
+		skip := free(100)
+		copy(skip[4:8], "skip")
+		mdatHeader := []byte{0, 0, 0, 0, 'm', 'd', 'a', 't'}
+		binary.BigEndian.PutUint32(mdatHeader, uint32(8+len(v.mdat)))
+
+		var file []byte
+		file = append(file, free(20)...)
+		file = append(file, ftyp.Bytes()[:ftypSize]...)
+		file = append(file, skip...)
+		file = append(file, free(4000)...)
+		file = append(file, free(8)...)
+		file = append(file, mdatHeader...)
+		file = append(file, v.mdat...)
+		file = append(file, free(30)...)
+		path := writeTempFile(t, file)
+
+		_, err = v.finalize(path)
+		require.NoError(t, err)
+
+		file2, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Len(t, file2, len(file))
+		expected := []string{"free", "ftyp", "moov", "free", "sidx", "mdat", "free"}
+		require.Equal(t, expected, boxTypes(t, file2))
+		require.Equal(t, file[:20+ftypSize], file2[:20+ftypSize])
+		require.Equal(t, file[len(file)-30-len(v.mdat)-8:], file2[len(file)-30-len(v.mdat)-8:])
+		require.Len(t, readSampleTables(t, file2), 2)
+	})
+	t.Run("mdatSize", func(t *testing.T) {
+		path := v.writeFile(t, 4096)
+		v2 := v
+		v2.samples = v.samples[:len(v.samples)-1]
+		_, err := v2.finalize(path)
+		require.ErrorIs(t, err, ErrMdatSize)
+	})
+	t.Run("notReserved", func(t *testing.T) {
+		path := writeTempFile(t, []byte{0, 0, 0, 8, 'm', 'd', 'a', 't'})
+		_, err := v.finalize(path)
+		require.ErrorIs(t, err, ErrNotReserved)
+	})
+	t.Run("invalidReserve", func(t *testing.T) {
+		_, err := WriteReservedHeader(&bytes.Buffer{}, 7)
+		require.ErrorIs(t, err, ErrInvalidReserve)
+	})
+}
+
+// reservedSize returns the size of the moov and sidx boxes.
+func reservedSize(t *testing.T, v reservedTestVideo) int64 {
+	t.Helper()
+	_, err := v.finalize(v.writeFile(t, 8))
+	var reserveErr *ReserveError
+	require.ErrorAs(t, err, &reserveErr)
+	return reserveErr.Needed
+}
+
+func TestRewriteReservedMP4(t *testing.T) {
+	v := newReservedTestVideo()
+	needed := reservedSize(t, v)
+
+	rewrite := func(reserve int64) ([]byte, Index) {
+		path := v.writeFile(t, 64)
+		file, err := os.Open(path)
+		require.NoError(t, err)
+		defer file.Close()
+		stat, err := file.Stat()
+		require.NoError(t, err)
+
+		buf := &bytes.Buffer{}
+		index, err := RewriteReservedMP4(
+			buf, file, stat.Size(), reserve,
+			v.startTime, v.samples, v.videoTrack, v.audioTrack)
+		require.NoError(t, err)
+		return buf.Bytes(), index
+	}
+
+	t.Run("growReserve", func(t *testing.T) {
+		file, index := rewrite(64)
+		require.Equal(t, []string{"ftyp", "moov", "sidx", "mdat"}, boxTypes(t, file))
+		v.requireSameMetadata(t, file, index)
+
+		// The rewritten file can be finalized in place.
+		path := writeTempFile(t, file)
+		_, err := v.finalize(path)
+		require.NoError(t, err)
+		file2, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, file, file2)
+	})
+	t.Run("keepReserve", func(t *testing.T) {
+		file, index := rewrite(needed + 1000)
+		require.Equal(t, []string{"ftyp", "moov", "free", "sidx", "mdat"}, boxTypes(t, file))
+		v.requireSameMetadata(t, file, index)
+	})
+}
@@ -121,7 +121,7 @@ func generateThumbnailVideoTrak(
 				Box: &mp4.Mdia{},
 				Children: []mp4.Boxes{
 					{Box: &mp4.Mdhd{
-						Timescale: hls.VideoTimescale, // the number of time units that pass per second
+						Timescale: uint32(videoTrack.ClockRate()), // the number of time units that pass per second
 						Language:  [3]byte{'u', 'n', 'd'},
 					}},
 					{Box: &mp4.Hdlr{
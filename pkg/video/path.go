@@ -8,6 +8,8 @@ import (
 	"nvr/pkg/video/gortsplib"
 	"regexp"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type pathHLSServer interface {
@@ -26,9 +28,15 @@ type path struct {
 	sourceReady bool
 	stream      *stream
 	readers     map[*rtspSession]struct{}
+	stats       *pathStats
+	hlsDebug    *hlsDebugState
+	audioLevels *audioLevels
 
 	mu       sync.Mutex
 	canceled bool
+
+	// Initialized from the config, can be changed while running.
+	excludeLiveAudio atomic.Bool
 }
 
 func newPath(
@@ -38,15 +46,21 @@ func newPath(
 	wg *sync.WaitGroup,
 	hlsServer pathHLSServer,
 	logger log.ILogger,
+	hlsDebug *hlsDebugState,
+	audioLevels *audioLevels,
 ) *path {
 	pa := &path{
-		name:      name,
-		conf:      conf,
-		wg:        wg,
-		hlsServer: hlsServer,
-		logger:    logger,
-		readers:   make(map[*rtspSession]struct{}),
+		name:        name,
+		conf:        conf,
+		wg:          wg,
+		hlsServer:   hlsServer,
+		logger:      logger,
+		readers:     make(map[*rtspSession]struct{}),
+		stats:       &pathStats{},
+		hlsDebug:    hlsDebug,
+		audioLevels: audioLevels,
 	}
+	pa.excludeLiveAudio.Store(conf.ExcludeLiveAudio)
 
 	pa.wg.Add(1)
 	go func() {
@@ -99,6 +113,10 @@ func (pa *path) close() {
 		delete(pa.readers, r)
 	}
 
+	if pa.conf.AudioLevel && pa.audioLevels != nil {
+		pa.audioLevels.remove(pa.conf.MonitorID)
+	}
+
 	pa.canceled = true
 	pa.wg.Done()
 }
@@ -196,6 +214,17 @@ func (pa *path) readerStart(session *rtspSession) {
 	pa.readers[session] = struct{}{}
 }
 
+// sessions returns the number of RTSP sessions, including the publisher.
+func (pa *path) sessions() int {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+	n := len(pa.readers)
+	if pa.source != nil {
+		n++
+	}
+	return n
+}
+
 // Errors.
 var (
 	ErrEmptyName    = errors.New("name can not be empty")
@@ -231,6 +260,24 @@ func isValidPathName(name string) error {
 type PathConf struct {
 	MonitorID string
 	IsSub     bool
+
+	// Serve a I-frame only HLS playlist.
+	HLSIFrames bool
+
+	// Encrypt the HLS segments with AES-128, the keys are
+	// replaced every HLSKeyRotation. Zero never rotates.
+	HLSEncryption  bool
+	HLSKeyRotation time.Duration
+
+	// Omit the audio track from the live HLS stream.
+	// The recorder always receives both tracks.
+	ExcludeLiveAudio bool
+
+	// Publish the approximate level of the audio track, see AudioLevel.
+	AudioLevel bool
+
+	// Log the RTSP requests and responses of the path.
+	DebugRTSP bool
 }
 
 // Errors.
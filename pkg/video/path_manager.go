@@ -7,13 +7,16 @@ import (
 	"nvr/pkg/video/gortsplib"
 	"nvr/pkg/video/gortsplib/pkg/base"
 	"nvr/pkg/video/hls"
+	"strings"
 	"sync"
+	"time"
 )
 
 type pathManagerHLSServer interface {
 	pathSourceReady(*path, gortsplib.Tracks) (*HLSMuxer, error)
 	pathSourceNotReady(pathName string)
 	MuxerByPathName(ctx context.Context, pathName string) (*hls.Muxer, error)
+	reinitLiveMuxer(pathName string)
 }
 
 type pathManager struct {
@@ -24,20 +27,28 @@ type pathManager struct {
 	hlsServer pathManagerHLSServer
 	pathConfs map[string]*PathConf
 	paths     map[string]*path
+
+	// By path name, kept when the path is removed.
+	hlsDebugStates map[string]*hlsDebugState
+
+	audioLevels *audioLevels
 }
 
 func newPathManager(
 	wg *sync.WaitGroup,
 	log log.ILogger,
 	hlsServer pathManagerHLSServer,
+	audioLevels *audioLevels,
 ) *pathManager {
 	return &pathManager{
 		wg:  wg,
 		log: log,
 
-		hlsServer: hlsServer,
-		pathConfs: make(map[string]*PathConf),
-		paths:     make(map[string]*path),
+		hlsServer:      hlsServer,
+		pathConfs:      make(map[string]*PathConf),
+		paths:          make(map[string]*path),
+		hlsDebugStates: make(map[string]*hlsDebugState),
+		audioLevels:    audioLevels,
 	}
 }
 
@@ -70,6 +81,12 @@ func (pm *pathManager) AddPath(
 	// Add config.
 	pm.pathConfs[name] = config
 
+	hlsDebug, exist := pm.hlsDebugStates[name]
+	if !exist || hlsDebug.monitorID != config.MonitorID {
+		hlsDebug = newHLSDebugState(config)
+		pm.hlsDebugStates[name] = hlsDebug
+	}
+
 	// Add path.
 	pm.paths[name] = newPath(
 		ctx,
@@ -78,6 +95,8 @@ func (pm *pathManager) AddPath(
 		pm.wg,
 		pm.hlsServer,
 		pm.log,
+		hlsDebug,
+		pm.audioLevels,
 	)
 
 	hlsMuxer := func(ctx context.Context) (IHLSMuxer, error) {
@@ -102,6 +121,43 @@ func (pm *pathManager) AddPath(
 	return hlsMuxer, nil
 }
 
+// setExcludeLiveAudio reinitializes the live HLS muxer if the value changed.
+func (pm *pathManager) setExcludeLiveAudio(name string, exclude bool) error {
+	pm.mu.Lock()
+	pa, exist := pm.paths[name]
+	pm.mu.Unlock()
+	if !exist {
+		return ErrPathNotExist
+	}
+
+	if pa.excludeLiveAudio.Swap(exclude) != exclude {
+		pm.hlsServer.reinitLiveMuxer(name)
+	}
+	return nil
+}
+
+// debugPath returns the path if RTSP debugging is enabled. The
+// name is the path of the request URL and may include a track.
+func (pm *pathManager) debugPath(name string) *path {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	name = strings.TrimSuffix(name, "/")
+	pa, exist := pm.paths[name]
+	if !exist {
+		// "name/trackID=0"
+		i := strings.LastIndex(name, "/")
+		if i == -1 {
+			return nil
+		}
+		pa, exist = pm.paths[name[:i]]
+	}
+	if !exist || !pa.conf.DebugRTSP {
+		return nil
+	}
+	return pa
+}
+
 // Testing.
 func (pm *pathManager) pathExist(name string) bool {
 	pm.mu.Lock()
@@ -111,6 +167,71 @@ func (pm *pathManager) pathExist(name string) bool {
 	return exist
 }
 
+// monitorID returns the monitor ID of the path.
+func (pm *pathManager) monitorID(name string) (string, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	conf, exist := pm.pathConfs[name]
+	if !exist {
+		return "", false
+	}
+	return conf.MonitorID, true
+}
+
+// sampleStats is called by the stats ticker.
+func (pm *pathManager) sampleStats(now time.Time) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for _, pa := range pm.paths {
+		pa.stats.sample(now)
+	}
+}
+
+// stats returns the ingest statistics of each monitor.
+func (pm *pathManager) stats(now time.Time) map[string]MonitorStats {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	stats := make(map[string]MonitorStats)
+	for _, pa := range pm.paths {
+		pathStats := pa.stats.snapshot(now)
+		monitorStats := stats[pa.conf.MonitorID]
+		if pa.conf.IsSub {
+			monitorStats.Sub = &pathStats
+		} else {
+			monitorStats.Main = &pathStats
+		}
+		stats[pa.conf.MonitorID] = monitorStats
+	}
+	return stats
+}
+
+// ingestBytes returns the total bytes received from the publishers of each monitor.
+func (pm *pathManager) ingestBytes() map[string]float64 {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	bytes := make(map[string]float64)
+	for _, pa := range pm.paths {
+		bytes[pa.conf.MonitorID] += float64(pa.stats.bytes.Load())
+	}
+	return bytes
+}
+
+// rtspSessions returns the number of RTSP sessions of each monitor.
+func (pm *pathManager) rtspSessions() map[string]float64 {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	sessions := make(map[string]float64)
+	for _, pa := range pm.paths {
+		sessions[pa.conf.MonitorID] += float64(pa.sessions())
+	}
+	return sessions
+}
+
 // describe is called by a rtsp reader.
 func (pm *pathManager) onDescribe(
 	pathName string,
@@ -129,7 +250,7 @@ func (pm *pathManager) onDescribe(
 	if err != nil {
 		if errors.Is(err, ErrPathNoOnePublishing) {
 			return &base.Response{
-				StatusCode: base.StatusNotFound,
+				StatusCode: base.StatusServiceUnavailable,
 			}, nil, err
 		}
 		return &base.Response{
@@ -137,6 +258,12 @@ func (pm *pathManager) onDescribe(
 		}, nil, err
 	}
 
+	if err := stream.paramsReady(); err != nil {
+		return &base.Response{
+			StatusCode: base.StatusServiceUnavailable,
+		}, nil, err
+	}
+
 	return &base.Response{StatusCode: base.StatusOK}, stream.rtspStream, nil
 }
 
@@ -5,11 +5,15 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
+	"net"
+	"net/http"
 	"nvr/pkg/log"
 	"nvr/pkg/video/gortsplib"
 	"nvr/pkg/video/gortsplib/pkg/base"
+	"nvr/pkg/video/gortsplib/pkg/headers"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/rtp"
@@ -20,23 +24,35 @@ type rtspServer struct {
 	readTimeout time.Duration
 	pathManager *pathManager
 	logger      *log.Logger
+	readAuth    ReadAuthFunc
 
 	ctx      context.Context
 	wg       *sync.WaitGroup
 	srv      *gortsplib.Server
 	mu       sync.RWMutex
 	sessions map[*gortsplib.ServerSession]*rtspSession
+
+	listening atomic.Bool
+
+	// Limit log entries are throttled, a misbehaving
+	// client can hit the limits thousands of times.
+	limitLogMu         sync.Mutex
+	limitLogLast       time.Time
+	limitLogSuppressed int
 }
 
 const (
 	readTimeout  = 10 * time.Second
 	writeTimeout = 10 * time.Second
+
+	limitLogInterval = 10 * time.Second
 )
 
 func newRTSPServer(
 	wg *sync.WaitGroup,
 	address string,
 	readBufferCount int,
+	readerRate int,
 	pathManager *pathManager,
 	logger *log.Logger,
 ) *rtspServer {
@@ -57,6 +73,16 @@ func newRTSPServer(
 		readBufferCount,
 		address,
 	)
+	// The monitor pipeline connects from localhost.
+	s.srv.SetLimits(gortsplib.ServerLimits{ExemptLoopback: true})
+	s.srv.SetWriteRate(gortsplib.ServerWriteRate{
+		BytesPerSecond: readerRate,
+		ExemptLoopback: true,
+	})
+	s.srv.SetDebugHooks(gortsplib.ServerDebugHooks{
+		OnRequest:  s.onDebugRequest,
+		OnResponse: s.onDebugResponse,
+	})
 
 	return s
 }
@@ -70,12 +96,37 @@ func (s *rtspServer) logf(level log.Level, format string, a ...interface{}) {
 	})
 }
 
+// debugPath returns the path of the request if the
+// monitor has RTSP debugging enabled, otherwise nil.
+func (s *rtspServer) debugPath(req *base.Request) *path {
+	if req.URL == nil {
+		return nil
+	}
+	name, ok := req.URL.RTSPPath()
+	if !ok {
+		return nil
+	}
+	return s.pathManager.debugPath(name)
+}
+
+func (s *rtspServer) onDebugRequest(remoteAddr net.Addr, req *base.Request) {
+	if pa := s.debugPath(req); pa != nil {
+		pa.logf(log.LevelDebug, "RTSP request from %v:\n%v", remoteAddr, req)
+	}
+}
+
+func (s *rtspServer) onDebugResponse(remoteAddr net.Addr, req *base.Request, res *base.Response) {
+	if pa := s.debugPath(req); pa != nil {
+		pa.logf(log.LevelDebug, "RTSP response to %v:\n%v", remoteAddr, res)
+	}
+}
+
 func (s *rtspServer) start(ctx context.Context) error {
 	s.ctx = ctx
 
 	err := s.srv.Start()
 	if err != nil {
-		return err
+		return fmt.Errorf("RTSP: listen on %v: %w", s.address, err)
 	}
 
 	s.logger.Log(log.Entry{
@@ -83,6 +134,7 @@ func (s *rtspServer) start(ctx context.Context) error {
 		Src:   "app",
 		Msg:   fmt.Sprintf("RTSP: listener opened on %v", s.address),
 	})
+	s.listening.Store(true)
 	s.wg.Add(1)
 	go s.run()
 
@@ -91,6 +143,7 @@ func (s *rtspServer) start(ctx context.Context) error {
 
 func (s *rtspServer) run() {
 	defer s.wg.Done()
+	defer s.listening.Store(false)
 
 	serverErr := make(chan error)
 	go func() {
@@ -141,6 +194,50 @@ func (s *rtspServer) newSessionID() string {
 	}
 }
 
+// OnRequest implements gortsplib.ServerHandler.
+func (s *rtspServer) OnRequest(
+	sc *gortsplib.ServerConn,
+	req *base.Request,
+) (*base.Response, error) {
+	if s.readAuth == nil {
+		return nil, nil
+	}
+
+	switch req.Method {
+	case base.Describe, base.Setup, base.Play, base.Announce, base.Record:
+	default:
+		return nil, nil
+	}
+
+	// Internal clients are always allowed. They connect to the bind
+	// address, which isn't a loopback address if one is configured.
+	if sc.IsLocal() {
+		return nil, nil
+	}
+	remoteAddr := sc.NetConn().RemoteAddr()
+
+	r := &http.Request{
+		Header:     http.Header{"Authorization": req.Header["Authorization"]},
+		RemoteAddr: remoteAddr.String(),
+	}
+	if s.readAuth(r) {
+		return nil, nil
+	}
+
+	res := &base.Response{
+		StatusCode: base.StatusUnauthorized,
+		Header: base.Header{
+			"WWW-Authenticate": base.HeaderValue{`Basic realm="NVR"`},
+		},
+	}
+
+	// Keep the connection open so the client can retry with credentials.
+	if len(req.Header["Authorization"]) == 0 {
+		return res, nil
+	}
+	return res, ErrUnauthorized
+}
+
 // OnConnClose implements gortsplib.ServerHandler.
 func (s *rtspServer) OnConnClose(sc *gortsplib.ServerConn, err error) {
 	session := sc.Session()
@@ -199,7 +296,7 @@ func (s *rtspServer) OnDescribe(
 	return s.pathManager.onDescribe(pathName)
 }
 
-// OnAnnounce implements gortsplib.ServerHandler.
+// OnAnnounce implements gortsplib.ServerHandlerOnAnnounce.
 func (s *rtspServer) OnAnnounce(
 	session *gortsplib.ServerSession,
 	path string,
@@ -223,9 +320,11 @@ func (s *rtspServer) OnSetup(
 	return se.onSetup(path, trackID)
 }
 
-// OnPlay implements gortsplib.ServerHandler.
+// OnPlay implements gortsplib.ServerHandlerOnPlay. Only
+// live streams are served, the range is ignored.
 func (s *rtspServer) OnPlay(
 	session *gortsplib.ServerSession,
+	_ headers.Range,
 ) (*base.Response, error) {
 	s.mu.RLock()
 	se := s.sessions[session]
@@ -233,7 +332,7 @@ func (s *rtspServer) OnPlay(
 	return se.onPlay()
 }
 
-// OnRecord implements gortsplib.ServerHandler.
+// OnRecord implements gortsplib.ServerHandlerOnRecord.
 func (s *rtspServer) OnRecord(
 	session *gortsplib.ServerSession,
 ) (*base.Response, error) {
@@ -243,6 +342,23 @@ func (s *rtspServer) OnRecord(
 	return se.onRecord()
 }
 
+// OnGetParameter implements gortsplib.ServerHandlerOnGetParameter.
+// GET_PARAMETER is used like a ping when reading, and sometimes
+// also when publishing, there are no parameters.
+func (s *rtspServer) OnGetParameter(
+	*gortsplib.ServerSession,
+	string,
+	*base.Request,
+) (*base.Response, error) {
+	return &base.Response{
+		StatusCode: base.StatusOK,
+		Header: base.Header{
+			"Content-Type": base.HeaderValue{"text/parameters"},
+		},
+		Body: []byte{},
+	}, nil
+}
+
 // OnPacketRTP implements gortsplib.ServerHandler.
 func (s *rtspServer) OnPacketRTP(
 	session *gortsplib.ServerSession,
@@ -270,3 +386,24 @@ func (s *rtspServer) OnDecodeError(
 		s.logf(log.LevelDebug, "decode error: %v", err)
 	}
 }
+
+// OnLimitReached implements gortsplib.ServerHandler.
+func (s *rtspServer) OnLimitReached(addr net.Addr, err error) {
+	s.limitLogMu.Lock()
+	defer s.limitLogMu.Unlock()
+
+	if time.Since(s.limitLogLast) < limitLogInterval {
+		s.limitLogSuppressed++
+		return
+	}
+	s.limitLogLast = time.Now()
+
+	suppressed := s.limitLogSuppressed
+	s.limitLogSuppressed = 0
+	if suppressed == 0 {
+		s.logf(log.LevelWarning, "rejected %v: %v", addr, err)
+		return
+	}
+	s.logf(log.LevelWarning, "rejected %v: %v (%d more rejected since the last entry)",
+		addr, err, suppressed)
+}
@@ -0,0 +1,95 @@
+package video
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"nvr/pkg/log"
+	"nvr/pkg/video/gortsplib/pkg/base"
+	"nvr/pkg/video/gortsplib/pkg/conn"
+	"nvr/pkg/video/gortsplib/pkg/url"
+
+	"github.com/stretchr/testify/require"
+)
+
+// nonLoopbackIP returns an IPv4 address of the host that
+// isn't a loopback address, empty if there isn't one.
+func nonLoopbackIP(t *testing.T) string {
+	t.Helper()
+	addrs, err := net.InterfaceAddrs()
+	require.NoError(t, err)
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+			continue
+		}
+		return ipNet.IP.String()
+	}
+	return ""
+}
+
+func freePort(t *testing.T, ip string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+	require.NoError(t, err)
+	defer ln.Close()
+	return strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+}
+
+func TestRTSPServerAuthBindAddress(t *testing.T) {
+	ip := nonLoopbackIP(t)
+	if ip == "" {
+		t.Skip("no non-loopback address")
+	}
+	address := net.JoinHostPort(ip, freePort(t, ip))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	logger := log.NewLogger(&wg, nil)
+	require.NoError(t, logger.Start(ctx))
+
+	pathManager := newPathManager(&wg, logger, nil, newAudioLevels())
+	_, err := pathManager.AddPath(ctx, "mypath", PathConf{MonitorID: "x"})
+	require.NoError(t, err)
+
+	s := newRTSPServer(&wg, address, readBufferCount, 0, pathManager, logger)
+	s.readAuth = func(*http.Request) bool { return false }
+	require.NoError(t, s.start(ctx))
+
+	// The monitor pipeline publishes to the bind address.
+	nconn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer nconn.Close()
+	c := conn.NewConn(nconn)
+
+	u, err := url.Parse("rtsp://" + address + "/mypath")
+	require.NoError(t, err)
+	req := &base.Request{
+		Method: base.Announce,
+		URL:    u,
+		Header: base.Header{
+			"CSeq":         base.HeaderValue{"1"},
+			"Content-Type": base.HeaderValue{"application/sdp"},
+		},
+		Body: []byte("v=0\r\n" +
+			"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+			"s=Stream\r\n" +
+			"c=IN IP4 0.0.0.0\r\n" +
+			"t=0 0\r\n" +
+			"m=video 0 RTP/AVP 96\r\n" +
+			"a=rtpmap:96 H264/90000\r\n" +
+			"a=fmtp:96 packetization-mode=1; sprop-parameter-sets=Z2QAKKy0A8ARPyo=,aO4Bniw=\r\n" +
+			"a=control:trackID=0\r\n"),
+	}
+	require.NoError(t, c.WriteRequest(req))
+	res, err := c.ReadResponse()
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+}
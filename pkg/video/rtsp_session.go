@@ -114,6 +114,10 @@ func (s *rtspSession) onAnnounce(
 		return &base.Response{StatusCode: base.StatusBadRequest}, err
 	}
 
+	for _, warning := range s.ss.AnnounceWarnings() {
+		s.logf(log.LevelWarning, "announce: %v", warning)
+	}
+
 	s.path = path
 	s.announcedTracks = tracks
 
@@ -204,6 +208,8 @@ func (s *rtspSession) onRecord() (*base.Response, error) {
 
 // onPacketRTP is called by rtspServer.
 func (s *rtspSession) onPacketRTP(trackID int, packet *rtp.Packet) {
+	s.path.stats.onPacket(packet.MarshalSize())
+
 	var err error
 
 	switch s.announcedTracks[trackID].(type) {
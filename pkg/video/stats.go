@@ -0,0 +1,224 @@
+package video
+
+import (
+	"context"
+	"net/http"
+	"nvr/pkg/metrics"
+	"nvr/pkg/web/api"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Ingest statistics are sampled every statsInterval and
+// averaged over the last statsWindow samples.
+const (
+	statsInterval = time.Second
+	statsWindow   = 5
+)
+
+// IngestStats statistics of the stream received from the publisher.
+// Durations are in seconds.
+type IngestStats struct {
+	BytesPerSecond   float64 `json:"bytesPerSecond"`
+	PacketsPerSecond float64 `json:"packetsPerSecond"`
+	KeyframeInterval float64 `json:"keyframeInterval"`
+
+	// Zero if no keyframe has been received.
+	LastKeyframeAge float64 `json:"lastKeyframeAge"`
+
+	// Duration of the last HLS segment minus the target duration.
+	SegmentDrift float64 `json:"segmentDrift"`
+}
+
+// MonitorStats ingest statistics of the main and sub stream
+// and the memory usage of the in-memory buffers.
+type MonitorStats struct {
+	Main   *IngestStats `json:"main,omitempty"`
+	Sub    *IngestStats `json:"sub,omitempty"`
+	Memory MemoryStats  `json:"memory"`
+
+	// Nil unless the audio level is enabled and published.
+	AudioLevel *AudioLevel `json:"audioLevel,omitempty"`
+}
+
+// rollingRate average rate per second of a
+// monotonic counter over the last statsWindow samples.
+type rollingRate struct {
+	times  [statsWindow + 1]time.Time
+	totals [statsWindow + 1]int64
+	n      int // Number of samples.
+	next   int // Index of the next sample.
+}
+
+func (r *rollingRate) add(t time.Time, total int64) {
+	r.times[r.next] = t
+	r.totals[r.next] = total
+	r.next = (r.next + 1) % len(r.times)
+	if r.n < len(r.times) {
+		r.n++
+	}
+}
+
+func (r *rollingRate) perSecond() float64 {
+	if r.n < 2 {
+		return 0
+	}
+	newest := (r.next - 1 + len(r.times)) % len(r.times)
+	oldest := (r.next - r.n + len(r.times)) % len(r.times)
+
+	elapsed := r.times[newest].Sub(r.times[oldest]).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(r.totals[newest]-r.totals[oldest]) / elapsed
+}
+
+// pathStats the counters are updated on the packet
+// path without locks and sampled by the stats ticker.
+type pathStats struct {
+	bytes            atomic.Int64
+	packets          atomic.Int64
+	lastKeyframe     atomic.Int64 // UnixNano.
+	keyframeInterval atomic.Int64
+	segmentDrift     atomic.Int64
+
+	mu          sync.Mutex
+	bytesRate   rollingRate
+	packetsRate rollingRate
+}
+
+// onPacket is called for every RTP packet from the publisher.
+func (s *pathStats) onPacket(size int) {
+	s.bytes.Add(int64(size))
+	s.packets.Add(1)
+}
+
+// onKeyframe is called by the HLS muxer.
+func (s *pathStats) onKeyframe(t time.Time) {
+	prev := s.lastKeyframe.Swap(t.UnixNano())
+	if prev != 0 {
+		s.keyframeInterval.Store(t.UnixNano() - prev)
+	}
+}
+
+// onSegmentFinalized is called by the HLS muxer.
+func (s *pathStats) onSegmentFinalized(duration time.Duration) {
+	s.segmentDrift.Store(int64(duration - hlsSegmentDuration))
+}
+
+func (s *pathStats) sample(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytesRate.add(now, s.bytes.Load())
+	s.packetsRate.add(now, s.packets.Load())
+}
+
+func (s *pathStats) snapshot(now time.Time) IngestStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lastKeyframeAge time.Duration
+	if lastKeyframe := s.lastKeyframe.Load(); lastKeyframe != 0 {
+		lastKeyframeAge = now.Sub(time.Unix(0, lastKeyframe))
+	}
+
+	return IngestStats{
+		BytesPerSecond:   s.bytesRate.perSecond(),
+		PacketsPerSecond: s.packetsRate.perSecond(),
+		KeyframeInterval: time.Duration(s.keyframeInterval.Load()).Seconds(),
+		LastKeyframeAge:  lastKeyframeAge.Seconds(),
+		SegmentDrift:     time.Duration(s.segmentDrift.Load()).Seconds(),
+	}
+}
+
+// runStats samples the stats of all paths until the context is canceled.
+func (s *Server) runStats(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.pathManager.sampleStats(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// HandleStats returns the ingest statistics of all monitors,
+// or a single monitor if the "id" query parameter is set.
+func (s *Server) HandleStats() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		stats := s.pathManager.stats(time.Now())
+		for id, memoryStats := range s.memory.stats() {
+			if monitorStats, exist := stats[id]; exist {
+				monitorStats.Memory = memoryStats
+				stats[id] = monitorStats
+			}
+		}
+		for id, level := range s.audioLevels.latest() {
+			if monitorStats, exist := stats[id]; exist {
+				level := level
+				monitorStats.AudioLevel = &level
+				stats[id] = monitorStats
+			}
+		}
+
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			api.WriteJSON(w, r, stats)
+			return
+		}
+
+		monitorStats, exist := stats[id]
+		if !exist {
+			api.NotFound(w, "monitor not found")
+			return
+		}
+		api.WriteJSON(w, r, monitorStats)
+	})
+}
+
+// RegisterMetrics registers the ingest, RTSP and HLS metrics.
+func (s *Server) RegisterMetrics(r *metrics.Registry) {
+	r.MustRegister(
+		metrics.NewCounterFunc(
+			"nvr_ingest_bytes_total",
+			"Bytes received from the main and sub stream publishers.",
+			metrics.LabelMonitorID,
+			s.pathManager.ingestBytes,
+		),
+		metrics.NewGaugeFunc(
+			"nvr_rtsp_sessions",
+			"Active RTSP sessions, publishers and readers.",
+			metrics.LabelMonitorID,
+			s.pathManager.rtspSessions,
+		),
+		metrics.NewGaugeFunc(
+			"nvr_hls_viewers",
+			"Active HLS viewing sessions.",
+			metrics.LabelMonitorID,
+			s.hlsViewers,
+		),
+	)
+}
+
+func (s *Server) hlsViewers() map[string]float64 {
+	viewers := make(map[string]float64)
+	if s.hlsSessions == nil {
+		return viewers
+	}
+	for id, stats := range s.hlsSessions.viewers() {
+		viewers[id] = float64(stats.Viewers)
+	}
+	return viewers
+}
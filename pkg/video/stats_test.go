@@ -0,0 +1,147 @@
+package video
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollingRate(t *testing.T) {
+	start := time.Unix(1, 0)
+	at := func(sec int) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+
+	var r rollingRate
+	require.Equal(t, float64(0), r.perSecond())
+
+	r.add(at(0), 0)
+	require.Equal(t, float64(0), r.perSecond())
+
+	r.add(at(1), 100)
+	require.Equal(t, float64(100), r.perSecond())
+
+	r.add(at(2), 300)
+	require.Equal(t, float64(150), r.perSecond())
+
+	// Fill the window.
+	for i := 3; i <= statsWindow; i++ {
+		r.add(at(i), 300)
+	}
+	require.Equal(t, float64(60), r.perSecond())
+
+	// Oldest sample is dropped.
+	r.add(at(statsWindow+1), 300)
+	require.Equal(t, float64(40), r.perSecond())
+
+	r.add(at(statsWindow+2), 300)
+	require.Equal(t, float64(0), r.perSecond())
+
+	// Uneven intervals.
+	r.add(at(statsWindow+2).Add(500*time.Millisecond), 350)
+	require.InDelta(t, 50/4.5, r.perSecond(), 0.0001)
+}
+
+func TestPathStatsSnapshot(t *testing.T) {
+	now := time.Unix(100, 0)
+	var s pathStats
+
+	require.Equal(t, IngestStats{}, s.snapshot(now))
+
+	s.sample(now)
+	s.onPacket(1000)
+	s.onPacket(500)
+	s.onKeyframe(now.Add(-3 * time.Second))
+	s.onKeyframe(now.Add(-time.Second))
+	s.onSegmentFinalized(hlsSegmentDuration + 2*time.Second)
+	s.sample(now.Add(time.Second))
+
+	expected := IngestStats{
+		BytesPerSecond:   1500,
+		PacketsPerSecond: 2,
+		KeyframeInterval: 2,
+		LastKeyframeAge:  2,
+		SegmentDrift:     2,
+	}
+	require.Equal(t, expected, s.snapshot(now.Add(time.Second)))
+}
+
+func TestHandleStats(t *testing.T) {
+	s, cancel := newTestServer(t)
+	defer cancel()
+
+	ctx, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	_, err := s.NewPath(ctx, "x", PathConf{MonitorID: "x"})
+	require.NoError(t, err)
+	_, err = s.NewPath(ctx, "x_sub", PathConf{MonitorID: "x", IsSub: true})
+	require.NoError(t, err)
+	_, err = s.NewPath(ctx, "y", PathConf{MonitorID: "y"})
+	require.NoError(t, err)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.HandleStats().ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	zero := `{` +
+		`"bytesPerSecond":0,` +
+		`"packetsPerSecond":0,` +
+		`"keyframeInterval":0,` +
+		`"lastKeyframeAge":0,` +
+		`"segmentDrift":0` +
+		`}`
+	noMemory := `{"bytes":0,"shrinks":0}`
+
+	t.Run("all", func(t *testing.T) {
+		w := get("/api/monitor/stats")
+		require.Equal(t, http.StatusOK, w.Code)
+		expected := `{` +
+			`"x":{"main":` + zero + `,"sub":` + zero + `,"memory":` + noMemory + `},` +
+			`"y":{"main":` + zero + `,"memory":` + noMemory + `}` +
+			`}`
+		require.JSONEq(t, expected, w.Body.String())
+	})
+	t.Run("single", func(t *testing.T) {
+		w := get("/api/monitor/stats?id=y")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{"main":`+zero+`,"memory":`+noMemory+`}`, w.Body.String())
+	})
+	t.Run("memory", func(t *testing.T) {
+		unregister := s.memory.register(&memoryConsumer{
+			monitorID: "y",
+			usage:     func() int64 { return 5 },
+		})
+		defer unregister()
+		s.memory.shrinks["y"] = 2
+
+		w := get("/api/monitor/stats?id=y")
+		require.Equal(t, http.StatusOK, w.Code)
+		expected := `{"main":` + zero + `,"memory":{"bytes":5,"shrinks":2}}`
+		require.JSONEq(t, expected, w.Body.String())
+	})
+	t.Run("audioLevel", func(t *testing.T) {
+		s.audioLevels.publish("x", AudioLevel{RMS: -30, Peak: -20.5})
+		defer s.audioLevels.remove("x")
+
+		w := get("/api/monitor/stats?id=x")
+		require.Equal(t, http.StatusOK, w.Code)
+		expected := `{"main":` + zero + `,"sub":` + zero + `,"memory":` + noMemory + `,` +
+			`"audioLevel":{"rms":-30,"peak":-20.5}}`
+		require.JSONEq(t, expected, w.Body.String())
+	})
+	t.Run("notExist", func(t *testing.T) {
+		w := get("/api/monitor/stats?id=nil")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+	t.Run("methodNotAllowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/monitor/stats", nil)
+		s.HandleStats().ServeHTTP(w, r)
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
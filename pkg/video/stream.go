@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"nvr/pkg/video/gortsplib"
 	"nvr/pkg/video/gortsplib/pkg/h264"
 	"nvr/pkg/video/gortsplib/pkg/rtph264"
@@ -29,6 +30,10 @@ func newStreamTrack(track gortsplib.Track) streamTrack {
 	}
 }
 
+// RTSP readers receive the packets since the last IDR when they start
+// playing. Longer GOPs aren't cached, the readers wait for the next IDR.
+const gopCacheMaxBytes = 2 * 1000 * 1000
+
 type stream struct {
 	rtspStream   *gortsplib.ServerStream
 	hlsMuxer     *HLSMuxer
@@ -40,6 +45,7 @@ func newStream(tracks gortsplib.Tracks, hlsMuxer *HLSMuxer) *stream {
 		rtspStream: gortsplib.NewServerStream(tracks),
 		hlsMuxer:   hlsMuxer,
 	}
+	s.rtspStream.EnableGOPCache(gopCacheMaxBytes)
 
 	s.streamTracks = make([]streamTrack, len(s.rtspStream.Tracks()))
 
@@ -58,6 +64,22 @@ func (s *stream) tracks() gortsplib.Tracks {
 	return s.rtspStream.Tracks()
 }
 
+// ErrStreamNoParams the publisher hasn't provided the parameter sets yet.
+var ErrStreamNoParams = errors.New("stream parameter sets not available")
+
+// paramsReady returns ErrStreamNoParams if a H264
+// track is missing the SPS or PPS.
+func (s *stream) paramsReady() error {
+	for _, track := range s.tracks() {
+		if t, ok := track.(*gortsplib.TrackH264); ok {
+			if t.SafeSPS() == nil || t.SafePPS() == nil {
+				return ErrStreamNoParams
+			}
+		}
+	}
+	return nil
+}
+
 func (s *stream) writeData(data data) error {
 	err := s.streamTracks[data.getTrackID()].onData(data)
 	if err != nil {
@@ -69,8 +91,8 @@ func (s *stream) writeData(data data) error {
 		s.rtspStream.WritePacketRTPWithNTP(data.getTrackID(), pkt, data.getNTP())
 	}
 
-	// Forward to hls muxer.
-	s.hlsMuxer.readerData(data)
+	// Forward to hls muxer, the muxer keeps the data.
+	s.hlsMuxer.readerData(detachData(data))
 
 	return nil
 }
@@ -136,6 +158,10 @@ type streamTrackH264 struct {
 	track   *gortsplib.TrackH264
 	encoder *rtph264.Encoder
 	decoder *rtph264.Decoder
+
+	// Hashes of the last in-band parameter sets.
+	spsHash uint64
+	ppsHash uint64
 }
 
 func newStreamTrackH264(track *gortsplib.TrackH264) *streamTrackH264 {
@@ -148,12 +174,12 @@ func newStreamTrackH264(track *gortsplib.TrackH264) *streamTrackH264 {
 func (t *streamTrackH264) updateTrackParametersFromRTPPacket(pkt *rtp.Packet) {
 	sps, pps := rtpH264ExtractSPSPPS(pkt)
 
-	if sps != nil && !bytes.Equal(sps, t.track.SafeSPS()) {
-		t.track.SafeSetSPS(sps)
+	if sps != nil {
+		t.updateSPS(sps)
 	}
 
-	if pps != nil && !bytes.Equal(pps, t.track.SafePPS()) {
-		t.track.SafeSetPPS(pps)
+	if pps != nil {
+		t.updatePPS(pps)
 	}
 }
 
@@ -163,18 +189,45 @@ func (t *streamTrackH264) updateTrackParametersFromNALUs(nalus [][]byte) {
 
 		switch typ {
 		case h264.NALUTypeSPS:
-			if !bytes.Equal(nalu, t.track.SafeSPS()) {
-				t.track.SafeSetSPS(nalu)
-			}
+			t.updateSPS(nalu)
 
 		case h264.NALUTypePPS:
-			if !bytes.Equal(nalu, t.track.SafePPS()) {
-				t.track.SafeSetPPS(nalu)
-			}
+			t.updatePPS(nalu)
 		}
 	}
 }
 
+// Cameras resend the parameter sets every GOP, bit-identical repeats
+// are ignored by comparing hashes. A change is picked up by the HLS
+// segmenter on the next IDR, which starts a new segment and init file.
+func (t *streamTrackH264) updateSPS(sps []byte) {
+	hash := naluHash(sps)
+	if hash == t.spsHash {
+		return
+	}
+	t.spsHash = hash
+	if !bytes.Equal(sps, t.track.SafeSPS()) {
+		t.track.SafeSetSPS(append([]byte(nil), sps...))
+	}
+}
+
+func (t *streamTrackH264) updatePPS(pps []byte) {
+	hash := naluHash(pps)
+	if hash == t.ppsHash {
+		return
+	}
+	t.ppsHash = hash
+	if !bytes.Equal(pps, t.track.SafePPS()) {
+		t.track.SafeSetPPS(append([]byte(nil), pps...))
+	}
+}
+
+func naluHash(nalu []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(nalu)
+	return h.Sum64()
+}
+
 // remux is needed to fix corrupted streams and make streams
 // compatible with all protocols.
 func (t *streamTrackH264) remuxNALUs(nalus [][]byte) [][]byte {
@@ -268,6 +321,7 @@ func (t *streamTrackH264) onData(dat data) error {
 				InitialSequenceNumber: &v2,
 				InitialTimestamp:      &v3,
 				PacketizationMode:     1,
+				ClockRate:             t.track.ClockRate(),
 			}
 			t.encoder.Init()
 		}
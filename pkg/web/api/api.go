@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Package api provides the JSON response helpers shared by all API handlers.
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"nvr/pkg/log"
+	"strconv"
+	"time"
+)
+
+// Stable machine-readable error codes.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeLocked           = "locked"
+	CodeTooManyRequests  = "too_many_requests"
+	CodeInternal         = "internal_error"
+)
+
+// Error is the JSON envelope returned by all failed API requests.
+type Error struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Success is returned by successful mutations.
+type Success struct {
+	Status string `json:"status"`
+}
+
+const jsonContentType = "application/json"
+
+// WriteJSON encodes v as the response body.
+func WriteJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", jsonContentType)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		// Headers have already been written.
+		logError(r, "", fmt.Errorf("encode response: %w", err))
+	}
+}
+
+// WriteOK writes the response for a successful mutation.
+func WriteOK(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, r, Success{Status: "ok"})
+}
+
+// WriteError writes an error envelope with the given status code.
+func WriteError(w http.ResponseWriter, status int, e Error) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", jsonContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e) //nolint:errcheck
+}
+
+// BadRequest writes a 400 error envelope.
+func BadRequest(w http.ResponseWriter, msg string) {
+	WriteError(w, http.StatusBadRequest, Error{Code: CodeBadRequest, Message: msg})
+}
+
+// Forbidden writes a 403 error envelope.
+func Forbidden(w http.ResponseWriter, msg string) {
+	WriteError(w, http.StatusForbidden, Error{Code: CodeForbidden, Message: msg})
+}
+
+// NotFound writes a 404 error envelope.
+func NotFound(w http.ResponseWriter, msg string) {
+	WriteError(w, http.StatusNotFound, Error{Code: CodeNotFound, Message: msg})
+}
+
+// MethodNotAllowed writes a 405 error envelope.
+func MethodNotAllowed(w http.ResponseWriter) {
+	WriteError(w, http.StatusMethodNotAllowed, Error{
+		Code:    CodeMethodNotAllowed,
+		Message: "invalid request method",
+	})
+}
+
+// Conflict writes a 409 error envelope.
+func Conflict(w http.ResponseWriter, msg string) {
+	WriteError(w, http.StatusConflict, Error{Code: CodeConflict, Message: msg})
+}
+
+// Locked writes a 423 error envelope.
+func Locked(w http.ResponseWriter, msg string) {
+	WriteError(w, http.StatusLocked, Error{Code: CodeLocked, Message: msg})
+}
+
+// TooManyRequests writes a 429 error envelope. The
+// Retry-After header is rounded up to whole seconds.
+func TooManyRequests(w http.ResponseWriter, msg string, retryAfter time.Duration) {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	WriteError(w, http.StatusTooManyRequests, Error{Code: CodeTooManyRequests, Message: msg})
+}
+
+// InternalError logs the error with a correlation ID and writes a
+// 500 error envelope. The raw error is never returned to the client.
+func InternalError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	WriteError(w, http.StatusInternalServerError, NewInternalError(r, msg, err))
+}
+
+// NewInternalError logs the error with a correlation ID and returns
+// the envelope, used when the error is part of a larger response.
+func NewInternalError(r *http.Request, msg string, err error) Error {
+	id := newCorrelationID()
+	logError(r, id, fmt.Errorf("%s: %w", msg, err))
+	return Error{
+		Code:    CodeInternal,
+		Message: msg,
+		Details: map[string]string{"correlationID": id},
+	}
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+type ctxKey struct{}
+
+// Middleware makes the logger available to the helpers
+// and recovers panics into a 500 error envelope.
+func Middleware(logger log.ILogger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), ctxKey{}, logger))
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler { //nolint:errorlint,goerr113
+				panic(rec)
+			}
+			InternalError(w, r, "internal error", fmt.Errorf("panic: %v", rec)) //nolint:goerr113
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func logError(r *http.Request, id string, err error) {
+	logger, ok := r.Context().Value(ctxKey{}).(log.ILogger)
+	if !ok {
+		return
+	}
+	msg := fmt.Sprintf("%v %v: %v", r.Method, r.URL.Path, err)
+	if id != "" {
+		msg = fmt.Sprintf("%v %v [%v]: %v", r.Method, r.URL.Path, id, err)
+	}
+	logger.Log(log.Entry{
+		Level: log.LevelError,
+		Src:   "app",
+		Msg:   msg,
+	})
+}
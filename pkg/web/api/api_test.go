@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"nvr/pkg/log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) Error {
+	t.Helper()
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var e Error
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&e))
+	return e
+}
+
+// serve returns the response and the logged message.
+func serve(h http.Handler, logs chan string) (*httptest.ResponseRecorder, string) {
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/x", nil))
+		close(done)
+	}()
+	msg := <-logs
+	<-done
+	return w, msg
+}
+
+func TestErrors(t *testing.T) {
+	cases := map[string]struct {
+		write    func(http.ResponseWriter)
+		code     int
+		expected Error
+	}{
+		"badRequest": {
+			func(w http.ResponseWriter) { BadRequest(w, "a") },
+			http.StatusBadRequest,
+			Error{Code: CodeBadRequest, Message: "a"},
+		},
+		"notFound": {
+			func(w http.ResponseWriter) { NotFound(w, "b") },
+			http.StatusNotFound,
+			Error{Code: CodeNotFound, Message: "b"},
+		},
+		"methodNotAllowed": {
+			MethodNotAllowed,
+			http.StatusMethodNotAllowed,
+			Error{Code: CodeMethodNotAllowed, Message: "invalid request method"},
+		},
+		"conflict": {
+			func(w http.ResponseWriter) { Conflict(w, "c") },
+			http.StatusConflict,
+			Error{Code: CodeConflict, Message: "c"},
+		},
+		"locked": {
+			func(w http.ResponseWriter) { Locked(w, "d") },
+			http.StatusLocked,
+			Error{Code: CodeLocked, Message: "d"},
+		},
+		"tooManyRequests": {
+			func(w http.ResponseWriter) { TooManyRequests(w, "e", 1500*time.Millisecond) },
+			http.StatusTooManyRequests,
+			Error{Code: CodeTooManyRequests, Message: "e"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tc.write(w)
+			require.Equal(t, tc.code, w.Code)
+			require.Equal(t, tc.expected, decodeError(t, w))
+		})
+	}
+}
+
+func TestTooManyRequestsRetryAfter(t *testing.T) {
+	w := httptest.NewRecorder()
+	TooManyRequests(w, "a", 1500*time.Millisecond)
+	require.Equal(t, "2", w.Header().Get("Retry-After"))
+}
+
+func TestInternalError(t *testing.T) {
+	logger, logs := log.NewMockLogger()
+	h := Middleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		InternalError(w, r, "could not x", errors.New("/secret/path"))
+	}))
+
+	w, msg := serve(h, logs)
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	e := decodeError(t, w)
+	require.Equal(t, CodeInternal, e.Code)
+	require.Equal(t, "could not x", e.Message)
+
+	id := e.Details["correlationID"]
+	require.Len(t, id, 16)
+	require.Contains(t, msg, id)
+	require.Contains(t, msg, "/secret/path")
+}
+
+func TestMiddlewareRecover(t *testing.T) {
+	logger, logs := log.NewMockLogger()
+	h := Middleware(logger, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("mock")
+	}))
+
+	w, msg := serve(h, logs)
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	e := decodeError(t, w)
+	require.Equal(t, CodeInternal, e.Code)
+	require.Contains(t, msg, "panic: mock")
+}
+
+func TestWriteOK(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteOK(w, httptest.NewRequest(http.MethodPut, "/api/x", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.Equal(t, `{"status":"ok"}`+"\n", w.Body.String())
+}
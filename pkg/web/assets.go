@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"nvr/pkg/web/api"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors.
+var (
+	ErrAssetPrefixInvalid = errors.New("prefix must be a clean path inside /static/")
+	ErrAssetPrefixExist   = errors.New("prefix is already registered")
+	ErrAssetNotExist      = errors.New("asset does not exist")
+)
+
+const (
+	assetRoot = "/static/"
+
+	// Length of the truncated hex encoded hash.
+	assetHashLength = 16
+
+	cacheControlImmutable = "public, max-age=31536000, immutable"
+)
+
+// Assets serves static files from multiple file systems. The URLs
+// returned by URL includes a hash of the file content, hashed
+// requests are cached by the browser until the file changes.
+// The file systems must not change after they're registered.
+type Assets struct {
+	// Sorted by prefix length, longest first.
+	mounts []assetMount
+
+	mu     sync.Mutex
+	hashes map[string]string
+}
+
+type assetMount struct {
+	prefix string
+	fsys   fs.FS
+}
+
+// NewAssets returns a empty asset registry.
+func NewAssets() *Assets {
+	return &Assets{hashes: make(map[string]string)}
+}
+
+// Register mounts the file system at the prefix, "/static/doods/".
+// Files in mounts with longer prefixes shadow the shorter ones.
+func (a *Assets) Register(prefix string, fsys fs.FS) error {
+	if !strings.HasPrefix(prefix, assetRoot) ||
+		!strings.HasSuffix(prefix, "/") ||
+		(prefix != assetRoot && path.Clean(prefix)+"/" != prefix) {
+		return fmt.Errorf("%w: %q", ErrAssetPrefixInvalid, prefix)
+	}
+	for _, m := range a.mounts {
+		if m.prefix == prefix {
+			return fmt.Errorf("%w: %q", ErrAssetPrefixExist, prefix)
+		}
+	}
+	a.mounts = append(a.mounts, assetMount{prefix: prefix, fsys: fsys})
+	sort.SliceStable(a.mounts, func(i, j int) bool {
+		return len(a.mounts[i].prefix) > len(a.mounts[j].prefix)
+	})
+	return nil
+}
+
+// read returns the content of the file at the URL path.
+func (a *Assets) read(urlPath string) ([]byte, error) {
+	for _, m := range a.mounts {
+		if !strings.HasPrefix(urlPath, m.prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(urlPath, m.prefix)
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("%w: %q", ErrAssetNotExist, urlPath)
+		}
+		info, err := fs.Stat(m.fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrAssetNotExist, urlPath)
+		}
+		if info.IsDir() {
+			return nil, fmt.Errorf("%w: %q is a directory", ErrAssetNotExist, urlPath)
+		}
+		return fs.ReadFile(m.fsys, name)
+	}
+	return nil, fmt.Errorf("%w: %q", ErrAssetNotExist, urlPath)
+}
+
+// hash returns the cached content hash of the file.
+func (a *Assets) hash(urlPath string, content []byte) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if hash, exist := a.hashes[urlPath]; exist {
+		return hash
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])[:assetHashLength]
+	a.hashes[urlPath] = hash
+	return hash
+}
+
+// URL returns the cache busting URL of a file relative to
+// "/static/". Used by the "asset" template function.
+//
+//	{{ asset "doods/doods.mjs" }} -> ./static/doods/doods.mjs?v=0123456789abcdef
+func (a *Assets) URL(name string) (string, error) {
+	urlPath := assetRoot + strings.TrimPrefix(name, "/")
+	content, err := a.read(urlPath)
+	if err != nil {
+		return "", err
+	}
+	return "." + urlPath + "?v=" + a.hash(urlPath, content), nil
+}
+
+// Handler serves the assets. Requests with the current hash
+// are marked as immutable, other requests must be revalidated.
+func (a *Assets) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		content, err := a.read(r.URL.Path)
+		if errors.Is(err, ErrAssetNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, "could not read asset", http.StatusInternalServerError)
+			return
+		}
+
+		hash := a.hash(r.URL.Path, content)
+		if r.URL.Query().Get("v") == hash {
+			w.Header().Set("Cache-Control", cacheControlImmutable)
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		w.Header().Set("ETag", `"`+hash+`"`)
+		if contentType := assetContentType(r.URL.Path); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(content))
+	})
+}
+
+// assetContentType returns "" if the type should be sniffed.
+// JavaScript modules are rejected by browsers if the type is wrong,
+// the system mime database doesn't always know about ".mjs".
+func assetContentType(name string) string {
+	switch ext := path.Ext(name); ext {
+	case ".js", ".mjs":
+		return "text/javascript; charset=utf-8"
+	default:
+		return mime.TypeByExtension(ext)
+	}
+}
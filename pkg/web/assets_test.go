@@ -0,0 +1,166 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAssets(t *testing.T) *Assets {
+	t.Helper()
+	assets := NewAssets()
+	require.NoError(t, assets.Register("/static/", fstest.MapFS{
+		"style/style.css":    {Data: []byte("body {}")},
+		"scripts/common.mjs": {Data: []byte("export {}")},
+		"doods/shadowed.mjs": {Data: []byte("core")},
+	}))
+	require.NoError(t, assets.Register("/static/doods/", fstest.MapFS{
+		"doods.mjs":    {Data: []byte("export function doods() {}")},
+		"shadowed.mjs": {Data: []byte("addon")},
+	}))
+	return assets
+}
+
+func TestAssetsRegister(t *testing.T) {
+	assets := newTestAssets(t)
+
+	err := assets.Register("/static/doods/", fstest.MapFS{})
+	require.ErrorIs(t, err, ErrAssetPrefixExist)
+
+	for _, prefix := range []string{
+		"", "/", "/doods/", "/static", "/static/doods", "static/doods/",
+		"/static/../doods/", "/static/doods//", "/static/./doods/",
+	} {
+		err := assets.Register(prefix, fstest.MapFS{})
+		require.ErrorIs(t, err, ErrAssetPrefixInvalid, prefix)
+	}
+}
+
+func TestAssetsURL(t *testing.T) {
+	assets := newTestAssets(t)
+
+	url, err := assets.URL("doods/doods.mjs")
+	require.NoError(t, err)
+	require.Equal(t, "./static/doods/doods.mjs?v=b2be671e657ccf2c", url)
+
+	// Stable.
+	url2, err := newTestAssets(t).URL("/doods/doods.mjs")
+	require.NoError(t, err)
+	require.Equal(t, url, url2)
+
+	// The hash changes with the content.
+	url3, err := assets.URL("doods/shadowed.mjs")
+	require.NoError(t, err)
+	require.NotEqual(t, url[len(url)-16:], url3[len(url3)-16:])
+
+	for _, name := range []string{"nil", "doods", "doods/", "../doods/doods.mjs"} {
+		_, err = assets.URL(name)
+		require.ErrorIs(t, err, ErrAssetNotExist, name)
+	}
+}
+
+func TestAssetsHandler(t *testing.T) {
+	handler := newTestAssets(t).Handler()
+	request := func(method string, target string) *http.Response {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w.Result()
+	}
+	readBody := func(t *testing.T, res *http.Response) string {
+		t.Helper()
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	t.Run("hashed", func(t *testing.T) {
+		res := request(http.MethodGet, "/static/doods/doods.mjs?v=b2be671e657ccf2c")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, "public, max-age=31536000, immutable", res.Header.Get("Cache-Control"))
+		require.Equal(t, "text/javascript; charset=utf-8", res.Header.Get("Content-Type"))
+		require.Equal(t, `"b2be671e657ccf2c"`, res.Header.Get("ETag"))
+		require.Equal(t, "export function doods() {}", readBody(t, res))
+	})
+	t.Run("unhashed", func(t *testing.T) {
+		for _, target := range []string{
+			"/static/doods/doods.mjs",
+			"/static/doods/doods.mjs?v=0123456789abcdef",
+		} {
+			res := request(http.MethodGet, target)
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			require.Equal(t, "no-cache", res.Header.Get("Cache-Control"))
+		}
+	})
+	t.Run("contentType", func(t *testing.T) {
+		res := request(http.MethodGet, "/static/style/style.css")
+		require.Equal(t, "text/css; charset=utf-8", res.Header.Get("Content-Type"))
+
+		res = request(http.MethodGet, "/static/scripts/common.mjs")
+		require.Equal(t, "text/javascript; charset=utf-8", res.Header.Get("Content-Type"))
+	})
+	t.Run("shadowed", func(t *testing.T) {
+		res := request(http.MethodGet, "/static/doods/shadowed.mjs")
+		require.Equal(t, "addon", readBody(t, res))
+	})
+	t.Run("notModified", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/static/doods/doods.mjs", nil)
+		r.Header.Set("If-None-Match", `"b2be671e657ccf2c"`)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusNotModified, w.Code)
+	})
+	t.Run("notFound", func(t *testing.T) {
+		for _, target := range []string{
+			"/static/nil.mjs",
+			"/static/doods/nil.mjs",
+			"/static/doods/",
+			"/static/scripts",
+			"/nil",
+		} {
+			res := request(http.MethodGet, target)
+			require.Equal(t, http.StatusNotFound, res.StatusCode, target)
+		}
+	})
+	t.Run("methodNotAllowed", func(t *testing.T) {
+		res := request(http.MethodPost, "/static/doods/doods.mjs")
+		require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	})
+}
+
+func TestTemplaterAsset(t *testing.T) {
+	hooks := TemplateHooks{
+		Tpl: func(pageFiles map[string]string) error {
+			pageFiles["asset.tpl"] = `<script src="{{ asset "scripts/common.mjs" }}"></script>` +
+				`<script type="module">import "{{ asset "doods/doods.mjs" }}";</script>`
+			return nil
+		},
+		Sub: func(map[string]string) error { return nil },
+	}
+	templater, err := NewTemplater(&stubAuth{}, newTestAssets(t), hooks)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	templater.Render("asset.tpl").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	expected := `<script src="./static/scripts/common.mjs?v=f4c5cf9bb78e85f1"></script>` +
+		`<script type="module">import ".\/static\/doods\/doods.mjs?v=b2be671e657ccf2c";</script>`
+	require.Equal(t, expected, w.Body.String())
+
+	t.Run("notExist", func(t *testing.T) {
+		hooks.Tpl = func(pageFiles map[string]string) error {
+			pageFiles["asset.tpl"] = `{{ asset "nil" }}`
+			return nil
+		}
+		templater, err := NewTemplater(&stubAuth{}, newTestAssets(t), hooks)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		templater.Render("asset.tpl").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
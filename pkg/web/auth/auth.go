@@ -4,9 +4,11 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"fmt"
 	"net/http"
+	"nvr/pkg/audit"
 	"nvr/pkg/log"
 	"nvr/pkg/storage"
 
@@ -33,6 +35,10 @@ type AccountObfuscated struct {
 type ValidateResponse struct {
 	IsValid bool
 	User    Account
+	Source  string // How the user was authenticated.
+
+	// Session of the request, empty if the request doesn't have a session.
+	SessionID string
 }
 
 // SetUserRequest set user details request.
@@ -41,10 +47,13 @@ type SetUserRequest struct {
 	Username      string `json:"username"`
 	PlainPassword string `json:"plainPassword,omitempty"`
 	IsAdmin       bool   `json:"isAdmin"`
+
+	// Session that isn't revoked when the password is changed.
+	KeepSessionID string `json:"-"`
 }
 
 // NewAuthenticatorFunc function to create authenticator.
-type NewAuthenticatorFunc func(storage.ConfigEnv, *log.Logger) (Authenticator, error)
+type NewAuthenticatorFunc func(storage.ConfigEnv, *log.Logger, audit.Func) (Authenticator, error)
 
 // Authenticator is responsible for blocking all
 // unauthenticated requests and storing user information.
@@ -61,10 +70,21 @@ type Authenticator interface {
 	// UserDelete deletes a user by id.
 	UserDelete(string) error
 
+	// SessionsList returns the sessions of a account,
+	// the sessions of all accounts if the id is empty.
+	SessionsList(accountID string) []Session
+	// SessionRevoke revokes a session by id. The session must
+	// belong to the account unless the account id is empty.
+	SessionRevoke(id string, accountID string) error
+	// SessionsRevokeAll revokes all sessions of a account except keepID.
+	SessionsRevokeAll(accountID string, keepID string) error
+
 	// Handler wrappers.
 	// User blocks unauthenticated requests.
+	// Non-GET requests require a valid CSRF-token, see ValidCSRF.
 	User(http.Handler) http.Handler
 	// Admin only allows authenticated requests from users with admin privileges.
+	// Non-GET requests require a valid CSRF-token, see ValidCSRF.
 	Admin(http.Handler) http.Handler
 	// CSRF blocks invalid Cross-site request forgery tokens.
 	// Each user has a unique token. The request needs to
@@ -76,8 +96,32 @@ type Authenticator interface {
 	Logout() http.Handler
 }
 
-// LogFailedLogin finds and logs the ip.
-func LogFailedLogin(logger *log.Logger, r *http.Request, username string) {
+// LogFailedLogin finds and logs the ip and records it in the audit log.
+func LogFailedLogin(logger *log.Logger, auditf audit.Func, r *http.Request, username string) {
+	ip := RequestIP(r)
+
+	logger.Log(log.Entry{
+		Level: log.LevelInfo,
+		Src:   "auth",
+		Msg:   fmt.Sprintf("failed login: username: %v %v\n", username, ip),
+	})
+
+	err := auditf(audit.Entry{
+		User:   username,
+		IP:     ip,
+		Action: audit.ActionLoginFailed,
+	})
+	if err != nil {
+		logger.Log(log.Entry{
+			Level: log.LevelError,
+			Src:   "auth",
+			Msg:   fmt.Sprintf("could not write audit entry: %v", err),
+		})
+	}
+}
+
+// RequestIP returns the real, forwarded and remote address of the request.
+func RequestIP(r *http.Request) string {
 	ip := ""
 	realIP := r.Header.Get("X-Real-Ip")
 	if realIP != "" {
@@ -91,12 +135,32 @@ func LogFailedLogin(logger *log.Logger, r *http.Request, username string) {
 	if remoteAddr != "" && remoteAddr != forwarded {
 		ip += "addr:" + remoteAddr
 	}
+	return ip
+}
 
-	logger.Log(log.Entry{
-		Level: log.LevelInfo,
-		Src:   "auth",
-		Msg:   fmt.Sprintf("failed login: username: %v %v\n", username, ip),
-	})
+// CSRF-token locations. Requests from the web interface
+// use the header, the form field is for HTML forms.
+const (
+	CSRFHeader    = "X-CSRF-TOKEN"
+	CSRFFormField = "csrfToken"
+)
+
+// ValidCSRF returns true if the request method is safe or if the request
+// has a token in the header or form field that matches the user token.
+func ValidCSRF(r *http.Request, userToken string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	token := r.Header.Get(CSRFHeader)
+	if token == "" {
+		token = r.PostFormValue(CSRFFormField)
+	}
+	if token == "" || userToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(userToken)) == 1
 }
 
 // GenToken generates a CSRF-token.
@@ -107,6 +171,3 @@ func GenToken() string {
 	}
 	return hex.EncodeToString(b)
 }
-
-// DefaultBcryptHashCost bcrypt hash cost.
-const DefaultBcryptHashCost = 10
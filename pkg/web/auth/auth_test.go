@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidCSRF(t *testing.T) {
+	newRequest := func(method string, header http.Header, form url.Values) *http.Request {
+		body := ""
+		if form != nil {
+			body = form.Encode()
+		}
+		r := httptest.NewRequest(method, "/", strings.NewReader(body))
+		if form != nil {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		return r
+	}
+	header := func(k, v string) http.Header {
+		h := http.Header{}
+		h.Set(k, v)
+		return h
+	}
+
+	cases := map[string]struct {
+		r         *http.Request
+		userToken string
+		expected  bool
+	}{
+		"get": {
+			newRequest(http.MethodGet, nil, nil), "a", true,
+		},
+		"missing": {
+			newRequest(http.MethodPost, nil, nil), "a", false,
+		},
+		"header": {
+			newRequest(http.MethodPost, header(CSRFHeader, "a"), nil), "a", true,
+		},
+		"headerCase": {
+			newRequest(http.MethodPut, header("X-Csrf-Token", "a"), nil), "a", true,
+		},
+		"wrongToken": {
+			newRequest(http.MethodPost, header(CSRFHeader, "b"), nil), "a", false,
+		},
+		"emptyUserToken": {
+			newRequest(http.MethodPost, header(CSRFHeader, ""), nil), "", false,
+		},
+		"formField": {
+			newRequest(http.MethodPost, nil, url.Values{CSRFFormField: {"a"}}), "a", true,
+		},
+		"bearer": {
+			newRequest(http.MethodDelete, header("Authorization", "Bearer x"), nil), "a", false,
+		},
+		"basic": {
+			newRequest(http.MethodDelete, header("Authorization", "Basic x"), nil), "a", false,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, ValidCSRF(tc.r, tc.userToken))
+		})
+	}
+}
@@ -0,0 +1,229 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"nvr/pkg/storage"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashes are stored as modular crypt strings, the algorithm
+// and parameters are stored with each hash so they can be changed
+// without breaking existing accounts.
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+//
+// Legacy accounts use bcrypt "$2a$10$...", they are verified
+// and rehashed to the preferred scheme on the next login.
+
+// Default argon2id parameters.
+const (
+	DefaultArgon2Memory     = 19 * 1024 // KiB.
+	DefaultArgon2Iterations = 2
+	DefaultArgon2Threads    = 1
+)
+
+const (
+	argon2SaltSize = 16
+	argon2KeySize  = 32
+)
+
+// Errors.
+var (
+	ErrHashUnknownScheme = errors.New("unknown password hash scheme")
+	ErrHashInvalid       = errors.New("invalid password hash")
+	ErrHashVersion       = errors.New("unsupported argon2 version")
+)
+
+// PasswordHasher hashes new passwords with the preferred scheme.
+type PasswordHasher struct {
+	params argon2Params
+}
+
+// NewPasswordHasher creates a hasher, zero values use the defaults.
+func NewPasswordHasher(config storage.PasswordHashConfig) PasswordHasher {
+	params := argon2Params{
+		memory:     DefaultArgon2Memory,
+		iterations: DefaultArgon2Iterations,
+		threads:    DefaultArgon2Threads,
+	}
+	if config.Memory > 0 {
+		params.memory = uint32(config.Memory)
+	}
+	if config.Iterations > 0 {
+		params.iterations = uint32(config.Iterations)
+	}
+	return PasswordHasher{params: params}
+}
+
+// Hash returns the modular crypt string of a new password.
+func (h PasswordHasher) Hash(password string) ([]byte, error) {
+	salt := make([]byte, argon2SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generate salt: %w", err)
+	}
+	hash := argon2Hash{
+		params: h.params,
+		salt:   salt,
+		key:    h.params.key(password, salt, argon2KeySize),
+	}
+	return []byte(hash.String()), nil
+}
+
+// Verify compares a password with a stored hash of any supported scheme.
+// needsRehash is true if the password matched but the hash doesn't
+// use the preferred scheme and parameters.
+func (h PasswordHasher) Verify(hash []byte, password string) (ok bool, needsRehash bool) {
+	stored, err := parsePasswordHash(hash)
+	if err != nil {
+		return false, false
+	}
+	if !stored.verify(password) {
+		return false, false
+	}
+	return true, !stored.isPreferred(h.params)
+}
+
+// passwordHash is a parsed password hash.
+type passwordHash interface {
+	verify(password string) bool
+	isPreferred(argon2Params) bool
+}
+
+// parsePasswordHash detects the scheme of a stored password hash.
+func parsePasswordHash(hash []byte) (passwordHash, error) {
+	s := string(hash)
+	switch {
+	case strings.HasPrefix(s, "$argon2id$"):
+		return parseArgon2Hash(s)
+	case isBcryptHash(s):
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrHashInvalid, err)
+		}
+		return bcryptHash(hash), nil
+	default:
+		return nil, ErrHashUnknownScheme
+	}
+}
+
+func isBcryptHash(s string) bool {
+	for _, prefix := range []string{"$2$", "$2a$", "$2b$", "$2x$", "$2y$"} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// bcryptHash legacy scheme, always rehashed.
+type bcryptHash []byte
+
+func (h bcryptHash) verify(password string) bool {
+	return bcrypt.CompareHashAndPassword(h, []byte(password)) == nil
+}
+
+func (h bcryptHash) isPreferred(argon2Params) bool {
+	return false
+}
+
+type argon2Params struct {
+	memory     uint32
+	iterations uint32
+	threads    uint8
+}
+
+func (p argon2Params) key(password string, salt []byte, size uint32) []byte {
+	return argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.threads, size)
+}
+
+type argon2Hash struct {
+	params argon2Params
+	salt   []byte
+	key    []byte
+}
+
+func (h argon2Hash) String() string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.params.memory,
+		h.params.iterations,
+		h.params.threads,
+		base64.RawStdEncoding.EncodeToString(h.salt),
+		base64.RawStdEncoding.EncodeToString(h.key),
+	)
+}
+
+func (h argon2Hash) verify(password string) bool {
+	key := h.params.key(password, h.salt, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+func (h argon2Hash) isPreferred(params argon2Params) bool {
+	return h.params == params &&
+		len(h.salt) == argon2SaltSize &&
+		len(h.key) == argon2KeySize
+}
+
+// parseArgon2Hash "$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>".
+func parseArgon2Hash(s string) (argon2Hash, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 {
+		return argon2Hash{}, fmt.Errorf("%w: expected 6 fields, got %d", ErrHashInvalid, len(parts))
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return argon2Hash{}, fmt.Errorf("%w: version: %w", ErrHashInvalid, err)
+	}
+	if version != argon2.Version {
+		return argon2Hash{}, fmt.Errorf("%w: %d", ErrHashVersion, version)
+	}
+
+	var h argon2Hash
+	for _, param := range strings.Split(parts[3], ",") {
+		key, value, _ := strings.Cut(param, "=")
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || n == 0 {
+			return argon2Hash{}, fmt.Errorf("%w: parameter: %q", ErrHashInvalid, param)
+		}
+		switch key {
+		case "m":
+			h.params.memory = uint32(n)
+		case "t":
+			h.params.iterations = uint32(n)
+		case "p":
+			if n > 255 {
+				return argon2Hash{}, fmt.Errorf("%w: parameter: %q", ErrHashInvalid, param)
+			}
+			h.params.threads = uint8(n)
+		default:
+			return argon2Hash{}, fmt.Errorf("%w: parameter: %q", ErrHashInvalid, param)
+		}
+	}
+	if h.params.memory == 0 || h.params.iterations == 0 || h.params.threads == 0 {
+		return argon2Hash{}, fmt.Errorf("%w: missing parameter", ErrHashInvalid)
+	}
+
+	var err error
+	h.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2Hash{}, fmt.Errorf("%w: salt: %w", ErrHashInvalid, err)
+	}
+	h.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return argon2Hash{}, fmt.Errorf("%w: key: %w", ErrHashInvalid, err)
+	}
+	if len(h.key) == 0 {
+		return argon2Hash{}, fmt.Errorf("%w: empty key", ErrHashInvalid)
+	}
+	return h, nil
+}
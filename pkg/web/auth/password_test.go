@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHasher() PasswordHasher {
+	return NewPasswordHasher(storage.PasswordHashConfig{Memory: 64, Iterations: 1})
+}
+
+func TestNewPasswordHasher(t *testing.T) {
+	h := NewPasswordHasher(storage.PasswordHashConfig{})
+	require.Equal(t, argon2Params{memory: 19456, iterations: 2, threads: 1}, h.params)
+
+	h = NewPasswordHasher(storage.PasswordHashConfig{Memory: 1024, Iterations: 3})
+	require.Equal(t, argon2Params{memory: 1024, iterations: 3, threads: 1}, h.params)
+}
+
+func TestPasswordHash(t *testing.T) {
+	h := newTestHasher()
+
+	hash, err := h.Hash("pass")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(hash), "$argon2id$v=19$m=64,t=1,p=1$"), hash)
+
+	ok, needsRehash := h.Verify(hash, "pass")
+	require.True(t, ok)
+	require.False(t, needsRehash)
+
+	ok, _ = h.Verify(hash, "wrong")
+	require.False(t, ok)
+
+	// Salted.
+	hash2, err := h.Hash("pass")
+	require.NoError(t, err)
+	require.NotEqual(t, hash, hash2)
+
+	// The stored parameters are used.
+	h2 := NewPasswordHasher(storage.PasswordHashConfig{Memory: 128, Iterations: 2})
+	ok, needsRehash = h2.Verify(hash, "pass")
+	require.True(t, ok)
+	require.True(t, needsRehash)
+}
+
+func TestPasswordHashLegacy(t *testing.T) {
+	h := newTestHasher()
+
+	// bcrypt "pass1".
+	legacy := []byte("$2a$04$M0InS5zIFKk.xmjtcabjrudhKhukxJo6cnhJBq9I.J/slbgWE0F.S")
+
+	ok, needsRehash := h.Verify(legacy, "pass1")
+	require.True(t, ok)
+	require.True(t, needsRehash)
+
+	ok, needsRehash = h.Verify(legacy, "wrong")
+	require.False(t, ok)
+	require.False(t, needsRehash)
+}
+
+func TestParsePasswordHash(t *testing.T) {
+	hash, err := newTestHasher().Hash("pass")
+	require.NoError(t, err)
+	_, err = parsePasswordHash(hash)
+	require.NoError(t, err)
+
+	cases := []struct {
+		hash     string
+		expected error
+	}{
+		{"", ErrHashUnknownScheme},
+		{"pass", ErrHashUnknownScheme},
+		{"$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5", ErrHashUnknownScheme},
+		{"$2a$04$x", ErrHashInvalid},
+		{"$argon2id$v=19$m=64,t=1,p=1$c2FsdA", ErrHashInvalid},
+		{"$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5", ErrHashVersion},
+		{"$argon2id$v=x$m=64,t=1,p=1$c2FsdA$a2V5", ErrHashInvalid},
+		{"$argon2id$v=19$m=64,t=1$c2FsdA$a2V5", ErrHashInvalid},
+		{"$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5", ErrHashInvalid},
+		{"$argon2id$v=19$m=64,t=1,p=256$c2FsdA$a2V5", ErrHashInvalid},
+		{"$argon2id$v=19$m=64,t=1,p=1,x=1$c2FsdA$a2V5", ErrHashInvalid},
+		{"$argon2id$v=19$m=64,t=1,p=1$!$a2V5", ErrHashInvalid},
+		{"$argon2id$v=19$m=64,t=1,p=1$c2FsdA$", ErrHashInvalid},
+	}
+	for _, tc := range cases {
+		t.Run(tc.hash, func(t *testing.T) {
+			_, err := parsePasswordHash([]byte(tc.hash))
+			require.ErrorIs(t, err, tc.expected)
+
+			ok, _ := newTestHasher().Verify([]byte(tc.hash), "pass")
+			require.False(t, ok)
+		})
+	}
+}
@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"nvr/pkg/storage"
+	"strings"
+)
+
+// Authentication sources, used in the audit log.
+const (
+	SourceBasic = "basic"
+	SourceProxy = "proxy"
+	SourceSetup = "setup" // Unauthenticated first-run setup.
+)
+
+// Default proxy auth headers.
+const (
+	DefaultProxyUserHeader   = "Remote-User"
+	DefaultProxyGroupsHeader = "Remote-Groups"
+)
+
+// ProxyAuth identifies users from the headers set by a authenticating
+// reverse proxy like Authelia. The headers are ignored unless the
+// request comes directly from one of the trusted proxies.
+type ProxyAuth struct {
+	trustedProxies []*net.IPNet
+	userHeader     string
+	groupsHeader   string
+	adminGroups    map[string]struct{}
+	userGroups     map[string]struct{}
+	autoProvision  bool
+}
+
+// ProxyIdentity identity provided by a trusted proxy.
+type ProxyIdentity struct {
+	Username string
+
+	// HasGroups is false if the proxy did not send any
+	// groups, the role of the account should be used.
+	HasGroups bool
+	IsAdmin   bool
+}
+
+// ErrNoTrustedProxies proxy auth enabled without trusted proxies.
+var ErrNoTrustedProxies = errors.New("no trusted proxies")
+
+// NewProxyAuth returns nil if proxy auth is disabled.
+func NewProxyAuth(c storage.ProxyAuthConfig) (*ProxyAuth, error) {
+	if !c.Enable {
+		return nil, nil //nolint:nilnil
+	}
+	if len(c.TrustedProxies) == 0 {
+		return nil, ErrNoTrustedProxies
+	}
+
+	p := &ProxyAuth{
+		userHeader:    c.UserHeader,
+		groupsHeader:  c.GroupsHeader,
+		adminGroups:   toSet(c.AdminGroups),
+		userGroups:    toSet(c.UserGroups),
+		autoProvision: c.AutoProvision,
+	}
+	for _, cidr := range c.TrustedProxies {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy: %w", err)
+		}
+		p.trustedProxies = append(p.trustedProxies, ipNet)
+	}
+	if p.userHeader == "" {
+		p.userHeader = DefaultProxyUserHeader
+	}
+	if p.groupsHeader == "" {
+		p.groupsHeader = DefaultProxyGroupsHeader
+	}
+	return p, nil
+}
+
+func toSet(list []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(list))
+	for _, v := range list {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+// AutoProvision if accounts should be created for unknown users.
+func (p *ProxyAuth) AutoProvision() bool {
+	return p.autoProvision
+}
+
+// Identify returns the identity from the request headers. Returns false if
+// the request isn't from a trusted proxy, if the user header is missing
+// or if the user isn't a member of any allowed group.
+func (p *ProxyAuth) Identify(r *http.Request) (ProxyIdentity, bool) {
+	if !p.isTrusted(r.RemoteAddr) {
+		return ProxyIdentity{}, false
+	}
+
+	username := strings.ToLower(strings.TrimSpace(r.Header.Get(p.userHeader)))
+	if username == "" {
+		return ProxyIdentity{}, false
+	}
+
+	rawGroups := r.Header.Get(p.groupsHeader)
+	if rawGroups == "" {
+		if len(p.userGroups) != 0 {
+			return ProxyIdentity{}, false
+		}
+		return ProxyIdentity{Username: username}, true
+	}
+
+	isAdmin, isUser := false, len(p.userGroups) == 0
+	for _, group := range strings.Split(rawGroups, ",") {
+		group = strings.TrimSpace(group)
+		if _, exist := p.adminGroups[group]; exist {
+			isAdmin = true
+		}
+		if _, exist := p.userGroups[group]; exist {
+			isUser = true
+		}
+	}
+	if !isAdmin && !isUser {
+		return ProxyIdentity{}, false
+	}
+	return ProxyIdentity{
+		Username:  username,
+		HasGroups: true,
+		IsAdmin:   isAdmin,
+	}, true
+}
+
+// isTrusted only checks the address of the direct connection,
+// forwarding headers can be set by anyone.
+func (p *ProxyAuth) isTrusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range p.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
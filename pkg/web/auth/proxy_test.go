@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package auth
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProxyAuth(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		p, err := NewProxyAuth(storage.ProxyAuthConfig{})
+		require.NoError(t, err)
+		require.Nil(t, p)
+	})
+	t.Run("defaultHeaders", func(t *testing.T) {
+		p, err := NewProxyAuth(storage.ProxyAuthConfig{
+			Enable:         true,
+			TrustedProxies: []string{"10.0.0.0/8"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, DefaultProxyUserHeader, p.userHeader)
+		require.Equal(t, DefaultProxyGroupsHeader, p.groupsHeader)
+	})
+	t.Run("noTrustedProxies", func(t *testing.T) {
+		_, err := NewProxyAuth(storage.ProxyAuthConfig{Enable: true})
+		require.ErrorIs(t, err, ErrNoTrustedProxies)
+	})
+	t.Run("invalidCIDR", func(t *testing.T) {
+		_, err := NewProxyAuth(storage.ProxyAuthConfig{
+			Enable:         true,
+			TrustedProxies: []string{"10.0.0.1"},
+		})
+		var parseErr *net.ParseError
+		require.ErrorAs(t, err, &parseErr)
+	})
+}
+
+func TestProxyAuthIdentify(t *testing.T) {
+	newRequest := func(remoteAddr string, user string, groups string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		if user != "" {
+			r.Header.Set("Remote-User", user)
+		}
+		if groups != "" {
+			r.Header.Set("Remote-Groups", groups)
+		}
+		return r
+	}
+
+	t.Run("spoofed", func(t *testing.T) {
+		p, err := NewProxyAuth(storage.ProxyAuthConfig{
+			Enable:         true,
+			TrustedProxies: []string{"10.0.0.0/24", "::1/128"},
+			AdminGroups:    []string{"admins"},
+		})
+		require.NoError(t, err)
+
+		cases := map[string]*http.Request{
+			"untrusted":   newRequest("10.0.1.1:1234", "admin", "admins"),
+			"untrustedV6": newRequest("[::2]:1234", "admin", "admins"),
+			"invalidAddr": newRequest("x", "admin", "admins"),
+			"forwarded": func() *http.Request {
+				r := newRequest("192.168.1.1:1234", "admin", "admins")
+				r.Header.Set("X-Forwarded-For", "10.0.0.1")
+				r.Header.Set("X-Real-Ip", "10.0.0.1")
+				return r
+			}(),
+		}
+		for name, r := range cases {
+			t.Run(name, func(t *testing.T) {
+				_, ok := p.Identify(r)
+				require.False(t, ok)
+			})
+		}
+
+		identity, ok := p.Identify(newRequest("[::1]:1234", "admin", "admins"))
+		require.True(t, ok)
+		require.Equal(t, "admin", identity.Username)
+	})
+	t.Run("groupMapping", func(t *testing.T) {
+		p, err := NewProxyAuth(storage.ProxyAuthConfig{
+			Enable:         true,
+			TrustedProxies: []string{"10.0.0.0/24"},
+			AdminGroups:    []string{"admins"},
+			UserGroups:     []string{"nvr", "staff"},
+		})
+		require.NoError(t, err)
+
+		cases := map[string]struct {
+			user       string
+			groups     string
+			expected   ProxyIdentity
+			expectedOK bool
+		}{
+			"admin": {
+				"A", "dev, admins",
+				ProxyIdentity{Username: "a", HasGroups: true, IsAdmin: true}, true,
+			},
+			"user": {
+				"b", "staff",
+				ProxyIdentity{Username: "b", HasGroups: true, IsAdmin: false}, true,
+			},
+			"adminAndUser": {
+				"c", "nvr,admins",
+				ProxyIdentity{Username: "c", HasGroups: true, IsAdmin: true}, true,
+			},
+			"noMatchingGroup": {"d", "dev", ProxyIdentity{}, false},
+			"noGroups":        {"e", "", ProxyIdentity{}, false},
+			"noUser":          {"", "admins", ProxyIdentity{}, false},
+		}
+		for name, tc := range cases {
+			t.Run(name, func(t *testing.T) {
+				identity, ok := p.Identify(newRequest("10.0.0.1:1234", tc.user, tc.groups))
+				require.Equal(t, tc.expectedOK, ok)
+				require.Equal(t, tc.expected, identity)
+			})
+		}
+	})
+	t.Run("noUserGroups", func(t *testing.T) {
+		p, err := NewProxyAuth(storage.ProxyAuthConfig{
+			Enable:         true,
+			TrustedProxies: []string{"10.0.0.0/24"},
+			UserHeader:     "X-User",
+		})
+		require.NoError(t, err)
+
+		r := newRequest("10.0.0.1:1234", "", "")
+		r.Header.Set("X-User", "a")
+		identity, ok := p.Identify(r)
+		require.True(t, ok)
+		require.Equal(t, ProxyIdentity{Username: "a"}, identity)
+
+		r.Header.Set("Remote-Groups", "dev")
+		identity, ok = p.Identify(r)
+		require.True(t, ok)
+		require.Equal(t, ProxyIdentity{Username: "a", HasGroups: true}, identity)
+	})
+}
@@ -0,0 +1,275 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Browsers receive a session cookie when they load a page. Requests with
+// a revoked session cookie are rejected even if the credentials are valid,
+// the browser has to login again. Only a hash of the token is stored.
+
+// SessionCookie name of the session cookie.
+const SessionCookie = "session"
+
+const (
+	// Sessions that aren't used for this long are removed.
+	SessionMaxIdle = 30 * 24 * time.Hour
+
+	// The last seen time is saved to disk at most once per interval.
+	sessionSaveInterval = time.Minute
+)
+
+// Session of a logged in browser.
+type Session struct {
+	ID        string    `json:"id"`
+	AccountID string    `json:"accountId"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"lastSeen"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+}
+
+// ErrSessionNotExist session does not exist.
+var ErrSessionNotExist = errors.New("session does not exist")
+
+// SessionStore keeps the sessions in memory and persists them to a file.
+type SessionStore struct {
+	path string
+	now  func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]*Session  // map[tokenHash]session.
+	saved    map[string]time.Time // Last seen times on disk, map[tokenHash]lastSeen.
+}
+
+// NewSessionStore reads the sessions from the file, a missing file is ignored.
+func NewSessionStore(path string) (*SessionStore, error) {
+	s := &SessionStore{
+		path:     path,
+		now:      time.Now,
+		sessions: make(map[string]*Session),
+		saved:    make(map[string]time.Time),
+	}
+
+	file, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read sessions file: %w", err)
+	}
+	if err := json.Unmarshal(file, &s.sessions); err != nil {
+		return nil, fmt.Errorf("unmarshal sessions: %w", err)
+	}
+	for hash, session := range s.sessions {
+		s.saved[hash] = session.LastSeen
+	}
+	return s, nil
+}
+
+func hashSessionToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// Create creates a session for the account and returns the token.
+func (s *SessionStore) Create(accountID string, r *http.Request) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.pruneUnsafe(now)
+
+	token := GenToken()
+	hash := hashSessionToken(token)
+	s.sessions[hash] = &Session{
+		ID:        GenToken()[:16],
+		AccountID: accountID,
+		Created:   now,
+		LastSeen:  now,
+		IP:        strings.TrimSpace(RequestIP(r)),
+		UserAgent: r.UserAgent(),
+	}
+	if err := s.saveUnsafe(); err != nil {
+		delete(s.sessions, hash)
+		return "", err
+	}
+	return token, nil
+}
+
+// Validate returns the session of the token and updates the last seen time.
+func (s *SessionStore) Validate(token string) (Session, bool) {
+	hash := hashSessionToken(token)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[hash]
+	if !exists {
+		return Session{}, false
+	}
+	now := s.now()
+	if now.Sub(session.LastSeen) > SessionMaxIdle {
+		return Session{}, false
+	}
+
+	session.LastSeen = now
+	if now.Sub(s.saved[hash]) > sessionSaveInterval {
+		s.saveUnsafe() //nolint:errcheck
+	}
+	return *session, true
+}
+
+// List returns the sessions of the account, most recently
+// used first. All sessions are returned if the id is empty.
+func (s *SessionStore) List(accountID string) []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := []Session{}
+	for _, session := range s.sessions {
+		if accountID == "" || session.AccountID == accountID {
+			sessions = append(sessions, *session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions
+}
+
+// Revoke revokes the session by id. The session must belong to
+// the account unless the account id is empty.
+func (s *SessionStore) Revoke(id string, accountID string) error {
+	return s.revoke(func(session *Session) bool {
+		return session.ID == id && (accountID == "" || session.AccountID == accountID)
+	}, true)
+}
+
+// RevokeToken revokes the session of the token.
+func (s *SessionStore) RevokeToken(token string) error {
+	hash := hashSessionToken(token)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.sessions[hash]; !exists {
+		return ErrSessionNotExist
+	}
+	delete(s.sessions, hash)
+	delete(s.saved, hash)
+	return s.saveUnsafe()
+}
+
+// RevokeAccount revokes all sessions of the account except keepID.
+func (s *SessionStore) RevokeAccount(accountID string, keepID string) error {
+	return s.revoke(func(session *Session) bool {
+		return session.AccountID == accountID && session.ID != keepID
+	}, false)
+}
+
+func (s *SessionStore) revoke(match func(*Session) bool, mustExist bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revoked := make(map[string]*Session)
+	for hash, session := range s.sessions {
+		if match(session) {
+			revoked[hash] = session
+		}
+	}
+	if len(revoked) == 0 {
+		if mustExist {
+			return ErrSessionNotExist
+		}
+		return nil
+	}
+
+	for hash := range revoked {
+		delete(s.sessions, hash)
+	}
+	if err := s.saveUnsafe(); err != nil {
+		// Keep the sessions consistent with the file.
+		for hash, session := range revoked {
+			s.sessions[hash] = session
+		}
+		return err
+	}
+	for hash := range revoked {
+		delete(s.saved, hash)
+	}
+	return nil
+}
+
+// pruneUnsafe removes idle sessions, they're saved with the next change.
+func (s *SessionStore) pruneUnsafe(now time.Time) {
+	for hash, session := range s.sessions {
+		if now.Sub(session.LastSeen) > SessionMaxIdle {
+			delete(s.sessions, hash)
+			delete(s.saved, hash)
+		}
+	}
+}
+
+func (s *SessionStore) saveUnsafe() error {
+	sessions, err := json.MarshalIndent(s.sessions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal sessions: %w", err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, sessions, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	for hash, session := range s.sessions {
+		s.saved[hash] = session.LastSeen
+	}
+	return nil
+}
+
+// SetSessionCookie sets the session cookie of the response.
+func SetSessionCookie(w http.ResponseWriter, r *http.Request, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(SessionMaxIdle.Seconds()),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// ClearSessionCookie removes the session cookie from the browser.
+func ClearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// IsPageRequest returns true if the request is a page load from a browser,
+// sessions are only created for browsers. API clients don't accept HTML.
+func IsPageRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
@@ -0,0 +1,191 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSessionStore(t *testing.T) (*SessionStore, *time.Time) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	s, err := NewSessionStore(path)
+	require.NoError(t, err)
+	now := time.Unix(1000, 0).UTC()
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func newTestSessionRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/live", nil)
+	r.RemoteAddr = "1.2.3.4:5"
+	r.Header.Set("User-Agent", "test")
+	return r
+}
+
+func TestSessionStore(t *testing.T) {
+	t.Run("createValidate", func(t *testing.T) {
+		s, now := newTestSessionStore(t)
+		token, err := s.Create("a", newTestSessionRequest())
+		require.NoError(t, err)
+
+		session, valid := s.Validate(token)
+		require.True(t, valid)
+		require.Len(t, session.ID, 16)
+		session.ID = ""
+		expected := Session{
+			AccountID: "a",
+			Created:   *now,
+			LastSeen:  *now,
+			IP:        "addr:1.2.3.4:5",
+			UserAgent: "test",
+		}
+		require.Equal(t, expected, session)
+
+		_, valid = s.Validate("x")
+		require.False(t, valid)
+
+		// Only the hash is stored.
+		file, err := os.ReadFile(s.path)
+		require.NoError(t, err)
+		require.NotContains(t, string(file), token)
+		require.Contains(t, string(file), hashSessionToken(token))
+	})
+	t.Run("persist", func(t *testing.T) {
+		s, now := newTestSessionStore(t)
+		token, err := s.Create("a", newTestSessionRequest())
+		require.NoError(t, err)
+
+		// The last seen time isn't saved on every request.
+		*now = now.Add(sessionSaveInterval / 2)
+		_, valid := s.Validate(token)
+		require.True(t, valid)
+		s2, err := NewSessionStore(s.path)
+		require.NoError(t, err)
+		require.Equal(t, now.Add(-sessionSaveInterval/2), s2.List("")[0].LastSeen)
+
+		*now = now.Add(sessionSaveInterval)
+		_, valid = s.Validate(token)
+		require.True(t, valid)
+		s2, err = NewSessionStore(s.path)
+		require.NoError(t, err)
+		require.Equal(t, *now, s2.List("")[0].LastSeen)
+
+		s2.now = s.now
+		_, valid = s2.Validate(token)
+		require.True(t, valid)
+	})
+	t.Run("idle", func(t *testing.T) {
+		s, now := newTestSessionStore(t)
+		token, err := s.Create("a", newTestSessionRequest())
+		require.NoError(t, err)
+
+		*now = now.Add(SessionMaxIdle + time.Second)
+		_, valid := s.Validate(token)
+		require.False(t, valid)
+
+		// Idle sessions are pruned when a session is created.
+		_, err = s.Create("b", newTestSessionRequest())
+		require.NoError(t, err)
+		sessions := s.List("")
+		require.Len(t, sessions, 1)
+		require.Equal(t, "b", sessions[0].AccountID)
+	})
+	t.Run("list", func(t *testing.T) {
+		s, now := newTestSessionStore(t)
+		for _, accountID := range []string{"a", "b", "a"} {
+			_, err := s.Create(accountID, newTestSessionRequest())
+			require.NoError(t, err)
+			*now = now.Add(time.Second)
+		}
+
+		sessions := s.List("a")
+		require.Len(t, sessions, 2)
+		require.True(t, sessions[0].LastSeen.After(sessions[1].LastSeen))
+		require.Len(t, s.List(""), 3)
+		require.Empty(t, s.List("c"))
+	})
+	t.Run("revoke", func(t *testing.T) {
+		s, _ := newTestSessionStore(t)
+		token, err := s.Create("a", newTestSessionRequest())
+		require.NoError(t, err)
+		session, _ := s.Validate(token)
+
+		require.ErrorIs(t, s.Revoke(session.ID, "b"), ErrSessionNotExist)
+		require.NoError(t, s.Revoke(session.ID, "a"))
+		_, valid := s.Validate(token)
+		require.False(t, valid)
+		require.ErrorIs(t, s.Revoke(session.ID, ""), ErrSessionNotExist)
+
+		// The revocation is persisted.
+		s2, err := NewSessionStore(s.path)
+		require.NoError(t, err)
+		require.Empty(t, s2.List(""))
+	})
+	t.Run("revokeToken", func(t *testing.T) {
+		s, _ := newTestSessionStore(t)
+		token, err := s.Create("a", newTestSessionRequest())
+		require.NoError(t, err)
+
+		require.NoError(t, s.RevokeToken(token))
+		_, valid := s.Validate(token)
+		require.False(t, valid)
+		require.ErrorIs(t, s.RevokeToken(token), ErrSessionNotExist)
+	})
+	t.Run("revokeAccount", func(t *testing.T) {
+		s, _ := newTestSessionStore(t)
+		tokenA1, err := s.Create("a", newTestSessionRequest())
+		require.NoError(t, err)
+		tokenA2, err := s.Create("a", newTestSessionRequest())
+		require.NoError(t, err)
+		tokenB, err := s.Create("b", newTestSessionRequest())
+		require.NoError(t, err)
+		keep, _ := s.Validate(tokenA1)
+
+		require.NoError(t, s.RevokeAccount("a", keep.ID))
+		_, valid := s.Validate(tokenA1)
+		require.True(t, valid)
+		_, valid = s.Validate(tokenA2)
+		require.False(t, valid)
+		_, valid = s.Validate(tokenB)
+		require.True(t, valid)
+
+		require.NoError(t, s.RevokeAccount("a", ""))
+		_, valid = s.Validate(tokenA1)
+		require.False(t, valid)
+	})
+	t.Run("unmarshalErr", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sessions.json")
+		require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+		_, err := NewSessionStore(path)
+		require.Error(t, err)
+	})
+}
+
+func TestSessionCookie(t *testing.T) {
+	w := httptest.NewRecorder()
+	SetSessionCookie(w, newTestSessionRequest(), "x")
+	cookie := w.Header().Get("Set-Cookie")
+	require.True(t, strings.HasPrefix(cookie, "session=x; Path=/; Max-Age=2592000;"), cookie)
+	require.Contains(t, cookie, "HttpOnly; SameSite=Strict")
+
+	w = httptest.NewRecorder()
+	ClearSessionCookie(w)
+	require.Contains(t, w.Header().Get("Set-Cookie"), "Max-Age=0")
+}
+
+func TestIsPageRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/live", nil)
+	require.False(t, IsPageRequest(r))
+	r.Header.Set("Accept", "text/html,application/xhtml+xml")
+	require.True(t, IsPageRequest(r))
+	r.Method = http.MethodPost
+	require.False(t, IsPageRequest(r))
+}
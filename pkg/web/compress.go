@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Responses smaller than this are not worth compressing.
+const compressMinSize = 256
+
+// compressibleTypes are the Content-Type prefixes that are compressed.
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/vnd.apple.mpegurl",
+	"audio/mpegurl",
+}
+
+// Compress compresses text responses with gzip or deflate if the
+// client accepts it. Compressible responses are buffered, they must
+// be small. Other responses, like video segments, are passed through.
+// Uncompressed buffered responses get a accurate Content-Length.
+func Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			w:        w,
+			encoding: negotiateEncoding(r.Header.Get("Accept-Encoding")),
+		}
+		next.ServeHTTP(cw, r)
+		cw.finish()
+	})
+}
+
+// negotiateEncoding returns "gzip", "deflate" or "" for identity.
+// Unknown and malformed encodings are ignored.
+func negotiateEncoding(acceptEncoding string) string {
+	qualities := make(map[string]float64)
+	for _, entry := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(entry, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		if params != "" {
+			name, value, _ := strings.Cut(strings.TrimSpace(params), "=")
+			if strings.TrimSpace(name) != "q" {
+				continue
+			}
+			var err error
+			q, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		qualities[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, exist := qualities[coding]
+		if !exist {
+			q, exist = qualities["*"]
+		}
+		if exist && q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+func isCompressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter buffers compressible responses until the handler returns.
+type compressWriter struct {
+	w        http.ResponseWriter
+	encoding string
+
+	status      int
+	wroteHeader bool
+	buffering   bool
+	buf         bytes.Buffer
+}
+
+func (cw *compressWriter) Header() http.Header {
+	return cw.w.Header()
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+
+	// Partial and already encoded responses are passed through.
+	h := cw.w.Header()
+	if status == http.StatusOK &&
+		isCompressible(h.Get("Content-Type")) &&
+		h.Get("Content-Range") == "" &&
+		h.Get("Content-Encoding") == "" {
+		cw.buffering = true
+		return
+	}
+	cw.w.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.buffering {
+		return cw.buf.Write(p)
+	}
+	return cw.w.Write(p)
+}
+
+// finish writes the buffered response.
+func (cw *compressWriter) finish() {
+	if !cw.buffering {
+		return
+	}
+
+	body := cw.buf.Bytes()
+	h := cw.w.Header()
+	if cw.encoding != "" && len(body) >= compressMinSize {
+		if compressed, err := compress(cw.encoding, body); err == nil {
+			body = compressed
+			h.Set("Content-Encoding", cw.encoding)
+		}
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	cw.w.WriteHeader(cw.status)
+	cw.w.Write(body) //nolint:errcheck
+}
+
+func compress(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	if encoding == "gzip" {
+		w = gzip.NewWriter(&buf)
+	} else {
+		w = zlib.NewWriter(&buf)
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
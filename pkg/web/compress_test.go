@@ -0,0 +1,163 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"GZIP", "gzip"},
+		{"gzip, deflate, br", "gzip"},
+		{"deflate;q=1, gzip;q=0.5", "deflate"},
+		{"gzip;q=0", ""},
+		{"gzip;q=0, deflate", "deflate"},
+		{"identity", ""},
+		{"*", "gzip"},
+		{"*;q=0", ""},
+		{"*, gzip;q=0", "deflate"},
+		{"br", ""},
+		// Bogus.
+		{"gzip;q=abc", ""},
+		{"gzip;q=2", ""},
+		{"gzip;x=1", ""},
+		{";;,,", ""},
+		{"g z i p", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			require.Equal(t, tc.expected, negotiateEncoding(tc.input))
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	playlist := []byte("#EXTM3U\n" + strings.Repeat("#EXT-X-PART:DURATION=0.5,URI=\"part.mp4\"\n", 20))
+	segment := bytes.Repeat([]byte{0}, 1000)
+
+	handler := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index.m3u8":
+			w.Header().Set("Content-Type", "audio/mpegURL")
+			w.Write(playlist) //nolint:errcheck
+		case "/seek.m3u8":
+			w.Header().Set("Content-Type", "audio/mpegURL")
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(playlist))
+		case "/small.m3u8":
+			w.Header().Set("Content-Type", "audio/mpegURL")
+			w.Write([]byte("#EXTM3U\n")) //nolint:errcheck
+		case "/seg.mp4":
+			w.Header().Set("Content-Type", "video/mp4")
+			w.Write(segment) //nolint:errcheck
+		case "/notFound.m3u8":
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(playlist) //nolint:errcheck
+		}
+	}))
+
+	request := func(path string, acceptEncoding string) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Result()
+	}
+	readBody := func(t *testing.T, res *http.Response) []byte {
+		t.Helper()
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Equal(t, strconv.Itoa(len(body)), res.Header.Get("Content-Length"))
+		return body
+	}
+
+	t.Run("gzip", func(t *testing.T) {
+		for _, path := range []string{"/index.m3u8", "/seek.m3u8"} {
+			res := request(path, "gzip, deflate")
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			require.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+			require.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+
+			body := readBody(t, res)
+			require.Less(t, len(body), len(playlist))
+
+			r, err := gzip.NewReader(bytes.NewReader(body))
+			require.NoError(t, err)
+			decompressed, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, playlist, decompressed)
+		}
+	})
+	t.Run("deflate", func(t *testing.T) {
+		res := request("/index.m3u8", "deflate")
+		require.Equal(t, "deflate", res.Header.Get("Content-Encoding"))
+
+		r, err := zlib.NewReader(bytes.NewReader(readBody(t, res)))
+		require.NoError(t, err)
+		decompressed, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, playlist, decompressed)
+	})
+	t.Run("identity", func(t *testing.T) {
+		for _, acceptEncoding := range []string{"", "identity", "br", "gzip;q=0"} {
+			res := request("/index.m3u8", acceptEncoding)
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			require.Empty(t, res.Header.Get("Content-Encoding"))
+			require.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+			require.Equal(t, playlist, readBody(t, res))
+		}
+	})
+	t.Run("bogus", func(t *testing.T) {
+		res := request("/index.m3u8", "gzip;q=x;;, ,*;q=-1, \x00")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Empty(t, res.Header.Get("Content-Encoding"))
+		require.Equal(t, playlist, readBody(t, res))
+	})
+	t.Run("small", func(t *testing.T) {
+		res := request("/small.m3u8", "gzip")
+		require.Empty(t, res.Header.Get("Content-Encoding"))
+		require.Equal(t, []byte("#EXTM3U\n"), readBody(t, res))
+	})
+	t.Run("segment", func(t *testing.T) {
+		res := request("/seg.mp4", "gzip")
+		require.Empty(t, res.Header.Get("Content-Encoding"))
+		require.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Equal(t, segment, body)
+	})
+	t.Run("notOK", func(t *testing.T) {
+		res := request("/notFound.m3u8", "gzip")
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+		require.Empty(t, res.Header.Get("Content-Encoding"))
+	})
+	t.Run("range", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/seek.m3u8", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		r.Header.Set("Range", "bytes=0-6")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		require.Empty(t, w.Header().Get("Content-Encoding"))
+		require.Equal(t, "#EXTM3U", w.Body.String())
+	})
+}
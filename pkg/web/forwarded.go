@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package web
+
+import (
+	"context"
+	"net/http"
+)
+
+type forwardedProtoKey struct{}
+
+// ForwardedProtoMiddleware stores the protocol from the
+// X-Forwarded-Proto header of the reverse proxy in the
+// request context, it's read by ForwardedProto.
+func ForwardedProtoMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto := r.Header.Get("X-Forwarded-Proto")
+		if proto != "" {
+			r = r.WithContext(context.WithValue(r.Context(), forwardedProtoKey{}, proto))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ForwardedProto returns the protocol that the client used to
+// connect to the reverse proxy. Returns false if the request
+// wasn't forwarded or the middleware isn't used.
+func ForwardedProto(r *http.Request) (string, bool) {
+	proto, ok := r.Context().Value(forwardedProtoKey{}).(string)
+	return proto, ok
+}
@@ -0,0 +1,37 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardedProtoMiddleware(t *testing.T) {
+	var proto string
+	var ok bool
+	h := ForwardedProtoMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		proto, ok = ForwardedProto(r)
+	}))
+
+	t.Run("forwarded", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/debug", nil)
+		r.Header.Set("X-Forwarded-Proto", "https")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		require.True(t, ok)
+		require.Equal(t, "https", proto)
+	})
+	t.Run("direct", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/debug", nil)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		require.False(t, ok)
+		require.Equal(t, "", proto)
+	})
+	t.Run("noMiddleware", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/debug", nil)
+		r.Header.Set("X-Forwarded-Proto", "https")
+		_, ok := ForwardedProto(r)
+		require.False(t, ok)
+	})
+}
@@ -0,0 +1,488 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/url"
+	"nvr/pkg/pdf"
+	"nvr/pkg/storage"
+	"nvr/pkg/web/api"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Maximum number of key frames in a report.
+	reportMaxFrames = 6
+
+	// Width of the key frames in pixels.
+	reportFrameWidth = 640
+)
+
+// ExtractFrameFunc decodes the frame at the offset of the
+// video and returns it as a JPEG image scaled to the width.
+type ExtractFrameFunc func(
+	ctx context.Context,
+	video io.Reader,
+	offset time.Duration,
+	width int,
+) ([]byte, error)
+
+// Report is an incident report of a recording.
+type Report struct {
+	RecordingID string
+	MonitorID   string
+	MonitorName string
+
+	// Local time of the monitor.
+	Start    time.Time
+	End      time.Time
+	TimeZone string
+	Duration ReportOffset
+
+	// Ordered by time.
+	Detections []ReportDetection
+	Frames     []ReportFrame
+}
+
+// ReportDetection is a detection of a recording event.
+type ReportDetection struct {
+	Time   time.Time
+	Offset ReportOffset
+	Label  string
+	Score  float64
+	Source string
+	Zones  []string
+}
+
+// ReportFrame is a key frame of the recording. The image is empty
+// until it's extracted, the thumbnail doesn't have a detection.
+type ReportFrame struct {
+	Time   time.Time
+	Offset ReportOffset
+	Label  string
+	Score  float64
+	Image  []byte
+}
+
+// DataURL returns the JPEG image as a data URL.
+func (f ReportFrame) DataURL() template.URL {
+	return template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(f.Image))
+}
+
+// ReportOffset is a duration from the start of the recording.
+type ReportOffset time.Duration
+
+// String returns the offset as "hh:mm:ss".
+func (o ReportOffset) String() string {
+	s := int(time.Duration(o).Seconds())
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, s/60%60, s%60)
+}
+
+// newReport returns the report of the recording without frame images.
+// The key frames are the best detection of each event, the events
+// with the highest scores are chosen.
+func newReport(
+	recID string,
+	recData storage.RecordingData,
+	fallbackLoc *time.Location,
+	monitorName string,
+) Report {
+	times := recData.Times(fallbackLoc)
+	loc := times.StartLocal.Location()
+	duration := recData.End.Sub(recData.Start)
+
+	offsetOf := func(t time.Time) ReportOffset {
+		return ReportOffset(min(max(t.Sub(recData.Start), 0), duration))
+	}
+
+	report := Report{
+		RecordingID: recID,
+		MonitorID:   recID[20:],
+		MonitorName: monitorName,
+		Start:       times.StartLocal,
+		End:         times.EndLocal,
+		TimeZone:    times.TimeZone,
+		Duration:    ReportOffset(duration),
+	}
+
+	events := append([]storage.Event(nil), recData.Events...)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+
+	var frames []ReportFrame
+	for _, e := range events {
+		e = e.WithDetectionSources()
+		var best *storage.Detection
+		for i, d := range e.Detections {
+			report.Detections = append(report.Detections, ReportDetection{
+				Time:   e.Time.In(loc),
+				Offset: offsetOf(e.Time),
+				Label:  d.Label,
+				Score:  d.Score,
+				Source: d.Source,
+				Zones:  d.Zones,
+			})
+			if best == nil || d.Score > best.Score {
+				best = &e.Detections[i]
+			}
+		}
+		if best != nil {
+			frames = append(frames, ReportFrame{
+				Time:   e.Time.In(loc),
+				Offset: offsetOf(e.Time),
+				Label:  best.Label,
+				Score:  best.Score,
+			})
+		}
+	}
+
+	sort.SliceStable(frames, func(i, j int) bool {
+		return frames[i].Score > frames[j].Score
+	})
+	if len(frames) > reportMaxFrames {
+		frames = frames[:reportMaxFrames]
+	}
+	sort.SliceStable(frames, func(i, j int) bool {
+		return frames[i].Offset < frames[j].Offset
+	})
+	report.Frames = frames
+	return report
+}
+
+// Reporter generates incident reports of recordings.
+type Reporter struct {
+	recordingsDirs storage.RecordingsDirs
+	videoCache     *storage.VideoCache
+	loc            *time.Location
+	monitorName    func(monitorID string) string
+	extractFrame   ExtractFrameFunc
+}
+
+// NewReporter returns a reporter. The location is used for
+// recordings without a time zone. monitorName returns an
+// empty string if the monitor doesn't exist anymore.
+func NewReporter(
+	recordingsDirs storage.RecordingsDirs,
+	videoCache *storage.VideoCache,
+	loc *time.Location,
+	monitorName func(monitorID string) string,
+	extractFrame ExtractFrameFunc,
+) *Reporter {
+	return &Reporter{
+		recordingsDirs: recordingsDirs,
+		videoCache:     videoCache,
+		loc:            loc,
+		monitorName:    monitorName,
+		extractFrame:   extractFrame,
+	}
+}
+
+// report reads the recording data and extracts the key frames. Frames that
+// cannot be extracted are left out, the thumbnail is used if none are left.
+func (rp *Reporter) report(ctx context.Context, recID string) (*Report, error) {
+	path, err := recordingPath(rp.recordingsDirs, recID)
+	if err != nil {
+		return nil, err
+	}
+
+	rawData, err := os.ReadFile(path + ".json")
+	if err != nil {
+		return nil, err
+	}
+	var recData storage.RecordingData
+	if err := json.Unmarshal(rawData, &recData); err != nil {
+		return nil, fmt.Errorf("unmarshal recording data: %w", err)
+	}
+
+	report := newReport(recID, recData, rp.loc, rp.monitorName(recID[20:]))
+
+	var frames []ReportFrame
+	for _, frame := range report.Frames {
+		image, err := rp.readFrame(ctx, path, time.Duration(frame.Offset))
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		frame.Image = image
+		frames = append(frames, frame)
+	}
+
+	if len(frames) == 0 {
+		thumbnail, err := os.ReadFile(path + ".jpeg")
+		if err == nil {
+			frames = append(frames, ReportFrame{
+				Time:  report.Start,
+				Image: thumbnail,
+			})
+		}
+	}
+	report.Frames = frames
+	return &report, nil
+}
+
+func (rp *Reporter) readFrame(ctx context.Context, path string, offset time.Duration) ([]byte, error) {
+	var video io.ReadCloser
+	video, err := os.Open(path + ".mp4")
+	if errors.Is(err, os.ErrNotExist) {
+		video, err = storage.NewVideoReader(path, rp.videoCache)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer video.Close()
+
+	return rp.extractFrame(ctx, video, offset, reportFrameWidth)
+}
+
+func writeReportError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, storage.ErrInvalidRecordingID):
+		api.BadRequest(w, err.Error())
+	case errors.Is(err, os.ErrNotExist):
+		api.NotFound(w, "recording not found")
+	default:
+		api.InternalError(w, r, "could not generate report", err)
+	}
+}
+
+// Page handles "/report/<id>". Renders the report page template.
+func (rp *Reporter) Page(t *Templater, page string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		recID := strings.TrimPrefix(r.URL.Path, "/report/")
+		report, err := rp.report(r.Context(), recID)
+		if err != nil {
+			writeReportError(w, r, err)
+			return
+		}
+		t.RenderData(w, r, page, template.FuncMap{"report": report})
+	})
+}
+
+// PDF handles "/api/recording/<id>/report.pdf".
+func (rp *Reporter) PDF() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		recID, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/recording/"), "/")
+		report, err := rp.report(r.Context(), recID)
+		if err != nil {
+			writeReportError(w, r, err)
+			return
+		}
+
+		var b bytes.Buffer
+		if _, err := reportPDF(report, clipURL(r, recID)).WriteTo(&b); err != nil {
+			api.InternalError(w, r, "could not write pdf", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/pdf")
+		setAttachment(w, "report_"+recID+".pdf")
+		w.Write(b.Bytes()) //nolint:errcheck
+	})
+}
+
+// clipURL returns the absolute URL of the recording video.
+func clipURL(r *http.Request, recID string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	u := url.URL{
+		Scheme: scheme,
+		Host:   r.Host,
+		Path:   "/api/recording/video/" + recID,
+	}
+	return u.String()
+}
+
+// PDF layout in points.
+const (
+	reportMargin     = 40
+	reportFrameGap   = 12
+	reportLineHeight = 14
+	reportFontSize   = 9
+)
+
+// reportLayout adds pages to the document when the content overflows.
+type reportLayout struct {
+	doc  *pdf.Document
+	page *pdf.Page
+	y    float64
+}
+
+func (l *reportLayout) newPage() {
+	l.page = l.doc.AddPage()
+	l.y = reportMargin
+}
+
+// reserve starts a new page if the height doesn't fit.
+func (l *reportLayout) reserve(height float64) {
+	if l.y+height > pdf.PageHeight-reportMargin {
+		l.newPage()
+	}
+}
+
+func (l *reportLayout) heading(text string) {
+	l.reserve(reportLineHeight * 3)
+	l.y += reportLineHeight
+	l.page.Text(reportMargin, l.y, pdf.HelveticaBold, 12, text)
+	l.y += 6
+	l.page.Line(reportMargin, l.y, pdf.PageWidth-reportMargin, l.y, 0.5)
+	l.y += reportLineHeight
+}
+
+// reportColumn is a column of the detection table, x is the offset from the margin.
+type reportColumn struct {
+	title string
+	x     float64
+	width float64
+	value func(ReportDetection) string
+}
+
+var reportColumns = []reportColumn{
+	{"Time", 0, 110, func(d ReportDetection) string {
+		return d.Time.Format("2006-01-02 15:04:05")
+	}},
+	{"Offset", 110, 50, func(d ReportDetection) string { return d.Offset.String() }},
+	{"Label", 160, 100, func(d ReportDetection) string { return d.Label }},
+	{"Score", 260, 40, func(d ReportDetection) string {
+		return strconv.FormatFloat(d.Score, 'f', 0, 64) + "%"
+	}},
+	{"Source", 300, 70, func(d ReportDetection) string { return d.Source }},
+	{"Zones", 370, 145, func(d ReportDetection) string { return strings.Join(d.Zones, ", ") }},
+}
+
+// reportPDF returns the report as a printable document.
+func reportPDF(report *Report, clipURL string) *pdf.Document {
+	monitor := report.MonitorID
+	if report.MonitorName != "" {
+		monitor = report.MonitorName + " (" + report.MonitorID + ")"
+	}
+
+	l := &reportLayout{doc: pdf.New("Incident report " + report.RecordingID)}
+	l.newPage()
+
+	l.y += 18
+	l.page.Text(reportMargin, l.y, pdf.HelveticaBold, 18, "Incident report")
+	l.y += reportLineHeight
+
+	const layout = "2006-01-02 15:04:05 MST"
+	const labelWidth = 70
+	fields := [][2]string{
+		{"Monitor", monitor},
+		{"Recording", report.RecordingID},
+		{"Start", report.Start.Format(layout)},
+		{"End", report.End.Format(layout)},
+		{"Duration", report.Duration.String()},
+	}
+	for _, field := range fields {
+		l.y += reportLineHeight
+		l.page.Text(reportMargin, l.y, pdf.HelveticaBold, 10, field[0])
+		l.page.Text(reportMargin+labelWidth, l.y, pdf.Helvetica, 10, pdf.Truncate(
+			field[1], pdf.Helvetica, 10, pdf.PageWidth-2*reportMargin-labelWidth))
+	}
+	l.y += reportLineHeight
+	l.page.Text(reportMargin, l.y, pdf.HelveticaBold, 10, "Clip")
+	l.page.Text(reportMargin+labelWidth, l.y, pdf.Helvetica, 10, "Open recording")
+	l.page.Link(reportMargin+labelWidth, l.y-10, pdf.TextWidth("Open recording", pdf.Helvetica, 10), 13, clipURL)
+	l.y += reportLineHeight
+
+	l.heading("Key frames")
+	frameWidth := (pdf.PageWidth - 2*reportMargin - reportFrameGap) / 2
+	type pdfFrame struct {
+		ReportFrame
+		height float64
+	}
+	var frames []pdfFrame
+	for _, frame := range report.Frames {
+		config, err := jpeg.DecodeConfig(bytes.NewReader(frame.Image))
+		if err != nil || config.Width == 0 {
+			continue
+		}
+		height := frameWidth * float64(config.Height) / float64(config.Width)
+		frames = append(frames, pdfFrame{frame, height})
+	}
+
+	// Two frames per row.
+	for i := 0; i < len(frames); i += 2 {
+		row := frames[i:min(i+2, len(frames))]
+		rowHeight := 0.0
+		for _, frame := range row {
+			rowHeight = max(rowHeight, frame.height)
+		}
+		l.reserve(rowHeight + reportLineHeight*2)
+
+		for j, frame := range row {
+			x := reportMargin + float64(j)*(frameWidth+reportFrameGap)
+			if err := l.page.Image(frame.Image, x, l.y, frameWidth, frame.height); err != nil {
+				continue
+			}
+			caption := frame.Offset.String() + "  " + frame.Time.Format("15:04:05")
+			if frame.Label != "" {
+				caption += "  " + frame.Label + " " + strconv.FormatFloat(frame.Score, 'f', 0, 64) + "%"
+			}
+			l.page.Text(x, l.y+frame.height+reportLineHeight, pdf.Helvetica, reportFontSize,
+				pdf.Truncate(caption, pdf.Helvetica, reportFontSize, frameWidth))
+		}
+		l.y += rowHeight + reportLineHeight*2
+	}
+	if len(frames) == 0 {
+		l.page.Text(reportMargin, l.y, pdf.Helvetica, 10, "No frames.")
+		l.y += reportLineHeight
+	}
+
+	l.heading("Detections")
+	tableHeader := func() {
+		l.page.FillRect(reportMargin, l.y-10, pdf.PageWidth-2*reportMargin, reportLineHeight, 0.9)
+		for _, c := range reportColumns {
+			l.page.Text(reportMargin+c.x+2, l.y, pdf.HelveticaBold, reportFontSize, c.title)
+		}
+		l.y += reportLineHeight
+	}
+	tableHeader()
+	for _, d := range report.Detections {
+		if l.y+reportLineHeight > pdf.PageHeight-reportMargin {
+			l.newPage()
+			l.y += reportLineHeight
+			tableHeader()
+		}
+		for _, c := range reportColumns {
+			text := pdf.Truncate(c.value(d), pdf.Helvetica, reportFontSize, c.width-4)
+			l.page.Text(reportMargin+c.x+2, l.y, pdf.Helvetica, reportFontSize, text)
+		}
+		l.y += reportLineHeight
+	}
+	if len(report.Detections) == 0 {
+		l.page.Text(reportMargin, l.y, pdf.Helvetica, 10, "No detections.")
+	}
+	return l.doc
+}
@@ -0,0 +1,331 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportOffset(t *testing.T) {
+	require.Equal(t, "00:00:00", ReportOffset(0).String())
+	require.Equal(t, "00:01:05", ReportOffset(65*time.Second).String())
+	require.Equal(t, "01:00:01", ReportOffset(3601*time.Second).String())
+}
+
+func TestNewReport(t *testing.T) {
+	start := time.Date(2000, 1, 1, 1, 1, 1, 0, time.UTC)
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+	recData := storage.RecordingData{
+		Start:    start,
+		End:      at(60),
+		TimeZone: "Europe/Stockholm",
+		Events: []storage.Event{
+			{
+				Time:   at(20),
+				Source: "doods",
+				Detections: []storage.Detection{
+					{Label: "car", Score: 60, Zones: []string{"road"}},
+					{Label: "person", Score: 90, Zones: []string{"road", "door"}},
+				},
+			},
+			{
+				Time:       at(10),
+				Source:     "doods",
+				Detections: []storage.Detection{{Label: "dog", Score: 70}},
+			},
+			{
+				Time:   at(-1),
+				Source: "api",
+				Detections: []storage.Detection{
+					{Label: "manual", Score: 100, Source: "button"},
+				},
+			},
+			{Time: at(30), Source: "motion"},
+		},
+	}
+	report := newReport("2000-01-01_01-01-01_m1", recData, time.UTC, "Front door")
+
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	require.NoError(t, err)
+	localAt := func(seconds int) time.Time { return at(seconds).In(stockholm) }
+
+	require.Equal(t, "2000-01-01_01-01-01_m1", report.RecordingID)
+	require.Equal(t, "m1", report.MonitorID)
+	require.Equal(t, "Front door", report.MonitorName)
+	require.Equal(t, localAt(0), report.Start)
+	require.Equal(t, localAt(60), report.End)
+	require.Equal(t, "Europe/Stockholm", report.TimeZone)
+	require.Equal(t, ReportOffset(time.Minute), report.Duration)
+
+	offset := func(seconds int) ReportOffset {
+		return ReportOffset(time.Duration(seconds) * time.Second)
+	}
+	expectedDetections := []ReportDetection{
+		{
+			Time:   localAt(-1),
+			Offset: 0,
+			Label:  "manual",
+			Score:  100,
+			Source: "button",
+		},
+		{Time: localAt(10), Offset: offset(10), Label: "dog", Score: 70, Source: "doods"},
+		{
+			Time:   localAt(20),
+			Offset: offset(20),
+			Label:  "car",
+			Score:  60,
+			Source: "doods",
+			Zones:  []string{"road"},
+		},
+		{
+			Time:   localAt(20),
+			Offset: offset(20),
+			Label:  "person",
+			Score:  90,
+			Source: "doods",
+			Zones:  []string{"road", "door"},
+		},
+	}
+	require.Equal(t, expectedDetections, report.Detections)
+
+	expectedFrames := []ReportFrame{
+		{Time: localAt(-1), Offset: 0, Label: "manual", Score: 100},
+		{Time: localAt(10), Offset: offset(10), Label: "dog", Score: 70},
+		{Time: localAt(20), Offset: offset(20), Label: "person", Score: 90},
+	}
+	require.Equal(t, expectedFrames, report.Frames)
+}
+
+func TestNewReportMaxFrames(t *testing.T) {
+	start := time.Date(2000, 1, 1, 1, 1, 1, 0, time.UTC)
+	recData := storage.RecordingData{Start: start, End: start.Add(time.Minute)}
+	for i := 0; i < reportMaxFrames+2; i++ {
+		recData.Events = append(recData.Events, storage.Event{
+			Time: start.Add(time.Duration(i) * time.Second),
+			Detections: []storage.Detection{
+				{Label: "person", Score: float64(50 + i%3*10)},
+			},
+		})
+	}
+	report := newReport("2000-01-01_01-01-01_m1", recData, time.UTC, "")
+	require.Len(t, report.Detections, reportMaxFrames+2)
+
+	// Scores 50, 60, 70, 50, 60, 70, 50, 60, the last two 50s are dropped.
+	var offsets []ReportOffset
+	for _, frame := range report.Frames {
+		offsets = append(offsets, frame.Offset)
+	}
+	expected := []ReportOffset{0, 1, 2, 4, 5, 7}
+	for i := range expected {
+		expected[i] *= ReportOffset(time.Second)
+	}
+	require.Equal(t, expected, offsets)
+}
+
+func testReportJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	require.NoError(t, jpeg.Encode(&b, image.NewRGBA(image.Rect(0, 0, width, height)), nil))
+	return b.Bytes()
+}
+
+// newTestReporter returns a reporter of a recordings directory with
+// a recording with multiple detections and a recording without events.
+func newTestReporter(t *testing.T, extract ExtractFrameFunc) *Reporter {
+	t.Helper()
+	recordingsDir := t.TempDir()
+	recDir := filepath.Join(recordingsDir, "2000", "01", "01", "m1")
+	require.NoError(t, os.MkdirAll(recDir, 0o700))
+
+	start := time.Date(2000, 1, 1, 1, 1, 1, 0, time.UTC)
+	recData := storage.RecordingData{
+		Start: start,
+		End:   start.Add(time.Minute),
+		Events: []storage.Event{
+			{
+				Time:   start.Add(5 * time.Second),
+				Source: "doods",
+				Detections: []storage.Detection{
+					{Label: "person", Score: 91, Zones: []string{"driveway"}},
+					{Label: "car", Score: 72},
+				},
+			},
+			{
+				Time:       start.Add(20 * time.Second),
+				Source:     "doods",
+				Detections: []storage.Detection{{Label: "dog", Score: 55}},
+			},
+		},
+	}
+	rawData, err := json.Marshal(recData)
+	require.NoError(t, err)
+	emptyData, err := json.Marshal(storage.RecordingData{Start: start, End: start.Add(time.Minute)})
+	require.NoError(t, err)
+
+	files := map[string][]byte{
+		"2000-01-01_01-01-01_m1.json": rawData,
+		"2000-01-01_01-01-01_m1.mp4":  []byte("video"),
+		"2000-01-01_01-01-02_m1.json": emptyData,
+		"2000-01-01_01-01-02_m1.jpeg": testReportJPEG(t, 4, 2),
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(recDir, name), content, 0o600))
+	}
+
+	monitorName := func(monitorID string) string {
+		if monitorID == "m1" {
+			return "Garage"
+		}
+		return ""
+	}
+	return NewReporter(
+		storage.RecordingsDirs{recordingsDir},
+		storage.NewVideoCache(),
+		time.UTC,
+		monitorName,
+		extract,
+	)
+}
+
+func TestReportPage(t *testing.T) {
+	hooks := TemplateHooks{
+		Tpl: func(map[string]string) error { return nil },
+		Sub: func(map[string]string) error { return nil },
+	}
+	templater, err := NewTemplater(&stubAuth{}, newTestAssets(t), hooks)
+	require.NoError(t, err)
+
+	var offsets []time.Duration
+	extract := func(_ context.Context, video io.Reader, offset time.Duration, width int) ([]byte, error) {
+		b, err := io.ReadAll(video)
+		require.NoError(t, err)
+		require.Equal(t, "video", string(b))
+		require.Equal(t, reportFrameWidth, width)
+		offsets = append(offsets, offset)
+		return testReportJPEG(t, 16, 9), nil
+	}
+	h := newTestReporter(t, extract).Page(templater, "report.tpl")
+
+	get := func(t *testing.T, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		w := get(t, "/report/2000-01-01_01-01-01_m1")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, []time.Duration{5 * time.Second, 20 * time.Second}, offsets)
+
+		body := w.Body.String()
+		require.Contains(t, body, "Garage (m1)")
+		require.Contains(t, body, "2000-01-01 01:01:01 UTC")
+		require.Contains(t, body, `href="../api/recording/video/2000-01-01_01-01-01_m1"`)
+		require.Contains(t, body, `href="../api/recording/2000-01-01_01-01-01_m1/report.pdf"`)
+		require.Contains(t, body, "@media print")
+		require.Equal(t, 2, bytes.Count(w.Body.Bytes(), []byte(`src="data:image/jpeg;base64,`)))
+
+		detections := regexp.MustCompile(
+			`<td>00:00:05</td>\s*<td>person</td>\s*<td>91%</td>\s*<td>doods</td>\s*<td>driveway</td>` +
+				`[\s\S]*<td>00:00:05</td>\s*<td>car</td>\s*<td>72%</td>` +
+				`[\s\S]*<td>00:00:20</td>\s*<td>dog</td>\s*<td>55%</td>`)
+		require.Regexp(t, detections, body)
+	})
+	t.Run("thumbnail", func(t *testing.T) {
+		w := get(t, "/report/2000-01-01_01-01-02_m1")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, 1, bytes.Count(w.Body.Bytes(), []byte(`src="data:image/jpeg;base64,`)))
+		require.Contains(t, w.Body.String(), "No detections.")
+	})
+	t.Run("notFound", func(t *testing.T) {
+		w := get(t, "/report/2000-01-01_01-01-03_m1")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+	t.Run("invalidID", func(t *testing.T) {
+		w := get(t, "/report/x")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestReportPDF(t *testing.T) {
+	extract := func(context.Context, io.Reader, time.Duration, int) ([]byte, error) {
+		return testReportJPEG(t, 16, 9), nil
+	}
+	h := newTestReporter(t, extract).PDF()
+
+	get := func(t *testing.T, method string, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+		return w
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		w := get(t, http.MethodGet, "/api/recording/2000-01-01_01-01-01_m1/report.pdf")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+		require.Equal(t,
+			`attachment; filename=report_2000-01-01_01-01-01_m1.pdf`,
+			w.Header().Get("Content-Disposition"))
+
+		doc := w.Body.Bytes()
+		requireValidPDF(t, doc)
+		require.Equal(t, 2, bytes.Count(doc, []byte("/Subtype /Image")))
+		require.Contains(t, string(doc), "/URI (http://example.com/api/recording/video/2000-01-01_01-01-01_m1)")
+	})
+	t.Run("extractError", func(t *testing.T) {
+		h := newTestReporter(t, func(context.Context, io.Reader, time.Duration, int) ([]byte, error) {
+			return nil, io.ErrUnexpectedEOF
+		}).PDF()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/recording/2000-01-01_01-01-01_m1/report.pdf", nil)
+		h.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		requireValidPDF(t, w.Body.Bytes())
+		require.NotContains(t, w.Body.String(), "/Subtype /Image")
+	})
+	t.Run("methodNotAllowed", func(t *testing.T) {
+		w := get(t, http.MethodPost, "/api/recording/2000-01-01_01-01-01_m1/report.pdf")
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+	t.Run("notFound", func(t *testing.T) {
+		w := get(t, http.MethodGet, "/api/recording/2000-01-01_01-01-03_m1/report.pdf")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+// requireValidPDF checks that the cross-reference table points at the objects.
+func requireValidPDF(t *testing.T, doc []byte) {
+	t.Helper()
+	require.True(t, bytes.HasPrefix(doc, []byte("%PDF-1.4\n")))
+
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(doc)
+	require.NotNil(t, m)
+	xref, err := strconv.Atoi(string(m[1]))
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(doc[xref:], []byte("xref\n")))
+
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(doc[xref:], -1)
+	require.NotEmpty(t, entries)
+	for i, entry := range entries {
+		offset, err := strconv.Atoi(string(entry[1]))
+		require.NoError(t, err)
+		require.True(t, bytes.HasPrefix(doc[offset:], []byte(strconv.Itoa(i+1)+" 0 obj\n")))
+	}
+}
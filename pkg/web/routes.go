@@ -3,56 +3,44 @@
 package web
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/url"
+	"nvr/pkg/audit"
 	"nvr/pkg/group"
 	"nvr/pkg/log"
 	"nvr/pkg/monitor"
 	"nvr/pkg/storage"
+	"nvr/pkg/web/api"
 	"nvr/pkg/web/auth"
-	"nvr/web/static"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/gorilla/websocket"
 )
 
-const jsonContentType = "application/json"
-
-// Static serves files from `web/static`.
-func Static() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
-			return
-		}
-		// w.Header().Set("Cache-Control", "max-age=2629800")
-		w.Header().Set("Cache-Control", "no-cache")
-
-		h := http.StripPrefix("/static/", http.FileServer(http.FS(static.Static)))
-		h.ServeHTTP(w, r)
-	})
-}
-
 // TimeZone returns system timeZone.
 func TimeZone(timeZone string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
-			return
-		}
-		w.Header().Set("Content-Type", jsonContentType)
-		err := json.NewEncoder(w).Encode(timeZone)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.MethodNotAllowed(w)
 			return
 		}
+		api.WriteJSON(w, r, timeZone)
 	})
 }
 
@@ -60,16 +48,11 @@ func TimeZone(timeZone string) http.Handler {
 func General(general *storage.ConfigGeneral) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
-		w.Header().Set("Content-Type", jsonContentType)
-		err := json.NewEncoder(w).Encode(general.Get())
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		api.WriteJSON(w, r, general.Get())
 	})
 }
 
@@ -77,27 +60,35 @@ func General(general *storage.ConfigGeneral) http.Handler {
 func GeneralSet(general *storage.ConfigGeneral) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
 		var config map[string]string
 		err := json.NewDecoder(r.Body).Decode(&config)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			api.BadRequest(w, err.Error())
 			return
 		}
 
 		if config["diskSpace"] == "" {
-			http.Error(w, "DiskSpace missing", http.StatusBadRequest)
+			api.BadRequest(w, "DiskSpace missing")
 			return
 		}
+		if v := config["maxLockedPercent"]; v != "" {
+			percent, err := strconv.Atoi(v)
+			if err != nil || percent < 1 || percent > 100 {
+				api.BadRequest(w, "maxLockedPercent must be between 1 and 100")
+				return
+			}
+		}
 
 		err = general.Set(config)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.InternalError(w, r, "could not set general config", err)
 			return
 		}
+		api.WriteOK(w, r)
 	})
 }
 
@@ -105,71 +96,147 @@ func GeneralSet(general *storage.ConfigGeneral) http.Handler {
 func Users(a auth.Authenticator) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
-		w.Header().Set("Content-Type", jsonContentType)
-		err := json.NewEncoder(w).Encode(a.UsersList())
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		api.WriteJSON(w, r, a.UsersList())
 	})
 }
 
 // UserSet handler to set user details.
-func UserSet(a auth.Authenticator) http.Handler {
+func UserSet(a auth.Authenticator, auditf AuditFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
 		var req auth.SetUserRequest
 		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			api.BadRequest(w, err.Error())
+			return
 		}
 
 		for _, r := range req.Username {
 			if unicode.IsUpper(r) {
-				http.Error(
-					w,
-					fmt.Sprintf("username cannot contain uppercase letters: %q", string(r)),
-					http.StatusBadRequest,
-				)
+				api.BadRequest(w,
+					fmt.Sprintf("username cannot contain uppercase letters: %q", string(r)))
 				return
 			}
 		}
 
+		action := audit.ActionAccountCreate
+		if _, exists := a.UsersList()[req.ID]; exists {
+			action = audit.ActionAccountUpdate
+		}
+		if err := auditf(r, action, req.ID); err != nil {
+			api.InternalError(w, r, "could not write audit entry", err)
+			return
+		}
+
+		// The requester stays logged in after changing their own password.
+		req.KeepSessionID = a.ValidateRequest(r).SessionID
+
 		err = a.UserSet(req)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			api.BadRequest(w, err.Error())
 			return
 		}
+		api.WriteOK(w, r)
+	})
+}
+
+type accountSession struct {
+	auth.Session
+	Current bool `json:"current"`
+}
+
+// AccountSessions lists and revokes browser sessions. Users can only see
+// and revoke their own sessions, admins can see and revoke all sessions.
+// DELETE without a id revokes all other sessions of the requesting user.
+func AccountSessions(a auth.Authenticator, auditf AuditFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := a.ValidateRequest(r)
+		accountID := res.User.ID
+		if res.User.IsAdmin {
+			accountID = ""
+		}
+
+		id := strings.TrimPrefix(r.URL.Path, "/api/account/sessions")
+		id = strings.TrimPrefix(id, "/")
+
+		switch {
+		case r.Method == http.MethodGet && id == "":
+			sessions := []accountSession{}
+			for _, session := range a.SessionsList(accountID) {
+				sessions = append(sessions, accountSession{
+					Session: session,
+					Current: session.ID == res.SessionID,
+				})
+			}
+			api.WriteJSON(w, r, sessions)
+
+		case r.Method == http.MethodDelete && id == "":
+			if err := auditf(r, audit.ActionSessionRevokeAll, res.User.ID); err != nil {
+				api.InternalError(w, r, "could not write audit entry", err)
+				return
+			}
+			err := a.SessionsRevokeAll(res.User.ID, res.SessionID)
+			if err != nil {
+				api.InternalError(w, r, "could not revoke sessions", err)
+				return
+			}
+			api.WriteOK(w, r)
+
+		case r.Method == http.MethodDelete:
+			if err := auditf(r, audit.ActionSessionRevoke, id); err != nil {
+				api.InternalError(w, r, "could not write audit entry", err)
+				return
+			}
+			err := a.SessionRevoke(id, accountID)
+			if errors.Is(err, auth.ErrSessionNotExist) {
+				api.NotFound(w, "session does not exist")
+				return
+			}
+			if err != nil {
+				api.InternalError(w, r, "could not revoke session", err)
+				return
+			}
+			api.WriteOK(w, r)
+
+		default:
+			api.MethodNotAllowed(w)
+		}
 	})
 }
 
 // UserDelete handler to delete user.
-func UserDelete(a auth.Authenticator) http.Handler {
+func UserDelete(a auth.Authenticator, auditf AuditFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
 		name := r.URL.Query().Get("id")
 		if name == "" {
-			http.Error(w, "id missing", http.StatusBadRequest)
+			api.BadRequest(w, "id missing")
+			return
+		}
+
+		if err := auditf(r, audit.ActionAccountDelete, name); err != nil {
+			api.InternalError(w, r, "could not write audit entry", err)
 			return
 		}
 
 		err := a.UserDelete(name)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.InternalError(w, r, "could not delete user", err)
 			return
 		}
+		api.WriteOK(w, r)
 	})
 }
 
@@ -177,16 +244,11 @@ func UserDelete(a auth.Authenticator) http.Handler {
 func MonitorList(monitorInfo func() monitor.RawConfigs) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
-		w.Header().Set("Content-Type", jsonContentType)
-		err := json.NewEncoder(w).Encode(monitorInfo())
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		api.WriteJSON(w, r, monitorInfo())
 	})
 }
 
@@ -194,16 +256,11 @@ func MonitorList(monitorInfo func() monitor.RawConfigs) http.Handler {
 func MonitorConfigs(c *monitor.Manager) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
-		w.Header().Set("Content-Type", jsonContentType)
-		err := json.NewEncoder(w).Encode(c.MonitorConfigs())
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		api.WriteJSON(w, r, c.MonitorConfigs())
 	})
 }
 
@@ -211,115 +268,537 @@ func MonitorConfigs(c *monitor.Manager) http.Handler {
 func MonitorRestart(m *monitor.Manager) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
 		id := r.URL.Query().Get("id")
 		if id == "" {
-			http.Error(w, "id missing", http.StatusBadRequest)
+			api.BadRequest(w, "id missing")
 			return
 		}
 
 		err := m.RestartMonitor(id)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("could not restart monitor: %v", err),
-				http.StatusInternalServerError)
+			api.InternalError(w, r, "could not restart monitor", err)
+			return
 		}
+		api.WriteOK(w, r)
 	})
 }
 
 // MonitorSet handler to set monitor configuration.
-func MonitorSet(m *monitor.Manager) http.Handler {
+func MonitorSet(m *monitor.Manager, auditf AuditFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
 		var c monitor.RawConfig
 		err := json.NewDecoder(r.Body).Decode(&c)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			api.BadRequest(w, err.Error())
 			return
 		}
 
 		if err := checkIDandName(c); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			api.BadRequest(w, err.Error())
+			return
+		}
+
+		if err := auditf(r, audit.ActionMonitorSet, c["id"]); err != nil {
+			api.InternalError(w, r, "could not write audit entry", err)
 			return
 		}
 
 		err = m.MonitorSet(c["id"], c)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.InternalError(w, r, "could not set monitor", err)
 			return
 		}
+		api.WriteOK(w, r)
 	})
 }
 
 // MonitorDelete handler to delete monitor.
-func MonitorDelete(m *monitor.Manager) http.Handler {
+func MonitorDelete(m *monitor.Manager, auditf AuditFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
 		id := r.URL.Query().Get("id")
 		if id == "" {
-			http.Error(w, "id missing", http.StatusBadRequest)
+			api.BadRequest(w, "id missing")
+			return
+		}
+
+		if err := auditf(r, audit.ActionMonitorDelete, id); err != nil {
+			api.InternalError(w, r, "could not write audit entry", err)
 			return
 		}
 
 		err := m.MonitorDelete(id)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.InternalError(w, r, "could not delete monitor", err)
+			return
+		}
+		api.WriteOK(w, r)
+	})
+}
+
+// MonitorImportResponse warnings of an imported monitor config.
+type MonitorImportResponse struct {
+	Warnings []string `json:"warnings"`
+}
+
+func writeMonitorImport(w http.ResponseWriter, r *http.Request, warnings []string, err error) {
+	switch {
+	case errors.Is(err, monitor.ErrMonitorNotExist):
+		api.NotFound(w, err.Error())
+	case errors.Is(err, monitor.ErrMonitorExist):
+		api.Conflict(w, err.Error())
+	case err != nil:
+		api.InternalError(w, r, "could not import monitor", err)
+	default:
+		if warnings == nil {
+			warnings = []string{}
+		}
+		api.WriteJSON(w, r, MonitorImportResponse{Warnings: warnings})
+	}
+}
+
+// newIDandName returns the "id" and "name" query parameters
+// of an imported monitor, the name defaults to the ID.
+func newIDandName(query url.Values) (string, string) {
+	id := query.Get("id")
+	name := query.Get("name")
+	if name == "" {
+		name = id
+	}
+	return id, name
+}
+
+// MonitorImport handles "/api/monitor/config/import?id=x&name=y".
+// The body is a exported monitor config, the ID and name are replaced.
+func MonitorImport(m *monitor.Manager, auditf AuditFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		var c monitor.RawConfig
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			api.BadRequest(w, err.Error())
+			return
+		}
+		if c == nil {
+			c = make(monitor.RawConfig)
+		}
+		c["id"], c["name"] = newIDandName(r.URL.Query())
+
+		if err := checkIDandName(c); err != nil {
+			api.BadRequest(w, err.Error())
 			return
 		}
+
+		if err := auditf(r, audit.ActionMonitorImport, c["id"]); err != nil {
+			api.InternalError(w, r, "could not write audit entry", err)
+			return
+		}
+
+		warnings, err := m.MonitorImport(c)
+		writeMonitorImport(w, r, warnings, err)
 	})
 }
 
+// MonitorConfigByID handles "/api/monitor/config/<id>/export"
+// and "/api/monitor/config/<id>/duplicate?id=x&name=y".
+func MonitorConfigByID(m *monitor.Manager, auditf AuditFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		monitorID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/monitor/config/"), "/")
+		switch action {
+		case "export":
+			monitorExport(w, r, m, monitorID)
+		case "duplicate":
+			monitorDuplicate(w, r, m, auditf, monitorID)
+		default:
+			api.NotFound(w, "not found")
+		}
+	})
+}
+
+// monitorExport responds with the indented config of a single monitor.
+// The input credentials are redacted if the "redact" query is true.
+func monitorExport(w http.ResponseWriter, r *http.Request, m *monitor.Manager, id string) {
+	if r.Method != http.MethodGet {
+		api.MethodNotAllowed(w)
+		return
+	}
+
+	rawConf, err := m.MonitorConfig(id)
+	if err != nil {
+		if errors.Is(err, monitor.ErrMonitorNotExist) {
+			api.NotFound(w, err.Error())
+			return
+		}
+		api.InternalError(w, r, "could not get monitor config", err)
+		return
+	}
+	if r.URL.Query().Get("redact") == "true" {
+		rawConf = monitor.RedactCredentials(rawConf)
+	}
+
+	configJSON, err := json.MarshalIndent(rawConf, "", "    ")
+	if err != nil {
+		api.InternalError(w, r, "could not marshal monitor config", err)
+		return
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": id + ".json"})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", disposition)
+	w.Write(configJSON) //nolint:errcheck
+}
+
+// monitorDuplicate creates a copy of the monitor with a new ID and name.
+func monitorDuplicate(
+	w http.ResponseWriter,
+	r *http.Request,
+	m *monitor.Manager,
+	auditf AuditFunc,
+	srcID string,
+) {
+	if r.Method != http.MethodPost {
+		api.MethodNotAllowed(w)
+		return
+	}
+
+	id, name := newIDandName(r.URL.Query())
+	if err := checkIDandName(monitor.RawConfig{"id": id, "name": name}); err != nil {
+		api.BadRequest(w, err.Error())
+		return
+	}
+
+	if err := auditf(r, audit.ActionMonitorImport, id); err != nil {
+		api.InternalError(w, r, "could not write audit entry", err)
+		return
+	}
+
+	warnings, err := m.MonitorDuplicate(srcID, id, name)
+	writeMonitorImport(w, r, warnings, err)
+}
+
+// MonitorTriggerRequest body of "/api/monitor/<id>/trigger".
+type MonitorTriggerRequest struct {
+	Label string  `json:"label"`
+	Score float64 `json:"score"`
+
+	// Seconds to record after the trigger.
+	Duration float64 `json:"duration"`
+
+	// Replaces the detection created from the label and score.
+	Detections []storage.Detection `json:"detections"`
+}
+
+// MonitorTriggerResponse the recording ID is empty
+// if the recording didn't start in time.
+type MonitorTriggerResponse struct {
+	RecordingID string `json:"recordingID"`
+}
+
+const (
+	defaultTriggerDuration = 120 * time.Second
+	maxTriggerDuration     = 1 * time.Hour
+
+	// Time to wait for the first segment of a new recording.
+	triggerWaitTimeout = 10 * time.Second
+)
+
+// Errors.
+var (
+	ErrTriggerScore    = errors.New("score must be between 0 and 100")
+	ErrTriggerDuration = fmt.Errorf(
+		"duration must be between 0 and %v seconds", maxTriggerDuration.Seconds())
+)
+
+// event validates the request and returns the trigger event.
+func (req MonitorTriggerRequest) event(now time.Time) (storage.Event, error) {
+	detections := req.Detections
+	if len(detections) == 0 {
+		detections = []storage.Detection{{Label: req.Label, Score: req.Score}}
+	}
+	for _, d := range detections {
+		if d.Label == "" {
+			return storage.Event{}, fmt.Errorf("label: %w", ErrEmptyValue)
+		}
+		if d.Score < 0 || d.Score > 100 {
+			return storage.Event{}, fmt.Errorf("%w: %v", ErrTriggerScore, d.Score)
+		}
+	}
+
+	recDuration := time.Duration(req.Duration * float64(time.Second))
+	switch {
+	case req.Duration == 0:
+		recDuration = defaultTriggerDuration
+	case recDuration <= 0 || recDuration > maxTriggerDuration:
+		return storage.Event{}, fmt.Errorf("%w: %v", ErrTriggerDuration, req.Duration)
+	}
+
+	return storage.Event{
+		Time:        now,
+		Detections:  detections,
+		RecDuration: recDuration,
+		Source:      storage.EventSourceAPI,
+	}, nil
+}
+
+// MonitorHandlerFunc returns the handler of a monitor action.
+type MonitorHandlerFunc func(monitorID string) http.Handler
+
+// MonitorByID handles "/api/monitor/<id>/trigger", "/api/monitor/<id>/recorder"
+// and "/api/monitor/<id>/hls-debug". The caller is responsible
+// for the authentication of the HLS debug handler.
+func MonitorByID(m *monitor.Manager, hlsDebug MonitorHandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		monitorID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/monitor/"), "/")
+		switch action {
+		case "trigger":
+			monitorTrigger(w, r, m, monitorID)
+		case "recorder":
+			monitorRecorder(w, r, m, monitorID)
+		case "hls-debug":
+			hlsDebug(monitorID).ServeHTTP(w, r)
+		default:
+			api.NotFound(w, "not found")
+		}
+	})
+}
+
+// monitorTrigger starts or extends a recording from a external trigger.
+func monitorTrigger(w http.ResponseWriter, r *http.Request, m *monitor.Manager, id string) {
+	if r.Method != http.MethodPost {
+		api.MethodNotAllowed(w)
+		return
+	}
+
+	var req MonitorTriggerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.BadRequest(w, err.Error())
+		return
+	}
+	event, err := req.event(time.Now())
+	if err != nil {
+		api.BadRequest(w, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), triggerWaitTimeout)
+	defer cancel()
+
+	recordingID, err := m.TriggerEvent(ctx, id, event)
+	var limitErr monitor.TriggerLimitError
+	switch {
+	case errors.Is(err, monitor.ErrMonitorNotExist):
+		api.NotFound(w, err.Error())
+	case errors.Is(err, monitor.ErrMonitorDisabled):
+		api.Conflict(w, err.Error())
+	case errors.As(err, &limitErr):
+		api.TooManyRequests(w, err.Error(), limitErr.RetryAfter)
+	case err != nil:
+		api.InternalError(w, r, "could not trigger monitor", err)
+	default:
+		api.WriteJSON(w, r, MonitorTriggerResponse{RecordingID: recordingID})
+	}
+}
+
+// monitorRecorder returns the event timer state of the recorder.
+func monitorRecorder(w http.ResponseWriter, r *http.Request, m *monitor.Manager, id string) {
+	if r.Method != http.MethodGet {
+		api.MethodNotAllowed(w)
+		return
+	}
+
+	status, err := m.RecorderStatus(id)
+	switch {
+	case errors.Is(err, monitor.ErrMonitorNotExist):
+		api.NotFound(w, err.Error())
+	case errors.Is(err, monitor.ErrMonitorDisabled):
+		api.Conflict(w, err.Error())
+	case err != nil:
+		api.InternalError(w, r, "could not get recorder status", err)
+	default:
+		api.WriteJSON(w, r, status)
+	}
+}
+
+// PushFrameFunc queues a pushed frame for a monitor.
+type PushFrameFunc func(ctx context.Context, monitorID string, frame []byte) error
+
+// Errors.
+var (
+	ErrIngestContentType = errors.New("content type must be image/jpeg or multipart")
+	ErrIngestFrameSize   = errors.New("frame is too large")
+	ErrIngestNotJPEG     = errors.New("frame is not a JPEG")
+)
+
+// MonitorIngest handles "/api/ingest/<monitor-id>". The body is a single
+// JPEG or a continuous multipart stream of JPEGs, MJPEG over HTTP. The
+// monitor must have the push input type.
+func MonitorIngest(push PushFrameFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			api.MethodNotAllowed(w)
+			return
+		}
+		monitorID := strings.TrimPrefix(r.URL.Path, "/api/ingest/")
+
+		pushFrame := func(body io.Reader) error {
+			frame, err := readIngestFrame(body)
+			if err != nil {
+				return err
+			}
+			return push(r.Context(), monitorID, frame)
+		}
+
+		mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		var err error
+		switch {
+		case mediaType == "image/jpeg":
+			err = pushFrame(r.Body)
+		case strings.HasPrefix(mediaType, "multipart/"):
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			for {
+				var part *multipart.Part
+				part, err = mr.NextPart()
+				if errors.Is(err, io.EOF) {
+					err = nil
+					break
+				}
+				if err != nil {
+					break
+				}
+				if err = pushFrame(part); err != nil {
+					break
+				}
+			}
+		default:
+			err = ErrIngestContentType
+		}
+
+		switch {
+		case err == nil:
+			api.WriteOK(w, r)
+		case errors.Is(err, monitor.ErrMonitorNotExist):
+			api.NotFound(w, err.Error())
+		case errors.Is(err, monitor.ErrMonitorDisabled),
+			errors.Is(err, monitor.ErrNotPushInput):
+			api.Conflict(w, err.Error())
+		case r.Context().Err() != nil:
+			// The source disconnected.
+		default:
+			api.BadRequest(w, err.Error())
+		}
+	})
+}
+
+// readIngestFrame reads a JPEG frame.
+func readIngestFrame(r io.Reader) ([]byte, error) {
+	frame, err := io.ReadAll(io.LimitReader(r, monitor.MaxIngestFrameSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) > monitor.MaxIngestFrameSize {
+		return nil, ErrIngestFrameSize
+	}
+	if !bytes.HasPrefix(frame, []byte{0xff, 0xd8}) {
+		return nil, ErrIngestNotJPEG
+	}
+	return frame, nil
+}
+
 // GroupConfigs returns group configurations in json format.
 func GroupConfigs(m *group.Manager) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
-		w.Header().Set("Content-Type", jsonContentType)
-		err := json.NewEncoder(w).Encode(m.Configs())
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		api.WriteJSON(w, r, m.Configs())
 	})
 }
 
 // GroupSet handler to set group configuration.
-func GroupSet(m *group.Manager) http.Handler {
+func GroupSet(m *group.Manager, auditf AuditFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
 		var g group.Config
 		err := json.NewDecoder(r.Body).Decode(&g)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			api.BadRequest(w, err.Error())
 			return
 		}
 
 		if err := checkIDandNameGroup(g); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			api.BadRequest(w, err.Error())
+			return
+		}
+
+		if errs := group.ValidateLayout(g); len(errs) != 0 {
+			api.BadRequest(w, "invalid layout: "+errs[0].Error())
+			return
+		}
+
+		if err := auditf(r, audit.ActionGroupSet, g["id"]); err != nil {
+			api.InternalError(w, r, "could not write audit entry", err)
 			return
 		}
 
 		if err = m.GroupSet(g["id"], g); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.InternalError(w, r, "could not set group", err)
+			return
+		}
+		api.WriteOK(w, r)
+	})
+}
+
+// GroupLayout handler that validates a group layout for the layout
+// editor. Takes a group configuration and returns the parsed layout
+// and the validation errors of each cell.
+func GroupLayout() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		var g group.Config
+		if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+			api.BadRequest(w, err.Error())
 			return
 		}
+
+		res := struct {
+			Layout *group.Layout     `json:"layout"`
+			Errors []group.CellError `json:"errors"`
+		}{
+			Errors: []group.CellError{},
+		}
+		if errs := group.ValidateLayout(g); errs != nil {
+			res.Errors = errs
+		}
+		// The layout is nil if it can't be parsed.
+		res.Layout, _ = g.Layout()
+
+		api.WriteJSON(w, r, res)
 	})
 }
 
@@ -363,69 +842,373 @@ func checkIDandNameGroup(input map[string]string) error {
 }
 
 // GroupDelete handler to delete group.
-func GroupDelete(m *group.Manager) http.Handler {
+func GroupDelete(m *group.Manager, auditf AuditFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
 		id := r.URL.Query().Get("id")
 		if id == "" {
-			http.Error(w, "id missing", http.StatusBadRequest)
+			api.BadRequest(w, "id missing")
+			return
+		}
+
+		if err := auditf(r, audit.ActionGroupDelete, id); err != nil {
+			api.InternalError(w, r, "could not write audit entry", err)
 			return
 		}
 
 		err := m.GroupDelete(id)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.InternalError(w, r, "could not delete group", err)
 			return
 		}
+		api.WriteOK(w, r)
 	})
 }
 
-// RecordingDelete deletes a recording.
-func RecordingDelete(recordingsDir string) http.Handler {
+// RecordingDelete handles "/api/recording/delete/<id>" and "/api/recording/<id>".
+func RecordingDelete(
+	recordingsDirs storage.RecordingsDirs,
+	videoCache *storage.VideoCache,
+	auditf AuditFunc,
+) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
-		recID := strings.TrimPrefix(r.URL.Path, "/api/recording/delete/")
+		recID := path.Base(r.URL.Path)
+
+		if err := auditf(r, audit.ActionRecDelete, recID); err != nil {
+			api.InternalError(w, r, "could not write audit entry", err)
+			return
+		}
 
-		err := storage.DeleteRecording(recordingsDir, recID)
+		err := deleteRecording(recordingsDirs, videoCache, recID)
+		if err != nil {
+			status, apiErr := recordingDeleteError(r, err)
+			api.WriteError(w, status, apiErr)
+			return
+		}
+		api.WriteOK(w, r)
+	})
+}
+
+// RecordingDeleteBulkRequest recording IDs to delete.
+type RecordingDeleteBulkRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// RecordingDeleteResult the result of a single recording in a bulk delete.
+// Error is nil if the recording was deleted.
+type RecordingDeleteResult struct {
+	ID    string     `json:"id"`
+	Error *api.Error `json:"error,omitempty"`
+}
+
+const maxBulkDelete = 1000
+
+// RecordingDeleteBulk deletes multiple recordings. A recording that can't
+// be deleted doesn't fail the batch, the result of each ID is returned.
+func RecordingDeleteBulk(
+	recordingsDirs storage.RecordingsDirs,
+	videoCache *storage.VideoCache,
+	auditf AuditFunc,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		var req RecordingDeleteBulkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			api.BadRequest(w, err.Error())
+			return
+		}
+		if len(req.IDs) == 0 {
+			api.BadRequest(w, "ids missing")
+			return
+		}
+		if len(req.IDs) > maxBulkDelete {
+			api.BadRequest(w, fmt.Sprintf("too many ids, max %d", maxBulkDelete))
+			return
+		}
+
+		results := make([]RecordingDeleteResult, 0, len(req.IDs))
+		for _, recID := range req.IDs {
+			result := RecordingDeleteResult{ID: recID}
+			err := auditf(r, audit.ActionRecDelete, recID)
+			if err != nil {
+				apiErr := api.NewInternalError(r, "could not write audit entry", err)
+				result.Error = &apiErr
+				results = append(results, result)
+				continue
+			}
+
+			err = deleteRecording(recordingsDirs, videoCache, recID)
+			if err != nil {
+				_, apiErr := recordingDeleteError(r, err)
+				result.Error = &apiErr
+			}
+			results = append(results, result)
+		}
+		api.WriteJSON(w, r, results)
+	})
+}
+
+func deleteRecording(
+	recordingsDirs storage.RecordingsDirs,
+	videoCache *storage.VideoCache,
+	recID string,
+) error {
+	recordingsDir, recID := recordingsDirs.Resolve(recID)
+	if err := storage.DeleteRecording(recordingsDir, recID); err != nil {
+		return err
+	}
+	// RecordingIDToPath can't fail after a successful delete.
+	recPath, _ := storage.RecordingIDToPath(recID)
+	videoCache.Delete(filepath.Join(recordingsDir, recPath))
+	return nil
+}
+
+// recordingDeleteError returns the status and error envelope of a delete error.
+func recordingDeleteError(r *http.Request, err error) (int, api.Error) {
+	switch {
+	case errors.Is(err, storage.ErrInvalidRecordingID):
+		return http.StatusBadRequest, api.Error{Code: api.CodeBadRequest, Message: err.Error()}
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound, api.Error{Code: api.CodeNotFound, Message: "recording not found"}
+	case errors.Is(err, storage.ErrRecordingInProgress):
+		return http.StatusConflict, api.Error{Code: api.CodeConflict, Message: err.Error()}
+	case errors.Is(err, storage.ErrRecordingLocked):
+		return http.StatusLocked, api.Error{Code: api.CodeLocked, Message: err.Error()}
+	}
+	return http.StatusInternalServerError, api.NewInternalError(r, "could not delete recording", err)
+}
+
+// RecordingByID routes "/api/recording/<id>" to the delete handler and
+// "/api/recording/<id>/<action>" to the handler of the action.
+func RecordingByID(deleteHandler http.Handler, actionHandlers map[string]http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, action, hasAction := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/recording/"), "/")
+		if !hasAction {
+			deleteHandler.ServeHTTP(w, r)
+			return
+		}
+		h, exist := actionHandlers[action]
+		if !exist {
+			api.NotFound(w, "not found")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// RecordingLock handles "/api/recording/<id>/lock" and
+// "/api/recording/<id>/unlock". Locked recordings are
+// not deleted by the storage pruning.
+func RecordingLock(recordingsDirs storage.RecordingsDirs, auditf AuditFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/recording/"), "/")
+
+		var locked bool
+		var auditAction string
+		switch action {
+		case "lock":
+			locked, auditAction = true, audit.ActionRecLock
+		case "unlock":
+			locked, auditAction = false, audit.ActionRecUnlock
+		default:
+			api.NotFound(w, "not found")
+			return
+		}
+
+		if r.Method != http.MethodPatch {
+			api.MethodNotAllowed(w)
+			return
+		}
+
+		if err := auditf(r, auditAction, recID); err != nil {
+			api.InternalError(w, r, "could not write audit entry", err)
+			return
+		}
+
+		err := storage.SetRecordingLocked(recordingsDirs.Find(recID), recID, locked)
 		if err != nil {
 			if errors.Is(err, storage.ErrInvalidRecordingID) {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				api.BadRequest(w, err.Error())
 				return
 			}
 			if errors.Is(err, os.ErrNotExist) {
-				http.Error(w, "", http.StatusNotFound)
+				api.NotFound(w, "recording not found")
+				return
+			}
+			api.InternalError(w, r, "could not set recording lock", err)
+			return
+		}
+		api.WriteOK(w, r)
+	})
+}
+
+// VerifyRecordingFunc verifies the checksums of a recording.
+type VerifyRecordingFunc func(ctx context.Context, recID string) (*storage.Verification, error)
+
+// RecordingVerify handles "/api/recording/<id>/verify". Verifies the
+// checksums of the recording immediately and returns the result.
+func RecordingVerify(verify VerifyRecordingFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			api.MethodNotAllowed(w)
+			return
+		}
+		recID, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/recording/"), "/")
+
+		v, err := verify(r.Context(), recID)
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrInvalidRecordingID):
+				api.BadRequest(w, err.Error())
+			case errors.Is(err, os.ErrNotExist):
+				api.NotFound(w, "recording not found")
+			case errors.Is(err, storage.ErrNoChecksums):
+				api.Conflict(w, err.Error())
+			default:
+				api.InternalError(w, r, "could not verify recording", err)
+			}
+			return
+		}
+		api.WriteJSON(w, r, v)
+	})
+}
+
+// RecordingDetailHook is called before the recording detail is
+// returned, used by addons to add their state to detail.Addons.
+type RecordingDetailHook func(detail *storage.RecordingDetail)
+
+// recordingDetailResponse .
+type recordingDetailResponse struct {
+	*storage.RecordingDetail
+
+	// URL of the thumbnail, empty if the recording doesn't have one.
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+// RecordingDetail handles "/api/recording/<id>/detail". Returns the
+// recording data, the thumbnail and the state of the sidecars in a
+// single response. Saved recordings have a ETag of the response and
+// are revalidated, active recordings are never cached.
+func RecordingDetail(
+	recordingsDirs storage.RecordingsDirs,
+	loc *time.Location,
+	hooks []RecordingDetailHook,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
+			return
+		}
+		recID, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/recording/"), "/")
+
+		detail, err := storage.ReadRecordingDetail(recordingsDirs.Find(recID), recID, loc)
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrInvalidRecordingID):
+				api.BadRequest(w, err.Error())
+			case errors.Is(err, os.ErrNotExist):
+				api.NotFound(w, "recording not found")
+			default:
+				api.InternalError(w, r, "could not read recording", err)
+			}
+			return
+		}
+		detail.Addons = make(map[string]interface{})
+		for _, hook := range hooks {
+			hook(detail)
+		}
+
+		res := recordingDetailResponse{RecordingDetail: detail}
+		if detail.HasFile(".jpeg") {
+			res.Thumbnail = "/api/recording/thumbnail/" + recID
+		}
+
+		var body bytes.Buffer
+		if err := json.NewEncoder(&body).Encode(res); err != nil {
+			api.InternalError(w, r, "could not encode recording", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if detail.Active {
+			w.Header().Set("Cache-Control", "no-store")
+		} else {
+			// The map keys are sorted, the response is
+			// stable until a file of the recording changes.
+			sum := sha256.Sum256(body.Bytes())
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+			if checkIfNoneMatch(w, r) == condFalse {
+				w.WriteHeader(http.StatusNotModified)
 				return
 			}
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		w.Write(body.Bytes()) //nolint:errcheck
+	})
+}
+
+// RecordingScrubber returns the verification counters of the scrubber.
+func RecordingScrubber(status func() storage.ScrubberStatus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
+			return
+		}
+		api.WriteJSON(w, r, status())
+	})
+}
+
+// PurgeHistory returns the reports of the latest storage purge cycles.
+func PurgeHistory(history func() []storage.PurgeReport) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
+			return
+		}
+		api.WriteJSON(w, r, history())
+	})
+}
+
+// PurgePreview returns what the next storage purge cycle would delete.
+func PurgePreview(preview func() storage.PurgeReport) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
 			return
 		}
+		api.WriteJSON(w, r, preview())
 	})
 }
 
 // RecordingThumbnail serves thumbnail by exact recording ID.
-func RecordingThumbnail(recordingsDir string) http.Handler {
+func RecordingThumbnail(recordingsDirs storage.RecordingsDirs) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
 		recID := r.URL.Path[25:] // Trim "/api/recording/thumbnail/"
-		recPath, err := storage.RecordingIDToPath(recID)
+		path, err := recordingPath(recordingsDirs, recID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			api.BadRequest(w, err.Error())
 			return
 		}
 
-		thumbPath := filepath.Join(recordingsDir, recPath+".jpeg")
+		thumbPath := path + ".jpeg"
 
 		// ServeFile will sanitize ".."
 		http.ServeFile(w, r, thumbPath)
@@ -433,54 +1216,219 @@ func RecordingThumbnail(recordingsDir string) http.Handler {
 }
 
 // RecordingVideo serves video by exact recording ID.
-func RecordingVideo(logger *log.Logger, recordingsDir string) http.Handler {
-	videoReaderCache := storage.NewVideoCache()
+func RecordingVideo(recordingsDirs storage.RecordingsDirs, videoCache *storage.VideoCache) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
 		recID := r.URL.Path[21:] // Trim "/api/recording/video/"
-		recPath, err := storage.RecordingIDToPath(recID)
+		path, err := recordingPath(recordingsDirs, recID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			api.BadRequest(w, err.Error())
 			return
 		}
-		path := filepath.Join(recordingsDir, recPath)
-		// Sanitize path.
-		if containsDotDot(path) {
-			http.Error(w, "invalid recording ID", http.StatusBadRequest)
+		serveRecordingVideo(w, r, path, videoCache)
+	})
+}
+
+// recordingPath returns the path of the recording without extension.
+// Legacy recordings are also found by their UTC ID.
+func recordingPath(recordingsDirs storage.RecordingsDirs, recID string) (string, error) {
+	recordingsDir, recID := recordingsDirs.Resolve(recID)
+	recPath, err := storage.RecordingIDToPath(recID)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(recordingsDir, recPath)
+	// Sanitize path.
+	if containsDotDot(path) {
+		return "", storage.ErrInvalidRecordingID
+	}
+	return path, nil
+}
+
+func serveRecordingVideo(
+	w http.ResponseWriter,
+	r *http.Request,
+	path string,
+	videoCache *storage.VideoCache,
+) {
+	mp4Path := path + ".mp4"
+
+	_, err := os.Stat(mp4Path)
+	if err == nil { // File exist.
+		http.ServeFile(w, r, mp4Path)
+		return
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		api.InternalError(w, r, "could not stat mp4 file", err)
+		return
+	}
+
+	video, err := storage.NewVideoReader(path, videoCache)
+	if err != nil {
+		api.InternalError(w, r, "could not read video", err)
+		return
+	}
+	defer video.Close()
+
+	ServeMP4Content(w, r, video.ModTime(), video.Size(), video)
+}
+
+// RecordingFragment is a keyframe aligned byte range of the video of
+// "/api/recording/video/<id>". The times are seconds from the start.
+type RecordingFragment struct {
+	Offset   int64   `json:"offset"`
+	Size     int64   `json:"size"`
+	Start    float64 `json:"start"`
+	Duration float64 `json:"duration"`
+}
+
+// RecordingIndex handles "/api/recording/<id>/index". Players map a time to
+// the byte range of the fragment and request it with a Range header.
+func RecordingIndex(recordingsDirs storage.RecordingsDirs, videoCache *storage.VideoCache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
 			return
 		}
+		recID, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/recording/"), "/")
 
-		mp4Path := path + ".mp4"
+		path, err := recordingPath(recordingsDirs, recID)
+		if err != nil {
+			api.BadRequest(w, err.Error())
+			return
+		}
 
-		_, err = os.Stat(mp4Path)
-		if err == nil { // File exist.
-			http.ServeFile(w, r, mp4Path)
+		video, err := storage.NewVideoReader(path, videoCache)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// Old recordings that are stored as mp4 files have no index.
+				api.NotFound(w, "recording not found")
+				return
+			}
+			api.InternalError(w, r, "could not read video", err)
 			return
 		}
-		if !errors.Is(err, os.ErrNotExist) {
-			http.Error(w, "stat mp4 file", http.StatusInternalServerError)
+		defer video.Close()
+
+		fragments := []RecordingFragment{}
+		for _, f := range video.Index() {
+			fragments = append(fragments, RecordingFragment{
+				Offset:   f.Offset,
+				Size:     f.Size,
+				Start:    f.Start.Seconds(),
+				Duration: f.Duration.Seconds(),
+			})
+		}
+		api.WriteJSON(w, r, fragments)
+	})
+}
+
+// RemuxFunc copies the streams of the input video
+// into a progressive mp4 file at the output path.
+type RemuxFunc func(ctx context.Context, input string, output string) error
+
+// Download formats.
+const (
+	DownloadFormatFragmented  = "fragmented"
+	DownloadFormatProgressive = "progressive"
+)
+
+// RecordingDownload handles "/api/recording/<id>/download?format=x". The
+// fragmented format is the default and the same video as RecordingVideo.
+// The progressive format has no segment index and is generated on the fly,
+// recordings that are stored as mp4 files are remuxed by FFmpeg instead.
+func RecordingDownload(
+	recordingsDirs storage.RecordingsDirs,
+	videoCache *storage.VideoCache,
+	remux RemuxFunc,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
 			return
 		}
 
-		video, err := storage.NewVideoReader(path, videoReaderCache)
+		recID, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/recording/"), "/")
+		path, err := recordingPath(recordingsDirs, recID)
 		if err != nil {
-			logger.Log(log.Entry{
-				Level: log.LevelError,
-				Src:   "app",
-				Msg:   fmt.Sprintf("video request: %v", err),
-			})
-			http.Error(w, "see logs for details", http.StatusInternalServerError)
+			api.BadRequest(w, err.Error())
+			return
 		}
-		defer video.Close()
 
-		ServeMP4Content(w, r, video.ModTime(), video.Size(), video)
+		format := r.URL.Query().Get("format")
+		switch format {
+		case "", DownloadFormatFragmented:
+			setAttachment(w, recID+".mp4")
+			serveRecordingVideo(w, r, path, videoCache)
+		case DownloadFormatProgressive:
+			setAttachment(w, recID+".mp4")
+			serveProgressiveVideo(w, r, path, remux)
+		default:
+			api.BadRequest(w, fmt.Sprintf("invalid format: %q", format))
+		}
 	})
 }
 
+func setAttachment(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+}
+
+func serveProgressiveVideo(w http.ResponseWriter, r *http.Request, path string, remux RemuxFunc) {
+	mp4Path := path + ".mp4"
+
+	_, err := os.Stat(mp4Path)
+	if err == nil { // File exist.
+		serveRemuxedVideo(w, r, mp4Path, remux)
+		return
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		api.InternalError(w, r, "could not stat mp4 file", err)
+		return
+	}
+
+	video, err := storage.NewProgressiveVideoReader(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			api.NotFound(w, "recording not found")
+			return
+		}
+		api.InternalError(w, r, "could not read video", err)
+		return
+	}
+	defer video.Close()
+
+	ServeMP4Content(w, r, video.ModTime(), video.Size(), video)
+}
+
+// serveRemuxedVideo remuxes the video into a temporary file,
+// FFmpeg needs a seekable output to write the moov box first.
+func serveRemuxedVideo(w http.ResponseWriter, r *http.Request, input string, remux RemuxFunc) {
+	tmp, err := os.CreateTemp("", "nvr-download-*.mp4")
+	if err != nil {
+		api.InternalError(w, r, "could not create temp file", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := remux(r.Context(), input, tmp.Name()); err != nil {
+		api.InternalError(w, r, "could not remux video", err)
+		return
+	}
+
+	stat, err := tmp.Stat()
+	if err != nil {
+		api.InternalError(w, r, "could not stat remuxed video", err)
+		return
+	}
+	ServeMP4Content(w, r, stat.ModTime(), stat.Size(), tmp)
+}
+
 func containsDotDot(v string) bool {
 	if !strings.Contains(v, "..") {
 		return false
@@ -495,33 +1443,69 @@ func containsDotDot(v string) bool {
 
 func isSlashRune(r rune) bool { return r == '/' || r == '\\' }
 
+// RecordingSummary handles the per day recording summary of a month.
+// All monitors are included if the monitor parameter is omitted.
+func RecordingSummary(index *storage.SummaryIndex, loc *time.Location) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
+			return
+		}
+		query := r.URL.Query()
+
+		year, err := strconv.Atoi(query.Get("year"))
+		if err != nil {
+			api.BadRequest(w, fmt.Sprintf("invalid year: %q", query.Get("year")))
+			return
+		}
+		month, err := strconv.Atoi(query.Get("month"))
+		if err != nil || month < 1 || month > 12 {
+			api.BadRequest(w, fmt.Sprintf("invalid month: %q", query.Get("month")))
+			return
+		}
+
+		var monitors []string
+		if monitor := query.Get("monitor"); monitor != "" {
+			monitors = []string{monitor}
+		}
+
+		summary, err := index.MonthSummary(year, time.Month(month), loc, monitors)
+		if err != nil {
+			api.InternalError(w, r, "could not summarize recordings", err)
+			return
+		}
+		api.WriteJSON(w, r, summary)
+	})
+}
+
 // RecordingQuery handles recording query.
-func RecordingQuery(crawler *storage.Crawler, logger *log.Logger) http.Handler { //nolint:funlen
+// The times of recordings without a time zone are localized in loc.
+func RecordingQuery(crawler *storage.Crawler, loc *time.Location) http.Handler { //nolint:funlen
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 		query := r.URL.Query()
 		limit := query.Get("limit")
 		if limit == "" {
-			http.Error(w, "limit missing", http.StatusBadRequest)
+			api.BadRequest(w, "limit missing")
 			return
 		}
 
 		limitInt, err := strconv.Atoi(limit)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("could not convert limit to int: %v", err), http.StatusBadRequest)
+			api.BadRequest(w, fmt.Sprintf("could not convert limit to int: %v", err))
 			return
 		}
 
 		time := query.Get("time")
 		if time == "" {
-			http.Error(w, "time missing", http.StatusBadRequest)
+			api.BadRequest(w, "time missing")
 			return
 		}
 		if len(time) < 19 {
-			http.Error(w, "time value to short", http.StatusBadRequest)
+			api.BadRequest(w, "time value to short")
 			return
 		}
 		reverse := query.Get("reverse")
@@ -538,31 +1522,39 @@ func RecordingQuery(crawler *storage.Crawler, logger *log.Logger) http.Handler {
 			data = true
 		}
 
+		var locked *bool
+		if lockedStr := query.Get("locked"); lockedStr != "" {
+			lockedBool, err := strconv.ParseBool(lockedStr)
+			if err != nil {
+				api.BadRequest(w, fmt.Sprintf("could not parse locked: %v", err))
+				return
+			}
+			locked = &lockedBool
+		}
+
 		q := &storage.CrawlerQuery{
 			Time:        time,
 			Limit:       limitInt,
 			Reverse:     reverse == "true",
 			Monitors:    monitors,
 			IncludeData: data,
+			Locked:      locked,
+			Source:      query.Get("source"),
 		}
 
 		recordings, err := crawler.RecordingByQuery(q)
 		if err != nil {
-			logger.Log(log.Entry{
-				Level: log.LevelError,
-				Src:   "app",
-				Msg:   fmt.Sprintf("crawler: could not process recording query: %v", err),
-			})
-			http.Error(w, "could not process recording query", http.StatusInternalServerError)
+			api.InternalError(w, r, "could not process recording query", err)
 			return
 		}
-
-		w.Header().Set("Content-Type", jsonContentType)
-		err = json.NewEncoder(w).Encode(recordings)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		for i, rec := range recordings {
+			if rec.Data != nil {
+				times := rec.Data.Times(loc)
+				recordings[i].Times = &times
+			}
 		}
+
+		api.WriteJSON(w, r, recordings)
 	})
 }
 
@@ -570,92 +1562,135 @@ func RecordingQuery(crawler *storage.Crawler, logger *log.Logger) http.Handler {
 func LogFeed(logger *log.Logger, a auth.Authenticator) http.Handler { //nolint:funlen,gocognit
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
-		query := r.URL.Query()
-
-		levelsCSV := query.Get("levels")
-		var levels []log.Level
-		if levelsCSV != "" {
-			for _, levelStr := range strings.Split(levelsCSV, ",") {
-				levelInt, err := strconv.Atoi(levelStr)
-				if err != nil {
-					http.Error(w,
-						fmt.Sprintf("invalid levels list: %v %v", levelsCSV, err),
-						http.StatusBadRequest)
-				}
-				levels = append(levels, log.Level(levelInt))
-			}
+		filter, err := parseLogFilter(r.URL.Query())
+		if err != nil {
+			api.BadRequest(w, err.Error())
+			return
 		}
 
-		sources := parseCSVParam(query, "sources")
-		monitors := parseCSVParam(query, "monitors")
-
-		q := log.Query{
-			Levels:   levels,
-			Sources:  sources,
-			Monitors: monitors,
+		// The feed defaults to JSON.
+		format := log.FormatJSON
+		if rawFormat := r.URL.Query().Get("format"); rawFormat != "" {
+			format, err = log.ParseFormat(rawFormat)
+			if err != nil {
+				api.BadRequest(w, err.Error())
+				return
+			}
 		}
 
 		upgrader := websocket.Upgrader{}
 		c, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			// Upgrade has already replied with an error.
 			return
 		}
 		defer c.Close()
 
-		feed, cancel := logger.Subscribe()
+		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
+		sub := logger.Subscribe(ctx, filter)
 
+		var dropped uint64
 		for {
 			var entry log.Entry
 			select {
-			case entry = <-feed:
+			case e, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				entry = e
 			case <-logger.Ctx.Done():
 				return
 			}
 
-			if !log.LevelInLevels(entry.Level, q.Levels) {
-				continue
-			}
-			if !log.StringInStrings(entry.Src, q.Sources) {
-				continue
-			}
-
 			// Validate auth before each message.
 			auth := a.ValidateRequest(r)
 			if !auth.IsValid || !auth.User.IsAdmin {
 				return
 			}
 
-			if err := c.WriteJSON(entry); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+			if d := sub.Dropped(); d != dropped {
+				dropped = d
+				raw := encodeLogDropped(dropped, format)
+				if err := c.WriteMessage(websocket.TextMessage, raw); err != nil {
+					return
+				}
+			}
+
+			raw, err := entry.Encode(format)
+			if err != nil {
+				return
+			}
+			if err := c.WriteMessage(websocket.TextMessage, raw); err != nil {
 				return
 			}
 		}
 	})
 }
 
+// parseLogFilter parses the log feed subscription filter. The least
+// severe level in the legacy levels list is used as the min level.
+func parseLogFilter(query url.Values) (log.Filter, error) {
+	var filter log.Filter
+	if rawMinLevel := query.Get("minLevel"); rawMinLevel != "" {
+		minLevel, err := strconv.ParseUint(rawMinLevel, 10, 8)
+		if err != nil {
+			return log.Filter{}, fmt.Errorf("invalid min level: %w", err)
+		}
+		filter.MinLevel = log.Level(minLevel)
+	} else if levelsCSV := query.Get("levels"); levelsCSV != "" {
+		for _, levelStr := range strings.Split(levelsCSV, ",") {
+			level, err := strconv.ParseUint(levelStr, 10, 8)
+			if err != nil {
+				return log.Filter{}, fmt.Errorf("invalid levels list: %v %w", levelsCSV, err)
+			}
+			filter.MinLevel = max(filter.MinLevel, log.Level(level))
+		}
+	}
+
+	filter.Sources = parseCSVParam(query, "sources")
+	filter.MonitorIDs = parseCSVParam(query, "monitors")
+
+	if rawLimit := query.Get("limit"); rawLimit != "" {
+		limit, err := strconv.Atoi(rawLimit)
+		if err != nil || limit < 0 {
+			return log.Filter{}, fmt.Errorf("invalid limit: %v", rawLimit)
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+// encodeLogDropped returns the message that tells the client how many
+// entries that have been dropped in total because it fell behind.
+func encodeLogDropped(dropped uint64, format log.Format) []byte {
+	if format == log.FormatJSON {
+		return []byte(fmt.Sprintf(`{"dropped":%d}`, dropped))
+	}
+	return []byte(fmt.Sprintf("[DROPPED] %d log entries in total", dropped))
+}
+
 // LogQuery handles log queries.
 func LogQuery(logStore *log.Store) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 		query := r.URL.Query()
 
 		limit := query.Get("limit")
 		if limit == "" {
-			http.Error(w, "limit missing", http.StatusBadRequest)
+			api.BadRequest(w, "limit missing")
 			return
 		}
 
 		limitInt, err := strconv.Atoi(limit)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("could not convert limit to int: %v", err), http.StatusBadRequest)
+			api.BadRequest(w, fmt.Sprintf("could not convert limit to int: %v", err))
 			return
 		}
 
@@ -665,9 +1700,8 @@ func LogQuery(logStore *log.Store) http.Handler {
 			for _, levelStr := range strings.Split(levelsCSV, ",") {
 				levelInt, err := strconv.Atoi(levelStr)
 				if err != nil {
-					http.Error(w,
-						fmt.Sprintf("invalid levels list: %v %v", levelsCSV, err),
-						http.StatusBadRequest)
+					api.BadRequest(w, fmt.Sprintf("invalid levels list: %v %v", levelsCSV, err))
+					return
 				}
 				levels = append(levels, log.Level(levelInt))
 			}
@@ -679,7 +1713,7 @@ func LogQuery(logStore *log.Store) http.Handler {
 		time := query.Get("time")
 		timeInt, err := strconv.Atoi(time)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("could not convert time to int: %v", err), http.StatusBadRequest)
+			api.BadRequest(w, fmt.Sprintf("could not convert time to int: %v", err))
 			return
 		}
 
@@ -693,16 +1727,11 @@ func LogQuery(logStore *log.Store) http.Handler {
 
 		logs, err := logStore.Query(q)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.InternalError(w, r, "could not query logs", err)
 			return
 		}
 
-		w.Header().Set("Content-Type", jsonContentType)
-		err = json.NewEncoder(w).Encode(logs)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		api.WriteJSON(w, r, logs)
 	})
 }
 
@@ -719,16 +1748,81 @@ func parseCSVParam(query url.Values, key string) []string {
 func LogSources(l *log.Logger) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			api.MethodNotAllowed(w)
 			return
 		}
 
-		w.Header().Set("Content-Type", jsonContentType)
-		err := json.NewEncoder(w).Encode(l.Sources())
+		api.WriteJSON(w, r, l.Sources())
+	})
+}
+
+// AuditFunc records an audit entry for the request. Handlers
+// must call it before applying the action and abort on error.
+type AuditFunc func(r *http.Request, action string, target string) error
+
+// NewAuditFunc returns a AuditFunc that resolves
+// the acting user and ip from the request.
+func NewAuditFunc(a auth.Authenticator, auditf audit.Func) AuditFunc {
+	return func(r *http.Request, action string, target string) error {
+		res := a.ValidateRequest(r)
+		return auditf(audit.Entry{
+			User:   res.User.Username,
+			IP:     auth.RequestIP(r),
+			Action: action,
+			Target: target,
+			Auth:   res.Source,
+		})
+	}
+}
+
+// AuditQuery handles audit log queries.
+func AuditQuery(auditLog *audit.Log) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			api.MethodNotAllowed(w)
+			return
+		}
+		query := r.URL.Query()
+
+		var q audit.Query
+		if limit := query.Get("limit"); limit != "" {
+			limitInt, err := strconv.Atoi(limit)
+			if err != nil {
+				api.BadRequest(w, fmt.Sprintf("could not convert limit to int: %v", err))
+				return
+			}
+			q.Limit = limitInt
+		}
+
+		parseTime := func(key string) (time.Time, error) {
+			v := query.Get(key)
+			if v == "" {
+				return time.Time{}, nil
+			}
+			t, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("could not convert %v to int: %w", key, err)
+			}
+			return time.UnixMicro(t), nil
+		}
+		var err error
+		if q.Before, err = parseTime("before"); err != nil {
+			api.BadRequest(w, err.Error())
+			return
+		}
+		if q.After, err = parseTime("after"); err != nil {
+			api.BadRequest(w, err.Error())
+			return
+		}
+		q.User = query.Get("user")
+
+		entries, err := auditLog.Query(q)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.InternalError(w, r, "could not query audit log", err)
 			return
 		}
+
+		api.WriteJSON(w, r, entries)
 	})
 }
 
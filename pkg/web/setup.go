@@ -0,0 +1,341 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"nvr/pkg/audit"
+	"nvr/pkg/monitor"
+	"nvr/pkg/web/api"
+	"nvr/pkg/web/auth"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// SetupEnv is the part of the environment config set by the first-run setup.
+type SetupEnv struct {
+	StorageDir string `json:"storageDir"`
+	FFmpegBin  string `json:"ffmpegBin"`
+}
+
+// SetupFuncs are the functions used by the first-run setup to
+// read and persist the state. The state is only stored on disk
+// so a interrupted setup resumes from the first missing step.
+type SetupFuncs struct {
+	// Env returns the current paths and the error
+	// from validating them, nil if they're valid.
+	Env func() (SetupEnv, error)
+	// SetEnv validates and persists the paths.
+	SetEnv func(SetupEnv) error
+
+	// Monitors returns the IDs of the existing monitors.
+	Monitors func() []string
+	// ValidateMonitor validates a monitor config
+	// with the same validators as the settings page.
+	ValidateMonitor func(monitor.RawConfig) error
+	// SetMonitor persists a monitor config.
+	SetMonitor func(monitor.RawConfig) error
+
+	// Completed returns true if the setup was completed by a previous run.
+	Completed func() bool
+	// SetCompleted persists the completion of the setup.
+	SetCompleted func() error
+}
+
+// Setup serves the first-run setup while no accounts exist. The
+// setup is completed by creating the first admin and can't be
+// activated again, even if every account is deleted afterwards.
+// The completion is persisted and survives restarts.
+type Setup struct {
+	auth   auth.Authenticator
+	funcs  SetupFuncs
+	auditf audit.Func
+
+	mu        sync.Mutex
+	completed bool
+	done      chan struct{}
+}
+
+// NewSetup returns a new first-run setup.
+func NewSetup(a auth.Authenticator, funcs SetupFuncs, auditf audit.Func) *Setup {
+	s := &Setup{
+		auth:   a,
+		funcs:  funcs,
+		auditf: auditf,
+		done:   make(chan struct{}),
+	}
+	if funcs.Completed() {
+		s.unsafeComplete()
+	}
+	s.Active()
+	return s
+}
+
+// Active returns true if the setup is required.
+func (s *Setup) Active() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unsafeActive()
+}
+
+func (s *Setup) unsafeActive() bool {
+	if s.completed {
+		return false
+	}
+	if s.auth.AuthDisabled() {
+		s.unsafeComplete()
+	}
+	if len(s.auth.UsersList()) != 0 {
+		// The accounts may predate the completion marker. The error is
+		// ignored, the marker is written again on the next start.
+		s.funcs.SetCompleted() //nolint:errcheck
+		s.unsafeComplete()
+	}
+	return !s.completed
+}
+
+func (s *Setup) unsafeComplete() {
+	if !s.completed {
+		s.completed = true
+		close(s.done)
+	}
+}
+
+// Done is closed when the setup is completed.
+func (s *Setup) Done() <-chan struct{} {
+	return s.done
+}
+
+// SetupStatus is the response of "GET /api/setup".
+type SetupStatus struct {
+	Env      SetupEnv `json:"env"`
+	EnvError string   `json:"envError,omitempty"`
+	Monitors []string `json:"monitors"`
+}
+
+// Errors.
+var (
+	ErrSetupCompleted = errors.New("setup is completed")
+	ErrSetupEnvFirst  = errors.New("the storage directory and ffmpeg binary must be set first")
+)
+
+// Handler returns the setup handler. All paths except
+// the setup page, the static files and the setup
+// endpoints are redirected to the setup page.
+func (s *Setup) Handler(page http.Handler, static http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/setup", page)
+	mux.Handle("/static/", static)
+	mux.HandleFunc("/api/setup", s.handleStatus)
+	mux.HandleFunc("/api/setup/env", s.handleEnv)
+	mux.HandleFunc("/api/setup/monitor", s.handleMonitor)
+	mux.HandleFunc("/api/setup/admin", s.handleAdmin)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		isSetupAPI := r.URL.Path == "/api/setup" || strings.HasPrefix(r.URL.Path, "/api/setup/")
+		if !s.Active() {
+			if isSetupAPI {
+				api.Forbidden(w, ErrSetupCompleted.Error())
+				return
+			}
+			// The app is starting.
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+
+		switch {
+		case isSetupAPI, r.URL.Path == "/setup", strings.HasPrefix(r.URL.Path, "/static/"):
+			mux.ServeHTTP(w, r)
+		default:
+			http.Redirect(w, r, "/setup", http.StatusSeeOther)
+		}
+	})
+}
+
+// SetupCompleted is served on the setup endpoints after the setup.
+func SetupCompleted() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.Forbidden(w, ErrSetupCompleted.Error())
+	})
+}
+
+func (s *Setup) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.MethodNotAllowed(w)
+		return
+	}
+	api.WriteJSON(w, r, s.status())
+}
+
+func (s *Setup) status() SetupStatus {
+	env, err := s.funcs.Env()
+	status := SetupStatus{
+		Env:      env,
+		Monitors: s.funcs.Monitors(),
+	}
+	if err != nil {
+		status.EnvError = err.Error()
+	}
+	if status.Monitors == nil {
+		status.Monitors = []string{}
+	}
+	return status
+}
+
+// decodeSetupRequest locks the setup and decodes the request body.
+// The unlock function is nil if a error response was written.
+// The setup page is unauthenticated, requiring a JSON body
+// prevents cross-site form submissions.
+func (s *Setup) decodeSetupRequest(
+	w http.ResponseWriter,
+	r *http.Request,
+	v interface{},
+) func() {
+	if r.Method != http.MethodPost {
+		api.MethodNotAllowed(w)
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		api.BadRequest(w, "content type must be application/json")
+		return nil
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		api.BadRequest(w, err.Error())
+		return nil
+	}
+
+	s.mu.Lock()
+	if !s.unsafeActive() {
+		s.mu.Unlock()
+		api.Forbidden(w, ErrSetupCompleted.Error())
+		return nil
+	}
+	return s.mu.Unlock
+}
+
+func (s *Setup) audit(r *http.Request, action string, target string) error {
+	return s.auditf(audit.Entry{
+		IP:     auth.RequestIP(r),
+		Action: action,
+		Target: target,
+		Auth:   auth.SourceSetup,
+	})
+}
+
+func (s *Setup) handleEnv(w http.ResponseWriter, r *http.Request) {
+	var env SetupEnv
+	unlock := s.decodeSetupRequest(w, r, &env)
+	if unlock == nil {
+		return
+	}
+	defer unlock()
+
+	if env.StorageDir == "" || env.FFmpegBin == "" {
+		api.BadRequest(w, "storageDir and ffmpegBin are required")
+		return
+	}
+	if err := s.audit(r, audit.ActionSetupEnv, ""); err != nil {
+		api.InternalError(w, r, "could not write audit entry", err)
+		return
+	}
+	if err := s.funcs.SetEnv(env); err != nil {
+		api.BadRequest(w, err.Error())
+		return
+	}
+	api.WriteJSON(w, r, s.status())
+}
+
+func (s *Setup) handleMonitor(w http.ResponseWriter, r *http.Request) {
+	var c monitor.RawConfig
+	unlock := s.decodeSetupRequest(w, r, &c)
+	if unlock == nil {
+		return
+	}
+	defer unlock()
+
+	if _, err := s.funcs.Env(); err != nil {
+		api.Conflict(w, ErrSetupEnvFirst.Error())
+		return
+	}
+	if err := checkIDandName(c); err != nil {
+		api.BadRequest(w, err.Error())
+		return
+	}
+	if err := s.funcs.ValidateMonitor(c); err != nil {
+		api.BadRequest(w, err.Error())
+		return
+	}
+	if err := s.audit(r, audit.ActionMonitorSet, c["id"]); err != nil {
+		api.InternalError(w, r, "could not write audit entry", err)
+		return
+	}
+	if err := s.funcs.SetMonitor(c); err != nil {
+		api.InternalError(w, r, "could not set monitor", err)
+		return
+	}
+	api.WriteJSON(w, r, s.status())
+}
+
+// SetupAdminRequest is the request of "POST /api/setup/admin".
+type SetupAdminRequest struct {
+	Username      string `json:"username"`
+	PlainPassword string `json:"plainPassword"`
+}
+
+// handleAdmin creates the first admin and completes the setup.
+func (s *Setup) handleAdmin(w http.ResponseWriter, r *http.Request) {
+	var req SetupAdminRequest
+	unlock := s.decodeSetupRequest(w, r, &req)
+	if unlock == nil {
+		return
+	}
+	defer unlock()
+
+	if _, err := s.funcs.Env(); err != nil {
+		api.Conflict(w, ErrSetupEnvFirst.Error())
+		return
+	}
+	if req.Username == "" || req.PlainPassword == "" {
+		api.BadRequest(w, "username and plainPassword are required")
+		return
+	}
+	for _, r := range req.Username {
+		if unicode.IsUpper(r) {
+			api.BadRequest(w,
+				fmt.Sprintf("username cannot contain uppercase letters: %q", string(r)))
+			return
+		}
+	}
+
+	id := auth.GenToken()[:16]
+	if err := s.audit(r, audit.ActionAccountCreate, id); err != nil {
+		api.InternalError(w, r, "could not write audit entry", err)
+		return
+	}
+	err := s.auth.UserSet(auth.SetUserRequest{
+		ID:            id,
+		Username:      req.Username,
+		PlainPassword: req.PlainPassword,
+		IsAdmin:       true,
+	})
+	if err != nil {
+		api.BadRequest(w, err.Error())
+		return
+	}
+
+	// The admin exists, the setup is completed even if the marker
+	// can't be written, the accounts keep it completed on restarts.
+	s.unsafeComplete()
+	if err := s.funcs.SetCompleted(); err != nil {
+		api.InternalError(w, r, "could not save setup completion", err)
+		return
+	}
+	api.WriteOK(w, r)
+}
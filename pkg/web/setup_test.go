@@ -0,0 +1,263 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nvr/pkg/audit"
+	"nvr/pkg/monitor"
+	"nvr/pkg/web/auth"
+
+	"github.com/stretchr/testify/require"
+)
+
+type stubSetupAuth struct {
+	auth.Authenticator
+	users map[string]auth.AccountObfuscated
+}
+
+func (a *stubSetupAuth) AuthDisabled() bool { return false }
+
+func (a *stubSetupAuth) UsersList() map[string]auth.AccountObfuscated {
+	return a.users
+}
+
+func (a *stubSetupAuth) UserSet(req auth.SetUserRequest) error {
+	if req.PlainPassword == "" {
+		return errors.New("password missing") //nolint:goerr113
+	}
+	a.users[req.ID] = auth.AccountObfuscated{
+		ID:       req.ID,
+		Username: req.Username,
+		IsAdmin:  req.IsAdmin,
+	}
+	return nil
+}
+
+type testSetup struct {
+	*Setup
+	auth      *stubSetupAuth
+	env       SetupEnv
+	envErr    error
+	monitors  []string
+	completed bool
+	entries   []audit.Entry
+	handler   http.Handler
+}
+
+func newTestSetup(users map[string]auth.AccountObfuscated) *testSetup {
+	return newTestSetupCompleted(users, false)
+}
+
+func newTestSetupCompleted(users map[string]auth.AccountObfuscated, completed bool) *testSetup {
+	s := &testSetup{
+		auth:      &stubSetupAuth{users: users},
+		envErr:    errors.New("storageDir: not writable"), //nolint:goerr113
+		completed: completed,
+	}
+	s.Setup = NewSetup(s.auth, SetupFuncs{
+		Env: func() (SetupEnv, error) { return s.env, s.envErr },
+		SetEnv: func(env SetupEnv) error {
+			if env.FFmpegBin != "/ffmpeg" {
+				return errors.New("ffmpegBin: file does not exist") //nolint:goerr113
+			}
+			s.env, s.envErr = env, nil
+			return nil
+		},
+		Monitors:        func() []string { return s.monitors },
+		ValidateMonitor: monitor.ValidateConfig,
+		SetMonitor: func(c monitor.RawConfig) error {
+			s.monitors = append(s.monitors, c["id"])
+			return nil
+		},
+		Completed: func() bool { return s.completed },
+		SetCompleted: func() error {
+			s.completed = true
+			return nil
+		},
+	}, func(e audit.Entry) error {
+		s.entries = append(s.entries, e)
+		return nil
+	})
+	page := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("page")) //nolint:errcheck
+	})
+	s.handler = s.Handler(page, http.NotFoundHandler())
+	return s
+}
+
+func (s *testSetup) serve(method string, path string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+const testSetupMonitor = `{
+	"id": "1",
+	"name": "one",
+	"enable": "true",
+	"mainStream": "rtsp://x",
+	"videoLength": "15",
+	"timestampOffset": "500"
+}`
+
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestSetupGating(t *testing.T) {
+	s := newTestSetup(map[string]auth.AccountObfuscated{})
+	require.True(t, s.Active())
+
+	for _, path := range []string{"/", "/live", "/api/users", "/api/monitor/set"} {
+		w := s.serve(http.MethodGet, path, "")
+		require.Equal(t, http.StatusSeeOther, w.Code, path)
+		require.Equal(t, "/setup", w.Header().Get("Location"), path)
+	}
+
+	w := s.serve(http.MethodGet, "/setup", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "page", w.Body.String())
+
+	w = s.serve(http.MethodGet, "/api/setup", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var status SetupStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	expected := SetupStatus{
+		EnvError: "storageDir: not writable",
+		Monitors: []string{},
+	}
+	require.Equal(t, expected, status)
+
+	// Form submissions are rejected.
+	r := httptest.NewRequest(http.MethodPost, "/api/setup/env",
+		strings.NewReader(`{"storageDir":"/x","ffmpegBin":"/ffmpeg"}`))
+	r.Header.Set("Content-Type", "text/plain")
+	w = httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = s.serve(http.MethodGet, "/api/setup/env", "")
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestSetupTransition(t *testing.T) {
+	s := newTestSetup(map[string]auth.AccountObfuscated{})
+	admin := `{"username":"admin","plainPassword":"pass"}`
+
+	// The env must be valid before the monitor and the admin.
+	w := s.serve(http.MethodPost, "/api/setup/monitor", testSetupMonitor)
+	require.Equal(t, http.StatusConflict, w.Code)
+	w = s.serve(http.MethodPost, "/api/setup/admin", admin)
+	require.Equal(t, http.StatusConflict, w.Code)
+
+	w = s.serve(http.MethodPost, "/api/setup/env", `{"storageDir":"/x","ffmpegBin":"/y"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "ffmpegBin")
+
+	w = s.serve(http.MethodPost, "/api/setup/env", `{"storageDir":"/x","ffmpegBin":"/ffmpeg"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var status SetupStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.Equal(t, SetupStatus{
+		Env:      SetupEnv{StorageDir: "/x", FFmpegBin: "/ffmpeg"},
+		Monitors: []string{},
+	}, status)
+
+	// Monitors go through the normal validation.
+	w = s.serve(http.MethodPost, "/api/setup/monitor", `{"id":"1","name":"one","enable":"x"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = s.serve(http.MethodPost, "/api/setup/monitor", `{"id":"","name":"one"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = s.serve(http.MethodPost, "/api/setup/monitor", testSetupMonitor)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, []string{"1"}, s.monitors)
+
+	w = s.serve(http.MethodPost, "/api/setup/admin", `{"username":"Admin","plainPassword":"pass"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = s.serve(http.MethodPost, "/api/setup/admin", `{"username":"admin"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.False(t, isClosed(s.Done()))
+
+	require.False(t, s.completed)
+
+	w = s.serve(http.MethodPost, "/api/setup/admin", admin)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.True(t, isClosed(s.Done()))
+	require.False(t, s.Active())
+	require.True(t, s.completed)
+
+	require.Len(t, s.auth.users, 1)
+	for _, user := range s.auth.users {
+		require.Equal(t, "admin", user.Username)
+		require.True(t, user.IsAdmin)
+	}
+
+	actions := make([]string, len(s.entries))
+	for i, e := range s.entries {
+		require.Equal(t, auth.SourceSetup, e.Auth)
+		actions[i] = e.Action
+	}
+	expected := []string{
+		audit.ActionSetupEnv,
+		audit.ActionSetupEnv,
+		audit.ActionMonitorSet,
+		audit.ActionAccountCreate,
+	}
+	require.Equal(t, expected, actions)
+
+	// The setup endpoints are disabled permanently.
+	for _, path := range []string{"/api/setup/env", "/api/setup/monitor", "/api/setup/admin"} {
+		w = s.serve(http.MethodPost, path, admin)
+		require.Equal(t, http.StatusForbidden, w.Code, path)
+	}
+	require.Len(t, s.auth.users, 1)
+
+	s.auth.users = map[string]auth.AccountObfuscated{}
+	require.False(t, s.Active())
+	w = s.serve(http.MethodGet, "/api/setup", "")
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	// The app replaces the setup server.
+	w = s.serve(http.MethodGet, "/live", "")
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestSetupNotRequired(t *testing.T) {
+	s := newTestSetup(map[string]auth.AccountObfuscated{
+		"1": {ID: "1", Username: "user"},
+	})
+	require.False(t, s.Active())
+	require.True(t, isClosed(s.Done()))
+
+	w := s.serve(http.MethodPost, "/api/setup/admin", `{"username":"admin","plainPassword":"pass"}`)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Len(t, s.auth.users, 1)
+
+	// Existing accounts predate the marker.
+	require.True(t, s.completed)
+}
+
+func TestSetupCompletedBefore(t *testing.T) {
+	// Every account was deleted after the setup.
+	s := newTestSetupCompleted(map[string]auth.AccountObfuscated{}, true)
+	require.False(t, s.Active())
+	require.True(t, isClosed(s.Done()))
+
+	w := s.serve(http.MethodPost, "/api/setup/admin", `{"username":"admin","plainPassword":"pass"}`)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Empty(t, s.auth.users)
+}
@@ -45,8 +45,9 @@ type Templater struct {
 	lastModified time.Time
 }
 
-// NewTemplater return template renderer.
-func NewTemplater(a auth.Authenticator, hooks TemplateHooks) (*Templater, error) {
+// NewTemplater return template renderer. The "asset" template
+// function returns the cache busting URL of a static file.
+func NewTemplater(a auth.Authenticator, assets *Assets, hooks TemplateHooks) (*Templater, error) {
 	pageFiles := tpls.PageFiles
 	if err := hooks.Tpl(pageFiles); err != nil {
 		return nil, err
@@ -57,9 +58,11 @@ func NewTemplater(a auth.Authenticator, hooks TemplateHooks) (*Templater, error)
 		return nil, err
 	}
 
+	funcs := template.FuncMap{"asset": assets.URL}
+
 	templates := make(map[string]*template.Template)
 	for fileName, page := range pageFiles {
-		t := template.New(fileName)
+		t := template.New(fileName).Funcs(funcs)
 		t, err := t.Parse(page)
 		if err != nil {
 			return nil, fmt.Errorf("parse page: %w", err)
@@ -90,43 +93,57 @@ func (templater *Templater) RegisterTemplateDataFuncs(dataFuncs ...TemplateDataF
 // Render executes a template.
 func (templater *Templater) Render(page string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		t, exists := templater.templates[page]
-		if !exists {
-			http.Error(w, "could not find template for page: "+page, http.StatusInternalServerError)
-			return
-		}
+		templater.RenderData(w, r, page, nil)
+	})
+}
 
-		if strings.Contains(page, ".js") {
-			w.Header().Set("content-type", "text/javascript")
-		}
+// RenderData executes a template with additional
+// page data that overrides the registered data.
+func (templater *Templater) RenderData(
+	w http.ResponseWriter,
+	r *http.Request,
+	page string,
+	pageData template.FuncMap,
+) {
+	t, exists := templater.templates[page]
+	if !exists {
+		http.Error(w, "could not find template for page: "+page, http.StatusInternalServerError)
+		return
+	}
 
-		data := make(template.FuncMap)
+	if strings.Contains(page, ".js") {
+		w.Header().Set("content-type", "text/javascript")
+	}
 
-		pageName := strings.TrimSuffix(page, filepath.Ext(page))
-		data["currentPage"] = cases.Title(language.Und).String(pageName)
+	data := make(template.FuncMap)
 
-		auth := templater.auth.ValidateRequest(r)
-		data["user"] = auth.User
+	pageName := strings.TrimSuffix(page, filepath.Ext(page))
+	data["currentPage"] = cases.Title(language.Und).String(pageName)
 
-		if page == "debug.tpl" {
-			tls := r.Header["X-Forwarded-Proto"]
-			if len(tls) != 0 {
-				data["tls"] = tls[0]
-			}
-		}
+	auth := templater.auth.ValidateRequest(r)
+	data["user"] = auth.User
+	data["csrfToken"] = auth.User.Token
 
-		for _, dataFunc := range templater.templateDataFuncs {
-			dataFunc(data, page)
+	if page == "debug.tpl" {
+		if proto, ok := ForwardedProto(r); ok {
+			data["tls"] = proto
 		}
+	}
 
-		var b bytes.Buffer
-		if err := t.Execute(&b, data); err != nil {
-			http.Error(w, "could not execute template "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if _, err := io.WriteString(w, b.String()); err != nil {
-			http.Error(w, "could not write string", http.StatusInternalServerError)
-			return
-		}
-	})
+	for _, dataFunc := range templater.templateDataFuncs {
+		dataFunc(data, page)
+	}
+	for key, value := range pageData {
+		data[key] = value
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		http.Error(w, "could not execute template "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		http.Error(w, "could not write string", http.StatusInternalServerError)
+		return
+	}
 }
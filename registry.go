@@ -0,0 +1,433 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package nvr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"nvr/pkg/metrics"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"nvr/pkg/web"
+	"nvr/pkg/web/api"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Addon is a compiled addon that can be enabled or disabled
+// at runtime. Hooks registered through the addon are skipped
+// while it's disabled. Hooks that are only called on startup
+// require a restart before the new state takes effect.
+type Addon struct {
+	name        string
+	description string
+	hooks       *hookList
+	registry    *addonRegistry
+
+	// The addon has hooks that are only called on startup.
+	restartRequired bool
+}
+
+// RegisterAddon registers a runtime toggleable addon.
+func RegisterAddon(name string, description string) *Addon {
+	return hooks.registerAddon(name, description)
+}
+
+// AddonState is the state of a single addon.
+type AddonState struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+
+	// Pending is true if the addon must be restarted
+	// before the enabled state takes effect.
+	Pending bool `json:"pending"`
+}
+
+type addonRegistry struct {
+	addons map[string]*Addon
+
+	mu       sync.Mutex
+	path     string
+	disabled map[string]bool
+
+	// Disabled addons when the app started.
+	startupDisabled map[string]bool
+}
+
+func newAddonRegistry() *addonRegistry {
+	return &addonRegistry{
+		addons:          make(map[string]*Addon),
+		disabled:        make(map[string]bool),
+		startupDisabled: make(map[string]bool),
+	}
+}
+
+func (h *hookList) registerAddon(name string, description string) *Addon {
+	r := h.addons
+	if _, exist := r.addons[name]; exist {
+		panic(fmt.Sprintf("addon already registered: %v", name))
+	}
+	a := &Addon{
+		name:        name,
+		description: description,
+		hooks:       h,
+		registry:    r,
+	}
+	r.addons[name] = a
+	return a
+}
+
+// load reads the enabled states from the file, addons are
+// enabled by default. Must be called before the app starts.
+func (r *addonRegistry) load(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.path = path
+	r.disabled = make(map[string]bool)
+	r.startupDisabled = make(map[string]bool)
+
+	file, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read addon states: %w", err)
+	}
+
+	var enabled map[string]bool
+	if err := json.Unmarshal(file, &enabled); err != nil {
+		return fmt.Errorf("unmarshal addon states: %w", err)
+	}
+	for name, e := range enabled {
+		if !e {
+			r.disabled[name] = true
+			r.startupDisabled[name] = true
+		}
+	}
+	return nil
+}
+
+// ErrAddonNotExist addon does not exist.
+var ErrAddonNotExist = errors.New("addon does not exist")
+
+// set enables or disables the addon and saves the states.
+func (r *addonRegistry) set(name string, enabled bool) (AddonState, error) {
+	a, exist := r.addons[name]
+	if !exist {
+		return AddonState{}, fmt.Errorf("%w: %v", ErrAddonNotExist, name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prev := r.disabled[name]
+	r.disabled[name] = !enabled
+	if err := r.unsafeSave(); err != nil {
+		r.disabled[name] = prev
+		return AddonState{}, err
+	}
+	return r.unsafeState(a), nil
+}
+
+func (r *addonRegistry) unsafeSave() error {
+	states := make(map[string]bool, len(r.addons))
+	for name := range r.addons {
+		states[name] = !r.disabled[name]
+	}
+	data, err := json.MarshalIndent(states, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(r.path, data, 0o600); err != nil {
+		return fmt.Errorf("write addon states: %w", err)
+	}
+	return nil
+}
+
+// states returns the state of all addons sorted by name.
+func (r *addonRegistry) states() []AddonState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	states := make([]AddonState, 0, len(r.addons))
+	for _, a := range r.addons {
+		states = append(states, r.unsafeState(a))
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states
+}
+
+func (r *addonRegistry) unsafeState(a *Addon) AddonState {
+	enabled := !r.disabled[a.name]
+	return AddonState{
+		Name:        a.name,
+		Description: a.description,
+		Enabled:     enabled,
+		Pending:     a.restartRequired && enabled == r.startupDisabled[a.name],
+	}
+}
+
+// activeAtStartup returns true if the addon was enabled when
+// the app started. Used by hooks tied to the templates.
+func (a *Addon) activeAtStartup() bool {
+	a.registry.mu.Lock()
+	defer a.registry.mu.Unlock()
+	return !a.registry.startupDisabled[a.name]
+}
+
+// active returns true if the addon's hooks should be called.
+// Addons with startup hooks can't be enabled without a restart.
+func (a *Addon) active() bool {
+	a.registry.mu.Lock()
+	defer a.registry.mu.Unlock()
+	if a.restartRequired && a.registry.startupDisabled[a.name] {
+		return false
+	}
+	return !a.registry.disabled[a.name]
+}
+
+func (r *addonRegistry) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			api.WriteJSON(w, req, r.states())
+
+		case http.MethodPut:
+			var body struct {
+				Name    string `json:"name"`
+				Enabled bool   `json:"enabled"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				api.BadRequest(w, "could not decode request body")
+				return
+			}
+			state, err := r.set(body.Name, body.Enabled)
+			if errors.Is(err, ErrAddonNotExist) {
+				api.NotFound(w, "addon does not exist")
+				return
+			}
+			if err != nil {
+				api.InternalError(w, req, "could not set addon state", err)
+				return
+			}
+			api.WriteJSON(w, req, state)
+
+		default:
+			api.MethodNotAllowed(w)
+		}
+	})
+}
+
+// RegisterAppRunHook registers hook that's called when app runs.
+func (a *Addon) RegisterAppRunHook(h appRunHook) {
+	a.restartRequired = true
+	hook := func(ctx context.Context, app *App) error {
+		if !a.active() {
+			return nil
+		}
+		return h(ctx, app)
+	}
+	a.hooks.onAppRun = append(a.hooks.onAppRun, hook)
+}
+
+// RegisterTplHook registers function used to modify templates.
+func (a *Addon) RegisterTplHook(h web.TemplateHook) {
+	a.restartRequired = true
+	hook := func(pageFiles map[string]string) error {
+		if !a.active() {
+			return nil
+		}
+		return h(pageFiles)
+	}
+	a.hooks.template = append(a.hooks.template, hook)
+}
+
+// RegisterTplSubHook registers function used to modify sub templates.
+func (a *Addon) RegisterTplSubHook(h web.TemplateHook) {
+	a.restartRequired = true
+	hook := func(pageFiles map[string]string) error {
+		if !a.active() {
+			return nil
+		}
+		return h(pageFiles)
+	}
+	a.hooks.templateSub = append(a.hooks.templateSub, hook)
+}
+
+// RegisterTplDataHook registers function thats called
+// on page render. Used to modify template data. The
+// hook follows the templates and ignores runtime toggles.
+func (a *Addon) RegisterTplDataHook(h web.TemplateDataFunc) {
+	hook := func(data template.FuncMap, page string) {
+		if a.activeAtStartup() {
+			h(data, page)
+		}
+	}
+	a.hooks.templateData = append(a.hooks.templateData, hook)
+}
+
+// RegisterStaticFS registers a file system that's served at
+// the prefix, "/static/doods/". The file system follows the
+// templates and is only registered if the addon was enabled
+// when the app started.
+func (a *Addon) RegisterStaticFS(prefix string, fsys fs.FS) {
+	a.restartRequired = true
+	hook := func(assets *web.Assets) error {
+		if !a.activeAtStartup() {
+			return nil
+		}
+		return assets.Register(prefix, fsys)
+	}
+	a.hooks.staticFS = append(a.hooks.staticFS, hook)
+}
+
+// RegisterMetrics registers a hook that registers the custom metrics
+// of the addon. The metrics are only registered if the addon was
+// enabled when the app started.
+func (a *Addon) RegisterMetrics(h metricsHook) {
+	a.restartRequired = true
+	hook := func(registry *metrics.Registry) error {
+		if !a.activeAtStartup() {
+			return nil
+		}
+		return h(registry)
+	}
+	a.hooks.metrics = append(a.hooks.metrics, hook)
+}
+
+// RegisterRecordingDetailHook registers hook that's called
+// when the detail of a recording is requested.
+func (a *Addon) RegisterRecordingDetailHook(h web.RecordingDetailHook) {
+	hook := func(detail *storage.RecordingDetail) {
+		if a.active() {
+			h(detail)
+		}
+	}
+	a.hooks.recordingDetail = append(a.hooks.recordingDetail, hook)
+}
+
+// RegisterHTTPMiddleware registers a middleware that wraps the router.
+// Requests skip the middleware while the addon is disabled.
+func (a *Addon) RegisterHTTPMiddleware(m func(http.Handler) http.Handler, priority int) {
+	wrap := func(next http.Handler) http.Handler {
+		wrapped := m(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if a.active() {
+				wrapped.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	a.hooks.httpMiddleware = append(a.hooks.httpMiddleware, httpMiddleware{
+		wrap:     wrap,
+		priority: priority,
+	})
+}
+
+// RegisterMonitorStartHook registers hook that's called when the monitor starts.
+func (a *Addon) RegisterMonitorStartHook(h monitor.StartHook) {
+	hook := func(ctx context.Context, m *monitor.Monitor) {
+		if a.active() {
+			h(ctx, m)
+		}
+	}
+	a.hooks.monitorStart = append(a.hooks.monitorStart, hook)
+}
+
+// RegisterMonitorStopHook registers hook that's called after the
+// monitor have stopped. The hook is called even if the addon was
+// disabled while the monitor was running, so it can clean up.
+func (a *Addon) RegisterMonitorStopHook(h monitor.StopHook) {
+	a.hooks.monitorStop = append(a.hooks.monitorStop, h)
+}
+
+// RegisterMonitorInputProcessHook registers hook that's
+// called when the monitor input process starts.
+func (a *Addon) RegisterMonitorInputProcessHook(h monitor.StartInputHook) {
+	hook := func(ctx context.Context, i *monitor.InputProcess, args *[]string) {
+		if a.active() {
+			h(ctx, i, args)
+		}
+	}
+	a.hooks.monitorInputProcess = append(a.hooks.monitorInputProcess, hook)
+}
+
+// RegisterMonitorEventHook registers hook that's called on every event.
+func (a *Addon) RegisterMonitorEventHook(h monitor.EventHook) {
+	hook := func(r *monitor.Recorder, event *storage.Event) {
+		if a.active() {
+			h(r, event)
+		}
+	}
+	a.hooks.monitorEvent = append(a.hooks.monitorEvent, hook)
+}
+
+// RegisterMonitorRecSaveHook registers hook that's called when monitor saves recording.
+func (a *Addon) RegisterMonitorRecSaveHook(h monitor.RecSaveHook) {
+	hook := func(r *monitor.Recorder, args *string) {
+		if a.active() {
+			h(r, args)
+		}
+	}
+	a.hooks.monitorRecSave = append(a.hooks.monitorRecSave, hook)
+}
+
+// RegisterMonitorRecSavedHook registers hook that's called after monitor have saved recording.
+func (a *Addon) RegisterMonitorRecSavedHook(h monitor.RecSavedHook) {
+	hook := func(r *monitor.Recorder, recPath string, recData storage.RecordingData) {
+		if a.active() {
+			h(r, recPath, recData)
+		}
+	}
+	a.hooks.monitorRecSaved = append(a.hooks.monitorRecSaved, hook)
+}
+
+// RegisterMigrationMonitorHook is called when each monitor config is loaded.
+func (a *Addon) RegisterMigrationMonitorHook(h monitor.MigationHook) {
+	a.restartRequired = true
+	hook := func(conf monitor.RawConfig) (monitor.MigrationVersions, error) {
+		if !a.active() {
+			return monitor.MigrationVersions{}, nil
+		}
+		return h(conf)
+	}
+	a.hooks.migrationMonitor = append(a.hooks.migrationMonitor, monitor.Migration{
+		Name: a.name,
+		Hook: hook,
+	})
+}
+
+// RegisterMonitorConfigKeys registers the monitor config keys used
+// by the addon. The keys are unknown while the addon is disabled.
+func (a *Addon) RegisterMonitorConfigKeys(keys ...string) {
+	hook := func() []string {
+		if !a.active() {
+			return nil
+		}
+		return keys
+	}
+	a.hooks.monitorConfigKeys = append(a.hooks.monitorConfigKeys, hook)
+}
+
+// RegisterMonitorValidateHook registers a hook that validates
+// each monitor config. The hook is skipped while disabled.
+func (a *Addon) RegisterMonitorValidateHook(h monitor.ValidateHook) {
+	hook := func(conf monitor.RawConfig) error {
+		if !a.active() {
+			return nil
+		}
+		return h(conf)
+	}
+	a.hooks.monitorValidate = append(a.hooks.monitorValidate, hook)
+}
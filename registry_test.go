@@ -0,0 +1,346 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package nvr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"nvr/pkg/video"
+	"nvr/pkg/web"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAddon struct {
+	addon    *Addon
+	tplCalls int
+	recCalls int
+}
+
+func newFakeAddon(h *hookList, name string, withTpl bool) *fakeAddon {
+	f := &fakeAddon{addon: h.registerAddon(name, "fake addon")}
+	if withTpl {
+		f.addon.RegisterTplHook(func(map[string]string) error {
+			f.tplCalls++
+			return nil
+		})
+	}
+	f.addon.RegisterMonitorRecSavedHook(
+		func(*monitor.Recorder, string, storage.RecordingData) {
+			f.recCalls++
+		})
+	return f
+}
+
+func newTestHooks(t *testing.T, states string) (*hookList, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "addons.json")
+	if states != "" {
+		require.NoError(t, os.WriteFile(path, []byte(states), 0o600))
+	}
+	return &hookList{addons: newAddonRegistry()}, path
+}
+
+func TestAddonToggle(t *testing.T) {
+	t.Run("runtimeHooks", func(t *testing.T) {
+		h, path := newTestHooks(t, "")
+		f := newFakeAddon(h, "fake", false)
+		require.NoError(t, h.addons.load(path))
+
+		recSaved := h.monitor().RecSaved
+		recSaved(nil, "", storage.RecordingData{})
+		require.Equal(t, 1, f.recCalls)
+
+		state, err := h.addons.set("fake", false)
+		require.NoError(t, err)
+		require.Equal(t, AddonState{Name: "fake", Description: "fake addon"}, state)
+
+		recSaved(nil, "", storage.RecordingData{})
+		require.Equal(t, 1, f.recCalls)
+
+		_, err = h.addons.set("fake", true)
+		require.NoError(t, err)
+		recSaved(nil, "", storage.RecordingData{})
+		require.Equal(t, 2, f.recCalls)
+
+		file, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"fake": true}`, string(file))
+	})
+	t.Run("disableRequiresRestart", func(t *testing.T) {
+		h, path := newTestHooks(t, "")
+		f := newFakeAddon(h, "fake", true)
+		require.NoError(t, h.addons.load(path))
+
+		state, err := h.addons.set("fake", false)
+		require.NoError(t, err)
+		require.True(t, state.Pending)
+
+		// Runtime hooks stop immediately.
+		h.monitor().RecSaved(nil, "", storage.RecordingData{})
+		require.Equal(t, 0, f.recCalls)
+
+		// Restart.
+		require.NoError(t, h.addons.load(path))
+		require.NoError(t, h.tplHooks().Tpl(map[string]string{}))
+		require.Equal(t, 0, f.tplCalls)
+		require.False(t, h.addons.states()[0].Pending)
+	})
+	t.Run("enableRequiresRestart", func(t *testing.T) {
+		h, path := newTestHooks(t, `{"fake": false}`)
+		f := newFakeAddon(h, "fake", true)
+		require.NoError(t, h.addons.load(path))
+
+		require.NoError(t, h.tplHooks().Tpl(map[string]string{}))
+		require.Equal(t, 0, f.tplCalls)
+
+		state, err := h.addons.set("fake", true)
+		require.NoError(t, err)
+		require.True(t, state.Pending)
+
+		h.monitor().RecSaved(nil, "", storage.RecordingData{})
+		require.Equal(t, 0, f.recCalls)
+
+		// Restart.
+		require.NoError(t, h.addons.load(path))
+		require.NoError(t, h.tplHooks().Tpl(map[string]string{}))
+		h.monitor().RecSaved(nil, "", storage.RecordingData{})
+		require.Equal(t, 1, f.tplCalls)
+		require.Equal(t, 1, f.recCalls)
+	})
+	t.Run("notExist", func(t *testing.T) {
+		h, path := newTestHooks(t, "")
+		require.NoError(t, h.addons.load(path))
+		_, err := h.addons.set("nil", false)
+		require.ErrorIs(t, err, ErrAddonNotExist)
+	})
+}
+
+func TestAddonMonitorConfigKeys(t *testing.T) {
+	h, path := newTestHooks(t, "")
+	a := h.registerAddon("fake", "fake addon")
+	a.RegisterMonitorConfigKeys("a", "b")
+	require.NoError(t, h.addons.load(path))
+
+	configKeys := h.monitor().ConfigKeys
+	require.Equal(t, []string{"a", "b"}, configKeys())
+
+	_, err := h.addons.set("fake", false)
+	require.NoError(t, err)
+	require.Empty(t, configKeys())
+}
+
+func TestAddonMonitorValidateHook(t *testing.T) {
+	h, path := newTestHooks(t, "")
+	a := h.registerAddon("fake", "fake addon")
+	a.RegisterMonitorValidateHook(func(monitor.RawConfig) error {
+		return errStub
+	})
+	require.NoError(t, h.addons.load(path))
+
+	rawConf := monitor.RawConfig{"id": "1", "name": "a"}
+	require.ErrorIs(t, h.validateMonitor(rawConf), errStub)
+
+	_, err := h.addons.set("fake", false)
+	require.NoError(t, err)
+	require.NoError(t, h.validateMonitor(rawConf))
+}
+
+func TestAddonStaticFS(t *testing.T) {
+	fsys := fstest.MapFS{"a.mjs": {Data: []byte("a")}}
+
+	h, path := newTestHooks(t, "")
+	h.registerAddon("a", "fake addon").RegisterStaticFS("/static/a/", fsys)
+	h.registerAddon("b", "fake addon").RegisterStaticFS("/static/b/", fsys)
+	require.NoError(t, h.addons.load(path))
+	_, err := h.addons.set("b", false)
+	require.NoError(t, err)
+	require.NoError(t, h.addons.load(path))
+
+	assets, err := h.assets()
+	require.NoError(t, err)
+
+	_, err = assets.URL("a/a.mjs")
+	require.NoError(t, err)
+	_, err = assets.URL("b/a.mjs")
+	require.ErrorIs(t, err, web.ErrAssetNotExist)
+
+	// Core files.
+	_, err = assets.URL("scripts/libs/common.mjs")
+	require.NoError(t, err)
+}
+
+func TestAddonHandler(t *testing.T) {
+	h, path := newTestHooks(t, "")
+	newFakeAddon(h, "b", true)
+	newFakeAddon(h, "a", false)
+	require.NoError(t, h.addons.load(path))
+	handler := h.addons.handler()
+
+	serve := func(method string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/api/system/addons", strings.NewReader(body))
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(http.MethodPut, `{"name": "b", "enabled": false}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(http.MethodGet, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var states []AddonState
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&states))
+	expected := []AddonState{
+		{Name: "a", Description: "fake addon", Enabled: true},
+		{Name: "b", Description: "fake addon", Enabled: false, Pending: true},
+	}
+	require.Equal(t, expected, states)
+
+	w = serve(http.MethodPut, `{"name": "nil"}`)
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(http.MethodPut, `nil`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(http.MethodPost, "")
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestAddonMonitorLifecycle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wg := &sync.WaitGroup{}
+	logger := log.NewLogger(wg, nil)
+	require.NoError(t, logger.Start(ctx))
+	videoServer := video.NewServer(logger, wg, storage.ConfigEnv{})
+
+	h, path := newTestHooks(t, "")
+	a := h.registerAddon("fake", "fake addon")
+
+	var mu sync.Mutex
+	var calls []string
+	record := func(call string) {
+		mu.Lock()
+		calls = append(calls, call)
+		mu.Unlock()
+	}
+	contexts := make(chan context.Context, 3)
+	a.RegisterMonitorStartHook(func(ctx context.Context, m *monitor.Monitor) {
+		record("start")
+		contexts <- ctx
+		m.WG.Add(1)
+		go func() {
+			defer m.WG.Done()
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			record("exit")
+		}()
+	})
+	a.RegisterMonitorStopHook(func(*monitor.Monitor) {
+		time.Sleep(10 * time.Millisecond)
+		record("stop")
+	})
+	require.NoError(t, h.addons.load(path))
+
+	configDir := t.TempDir()
+	rawConf := monitor.RawConfig{"id": "1", "name": "a", "enable": "true", "mainStream": "x"}
+	data, err := json.Marshal(rawConf)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "1.json"), data, 0o600))
+
+	manager, err := monitor.NewManager(
+		configDir,
+		storage.ConfigEnv{},
+		func() string { return "" },
+		logger,
+		videoServer,
+		h.monitor(),
+	)
+	require.NoError(t, err)
+	manager.StartMonitors()
+	first := <-contexts
+
+	// Rapid restart, the config must change to prevent a hot reload.
+	for _, name := range []string{"b", "c"} {
+		rawConf := monitor.RawConfig{"id": "1", "name": name, "enable": "true", "mainStream": "x"}
+		require.NoError(t, manager.MonitorSet("1", rawConf))
+		require.NoError(t, manager.RestartMonitor("1"))
+	}
+	second := <-contexts
+	third := <-contexts
+	require.ErrorIs(t, first.Err(), context.Canceled)
+	require.ErrorIs(t, second.Err(), context.Canceled)
+	require.NoError(t, third.Err())
+
+	require.NoError(t, manager.MonitorDelete("1"))
+	require.ErrorIs(t, third.Err(), context.Canceled)
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{
+		"start", "exit", "stop",
+		"start", "exit", "stop",
+		"start", "exit", "stop",
+	}
+	require.Equal(t, expected, calls)
+}
+
+func TestAddonHTTPMiddleware(t *testing.T) {
+	h, path := newTestHooks(t, "")
+	a := h.registerAddon("fake", "fake addon")
+	a.RegisterHTTPMiddleware(newTestMiddleware("fake"), 0)
+	require.NoError(t, h.addons.load(path))
+
+	router := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("X-Order", "router")
+	})
+	handler := h.httpHandler(log.NewDummyLogger(), router)
+	serve := func() []string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Header().Values("X-Order")
+	}
+	require.Equal(t, []string{"fake", "router", "/fake"}, serve())
+
+	// Disabled without a restart.
+	state, err := h.addons.set("fake", false)
+	require.NoError(t, err)
+	require.False(t, state.Pending)
+	require.Equal(t, []string{"router"}, serve())
+}
+
+func TestAddonRecordingDetailHook(t *testing.T) {
+	h, path := newTestHooks(t, "")
+	a := h.registerAddon("fake", "fake addon")
+	a.RegisterRecordingDetailHook(func(detail *storage.RecordingDetail) {
+		detail.Addons["fake"] = true
+	})
+	require.NoError(t, h.addons.load(path))
+
+	callHooks := func() map[string]interface{} {
+		detail := &storage.RecordingDetail{Addons: make(map[string]interface{})}
+		for _, hook := range h.recordingDetail {
+			hook(detail)
+		}
+		return detail.Addons
+	}
+	require.Equal(t, map[string]interface{}{"fake": true}, callHooks())
+
+	_, err := h.addons.set("fake", false)
+	require.NoError(t, err)
+	require.Empty(t, callHooks())
+}
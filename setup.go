@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package nvr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"nvr/pkg/audit"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"nvr/pkg/web"
+	"nvr/pkg/web/api"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// setupCompletedFile is created in the config directory when the setup
+// is completed, the setup isn't served again if every account is deleted.
+const setupCompletedFile = "setup_completed"
+
+// errSetupStopped the process received a signal during the setup.
+var errSetupStopped = errors.New("setup stopped")
+
+// runSetup serves the first-run setup on the app port until the first
+// admin is created, it returns immediately if any accounts exist or if
+// the setup was completed before. The state of each step is read from
+// the disk so the setup resumes from the first missing step if the
+// process is restarted.
+func runSetup(envPath string, h *hookList, stop <-chan os.Signal) error { //nolint:funlen
+	envYAML, err := os.ReadFile(envPath)
+	if err != nil {
+		return fmt.Errorf("could not read env.yaml: %w", err)
+	}
+
+	// The paths aren't checked, they may be set by the setup.
+	env, err := storage.ParseConfigEnv(envPath, envYAML)
+	if err != nil {
+		return fmt.Errorf("could not get environment config: %w", err)
+	}
+
+	err = h.addons.load(filepath.Join(env.ConfigDir, "addons.json"))
+	if err != nil {
+		return fmt.Errorf("could not load addon states: %w", err)
+	}
+
+	if h.newAuthenticator == nil {
+		// Reported by newApp.
+		return nil
+	}
+
+	wg := &sync.WaitGroup{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer wg.Wait()
+	defer cancel()
+
+	logger := log.NewLogger(wg, h.logSource)
+	if err := logger.Start(ctx); err != nil {
+		return fmt.Errorf("could not start logger: %w", err)
+	}
+	logFormat, _ := log.ParseFormat(string(env.LogFormat))
+	logger.LogToWriter(ctx, os.Stdout, logFormat)
+	time.Sleep(10 * time.Millisecond)
+
+	auditLog, err := audit.NewLog(filepath.Join(env.ConfigDir, "audit"))
+	if err != nil {
+		return fmt.Errorf("could not create audit log: %w", err)
+	}
+	defer auditLog.Close()
+
+	a, err := h.newAuthenticator(*env, logger, auditLog.Write)
+	if err != nil {
+		return fmt.Errorf("could not create authenticator: %w", err)
+	}
+
+	monitorManager, err := monitor.NewManager(
+		filepath.Join(env.ConfigDir, "monitors"),
+		*env,
+		nil,
+		logger,
+		nil,
+		h.monitor(),
+	)
+	if err != nil {
+		return fmt.Errorf("could not create monitor manager: %w", err)
+	}
+
+	markerPath := filepath.Join(env.ConfigDir, setupCompletedFile)
+	setup := web.NewSetup(a, web.SetupFuncs{
+		Env: func() (web.SetupEnv, error) {
+			return readSetupEnv(envPath)
+		},
+		SetEnv: func(setupEnv web.SetupEnv) error {
+			return writeSetupEnv(envPath, setupEnv)
+		},
+		Monitors: func() []string {
+			return sortedKeys(monitorManager.MonitorsInfo())
+		},
+		ValidateMonitor: h.validateMonitor,
+		SetMonitor: func(c monitor.RawConfig) error {
+			return monitorManager.MonitorSet(c["id"], c)
+		},
+		Completed: func() bool {
+			_, err := os.Stat(markerPath)
+			return err == nil
+		},
+		SetCompleted: func() error {
+			return os.WriteFile(markerPath, nil, 0o600)
+		},
+	}, auditLog.Write)
+	if !setup.Active() {
+		return nil
+	}
+
+	assets, err := h.assets()
+	if err != nil {
+		return err
+	}
+	t, err := web.NewTemplater(a, assets, h.tplHooks())
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:    ":" + strconv.Itoa(env.Port),
+		Handler: api.Middleware(logger, setup.Handler(t.Render("setup.tpl"), assets.Handler())),
+	}
+	fatal := make(chan error, 1)
+	go func() { fatal <- server.ListenAndServe() }()
+
+	logger.Log(log.Entry{
+		Level: log.LevelInfo,
+		Src:   "app",
+		Msg:   fmt.Sprintf("No accounts, serving first-run setup on port %v", env.Port),
+	})
+
+	select {
+	case <-setup.Done():
+	case err := <-fatal:
+		return fmt.Errorf("setup server: %w", err)
+	case <-stop:
+		err = errSetupStopped
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel2()
+	if err2 := server.Shutdown(ctx2); err2 != nil && err == nil {
+		return fmt.Errorf("stop setup server: %w", err2)
+	}
+	return err
+}
+
+// readSetupEnv returns the configured paths and the
+// error from validating the environment config.
+func readSetupEnv(envPath string) (web.SetupEnv, error) {
+	envYAML, err := os.ReadFile(envPath)
+	if err != nil {
+		return web.SetupEnv{}, err
+	}
+	env, err := storage.ParseConfigEnv(envPath, envYAML)
+	if err != nil {
+		return web.SetupEnv{}, err
+	}
+	setupEnv := web.SetupEnv{
+		StorageDir: env.StorageDir,
+		FFmpegBin:  env.FFmpegBin,
+	}
+	_, err = storage.NewConfigEnv(envPath, envYAML)
+	return setupEnv, err
+}
+
+// writeSetupEnv validates the environment config with the new
+// paths and replaces the env.yaml if the config is valid.
+func writeSetupEnv(envPath string, setupEnv web.SetupEnv) error {
+	envYAML, err := os.ReadFile(envPath)
+	if err != nil {
+		return err
+	}
+	newYAML, err := storage.SetConfigEnvPaths(envYAML, setupEnv.StorageDir, setupEnv.FFmpegBin)
+	if err != nil {
+		return err
+	}
+	if _, err := storage.NewConfigEnv(envPath, newYAML); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash
+	// can't leave behind a partially written file.
+	tmpPath := envPath + ".tmp"
+	if err := os.WriteFile(tmpPath, newYAML, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, envPath)
+}
@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+package nvr
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"nvr/pkg/audit"
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+	"nvr/pkg/web"
+	"nvr/pkg/web/auth"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fileAuth stores the accounts in a file, like the basic auth addon.
+type fileAuth struct {
+	auth.Authenticator
+	path string
+}
+
+func (a *fileAuth) AuthDisabled() bool { return false }
+
+func (a *fileAuth) ValidateRequest(*http.Request) auth.ValidateResponse {
+	return auth.ValidateResponse{}
+}
+
+func (a *fileAuth) UsersList() map[string]auth.AccountObfuscated {
+	users := make(map[string]auth.AccountObfuscated)
+	file, err := os.ReadFile(a.path)
+	if err == nil {
+		json.Unmarshal(file, &users) //nolint:errcheck
+	}
+	return users
+}
+
+func (a *fileAuth) UserSet(req auth.SetUserRequest) error {
+	users := a.UsersList()
+	users[req.ID] = auth.AccountObfuscated{
+		ID:       req.ID,
+		Username: req.Username,
+		IsAdmin:  req.IsAdmin,
+	}
+	file, err := json.Marshal(users)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.path, file, 0o600)
+}
+
+func newSetupTestHooks() *hookList {
+	h := newCheckTestHooks()
+	h.newAuthenticator = func(env storage.ConfigEnv, _ *log.Logger, _ audit.Func) (auth.Authenticator, error) {
+		return &fileAuth{path: filepath.Join(env.ConfigDir, "users.json")}, nil
+	}
+	return h
+}
+
+type setupTestRun struct {
+	stop chan os.Signal
+	err  chan error
+}
+
+func startSetup(t *testing.T, envPath string) *setupTestRun {
+	t.Helper()
+	run := &setupTestRun{
+		stop: make(chan os.Signal, 1),
+		err:  make(chan error, 1),
+	}
+	go func() { run.err <- runSetup(envPath, newSetupTestHooks(), run.stop) }()
+	return run
+}
+
+func (run *setupTestRun) wait(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-run.err:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+		return nil
+	}
+}
+
+// setupRequest waits for the setup server and sends a request.
+func setupRequest(t *testing.T, port int, method string, path string, body string) *http.Response {
+	t.Helper()
+	url := "http://127.0.0.1:" + strconv.Itoa(port) + path
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for i := 0; ; i++ {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		res, err := client.Do(req)
+		if err == nil {
+			t.Cleanup(func() { res.Body.Close() })
+			return res
+		}
+		require.Less(t, i, 100, err)
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRunSetup(t *testing.T) {
+	t.Run("resume", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		require.NoError(t, os.RemoveAll(filepath.Join(e.configDir, "monitors")))
+		validFFmpeg := e.ffmpegBin
+		e.ffmpegBin = filepath.Join(e.homeDir, "missing")
+		e.writeEnv(t, "# comment\n")
+		port := e.ports[0]
+
+		run := startSetup(t, e.envPath)
+
+		res := setupRequest(t, port, http.MethodGet, "/live", "")
+		require.Equal(t, http.StatusSeeOther, res.StatusCode)
+		require.Equal(t, "/setup", res.Header.Get("Location"))
+
+		res = setupRequest(t, port, http.MethodGet, "/setup", "")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		storageDir := filepath.Join(e.homeDir, "recordings")
+		env := `{"storageDir":"` + storageDir + `","ffmpegBin":"` + validFFmpeg + `"}`
+		res = setupRequest(t, port, http.MethodPost, "/api/setup/env", env)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		res = setupRequest(t, port, http.MethodPost, "/api/setup/monitor", `{
+			"id": "1",
+			"name": "one",
+			"enable": "true",
+			"mainStream": "rtsp://x",
+			"videoLength": "15",
+			"timestampOffset": "500"
+		}`)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		// Stopped before the admin was created.
+		run.stop <- os.Interrupt
+		require.ErrorIs(t, run.wait(t), errSetupStopped)
+
+		run = startSetup(t, e.envPath)
+
+		res = setupRequest(t, port, http.MethodGet, "/api/setup", "")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var status web.SetupStatus
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&status))
+		expected := web.SetupStatus{
+			Env:      web.SetupEnv{StorageDir: storageDir, FFmpegBin: validFFmpeg},
+			Monitors: []string{"1"},
+		}
+		require.Equal(t, expected, status)
+
+		res = setupRequest(t, port, http.MethodPost, "/api/setup/admin",
+			`{"username":"admin","plainPassword":"pass"}`)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.NoError(t, run.wait(t))
+
+		// The env.yaml is valid and the other keys are kept.
+		envYAML, err := os.ReadFile(e.envPath)
+		require.NoError(t, err)
+		require.Contains(t, string(envYAML), "# comment")
+		_, err = storage.NewConfigEnv(e.envPath, envYAML)
+		require.NoError(t, err)
+
+		// The setup isn't served again.
+		require.NoError(t, startSetup(t, e.envPath).wait(t))
+		require.FileExists(t, filepath.Join(e.configDir, setupCompletedFile))
+	})
+	t.Run("completedBefore", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		writeTestFile(t, filepath.Join(e.configDir, setupCompletedFile), "", 0o600)
+		require.NoError(t, startSetup(t, e.envPath).wait(t))
+	})
+	t.Run("accountsExist", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		writeTestFile(t, filepath.Join(e.configDir, "users.json"), `{"1":{"id":"1"}}`, 0o600)
+		require.NoError(t, startSetup(t, e.envPath).wait(t))
+	})
+	t.Run("noAuthenticator", func(t *testing.T) {
+		e := newCheckTestEnv(t)
+		require.NoError(t, runSetup(e.envPath, &hookList{addons: newAddonRegistry()}, nil))
+	})
+}
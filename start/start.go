@@ -24,8 +24,8 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-// ErrPathNotAbsolute path is not absolute.
-var ErrPathNotAbsolute = errors.New("path is not absolute")
+// ErrUndefinedEnvVar undefined environment variable.
+var ErrUndefinedEnvVar = errors.New("undefined environment variable")
 
 func main() {
 	err := start()
@@ -42,6 +42,12 @@ type configEnv struct {
 
 func start() error {
 	envFlag := flag.String("env", "", "path to env.yaml")
+	migrateDryRunFlag := flag.Bool("migrate-dry-run", false,
+		"print the monitor config migrations without applying them")
+	migrateRestoreFlag := flag.String("migrate-restore", "",
+		"restore the most recent migration backup of a monitor by ID")
+	checkFlag := flag.Bool("check", false,
+		"check the config and the environment, print a report and exit")
 	flag.Parse()
 
 	if *envFlag == "" {
@@ -84,12 +90,25 @@ func start() error {
 		return err
 	}
 
-	return startMain(*env, main, envPath)
+	// Migration and check flags are passed through to the app.
+	var extraArgs []string
+	if *checkFlag {
+		extraArgs = append(extraArgs, "-check")
+	}
+	if *migrateDryRunFlag {
+		extraArgs = append(extraArgs, "-migrate-dry-run")
+	}
+	if *migrateRestoreFlag != "" {
+		extraArgs = append(extraArgs, "-migrate-restore", *migrateRestoreFlag)
+	}
+
+	return startMain(*env, main, envPath, extraArgs)
 }
 
-func startMain(env configEnv, main string, envPath string) error {
+func startMain(env configEnv, main string, envPath string, extraArgs []string) error {
 	// go run ./start/build/nvr.go -env ./config/env.yaml
-	cmd := exec.Command(env.GoBin, "run", main, "-env", envPath)
+	args := append([]string{"run", main, "-env", envPath}, extraArgs...)
+	cmd := exec.Command(env.GoBin, args...)
 	cmd.Dir = env.HomeDir
 
 	// Give parent file descriptors and environment to child process.
@@ -122,11 +141,16 @@ func parseEnv(envPath string, envYAML []byte) (*configEnv, error) {
 		return nil, fmt.Errorf("could not unmarshal env.yaml: %w", err)
 	}
 
+	configDir := filepath.Dir(envPath)
 	if env.GoBin == "" {
 		env.GoBin = "/usr/bin/go"
+	} else if env.GoBin, err = resolvePath(env.GoBin, configDir); err != nil {
+		return nil, fmt.Errorf("goBin: %w", err)
 	}
 	if env.HomeDir == "" {
-		env.HomeDir = filepath.Dir(filepath.Dir(envPath))
+		env.HomeDir = filepath.Dir(configDir)
+	} else if env.HomeDir, err = resolvePath(env.HomeDir, configDir); err != nil {
+		return nil, fmt.Errorf("homeDir: %w", err)
 	}
 
 	if !dirExist(env.GoBin) {
@@ -136,14 +160,36 @@ func parseEnv(envPath string, envYAML []byte) (*configEnv, error) {
 		return nil, fmt.Errorf("homeDir '%v': %w", env.HomeDir, os.ErrNotExist)
 	}
 
-	if !filepath.IsAbs(env.GoBin) {
-		return nil, fmt.Errorf("goBin '%v': %w", env.GoBin, ErrPathNotAbsolute)
+	return &env, nil
+}
+
+// resolvePath expands environment variables and a leading "~" and
+// resolves relative paths against the base directory. Same as storage.
+func resolvePath(path string, baseDir string) (string, error) {
+	var undefined []string
+	path = os.Expand(path, func(name string) string {
+		value, exist := os.LookupEnv(name)
+		if !exist {
+			undefined = append(undefined, name)
+		}
+		return value
+	})
+	if len(undefined) != 0 {
+		return "", fmt.Errorf("%w: %v", ErrUndefinedEnvVar, strings.Join(undefined, ", "))
 	}
-	if !filepath.IsAbs(env.HomeDir) {
-		return nil, fmt.Errorf("homeDir '%v': %w", env.HomeDir, ErrPathNotAbsolute)
+
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, path[1:])
 	}
 
-	return &env, nil
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	return filepath.Clean(path), nil
 }
 
 // genBuildFile inserts addons into "main.go" template and writes to file.
@@ -277,12 +323,38 @@ port: 2020
 # The RTSP and HLS ports use the loopback interface(127.0.0.1)
 # by default. You can expose them to LAN if you need
 # to access them remotely or from another container.
-# But keep in mind that they are completely unprotected.
+# The HLS port is completely unprotected.
 rtspPort: 2021
-rtspPortExpose: False
 hlsPort: 2022
 hlsPortExpose: False
 
+# IP address of the interface the RTSP server listens on, "0.0.0.0"
+# for all interfaces. The app refuses to start if the address isn't
+# a loopback address and "rtspAuth" is disabled, "rtspInsecure"
+# allows it anyway. Replaces "rtspPortExpose".
+rtspBindAddress: 127.0.0.1
+rtspInsecure: False
+
+# Bandwidth limit in kilobits per second of each RTSP reader that
+# doesn't connect from localhost. Keeps a single remote viewer from
+# saturating the uplink. 0 is unlimited.
+rtspReaderRate: 0
+
+# Track HLS viewers of the web interface with short-lived
+# sessions. Viewer counts are available at "/api/video/viewers".
+# "hlsMaxViewers" limits concurrent streams per account, 0 is unlimited.
+hlsSessions: False
+hlsMaxViewers: 0
+
+# Memory budget in megabytes for the in-memory video buffers of
+# all monitors combined. The largest buffers are shrunk when the
+# budget is exceeded. 0 is unlimited.
+videoMemoryBudget: 0
+
+# Require basic auth from non-loopback RTSP readers and
+# publishers. Uses the same accounts as the web interface.
+rtspAuth: False
+
 
 # Path to golang binary.
 goBin: {{ .goBin }}
@@ -315,6 +387,10 @@ addons: # Uncomment to enable.
   # Documentation ../addons/motion/README.md
   #- nvr/addons/motion
 
+  # Audio trigger.
+  # Documentation ../addons/audiotrigger/README.md
+  #- nvr/addons/audiotrigger
+
   # Thumbnail downscaling.
   # Downscale video thumbnails to improve loading times and data usage.
   #- nvr/addons/thumbscale
@@ -330,4 +406,8 @@ addons: # Uncomment to enable.
   # Timeline.
   # Works best with a Chromium based browser.
   #- nvr/addons/timeline
+
+  # Email alerts.
+  # Documentation ../addons/email/README.md
+  #- nvr/addons/email
 `
@@ -88,29 +88,32 @@ func TestParseEnv(t *testing.T) {
 		_, err = parseEnv(envPath, envYAML)
 		require.Error(t, err)
 	})
-	t.Run("goBinAbs", func(t *testing.T) {
+	t.Run("relative", func(t *testing.T) {
 		envPath, testEnv, cancel := newTestEnv(t)
 		defer cancel()
 
-		testEnv.GoBin = "."
-
-		envYAML, err := yaml.Marshal(testEnv)
+		envYAML := []byte("addons: [a]\ngoBin: ../../go\nhomeDir: ..\n")
+		env, err := parseEnv(envPath, envYAML)
 		require.NoError(t, err)
-
-		_, err = parseEnv(envPath, envYAML)
-		require.ErrorIs(t, err, ErrPathNotAbsolute)
+		require.Equal(t, testEnv, env)
 	})
-	t.Run("homeDirAbs", func(t *testing.T) {
+	t.Run("expand", func(t *testing.T) {
 		envPath, testEnv, cancel := newTestEnv(t)
 		defer cancel()
 
-		testEnv.HomeDir = "."
-
-		envYAML, err := yaml.Marshal(testEnv)
+		t.Setenv("HOME", testEnv.HomeDir)
+		t.Setenv("NVR_TEST_GO", testEnv.GoBin)
+		envYAML := []byte("addons: [a]\ngoBin: ${NVR_TEST_GO}\nhomeDir: ~\n")
+		env, err := parseEnv(envPath, envYAML)
 		require.NoError(t, err)
+		require.Equal(t, testEnv, env)
+	})
+	t.Run("undefinedEnvVar", func(t *testing.T) {
+		envPath, _, cancel := newTestEnv(t)
+		defer cancel()
 
-		_, err = parseEnv(envPath, envYAML)
-		require.ErrorIs(t, err, ErrPathNotAbsolute)
+		_, err := parseEnv(envPath, []byte("goBin: ${NVR_TEST_NIL}/go\n"))
+		require.ErrorIs(t, err, ErrUndefinedEnvVar)
 	})
 }
 
@@ -3,10 +3,11 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"io/fs"
 	"log"
+	nvrlog "nvr/pkg/log"
 	"nvr/pkg/storage"
 	"os"
 	"path/filepath"
@@ -22,15 +23,21 @@ func main() {
 }
 
 func run() error { //nolint:funlen
-	args := os.Args
-	if len(args) != 2 {
+	reserve := flag.Int64("reserve", storage.DefaultMP4Reserve,
+		"bytes reserved for the mp4 metadata, larger metadata requires a second copy")
+	flag.Usage = func() {
 		fmt.Println(usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
 		return nil
 	}
 
 	var recordings []string
 
-	path := args[1]
+	path := flag.Arg(0)
 
 	walkFunc := func(path string, info fs.DirEntry, err error) error {
 		if err != nil {
@@ -74,7 +81,7 @@ func run() error { //nolint:funlen
 		go func(recording string) {
 			chResults <- result{
 				recording: recording,
-				err:       convert(recording),
+				err:       convert(recording, *reserve),
 			}
 		}(recording)
 	}
@@ -96,22 +103,9 @@ type result struct {
 	err       error
 }
 
-func convert(recording string) error {
-	video, err := storage.NewVideoReader(recording, nil)
-	if err != nil {
-		return fmt.Errorf("create video reader: %w", err)
-	}
-	defer video.Close()
-
-	file, err := os.OpenFile(recording+".mp4", os.O_CREATE|os.O_WRONLY, 0o644)
-	if err != nil {
-		return fmt.Errorf("open file: %w", err)
+func convert(recording string, reserve int64) error {
+	logf := func(_ nvrlog.Level, format string, a ...interface{}) {
+		fmt.Printf("[WARN] "+format+"\n", a...)
 	}
-	defer file.Close()
-
-	_, err = io.Copy(file, video)
-	if err != nil {
-		return fmt.Errorf("copy: %w", err)
-	}
-	return nil
+	return storage.WriteMP4File(recording, reserve, logf)
 }
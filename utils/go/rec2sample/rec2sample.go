@@ -88,7 +88,7 @@ func newVideoReader(recordingPath string, nSamples int) (*videoReader, error) {
 	samples = samples[:nSamples]
 
 	metaBuf := &bytes.Buffer{}
-	mdatSize, err := mp4muxer.GenerateMP4(metaBuf, header.StartTime, samples, videoTrack, audioTrack)
+	mdatSize, _, err := mp4muxer.GenerateMP4(metaBuf, header.StartTime, samples, videoTrack, audioTrack)
 	if err != nil {
 		return nil, fmt.Errorf("generate meta: %w", err)
 	}
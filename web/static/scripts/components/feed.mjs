@@ -29,6 +29,7 @@ function newFeed(Hls, monitor, preferLowRes, buttons = []) {
 	const checkboxID = uniqueID();
 
 	return {
+		monitorID: id,
 		html: `
 			<div id="${elementID}" class="grid-item-container">
 				<input
@@ -85,6 +86,11 @@ function newFeed(Hls, monitor, preferLowRes, buttons = []) {
 			}
 		},
 		destroy() {
+			for (const button of buttons) {
+				if (button.destroy) {
+					button.destroy();
+				}
+			}
 			hls.destroy();
 		},
 	};
@@ -94,6 +100,7 @@ const newFeedBtn = {
 	mute: newMuteBtn,
 	fullscreen: newFullscreenBtn,
 	recordings: newRecordingsBtn,
+	health: newHealthBadge,
 };
 
 const iconMutedPath = "static/icons/feather/volume-x.svg";
@@ -174,4 +181,61 @@ function newRecordingsBtn(path, id) {
 	};
 }
 
-export { newFeed, newFeedBtn };
+const healthPollInterval = 5000;
+
+// Classifies the ingest statistics from "api/monitor/stats".
+function streamHealth(stats) {
+	if (!stats || stats.bytesPerSecond === 0) {
+		return "bad";
+	}
+	const maxKeyframeAge = Math.max(10, stats.keyframeInterval * 3);
+	if (stats.lastKeyframeAge > maxKeyframeAge) {
+		return "bad";
+	}
+	if (stats.keyframeInterval > 4 || Math.abs(stats.segmentDrift) > 1) {
+		return "warn";
+	}
+	return "ok";
+}
+
+function formatBitrate(bytesPerSecond) {
+	const kbps = (bytesPerSecond * 8) / 1000;
+	if (kbps >= 1000) {
+		return `${(kbps / 1000).toFixed(1)} Mb/s`;
+	}
+	return `${Math.round(kbps)} kb/s`;
+}
+
+function newHealthBadge(monitor, preferLowRes) {
+	const id = monitor["id"];
+	const sub = monitor["subInputEnabled"] === "true" && preferLowRes;
+	let interval;
+
+	return {
+		html: `<span class="js-health feed-health" data-health="bad"></span>`,
+		init($parent) {
+			const $badge = $parent.querySelector(".js-health");
+			const update = async () => {
+				try {
+					const response = await fetch("api/monitor/stats?id=" + id);
+					if (!response.ok) {
+						return;
+					}
+					const monitorStats = await response.json();
+					const stats = sub ? monitorStats.sub : monitorStats.main;
+					$badge.dataset.health = streamHealth(stats);
+					$badge.textContent = stats ? formatBitrate(stats.bytesPerSecond) : "";
+				} catch (error) {
+					console.log(error);
+				}
+			};
+			update();
+			interval = setInterval(update, healthPollInterval);
+		},
+		destroy() {
+			clearInterval(interval);
+		},
+	};
+}
+
+export { newFeed, newFeedBtn, streamHealth, formatBitrate };
@@ -1,7 +1,7 @@
 // SPDX-License-Identifier: GPL-2.0-or-later
 
 import { uidReset } from "../libs/common.mjs";
-import { newFeed, newFeedBtn } from "./feed.mjs";
+import { newFeed, newFeedBtn, streamHealth, formatBitrate } from "./feed.mjs";
 
 describe("feed", () => {
 	test("rendering", () => {
@@ -105,3 +105,37 @@ test("fullscreenBtn", () => {
 		</button>`.replaceAll(/\s/g, "");
 	expect(actual).toBe(expected);
 });
+
+describe("streamHealth", () => {
+	const stats = {
+		bytesPerSecond: 1000,
+		packetsPerSecond: 10,
+		keyframeInterval: 2,
+		lastKeyframeAge: 1,
+		segmentDrift: 0.1,
+	};
+	const cases = [
+		["ok", stats, "ok"],
+		["missing", undefined, "bad"],
+		["noData", { ...stats, bytesPerSecond: 0 }, "bad"],
+		["staleKeyframe", { ...stats, lastKeyframeAge: 11 }, "bad"],
+		["longKeyframeInterval", { ...stats, keyframeInterval: 5 }, "warn"],
+		["segmentDrift", { ...stats, segmentDrift: -1.5 }, "warn"],
+	];
+	test.each(cases)("%s", (_, input, expected) => {
+		expect(streamHealth(input)).toBe(expected);
+	});
+});
+
+test("formatBitrate", () => {
+	expect(formatBitrate(1000)).toBe("8 kb/s");
+	expect(formatBitrate(250000)).toBe("2.0 Mb/s");
+});
+
+test("healthBadge", () => {
+	const actual = newFeedBtn.health({ id: "a" }, false).html.replaceAll(/\s/g, "");
+	const expected = `
+		<span class="js-health feed-health" data-health="bad"></span>
+	`.replaceAll(/\s/g, "");
+	expect(actual).toBe(expected);
+});
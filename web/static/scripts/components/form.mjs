@@ -267,6 +267,11 @@ const inputRules = {
 	notEmpty: [/^s*$/, "cannot be empty"],
 	englishOnly: [/[^\dA-Za-z]/, "english charaters only"],
 	noUppercase: [/[^\da-z]/, "uppercase not allowed"],
+	noComma: [/,/, "use a dot as the decimal separator"],
+	feedRate: [
+		/^(?!\d*\.?\d+(\/\d*\.?\d+)?$)/,
+		"must be a number or fraction like 0.2 or 30000/1001",
+	],
 };
 
 /* Form field templates. */
@@ -301,6 +306,25 @@ const fieldTemplate = {
 			},
 		);
 	},
+	feedRate(label, initial) {
+		return newField(
+			[
+				inputRules.notEmpty,
+				inputRules.noSpaces,
+				inputRules.noComma,
+				inputRules.feedRate,
+			],
+			{
+				errorField: true,
+				input: "text",
+			},
+			{
+				label: label,
+				placeholder: "",
+				initial: initial,
+			},
+		);
+	},
 	toggle(label, initial) {
 		return newField(
 			[],
@@ -456,7 +456,11 @@ function newGroupPicker(groups) {
 	const onSelect = (selected) => {
 		const selectedGroup = groups[nameToID[selected]];
 		const groupMonitors = JSON.parse(selectedGroup["monitors"]);
-		content.setMonitors(groupMonitors);
+		// Automatic layout if the group doesn't have a layout.
+		const groupLayout = selectedGroup["layout"]
+			? JSON.parse(selectedGroup["layout"])
+			: undefined;
+		content.setMonitors(groupMonitors, groupLayout);
 		content.reset();
 	};
 
@@ -30,10 +30,15 @@ function newPlayer(data, isAdmin, token) {
 
 	const [dateString, timeString] = parseDate(start);
 
+	// Files that failed the checksum verification.
+	const corruptHTML = d.corrupt
+		? `<span class="player-menu-text player-corrupt-badge">corrupt</span>`
+		: "";
+
 	const topOverlayHTML = `
 			<span class="player-menu-text js-date">${dateString}</span>
 			<span class="player-menu-text js-time">${timeString}</span>
-			<span class="player-menu-text">${d.name}</span>`;
+			<span class="player-menu-text">${d.name}</span>${corruptHTML}`;
 
 	const thumbHTML = `
 		<img class="grid-item" src="${d.thumbPath}" />
@@ -82,6 +87,9 @@ function newPlayer(data, isAdmin, token) {
 				<a download href="${d.videoPath}" class="player-options-btn">
 					<img src="static/icons/feather/download.svg">
 				</a>
+				<a href="${d.reportPath}" target="_blank" class="player-options-btn">
+					<img src="static/icons/feather/book-open.svg">
+				</a>
 				<button class="js-fullscreen player-options-btn">
 					<img src="${iconMaximizePath}">
 				</button>
@@ -177,14 +185,15 @@ function newPlayer(data, isAdmin, token) {
 		// Delete
 		if (isAdmin) {
 			const $delete = element.querySelector(".js-delete");
-			$delete.addEventListener("click", (event) => {
+			$delete.addEventListener("click", async (event) => {
 				event.stopPropagation();
 				if (!confirm("delete?")) {
 					return;
 				}
-				fetchDelete(d.deletePath, token, "could not delete recording");
-
-				element.remove();
+				// Locked and in progress recordings can't be deleted.
+				if (await fetchDelete(d.deletePath, token, "could not delete recording")) {
+					element.remove();
+				}
 			});
 		}
 	};
@@ -27,6 +27,7 @@ const data = {
 	id: "A",
 	thumbPath: "B",
 	videoPath: "C",
+	reportPath: "E",
 	name: "D",
 	start: Date.parse("2001-06-02T00:00:00.000000Z"),
 	end: Date.parse("2001-06-02T00:10:00.000000Z"),
@@ -105,6 +106,9 @@ describe("newPlayer", () => {
 							<a download="" href="C"class="player-options-btn">
 								<img src="static/icons/feather/download.svg">
 							</a>
+							<a href="E" target="_blank" class="player-options-btn">
+								<img src="static/icons/feather/book-open.svg">
+							</a>
 							<button class="js-fullscreen player-options-btn">
 								<img src="static/icons/feather/maximize.svg">
 							</button>
@@ -127,7 +131,7 @@ describe("newPlayer", () => {
 		expect(actual3).toEqual(thumbnailHTML);
 	});
 
-	test("delete", () => {
+	test("delete", async () => {
 		window.confirm = () => {
 			return true;
 		};
@@ -168,6 +172,9 @@ describe("newPlayer", () => {
 			<a download="" href="C"class="player-options-btn">
 				<img src="static/icons/feather/download.svg">
 			</a>
+			<a href="E" target="_blank" class="player-options-btn">
+				<img src="static/icons/feather/book-open.svg">
+			</a>
 			<button class="js-fullscreen player-options-btn">
 				<img src="static/icons/feather/maximize.svg">
 			</button>`.replaceAll(/\s/g, "");
@@ -178,6 +185,7 @@ describe("newPlayer", () => {
 		expect(actual2).toEqual(expected2);
 
 		document.querySelector(".js-delete").click();
+		await new Promise((resolve) => setTimeout(resolve, 0));
 		expect(element.innerHTML).toBe("");
 	});
 
@@ -197,6 +205,18 @@ describe("newPlayer", () => {
 
 		expect(nclicks).toBe(1);
 	});
+
+	test("corruptBadge", () => {
+		document.body.innerHTML = "<div></div>";
+		const element = document.querySelector("div");
+
+		element.innerHTML = newPlayer(data).html;
+		expect(element.querySelector(".player-corrupt-badge")).toBeNull();
+
+		element.innerHTML = newPlayer({ ...data, corrupt: true }).html;
+		const $badge = element.querySelector(".player-corrupt-badge");
+		expect($badge.textContent).toBe("corrupt");
+	});
 });
 
 describe("detectionRenderer", () => {
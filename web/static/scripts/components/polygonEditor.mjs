@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+// Editor for the points of a single polygon. Values are in percent.
+// onChange is called after the points have been modified.
+function newPolygonEditor($parent, onChange) {
+	let value = [];
+
+	const render = () => {
+		let html = "";
+		for (const [index, [x, y]] of value.entries()) {
+			html += `
+				<div class="js-point polygon-editor-point">
+					<input
+						class="polygon-editor-point-input"
+						type="number"
+						min="0"
+						max="100"
+						value="${x}"
+					/>
+					<span class="polygon-editor-point-label">${index}</span>
+					<input
+						class="polygon-editor-point-input"
+						type="number"
+						min="0"
+						max="100"
+						value="${y}"
+					/>
+				</div>`;
+		}
+		html += `
+			<div style="display: flex; column-gap: 0.2rem;">
+				<button
+					class="js-plus form-field-edit-btn polygon-editor-button"
+					style="margin: 0;"
+				>
+					<img src="static/icons/feather/plus.svg">
+				</button>
+				<button
+					class="js-minus form-field-edit-btn polygon-editor-button"
+					style="margin: 0;"
+				>
+					<img src="static/icons/feather/minus.svg">
+				</button>
+			</div>`;
+
+		$parent.innerHTML = html;
+
+		for (const element of $parent.querySelectorAll(".js-point")) {
+			element.addEventListener("change", () => {
+				const index = element.querySelector("span").innerHTML;
+				const $points = element.querySelectorAll("input");
+				const x = Number.parseInt($points[0].value);
+				const y = Number.parseInt($points[1].value);
+				value[index] = [x, y];
+				onChange();
+			});
+		}
+
+		$parent.querySelector(".js-plus").addEventListener("click", () => {
+			value.push([50, 50]);
+			render();
+			onChange();
+		});
+		$parent.querySelector(".js-minus").addEventListener("click", () => {
+			if (value.length > 3) {
+				value.pop();
+				render();
+				onChange();
+			}
+		});
+	};
+
+	return {
+		// The polygon is modified in place.
+		set(polygon) {
+			value = polygon;
+			render();
+		},
+	};
+}
+
+// Returns SVG polygons for a overlay with a "0 0 100 100" viewBox.
+function renderPolygons(polygons) {
+	let html = "";
+	for (const polygon of polygons) {
+		let points = "";
+		for (const p of polygon) {
+			points += p[0] + "," + p[1] + " ";
+		}
+		html += `
+			<polygon
+				style="fill: black;"
+				points="${points}"
+			/>`;
+	}
+	return html;
+}
+
+export { newPolygonEditor, renderPolygons };
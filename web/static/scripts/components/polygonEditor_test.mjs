@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+import { newPolygonEditor, renderPolygons } from "./polygonEditor.mjs";
+
+test("newPolygonEditor", () => {
+	document.body.innerHTML = "<div></div>";
+	const element = document.querySelector("div");
+
+	let changes = 0;
+	const editor = newPolygonEditor(element, () => {
+		changes++;
+	});
+
+	const polygon = [
+		[1, 2],
+		[3, 4],
+		[5, 6],
+	];
+	editor.set(polygon);
+	expect(element.querySelectorAll(".js-point").length).toBe(3);
+
+	element.querySelector(".js-plus").click();
+	expect(polygon).toEqual([
+		[1, 2],
+		[3, 4],
+		[5, 6],
+		[50, 50],
+	]);
+	expect(element.querySelectorAll(".js-point").length).toBe(4);
+	expect(changes).toBe(1);
+
+	const $point = element.querySelectorAll(".js-point")[1];
+	$point.querySelectorAll("input")[0].value = "7";
+	$point.dispatchEvent(new Event("change"));
+	expect(polygon[1]).toEqual([7, 4]);
+	expect(changes).toBe(2);
+
+	element.querySelector(".js-minus").click();
+	element.querySelector(".js-minus").click();
+	expect(polygon.length).toBe(3);
+	expect(changes).toBe(3);
+});
+
+test("renderPolygons", () => {
+	const polygons = [
+		[
+			[1, 2],
+			[3, 4],
+			[5, 6],
+		],
+		[
+			[7, 8],
+			[9, 10],
+			[11, 12],
+		],
+	];
+	const expected = `
+		<polygon style="fill: black;" points="1,2 3,4 5,6 "/>
+		<polygon style="fill: black;" points="7,8 9,10 11,12 "/>`.replaceAll(/\s/g, "");
+
+	expect(renderPolygons(polygons).replaceAll(/\s/g, "")).toEqual(expected);
+});
@@ -1,7 +1,16 @@
 // SPDX-License-Identifier: GPL-2.0-or-later
 
 async function sendAlert(msg, response) {
-	alert(`${msg}: ${response.status}, ${await response.text()}`);
+	const text = await response.text();
+	let details = text;
+	try {
+		const err = JSON.parse(text);
+		details = err.message;
+		if (err.details && err.details.correlationID) {
+			details += ` (id: ${err.details.correlationID})`;
+		}
+	} catch (_) {} // eslint-disable-line no-empty
+	alert(`${msg}: ${response.status}, ${details}`);
 }
 
 async function fetchGet(url, msg) {
@@ -13,12 +22,18 @@ async function fetchGet(url, msg) {
 	return await response.json();
 }
 
+// csrfToken returns the token from the "csrf-token" meta tag.
+function csrfToken() {
+	const $meta = document.querySelector(`meta[name="csrf-token"]`);
+	return $meta ? $meta.content : "";
+}
+
 async function fetchPost(url, data, token, msg) {
 	const response = await fetch(url, {
 		body: JSON.stringify(data),
 		headers: {
 			"Content-Type": "application/json",
-			"X-CSRF-TOKEN": token,
+			"X-CSRF-TOKEN": token || csrfToken(),
 		},
 		method: "post",
 	});
@@ -34,7 +49,7 @@ async function fetchPut(url, data, token, msg) {
 		body: JSON.stringify(data),
 		headers: {
 			"Content-Type": "application/json",
-			"X-CSRF-TOKEN": token,
+			"X-CSRF-TOKEN": token || csrfToken(),
 		},
 		method: "put",
 	});
@@ -48,7 +63,7 @@ async function fetchPut(url, data, token, msg) {
 async function fetchDelete(url, token, msg) {
 	const response = await fetch(url, {
 		headers: {
-			"X-CSRF-TOKEN": token,
+			"X-CSRF-TOKEN": token || csrfToken(),
 		},
 		method: "delete",
 	});
@@ -106,6 +106,30 @@ test("fetchPut", async () => {
 	testFetchError(fetchPost);
 });
 
+test("fetchMetaToken", async () => {
+	document.head.innerHTML = `<meta name="csrf-token" content="x" />`;
+	let response;
+	window.fetch = async (url, data) => {
+		response = [url, data];
+		return fetchOk;
+	};
+
+	await fetchDelete("a");
+
+	const expected = [
+		"a",
+		{
+			headers: {
+				"X-CSRF-TOKEN": "x",
+			},
+			method: "delete",
+		},
+	];
+
+	expect(response).toEqual(expected);
+	document.head.innerHTML = "";
+});
+
 test("fetchDelete", async () => {
 	let response;
 	window.fetch = async (url, data) => {
@@ -23,9 +23,22 @@ function newViewer($parent, monitors, hls) {
 	let preferLowRes = false;
 	let feeds = [];
 
+	// Fixed grid layout of the selected group, automatic layout if undefined.
+	let layout;
+	const cellsByMonitor = () => {
+		const cells = {};
+		if (layout !== undefined) {
+			for (const cell of layout.cells) {
+				cells[cell.monitorId] = cell;
+			}
+		}
+		return cells;
+	};
+
 	return {
-		setMonitors(input) {
+		setMonitors(input, inputLayout) {
 			selectedMonitors = input;
+			layout = inputLayout;
 		},
 		setPreferLowRes(bool) {
 			preferLowRes = bool;
@@ -48,16 +61,35 @@ function newViewer($parent, monitors, hls) {
 					newFeedBtn.recordings(recordingsPath, monitor["id"]),
 					newFeedBtn.fullscreen(),
 					newFeedBtn.mute(monitor),
+					newFeedBtn.health(monitor, preferLowRes),
 				];
 				feeds.push(newFeed(hls, monitor, preferLowRes, buttons));
 			}
 
+			const cells = cellsByMonitor();
 			let html = "";
 			for (const feed of feeds) {
-				html += feed.html;
+				const cell = cells[feed.monitorID];
+				if (cell === undefined) {
+					html += feed.html;
+					continue;
+				}
+				html += `
+					<div
+						class="grid-layout-cell"
+						style="grid-area: ${cell.y + 1} / ${cell.x + 1} / span ${cell.height} / span ${cell.width}"
+					>${feed.html}</div>`;
 			}
 			$parent.innerHTML = html;
 
+			if (layout === undefined) {
+				$parent.style.gridTemplateColumns = "";
+				$parent.style.gridTemplateRows = "";
+			} else {
+				$parent.style.gridTemplateColumns = `repeat(${layout.columns}, 1fr)`;
+				$parent.style.gridTemplateRows = `repeat(${layout.rows}, auto)`;
+			}
+
 			for (const feed of feeds) {
 				feed.init($parent);
 			}
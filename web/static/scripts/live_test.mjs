@@ -1,6 +1,6 @@
 // SPDX-License-Identifier: GPL-2.0-or-later
 
-import { resBtn } from "./live.mjs";
+import { newViewer, resBtn } from "./live.mjs";
 
 class mockHls {
 	constructor() {}
@@ -67,3 +67,40 @@ describe("resBtn", () => {
 		expect(resetCalled).toBe(true);
 	});
 });
+
+describe("newViewer", () => {
+	const monitors = {
+		a: { id: "a", name: "a", enable: "true" },
+		b: { id: "b", name: "b", enable: "true" },
+	};
+	test("layout", () => {
+		document.body.innerHTML = `<div></div>`;
+		const element = document.querySelector("div");
+
+		const viewer = newViewer(element, monitors, mockHls);
+		const layout = {
+			columns: 3,
+			rows: 2,
+			cells: [{ monitorId: "a", x: 1, y: 0, width: 2, height: 2 }],
+		};
+		viewer.setMonitors(["a", "b"], layout);
+		viewer.reset();
+
+		expect(element.style.gridTemplateColumns).toBe("repeat(3, 1fr)");
+		const $cells = element.querySelectorAll(".grid-layout-cell");
+		expect($cells.length).toBe(1);
+		expect($cells[0].style.gridArea).toBe("1 / 2 / span 2 / span 2");
+
+		// Monitors without a cell are placed automatically.
+		expect(element.querySelectorAll(".grid-item-container").length).toBe(2);
+
+		viewer.setMonitors(["a", "b"]);
+		viewer.reset();
+		expect(element.style.gridTemplateColumns).toBe("");
+		expect(element.querySelectorAll(".grid-layout-cell").length).toBe(0);
+
+		// Destroy the feeds.
+		viewer.setMonitors(["x"]);
+		viewer.reset();
+	});
+});
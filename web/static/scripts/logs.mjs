@@ -16,8 +16,9 @@ function newLogger(formatLog) {
 	let logStream;
 
 	const startLogFeed = () => {
+		// Filtering is done by the server.
 		const parameters = new URLSearchParams({
-			levels: levels,
+			minLevel: Math.max(...levels),
 			sources: sources,
 			monitors: monitors,
 		});
@@ -36,10 +37,17 @@ function newLogger(formatLog) {
 			console.log(error);
 		});
 
+		let dropped = 0;
 		logStream.addEventListener("message", ({ data }) => {
 			const log = JSON.parse(data);
 			const line = document.createElement("span");
-			line.textContent = formatLog(log);
+			if (log.dropped === undefined) {
+				line.textContent = formatLog(log);
+			} else {
+				// The server dropped entries because we fell behind.
+				line.textContent = `[DROPPED] ${log.dropped - dropped} log entries`;
+				dropped = log.dropped;
+			}
 			$logList.insertBefore(line, $logList.childNodes[0]);
 		});
 
@@ -182,6 +190,11 @@ function newFormater(monitorNameByID, timeZone) {
 		}
 
 		output += log.msg;
+
+		// The error isn't part of the message in live entries.
+		if (log.fields && log.fields.err) {
+			output += ": " + log.fields.err;
+		}
 		return output;
 	};
 }
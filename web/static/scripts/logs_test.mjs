@@ -54,6 +54,12 @@ describe("logger", () => {
 		log.level = 48;
 		expect(format(log)).toBe("[DEBUG] 1970-01-01_00:00:00 0: m0: 0");
 	});
+	test("errField", () => {
+		const format = newFormater(monitorIDtoName, "utc");
+		const log = newTestLog();
+		log.fields = { recordingID: "1", err: "2" };
+		expect(format(log)).toBe("[ERROR] 1970-01-01_00:00:00 0: m0: 0: 2");
+	});
 });
 
 describe("MultiSelect", () => {
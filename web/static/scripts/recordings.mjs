@@ -1,6 +1,11 @@
 // SPDX-License-Identifier: GPL-2.0-or-later
 
-import { fetchGet, newMonitorNameByID, getHashParam } from "./libs/common.mjs";
+import {
+	fetchGet,
+	fetchPost,
+	newMonitorNameByID,
+	getHashParam,
+} from "./libs/common.mjs";
 import { newPlayer } from "./components/player.mjs";
 import { newOptionsMenu, newOptionsBtn } from "./components/optionsMenu.mjs";
 
@@ -25,7 +30,8 @@ async function newViewer(monitorNameByID, $parent, timeZone, isAdmin, token) {
 			d.id = rec.id;
 			d.videoPath = toAbsolutePath(`api/recording/video/${d.id}`);
 			d.thumbPath = toAbsolutePath(`api/recording/thumbnail/${d.id}`);
-			d.deletePath = toAbsolutePath(`api/recording/delete/${d.id}`);
+			d.deletePath = toAbsolutePath(`api/recording/${d.id}`);
+			d.reportPath = toAbsolutePath(`report/${d.id}`);
 			d.name = await monitorNameByID(d.id.slice(20));
 			d.timeZone = timeZone;
 
@@ -33,6 +39,10 @@ async function newViewer(monitorNameByID, $parent, timeZone, isAdmin, token) {
 				d.start = Date.parse(rec.data.start);
 				d.end = Date.parse(rec.data.end);
 				d.events = rec.data.events;
+				d.locked = rec.data.locked === true;
+				d.corrupt =
+					rec.data.verification !== undefined &&
+					rec.data.verification.status === "corrupt";
 			} else {
 				d.start = Date.parse(idToISOstring(d.id));
 			}
@@ -94,6 +104,64 @@ async function newViewer(monitorNameByID, $parent, timeZone, isAdmin, token) {
 		}
 	};
 
+	// Clicks select recordings instead of playing them in select mode.
+	let selectMode = false;
+	$parent.addEventListener(
+		"click",
+		(event) => {
+			if (!selectMode) {
+				return;
+			}
+			const $container = event.target.closest(".grid-item-container");
+			if ($container === null) {
+				return;
+			}
+			event.stopPropagation();
+			event.preventDefault();
+			$container.classList.toggle("grid-item-selected");
+		},
+		true, // Capture before the player.
+	);
+
+	const selectedIDs = () => {
+		const ids = [];
+		for (const $container of $parent.querySelectorAll(".grid-item-selected")) {
+			ids.push($container.id.slice(3)); // Trim "rec".
+		}
+		return ids;
+	};
+
+	// Deletes the selected recordings, returns the number of failures.
+	const deleteSelected = async () => {
+		const ids = selectedIDs();
+		if (ids.length === 0) {
+			return 0;
+		}
+		const response = await fetch(toAbsolutePath("api/recording/delete"), {
+			body: JSON.stringify({ ids: ids }),
+			headers: {
+				"Content-Type": "application/json",
+				"X-CSRF-TOKEN": token,
+			},
+			method: "post",
+		});
+		if (response.status !== 200) {
+			alert(`could not delete recordings: ${response.status}`);
+			return ids.length;
+		}
+		let failed = 0;
+		for (const result of await response.json()) {
+			const $container = document.getElementById("rec" + result.id);
+			if (result.error) {
+				failed++;
+				$container.classList.remove("grid-item-selected");
+				continue;
+			}
+			$container.remove();
+		}
+		return failed;
+	};
+
 	let selectedDate;
 
 	const reset = async () => {
@@ -120,10 +188,59 @@ async function newViewer(monitorNameByID, $parent, timeZone, isAdmin, token) {
 		setMonitors(input) {
 			selectedMonitors = input;
 		},
+		setSelectMode(enable) {
+			selectMode = enable;
+			$parent.classList.toggle("grid-select-mode", enable);
+			if (!enable) {
+				for (const $container of $parent.querySelectorAll(".grid-item-selected")) {
+					$container.classList.remove("grid-item-selected");
+				}
+			}
+		},
+		selectedIDs: selectedIDs,
+		deleteSelected: deleteSelected,
 		lazyLoadRecordings: lazyLoadRecordings,
 	};
 }
 
+// Select and delete multiple recordings, admin only.
+function newSelectBtns() {
+	return {
+		html: `
+			<button class="options-menu-btn js-select">
+				<img class="icon" src="static/icons/feather/check.svg">
+			</button>
+			<button class="options-menu-btn js-delete-selected" style="display: none;">
+				<img class="icon" src="static/icons/feather/trash-2.svg">
+			</button>`,
+		init($parent, viewer) {
+			const $select = $parent.querySelector(".js-select");
+			const $delete = $parent.querySelector(".js-delete-selected");
+			let enabled = false;
+			const setEnabled = (enable) => {
+				enabled = enable;
+				viewer.setSelectMode(enable);
+				$select.classList.toggle("options-menu-btn-active", enable);
+				$delete.style.display = enable ? "" : "none";
+			};
+			$select.addEventListener("click", () => {
+				setEnabled(!enabled);
+			});
+			$delete.addEventListener("click", async () => {
+				const n = viewer.selectedIDs().length;
+				if (n === 0 || !confirm(`delete ${n} recordings?`)) {
+					return;
+				}
+				const failed = await viewer.deleteSelected();
+				if (failed !== 0) {
+					alert(`could not delete ${failed} recordings, they may be locked or in progress`);
+				}
+				setEnabled(false);
+			});
+		},
+	};
+}
+
 function toAbsolutePath(input) {
 	return window.location.href.replace("recordings", input);
 }
@@ -176,6 +293,7 @@ async function init() {
 		newOptionsBtn.date(timeZone),
 		newOptionsBtn.monitor(monitors),
 		newOptionsBtn.group(groups),
+		isAdmin ? newSelectBtns() : undefined,
 	];
 	const optionsMenu = newOptionsMenu(buttons);
 	$options.innerHTML = optionsMenu.html;
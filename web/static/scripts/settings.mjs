@@ -6,9 +6,12 @@ import {
 	fetchPut,
 	fetchDelete,
 	sortByName,
+	uniqueID,
 } from "./libs/common.mjs";
 import { newForm } from "./components/form.mjs";
 import { newModal } from "./components/modal.mjs";
+import { newFeed } from "./components/feed.mjs";
+import { newPolygonEditor, renderPolygons } from "./components/polygonEditor.mjs";
 
 function newRenderer($parent) {
 	let categories = [];
@@ -770,6 +773,114 @@ function newUser(token, fields) {
 	};
 }
 
+function newSessions(token) {
+	const name = "sessions";
+	const title = "Sessions";
+	const icon = "static/icons/feather/users.svg";
+
+	const category = newSimpleCategory(name, title);
+
+	let $list;
+	const renderSessions = (sessions) => {
+		$list.innerHTML = "";
+		for (const s of sessions) {
+			const action = s.current
+				? "<span>current</span>"
+				: `<button class="js-revoke form-button delete-btn">
+					<span>Revoke</span>
+				</button>`;
+
+			const $item = document.createElement("li");
+			$item.classList.add("settings-category-nav-item");
+			$item.innerHTML = `
+				<span class="js-user-agent"></span>
+				<span class="js-ip"></span>
+				<span>${new Date(s.lastSeen).toLocaleString()}</span>
+				${action}`;
+
+			// User controlled.
+			$item.querySelector(".js-user-agent").textContent = s.userAgent;
+			$item.querySelector(".js-ip").textContent = s.ip;
+
+			if (!s.current) {
+				$item.querySelector(".js-revoke").addEventListener("click", () => {
+					revoke(s.id);
+				});
+			}
+			$list.append($item);
+		}
+	};
+
+	const load = async () => {
+		const sessions = await fetchGet("api/account/sessions", "could not get sessions");
+		renderSessions(sessions);
+	};
+
+	const revoke = async (id) => {
+		const ok = await fetchDelete(
+			"api/account/sessions/" + id,
+			token,
+			"could not revoke session",
+		);
+		if (ok) {
+			load();
+		}
+	};
+
+	const revokeOthers = async () => {
+		const ok = await fetchDelete(
+			"api/account/sessions",
+			token,
+			"could not revoke sessions",
+		);
+		if (ok) {
+			load();
+		}
+	};
+
+	category.setForm({
+		html() {
+			return `
+				<ul class="settings-category-nav js-sessions"></ul>
+				<div class="form-button-wrapper">
+					<button class="js-revoke-others form-button delete-btn">
+						<span>Revoke all other sessions</span>
+					</button>
+				</div>`;
+		},
+		init($parent) {
+			$list = $parent.querySelector(".js-sessions");
+			$parent.querySelector(".js-revoke-others").addEventListener("click", () => {
+				if (confirm("revoke all other sessions?")) {
+					revokeOthers();
+				}
+			});
+		},
+	});
+
+	return {
+		name() {
+			return name;
+		},
+		title() {
+			return title;
+		},
+		icon() {
+			return icon;
+		},
+		html() {
+			return category.html();
+		},
+		init() {
+			category.init();
+		},
+		open() {
+			category.open();
+			load();
+		},
+	};
+}
+
 function randomString(length) {
 	var charSet = "234565789abcdefghjkmnpqrstuvwxyz";
 	var output = "";
@@ -889,4 +1000,175 @@ function newSelectMonitor(id) {
 	};
 }
 
-export { newRenderer, newGeneral, newMonitor, newGroup, newUser, newSelectMonitor };
+// Areas that are blacked out in the stream before it's recorded.
+function newPrivacyMask(hls) {
+	let fields = {};
+	let value = {};
+	let $enable, $overlay, $areas, $modalContent, $feed;
+
+	const modal = newModal("Privacy mask");
+
+	const renderModal = (element, feed) => {
+		const html = `
+			<li class="js-enable form-field">
+				<label class="form-field-label">Enable privacy mask</label>
+				<div class="form-field-select-container">
+					<select class="form-field-select js-input">
+						<option>true</option>
+						<option>false</option>
+					</select>
+				</div>
+			</li>
+			<li class="form-field">
+				<label class="form-field-label">Preview</label>
+				<div style="position: relative; margin-top: 0.69rem">
+					<div class="js-feed privacy-mask-preview-feed">${feed.html}</div>
+					<svg
+						class="js-overlay privacy-mask-preview-overlay"
+						viewBox="0 0 100 100"
+						preserveAspectRatio="none"
+						style="opacity: 0.7;"
+					></svg>
+				</div>
+			</li>
+			<li class="js-areas form-field"></li>`;
+
+		$modalContent = modal.init(element);
+		$modalContent.innerHTML = html;
+		$feed = $modalContent.querySelector(".js-feed");
+
+		$enable = $modalContent.querySelector(".js-enable .js-input");
+		$enable.addEventListener("change", () => {
+			value.enable = $enable.value == "true";
+		});
+
+		$overlay = $modalContent.querySelector(".js-overlay");
+		$areas = $modalContent.querySelector(".js-areas");
+
+		renderValue();
+	};
+
+	const renderPreview = () => {
+		$overlay.innerHTML = renderPolygons(value.areas);
+	};
+
+	const renderValue = () => {
+		$enable.value = value.enable;
+
+		let html = "";
+		for (const index of value.areas.keys()) {
+			html += `
+				<div class="privacy-mask-area">
+					<label class="form-field-label">Area ${index}</label>
+					<div class="js-area-${index} polygon-editor-grid"></div>
+				</div>`;
+		}
+		html += `
+			<div style="display: flex; column-gap: 0.2rem;">
+				<button class="js-add-area form-field-edit-btn polygon-editor-button">
+					<img src="static/icons/feather/plus.svg">
+				</button>
+				<button class="js-remove-area form-field-edit-btn polygon-editor-button">
+					<img src="static/icons/feather/minus.svg">
+				</button>
+			</div>`;
+		$areas.innerHTML = html;
+
+		for (const [index, area] of value.areas.entries()) {
+			const $area = $areas.querySelector(`.js-area-${index}`);
+			newPolygonEditor($area, renderPreview).set(area);
+		}
+
+		$areas.querySelector(".js-add-area").addEventListener("click", () => {
+			value.areas.push(initialArea());
+			renderValue();
+		});
+		$areas.querySelector(".js-remove-area").addEventListener("click", () => {
+			if (value.areas.length > 0) {
+				value.areas.pop();
+				renderValue();
+			}
+		});
+
+		renderPreview();
+	};
+
+	const initialArea = () => {
+		return [
+			[50, 15],
+			[85, 15],
+			[85, 50],
+		];
+	};
+
+	let rendered = false;
+	const id = uniqueID();
+
+	return {
+		html: `
+			<li
+				id="${id}"
+				class="form-field"
+				style="display:flex; padding-bottom:0.25rem;"
+			>
+				<label class="form-field-label">Privacy mask</label>
+				<div style="width:auto">
+					<button class="form-field-edit-btn color2">
+						<img src="static/icons/feather/edit-3.svg"/>
+					</button>
+				</div>
+				${modal.html}
+			</li> `,
+
+		value() {
+			return JSON.stringify(value);
+		},
+		set(input, _, f) {
+			fields = f;
+			value = input === "" ? { enable: false, areas: [] } : JSON.parse(input);
+			if (rendered) {
+				renderValue();
+			}
+		},
+		init($parent) {
+			let feed;
+			const element = $parent.querySelector(`#${id}`);
+			element
+				.querySelector(".form-field-edit-btn")
+				.addEventListener("click", () => {
+					const subInputEnabled = fields.subStream.value() === "" ? "" : "true";
+					const monitor = {
+						id: fields.id.value(),
+						audioEnabled: "false",
+						subInputEnabled: subInputEnabled,
+					};
+					feed = newFeed(hls, monitor, true);
+
+					if (rendered) {
+						// Update feed.
+						$feed.innerHTML = feed.html;
+					} else {
+						renderModal(element, feed);
+						modal.onClose(() => {
+							feed.destroy();
+						});
+						rendered = true;
+					}
+
+					modal.open();
+					feed.init($modalContent);
+				});
+		},
+	};
+}
+
+export {
+	newRenderer,
+	newGeneral,
+	newMonitor,
+	newGroup,
+	newUser,
+	newSessions,
+	newSelectMonitor,
+	newPrivacyMask,
+};
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+import { fetchGet, fetchPost } from "./libs/common.mjs";
+
+// setupStep returns the first step that isn't completed.
+// The monitor step is optional once the env is valid.
+function setupStep(status, monitorSkipped) {
+	if (status.envError) {
+		return "env";
+	}
+	if (status.monitors.length === 0 && !monitorSkipped) {
+		return "monitor";
+	}
+	return "admin";
+}
+
+function newMonitorConfig(id, name, mainStream) {
+	return {
+		id: id,
+		name: name,
+		enable: "true",
+		mainStream: mainStream,
+		videoLength: "15",
+		timestampOffset: "500",
+	};
+}
+
+// waitForApp polls until the app has replaced the setup
+// server, the setup server responds with 503 until it stops.
+async function waitForApp() {
+	for (;;) {
+		try {
+			const response = await fetch("/live", { redirect: "manual" });
+			if (response.status !== 503) {
+				return;
+			}
+		} catch (_) {} // eslint-disable-line no-empty
+		await new Promise((resolve) => setTimeout(resolve, 1000));
+	}
+}
+
+const value = (id) => document.querySelector(`#${id}`).value;
+
+async function init() {
+	const $steps = document.querySelectorAll(".js-step");
+	let status = await fetchGet("/api/setup", "could not get setup status");
+	let monitorSkipped = false;
+
+	const render = () => {
+		const step = setupStep(status, monitorSkipped);
+		for (const $step of $steps) {
+			$step.hidden = $step.dataset.step !== step;
+		}
+		document.querySelector("#env-error").textContent = status.envError || "";
+		document.querySelector("#storageDir").value = status.env.storageDir;
+		document.querySelector("#ffmpegBin").value = status.env.ffmpegBin;
+	};
+
+	const post = async (url, data, msg) => {
+		const response = await fetch(url, {
+			body: JSON.stringify(data),
+			headers: { "Content-Type": "application/json" },
+			method: "post",
+		});
+		if (response.status !== 200) {
+			const err = await response.json();
+			alert(`${msg}: ${err.message}`);
+			return;
+		}
+		return await response.json();
+	};
+
+	document.querySelector("#env-btn").addEventListener("click", async () => {
+		const res = await post(
+			"/api/setup/env",
+			{ storageDir: value("storageDir"), ffmpegBin: value("ffmpegBin") },
+			"could not set paths"
+		);
+		if (res) {
+			status = res;
+			render();
+		}
+	});
+	document.querySelector("#monitor-btn").addEventListener("click", async () => {
+		const config = newMonitorConfig(
+			value("monitorID"),
+			value("monitorName"),
+			value("monitorInput")
+		);
+		const res = await post("/api/setup/monitor", config, "could not add monitor");
+		if (res) {
+			status = res;
+			render();
+		}
+	});
+	document.querySelector("#monitor-skip-btn").addEventListener("click", () => {
+		monitorSkipped = true;
+		render();
+	});
+	document.querySelector("#admin-btn").addEventListener("click", async () => {
+		if (value("password") !== value("password2")) {
+			alert("passwords do not match");
+			return;
+		}
+		const ok = await fetchPost(
+			"/api/setup/admin",
+			{ username: value("username"), plainPassword: value("password") },
+			"",
+			"could not create admin"
+		);
+		if (ok) {
+			for (const $step of $steps) {
+				$step.hidden = $step.dataset.step !== "done";
+			}
+			await waitForApp();
+			window.location.href = "/live";
+		}
+	});
+
+	render();
+}
+
+export { setupStep, newMonitorConfig, init };
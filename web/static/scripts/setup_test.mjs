@@ -0,0 +1,33 @@
+import { setupStep, newMonitorConfig } from "./setup.mjs";
+
+describe("setupStep", () => {
+	test("env", () => {
+		const status = { envError: "x", monitors: [] };
+		expect(setupStep(status, false)).toBe("env");
+		expect(setupStep(status, true)).toBe("env");
+	});
+	test("monitor", () => {
+		const status = { monitors: [] };
+		expect(setupStep(status, false)).toBe("monitor");
+	});
+	test("skipped", () => {
+		const status = { monitors: [] };
+		expect(setupStep(status, true)).toBe("admin");
+	});
+	test("resume", () => {
+		const status = { monitors: ["1"] };
+		expect(setupStep(status, false)).toBe("admin");
+	});
+});
+
+test("newMonitorConfig", () => {
+	const expected = {
+		id: "1",
+		name: "one",
+		enable: "true",
+		mainStream: "rtsp://x",
+		videoLength: "15",
+		timestampOffset: "500",
+	};
+	expect(newMonitorConfig("1", "one", "rtsp://x")).toEqual(expected);
+});
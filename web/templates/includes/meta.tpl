@@ -4,6 +4,7 @@
 {{ define "meta" }}
 	<title>OS-NVR</title>
 	<meta name="viewport" content="width=device-width, initial-scale=1" />
+	<meta name="csrf-token" content="{{ .csrfToken }}" />
 	<link rel="stylesheet" type="text/css" href="static/style/style.css" />
 	<link rel="stylesheet" type="text/css" href="static/style/themes/{{ .theme }}.css" />
 	<link
@@ -18,6 +19,6 @@
 		const Monitors = JSON.parse("{{ .monitors }}");
 		const LogSources = {{ .logSources }};
 		const IsAdmin = "{{ .user.IsAdmin }}" === "true";
-		const CSRFToken = "{{ .user.Token }}";
+		const CSRFToken = "{{ .csrfToken }}";
 	</script>
 {{ end }}
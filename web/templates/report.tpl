@@ -0,0 +1,168 @@
+<!-- SPDX-License-Identifier: GPL-2.0-or-later -->
+
+<!DOCTYPE html>
+{{ template "html" }}
+<head>
+	<title>Incident report {{ .report.RecordingID }}</title>
+	<meta name="viewport" content="width=device-width, initial-scale=1" />
+	<style>
+		body {
+			margin: 0 auto;
+			padding: 1rem;
+			max-width: 60rem;
+			font-family: Helvetica, Arial, sans-serif;
+			font-size: 0.9rem;
+			color: #000;
+			background: #fff;
+		}
+		h1 {
+			margin: 0 0 1rem 0;
+			font-size: 1.6rem;
+		}
+		h2 {
+			margin: 1.5rem 0 0.5rem 0;
+			font-size: 1.1rem;
+			border-bottom: 1px solid #888;
+		}
+		.report-actions {
+			float: right;
+		}
+		.report-actions > * {
+			margin-left: 0.5rem;
+		}
+		.report-fields th {
+			padding-right: 1rem;
+			text-align: left;
+		}
+		.report-frames {
+			display: grid;
+			grid-template-columns: 1fr 1fr;
+			gap: 0.75rem;
+		}
+		.report-frame {
+			margin: 0;
+			break-inside: avoid;
+		}
+		.report-frame img {
+			width: 100%;
+			display: block;
+		}
+		.report-frame figcaption {
+			font-size: 0.8rem;
+		}
+		.report-detections {
+			width: 100%;
+			border-collapse: collapse;
+		}
+		.report-detections th {
+			background: #e6e6e6;
+			text-align: left;
+		}
+		.report-detections th,
+		.report-detections td {
+			padding: 0.15rem 0.3rem;
+		}
+		.report-detections tr {
+			break-inside: avoid;
+		}
+		@media print {
+			@page {
+				size: A4;
+				margin: 1.5cm;
+			}
+			body {
+				padding: 0;
+				max-width: none;
+				font-size: 10pt;
+			}
+			.report-actions {
+				display: none;
+			}
+			.report-detections th {
+				-webkit-print-color-adjust: exact;
+				print-color-adjust: exact;
+			}
+		}
+	</style>
+</head>
+<body>
+	{{ with .report }}
+	<div class="report-actions">
+		<button onclick="window.print()">Print</button>
+		<a href="../api/recording/{{ .RecordingID }}/report.pdf">PDF</a>
+	</div>
+	<h1>Incident report</h1>
+	<table class="report-fields">
+		<tr>
+			<th>Monitor</th>
+			<td>
+				{{ if .MonitorName }}{{ .MonitorName }} ({{ .MonitorID }}){{ else }}{{ .MonitorID }}{{ end }}
+			</td>
+		</tr>
+		<tr>
+			<th>Recording</th>
+			<td>{{ .RecordingID }}</td>
+		</tr>
+		<tr>
+			<th>Start</th>
+			<td>{{ .Start.Format "2006-01-02 15:04:05 MST" }}</td>
+		</tr>
+		<tr>
+			<th>End</th>
+			<td>{{ .End.Format "2006-01-02 15:04:05 MST" }}</td>
+		</tr>
+		<tr>
+			<th>Duration</th>
+			<td>{{ .Duration }}</td>
+		</tr>
+		<tr>
+			<th>Clip</th>
+			<td><a href="../api/recording/video/{{ .RecordingID }}">Open recording</a></td>
+		</tr>
+	</table>
+
+	<h2>Key frames</h2>
+	{{ if .Frames }}
+	<div class="report-frames">
+		{{ range .Frames }}
+		<figure class="report-frame">
+			<img src="{{ .DataURL }}" />
+			<figcaption>
+				{{ .Offset }} {{ .Time.Format "15:04:05" }}
+				{{ if .Label }}{{ .Label }} {{ printf "%.0f" .Score }}%{{ end }}
+			</figcaption>
+		</figure>
+		{{ end }}
+	</div>
+	{{ else }}
+	<p>No frames.</p>
+	{{ end }}
+
+	<h2>Detections</h2>
+	{{ if .Detections }}
+	<table class="report-detections">
+		<tr>
+			<th>Time</th>
+			<th>Offset</th>
+			<th>Label</th>
+			<th>Score</th>
+			<th>Source</th>
+			<th>Zones</th>
+		</tr>
+		{{ range .Detections }}
+		<tr>
+			<td>{{ .Time.Format "2006-01-02 15:04:05" }}</td>
+			<td>{{ .Offset }}</td>
+			<td>{{ .Label }}</td>
+			<td>{{ printf "%.0f" .Score }}%</td>
+			<td>{{ .Source }}</td>
+			<td>{{ range $i, $zone := .Zones }}{{ if $i }}, {{ end }}{{ $zone }}{{ end }}</td>
+		</tr>
+		{{ end }}
+	</table>
+	{{ else }}
+	<p>No detections.</p>
+	{{ end }}
+	{{ end }}
+</body>
+{{ template "html2" }}
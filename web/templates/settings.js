@@ -1,6 +1,7 @@
 // SPDX-License-Identifier: GPL-2.0-or-later
 
 /* eslint-disable no-unused-vars */
+import Hls from "./static/scripts/vendor/hls.mjs";
 import { uniqueID } from "./static/scripts/libs/common.mjs";
 import { newModal } from "./static/scripts/components/modal.mjs";
 import {
@@ -19,7 +20,9 @@ import {
 	newMonitor,
 	newGroup,
 	newUser,
+	newSessions,
 	newSelectMonitor,
+	newPrivacyMask,
 } from "./static/scripts/settings.mjs";
 
 // Globals.
@@ -31,6 +34,7 @@ if (isAdmin) {
 
 	const generalFields = {
 		diskSpace: fieldTemplate.text("Max disk usage (GB)", "5000"),
+		maxLockedPercent: fieldTemplate.integer("Max locked recordings (%)", "50", "50"),
 		theme: fieldTemplate.select("Theme", ["default", "light"], "default"),
 	};
 	const general = newGeneral(csrfToken, generalFields);
@@ -56,26 +60,27 @@ if (isAdmin) {
 		),
 		name: fieldTemplate.text("Name", "my_monitor"),
 		enable: fieldTemplate.toggle("Enable monitor", "true"),
+		inputType: fieldTemplate.select("Input type", ["camera", "push"], "camera"),
 		inputOptions: newSelectCustomField([], ["", "-rtsp_transport tcp"], {
 			label: "Input options",
 		}),
-		mainInput: newField(
+		mainStream: newField(
 			[inputRules.notEmpty],
 			{
 				input: "text",
 			},
 			{
-				label: "Main input",
+				label: "Main stream",
 				placeholder: "rtsp://x.x.x.x/main",
 			},
 		),
-		subInput: newField(
+		subStream: newField(
 			[],
 			{
 				input: "text",
 			},
 			{
-				label: "Sub input",
+				label: "Sub stream",
 				placeholder: "rtsp//x.x.x.x/sub (optional)",
 			},
 		),
@@ -103,9 +108,76 @@ if (isAdmin) {
 			["none", "copy", "aac"],
 			"none",
 		),
+		liveAudio: fieldTemplate.toggle("Live audio", "true"),
+		audioLevel: fieldTemplate.toggle("Audio level meter", "false"),
+		privacyMask: newPrivacyMask(Hls),
 		alwaysRecord: fieldTemplate.toggle("Always record", "false"),
 		videoLength: fieldTemplate.text("Video length (min)", "15", "15"),
+		postEventDuration: newField(
+			[inputRules.noSpaces],
+			{
+				errorField: true,
+				input: "number",
+				min: "0",
+				step: "any",
+			},
+			{
+				label: "Post event duration (sec)",
+				placeholder: "trigger duration",
+			},
+		),
+		maxEventGap: newField(
+			[inputRules.noSpaces],
+			{
+				errorField: true,
+				input: "number",
+				min: "0",
+				step: "any",
+			},
+			{
+				label: "Max event gap (sec)",
+				placeholder: "disabled",
+			},
+		),
+		maxTotalDuration: newField(
+			[inputRules.noSpaces],
+			{
+				errorField: true,
+				input: "number",
+				min: "0",
+				step: "any",
+			},
+			{
+				label: "Max total duration (min)",
+				placeholder: "disabled",
+			},
+		),
+		hlsIFrames: fieldTemplate.toggle("I-frame playlist", "false"),
+		hlsEncryption: fieldTemplate.toggle("HLS encryption", "false"),
+		hlsKeyRotation: newField(
+			[inputRules.noSpaces],
+			{
+				errorField: true,
+				input: "number",
+				min: "0",
+				step: "any",
+			},
+			{
+				label: "HLS key rotation (min)",
+				placeholder: "60",
+			},
+		),
 		timestampOffset: fieldTemplate.integer("Timestamp offset (ms)", "500", "500"),
+		timeZone: newField(
+			[],
+			{
+				input: "text",
+			},
+			{
+				label: "Time zone",
+				placeholder: "Europe/Stockholm (optional)",
+			},
+		),
 		logLevel: fieldTemplate.select(
 			"Log level",
 			["quiet", "fatal", "error", "warning", "info", "debug"],
@@ -156,6 +228,8 @@ if (isAdmin) {
 	const user = newUser(csrfToken, userFields);
 	renderer.addCategory(user);
 
+	renderer.addCategory(newSessions(csrfToken));
+
 	renderer.render();
 	renderer.init();
 }
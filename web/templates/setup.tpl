@@ -0,0 +1,90 @@
+<!-- SPDX-License-Identifier: GPL-2.0-or-later -->
+
+<!DOCTYPE html>
+{{ template "html" }}
+<head>
+	<title>OS-NVR setup</title>
+	<meta name="viewport" content="width=device-width, initial-scale=1" />
+	<link rel="stylesheet" type="text/css" href="static/style/style.css" />
+	<link rel="stylesheet" type="text/css" href="static/style/themes/default.css" />
+	<script type="module" defer>
+		import { init } from "./static/scripts/setup.mjs";
+		init();
+	</script>
+</head>
+<body>
+	<div id="content">
+		<div class="setup-step js-step" data-step="env" hidden>
+			<h2>Paths</h2>
+			<span class="setup-error" id="env-error"></span>
+			<label for="storageDir">Storage directory</label>
+			<input id="storageDir" type="text" />
+			<label for="ffmpegBin">FFmpeg binary</label>
+			<input id="ffmpegBin" type="text" />
+			<button id="env-btn" class="setup-btn">Save</button>
+		</div>
+		<div class="setup-step js-step" data-step="monitor" hidden>
+			<h2>First monitor</h2>
+			<label for="monitorID">ID</label>
+			<input id="monitorID" type="text" />
+			<label for="monitorName">Name</label>
+			<input id="monitorName" type="text" />
+			<label for="monitorInput">Main input</label>
+			<input id="monitorInput" type="text" placeholder="rtsp://" />
+			<button id="monitor-btn" class="setup-btn">Add</button>
+			<button id="monitor-skip-btn" class="setup-btn">Skip</button>
+		</div>
+		<div class="setup-step js-step" data-step="admin" hidden>
+			<h2>Admin account</h2>
+			<label for="username">Username</label>
+			<input id="username" type="text" autocomplete="username" />
+			<label for="password">Password</label>
+			<input id="password" type="password" autocomplete="new-password" />
+			<label for="password2">Repeat password</label>
+			<input id="password2" type="password" autocomplete="new-password" />
+			<button id="admin-btn" class="setup-btn">Create</button>
+		</div>
+		<div class="setup-step js-step" data-step="done" hidden>
+			<h2>Starting..</h2>
+		</div>
+	</div>
+</body>
+<style>
+	#content {
+		display: flex;
+		justify-content: center;
+		background: var(--color2);
+	}
+	.setup-step {
+		display: flex;
+		flex-direction: column;
+		width: 100%;
+		max-width: 12rem;
+		padding: 1rem;
+		color: var(--color-text);
+	}
+	.setup-step[hidden] {
+		display: none;
+	}
+	.setup-step > input {
+		margin-bottom: 0.5rem;
+		font-size: 0.6rem;
+	}
+	.setup-error {
+		color: var(--color-red);
+		font-size: 0.5rem;
+		word-wrap: break-word;
+	}
+	.setup-btn {
+		margin-top: 0.5rem;
+		padding: 0.2rem;
+		color: var(--color-text);
+		font-size: 0.6rem;
+		background: var(--color3);
+		border-radius: 0.2rem;
+	}
+	.setup-btn:hover {
+		background: var(--color3-hover);
+	}
+</style>
+{{ template "html2" }}